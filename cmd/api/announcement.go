@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package apicmd
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// announcementReporter is a middleware that adds the X-Keppel-Announcement
+// header to all responses while an announcement is active, so that UIs and
+// CLIs can show upcoming maintenance to users without having to poll the
+// GET /keppel/v1/announcement endpoint.
+type announcementReporter struct {
+	db *keppel.DB
+	// The current announcement is cached for a short while, since we do not
+	// want to hit the DB for every single request.
+	mutex     sync.Mutex
+	value     string
+	expiresAt time.Time
+}
+
+const announcementCacheDuration = 30 * time.Second
+
+func (ar *announcementReporter) Middleware(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if value := ar.getHeaderValue(); value != "" {
+			w.Header().Set("X-Keppel-Announcement", value)
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+func (ar *announcementReporter) getHeaderValue() string {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+
+	now := time.Now()
+	if now.Before(ar.expiresAt) {
+		return ar.value
+	}
+
+	ar.expiresAt = now.Add(announcementCacheDuration)
+	announcement, err := keppel.FindCurrentAnnouncement(ar.db, now)
+	switch {
+	case err != nil:
+		// do not fail the request just because of this; keep showing the
+		// previous value until the DB works again
+		logg.Error("cannot load current announcement: %s", err.Error())
+	case announcement == nil:
+		ar.value = ""
+	default:
+		ar.value = keppel.RenderAnnouncement(*announcement).HeaderValue()
+	}
+	return ar.value
+}
@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package apicmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/cors"
+	"github.com/sapcc/go-bits/osext"
+
+	keppelv1 "github.com/sapcc/keppel/internal/api/keppel"
+)
+
+// newCORSMiddleware builds the CORS middleware for keppel-api from the
+// KEPPEL_API_CORS_* environment variables. The middleware applies to all APIs
+// served by keppel-api (Keppel API, Registry API and auth endpoint), so that
+// browser-based clients can talk to Keppel directly.
+func newCORSMiddleware() (*cors.Cors, error) {
+	opts := cors.Options{
+		AllowedOrigins: splitList(osext.GetenvOrDefault("KEPPEL_API_CORS_ALLOWED_ORIGINS", "*")),
+		AllowedMethods: []string{"HEAD", "GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders: []string{
+			"Accept", "Authorization", "Content-Range", "Content-Type", "Range", "User-Agent",
+			"X-Auth-Token", keppelv1.SubleaseHeader,
+		},
+		// Registry API clients need to see these headers in order to follow redirects,
+		// paginate, resume uploads and verify the content they received
+		ExposedHeaders: []string{
+			"Deprecation", "Docker-Content-Digest", "Docker-Distribution-Api-Version", "Docker-Upload-Uuid",
+			"Link", "Location", "Oci-Chunk-Min-Length", "Oci-Subject", "Range", "Retry-After", "Sunset", "Www-Authenticate",
+			"X-Keppel-Announcement", "X-Keppel-Chunk-Max-Length", "X-Keppel-Your-Ip",
+		},
+		AllowCredentials: osext.GetenvBool("KEPPEL_API_CORS_ALLOW_CREDENTIALS"),
+	}
+	opts.AllowedHeaders = append(opts.AllowedHeaders, splitList(os.Getenv("KEPPEL_API_CORS_ALLOWED_HEADERS"))...)
+
+	if len(opts.AllowedOrigins) == 0 {
+		return nil, errors.New("KEPPEL_API_CORS_ALLOWED_ORIGINS may not be empty")
+	}
+	if opts.AllowCredentials && slices.Contains(opts.AllowedOrigins, "*") {
+		return nil, errors.New("KEPPEL_API_CORS_ALLOW_CREDENTIALS may not be set while KEPPEL_API_CORS_ALLOWED_ORIGINS allows all origins")
+	}
+
+	if maxAgeStr := os.Getenv("KEPPEL_API_CORS_MAX_AGE"); maxAgeStr != "" {
+		maxAge, err := time.ParseDuration(maxAgeStr)
+		if err != nil || maxAge < time.Second {
+			return nil, fmt.Errorf("malformed KEPPEL_API_CORS_MAX_AGE: expected a duration of at least 1s, but got %q", maxAgeStr)
+		}
+		opts.MaxAge = int(maxAge / time.Second)
+	}
+
+	return cors.New(opts), nil
+}
+
+// splitList splits a comma-separated list, ignoring whitespace and empty entries.
+func splitList(in string) []string {
+	var result []string
+	for _, field := range strings.Split(in, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			result = append(result, field)
+		}
+	}
+	return result
+}
@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package apicmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+var corsTestHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func corsRequest(t *testing.T, h http.Handler, method, origin string, headers map[string]string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, "/v2/test1/foo/manifests/latest", http.NoBody)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func TestCORSDefaults(t *testing.T) {
+	c, err := newCORSMiddleware()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	h := c.Handler(corsTestHandler)
+
+	// by default, any origin is allowed
+	resp := corsRequest(t, h, http.MethodGet, "https://example.com", nil)
+	if v := resp.Header.Get("Access-Control-Allow-Origin"); v != "*" {
+		t.Errorf("expected Access-Control-Allow-Origin to be %q, but got %q", "*", v)
+	}
+	if v := resp.Header.Get("Access-Control-Allow-Credentials"); v != "" {
+		t.Errorf("expected no Access-Control-Allow-Credentials, but got %q", v)
+	}
+	exposed := splitList(resp.Header.Get("Access-Control-Expose-Headers"))
+	for _, hdr := range []string{"Docker-Content-Digest", "Location", "Www-Authenticate"} {
+		if !slices.Contains(exposed, hdr) {
+			t.Errorf("expected %s to be exposed, but Access-Control-Expose-Headers is %q", hdr, resp.Header.Get("Access-Control-Expose-Headers"))
+		}
+	}
+
+	// requests without an Origin header are not CORS requests
+	resp = corsRequest(t, h, http.MethodGet, "", nil)
+	if v := resp.Header.Get("Access-Control-Allow-Origin"); v != "" {
+		t.Errorf("expected no Access-Control-Allow-Origin for non-CORS request, but got %q", v)
+	}
+
+	// preflight requests for the registry's auth header are answered (browsers
+	// send the requested headers in lowercase)
+	resp = corsRequest(t, h, http.MethodOptions, "https://example.com", map[string]string{
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "authorization,content-type",
+	})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected preflight to return status %d, but got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if v := resp.Header.Get("Access-Control-Allow-Methods"); v != "PUT" {
+		t.Errorf("expected Access-Control-Allow-Methods to be %q, but got %q", "PUT", v)
+	}
+	if v := resp.Header.Get("Access-Control-Max-Age"); v != "" {
+		t.Errorf("expected no Access-Control-Max-Age, but got %q", v)
+	}
+
+	// headers outside the allowed list are rejected during preflight
+	resp = corsRequest(t, h, http.MethodOptions, "https://example.com", map[string]string{
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "x-custom-header",
+	})
+	if v := resp.Header.Get("Access-Control-Allow-Origin"); v != "" {
+		t.Errorf("expected preflight with unknown header to be rejected, but got Access-Control-Allow-Origin = %q", v)
+	}
+}
+
+func TestCORSCustomized(t *testing.T) {
+	t.Setenv("KEPPEL_API_CORS_ALLOWED_ORIGINS", "https://one.example.com, https://two.example.com,")
+	t.Setenv("KEPPEL_API_CORS_ALLOWED_HEADERS", "X-Custom-Header")
+	t.Setenv("KEPPEL_API_CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("KEPPEL_API_CORS_MAX_AGE", "10m")
+
+	c, err := newCORSMiddleware()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	h := c.Handler(corsTestHandler)
+
+	// listed origins are allowed and echoed back
+	for _, origin := range []string{"https://one.example.com", "https://two.example.com"} {
+		resp := corsRequest(t, h, http.MethodGet, origin, nil)
+		if v := resp.Header.Get("Access-Control-Allow-Origin"); v != origin {
+			t.Errorf("expected Access-Control-Allow-Origin to be %q, but got %q", origin, v)
+		}
+		if v := resp.Header.Get("Access-Control-Allow-Credentials"); v != "true" {
+			t.Errorf("expected Access-Control-Allow-Credentials to be %q, but got %q", "true", v)
+		}
+	}
+
+	// other origins are not
+	resp := corsRequest(t, h, http.MethodGet, "https://evil.example.com", nil)
+	if v := resp.Header.Get("Access-Control-Allow-Origin"); v != "" {
+		t.Errorf("expected no Access-Control-Allow-Origin for disallowed origin, but got %q", v)
+	}
+
+	// additional headers and max age are honored during preflight
+	resp = corsRequest(t, h, http.MethodOptions, "https://one.example.com", map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "x-custom-header",
+	})
+	if v := resp.Header.Get("Access-Control-Allow-Origin"); v != "https://one.example.com" {
+		t.Errorf("expected preflight with custom header to be allowed, but got Access-Control-Allow-Origin = %q", v)
+	}
+	if v := resp.Header.Get("Access-Control-Max-Age"); v != "600" {
+		t.Errorf("expected Access-Control-Max-Age to be %q, but got %q", "600", v)
+	}
+}
+
+func TestCORSInvalidConfig(t *testing.T) {
+	testCases := []struct {
+		Env           map[string]string
+		ExpectedError string
+	}{
+		{
+			Env:           map[string]string{"KEPPEL_API_CORS_ALLOWED_ORIGINS": " , "},
+			ExpectedError: "KEPPEL_API_CORS_ALLOWED_ORIGINS may not be empty",
+		},
+		{
+			Env:           map[string]string{"KEPPEL_API_CORS_ALLOW_CREDENTIALS": "true"},
+			ExpectedError: "KEPPEL_API_CORS_ALLOW_CREDENTIALS may not be set while KEPPEL_API_CORS_ALLOWED_ORIGINS allows all origins",
+		},
+		{
+			Env: map[string]string{
+				"KEPPEL_API_CORS_ALLOWED_ORIGINS":   "https://one.example.com,*",
+				"KEPPEL_API_CORS_ALLOW_CREDENTIALS": "true",
+			},
+			ExpectedError: "KEPPEL_API_CORS_ALLOW_CREDENTIALS may not be set while KEPPEL_API_CORS_ALLOWED_ORIGINS allows all origins",
+		},
+		{
+			Env:           map[string]string{"KEPPEL_API_CORS_MAX_AGE": "soon"},
+			ExpectedError: `malformed KEPPEL_API_CORS_MAX_AGE: expected a duration of at least 1s, but got "soon"`,
+		},
+		{
+			Env:           map[string]string{"KEPPEL_API_CORS_MAX_AGE": "500ms"},
+			ExpectedError: `malformed KEPPEL_API_CORS_MAX_AGE: expected a duration of at least 1s, but got "500ms"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ExpectedError, func(t *testing.T) {
+			for k, v := range tc.Env {
+				t.Setenv(k, v)
+			}
+			_, err := newCORSMiddleware()
+			if err == nil {
+				t.Errorf("expected error %q, but got none", tc.ExpectedError)
+			} else if err.Error() != tc.ExpectedError {
+				t.Errorf("expected error %q, but got %q", tc.ExpectedError, err.Error())
+			}
+		})
+	}
+}
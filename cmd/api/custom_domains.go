@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package apicmd
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// How long certificates for custom domains are cached before they are read
+// from the secret store again. Since changes to accounts are propagated via
+// keppel.ListenForAccountChanges(), this only matters for rotation of
+// certificates in the secret store itself.
+const customDomainCertificateCacheTTL = 5 * time.Minute
+
+// customDomainCertificateLoader selects TLS certificates by SNI hostname.
+// Certificates for custom domains of accounts are loaded from the secret
+// store. For all other hostnames, the default certificate is used.
+type customDomainCertificateLoader struct {
+	db          *keppel.DB
+	secd        keppel.SecretsDriver
+	defaultCert *tls.Certificate // may be nil
+
+	mutex sync.Mutex
+	cache map[string]cachedCertificate
+	// incremented by Invalidate(), so that cache fills that raced with an
+	// invalidation do not put outdated certificates back into the cache
+	generation uint64
+}
+
+type cachedCertificate struct {
+	Certificate *tls.Certificate // nil if the hostname is not a custom domain with a certificate
+	ExpiresAt   time.Time
+}
+
+// newTLSConfigForCustomDomains builds the TLS config for the listener on
+// KEPPEL_API_TLS_LISTEN_ADDRESS. The certificate cache is invalidated whenever
+// any keppel-api replica changes an account, until `ctx` expires.
+func newTLSConfigForCustomDomains(ctx context.Context, dbURL url.URL, db *keppel.DB, secd keppel.SecretsDriver) (*tls.Config, error) {
+	l := &customDomainCertificateLoader{
+		db:    db,
+		secd:  secd,
+		cache: make(map[string]cachedCertificate),
+	}
+
+	certPath := os.Getenv("KEPPEL_API_TLS_CERT_PATH")
+	keyPath := os.Getenv("KEPPEL_API_TLS_KEY_PATH")
+	if certPath != "" || keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load default TLS certificate: %w", err)
+		}
+		l.defaultCert = &cert
+	}
+
+	go func() {
+		err := keppel.ListenForAccountChanges(ctx, dbURL, func(models.AccountName) { l.Invalidate() })
+		if err != nil {
+			logg.Error("cannot listen for account changes, certificates for custom domains will only be reloaded after %s: %s",
+				customDomainCertificateCacheTTL, err.Error())
+		}
+	}()
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: l.GetCertificate,
+	}, nil
+}
+
+// GetCertificate implements the tls.Config.GetCertificate callback.
+func (l *customDomainCertificateLoader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	hostname := strings.ToLower(hello.ServerName)
+	if hostname != "" {
+		cert, err := l.getCertificateForCustomDomain(hello.Context(), hostname)
+		if err != nil {
+			// do not fail the handshake if we have something else to offer
+			logg.Error("cannot load TLS certificate for custom domain %q: %s", hostname, err.Error())
+		} else if cert != nil {
+			return cert, nil
+		}
+	}
+	if l.defaultCert == nil {
+		return nil, fmt.Errorf("no TLS certificate available for %q", hostname)
+	}
+	return l.defaultCert, nil
+}
+
+// Invalidate discards all cached certificates. Since a change to an account
+// may move a custom domain to a different account, we do not bother with
+// finding out which hostnames are affected by the change.
+func (l *customDomainCertificateLoader) Invalidate() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	clear(l.cache)
+	l.generation++
+}
+
+func (l *customDomainCertificateLoader) getCertificateForCustomDomain(ctx context.Context, hostname string) (*tls.Certificate, error) {
+	now := time.Now()
+	l.mutex.Lock()
+	cached, ok := l.cache[hostname]
+	generation := l.generation
+	l.mutex.Unlock()
+	if ok && cached.ExpiresAt.After(now) {
+		return cached.Certificate, nil
+	}
+
+	var cert *tls.Certificate
+	var ref, authTenantID string
+	err := l.db.QueryRow(`SELECT custom_domain_certificate_ref, auth_tenant_id FROM accounts WHERE custom_domain = $1 AND custom_domain_verified_at IS NOT NULL`, hostname).Scan(&ref, &authTenantID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if ref != "" {
+		secret, err := l.secd.ReadSecret(ctx, authTenantID, ref)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve certificate_ref %q: %w", ref, err)
+		}
+		cert, err = keppel.ParseCustomDomainCertificate(secret, hostname)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l.mutex.Lock()
+	if l.generation == generation {
+		l.cache[hostname] = cachedCertificate{Certificate: cert, ExpiresAt: now.Add(customDomainCertificateCacheTTL)}
+	}
+	l.mutex.Unlock()
+	return cert, nil
+}
@@ -5,15 +5,16 @@ package apicmd
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/dlmiddlecote/sqlstats"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
-	"github.com/rs/cors"
 	"github.com/sapcc/go-bits/easypg"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/httpapi/pprofapi"
@@ -28,6 +29,7 @@ import (
 	peerv1 "github.com/sapcc/keppel/internal/api/peer"
 	registryv2 "github.com/sapcc/keppel/internal/api/registry"
 	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
 )
 
 // AddCommandTo mounts this command into the command hierarchy.
@@ -49,6 +51,7 @@ func run(cmd *cobra.Command, args []string) {
 
 	cfg := keppel.ParseConfiguration()
 	ctx := httpext.ContextWithSIGINT(cmd.Context(), 10*time.Second)
+	keppel.WatchConfigFile(ctx)
 	auditor := must.Return(keppel.InitAuditTrail(ctx))
 
 	dbURL, dbName := keppel.GetDatabaseURLFromEnvironment()
@@ -60,28 +63,56 @@ func run(cmd *cobra.Command, args []string) {
 	rc := must.Return(initRedis())
 	ad := must.Return(keppel.NewAuthDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_AUTH"), rc))
 	fd := must.Return(keppel.NewFederationDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_FEDERATION"), ad, cfg))
-	sd := must.Return(keppel.NewStorageDriver(osext.MustGetenv("KEPPEL_DRIVER_STORAGE"), ad, cfg))
+	sd := keppel.InstrumentStorageDriver(must.Return(keppel.NewStorageDriver(osext.MustGetenv("KEPPEL_DRIVER_STORAGE"), ad, cfg)))
 	icd := must.Return(keppel.NewInboundCacheDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_INBOUND_CACHE"), cfg))
+	secd := must.Return(keppel.NewSecretsDriver(ctx, osext.GetenvOrDefault("KEPPEL_DRIVER_SECRETS", "trivial"), cfg))
+	var cdnd keppel.CDNDriver
+	if pluginTypeID := osext.GetenvOrDefault("KEPPEL_DRIVER_CDN", ""); pluginTypeID != "" {
+		cdnd = must.Return(keppel.NewCDNDriver(ctx, pluginTypeID, cfg))
+	}
+	var nvd keppel.NameValidationDriver
+	if pluginTypeID := osext.GetenvOrDefault("KEPPEL_DRIVER_NAME_VALIDATION", ""); pluginTypeID != "" {
+		nvd = must.Return(keppel.NewNameValidationDriver(ctx, pluginTypeID, cfg))
+	}
 
 	rle := (*keppel.RateLimitEngine)(nil)
 	if rc != nil {
 		rld := must.Return(keppel.NewRateLimitDriver(osext.MustGetenv("KEPPEL_DRIVER_RATELIMIT"), ad, cfg))
-		rle = &keppel.RateLimitEngine{Driver: rld, Client: rc}
+		rle = &keppel.RateLimitEngine{
+			Driver:           rld,
+			Client:           rc,
+			IPv6PrefixLength: must.Return(keppel.GetRateLimitIPv6PrefixLength()),
+			Exemptions:       keppel.NewRateLimitExemptionCache(db, time.Now),
+		}
 	}
 
 	// start background goroutines
 	runPeering(ctx, cfg, db)
+	go func() {
+		err := keppel.ListenForAccountChanges(ctx, dbURL, func(models.AccountName) {
+			keppel.InvalidateCustomDomainCache()
+			keppel.InvalidateRBACPolicyEpochCache()
+		})
+		if err != nil {
+			logg.Error("cannot listen for account changes, changes to custom domains and RBAC policies will only be picked up after a minute: %s", err.Error())
+		}
+	}()
+
+	pullAttestations := keppel.NewPullAttestationRecorder(db)
+	go pullAttestations.Run(ctx, time.Minute)
+	rbacPolicyUsage := keppel.NewRBACPolicyUsageRecorder(db)
+	go rbacPolicyUsage.Run(ctx, time.Minute)
+	go keppel.RunDeprecatedAPIUsageFlush(ctx, db, time.Minute)
 
 	// wire up HTTP handlers
-	corsMiddleware := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"HEAD", "GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders: []string{"Content-Type", "User-Agent", "Authorization", "X-Auth-Token", keppelv1.SubleaseHeader},
-	})
+	corsMiddleware := must.Return(reloadable(newCORSMiddleware,
+		"KEPPEL_API_CORS_ALLOWED_ORIGINS", "KEPPEL_API_CORS_ALLOWED_HEADERS", "KEPPEL_API_CORS_ALLOW_CREDENTIALS", "KEPPEL_API_CORS_MAX_AGE"))
+	monitoring := must.Return(reloadable(newMonitoringGate,
+		"KEPPEL_API_MONITORING_TOKEN", "KEPPEL_API_UNAUTHENTICATED_ENDPOINTS"))
 	handler := httpapi.Compose(
-		keppelv1.NewAPI(cfg, ad, fd, sd, icd, db, auditor, rle),
-		auth.NewAPI(cfg, ad, fd, db),
-		registryv2.NewAPI(cfg, ad, fd, sd, icd, db, auditor, rle),
+		keppelv1.NewAPI(cfg, ad, fd, sd, icd, secd, nvd, db, auditor, rle, rbacPolicyUsage),
+		auth.NewAPI(cfg, ad, fd, db, rbacPolicyUsage),
+		registryv2.NewAPI(cfg, ad, fd, sd, icd, secd, cdnd, nvd, db, auditor, rle, keppel.NewConcurrencyLimiter(cfg.RequestLimits.MaxConcurrentRequestsPerAccount), keppel.NewDistributionNotifier(ctx, cfg), pullAttestations, rbacPolicyUsage),
 		peerv1.NewAPI(cfg, ad, db),
 		&headerReflector{logg.ShowDebug}, // the header reflection endpoint is only enabled where debugging is enabled (i.e. usually in dev/QA only)
 		httpapi.HealthCheckAPI{
@@ -91,19 +122,56 @@ func run(cmd *cobra.Command, args []string) {
 			},
 		},
 		httpapi.WithGlobalMiddleware(reportClientIP),
-		httpapi.WithGlobalMiddleware(corsMiddleware.Handler),
+		httpapi.WithGlobalMiddleware(normalizeClientIP), // must be outside of reportClientIP
+		httpapi.WithGlobalMiddleware(func(inner http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				corsMiddleware.Load().ServeHTTP(w, r, inner.ServeHTTP)
+			})
+		}),
+		httpapi.WithGlobalMiddleware((&announcementReporter{db: db}).Middleware),
 		pprofapi.API{IsAuthorized: pprofapi.IsRequestFromLocalhost},
 		// This needs to be at the end because it is the fallback match for all
 		// paths that are not otherwise defined.
 		&guiRedirecter{db, os.Getenv("KEPPEL_GUI_URI")},
 	)
 	mux := http.NewServeMux()
-	mux.Handle("/", handler)
+	mux.Handle("/", limitRequests(cfg.RequestLimits, handler))
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("GET /version", serveVersion)
+	rootHandler := monitoringMiddleware(monitoring, mux)
+
+	// start HTTPS server for custom domains if requested
+	if tlsListenAddress := os.Getenv("KEPPEL_API_TLS_LISTEN_ADDRESS"); tlsListenAddress != "" {
+		tlsConfig := must.Return(newTLSConfigForCustomDomains(ctx, dbURL, db, secd))
+		go func() {
+			must.Succeed(listenAndServe(ctx, tlsListenAddress, rootHandler, cfg.RequestLimits, tlsConfig))
+		}()
+	}
 
 	// start HTTP server
 	apiListenAddress := osext.GetenvOrDefault("KEPPEL_API_LISTEN_ADDRESS", ":8080")
-	must.Succeed(httpext.ListenAndServeContext(ctx, apiListenAddress, mux))
+	must.Succeed(listenAndServe(ctx, apiListenAddress, rootHandler, cfg.RequestLimits, nil))
+}
+
+// reloadable builds a value from the environment, and builds it again whenever
+// the given variables are changed by a reload of the configuration file.
+func reloadable[T any](build func() (*T, error), names ...string) (*atomic.Pointer[T], error) {
+	var result atomic.Pointer[T]
+	value, err := build()
+	if err != nil {
+		return nil, err
+	}
+	result.Store(value)
+
+	keppel.OnConfigFileReload(func() error {
+		value, err := build()
+		if err != nil {
+			return err
+		}
+		result.Store(value)
+		return nil
+	}, names...)
+	return &result, nil
 }
 
 // Note that, since Redis is optional, this may return (nil, nil).
@@ -164,7 +232,22 @@ func reportClientIP(inner http.Handler) http.Handler {
 		// This middleware adds the X-Keppel-Your-Ip header to all requests, which is used:
 		// 1. by end users to understand which IPs they need to put in their RBAC policies
 		// 2. by Keppel operators to check if X-Forwarded-For is transported correctly through reverse proxies
-		w.Header().Set("X-Keppel-Your-Ip", httpext.GetRequesterIPFor(r))
+		w.Header().Set("X-Keppel-Your-Ip", keppel.ClientIPFor(r))
+		inner.ServeHTTP(w, r)
+	})
+}
+
+func normalizeClientIP(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// This middleware brings the requester IP into its canonical form before
+		// anything else looks at it. Otherwise, the same IPv6 address could
+		// appear in different spellings in RBAC policy evaluation, rate limit
+		// keys and audit events.
+		if xForwardedFor := r.Header.Get("X-Forwarded-For"); xForwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", keppel.NormalizeForwardedFor(xForwardedFor))
+		} else if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			r.RemoteAddr = net.JoinHostPort(keppel.NormalizeIP(host), port)
+		}
 		inner.ServeHTTP(w, r)
 	})
 }
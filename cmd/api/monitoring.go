@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package apicmd
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sapcc/go-api-declarations/bininfo"
+	"github.com/sapcc/go-bits/respondwith"
+)
+
+// monitoringEndpoints maps the names accepted in
+// KEPPEL_API_UNAUTHENTICATED_ENDPOINTS to the paths of the respective
+// endpoints. None of these endpoints reveals anything about accounts, repos or
+// their contents. No other endpoints can be exempted from authentication.
+var monitoringEndpoints = map[string]string{
+	"healthcheck": "/healthcheck",
+	"metrics":     "/metrics",
+	"version":     "/version",
+}
+
+// monitoringGate restricts access to the monitoring endpoints of keppel-api.
+// If no token is configured, the monitoring endpoints are public, as they
+// always have been.
+type monitoringGate struct {
+	Token           string
+	PublicEndpoints []string // names from monitoringEndpoints
+}
+
+// newMonitoringGate builds the monitoringGate from the
+// KEPPEL_API_MONITORING_TOKEN and KEPPEL_API_UNAUTHENTICATED_ENDPOINTS
+// environment variables.
+func newMonitoringGate() (*monitoringGate, error) {
+	g := &monitoringGate{
+		Token:           os.Getenv("KEPPEL_API_MONITORING_TOKEN"),
+		PublicEndpoints: splitList(os.Getenv("KEPPEL_API_UNAUTHENTICATED_ENDPOINTS")),
+	}
+
+	validNames := make([]string, 0, len(monitoringEndpoints))
+	for name := range monitoringEndpoints {
+		validNames = append(validNames, fmt.Sprintf("%q", name))
+	}
+	slices.Sort(validNames)
+	for _, name := range g.PublicEndpoints {
+		if _, exists := monitoringEndpoints[name]; !exists {
+			return nil, fmt.Errorf("invalid value in KEPPEL_API_UNAUTHENTICATED_ENDPOINTS: %q is not a monitoring endpoint (only %s may be accessed without authentication)",
+				name, strings.Join(validNames, ", "))
+		}
+	}
+	if len(g.PublicEndpoints) > 0 && g.Token == "" {
+		return nil, errors.New("KEPPEL_API_UNAUTHENTICATED_ENDPOINTS has no effect unless KEPPEL_API_MONITORING_TOKEN is set")
+	}
+
+	return g, nil
+}
+
+// monitoringMiddleware rejects requests for monitoring endpoints that are
+// neither listed in PublicEndpoints nor carry the monitoring token. All other
+// requests are passed through unchanged since they are subject to regular
+// auth. The gate is looked up on each request since it is replaced when the
+// configuration file is reloaded.
+func monitoringMiddleware(gate *atomic.Pointer[monitoringGate], inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := gate.Load()
+		if g.requiresToken(r.URL.Path) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(g.Token)) != 1 {
+				w.Header().Set("Www-Authenticate", `Bearer realm="keppel-monitoring"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+func (g *monitoringGate) requiresToken(path string) bool {
+	if g.Token == "" {
+		return false
+	}
+	for name, endpointPath := range monitoringEndpoints {
+		if path == endpointPath {
+			return !slices.Contains(g.PublicEndpoints, name)
+		}
+	}
+	return false
+}
+
+// serveVersion implements the GET /version endpoint.
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+	_ = r
+	respondwith.JSON(w, http.StatusOK, map[string]string{
+		"component": bininfo.Component(),
+		"version":   bininfo.VersionOr("rolling"),
+	})
+}
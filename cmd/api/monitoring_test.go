@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package apicmd
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sapcc/go-api-declarations/bininfo"
+)
+
+func newMonitoringTestHandler(t *testing.T, token, publicEndpoints string) (http.Handler, *atomic.Pointer[monitoringGate]) {
+	t.Helper()
+	t.Setenv("KEPPEL_API_MONITORING_TOKEN", token)
+	t.Setenv("KEPPEL_API_UNAUTHENTICATED_ENDPOINTS", publicEndpoints)
+	g, err := newMonitoringGate()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var gate atomic.Pointer[monitoringGate]
+	gate.Store(g)
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return monitoringMiddleware(&gate, inner), &gate
+}
+
+func checkMonitoringRequest(t *testing.T, h http.Handler, path, authHeader string, expectedStatus int) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != expectedStatus {
+		t.Errorf("GET %s with Authorization %q: expected status %d, but got %d", path, authHeader, expectedStatus, rec.Code)
+	}
+	if rec.Code == http.StatusUnauthorized {
+		if v := rec.Header().Get("Www-Authenticate"); v != `Bearer realm="keppel-monitoring"` {
+			t.Errorf("GET %s: unexpected Www-Authenticate header: %q", path, v)
+		}
+	}
+}
+
+func TestMonitoringWithoutToken(t *testing.T) {
+	// without a token, all monitoring endpoints are public
+	h, _ := newMonitoringTestHandler(t, "", "")
+	for _, path := range []string{"/healthcheck", "/metrics", "/version"} {
+		checkMonitoringRequest(t, h, path, "", http.StatusOK)
+	}
+}
+
+func TestMonitoringWithToken(t *testing.T) {
+	h, gate := newMonitoringTestHandler(t, "secret", "healthcheck")
+
+	// endpoints listed in KEPPEL_API_UNAUTHENTICATED_ENDPOINTS remain public
+	checkMonitoringRequest(t, h, "/healthcheck", "", http.StatusOK)
+
+	// other monitoring endpoints require the token
+	for _, path := range []string{"/metrics", "/version"} {
+		checkMonitoringRequest(t, h, path, "", http.StatusUnauthorized)
+		checkMonitoringRequest(t, h, path, "Bearer wrong", http.StatusUnauthorized)
+		checkMonitoringRequest(t, h, path, "Bearer secretsecret", http.StatusUnauthorized)
+		checkMonitoringRequest(t, h, path, "Basic secret", http.StatusUnauthorized)
+		checkMonitoringRequest(t, h, path, "secret", http.StatusUnauthorized)
+		checkMonitoringRequest(t, h, path, "Bearer secret", http.StatusOK)
+	}
+
+	// only the exact paths are gated; everything else is subject to regular auth
+	// and passed through unchanged
+	checkMonitoringRequest(t, h, "/metrics/", "", http.StatusOK)
+	checkMonitoringRequest(t, h, "/keppel/v1/accounts", "", http.StatusOK)
+	checkMonitoringRequest(t, h, "/v2/", "Bearer some-registry-token", http.StatusOK)
+
+	// when the configuration is reloaded, the new gate takes effect immediately
+	gate.Store(&monitoringGate{Token: "new-secret", PublicEndpoints: []string{"metrics", "version"}})
+	checkMonitoringRequest(t, h, "/healthcheck", "Bearer secret", http.StatusUnauthorized)
+	checkMonitoringRequest(t, h, "/healthcheck", "Bearer new-secret", http.StatusOK)
+	checkMonitoringRequest(t, h, "/metrics", "", http.StatusOK)
+	checkMonitoringRequest(t, h, "/version", "", http.StatusOK)
+}
+
+func TestMonitoringInvalidConfig(t *testing.T) {
+	testCases := []struct {
+		Token           string
+		PublicEndpoints string
+		ExpectedError   string
+	}{
+		{
+			Token:           "secret",
+			PublicEndpoints: "healthcheck,accounts",
+			ExpectedError:   `invalid value in KEPPEL_API_UNAUTHENTICATED_ENDPOINTS: "accounts" is not a monitoring endpoint (only "healthcheck", "metrics", "version" may be accessed without authentication)`,
+		},
+		{
+			Token:           "secret",
+			PublicEndpoints: "/metrics",
+			ExpectedError:   `invalid value in KEPPEL_API_UNAUTHENTICATED_ENDPOINTS: "/metrics" is not a monitoring endpoint (only "healthcheck", "metrics", "version" may be accessed without authentication)`,
+		},
+		{
+			Token:           "",
+			PublicEndpoints: "metrics",
+			ExpectedError:   "KEPPEL_API_UNAUTHENTICATED_ENDPOINTS has no effect unless KEPPEL_API_MONITORING_TOKEN is set",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Setenv("KEPPEL_API_MONITORING_TOKEN", tc.Token)
+		t.Setenv("KEPPEL_API_UNAUTHENTICATED_ENDPOINTS", tc.PublicEndpoints)
+		_, err := newMonitoringGate()
+		if err == nil {
+			t.Errorf("expected error %q, but got none", tc.ExpectedError)
+		} else if err.Error() != tc.ExpectedError {
+			t.Errorf("expected error %q, but got %q", tc.ExpectedError, err.Error())
+		}
+	}
+
+	// whitespace and empty entries in the list are ignored
+	t.Setenv("KEPPEL_API_MONITORING_TOKEN", "secret")
+	t.Setenv("KEPPEL_API_UNAUTHENTICATED_ENDPOINTS", " healthcheck, ,metrics ")
+	g, err := newMonitoringGate()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(g.PublicEndpoints) != 2 || g.PublicEndpoints[0] != "healthcheck" || g.PublicEndpoints[1] != "metrics" {
+		t.Errorf("unexpected public endpoints: %#v", g.PublicEndpoints)
+	}
+}
+
+func TestServeVersion(t *testing.T) {
+	rec := httptest.NewRecorder()
+	serveVersion(rec, httptest.NewRequest(http.MethodGet, "/version", http.NoBody))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, but got %d", rec.Code)
+	}
+	var data map[string]string
+	err := json.Unmarshal(rec.Body.Bytes(), &data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// test binaries are built without version information
+	expected := map[string]string{"component": bininfo.Component(), "version": "rolling"}
+	if !maps.Equal(data, expected) {
+		t.Errorf("expected response body %v, but got %s", expected, rec.Body.String())
+	}
+}
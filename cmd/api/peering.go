@@ -24,11 +24,18 @@ import (
 type peeringConfig []struct {
 	Hostname             string `json:"hostname"`
 	UseForPullDelegation *bool  `json:"use_for_pull_delegation"`
+	UseForReplication    bool   `json:"use_for_replication"`
+	Region               string `json:"region"`
+	LinkCost             int    `json:"link_cost"`
 }
 
 var createOrUpdatePeerQuery = sqlext.SimplifyWhitespace(`
-	INSERT INTO peers (hostname, use_for_pull_delegation) VALUES ($1, $2)
-		ON CONFLICT (hostname) DO UPDATE SET use_for_pull_delegation = EXCLUDED.use_for_pull_delegation
+	INSERT INTO peers (hostname, use_for_pull_delegation, use_for_replication, region, link_cost) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (hostname) DO UPDATE SET
+			use_for_pull_delegation = EXCLUDED.use_for_pull_delegation,
+			use_for_replication = EXCLUDED.use_for_replication,
+			region = EXCLUDED.region,
+			link_cost = EXCLUDED.link_cost
 `)
 
 func runPeering(ctx context.Context, cfg keppel.Configuration, db *keppel.DB) {
@@ -42,12 +49,15 @@ func runPeering(ctx context.Context, cfg keppel.Configuration, db *keppel.DB) {
 	// add missing entries to `peers` table
 	for _, peer := range peeringCfg {
 		isPeerHostName[peer.Hostname] = true
+		if peer.LinkCost < 0 {
+			logg.Fatal("malformed KEPPEL_PEERS: link_cost for %s may not be negative", peer.Hostname)
+		}
 
 		useForPullDelegation := true
 		if peer.UseForPullDelegation != nil {
 			useForPullDelegation = *peer.UseForPullDelegation
 		}
-		_ = must.Return(db.Exec(createOrUpdatePeerQuery, peer.Hostname, useForPullDelegation))
+		_ = must.Return(db.Exec(createOrUpdatePeerQuery, peer.Hostname, useForPullDelegation, peer.UseForReplication, peer.Region, peer.LinkCost))
 	}
 
 	// remove old entries from `peers` table
@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package apicmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/sapcc/go-bits/httpext"
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+var (
+	manifestPathRx   = regexp.MustCompile(`^/v2/.+/manifests/[^/]+$`)
+	blobUploadPathRx = regexp.MustCompile(`^/v2/.+/blobs/uploads/`)
+)
+
+// limitRequests is a middleware that enforces the limits from
+// keppel.RequestLimits on incoming requests, depending on which class of
+// endpoint they are directed at.
+//
+// This middleware must be the outermost layer in the handler chain because the
+// http.ResponseWriter wrappers from go-bits/httpapi do not support setting
+// read deadlines.
+func limitRequests(limits keppel.RequestLimits, inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case blobUploadPathRx.MatchString(r.URL.Path):
+			// blob uploads can be arbitrarily large and take arbitrarily long; they
+			// are only limited by the quotas in the storage backend
+		case manifestPathRx.MatchString(r.URL.Path):
+			keppel.LimitRequestBody(w, r, limits.MaxManifestBodySizeBytes, limits.ManifestBodyReadTimeout)
+		default:
+			keppel.LimitRequestBody(w, r, limits.MaxJSONBodySizeBytes, limits.JSONBodyReadTimeout)
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// listenAndServe is like httpext.ListenAndServeContext, but configures the
+// server-wide timeouts from keppel.RequestLimits. If tlsConfig is not nil,
+// the server terminates TLS itself.
+func listenAndServe(ctx context.Context, addr string, handler http.Handler, limits keppel.RequestLimits, tlsConfig *tls.Config) error {
+	logg.Info("Listening on %s...", addr)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: limits.ReadHeaderTimeout,
+		IdleTimeout:       limits.IdleTimeout,
+		TLSConfig:         tlsConfig,
+	}
+
+	shutdownErrChan := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		logg.Info("Shutting down HTTP server...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpext.ShutdownTimeout)
+		defer cancel()
+		shutdownErrChan <- server.Shutdown(shutdownCtx)
+	}()
+
+	var err error
+	if tlsConfig == nil {
+		err = server.ListenAndServe()
+	} else {
+		// certificates are supplied by tlsConfig.GetCertificate
+		err = server.ListenAndServeTLS("", "")
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("ListenAndServe failed: %w", err)
+	}
+	err = <-shutdownErrChan
+	if err != nil {
+		return fmt.Errorf("could not shutdown HTTP server: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package apicmd
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// Like the actual API handlers, this handler reads the request body and
+// reports errors from AsRequestBodyError as API errors.
+var bodyReadingHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if rerr := keppel.AsRequestBodyError(err); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(strconv.Itoa(len(buf))))
+})
+
+func TestLimitRequestsBodySize(t *testing.T) {
+	limits := keppel.RequestLimits{
+		MaxJSONBodySizeBytes:     10,
+		MaxManifestBodySizeBytes: 100,
+	}
+	server := httptest.NewServer(limitRequests(limits, bodyReadingHandler))
+	defer server.Close()
+
+	testCases := []struct {
+		Method       string
+		Path         string
+		BodySize     int
+		ExpectStatus int
+	}{
+		// JSON endpoints use the JSON limit
+		{"PUT", "/keppel/v1/accounts/test1", 10, http.StatusOK},
+		{"PUT", "/keppel/v1/accounts/test1", 11, http.StatusRequestEntityTooLarge},
+		{"POST", "/keppel/v1/auth/peering", 50, http.StatusRequestEntityTooLarge},
+		// manifest PUTs use the manifest limit
+		{"PUT", "/v2/test1/foo/manifests/latest", 100, http.StatusOK},
+		{"PUT", "/v2/test1/foo/manifests/latest", 101, http.StatusRequestEntityTooLarge},
+		// the manifest limit does not apply to other paths below a repo that is called "manifests"
+		{"PUT", "/keppel/v1/accounts/test1/repositories/manifests", 50, http.StatusRequestEntityTooLarge},
+		// blob uploads are not limited
+		{"PATCH", "/v2/test1/foo/blobs/uploads/9b8dd4e5-9f5e-4c1e-a9a0-4d2f84ad1b5f", 1000, http.StatusOK},
+		{"POST", "/v2/test1/foo/blobs/uploads/?digest=sha256:abc", 1000, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		req, err := http.NewRequest(tc.Method, server.URL+tc.Path, bytes.NewReader(bytes.Repeat([]byte("x"), tc.BodySize)))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.ExpectStatus {
+			t.Errorf("%s %s with %d bytes: expected status %d, but got %d: %s",
+				tc.Method, tc.Path, tc.BodySize, tc.ExpectStatus, resp.StatusCode, respBody)
+			continue
+		}
+		switch tc.ExpectStatus {
+		case http.StatusOK:
+			if string(respBody) != strconv.Itoa(tc.BodySize) {
+				t.Errorf("%s %s with %d bytes: handler saw only %s bytes", tc.Method, tc.Path, tc.BodySize, respBody)
+			}
+		case http.StatusRequestEntityTooLarge:
+			if !strings.Contains(string(respBody), "request body exceeds the maximum size of") {
+				t.Errorf("%s %s with %d bytes: unexpected error message: %s", tc.Method, tc.Path, tc.BodySize, respBody)
+			}
+		}
+	}
+}
+
+func TestLimitRequestsReadTimeout(t *testing.T) {
+	limits := keppel.RequestLimits{
+		JSONBodyReadTimeout:     100 * time.Millisecond,
+		ManifestBodyReadTimeout: 100 * time.Millisecond,
+	}
+	server := httptest.NewServer(limitRequests(limits, bodyReadingHandler))
+	defer server.Close()
+
+	// sendSlowRequest announces a body of 10 bytes, but only sends the first
+	// half of it before stalling for longer than the read timeout
+	sendSlowRequest := func(path string) string {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer conn.Close()
+		_, err = io.WriteString(conn, "PUT "+path+" HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nxxxxx")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		resp, err := io.ReadAll(conn)
+		if err != nil && len(resp) == 0 {
+			t.Fatal(err.Error())
+		}
+		return string(resp)
+	}
+
+	for _, path := range []string{"/keppel/v1/accounts/test1", "/v2/test1/foo/manifests/latest"} {
+		resp := sendSlowRequest(path)
+		if !strings.HasPrefix(resp, "HTTP/1.1 408 ") || !strings.Contains(resp, "request body was not received in time") {
+			t.Errorf("PUT %s: expected 408 response for slow request body, but got: %q", path, resp)
+		}
+	}
+
+	// requests that deliver their body in time are not affected
+	req, err := http.NewRequest(http.MethodPut, server.URL+"/keppel/v1/accounts/test1", strings.NewReader("xxxxxxxxxx"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected fast request to succeed, but got status %d", resp.StatusCode)
+	}
+}
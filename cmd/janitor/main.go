@@ -40,6 +40,7 @@ func run(cmd *cobra.Command, args []string) {
 
 	cfg := keppel.ParseConfiguration()
 	ctx := httpext.ContextWithSIGINT(cmd.Context(), 10*time.Second)
+	keppel.WatchConfigFile(ctx)
 	auditor := must.Return(keppel.InitAuditTrail(ctx))
 
 	dbURL, dbName := keppel.GetDatabaseURLFromEnvironment()
@@ -50,25 +51,60 @@ func run(cmd *cobra.Command, args []string) {
 	ad := must.Return(keppel.NewAuthDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_AUTH"), nil))
 	amd := must.Return(keppel.NewAccountManagementDriver(osext.MustGetenv("KEPPEL_DRIVER_ACCOUNT_MANAGEMENT")))
 	fd := must.Return(keppel.NewFederationDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_FEDERATION"), ad, cfg))
-	sd := must.Return(keppel.NewStorageDriver(osext.MustGetenv("KEPPEL_DRIVER_STORAGE"), ad, cfg))
+	sd := keppel.InstrumentStorageDriver(must.Return(keppel.NewStorageDriver(osext.MustGetenv("KEPPEL_DRIVER_STORAGE"), ad, cfg)))
 	icd := must.Return(keppel.NewInboundCacheDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_INBOUND_CACHE"), cfg))
+	secd := must.Return(keppel.NewSecretsDriver(ctx, osext.GetenvOrDefault("KEPPEL_DRIVER_SECRETS", "trivial"), cfg))
+	var bd keppel.BackupDriver
+	if pluginTypeID := osext.GetenvOrDefault("KEPPEL_DRIVER_BACKUP", ""); pluginTypeID != "" {
+		bd = must.Return(keppel.NewBackupDriver(ctx, pluginTypeID, cfg))
+	}
+	var cdnd keppel.CDNDriver
+	if pluginTypeID := osext.GetenvOrDefault("KEPPEL_DRIVER_CDN", ""); pluginTypeID != "" {
+		cdnd = must.Return(keppel.NewCDNDriver(ctx, pluginTypeID, cfg))
+	}
+	var nvd keppel.NameValidationDriver
+	if pluginTypeID := osext.GetenvOrDefault("KEPPEL_DRIVER_NAME_VALIDATION", ""); pluginTypeID != "" {
+		nvd = must.Return(keppel.NewNameValidationDriver(ctx, pluginTypeID, cfg))
+	}
 
 	// start task loops
-	janitor := tasks.NewJanitor(cfg, fd, sd, icd, db, amd, auditor)
+	janitor := tasks.NewJanitor(cfg, fd, sd, icd, secd, bd, cdnd, nvd, db, amd, auditor)
 	go janitor.AccountFederationAnnouncementJob(nil).Run(ctx)
 	go janitor.AbandonedUploadCleanupJob(nil).Run(ctx)
 	go janitor.DeleteAccountsJob(nil).Run(ctx)
 	go janitor.EnforceManagedAccountsJob(nil).Run(ctx)
 	go janitor.ManifestGarbageCollectionJob(nil).Run(ctx)
+	go janitor.TagRetentionJob(nil).Run(ctx)
 	go janitor.BlobMountSweepJob(nil).Run(ctx)
 	go janitor.BlobSweepJob(nil).Run(ctx)
 	go janitor.StorageSweepJob(nil).Run(ctx)
+	go janitor.OrphanedSegmentSweepJob(nil).Run(ctx)
 	go janitor.ManifestSyncJob(nil).Run(ctx)
+	go janitor.ReplicaRevalidationJob(nil).Run(ctx)
+	go janitor.TagWatchJob(nil).Run(ctx)
+	go janitor.CredentialReportJob(nil).Run(ctx)
+	go janitor.CustomDomainVerificationJob(nil).Run(ctx)
 	go janitor.BlobValidationJob(nil).Run(ctx)
 	go janitor.ManifestValidationJob(nil).Run(ctx)
+	go janitor.ManifestVerificationJob(nil).Run(ctx)
+	go janitor.BackgroundMigrationJob(nil).Run(ctx)
+	go janitor.LazyPullVariantJob(nil).Run(ctx)
+	go janitor.RecompressedVariantJob(nil).Run(ctx)
+	go janitor.WebhookDeliveryJob(nil).Run(ctx)
+	go janitor.CDNPurgeJob(nil).Run(ctx)
+	go janitor.PullAttestationPruningJob(nil).Run(ctx)
+	go janitor.DatabaseMaintenanceJob(nil).Run(ctx)
 	if cfg.Trivy != nil {
 		go janitor.CheckTrivySecurityStatusJob(nil).Run(ctx, jobloop.NumGoroutines(3))
 	}
+	if cfg.Telemetry != nil {
+		go janitor.TelemetryExportJob(nil).Run(ctx)
+	}
+	if bd != nil {
+		go janitor.BlobBackupJob(nil).Run(ctx)
+		go janitor.ManifestBackupJob(nil).Run(ctx)
+		go janitor.BackupSnapshotJob(nil).Run(ctx)
+	}
 
 	// start HTTP server for Prometheus metrics and health check
 	handler := httpapi.Compose(
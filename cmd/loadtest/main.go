@@ -0,0 +1,298 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package loadtestcmd
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/httpext"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var longDesc = strings.TrimSpace(`
+Generates push and pull traffic against a single repository on a Keppel
+deployment, and reports latency percentiles for each type of operation. This is
+intended for capacity validation before production rollouts.
+
+Each push uploads a new image with random layer contents under one of a fixed
+set of tags, thus replacing the image that previously had this tag. Each pull
+downloads the manifest and all layers of a previously pushed image. The images
+are not runnable, and the repository should be deleted after the load test.
+`)
+
+var (
+	authUserName          string
+	authPassword          string
+	testDuration          time.Duration
+	concurrency           uint
+	pushRatio             float64
+	layersPerImage        uint
+	layerSizeDistribution string
+	tagCount              uint
+)
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "loadtest <repository>",
+		Example: "  keppel loadtest registry.example.org/loadtest/images --concurrency 50 --layer-sizes 1M:90,64M:10",
+		Short:   "Generates push/pull traffic against a Keppel repository and reports latencies.",
+		Long:    longDesc,
+		Args:    cobra.ExactArgs(1),
+		Run:     run,
+	}
+	cmd.PersistentFlags().StringVarP(&authUserName, "username", "u", "", "User name (must have push and pull access to the repository).")
+	cmd.PersistentFlags().StringVarP(&authPassword, "password", "p", "", "Password (must have push and pull access to the repository).")
+	cmd.PersistentFlags().DurationVarP(&testDuration, "duration", "d", time.Minute, "How long to generate traffic for.")
+	cmd.PersistentFlags().UintVarP(&concurrency, "concurrency", "c", 10, "How many operations to run in parallel.")
+	cmd.PersistentFlags().Float64Var(&pushRatio, "push-ratio", 0.2, "Fraction of operations that are pushes (between 0 and 1). All other operations are pulls.")
+	cmd.PersistentFlags().UintVar(&layersPerImage, "layers", 3, "Number of layers in each pushed image.")
+	cmd.PersistentFlags().StringVar(&layerSizeDistribution, "layer-sizes", "1M:70,16M:25,128M:5", "Distribution of layer sizes, as a comma-separated list of `size:weight` pairs. Sizes may have the binary suffixes K, M or G.")
+	cmd.PersistentFlags().UintVar(&tagCount, "tags", 10, "Number of distinct tags that pushes rotate through. Fewer tags mean more tag churn, i.e. more images becoming untagged.")
+	parent.AddCommand(cmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ref, interpretation, err := models.ParseImageReference(args[0])
+	logg.Info("interpreting %s as %s", args[0], interpretation)
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+	sizes, err := parseSizeDistribution(layerSizeDistribution)
+	if err != nil {
+		logg.Fatal("invalid value for --layer-sizes: %s", err.Error())
+	}
+	if pushRatio < 0 || pushRatio > 1 {
+		logg.Fatal("invalid value for --push-ratio: must be between 0 and 1")
+	}
+	if concurrency == 0 || tagCount == 0 || layersPerImage == 0 {
+		logg.Fatal("--concurrency, --layers and --tags must be positive")
+	}
+
+	lt := &loadTest{
+		Client: &client.RepoClient{
+			Host:     ref.Host,
+			RepoName: ref.RepoName,
+			UserName: authUserName,
+			Password: authPassword,
+		},
+		LayerSizes: sizes,
+		Images:     make(map[string][]digest.Digest),
+		Results:    make(map[operation]*operationResults),
+	}
+
+	// pulls need something to pull, so start by pushing to each tag once
+	ctx := httpext.ContextWithSIGINT(cmd.Context(), 1*time.Second)
+	logg.Info("pushing initial images to %d tags...", tagCount)
+	for idx := range tagCount {
+		err := lt.push(ctx, tagName(idx))
+		if err != nil {
+			logg.Fatal("initial push failed: %s", err.Error())
+		}
+	}
+	lt.Results = make(map[operation]*operationResults) // do not count the initial pushes
+
+	logg.Info("generating traffic for %s with concurrency %d...", testDuration, concurrency)
+	ctx, cancel := context.WithTimeout(ctx, testDuration)
+	defer cancel()
+	startedAt := time.Now()
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lt.runWorker(ctx)
+		}()
+	}
+	wg.Wait()
+
+	lt.printReport(os.Stdout, time.Since(startedAt))
+}
+
+func tagName(idx uint) string {
+	return fmt.Sprintf("loadtest-%d", idx)
+}
+
+type operation string
+
+const (
+	pushOperation operation = "push"
+	pullOperation operation = "pull"
+)
+
+type operationResults struct {
+	Latencies []time.Duration
+	Errors    uint64
+	Bytes     uint64
+}
+
+type loadTest struct {
+	Client     *client.RepoClient
+	LayerSizes sizeDistribution
+
+	mutex   sync.Mutex
+	Images  map[string][]digest.Digest // tag name -> layer digests of the image currently behind that tag
+	Results map[operation]*operationResults
+}
+
+func (lt *loadTest) runWorker(ctx context.Context) {
+	for ctx.Err() == nil {
+		op := pullOperation
+		if mathrand.Float64() < pushRatio {
+			op = pushOperation
+		}
+		tag := tagName(mathrand.UintN(tagCount))
+
+		startedAt := time.Now()
+		var (
+			bytes uint64
+			err   error
+		)
+		if op == pushOperation {
+			err = lt.push(ctx, tag)
+		} else {
+			bytes, err = lt.pull(ctx, tag)
+		}
+		duration := time.Since(startedAt)
+
+		if ctx.Err() != nil {
+			// operations interrupted by the end of the test would skew the result
+			return
+		}
+		lt.recordResult(op, duration, bytes, err)
+	}
+}
+
+func (lt *loadTest) recordResult(op operation, duration time.Duration, bytes uint64, err error) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	result := lt.Results[op]
+	if result == nil {
+		result = &operationResults{}
+		lt.Results[op] = result
+	}
+	if err != nil {
+		logg.Error("%s failed: %s", op, err.Error())
+		result.Errors++
+		return
+	}
+	result.Latencies = append(result.Latencies, duration)
+	result.Bytes += bytes
+}
+
+// Pushes a new image with random layer contents under the given tag.
+func (lt *loadTest) push(ctx context.Context, tag string) error {
+	layers := make([]imgspecv1.Descriptor, layersPerImage)
+	layerDigests := make([]digest.Digest, layersPerImage)
+	diffIDs := make([]digest.Digest, layersPerImage)
+	for idx := range layers {
+		contents := make([]byte, lt.LayerSizes.Sample())
+		_, err := rand.Read(contents)
+		if err != nil {
+			return err
+		}
+		d, err := lt.Client.UploadMonolithicBlob(ctx, contents)
+		if err != nil {
+			return fmt.Errorf("while uploading layer: %w", err)
+		}
+		layers[idx] = imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageLayer,
+			Digest:    d,
+			Size:      int64(len(contents)),
+		}
+		layerDigests[idx] = d
+		diffIDs[idx] = d
+	}
+
+	config, err := buildImageConfig(diffIDs)
+	if err != nil {
+		return err
+	}
+	configDigest, err := lt.Client.UploadMonolithicBlob(ctx, config)
+	if err != nil {
+		return fmt.Errorf("while uploading image config: %w", err)
+	}
+	manifest, err := buildImageManifest(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    configDigest,
+		Size:      int64(len(config)),
+	}, layers)
+	if err != nil {
+		return err
+	}
+	_, err = lt.Client.UploadManifest(ctx, manifest, imgspecv1.MediaTypeImageManifest, tag)
+	if err != nil {
+		return fmt.Errorf("while uploading manifest: %w", err)
+	}
+
+	lt.mutex.Lock()
+	lt.Images[tag] = layerDigests
+	lt.mutex.Unlock()
+	return nil
+}
+
+// Pulls the manifest and all layers of the image under the given tag.
+// Returns the number of bytes downloaded.
+func (lt *loadTest) pull(ctx context.Context, tag string) (uint64, error) {
+	lt.mutex.Lock()
+	layerDigests := lt.Images[tag]
+	lt.mutex.Unlock()
+
+	manifest, _, err := lt.Client.DownloadManifest(ctx, models.ManifestReference{Tag: tag}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("while downloading manifest: %w", err)
+	}
+	total := uint64(len(manifest))
+
+	// If the tag was pushed to concurrently, we might download layers of the
+	// previous image. This is fine since those layers are still present.
+	for _, d := range layerDigests {
+		contents, _, err := lt.Client.DownloadBlob(ctx, d)
+		if err != nil {
+			return total, fmt.Errorf("while downloading layer %s: %w", d, err)
+		}
+		n, err := io.Copy(io.Discard, contents)
+		contents.Close()
+		total += uint64(n) //nolint:gosec // n is never negative
+		if err != nil {
+			return total, fmt.Errorf("while downloading layer %s: %w", d, err)
+		}
+	}
+	return total, nil
+}
+
+func (lt *loadTest) printReport(w io.Writer, duration time.Duration) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPERATION\tCOUNT\tERRORS\tRATE\tTHROUGHPUT\tP50\tP90\tP99\tMAX")
+	for _, op := range []operation{pushOperation, pullOperation} {
+		result := lt.Results[op]
+		if result == nil {
+			continue
+		}
+		p := computePercentiles(result.Latencies)
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f/s\t%s/s\t%s\t%s\t%s\t%s\n",
+			op, len(result.Latencies), result.Errors,
+			float64(len(result.Latencies))/duration.Seconds(),
+			formatBytes(uint64(float64(result.Bytes)/duration.Seconds())),
+			p.P50, p.P90, p.P99, p.Max,
+		)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package loadtestcmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	mathrand "math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type sizeDistributionEntry struct {
+	SizeBytes uint64
+	Weight    uint64
+}
+
+// sizeDistribution is a weighted list of sizes, as given in the --layer-sizes flag.
+type sizeDistribution []sizeDistributionEntry
+
+func parseSizeDistribution(input string) (sizeDistribution, error) {
+	var result sizeDistribution
+	for field := range strings.SplitSeq(input, ",") {
+		sizeStr, weightStr, ok := strings.Cut(strings.TrimSpace(field), ":")
+		if !ok {
+			return nil, fmt.Errorf("expected \"size:weight\", but got %q", field)
+		}
+		size, err := parseSize(sizeStr)
+		if err != nil {
+			return nil, err
+		}
+		weight, err := strconv.ParseUint(weightStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight %q: %w", weightStr, err)
+		}
+		result = append(result, sizeDistributionEntry{size, weight})
+	}
+
+	var totalWeight uint64
+	for _, e := range result {
+		var carry uint64
+		totalWeight, carry = bits.Add64(totalWeight, e.Weight, 0)
+		if carry != 0 {
+			return nil, errors.New("sum of weights is out of range")
+		}
+	}
+	if totalWeight == 0 {
+		return nil, errors.New("at least one weight must be positive")
+	}
+	return result, nil
+}
+
+func parseSize(input string) (uint64, error) {
+	numberStr := input
+	multiplier := uint64(1)
+	for idx, suffix := range []string{"K", "M", "G"} {
+		if s, ok := strings.CutSuffix(input, suffix); ok {
+			numberStr = s
+			multiplier = 1 << (10 * (idx + 1))
+			break
+		}
+	}
+	value, err := strconv.ParseUint(numberStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", input, err)
+	}
+	if value > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("invalid size %q: value out of range", input)
+	}
+	return value * multiplier, nil
+}
+
+// Sample returns a random size from the distribution.
+func (d sizeDistribution) Sample() uint64 {
+	var totalWeight uint64
+	for _, e := range d {
+		totalWeight += e.Weight
+	}
+	value := mathrand.Uint64N(totalWeight)
+	for _, e := range d {
+		if value < e.Weight {
+			return e.SizeBytes
+		}
+		value -= e.Weight
+	}
+	return d[len(d)-1].SizeBytes // unreachable
+}
+
+type percentiles struct {
+	P50, P90, P99, Max time.Duration
+}
+
+// computePercentiles uses the nearest-rank method.
+func computePercentiles(latencies []time.Duration) percentiles {
+	if len(latencies) == 0 {
+		return percentiles{}
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	rank := func(p int) time.Duration {
+		// nearest rank is ceil(p/100 * N), converted to a 0-based index
+		idx := (p*len(sorted)+99)/100 - 1
+		return sorted[max(idx, 0)]
+	}
+	return percentiles{
+		P50: rank(50),
+		P90: rank(90),
+		P99: rank(99),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+func formatBytes(value uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	amount := float64(value)
+	idx := 0
+	for amount >= 1024 && idx < len(units)-1 {
+		amount /= 1024
+		idx++
+	}
+	return fmt.Sprintf("%.1f %s", amount, units[idx])
+}
+
+func buildImageConfig(diffIDs []digest.Digest) ([]byte, error) {
+	created := time.Now()
+	return json.Marshal(imgspecv1.Image{
+		Created: &created,
+		Platform: imgspecv1.Platform{
+			Architecture: "amd64",
+			OS:           "linux",
+		},
+		RootFS: imgspecv1.RootFS{
+			Type:    "layers",
+			DiffIDs: diffIDs,
+		},
+	})
+}
+
+func buildImageManifest(config imgspecv1.Descriptor, layers []imgspecv1.Descriptor) ([]byte, error) {
+	return json.Marshal(imgspecv1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    layers,
+	})
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package loadtestcmd
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	testCases := []struct {
+		Input         string
+		Expected      uint64
+		ExpectedError string
+	}{
+		{"0", 0, ""},
+		{"512", 512, ""},
+		{"4K", 4 << 10, ""},
+		{"10M", 10 << 20, ""},
+		{"2G", 2 << 30, ""},
+		{"18446744073709551615", 18446744073709551615, ""},
+		{"17179869183G", 17179869183 << 30, ""},
+		{"17179869184G", 0, `invalid size "17179869184G": value out of range`},
+		{"18446744073709551615K", 0, `invalid size "18446744073709551615K": value out of range`},
+		{"18446744073709551616", 0, `invalid size "18446744073709551616": strconv.ParseUint: parsing "18446744073709551616": value out of range`},
+		{"", 0, `invalid size "": strconv.ParseUint: parsing "": invalid syntax`},
+		{"K", 0, `invalid size "K": strconv.ParseUint: parsing "": invalid syntax`},
+		{"1T", 0, `invalid size "1T": strconv.ParseUint: parsing "1T": invalid syntax`},
+		{"-1M", 0, `invalid size "-1M": strconv.ParseUint: parsing "-1": invalid syntax`},
+	}
+
+	for _, tc := range testCases {
+		actual, err := parseSize(tc.Input)
+		switch {
+		case tc.ExpectedError == "" && err != nil:
+			t.Errorf("parseSize(%q): unexpected error: %s", tc.Input, err.Error())
+		case tc.ExpectedError != "" && err == nil:
+			t.Errorf("parseSize(%q): expected error %q, but got %d", tc.Input, tc.ExpectedError, actual)
+		case tc.ExpectedError != "" && err.Error() != tc.ExpectedError:
+			t.Errorf("parseSize(%q): expected error %q, but got %q", tc.Input, tc.ExpectedError, err.Error())
+		case tc.ExpectedError == "" && actual != tc.Expected:
+			t.Errorf("parseSize(%q): expected %d, but got %d", tc.Input, tc.Expected, actual)
+		}
+	}
+}
+
+func TestParseSizeDistribution(t *testing.T) {
+	d, err := parseSizeDistribution("1M:3, 10M:1,100K:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := sizeDistribution{{1 << 20, 3}, {10 << 20, 1}, {100 << 10, 0}}
+	if !slices.Equal(d, expected) {
+		t.Errorf("expected %v, but got %v", expected, d)
+	}
+
+	// entries with weight zero are never sampled
+	for range 100 {
+		size := d.Sample()
+		if size != 1<<20 && size != 10<<20 {
+			t.Errorf("unexpected sample: %d", size)
+		}
+	}
+
+	errorCases := map[string]string{
+		"1M":                           `expected "size:weight", but got "1M"`,
+		"1M:x":                         `invalid weight "x": strconv.ParseUint: parsing "x": invalid syntax`,
+		"1X:1":                         `invalid size "1X": strconv.ParseUint: parsing "1X": invalid syntax`,
+		"1M:0,2M:0":                    `at least one weight must be positive`,
+		"1M:18446744073709551615,2M:1": `sum of weights is out of range`,
+		"99999999999999999999G:1":      `invalid size "99999999999999999999G": strconv.ParseUint: parsing "99999999999999999999": value out of range`,
+		"9999999999999999999G:1,1M:1":  `invalid size "9999999999999999999G": value out of range`,
+	}
+	for input, expectedError := range errorCases {
+		_, err := parseSizeDistribution(input)
+		if err == nil {
+			t.Errorf("parseSizeDistribution(%q): expected error %q, but got none", input, expectedError)
+		} else if err.Error() != expectedError {
+			t.Errorf("parseSizeDistribution(%q): expected error %q, but got %q", input, expectedError, err.Error())
+		}
+	}
+}
+
+func TestComputePercentiles(t *testing.T) {
+	if p := computePercentiles(nil); p != (percentiles{}) {
+		t.Errorf("expected zero percentiles for empty input, but got %#v", p)
+	}
+
+	// 1ms, 2ms, ..., 100ms in reverse order
+	var latencies []time.Duration
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	expected := percentiles{
+		P50: 50 * time.Millisecond,
+		P90: 90 * time.Millisecond,
+		P99: 99 * time.Millisecond,
+		Max: 100 * time.Millisecond,
+	}
+	if p := computePercentiles(latencies); p != expected {
+		t.Errorf("expected %#v, but got %#v", expected, p)
+	}
+	if latencies[0] != 100*time.Millisecond {
+		t.Error("computePercentiles() modified its input")
+	}
+
+	// with a single value, all percentiles are that value
+	single := 7 * time.Millisecond
+	if p := computePercentiles([]time.Duration{single}); p != (percentiles{single, single, single, single}) {
+		t.Errorf("unexpected percentiles for single value: %#v", p)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	testCases := map[uint64]string{
+		0:       "0.0 B",
+		1023:    "1023.0 B",
+		1024:    "1.0 KiB",
+		1536:    "1.5 KiB",
+		5 << 20: "5.0 MiB",
+		3 << 40: "3072.0 GiB",
+	}
+	for input, expected := range testCases {
+		if actual := formatBytes(input); actual != expected {
+			t.Errorf("formatBytes(%d): expected %q, but got %q", input, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package migratecmd
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/must"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+var dryRun bool
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "migrate",
+		Example: "  keppel server migrate --dry-run",
+		Short:   "Reports on pending database schema migrations and applies them.",
+		Long: `Reports on pending database schema migrations and applies them.
+For each statement in each pending migration, the report shows the affected table, its estimated row count, and how much the statement will interfere with concurrent usage of the table. With --dry-run, only the report is shown. Configuration is read from environment variables as described in the operator guide.`,
+		Args: cobra.NoArgs,
+		Run:  run,
+	}
+	cmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Only report on pending migrations, do not apply them.")
+	parent.AddCommand(cmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	keppel.SetTaskName("migrate")
+
+	// connect without applying migrations (easypg.Connect would apply them right away)
+	dbURL, _ := keppel.GetDatabaseURLFromEnvironment()
+	dbConn := must.Return(sql.Open("postgres", dbURL.String()))
+	version, dirty, err := keppel.GetSchemaVersion(dbConn)
+	must.Succeed(err)
+	pendingMigrations := must.Return(keppel.CheckPendingMigrations(dbConn))
+	must.Succeed(dbConn.Close())
+
+	if dirty {
+		fmt.Printf("Schema version: %d (dirty: the last migration failed halfway and will be retried)\n", version)
+	} else {
+		fmt.Printf("Schema version: %d\n", version)
+	}
+	fmt.Printf("Latest schema version: %d\n", keppel.LatestSchemaVersion())
+	if len(pendingMigrations) == 0 {
+		fmt.Println("No pending migrations.")
+		return
+	}
+
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tTABLE\tEST. ROWS\tLOCK IMPACT\tSTATEMENT")
+	for _, pm := range pendingMigrations {
+		for _, stmt := range pm.Statements {
+			sqlText := stmt.SQL
+			if len(sqlText) > 60 {
+				sqlText = sqlText[:57] + "..."
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\n", pm.Version, pm.Name, stmt.Table, stmt.EstimatedRows, stmt.LockImpact, sqlText)
+		}
+	}
+	must.Succeed(tw.Flush())
+
+	if dryRun {
+		return
+	}
+	fmt.Println()
+	logg.Info("applying %d pending migrations...", len(pendingMigrations))
+	must.Succeed(must.Return(easypg.Connect(dbURL, keppel.DBConfiguration())).Close())
+	logg.Info("all migrations applied successfully")
+}
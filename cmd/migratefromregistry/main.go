@@ -0,0 +1,440 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package migratefromregistrycmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/must"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var (
+	sourceUserName string
+	sourcePassword string
+	targetUserName string
+	targetPassword string
+	repoNames      []string
+	stateFilePath  string
+)
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	migrateCmd := &cobra.Command{
+		Use:   "migrate <subcommand> <args...>",
+		Short: "Client commands for migrating images into Keppel.",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:     "from-registry <source-registry> <target-registry>/<account>",
+		Example: "  keppel migrate from-registry --source-username admin --source-password secret harbor.example.com registry.example.org/myaccount",
+		Short:   "Copies all repositories of another registry into a Keppel account.",
+		Long: `Copies all repositories of another registry into a Keppel account.
+The source registry can be any registry implementing the OCI Distribution API, e.g. Harbor or Docker Registry. Repositories are enumerated using the catalog endpoint of the source registry. For registries that do not offer this endpoint, or to only migrate some repositories, give the repository names with --repo instead.
+If the source registry is in Amazon ECR (i.e. its hostname looks like "<account-id>.dkr.ecr.<region>.amazonaws.com"), repositories are enumerated using the ECR API instead. This requires AWS credentials in the environment variables $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and (for temporary credentials only) $AWS_SESSION_TOKEN. Unless --source-username and --source-password are given, these credentials are also used to obtain credentials for pulling from the source registry.
+Each repository is copied into the Keppel account under the same name. Blob and manifest contents are verified against their digests while copying. Tags that already point to the same digest in the target repository are skipped, so the migration can be repeated to sync new or moved tags. When --state-file is given, progress is recorded in this file to resume interrupted migrations without checking each tag again.
+At the end, a report shows all tags that differ between source and target.`,
+		Args: cobra.ExactArgs(2),
+		Run:  run,
+	}
+	cmd.PersistentFlags().StringVar(&sourceUserName, "source-username", "", "User name for the source registry (only required for non-public images).")
+	cmd.PersistentFlags().StringVar(&sourcePassword, "source-password", "", "Password for the source registry (only required for non-public images).")
+	cmd.PersistentFlags().StringVarP(&targetUserName, "username", "u", "", "User name for the target Keppel.")
+	cmd.PersistentFlags().StringVarP(&targetPassword, "password", "p", "", "Password for the target Keppel.")
+	cmd.PersistentFlags().StringSliceVar(&repoNames, "repo", nil, "Only migrate the given repositories (may be given multiple times). If not given, all repositories in the source registry are migrated.")
+	cmd.PersistentFlags().StringVar(&stateFilePath, "state-file", "", "Record migration progress in this file, and skip tags that have already been migrated according to it.")
+	migrateCmd.AddCommand(cmd)
+	parent.AddCommand(migrateCmd)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// migration state
+
+// Records which tags have been migrated at which digest, by repo name and tag name.
+type migrationState map[string]map[string]digest.Digest
+
+func loadState() (migrationState, error) {
+	state := make(migrationState)
+	if stateFilePath == "" {
+		return state, nil
+	}
+	buf, err := os.ReadFile(stateFilePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &state)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", stateFilePath, err)
+	}
+	return state, nil
+}
+
+func (s migrationState) record(repoName, tagName string, manifestDigest digest.Digest) error {
+	if s[repoName] == nil {
+		s[repoName] = make(map[string]digest.Digest)
+	}
+	s[repoName][tagName] = manifestDigest
+	if stateFilePath == "" {
+		return nil
+	}
+
+	// write atomically, so that an interruption does not leave a broken file behind
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmpPath := stateFilePath + ".tmp"
+	err = os.WriteFile(tmpPath, buf, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, stateFilePath)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// main logic
+
+type migration struct {
+	Source      *client.RepoClient
+	Target      *client.RepoClient
+	TagsCopied  int
+	TagsSkipped int
+	BlobsCopied int
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+	sourceHost := args[0]
+	targetHost, accountName, ok := strings.Cut(args[1], "/")
+	if !ok || accountName == "" || strings.Contains(accountName, "/") {
+		logg.Fatal("target must be given as <target-registry>/<account>, got %q", args[1])
+	}
+
+	state, err := loadState()
+	if err != nil {
+		logg.Fatal("cannot load migration state: %s", err.Error())
+	}
+
+	// for ECR, repositories are enumerated and registry credentials are obtained through the ECR API
+	ecrClient, isECR := client.NewECRClient(sourceHost, client.AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	})
+	if isECR {
+		if ecrClient.Credentials.AccessKeyID == "" || ecrClient.Credentials.SecretAccessKey == "" {
+			logg.Fatal("migrating from ECR requires AWS credentials in $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY")
+		}
+		if sourceUserName == "" && sourcePassword == "" {
+			sourceUserName, sourcePassword, err = ecrClient.GetRegistryCredentials(ctx)
+			if err != nil {
+				logg.Fatal("cannot obtain credentials for %s: %s", sourceHost, err.Error())
+			}
+		}
+	}
+
+	// enumerate repositories
+	switch {
+	case len(repoNames) > 0:
+		// use repo names from --repo
+	case isECR:
+		repoNames, err = ecrClient.ListRepositories(ctx)
+		if err != nil {
+			logg.Fatal("cannot list repositories in %s: %s", sourceHost, err.Error())
+		}
+	default:
+		catalogClient := &client.RepoClient{Host: sourceHost, UserName: sourceUserName, Password: sourcePassword}
+		repoNames, err = catalogClient.ListRepositories(ctx)
+		if err != nil {
+			logg.Fatal("cannot list repositories in %s (use --repo if the source registry does not support listing repositories): %s", sourceHost, err.Error())
+		}
+	}
+	logg.Info("migrating %d repositories from %s into %s", len(repoNames), sourceHost, args[1])
+
+	var (
+		failedRepos []string
+		reports     []repoReport
+	)
+	for _, repoName := range repoNames {
+		m := migration{
+			Source: &client.RepoClient{Host: sourceHost, RepoName: repoName, UserName: sourceUserName, Password: sourcePassword},
+			Target: &client.RepoClient{Host: targetHost, RepoName: accountName + "/" + repoName, UserName: targetUserName, Password: targetPassword},
+		}
+		err := m.migrateRepo(ctx, repoName, state)
+		if err != nil {
+			logg.Error("while migrating repository %s: %s", repoName, err.Error())
+			failedRepos = append(failedRepos, repoName)
+		}
+		logg.Info("repository %s: %d tags copied, %d tags skipped, %d blobs copied", repoName, m.TagsCopied, m.TagsSkipped, m.BlobsCopied)
+
+		report, err := m.compareTags(ctx, repoName)
+		if err != nil {
+			logg.Error("while comparing repository %s: %s", repoName, err.Error())
+			failedRepos = append(failedRepos, repoName)
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	differenceCount := printReport(reports)
+	if len(failedRepos) > 0 {
+		slices.Sort(failedRepos)
+		logg.Fatal("migration failed for the following repositories: %s", strings.Join(slices.Compact(failedRepos), ", "))
+	}
+	if differenceCount > 0 {
+		os.Exit(1)
+	}
+}
+
+func (m *migration) migrateRepo(ctx context.Context, repoName string, state migrationState) error {
+	tagNames, err := m.Source.ListTags(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot list tags: %w", err)
+	}
+
+	for _, tagName := range tagNames {
+		tagRef := models.ManifestReference{Tag: tagName}
+		sourceDigest, err := m.Source.GetManifestDigest(ctx, tagRef)
+		if err != nil {
+			return fmt.Errorf("cannot find digest of tag %s: %w", tagName, err)
+		}
+
+		// skip tags that were already migrated (according to the state file or the target)
+		if state[repoName][tagName] == sourceDigest {
+			m.TagsSkipped++
+			continue
+		}
+		targetDigest, err := m.Target.GetManifestDigest(ctx, tagRef)
+		if err == nil && targetDigest == sourceDigest {
+			m.TagsSkipped++
+			err = state.record(repoName, tagName, sourceDigest)
+			if err != nil {
+				return fmt.Errorf("cannot record migration state: %w", err)
+			}
+			continue
+		}
+
+		// copy the manifest tree, then tag the top-level manifest
+		manifestBytes, mediaType, err := m.copyManifest(ctx, models.ManifestReference{Digest: sourceDigest})
+		if err != nil {
+			return fmt.Errorf("cannot copy tag %s: %w", tagName, err)
+		}
+		_, err = m.Target.UploadManifest(ctx, manifestBytes, mediaType, tagName)
+		if err != nil {
+			return fmt.Errorf("cannot push tag %s: %w", tagName, err)
+		}
+		logg.Info("copied %s:%s (%s)", repoName, tagName, sourceDigest)
+		m.TagsCopied++
+
+		err = state.record(repoName, tagName, sourceDigest)
+		if err != nil {
+			return fmt.Errorf("cannot record migration state: %w", err)
+		}
+	}
+	return nil
+}
+
+// Copies all blobs and submanifests referenced by the given manifest, and the
+// manifest itself (addressed by digest). The manifest contents are returned
+// to allow tagging the manifest afterwards.
+func (m *migration) copyManifest(ctx context.Context, ref models.ManifestReference) ([]byte, string, error) {
+	manifestBytes, mediaType, err := m.Source.DownloadManifest(ctx, ref, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	actualDigest := digest.Canonical.FromBytes(manifestBytes)
+	if actualDigest != ref.Digest {
+		return nil, "", fmt.Errorf("manifest %s has actual digest %s", ref.Digest, actualDigest)
+	}
+	parsed, err := keppel.ParseManifest(mediaType, manifestBytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot parse manifest %s: %w", ref.Digest, err)
+	}
+
+	for _, layerInfo := range parsed.BlobReferences() {
+		err := m.copyBlob(ctx, layerInfo.Digest)
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot copy blob %s: %w", layerInfo.Digest, err)
+		}
+	}
+	for _, desc := range parsed.ManifestReferences(nil) {
+		_, _, err := m.copyManifest(ctx, models.ManifestReference{Digest: desc.Digest})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	_, err = m.Target.UploadManifest(ctx, manifestBytes, mediaType, "")
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot push manifest %s: %w", ref.Digest, err)
+	}
+	return manifestBytes, mediaType, nil
+}
+
+func (m *migration) copyBlob(ctx context.Context, blobDigest digest.Digest) (returnErr error) {
+	exists, err := m.Target.HasBlob(ctx, blobDigest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	// buffer the blob in a temporary file, so that we can verify its digest
+	// before pushing it, and so that the upload can be retried if necessary
+	contents, _, err := m.Source.DownloadBlob(ctx, blobDigest)
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+	tmpFile, err := os.CreateTemp("", "keppel-migrate-blob-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmpFile.Close()
+		err := os.Remove(tmpFile.Name())
+		if err != nil && returnErr == nil {
+			returnErr = err
+		}
+	}()
+
+	hash := blobDigest.Algorithm().Hash()
+	sizeBytes, err := io.Copy(io.MultiWriter(tmpFile, hash), contents)
+	if err != nil {
+		return err
+	}
+	actualDigest := digest.NewDigest(blobDigest.Algorithm(), hash)
+	if actualDigest != blobDigest {
+		return fmt.Errorf("actual digest is %s", actualDigest)
+	}
+	_, err = tmpFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	err = m.Target.UploadMonolithicBlobFromReader(ctx, blobDigest, tmpFile, uint64(sizeBytes)) //nolint:gosec // io.Copy does not return negative sizes
+	if err != nil {
+		return err
+	}
+	m.BlobsCopied++
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// final report
+
+type repoReport struct {
+	RepoName    string
+	Differences []tagDifference
+}
+
+type tagDifference struct {
+	TagName      string
+	SourceDigest digest.Digest // empty if the tag only exists in the target
+	TargetDigest digest.Digest // empty if the tag only exists in the source
+}
+
+func (m *migration) compareTags(ctx context.Context, repoName string) (repoReport, error) {
+	report := repoReport{RepoName: repoName}
+	sourceDigests, err := collectTagDigests(ctx, m.Source)
+	if err != nil {
+		return report, fmt.Errorf("in source: %w", err)
+	}
+	targetDigests, err := collectTagDigests(ctx, m.Target)
+	if err != nil {
+		return report, fmt.Errorf("in target: %w", err)
+	}
+
+	allTagNames := make([]string, 0, len(sourceDigests)+len(targetDigests))
+	for tagName := range sourceDigests {
+		allTagNames = append(allTagNames, tagName)
+	}
+	for tagName := range targetDigests {
+		if _, exists := sourceDigests[tagName]; !exists {
+			allTagNames = append(allTagNames, tagName)
+		}
+	}
+	slices.Sort(allTagNames)
+
+	for _, tagName := range allTagNames {
+		if sourceDigests[tagName] != targetDigests[tagName] {
+			report.Differences = append(report.Differences, tagDifference{
+				TagName:      tagName,
+				SourceDigest: sourceDigests[tagName],
+				TargetDigest: targetDigests[tagName],
+			})
+		}
+	}
+	return report, nil
+}
+
+func collectTagDigests(ctx context.Context, c *client.RepoClient) (map[string]digest.Digest, error) {
+	tagNames, err := c.ListTags(ctx)
+	if err != nil {
+		var rerr *keppel.RegistryV2Error
+		if errors.As(err, &rerr) && rerr.Code == keppel.ErrNameUnknown {
+			// repo does not exist (yet)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	result := make(map[string]digest.Digest, len(tagNames))
+	for _, tagName := range tagNames {
+		result[tagName], err = c.GetManifestDigest(ctx, models.ManifestReference{Tag: tagName})
+		if err != nil {
+			return nil, fmt.Errorf("cannot find digest of tag %s: %w", tagName, err)
+		}
+	}
+	return result, nil
+}
+
+func printReport(reports []repoReport) (differenceCount int) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tTAG\tSOURCE DIGEST\tTARGET DIGEST")
+	for _, report := range reports {
+		for _, diff := range report.Differences {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", report.RepoName, diff.TagName,
+				orMissing(diff.SourceDigest), orMissing(diff.TargetDigest))
+			differenceCount++
+		}
+	}
+	if differenceCount == 0 {
+		fmt.Println("Migration complete: all tags match between source and target.")
+		return 0
+	}
+	fmt.Printf("Migration incomplete: %d tags differ between source and target.\n\n", differenceCount)
+	must.Succeed(tw.Flush())
+	return differenceCount
+}
+
+func orMissing(d digest.Digest) string {
+	if d == "" {
+		return "(missing)"
+	}
+	return d.String()
+}
@@ -0,0 +1,375 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package migratefromregistrycmd
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// fake registry
+
+type fakeManifest struct {
+	Contents  []byte
+	MediaType string
+}
+
+type fakeRepo struct {
+	Manifests map[digest.Digest]fakeManifest
+	Tags      map[string]digest.Digest
+}
+
+// fakeRegistry is a minimal in-memory implementation of the parts of the OCI
+// Distribution API that are used by the migration.
+type fakeRegistry struct {
+	mutex sync.Mutex
+	Repos map[string]*fakeRepo
+	Blobs map[digest.Digest][]byte
+	// if set, requests must carry these credentials via HTTP Basic auth (like in ECR)
+	BasicAuthUser     string
+	BasicAuthPassword string
+	// counts requests by method and endpoint type, e.g. "POST blobs/uploads"
+	RequestCounts map[string]int
+}
+
+var fakeRegistryPathRx = regexp.MustCompile(`^/v2/(.+)/(tags/list|manifests/[^/]+|blobs/uploads/|blobs/[^/]+)$`)
+
+func newFakeRegistry(t *testing.T) (*fakeRegistry, *httptest.Server) {
+	r := &fakeRegistry{
+		Repos:         make(map[string]*fakeRepo),
+		Blobs:         make(map[digest.Digest][]byte),
+		RequestCounts: make(map[string]int),
+	}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.BasicAuthUser != "" {
+		userName, password, ok := req.BasicAuth()
+		if !ok || userName != r.BasicAuthUser || password != r.BasicAuthPassword {
+			w.Header().Set("Www-Authenticate", `Basic realm="fake"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	match := fakeRegistryPathRx.FindStringSubmatch(req.URL.Path)
+	if match == nil {
+		http.NotFound(w, req)
+		return
+	}
+	repoName, endpoint := match[1], match[2]
+	endpointType, ref, _ := strings.Cut(endpoint, "/")
+	if endpoint == "blobs/uploads/" {
+		ref = ""
+		endpointType = "blobs/uploads"
+	}
+	r.RequestCounts[req.Method+" "+endpointType]++
+
+	repo := r.Repos[repoName]
+	if repo == nil {
+		if req.Method != http.MethodPut && req.Method != http.MethodPost {
+			keppel.ErrNameUnknown.With("repository name not known to registry").WriteAsRegistryV2ResponseTo(w, req)
+			return
+		}
+		repo = &fakeRepo{Manifests: make(map[digest.Digest]fakeManifest), Tags: make(map[string]digest.Digest)}
+		r.Repos[repoName] = repo
+	}
+
+	switch {
+	case endpointType == "tags" && req.Method == http.MethodGet:
+		tagNames := slices.Sorted(maps.Keys(repo.Tags))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"name": repoName, "tags": tagNames}) //nolint:errcheck
+
+	case endpointType == "manifests" && (req.Method == http.MethodGet || req.Method == http.MethodHead):
+		manifestDigest, ok := repo.Tags[ref]
+		if !ok {
+			manifestDigest = digest.Digest(ref)
+		}
+		m, ok := repo.Manifests[manifestDigest]
+		if !ok {
+			keppel.ErrManifestUnknown.With("").WriteAsRegistryV2ResponseTo(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", m.MediaType)
+		w.Header().Set("Docker-Content-Digest", manifestDigest.String())
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			w.Write(m.Contents) //nolint:errcheck
+		}
+
+	case endpointType == "manifests" && req.Method == http.MethodPut:
+		contents, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		manifestDigest := digest.Canonical.FromBytes(contents)
+		repo.Manifests[manifestDigest] = fakeManifest{contents, req.Header.Get("Content-Type")}
+		if ref != manifestDigest.String() {
+			repo.Tags[ref] = manifestDigest
+		}
+		w.Header().Set("Docker-Content-Digest", manifestDigest.String())
+		w.WriteHeader(http.StatusCreated)
+
+	case endpointType == "blobs" && (req.Method == http.MethodGet || req.Method == http.MethodHead):
+		contents, ok := r.Blobs[digest.Digest(ref)]
+		if !ok {
+			keppel.ErrBlobUnknown.With("").WriteAsRegistryV2ResponseTo(w, req)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			w.Write(contents) //nolint:errcheck
+		}
+
+	case endpointType == "blobs/uploads" && req.Method == http.MethodPost:
+		contents, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		blobDigest := digest.Digest(req.URL.Query().Get("digest"))
+		if digest.Canonical.FromBytes(contents) != blobDigest {
+			keppel.ErrDigestInvalid.With("").WriteAsRegistryV2ResponseTo(w, req)
+			return
+		}
+		r.Blobs[blobDigest] = contents
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *fakeRegistry) addImage(repoName, tagName string, img test.Image) {
+	for _, layer := range img.Layers {
+		r.Blobs[layer.Digest] = layer.Contents
+	}
+	r.Blobs[img.Config.Digest] = img.Config.Contents
+	r.addManifest(repoName, tagName, img.Manifest)
+}
+
+func (r *fakeRegistry) addManifest(repoName, tagName string, manifest test.Bytes) {
+	repo := r.Repos[repoName]
+	if repo == nil {
+		repo = &fakeRepo{Manifests: make(map[digest.Digest]fakeManifest), Tags: make(map[string]digest.Digest)}
+		r.Repos[repoName] = repo
+	}
+	repo.Manifests[manifest.Digest] = fakeManifest{manifest.Contents, manifest.MediaType}
+	if tagName != "" {
+		repo.Tags[tagName] = manifest.Digest
+	}
+}
+
+func newTestMigration(sourceServer, targetServer *httptest.Server, repoName string) *migration {
+	return &migration{
+		Source: &client.RepoClient{
+			Scheme:   "http",
+			Host:     strings.TrimPrefix(sourceServer.URL, "http://"),
+			RepoName: repoName,
+			UserName: "AWS",
+			Password: "secret",
+		},
+		Target: &client.RepoClient{
+			Scheme:   "http",
+			Host:     strings.TrimPrefix(targetServer.URL, "http://"),
+			RepoName: "test1/" + repoName,
+		},
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// tests
+
+func TestMigrateRepo(t *testing.T) {
+	source, sourceServer := newFakeRegistry(t)
+	source.BasicAuthUser = "AWS"
+	source.BasicAuthPassword = "secret"
+	target, targetServer := newFakeRegistry(t)
+
+	image1 := test.GenerateImage(test.GenerateExampleLayer(1), test.GenerateExampleLayer(2))
+	image2 := test.GenerateImage(test.GenerateExampleLayer(2), test.GenerateExampleLayer(3))
+	imageList := test.GenerateImageList(image1, image2)
+	source.addImage("foo", "first", image1)
+	source.addImage("foo", "", image2)
+	source.addManifest("foo", "list", imageList.Manifest)
+
+	stateFilePath = filepath.Join(t.TempDir(), "state.json")
+	t.Cleanup(func() { stateFilePath = "" })
+	state, err := loadState()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// first run copies all tags, including the submanifests of the image list,
+	// and does not upload shared blobs twice
+	m := newTestMigration(sourceServer, targetServer, "foo")
+	err = m.migrateRepo(t.Context(), "foo", state)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "TagsCopied", m.TagsCopied, 2)
+	assert.DeepEqual(t, "TagsSkipped", m.TagsSkipped, 0)
+	assert.DeepEqual(t, "BlobsCopied", m.BlobsCopied, 5)
+	assert.DeepEqual(t, "target blobs", slices.Sorted(maps.Keys(target.Blobs)), slices.Sorted(maps.Keys(source.Blobs)))
+	assert.DeepEqual(t, "target manifests", slices.Sorted(maps.Keys(target.Repos["test1/foo"].Manifests)),
+		slices.Sorted(maps.Keys(source.Repos["foo"].Manifests)))
+	assert.DeepEqual(t, "target tags", target.Repos["test1/foo"].Tags, source.Repos["foo"].Tags)
+
+	// progress is recorded in the state file
+	buf, err := os.ReadFile(stateFilePath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "state file", string(buf),
+		`{"foo":{"first":"`+image1.Manifest.Digest.String()+`","list":"`+imageList.Manifest.Digest.String()+`"}}`)
+
+	// second run (with the state loaded from the file) does not copy anything
+	state, err = loadState()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	target.RequestCounts = make(map[string]int)
+	m = newTestMigration(sourceServer, targetServer, "foo")
+	err = m.migrateRepo(t.Context(), "foo", state)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "TagsCopied", m.TagsCopied, 0)
+	assert.DeepEqual(t, "TagsSkipped", m.TagsSkipped, 2)
+	assert.DeepEqual(t, "target requests", target.RequestCounts, map[string]int{})
+
+	// without a state file, tags that match the target are skipped as well
+	stateFilePath = ""
+	m = newTestMigration(sourceServer, targetServer, "foo")
+	err = m.migrateRepo(t.Context(), "foo", make(migrationState))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "TagsCopied", m.TagsCopied, 0)
+	assert.DeepEqual(t, "TagsSkipped", m.TagsSkipped, 2)
+
+	// when a tag moves in the source, only that tag is copied again
+	source.addManifest("foo", "first", image2.Manifest)
+	m = newTestMigration(sourceServer, targetServer, "foo")
+	err = m.migrateRepo(t.Context(), "foo", state)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "TagsCopied", m.TagsCopied, 1)
+	assert.DeepEqual(t, "TagsSkipped", m.TagsSkipped, 1)
+	assert.DeepEqual(t, "BlobsCopied", m.BlobsCopied, 0)
+	assert.DeepEqual(t, "target tags", target.Repos["test1/foo"].Tags, source.Repos["foo"].Tags)
+}
+
+func TestMigrateRepoVerifiesDigests(t *testing.T) {
+	source, sourceServer := newFakeRegistry(t)
+	_, targetServer := newFakeRegistry(t)
+
+	// corrupt a layer in the source registry
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	source.addImage("foo", "latest", image)
+	source.Blobs[image.Layers[0].Digest] = []byte("corrupted")
+
+	m := newTestMigration(sourceServer, targetServer, "foo")
+	err := m.migrateRepo(t.Context(), "foo", make(migrationState))
+	expectedError := "cannot copy tag latest: cannot copy blob " + image.Layers[0].Digest.String() +
+		": actual digest is " + digest.Canonical.FromBytes([]byte("corrupted")).String()
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("expected error %q, but got %v", expectedError, err)
+	}
+	assert.DeepEqual(t, "TagsCopied", m.TagsCopied, 0)
+}
+
+func TestCompareTags(t *testing.T) {
+	source, sourceServer := newFakeRegistry(t)
+	target, targetServer := newFakeRegistry(t)
+
+	image1 := test.GenerateImage(test.GenerateExampleLayer(1))
+	image2 := test.GenerateImage(test.GenerateExampleLayer(2))
+
+	// when the target repo does not exist yet, all source tags are missing there
+	source.addImage("foo", "same", image1)
+	source.addImage("foo", "moved", image1)
+	source.addImage("foo", "only-source", image2)
+	m := newTestMigration(sourceServer, targetServer, "foo")
+	report, err := m.compareTags(t.Context(), "foo")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "report", report, repoReport{
+		RepoName: "foo",
+		Differences: []tagDifference{
+			{TagName: "moved", SourceDigest: image1.Manifest.Digest},
+			{TagName: "only-source", SourceDigest: image2.Manifest.Digest},
+			{TagName: "same", SourceDigest: image1.Manifest.Digest},
+		},
+	})
+
+	// differences in both directions are reported, sorted by tag name
+	target.addImage("test1/foo", "same", image1)
+	target.addImage("test1/foo", "moved", image2)
+	target.addImage("test1/foo", "only-target", image2)
+	report, err = m.compareTags(t.Context(), "foo")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "report", report, repoReport{
+		RepoName: "foo",
+		Differences: []tagDifference{
+			{TagName: "moved", SourceDigest: image1.Manifest.Digest, TargetDigest: image2.Manifest.Digest},
+			{TagName: "only-source", SourceDigest: image2.Manifest.Digest},
+			{TagName: "only-target", TargetDigest: image2.Manifest.Digest},
+		},
+	})
+}
+
+func TestLoadState(t *testing.T) {
+	t.Cleanup(func() { stateFilePath = "" })
+
+	// a missing state file is treated as empty
+	stateFilePath = filepath.Join(t.TempDir(), "state.json")
+	state, err := loadState()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "state", state, make(migrationState))
+
+	// a malformed state file is rejected
+	err = os.WriteFile(stateFilePath, []byte("{"), 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = loadState()
+	if err == nil || !strings.HasPrefix(err.Error(), "cannot parse "+stateFilePath+": ") {
+		t.Errorf("expected parse error, but got %v", err)
+	}
+}
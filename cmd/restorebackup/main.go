@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package restorebackupcmd
+
+import (
+	"time"
+
+	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/httpext"
+	"github.com/sapcc/go-bits/must"
+	"github.com/sapcc/go-bits/osext"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/tasks"
+)
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "restore-backup [<snapshot-name>]",
+		Example: "  keppel server restore-backup snapshot-20250102T030405Z.json.gz",
+		Short:   "Restores a backup written by keppel-janitor into an empty database and storage.",
+		Long: `Restores a backup written by keppel-janitor into an empty database and storage.
+If no snapshot name is given, the latest snapshot is restored. Configuration is read from environment variables as described in the operator guide.`,
+		Args: cobra.MaximumNArgs(1),
+		Run:  run,
+	}
+	parent.AddCommand(cmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	keppel.SetTaskName("restore-backup")
+
+	cfg := keppel.ParseConfiguration()
+	ctx := httpext.ContextWithSIGINT(cmd.Context(), 10*time.Second)
+
+	dbURL, _ := keppel.GetDatabaseURLFromEnvironment()
+	dbConn := must.Return(easypg.Connect(dbURL, keppel.DBConfiguration()))
+	db := keppel.InitORM(dbConn)
+
+	ad := must.Return(keppel.NewAuthDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_AUTH"), nil))
+	sd := must.Return(keppel.NewStorageDriver(osext.MustGetenv("KEPPEL_DRIVER_STORAGE"), ad, cfg))
+	bd := must.Return(keppel.NewBackupDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_BACKUP"), cfg))
+	secd := must.Return(keppel.NewSecretsDriver(ctx, osext.GetenvOrDefault("KEPPEL_DRIVER_SECRETS", "trivial"), cfg))
+
+	snapshotName := ""
+	if len(args) > 0 {
+		snapshotName = args[0]
+	}
+	must.Succeed(tasks.RestoreBackup(ctx, db, sd, bd, secd, snapshotName))
+}
@@ -0,0 +1,452 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package selftestcmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/errext"
+	"github.com/sapcc/go-bits/httpext"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var longDesc = strings.TrimSpace(`
+Runs a battery of push, pull and delete operations with generated test images
+against a Keppel account, and reports for each capability whether it works. This
+is intended for smoke validation by operators after a deployment.
+
+All test images are pushed into a single repository in the given account (see
+--repository). Unless --keep is given, the pushed manifests are deleted again at
+the end of the test, even if some checks failed or the test was interrupted.
+Blobs are not deleted explicitly since Keppel's janitor garbage-collects them
+once they are not referenced by any manifest anymore.
+
+If any check fails, the command exits with non-zero status. Checks whose
+prerequisites have failed are reported as skipped.
+`)
+
+var (
+	authUserName string
+	authPassword string
+	repoName     string
+	keepImages   bool
+)
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "selftest <account>",
+		Example: "  keppel selftest registry.example.org/selftest --username alice --password secret",
+		Short:   "Checks push/pull/delete capabilities of a Keppel account with generated test images.",
+		Long:    longDesc,
+		Args:    cobra.ExactArgs(1),
+		Run:     run,
+	}
+	cmd.PersistentFlags().StringVarP(&authUserName, "username", "u", "", "User name (must have pull, push and delete access to the account).")
+	cmd.PersistentFlags().StringVarP(&authPassword, "password", "p", "", "Password (must have pull, push and delete access to the account).")
+	cmd.PersistentFlags().StringVar(&repoName, "repository", "keppel-selftest", "Name of the repository within the account that test images are pushed into.")
+	cmd.PersistentFlags().BoolVar(&keepImages, "keep", false, "Do not delete the test images at the end of the test. The checks for manifest deletion are skipped.")
+	parent.AddCommand(cmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	host, accountName, ok := strings.Cut(args[0], "/")
+	if !ok || host == "" || accountName == "" || strings.Contains(accountName, "/") {
+		logg.Fatal("expected account reference of the form <host>/<account>, but got %q", args[0])
+	}
+	if repoName == "" {
+		logg.Fatal("--repository may not be empty")
+	}
+
+	st := &selfTest{
+		Client: &client.RepoClient{
+			Host:     host,
+			RepoName: accountName + "/" + repoName,
+			UserName: authUserName,
+			Password: authPassword,
+		},
+		TagName: "selftest-" + hex.EncodeToString(randomBytes(4)),
+	}
+	logg.Info("running self-test against %s/%s with tag %s", st.Client.Host, st.Client.RepoName, st.TagName)
+
+	ctx := httpext.ContextWithSIGINT(cmd.Context(), 1*time.Second)
+	results := st.Run(ctx)
+	printReport(os.Stdout, results)
+
+	for _, result := range results {
+		if result.Status == statusFailed {
+			os.Exit(1)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// checks
+
+type checkStatus string
+
+const (
+	statusPassed  checkStatus = "PASS"
+	statusFailed  checkStatus = "FAIL"
+	statusSkipped checkStatus = "SKIP"
+)
+
+type check struct {
+	Capability string
+	// If any of these checks did not pass, this check is skipped.
+	DependsOn []string
+	Run       func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Capability string
+	Status     checkStatus
+	Duration   time.Duration
+	Message    string
+}
+
+type selfTest struct {
+	Client  *client.RepoClient
+	TagName string
+
+	// filled while the checks run
+	layerContents  []byte
+	layerDigest    digest.Digest
+	configContents []byte
+	configDigest   digest.Digest
+	manifest       []byte
+	manifestDigest digest.Digest
+	index          []byte
+	indexDigest    digest.Digest
+	// manifests that were pushed and not deleted yet, in order of pushing
+	pushedManifests []digest.Digest
+}
+
+func (st *selfTest) checks() []check {
+	checks := []check{
+		{"push blob", nil, st.checkPushBlob},
+		{"check blob existence", []string{"push blob"}, st.checkBlobExistence},
+		{"pull blob", []string{"push blob"}, st.checkPullBlob},
+		{"push manifest by tag", []string{"push blob"}, st.checkPushManifestByTag},
+		{"pull manifest by tag", []string{"push manifest by tag"}, st.checkPullManifestByTag},
+		{"pull manifest by digest", []string{"push manifest by tag"}, st.checkPullManifestByDigest},
+		{"resolve tag to digest", []string{"push manifest by tag"}, st.checkResolveTag},
+		{"list tags", []string{"push manifest by tag"}, st.checkListTags},
+		{"push image index by digest", []string{"push manifest by tag"}, st.checkPushIndex},
+		{"pull image index by digest", []string{"push image index by digest"}, st.checkPullIndex},
+	}
+	if !keepImages {
+		checks = append(checks,
+			check{"delete image index", []string{"push image index by digest"}, st.checkDeleteIndex},
+			check{"delete manifest", []string{"push manifest by tag", "delete image index"}, st.checkDeleteManifest},
+		)
+	}
+	return checks
+}
+
+// Run executes all checks in order and returns their results.
+func (st *selfTest) Run(ctx context.Context) []checkResult {
+	var (
+		results  []checkResult
+		isPassed = make(map[string]bool)
+	)
+	for _, c := range st.checks() {
+		result := checkResult{Capability: c.Capability, Status: statusPassed}
+
+		var failedDeps []string
+		for _, dep := range c.DependsOn {
+			if !isPassed[dep] {
+				failedDeps = append(failedDeps, dep)
+			}
+		}
+		if len(failedDeps) > 0 {
+			result.Status = statusSkipped
+			result.Message = "prerequisite did not pass: " + strings.Join(failedDeps, ", ")
+		} else {
+			startedAt := time.Now()
+			err := c.Run(ctx)
+			result.Duration = time.Since(startedAt)
+			if err != nil {
+				logg.Error("check %q failed: %s", c.Capability, err.Error())
+				result.Status = statusFailed
+				result.Message = err.Error()
+			}
+		}
+
+		isPassed[c.Capability] = result.Status == statusPassed
+		results = append(results, result)
+	}
+
+	if !keepImages && len(st.pushedManifests) > 0 {
+		results = append(results, st.cleanup(ctx))
+	}
+	return results
+}
+
+// Deletes all manifests that were pushed by the checks, but not deleted by
+// them (e.g. because a check failed). This uses its own context, so that it
+// also runs when the checks were interrupted.
+func (st *selfTest) cleanup(ctx context.Context) checkResult {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	startedAt := time.Now()
+	result := checkResult{Capability: "clean up leftover test images", Status: statusPassed}
+
+	// the image index references the manifest, so delete in reverse order of pushing
+	var errs errext.ErrorSet
+	for _, manifestDigest := range slices.Backward(slices.Clone(st.pushedManifests)) {
+		err := st.deleteManifest(ctx, manifestDigest)
+		var rerr *keppel.RegistryV2Error
+		if err != nil && !(errors.As(err, &rerr) && rerr.Code == keppel.ErrManifestUnknown) {
+			errs.Addf("cannot delete manifest %s: %w", manifestDigest, err)
+		}
+	}
+	result.Duration = time.Since(startedAt)
+
+	if !errs.IsEmpty() {
+		logg.Error("check %q failed: %s", result.Capability, errs.Join(", "))
+		result.Status = statusFailed
+		result.Message = errs.Join(", ")
+	}
+	return result
+}
+
+func (st *selfTest) checkPushBlob(ctx context.Context) (err error) {
+	st.layerContents = randomBytes(1 << 20)
+	st.layerDigest, err = st.Client.UploadMonolithicBlob(ctx, st.layerContents)
+	if err != nil {
+		return fmt.Errorf("while uploading layer: %w", err)
+	}
+
+	created := time.Now()
+	st.configContents, err = json.Marshal(imgspecv1.Image{
+		Created: &created,
+		Platform: imgspecv1.Platform{
+			Architecture: "amd64",
+			OS:           "linux",
+		},
+		RootFS: imgspecv1.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{st.layerDigest},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	st.configDigest, err = st.Client.UploadMonolithicBlob(ctx, st.configContents)
+	if err != nil {
+		return fmt.Errorf("while uploading image config: %w", err)
+	}
+	return nil
+}
+
+func (st *selfTest) checkBlobExistence(ctx context.Context) error {
+	exists, err := st.Client.HasBlob(ctx, st.layerDigest)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("blob %s was reported as not existing", st.layerDigest)
+	}
+	return nil
+}
+
+func (st *selfTest) checkPullBlob(ctx context.Context) error {
+	contents, _, err := st.Client.DownloadBlob(ctx, st.layerDigest)
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+	buf, err := io.ReadAll(contents)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(buf, st.layerContents) {
+		return fmt.Errorf("expected blob contents with digest %s, but got contents with digest %s",
+			st.layerDigest, digest.Canonical.FromBytes(buf))
+	}
+	return nil
+}
+
+func (st *selfTest) checkPushManifestByTag(ctx context.Context) (err error) {
+	st.manifest, err = json.Marshal(imgspecv1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config: imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageConfig,
+			Digest:    st.configDigest,
+			Size:      int64(len(st.configContents)),
+		},
+		Layers: []imgspecv1.Descriptor{{
+			MediaType: imgspecv1.MediaTypeImageLayer,
+			Digest:    st.layerDigest,
+			Size:      int64(len(st.layerContents)),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	st.manifestDigest, err = st.Client.UploadManifest(ctx, st.manifest, imgspecv1.MediaTypeImageManifest, st.TagName)
+	if err != nil {
+		return err
+	}
+	st.pushedManifests = append(st.pushedManifests, st.manifestDigest)
+	return nil
+}
+
+func (st *selfTest) checkPullManifestByTag(ctx context.Context) error {
+	return st.expectManifest(ctx, models.ManifestReference{Tag: st.TagName}, st.manifest, imgspecv1.MediaTypeImageManifest)
+}
+
+func (st *selfTest) checkPullManifestByDigest(ctx context.Context) error {
+	return st.expectManifest(ctx, models.ManifestReference{Digest: st.manifestDigest}, st.manifest, imgspecv1.MediaTypeImageManifest)
+}
+
+func (st *selfTest) checkResolveTag(ctx context.Context) error {
+	d, err := st.Client.GetManifestDigest(ctx, models.ManifestReference{Tag: st.TagName})
+	if err != nil {
+		return err
+	}
+	if d != st.manifestDigest {
+		return fmt.Errorf("expected tag %s to resolve to %s, but got %s", st.TagName, st.manifestDigest, d)
+	}
+	return nil
+}
+
+func (st *selfTest) checkListTags(ctx context.Context) error {
+	tags, err := st.Client.ListTags(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(tags, st.TagName) {
+		return fmt.Errorf("expected tag %s to be listed, but got %d other tags", st.TagName, len(tags))
+	}
+	return nil
+}
+
+func (st *selfTest) checkPushIndex(ctx context.Context) (err error) {
+	st.index, err = json.Marshal(imgspecv1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: []imgspecv1.Descriptor{{
+			MediaType: imgspecv1.MediaTypeImageManifest,
+			Digest:    st.manifestDigest,
+			Size:      int64(len(st.manifest)),
+			Platform: &imgspecv1.Platform{
+				Architecture: "amd64",
+				OS:           "linux",
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	st.indexDigest, err = st.Client.UploadManifest(ctx, st.index, imgspecv1.MediaTypeImageIndex, "")
+	if err != nil {
+		return err
+	}
+	st.pushedManifests = append(st.pushedManifests, st.indexDigest)
+	return nil
+}
+
+func (st *selfTest) checkPullIndex(ctx context.Context) error {
+	return st.expectManifest(ctx, models.ManifestReference{Digest: st.indexDigest}, st.index, imgspecv1.MediaTypeImageIndex)
+}
+
+func (st *selfTest) checkDeleteIndex(ctx context.Context) error {
+	return st.expectDeletion(ctx, st.indexDigest)
+}
+
+func (st *selfTest) checkDeleteManifest(ctx context.Context) error {
+	return st.expectDeletion(ctx, st.manifestDigest)
+}
+
+func (st *selfTest) expectManifest(ctx context.Context, ref models.ManifestReference, expectedContents []byte, expectedMediaType string) error {
+	contents, mediaType, err := st.Client.DownloadManifest(ctx, ref, &client.DownloadManifestOpts{DoNotCountTowardsLastPulled: true})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(contents, expectedContents) {
+		return fmt.Errorf("expected manifest with digest %s, but got manifest with digest %s",
+			digest.Canonical.FromBytes(expectedContents), digest.Canonical.FromBytes(contents))
+	}
+	if mediaType != expectedMediaType {
+		return fmt.Errorf("expected media type %q, but got %q", expectedMediaType, mediaType)
+	}
+	return nil
+}
+
+// Deletes the given manifest and checks that it cannot be pulled anymore afterwards.
+func (st *selfTest) expectDeletion(ctx context.Context, manifestDigest digest.Digest) error {
+	err := st.deleteManifest(ctx, manifestDigest)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = st.Client.DownloadManifest(ctx, models.ManifestReference{Digest: manifestDigest}, &client.DownloadManifestOpts{DoNotCountTowardsLastPulled: true})
+	var rerr *keppel.RegistryV2Error
+	switch {
+	case err == nil:
+		return fmt.Errorf("manifest %s can still be pulled after deletion", manifestDigest)
+	case errors.As(err, &rerr) && rerr.Code == keppel.ErrManifestUnknown:
+		return nil
+	default:
+		return fmt.Errorf("expected %s when pulling deleted manifest %s, but got: %w", keppel.ErrManifestUnknown, manifestDigest, err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// helper functions
+
+func (st *selfTest) deleteManifest(ctx context.Context, manifestDigest digest.Digest) error {
+	err := st.Client.DeleteManifest(ctx, manifestDigest)
+	if err != nil {
+		return err
+	}
+	st.pushedManifests = slices.DeleteFunc(st.pushedManifests, func(d digest.Digest) bool { return d == manifestDigest })
+	return nil
+}
+
+func randomBytes(count int) []byte {
+	buf := make([]byte, count)
+	_, err := rand.Read(buf)
+	if err != nil {
+		logg.Fatal("cannot generate random bytes: %s", err.Error())
+	}
+	return buf
+}
+
+func printReport(w io.Writer, results []checkResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CAPABILITY\tRESULT\tDURATION\tMESSAGE")
+	for _, result := range results {
+		duration := "-"
+		if result.Status != statusSkipped {
+			duration = result.Duration.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Capability, result.Status, duration, result.Message)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,226 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package selftestcmd
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/easypg"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestMain(m *testing.M) {
+	easypg.WithTestDB(m, func() int { return m.Run() })
+}
+
+// Runs the self-test against a test registry. If `middleware` is not nil, it
+// is wrapped around the registry API to inject failures.
+func runSelfTest(t *testing.T, ctx context.Context, middleware func(http.Handler) http.Handler) (test.Setup, []checkResult) {
+	t.Helper()
+	var (
+		s       test.Setup
+		results []checkResult
+	)
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		s = test.NewSetup(t,
+			test.WithAccount(models.Account{Name: "test1", AuthTenantID: "test1authtenant"}),
+			test.WithQuotas,
+		)
+		s.AD.ExpectedUserName = "correctusername"
+		s.AD.ExpectedPassword = "correctpassword"
+		s.AD.GrantedPermissions = "view:test1authtenant,pull:test1authtenant,push:test1authtenant,delete:test1authtenant"
+		if middleware != nil {
+			tt.Handlers[s.Config.APIPublicHostname] = middleware(s.Handler)
+		}
+
+		st := &selfTest{
+			Client: &client.RepoClient{
+				Host:     s.Config.APIPublicHostname,
+				RepoName: "test1/keppel-selftest",
+				UserName: "correctusername",
+				Password: "correctpassword",
+			},
+			TagName: "selftest-12345678",
+		}
+		results = st.Run(ctx)
+	})
+	return s, results
+}
+
+func expectStatuses(t *testing.T, results []checkResult, expected map[string]checkStatus) {
+	t.Helper()
+	actual := make(map[string]checkStatus, len(results))
+	for _, result := range results {
+		actual[result.Capability] = result.Status
+	}
+	assert.DeepEqual(t, "check results", actual, expected)
+}
+
+func expectManifestCount(t *testing.T, s test.Setup, expectedManifests, expectedTags int) {
+	t.Helper()
+	manifestCount, err := s.DB.SelectInt(`SELECT COUNT(*) FROM manifests`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tagCount, err := s.DB.SelectInt(`SELECT COUNT(*) FROM tags`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "manifest count", int(manifestCount), expectedManifests)
+	assert.DeepEqual(t, "tag count", int(tagCount), expectedTags)
+}
+
+var allChecksPassed = map[string]checkStatus{
+	"push blob":                  statusPassed,
+	"check blob existence":       statusPassed,
+	"pull blob":                  statusPassed,
+	"push manifest by tag":       statusPassed,
+	"pull manifest by tag":       statusPassed,
+	"pull manifest by digest":    statusPassed,
+	"resolve tag to digest":      statusPassed,
+	"list tags":                  statusPassed,
+	"push image index by digest": statusPassed,
+	"pull image index by digest": statusPassed,
+	"delete image index":         statusPassed,
+	"delete manifest":            statusPassed,
+}
+
+func withChanges(base map[string]checkStatus, changes map[string]checkStatus) map[string]checkStatus {
+	result := maps.Clone(base)
+	for k, v := range changes {
+		if v == "" {
+			delete(result, k)
+		} else {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+func TestSelfTestSuccess(t *testing.T) {
+	s, results := runSelfTest(t, t.Context(), nil)
+	expectStatuses(t, results, allChecksPassed)
+
+	// all test images were deleted by the checks themselves
+	expectManifestCount(t, s, 0, 0)
+}
+
+func TestSelfTestKeepImages(t *testing.T) {
+	keepImages = true
+	t.Cleanup(func() { keepImages = false })
+
+	s, results := runSelfTest(t, t.Context(), nil)
+	expectStatuses(t, results, withChanges(allChecksPassed, map[string]checkStatus{
+		"delete image index": "",
+		"delete manifest":    "",
+	}))
+	expectManifestCount(t, s, 2, 1)
+}
+
+// failFirstDelete makes the first DELETE request fail with a server error.
+func failFirstDelete(inner http.Handler) http.Handler {
+	failed := false
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete && !failed {
+			failed = true
+			http.Error(w, "simulated failure", http.StatusInternalServerError)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+func TestSelfTestCleanupAfterFailedCheck(t *testing.T) {
+	s, results := runSelfTest(t, t.Context(), failFirstDelete)
+
+	// the leftover manifests are deleted during cleanup
+	expectStatuses(t, results, withChanges(allChecksPassed, map[string]checkStatus{
+		"delete image index":            statusFailed,
+		"delete manifest":               statusSkipped,
+		"clean up leftover test images": statusPassed,
+	}))
+	expectManifestCount(t, s, 0, 0)
+}
+
+func TestSelfTestCleanupAfterInterrupt(t *testing.T) {
+	// simulate a SIGINT right after the image index was pushed
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	cancelAfterIndexPush := func(inner http.Handler) http.Handler {
+		indexPushed := false
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if indexPushed && ctx.Err() == nil {
+				cancel()
+				http.Error(w, "interrupted", http.StatusServiceUnavailable)
+				return
+			}
+			inner.ServeHTTP(w, r)
+			if r.Method == http.MethodPut && r.Header.Get("Content-Type") == imgspecv1.MediaTypeImageIndex {
+				indexPushed = true
+			}
+		})
+	}
+
+	// the remaining checks fail, but cleanup still runs to completion
+	s, results := runSelfTest(t, ctx, cancelAfterIndexPush)
+	expectStatuses(t, results, withChanges(allChecksPassed, map[string]checkStatus{
+		"pull image index by digest":    statusFailed,
+		"delete image index":            statusFailed,
+		"delete manifest":               statusSkipped,
+		"clean up leftover test images": statusPassed,
+	}))
+	expectManifestCount(t, s, 0, 0)
+}
+
+func TestSelfTestCleanupFailure(t *testing.T) {
+	denyDelete := func(inner http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				http.Error(w, "simulated failure", http.StatusInternalServerError)
+				return
+			}
+			inner.ServeHTTP(w, r)
+		})
+	}
+
+	s, results := runSelfTest(t, t.Context(), denyDelete)
+	expectStatuses(t, results, withChanges(allChecksPassed, map[string]checkStatus{
+		"delete image index":            statusFailed,
+		"delete manifest":               statusSkipped,
+		"clean up leftover test images": statusFailed,
+	}))
+	expectManifestCount(t, s, 2, 1)
+
+	// the report names the manifests that were left behind
+	cleanupResult := results[len(results)-1]
+	if strings.Count(cleanupResult.Message, "cannot delete manifest sha256:") != 2 {
+		t.Errorf("unexpected message for cleanup: %q", cleanupResult.Message)
+	}
+}
+
+func TestPrintReport(t *testing.T) {
+	var buf strings.Builder
+	printReport(&buf, []checkResult{
+		{Capability: "push blob", Status: statusPassed, Duration: 1234567},
+		{Capability: "pull blob", Status: statusFailed, Duration: 2000000, Message: "boom"},
+		{Capability: "push manifest by tag", Status: statusSkipped, Message: "prerequisite did not pass: push blob"},
+	})
+	expected := strings.Join([]string{
+		"CAPABILITY            RESULT  DURATION  MESSAGE",
+		"push blob             PASS    1ms       ",
+		"pull blob             FAIL    2ms       boom",
+		"push manifest by tag  SKIP    -         prerequisite did not pass: push blob",
+		"",
+	}, "\n")
+	assert.DeepEqual(t, "report", buf.String(), expected)
+}
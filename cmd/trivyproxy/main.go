@@ -6,6 +6,7 @@ package trivyproxycmd
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -104,12 +105,30 @@ func (a *API) proxyToTrivy(w http.ResponseWriter, r *http.Request) {
 		format = "json"
 	}
 
+	timeout := trivy.DefaultScanTimeout
+	if timeoutStr := query.Get("timeout"); timeoutStr != "" {
+		var err error
+		timeout, err = time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 {
+			http.Error(w, "timeout query string must be a positive duration", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
 	keppelToken := r.Header.Get(trivy.KeppelTokenHeader)
 
-	stdout, stderr, err := a.runTrivy(r.Context(), imageURL, format, keppelToken)
+	stdout, stderr, err := a.runTrivy(r.Context(), imageURL, format, keppelToken, timeout)
 	if err != nil {
 		cleanedErr := strings.ReplaceAll(strings.TrimSpace(string(stderr)), "\n", " ")
-		http.Error(w, fmt.Sprintf("trivy: %s: %s", err, cleanedErr), http.StatusInternalServerError)
+		// timeouts are reported with a distinct status code and a header that
+		// intermediaries will not set, so that Keppel can tell timeouts apart
+		// from other scan errors
+		status := http.StatusInternalServerError
+		if errors.Is(err, context.DeadlineExceeded) {
+			w.Header().Set(trivy.ScanTimedOutHeader, "true")
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, fmt.Sprintf("trivy: %s: %s", err, cleanedErr), status)
 		return
 	}
 
@@ -118,7 +137,12 @@ func (a *API) proxyToTrivy(w http.ResponseWriter, r *http.Request) {
 	w.Write(stdout)
 }
 
-func (a *API) runTrivy(ctx context.Context, imageURL, format, keppelToken string) (stdout, stderr []byte, err error) {
+func (a *API) runTrivy(ctx context.Context, imageURL, format, keppelToken string, timeout time.Duration) (stdout, stderr []byte, err error) {
+	// we enforce the timeout ourselves to know for sure when it was exceeded
+	// (Trivy's own timeout starts a bit later, so it will not fire first)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	//nolint:gosec // intended behaviour
 	cmd := exec.CommandContext(ctx,
 		"trivy", "image",
@@ -130,7 +154,7 @@ func (a *API) runTrivy(ctx context.Context, imageURL, format, keppelToken string
 		"--registry-token", keppelToken,
 		"--format", format,
 		"--token", a.token,
-		"--timeout", "10m", // default is 5m
+		"--timeout", timeout.String(),
 		"--image-src", "remote", // don't try to use a container runtime which is not installed anyway
 		imageURL)
 	var stdoutBuf, stderrBuf bytes.Buffer
@@ -139,6 +163,9 @@ func (a *API) runTrivy(ctx context.Context, imageURL, format, keppelToken string
 	cmd.Stderr = &stderrBuf
 	cmd.WaitDelay = 3 * time.Second
 	err = cmd.Run()
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%w: %w", ctx.Err(), err)
+	}
 
 	return stdoutBuf.Bytes(), stderrBuf.Bytes(), err
 }
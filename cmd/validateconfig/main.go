@@ -9,14 +9,15 @@ import (
 	"github.com/sapcc/go-bits/must"
 
 	"github.com/sapcc/keppel/internal/drivers/basic"
+	"github.com/sapcc/keppel/internal/keppel"
 )
 
 // AddCommandTo mounts this command into the command hierarchy.
 func AddCommandTo(parent *cobra.Command) {
 	cmd := &cobra.Command{
 		Use:   "validate-config",
-		Short: "Validates driver configuration files.",
-		Long: `Contains subcommands to validate configuration files for specific drivers.
+		Short: "Validates configuration files.",
+		Long: `Contains subcommands to validate the Keppel configuration file and configuration files for specific drivers.
 This is intended to be used e.g. for preflight checks in CI deployments.`,
 		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
@@ -25,6 +26,16 @@ This is intended to be used e.g. for preflight checks in CI deployments.`,
 	}
 	parent.AddCommand(cmd)
 
+	cmd.AddCommand(&cobra.Command{
+		Use:     "config-file <path>",
+		Example: "  keppel server validate-config config-file ./config/keppel.yaml",
+		Short:   "Validates a configuration file for Keppel itself (see KEPPEL_CONFIG_FILE).",
+		Long: `Validates a configuration file for Keppel itself (see KEPPEL_CONFIG_FILE).
+Settings from the environment of this command take precedence over the configuration file, just like for the actual server components.`,
+		Args: cobra.ExactArgs(1),
+		Run:  runForConfigFile,
+	})
+
 	cmd.AddCommand(&cobra.Command{
 		Use:     "account-management-basic <path>",
 		Example: "  keppel server validate-config account-management-basic ./config/managed-accounts.json",
@@ -34,6 +45,10 @@ This is intended to be used e.g. for preflight checks in CI deployments.`,
 	})
 }
 
+func runForConfigFile(cmd *cobra.Command, args []string) {
+	must.Succeed(keppel.ValidateConfigFile(args[0]))
+}
+
 func runForAccountManagementBasic(cmd *cobra.Command, args []string) {
 	driver := &basic.AccountManagementDriver{ConfigPath: args[0]}
 	must.Succeed(driver.LoadConfig())
@@ -4,8 +4,16 @@
 package authapi
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
 	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sapcc/go-bits/errext"
@@ -24,17 +32,26 @@ type API struct {
 	authDriver keppel.AuthDriver
 	fd         keppel.FederationDriver
 	db         *keppel.DB
+	rpur       *keppel.RBACPolicyUsageRecorder // may be nil
+	timeNow    func() time.Time
 }
 
 // NewAPI constructs a new API instance.
-func NewAPI(cfg keppel.Configuration, ad keppel.AuthDriver, fd keppel.FederationDriver, db *keppel.DB) *API {
-	return &API{cfg, ad, fd, db}
+func NewAPI(cfg keppel.Configuration, ad keppel.AuthDriver, fd keppel.FederationDriver, db *keppel.DB, rpur *keppel.RBACPolicyUsageRecorder) *API {
+	return &API{cfg, ad, fd, db, rpur, time.Now}
+}
+
+// OverrideTimeNow replaces time.Now with a test double.
+func (a *API) OverrideTimeNow(timeNow func() time.Time) *API {
+	a.timeNow = timeNow
+	return a
 }
 
 // AddTo implements the api.API interface.
 func (a *API) AddTo(r *mux.Router) {
 	r.Methods("GET").Path("/keppel/v1/auth").HandlerFunc(a.handleGetAuth)
 	r.Methods("POST").Path("/keppel/v1/auth/peering").HandlerFunc(a.handlePostPeering)
+	r.Methods("POST").Path("/keppel/v1/auth/narrow").HandlerFunc(a.handlePostNarrow)
 }
 
 func respondWithError(w http.ResponseWriter, code int, err error) bool {
@@ -57,41 +74,33 @@ func (a *API) handleGetAuth(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/auth")
 
 	// parse request
-	req, err := parseRequest(r.URL.RawQuery, a.cfg)
+	req, err := parseRequest(r.URL.RawQuery, a.cfg, a.db)
 	if respondWithError(w, http.StatusBadRequest, err) {
 		return
 	}
 
 	// special cases for anycast requests
+	var peerScopes map[string]auth.ScopeSet
 	if req.IntendedAudience.IsAnycast {
-		if len(req.Scopes) > 1 {
-			//NOTE: This is not a fundamental restriction, there was just no demand for
-			// it yet. If the requirement comes up, we could ask all relevant upstreams
-			// for tokens and issue one token that grants the sum of all accesses.
-			respondWithError(w, http.StatusInternalServerError, errors.New("anycast tokens cannot be issued for multiple scopes at once"))
+		var localScopes auth.ScopeSet
+		localScopes, peerScopes, err = a.splitAnycastScopes(r, req)
+		if respondWithError(w, http.StatusInternalServerError, err) {
 			return
 		}
 
-		if len(req.Scopes) == 1 {
-			scope := req.Scopes[0]
-			if scope.ResourceType == "repository" {
-				repoScope := scope.ParseRepositoryScope(req.IntendedAudience)
-				accountExists, err := keppel.DoesAccountExist(a.db, repoScope.AccountName)
-				if respondWithError(w, http.StatusInternalServerError, err) {
-					return
-				}
-
-				// if we don't have this account locally, but the request is an anycast
-				// request and one of our peers has the account, ask them to issue the token
-				if !accountExists {
-					err := a.reverseProxyTokenReqToUpstream(w, r, req.IntendedAudience, repoScope.AccountName)
-					if !errors.Is(err, keppel.ErrNoSuchPrimaryAccount) {
-						respondWithError(w, http.StatusInternalServerError, err)
-						return
-					}
-				}
+		// if all requested scopes refer to accounts that one of our peers has,
+		// ask them to issue the token
+		if len(localScopes) == 0 && len(peerScopes) == 1 {
+			for peerHostName := range peerScopes {
+				err := a.reverseProxyTokenReqToUpstream(w, r, req.IntendedAudience, peerHostName)
+				respondWithError(w, http.StatusInternalServerError, err)
+				return
 			}
 		}
+
+		// otherwise we authorize the local scopes ourselves and ask the peers
+		// for the rest (see below)
+		req.Scopes = localScopes
 	}
 
 	authz, _, rerr := auth.IncomingRequest{
@@ -101,11 +110,24 @@ func (a *API) handleGetAuth(w http.ResponseWriter, r *http.Request) {
 		AllowsDomainRemapping:    true,
 		AudienceForTokenIssuance: &req.IntendedAudience,
 		PartialAccessAllowed:     true,
+		RBACPolicyUsage:          a.rpur,
+		TimeNow:                  a.timeNow,
 	}.Authorize(r.Context(), a.cfg, a.authDriver, a.db)
 	if rerr != nil {
 		rerr.WriteAsAuthResponseTo(w)
 		return
 	}
+	if authz.DigestRestriction != nil {
+		// otherwise narrowed tokens could be renewed indefinitely
+		respondWithError(w, http.StatusForbidden, errors.New("narrowed tokens cannot be exchanged for new tokens"))
+		return
+	}
+
+	authz.ClientID = keppel.NormalizeClientID(req.ClientID)
+
+	// for anycast requests spanning multiple peers, the token that we issue
+	// shall also grant the accesses that the respective peers are willing to grant
+	a.mergeTokensFromPeers(r, authz, peerScopes)
 
 	tokenResponse, err := authz.IssueToken(a.cfg)
 	if respondWithError(w, http.StatusBadRequest, err) {
@@ -114,19 +136,168 @@ func (a *API) handleGetAuth(w http.ResponseWriter, r *http.Request) {
 	respondwith.JSON(w, http.StatusOK, tokenResponse)
 }
 
-func (a *API) reverseProxyTokenReqToUpstream(w http.ResponseWriter, r *http.Request, audience auth.Audience, accountName models.AccountName) error {
-	primaryHostName, err := a.fd.FindPrimaryAccount(r.Context(), accountName)
-	if err != nil {
-		return err
+// Sorts the scopes of an anycast token request into those that we can
+// authorize ourselves, and those that refer to accounts that one of our peers
+// has (grouped by the peer's hostname).
+func (a *API) splitAnycastScopes(r *http.Request, req Request) (localScopes auth.ScopeSet, peerScopes map[string]auth.ScopeSet, err error) {
+	peerScopes = make(map[string]auth.ScopeSet)
+	for _, scope := range req.Scopes {
+		if scope.ResourceType != "repository" {
+			localScopes = append(localScopes, scope)
+			continue
+		}
+		repoScope := scope.ParseRepositoryScope(req.IntendedAudience)
+		accountExists, err := keppel.DoesAccountExist(a.db, repoScope.AccountName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if accountExists {
+			localScopes = append(localScopes, scope)
+			continue
+		}
+
+		// if we don't have this account locally, one of our peers might have it
+		primaryHostName, err := a.fd.FindPrimaryAccount(r.Context(), repoScope.AccountName)
+		switch {
+		case errors.Is(err, keppel.ErrNoSuchPrimaryAccount):
+			// nobody has this account, so the scope will just not be granted
+			localScopes = append(localScopes, scope)
+		case err != nil:
+			return nil, nil, err
+		default:
+			peerScopes[primaryHostName] = append(peerScopes[primaryHostName], scope)
+		}
 	}
+	return localScopes, peerScopes, nil
+}
 
+func (a *API) reverseProxyTokenReqToUpstream(w http.ResponseWriter, r *http.Request, audience auth.Audience, primaryHostName string) error {
 	// protect against infinite forwarding loops in case different Keppels have
 	// different ideas about who is the primary account
 	if forwardedBy := r.URL.Query().Get("X-Keppel-Forwarded-By"); forwardedBy != "" {
-		logg.Error("not forwarding anycast token request for account %q to %s because request was already forwarded to us by %s",
-			accountName, primaryHostName, forwardedBy)
+		logg.Error("not forwarding anycast token request to %s because request was already forwarded to us by %s",
+			primaryHostName, forwardedBy)
 		return errors.New("request blocked by reverse-proxy loop protection")
 	}
 
 	return a.cfg.ReverseProxyAnycastRequestToPeer(w, r, audience.MapPeerHostname(primaryHostName))
 }
+
+// peerTokenRequestTimeout bounds how long the issuance of an anycast token
+// waits for peers that are asked to contribute to it.
+const peerTokenRequestTimeout = 10 * time.Second
+
+// Used for requesting anycast tokens from peers. The transport is not set
+// explicitly, so that unit tests can substitute http.DefaultTransport.
+var peerTokenClient = &http.Client{Timeout: peerTokenRequestTimeout}
+
+// Asks all peers in parallel to issue anycast tokens for the respective
+// scopes, and adds the accesses granted by those tokens to the given
+// Authorization. Peers that fail to respond in time are skipped.
+func (a *API) mergeTokensFromPeers(r *http.Request, authz *auth.Authorization, peerScopes map[string]auth.ScopeSet) {
+	peerHostNames := slices.Sorted(maps.Keys(peerScopes))
+	peerAuthzs := make([]*auth.Authorization, len(peerHostNames))
+	var wg sync.WaitGroup
+	for idx, peerHostName := range peerHostNames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peerAuthz, err := a.getTokenFromPeer(r, authz, peerHostName, peerScopes[peerHostName])
+			if err != nil {
+				logg.Error("while requesting anycast token from %s: %s", peerHostName, err.Error())
+				return
+			}
+			peerAuthzs[idx] = peerAuthz
+		}()
+	}
+	wg.Wait()
+
+	// merge in a deterministic order
+	for _, peerAuthz := range peerAuthzs {
+		if peerAuthz != nil {
+			mergePeerAuthorization(authz, *peerAuthz)
+		}
+	}
+}
+
+// Asks the given peer to issue an anycast token for the given scopes (using
+// the same credentials as the original request), and returns the
+// Authorization contained in that token.
+func (a *API) getTokenFromPeer(r *http.Request, authz *auth.Authorization, primaryHostName string, scopes auth.ScopeSet) (*auth.Authorization, error) {
+	// protect against infinite forwarding loops (see above)
+	if forwardedBy := r.Header.Get("X-Keppel-Forwarded-By"); forwardedBy != "" {
+		return nil, fmt.Errorf("request blocked by reverse-proxy loop protection (request was already forwarded to us by %s)", forwardedBy)
+	}
+
+	query := url.Values{}
+	query.Set("service", r.URL.Query().Get("service"))
+	for _, scope := range scopes {
+		query.Add("scope", scope.String())
+	}
+	if clientID := r.URL.Query().Get("client_id"); clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	query.Set("forwarded-by", a.cfg.APIPublicHostname)
+	reqURL := url.URL{
+		Scheme:   "https",
+		Host:     authz.Audience.MapPeerHostname(primaryHostName),
+		Path:     r.URL.Path,
+		RawQuery: query.Encode(),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), peerTokenRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	req.Header.Set("X-Keppel-Forwarded-By", a.cfg.APIPublicHostname)
+	resp, err := peerTokenClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected 200 OK, but got %s", resp.Status)
+	}
+	var tokenResponse auth.TokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&tokenResponse)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode token response: %w", err)
+	}
+
+	// only trust the token if it was signed with our shared anycast keys
+	peerAuthz, err := auth.ParseTokenFromPeer(a.cfg, a.authDriver, authz.Audience, tokenResponse.Token)
+	if err != nil {
+		return nil, fmt.Errorf("cannot validate token: %w", err)
+	}
+	if peerAuthz.UserIdentity.UserName() != authz.UserIdentity.UserName() {
+		return nil, fmt.Errorf("token was issued for user %q instead of %q", peerAuthz.UserIdentity.UserName(), authz.UserIdentity.UserName())
+	}
+	return peerAuthz, nil
+}
+
+// Adds the accesses granted by a peer's token to the given Authorization.
+func mergePeerAuthorization(authz *auth.Authorization, peerAuthz auth.Authorization) {
+	for _, scope := range peerAuthz.ScopeSet {
+		authz.ScopeSet.Add(*scope)
+	}
+	if len(peerAuthz.KeppelLabelRestrictions) > 0 {
+		if authz.KeppelLabelRestrictions == nil {
+			authz.KeppelLabelRestrictions = make(map[string][]map[string]string)
+		}
+		maps.Copy(authz.KeppelLabelRestrictions, peerAuthz.KeppelLabelRestrictions)
+	}
+	if len(peerAuthz.PolicyEpochs) > 0 {
+		if authz.PolicyEpochs == nil {
+			authz.PolicyEpochs = make(map[models.AccountName]int64)
+		}
+		maps.Copy(authz.PolicyEpochs, peerAuthz.PolicyEpochs)
+	}
+	if authz.ClientID == "" {
+		authz.ClientID = peerAuthz.ClientID
+	}
+}
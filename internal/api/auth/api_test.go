@@ -16,6 +16,7 @@ import (
 
 	"github.com/sapcc/go-bits/assert"
 	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/must"
 
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
@@ -140,6 +141,18 @@ var testCases = []TestCase{
 		CannotDelete: true, GrantedActions: "pull,push"},
 	{Scope: "repository:test1/foo:delete",
 		CannotDelete: true, GrantedActions: ""},
+	// scan_read is a narrower version of pull that only covers vulnerability reports
+	{Scope: "repository:test1/foo:scan_read",
+		GrantedActions: "scan_read"},
+	{Scope: "repository:test1/foo:scan_read",
+		CannotPull: true, GrantedActions: ""},
+	{Scope: "repository:test1/foo:pull,scan_read",
+		CannotPush: true, GrantedActions: "pull,scan_read"},
+	{Scope: "repository:test1/foo:scan_read", AnonymousLogin: true,
+		GrantedActions: ""},
+	{Scope: "repository:test1/foo:scan_read", AnonymousLogin: true,
+		RBACPolicy:     &policyAnonPull,
+		GrantedActions: "scan_read"},
 	// catalog access always allowed if username/password are ok (access to
 	// specific accounts is filtered later)
 	{Scope: "registry:catalog:*",
@@ -465,6 +478,8 @@ type jwtToken struct {
 	// indirectly in the registry API tests since the registry API uses attributes
 	// from the EmbeddedAuthorization.
 	Ignored map[string]any `json:"kea"`
+	// The RBAC policy epochs are exercised by TestTokenInvalidationOnRBACPolicyChange.
+	PolicyEpochs map[string]int64 `json:"kpe"`
 }
 
 // jwtContents contains what we expect in a JWT token payload section. This type
@@ -687,6 +702,112 @@ func TestInvalidCredentials(t *testing.T) {
 	req.Check(t, h)
 }
 
+func TestTokenInvalidationOnRBACPolicyChange(t *testing.T) {
+	s := setupPrimary(t, test.WithKeppelAPI)
+	h := s.Handler
+	s.AD.GrantedPermissions = "view:test1authtenant,pull:test1authtenant,change:test1authtenant"
+
+	getToken := func() string {
+		t.Helper()
+		_, respBody := assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/auth?service=registry.example.org&scope=repository:test1/foo:pull",
+			Header:       map[string]string{"Authorization": keppel.BuildBasicAuthHeader("correctusername", "correctpassword")},
+			ExpectStatus: http.StatusOK,
+		}.Check(t, h)
+		var data struct {
+			Token string `json:"token"`
+		}
+		err := json.Unmarshal(respBody, &data)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return data.Token
+	}
+	expectTokenAccepted := func(token string) {
+		t.Helper()
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/tags/list",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusNotFound,
+			ExpectBody:   test.ErrorCode(keppel.ErrNameUnknown),
+		}.Check(t, h)
+	}
+
+	// a token issued under the current RBAC policies is accepted
+	oldToken := getToken()
+	expectTokenAccepted(oldToken)
+
+	// changes to unrelated account attributes do not affect existing tokens
+	accountJSON := assert.JSONObject{"auth_tenant_id": "test1authtenant"}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1",
+		Header:       map[string]string{"X-Test-Perms": "change:test1authtenant"},
+		Body:         assert.JSONObject{"account": accountJSON},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	expectTokenAccepted(oldToken)
+
+	// after the RBAC policies have changed, the old token is rejected...
+	accountJSON["rbac_policies"] = []assert.JSONObject{{
+		"match_repository": "bar",
+		"match_username":   "correctusername",
+		"permissions":      []string{"pull"},
+	}}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1",
+		Header:       map[string]string{"X-Test-Perms": "change:test1authtenant"},
+		Body:         assert.JSONObject{"account": accountJSON},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/foo/tags/list",
+		Header:       map[string]string{"Authorization": "Bearer " + oldToken},
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody:   test.ErrorCode(keppel.ErrUnauthorized),
+	}.Check(t, h)
+
+	// ...but a fresh token works
+	newToken := getToken()
+	expectTokenAccepted(newToken)
+
+	// when the RBAC policies are changed through another keppel-api replica, the
+	// epochs cached in this process may be outdated, but tokens issued by the
+	// other replica are still accepted
+	_, err := s.DB.Exec(`UPDATE accounts SET rbac_policy_epoch = rbac_policy_epoch + 1 WHERE name = $1`, "test1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	newToken = getToken()
+	expectTokenAccepted(newToken)
+
+	// the same applies to changes to RBAC policies of namespaces
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1/namespaces/team",
+		Header: map[string]string{"X-Test-Perms": "change:test1authtenant"},
+		Body: assert.JSONObject{"namespace": assert.JSONObject{
+			"rbac_policies": []assert.JSONObject{{
+				"match_repository": ".*",
+				"permissions":      []string{"anonymous_pull"},
+			}},
+		}},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/foo/tags/list",
+		Header:       map[string]string{"Authorization": "Bearer " + newToken},
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody:   test.ErrorCode(keppel.ErrUnauthorized),
+	}.Check(t, h)
+	expectTokenAccepted(getToken())
+}
+
 type anycastTestCase struct {
 	// request
 	AccountName models.AccountName
@@ -808,6 +929,57 @@ func TestAnycastAndDomainRemappedTokens(t *testing.T) {
 			}
 		}
 
+		// test that anycast tokens for multiple accounts on different peers can be
+		// obtained in one request: the issuing Keppel asks its peers for the
+		// scopes referring to their accounts and returns one combined token
+		multiScopeQuery := "scope=repository:test1/foo:pull&scope=repository:test2/bar:pull&scope=repository:test3/baz:pull"
+		multiScopeTestCases := []struct {
+			Handler      http.Handler
+			Issuer       string
+			LocalRepo    string
+			FromPeerRepo string
+		}{
+			{Handler: h1, Issuer: localService1, LocalRepo: "test1/foo", FromPeerRepo: "test2/bar"},
+			{Handler: h2, Issuer: localService2, LocalRepo: "test2/bar", FromPeerRepo: "test1/foo"},
+		}
+		for _, c := range multiScopeTestCases {
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         fmt.Sprintf("/keppel/v1/auth?service=%s&%s", anycastService, multiScopeQuery),
+				Header:       correctAuthHeader,
+				ExpectStatus: http.StatusOK,
+				ExpectBody: jwtContents{
+					Audience: anycastService,
+					Issuer:   "keppel-api@" + c.Issuer,
+					Subject:  "correctusername",
+					Access: []jwtAccess{
+						// the local scope comes first, then the one obtained from the peer
+						// (test3 does not exist anywhere, so no access is granted for it)
+						{Type: "repository", Name: c.LocalRepo, Actions: []string{"pull"}},
+						{Type: "repository", Name: c.FromPeerRepo, Actions: []string{"pull"}},
+					},
+				},
+			}.Check(t, c.Handler)
+		}
+
+		// when all scopes refer to accounts on the same peer, the request is
+		// reverse-proxied as a whole
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         fmt.Sprintf("/keppel/v1/auth?service=%s&scope=repository:test2/foo:pull&scope=repository:test2/bar:pull", anycastService),
+			Header:       correctAuthHeader,
+			ExpectStatus: http.StatusOK,
+			ExpectBody: jwtContents{
+				Audience: anycastService,
+				Issuer:   "keppel-api@" + localService2,
+				Subject:  "correctusername",
+				Access: []jwtAccess{
+					{Type: "repository", Name: "test2/foo", Actions: []string{"pull"}},
+					{Type: "repository", Name: "test2/bar", Actions: []string{"pull"}},
+				},
+			},
+		}.Check(t, h1)
+
 		// test that catalog access is not allowed on anycast (since we don't know
 		// which peer to ask for authentication)
 		assert.HTTPRequest{
@@ -858,6 +1030,61 @@ func TestAnycastAndDomainRemappedTokens(t *testing.T) {
 	})
 }
 
+func TestCustomDomainTokens(t *testing.T) {
+	s := setupPrimary(t)
+	h := s.Handler
+	s.AD.GrantedPermissions = fmt.Sprintf("%s:test1authtenant,%s:test1authtenant", keppel.CanPullFromAccount, keppel.CanViewAccount)
+	correctAuthHeader := map[string]string{
+		"Authorization": keppel.BuildBasicAuthHeader("correctusername", "correctpassword"),
+	}
+
+	// before the custom domain is configured, we cannot issue tokens for it
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/auth?service=registry.team.example.com&scope=repository:foo:pull",
+		Header:       correctAuthHeader,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.JSONObject{"details": `cannot issue tokens for service: "registry.team.example.com"`},
+	}.Check(t, h)
+
+	// the same is true while the ownership of the custom domain has not been verified
+	_, err := s.DB.Exec(`UPDATE accounts SET custom_domain = $1 WHERE name = $2`, "registry.team.example.com", "test1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	must.Succeed(keppel.NotifyAccountChanged(s.DB, "test1"))
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/auth?service=registry.team.example.com&scope=repository:foo:pull",
+		Header:       correctAuthHeader,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.JSONObject{"details": `cannot issue tokens for service: "registry.team.example.com"`},
+	}.Check(t, h)
+
+	// afterwards, tokens for the custom domain behave like tokens for the domain-remapped API of the account
+	_, err = s.DB.Exec(`UPDATE accounts SET custom_domain_verified_at = $1 WHERE name = $2`, s.Clock.Now(), "test1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	must.Succeed(keppel.NotifyAccountChanged(s.DB, "test1"))
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/auth?service=registry.team.example.com&scope=repository:foo:pull",
+		Header:       correctAuthHeader,
+		ExpectStatus: http.StatusOK,
+		ExpectBody: jwtContents{
+			Audience: "registry.team.example.com",
+			Issuer:   "keppel-api@registry.team.example.com",
+			Subject:  "correctusername",
+			Access: []jwtAccess{{
+				Type:    "repository",
+				Name:    "foo",
+				Actions: []string{"pull"},
+			}},
+		},
+	}.Check(t, h)
+}
+
 func TestMultiScope(t *testing.T) {
 	// It turns out that it's allowed to send multiple scopes in a single auth
 	// request, which produces a token with a union of all granted scopes. This
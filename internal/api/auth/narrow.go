@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package authapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+const (
+	// DefaultNarrowedTokenLifetime is used by POST /keppel/v1/auth/narrow when
+	// the request does not specify a lifetime.
+	DefaultNarrowedTokenLifetime = 1 * time.Minute
+	// MaxNarrowedTokenLifetime is the longest lifetime that can be requested
+	// from POST /keppel/v1/auth/narrow.
+	MaxNarrowedTokenLifetime = 15 * time.Minute
+	// MaxNarrowedTokenDigests is the largest number of digests that a narrowed
+	// token can be issued for.
+	MaxNarrowedTokenDigests = 100
+)
+
+// NarrowRequest is the structure of the JSON request body sent to the POST
+// /keppel/v1/auth/narrow endpoint.
+type NarrowRequest struct {
+	Repository string          `json:"repository"`
+	Digests    []digest.Digest `json:"digests"`
+	ExpiresIn  uint64          `json:"expires_in"`
+}
+
+func (a *API) handlePostNarrow(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/auth/narrow")
+	// decode request body
+	var req NarrowRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&req)
+	if rerr := keppel.AsRequestBodyError(err); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
+	if err != nil {
+		http.Error(w, "request body is not valid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// validate request
+	if !models.RepoNameWithLeadingSlashRx.MatchString("/" + req.Repository) {
+		respondWithError(w, http.StatusUnprocessableEntity, fmt.Errorf("invalid repository name: %q", req.Repository))
+		return
+	}
+	if len(req.Digests) == 0 {
+		respondWithError(w, http.StatusUnprocessableEntity, errors.New("at least one digest must be given"))
+		return
+	}
+	if len(req.Digests) > MaxNarrowedTokenDigests {
+		respondWithError(w, http.StatusUnprocessableEntity, fmt.Errorf("cannot issue narrowed token for more than %d digests", MaxNarrowedTokenDigests))
+		return
+	}
+	for _, d := range req.Digests {
+		err := d.Validate()
+		if err != nil {
+			respondWithError(w, http.StatusUnprocessableEntity, fmt.Errorf("invalid digest %q: %w", d, err))
+			return
+		}
+	}
+	lifetime := DefaultNarrowedTokenLifetime
+	if req.ExpiresIn != 0 {
+		if req.ExpiresIn > uint64(MaxNarrowedTokenLifetime/time.Second) {
+			respondWithError(w, http.StatusUnprocessableEntity, fmt.Errorf("expires_in may not be larger than %d", uint64(MaxNarrowedTokenLifetime/time.Second)))
+			return
+		}
+		lifetime = time.Duration(req.ExpiresIn) * time.Second //nolint:gosec // overflow is prevented by the check above
+	}
+
+	// the narrowed token can only grant what the existing token grants
+	scope := auth.Scope{
+		ResourceType: "repository",
+		ResourceName: req.Repository,
+		Actions:      []string{"pull"},
+	}
+	authz, _, rerr := auth.IncomingRequest{
+		HTTPRequest:           r,
+		Scopes:                auth.NewScopeSet(scope),
+		AllowsDomainRemapping: true,
+		CorrectlyReturn403:    true,
+		NoImplicitAnonymous:   true,
+	}.Authorize(r.Context(), a.cfg, a.authDriver, a.db)
+	if rerr != nil {
+		rerr.WriteAsAuthResponseTo(w)
+		return
+	}
+	if authz.DigestRestriction != nil {
+		for _, d := range req.Digests {
+			if !slices.Contains(authz.DigestRestriction, d) {
+				respondWithError(w, http.StatusForbidden, fmt.Errorf("existing token does not grant access to digest %q", d))
+				return
+			}
+		}
+	}
+	if !authz.ExpiresAt.IsZero() {
+		remaining := time.Until(authz.ExpiresAt).Truncate(time.Second)
+		if remaining < lifetime {
+			lifetime = remaining
+		}
+		if lifetime <= 0 {
+			respondWithError(w, http.StatusForbidden, errors.New("existing token is about to expire"))
+			return
+		}
+	}
+
+	narrowedAuthz := auth.Authorization{
+		UserIdentity:      authz.UserIdentity,
+		ScopeSet:          auth.NewScopeSet(scope),
+		Audience:          authz.Audience,
+		DigestRestriction: slices.Clone(req.Digests),
+		PolicyEpochs:      authz.PolicyEpochs,
+		// the narrowed token must not allow pulling more than the original token
+		KeppelLabelRestrictions: authz.KeppelLabelRestrictions,
+	}
+	tokenResponse, err := narrowedAuthz.IssueTokenWithExpires(a.cfg, lifetime)
+	if respondWithError(w, http.StatusInternalServerError, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, tokenResponse)
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package authapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestNarrowedTokens(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "test1authtenant"}),
+	)
+	h := s.Handler
+	fooRepoRef := models.Repository{AccountName: "test1", Name: "foo"}
+	blob1 := test.NewBytes([]byte("first blob"))
+	blob2 := test.NewBytes([]byte("second blob"))
+	blob1.MustUpload(t, s, fooRepoRef)
+	blob2.MustUpload(t, s, fooRepoRef)
+	token := s.GetToken(t, "repository:test1/foo:pull")
+
+	// narrowing requires an existing token that grants pull access to the repo
+	narrowReq := assert.JSONObject{
+		"repository": "test1/foo",
+		"digests":    []string{blob1.Digest.String()},
+	}
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/auth/narrow",
+		Body:         narrowReq,
+		ExpectStatus: http.StatusUnauthorized,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/auth/narrow",
+		Header: map[string]string{"Authorization": "Bearer " + token},
+		Body: assert.JSONObject{
+			"repository": "test1/bar",
+			"digests":    []string{blob1.Digest.String()},
+		},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// invalid requests are rejected
+	assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/auth/narrow",
+		Header: map[string]string{"Authorization": "Bearer " + token},
+		Body: assert.JSONObject{
+			"repository": "test1/foo",
+			"digests":    []string{"sha256:foo"},
+		},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.JSONObject{"details": `invalid digest "sha256:foo": invalid checksum digest length`},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/auth/narrow",
+		Header: map[string]string{"Authorization": "Bearer " + token},
+		Body: assert.JSONObject{
+			"repository": "test1/foo",
+			"digests":    []string{blob1.Digest.String()},
+			"expires_in": 86400,
+		},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.JSONObject{"details": "expires_in may not be larger than 900"},
+	}.Check(t, h)
+
+	// happy path
+	resp, respBody := assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/auth/narrow",
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		Body:         narrowReq,
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	if resp.StatusCode != http.StatusOK {
+		t.FailNow()
+	}
+	var tokenResponse struct {
+		Token     string `json:"token"`
+		ExpiresIn uint64 `json:"expires_in"`
+	}
+	err := json.Unmarshal(respBody, &tokenResponse)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "expires_in", tokenResponse.ExpiresIn, uint64(60))
+	narrowedHeader := map[string]string{"Authorization": "Bearer " + tokenResponse.Token}
+
+	// the narrowed token can pull the blob that it was issued for...
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/foo/blobs/" + blob1.Digest.String(),
+		Header:       narrowedHeader,
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.ByteData(blob1.Contents),
+	}.Check(t, h)
+
+	// ...but nothing else
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/foo/blobs/" + blob2.Digest.String(),
+		Header:       narrowedHeader,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   test.ErrorCode(keppel.ErrDenied),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/foo/tags/list",
+		Header:       narrowedHeader,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   test.ErrorCode(keppel.ErrDenied),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests",
+		Header:       narrowedHeader,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.StringData("token is restricted to pulling specific digests\n"),
+	}.Check(t, h)
+
+	// narrowed tokens cannot be renewed or widened
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/auth?" + url.Values{"service": {"registry.example.org"}, "scope": {"repository:test1/foo:pull"}}.Encode(),
+		Header:       narrowedHeader,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.JSONObject{"details": "narrowed tokens cannot be exchanged for new tokens"},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/auth/narrow",
+		Header: narrowedHeader,
+		Body: assert.JSONObject{
+			"repository": "test1/foo",
+			"digests":    []string{blob2.Digest.String()},
+		},
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.JSONObject{"details": `existing token does not grant access to digest "` + blob2.Digest.String() + `"`},
+	}.Check(t, h)
+}
@@ -32,6 +32,10 @@ func (a *API) handlePostPeering(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 	err := decoder.Decode(&req)
+	if rerr := keppel.AsRequestBodyError(err); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
 	if err != nil {
 		http.Error(w, "request body is not valid JSON: "+err.Error(), http.StatusBadRequest)
 		return
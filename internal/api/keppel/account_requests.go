@@ -0,0 +1,297 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var accountRequestsGetQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM account_requests
+	 WHERE ($1 = '' OR auth_tenant_id = $1) AND ($2 = '' OR state = $2)
+	 ORDER BY id
+`)
+
+var pendingAccountRequestExistsQuery = sqlext.SimplifyWhitespace(`
+	SELECT COUNT(*) > 0 FROM account_requests WHERE account_name = $1 AND state = 'pending'
+`)
+
+var decideAccountRequestQuery = sqlext.SimplifyWhitespace(`
+	UPDATE account_requests SET state = $2, decided_at = $3, decided_by = $4, decision_reason = $5
+	 WHERE id = $1 AND state = 'pending'
+`)
+
+func (a *API) handleGetAccountRequests(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/account_requests")
+	if !a.checkAccountRequestsEnabled(w) {
+		return
+	}
+
+	// admins can see all requests; other users can only see the requests for auth tenants that they have access to
+	query := r.URL.Query()
+	authTenantID := query.Get("auth_tenant_id")
+	scopes := auth.NewScopeSet(auth.AdminAPIScope)
+	if authTenantID != "" {
+		scopes = authTenantScope(keppel.CanViewAccount, authTenantID)
+	}
+	authz := a.authenticateRequest(w, r, scopes)
+	if authz == nil {
+		return
+	}
+
+	state := models.AccountRequestState(query.Get("state"))
+	switch state {
+	case "", models.AccountRequestPending, models.AccountRequestApproved, models.AccountRequestDenied:
+		// acceptable
+	default:
+		http.Error(w, `invalid value for query parameter "state"`, http.StatusBadRequest)
+		return
+	}
+
+	var dbRequests []models.AccountRequest
+	_, err := a.db.Select(&dbRequests, accountRequestsGetQuery, authTenantID, string(state))
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	requests := make([]keppel.AccountRequest, len(dbRequests))
+	for idx, ar := range dbRequests {
+		requests[idx], err = keppel.RenderAccountRequest(ar)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"account_requests": requests})
+}
+
+func (a *API) handlePostAccountRequest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/account_requests")
+	if !a.checkAccountRequestsEnabled(w) {
+		return
+	}
+
+	// decode request body
+	var req struct {
+		Account keppel.Account `json:"account"`
+	}
+	ok := decodeJSONRequestBody(w, r.Body, &req)
+	if !ok {
+		return
+	}
+	if !models.IsAccountName(string(req.Account.Name)) {
+		http.Error(w, `malformed attribute "account.name" in request body`, http.StatusUnprocessableEntity)
+		return
+	}
+	if req.Account.AuthTenantID == "" {
+		http.Error(w, `missing attribute "account.auth_tenant_id" in request body`, http.StatusUnprocessableEntity)
+		return
+	}
+	if req.Account.State != "" {
+		http.Error(w, `malformed attribute "account.state" in request body is not allowed here`, http.StatusUnprocessableEntity)
+		return
+	}
+	// custom metadata is validated again when the account is created, but
+	// requesters should learn about missing fields right away
+	var metadata map[string]string
+	if req.Account.Metadata != nil {
+		metadata = *req.Account.Metadata
+	}
+	err := a.cfg.AccountMetadataSchema.Validate(metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// unlike for PUT /keppel/v1/accounts/:name, read access to the auth tenant is sufficient
+	authz := a.authenticateRequest(w, r, authTenantScope(keppel.CanViewAccount, req.Account.AuthTenantID))
+	if authz == nil {
+		return
+	}
+
+	// check for conflicts
+	account, err := keppel.FindAccount(a.db, req.Account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if account != nil {
+		http.Error(w, "account name already in use", http.StatusConflict)
+		return
+	}
+	isPending, err := a.db.SelectBool(pendingAccountRequestExistsQuery, req.Account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if isPending {
+		http.Error(w, "there is already a pending request for this account name", http.StatusConflict)
+		return
+	}
+
+	accountJSON, err := json.Marshal(req.Account)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	ar := models.AccountRequest{
+		AccountName:  req.Account.Name,
+		AuthTenantID: req.Account.AuthTenantID,
+		AccountJSON:  string(accountJSON),
+		State:        models.AccountRequestPending,
+		RequestedAt:  a.timeNow(),
+		RequestedBy:  authz.UserIdentity.UserName(),
+	}
+	err = a.db.Insert(&ar)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	rendered, err := keppel.RenderAccountRequest(ar)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	a.recordAccountRequestAuditEvent(r, authz, http.StatusCreated, cadf.CreateAction, rendered)
+	respondwith.JSON(w, http.StatusCreated, map[string]any{"account_request": rendered})
+}
+
+func (a *API) handlePostApproveAccountRequest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/account_requests/:id/approve")
+	authz, ar, reason := a.findAccountRequestForDecision(w, r)
+	if authz == nil {
+		return
+	}
+
+	// the account might have been created through other means in the meantime;
+	// in this case, we must not apply the requested configuration to it
+	account, err := keppel.FindAccount(a.db, ar.AccountName)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if account != nil {
+		http.Error(w, "account name already in use", http.StatusConflict)
+		return
+	}
+
+	// create the account
+	var requestedAccount keppel.Account
+	err = json.Unmarshal([]byte(ar.AccountJSON), &requestedAccount)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	getSubleaseTokenCallback := func(_ models.Peer) (keppel.SubleaseToken, error) {
+		// there is no way for the requester to supply a sublease token, so this
+		// only works with federation drivers that do not need one
+		return keppel.SubleaseToken{}, nil
+	}
+	finalizeAccountCallback := func(_ *models.Account) *keppel.RegistryV2Error {
+		return nil
+	}
+	_, rerr := a.processor().CreateOrUpdateAccount(r.Context(), requestedAccount, authz.UserIdentity.UserInfo(), r, getSubleaseTokenCallback, finalizeAccountCallback)
+	if rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
+
+	a.finishAccountRequestDecision(w, r, authz, *ar, models.AccountRequestApproved, reason)
+}
+
+func (a *API) handlePostDenyAccountRequest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/account_requests/:id/deny")
+	authz, ar, reason := a.findAccountRequestForDecision(w, r)
+	if authz == nil {
+		return
+	}
+	if reason == "" {
+		http.Error(w, `missing "reason" attribute`, http.StatusUnprocessableEntity)
+		return
+	}
+	a.finishAccountRequestDecision(w, r, authz, *ar, models.AccountRequestDenied, reason)
+}
+
+func (a *API) checkAccountRequestsEnabled(w http.ResponseWriter) bool {
+	if !a.cfg.AccountRequestsEnabled {
+		http.Error(w, "account requests are not enabled", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// Shared preparation for the endpoints that approve or deny account requests.
+// If the returned *auth.Authorization is nil, an error response has been written.
+func (a *API) findAccountRequestForDecision(w http.ResponseWriter, r *http.Request) (*auth.Authorization, *models.AccountRequest, string) {
+	if !a.checkAccountRequestsEnabled(w) {
+		return nil, nil, ""
+	}
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return nil, nil, ""
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if !decodeJSONRequestBody(w, r.Body, &req) {
+		return nil, nil, ""
+	}
+
+	var ar models.AccountRequest
+	err := a.db.SelectOne(&ar, `SELECT * FROM account_requests WHERE id = $1`, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such account request", http.StatusNotFound)
+		return nil, nil, ""
+	}
+	if respondwith.ErrorText(w, err) {
+		return nil, nil, ""
+	}
+	if ar.State != models.AccountRequestPending {
+		http.Error(w, "account request was already "+string(ar.State), http.StatusConflict)
+		return nil, nil, ""
+	}
+	return authz, &ar, req.Reason
+}
+
+func (a *API) finishAccountRequestDecision(w http.ResponseWriter, r *http.Request, authz *auth.Authorization, ar models.AccountRequest, state models.AccountRequestState, reason string) {
+	now := a.timeNow()
+	ar.State = state
+	ar.DecidedAt = &now
+	ar.DecidedBy = authz.UserIdentity.UserName()
+	ar.DecisionReason = reason
+	_, err := a.db.Exec(decideAccountRequestQuery, ar.ID, ar.State, ar.DecidedAt, ar.DecidedBy, ar.DecisionReason)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	rendered, err := keppel.RenderAccountRequest(ar)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	action := cadf.AllowAction
+	if state == models.AccountRequestDenied {
+		action = cadf.DenyAction
+	}
+	a.recordAccountRequestAuditEvent(r, authz, http.StatusOK, action, rendered)
+	respondwith.JSON(w, http.StatusOK, map[string]any{"account_request": rendered})
+}
+
+func (a *API) recordAccountRequestAuditEvent(r *http.Request, authz *auth.Authorization, reasonCode int, action cadf.Action, ar keppel.AccountRequest) {
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: reasonCode,
+			Action:     action,
+			Target:     AuditAccountRequest{AccountRequest: ar},
+		})
+	}
+}
@@ -0,0 +1,224 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestAccountRequestsDisabled(t *testing.T) {
+	s := test.NewSetup(t, test.WithKeppelAPI)
+
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         assert.JSONObject{"account": assert.JSONObject{"name": "first", "auth_tenant_id": "tenant1"}},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("account requests are not enabled\n"),
+	}.Check(t, s.Handler)
+}
+
+func TestAccountRequestsWorkflow(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccountRequests,
+		test.WithAccount(models.Account{Name: "existing", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	// the requester and the approver are recorded by username
+	s.AD.ExpectedUserName = "correctusername"
+
+	// requesting an account requires at least read access to the auth tenant
+	requestedAccount := assert.JSONObject{
+		"name":           "first",
+		"auth_tenant_id": "tenant1",
+		"rbac_policies": []assert.JSONObject{{
+			"match_repository": "library/.*",
+			"permissions":      []string{"anonymous_pull"},
+		}},
+		"metadata": nil,
+	}
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant2"},
+		Body:         assert.JSONObject{"account": requestedAccount},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// error cases
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         assert.JSONObject{"account": assert.JSONObject{"name": "Not_Valid", "auth_tenant_id": "tenant1"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("malformed attribute \"account.name\" in request body\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         assert.JSONObject{"account": assert.JSONObject{"name": "existing", "auth_tenant_id": "tenant1"}},
+		ExpectStatus: http.StatusConflict,
+		ExpectBody:   assert.StringData("account name already in use\n"),
+	}.Check(t, h)
+
+	// happy path: request an account
+	s.Clock.StepBy(time.Hour)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         assert.JSONObject{"account": requestedAccount},
+		ExpectStatus: http.StatusCreated,
+		ExpectBody: assert.JSONObject{"account_request": assert.JSONObject{
+			"id":           1,
+			"account":      requestedAccount,
+			"state":        "pending",
+			"requested_at": 3600,
+			"requested_by": "correctusername",
+		}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         assert.JSONObject{"account": requestedAccount},
+		ExpectStatus: http.StatusConflict,
+		ExpectBody:   assert.StringData("there is already a pending request for this account name\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         assert.JSONObject{"account": assert.JSONObject{"name": "second", "auth_tenant_id": "tenant1"}},
+		ExpectStatus: http.StatusCreated,
+	}.Check(t, h)
+
+	// listing all requests requires admin permission, but users can list the requests for their own auth tenant
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/account_requests",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/account_requests?auth_tenant_id=tenant2",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/account_requests?auth_tenant_id=tenant1&state=pending",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"account_requests": []assert.JSONObject{
+			{
+				"id":           1,
+				"account":      requestedAccount,
+				"state":        "pending",
+				"requested_at": 3600,
+				"requested_by": "correctusername",
+			},
+			{
+				"id":           2,
+				"account":      assert.JSONObject{"name": "second", "auth_tenant_id": "tenant1", "rbac_policies": []assert.JSONObject{}, "metadata": nil},
+				"state":        "pending",
+				"requested_at": 3600,
+				"requested_by": "correctusername",
+			},
+		}},
+	}.Check(t, h)
+
+	// decisions require admin permission
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests/1/approve",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests/3/approve",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such account request\n"),
+	}.Check(t, h)
+
+	// happy path: approve the first request, which creates the account
+	s.Clock.StepBy(time.Hour)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests/1/approve",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{"reason": "looks good"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"account_request": assert.JSONObject{
+			"id":              1,
+			"account":         requestedAccount,
+			"state":           "approved",
+			"requested_at":    3600,
+			"requested_by":    "correctusername",
+			"decided_at":      7200,
+			"decided_by":      "correctusername",
+			"decision_reason": "looks good",
+		}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/first",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests/1/deny",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{"reason": "changed my mind"},
+		ExpectStatus: http.StatusConflict,
+		ExpectBody:   assert.StringData("account request was already approved\n"),
+	}.Check(t, h)
+
+	// happy path: deny the second request, which requires a reason
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests/2/deny",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("missing \"reason\" attribute\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/account_requests/2/deny",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{"reason": "please use the existing account"},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/second",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/account_requests?state=pending",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"account_requests": []assert.JSONObject{}},
+	}.Check(t, h)
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/respondwith"
 
+	"github.com/sapcc/keppel/internal/auth"
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 )
@@ -56,7 +57,7 @@ func (a *API) handleGetAccounts(w http.ResponseWriter, r *http.Request) {
 	// render accounts to JSON
 	accountsRendered := make([]keppel.Account, len(accountsFiltered))
 	for idx, account := range accountsFiltered {
-		accountsRendered[idx], err = keppel.RenderAccount(account)
+		accountsRendered[idx], err = a.renderAccount(account)
 		if respondwith.ErrorText(w, err) {
 			return
 		}
@@ -64,6 +65,23 @@ func (a *API) handleGetAccounts(w http.ResponseWriter, r *http.Request) {
 	respondwith.JSON(w, http.StatusOK, map[string]any{"accounts": accountsRendered})
 }
 
+// renderAccount extends keppel.RenderAccount() with information that needs
+// to be queried from the DB, namely the issues of the account.
+func (a *API) renderAccount(dbAccount models.Account) (keppel.Account, error) {
+	account, err := keppel.RenderAccount(dbAccount)
+	if err != nil {
+		return keppel.Account{}, err
+	}
+	account.Issues, err = keppel.FindAccountIssues(a.db, dbAccount)
+	if err != nil {
+		return keppel.Account{}, err
+	}
+	if account.State == "" && len(account.Issues) > 0 {
+		account.State = "degraded"
+	}
+	return account, nil
+}
+
 func (a *API) handleGetAccount(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account")
 	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
@@ -75,7 +93,7 @@ func (a *API) handleGetAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accountRendered, err := keppel.RenderAccount(*account)
+	accountRendered, err := a.renderAccount(*account)
 	if respondwith.ErrorText(w, err) {
 		return
 	}
@@ -102,9 +120,9 @@ func (a *API) handlePutAccount(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `malformed attribute "account.state" in request body is not allowed here`, http.StatusUnprocessableEntity)
 		return
 	}
-	// ... or metadata ...
-	if req.Account.Metadata != nil && len(*req.Account.Metadata) > 0 {
-		http.Error(w, `malformed attribute "account.metadata" in request body is not allowed here`, http.StatusUnprocessableEntity)
+	// ... or issues ...
+	if len(req.Account.Issues) > 0 {
+		http.Error(w, `malformed attribute "account.issues" in request body is not allowed here`, http.StatusUnprocessableEntity)
 		return
 	}
 	// ... and transfer the name here into the struct, to make the below code simpler
@@ -129,17 +147,53 @@ func (a *API) handlePutAccount(w http.ResponseWriter, r *http.Request) {
 		}
 		return nil
 	}
+
+	// if the account has an approval policy, some parts of the update may need
+	// to be split off into pending changes
+	var pendingChanges []models.PendingChange
+	originalAccount, err := keppel.FindAccount(a.db, req.Account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if originalAccount != nil && originalAccount.AuthTenantID == req.Account.AuthTenantID {
+		var rerr *keppel.RegistryV2Error
+		pendingChanges, rerr = a.processor().PendingChangesForAccountUpdate(*originalAccount, &req.Account)
+		if rerr != nil {
+			rerr.WriteAsTextTo(w)
+			return
+		}
+	}
+
 	account, rerr := a.processor().CreateOrUpdateAccount(r.Context(), req.Account, authz.UserIdentity.UserInfo(), r, getSubleaseTokenCallback, finalizeAccountCallback)
 	if rerr != nil {
 		rerr.WriteAsTextTo(w)
 		return
 	}
 
-	accountRendered, err := keppel.RenderAccount(account)
+	accountRendered, err := a.renderAccount(account)
 	if respondwith.ErrorText(w, err) {
 		return
 	}
-	respondwith.JSON(w, http.StatusOK, map[string]any{"account": accountRendered})
+	if len(pendingChanges) == 0 {
+		respondwith.JSON(w, http.StatusOK, map[string]any{"account": accountRendered})
+		return
+	}
+
+	pendingChangesRendered := make([]keppel.PendingChange, len(pendingChanges))
+	for idx := range pendingChanges {
+		err := a.processor().SubmitPendingChange(account.Reduced(), &pendingChanges[idx], keppel.AuditContext{
+			UserIdentity: authz.UserIdentity,
+			Request:      r,
+		})
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+		pendingChangesRendered[idx], err = keppel.RenderPendingChange(pendingChanges[idx])
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+	}
+	respondwith.JSON(w, http.StatusAccepted, map[string]any{"account": accountRendered, "pending_changes": pendingChangesRendered})
 }
 
 func (a *API) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
@@ -158,7 +212,16 @@ func (a *API) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := a.processor().MarkAccountForDeletion(*account, keppel.AuditContext{
+	pc, err := a.processor().PendingChangeForAccountDeletion(*account)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if pc != nil {
+		a.submitPendingChange(w, r, authz, account.Reduced(), pc)
+		return
+	}
+
+	err = a.processor().MarkAccountForDeletion(*account, keppel.AuditContext{
 		UserIdentity: authz.UserIdentity,
 		Request:      r,
 	})
@@ -276,15 +339,29 @@ func (a *API) handlePutSecurityScanPolicies(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if !a.storeSecurityScanPolicies(w, r, authz, *account, dbPolicies, req.Policies) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"policies": req.Policies})
+}
+
+// Writes the given set of security scan policies into the DB and generates
+// audit events for each policy that was created or deleted relative to
+// `dbPolicies`. Returns false if an error response was written.
+func (a *API) storeSecurityScanPolicies(w http.ResponseWriter, r *http.Request, authz *auth.Authorization, account models.Account, dbPolicies, policies []keppel.SecurityScanPolicy) bool {
 	// update policies in DB
-	jsonBuf, err := json.Marshal(req.Policies)
+	jsonBuf, err := json.Marshal(policies)
 	if respondwith.ErrorText(w, err) {
-		return
+		return false
 	}
 	_, err = a.db.Exec(`UPDATE accounts SET security_scan_policies_json = $1 WHERE name = $2`,
 		string(jsonBuf), account.Name)
 	if respondwith.ErrorText(w, err) {
-		return
+		return false
+	}
+	err = keppel.NotifyAccountChanged(a.db, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return false
 	}
 
 	// generate audit events
@@ -300,22 +377,21 @@ func (a *API) handlePutSecurityScanPolicies(w http.ResponseWriter, r *http.Reque
 			})
 		}
 	}
-	for _, policy := range req.Policies {
+	for _, policy := range policies {
 		if !slices.Contains(dbPolicies, policy) {
 			submitAudit("create/security-scan-policy", AuditSecurityScanPolicy{
-				Account: *account,
+				Account: account,
 				Policy:  policy,
 			})
 		}
 	}
 	for _, policy := range dbPolicies {
-		if !slices.Contains(req.Policies, policy) {
+		if !slices.Contains(policies, policy) {
 			submitAudit("delete/security-scan-policy", AuditSecurityScanPolicy{
-				Account: *account,
+				Account: account,
 				Policy:  policy,
 			})
 		}
 	}
-
-	respondwith.JSON(w, http.StatusOK, map[string]any{"policies": req.Policies})
+	return true
 }
@@ -4,12 +4,16 @@
 package keppelv1_test
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sapcc/go-api-declarations/cadf"
 	"github.com/sapcc/go-bits/assert"
@@ -389,7 +393,7 @@ func TestAccountsAPI(t *testing.T) {
 		ExpectBody:   assert.JSONObject{"sublease_token": makeSubleaseToken("second", "registry.example.org", "this-is-the-token")},
 	}.Check(t, h)
 	tr.DBChanges().AssertEqual(`
-		UPDATE accounts SET gc_policies_json = '[]', rbac_policies_json = '[{"match_repository":"library/alpine","match_username":".*@tenant2","permissions":["pull"]},{"match_repository":"library/alpine","match_username":".*@tenant3","permissions":["pull","delete"]}]' WHERE name = 'second';
+		UPDATE accounts SET gc_policies_json = '[]', rbac_policies_json = '[{"match_repository":"library/alpine","match_username":".*@tenant2","permissions":["pull"]},{"match_repository":"library/alpine","match_username":".*@tenant3","permissions":["pull","delete"]}]', rbac_policy_epoch = 1 WHERE name = 'second';
 	`)
 }
 
@@ -725,6 +729,15 @@ func TestPutAccountErrorCases(t *testing.T) {
 			},
 			ErrorMessage: `GC policy cannot have the "except_tag" attribute when "only_untagged" is set`,
 		},
+		{
+			GCPolicyJSON: assert.JSONObject{
+				"match_repository": "library/.*",
+				"only_archived":    true,
+				"except_archived":  true,
+				"action":           "delete",
+			},
+			ErrorMessage: `GC policy cannot have the "only_archived" and "except_archived" attributes at the same time`,
+		},
 		{
 			GCPolicyJSON: assert.JSONObject{
 				"match_repository": "library/.*",
@@ -794,6 +807,18 @@ func TestPutAccountErrorCases(t *testing.T) {
 			},
 			ErrorMessage: `GC policy with action "delete" cannot set the "time_constraint.newest" attribute`,
 		},
+		{
+			GCPolicyJSON: assert.JSONObject{
+				"match_repository": "library/.*",
+				"time_constraint": assert.JSONObject{
+					"on":                       "pushed_at",
+					"older_than_business_days": 10,
+					"time_zone":                "Europe/Atlantis",
+				},
+				"action": "delete",
+			},
+			ErrorMessage: `"Europe/Atlantis" is not a valid time zone for a GC policy time constraint`,
+		},
 	}
 	for _, tc := range gcPolicyTestcases {
 		expectedStatus := http.StatusUnprocessableEntity
@@ -901,6 +926,13 @@ func TestPutAccountErrorCases(t *testing.T) {
 			},
 			ErrorMessage: `RBAC policy with "delete" must have the "match_username" attribute`,
 		},
+		{
+			RBACPolicyJSON: assert.JSONObject{
+				"match_repository": "library/.+",
+				"permissions":      []string{"promote"},
+			},
+			ErrorMessage: `RBAC policy with "promote" must have the "match_username" attribute`,
+		},
 		{
 			RBACPolicyJSON: assert.JSONObject{
 				"match_repository": "library/.+",
@@ -1072,6 +1104,21 @@ func TestPutAccountErrorCases(t *testing.T) {
 		ExpectBody:   assert.StringData("platform filter is only allowed on replica accounts\n"),
 	}.Check(t, h)
 
+	// test unexpected replication retry hints on new primary account
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/third",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: assert.JSONObject{
+			"account": assert.JSONObject{
+				"auth_tenant_id":          "tenant1",
+				"replication_retry_hints": true,
+			},
+		},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("replication retry hints are only allowed on replica accounts\n"),
+	}.Check(t, h)
+
 	// test errors for sublease token issuance: missing authentication/authorization
 	assert.HTTPRequest{
 		Method:       "POST",
@@ -1125,7 +1172,7 @@ func TestPutAccountErrorCases(t *testing.T) {
 			},
 		},
 		ExpectStatus: http.StatusUnprocessableEntity,
-		ExpectBody:   assert.StringData("malformed attribute \"account.metadata\" in request body is not allowed here\n"),
+		ExpectBody:   assert.StringData("unknown account metadata field: \"foo\"\n"),
 	}.Check(t, h)
 
 	// test protection for managed accounts
@@ -1599,6 +1646,192 @@ func TestGetPutAccountReplicationFromExternalOnFirstUse(t *testing.T) {
 		ExpectBody:   assert.StringData("cannot change username for \"from_external_on_first_use\" replication without also changing password\n"),
 	}.Check(t, h)
 
+	// test PUT on existing account to add per-repo-prefix credentials
+	makeCredentialsRequest := func(credentials []assert.JSONObject) assert.JSONObject {
+		return assert.JSONObject{
+			"account": assert.JSONObject{
+				"auth_tenant_id": "tenant1",
+				"rbac_policies":  []assert.JSONObject{},
+				"replication": assert.JSONObject{
+					"strategy": "from_external_on_first_use",
+					"upstream": assert.JSONObject{
+						"url":         "registry.example.com",
+						"username":    "foo",
+						"credentials": credentials,
+					},
+				},
+				"platform_filter": testPlatformFilter,
+			},
+		}
+	}
+	expectedAccountWithCredentials := assert.JSONObject{
+		"account": assert.JSONObject{
+			"name":           "first",
+			"auth_tenant_id": "tenant1",
+			"metadata":       nil,
+			"rbac_policies":  []assert.JSONObject{},
+			"replication": assert.JSONObject{
+				"strategy": "from_external_on_first_use",
+				"upstream": assert.JSONObject{
+					"url":      "registry.example.com",
+					"username": "foo",
+					"credentials": []assert.JSONObject{
+						{"repo_prefix": "org1/", "username": "robot1"},
+						{"repo_prefix": "org2/", "username": "robot2"},
+					},
+				},
+			},
+			"platform_filter": testPlatformFilter,
+		},
+	}
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeCredentialsRequest([]assert.JSONObject{
+			{"repo_prefix": "org1/", "username": "robot1", "password": "secret1"},
+			{"repo_prefix": "org2/", "username": "robot2", "password": "secret2"},
+		}),
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   expectedAccountWithCredentials,
+	}.Check(t, h)
+
+	// as above, the credentials can be copied from GET without passwords
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeCredentialsRequest([]assert.JSONObject{
+			{"repo_prefix": "org1/", "username": "robot1"},
+			{"repo_prefix": "org2/", "username": "robot2"},
+		}),
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   expectedAccountWithCredentials,
+	}.Check(t, h)
+	account, err := keppel.FindAccount(s.DB, "first")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "stored credentials", account.ExternalPeerCredentials, models.ExternalPeerCredentials{
+		{RepoPrefix: "org1/", UserName: "robot1", Password: "secret1"},
+		{RepoPrefix: "org2/", UserName: "robot2", Password: "secret2"},
+	})
+
+	// error cases for per-repo-prefix credentials
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeCredentialsRequest([]assert.JSONObject{
+			{"repo_prefix": "org1/", "username": "robot3"},
+		}),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("cannot change username for repo_prefix \"org1/\" in \"from_external_on_first_use\" replication without also changing password\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeCredentialsRequest([]assert.JSONObject{
+			{"repo_prefix": "org3/", "password": "secret3"},
+		}),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("need both username and password for repo_prefix \"org3/\" in \"from_external_on_first_use\" replication\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeCredentialsRequest([]assert.JSONObject{
+			{"repo_prefix": "org1/", "username": "robot1", "password": "secret1"},
+			{"repo_prefix": "org1/", "username": "robot3", "password": "secret3"},
+		}),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("duplicate repo_prefix \"org1/\" in credentials for \"from_external_on_first_use\" replication\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeCredentialsRequest([]assert.JSONObject{
+			{"username": "robot3", "password": "secret3"},
+		}),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("missing repo_prefix in credentials for \"from_external_on_first_use\" replication\n"),
+	}.Check(t, h)
+
+	// passwords can be given as references into the secret store, but only if the reference can be resolved
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeCredentialsRequest([]assert.JSONObject{
+			{"repo_prefix": "org1/", "username": "robot1", "password_ref": "secret/robot1"},
+		}),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("cannot resolve password_ref \"secret/robot1\": no such secret, or access denied\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeCredentialsRequest([]assert.JSONObject{
+			{"repo_prefix": "org1/", "username": "robot1", "password": "secret1", "password_ref": "secret/robot1"},
+		}),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("cannot give both password and password_ref for repo_prefix \"org1/\" in \"from_external_on_first_use\" replication\n"),
+	}.Check(t, h)
+
+	// secrets of other tenants cannot be referred to
+	s.SecD.Secrets["tenant2/secret/robot1"] = "secret1"
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeCredentialsRequest([]assert.JSONObject{
+			{"repo_prefix": "org1/", "username": "robot1", "password_ref": "../tenant2/secret/robot1"},
+		}),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("cannot resolve password_ref \"../tenant2/secret/robot1\": no such secret, or access denied\n"),
+	}.Check(t, h)
+
+	s.SecD.Secrets["tenant1/secret/robot1"] = "secret1"
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeCredentialsRequest([]assert.JSONObject{
+			{"repo_prefix": "org1/", "username": "robot1", "password_ref": "secret/robot1"},
+		}),
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"account": assert.JSONObject{
+				"name":           "first",
+				"auth_tenant_id": "tenant1",
+				"metadata":       nil,
+				"rbac_policies":  []assert.JSONObject{},
+				"replication": assert.JSONObject{
+					"strategy": "from_external_on_first_use",
+					"upstream": assert.JSONObject{
+						"url":      "registry.example.com",
+						"username": "foo",
+						"credentials": []assert.JSONObject{
+							{"repo_prefix": "org1/", "username": "robot1", "password_ref": "secret/robot1"},
+						},
+					},
+				},
+				"platform_filter": testPlatformFilter,
+			},
+		},
+	}.Check(t, h)
+	account, err = keppel.FindAccount(s.DB, "first")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "stored credentials", account.ExternalPeerCredentials, models.ExternalPeerCredentials{
+		{RepoPrefix: "org1/", UserName: "robot1", PasswordRef: "secret/robot1"},
+	})
+
 	// test sublease token issuance on account (external replicas count as primary
 	// accounts for the purposes of account name subleasing)
 	s.FD.NextSubleaseTokenSecretToIssue = "this-is-the-token"
@@ -1941,84 +2174,336 @@ func TestReplicaAccountsInheritPlatformFilter(t *testing.T) {
 	})
 }
 
-func TestSecurityScanPoliciesHappyPath(t *testing.T) {
-	s := test.NewSetup(t,
-		test.WithKeppelAPI,
-	)
+func TestPutAccountCustomDomain(t *testing.T) {
+	s := test.NewSetup(t, test.WithKeppelAPI)
+	h := s.Handler
 
-	// we need to set test.AuthDriver.ExpectedUserName because this username is
-	// matched against the managed_by_user field of our policies
-	s.AD.ExpectedUserName = "exampleuser"
+	makeRequest := func(customDomain assert.JSONObject) assert.JSONObject {
+		return assert.JSONObject{
+			"account": assert.JSONObject{
+				"auth_tenant_id": "tenant1",
+				"custom_domain":  customDomain,
+			},
+		}
+	}
 
-	// create a fresh account for testing
+	// hostnames must be valid and may not overlap with our own domain (those are reserved for domain-remapped APIs)
+	testCases := map[string]string{
+		"":                           `invalid hostname for custom domain: ""`,
+		"Registry.Team.Example.Com":  `invalid hostname for custom domain: "Registry.Team.Example.Com"`,
+		"localhost":                  `invalid hostname for custom domain: "localhost"`,
+		"-foo.example.com":           `invalid hostname for custom domain: "-foo.example.com"`,
+		"registry.example.org":       `custom domain "registry.example.org" overlaps with the domain of this registry`,
+		"first.registry.example.org": `custom domain "first.registry.example.org" overlaps with the domain of this registry`,
+	}
+	for hostname, expectedMessage := range testCases {
+		assert.HTTPRequest{
+			Method:       "PUT",
+			Path:         "/keppel/v1/accounts/first",
+			Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+			Body:         makeRequest(assert.JSONObject{"hostname": hostname}),
+			ExpectStatus: http.StatusUnprocessableEntity,
+			ExpectBody:   assert.StringData(expectedMessage + "\n"),
+		}.Check(t, h)
+	}
+
+	// certificates are given as references into the secret store, and must be valid for the hostname
 	assert.HTTPRequest{
 		Method: "PUT",
 		Path:   "/keppel/v1/accounts/first",
 		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
-		Body: assert.JSONObject{
-			"account": assert.JSONObject{"auth_tenant_id": "tenant1"},
-		},
-		ExpectStatus: http.StatusOK,
-	}.Check(t, s.Handler)
+		Body: makeRequest(assert.JSONObject{
+			"hostname":        "registry.team.example.com",
+			"certificate_ref": "secret/team-cert",
+		}),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("cannot resolve certificate_ref \"secret/team-cert\": no such secret, or access denied\n"),
+	}.Check(t, h)
+	s.SecD.Secrets["tenant1/secret/team-cert"] = "not a certificate"
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeRequest(assert.JSONObject{
+			"hostname":        "registry.team.example.com",
+			"certificate_ref": "secret/team-cert",
+		}),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("cannot parse TLS certificate: tls: failed to find any PEM data in certificate input\n"),
+	}.Check(t, h)
+	s.SecD.Secrets["tenant1/secret/team-cert"] = test.GenerateTLSCertificatePEM(t, "other.example.com")
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeRequest(assert.JSONObject{
+			"hostname":        "registry.team.example.com",
+			"certificate_ref": "secret/team-cert",
+		}),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("TLS certificate is not valid for custom domain: x509: certificate is valid for other.example.com, not registry.team.example.com\n"),
+	}.Check(t, h)
 
-	// a freshly-created account should have no policies at all
+	// happy path
+	s.SecD.Secrets["tenant1/secret/team-cert"] = test.GenerateTLSCertificatePEM(t, "registry.team.example.com")
 	assert.HTTPRequest{
-		Method:       "GET",
-		Path:         "/keppel/v1/accounts/first/security_scan_policies",
-		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeRequest(assert.JSONObject{
+			"hostname":        "registry.team.example.com",
+			"certificate_ref": "secret/team-cert",
+		}),
 		ExpectStatus: http.StatusOK,
-		ExpectBody:   assert.JSONObject{"policies": []assert.JSONObject{}},
-	}.Check(t, s.Handler)
-	s.Auditor.IgnoreEventsUntilNow()
+	}.Check(t, h)
 
-	// helper function for testing a successful PUT of policies, followed by a GET
-	// that returns those same policies
-	expectPoliciesToBeApplied := func(policies ...assert.JSONObject) {
-		assert.HTTPRequest{
-			Method:       "PUT",
-			Path:         "/keppel/v1/accounts/first/security_scan_policies",
-			Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
-			Body:         assert.JSONObject{"policies": policies},
-			ExpectStatus: http.StatusOK,
-			ExpectBody:   assert.JSONObject{"policies": policies},
-		}.Check(t, s.Handler)
+	// the custom domain is not used until its ownership has been verified by
+	// publishing the random token in DNS
+	expectCustomDomain := func(accountName models.AccountName, status string) {
+		t.Helper()
+		token, err := s.DB.SelectStr(`SELECT custom_domain_verification_token FROM accounts WHERE name = $1`, accountName)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !strings.HasPrefix(token, "keppel-verification=") {
+			t.Errorf("expected a verification token, but got %q", token)
+		}
 		assert.HTTPRequest{
 			Method:       "GET",
-			Path:         "/keppel/v1/accounts/first/security_scan_policies",
+			Path:         "/keppel/v1/accounts/" + string(accountName),
 			Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
 			ExpectStatus: http.StatusOK,
-			ExpectBody:   assert.JSONObject{"policies": policies},
-		}.Check(t, s.Handler)
+			ExpectBody: assert.JSONObject{
+				"account": assert.JSONObject{
+					"name":           accountName,
+					"auth_tenant_id": "tenant1",
+					"metadata":       nil,
+					"rbac_policies":  []assert.JSONObject{},
+					"custom_domain": assert.JSONObject{
+						"hostname":        "registry.team.example.com",
+						"certificate_ref": "secret/team-cert",
+						"verification": assert.JSONObject{
+							"status":           status,
+							"txt_record_name":  "_keppel-challenge.registry.team.example.com",
+							"txt_record_value": token,
+						},
+					},
+				},
+			},
+		}.Check(t, h)
 	}
+	expectCustomDomain("first", "pending")
 
-	// PUT with no policies is okay, does nothing
-	expectPoliciesToBeApplied( /* nothing */ )
-	s.Auditor.ExpectEvents(t /*, nothing */)
+	// while the custom domain is unverified, other accounts can claim it as well
+	// (otherwise anyone could block a domain from being used by its owner)
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/second",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeRequest(assert.JSONObject{
+			"hostname":        "registry.team.example.com",
+			"certificate_ref": "secret/team-cert",
+		}),
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	expectCustomDomain("second", "pending")
 
-	// add the policies from the API spec example
-	policy1 := assert.JSONObject{
-		"match_repository":       ".*",
-		"match_vulnerability_id": ".*",
-		"except_fix_released":    true,
-		"action": assert.JSONObject{
-			"ignore":     true,
-			"assessment": "risk accepted: vulnerabilities without an available fix are not actionable",
-		},
-	}
-	policy2 := assert.JSONObject{
-		"managed_by_user":        "exampleuser",
-		"match_repository":       "my-python-app|my-other-image",
-		"match_vulnerability_id": "CVE-2022-40897",
-		"action": assert.JSONObject{
-			"severity":   "Low",
-			"assessment": "adjusted severity: python-setuptools cannot be invoked through user requests",
-		},
+	// once verified, the same custom domain cannot be used by a different account
+	_, err := s.DB.Exec(`UPDATE accounts SET custom_domain_verified_at = $1 WHERE name = $2`, s.Clock.Now(), "first")
+	if err != nil {
+		t.Fatal(err.Error())
 	}
-	expectPoliciesToBeApplied(policy1, policy2)
+	expectCustomDomain("first", "verified")
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/second",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         makeRequest(assert.JSONObject{"hostname": "registry.team.example.com"}),
+		ExpectStatus: http.StatusConflict,
+		ExpectBody:   assert.StringData("custom domain \"registry.team.example.com\" is already in use by another account\n"),
+	}.Check(t, h)
 
-	// adding two policies generates one create event per policy
-	expectedEventForPolicy := func(action cadf.Action, policy assert.JSONObject) cadf.Event {
-		return cadf.Event{
+	// updates that do not change the custom domain keep the verification
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: makeRequest(assert.JSONObject{
+			"hostname":        "registry.team.example.com",
+			"certificate_ref": "secret/team-cert",
+		}),
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	expectCustomDomain("first", "verified")
+
+	// omitting the custom domain removes it
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: assert.JSONObject{
+			"account": assert.JSONObject{"auth_tenant_id": "tenant1"},
+		},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"account": assert.JSONObject{
+				"name":           "first",
+				"auth_tenant_id": "tenant1",
+				"metadata":       nil,
+				"rbac_policies":  []assert.JSONObject{},
+			},
+		},
+	}.Check(t, h)
+}
+
+func TestPutAccountContentEncryptionKeyRef(t *testing.T) {
+	s := test.NewSetup(t, test.WithKeppelAPI)
+	h := s.Handler
+
+	makeRequest := func(keyRef string) assert.JSONObject {
+		return assert.JSONObject{
+			"account": assert.JSONObject{
+				"auth_tenant_id":             "tenant1",
+				"content_encryption_key_ref": keyRef,
+			},
+		}
+	}
+
+	// the data key is given as a reference into the secret store, and must be a valid key
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/first",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         makeRequest("secret/data-key"),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("cannot resolve content_encryption_key_ref \"secret/data-key\": no such secret, or access denied\n"),
+	}.Check(t, h)
+	s.SecD.Secrets["tenant1/secret/data-key"] = base64.StdEncoding.EncodeToString([]byte("too short"))
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/first",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         makeRequest("secret/data-key"),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("content encryption key must be a base64-encoded key of 32 bytes\n"),
+	}.Check(t, h)
+
+	// happy path
+	s.SecD.Secrets["tenant1/secret/data-key"] = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x42}, 32))
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/first",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         makeRequest("secret/data-key"),
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"account": assert.JSONObject{
+				"name":                       "first",
+				"auth_tenant_id":             "tenant1",
+				"metadata":                   nil,
+				"rbac_policies":              []assert.JSONObject{},
+				"content_encryption_key_ref": "secret/data-key",
+			},
+		},
+	}.Check(t, h)
+
+	// omitting the key reference removes it (existing contents stay readable
+	// since each of them records the key that it was encrypted with)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/first",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         makeRequest(""),
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"account": assert.JSONObject{
+				"name":           "first",
+				"auth_tenant_id": "tenant1",
+				"metadata":       nil,
+				"rbac_policies":  []assert.JSONObject{},
+			},
+		},
+	}.Check(t, h)
+}
+
+func TestSecurityScanPoliciesHappyPath(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+	)
+
+	// we need to set test.AuthDriver.ExpectedUserName because this username is
+	// matched against the managed_by_user field of our policies
+	s.AD.ExpectedUserName = "exampleuser"
+
+	// create a fresh account for testing
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: assert.JSONObject{
+			"account": assert.JSONObject{"auth_tenant_id": "tenant1"},
+		},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, s.Handler)
+
+	// a freshly-created account should have no policies at all
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/first/security_scan_policies",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"policies": []assert.JSONObject{}},
+	}.Check(t, s.Handler)
+	s.Auditor.IgnoreEventsUntilNow()
+
+	// helper function for testing a successful PUT of policies, followed by a GET
+	// that returns those same policies
+	expectPoliciesToBeApplied := func(policies ...assert.JSONObject) {
+		assert.HTTPRequest{
+			Method:       "PUT",
+			Path:         "/keppel/v1/accounts/first/security_scan_policies",
+			Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+			Body:         assert.JSONObject{"policies": policies},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.JSONObject{"policies": policies},
+		}.Check(t, s.Handler)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/first/security_scan_policies",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.JSONObject{"policies": policies},
+		}.Check(t, s.Handler)
+	}
+
+	// PUT with no policies is okay, does nothing
+	expectPoliciesToBeApplied( /* nothing */ )
+	s.Auditor.ExpectEvents(t /*, nothing */)
+
+	// add the policies from the API spec example
+	policy1 := assert.JSONObject{
+		"match_repository":       ".*",
+		"match_vulnerability_id": ".*",
+		"except_fix_released":    true,
+		"action": assert.JSONObject{
+			"ignore":     true,
+			"assessment": "risk accepted: vulnerabilities without an available fix are not actionable",
+		},
+	}
+	policy2 := assert.JSONObject{
+		"managed_by_user":        "exampleuser",
+		"match_repository":       "my-python-app|my-other-image",
+		"match_vulnerability_id": "CVE-2022-40897",
+		"action": assert.JSONObject{
+			"severity":   "Low",
+			"assessment": "adjusted severity: python-setuptools cannot be invoked through user requests",
+		},
+	}
+	expectPoliciesToBeApplied(policy1, policy2)
+
+	// adding two policies generates one create event per policy
+	expectedEventForPolicy := func(action cadf.Action, policy assert.JSONObject) cadf.Event {
+		return cadf.Event{
 			RequestPath: "/keppel/v1/accounts/first/security_scan_policies",
 			Action:      action,
 			Outcome:     "success",
@@ -2322,3 +2807,455 @@ func TestSecurityScanPoliciesAuthorizationErrors(t *testing.T) {
 		),
 	}.Check(t, s.Handler)
 }
+
+func TestSecurityScanPolicyImport(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "first", AuthTenantID: "tenant1", SecurityScanPoliciesJSON: "[]"}),
+	)
+	s.Clock.StepBy(time.Hour)
+	path := "/keppel/v1/accounts/first/security_scan_policies/import"
+
+	// error cases
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path + "?format=harbor",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         assert.JSONObject{"items": []assert.JSONObject{}},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, s.Handler)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path + "?format=trivy",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         assert.JSONObject{"items": []assert.JSONObject{}},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("query parameter \"format\" must be \"harbor\" or \"quay\"\n"),
+	}.Check(t, s.Handler)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path + "?format=harbor",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         assert.JSONObject{"expires_at": 1800, "items": []assert.JSONObject{{"cve_id": "CVE-2024-0001"}}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("cannot import a Harbor CVE allowlist that expired at 1970-01-01T00:30:00Z\n"),
+	}.Check(t, s.Handler)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path + "?format=quay",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         assert.JSONObject{"images": assert.JSONObject{"Foo:latest": assert.JSONObject{"CVE-2024-0001": ""}}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("image name \"Foo:latest\" in Quay CVE whitelist is not a valid repository name\n"),
+	}.Check(t, s.Handler)
+	s.Auditor.ExpectEvents(t /*, nothing */)
+
+	// import a Harbor allowlist (including the metadata fields that Harbor puts in its exports)
+	harborPolicies := []assert.JSONObject{
+		{
+			"match_repository":       ".*",
+			"match_vulnerability_id": "CVE-2024-0001",
+			"action":                 assert.JSONObject{"ignore": true, "assessment": "imported from Harbor CVE allowlist (was set to expire at 1970-01-01T02:00:00Z)"},
+		},
+		{
+			"match_repository":       ".*",
+			"match_vulnerability_id": "CVE-2024-0002",
+			"action":                 assert.JSONObject{"ignore": true, "assessment": "imported from Harbor CVE allowlist (was set to expire at 1970-01-01T02:00:00Z)"},
+		},
+	}
+	assert.HTTPRequest{
+		Method: "POST",
+		Path:   path + "?format=harbor",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: assert.JSONObject{
+			"id":            3,
+			"project_id":    2,
+			"expires_at":    7200,
+			"creation_time": "2024-01-01T00:00:00Z",
+			"update_time":   "2024-01-01T00:00:00Z",
+			"items":         []assert.JSONObject{{"cve_id": "CVE-2024-0001"}, {"cve_id": "CVE-2024-0002"}},
+		},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"policies": harborPolicies},
+	}.Check(t, s.Handler)
+	if events := s.Auditor.RecordedEvents(); len(events) != 2 {
+		t.Errorf("expected 2 audit events, but got %d", len(events))
+	}
+
+	// import a Quay whitelist on top; policies that already exist are not duplicated
+	quayPolicies := []assert.JSONObject{
+		{
+			"match_repository":       ".*",
+			"match_vulnerability_id": "CVE-2024-0003",
+			"action":                 assert.JSONObject{"ignore": true, "assessment": "imported from Quay CVE whitelist: not reachable in our usage"},
+		},
+		{
+			"match_repository":       "library/alpine",
+			"match_vulnerability_id": "CVE-2024-0004",
+			"action":                 assert.JSONObject{"ignore": true, "assessment": "imported from Quay CVE whitelist"},
+		},
+		{
+			"match_repository":       "ubuntu",
+			"match_vulnerability_id": "CVE-2024-0005",
+			"action":                 assert.JSONObject{"ignore": true, "assessment": "imported from Quay CVE whitelist: fixed by our base image"},
+		},
+	}
+	quayBody := assert.JSONObject{
+		"generalwhitelist": assert.JSONObject{"CVE-2024-0003": "not reachable in our usage"},
+		"images": assert.JSONObject{
+			"first/library/alpine": assert.JSONObject{"CVE-2024-0004": ""},
+			"ubuntu":               assert.JSONObject{"CVE-2024-0005": "fixed by our base image"},
+		},
+	}
+	for range 2 {
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         path + "?format=quay",
+			Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+			Body:         quayBody,
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.JSONObject{"policies": append(slices.Clone(harborPolicies), quayPolicies...)},
+		}.Check(t, s.Handler)
+	}
+	if events := s.Auditor.RecordedEvents(); len(events) != 3 {
+		t.Errorf("expected 3 audit events, but got %d", len(events))
+	}
+
+	// the imported policies are visible through the regular API
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/first/security_scan_policies",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"policies": append(slices.Clone(harborPolicies), quayPolicies...)},
+	}.Check(t, s.Handler)
+}
+
+func TestAccountIssues(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "first", AuthTenantID: "tenant1", ExternalPeerURL: "registry.example.com"}),
+	)
+	h := s.Handler
+	for _, repoName := range []string{"bar", "baz", "foo"} {
+		mustInsert(t, s.DB, &models.Repository{AccountName: "first", Name: repoName})
+	}
+	expectedAccount := assert.JSONObject{
+		"name":           "first",
+		"auth_tenant_id": "tenant1",
+		"metadata":       nil,
+		"rbac_policies":  []assert.JSONObject{},
+		"replication": assert.JSONObject{
+			"strategy": "from_external_on_first_use",
+			"upstream": assert.JSONObject{"url": "registry.example.com"},
+		},
+	}
+
+	// without any errors in background processing, there are no issues
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/first",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"account": expectedAccount},
+	}.Check(t, h)
+
+	// errors from janitor jobs are reported as issues, and the account is shown as degraded
+	mustExec(t, s.DB, `UPDATE repos SET manifest_sync_error_message = $1 WHERE name = $2`, "upstream is on fire", "foo")
+	mustExec(t, s.DB, `UPDATE repos SET gc_error_message = $1 WHERE name IN ($2, $3)`, "cannot load GC policies", "bar", "baz")
+	expectedAccount["state"] = "degraded"
+	expectedAccount["issues"] = []assert.JSONObject{
+		{
+			"type":        "manifest_sync_failing",
+			"reason":      `manifest sync failed in repository "foo": upstream is on fire`,
+			"remediation": "Check that the upstream registry is reachable and that the replication credentials of this account are valid. The manifest sync is retried automatically.",
+		},
+		{
+			"type":        "garbage_collection_failing",
+			"reason":      `garbage collection failed in repository "bar": cannot load GC policies (and in 1 more repositories)`,
+			"remediation": "Check the GC policies of this account. Garbage collection is retried automatically.",
+		},
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/first",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"account": expectedAccount},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"accounts": []assert.JSONObject{expectedAccount}},
+	}.Check(t, h)
+
+	// issues cannot be set through the API
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/first",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: assert.JSONObject{"account": assert.JSONObject{
+			"auth_tenant_id": "tenant1",
+			"issues":         []assert.JSONObject{{"type": "replication_paused"}},
+		}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("malformed attribute \"account.issues\" in request body is not allowed here\n"),
+	}.Check(t, h)
+}
+
+func TestAccountAndRepositoryNameValidation(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithReservedNames(
+			[]*regexp.Regexp{regexp.MustCompile(`^(?:admin|.*acme.*)$`)},
+			[]*regexp.Regexp{regexp.MustCompile(`^(?:library/.*)$`)},
+		),
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	s.NVD.RejectedNames["team-x"] = `account names must start with the prefix "org-"`
+	s.NVD.RejectedNames["test1/tmp"] = `repository names must have the format "<team>/<image>"`
+
+	// new accounts cannot use reserved names...
+	for _, name := range []string{"admin", "my-acme-images"} {
+		assert.HTTPRequest{
+			Method:       "PUT",
+			Path:         "/keppel/v1/accounts/" + name,
+			Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+			Body:         assert.JSONObject{"account": assert.JSONObject{"auth_tenant_id": "tenant1"}},
+			ExpectStatus: http.StatusUnprocessableEntity,
+			ExpectBody:   assert.StringData(fmt.Sprintf("account name %q is reserved by the operator of this registry (matches reserved pattern \"^(?:admin|.*acme.*)$\")\n", name)),
+		}.Check(t, h)
+	}
+
+	// ...or names rejected by the NameValidationDriver
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/team-x",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         assert.JSONObject{"account": assert.JSONObject{"auth_tenant_id": "tenant1"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("account name \"team-x\" is not acceptable: account names must start with the prefix \"org-\"\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/org-team-x",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         assert.JSONObject{"account": assert.JSONObject{"auth_tenant_id": "tenant1"}},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+
+	// existing accounts are not affected by naming conventions
+	s.NVD.RejectedNames["test1"] = `account names must start with the prefix "org-"`
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         assert.JSONObject{"account": assert.JSONObject{"auth_tenant_id": "tenant1"}},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+
+	// the same applies to new repositories
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/library/alpine",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("repository name \"library/alpine\" is reserved by the operator of this registry (matches reserved pattern \"^(?:library/.*)$\")\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/tmp",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("repository name \"tmp\" is not acceptable: repository names must have the format \"<team>/<image>\"\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/team-a/tmp",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{}},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+}
+
+func TestAccountMetadata(t *testing.T) {
+	schema, err := keppel.ParseAccountMetadataSchema([]byte(`{"fields":[
+		{"name": "cost_center", "required": true, "pattern": "[0-9]{6}"},
+		{"name": "data_classification", "required": true, "allowed_values": ["public", "internal", "confidential"]},
+		{"name": "owner_team"}
+	]}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccountMetadataSchema(schema),
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	// metadata is validated against the schema when creating an account...
+	testCases := []struct {
+		Metadata     assert.JSONObject
+		ErrorMessage string
+	}{
+		{
+			Metadata:     nil,
+			ErrorMessage: `missing value for required account metadata field "cost_center"`,
+		},
+		{
+			Metadata:     assert.JSONObject{"cost_center": "123456", "data_classification": ""},
+			ErrorMessage: `missing value for required account metadata field "data_classification"`,
+		},
+		{
+			Metadata:     assert.JSONObject{"cost_center": "12345a", "data_classification": "public"},
+			ErrorMessage: `value for account metadata field "cost_center" does not match the pattern "[0-9]{6}"`,
+		},
+		{
+			Metadata:     assert.JSONObject{"cost_center": "123456", "data_classification": "secret"},
+			ErrorMessage: `value for account metadata field "data_classification" must be one of: ["public" "internal" "confidential"]`,
+		},
+		{
+			Metadata:     assert.JSONObject{"cost_center": "123456", "data_classification": "public", "favorite_color": "blue"},
+			ErrorMessage: `unknown account metadata field: "favorite_color"`,
+		},
+	}
+	for _, tc := range testCases {
+		account := assert.JSONObject{"auth_tenant_id": "tenant1"}
+		if tc.Metadata != nil {
+			account["metadata"] = tc.Metadata
+		}
+		assert.HTTPRequest{
+			Method:       "PUT",
+			Path:         "/keppel/v1/accounts/test2",
+			Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+			Body:         assert.JSONObject{"account": account},
+			ExpectStatus: http.StatusUnprocessableEntity,
+			ExpectBody:   assert.StringData(tc.ErrorMessage + "\n"),
+		}.Check(t, h)
+	}
+
+	// existing accounts that predate a required field can still be updated as long as their metadata is not changed...
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         assert.JSONObject{"account": assert.JSONObject{"auth_tenant_id": "tenant1"}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"account": assert.JSONObject{
+			"name":           "test1",
+			"auth_tenant_id": "tenant1",
+			"rbac_policies":  []assert.JSONObject{},
+			"metadata":       nil,
+		}},
+	}.Check(t, h)
+
+	// ...but when their metadata is changed, the required fields must be filled in
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         assert.JSONObject{"account": assert.JSONObject{"auth_tenant_id": "tenant1", "metadata": assert.JSONObject{"owner_team": "team-b"}}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("missing value for required account metadata field \"cost_center\"\n"),
+	}.Check(t, h)
+
+	// happy case: valid metadata is stored and shown in the account listing
+	metadata := assert.JSONObject{"cost_center": "123456", "data_classification": "internal", "owner_team": "team-a"}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test2",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         assert.JSONObject{"account": assert.JSONObject{"auth_tenant_id": "tenant1", "metadata": metadata}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"account": assert.JSONObject{
+			"name":           "test2",
+			"auth_tenant_id": "tenant1",
+			"rbac_policies":  []assert.JSONObject{},
+			"metadata":       metadata,
+		}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"accounts": []assert.JSONObject{
+			{
+				"name":           "test1",
+				"auth_tenant_id": "tenant1",
+				"rbac_policies":  []assert.JSONObject{},
+				"metadata":       nil,
+			},
+			{
+				"name":           "test2",
+				"auth_tenant_id": "tenant1",
+				"rbac_policies":  []assert.JSONObject{},
+				"metadata":       metadata,
+			},
+		}},
+	}.Check(t, h)
+}
+
+func TestExternalReplicaUpstreamHostPolicy(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithExternalUpstreamHosts(keppel.ExternalUpstreamHostPolicy{
+			AllowedHosts: []*regexp.Regexp{regexp.MustCompile(`^(?:.*\.example\.org)$`)},
+			BlockedHosts: []*regexp.Regexp{regexp.MustCompile(`^(?:untrusted\.example\.org)$`)},
+		}),
+		// this account was created before the operator blocked its upstream
+		test.WithAccount(models.Account{Name: "second", AuthTenantID: "tenant1", ExternalPeerURL: "untrusted.example.org/library"}),
+	)
+	h := s.Handler
+
+	makeRequestBody := func(upstreamURL string) assert.JSONObject {
+		return assert.JSONObject{
+			"account": assert.JSONObject{
+				"auth_tenant_id": "tenant1",
+				"replication": assert.JSONObject{
+					"strategy": "from_external_on_first_use",
+					"upstream": assert.JSONObject{"url": upstreamURL},
+				},
+			},
+		}
+	}
+
+	// upstreams that are not allowed are rejected on account creation
+	// (ports and case do not allow to sidestep the policy)
+	for _, upstreamURL := range []string{"registry.example.com", "untrusted.example.org/library", "UNTRUSTED.example.org:443"} {
+		hostname := keppel.ExternalUpstreamHostNameOf(upstreamURL)
+		assert.HTTPRequest{
+			Method:       "PUT",
+			Path:         "/keppel/v1/accounts/first",
+			Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+			Body:         makeRequestBody(upstreamURL),
+			ExpectStatus: http.StatusUnprocessableEntity,
+			ExpectBody:   assert.StringData(fmt.Sprintf("replication from upstream registry %q is not allowed by the operator of this Keppel\n", hostname)),
+		}.Check(t, h)
+	}
+
+	// allowed upstreams are accepted
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/first",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         makeRequestBody("registry.example.org/library"),
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+
+	// existing accounts with a blocked upstream can still be updated
+	// (replications will fail, but the account can be reconfigured or deleted by its owners)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/second",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         makeRequestBody("untrusted.example.org/library"),
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+}
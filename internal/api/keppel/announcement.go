@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"net/http"
+
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func (a *API) handleGetAnnouncement(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/announcement")
+	// no authentication required: announcements need to be visible to everyone,
+	// including users whose credentials are not working because of the very
+	// maintenance that is being announced
+
+	announcement, err := keppel.FindCurrentAnnouncement(a.db, a.timeNow())
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if announcement == nil {
+		http.Error(w, "no announcement", http.StatusNotFound)
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"announcement": keppel.RenderAnnouncement(*announcement)})
+}
+
+func (a *API) handlePutAnnouncement(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/announcement")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return
+	}
+
+	var req struct {
+		Announcement keppel.Announcement `json:"announcement"`
+	}
+	ok := decodeJSONRequestBody(w, r.Body, &req)
+	if !ok {
+		return
+	}
+	announcement := models.Announcement{
+		CreatedAt: a.timeNow(),
+		CreatedBy: authz.UserIdentity.UserName(),
+	}
+	err := req.Announcement.ApplyToModel(&announcement)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// there can only be one announcement at a time, so replace any existing ones
+	tx, err := a.db.Begin()
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+	_, err = tx.Exec(`DELETE FROM announcements`)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	err = tx.Insert(&announcement)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	err = tx.Commit()
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     cadf.UpdateAction,
+			Target:     AuditAnnouncement{Announcement: announcement},
+		})
+	}
+
+	respondwith.JSON(w, http.StatusOK, map[string]any{"announcement": keppel.RenderAnnouncement(announcement)})
+}
+
+func (a *API) handleDeleteAnnouncement(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/announcement")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return
+	}
+
+	announcement, err := keppel.FindCurrentAnnouncement(a.db, a.timeNow())
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if announcement == nil {
+		http.Error(w, "no announcement", http.StatusNotFound)
+		return
+	}
+	_, err = a.db.Exec(`DELETE FROM announcements`)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusNoContent,
+			Action:     cadf.DeleteAction,
+			Target:     AuditAnnouncement{Announcement: *announcement},
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
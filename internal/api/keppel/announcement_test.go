@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestAnnouncementAPI(t *testing.T) {
+	s := test.NewSetup(t, test.WithKeppelAPI)
+	h := s.Handler
+
+	// without an announcement, GET returns 404 (even for anonymous users)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/announcement",
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no announcement\n"),
+	}.Check(t, h)
+
+	// setting an announcement requires cluster-admin permission
+	announcement := assert.JSONObject{
+		"message":   "Scheduled maintenance on Monday",
+		"severity":  "warning",
+		"starts_at": 3600,
+		"ends_at":   7200,
+	}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/announcement",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         assert.JSONObject{"announcement": announcement},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// invalid announcements are rejected
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/announcement",
+		Header: map[string]string{"X-Test-Perms": "admin:"},
+		Body: assert.JSONObject{"announcement": assert.JSONObject{
+			"message":  "Scheduled maintenance on Monday",
+			"severity": "apocalyptic",
+		}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("invalid announcement severity: \"apocalyptic\"\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/announcement",
+		Header: map[string]string{"X-Test-Perms": "admin:"},
+		Body: assert.JSONObject{"announcement": assert.JSONObject{
+			"message":   "Scheduled maintenance on Monday",
+			"severity":  "info",
+			"starts_at": 7200,
+			"ends_at":   3600,
+		}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("announcement may not end before it starts\n"),
+	}.Check(t, h)
+
+	// happy path
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/announcement",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{"announcement": announcement},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"announcement": announcement},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/announcement",
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"announcement": announcement},
+	}.Check(t, h)
+
+	// once the time window has passed, the announcement is not shown anymore
+	s.Clock.StepBy(2 * time.Hour)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/announcement",
+		ExpectStatus: http.StatusNotFound,
+	}.Check(t, h)
+
+	// announcements without end are shown until deleted
+	announcement = assert.JSONObject{
+		"message":  "Pushing is currently slow, we are investigating.",
+		"severity": "critical",
+	}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/announcement",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{"announcement": announcement},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"announcement": announcement},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/announcement",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/announcement",
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"announcement": announcement},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/announcement",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/announcement",
+		ExpectStatus: http.StatusNotFound,
+	}.Check(t, h)
+}
@@ -32,16 +32,19 @@ type API struct {
 	fd         keppel.FederationDriver
 	sd         keppel.StorageDriver
 	icd        keppel.InboundCacheDriver
+	secd       keppel.SecretsDriver
+	nvd        keppel.NameValidationDriver // may be nil
 	db         *keppel.DB
 	auditor    audittools.Auditor
-	rle        *keppel.RateLimitEngine // may be nil
+	rle        *keppel.RateLimitEngine         // may be nil
+	rpur       *keppel.RBACPolicyUsageRecorder // may be nil
 	// non-pure functions that can be replaced by deterministic doubles for unit tests
 	timeNow func() time.Time
 }
 
 // NewAPI constructs a new API instance.
-func NewAPI(cfg keppel.Configuration, ad keppel.AuthDriver, fd keppel.FederationDriver, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, db *keppel.DB, auditor audittools.Auditor, rle *keppel.RateLimitEngine) *API {
-	return &API{cfg, ad, fd, sd, icd, db, auditor, rle, time.Now}
+func NewAPI(cfg keppel.Configuration, ad keppel.AuthDriver, fd keppel.FederationDriver, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, secd keppel.SecretsDriver, nvd keppel.NameValidationDriver, db *keppel.DB, auditor audittools.Auditor, rle *keppel.RateLimitEngine, rpur *keppel.RBACPolicyUsageRecorder) *API {
+	return &API{cfg, ad, fd, sd, icd, secd, nvd, db, auditor, rle, rpur, time.Now}
 }
 
 // OverrideTimeNow replaces time.Now with a test double.
@@ -53,6 +56,7 @@ func (a *API) OverrideTimeNow(timeNow func() time.Time) *API {
 // AddTo implements the api.API interface.
 func (a *API) AddTo(r *mux.Router) {
 	r.Methods("GET").Path("/keppel/v1").HandlerFunc(a.handleGetAPIInfo)
+	r.Methods("GET").Path("/keppel/v1/info").HandlerFunc(a.handleGetServerInfo)
 
 	//NOTE: Keppel account names are severely restricted because we used to
 	// derive Postgres database names from them.
@@ -61,19 +65,81 @@ func (a *API) AddTo(r *mux.Router) {
 	r.Methods("PUT").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}").HandlerFunc(a.handlePutAccount)
 	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}").HandlerFunc(a.handleDeleteAccount)
 	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/sublease").HandlerFunc(a.handlePostAccountSublease)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/replication_health").HandlerFunc(a.handleGetReplicationHealth)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/replication_health/resume").HandlerFunc(a.handlePostResumeReplication)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/blob_sweep").HandlerFunc(a.handleGetBlobSweep)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/blob_sweep").HandlerFunc(a.handlePostBlobSweep)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/pull_terms").HandlerFunc(a.handleGetPullTerms)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/pull_terms/acceptance").HandlerFunc(a.handlePostPullTermsAcceptance)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/namespaces").HandlerFunc(a.handleGetNamespaces)
+	r.Methods("PUT").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/namespaces/{prefix:.+}").HandlerFunc(a.handlePutNamespace)
+	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/namespaces/{prefix:.+}").HandlerFunc(a.handleDeleteNamespace)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/quarantine").HandlerFunc(a.handleGetQuarantinedManifests)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/snapshots").HandlerFunc(a.handleGetSnapshots)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/snapshots").HandlerFunc(a.handlePostSnapshot)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/snapshots/{id:[0-9]+}").HandlerFunc(a.handleGetSnapshot)
+	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/snapshots/{id:[0-9]+}").HandlerFunc(a.handleDeleteSnapshot)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/snapshots/{id:[0-9]+}/restore").HandlerFunc(a.handlePostRestoreSnapshot)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/pending_changes").HandlerFunc(a.handleGetPendingChanges)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/pending_changes/{id:[0-9]+}/approve").HandlerFunc(a.handlePostApprovePendingChange)
+	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/pending_changes/{id:[0-9]+}").HandlerFunc(a.handleDeletePendingChange)
 	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/security_scan_policies").HandlerFunc(a.handleGetSecurityScanPolicies)
 	r.Methods("PUT").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/security_scan_policies").HandlerFunc(a.handlePutSecurityScanPolicies)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/security_scan_policies/import").HandlerFunc(a.handlePostSecurityScanPolicyImport)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/tag_watches").HandlerFunc(a.handleGetTagWatches)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/tag_watches").HandlerFunc(a.handlePostTagWatch)
+	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/tag_watches/{id:[0-9]+}").HandlerFunc(a.handleDeleteTagWatch)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/webhooks").HandlerFunc(a.handleGetWebhooks)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/webhooks").HandlerFunc(a.handlePostWebhook)
+	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/webhooks/{id:[0-9]+}").HandlerFunc(a.handleDeleteWebhook)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/credential_report").HandlerFunc(a.handleGetCredentialReport)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/recompression_savings").HandlerFunc(a.handleGetRecompressionSavings)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/export").HandlerFunc(a.handleGetAccountExport)
 
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/revalidate").HandlerFunc(a.handleGetRevalidateAccount)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/revalidate").HandlerFunc(a.handlePostRevalidateAccount)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_revalidate").HandlerFunc(a.handlePostRevalidateRepository)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_pull_secret").HandlerFunc(a.handlePostPullSecret)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_deletion_impact").HandlerFunc(a.handleGetRepositoryDeletionImpact)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_robot_credentials").HandlerFunc(a.handleGetRobotCredentials)
+	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_robot_credentials/{user_name}").HandlerFunc(a.handleDeleteRobotCredential)
 	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests").HandlerFunc(a.handleGetManifests)
 	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}").HandlerFunc(a.handleDeleteManifest)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/quarantine").HandlerFunc(a.handlePostQuarantineManifest)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/release").HandlerFunc(a.handlePostReleaseManifest)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/promote").HandlerFunc(a.handlePostPromoteManifest)
+	r.Methods("PUT").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/keppel_labels").HandlerFunc(a.handlePutManifestKeppelLabels)
 	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/trivy_report").HandlerFunc(a.handleGetTrivyReport)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/deletion_impact").HandlerFunc(a.handleGetManifestDeletionImpact)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_tags").HandlerFunc(a.handleGetTags)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_replications/{id}").HandlerFunc(a.handleGetBlobReplication)
 	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_tags/{tag_name}").HandlerFunc(a.handleDeleteTag)
 
 	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories").HandlerFunc(a.handleGetRepositories)
+	r.Methods("PUT").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}").HandlerFunc(a.handlePutRepository)
 	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}").HandlerFunc(a.handleDeleteRepository)
 
+	r.Methods("GET").Path("/keppel/v1/account_requests").HandlerFunc(a.handleGetAccountRequests)
+	r.Methods("POST").Path("/keppel/v1/account_requests").HandlerFunc(a.handlePostAccountRequest)
+	r.Methods("POST").Path("/keppel/v1/account_requests/{id:[0-9]+}/approve").HandlerFunc(a.handlePostApproveAccountRequest)
+	r.Methods("POST").Path("/keppel/v1/account_requests/{id:[0-9]+}/deny").HandlerFunc(a.handlePostDenyAccountRequest)
+
+	r.Methods("GET").Path("/keppel/v1/announcement").HandlerFunc(a.handleGetAnnouncement)
+	r.Methods("PUT").Path("/keppel/v1/announcement").HandlerFunc(a.handlePutAnnouncement)
+	r.Methods("DELETE").Path("/keppel/v1/announcement").HandlerFunc(a.handleDeleteAnnouncement)
+
+	r.Methods("GET").Path("/keppel/v1/migrations").HandlerFunc(a.handleGetMigrations)
+	r.Methods("GET").Path("/keppel/v1/circuit_breakers").HandlerFunc(a.handleGetCircuitBreakers)
+	r.Methods("DELETE").Path("/keppel/v1/circuit_breakers/{hostname}").HandlerFunc(a.handleDeleteCircuitBreaker)
+	r.Methods("GET").Path("/keppel/v1/deprecations").HandlerFunc(a.handleGetDeprecations)
+	r.Methods("GET").Path("/keppel/v1/pull_attestations").HandlerFunc(a.handleGetPullAttestations)
+
 	r.Methods("GET").Path("/keppel/v1/peers").HandlerFunc(a.handleGetPeers)
 
+	r.Methods("GET").Path("/keppel/v1/rate_limit_exemptions").HandlerFunc(a.handleGetRateLimitExemptions)
+	r.Methods("POST").Path("/keppel/v1/rate_limit_exemptions").HandlerFunc(a.handlePostRateLimitExemption)
+	r.Methods("DELETE").Path("/keppel/v1/rate_limit_exemptions/{id:[0-9]+}").HandlerFunc(a.handleDeleteRateLimitExemption)
+
 	r.Methods("GET").Path("/keppel/v1/quotas/{auth_tenant_id}").HandlerFunc(a.handleGetQuotas)
 	r.Methods("PUT").Path("/keppel/v1/quotas/{auth_tenant_id}").HandlerFunc(a.handlePutQuotas)
 
@@ -86,7 +152,7 @@ func (a *API) AddTo(r *mux.Router) {
 }
 
 func (a *API) processor() *processor.Processor {
-	return processor.New(a.cfg, a.db, a.sd, a.icd, a.auditor, a.fd, a.timeNow)
+	return processor.New(a.cfg, a.db, a.sd, a.icd, a.secd, a.auditor, a.fd, a.nvd, a.timeNow)
 }
 
 func (a *API) handleGetAPIInfo(w http.ResponseWriter, r *http.Request) {
@@ -149,11 +215,24 @@ func (a *API) authenticateRequest(w http.ResponseWriter, r *http.Request, ss aut
 		Scopes:               ss,
 		CorrectlyReturn403:   true,
 		PartialAccessAllowed: r.URL.Path == "/keppel/v1/accounts",
+		RBACPolicyUsage:      a.rpur,
+		TimeNow:              a.timeNow,
 	}.Authorize(r.Context(), a.cfg, a.authDriver, a.db)
 	if rerr != nil {
 		rerr.WriteAsTextTo(w)
 		return nil
 	}
+	// narrowed tokens are only intended for pulling specific digests via the registry API
+	if authz.DigestRestriction != nil {
+		http.Error(w, "token is restricted to pulling specific digests", http.StatusForbidden)
+		return nil
+	}
+	// access that is restricted to manifests with certain Keppel labels is only
+	// enforced by the registry API, so we cannot allow it here
+	if authz.KeppelLabelRestrictions != nil {
+		http.Error(w, "access to this repository is restricted to pulling manifests with certain Keppel labels", http.StatusForbidden)
+		return nil
+	}
 	return authz
 }
 
@@ -199,6 +278,10 @@ func decodeJSONRequestBody(w http.ResponseWriter, body io.Reader, target any) (o
 	decoder := json.NewDecoder(body)
 	decoder.DisallowUnknownFields()
 	err := decoder.Decode(&target)
+	if rerr := keppel.AsRequestBodyError(err); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return false
+	}
 	if err != nil {
 		http.Error(w, "request body is not valid JSON: "+err.Error(), http.StatusBadRequest)
 		return false
@@ -247,6 +330,6 @@ func (q paginatedQuery) Prepare() (modifiedSQLQuery string, modifiedBindValues [
 		query = strings.Replace(query, `$CONDITION`, `TRUE`, 1)
 		return query, q.BindValues, limit, nil
 	}
-	query = strings.Replace(query, `$CONDITION`, q.MarkerField+` > $2`, 1)
+	query = strings.Replace(query, `$CONDITION`, fmt.Sprintf(`%s > $%d`, q.MarkerField, len(q.BindValues)+1), 1)
 	return query, append(q.BindValues, marker), limit, nil
 }
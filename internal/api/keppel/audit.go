@@ -4,6 +4,9 @@
 package keppelv1
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/sapcc/go-api-declarations/cadf"
 	"github.com/sapcc/go-bits/must"
 
@@ -28,3 +31,190 @@ func (a AuditSecurityScanPolicy) Render() cadf.Resource {
 		},
 	}
 }
+
+// AuditAnnouncement is an audittools.Target.
+type AuditAnnouncement struct {
+	Announcement models.Announcement
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditAnnouncement) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI: "docker-registry/announcement",
+		ID:      strconv.FormatInt(a.Announcement.ID, 10),
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("payload", keppel.RenderAnnouncement(a.Announcement))),
+		},
+	}
+}
+
+// AuditCircuitBreaker is an audittools.Target.
+type AuditCircuitBreaker struct {
+	CircuitBreaker CircuitBreaker
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditCircuitBreaker) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI: "docker-registry/upstream-circuit-breaker",
+		ID:      a.CircuitBreaker.HostName,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("payload", a.CircuitBreaker)),
+		},
+	}
+}
+
+// AuditAccountRequest is an audittools.Target.
+type AuditAccountRequest struct {
+	AccountRequest keppel.AccountRequest
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditAccountRequest) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI:   "docker-registry/account-request",
+		ID:        strconv.FormatInt(a.AccountRequest.ID, 10),
+		ProjectID: a.AccountRequest.Account.AuthTenantID,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("payload", a.AccountRequest)),
+		},
+	}
+}
+
+// AuditRepositoryNamespace is an audittools.Target.
+type AuditRepositoryNamespace struct {
+	Account   models.Account
+	Namespace keppel.RepositoryNamespace
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditRepositoryNamespace) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI:   "docker-registry/account/namespace",
+		ID:        fmt.Sprintf("%s/%s", a.Account.Name, a.Namespace.Prefix),
+		ProjectID: a.Account.AuthTenantID,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("payload", a.Namespace)),
+		},
+	}
+}
+
+// AuditRepository is an audittools.Target.
+type AuditRepository struct {
+	Account    models.Account
+	Repository Repository
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditRepository) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI:   "docker-registry/account/repository",
+		ID:        fmt.Sprintf("%s/%s", a.Account.Name, a.Repository.Name),
+		ProjectID: a.Account.AuthTenantID,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("payload", a.Repository)),
+		},
+	}
+}
+
+// AuditRateLimitExemption is an audittools.Target.
+type AuditRateLimitExemption struct {
+	Exemption models.RateLimitExemption
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditRateLimitExemption) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI: "docker-registry/rate-limit-exemption",
+		ID:      strconv.FormatInt(a.Exemption.ID, 10),
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("payload", keppel.RenderRateLimitExemption(a.Exemption))),
+		},
+	}
+}
+
+// AuditTagWatch is an audittools.Target.
+type AuditTagWatch struct {
+	Account models.Account
+	Watch   models.TagWatch
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditTagWatch) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI:   "docker-registry/account/repository/tag-watch",
+		Name:      fmt.Sprintf("%s/%s", a.Account.Name, a.Watch.RepoName),
+		ID:        strconv.FormatInt(a.Watch.ID, 10),
+		ProjectID: a.Account.AuthTenantID,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("payload", keppel.RenderTagWatch(a.Watch))),
+		},
+	}
+}
+
+// AuditWebhook is an audittools.Target.
+type AuditWebhook struct {
+	Account models.Account
+	Webhook models.Webhook
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditWebhook) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI:   "docker-registry/account/webhook",
+		Name:      string(a.Account.Name),
+		ID:        strconv.FormatInt(a.Webhook.ID, 10),
+		ProjectID: a.Account.AuthTenantID,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("payload", keppel.RenderWebhook(a.Webhook))),
+		},
+	}
+}
+
+// AuditRobotCredential is an audittools.Target.
+type AuditRobotCredential struct {
+	Account    models.Account
+	Repository models.Repository
+	Credential models.RobotCredential
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditRobotCredential) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI:   "docker-registry/account/repository/robot-credential",
+		Name:      fmt.Sprintf("%s/%s", a.Account.Name, a.Repository.Name),
+		ID:        a.Credential.UserName,
+		ProjectID: a.Account.AuthTenantID,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("payload", map[string]any{
+				"user_name":  a.Credential.UserName,
+				"created_at": a.Credential.CreatedAt.Unix(),
+				"created_by": a.Credential.CreatedBy,
+				"expires_at": a.Credential.ExpiresAt.Unix(),
+			})),
+		},
+	}
+}
+
+// AuditPullTermsAcceptance is an audittools.Target.
+type AuditPullTermsAcceptance struct {
+	Account    models.Account
+	Acceptance models.PullTermsAcceptance
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditPullTermsAcceptance) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI:   "docker-registry/account/pull-terms-acceptance",
+		ID:        fmt.Sprintf("%s/%s", a.Account.Name, a.Acceptance.TermsVersion),
+		ProjectID: a.Account.AuthTenantID,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("payload", map[string]any{
+				"user_name":   a.Acceptance.UserName,
+				"version":     a.Acceptance.TermsVersion,
+				"url":         a.Account.PullTermsURL,
+				"accepted_at": a.Acceptance.AcceptedAt.Unix(),
+			})),
+		},
+	}
+}
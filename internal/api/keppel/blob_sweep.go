@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"net/http"
+
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+func (a *API) handleGetBlobSweep(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/blob_sweep")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	status, err := keppel.FindBlobSweepStatus(a.db, *account)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"blob_sweep": status})
+}
+
+func (a *API) handlePostBlobSweep(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/blob_sweep")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanDeleteFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	// Blobs are only swept once they are not mounted in any repo anymore, so the
+	// blob mount sweeps in all repos of this account need to be moved up as well.
+	// (NULL puts these at the front of the respective job queues.)
+	_, err := a.db.Exec(`UPDATE repos SET next_blob_mount_sweep_at = NULL WHERE account_name = $1`, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	_, err = a.db.Exec(`UPDATE accounts SET next_blob_sweep_at = NULL WHERE name = $1`, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	account.NextBlobSweepedAt = nil
+
+	status, err := keppel.FindBlobSweepStatus(a.db, *account)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusAccepted, map[string]any{"blob_sweep": status})
+}
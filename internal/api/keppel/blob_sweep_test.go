@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestBlobSweep(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+	repo, err := keppel.FindRepository(s.DB, "foo", "test1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// setup: one blob that is referenced by a manifest, one blob that is still
+	// mounted but not referenced anymore, one blob that is not mounted anymore,
+	// and one unbacked blob that does not occupy any storage
+	blobs := make([]models.Blob, 4)
+	for idx := range blobs {
+		blobs[idx] = models.Blob{
+			AccountName:      "test1",
+			Digest:           test.DeterministicDummyDigest(idx + 1),
+			SizeBytes:        uint64(1000 * (idx + 1)), //nolint:gosec // construction guarantees that value is positive
+			StorageID:        test.DeterministicDummyDigest(idx + 11).Encoded(),
+			PushedAt:         time.Unix(1000, 0),
+			NextValidationAt: time.Unix(1000, 0).Add(models.BlobValidationInterval),
+		}
+		if idx == 3 {
+			blobs[idx].StorageID = ""
+		}
+		mustInsert(t, s.DB, &blobs[idx])
+		if idx < 2 {
+			err := keppel.MountBlobIntoRepo(s.DB, blobs[idx], *repo)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+	}
+	manifestDigest := test.DeterministicDummyDigest(100)
+	mustInsert(t, s.DB, &models.Manifest{
+		RepositoryID:     repo.ID,
+		Digest:           manifestDigest,
+		MediaType:        "application/vnd.oci.image.manifest.v1+json",
+		SizeBytes:        500,
+		PushedAt:         time.Unix(1000, 0),
+		NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+	})
+	mustExec(t, s.DB, `INSERT INTO manifest_blob_refs (repo_id, digest, blob_id) VALUES ($1, $2, $3)`,
+		repo.ID, manifestDigest, blobs[0].ID)
+	mustExec(t, s.DB, `UPDATE accounts SET next_blob_sweep_at = $1`, time.Unix(5000, 0))
+	mustExec(t, s.DB, `UPDATE repos SET next_blob_mount_sweep_at = $1`, time.Unix(5000, 0))
+
+	// GET reports the blobs that are not referenced by any manifest
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/blob_sweep",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"blob_sweep": assert.JSONObject{
+			"unreferenced_blob_count": 2,
+			"unreferenced_blob_bytes": 5000,
+			"next_sweep_at":           5000,
+		}},
+	}.Check(t, h)
+
+	// POST requires delete permission
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/blob_sweep",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// POST moves up the next sweeps
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/blob_sweep",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,delete:tenant1"},
+		ExpectStatus: http.StatusAccepted,
+		ExpectBody: assert.JSONObject{"blob_sweep": assert.JSONObject{
+			"unreferenced_blob_count": 2,
+			"unreferenced_blob_bytes": 5000,
+		}},
+	}.Check(t, h)
+	count, err := s.DB.SelectInt(`SELECT COUNT(*) FROM repos WHERE next_blob_mount_sweep_at IS NOT NULL`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "repos with scheduled blob mount sweep", count, int64(0))
+	count, err = s.DB.SelectInt(`SELECT COUNT(*) FROM accounts WHERE next_blob_sweep_at IS NOT NULL`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "accounts with scheduled blob sweep", count, int64(0))
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// CircuitBreaker is the API representation of a models.UpstreamCircuitBreaker.
+type CircuitBreaker struct {
+	HostName            string `json:"hostname"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	OpenUntil           *int64 `json:"open_until,omitempty"`
+}
+
+func renderCircuitBreaker(cb models.UpstreamCircuitBreaker) CircuitBreaker {
+	return CircuitBreaker{
+		HostName:            cb.HostName,
+		ConsecutiveFailures: cb.ConsecutiveFailures,
+		OpenUntil:           keppel.MaybeTimeToUnix(cb.OpenUntil),
+	}
+}
+
+func (a *API) handleGetCircuitBreakers(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/circuit_breakers")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return
+	}
+
+	var dbBreakers []models.UpstreamCircuitBreaker
+	_, err := a.db.Select(&dbBreakers, `SELECT * FROM upstream_circuit_breakers ORDER BY hostname`)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	breakers := make([]CircuitBreaker, len(dbBreakers))
+	for idx, cb := range dbBreakers {
+		breakers[idx] = renderCircuitBreaker(cb)
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"circuit_breakers": breakers})
+}
+
+func (a *API) handleDeleteCircuitBreaker(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/circuit_breakers/:hostname")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return
+	}
+
+	var cb models.UpstreamCircuitBreaker
+	err := a.db.SelectOne(&cb, `SELECT * FROM upstream_circuit_breakers WHERE hostname = $1`, mux.Vars(r)["hostname"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such circuit breaker", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	_, err = a.db.Delete(&cb)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusNoContent,
+			Action:     cadf.DeleteAction,
+			Target:     AuditCircuitBreaker{CircuitBreaker: renderCircuitBreaker(cb)},
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestCircuitBreakersAPI(t *testing.T) {
+	s := test.NewSetup(t, test.WithKeppelAPI)
+	h := s.Handler
+
+	// without failing upstreams, there are no circuit breakers
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/circuit_breakers",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"circuit_breakers": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	mustExec(t, s.DB, `INSERT INTO upstream_circuit_breakers (hostname, consecutive_failures, open_until) VALUES ($1, $2, $3)`,
+		"registry.example.org", 12, s.Clock.Now().Add(time.Minute))
+	mustExec(t, s.DB, `INSERT INTO upstream_circuit_breakers (hostname, consecutive_failures) VALUES ($1, $2)`,
+		"registry.example.com", 2)
+
+	// listing and resetting circuit breakers requires cluster-admin permission
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/circuit_breakers",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/circuit_breakers/registry.example.org",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/circuit_breakers",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"circuit_breakers": []assert.JSONObject{
+			{"hostname": "registry.example.com", "consecutive_failures": 2},
+			{"hostname": "registry.example.org", "consecutive_failures": 12, "open_until": s.Clock.Now().Add(time.Minute).Unix()},
+		}},
+	}.Check(t, h)
+
+	// happy path for reset
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/circuit_breakers/registry.example.org",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/circuit_breakers/registry.example.org",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such circuit breaker\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/circuit_breakers",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"circuit_breakers": []assert.JSONObject{
+			{"hostname": "registry.example.com", "consecutive_failures": 2},
+		}},
+	}.Check(t, h)
+}
@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+func (a *API) handleGetCredentialReport(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/credential_report")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	unusedDays := a.cfg.CredentialReport.UnusedDays
+	if str := r.URL.Query().Get("unused_days"); str != "" {
+		value, err := strconv.ParseUint(str, 10, 16)
+		if err != nil || value == 0 {
+			http.Error(w, `query parameter "unused_days" must be a positive integer`, http.StatusBadRequest)
+			return
+		}
+		unusedDays = int(value)
+	}
+
+	report, err := keppel.BuildCredentialReport(a.db, *account, unusedDays, a.timeNow())
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"credential_report": report})
+}
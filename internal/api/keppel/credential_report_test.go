@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestCredentialReportAPI(t *testing.T) {
+	policyJSON := assert.JSONObject{"match_username": "correctusername", "permissions": []string{"pull"}}
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{
+			Name:             "test1",
+			AuthTenantID:     "tenant1",
+			RBACPoliciesJSON: `[{"match_username":"correctusername","permissions":["pull"]}]`,
+		}),
+		test.WithRepo(models.Repository{AccountName: "test1", Name: "foo"}),
+	)
+	h := s.Handler
+	// the RBAC policy matches on this username
+	s.AD.ExpectedUserName = "correctusername"
+	s.Clock.StepBy(time.Hour)
+
+	// before the policy was observed by Keppel, nothing is known about its usage
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/credential_report",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"credential_report": assert.JSONObject{
+			"unused_days": 90,
+			"rbac_policies": []assert.JSONObject{{
+				"policy":        policyJSON,
+				"first_seen_at": nil,
+				"last_used_at":  nil,
+				"unused":        false,
+			}},
+		}},
+	}.Check(t, h)
+
+	// using the policy records its usage (this is written into the DB in batches)
+	usedAt := s.Clock.Now()
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	flushRBACPolicyUsage(t, s)
+	s.Clock.StepBy(time.Hour)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/credential_report",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"credential_report": assert.JSONObject{
+			"unused_days": 90,
+			"rbac_policies": []assert.JSONObject{{
+				"policy":        policyJSON,
+				"first_seen_at": usedAt.Unix(),
+				"last_used_at":  usedAt.Unix(),
+				"unused":        false,
+			}},
+		}},
+	}.Check(t, h)
+
+	// after a long time without usage, the policy is reported as unused
+	s.Clock.StepBy(91 * 24 * time.Hour)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/credential_report",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"credential_report": assert.JSONObject{
+			"unused_days": 90,
+			"rbac_policies": []assert.JSONObject{{
+				"policy":         policyJSON,
+				"first_seen_at":  usedAt.Unix(),
+				"last_used_at":   usedAt.Unix(),
+				"unused":         true,
+				"recommendation": "This policy has not been used since 1970-01-01. Consider removing it.",
+			}},
+		}},
+	}.Check(t, h)
+
+	// the threshold can be chosen by the client
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/credential_report?unused_days=100",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"credential_report": assert.JSONObject{
+			"unused_days": 100,
+			"rbac_policies": []assert.JSONObject{{
+				"policy":        policyJSON,
+				"first_seen_at": usedAt.Unix(),
+				"last_used_at":  usedAt.Unix(),
+				"unused":        false,
+			}},
+		}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/credential_report?unused_days=0",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("query parameter \"unused_days\" must be a positive integer\n"),
+	}.Check(t, h)
+
+	// the report requires view permission
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/credential_report",
+		Header:       map[string]string{"X-Test-Perms": "pull:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+}
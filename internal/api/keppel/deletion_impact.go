@@ -0,0 +1,222 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// ManifestDeletionImpact is the API representation of everything that depends
+// on a manifest, and would therefore be affected by its deletion.
+type ManifestDeletionImpact struct {
+	Tags            []string         `json:"tags"`
+	ParentManifests []digest.Digest  `json:"parent_manifests"`
+	Referrers       []Referrer       `json:"referrers"`
+	Replicas        []string         `json:"replicas"`
+	LastPulledAt    *int64           `json:"last_pulled_at"`
+	PulledBy        []ClientPullInfo `json:"pulled_by"`
+}
+
+// RepositoryDeletionImpact is the API representation of everything that
+// depends on a repository, and would therefore be affected by its deletion.
+type RepositoryDeletionImpact struct {
+	ManifestCount uint64           `json:"manifest_count"`
+	TagCount      uint64           `json:"tag_count"`
+	UploadCount   uint64           `json:"upload_count"`
+	Replicas      []string         `json:"replicas"`
+	LastPulledAt  *int64           `json:"last_pulled_at"`
+	PulledBy      []ClientPullInfo `json:"pulled_by"`
+}
+
+// Referrer appears in type ManifestDeletionImpact.
+type Referrer struct {
+	Digest       digest.Digest `json:"digest"`
+	ArtifactType string        `json:"artifact_type,omitempty"`
+}
+
+// ClientPullInfo appears in type ManifestDeletionImpact and RepositoryDeletionImpact.
+type ClientPullInfo struct {
+	ClientID     string `json:"client_id"`
+	LastPulledAt int64  `json:"last_pulled_at"`
+	Count        uint64 `json:"count"`
+}
+
+var (
+	deletionImpactTagsQuery = sqlext.SimplifyWhitespace(`
+		SELECT name FROM tags WHERE repo_id = $1 AND digest = $2 ORDER BY name
+	`)
+	deletionImpactParentsQuery = sqlext.SimplifyWhitespace(`
+		SELECT parent_digest FROM manifest_manifest_refs WHERE repo_id = $1 AND child_digest = $2 ORDER BY parent_digest
+	`)
+	deletionImpactReferrersQuery = sqlext.SimplifyWhitespace(`
+		SELECT digest, artifact_type FROM manifests WHERE repo_id = $1 AND subject_digest = $2 ORDER BY digest
+	`)
+	deletionImpactManifestReplicasQuery = sqlext.SimplifyWhitespace(`
+		SELECT peer_hostname FROM replicated_manifests WHERE repo_id = $1 AND digest = $2 ORDER BY peer_hostname
+	`)
+	deletionImpactRepoReplicasQuery = sqlext.SimplifyWhitespace(`
+		SELECT DISTINCT peer_hostname FROM replicated_manifests WHERE repo_id = $1 ORDER BY peer_hostname
+	`)
+	deletionImpactManifestPullsQuery = sqlext.SimplifyWhitespace(`
+		SELECT client_id, last_pulled_at, pull_count
+		  FROM pull_attestations
+		 WHERE account_name = $1 AND repo_name = $2 AND digest = $3
+		 ORDER BY client_id
+	`)
+	deletionImpactRepoPullsQuery = sqlext.SimplifyWhitespace(`
+		SELECT client_id, MAX(last_pulled_at), SUM(pull_count)
+		  FROM pull_attestations
+		 WHERE account_name = $1 AND repo_name = $2
+		 GROUP BY client_id
+		 ORDER BY client_id
+	`)
+	deletionImpactRepoStatsQuery = sqlext.SimplifyWhitespace(`
+		SELECT (SELECT COUNT(*) FROM manifests WHERE repo_id = $1),
+		       (SELECT COUNT(*) FROM tags WHERE repo_id = $1),
+		       (SELECT COUNT(*) FROM uploads WHERE repo_id = $1),
+		       (SELECT MAX(last_pulled_at) FROM manifests WHERE repo_id = $1)
+	`)
+)
+
+func (a *API) handleGetManifestDeletionImpact(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/deletion_impact")
+	// this requires the same permission as the deletion itself, since the
+	// report reveals client IDs and peer hostnames
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanDeleteFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+	parsedDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "digest not found", http.StatusNotFound)
+		return
+	}
+	manifest, err := keppel.FindManifest(a.db, *repo, parsedDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such manifest", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	result := ManifestDeletionImpact{
+		Tags:            []string{},
+		ParentManifests: []digest.Digest{},
+		Referrers:       []Referrer{},
+		LastPulledAt:    keppel.MaybeTimeToUnix(manifest.LastPulledAt),
+	}
+	_, err = a.db.Select(&result.Tags, deletionImpactTagsQuery, repo.ID, manifest.Digest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	_, err = a.db.Select(&result.ParentManifests, deletionImpactParentsQuery, repo.ID, manifest.Digest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	err = sqlext.ForeachRow(a.db, deletionImpactReferrersQuery, []any{repo.ID, manifest.Digest}, func(rows *sql.Rows) error {
+		var ref Referrer
+		err := rows.Scan(&ref.Digest, &ref.ArtifactType)
+		if err != nil {
+			return err
+		}
+		result.Referrers = append(result.Referrers, ref)
+		return nil
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	result.Replicas, err = a.selectPeerHostnames(deletionImpactManifestReplicasQuery, repo.ID, manifest.Digest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	result.PulledBy, err = a.selectClientPullInfos(deletionImpactManifestPullsQuery, account.Name, repo.Name, manifest.Digest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	respondwith.JSON(w, http.StatusOK, map[string]any{"deletion_impact": result})
+}
+
+func (a *API) handleGetRepositoryDeletionImpact(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_deletion_impact")
+	// this requires the same permission as the deletion itself, since the
+	// report reveals client IDs and peer hostnames
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanDeleteFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+
+	var (
+		result       RepositoryDeletionImpact
+		lastPulledAt *time.Time
+	)
+	err := a.db.QueryRow(deletionImpactRepoStatsQuery, repo.ID).
+		Scan(&result.ManifestCount, &result.TagCount, &result.UploadCount, &lastPulledAt)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	result.LastPulledAt = keppel.MaybeTimeToUnix(lastPulledAt)
+	result.Replicas, err = a.selectPeerHostnames(deletionImpactRepoReplicasQuery, repo.ID)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	result.PulledBy, err = a.selectClientPullInfos(deletionImpactRepoPullsQuery, account.Name, repo.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	respondwith.JSON(w, http.StatusOK, map[string]any{"deletion_impact": result})
+}
+
+func (a *API) selectPeerHostnames(query string, args ...any) ([]string, error) {
+	result := []string{}
+	_, err := a.db.Select(&result, query, args...)
+	return result, err
+}
+
+func (a *API) selectClientPullInfos(query string, args ...any) ([]ClientPullInfo, error) {
+	result := []ClientPullInfo{}
+	err := sqlext.ForeachRow(a.db, query, args, func(rows *sql.Rows) error {
+		var (
+			info         ClientPullInfo
+			lastPulledAt time.Time
+		)
+		err := rows.Scan(&info.ClientID, &lastPulledAt, &info.Count)
+		if err != nil {
+			return err
+		}
+		info.LastPulledAt = lastPulledAt.Unix()
+		result = append(result, info)
+		return nil
+	})
+	return result, err
+}
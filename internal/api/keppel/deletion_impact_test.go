@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestDeletionImpactAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	repo := models.Repository{Name: "foo", AccountName: "test1"}
+	mustInsert(t, s.DB, &repo)
+	emptyRepo := models.Repository{Name: "bar", AccountName: "test1"}
+	mustInsert(t, s.DB, &emptyRepo)
+
+	// setup an image, an image list containing it, and a signature referring to it
+	imageDigest := digest.FromString("image")
+	listDigest := digest.FromString("list")
+	signatureDigest := digest.FromString("signature")
+	for _, d := range []digest.Digest{imageDigest, listDigest, signatureDigest} {
+		m := models.Manifest{
+			RepositoryID:     repo.ID,
+			Digest:           d,
+			MediaType:        "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:        1000,
+			PushedAt:         time.Unix(1000, 0),
+			NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+		}
+		switch d {
+		case imageDigest:
+			lastPulledAt := time.Unix(3000, 0)
+			m.LastPulledAt = &lastPulledAt
+		case listDigest:
+			m.MediaType = "application/vnd.oci.image.index.v1+json"
+		case signatureDigest:
+			m.ArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+			m.SubjectDigest = imageDigest
+		}
+		mustInsert(t, s.DB, &m)
+	}
+	mustExec(t, s.DB, `INSERT INTO manifest_manifest_refs (repo_id, parent_digest, child_digest) VALUES ($1, $2, $3)`,
+		repo.ID, listDigest, imageDigest)
+	for _, tagName := range []string{"stable", "latest"} {
+		mustInsert(t, s.DB, &models.Tag{RepositoryID: repo.ID, Name: tagName, Digest: imageDigest, PushedAt: time.Unix(1000, 0)})
+	}
+	mustExec(t, s.DB, `INSERT INTO replicated_manifests (repo_id, digest, peer_hostname) VALUES ($1, $2, $3)`,
+		repo.ID, imageDigest, "registry-secondary.example.org")
+	for _, clientID := range []string{"cluster-b", "cluster-a"} {
+		mustExec(t, s.DB, `INSERT INTO pull_attestations (account_name, repo_name, digest, client_id, first_pulled_at, last_pulled_at, pull_count) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			"test1", "foo", imageDigest, clientID, time.Unix(2000, 0), time.Unix(3000, 0), 2)
+	}
+	mustExec(t, s.DB, `INSERT INTO pull_attestations (account_name, repo_name, digest, client_id, first_pulled_at, last_pulled_at, pull_count) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		"test1", "foo", listDigest, "cluster-a", time.Unix(2000, 0), time.Unix(4000, 0), 1)
+
+	imagePath := "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + imageDigest.String() + "/deletion_impact"
+
+	// the analysis requires delete permission, since it reveals who pulled the
+	// manifests and which peers replicated them
+	for _, path := range []string{imagePath, "/keppel/v1/accounts/test1/repositories/foo/_deletion_impact"} {
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path,
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusForbidden,
+		}.Check(t, h)
+	}
+
+	// error cases
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + digest.FromString("other").String() + "/deletion_impact",
+		Header:       map[string]string{"X-Test-Perms": "delete:tenant1,view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such manifest\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/qux/_deletion_impact",
+		Header:       map[string]string{"X-Test-Perms": "delete:tenant1,view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("repo not found\n"),
+	}.Check(t, h)
+
+	// all dependents of the image are reported
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         imagePath,
+		Header:       map[string]string{"X-Test-Perms": "delete:tenant1,view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"deletion_impact": assert.JSONObject{
+			"tags":             []string{"latest", "stable"},
+			"parent_manifests": []string{listDigest.String()},
+			"referrers": []assert.JSONObject{{
+				"digest":        signatureDigest.String(),
+				"artifact_type": "application/vnd.dev.cosign.artifact.sig.v1+json",
+			}},
+			"replicas":       []string{"registry-secondary.example.org"},
+			"last_pulled_at": 3000,
+			"pulled_by": []assert.JSONObject{
+				{"client_id": "cluster-a", "last_pulled_at": 3000, "count": 2},
+				{"client_id": "cluster-b", "last_pulled_at": 3000, "count": 2},
+			},
+		}},
+	}.Check(t, h)
+
+	// a manifest without dependents reports empty lists
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + signatureDigest.String() + "/deletion_impact",
+		Header:       map[string]string{"X-Test-Perms": "delete:tenant1,view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"deletion_impact": assert.JSONObject{
+			"tags":             []string{},
+			"parent_manifests": []string{},
+			"referrers":        []assert.JSONObject{},
+			"replicas":         []string{},
+			"last_pulled_at":   nil,
+			"pulled_by":        []assert.JSONObject{},
+		}},
+	}.Check(t, h)
+
+	// the analysis for repositories aggregates over all manifests
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_deletion_impact",
+		Header:       map[string]string{"X-Test-Perms": "delete:tenant1,view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"deletion_impact": assert.JSONObject{
+			"manifest_count": 3,
+			"tag_count":      2,
+			"upload_count":   0,
+			"replicas":       []string{"registry-secondary.example.org"},
+			"last_pulled_at": 3000,
+			"pulled_by": []assert.JSONObject{
+				{"client_id": "cluster-a", "last_pulled_at": 4000, "count": 3},
+				{"client_id": "cluster-b", "last_pulled_at": 3000, "count": 2},
+			},
+		}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/bar/_deletion_impact",
+		Header:       map[string]string{"X-Test-Perms": "delete:tenant1,view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"deletion_impact": assert.JSONObject{
+			"manifest_count": 0,
+			"tag_count":      0,
+			"upload_count":   0,
+			"replicas":       []string{},
+			"last_pulled_at": nil,
+			"pulled_by":      []assert.JSONObject{},
+		}},
+	}.Check(t, h)
+}
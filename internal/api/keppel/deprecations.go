@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"net/http"
+
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// Deprecation is the API representation of a keppel.Deprecation, including
+// its usage across all accounts.
+type Deprecation struct {
+	ID           string            `json:"id"`
+	Description  string            `json:"description"`
+	Replacement  string            `json:"replacement"`
+	DeprecatedAt int64             `json:"deprecated_at"`
+	SunsetAt     *int64            `json:"sunset_at,omitempty"`
+	Usage        []DeprecatedUsage `json:"usage"`
+}
+
+// DeprecatedUsage appears in type Deprecation.
+type DeprecatedUsage struct {
+	AccountName models.AccountName `json:"account"`
+	FirstUsedAt int64              `json:"first_used_at"`
+	LastUsedAt  int64              `json:"last_used_at"`
+	Count       uint64             `json:"count"`
+}
+
+func (a *API) handleGetDeprecations(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/deprecations")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return
+	}
+
+	var dbUsages []models.DeprecatedAPIUsage
+	_, err := a.db.Select(&dbUsages, `SELECT * FROM deprecated_api_usage ORDER BY account_name`)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	usagesByID := make(map[string][]DeprecatedUsage)
+	for _, u := range dbUsages {
+		usagesByID[u.DeprecationID] = append(usagesByID[u.DeprecationID], DeprecatedUsage{
+			AccountName: u.AccountName,
+			FirstUsedAt: u.FirstUsedAt.Unix(),
+			LastUsedAt:  u.LastUsedAt.Unix(),
+			Count:       u.UsageCount,
+		})
+	}
+
+	deprecations := make([]Deprecation, len(keppel.AllDeprecations))
+	for idx, d := range keppel.AllDeprecations {
+		deprecations[idx] = Deprecation{
+			ID:           d.ID,
+			Description:  d.Description,
+			Replacement:  d.Replacement,
+			DeprecatedAt: d.DeprecatedAt.Unix(),
+			Usage:        usagesByID[d.ID],
+		}
+		if !d.SunsetAt.IsZero() {
+			deprecations[idx].SunsetAt = keppel.MaybeTimeToUnix(&d.SunsetAt)
+		}
+		if deprecations[idx].Usage == nil {
+			deprecations[idx].Usage = []DeprecatedUsage{}
+		}
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"deprecations": deprecations})
+}
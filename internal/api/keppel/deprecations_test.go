@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestDeprecationsAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	// there are currently no actual deprecations, so this test uses a fake one
+	deprecation := keppel.Deprecation{
+		ID:           "example",
+		Description:  "GET /keppel/v1/example",
+		Replacement:  "Use GET /keppel/v1/other-example instead.",
+		DeprecatedAt: time.Unix(1000, 0),
+		SunsetAt:     time.Unix(2000, 0),
+	}
+	originalDeprecations := keppel.AllDeprecations
+	keppel.AllDeprecations = []keppel.Deprecation{deprecation}
+	t.Cleanup(func() { keppel.AllDeprecations = originalDeprecations })
+
+	// listing deprecations requires cluster-admin permission
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/deprecations",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// without usage in any account, all deprecations are listed with empty usage
+	expectedDeprecation := assert.JSONObject{
+		"id":            "example",
+		"description":   "GET /keppel/v1/example",
+		"replacement":   "Use GET /keppel/v1/other-example instead.",
+		"deprecated_at": 1000,
+		"sunset_at":     2000,
+		"usage":         []assert.JSONObject{},
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/deprecations",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"deprecations": []assert.JSONObject{expectedDeprecation}},
+	}.Check(t, h)
+
+	// using a deprecated API adds the Deprecation and Sunset headers to the response...
+	for _, tc := range []struct {
+		AccountName models.AccountName
+		UsedAt      int64
+	}{
+		{"test2", 10},
+		{"test1", 10},
+		{"test1", 15},
+		{"test1", 20},
+		{"", 30}, // not attributed to any account
+	} {
+		w := httptest.NewRecorder()
+		keppel.ReportDeprecatedUsage(w, deprecation, tc.AccountName, time.Unix(tc.UsedAt, 0))
+		assert.DeepEqual(t, "Deprecation header", w.Header().Get("Deprecation"), "@1000")
+		assert.DeepEqual(t, "Sunset header", w.Header().Get("Sunset"), "Thu, 01 Jan 1970 00:33:20 GMT")
+	}
+
+	// ...and its usage is reported per account once it has been flushed into the DB
+	err := keppel.FlushDeprecatedAPIUsage(s.DB)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectedDeprecation["usage"] = []assert.JSONObject{
+		{"account": "test1", "first_used_at": 10, "last_used_at": 20, "count": 3},
+		{"account": "test2", "first_used_at": 10, "last_used_at": 10, "count": 1},
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/deprecations",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"deprecations": []assert.JSONObject{expectedDeprecation}},
+	}.Check(t, h)
+
+	// flushing again adds to the existing records
+	keppel.ReportDeprecatedUsage(httptest.NewRecorder(), deprecation, "test2", time.Unix(40, 0))
+	err = keppel.FlushDeprecatedAPIUsage(s.DB)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectedDeprecation["usage"] = []assert.JSONObject{
+		{"account": "test1", "first_used_at": 10, "last_used_at": 20, "count": 3},
+		{"account": "test2", "first_used_at": 10, "last_used_at": 40, "count": 2},
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/deprecations",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"deprecations": []assert.JSONObject{expectedDeprecation}},
+	}.Check(t, h)
+}
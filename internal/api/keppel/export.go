@@ -0,0 +1,370 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ExportRecord is one line in the response of GET /keppel/v1/accounts/:account/export.
+// Exactly one of the payload fields is filled, as indicated by Type.
+type ExportRecord struct {
+	// Clients can resume an interrupted export after this record by supplying this cursor.
+	Cursor       string                `json:"cursor,omitempty"`
+	Type         string                `json:"type"`
+	Account      *keppel.Account       `json:"account,omitempty"`
+	Repository   *ExportedRepository   `json:"repository,omitempty"`
+	Manifest     *ExportedManifest     `json:"manifest,omitempty"`
+	Tag          *ExportedTag          `json:"tag,omitempty"`
+	AuditSummary *ExportedAuditSummary `json:"audit_summary,omitempty"`
+	Error        string                `json:"error,omitempty"`
+}
+
+// ExportedRepository appears in type ExportRecord.
+type ExportedRepository struct {
+	Name                      string            `json:"name"`
+	StorageQuotaBytes         *uint64           `json:"storage_quota_bytes,omitempty"`
+	IsArchived                bool              `json:"archived,omitempty"`
+	GCPolicies                []keppel.GCPolicy `json:"gc_policies,omitempty"`
+	IgnoreInheritedGCPolicies bool              `json:"ignore_inherited_gc_policies,omitempty"`
+}
+
+// ExportedManifest appears in type ExportRecord.
+type ExportedManifest struct {
+	RepositoryName         string                `json:"repository"`
+	Digest                 digest.Digest         `json:"digest"`
+	MediaType              string                `json:"media_type"`
+	ArtifactType           string                `json:"artifact_type,omitempty"`
+	SubjectDigest          digest.Digest         `json:"subject_digest,omitempty"`
+	SizeBytes              uint64                `json:"size_bytes"`
+	PushedAt               int64                 `json:"pushed_at"`
+	LastPulledAt           *int64                `json:"last_pulled_at"`
+	LabelsJSON             json.RawMessage       `json:"labels,omitempty"`
+	AnnotationsJSON        json.RawMessage       `json:"annotations,omitempty"`
+	ValidationErrorMessage string                `json:"validation_error,omitempty"`
+	QuarantinedAt          *int64                `json:"quarantined_at,omitempty"`
+	QuarantineReason       string                `json:"quarantine_reason,omitempty"`
+	PromotionState         models.PromotionState `json:"promotion_state,omitempty"`
+}
+
+// ExportedTag appears in type ExportRecord.
+type ExportedTag struct {
+	RepositoryName string        `json:"repository"`
+	Name           string        `json:"name"`
+	Digest         digest.Digest `json:"digest"`
+	PushedAt       int64         `json:"pushed_at"`
+	LastPulledAt   *int64        `json:"last_pulled_at"`
+}
+
+// ExportedAuditSummary appears in type ExportRecord. It lists the
+// security-relevant objects in the account that were created by users.
+type ExportedAuditSummary struct {
+	PendingChanges   []ExportedAuditItem `json:"pending_changes"`
+	Snapshots        []ExportedAuditItem `json:"snapshots"`
+	RobotCredentials []ExportedAuditItem `json:"robot_credentials"`
+}
+
+// ExportedAuditItem appears in type ExportedAuditSummary.
+type ExportedAuditItem struct {
+	ID             string `json:"id"`
+	Kind           string `json:"kind,omitempty"`
+	RepositoryName string `json:"repository,omitempty"`
+	CreatedAt      int64  `json:"created_at"`
+	CreatedBy      string `json:"created_by,omitempty"`
+}
+
+// Record types in the order in which they appear within a repository.
+const (
+	exportStageNone = iota
+	exportStageRepository
+	exportStageManifests
+	exportStageTags
+)
+
+// exportCursor is the parsed form of ExportRecord.Cursor. The export
+// continues after the record identified by the cursor.
+type exportCursor struct {
+	AccountDone bool
+	AllDone     bool
+	// only set if the cursor points into a repository: the ID of this repo,
+	// the type of the last exported record in it, and the manifest digest or
+	// tag name of the last exported record (if any)
+	RepositoryID int64
+	Stage        int
+	Key          string
+}
+
+var exportRobotCredentialsQuery = sqlext.SimplifyWhitespace(`
+	SELECT rc.user_name, r.name, rc.created_at, rc.created_by
+	  FROM robot_credentials rc
+	  JOIN repos r ON r.id = rc.repo_id
+	 WHERE r.account_name = $1
+	 ORDER BY rc.id
+`)
+
+func parseExportCursor(in string) (exportCursor, error) {
+	switch in {
+	case "":
+		return exportCursor{}, nil
+	case "account":
+		return exportCursor{AccountDone: true}, nil
+	case "audit_summary":
+		return exportCursor{AccountDone: true, AllDone: true}, nil
+	}
+
+	// digests contain a colon, so the key must be the last field
+	fields := strings.SplitN(in, ":", 4)
+	if fields[0] != "repo" || len(fields) == 3 {
+		return exportCursor{}, fmt.Errorf("invalid cursor: %q", in)
+	}
+	repoID, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return exportCursor{}, fmt.Errorf("invalid cursor: %q", in)
+	}
+	c := exportCursor{AccountDone: true, RepositoryID: repoID, Stage: exportStageRepository}
+	if len(fields) == 4 {
+		switch fields[2] {
+		case "manifest":
+			c.Stage = exportStageManifests
+		case "tag":
+			c.Stage = exportStageTags
+		default:
+			return exportCursor{}, fmt.Errorf("invalid cursor: %q", in)
+		}
+		c.Key = fields[3]
+	}
+	return c, nil
+}
+
+func (a *API) handleGetAccountExport(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/export")
+	// this exposes all metadata in the account at once, so it is reserved for
+	// cluster admins (e.g. for compliance archiving)
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	cursor, err := parseExportCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// once we start streaming, errors cannot be reported through the status
+	// code anymore; they are reported as a final record of type "error"
+	// instead, after which the client can resume from the last cursor it saw
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	err = a.streamAccountExport(w, enc, *account, cursor)
+	if err != nil {
+		logg.Error("while exporting account %s: %s", account.Name, err.Error())
+		_ = enc.Encode(ExportRecord{Type: "error", Error: err.Error()})
+	}
+}
+
+func (a *API) streamAccountExport(w http.ResponseWriter, enc *json.Encoder, account models.Account, cursor exportCursor) error {
+	if cursor.AllDone {
+		return nil
+	}
+	if !cursor.AccountDone {
+		accountRendered, err := keppel.RenderAccount(account)
+		if err != nil {
+			return err
+		}
+		err = enc.Encode(ExportRecord{Cursor: "account", Type: "account", Account: &accountRendered})
+		if err != nil {
+			return err
+		}
+	}
+
+	var repos []models.Repository
+	_, err := a.db.Select(&repos, `SELECT * FROM repos WHERE account_name = $1 AND id >= $2 ORDER BY id`, account.Name, cursor.RepositoryID)
+	if err != nil {
+		return err
+	}
+	rc := http.NewResponseController(w)
+	for _, repo := range repos {
+		// within the repository from the cursor, skip what was already exported
+		stage, key := exportStageNone, ""
+		if repo.ID == cursor.RepositoryID {
+			stage, key = cursor.Stage, cursor.Key
+		}
+		err := a.streamRepositoryExport(enc, repo, stage, key)
+		if err != nil {
+			return err
+		}
+		// ignore errors here: if the connection is broken, the next write will fail anyway
+		_ = rc.Flush()
+	}
+
+	summary, err := a.buildExportedAuditSummary(account)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(ExportRecord{Cursor: "audit_summary", Type: "audit_summary", AuditSummary: &summary})
+}
+
+func (a *API) streamRepositoryExport(enc *json.Encoder, repo models.Repository, stage int, key string) error {
+	cursorPrefix := fmt.Sprintf("repo:%d", repo.ID)
+
+	if stage < exportStageRepository {
+		gcPolicies, err := keppel.ParseRepositoryGCPolicies(repo)
+		if err != nil {
+			return fmt.Errorf("cannot parse GC policies of repo %s: %w", repo.FullName(), err)
+		}
+		err = enc.Encode(ExportRecord{
+			Cursor: cursorPrefix,
+			Type:   "repository",
+			Repository: &ExportedRepository{
+				Name:                      repo.Name,
+				StorageQuotaBytes:         repo.StorageQuotaBytes,
+				IsArchived:                repo.IsArchived,
+				GCPolicies:                gcPolicies,
+				IgnoreInheritedGCPolicies: repo.IgnoreInheritedGCPolicies,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if stage <= exportStageManifests {
+		afterDigest := ""
+		if stage == exportStageManifests {
+			afterDigest = key
+		}
+		var manifests []models.Manifest
+		_, err := a.db.Select(&manifests, `SELECT * FROM manifests WHERE repo_id = $1 AND digest > $2 ORDER BY digest`, repo.ID, afterDigest)
+		if err != nil {
+			return err
+		}
+		for _, m := range manifests {
+			exported := ExportedManifest{
+				RepositoryName:         repo.Name,
+				Digest:                 m.Digest,
+				MediaType:              m.MediaType,
+				ArtifactType:           m.ArtifactType,
+				SubjectDigest:          m.SubjectDigest,
+				SizeBytes:              m.SizeBytes,
+				PushedAt:               m.PushedAt.Unix(),
+				LastPulledAt:           keppel.MaybeTimeToUnix(m.LastPulledAt),
+				ValidationErrorMessage: m.ValidationErrorMessage,
+				QuarantinedAt:          keppel.MaybeTimeToUnix(m.QuarantinedAt),
+				QuarantineReason:       m.QuarantineReason,
+				PromotionState:         m.PromotionState,
+			}
+			if m.LabelsJSON != "" && m.LabelsJSON != "{}" {
+				exported.LabelsJSON = json.RawMessage(m.LabelsJSON)
+			}
+			if m.AnnotationsJSON != "" && m.AnnotationsJSON != "{}" {
+				exported.AnnotationsJSON = json.RawMessage(m.AnnotationsJSON)
+			}
+			err := enc.Encode(ExportRecord{
+				Cursor:   fmt.Sprintf("%s:manifest:%s", cursorPrefix, m.Digest),
+				Type:     "manifest",
+				Manifest: &exported,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	afterTagName := ""
+	if stage == exportStageTags {
+		afterTagName = key
+	}
+	var tags []models.Tag
+	_, err := a.db.Select(&tags, `SELECT * FROM tags WHERE repo_id = $1 AND name > $2 ORDER BY name`, repo.ID, afterTagName)
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		err := enc.Encode(ExportRecord{
+			Cursor: fmt.Sprintf("%s:tag:%s", cursorPrefix, t.Name),
+			Type:   "tag",
+			Tag: &ExportedTag{
+				RepositoryName: repo.Name,
+				Name:           t.Name,
+				Digest:         t.Digest,
+				PushedAt:       t.PushedAt.Unix(),
+				LastPulledAt:   keppel.MaybeTimeToUnix(t.LastPulledAt),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *API) buildExportedAuditSummary(account models.Account) (ExportedAuditSummary, error) {
+	summary := ExportedAuditSummary{
+		PendingChanges:   []ExportedAuditItem{},
+		Snapshots:        []ExportedAuditItem{},
+		RobotCredentials: []ExportedAuditItem{},
+	}
+
+	var pendingChanges []models.PendingChange
+	_, err := a.db.Select(&pendingChanges, `SELECT * FROM pending_changes WHERE account_name = $1 ORDER BY id`, account.Name)
+	if err != nil {
+		return summary, err
+	}
+	for _, pc := range pendingChanges {
+		summary.PendingChanges = append(summary.PendingChanges, ExportedAuditItem{
+			ID:        strconv.FormatInt(pc.ID, 10),
+			Kind:      string(pc.Kind),
+			CreatedAt: pc.RequestedAt.Unix(),
+			CreatedBy: pc.RequestedBy,
+		})
+	}
+
+	var snapshots []models.AccountSnapshot
+	_, err = a.db.Select(&snapshots, `SELECT * FROM account_snapshots WHERE account_name = $1 ORDER BY id`, account.Name)
+	if err != nil {
+		return summary, err
+	}
+	for _, s := range snapshots {
+		summary.Snapshots = append(summary.Snapshots, ExportedAuditItem{
+			ID:        strconv.FormatInt(s.ID, 10),
+			CreatedAt: s.CreatedAt.Unix(),
+			CreatedBy: s.CreatedBy,
+		})
+	}
+
+	err = sqlext.ForeachRow(a.db, exportRobotCredentialsQuery, []any{account.Name}, func(rows *sql.Rows) error {
+		var (
+			item      ExportedAuditItem
+			createdAt time.Time
+		)
+		err := rows.Scan(&item.ID, &item.RepositoryName, &createdAt, &item.CreatedBy)
+		if err != nil {
+			return err
+		}
+		item.CreatedAt = createdAt.Unix()
+		summary.RobotCredentials = append(summary.RobotCredentials, item)
+		return nil
+	})
+	return summary, err
+}
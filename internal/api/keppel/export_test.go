@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestAccountExport(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	// setup: two repos with some manifests and tags
+	fooRepo := models.Repository{Name: "foo", AccountName: "test1"}
+	mustInsert(t, s.DB, &fooRepo)
+	barRepo := models.Repository{Name: "bar", AccountName: "test1", IsArchived: true}
+	mustInsert(t, s.DB, &barRepo)
+	digests := []digest.Digest{digest.FromString("a"), digest.FromString("b"), digest.FromString("c")}
+	for idx, d := range digests {
+		repo := fooRepo
+		if idx == 2 {
+			repo = barRepo
+		}
+		mustInsert(t, s.DB, &models.Manifest{
+			RepositoryID:     repo.ID,
+			Digest:           d,
+			MediaType:        "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:        1000,
+			PushedAt:         time.Unix(1000, 0),
+			NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+		})
+	}
+	mustInsert(t, s.DB, &models.Tag{RepositoryID: fooRepo.ID, Name: "latest", Digest: digests[0], PushedAt: time.Unix(2000, 0)})
+	mustInsert(t, s.DB, &models.Tag{RepositoryID: fooRepo.ID, Name: "v1", Digest: digests[1], PushedAt: time.Unix(2000, 0)})
+	mustInsert(t, s.DB, &models.AccountSnapshot{AccountName: "test1", CreatedAt: time.Unix(3000, 0), CreatedBy: "someone", ContentsJSON: `{"repositories":[]}`})
+
+	// the export is only available to cluster admins
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/export",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/export?cursor=foo",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("invalid cursor: \"foo\"\n"),
+	}.Check(t, h)
+
+	getExport := func(cursor string) []map[string]any {
+		t.Helper()
+		resp, body := assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/export?cursor=" + cursor,
+			Header:       map[string]string{"X-Test-Perms": "admin:"},
+			ExpectStatus: http.StatusOK,
+		}.Check(t, h)
+		assert.DeepEqual(t, "Content-Type", resp.Header.Get("Content-Type"), "application/x-ndjson")
+
+		records := []map[string]any{}
+		for _, line := range bytes.Split(bytes.TrimSpace(body), []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			var record map[string]any
+			err := json.Unmarshal(line, &record)
+			if err != nil {
+				t.Fatalf("cannot decode export line %q: %s", string(line), err.Error())
+			}
+			records = append(records, record)
+		}
+		return records
+	}
+
+	// full export: the account, then each repo with its manifests and tags,
+	// then the audit summary
+	records := getExport("")
+	var cursors []string
+	for _, record := range records {
+		cursors = append(cursors, record["cursor"].(string))
+	}
+	fooPrefix := "repo:" + strconv.FormatInt(fooRepo.ID, 10)
+	barPrefix := "repo:" + strconv.FormatInt(barRepo.ID, 10)
+	assert.DeepEqual(t, "cursors", cursors, []string{
+		"account",
+		fooPrefix,
+		fooPrefix + ":manifest:" + digests[0].String(),
+		fooPrefix + ":manifest:" + digests[1].String(),
+		fooPrefix + ":tag:latest",
+		fooPrefix + ":tag:v1",
+		barPrefix,
+		barPrefix + ":manifest:" + digests[2].String(),
+		"audit_summary",
+	})
+
+	assert.DeepEqual(t, "account name", records[0]["account"].(map[string]any)["name"], any("test1"))
+	assert.DeepEqual(t, "archived repo", records[6]["repository"], any(map[string]any{"name": "bar", "archived": true}))
+	assert.DeepEqual(t, "tag record", records[5]["tag"], any(map[string]any{
+		"repository":     "foo",
+		"name":           "v1",
+		"digest":         digests[1].String(),
+		"pushed_at":      float64(2000),
+		"last_pulled_at": nil,
+	}))
+	assert.DeepEqual(t, "audit summary", records[8]["audit_summary"], any(map[string]any{
+		"pending_changes":   []any{},
+		"snapshots":         []any{map[string]any{"id": "1", "created_at": float64(3000), "created_by": "someone"}},
+		"robot_credentials": []any{},
+	}))
+
+	// resuming after any record yields exactly the remaining records
+	for idx, cursor := range cursors {
+		assert.DeepEqual(t, "export after "+cursor, getExport(cursor), records[idx+1:])
+	}
+}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/sapcc/go-api-declarations/bininfo"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// serverInfo is the response body of GET /keppel/v1/info.
+type serverInfo struct {
+	Version     string            `json:"version"`
+	APIVersions []string          `json:"api_versions"`
+	Drivers     map[string]string `json:"drivers"`
+	Features    []string          `json:"features"`
+	// AccountMetadataFields lists the custom metadata fields that accounts can or must have.
+	AccountMetadataFields []keppel.AccountMetadataField `json:"account_metadata_fields,omitempty"`
+}
+
+func (a *API) handleGetServerInfo(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/info")
+
+	info := serverInfo{
+		Version:     bininfo.VersionOr("rolling"),
+		APIVersions: []string{"v1"},
+		Drivers: map[string]string{
+			"auth":          a.authDriver.PluginTypeID(),
+			"federation":    a.fd.PluginTypeID(),
+			"storage":       a.sd.PluginTypeID(),
+			"inbound_cache": a.icd.PluginTypeID(),
+			"secrets":       a.secd.PluginTypeID(),
+		},
+		// these features are always available
+		Features:              []string{"referrers_api", "lazy_pull_variants", "recompressed_variants", "tag_watches", "webhooks"},
+		AccountMetadataFields: a.cfg.AccountMetadataSchema.Fields,
+	}
+
+	if a.cfg.Trivy != nil {
+		info.Drivers["scanner"] = "trivy"
+		info.Features = append(info.Features, "vulnerability_scanning")
+	}
+	if a.cfg.AnycastAPIPublicHostname != "" {
+		info.Features = append(info.Features, "anycast")
+	}
+	if a.cfg.AccountRequestsEnabled {
+		info.Features = append(info.Features, "account_requests")
+	}
+	if a.cfg.AdmissionWebhook != nil {
+		info.Features = append(info.Features, "admission_webhook")
+	}
+	if len(a.cfg.DistributionNotificationURLs) > 0 {
+		info.Features = append(info.Features, "distribution_notifications")
+	}
+	if a.rle != nil {
+		info.Features = append(info.Features, "rate_limits")
+	}
+	slices.Sort(info.Features)
+
+	respondwith.JSON(w, http.StatusOK, info)
+}
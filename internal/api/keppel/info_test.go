@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGetServerInfo(t *testing.T) {
+	drivers := assert.JSONObject{
+		"auth":          "unittest",
+		"federation":    "unittest",
+		"storage":       "in-memory-for-testing",
+		"inbound_cache": "unittest",
+		"secrets":       "unittest",
+	}
+
+	// minimal setup: only the features that are always available
+	s := test.NewSetup(t, test.WithKeppelAPI)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/info",
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"version":      "rolling",
+			"api_versions": []string{"v1"},
+			"drivers":      drivers,
+			"features":     []string{"lazy_pull_variants", "recompressed_variants", "referrers_api", "tag_watches", "webhooks"},
+		},
+	}.Check(t, s.Handler)
+
+	// optional features show up when configured
+	s = test.NewSetup(t, test.WithKeppelAPI, test.WithAnycast(true), test.WithAccountRequests, test.WithTrivyDouble)
+	drivers["scanner"] = "trivy"
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/info",
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"version":      "rolling",
+			"api_versions": []string{"v1"},
+			"drivers":      drivers,
+			"features": []string{
+				"account_requests", "anycast", "lazy_pull_variants", "recompressed_variants", "referrers_api", "tag_watches", "vulnerability_scanning", "webhooks",
+			},
+		},
+	}.Check(t, s.Handler)
+}
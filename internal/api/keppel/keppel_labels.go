@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+func (a *API) handlePutManifestKeppelLabels(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/keppel_labels")
+	// Keppel labels can be used to grant pull access through RBAC policies, so
+	// changing them requires the same permission as changing promotion states
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.Scope{
+		ResourceType: "repository",
+		ResourceName: fmt.Sprintf("%s/%s", mux.Vars(r)["account"], mux.Vars(r)["repo_name"]),
+		Actions:      []string{"promote"},
+	}))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+	manifestDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "digest not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		KeppelLabels map[string]string `json:"keppel_labels"`
+	}
+	if !decodeJSONRequestBody(w, r.Body, &req) {
+		return
+	}
+	if req.KeppelLabels == nil {
+		req.KeppelLabels = map[string]string{}
+	}
+	err = keppel.ValidateKeppelLabels(req.KeppelLabels)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = a.processor().SetManifestKeppelLabels(account.Reduced(), *repo, manifestDigest, req.KeppelLabels, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such manifest", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, req)
+}
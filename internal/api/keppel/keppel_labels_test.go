@@ -0,0 +1,208 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/easypg"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestManifestKeppelLabelsAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	repo := models.Repository{Name: "foo", AccountName: "test1"}
+	mustInsert(t, s.DB, &repo)
+	firstDigest := test.DeterministicDummyDigest(1)
+	secondDigest := test.DeterministicDummyDigest(2)
+	for _, manifestDigest := range []digest.Digest{firstDigest, secondDigest} {
+		mustInsert(t, s.DB, &models.Manifest{
+			RepositoryID:     repo.ID,
+			Digest:           manifestDigest,
+			MediaType:        "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:        1000,
+			PushedAt:         time.Unix(1000, 0),
+			NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+		})
+		mustInsert(t, s.DB, &models.TrivySecurityInfo{
+			RepositoryID:        repo.ID,
+			Digest:              manifestDigest,
+			VulnerabilityStatus: models.PendingVulnerabilityStatus,
+			NextCheckAt:         time.Unix(0, 0),
+		})
+	}
+	manifestPath := "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + firstDigest.String()
+
+	// changing Keppel labels requires the same permission as changing the promotion state
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         manifestPath + "/keppel_labels",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1,push:tenant1,delete:tenant1"},
+		Body:         assert.JSONObject{"keppel_labels": assert.JSONObject{"approved-for-prod": "true"}},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// error cases
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         manifestPath + "/keppel_labels",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"keppel_labels": assert.JSONObject{"-approved": "true"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("\"-approved\" is not a valid Keppel label name\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         manifestPath + "/keppel_labels",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"keppel_labels": assert.JSONObject{"approved-for-prod": strings.Repeat("x", 257)}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("value of Keppel label \"approved-for-prod\" is too long (got 257 bytes, but the limit is 256)\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + test.DeterministicDummyDigest(3).String() + "/keppel_labels",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"keppel_labels": assert.JSONObject{"approved-for-prod": "true"}},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such manifest\n"),
+	}.Check(t, h)
+	s.Auditor.ExpectEvents(t /*, nothing */)
+
+	// happy path: set labels (the manifest itself does not change)
+	tr, _ := easypg.NewTracker(t, s.DB.Db)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         manifestPath + "/keppel_labels",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"keppel_labels": assert.JSONObject{"approved-for-prod": "true", "team": "alpha"}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"keppel_labels": assert.JSONObject{"approved-for-prod": "true", "team": "alpha"}},
+	}.Check(t, h)
+	tr.DBChanges().AssertEqualf(`
+			INSERT INTO manifest_keppel_labels (repo_id, digest, name, value) VALUES (1, '%[1]s', 'approved-for-prod', 'true');
+			INSERT INTO manifest_keppel_labels (repo_id, digest, name, value) VALUES (1, '%[1]s', 'team', 'alpha');
+		`,
+		firstDigest,
+	)
+	s.Auditor.ExpectEvents(t, cadf.Event{
+		RequestPath: manifestPath + "/keppel_labels",
+		Action:      cadf.UpdateAction,
+		Outcome:     "success",
+		Reason:      test.CADFReasonOK,
+		Target: cadf.Resource{
+			TypeURI:   "docker-registry/account/repository/manifest",
+			Name:      "test1/foo@" + firstDigest.String(),
+			ID:        firstDigest.String(),
+			ProjectID: "tenant1",
+			Attachments: []cadf.Attachment{{
+				Name:    "keppel_labels",
+				TypeURI: "mime:application/json",
+				Content: test.ToJSON(assert.JSONObject{
+					"previous_labels": assert.JSONObject{},
+					"new_labels":      assert.JSONObject{"approved-for-prod": "true", "team": "alpha"},
+				}),
+			}},
+		},
+	})
+
+	// setting the same labels again is a no-op
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         manifestPath + "/keppel_labels",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"keppel_labels": assert.JSONObject{"approved-for-prod": "true", "team": "alpha"}},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	tr.DBChanges().AssertEmpty()
+	s.Auditor.ExpectEvents(t /*, nothing */)
+
+	// labels are shown in the manifest list and can be used for filtering
+	renderManifest := func(manifestDigest digest.Digest, keppelLabels assert.JSONObject) assert.JSONObject {
+		result := assert.JSONObject{
+			"digest":               manifestDigest,
+			"media_type":           "application/vnd.oci.image.manifest.v1+json",
+			"size_bytes":           1000,
+			"pushed_at":            1000,
+			"last_pulled_at":       nil,
+			"vulnerability_status": "Pending",
+			"min_layer_created_at": nil,
+			"max_layer_created_at": nil,
+		}
+		if keppelLabels != nil {
+			result["keppel_labels"] = keppelLabels
+		}
+		return result
+	}
+	firstRendered := renderManifest(firstDigest, assert.JSONObject{"approved-for-prod": "true", "team": "alpha"})
+	secondRendered := renderManifest(secondDigest, nil)
+	for query, expectedManifests := range map[string][]assert.JSONObject{
+		"":                                         {firstRendered, secondRendered},
+		"?keppel_label=approved-for-prod":          {firstRendered},
+		"?keppel_label=approved-for-prod=true":     {firstRendered},
+		"?keppel_label=approved-for-prod=false":    {},
+		"?keppel_label=team&keppel_label=whatever": {},
+	} {
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests" + query,
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.JSONObject{"manifests": expectedManifests},
+		}.Check(t, h)
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests?keppel_label=%20",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("\" \" is not a valid Keppel label name\n"),
+	}.Check(t, h)
+
+	// replacing the labels removes those that are not given anymore
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         manifestPath + "/keppel_labels",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"keppel_labels": assert.JSONObject{"team": "beta"}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"keppel_labels": assert.JSONObject{"team": "beta"}},
+	}.Check(t, h)
+	tr.DBChanges().AssertEqualf(`
+			DELETE FROM manifest_keppel_labels WHERE repo_id = 1 AND digest = '%[1]s' AND name = 'approved-for-prod';
+			UPDATE manifest_keppel_labels SET value = 'beta' WHERE repo_id = 1 AND digest = '%[1]s' AND name = 'team';
+		`,
+		firstDigest,
+	)
+	s.Auditor.IgnoreEventsUntilNow()
+
+	// an empty label set removes all labels
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         manifestPath + "/keppel_labels",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"keppel_labels": assert.JSONObject{}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"keppel_labels": assert.JSONObject{}},
+	}.Check(t, h)
+	tr.DBChanges().AssertEqualf(`
+			DELETE FROM manifest_keppel_labels WHERE repo_id = 1 AND digest = '%[1]s' AND name = 'team';
+		`,
+		firstDigest,
+	)
+	s.Auditor.IgnoreEventsUntilNow()
+}
@@ -11,6 +11,8 @@ import (
 	"html"
 	"net/http"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/opencontainers/go-digest"
@@ -34,18 +36,41 @@ type Manifest struct {
 	LastPulledAt                  *int64                     `json:"last_pulled_at"`
 	Tags                          []Tag                      `json:"tags,omitempty"`
 	LabelsJSON                    json.RawMessage            `json:"labels,omitempty"`
+	KeppelLabels                  map[string]string          `json:"keppel_labels,omitempty"`
 	GCStatusJSON                  json.RawMessage            `json:"gc_status,omitempty"`
 	VulnerabilityStatus           models.VulnerabilityStatus `json:"vulnerability_status"`
 	VulnerabilityScanErrorMessage string                     `json:"vulnerability_scan_error,omitempty"`
+	VulnerabilityScannedLayers    *int64                     `json:"vulnerability_scanned_layers,omitempty"`
 	MinLayerCreatedAt             *int64                     `json:"min_layer_created_at"`
 	MaxLayerCreatedAt             *int64                     `json:"max_layer_created_at"`
+	ValidationWarningsJSON        json.RawMessage            `json:"validation_warnings,omitempty"`
+	QuarantinedAt                 *int64                     `json:"quarantined_at,omitempty"`
+	QuarantineReason              string                     `json:"quarantine_reason,omitempty"`
+	VerifiedAt                    *int64                     `json:"verified_at,omitempty"`
+	PromotionState                models.PromotionState      `json:"promotion_state,omitempty"`
+	PushInfoJSON                  json.RawMessage            `json:"push_info,omitempty"`
 }
 
 // Tag represents a tag in the API.
 type Tag struct {
-	Name         string `json:"name"`
-	PushedAt     int64  `json:"pushed_at"`
-	LastPulledAt *int64 `json:"last_pulled_at"`
+	Name           string `json:"name"`
+	PushedAt       int64  `json:"pushed_at"`
+	LastPulledAt   *int64 `json:"last_pulled_at"`
+	PullCount      uint64 `json:"pull_count,omitempty"`
+	ChildPullCount uint64 `json:"child_pull_count,omitempty"`
+}
+
+// TagWithManifest represents a tag in the tag listing of the API, together
+// with the most important information about the manifest that it points to.
+type TagWithManifest struct {
+	Name           string        `json:"name"`
+	Digest         digest.Digest `json:"digest"`
+	MediaType      string        `json:"media_type"`
+	SizeBytes      uint64        `json:"size_bytes"`
+	PushedAt       int64         `json:"pushed_at"`
+	LastPulledAt   *int64        `json:"last_pulled_at"`
+	PullCount      uint64        `json:"pull_count,omitempty"`
+	ChildPullCount uint64        `json:"child_pull_count,omitempty"`
 }
 
 var manifestGetQuery = sqlext.SimplifyWhitespace(`
@@ -69,6 +94,43 @@ var tagGetQuery = sqlext.SimplifyWhitespace(`
 	 WHERE repo_id = $1 AND digest >= $2 AND digest <= $3
 `)
 
+var tagWithManifestGetQuery = sqlext.SimplifyWhitespace(`
+	SELECT t.name, t.digest, m.media_type, m.size_bytes, t.pushed_at, t.last_pulled_at, t.pull_count, t.child_pull_count
+	  FROM tags t
+	  JOIN manifests m ON m.repo_id = t.repo_id AND m.digest = t.digest
+	 WHERE t.repo_id = $1 AND $CONDITION
+	 ORDER BY t.name ASC
+	 LIMIT $LIMIT
+`)
+
+var keppelLabelGetQuery = sqlext.SimplifyWhitespace(`
+	SELECT digest, name, value
+	  FROM manifest_keppel_labels
+	 WHERE repo_id = $1 AND digest >= $2 AND digest <= $3
+`)
+
+// Builds the extra SQL condition for `?keppel_label=name` (match manifests
+// having this Keppel label with any value) and `?keppel_label=name=value`
+// (match manifests having this Keppel label with exactly this value). This
+// condition works for every table with "repo_id" and "digest" columns.
+func keppelLabelFilterCondition(filters []string, bindValues []any) (condition string, modifiedBindValues []any, err error) {
+	conditions := []string{`$CONDITION`}
+	for _, filter := range filters {
+		name, value, hasValue := strings.Cut(filter, "=")
+		if !keppel.KeppelLabelNameRx.MatchString(name) {
+			return "", nil, fmt.Errorf("%q is not a valid Keppel label name", name)
+		}
+		bindValues = append(bindValues, name)
+		subquery := fmt.Sprintf(`SELECT digest FROM manifest_keppel_labels WHERE repo_id = $1 AND name = $%d`, len(bindValues))
+		if hasValue {
+			bindValues = append(bindValues, value)
+			subquery += fmt.Sprintf(` AND value = $%d`, len(bindValues))
+		}
+		conditions = append(conditions, fmt.Sprintf(`digest IN (%s)`, subquery))
+	}
+	return strings.Join(conditions, ` AND `), bindValues, nil
+}
+
 func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests")
 	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
@@ -84,11 +146,19 @@ func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the filter needs to be applied to both queries below, otherwise we might
+	// not find the security info for all manifests that we find
+	filterCondition, filterBindValues, err := keppelLabelFilterCondition(r.URL.Query()["keppel_label"], []any{repo.ID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	manifestQuery, vulnBindValues, manifestLimit, err := paginatedQuery{
-		SQL:         manifestGetQuery,
+		SQL:         strings.Replace(manifestGetQuery, `$CONDITION`, filterCondition, 1),
 		MarkerField: "digest",
 		Options:     r.URL.Query(),
-		BindValues:  []any{repo.ID},
+		BindValues:  filterBindValues,
 	}.Prepare()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -102,10 +172,10 @@ func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 	}
 
 	securityInfoQuery, securityBindValues, _, err := paginatedQuery{
-		SQL:         securityInfoGetQuery,
+		SQL:         strings.Replace(securityInfoGetQuery, `$CONDITION`, filterCondition, 1),
 		MarkerField: "digest",
 		Options:     r.URL.Query(),
-		BindValues:  []any{repo.ID},
+		BindValues:  filterBindValues,
 	}.Prepare()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -123,6 +193,9 @@ func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 		securityInfos[securityInfo.Digest] = securityInfo
 	}
 
+	// the client IP in the push info is personal data
+	canSeeClientIP := authz.UserIdentity.HasPermission(keppel.CanChangeAccount, account.AuthTenantID)
+
 	var result struct {
 		Manifests   []*Manifest `json:"manifests"`
 		IsTruncated bool        `json:"truncated,omitempty"`
@@ -142,6 +215,10 @@ func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		pushInfoJSON := dbManifest.PushInfoJSON
+		if !canSeeClientIP {
+			pushInfoJSON = keppel.RedactPushInfoClientIP(pushInfoJSON)
+		}
 		result.Manifests = append(result.Manifests, &Manifest{
 			Digest:                        dbManifest.Digest,
 			MediaType:                     dbManifest.MediaType,
@@ -149,11 +226,18 @@ func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 			PushedAt:                      dbManifest.PushedAt.Unix(),
 			LastPulledAt:                  keppel.MaybeTimeToUnix(dbManifest.LastPulledAt),
 			LabelsJSON:                    json.RawMessage(dbManifest.LabelsJSON),
+			ValidationWarningsJSON:        json.RawMessage(dbManifest.ValidationWarningsJSON),
 			GCStatusJSON:                  json.RawMessage(dbManifest.GCStatusJSON),
 			VulnerabilityStatus:           securityInfo.VulnerabilityStatus,
 			VulnerabilityScanErrorMessage: securityInfo.Message,
+			VulnerabilityScannedLayers:    securityInfo.ScannedLayerCount,
 			MinLayerCreatedAt:             keppel.MaybeTimeToUnix(dbManifest.MinLayerCreatedAt),
 			MaxLayerCreatedAt:             keppel.MaybeTimeToUnix(dbManifest.MaxLayerCreatedAt),
+			QuarantinedAt:                 keppel.MaybeTimeToUnix(dbManifest.QuarantinedAt),
+			QuarantineReason:              dbManifest.QuarantineReason,
+			VerifiedAt:                    keppel.MaybeTimeToUnix(dbManifest.VerifiedAt),
+			PromotionState:                dbManifest.PromotionState,
+			PushInfoJSON:                  json.RawMessage(pushInfoJSON),
 		})
 	}
 
@@ -174,12 +258,35 @@ func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 		tagsByDigest := make(map[digest.Digest][]Tag)
 		for _, dbTag := range dbTags {
 			tagsByDigest[dbTag.Digest] = append(tagsByDigest[dbTag.Digest], Tag{
-				Name:         dbTag.Name,
-				PushedAt:     dbTag.PushedAt.Unix(),
-				LastPulledAt: keppel.MaybeTimeToUnix(dbTag.LastPulledAt),
+				Name:           dbTag.Name,
+				PushedAt:       dbTag.PushedAt.Unix(),
+				LastPulledAt:   keppel.MaybeTimeToUnix(dbTag.LastPulledAt),
+				PullCount:      dbTag.PullCount,
+				ChildPullCount: dbTag.ChildPullCount,
 			})
 		}
+		keppelLabelsByDigest := make(map[digest.Digest]map[string]string)
+		err = sqlext.ForeachRow(a.db, keppelLabelGetQuery, []any{repo.ID, firstDigest, lastDigest}, func(rows *sql.Rows) error {
+			var (
+				manifestDigest digest.Digest
+				name, value    string
+			)
+			err := rows.Scan(&manifestDigest, &name, &value)
+			if err != nil {
+				return err
+			}
+			if keppelLabelsByDigest[manifestDigest] == nil {
+				keppelLabelsByDigest[manifestDigest] = make(map[string]string)
+			}
+			keppelLabelsByDigest[manifestDigest][name] = value
+			return nil
+		})
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+
 		for _, manifest := range result.Manifests {
+			manifest.KeppelLabels = keppelLabelsByDigest[manifest.Digest]
 			manifest.Tags = tagsByDigest[manifest.Digest]
 			// sort in deterministic order for unit test
 			sort.Slice(manifest.Tags, func(i, j int) bool {
@@ -210,6 +317,25 @@ func (a *API) handleDeleteManifest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "digest not found", http.StatusNotFound)
 		return
 	}
+	if !checkRepositoryNotArchived(w, *repo) {
+		return
+	}
+	if !a.checkDeletionAllowedByTagProtection(w, authz, *account, *repo, models.ManifestReference{Digest: parsedDigest}) {
+		return
+	}
+
+	pc, err := a.processor().PendingChangeForManifestDeletion(account.Reduced(), *repo, parsedDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such manifest", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if pc != nil {
+		a.submitPendingChange(w, r, authz, account.Reduced(), pc)
+		return
+	}
 
 	err = a.processor().DeleteManifest(r.Context(), account.Reduced(), *repo, parsedDigest, keppel.AuditContext{
 		UserIdentity: authz.UserIdentity,
@@ -226,6 +352,64 @@ func (a *API) handleDeleteManifest(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (a *API) handleGetTags(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_tags")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+
+	query, bindValues, limit, err := paginatedQuery{
+		SQL:         tagWithManifestGetQuery,
+		MarkerField: "t.name",
+		Options:     r.URL.Query(),
+		BindValues:  []any{repo.ID},
+	}.Prepare()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result struct {
+		Tags        []TagWithManifest `json:"tags"`
+		IsTruncated bool              `json:"truncated,omitempty"`
+	}
+	err = sqlext.ForeachRow(a.db, query, bindValues, func(rows *sql.Rows) error {
+		if uint64(len(result.Tags)) >= limit {
+			result.IsTruncated = true
+			return nil
+		}
+		var (
+			tag          TagWithManifest
+			pushedAt     time.Time
+			lastPulledAt *time.Time
+		)
+		err := rows.Scan(&tag.Name, &tag.Digest, &tag.MediaType, &tag.SizeBytes, &pushedAt, &lastPulledAt, &tag.PullCount, &tag.ChildPullCount)
+		if err != nil {
+			return err
+		}
+		tag.PushedAt = pushedAt.Unix()
+		tag.LastPulledAt = keppel.MaybeTimeToUnix(lastPulledAt)
+		result.Tags = append(result.Tags, tag)
+		return nil
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if len(result.Tags) == 0 {
+		result.Tags = []TagWithManifest{}
+	}
+	respondwith.JSON(w, http.StatusOK, result)
+}
+
 func (a *API) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_tags/:name")
 	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanDeleteFromAccount))
@@ -240,9 +424,28 @@ func (a *API) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
 	if repo == nil {
 		return
 	}
+	if !checkRepositoryNotArchived(w, *repo) {
+		return
+	}
 	tagName := mux.Vars(r)["tag_name"]
+	if !a.checkDeletionAllowedByTagProtection(w, authz, *account, *repo, models.ManifestReference{Tag: tagName}) {
+		return
+	}
+
+	pc, err := a.processor().PendingChangeForTagDeletion(account.Reduced(), *repo, tagName)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such tag", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if pc != nil {
+		a.submitPendingChange(w, r, authz, account.Reduced(), pc)
+		return
+	}
 
-	err := a.processor().DeleteTag(account.Reduced(), *repo, tagName, keppel.AuditContext{
+	err = a.processor().DeleteTag(account.Reduced(), *repo, tagName, keppel.AuditContext{
 		UserIdentity: authz.UserIdentity,
 		Request:      r,
 	})
@@ -257,9 +460,34 @@ func (a *API) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Archived repos are read-only, so nothing can be deleted from them either.
+func checkRepositoryNotArchived(w http.ResponseWriter, repo models.Repository) bool {
+	if repo.IsArchived {
+		keppel.ErrUnsupported.With("repository is archived").WithStatus(http.StatusMethodNotAllowed).
+			WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonRepositoryArchived}).
+			WriteAsTextTo(w)
+		return false
+	}
+	return true
+}
+
+func (a *API) checkDeletionAllowedByTagProtection(w http.ResponseWriter, authz *auth.Authorization, account models.Account, repo models.Repository, ref models.ManifestReference) bool {
+	err := a.processor().CheckDeletionAllowedByTagProtection(account.Reduced(), repo, ref, authz.UserIdentity)
+	if rerr, ok := errext.As[*keppel.RegistryV2Error](err); ok && rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return false
+	}
+	return !respondwith.ErrorText(w, err)
+}
+
 func (a *API) handleGetTrivyReport(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/trivy_report")
-	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	// this uses the narrower "scan_read" action instead of "pull" (see auth.Scope.Contains)
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.Scope{
+		ResourceType: "repository",
+		ResourceName: fmt.Sprintf("%s/%s", mux.Vars(r)["account"], mux.Vars(r)["repo_name"]),
+		Actions:      []string{"scan_read"},
+	}))
 	if authz == nil {
 		return
 	}
@@ -333,17 +561,26 @@ func (a *API) handleGetTrivyReport(w http.ResponseWriter, r *http.Request) {
 		format = "json"
 	}
 
-	if format != "json" && format != "spdx-json" {
+	// all supported formats are generated by Trivy itself (SARIF is supported
+	// so that CI systems can ingest our reports without a conversion step)
+	contentType := "application/json"
+	switch format {
+	case "json", "spdx-json":
+		// use default content type
+	case "sarif":
+		contentType = "application/sarif+json"
+	default:
 		http.Error(w, fmt.Sprintf("format %s not supported", html.EscapeString(format)), http.StatusBadRequest)
 		return
 	}
 
-	tokenResp, err := auth.IssueTokenForTrivy(a.cfg, repo.FullName())
+	scanTimeout, _ := a.cfg.Trivy.EffectiveScanTimeouts()
+	tokenResp, err := auth.IssueTokenForTrivy(a.cfg, repo.FullName(), scanTimeout)
 	if respondwith.ErrorText(w, err) {
 		return
 	}
 
-	report, err := a.cfg.Trivy.ScanManifest(r.Context(), tokenResp.Token, imageRef, format)
+	report, err := a.cfg.Trivy.ScanManifest(r.Context(), tokenResp.Token, imageRef, format, scanTimeout)
 	if respondwith.ErrorText(w, err) {
 		return
 	}
@@ -357,7 +594,7 @@ func (a *API) handleGetTrivyReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(http.StatusOK)
 	w.Write(report.Contents)
 }
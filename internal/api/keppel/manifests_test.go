@@ -257,6 +257,68 @@ func TestManifestsAPI(t *testing.T) {
 			ExpectBody:   assert.StringData("strconv.ParseUint: parsing \"foo\": invalid syntax\n"),
 		}.Check(t, h)
 
+		// test GET on tag listing (tags are sorted by name: "first" < "second" < "stillfirst")
+		renderedTags := []assert.JSONObject{
+			{
+				"name":           "first",
+				"digest":         test.DeterministicDummyDigest(11),
+				"media_type":     manifest.DockerV2Schema2MediaType,
+				"size_bytes":     1000,
+				"pushed_at":      20001,
+				"last_pulled_at": 20101,
+			},
+			{
+				"name":           "second",
+				"digest":         test.DeterministicDummyDigest(12),
+				"media_type":     manifest.DockerV2Schema2MediaType,
+				"size_bytes":     2000,
+				"pushed_at":      20003,
+				"last_pulled_at": nil,
+			},
+			{
+				"name":           "stillfirst",
+				"digest":         test.DeterministicDummyDigest(11),
+				"media_type":     manifest.DockerV2Schema2MediaType,
+				"size_bytes":     1000,
+				"pushed_at":      20002,
+				"last_pulled_at": nil,
+			},
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_tags",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.JSONObject{"tags": renderedTags},
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_tags?limit=2",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.JSONObject{"tags": renderedTags[0:2], "truncated": true},
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_tags?limit=2&marker=second",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.JSONObject{"tags": renderedTags[2:3]},
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/doesnotexist/repositories/repo1-1/_tags",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusForbidden,
+			ExpectBody:   assert.StringData("no permission for repository:doesnotexist/repo1-1:pull\n"),
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/doesnotexist/_tags",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusNotFound,
+		}.Check(t, h)
+
 		// test DELETE manifest happy case
 		easypg.AssertDBContent(t, s.DB.Db, "fixtures/before-delete-manifest.sql")
 		assert.HTTPRequest{
@@ -426,6 +488,25 @@ func TestManifestsAPI(t *testing.T) {
 			ExpectBody:   assert.JSONFixtureFile("../../tasks/fixtures/trivy/report-vulnerable-with-fixes.json"),
 		}.Check(t, h)
 
+		// the report can also be retrieved in SARIF format for ingestion into CI systems
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_manifests/" + test.DeterministicDummyDigest(12).String() + "/trivy_report?format=sarif",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{"Content-Type": "application/sarif+json"},
+			ExpectBody:   assert.JSONFixtureFile("../../tasks/fixtures/trivy/report-vulnerable-with-fixes.sarif.json"),
+		}.Check(t, h)
+
+		// unknown formats are rejected
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_manifests/" + test.DeterministicDummyDigest(12).String() + "/trivy_report?format=table",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   assert.StringData("format table not supported\n"),
+		}.Check(t, h)
+
 		// with security scan policies configured, we expect the Trivy report to be
 		// enriched with the X-Keppel-Applicable-Policies field (the rest of the report is untouched)
 		policyJSON := must.Return(json.Marshal([]keppel.SecurityScanPolicy{
@@ -529,3 +610,41 @@ func TestRateLimitsTrivyReport(t *testing.T) {
 		failingReq.Check(t, h)
 	})
 }
+
+func TestTrivyReportRequiresScanRead(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithTrivyDouble,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	_, err := keppel.FindOrCreateRepository(s.DB, "foo", models.AccountName("test1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	path := fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_manifests/%s/trivy_report", test.DeterministicDummyDigest(1))
+
+	// tokens with "scan_read" or "pull" are accepted (this yields 404 since the manifest does not exist)
+	for _, scope := range []string{"repository:test1/foo:scan_read", "repository:test1/foo:pull"} {
+		token := s.GetToken(t, scope)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusNotFound,
+			ExpectBody:   assert.StringData("not found\n"),
+		}.Check(t, h)
+	}
+
+	// tokens for other actions are not accepted
+	for _, scope := range []string{"repository:test1/foo:push", "repository:test1/foo:delete"} {
+		token := s.GetToken(t, scope)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusUnauthorized,
+		}.Check(t, h)
+	}
+}
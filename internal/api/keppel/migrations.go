@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"net/http"
+
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// BackgroundMigration is the API representation of a keppel.BackgroundMigration.
+type BackgroundMigration struct {
+	Name          string `json:"name"`
+	ProcessedRows int64  `json:"processed_rows"`
+	RemainingRows int64  `json:"remaining_rows"`
+	StartedAt     *int64 `json:"started_at,omitempty"`
+	FinishedAt    *int64 `json:"finished_at,omitempty"`
+}
+
+func (a *API) handleGetMigrations(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/migrations")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return
+	}
+
+	schemaVersion, dirty, err := keppel.GetSchemaVersion(a.db.Db)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	pendingMigrations, err := keppel.CheckPendingMigrations(a.db.Db)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if pendingMigrations == nil {
+		pendingMigrations = []keppel.PendingMigration{}
+	}
+
+	status, err := keppel.GetBackgroundMigrationStatus(a.db)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	backgroundMigrations := make([]BackgroundMigration, len(status))
+	for idx, s := range status {
+		bm := BackgroundMigration{
+			Name:          s.Name,
+			ProcessedRows: s.ProcessedRows,
+			FinishedAt:    keppel.MaybeTimeToUnix(s.FinishedAt),
+		}
+		if !s.StartedAt.IsZero() {
+			bm.StartedAt = keppel.MaybeTimeToUnix(&s.StartedAt)
+		}
+		if s.FinishedAt == nil {
+			bm.RemainingRows, err = keppel.BackgroundMigrations[idx].CountRemainingRows(a.db)
+			if respondwith.ErrorText(w, err) {
+				return
+			}
+		}
+		backgroundMigrations[idx] = bm
+	}
+
+	respondwith.JSON(w, http.StatusOK, map[string]any{
+		"schema": map[string]any{
+			"version":        schemaVersion,
+			"latest_version": keppel.LatestSchemaVersion(),
+			"dirty":          dirty,
+		},
+		"pending_migrations":    pendingMigrations,
+		"background_migrations": backgroundMigrations,
+	})
+}
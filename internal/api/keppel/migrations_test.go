@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestMigrationsAPI(t *testing.T) {
+	s := test.NewSetup(t, test.WithKeppelAPI)
+	h := s.Handler
+
+	// showing migrations requires cluster-admin permission
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/migrations",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// the test DB is always fully migrated
+	latestVersion := keppel.LatestSchemaVersion()
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/migrations",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"schema": assert.JSONObject{
+				"version":        latestVersion,
+				"latest_version": latestVersion,
+				"dirty":          false,
+			},
+			"pending_migrations":    []assert.JSONObject{},
+			"background_migrations": []assert.JSONObject{},
+		},
+	}.Check(t, h)
+
+	// background migrations are listed with their progress
+	keppel.BackgroundMigrations = []keppel.BackgroundMigration{{
+		Name:           "backfill_accounts_foo",
+		BatchQuery:     `UPDATE accounts SET required_labels = 'foo' WHERE name IN (SELECT name FROM accounts WHERE required_labels = '' LIMIT $1)`,
+		RemainingQuery: `SELECT COUNT(*) FROM accounts WHERE required_labels = ''`,
+	}}
+	defer func() { keppel.BackgroundMigrations = nil }()
+	mustExec(t, s.DB, `INSERT INTO accounts (name, auth_tenant_id) VALUES ('test1', 'tenant1')`)
+	mustExec(t, s.DB, `INSERT INTO background_migrations (name, processed_rows, started_at) VALUES ($1, $2, $3)`,
+		"backfill_accounts_foo", 42, s.Clock.Now())
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/migrations",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"schema": assert.JSONObject{
+				"version":        latestVersion,
+				"latest_version": latestVersion,
+				"dirty":          false,
+			},
+			"pending_migrations": []assert.JSONObject{},
+			"background_migrations": []assert.JSONObject{{
+				"name":           "backfill_accounts_foo",
+				"processed_rows": 42,
+				"remaining_rows": 1,
+				"started_at":     s.Clock.Now().Unix(),
+			}},
+		},
+	}.Check(t, h)
+}
@@ -0,0 +1,275 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var overlappingNamespaceExistsQuery = sqlext.SimplifyWhitespace(`
+	SELECT COUNT(*) > 0 FROM repo_namespaces
+	 WHERE account_name = $1 AND (starts_with($2, prefix || '/') OR starts_with(prefix, $2 || '/'))
+`)
+
+func (a *API) handleGetNamespaces(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/namespaces")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var dbNamespaces []models.RepositoryNamespace
+	_, err := a.db.Select(&dbNamespaces, `SELECT * FROM repo_namespaces WHERE account_name = $1 ORDER BY prefix`, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	namespaces := make([]keppel.RepositoryNamespace, len(dbNamespaces))
+	for idx, ns := range dbNamespaces {
+		namespaces[idx], err = a.renderNamespace(ns)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"namespaces": namespaces})
+}
+
+func (a *API) handlePutNamespace(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/namespaces/:prefix")
+	// this endpoint can be used by namespace admins who do not have permission
+	// to change the account, so the precise permission check happens below
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	prefix := mux.Vars(r)["prefix"]
+	if !keppel.IsValidNamespacePrefix(prefix) {
+		http.Error(w, "namespace prefix invalid", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var req struct {
+		Namespace keppel.RepositoryNamespace `json:"namespace"`
+	}
+	if !decodeJSONRequestBody(w, r.Body, &req) {
+		return
+	}
+	if req.Namespace.Prefix != "" && req.Namespace.Prefix != prefix {
+		http.Error(w, `malformed attribute "namespace.prefix" does not match the URL`, http.StatusUnprocessableEntity)
+		return
+	}
+
+	// check permissions: account admins can change everything, but namespace admins can only change the policies
+	var existing models.RepositoryNamespace
+	err := a.db.SelectOne(&existing, `SELECT * FROM repo_namespaces WHERE account_name = $1 AND prefix = $2`, account.Name, prefix)
+	isNew := errors.Is(err, sql.ErrNoRows)
+	if !isNew && respondwith.ErrorText(w, err) {
+		return
+	}
+	if !authz.UserIdentity.HasPermission(keppel.CanChangeAccount, account.AuthTenantID) {
+		if isNew || !keppel.IsNamespaceAdmin(existing, authz.UserIdentity) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if string(req.Namespace.AdminUserNamePattern) != existing.AdminUserNamePattern || !equalQuotas(req.Namespace.ManifestQuota, existing.ManifestQuota) {
+			http.Error(w, "namespace admins may only change the policies of their namespace", http.StatusForbidden)
+			return
+		}
+	}
+
+	// validate policies
+	if req.Namespace.AdminUserNamePattern != "" {
+		_, err := req.Namespace.AdminUserNamePattern.Regexp()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	replicationStrategy := keppel.NoReplicationStrategy
+	if rp := keppel.RenderReplicationPolicy(*account); rp != nil {
+		replicationStrategy = rp.Strategy
+	}
+	for idx, policy := range req.Namespace.RBACPolicies {
+		err := policy.ValidateAndNormalize(replicationStrategy)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		req.Namespace.RBACPolicies[idx] = policy
+	}
+	for _, policy := range req.Namespace.GCPolicies {
+		err := policy.Validate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	// namespaces may not be nested, otherwise quotas and policies would become ambiguous
+	if isNew {
+		isOverlapping, err := a.db.SelectBool(overlappingNamespaceExistsQuery, account.Name, prefix)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+		if isOverlapping {
+			http.Error(w, "namespace overlaps with an existing namespace", http.StatusConflict)
+			return
+		}
+	}
+
+	ns := models.RepositoryNamespace{
+		AccountName:          account.Name,
+		Prefix:               prefix,
+		AdminUserNamePattern: string(req.Namespace.AdminUserNamePattern),
+		ManifestQuota:        req.Namespace.ManifestQuota,
+	}
+	if len(req.Namespace.RBACPolicies) > 0 {
+		buf, _ := json.Marshal(req.Namespace.RBACPolicies)
+		ns.RBACPoliciesJSON = string(buf)
+	}
+	if len(req.Namespace.GCPolicies) > 0 {
+		buf, _ := json.Marshal(req.Namespace.GCPolicies)
+		ns.GCPoliciesJSON = string(buf)
+	}
+
+	// if the account has an approval policy, changes to the GC policies may
+	// need to be split off into a pending change
+	pendingChange, err := a.processor().PendingChangeForNamespaceUpdate(*account, existing, &ns)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	action := cadf.UpdateAction
+	if isNew {
+		action = cadf.CreateAction
+		err = a.db.Insert(&ns)
+	} else {
+		_, err = a.db.Update(&ns)
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if ns.RBACPoliciesJSON != existing.RBACPoliciesJSON {
+		err = keppel.BumpRBACPolicyEpoch(a.db, account.Name)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+	}
+
+	rendered, err := a.renderNamespace(ns)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     action,
+			Target:     AuditRepositoryNamespace{Account: *account, Namespace: rendered},
+		})
+	}
+	if pendingChange == nil {
+		respondwith.JSON(w, http.StatusOK, map[string]any{"namespace": rendered})
+		return
+	}
+
+	err = a.processor().SubmitPendingChange(account.Reduced(), pendingChange, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	pendingChangeRendered, err := keppel.RenderPendingChange(*pendingChange)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusAccepted, map[string]any{"namespace": rendered, "pending_changes": []keppel.PendingChange{pendingChangeRendered}})
+}
+
+func (a *API) handleDeleteNamespace(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/namespaces/:prefix")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var ns models.RepositoryNamespace
+	err := a.db.SelectOne(&ns, `SELECT * FROM repo_namespaces WHERE account_name = $1 AND prefix = $2`, account.Name, mux.Vars(r)["prefix"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such namespace", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	rendered, err := a.renderNamespace(ns)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	_, err = a.db.Delete(&ns)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if ns.RBACPoliciesJSON != "" {
+		err = keppel.BumpRBACPolicyEpoch(a.db, account.Name)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusNoContent,
+			Action:     cadf.DeleteAction,
+			Target:     AuditRepositoryNamespace{Account: *account, Namespace: rendered},
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) renderNamespace(ns models.RepositoryNamespace) (keppel.RepositoryNamespace, error) {
+	usage, err := keppel.GetNamespaceManifestUsage(a.db, ns)
+	if err != nil {
+		return keppel.RepositoryNamespace{}, fmt.Errorf("cannot get manifest usage of namespace %q: %w", ns.Prefix, err)
+	}
+	return keppel.RenderRepositoryNamespace(ns, usage)
+}
+
+func equalQuotas(lhs, rhs *uint64) bool {
+	if lhs == nil || rhs == nil {
+		return lhs == rhs
+	}
+	return *lhs == *rhs
+}
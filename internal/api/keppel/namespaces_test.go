@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestNamespacesAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/namespaces",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"namespaces": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// creating a namespace requires permission to change the account
+	namespace := assert.JSONObject{
+		"prefix":               "team-a",
+		"match_admin_username": "correctusername",
+		"manifest_quota":       10,
+		"manifest_usage":       0,
+		"rbac_policies":        []assert.JSONObject{},
+		"gc_policies":          []assert.JSONObject{},
+	}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/namespaces/team-a",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         assert.JSONObject{"namespace": namespace},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// error cases
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/namespaces/Team-A",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"namespace": assert.JSONObject{}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("namespace prefix invalid\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1/namespaces/team-a",
+		Header: map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body: assert.JSONObject{"namespace": assert.JSONObject{
+			"rbac_policies": []assert.JSONObject{{"match_repository": ".*", "permissions": []string{"pull"}}},
+		}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("RBAC policy with \"pull\" must have the \"match_cidr\" or \"match_username\" attribute\n"),
+	}.Check(t, h)
+
+	// happy path: account admin creates a namespace
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/namespaces/team-a",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"namespace": namespace},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"namespace": namespace},
+	}.Check(t, h)
+
+	// namespaces may not be nested
+	for _, prefix := range []string{"team-a/sub", "team-a/sub/sub"} {
+		assert.HTTPRequest{
+			Method:       "PUT",
+			Path:         "/keppel/v1/accounts/test1/namespaces/" + prefix,
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+			Body:         assert.JSONObject{"namespace": assert.JSONObject{}},
+			ExpectStatus: http.StatusConflict,
+			ExpectBody:   assert.StringData("namespace overlaps with an existing namespace\n"),
+		}.Check(t, h)
+	}
+
+	// the namespace admin can change the policies of the namespace...
+	namespaceWithPolicies := assert.JSONObject{
+		"prefix":               "team-a",
+		"match_admin_username": "correctusername",
+		"manifest_quota":       10,
+		"manifest_usage":       0,
+		"rbac_policies": []assert.JSONObject{{
+			"match_repository": "public/.*",
+			"permissions":      []string{"anonymous_pull"},
+		}},
+		"gc_policies": []assert.JSONObject{{
+			"match_repository": ".*",
+			"only_untagged":    true,
+			"action":           "delete",
+		}},
+	}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/namespaces/team-a",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         assert.JSONObject{"namespace": namespaceWithPolicies},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"namespace": namespaceWithPolicies},
+	}.Check(t, h)
+
+	// ...but not the quota or the set of admins
+	namespaceWithOtherQuota := assert.JSONObject{
+		"prefix":               "team-a",
+		"match_admin_username": "correctusername",
+		"manifest_quota":       100,
+	}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/namespaces/team-a",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         assert.JSONObject{"namespace": namespaceWithOtherQuota},
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.StringData("namespace admins may only change the policies of their namespace\n"),
+	}.Check(t, h)
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/namespaces",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"namespaces": []assert.JSONObject{namespaceWithPolicies}},
+	}.Check(t, h)
+
+	// deleting a namespace requires permission to change the account
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/namespaces/team-a",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/namespaces/team-a",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/namespaces/team-a",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such namespace\n"),
+	}.Check(t, h)
+}
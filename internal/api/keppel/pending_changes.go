@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/processor"
+)
+
+func (a *API) handleGetPendingChanges(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/pending_changes")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var dbChanges []models.PendingChange
+	_, err := a.db.Select(&dbChanges, `SELECT * FROM pending_changes WHERE account_name = $1 ORDER BY id`, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	changes := make([]keppel.PendingChange, len(dbChanges))
+	for idx, pc := range dbChanges {
+		changes[idx], err = keppel.RenderPendingChange(pc)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"pending_changes": changes})
+}
+
+func (a *API) handlePostApprovePendingChange(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/pending_changes/:id/approve")
+	authz, account, pc := a.findPendingChangeFromRequest(w, r)
+	if pc == nil {
+		return
+	}
+
+	err := a.processor().ApprovePendingChange(r.Context(), *account, *pc, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	if errors.Is(err, processor.ErrSelfApproval) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "cannot execute pending change: target does not exist anymore", http.StatusConflict)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) handleDeletePendingChange(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/pending_changes/:id")
+	authz, account, pc := a.findPendingChangeFromRequest(w, r)
+	if pc == nil {
+		return
+	}
+
+	err := a.processor().RejectPendingChange(account.Reduced(), *pc, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Shared preparation for the endpoints that refer to a single pending change.
+// The user must have the permission that would be required for executing the
+// change directly. If the returned change is nil, an error response has been
+// written.
+func (a *API) findPendingChangeFromRequest(w http.ResponseWriter, r *http.Request) (*auth.Authorization, *models.Account, *models.PendingChange) {
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return nil, nil, nil
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return nil, nil, nil
+	}
+
+	var pc models.PendingChange
+	err := a.db.SelectOne(&pc, `SELECT * FROM pending_changes WHERE account_name = $1 AND id = $2`, account.Name, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such pending change", http.StatusNotFound)
+		return nil, nil, nil
+	}
+	if respondwith.ErrorText(w, err) {
+		return nil, nil, nil
+	}
+
+	perm := keppel.CanChangeAccount
+	if pc.Kind == models.PendingManifestDeletion || pc.Kind == models.PendingTagDeletion {
+		perm = keppel.CanDeleteFromAccount
+	}
+	if !authz.UserIdentity.HasPermission(perm, account.AuthTenantID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, nil, nil
+	}
+	return authz, account, &pc
+}
+
+// Submits the given pending change and responds with 202 Accepted, instead of
+// executing an operation that requires approval.
+func (a *API) submitPendingChange(w http.ResponseWriter, r *http.Request, authz *auth.Authorization, account models.ReducedAccount, pc *models.PendingChange) {
+	err := a.processor().SubmitPendingChange(account, pc, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	rendered, err := keppel.RenderPendingChange(*pc)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusAccepted, map[string]any{"pending_change": rendered})
+}
@@ -0,0 +1,433 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestPendingChanges(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	s.AD.ExpectedUserName = "correctusername"
+	changeHeaders := map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1,delete:tenant1"}
+
+	// setup: one repo with one tagged and one untagged manifest
+	repo := models.Repository{Name: "foo", AccountName: "test1"}
+	mustInsert(t, s.DB, &repo)
+	digests := []digest.Digest{digest.FromString("a"), digest.FromString("b")}
+	for _, d := range digests {
+		mustInsert(t, s.DB, &models.Manifest{
+			RepositoryID:     repo.ID,
+			Digest:           d,
+			MediaType:        "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:        1000,
+			PushedAt:         time.Unix(1000, 0),
+			NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+		})
+	}
+	mustInsert(t, s.DB, &models.Tag{RepositoryID: repo.ID, Name: "latest", Digest: digests[0], PushedAt: time.Unix(1000, 0)})
+	s.Clock.StepBy(time.Hour)
+
+	// setting up an approval policy does not require approval
+	approvalPolicy := assert.JSONObject{"tag_deletion_threshold": 0, "gc_manifest_threshold": 0}
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1",
+		Header: changeHeaders,
+		Body: assert.JSONObject{
+			"account": assert.JSONObject{"auth_tenant_id": "tenant1", "approval_policy": approvalPolicy},
+		},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"account": assert.JSONObject{
+				"name":            "test1",
+				"auth_tenant_id":  "tenant1",
+				"approval_policy": approvalPolicy,
+				"rbac_policies":   []assert.JSONObject{},
+				"metadata":        nil,
+			},
+		},
+	}.Check(t, h)
+
+	// tag deletion now requires approval
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_tags/latest",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusAccepted,
+		ExpectBody: assert.JSONObject{
+			"pending_change": assert.JSONObject{
+				"id":           1,
+				"kind":         "delete_tag",
+				"payload":      assert.JSONObject{"repository": "foo", "digest": digests[0], "tag": "latest", "tag_count": 1},
+				"requested_at": s.Clock.Now().Unix(),
+				"requested_by": "correctusername",
+			},
+		},
+	}.Check(t, h)
+	tagCount, err := s.DB.SelectInt(`SELECT COUNT(*) FROM tags`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "tag count", tagCount, int64(1))
+
+	// deleting an untagged manifest does not delete any tags, so it does not require approval
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + digests[1].String(),
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+
+	// pending changes can be listed by anyone who can view the account
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/pending_changes",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"pending_changes": []assert.JSONObject{{
+				"id":           1,
+				"kind":         "delete_tag",
+				"payload":      assert.JSONObject{"repository": "foo", "digest": digests[0], "tag": "latest", "tag_count": 1},
+				"requested_at": s.Clock.Now().Unix(),
+				"requested_by": "correctusername",
+			}},
+		},
+	}.Check(t, h)
+
+	// approving requires the permission that the change itself requires...
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/pending_changes/1/approve",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	// ...and must be done by a different user than the requester
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/pending_changes/1/approve",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.StringData("pending changes must be approved by a different user than the one who requested them\n"),
+	}.Check(t, h)
+	// (users are compared by their full identity, not by their user name, which
+	// can differ e.g. depending on the scope of their credentials)
+	mustExec(t, s.DB, `UPDATE pending_changes SET requested_by = $1`, "otherusername")
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/pending_changes/1/approve",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.StringData("pending changes must be approved by a different user than the one who requested them\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/pending_changes/2/approve",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusNotFound,
+	}.Check(t, h)
+
+	// happy path: approval by a different user executes the change
+	mustExec(t, s.DB, `UPDATE pending_changes SET requested_by = $1, requested_by_id = $2`, "otherusername", "unittest:otherusername")
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/pending_changes/1/approve",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	tagCount, err = s.DB.SelectInt(`SELECT COUNT(*) FROM tags`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "tag count", tagCount, int64(0))
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/pending_changes",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"pending_changes": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// a GC policy change that would delete manifests requires approval, and
+	// so does removing the approval policy; all other changes are applied immediately
+	gcPolicy := assert.JSONObject{"match_repository": ".*", "only_untagged": true, "action": "delete"}
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1",
+		Header: changeHeaders,
+		Body: assert.JSONObject{
+			"account": assert.JSONObject{
+				"auth_tenant_id": "tenant1",
+				"gc_policies":    []assert.JSONObject{gcPolicy},
+				"validation":     assert.JSONObject{"required_labels": []string{"foo"}},
+			},
+		},
+		ExpectStatus: http.StatusAccepted,
+		ExpectBody: assert.JSONObject{
+			"account": assert.JSONObject{
+				"name":            "test1",
+				"auth_tenant_id":  "tenant1",
+				"approval_policy": approvalPolicy,
+				"rbac_policies":   []assert.JSONObject{},
+				"validation":      assert.JSONObject{"required_labels": []string{"foo"}},
+				"metadata":        nil,
+			},
+			"pending_changes": []assert.JSONObject{
+				{
+					"id":           2,
+					"kind":         "update_approval_policy",
+					"payload":      assert.JSONObject{},
+					"requested_at": s.Clock.Now().Unix(),
+					"requested_by": "correctusername",
+				},
+				{
+					"id":           3,
+					"kind":         "update_gc_policies",
+					"payload":      assert.JSONObject{"gc_policies": []assert.JSONObject{gcPolicy}, "manifest_count": 1},
+					"requested_at": s.Clock.Now().Unix(),
+					"requested_by": "correctusername",
+				},
+			},
+		},
+	}.Check(t, h)
+
+	// rejecting a pending change discards it without executing it
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/pending_changes/2",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+
+	// approving the GC policy change applies it
+	mustExec(t, s.DB, `UPDATE pending_changes SET requested_by = $1, requested_by_id = $2`, "otherusername", "unittest:otherusername")
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/pending_changes/3/approve",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"account": assert.JSONObject{
+				"name":            "test1",
+				"auth_tenant_id":  "tenant1",
+				"approval_policy": approvalPolicy,
+				"gc_policies":     []assert.JSONObject{gcPolicy},
+				"rbac_policies":   []assert.JSONObject{},
+				"validation":      assert.JSONObject{"required_labels": []string{"foo"}},
+				"metadata":        nil,
+			},
+		},
+	}.Check(t, h)
+
+	// account deletion always requires approval
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusAccepted,
+		ExpectBody: assert.JSONObject{
+			"pending_change": assert.JSONObject{
+				"id":           4,
+				"kind":         "delete_account",
+				"payload":      assert.JSONObject{},
+				"requested_at": s.Clock.Now().Unix(),
+				"requested_by": "correctusername",
+			},
+		},
+	}.Check(t, h)
+	mustExec(t, s.DB, `UPDATE pending_changes SET requested_by = $1, requested_by_id = $2`, "otherusername", "unittest:otherusername")
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/pending_changes/4/approve",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	isDeleting, err := s.DB.SelectBool(`SELECT is_deleting FROM accounts WHERE name = $1`, "test1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "is_deleting", isDeleting, true)
+}
+
+func TestPendingChangesForRepositoryGCPolicies(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1", ApprovalPolicyJSON: `{"gc_manifest_threshold":0}`}),
+	)
+	h := s.Handler
+	s.AD.ExpectedUserName = "correctusername"
+	changeHeaders := map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"}
+
+	// setup: one repo with one untagged manifest
+	repo := models.Repository{Name: "foo", AccountName: "test1"}
+	mustInsert(t, s.DB, &repo)
+	mustInsert(t, s.DB, &models.Manifest{
+		RepositoryID:     repo.ID,
+		Digest:           digest.FromString("a"),
+		MediaType:        "application/vnd.oci.image.manifest.v1+json",
+		SizeBytes:        1000,
+		PushedAt:         time.Unix(1000, 0),
+		NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+	})
+	s.Clock.StepBy(time.Hour)
+
+	// repo settings that do not make GC delete anything are applied immediately
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo",
+		Header:       changeHeaders,
+		Body:         assert.JSONObject{"repository": assert.JSONObject{"ignore_inherited_gc_policies": true}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repository": assert.JSONObject{"name": "foo", "manifest_count": 1, "tag_count": 0, "pushed_at": 1000, "ignore_inherited_gc_policies": true},
+		},
+	}.Check(t, h)
+
+	// repo-level GC policies that would delete manifests require approval just
+	// like account-level GC policies; all other changes are applied immediately
+	gcPolicy := assert.JSONObject{"match_repository": ".*", "only_untagged": true, "action": "delete"}
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1/repositories/foo",
+		Header: changeHeaders,
+		Body: assert.JSONObject{"repository": assert.JSONObject{
+			"storage_quota_bytes": 5000,
+			"gc_policies":         []assert.JSONObject{{"only_untagged": true, "action": "delete"}},
+		}},
+		ExpectStatus: http.StatusAccepted,
+		ExpectBody: assert.JSONObject{
+			"repository": assert.JSONObject{"name": "foo", "manifest_count": 1, "tag_count": 0, "pushed_at": 1000, "storage_quota_bytes": 5000, "ignore_inherited_gc_policies": true},
+			"pending_changes": []assert.JSONObject{{
+				"id":   1,
+				"kind": "update_repo_gc_policies",
+				"payload": assert.JSONObject{
+					"repository":                   "foo",
+					"manifest_count":               1,
+					"gc_policies":                  []assert.JSONObject{gcPolicy},
+					"archived":                     false,
+					"ignore_inherited_gc_policies": true,
+				},
+				"requested_at": s.Clock.Now().Unix(),
+				"requested_by": "correctusername",
+			}},
+		},
+	}.Check(t, h)
+
+	// approving the change applies it
+	mustExec(t, s.DB, `UPDATE pending_changes SET requested_by = $1, requested_by_id = $2`, "otherusername", "unittest:otherusername")
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/pending_changes/1/approve",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"repositories": []assert.JSONObject{{
+			"name":                         "foo",
+			"manifest_count":               1,
+			"tag_count":                    0,
+			"pushed_at":                    1000,
+			"storage_quota_bytes":          5000,
+			"gc_policies":                  []assert.JSONObject{gcPolicy},
+			"ignore_inherited_gc_policies": true,
+		}}},
+	}.Check(t, h)
+}
+
+func TestPendingChangesForNamespaceGCPolicies(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1", ApprovalPolicyJSON: `{"gc_manifest_threshold":0}`}),
+	)
+	h := s.Handler
+	s.AD.ExpectedUserName = "correctusername"
+	changeHeaders := map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"}
+
+	// setup: one repo within the namespace with one untagged manifest
+	repo := models.Repository{Name: "team-a/foo", AccountName: "test1"}
+	mustInsert(t, s.DB, &repo)
+	mustInsert(t, s.DB, &models.Manifest{
+		RepositoryID:     repo.ID,
+		Digest:           digest.FromString("a"),
+		MediaType:        "application/vnd.oci.image.manifest.v1+json",
+		SizeBytes:        1000,
+		PushedAt:         time.Unix(1000, 0),
+		NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+	})
+	s.Clock.StepBy(time.Hour)
+
+	// namespace GC policies that would delete manifests require approval just
+	// like account-level GC policies; the rest of the namespace is created immediately
+	gcPolicy := assert.JSONObject{"match_repository": ".*", "only_untagged": true, "action": "delete"}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/namespaces/team-a",
+		Header:       changeHeaders,
+		Body:         assert.JSONObject{"namespace": assert.JSONObject{"gc_policies": []assert.JSONObject{gcPolicy}}},
+		ExpectStatus: http.StatusAccepted,
+		ExpectBody: assert.JSONObject{
+			"namespace": assert.JSONObject{
+				"prefix":         "team-a",
+				"manifest_usage": 1,
+				"rbac_policies":  []assert.JSONObject{},
+				"gc_policies":    []assert.JSONObject{},
+			},
+			"pending_changes": []assert.JSONObject{{
+				"id":   1,
+				"kind": "update_namespace_gc_policies",
+				"payload": assert.JSONObject{
+					"namespace":      "team-a",
+					"manifest_count": 1,
+					"gc_policies":    []assert.JSONObject{gcPolicy},
+				},
+				"requested_at": s.Clock.Now().Unix(),
+				"requested_by": "correctusername",
+			}},
+		},
+	}.Check(t, h)
+
+	// approving the change applies it
+	mustExec(t, s.DB, `UPDATE pending_changes SET requested_by = $1, requested_by_id = $2`, "otherusername", "unittest:otherusername")
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/pending_changes/1/approve",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/namespaces",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"namespaces": []assert.JSONObject{{
+			"prefix":         "team-a",
+			"manifest_usage": 1,
+			"rbac_policies":  []assert.JSONObject{},
+			"gc_policies":    []assert.JSONObject{gcPolicy},
+		}}},
+	}.Check(t, h)
+}
@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/processor"
+)
+
+func (a *API) handlePostPromoteManifest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/promote")
+	// the "promote" action is granted to account admins by default, and can be
+	// granted to or withheld from specific users through RBAC policies
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.Scope{
+		ResourceType: "repository",
+		ResourceName: fmt.Sprintf("%s/%s", mux.Vars(r)["account"], mux.Vars(r)["repo_name"]),
+		Actions:      []string{"promote"},
+	}))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+	manifestDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "digest not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		State models.PromotionState `json:"state"`
+	}
+	if !decodeJSONRequestBody(w, r.Body, &req) {
+		return
+	}
+	if req.State == models.PromotionStateNone {
+		http.Error(w, `missing "state" attribute`, http.StatusUnprocessableEntity)
+		return
+	}
+	if !req.State.IsValid() {
+		http.Error(w, fmt.Sprintf("%q is not a valid promotion state", req.State), http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = a.processor().PromoteManifest(account.Reduced(), *repo, manifestDigest, req.State, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	var errAlreadyInState processor.ErrManifestAlreadyInPromotionState
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "no such manifest", http.StatusNotFound)
+	case errors.As(err, &errAlreadyInState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		if !respondwith.ErrorText(w, err) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/easypg"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestPromotionAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	// the RBAC policies below match on this username
+	s.AD.ExpectedUserName = "correctusername"
+
+	repo := models.Repository{Name: "foo", AccountName: "test1"}
+	mustInsert(t, s.DB, &repo)
+	parentDigest := digest.FromString("parent")
+	childDigest := digest.FromString("child")
+	for _, manifestDigest := range []digest.Digest{parentDigest, childDigest} {
+		mustInsert(t, s.DB, &models.Manifest{
+			RepositoryID:     repo.ID,
+			Digest:           manifestDigest,
+			MediaType:        "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:        1000,
+			PushedAt:         time.Unix(1000, 0),
+			NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+		})
+	}
+	mustExec(t, s.DB, `INSERT INTO manifest_manifest_refs (repo_id, parent_digest, child_digest) VALUES ($1, $2, $3)`,
+		repo.ID, parentDigest, childDigest)
+	manifestPath := "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + parentDigest.String()
+
+	// changing the promotion state requires account admin permission by default
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/promote",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1,push:tenant1,delete:tenant1"},
+		Body:         assert.JSONObject{"state": "staging"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// error cases
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/promote",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("missing \"state\" attribute\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/promote",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"state": "qa"},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("\"qa\" is not a valid promotion state\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + digest.FromString("other").String() + "/promote",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"state": "staging"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such manifest\n"),
+	}.Check(t, h)
+	s.Auditor.ExpectEvents(t /*, nothing */)
+
+	// happy path: promote the manifest (the child manifest moves along)
+	tr, _ := easypg.NewTracker(t, s.DB.Db)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/promote",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"state": "staging"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	tr.DBChanges().AssertEqualf(`
+			UPDATE manifests SET promotion_state = 'staging' WHERE repo_id = 1 AND digest = '%[1]s';
+			UPDATE manifests SET promotion_state = 'staging' WHERE repo_id = 1 AND digest = '%[2]s';
+		`,
+		childDigest, parentDigest,
+	)
+	s.Auditor.ExpectEvents(t, cadf.Event{
+		RequestPath: manifestPath + "/promote",
+		Action:      cadf.UpdateAction,
+		Outcome:     "success",
+		Reason:      test.CADFReasonOK,
+		Target: cadf.Resource{
+			TypeURI:   "docker-registry/account/repository/manifest",
+			Name:      "test1/foo@" + parentDigest.String(),
+			ID:        parentDigest.String(),
+			ProjectID: "tenant1",
+			Attachments: []cadf.Attachment{{
+				Name:    "promotion",
+				TypeURI: "mime:application/json",
+				Content: test.ToJSON(assert.JSONObject{"previous_state": "", "new_state": "staging"}),
+			}},
+		},
+	})
+
+	// promoting into the same state again is a conflict
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/promote",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"state": "staging"},
+		ExpectStatus: http.StatusConflict,
+		ExpectBody:   assert.StringData("manifest is already in promotion state \"staging\"\n"),
+	}.Check(t, h)
+	tr.DBChanges().AssertEmpty()
+
+	// RBAC policies can allow specific users to promote...
+	grantingPolicyJSON := `[{"match_username":"correctusername","permissions":["promote"]}]`
+	mustExec(t, s.DB, `UPDATE accounts SET rbac_policies_json = $2 WHERE name = $1`, "test1", grantingPolicyJSON)
+	tr.DBChanges().Ignore()
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/promote",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		Body:         assert.JSONObject{"state": "prod"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	flushRBACPolicyUsage(t, s)
+	tr.DBChanges().AssertEqualf(`
+			UPDATE manifests SET promotion_state = 'prod' WHERE repo_id = 1 AND digest = '%[1]s';
+			UPDATE manifests SET promotion_state = 'prod' WHERE repo_id = 1 AND digest = '%[2]s';
+			INSERT INTO rbac_policy_usage (account_name, policy_fingerprint, first_seen_at, last_used_at) VALUES ('test1', '%[3]s', %[4]d, %[4]d);
+		`,
+		childDigest, parentDigest, rbacPolicyFingerprint(t, grantingPolicyJSON), s.Clock.Now().Unix(),
+	)
+	s.Auditor.IgnoreEventsUntilNow()
+
+	// ...or forbid account admins from doing so
+	forbiddingPolicyJSON := `[{"match_username":"correctusername","forbidden_permissions":["promote"]}]`
+	mustExec(t, s.DB, `UPDATE accounts SET rbac_policies_json = $2 WHERE name = $1`, "test1", forbiddingPolicyJSON)
+	tr.DBChanges().Ignore()
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/promote",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"state": "dev"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	flushRBACPolicyUsage(t, s)
+	tr.DBChanges().AssertEqualf(`
+			INSERT INTO rbac_policy_usage (account_name, policy_fingerprint, first_seen_at, last_used_at) VALUES ('test1', '%[1]s', %[2]d, %[2]d);
+		`,
+		rbacPolicyFingerprint(t, forbiddingPolicyJSON), s.Clock.Now().Unix(),
+	)
+}
+
+func flushRBACPolicyUsage(t *testing.T, s test.Setup) {
+	t.Helper()
+	err := s.RBACPolicyUsage.Flush()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func rbacPolicyFingerprint(t *testing.T, policiesJSON string) string {
+	t.Helper()
+	policies, err := keppel.ParseRBACPoliciesField(policiesJSON)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return policies[0].Fingerprint()
+}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// PullAttestation is the API representation of a models.PullAttestation.
+type PullAttestation struct {
+	AccountName    models.AccountName `json:"account"`
+	RepositoryName string             `json:"repository"`
+	Digest         digest.Digest      `json:"digest"`
+	ClientID       string             `json:"client_id"`
+	FirstPulledAt  int64              `json:"first_pulled_at"`
+	LastPulledAt   int64              `json:"last_pulled_at"`
+	Count          uint64             `json:"count"`
+}
+
+var pullAttestationsQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM pull_attestations
+	 WHERE digest = $1 AND ($2 = '' OR client_id = $2)
+	 ORDER BY account_name, repo_name, client_id
+`)
+
+func (a *API) handleGetPullAttestations(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/pull_attestations")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	manifestDigest, err := digest.Parse(query.Get("digest"))
+	if err != nil {
+		http.Error(w, `query parameter "digest" must be a valid digest`, http.StatusBadRequest)
+		return
+	}
+
+	var dbAttestations []models.PullAttestation
+	_, err = a.db.Select(&dbAttestations, pullAttestationsQuery, manifestDigest.String(), query.Get("client_id"))
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	attestations := make([]PullAttestation, len(dbAttestations))
+	for idx, pa := range dbAttestations {
+		attestations[idx] = PullAttestation{
+			AccountName:    pa.AccountName,
+			RepositoryName: pa.RepositoryName,
+			Digest:         pa.Digest,
+			ClientID:       pa.ClientID,
+			FirstPulledAt:  pa.FirstPulledAt.Unix(),
+			LastPulledAt:   pa.LastPulledAt.Unix(),
+			Count:          pa.PullCount,
+		}
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"pull_attestations": attestations})
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestPullAttestationsAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	compromisedDigest := test.GenerateExampleLayer(1).Digest.String()
+	otherDigest := test.GenerateExampleLayer(2).Digest.String()
+
+	// listing pull attestations requires cluster-admin permission
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/pull_attestations?digest=" + compromisedDigest,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// the digest is required
+	for _, query := range []string{"", "?digest=", "?digest=foo"} {
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/pull_attestations" + query,
+			Header:       map[string]string{"X-Test-Perms": "admin:"},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   assert.StringData("query parameter \"digest\" must be a valid digest\n"),
+		}.Check(t, h)
+	}
+
+	// without any recorded pulls, an empty list is returned
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/pull_attestations?digest=" + compromisedDigest,
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"pull_attestations": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// record some pulls
+	for _, accountName := range []string{"test2", "test1"} {
+		for _, clientID := range []string{"cluster-b", "cluster-a"} {
+			mustExec(t, s.DB, `INSERT INTO pull_attestations (account_name, repo_name, digest, client_id, first_pulled_at, last_pulled_at, pull_count) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				accountName, "foo", compromisedDigest, clientID, time.Unix(10, 0), time.Unix(20, 0), 3)
+		}
+	}
+	mustExec(t, s.DB, `INSERT INTO pull_attestations (account_name, repo_name, digest, client_id, first_pulled_at, last_pulled_at, pull_count) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		"test1", "foo", otherDigest, "cluster-c", time.Unix(10, 0), time.Unix(20, 0), 1)
+
+	makeAttestation := func(accountName, clientID string) assert.JSONObject {
+		return assert.JSONObject{
+			"account":         accountName,
+			"repository":      "foo",
+			"digest":          compromisedDigest,
+			"client_id":       clientID,
+			"first_pulled_at": 10,
+			"last_pulled_at":  20,
+			"count":           3,
+		}
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/pull_attestations?digest=" + compromisedDigest,
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"pull_attestations": []assert.JSONObject{
+			makeAttestation("test1", "cluster-a"),
+			makeAttestation("test1", "cluster-b"),
+			makeAttestation("test2", "cluster-a"),
+			makeAttestation("test2", "cluster-b"),
+		}},
+	}.Check(t, h)
+
+	// the result can be restricted to a single client
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/pull_attestations?client_id=cluster-b&digest=" + compromisedDigest,
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"pull_attestations": []assert.JSONObject{
+			makeAttestation("test1", "cluster-b"),
+			makeAttestation("test2", "cluster-b"),
+		}},
+	}.Check(t, h)
+}
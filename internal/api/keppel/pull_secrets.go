@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// KubernetesSecret is the subset of the Kubernetes Secret resource that is
+// generated by the pull secret endpoint.
+type KubernetesSecret struct {
+	APIVersion string                   `json:"apiVersion"`
+	Kind       string                   `json:"kind"`
+	Metadata   KubernetesSecretMetadata `json:"metadata"`
+	Type       string                   `json:"type"`
+	// The values are serialized in base64 encoding, as required by Kubernetes.
+	Data map[string][]byte `json:"data"`
+}
+
+// KubernetesSecretMetadata appears in type KubernetesSecret.
+type KubernetesSecretMetadata struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// RobotCredential is how a models.RobotCredential is rendered in API
+// responses. The password is never shown after the credential was created.
+type RobotCredential struct {
+	UserName  string `json:"user_name"`
+	CreatedAt int64  `json:"created_at"`
+	CreatedBy string `json:"created_by,omitempty"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func renderRobotCredential(cred models.RobotCredential) RobotCredential {
+	return RobotCredential{
+		UserName:  cred.UserName,
+		CreatedAt: cred.CreatedAt.Unix(),
+		CreatedBy: cred.CreatedBy,
+		ExpiresAt: cred.ExpiresAt.Unix(),
+	}
+}
+
+const (
+	// default and maximum lifetime of robot credentials generated for pull secrets
+	robotCredentialDefaultLifetimeDays = 90
+	robotCredentialMaxLifetimeDays     = 365
+)
+
+var (
+	// Kubernetes requires object names to be DNS subdomains, and namespace names to be DNS labels.
+	kubernetesNameRx      = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+	kubernetesNamespaceRx = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	// when deriving the default Secret name from the repo name, all other characters are replaced by dashes
+	kubernetesNameInvalidCharsRx = regexp.MustCompile(`[^a-z0-9.]+`)
+)
+
+func (a *API) handlePostPullSecret(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_pull_secret")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	secretName := query.Get("name")
+	if secretName == "" {
+		secretName = strings.Trim(kubernetesNameInvalidCharsRx.ReplaceAllString(fmt.Sprintf("keppel-%s-%s", account.Name, repo.Name), "-"), "-.")
+	}
+	if len(secretName) > 253 || !kubernetesNameRx.MatchString(secretName) {
+		http.Error(w, `invalid value for "name": must be a valid Kubernetes object name`, http.StatusUnprocessableEntity)
+		return
+	}
+	namespace := query.Get("namespace")
+	if namespace != "" && (len(namespace) > 63 || !kubernetesNamespaceRx.MatchString(namespace)) {
+		http.Error(w, `invalid value for "namespace": must be a valid Kubernetes namespace name`, http.StatusUnprocessableEntity)
+		return
+	}
+	lifetimeDays := uint64(robotCredentialDefaultLifetimeDays)
+	if str := query.Get("expires_in_days"); str != "" {
+		value, err := strconv.ParseUint(str, 10, 16)
+		if err != nil || value == 0 || value > robotCredentialMaxLifetimeDays {
+			msg := fmt.Sprintf(`invalid value for "expires_in_days": must be an integer between 1 and %d`, robotCredentialMaxLifetimeDays)
+			http.Error(w, msg, http.StatusUnprocessableEntity)
+			return
+		}
+		lifetimeDays = value
+	}
+
+	now := a.timeNow()
+	expiresAt := now.Add(time.Duration(lifetimeDays) * 24 * time.Hour)
+	cred, password, err := auth.NewRobotCredential(a.db, *repo, authz.UserIdentity.UserName(), now, expiresAt)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusCreated,
+			Action:     cadf.CreateAction,
+			Target:     AuditRobotCredential{Account: *account, Repository: *repo, Credential: cred},
+		})
+	}
+
+	// see <https://kubernetes.io/docs/concepts/configuration/secret/#docker-config-secrets>
+	type dockerAuth struct {
+		UserName string `json:"username"`
+		Password string `json:"password"`
+		Auth     []byte `json:"auth"`
+	}
+	dockerConfig, err := json.Marshal(map[string]any{
+		"auths": map[string]dockerAuth{
+			a.cfg.APIPublicHostname: {
+				UserName: cred.UserName,
+				Password: password,
+				Auth:     []byte(cred.UserName + ":" + password),
+			},
+		},
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	respondwith.JSON(w, http.StatusCreated, KubernetesSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: KubernetesSecretMetadata{
+			Name:      secretName,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				"keppel.sapcc.github.io/repository": fmt.Sprintf("%s/%s/%s", a.cfg.APIPublicHostname, account.Name, repo.Name),
+				"keppel.sapcc.github.io/robot-user": cred.UserName,
+				"keppel.sapcc.github.io/expires-at": cred.ExpiresAt.UTC().Format(time.RFC3339),
+			},
+		},
+		Type: "kubernetes.io/dockerconfigjson",
+		Data: map[string][]byte{".dockerconfigjson": dockerConfig},
+	})
+}
+
+func (a *API) handleGetRobotCredentials(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_robot_credentials")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+
+	var creds []models.RobotCredential
+	_, err := a.db.Select(&creds, `SELECT * FROM robot_credentials WHERE repo_id = $1 ORDER BY id`, repo.ID)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	result := make([]RobotCredential, len(creds))
+	for idx, cred := range creds {
+		result[idx] = renderRobotCredential(cred)
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"robot_credentials": result})
+}
+
+func (a *API) handleDeleteRobotCredential(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_robot_credentials/:user_name")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+
+	var cred models.RobotCredential
+	err := a.db.SelectOne(&cred, `SELECT * FROM robot_credentials WHERE repo_id = $1 AND user_name = $2`, repo.ID, mux.Vars(r)["user_name"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such robot credential", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	_, err = a.db.Delete(&cred)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusNoContent,
+			Action:     cadf.DeleteAction,
+			Target:     AuditRobotCredential{Account: *account, Repository: *repo, Credential: cred},
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
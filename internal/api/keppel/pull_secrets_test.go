@@ -0,0 +1,253 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestPullSecretAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{AccountName: "test1", Name: "foo/bar_baz"}),
+		test.WithRepo(models.Repository{AccountName: "test1", Name: "other"}),
+	)
+	h := s.Handler
+
+	// generating pull secrets requires permission to change the account
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/bar_baz/_pull_secret",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// error cases
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/repositories/missing/_pull_secret",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("repo not found\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/bar_baz/_pull_secret?name=Invalid_Name",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("invalid value for \"name\": must be a valid Kubernetes object name\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/bar_baz/_pull_secret?namespace=kube.system",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("invalid value for \"namespace\": must be a valid Kubernetes namespace name\n"),
+	}.Check(t, h)
+	for _, value := range []string{"0", "366", "forever"} {
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/keppel/v1/accounts/test1/repositories/foo/bar_baz/_pull_secret?expires_in_days=" + value,
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+			ExpectStatus: http.StatusUnprocessableEntity,
+			ExpectBody:   assert.StringData("invalid value for \"expires_in_days\": must be an integer between 1 and 365\n"),
+		}.Check(t, h)
+	}
+
+	// happy case: generate a pull secret
+	_, respBody := assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/bar_baz/_pull_secret?namespace=my-app",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusCreated,
+	}.Check(t, h)
+	var secret struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Metadata   struct {
+			Name        string            `json:"name"`
+			Namespace   string            `json:"namespace"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Type string            `json:"type"`
+		Data map[string][]byte `json:"data"`
+	}
+	err := json.Unmarshal(respBody, &secret)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "apiVersion", secret.APIVersion, "v1")
+	assert.DeepEqual(t, "kind", secret.Kind, "Secret")
+	assert.DeepEqual(t, "type", secret.Type, "kubernetes.io/dockerconfigjson")
+	assert.DeepEqual(t, "metadata.name", secret.Metadata.Name, "keppel-test1-foo-bar-baz")
+	assert.DeepEqual(t, "metadata.namespace", secret.Metadata.Namespace, "my-app")
+	assert.DeepEqual(t, "repository annotation", secret.Metadata.Annotations["keppel.sapcc.github.io/repository"], "registry.example.org/test1/foo/bar_baz")
+	expiresAt := s.Clock.Now().Add(90 * 24 * time.Hour)
+	assert.DeepEqual(t, "expires-at annotation", secret.Metadata.Annotations["keppel.sapcc.github.io/expires-at"], expiresAt.UTC().Format(time.RFC3339))
+
+	var dockerConfig struct {
+		Auths map[string]struct {
+			UserName string `json:"username"`
+			Password string `json:"password"`
+			Auth     []byte `json:"auth"`
+		} `json:"auths"`
+	}
+	err = json.Unmarshal(secret.Data[".dockerconfigjson"], &dockerConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	creds := dockerConfig.Auths["registry.example.org"]
+	assert.DeepEqual(t, "robot-user annotation", secret.Metadata.Annotations["keppel.sapcc.github.io/robot-user"], creds.UserName)
+	assert.DeepEqual(t, "auth", string(creds.Auth), creds.UserName+":"+creds.Password)
+	if !strings.HasPrefix(creds.Password, auth.RobotPasswordPrefix) {
+		t.Errorf("expected robot password to start with %q, but got %q", auth.RobotPasswordPrefix, creds.Password)
+	}
+
+	// robot credentials can be listed (without their passwords)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/bar_baz/_robot_credentials",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"robot_credentials": []assert.JSONObject{{
+				"user_name":  creds.UserName,
+				"created_at": s.Clock.Now().Unix(),
+				"expires_at": expiresAt.Unix(),
+			}},
+		},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/other/_robot_credentials",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"robot_credentials": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// the robot credential can only pull from its own repository
+	_, respBody = assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/auth?service=registry.example.org&scope=repository:test1/foo/bar_baz:pull,push&scope=repository:test1/other:pull",
+		Header:       map[string]string{"Authorization": keppel.BuildBasicAuthHeader(creds.UserName, creds.Password)},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	var tokenResp struct {
+		Token string `json:"token"`
+	}
+	err = json.Unmarshal(respBody, &tokenResp)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/foo/bar_baz/tags/list",
+		Header:       map[string]string{"Authorization": "Bearer " + tokenResp.Token},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"name": "test1/foo/bar_baz", "tags": []string{}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/other/tags/list",
+		Header:       map[string]string{"Authorization": "Bearer " + tokenResp.Token},
+		ExpectStatus: http.StatusUnauthorized,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/v2/test1/foo/bar_baz/blobs/uploads/",
+		Header:       map[string]string{"Authorization": "Bearer " + tokenResp.Token},
+		ExpectStatus: http.StatusUnauthorized,
+	}.Check(t, h)
+
+	// wrong passwords are rejected
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/auth?service=registry.example.org&scope=repository:test1/foo/bar_baz:pull",
+		Header:       map[string]string{"Authorization": keppel.BuildBasicAuthHeader(creds.UserName, auth.RobotPasswordPrefix+"wrongpassword")},
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody:   assert.JSONObject{"details": "invalid robot credentials"},
+	}.Check(t, h)
+
+	// passwords that do not look like robot passwords are checked by the auth
+	// driver, so robot credentials do not hide regular users with similar names
+	s.AD.ExpectedUserName = "robot@example"
+	s.AD.ExpectedPassword = "correctpassword"
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/auth?service=registry.example.org&scope=repository:test1/foo/bar_baz:pull",
+		Header:       map[string]string{"Authorization": keppel.BuildBasicAuthHeader("robot@example", "correctpassword")},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	s.AD.ExpectedUserName = ""
+	s.AD.ExpectedPassword = ""
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/auth?service=registry.example.org&scope=repository:test1/foo/bar_baz:pull",
+		Header:       map[string]string{"Authorization": keppel.BuildBasicAuthHeader(creds.UserName, "wrongpassword")},
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody:   assert.JSONObject{"details": "wrong credentials"},
+	}.Check(t, h)
+
+	// deleting the robot credential revokes it
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/other/_robot_credentials/" + creds.UserName,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such robot credential\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/bar_baz/_robot_credentials/" + creds.UserName,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/auth?service=registry.example.org&scope=repository:test1/foo/bar_baz:pull",
+		Header:       map[string]string{"Authorization": keppel.BuildBasicAuthHeader(creds.UserName, creds.Password)},
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody:   assert.JSONObject{"details": "wrong credentials"},
+	}.Check(t, h)
+
+	// robot credentials stop working once they expire
+	_, respBody = assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/bar_baz/_pull_secret?expires_in_days=1",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusCreated,
+	}.Check(t, h)
+	err = json.Unmarshal(respBody, &secret)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = json.Unmarshal(secret.Data[".dockerconfigjson"], &dockerConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	creds = dockerConfig.Auths["registry.example.org"]
+	authRequest := assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/auth?service=registry.example.org&scope=repository:test1/foo/bar_baz:pull",
+		Header:       map[string]string{"Authorization": keppel.BuildBasicAuthHeader(creds.UserName, creds.Password)},
+		ExpectStatus: http.StatusOK,
+	}
+	authRequest.Check(t, h)
+	s.Clock.StepBy(24 * time.Hour)
+	authRequest.ExpectStatus = http.StatusUnauthorized
+	authRequest.ExpectBody = assert.JSONObject{"details": "robot credentials have expired"}
+	authRequest.Check(t, h)
+}
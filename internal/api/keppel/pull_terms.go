@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// Terms of use are meant to be accepted by users that pull public images, so
+// these endpoints only require authentication, but no permissions on the
+// account. Accounts without terms of use are reported as not found to avoid
+// leaking the existence of private accounts.
+func (a *API) findAccountWithPullTerms(w http.ResponseWriter, r *http.Request) (*auth.Authorization, *models.Account) {
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet())
+	if authz == nil {
+		return nil, nil
+	}
+	if authz.UserIdentity.UserType() != keppel.RegularUser || authz.UserIdentity.UserName() == "" {
+		http.Error(w, "terms of use can only be accepted by authenticated users", http.StatusUnauthorized)
+		return nil, nil
+	}
+
+	account, err := keppel.FindAccount(a.db, models.AccountName(mux.Vars(r)["account"]))
+	if respondwith.ErrorText(w, err) {
+		return nil, nil
+	}
+	if account == nil || account.PullTermsVersion == "" {
+		http.Error(w, "no terms of use found for this account", http.StatusNotFound)
+		return nil, nil
+	}
+	return authz, account
+}
+
+func (a *API) handleGetPullTerms(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/pull_terms")
+	authz, account := a.findAccountWithPullTerms(w, r)
+	if account == nil {
+		return
+	}
+
+	var acceptance models.PullTermsAcceptance
+	err := a.db.SelectOne(&acceptance,
+		`SELECT * FROM pull_terms_acceptances WHERE account_name = $1 AND user_name = $2 AND terms_version = $3`,
+		account.Name, authz.UserIdentity.UserName(), account.PullTermsVersion)
+	var acceptedAt *int64
+	switch {
+	case err == nil:
+		ts := acceptance.AcceptedAt.Unix()
+		acceptedAt = &ts
+	case errors.Is(err, sql.ErrNoRows):
+		// not accepted yet
+	default:
+		respondwith.ErrorText(w, err)
+		return
+	}
+
+	respondwith.JSON(w, http.StatusOK, map[string]any{
+		"pull_terms":  keppel.RenderPullTerms(account.Reduced()),
+		"accepted_at": acceptedAt,
+	})
+}
+
+func (a *API) handlePostPullTermsAcceptance(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/pull_terms/acceptance")
+	authz, account := a.findAccountWithPullTerms(w, r)
+	if account == nil {
+		return
+	}
+
+	// the client must state which version it accepts, so that users do not
+	// accidentally accept terms of use that changed after they read them
+	var req struct {
+		Version string `json:"version"`
+	}
+	ok := decodeJSONRequestBody(w, r.Body, &req)
+	if !ok {
+		return
+	}
+	if req.Version != account.PullTermsVersion {
+		msg := fmt.Sprintf("cannot accept version %q of the terms of use: current version is %q", req.Version, account.PullTermsVersion)
+		http.Error(w, msg, http.StatusConflict)
+		return
+	}
+
+	acceptance := models.PullTermsAcceptance{
+		AccountName:  account.Name,
+		UserName:     authz.UserIdentity.UserName(),
+		TermsVersion: account.PullTermsVersion,
+		AcceptedAt:   a.timeNow(),
+	}
+	result, err := a.db.Exec(
+		`INSERT INTO pull_terms_acceptances (account_name, user_name, terms_version, accepted_at) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`,
+		acceptance.AccountName, acceptance.UserName, acceptance.TermsVersion, acceptance.AcceptedAt)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	// only record an audit event if this is a new acceptance
+	if rowsAffected > 0 {
+		if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+			a.auditor.Record(audittools.Event{
+				Time:       a.timeNow(),
+				Request:    r,
+				User:       userInfo,
+				ReasonCode: http.StatusOK,
+				Action:     cadf.CreateAction,
+				Target:     AuditPullTermsAcceptance{Account: *account, Acceptance: acceptance},
+			})
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,166 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestPullTerms(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	s.AD.ExpectedUserName = "correctusername"
+
+	// account without terms of use does not have the subresource
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/pull_terms",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no terms of use found for this account\n"),
+	}.Check(t, h)
+
+	// validation errors when configuring response headers and terms of use
+	for _, tc := range []struct {
+		Account assert.JSONObject
+		Error   string
+	}{
+		{
+			Account: assert.JSONObject{"response_headers": assert.JSONObject{"Content-Type": "text/plain"}},
+			Error:   `invalid response header name: "Content-Type" (must start with "X-", but not with "X-Keppel-")`,
+		},
+		{
+			Account: assert.JSONObject{"response_headers": assert.JSONObject{"X-Keppel-Foo": "bar"}},
+			Error:   `invalid response header name: "X-Keppel-Foo" (must start with "X-", but not with "X-Keppel-")`,
+		},
+		{
+			Account: assert.JSONObject{"pull_terms": assert.JSONObject{"version": "", "url": "https://example.org/terms"}},
+			Error:   `invalid version for pull terms: ""`,
+		},
+		{
+			Account: assert.JSONObject{"pull_terms": assert.JSONObject{"version": "1.0", "url": "ftp://example.org/terms"}},
+			Error:   `invalid URL for pull terms: "ftp://example.org/terms"`,
+		},
+	} {
+		tc.Account["auth_tenant_id"] = "tenant1"
+		assert.HTTPRequest{
+			Method:       "PUT",
+			Path:         "/keppel/v1/accounts/test1",
+			Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+			Body:         assert.JSONObject{"account": tc.Account},
+			ExpectStatus: http.StatusUnprocessableEntity,
+			ExpectBody:   assert.StringData(tc.Error + "\n"),
+		}.Check(t, h)
+	}
+
+	// configure response headers and terms of use
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1",
+		Header: map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body: assert.JSONObject{"account": assert.JSONObject{
+			"auth_tenant_id":   "tenant1",
+			"response_headers": assert.JSONObject{"x-support-contact": "support@example.org"},
+			"pull_terms":       assert.JSONObject{"version": "2025-01", "url": "https://example.org/terms"},
+		}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"account": assert.JSONObject{
+			"name":             "test1",
+			"auth_tenant_id":   "tenant1",
+			"metadata":         nil,
+			"rbac_policies":    []assert.JSONObject{},
+			"response_headers": assert.JSONObject{"X-Support-Contact": "support@example.org"},
+			"pull_terms":       assert.JSONObject{"version": "2025-01", "url": "https://example.org/terms"},
+		}},
+	}.Check(t, h)
+	s.Auditor.IgnoreEventsUntilNow()
+
+	// the terms of use can be viewed without any permissions on the account,
+	// but not anonymously
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/pull_terms",
+		ExpectStatus: http.StatusUnauthorized,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/pull_terms",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant2"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"pull_terms":  assert.JSONObject{"version": "2025-01", "url": "https://example.org/terms"},
+			"accepted_at": nil,
+		},
+	}.Check(t, h)
+
+	// accepting an outdated version is rejected
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/pull_terms/acceptance",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant2"},
+		Body:         assert.JSONObject{"version": "2024-01"},
+		ExpectStatus: http.StatusConflict,
+		ExpectBody:   assert.StringData("cannot accept version \"2024-01\" of the terms of use: current version is \"2025-01\"\n"),
+	}.Check(t, h)
+	s.Auditor.ExpectEvents(t /*, nothing */)
+
+	// accepting the current version works, and is idempotent
+	for pass := 1; pass <= 2; pass++ {
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/keppel/v1/accounts/test1/pull_terms/acceptance",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant2"},
+			Body:         assert.JSONObject{"version": "2025-01"},
+			ExpectStatus: http.StatusNoContent,
+		}.Check(t, h)
+
+		// only the first pass should generate an audit event
+		if pass == 1 {
+			s.Auditor.ExpectEvents(t, cadf.Event{
+				RequestPath: "/keppel/v1/accounts/test1/pull_terms/acceptance",
+				Action:      cadf.CreateAction,
+				Outcome:     "success",
+				Reason:      test.CADFReasonOK,
+				Target: cadf.Resource{
+					TypeURI:   "docker-registry/account/pull-terms-acceptance",
+					ID:        "test1/2025-01",
+					ProjectID: "tenant1",
+					Attachments: []cadf.Attachment{{
+						Name:    "payload",
+						TypeURI: "mime:application/json",
+						Content: test.ToJSON(assert.JSONObject{
+							"accepted_at": s.Clock.Now().Unix(),
+							"url":         "https://example.org/terms",
+							"user_name":   "correctusername",
+							"version":     "2025-01",
+						}),
+					}},
+				},
+			})
+		} else {
+			s.Auditor.ExpectEvents(t /*, nothing */)
+		}
+	}
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/pull_terms",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant2"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"pull_terms":  assert.JSONObject{"version": "2025-01", "url": "https://example.org/terms"},
+			"accepted_at": s.Clock.Now().Unix(),
+		},
+	}.Check(t, h)
+}
@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/processor"
+)
+
+// QuarantinedManifest represents a quarantined manifest in the API.
+type QuarantinedManifest struct {
+	RepositoryName   string        `json:"repository"`
+	Digest           digest.Digest `json:"digest"`
+	MediaType        string        `json:"media_type"`
+	SizeBytes        uint64        `json:"size_bytes"`
+	PushedAt         int64         `json:"pushed_at"`
+	QuarantinedAt    int64         `json:"quarantined_at"`
+	QuarantineReason string        `json:"quarantine_reason"`
+}
+
+var quarantinedManifestsGetQuery = sqlext.SimplifyWhitespace(`
+	SELECT r.name, m.digest, m.media_type, m.size_bytes, m.pushed_at, m.quarantined_at, m.quarantine_reason
+	  FROM manifests m
+	  JOIN repos r ON m.repo_id = r.id
+	 WHERE r.account_name = $1 AND m.quarantined_at IS NOT NULL
+	 ORDER BY r.name, m.digest
+`)
+
+func (a *API) handleGetQuarantinedManifests(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/quarantine")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	result := []QuarantinedManifest{}
+	err := sqlext.ForeachRow(a.db, quarantinedManifestsGetQuery, []any{account.Name}, func(rows *sql.Rows) error {
+		var (
+			m             QuarantinedManifest
+			pushedAt      time.Time
+			quarantinedAt time.Time
+		)
+		err := rows.Scan(&m.RepositoryName, &m.Digest, &m.MediaType, &m.SizeBytes, &pushedAt, &quarantinedAt, &m.QuarantineReason)
+		if err != nil {
+			return err
+		}
+		m.PushedAt = pushedAt.Unix()
+		m.QuarantinedAt = quarantinedAt.Unix()
+		result = append(result, m)
+		return nil
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"quarantined_manifests": result})
+}
+
+func (a *API) handlePostQuarantineManifest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/quarantine")
+	authz, account, repo, manifestDigest := a.findManifestForStateChange(w, r)
+	if authz == nil {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if !decodeJSONRequestBody(w, r.Body, &req) {
+		return
+	}
+	if req.Reason == "" {
+		http.Error(w, `missing "reason" attribute`, http.StatusUnprocessableEntity)
+		return
+	}
+
+	err := a.processor().QuarantineManifest(account.Reduced(), *repo, manifestDigest, req.Reason, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	respondToManifestStateChange(w, err)
+}
+
+func (a *API) handlePostReleaseManifest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/release")
+	authz, account, repo, manifestDigest := a.findManifestForStateChange(w, r)
+	if authz == nil {
+		return
+	}
+
+	err := a.processor().ReleaseManifest(account.Reduced(), *repo, manifestDigest, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	respondToManifestStateChange(w, err)
+}
+
+// Shared preparation for the endpoints that move manifests into or out of quarantine.
+// If the returned *auth.Authorization is nil, an error response has been written.
+func (a *API) findManifestForStateChange(w http.ResponseWriter, r *http.Request) (*auth.Authorization, *models.Account, *models.Repository, digest.Digest) {
+	// only admins may change the state of manifests; otherwise users could
+	// just release manifests that were quarantined by an admission policy
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return nil, nil, nil, ""
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return nil, nil, nil, ""
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return nil, nil, nil, ""
+	}
+	manifestDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "digest not found", http.StatusNotFound)
+		return nil, nil, nil, ""
+	}
+	return authz, account, repo, manifestDigest
+}
+
+func respondToManifestStateChange(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "no such manifest", http.StatusNotFound)
+	case errors.Is(err, processor.ErrManifestAlreadyQuarantined), errors.Is(err, processor.ErrManifestNotQuarantined):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		if !respondwith.ErrorText(w, err) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
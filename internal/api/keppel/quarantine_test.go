@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestQuarantineAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	repo := models.Repository{Name: "foo", AccountName: "test1"}
+	mustInsert(t, s.DB, &repo)
+	manifestDigest := digest.FromString("manifest")
+	mustInsert(t, s.DB, &models.Manifest{
+		RepositoryID:     repo.ID,
+		Digest:           manifestDigest,
+		MediaType:        "application/vnd.oci.image.manifest.v1+json",
+		SizeBytes:        1000,
+		PushedAt:         time.Unix(1000, 0),
+		NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+	})
+	manifestPath := "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + manifestDigest.String()
+
+	// nothing is quarantined yet
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/quarantine",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"quarantined_manifests": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// changing the state of manifests requires admin permission
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/quarantine",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1,delete:tenant1"},
+		Body:         assert.JSONObject{"reason": "malware detected"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// error cases for quarantining
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/quarantine",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("missing \"reason\" attribute\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + digest.FromString("other").String() + "/quarantine",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{"reason": "malware detected"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such manifest\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/release",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusConflict,
+		ExpectBody:   assert.StringData("manifest is not quarantined\n"),
+	}.Check(t, h)
+
+	// happy path: quarantine the manifest
+	s.Clock.StepBy(time.Hour)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/quarantine",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{"reason": "malware detected"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/quarantine",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{"reason": "malware detected"},
+		ExpectStatus: http.StatusConflict,
+		ExpectBody:   assert.StringData("manifest is already quarantined\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/quarantine",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"quarantined_manifests": []assert.JSONObject{{
+			"repository":        "foo",
+			"digest":            manifestDigest.String(),
+			"media_type":        "application/vnd.oci.image.manifest.v1+json",
+			"size_bytes":        1000,
+			"pushed_at":         1000,
+			"quarantined_at":    s.Clock.Now().Unix(),
+			"quarantine_reason": "malware detected",
+		}}},
+	}.Check(t, h)
+
+	// happy path: release the manifest again
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         manifestPath + "/release",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/quarantine",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"quarantined_manifests": []assert.JSONObject{}},
+	}.Check(t, h)
+}
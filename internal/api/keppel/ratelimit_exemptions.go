@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func (a *API) handleGetRateLimitExemptions(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/rate_limit_exemptions")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return
+	}
+
+	var dbExemptions []models.RateLimitExemption
+	_, err := a.db.Select(&dbExemptions, `SELECT * FROM rate_limit_exemptions ORDER BY id`)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	exemptions := make([]keppel.RateLimitExemption, len(dbExemptions))
+	for idx, e := range dbExemptions {
+		exemptions[idx] = keppel.RenderRateLimitExemption(e)
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"rate_limit_exemptions": exemptions})
+}
+
+func (a *API) handlePostRateLimitExemption(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/rate_limit_exemptions")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return
+	}
+
+	var req struct {
+		Exemption keppel.RateLimitExemption `json:"rate_limit_exemption"`
+	}
+	ok := decodeJSONRequestBody(w, r.Body, &req)
+	if !ok {
+		return
+	}
+	exemption := models.RateLimitExemption{
+		CreatedAt: a.timeNow(),
+		CreatedBy: authz.UserIdentity.UserName(),
+	}
+	err := req.Exemption.ApplyToModel(&exemption)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	err = a.db.Insert(&exemption)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	a.invalidateRateLimitExemptions()
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusCreated,
+			Action:     cadf.CreateAction,
+			Target:     AuditRateLimitExemption{Exemption: exemption},
+		})
+	}
+
+	respondwith.JSON(w, http.StatusCreated, map[string]any{"rate_limit_exemption": keppel.RenderRateLimitExemption(exemption)})
+}
+
+func (a *API) handleDeleteRateLimitExemption(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/rate_limit_exemptions/:id")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.AdminAPIScope))
+	if authz == nil {
+		return
+	}
+
+	var exemption models.RateLimitExemption
+	err := a.db.SelectOne(&exemption, `SELECT * FROM rate_limit_exemptions WHERE id = $1`, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such rate limit exemption", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	_, err = a.db.Delete(&exemption)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	a.invalidateRateLimitExemptions()
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusNoContent,
+			Action:     cadf.DeleteAction,
+			Target:     AuditRateLimitExemption{Exemption: exemption},
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Changes become visible in this process immediately. Other keppel-api
+// processes pick them up after RateLimitExemptionRefreshInterval at the latest.
+func (a *API) invalidateRateLimitExemptions() {
+	if a.rle != nil && a.rle.Exemptions != nil {
+		a.rle.Exemptions.Invalidate()
+	}
+}
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestRateLimitExemptionsAPI(t *testing.T) {
+	s := test.NewSetup(t, test.WithKeppelAPI)
+	h := s.Handler
+	// the creator of each exemption is recorded by username
+	s.AD.ExpectedUserName = "correctusername"
+
+	// all endpoints require cluster-admin permission
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/rate_limit_exemptions",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/rate_limit_exemptions",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         assert.JSONObject{"rate_limit_exemption": assert.JSONObject{"account": "test1"}},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// initially, there are no exemptions
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/rate_limit_exemptions",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"rate_limit_exemptions": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// invalid exemptions are rejected
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/rate_limit_exemptions",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{"rate_limit_exemption": assert.JSONObject{"comment": "matches everything"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("rate limit exemption must match on at least one of account, cidr or user_name\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/rate_limit_exemptions",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{"rate_limit_exemption": assert.JSONObject{"cidr": "198.51.100.0"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("invalid CIDR for rate limit exemption: netip.ParsePrefix(\"198.51.100.0\"): no '/'\n"),
+	}.Check(t, h)
+
+	// happy path (note that the CIDR gets normalized)
+	assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/rate_limit_exemptions",
+		Header: map[string]string{"X-Test-Perms": "admin:"},
+		Body: assert.JSONObject{"rate_limit_exemption": assert.JSONObject{
+			"account": "test1",
+			"cidr":    "198.51.100.10/24",
+			"comment": "CI pipeline",
+		}},
+		ExpectStatus: http.StatusCreated,
+		ExpectBody: assert.JSONObject{"rate_limit_exemption": assert.JSONObject{
+			"id":         1,
+			"account":    "test1",
+			"cidr":       "198.51.100.0/24",
+			"comment":    "CI pipeline",
+			"created_at": s.Clock.Now().Unix(),
+			"created_by": "correctusername",
+		}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/rate_limit_exemptions",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		Body:         assert.JSONObject{"rate_limit_exemption": assert.JSONObject{"user_name": "mirror-bot"}},
+		ExpectStatus: http.StatusCreated,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/rate_limit_exemptions",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"rate_limit_exemptions": []assert.JSONObject{
+			{
+				"id":         1,
+				"account":    "test1",
+				"cidr":       "198.51.100.0/24",
+				"comment":    "CI pipeline",
+				"created_at": s.Clock.Now().Unix(),
+				"created_by": "correctusername",
+			},
+			{
+				"id":         2,
+				"user_name":  "mirror-bot",
+				"created_at": s.Clock.Now().Unix(),
+				"created_by": "correctusername",
+			},
+		}},
+	}.Check(t, h)
+
+	// deleting exemptions
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/rate_limit_exemptions/1",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/rate_limit_exemptions/1",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such rate limit exemption\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/rate_limit_exemptions",
+		Header:       map[string]string{"X-Test-Perms": "admin:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"rate_limit_exemptions": []assert.JSONObject{{
+			"id":         2,
+			"user_name":  "mirror-bot",
+			"created_at": s.Clock.Now().Unix(),
+			"created_by": "correctusername",
+		}}},
+	}.Check(t, h)
+}
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"net/http"
+
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// RecompressionSavings is the API representation of the savings report for
+// recompressed variants in an account.
+type RecompressionSavings struct {
+	Format                  models.RecompressionFormat `json:"format"`
+	VariantCount            uint64                     `json:"variants"`
+	FailedCount             uint64                     `json:"failed"`
+	OriginalLayersSizeBytes uint64                     `json:"original_layers_size_bytes"`
+	VariantLayersSizeBytes  uint64                     `json:"variant_layers_size_bytes"`
+	VariantPullCount        uint64                     `json:"variant_pulls"`
+	SavedTransferBytes      uint64                     `json:"saved_transfer_bytes"`
+}
+
+var recompressionSavingsQuery = sqlext.SimplifyWhitespace(`
+	SELECT COUNT(*) FILTER (WHERE v.error_message = ''),
+	       COUNT(*) FILTER (WHERE v.error_message != ''),
+	       COALESCE(SUM(v.original_layers_size_bytes), 0),
+	       COALESCE(SUM(v.variant_layers_size_bytes), 0),
+	       COALESCE(SUM(v.pull_count), 0),
+	       COALESCE(SUM(v.pull_count * GREATEST(v.original_layers_size_bytes - v.variant_layers_size_bytes, 0)), 0)
+	  FROM recompressed_variants v
+	  JOIN repos r ON r.id = v.repo_id
+	 WHERE r.account_name = $1 AND v.format = $2
+`)
+
+func (a *API) handleGetRecompressionSavings(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/recompression_savings")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	if account.RecompressionFormat == models.RecompressionFormatNone {
+		http.Error(w, "recompression is not enabled for this account", http.StatusNotFound)
+		return
+	}
+
+	report := RecompressionSavings{Format: account.RecompressionFormat}
+	err := a.db.QueryRow(recompressionSavingsQuery, account.Name, account.RecompressionFormat).Scan(
+		&report.VariantCount, &report.FailedCount,
+		&report.OriginalLayersSizeBytes, &report.VariantLayersSizeBytes,
+		&report.VariantPullCount, &report.SavedTransferBytes,
+	)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"recompression_savings": report})
+}
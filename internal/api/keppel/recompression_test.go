@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestRecompressionSavingsAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	// the report is only available for accounts that opted into recompression
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/recompression_savings",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("recompression is not enabled for this account\n"),
+	}.Check(t, h)
+	mustExec(t, s.DB, `UPDATE accounts SET recompression_format = $1`, models.RecompressionFormatZstd)
+
+	// without any variants, nothing was saved
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/recompression_savings",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"recompression_savings": assert.JSONObject{
+			"format":                     "zstd",
+			"variants":                   0,
+			"failed":                     0,
+			"original_layers_size_bytes": 0,
+			"variant_layers_size_bytes":  0,
+			"variant_pulls":              0,
+			"saved_transfer_bytes":       0,
+		}},
+	}.Check(t, h)
+
+	// setup: three images, two of which have a recompressed variant (one of them
+	// was pulled several times), and one where recompression failed
+	repo := models.Repository{Name: "foo", AccountName: "test1"}
+	mustInsert(t, s.DB, &repo)
+	digests := []digest.Digest{digest.FromString("a"), digest.FromString("b"), digest.FromString("c")}
+	for _, d := range digests {
+		mustInsert(t, s.DB, &models.Manifest{
+			RepositoryID:     repo.ID,
+			Digest:           d,
+			MediaType:        "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:        1000,
+			PushedAt:         time.Unix(1000, 0),
+			NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+		})
+	}
+	nextAttemptAt := time.Unix(5000, 0)
+	mustInsert(t, s.DB, &models.RecompressedVariant{
+		RepositoryID:            repo.ID,
+		Digest:                  digests[0],
+		Format:                  models.RecompressionFormatZstd,
+		VariantDigest:           digest.FromString("a-zstd"),
+		OriginalLayersSizeBytes: 10000,
+		VariantLayersSizeBytes:  7000,
+		GeneratedAt:             time.Unix(2000, 0),
+		PullCount:               3,
+	})
+	mustInsert(t, s.DB, &models.RecompressedVariant{
+		RepositoryID:            repo.ID,
+		Digest:                  digests[1],
+		Format:                  models.RecompressionFormatZstd,
+		VariantDigest:           digest.FromString("b-zstd"),
+		OriginalLayersSizeBytes: 5000,
+		VariantLayersSizeBytes:  4000,
+		GeneratedAt:             time.Unix(2000, 0),
+	})
+	mustInsert(t, s.DB, &models.RecompressedVariant{
+		RepositoryID:  repo.ID,
+		Digest:        digests[2],
+		Format:        models.RecompressionFormatZstd,
+		GeneratedAt:   time.Unix(2000, 0),
+		ErrorMessage:  "gzip: invalid header",
+		NextAttemptAt: &nextAttemptAt,
+	})
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/recompression_savings",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"recompression_savings": assert.JSONObject{
+			"format":                     "zstd",
+			"variants":                   2,
+			"failed":                     1,
+			"original_layers_size_bytes": 15000,
+			"variant_layers_size_bytes":  11000,
+			"variant_pulls":              3,
+			"saved_transfer_bytes":       9000,
+		}},
+	}.Check(t, h)
+
+	// the report requires permission to view the account
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/recompression_savings",
+		Header:       map[string]string{"X-Test-Perms": "pull:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+}
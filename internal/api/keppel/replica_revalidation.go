@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// RepoRevalidation appears in the response of GET /keppel/v1/accounts/:name/revalidate.
+type RepoRevalidation struct {
+	Name          string `json:"name"`
+	RevalidatedAt int64  `json:"revalidated_at"`
+	keppel.ReplicaRevalidationResult
+}
+
+var (
+	revalidationScheduleQuery = sqlext.SimplifyWhitespace(`
+		UPDATE repos SET next_revalidation_at = $2, revalidation_repair = $3 WHERE account_name = $1
+	`)
+	revalidationPendingCountQuery = sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM repos WHERE account_name = $1 AND next_revalidation_at IS NOT NULL
+	`)
+	revalidationResultsQuery = sqlext.SimplifyWhitespace(`
+		SELECT * FROM repos
+		 WHERE account_name = $1 AND revalidated_at IS NOT NULL AND revalidation_result_json != '{}'
+		 ORDER BY name
+	`)
+)
+
+// Checks the preconditions that are shared by all revalidation endpoints.
+// Since revalidation causes requests to the upstream registry for every tag
+// and manifest, it requires the same permission as updating the account.
+func (a *API) prepareRevalidation(w http.ResponseWriter, r *http.Request) *models.Account {
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return nil
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return nil
+	}
+	if account.UpstreamPeerHostName == "" && account.ExternalPeerURL == "" {
+		http.Error(w, "operation not allowed for non-replica accounts", http.StatusBadRequest)
+		return nil
+	}
+	if account.IsDeleting {
+		http.Error(w, "account is being deleted", http.StatusConflict)
+		return nil
+	}
+	return account
+}
+
+func (a *API) handlePostRevalidateRepository(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_revalidate")
+	account := a.prepareRevalidation(w, r)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+
+	divergences, err := a.processor().RevalidateReplicaRepo(r.Context(), account.Reduced(), *repo)
+	if err != nil {
+		http.Error(w, "cannot revalidate against upstream: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	repairScheduled := false
+	if r.URL.Query().Get("repair") == "true" && keppel.HasRepairableDivergences(divergences) {
+		_, err := a.db.Exec(`UPDATE repos SET next_manifest_sync_at = $2 WHERE id = $1`, repo.ID, a.timeNow())
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+		repairScheduled = true
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{
+		"divergences":      divergences,
+		"repair_scheduled": repairScheduled,
+	})
+}
+
+func (a *API) handlePostRevalidateAccount(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/revalidate")
+	account := a.prepareRevalidation(w, r)
+	if account == nil {
+		return
+	}
+
+	// checking all repos can take a long time, so this is done by the janitor
+	// (see tasks.ReplicaRevalidationJob)
+	repair := r.URL.Query().Get("repair") == "true"
+	result, err := a.db.Exec(revalidationScheduleQuery, account.Name, a.timeNow(), repair)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	queuedCount, err := result.RowsAffected()
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusAccepted, map[string]any{"repositories_queued": queuedCount})
+}
+
+func (a *API) handleGetRevalidateAccount(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/revalidate")
+	account := a.prepareRevalidation(w, r)
+	if account == nil {
+		return
+	}
+
+	pendingCount, err := a.db.SelectInt(revalidationPendingCountQuery, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	var repos []models.Repository
+	_, err = a.db.Select(&repos, revalidationResultsQuery, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	// only repos with divergences or errors are reported
+	results := []RepoRevalidation{}
+	for _, repo := range repos {
+		rr := RepoRevalidation{Name: repo.Name, RevalidatedAt: repo.RevalidatedAt.Unix()}
+		err := json.Unmarshal([]byte(repo.RevalidationResultJSON), &rr.ReplicaRevalidationResult)
+		if err != nil {
+			respondwith.ErrorText(w, fmt.Errorf("cannot parse revalidation result for repo %s: %w", repo.FullName(), err))
+			return
+		}
+		if len(rr.Divergences) == 0 && rr.Error == "" {
+			continue
+		}
+		results = append(results, rr)
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{
+		"repositories":         results,
+		"repositories_pending": pendingCount,
+	})
+}
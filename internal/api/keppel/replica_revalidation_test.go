@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestReplicaRevalidation(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		fooRepo := models.Repository{AccountName: "test1", Name: "foo"}
+		s1 := test.NewSetup(t,
+			test.WithKeppelAPI,
+			test.WithPeerAPI,
+			test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+			test.WithRepo(fooRepo),
+			test.WithQuotas,
+		)
+		s2 := test.NewSetup(t,
+			test.IsSecondaryTo(&s1),
+			test.WithKeppelAPI,
+			test.WithPeerAPI,
+			test.WithAccount(models.Account{
+				Name:                 "test1",
+				AuthTenantID:         "tenant1",
+				ExternalPeerURL:      "registry.example.org/test1",
+				ExternalPeerUserName: "replication@registry-secondary.example.org",
+				ExternalPeerPassword: test.GetReplicationPassword(),
+			}),
+			test.WithRepo(fooRepo),
+			test.WithQuotas,
+		)
+		s1.Clock.StepBy(time.Hour)
+		s2.Clock.StepBy(time.Hour)
+
+		// upload some images to the primary and replicate them
+		replicaToken := s2.GetToken(t, "repository:test1/foo:pull")
+		images := make([]test.Image, 3)
+		for idx := range images {
+			images[idx] = test.GenerateImage(test.GenerateExampleLayer(int64(idx + 1)))
+		}
+		images[0].MustUpload(t, s1, fooRepo, "first")
+		images[1].MustUpload(t, s1, fooRepo, "second")
+		images[2].MustUpload(t, s1, fooRepo, "")
+		for _, ref := range []string{"first", "second", images[2].Manifest.Digest.String()} {
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/manifests/" + ref,
+				Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+				ExpectStatus: http.StatusOK,
+			}.Check(t, s2.Handler)
+		}
+
+		// revalidation causes requests to upstream for every tag and manifest, so
+		// it requires the same permission as updating the account
+		for _, method := range []string{"GET", "POST"} {
+			assert.HTTPRequest{
+				Method:       method,
+				Path:         "/keppel/v1/accounts/test1/revalidate",
+				Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+				ExpectStatus: http.StatusForbidden,
+			}.Check(t, s2.Handler)
+		}
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/keppel/v1/accounts/test1/repositories/foo/_revalidate",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusForbidden,
+		}.Check(t, s2.Handler)
+		changePerms := map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"}
+
+		// revalidation is only possible for replica accounts
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/keppel/v1/accounts/test1/revalidate",
+			Header:       changePerms,
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   assert.StringData("operation not allowed for non-replica accounts\n"),
+		}.Check(t, s1.Handler)
+
+		// while the replica is in sync, there are no divergences
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/keppel/v1/accounts/test1/repositories/foo/_revalidate",
+			Header:       changePerms,
+			ExpectStatus: http.StatusOK,
+			ExpectBody: assert.JSONObject{
+				"divergences":      []assert.JSONObject{},
+				"repair_scheduled": false,
+			},
+		}.Check(t, s2.Handler)
+
+		// move one tag and delete one manifest (including its tag) upstream
+		mustExec(t, s1.DB, `UPDATE tags SET digest = $1 WHERE name = $2`, images[2].Manifest.Digest, "first")
+		mustExec(t, s1.DB, `DELETE FROM manifests WHERE digest = $1`, images[1].Manifest.Digest)
+
+		expectedDivergences := []assert.JSONObject{
+			{
+				"kind":            "tag_moved",
+				"tag":             "first",
+				"digest":          images[0].Manifest.Digest,
+				"upstream_digest": images[2].Manifest.Digest,
+			},
+			{
+				"kind":   "tag_deleted",
+				"tag":    "second",
+				"digest": images[1].Manifest.Digest,
+			},
+			{
+				"kind":   "manifest_deleted",
+				"digest": images[1].Manifest.Digest,
+			},
+		}
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/keppel/v1/accounts/test1/repositories/foo/_revalidate",
+			Header:       changePerms,
+			ExpectStatus: http.StatusOK,
+			ExpectBody: assert.JSONObject{
+				"divergences":      expectedDivergences,
+				"repair_scheduled": false,
+			},
+		}.Check(t, s2.Handler)
+
+		// repairing schedules the next manifest sync right away
+		mustExec(t, s2.DB, `UPDATE repos SET next_manifest_sync_at = $1`, s2.Clock.Now().Add(24*time.Hour))
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/keppel/v1/accounts/test1/repositories/foo/_revalidate?repair=true",
+			Header:       changePerms,
+			ExpectStatus: http.StatusOK,
+			ExpectBody: assert.JSONObject{
+				"divergences":      expectedDivergences,
+				"repair_scheduled": true,
+			},
+		}.Check(t, s2.Handler)
+		nextSyncAt, err := s2.DB.SelectInt(`SELECT EXTRACT(EPOCH FROM next_manifest_sync_at)::BIGINT FROM repos WHERE name = $1`, "foo")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "next_manifest_sync_at", nextSyncAt, s2.Clock.Now().Unix())
+
+		// revalidation of the entire account is queued for the janitor
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/keppel/v1/accounts/test1/revalidate?repair=true",
+			Header:       changePerms,
+			ExpectStatus: http.StatusAccepted,
+			ExpectBody:   assert.JSONObject{"repositories_queued": 1},
+		}.Check(t, s2.Handler)
+		repairRequested, err := s2.DB.SelectInt(`SELECT COUNT(*) FROM repos WHERE next_revalidation_at = $1 AND revalidation_repair`, s2.Clock.Now())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "repos with requested revalidation", repairRequested, int64(1))
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/revalidate",
+			Header:       changePerms,
+			ExpectStatus: http.StatusOK,
+			ExpectBody: assert.JSONObject{
+				"repositories":         []assert.JSONObject{},
+				"repositories_pending": 1,
+			},
+		}.Check(t, s2.Handler)
+
+		// once the janitor is done, the results are reported (this simulates what
+		// tasks.ReplicaRevalidationJob does)
+		mustExec(t, s2.DB, `UPDATE repos SET next_revalidation_at = NULL, revalidation_repair = FALSE, revalidated_at = $1, revalidation_result_json = $2`,
+			s2.Clock.Now(), `{"divergences":[{"kind":"manifest_deleted","digest":"`+images[1].Manifest.Digest.String()+`"}],"repair_scheduled":true}`)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/revalidate",
+			Header:       changePerms,
+			ExpectStatus: http.StatusOK,
+			ExpectBody: assert.JSONObject{
+				"repositories": []assert.JSONObject{{
+					"name":             "foo",
+					"revalidated_at":   s2.Clock.Now().Unix(),
+					"divergences":      []assert.JSONObject{{"kind": "manifest_deleted", "digest": images[1].Manifest.Digest}},
+					"repair_scheduled": true,
+				}},
+				"repositories_pending": 0,
+			},
+		}.Check(t, s2.Handler)
+	})
+}
@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"net/http"
+
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/processor"
+)
+
+func (a *API) handleGetReplicationHealth(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/replication_health")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	if account.UpstreamPeerHostName == "" && account.ExternalPeerURL == "" {
+		http.Error(w, "operation not allowed for non-replica accounts", http.StatusBadRequest)
+		return
+	}
+
+	health, err := keppel.FindReplicationHealth(a.db, account.Reduced(), a.cfg.ReplicationErrorBudget, a.timeNow())
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"replication_health": health})
+}
+
+func (a *API) handlePostResumeReplication(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/replication_health/resume")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	if account.ReplicationPausedAt == nil {
+		http.Error(w, "replication is not paused", http.StatusConflict)
+		return
+	}
+
+	// when resuming, start over with a fresh error budget (otherwise the next
+	// failure would pause replication again immediately)
+	_, err := a.db.Exec(`UPDATE accounts SET replication_paused_at = NULL WHERE name = $1`, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	_, err = a.db.Exec(`DELETE FROM replication_outcomes WHERE account_name = $1`, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	account.ReplicationPausedAt = nil
+
+	health, err := keppel.FindReplicationHealth(a.db, account.Reduced(), a.cfg.ReplicationErrorBudget, a.timeNow())
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     cadf.EnableAction,
+			Target:     processor.AuditReplicationHealth{Account: account.Reduced(), Health: health},
+		})
+	}
+
+	respondwith.JSON(w, http.StatusOK, map[string]any{"replication_health": health})
+}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestReplicationHealthAPI(t *testing.T) {
+	pausedAt := time.Unix(3000, 0)
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "primary", AuthTenantID: "tenant1"}),
+		test.WithAccount(models.Account{
+			Name:                "replica",
+			AuthTenantID:        "tenant1",
+			ExternalPeerURL:     "registry.example.com",
+			ReplicationPausedAt: &pausedAt,
+		}),
+	)
+	h := s.Handler
+	s.Clock.StepBy(time.Hour)
+
+	// replication health is only reported for replica accounts
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/primary/replication_health",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("operation not allowed for non-replica accounts\n"),
+	}.Check(t, h)
+
+	// only outcomes within the window are counted
+	for _, bucketStart := range []int64{0, 3000, 3060} {
+		mustExec(t, s.DB, `INSERT INTO replication_outcomes (account_name, bucket_start, successes, failures) VALUES ($1, $2, $3, $4)`,
+			"replica", time.Unix(bucketStart, 0), 1, 3)
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/replica/replication_health",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"replication_health": assert.JSONObject{
+			"window_seconds": 3600,
+			"successes":      2,
+			"failures":       6,
+			"error_percent":  75.0,
+			"paused_at":      3000,
+		}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/replica",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"account": assert.JSONObject{
+			"name":           "replica",
+			"auth_tenant_id": "tenant1",
+			"metadata":       nil,
+			"rbac_policies":  []assert.JSONObject{},
+			"replication": assert.JSONObject{
+				"strategy": "from_external_on_first_use",
+				"upstream": assert.JSONObject{"url": "registry.example.com"},
+			},
+			"state": "replication_paused",
+			"issues": []assert.JSONObject{{
+				"type":        "replication_paused",
+				"reason":      "replication from upstream has been paused since 1970-01-01T00:50:00Z because of too many failed replications",
+				"remediation": "Check that the upstream registry is reachable and that the replication credentials of this account are valid, then resume replication with POST /keppel/v1/accounts/:name/replication_health/resume.",
+			}},
+		}},
+	}.Check(t, h)
+
+	// resuming replication requires change permission
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/replica/replication_health/resume",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// happy path: resuming resets the error budget
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/replica/replication_health/resume",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"replication_health": assert.JSONObject{
+			"window_seconds": 3600,
+			"successes":      0,
+			"failures":       0,
+		}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/replica/replication_health/resume",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusConflict,
+		ExpectBody:   assert.StringData("replication is not paused\n"),
+	}.Check(t, h)
+}
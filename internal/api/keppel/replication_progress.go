@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// BlobReplication represents the progress of a blob replication in the API.
+type BlobReplication struct {
+	ID              digest.Digest `json:"id"`
+	State           string        `json:"state"`
+	ReplicatedBytes uint64        `json:"replicated_bytes"`
+	TotalBytes      uint64        `json:"total_bytes"`
+	StartedAt       *int64        `json:"started_at,omitempty"`
+}
+
+// The replication ID is the digest of the blob that is being replicated,
+// since a blob is replicated at most once at a time per account (see
+// processor.ErrConcurrentReplication). It is reported to clients of the
+// Registry API in the X-Keppel-Replication-ID header.
+func (a *API) handleGetBlobReplication(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_replications/:id")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+
+	blobDigest, err := digest.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "replication not found", http.StatusNotFound)
+		return
+	}
+	blob, err := keppel.FindBlobByRepository(a.db, blobDigest, *repo)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "replication not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	result := BlobReplication{
+		ID:         blob.Digest,
+		TotalBytes: blob.SizeBytes,
+	}
+	pendingBlob, err := a.processor().GetBlobReplicationProgress(account.Name, blob.Digest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	switch {
+	case pendingBlob != nil:
+		result.State = "in_progress"
+		result.ReplicatedBytes = pendingBlob.ReplicatedBytes
+		result.StartedAt = keppel.MaybeTimeToUnix(&pendingBlob.PendingSince)
+	case blob.StorageID != "":
+		result.State = "done"
+		result.ReplicatedBytes = blob.SizeBytes
+	default:
+		// the replication has not been started yet, or it has failed
+		result.State = "not_started"
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"replication": result})
+}
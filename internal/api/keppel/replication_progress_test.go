@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestBlobReplicationProgressAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1", ExternalPeerURL: "registry.example.com"}),
+		test.WithRepo(models.Repository{AccountName: "test1", Name: "foo"}),
+	)
+	h := s.Handler
+	s.Clock.StepBy(time.Hour)
+
+	// setup a blob that was discovered while replicating a manifest, but whose contents were not replicated yet
+	blobDigest := test.DeterministicDummyDigest(1)
+	blob := models.Blob{
+		AccountName:      "test1",
+		Digest:           blobDigest,
+		SizeBytes:        10000,
+		PushedAt:         time.Unix(0, 0),
+		NextValidationAt: time.Unix(0, 0),
+	}
+	mustInsert(t, s.DB, &blob)
+	mustExec(t, s.DB, `INSERT INTO blob_mounts (blob_id, repo_id) VALUES ($1, 1)`, blob.ID)
+	path := "/keppel/v1/accounts/test1/repositories/foo/_replications/" + blobDigest.String()
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"replication": assert.JSONObject{
+			"id":               blobDigest,
+			"state":            "not_started",
+			"replicated_bytes": 0,
+			"total_bytes":      10000,
+		}},
+	}.Check(t, h)
+
+	// while the blob is being replicated, its progress is reported
+	mustExec(t, s.DB, `INSERT INTO pending_blobs (account_name, digest, reason, since, replicated_bytes) VALUES ($1, $2, $3, $4, $5)`,
+		"test1", blobDigest, models.PendingBecauseOfReplication, time.Unix(3000, 0), 2500)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"replication": assert.JSONObject{
+			"id":               blobDigest,
+			"state":            "in_progress",
+			"replicated_bytes": 2500,
+			"total_bytes":      10000,
+			"started_at":       3000,
+		}},
+	}.Check(t, h)
+
+	// once the replication has finished, the blob is shown as complete
+	mustExec(t, s.DB, `DELETE FROM pending_blobs`)
+	mustExec(t, s.DB, `UPDATE blobs SET storage_id = $1 WHERE id = $2`, "abcdef", blob.ID)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"replication": assert.JSONObject{
+			"id":               blobDigest,
+			"state":            "done",
+			"replicated_bytes": 10000,
+			"total_bytes":      10000,
+		}},
+	}.Check(t, h)
+
+	// failure cases
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.StringData("no permission for repository:test1/foo:pull\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_replications/" + test.DeterministicDummyDigest(2).String(),
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("replication not found\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_replications/not-a-digest",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("replication not found\n"),
+	}.Check(t, h)
+}
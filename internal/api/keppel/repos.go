@@ -5,23 +5,35 @@ package keppelv1
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/respondwith"
 	"github.com/sapcc/go-bits/sqlext"
 
 	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
 )
 
 // Repository represents a repository in the API.
 type Repository struct {
-	Name          string `json:"name"`
-	ManifestCount uint64 `json:"manifest_count"`
-	TagCount      uint64 `json:"tag_count"`
-	SizeBytes     uint64 `json:"size_bytes,omitempty"`
-	PushedAt      int64  `json:"pushed_at,omitempty"`
+	Name                      string            `json:"name"`
+	ManifestCount             uint64            `json:"manifest_count"`
+	TagCount                  uint64            `json:"tag_count"`
+	SizeBytes                 uint64            `json:"size_bytes,omitempty"`
+	StorageQuotaBytes         *uint64           `json:"storage_quota_bytes,omitempty"`
+	PushedAt                  int64             `json:"pushed_at,omitempty"`
+	IsArchived                bool              `json:"archived,omitempty"`
+	GCPolicies                []keppel.GCPolicy `json:"gc_policies,omitempty"`
+	IgnoreInheritedGCPolicies bool              `json:"ignore_inherited_gc_policies,omitempty"`
 }
 
 var repositoryGetQuery = sqlext.SimplifyWhitespace(`
@@ -42,7 +54,7 @@ var repositoryGetQuery = sqlext.SimplifyWhitespace(`
 			  FROM tags
 			 GROUP BY repo_id
 		)
-	SELECT r.name,
+	SELECT r.name, r.storage_quota_bytes, r.is_archived, r.gc_policies_json, r.ignore_inherited_gc_policies,
 	       bs.size_bytes,
 	       ms.count, ms.pushed_at,
 	       ts.count, ts.pushed_at
@@ -66,8 +78,14 @@ func (a *API) handleGetRepositories(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// archived repos are only shown on request
+	sqlQuery := repositoryGetQuery
+	if r.URL.Query().Get("include_archived") != "true" {
+		sqlQuery = strings.Replace(sqlQuery, "$CONDITION", "NOT r.is_archived AND $CONDITION", 1)
+	}
+
 	query, bindValues, limit, err := paginatedQuery{
-		SQL:         repositoryGetQuery,
+		SQL:         sqlQuery,
 		MarkerField: "r.name",
 		Options:     r.URL.Query(),
 		BindValues:  []any{account.Name},
@@ -81,9 +99,191 @@ func (a *API) handleGetRepositories(w http.ResponseWriter, r *http.Request) {
 		Repos       []Repository `json:"repositories"`
 		IsTruncated bool         `json:"truncated,omitempty"`
 	}
-	err = sqlext.ForeachRow(a.db, query, bindValues, func(rows *sql.Rows) error {
+	result.Repos, err = a.queryRepositories(query, bindValues...)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if result.Repos == nil {
+		result.Repos = []Repository{}
+	}
+	if uint64(len(result.Repos)) > limit {
+		result.Repos = result.Repos[0:limit]
+		result.IsTruncated = true
+	}
+	respondwith.JSON(w, http.StatusOK, result)
+}
+
+func (a *API) handlePutRepository(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repoName := mux.Vars(r)["repo_name"]
+	if !isValidRepoName(repoName) {
+		http.Error(w, "repo name invalid", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// only the fields that are present in the request are changed
+	var req struct {
+		Repository struct {
+			StorageQuotaBytes         optionalStorageQuota `json:"storage_quota_bytes"`
+			IsArchived                *bool                `json:"archived"`
+			GCPolicies                *[]keppel.GCPolicy   `json:"gc_policies"`
+			IgnoreInheritedGCPolicies *bool                `json:"ignore_inherited_gc_policies"`
+		} `json:"repository"`
+	}
+	if !decodeJSONRequestBody(w, r.Body, &req) {
+		return
+	}
+
+	// GC policies on a repo apply to this repo only, so `match_repository` is optional
+	gcPoliciesJSON := ""
+	if gcPolicies := req.Repository.GCPolicies; gcPolicies != nil && len(*gcPolicies) > 0 {
+		for idx, policy := range *gcPolicies {
+			if policy.RepositoryRx == "" {
+				policy.RepositoryRx = ".*"
+			}
+			err := policy.Validate()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			(*gcPolicies)[idx] = policy
+		}
+		buf, err := json.Marshal(*gcPolicies)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+		gcPoliciesJSON = string(buf)
+	}
+
+	// the quota may be set up before anything is pushed into the repo
+	tx, err := a.db.Begin()
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+	repo, err := keppel.FindRepository(tx, repoName, account.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		rerr := keppel.ValidateNewRepositoryName(r.Context(), a.cfg, a.nvd, account.Reduced(), repoName)
+		if rerr != nil {
+			rerr.WriteAsTextTo(w)
+			return
+		}
+		repo, err = keppel.FindOrCreateRepository(tx, repoName, account.Name)
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if quota := req.Repository.StorageQuotaBytes; quota.IsSet {
+		if quota.Value != nil {
+			usage, err := keppel.GetRepoStorageUsage(tx, *repo)
+			if respondwith.ErrorText(w, err) {
+				return
+			}
+			if *quota.Value < usage {
+				msg := fmt.Sprintf("requested storage quota (%d bytes) is below usage (%d bytes)", *quota.Value, usage)
+				http.Error(w, msg, http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		repo.StorageQuotaBytes = quota.Value
+	}
+	original := *repo
+	if req.Repository.IsArchived != nil {
+		repo.IsArchived = *req.Repository.IsArchived
+	}
+	if req.Repository.GCPolicies != nil {
+		repo.GCPoliciesJSON = gcPoliciesJSON
+	}
+	if req.Repository.IgnoreInheritedGCPolicies != nil {
+		repo.IgnoreInheritedGCPolicies = *req.Repository.IgnoreInheritedGCPolicies
+	}
+
+	// if the account has an approval policy, changes to the GC-related settings
+	// may need to be split off into a pending change
+	pendingChange, err := a.processor().PendingChangeForRepositoryUpdate(*account, original, repo)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	_, err = tx.Update(repo)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	err = tx.Commit()
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	query := strings.NewReplacer("$CONDITION", "r.name = $2", "$LIMIT", "1").Replace(repositoryGetQuery)
+	repos, err := a.queryRepositories(query, account.Name, repo.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if len(repos) != 1 {
+		http.Error(w, "repo not found", http.StatusNotFound) // can only happen if the repo was deleted concurrently
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     cadf.UpdateAction,
+			Target:     AuditRepository{Account: *account, Repository: repos[0]},
+		})
+	}
+	if pendingChange == nil {
+		respondwith.JSON(w, http.StatusOK, map[string]any{"repository": repos[0]})
+		return
+	}
+
+	err = a.processor().SubmitPendingChange(account.Reduced(), pendingChange, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	pendingChangeRendered, err := keppel.RenderPendingChange(*pendingChange)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusAccepted, map[string]any{"repository": repos[0], "pending_changes": []keppel.PendingChange{pendingChangeRendered}})
+}
+
+// optionalStorageQuota distinguishes between a storage quota that is omitted
+// from a request (no change) and one that is explicitly null (quota removed).
+type optionalStorageQuota struct {
+	IsSet bool
+	Value *uint64
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (q *optionalStorageQuota) UnmarshalJSON(buf []byte) error {
+	q.IsSet = true
+	return json.Unmarshal(buf, &q.Value)
+}
+
+func (a *API) queryRepositories(query string, bindValues ...any) ([]Repository, error) {
+	var repos []Repository
+	err := sqlext.ForeachRow(a.db, query, bindValues, func(rows *sql.Rows) error {
 		var (
 			name                string
+			storageQuotaBytes   *uint64
+			isArchived          bool
+			gcPoliciesJSON      string
+			ignoreInheritedGC   bool
 			sizeBytes           *uint64
 			manifestCount       *uint64
 			maxManifestPushedAt *time.Time
@@ -91,34 +291,32 @@ func (a *API) handleGetRepositories(w http.ResponseWriter, r *http.Request) {
 			maxTagPushedAt      *time.Time
 		)
 		err := rows.Scan(
-			&name,
+			&name, &storageQuotaBytes, &isArchived, &gcPoliciesJSON, &ignoreInheritedGC,
 			&sizeBytes,
 			&manifestCount, &maxManifestPushedAt,
 			&tagCount, &maxTagPushedAt,
 		)
-		if err == nil {
-			result.Repos = append(result.Repos, Repository{
-				Name:          name,
-				ManifestCount: unpackUint64OrZero(manifestCount),
-				TagCount:      unpackUint64OrZero(tagCount),
-				SizeBytes:     unpackUint64OrZero(sizeBytes),
-				PushedAt:      maxTimeToUnix(maxTagPushedAt, maxManifestPushedAt),
-			})
+		if err != nil {
+			return err
+		}
+		gcPolicies, err := keppel.ParseRepositoryGCPolicies(models.Repository{GCPoliciesJSON: gcPoliciesJSON})
+		if err != nil {
+			return fmt.Errorf("cannot parse GC policies of repo %q: %w", name, err)
 		}
-		return err
+		repos = append(repos, Repository{
+			Name:                      name,
+			ManifestCount:             unpackUint64OrZero(manifestCount),
+			TagCount:                  unpackUint64OrZero(tagCount),
+			SizeBytes:                 unpackUint64OrZero(sizeBytes),
+			StorageQuotaBytes:         storageQuotaBytes,
+			PushedAt:                  maxTimeToUnix(maxTagPushedAt, maxManifestPushedAt),
+			IsArchived:                isArchived,
+			GCPolicies:                gcPolicies,
+			IgnoreInheritedGCPolicies: ignoreInheritedGC,
+		})
+		return nil
 	})
-	if respondwith.ErrorText(w, err) {
-		return
-	}
-
-	if result.Repos == nil {
-		result.Repos = []Repository{}
-	}
-	if uint64(len(result.Repos)) > limit {
-		result.Repos = result.Repos[0:limit]
-		result.IsTruncated = true
-	}
-	respondwith.JSON(w, http.StatusOK, result)
+	return repos, err
 }
 
 func unpackUint64OrZero(x *uint64) uint64 {
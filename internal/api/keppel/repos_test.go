@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/sapcc/go-api-declarations/cadf"
 	"github.com/sapcc/go-bits/assert"
 	"github.com/sapcc/go-bits/easypg"
 
@@ -241,3 +242,301 @@ func TestReposAPI(t *testing.T) {
 		ExpectBody:   assert.StringData("cannot delete repository while there are still manifests in it\n"),
 	}.Check(t, h)
 }
+
+func TestPutRepository(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	// setting a storage quota requires permission to change the account
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/scratch",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,push:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{"storage_quota_bytes": 5000}},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// the quota can be set up before anything is pushed into the repo
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/scratch",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{"storage_quota_bytes": 5000}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repository": assert.JSONObject{"name": "scratch", "manifest_count": 0, "tag_count": 0, "storage_quota_bytes": 5000},
+		},
+	}.Check(t, h)
+
+	// the quota cannot be set below the current usage
+	repo, err := keppel.FindRepository(s.DB, "scratch", "test1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	blob := models.Blob{
+		AccountName:      "test1",
+		Digest:           test.DeterministicDummyDigest(1),
+		SizeBytes:        3000,
+		PushedAt:         time.Unix(1000, 0),
+		NextValidationAt: time.Unix(1000, 0).Add(models.BlobValidationInterval),
+	}
+	mustInsert(t, s.DB, &blob)
+	err = keppel.MountBlobIntoRepo(s.DB, blob, *repo)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/scratch",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{"storage_quota_bytes": 2000}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("requested storage quota (2000 bytes) is below usage (3000 bytes)\n"),
+	}.Check(t, h)
+
+	// the quota shows up in the repo list
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"repositories": []assert.JSONObject{
+			{"name": "scratch", "manifest_count": 0, "tag_count": 0, "size_bytes": 3000, "storage_quota_bytes": 5000},
+		}},
+	}.Check(t, h)
+
+	// omitting the quota leaves it unchanged
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/scratch",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repository": assert.JSONObject{"name": "scratch", "manifest_count": 0, "tag_count": 0, "size_bytes": 3000, "storage_quota_bytes": 5000},
+		},
+	}.Check(t, h)
+
+	// the quota can be removed again
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/scratch",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{"storage_quota_bytes": nil}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repository": assert.JSONObject{"name": "scratch", "manifest_count": 0, "tag_count": 0, "size_bytes": 3000},
+		},
+	}.Check(t, h)
+}
+
+func TestArchivedRepositories(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{AccountName: "test1", Name: "bar"}),
+		test.WithRepo(models.Repository{AccountName: "test1", Name: "foo"}),
+	)
+	h := s.Handler
+
+	// archiving a repo requires permission to change the account
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,push:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{"archived": true}},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	tr, _ := easypg.NewTracker(t, s.DB.Db)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{"archived": true}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repository": assert.JSONObject{"name": "foo", "manifest_count": 0, "tag_count": 0, "archived": true},
+		},
+	}.Check(t, h)
+	tr.DBChanges().AssertEqual(`UPDATE repos SET is_archived = TRUE WHERE id = 2 AND account_name = 'test1' AND name = 'foo';`)
+	s.Auditor.ExpectEvents(t, cadf.Event{
+		RequestPath: "/keppel/v1/accounts/test1/repositories/foo",
+		Action:      cadf.UpdateAction,
+		Outcome:     "success",
+		Reason:      test.CADFReasonOK,
+		Target: cadf.Resource{
+			TypeURI:   "docker-registry/account/repository",
+			ID:        "test1/foo",
+			ProjectID: "tenant1",
+			Attachments: []cadf.Attachment{{
+				Name:    "payload",
+				TypeURI: "mime:application/json",
+				Content: `{"name":"foo","manifest_count":0,"tag_count":0,"archived":true}`,
+			}},
+		},
+	})
+
+	// archived repos are hidden from the repo list by default
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"repositories": []assert.JSONObject{
+			{"name": "bar", "manifest_count": 0, "tag_count": 0},
+		}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories?include_archived=true",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"repositories": []assert.JSONObject{
+			{"name": "bar", "manifest_count": 0, "tag_count": 0},
+			{"name": "foo", "manifest_count": 0, "tag_count": 0, "archived": true},
+		}},
+	}.Check(t, h)
+
+	// updates that do not mention the archival state do not unarchive the repo
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{"storage_quota_bytes": 1000}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repository": assert.JSONObject{"name": "foo", "manifest_count": 0, "tag_count": 0, "archived": true, "storage_quota_bytes": 1000},
+		},
+	}.Check(t, h)
+	tr.DBChanges().AssertEqual(`UPDATE repos SET storage_quota_bytes = 1000 WHERE id = 2 AND account_name = 'test1' AND name = 'foo';`)
+
+	// archived repos are read-only, so nothing can be deleted from them
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + test.DeterministicDummyDigest(1).String(),
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,delete:tenant1"},
+		ExpectStatus: http.StatusMethodNotAllowed,
+		ExpectBody:   assert.StringData("repository is archived\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_tags/latest",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,delete:tenant1"},
+		ExpectStatus: http.StatusMethodNotAllowed,
+		ExpectBody:   assert.StringData("repository is archived\n"),
+	}.Check(t, h)
+	tr.DBChanges().AssertEmpty()
+
+	// unarchiving works the same way
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{"archived": false}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repository": assert.JSONObject{"name": "foo", "manifest_count": 0, "tag_count": 0, "storage_quota_bytes": 1000},
+		},
+	}.Check(t, h)
+	tr.DBChanges().AssertEqual(`UPDATE repos SET is_archived = FALSE WHERE id = 2 AND account_name = 'test1' AND name = 'foo';`)
+}
+
+func TestRepositoryGCPolicies(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{AccountName: "test1", Name: "golden-images"}),
+	)
+	h := s.Handler
+
+	// invalid GC policies are rejected
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1/repositories/golden-images",
+		Header: map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body: assert.JSONObject{"repository": assert.JSONObject{
+			"gc_policies": []assert.JSONObject{{"only_untagged": true}},
+		}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("GC policy must have the \"action\" attribute\n"),
+	}.Check(t, h)
+
+	// `match_repository` is optional for GC policies on repos
+	tr, _ := easypg.NewTracker(t, s.DB.Db)
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1/repositories/golden-images",
+		Header: map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body: assert.JSONObject{"repository": assert.JSONObject{
+			"gc_policies":                  []assert.JSONObject{{"only_untagged": true, "action": "delete"}},
+			"ignore_inherited_gc_policies": true,
+		}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repository": assert.JSONObject{
+				"name":                         "golden-images",
+				"manifest_count":               0,
+				"tag_count":                    0,
+				"gc_policies":                  []assert.JSONObject{{"match_repository": ".*", "only_untagged": true, "action": "delete"}},
+				"ignore_inherited_gc_policies": true,
+			},
+		},
+	}.Check(t, h)
+	tr.DBChanges().AssertEqual(`UPDATE repos SET gc_policies_json = '[{"match_repository":".*","only_untagged":true,"action":"delete"}]', ignore_inherited_gc_policies = TRUE WHERE id = 1 AND account_name = 'test1' AND name = 'golden-images';`)
+
+	// the repo listing shows these settings as well
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"repositories": []assert.JSONObject{{
+			"name":                         "golden-images",
+			"manifest_count":               0,
+			"tag_count":                    0,
+			"gc_policies":                  []assert.JSONObject{{"match_repository": ".*", "only_untagged": true, "action": "delete"}},
+			"ignore_inherited_gc_policies": true,
+		}}},
+	}.Check(t, h)
+
+	// like all other attributes, omitting them leaves them unchanged...
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/golden-images",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"repository": assert.JSONObject{"storage_quota_bytes": 5000}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repository": assert.JSONObject{
+				"name":                         "golden-images",
+				"manifest_count":               0,
+				"tag_count":                    0,
+				"storage_quota_bytes":          5000,
+				"gc_policies":                  []assert.JSONObject{{"match_repository": ".*", "only_untagged": true, "action": "delete"}},
+				"ignore_inherited_gc_policies": true,
+			},
+		},
+	}.Check(t, h)
+	tr.DBChanges().AssertEqual(`UPDATE repos SET storage_quota_bytes = 5000 WHERE id = 1 AND account_name = 'test1' AND name = 'golden-images';`)
+
+	// ...and they can be removed explicitly
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1/repositories/golden-images",
+		Header: map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body: assert.JSONObject{"repository": assert.JSONObject{
+			"storage_quota_bytes":          nil,
+			"gc_policies":                  []assert.JSONObject{},
+			"ignore_inherited_gc_policies": false,
+		}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repository": assert.JSONObject{"name": "golden-images", "manifest_count": 0, "tag_count": 0},
+		},
+	}.Check(t, h)
+	tr.DBChanges().AssertEqual(`UPDATE repos SET storage_quota_bytes = NULL, gc_policies_json = '', ignore_inherited_gc_policies = FALSE WHERE id = 1 AND account_name = 'test1' AND name = 'golden-images';`)
+}
@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sapcc/go-bits/errext"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/regexpext"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// harborCVEAllowlist is the format of CVE allowlists in Harbor, as returned by
+// its API for projects and for the system-wide allowlist.
+type harborCVEAllowlist struct {
+	ID           int64  `json:"id"`
+	ProjectID    int64  `json:"project_id"`
+	ExpiresAt    *int64 `json:"expires_at"`
+	CreationTime string `json:"creation_time"`
+	UpdateTime   string `json:"update_time"`
+	Items        []struct {
+		CVEID string `json:"cve_id"`
+	} `json:"items"`
+}
+
+// quayCVEWhitelist is the format of the whitelist files that are used to
+// suppress vulnerabilities when scanning images on Quay with Clair (in the JSON
+// representation). Both maps go from vulnerability ID to a justification. The
+// outer map in Images goes from image name to the vulnerabilities that are
+// suppressed only for that image.
+type quayCVEWhitelist struct {
+	GeneralWhitelist map[string]string            `json:"generalwhitelist"`
+	Images           map[string]map[string]string `json:"images"`
+}
+
+func (a *API) handlePostSecurityScanPolicyImport(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/security_scan_policies/import")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	// decode existing policies
+	var dbPolicies []keppel.SecurityScanPolicy
+	err := json.Unmarshal([]byte(account.SecurityScanPoliciesJSON), &dbPolicies)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	// decode request body and convert into policies
+	var imported []keppel.SecurityScanPolicy
+	switch format := r.URL.Query().Get("format"); format {
+	case "harbor":
+		var allowlist harborCVEAllowlist
+		if !decodeJSONRequestBody(w, r.Body, &allowlist) {
+			return
+		}
+		imported, err = convertHarborCVEAllowlist(allowlist, a.timeNow())
+	case "quay":
+		var whitelist quayCVEWhitelist
+		if !decodeJSONRequestBody(w, r.Body, &whitelist) {
+			return
+		}
+		imported, err = convertQuayCVEWhitelist(whitelist, account.Name)
+	default:
+		http.Error(w, `query parameter "format" must be "harbor" or "quay"`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// imported policies are added after the existing ones, so existing policies
+	// take precedence when both match the same vulnerability
+	policies := slices.Clone(dbPolicies)
+	var errs errext.ErrorSet
+	for idx, policy := range imported {
+		errs.Append(policy.Validate(fmt.Sprintf("imported policies[%d]", idx)))
+		if !slices.Contains(policies, policy) {
+			policies = append(policies, policy)
+		}
+	}
+	if !errs.IsEmpty() {
+		http.Error(w, errs.Join("\n"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !a.storeSecurityScanPolicies(w, r, authz, *account, dbPolicies, policies) {
+		return
+	}
+	if policies == nil {
+		policies = []keppel.SecurityScanPolicy{}
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"policies": policies})
+}
+
+func convertHarborCVEAllowlist(allowlist harborCVEAllowlist, now time.Time) ([]keppel.SecurityScanPolicy, error) {
+	assessment := "imported from Harbor CVE allowlist"
+	if allowlist.ExpiresAt != nil {
+		expiresAt := time.Unix(*allowlist.ExpiresAt, 0).UTC()
+		if !expiresAt.After(now) {
+			return nil, fmt.Errorf("cannot import a Harbor CVE allowlist that expired at %s", expiresAt.Format(time.RFC3339))
+		}
+		// Keppel policies do not expire, so we can only inform the user about this
+		assessment += fmt.Sprintf(" (was set to expire at %s)", expiresAt.Format(time.RFC3339))
+	}
+
+	result := make([]keppel.SecurityScanPolicy, 0, len(allowlist.Items))
+	for idx, item := range allowlist.Items {
+		if strings.TrimSpace(item.CVEID) == "" {
+			return nil, fmt.Errorf("items[%d] in Harbor CVE allowlist does not have a CVE ID", idx)
+		}
+		result = append(result, importedSecurityScanPolicy(".*", item.CVEID, assessment))
+	}
+	return result, nil
+}
+
+func convertQuayCVEWhitelist(whitelist quayCVEWhitelist, accountName models.AccountName) ([]keppel.SecurityScanPolicy, error) {
+	makeAssessment := func(justification string) string {
+		if justification == "" {
+			return "imported from Quay CVE whitelist"
+		}
+		return "imported from Quay CVE whitelist: " + justification
+	}
+
+	var result []keppel.SecurityScanPolicy
+	for _, vulnID := range slices.Sorted(maps.Keys(whitelist.GeneralWhitelist)) {
+		result = append(result, importedSecurityScanPolicy(".*", vulnID, makeAssessment(whitelist.GeneralWhitelist[vulnID])))
+	}
+	for _, imageName := range slices.Sorted(maps.Keys(whitelist.Images)) {
+		// image names may or may not include the account name
+		repoName := strings.TrimPrefix(imageName, string(accountName)+"/")
+		if !isValidRepoName(repoName) {
+			return nil, fmt.Errorf("image name %q in Quay CVE whitelist is not a valid repository name", imageName)
+		}
+		vulns := whitelist.Images[imageName]
+		for _, vulnID := range slices.Sorted(maps.Keys(vulns)) {
+			result = append(result, importedSecurityScanPolicy(regexp.QuoteMeta(repoName), vulnID, makeAssessment(vulns[vulnID])))
+		}
+	}
+	return result, nil
+}
+
+func importedSecurityScanPolicy(repositoryRx, vulnID, assessment string) keppel.SecurityScanPolicy {
+	return keppel.SecurityScanPolicy{
+		RepositoryRx:      regexpext.BoundedRegexp(repositoryRx),
+		VulnerabilityIDRx: regexpext.BoundedRegexp(regexp.QuoteMeta(strings.TrimSpace(vulnID))),
+		Action: keppel.SecurityScanPolicyAction{
+			Assessment: assessment,
+			Ignore:     true,
+		},
+	}
+}
@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/processor"
+)
+
+func (a *API) handleGetSnapshots(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/snapshots")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var dbSnapshots []models.AccountSnapshot
+	_, err := a.db.Select(&dbSnapshots, `SELECT * FROM account_snapshots WHERE account_name = $1 ORDER BY id`, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	snapshots := make([]keppel.AccountSnapshot, len(dbSnapshots))
+	for idx, s := range dbSnapshots {
+		snapshots[idx], err = keppel.RenderAccountSnapshot(s, false)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"snapshots": snapshots})
+}
+
+func (a *API) handlePostSnapshot(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/snapshots")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	snapshot, err := a.processor().CreateAccountSnapshot(account.Reduced(), keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	rendered, err := keppel.RenderAccountSnapshot(snapshot, false)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusCreated, map[string]any{"snapshot": rendered})
+}
+
+func (a *API) handleGetSnapshot(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/snapshots/:id")
+	_, _, snapshot := a.findSnapshotFromRequest(w, r, keppel.CanViewAccount)
+	if snapshot == nil {
+		return
+	}
+	rendered, err := keppel.RenderAccountSnapshot(*snapshot, true)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"snapshot": rendered})
+}
+
+func (a *API) handlePostRestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/snapshots/:id/restore")
+	authz, account, snapshot := a.findSnapshotFromRequest(w, r, keppel.CanChangeAccount)
+	if snapshot == nil {
+		return
+	}
+
+	result, err := a.processor().RestoreAccountSnapshot(account.Reduced(), *snapshot, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, result)
+}
+
+func (a *API) handleDeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/snapshots/:id")
+	authz, account, snapshot := a.findSnapshotFromRequest(w, r, keppel.CanChangeAccount)
+	if snapshot == nil {
+		return
+	}
+	_, err := a.db.Delete(snapshot)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusNoContent,
+			Action:     cadf.DeleteAction,
+			Target:     processor.AuditAccountSnapshot{Account: account.Reduced(), Snapshot: *snapshot},
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Shared preparation for the endpoints that refer to a single snapshot.
+// If the returned snapshot is nil, an error response has been written.
+func (a *API) findSnapshotFromRequest(w http.ResponseWriter, r *http.Request, perm keppel.Permission) (*auth.Authorization, *models.Account, *models.AccountSnapshot) {
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, perm))
+	if authz == nil {
+		return nil, nil, nil
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return nil, nil, nil
+	}
+
+	var snapshot models.AccountSnapshot
+	err := a.db.SelectOne(&snapshot, `SELECT * FROM account_snapshots WHERE account_name = $1 AND id = $2`, account.Name, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such snapshot", http.StatusNotFound)
+		return nil, nil, nil
+	}
+	if respondwith.ErrorText(w, err) {
+		return nil, nil, nil
+	}
+	return authz, account, &snapshot
+}
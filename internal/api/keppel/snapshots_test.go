@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestAccountSnapshots(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	// the creator of each snapshot is recorded by username
+	s.AD.ExpectedUserName = "correctusername"
+
+	// setup: two repos with some manifests and tags
+	fooRepo := models.Repository{Name: "foo", AccountName: "test1"}
+	mustInsert(t, s.DB, &fooRepo)
+	barRepo := models.Repository{Name: "bar", AccountName: "test1"}
+	mustInsert(t, s.DB, &barRepo)
+	digests := make([]digest.Digest, 4)
+	for idx := range digests {
+		digests[idx] = digest.FromString(string(rune('a' + idx)))
+	}
+	insertManifest := func(repo models.Repository, d digest.Digest) {
+		mustInsert(t, s.DB, &models.Manifest{
+			RepositoryID:     repo.ID,
+			Digest:           d,
+			MediaType:        "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:        1000,
+			PushedAt:         time.Unix(1000, 0),
+			NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+		})
+	}
+	insertTag := func(repo models.Repository, name string, d digest.Digest) {
+		mustInsert(t, s.DB, &models.Tag{RepositoryID: repo.ID, Name: name, Digest: d, PushedAt: time.Unix(1000, 0)})
+	}
+	insertManifest(fooRepo, digests[0])
+	insertManifest(fooRepo, digests[1])
+	insertManifest(barRepo, digests[2])
+	insertManifest(barRepo, digests[3])
+	insertTag(fooRepo, "latest", digests[0])
+	insertTag(fooRepo, "v1", digests[0])
+	insertTag(barRepo, "stable", digests[2])
+	insertTag(barRepo, "testing", digests[3])
+
+	// no snapshots yet
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/snapshots",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"snapshots": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// creating a snapshot requires permission to change the account
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/snapshots",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// happy path: create a snapshot
+	s.Clock.StepBy(time.Hour)
+	snapshotSummary := assert.JSONObject{
+		"id":               1,
+		"created_at":       s.Clock.Now().Unix(),
+		"created_by":       "correctusername",
+		"repository_count": 2,
+		"manifest_count":   4,
+		"tag_count":        4,
+	}
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/snapshots",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusCreated,
+		ExpectBody:   assert.JSONObject{"snapshot": snapshotSummary},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/snapshots",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"snapshots": []assert.JSONObject{snapshotSummary}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/snapshots/1",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"snapshot": assert.JSONObject{
+			"id":               1,
+			"created_at":       s.Clock.Now().Unix(),
+			"created_by":       "correctusername",
+			"repository_count": 2,
+			"manifest_count":   4,
+			"tag_count":        4,
+			"repositories": []assert.JSONObject{
+				{
+					"name":      "bar",
+					"manifests": []string{digests[2].String(), digests[3].String()},
+					"tags": []assert.JSONObject{
+						{"name": "stable", "digest": digests[2].String()},
+						{"name": "testing", "digest": digests[3].String()},
+					},
+				},
+				{
+					"name":      "foo",
+					"manifests": []string{digests[0].String(), digests[1].String()},
+					"tags": []assert.JSONObject{
+						{"name": "latest", "digest": digests[0].String()},
+						{"name": "v1", "digest": digests[0].String()},
+					},
+				},
+			},
+		}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/snapshots/2",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such snapshot\n"),
+	}.Check(t, h)
+
+	// simulate a bad automation: move and delete tags, delete a manifest, and add a new tag
+	mustExec(t, s.DB, `UPDATE tags SET digest = $1 WHERE repo_id = $2 AND name = 'latest'`, digests[1], fooRepo.ID)
+	mustExec(t, s.DB, `DELETE FROM tags WHERE repo_id = $1 AND name = 'v1'`, fooRepo.ID)
+	mustExec(t, s.DB, `DELETE FROM tags WHERE repo_id = $1 AND name = 'testing'`, barRepo.ID)
+	mustExec(t, s.DB, `DELETE FROM manifests WHERE repo_id = $1 AND digest = $2`, barRepo.ID, digests[3])
+	insertTag(fooRepo, "new", digests[1])
+
+	// restoring requires permission to change the account
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/snapshots/1/restore",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// happy path: restore tags as far as the manifests still exist
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/snapshots/1/restore",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"restored_tags": []assert.JSONObject{
+				{"repository": "foo", "name": "latest", "digest": digests[0].String(), "previous_digest": digests[1].String()},
+				{"repository": "foo", "name": "v1", "digest": digests[0].String()},
+			},
+			"skipped_tags": []assert.JSONObject{
+				{"repository": "bar", "name": "testing", "digest": digests[3].String(), "reason": "manifest does not exist anymore"},
+			},
+		},
+	}.Check(t, h)
+	tagCount, err := s.DB.SelectInt(`SELECT COUNT(*) FROM tags WHERE repo_id = $1`, fooRepo.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "tag count in repo foo", tagCount, int64(3)) // the new tag stays in place
+
+	// restoring again is a no-op, except for tags that still cannot be restored
+	mustExec(t, s.DB, `DELETE FROM tags WHERE repo_id = $1`, barRepo.ID)
+	mustExec(t, s.DB, `DELETE FROM manifests WHERE repo_id = $1`, barRepo.ID)
+	mustExec(t, s.DB, `DELETE FROM repos WHERE id = $1`, barRepo.ID)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/snapshots/1/restore",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"restored_tags": []assert.JSONObject{},
+			"skipped_tags": []assert.JSONObject{
+				{"repository": "bar", "name": "stable", "digest": digests[2].String(), "reason": "repository does not exist anymore"},
+				{"repository": "bar", "name": "testing", "digest": digests[3].String(), "reason": "repository does not exist anymore"},
+			},
+		},
+	}.Check(t, h)
+
+	// delete the snapshot
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/snapshots/1",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/snapshots/1",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/snapshots",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"snapshots": []assert.JSONObject{}},
+	}.Check(t, h)
+}
@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestTagProtectionPolicies(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	changeHeaders := map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1,delete:tenant1"}
+	deleteHeaders := map[string]string{"X-Test-Perms": "view:tenant1,delete:tenant1"}
+
+	// setup: one repo with one manifest that has a protected and an unprotected tag,
+	// and one manifest that only has an unprotected tag
+	repo := models.Repository{Name: "foo", AccountName: "test1"}
+	mustInsert(t, s.DB, &repo)
+	digests := []digest.Digest{digest.FromString("a"), digest.FromString("b")}
+	for _, d := range digests {
+		mustInsert(t, s.DB, &models.Manifest{
+			RepositoryID:     repo.ID,
+			Digest:           d,
+			MediaType:        "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:        1000,
+			PushedAt:         time.Unix(1000, 0),
+			NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+		})
+	}
+	mustInsert(t, s.DB, &models.Tag{RepositoryID: repo.ID, Name: "release-1", Digest: digests[0], PushedAt: time.Unix(1000, 0)})
+	mustInsert(t, s.DB, &models.Tag{RepositoryID: repo.ID, Name: "latest", Digest: digests[0], PushedAt: time.Unix(1000, 0)})
+	mustInsert(t, s.DB, &models.Tag{RepositoryID: repo.ID, Name: "nightly", Digest: digests[1], PushedAt: time.Unix(1000, 0)})
+
+	// invalid policies are rejected
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1",
+		Header: changeHeaders,
+		Body: assert.JSONObject{
+			"account": assert.JSONObject{
+				"auth_tenant_id":          "tenant1",
+				"tag_protection_policies": []assert.JSONObject{{"match_repository": "foo"}},
+			},
+		},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("tag protection policy must have the \"match_tag\" attribute\n"),
+	}.Check(t, h)
+
+	// setup a tag protection policy
+	policy := assert.JSONObject{"match_repository": "foo", "match_tag": "release-.*", "except_tag": "release-rc.*"}
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1",
+		Header: changeHeaders,
+		Body: assert.JSONObject{
+			"account": assert.JSONObject{"auth_tenant_id": "tenant1", "tag_protection_policies": []assert.JSONObject{policy}},
+		},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"account": assert.JSONObject{
+				"name":                    "test1",
+				"auth_tenant_id":          "tenant1",
+				"tag_protection_policies": []assert.JSONObject{policy},
+				"rbac_policies":           []assert.JSONObject{},
+				"metadata":                nil,
+			},
+		},
+	}.Check(t, h)
+
+	// users without permission to change the account can neither delete the
+	// protected tag nor the manifest that it points to
+	expectedError := assert.StringData("tag \"release-1\" is protected from deletion by a tag protection policy (match_tag: \"release-.*\")\n")
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_tags/release-1",
+		Header:       deleteHeaders,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   expectedError,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + digests[0].String(),
+		Header:       deleteHeaders,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   expectedError,
+	}.Check(t, h)
+
+	// unprotected tags and manifests can still be deleted
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_tags/latest",
+		Header:       deleteHeaders,
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_manifests/" + digests[1].String(),
+		Header:       deleteHeaders,
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+
+	// account admins can delete protected tags
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_tags/release-1",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+
+	// immutable tags cannot even be deleted by account admins
+	mustInsert(t, s.DB, &models.Tag{RepositoryID: repo.ID, Name: "v1.0", Digest: digests[0], PushedAt: time.Unix(1000, 0)})
+	policy = assert.JSONObject{"match_tag": "v[0-9].*", "immutable": true}
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1",
+		Header: changeHeaders,
+		Body: assert.JSONObject{
+			"account": assert.JSONObject{"auth_tenant_id": "tenant1", "tag_protection_policies": []assert.JSONObject{policy}},
+		},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"account": assert.JSONObject{
+				"name":                    "test1",
+				"auth_tenant_id":          "tenant1",
+				"tag_protection_policies": []assert.JSONObject{policy},
+				"rbac_policies":           []assert.JSONObject{},
+				"metadata":                nil,
+			},
+		},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_tags/v1.0",
+		Header:       changeHeaders,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.StringData("tag \"v1.0\" is immutable and cannot be deleted (match_tag: \"v[0-9].*\")\n"),
+	}.Check(t, h)
+}
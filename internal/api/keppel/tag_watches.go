@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func (a *API) handleGetTagWatches(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/tag_watches")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var dbWatches []models.TagWatch
+	_, err := a.db.Select(&dbWatches, `SELECT * FROM tag_watches WHERE account_name = $1 ORDER BY id`, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	watches := make([]keppel.TagWatch, len(dbWatches))
+	for idx, tw := range dbWatches {
+		watches[idx] = keppel.RenderTagWatch(tw)
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"tag_watches": watches})
+}
+
+func (a *API) handlePostTagWatch(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/tag_watches")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	if account.ExternalPeerURL == "" {
+		http.Error(w, "tag watches are only supported in replica accounts with an external upstream", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var req struct {
+		TagWatch keppel.TagWatch `json:"tag_watch"`
+	}
+	ok := decodeJSONRequestBody(w, r.Body, &req)
+	if !ok {
+		return
+	}
+	now := a.timeNow()
+	watch := models.TagWatch{
+		AccountName: account.Name,
+		CreatedAt:   now,
+		CreatedBy:   authz.UserIdentity.UserName(),
+		NextCheckAt: now, // check ASAP to record the initial state
+	}
+	err := req.TagWatch.ApplyToModel(&watch, a.cfg.WebhookTargets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if !isValidRepoName(watch.RepoName) {
+		http.Error(w, "repo name invalid", http.StatusUnprocessableEntity)
+		return
+	}
+
+	existingCount, err := a.db.SelectInt(
+		`SELECT COUNT(*) FROM tag_watches WHERE account_name = $1 AND repo_name = $2 AND tag_pattern = $3`,
+		watch.AccountName, watch.RepoName, watch.TagPattern)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if existingCount > 0 {
+		http.Error(w, "a tag watch with this repository and match_tag already exists", http.StatusConflict)
+		return
+	}
+	err = a.db.Insert(&watch)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusCreated,
+			Action:     cadf.CreateAction,
+			Target:     AuditTagWatch{Account: *account, Watch: watch},
+		})
+	}
+
+	respondwith.JSON(w, http.StatusCreated, map[string]any{"tag_watch": keppel.RenderTagWatch(watch)})
+}
+
+func (a *API) handleDeleteTagWatch(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/tag_watches/:id")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var watch models.TagWatch
+	err := a.db.SelectOne(&watch, `SELECT * FROM tag_watches WHERE account_name = $1 AND id = $2`, account.Name, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such tag watch", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	_, err = a.db.Delete(&watch)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusNoContent,
+			Action:     cadf.DeleteAction,
+			Target:     AuditTagWatch{Account: *account, Watch: watch},
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
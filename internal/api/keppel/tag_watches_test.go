@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestTagWatchesAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1", ExternalPeerURL: "registry.example.org/library"}),
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	// the creator of each tag watch is recorded by username
+	s.AD.ExpectedUserName = "correctusername"
+
+	// tag watches are only supported in external replica accounts
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test2/tag_watches",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"tag_watch": assert.JSONObject{"repository": "foo", "match_tag": ".*"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("tag watches are only supported in replica accounts with an external upstream\n"),
+	}.Check(t, h)
+
+	// creating tag watches requires change permission
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/tag_watches",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         assert.JSONObject{"tag_watch": assert.JSONObject{"repository": "foo", "match_tag": ".*"}},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// initially, there are no tag watches
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/tag_watches",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"tag_watches": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// invalid tag watches are rejected
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/tag_watches",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"tag_watch": assert.JSONObject{"match_tag": ".*"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("missing attribute \"repository\" in tag watch\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/tag_watches",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"tag_watch": assert.JSONObject{"repository": "foo"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("missing attribute \"match_tag\" in tag watch\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/tag_watches",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"tag_watch": assert.JSONObject{"repository": "foo", "match_tag": ".*", "webhook_url": "ftp://example.com"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("\"ftp://example.com\" is not a valid http(s) URL\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/tag_watches",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"tag_watch": assert.JSONObject{"repository": "foo", "match_tag": ".*", "webhook_url": "http://169.254.169.254/latest/meta-data/"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("\"http://169.254.169.254/latest/meta-data/\" points to a non-public IP address\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/tag_watches",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"tag_watch": assert.JSONObject{"repository": "Foo", "match_tag": ".*"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("repo name invalid\n"),
+	}.Check(t, h)
+	s.Auditor.ExpectEvents(t /*, nothing */)
+
+	// happy path
+	expectedWatch := assert.JSONObject{
+		"id":          1,
+		"repository":  "foo",
+		"match_tag":   `v1\..*`,
+		"webhook_url": "https://hooks.example.com/keppel",
+		"created_at":  s.Clock.Now().Unix(),
+		"created_by":  "correctusername",
+	}
+	assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/accounts/test1/tag_watches",
+		Header: map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body: assert.JSONObject{"tag_watch": assert.JSONObject{
+			"repository":  "foo",
+			"match_tag":   `v1\..*`,
+			"webhook_url": "https://hooks.example.com/keppel",
+		}},
+		ExpectStatus: http.StatusCreated,
+		ExpectBody:   assert.JSONObject{"tag_watch": expectedWatch},
+	}.Check(t, h)
+	s.Auditor.ExpectEvents(t, cadf.Event{
+		RequestPath: "/keppel/v1/accounts/test1/tag_watches",
+		Action:      cadf.CreateAction,
+		Outcome:     "success",
+		Reason:      test.CADFReasonOK,
+		Target: cadf.Resource{
+			TypeURI:   "docker-registry/account/repository/tag-watch",
+			Name:      "test1/foo",
+			ID:        "1",
+			ProjectID: "tenant1",
+			Attachments: []cadf.Attachment{{
+				Name:    "payload",
+				TypeURI: "mime:application/json",
+				Content: test.ToJSON(expectedWatch),
+			}},
+		},
+	})
+
+	// duplicate tag watches are rejected
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/tag_watches",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"tag_watch": assert.JSONObject{"repository": "foo", "match_tag": `v1\..*`}},
+		ExpectStatus: http.StatusConflict,
+		ExpectBody:   assert.StringData("a tag watch with this repository and match_tag already exists\n"),
+	}.Check(t, h)
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/tag_watches",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"tag_watches": []assert.JSONObject{expectedWatch}},
+	}.Check(t, h)
+
+	// deleting tag watches
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test2/tag_watches/1",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such tag watch\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/tag_watches/1",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	s.Auditor.IgnoreEventsUntilNow()
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/tag_watches/1",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such tag watch\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/tag_watches",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"tag_watches": []assert.JSONObject{}},
+	}.Check(t, h)
+}
@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func (a *API) handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/webhooks")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var dbWebhooks []models.Webhook
+	_, err := a.db.Select(&dbWebhooks, `SELECT * FROM webhooks WHERE account_name = $1 ORDER BY id`, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	// users who cannot change the account only see the webhooks of the namespaces that they administer
+	isAllowed, err := a.webhookPermissionChecker(*account, authz)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	webhooks := []keppel.Webhook{}
+	for _, wh := range dbWebhooks {
+		if isAllowed(wh.NamespacePrefix) {
+			webhooks = append(webhooks, keppel.RenderWebhook(wh))
+		}
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"webhooks": webhooks})
+}
+
+// Returns a function that checks whether the user can manage the webhooks of
+// the given namespace (or the account-level webhooks, if the prefix is
+// empty). Account admins can manage all webhooks, whereas namespace admins
+// can only manage the webhooks of their namespaces.
+func (a *API) webhookPermissionChecker(account models.Account, authz *auth.Authorization) (func(namespacePrefix string) bool, error) {
+	if authz.UserIdentity.HasPermission(keppel.CanChangeAccount, account.AuthTenantID) {
+		return func(string) bool { return true }, nil
+	}
+	var namespaces []models.RepositoryNamespace
+	_, err := a.db.Select(&namespaces, `SELECT * FROM repo_namespaces WHERE account_name = $1`, account.Name)
+	if err != nil {
+		return nil, err
+	}
+	administeredPrefixes := make(map[string]bool)
+	for _, ns := range namespaces {
+		if keppel.IsNamespaceAdmin(ns, authz.UserIdentity) {
+			administeredPrefixes[ns.Prefix] = true
+		}
+	}
+	return func(namespacePrefix string) bool {
+		return namespacePrefix != "" && administeredPrefixes[namespacePrefix]
+	}, nil
+}
+
+func (a *API) handlePostWebhook(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/webhooks")
+	// this endpoint can be used by namespace admins who do not have permission
+	// to change the account, so the precise permission check happens below
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var req struct {
+		Webhook keppel.Webhook `json:"webhook"`
+	}
+	ok := decodeJSONRequestBody(w, r.Body, &req)
+	if !ok {
+		return
+	}
+
+	isAllowed, err := a.webhookPermissionChecker(*account, authz)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if !isAllowed(req.Webhook.Namespace) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if req.Webhook.Namespace != "" {
+		exists, err := a.db.SelectBool(`SELECT COUNT(*) > 0 FROM repo_namespaces WHERE account_name = $1 AND prefix = $2`, account.Name, req.Webhook.Namespace)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+		if !exists {
+			http.Error(w, "no such namespace", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	webhook := models.Webhook{
+		AccountName: account.Name,
+		CreatedAt:   a.timeNow(),
+		CreatedBy:   authz.UserIdentity.UserName(),
+	}
+	err = req.Webhook.ApplyToModel(&webhook, a.cfg.WebhookTargets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	webhook.Secret, err = keppel.GenerateWebhookSecret()
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	err = a.db.Insert(&webhook)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusCreated,
+			Action:     cadf.CreateAction,
+			Target:     AuditWebhook{Account: *account, Webhook: webhook},
+		})
+	}
+
+	// the secret is only shown once, so that it does not leak to users who can only view the account
+	rendered := keppel.RenderWebhook(webhook)
+	rendered.Secret = webhook.Secret
+	respondwith.JSON(w, http.StatusCreated, map[string]any{"webhook": rendered})
+}
+
+func (a *API) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/webhooks/:id")
+	// this endpoint can be used by namespace admins who do not have permission
+	// to change the account, so the precise permission check happens below
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var webhook models.Webhook
+	err := a.db.SelectOne(&webhook, `SELECT * FROM webhooks WHERE account_name = $1 AND id = $2`, account.Name, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such webhook", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	isAllowed, err := a.webhookPermissionChecker(*account, authz)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if !isAllowed(webhook.NamespacePrefix) {
+		// do not reveal the existence of webhooks that the user cannot see
+		http.Error(w, "no such webhook", http.StatusNotFound)
+		return
+	}
+	_, err = a.db.Delete(&webhook)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusNoContent,
+			Action:     cadf.DeleteAction,
+			Target:     AuditWebhook{Account: *account, Webhook: webhook},
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,397 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppelv1_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestWebhooksAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	// the creator of each webhook is recorded by username
+	s.AD.ExpectedUserName = "correctusername"
+
+	// initially, there are no webhooks
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"webhooks": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// invalid webhooks are rejected
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"webhook": assert.JSONObject{"url": "ftp://example.com", "events": []string{"push"}}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("\"ftp://example.com\" is not a valid http(s) URL\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"webhook": assert.JSONObject{"url": "https://hooks.example.com/keppel"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("missing attribute \"events\" in webhook\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body:         assert.JSONObject{"webhook": assert.JSONObject{"url": "https://hooks.example.com/keppel", "events": []string{"pull"}}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("\"pull\" is not a valid webhook event type\n"),
+	}.Check(t, h)
+
+	// account-level webhooks can only be managed by users who can change the account
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,push:tenant1"},
+		Body:         assert.JSONObject{"webhook": assert.JSONObject{"url": "https://hooks.example.com/keppel", "events": []string{"push"}}},
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.StringData("Forbidden\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/accounts/test1/webhooks",
+		Header: map[string]string{"X-Test-Perms": "view:tenant1,push:tenant1"},
+		Body: assert.JSONObject{"webhook": assert.JSONObject{
+			"match_repository": "foo",
+			"url":              "https://hooks.example.com/keppel",
+			"events":           []string{"push"},
+		}},
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.StringData("Forbidden\n"),
+	}.Check(t, h)
+
+	// webhooks cannot point into internal networks (except for loopback, which test.Setup allows for the janitor tests)
+	for _, url := range []string{
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/hook",
+		"http://[fd00:ec2::254]/hook",
+		"http://[::ffff:192.168.0.1]/hook",
+	} {
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/keppel/v1/accounts/test1/webhooks",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+			Body:         assert.JSONObject{"webhook": assert.JSONObject{"url": url, "events": []string{"push"}}},
+			ExpectStatus: http.StatusUnprocessableEntity,
+			ExpectBody:   assert.StringData(fmt.Sprintf("%q points to a non-public IP address\n", url)),
+		}.Check(t, h)
+	}
+	s.Auditor.ExpectEvents(t /*, nothing */)
+
+	// happy path
+	expectedAccountWebhook := assert.JSONObject{
+		"id":         1,
+		"url":        "https://hooks.example.com/account",
+		"events":     []string{"push", "vulnerability_scan"},
+		"created_at": s.Clock.Now().Unix(),
+		"created_by": "correctusername",
+	}
+	_, respBody := assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/accounts/test1/webhooks",
+		Header: map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body: assert.JSONObject{"webhook": assert.JSONObject{
+			"url":    "https://hooks.example.com/account",
+			"events": []string{"push", "vulnerability_scan", "push"},
+		}},
+		ExpectStatus: http.StatusCreated,
+	}.Check(t, h)
+	expectSecretInResponse(t, s, respBody, 1, expectedAccountWebhook)
+	s.Auditor.ExpectEvents(t, cadf.Event{
+		RequestPath: "/keppel/v1/accounts/test1/webhooks",
+		Action:      cadf.CreateAction,
+		Outcome:     "success",
+		Reason:      test.CADFReasonOK,
+		Target: cadf.Resource{
+			TypeURI:   "docker-registry/account/webhook",
+			Name:      "test1",
+			ID:        "1",
+			ProjectID: "tenant1",
+			Attachments: []cadf.Attachment{{
+				Name:    "payload",
+				TypeURI: "mime:application/json",
+				Content: test.ToJSON(expectedAccountWebhook),
+			}},
+		},
+	})
+
+	expectedRepoWebhook := assert.JSONObject{
+		"id":               2,
+		"match_repository": "team-a/.*",
+		"url":              "https://hooks.example.com/team-a",
+		"events":           []string{"push"},
+		"created_at":       s.Clock.Now().Unix(),
+		"created_by":       "correctusername",
+	}
+	_, respBody = assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/accounts/test1/webhooks",
+		Header: map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body: assert.JSONObject{"webhook": assert.JSONObject{
+			"match_repository": "team-a/.*",
+			"url":              "https://hooks.example.com/team-a",
+			"events":           []string{"push"},
+		}},
+		ExpectStatus: http.StatusCreated,
+	}.Check(t, h)
+	expectSecretInResponse(t, s, respBody, 2, expectedRepoWebhook)
+	s.Auditor.IgnoreEventsUntilNow()
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"webhooks": []assert.JSONObject{expectedAccountWebhook, expectedRepoWebhook}},
+	}.Check(t, h)
+
+	// users who cannot change the account do not see account-level webhooks...
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,push:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"webhooks": []assert.JSONObject{}},
+	}.Check(t, h)
+	// ...and cannot delete them
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/webhooks/2",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,push:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such webhook\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/webhooks/2",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/webhooks/1",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	s.Auditor.IgnoreEventsUntilNow()
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/webhooks/1",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such webhook\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"webhooks": []assert.JSONObject{}},
+	}.Check(t, h)
+}
+
+func TestNamespaceWebhooksAPI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	mustInsert(t, s.DB, &models.RepositoryNamespace{
+		AccountName:          "test1",
+		Prefix:               "team-b",
+		AdminUserNamePattern: "teamb-.*",
+	})
+	mustInsert(t, s.DB, &models.Webhook{
+		AccountName: "test1",
+		URL:         "https://hooks.example.com/account",
+		EventTypes:  "push",
+		CreatedAt:   s.Clock.Now(),
+	})
+
+	// the namespace admin does not have permission to change the account, only
+	// to view it and to push into the repositories of their namespace
+	s.AD.ExpectedUserName = "teamb-alice"
+	namespaceAdminHeaders := map[string]string{"X-Test-Perms": "view:tenant1,push:tenant1"}
+
+	// namespace admins can create webhooks within their namespace
+	expectedNamespaceWebhook := assert.JSONObject{
+		"id":               2,
+		"namespace":        "team-b",
+		"match_repository": "app-.*",
+		"url":              "https://hooks.example.com/team-b",
+		"events":           []string{"push", "vulnerability_scan"},
+		"created_at":       s.Clock.Now().Unix(),
+		"created_by":       "teamb-alice",
+	}
+	_, respBody := assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/accounts/test1/webhooks",
+		Header: namespaceAdminHeaders,
+		Body: assert.JSONObject{"webhook": assert.JSONObject{
+			"namespace":        "team-b",
+			"match_repository": "app-.*",
+			"url":              "https://hooks.example.com/team-b",
+			"events":           []string{"push", "vulnerability_scan"},
+		}},
+		ExpectStatus: http.StatusCreated,
+	}.Check(t, h)
+	expectSecretInResponse(t, s, respBody, 2, expectedNamespaceWebhook)
+
+	// events concerning the whole account are not available within namespaces
+	assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/accounts/test1/webhooks",
+		Header: namespaceAdminHeaders,
+		Body: assert.JSONObject{"webhook": assert.JSONObject{
+			"namespace": "team-b",
+			"url":       "https://hooks.example.com/team-b",
+			"events":    []string{"storage_quota_exceeded"},
+		}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("webhooks in namespaces cannot subscribe to \"storage_quota_exceeded\" events\n"),
+	}.Check(t, h)
+
+	// namespace admins cannot create webhooks outside of their namespace
+	for _, namespace := range []string{"", "team-c"} {
+		assert.HTTPRequest{
+			Method: "POST",
+			Path:   "/keppel/v1/accounts/test1/webhooks",
+			Header: namespaceAdminHeaders,
+			Body: assert.JSONObject{"webhook": assert.JSONObject{
+				"namespace": namespace,
+				"url":       "https://hooks.example.com/team-b",
+				"events":    []string{"push"},
+			}},
+			ExpectStatus: http.StatusForbidden,
+			ExpectBody:   assert.StringData("Forbidden\n"),
+		}.Check(t, h)
+	}
+	// account admins can only create webhooks in namespaces that exist
+	assert.HTTPRequest{
+		Method: "POST",
+		Path:   "/keppel/v1/accounts/test1/webhooks",
+		Header: map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		Body: assert.JSONObject{"webhook": assert.JSONObject{
+			"namespace": "team-c",
+			"url":       "https://hooks.example.com/team-c",
+			"events":    []string{"push"},
+		}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("no such namespace\n"),
+	}.Check(t, h)
+
+	// namespace admins only see the webhooks of their namespace, whereas account
+	// admins see all webhooks
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       namespaceAdminHeaders,
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"webhooks": []assert.JSONObject{expectedNamespaceWebhook}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"webhooks": []assert.JSONObject{
+			{
+				"id":         1,
+				"url":        "https://hooks.example.com/account",
+				"events":     []string{"push"},
+				"created_at": s.Clock.Now().Unix(),
+			},
+			expectedNamespaceWebhook,
+		}},
+	}.Check(t, h)
+
+	// other users with the same permissions see nothing and cannot delete anything
+	s.AD.ExpectedUserName = "teamc-bob"
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       namespaceAdminHeaders,
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"webhooks": []assert.JSONObject{}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/webhooks/2",
+		Header:       namespaceAdminHeaders,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such webhook\n"),
+	}.Check(t, h)
+
+	// namespace admins can delete the webhooks of their namespace, but not others
+	s.AD.ExpectedUserName = "teamb-alice"
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/webhooks/1",
+		Header:       namespaceAdminHeaders,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such webhook\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/webhooks/2",
+		Header:       namespaceAdminHeaders,
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/webhooks",
+		Header:       namespaceAdminHeaders,
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"webhooks": []assert.JSONObject{}},
+	}.Check(t, h)
+}
+
+// The secret of a webhook is random, and only shown in the response to its creation.
+func expectSecretInResponse(t *testing.T, s test.Setup, respBody []byte, id int64, expected assert.JSONObject) {
+	t.Helper()
+	var resp struct {
+		Webhook map[string]any `json:"webhook"`
+	}
+	err := json.Unmarshal(respBody, &resp)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	secret, err := s.DB.SelectStr(`SELECT secret FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(secret) != 64 {
+		t.Errorf("expected a secret of 64 hex digits, but got %q", secret)
+	}
+	assert.DeepEqual(t, "secret", resp.Webhook["secret"], any(secret))
+	delete(resp.Webhook, "secret")
+
+	var expectedDecoded map[string]any
+	err = json.Unmarshal([]byte(test.ToJSON(expected)), &expectedDecoded)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "webhook", resp.Webhook, expectedDecoded)
+}
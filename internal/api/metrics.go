@@ -64,6 +64,14 @@ var (
 		},
 		[]string{"account", "auth_tenant_id", "method"},
 	)
+	// ConcurrencyLimitRejectionsCounter is a prometheus.CounterVec.
+	ConcurrencyLimitRejectionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_concurrency_limit_rejections",
+			Help: "Counts requests that were rejected because too many requests for the same account were in flight.",
+		},
+		[]string{"account", "auth_tenant_id"},
+	)
 )
 
 func init() {
@@ -74,4 +82,5 @@ func init() {
 	prometheus.MustRegister(ManifestsPulledCounter)
 	prometheus.MustRegister(ManifestsPushedCounter)
 	prometheus.MustRegister(UploadsAbortedCounter)
+	prometheus.MustRegister(ConcurrencyLimitRejectionsCounter)
 }
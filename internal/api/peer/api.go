@@ -34,6 +34,7 @@ func (a *API) AddTo(r *mux.Router) {
 	// Registry V2 API.
 	r.Methods("GET").Path("/peer/v1/delegatedpull/{hostname}/v2/{repo:.+}/manifests/{reference}").HandlerFunc(a.handleDelegatedPullManifest)
 	r.Methods("POST").Path("/peer/v1/sync-replica/{account}/{repo:.+}").HandlerFunc(a.handleSyncReplica)
+	r.Methods("GET").Path("/peer/v1/trivy-report/{account}/{repo:.+}/manifests/{digest}").HandlerFunc(a.handleGetTrivyReport)
 }
 
 func (a *API) authenticateRequest(w http.ResponseWriter, r *http.Request) *models.Peer {
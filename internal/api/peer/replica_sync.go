@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"github.com/opencontainers/go-digest"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/respondwith"
@@ -20,6 +21,21 @@ import (
 	"github.com/sapcc/keppel/internal/models"
 )
 
+var (
+	replicatedManifestsSelectQuery = sqlext.SimplifyWhitespace(`
+		SELECT digest FROM replicated_manifests WHERE repo_id = $1 AND peer_hostname = $2
+	`)
+	replicatedManifestsDeleteQuery = sqlext.SimplifyWhitespace(`
+		DELETE FROM replicated_manifests
+		 WHERE repo_id = $1 AND peer_hostname = $2 AND digest = ANY($3)
+	`)
+	replicatedManifestsInsertQuery = sqlext.SimplifyWhitespace(`
+		INSERT INTO replicated_manifests (repo_id, digest, peer_hostname)
+		SELECT $1, UNNEST($3::TEXT[]), $2
+		    ON CONFLICT DO NOTHING
+	`)
+)
+
 // Implementation for the POST /peer/v1/sync-replica/:account/:repo endpoint.
 func (a *API) handleSyncReplica(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/peer/v1/sync-replica/:account/:repo")
@@ -33,6 +49,10 @@ func (a *API) handleSyncReplica(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 	err := decoder.Decode(&req)
+	if rerr := keppel.AsRequestBodyError(err); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
 	if err != nil {
 		http.Error(w, "request body is not valid JSON: "+err.Error(), http.StatusBadRequest)
 		return
@@ -115,6 +135,14 @@ func (a *API) handleSyncReplica(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// remember which manifests the replica has, so that the deletion impact
+	// analysis can report replica copies of a manifest (since this runs on
+	// every sync, only the differences to the previous sync are written)
+	err = a.updateReplicatedManifests(*repo, peer.HostName, req.Manifests)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
 	// gather the data for our side of the bargain
 	tagsByDigest := make(map[digest.Digest][]keppel.TagForSync)
 	query = `SELECT name, digest FROM tags WHERE repo_id = $1`
@@ -154,3 +182,45 @@ func (a *API) handleSyncReplica(w http.ResponseWriter, r *http.Request) {
 
 	respondwith.JSON(w, http.StatusOK, keppel.ReplicaSyncPayload{Manifests: manifests})
 }
+
+func (a *API) updateReplicatedManifests(repo models.Repository, peerHostName string, manifests []keppel.ManifestForSync) error {
+	var knownDigests []string
+	_, err := a.db.Select(&knownDigests, replicatedManifestsSelectQuery, repo.ID, peerHostName)
+	if err != nil {
+		return err
+	}
+
+	isKnown := make(map[string]bool, len(knownDigests))
+	for _, d := range knownDigests {
+		isKnown[d] = true
+	}
+	isReplicated := make(map[string]bool, len(manifests))
+	var addedDigests []string
+	for _, m := range manifests {
+		d := m.Digest.String()
+		if !isKnown[d] && !isReplicated[d] {
+			addedDigests = append(addedDigests, d)
+		}
+		isReplicated[d] = true
+	}
+	var removedDigests []string
+	for _, d := range knownDigests {
+		if !isReplicated[d] {
+			removedDigests = append(removedDigests, d)
+		}
+	}
+
+	if len(removedDigests) > 0 {
+		_, err = a.db.Exec(replicatedManifestsDeleteQuery, repo.ID, peerHostName, pq.Array(removedDigests))
+		if err != nil {
+			return err
+		}
+	}
+	if len(addedDigests) > 0 {
+		_, err = a.db.Exec(replicatedManifestsInsertQuery, repo.ID, peerHostName, pq.Array(addedDigests))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
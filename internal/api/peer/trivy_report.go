@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package peerv1
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// Implementation for the GET /peer/v1/trivy-report/:account/:repo/manifests/:digest endpoint.
+func (a *API) handleGetTrivyReport(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/peer/v1/trivy-report/:account/:repo/manifests/:digest")
+	peer := a.authenticateRequest(w, r)
+	if peer == nil {
+		return
+	}
+
+	// find account, repository and manifest
+	accountName := models.AccountName(mux.Vars(r)["account"])
+	account, err := keppel.FindAccount(a.db, accountName)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if account == nil {
+		http.Error(w, "account not found", http.StatusNotFound)
+		return
+	}
+	repo, err := keppel.FindRepository(a.db, mux.Vars(r)["repo"], accountName)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "repo not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	manifestDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "manifest not found", http.StatusNotFound)
+		return
+	}
+	manifest, err := keppel.FindManifest(a.db, *repo, manifestDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "manifest not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	// we only hand out reports for manifests that we have scanned ourselves
+	// (see the similar check in the Keppel API's trivy_report endpoint)
+	securityInfo, err := keppel.GetSecurityInfo(a.db, repo.ID, manifest.Digest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no vulnerability report found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	blobCount, err := a.db.SelectInt(
+		`SELECT COUNT(*) FROM manifest_blob_refs WHERE repo_id = $1 AND digest = $2`,
+		repo.ID, manifest.Digest,
+	)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if a.cfg.Trivy == nil || !securityInfo.VulnerabilityStatus.HasReport() || securityInfo.CheckedAt == nil || blobCount == 0 {
+		http.Error(w, "no vulnerability report found", http.StatusNotFound)
+		return
+	}
+
+	// the janitor stores the report from its last scan, so we only need to ask
+	// Trivy if that report is missing (e.g. because the last scan happened
+	// before reports were stored)
+	storedReport, err := keppel.GetTrivyReport(a.db, repo.ID, manifest.Digest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if storedReport != nil {
+		respondwith.JSON(w, http.StatusOK, keppel.TrivyReportForSync{
+			CheckedAt: securityInfo.CheckedAt.Unix(),
+			Report:    storedReport,
+		})
+		return
+	}
+
+	imageRef := models.ImageReference{
+		Host:      a.cfg.APIPublicHostname,
+		RepoName:  repo.FullName(),
+		Reference: models.ManifestReference{Digest: manifest.Digest},
+	}
+	scanTimeout, _ := a.cfg.Trivy.EffectiveScanTimeouts()
+	tokenResp, err := auth.IssueTokenForTrivy(a.cfg, repo.FullName(), scanTimeout)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	report, err := a.cfg.Trivy.ScanManifest(r.Context(), tokenResp.Token, imageRef, "json", scanTimeout)
+	if err != nil {
+		respondwith.ErrorText(w, fmt.Errorf("cannot obtain vulnerability report: %w", err))
+		return
+	}
+
+	respondwith.JSON(w, http.StatusOK, keppel.TrivyReportForSync{
+		CheckedAt: securityInfo.CheckedAt.Unix(),
+		Report:    report.Contents,
+	})
+}
@@ -4,19 +4,23 @@
 package registryv2
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/audittools"
 	"github.com/sapcc/go-bits/errext"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/respondwith"
 
+	"github.com/sapcc/keppel/internal/api"
 	"github.com/sapcc/keppel/internal/auth"
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
@@ -30,17 +34,24 @@ type API struct {
 	fd      keppel.FederationDriver
 	sd      keppel.StorageDriver
 	icd     keppel.InboundCacheDriver
+	secd    keppel.SecretsDriver
+	cdnd    keppel.CDNDriver            // may be nil
+	nvd     keppel.NameValidationDriver // may be nil
 	db      *keppel.DB
 	auditor audittools.Auditor
-	rle     *keppel.RateLimitEngine // may be nil
+	rle     *keppel.RateLimitEngine         // may be nil
+	acl     *keppel.ConcurrencyLimiter      // may be nil
+	dn      *keppel.DistributionNotifier    // may be nil
+	par     *keppel.PullAttestationRecorder // may be nil
+	rpur    *keppel.RBACPolicyUsageRecorder // may be nil
 	// non-pure functions that can be replaced by deterministic doubles for unit tests
 	timeNow           func() time.Time
 	generateStorageID func() string
 }
 
 // NewAPI constructs a new API instance.
-func NewAPI(cfg keppel.Configuration, ad keppel.AuthDriver, fd keppel.FederationDriver, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, db *keppel.DB, auditor audittools.Auditor, rle *keppel.RateLimitEngine) *API {
-	return &API{cfg, ad, fd, sd, icd, db, auditor, rle, time.Now, keppel.GenerateStorageID}
+func NewAPI(cfg keppel.Configuration, ad keppel.AuthDriver, fd keppel.FederationDriver, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, secd keppel.SecretsDriver, cdnd keppel.CDNDriver, nvd keppel.NameValidationDriver, db *keppel.DB, auditor audittools.Auditor, rle *keppel.RateLimitEngine, acl *keppel.ConcurrencyLimiter, dn *keppel.DistributionNotifier, par *keppel.PullAttestationRecorder, rpur *keppel.RBACPolicyUsageRecorder) *API {
+	return &API{cfg, ad, fd, sd, icd, secd, cdnd, nvd, db, auditor, rle, acl, dn, par, rpur, time.Now, keppel.GenerateStorageID}
 }
 
 // OverrideTimeNow replaces time.Now with a test double.
@@ -66,44 +77,83 @@ func (a *API) AddTo(r *mux.Router) {
 	// checkAccountAccess().
 	r.Methods("DELETE").
 		Path("/v2/{repository:.+}/blobs/{digest}").
-		HandlerFunc(a.handleDeleteBlob)
+		HandlerFunc(a.limitConcurrency(a.handleDeleteBlob))
 	r.Methods("GET", "HEAD").
 		Path("/v2/{repository:.+}/blobs/{digest}").
-		HandlerFunc(a.handleGetOrHeadBlob)
+		HandlerFunc(a.limitConcurrency(a.handleGetOrHeadBlob))
 	r.Methods("POST").
 		Path("/v2/{repository:.+}/blobs/uploads/").
-		HandlerFunc(a.handleStartBlobUpload)
+		HandlerFunc(a.limitConcurrency(a.handleStartBlobUpload))
 	r.Methods("DELETE").
 		Path("/v2/{repository:.+}/blobs/uploads/{uuid}").
-		HandlerFunc(a.handleDeleteBlobUpload)
+		HandlerFunc(a.limitConcurrency(a.handleDeleteBlobUpload))
 	r.Methods("GET").
 		Path("/v2/{repository:.+}/blobs/uploads/{uuid}").
-		HandlerFunc(a.handleGetBlobUpload)
+		HandlerFunc(a.limitConcurrency(a.handleGetBlobUpload))
 	r.Methods("PATCH").
 		Path("/v2/{repository:.+}/blobs/uploads/{uuid}").
-		HandlerFunc(a.handleContinueBlobUpload)
+		HandlerFunc(a.limitConcurrency(a.handleContinueBlobUpload))
 	r.Methods("PUT").
 		Path("/v2/{repository:.+}/blobs/uploads/{uuid}").
-		HandlerFunc(a.handleFinishBlobUpload)
+		HandlerFunc(a.limitConcurrency(a.handleFinishBlobUpload))
 	r.Methods("DELETE").
 		Path("/v2/{repository:.+}/manifests/{reference}").
-		HandlerFunc(a.handleDeleteManifest)
+		HandlerFunc(a.limitConcurrency(a.handleDeleteManifest))
 	r.Methods("GET", "HEAD").
 		Path("/v2/{repository:.+}/manifests/{reference}").
-		HandlerFunc(a.handleGetOrHeadManifest)
+		HandlerFunc(a.limitConcurrency(a.handleGetOrHeadManifest))
 	r.Methods("PUT").
 		Path("/v2/{repository:.+}/manifests/{reference}").
-		HandlerFunc(a.handlePutManifest)
+		HandlerFunc(a.limitConcurrency(a.handlePutManifest))
 	r.Methods("GET").
 		Path("/v2/{repository:.+}/referrers/{reference}").
-		HandlerFunc(a.handleGetReferrers)
+		HandlerFunc(a.limitConcurrency(a.handleGetReferrers))
 	r.Methods("GET").
 		Path("/v2/{repository:.+}/tags/list").
-		HandlerFunc(a.handleListTags)
+		HandlerFunc(a.limitConcurrency(a.handleListTags))
+}
+
+// concurrencySlot is put into the request context by limitConcurrency(). It
+// holds the slot that checkAccountAccess() reserves in the ConcurrencyLimiter.
+type concurrencySlot struct {
+	release func()
+}
+
+type concurrencySlotContextKey struct{}
+
+// limitConcurrency wraps a handler for an endpoint that refers to a
+// repository, such that the concurrency slot reserved by checkAccountAccess()
+// is released when the handler returns.
+func (a *API) limitConcurrency(inner http.HandlerFunc) http.HandlerFunc {
+	if a.acl == nil {
+		return inner
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		slot := &concurrencySlot{}
+		defer func() {
+			if slot.release != nil {
+				slot.release()
+			}
+		}()
+		inner(w, r.WithContext(context.WithValue(r.Context(), concurrencySlotContextKey{}, slot)))
+	}
+}
+
+// takeConcurrencySlot hands the concurrency slot of this request over to the
+// caller, e.g. to work that continues after the request has ended. The slot
+// is then not released when the handler returns. Instead, the caller must
+// call the returned function once it is done.
+func takeConcurrencySlot(r *http.Request) (release func()) {
+	slot, ok := r.Context().Value(concurrencySlotContextKey{}).(*concurrencySlot)
+	if !ok || slot.release == nil {
+		return func() {}
+	}
+	release, slot.release = slot.release, nil
+	return release
 }
 
 func (a *API) processor() *processor.Processor {
-	return processor.New(a.cfg, a.db, a.sd, a.icd, a.auditor, a.fd, a.timeNow).OverrideTimeNow(a.timeNow).OverrideGenerateStorageID(a.generateStorageID)
+	return processor.New(a.cfg, a.db, a.sd, a.icd, a.secd, a.auditor, a.fd, a.nvd, a.timeNow).OverrideTimeNow(a.timeNow).OverrideGenerateStorageID(a.generateStorageID)
 }
 
 // This implements the GET /v2/ endpoint.
@@ -149,7 +199,7 @@ func respondWithError(w http.ResponseWriter, r *http.Request, err error) bool {
 		return true
 	}
 
-	keppel.ErrUnknown.With(err.Error()).WriteAsRegistryV2ResponseTo(w, r)
+	keppel.AsRegistryV2Error(err).WriteAsRegistryV2ResponseTo(w, r)
 	return true
 }
 
@@ -208,18 +258,26 @@ func (a *API) checkAccountAccess(w http.ResponseWriter, r *http.Request, strateg
 	case http.MethodGet, http.MethodHead:
 		scope.Actions = []string{"pull"}
 	default:
-		scope.Actions = []string{"pull", "push"}
+		// write operations only need "push" (Docker clients request "pull,push"
+		// anyway, but clients may also ask for tokens that can only push)
+		scope.Actions = []string{"push"}
 	}
 	authz, challenge, rerr := auth.IncomingRequest{
 		HTTPRequest:           r,
 		Scopes:                auth.NewScopeSet(scope),
 		AllowsAnycast:         anycastHandler != nil,
 		AllowsDomainRemapping: true,
+		RBACPolicyUsage:       a.rpur,
+		TimeNow:               a.timeNow,
 	}.Authorize(r.Context(), a.cfg, a.ad, a.db)
 	if rerr != nil {
 		rerr.WriteAsRegistryV2ResponseTo(w, r)
 		return nil, nil, nil, nil
 	}
+	if !isAllowedByDigestRestriction(r, *authz) {
+		keppel.ErrDenied.With("token is restricted to pulling specific digests").WithStatus(http.StatusForbidden).WriteAsRegistryV2ResponseTo(w, r)
+		return nil, nil, nil, nil
+	}
 
 	// we need to know the account to select the registry instance for this request
 	repoScope := scope.ParseRepositoryScope(authz.Audience)
@@ -258,6 +316,23 @@ func (a *API) checkAccountAccess(w http.ResponseWriter, r *http.Request, strateg
 		return nil, nil, nil, nil
 	}
 
+	// add custom response headers configured for this account
+	responseHeaders, err := keppel.ParseResponseHeaders(*account)
+	if respondWithError(w, r, err) {
+		return nil, nil, nil, nil
+	}
+	for name, value := range responseHeaders {
+		w.Header().Set(name, value)
+	}
+
+	// only now that we know the account, we can enforce its concurrency limit
+	if slot, ok := r.Context().Value(concurrencySlotContextKey{}).(*concurrencySlot); ok && slot.release == nil {
+		slot.release, err = api.AcquireConcurrencySlot(a.acl, *account, authz)
+		if respondWithError(w, r, err) {
+			return nil, nil, nil, nil
+		}
+	}
+
 	canCreateRepoIfMissing := false
 	canFirstPull := false
 	switch strategy {
@@ -273,10 +348,14 @@ func (a *API) checkAccountAccess(w http.ResponseWriter, r *http.Request, strateg
 	}
 
 	var repo *models.Repository
-	if canCreateRepoIfMissing {
+	repo, err = keppel.FindRepository(a.db, repoScope.RepositoryName, account.Name)
+	if errors.Is(err, sql.ErrNoRows) && canCreateRepoIfMissing {
+		rerr := keppel.ValidateNewRepositoryName(r.Context(), a.cfg, a.nvd, *account, repoScope.RepositoryName)
+		if rerr != nil {
+			rerr.WriteAsRegistryV2ResponseTo(w, r)
+			return nil, nil, nil, nil
+		}
 		repo, err = keppel.FindOrCreateRepository(a.db, repoScope.RepositoryName, account.Name)
-	} else {
-		repo, err = keppel.FindRepository(a.db, repoScope.RepositoryName, account.Name)
 	}
 	if errors.Is(err, sql.ErrNoRows) || repo == nil {
 		if canFirstPull {
@@ -292,6 +371,59 @@ func (a *API) checkAccountAccess(w http.ResponseWriter, r *http.Request, strateg
 	return account, repo, authz, challenge
 }
 
+// Writes an error response and returns false if the account has terms of use
+// that the requesting user has not accepted yet.
+func (a *API) checkPullTermsAccepted(w http.ResponseWriter, r *http.Request, account models.ReducedAccount, authz *auth.Authorization) bool {
+	if link := keppel.PullTermsLinkHeader(account); link != "" {
+		w.Header().Add("Link", link)
+	}
+	if !keppel.PullTermsRequiredFor(authz.UserIdentity) {
+		return true
+	}
+	accepted, err := keppel.HasAcceptedPullTerms(a.db, account, authz.UserIdentity.UserName())
+	if respondWithError(w, r, err) {
+		return false
+	}
+	if !accepted {
+		msg := fmt.Sprintf("the terms of use of account %q (version %s, see %s) must be accepted before pulling; accept them via POST /keppel/v1/accounts/%s/pull_terms/acceptance",
+			account.Name, account.PullTermsVersion, account.PullTermsURL, account.Name)
+		keppel.ErrDenied.With(msg).WithStatus(http.StatusForbidden).WriteAsRegistryV2ResponseTo(w, r)
+		return false
+	}
+	return true
+}
+
+// Narrowed tokens (see POST /keppel/v1/auth/narrow) may only be used to pull
+// the specific blobs and manifests that they were issued for.
+func isAllowedByDigestRestriction(r *http.Request, authz auth.Authorization) bool {
+	if authz.DigestRestriction == nil {
+		return true
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	pathTemplate, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+
+	var reference string
+	switch {
+	case strings.HasSuffix(pathTemplate, "/blobs/{digest}"):
+		reference = mux.Vars(r)["digest"]
+	case strings.HasSuffix(pathTemplate, "/manifests/{reference}"):
+		reference = mux.Vars(r)["reference"]
+	default:
+		return false
+	}
+	parsedDigest, err := digest.Parse(reference)
+	return err == nil && authz.AllowsDigest(parsedDigest)
+}
+
 // Returns the repository name as it appears in URL paths for this API.
 func getRepoNameForURLPath(repo models.Repository, authz *auth.Authorization) string {
 	// on the regular API, the URL path includes the account name
@@ -6,18 +6,24 @@ package registryv2
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/opencontainers/go-digest"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
 
 	"github.com/sapcc/keppel/internal/api"
 	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
 	"github.com/sapcc/keppel/internal/processor"
 )
 
@@ -26,6 +32,14 @@ var isImageConfigBlobMediaType = map[string]bool{
 	"application/vnd.oci.image.config.v1+json":       true,
 }
 
+var isBlobVerifiedQuery = sqlext.SimplifyWhitespace(`
+	SELECT EXISTS(
+		SELECT 1 FROM manifest_blob_refs r
+		  JOIN manifests m ON m.repo_id = r.repo_id AND m.digest = r.digest
+		 WHERE r.repo_id = $1 AND r.blob_id = $2 AND m.verified_at IS NOT NULL
+	)
+`)
+
 // This implements the GET/HEAD /v2/<account>/<repository>/blobs/<digest> endpoint.
 func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/v2/:account/:repo/blobs/:digest")
@@ -33,6 +47,9 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 	if account == nil {
 		return
 	}
+	if !a.checkPullTermsAccepted(w, r, *account, authz) {
+		return
+	}
 
 	err := api.CheckRateLimit(r, a.rle, *account, authz, keppel.BlobPullAction, 1)
 	if respondWithError(w, r, err) {
@@ -55,6 +72,42 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// if pull access was only granted by RBAC policies with "match_keppel_labels",
+	// only blobs belonging to manifests with these labels can be pulled
+	if restrictions, isRestricted := authz.KeppelLabelRestrictions[repo.FullName()]; isRestricted {
+		isAllowed, err := keppel.IsBlobAllowedByKeppelLabels(a.db, *repo, *blob, restrictions)
+		if respondWithError(w, r, err) {
+			return
+		}
+		if !isAllowed {
+			keppel.ErrDenied.With("blob %s does not belong to a manifest with the Keppel labels required for pulling it", blob.Digest).WithStatus(http.StatusForbidden).WriteAsRegistryV2ResponseTo(w, r)
+			return
+		}
+	}
+
+	err = a.checkBlobNotQuarantined(*repo, *blob, authz)
+	if respondWithError(w, r, err) {
+		return
+	}
+	err = a.checkBlobPromotionState(*account, *repo, *blob, authz)
+	if respondWithError(w, r, err) {
+		return
+	}
+
+	// in accounts replicating from untrusted upstreams, only blobs belonging to
+	// a manifest with a verified digest chain may be served (see tasks.ManifestVerificationJob)
+	if account.ExternalPeerVerifyOnly {
+		isVerified, err := a.db.SelectBool(isBlobVerifiedQuery, repo.ID, blob.ID)
+		if respondWithError(w, r, err) {
+			return
+		}
+		if !isVerified {
+			msg := "blob has not been verified yet; pull a manifest referencing it and wait for its verification"
+			keppel.ErrBlobUnknown.With(msg).WriteAsRegistryV2ResponseTo(w, r)
+			return
+		}
+	}
+
 	// if this blob has not been replicated...
 	if blob.StorageID == "" {
 		if account.UpstreamPeerHostName == "" && account.ExternalPeerURL == "" {
@@ -63,16 +116,44 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// ...refuse to replicate on behalf of other Keppels (they only ask us
+		// because we are a nearer replication source than their upstream, so
+		// they need to see the true 404 and try their next source; otherwise a
+		// replication could bounce between peers indefinitely)...
+		if authz.UserIdentity.UserType() == keppel.PeerUser {
+			keppel.ErrBlobUnknown.With("blob has not been replicated into this repository yet").WriteAsRegistryV2ResponseTo(w, r)
+			return
+		}
+
 		// ...answer HEAD requests with the metadata that we obtained when replicating the manifest...
 		if r.Method == http.MethodHead {
 			w.Header().Set("Content-Length", strconv.FormatUint(blob.SizeBytes, 10))
 			w.Header().Set("Content-Type", blob.SafeMediaType())
 			w.Header().Set("Docker-Content-Digest", blob.Digest.String())
+			w.Header().Set("Cache-Control", a.cfg.CachePolicy.CacheControlHeader(true))
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
-		// ...and answer GET requests by replicating the blob contents
+		// ...and answer GET requests by replicating the blob contents (if the
+		// account prefers it, the replication happens in the background and the
+		// client is told to come back later instead of waiting for it)
+		//
+		// Either way, the client can follow the replication progress through the
+		// Keppel API, which finds the replication by this ID.
+		w.Header().Set("X-Keppel-Replication-ID", blob.Digest.String())
+		if account.ReplicationRetryHints && blob.SizeBytes >= backgroundReplicationMinBytes {
+			// the background replication keeps the concurrency slot of this request
+			// until it is done, so that background replications count against the
+			// account's concurrency limit instead of piling up without bound
+			err := a.processor().ReplicateBlobInBackground(r.Context(), *blob, *account, *repo, takeConcurrencySlot(r))
+			if err == nil || errors.Is(err, processor.ErrConcurrentReplication) {
+				a.respondWithReplicationInProgress(w, r, *account, *blob)
+			} else {
+				respondWithError(w, r, err)
+			}
+			return
+		}
 		responseWasWritten, err := a.processor().ReplicateBlob(r.Context(), *blob, *account, *repo, w)
 
 		if err != nil {
@@ -82,12 +163,7 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 				logg.Error("while trying to replicate blob %s in %s/%s: %s",
 					blob.Digest, account.Name, repo.Name, err.Error())
 			case errors.Is(err, processor.ErrConcurrentReplication):
-				// special handling for GET during ongoing replication (429 Too Many
-				// Requests is not a perfect match, but it's my best guess for getting
-				// clients to automatically retry the request after a few seconds)
-				w.Header().Set("Retry-After", "10")
-				msg := "currently replicating on a different worker, please retry in a few seconds"
-				keppel.ErrTooManyRequests.With(msg).WriteAsRegistryV2ResponseTo(w, r)
+				a.respondWithReplicationInProgress(w, r, *account, *blob)
 			default:
 				respondWithError(w, r, err)
 			}
@@ -98,13 +174,31 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// GET requests may ask for a single byte range of the blob (e.g. lazy-pulling
+	// snapshotters only fetch the parts of a layer that they actually need)
+	var requestedRange *byteRange
+	bytesToSend := blob.SizeBytes
+	if r.Method == http.MethodGet {
+		requestedRange, err = parseRangeHeader(r.Header.Get("Range"), blob.SizeBytes)
+		if err != nil {
+			keppel.ErrSizeInvalid.With(err.Error()).
+				WithStatus(http.StatusRequestedRangeNotSatisfiable).
+				WithHeader("Content-Range", fmt.Sprintf("bytes */%d", blob.SizeBytes)).
+				WriteAsRegistryV2ResponseTo(w, r)
+			return
+		}
+		if requestedRange != nil {
+			bytesToSend = requestedRange.Length
+		}
+	}
+
 	// if a peer reverse-proxied to us to fulfill an anycast request, enforce the anycast rate limits
 	isAnycast := r.Header.Get("X-Keppel-Forwarded-By") != ""
 	if isAnycast {
 		// AnycastBlobBytePullAction is only relevant for GET requests since it
 		// limits the size of the response body (which is empty for HEAD)
 		if r.Method == http.MethodGet {
-			err = api.CheckRateLimit(r, a.rle, *account, authz, keppel.AnycastBlobBytePullAction, blob.SizeBytes)
+			err = api.CheckRateLimit(r, a.rle, *account, authz, keppel.AnycastBlobBytePullAction, bytesToSend)
 			if respondWithError(w, r, err) {
 				return
 			}
@@ -119,8 +213,23 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 		} else if isAnycast {
 			l["method"] = "registry-api+anycast"
 		}
-		api.BlobsPulledCounter.With(l).Inc()
-		api.BlobBytesPulledCounter.With(l).Add(float64(blob.SizeBytes))
+		api.BlobBytesPulledCounter.With(l).Add(float64(bytesToSend))
+
+		// lazy-pulling clients send lots of range requests for the same blob,
+		// so only the request for the start of the blob counts as a pull
+		if requestedRange == nil || requestedRange.Offset == 0 {
+			api.BlobsPulledCounter.With(l).Inc()
+			a.dn.Notify(r, "pull", blobEventTarget(*blob, *repo), authz.UserIdentity)
+		}
+
+		// Trivy only pulls the layers that it has not analyzed yet, so this tells
+		// the janitor how far a scan got when it times out
+		if authz.UserIdentity.UserType() == keppel.TrivyUser {
+			_, err := a.db.Exec(`UPDATE blobs SET trivy_fetched_at = $1 WHERE id = $2`, a.timeNow(), blob.ID)
+			if err != nil {
+				logg.Error("could not update trivy_fetched_at timestamp on blob %s in %s: %s", blob.Digest, account.Name, err.Error())
+			}
+		}
 	}
 
 	// prefer redirecting the client to a storage URL if the storage driver can give us one
@@ -131,29 +240,61 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 	// CORS happens correctly. This is important for web UIs reading image config
 	// blobs in order to render informational UIs.
 	if !isImageConfigBlobMediaType[blob.MediaType] {
-		url, err := a.sd.URLForBlob(r.Context(), *account, blob.StorageID)
-		if err == nil {
+		// if the account is served via CDN, redirect there instead of to the storage
+		if a.cdnd != nil && account.ServeBlobsViaCDN {
+			url, err := a.cdnd.URLForBlob(r.Context(), *account, *blob)
+			if respondWithError(w, r, err) {
+				return
+			}
 			w.Header().Set("Docker-Content-Digest", blob.Digest.String())
 			w.Header().Set("Location", url)
 			w.WriteHeader(http.StatusTemporaryRedirect)
 			return
 		}
-		if !errors.Is(err, keppel.ErrCannotGenerateURL) {
-			respondWithError(w, r, err)
-			return
+
+		// if the storage driver can generate a URL for the blob, redirect there
+		// instead of streaming the blob through us (unless disabled by policy)
+		if a.cfg.BlobRedirectPolicy.AppliesTo(*blob) {
+			url, err := a.sd.URLForBlob(r.Context(), *account, blob.StorageID)
+			if err == nil {
+				w.Header().Set("Docker-Content-Digest", blob.Digest.String())
+				w.Header().Set("Location", url)
+				w.WriteHeader(http.StatusTemporaryRedirect)
+				return
+			}
+			if !errors.Is(err, keppel.ErrCannotGenerateURL) {
+				respondWithError(w, r, err)
+				return
+			}
 		}
 	}
 
-	// return the blob contents to the client directly (TODO: support range requests)
-	reader, lengthBytes, err := a.sd.ReadBlob(r.Context(), *account, blob.StorageID)
+	// return the blob contents to the client directly
+	var (
+		reader      io.ReadCloser
+		lengthBytes uint64
+	)
+	if requestedRange == nil {
+		reader, lengthBytes, err = a.sd.ReadBlob(r.Context(), *account, blob.StorageID)
+	} else {
+		reader, err = a.sd.ReadBlobRange(r.Context(), *account, blob.StorageID, requestedRange.Offset, requestedRange.Length)
+		lengthBytes = requestedRange.Length
+	}
 	if respondWithError(w, r, err) {
 		return
 	}
 	defer reader.Close()
+	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("Content-Length", strconv.FormatUint(lengthBytes, 10))
 	w.Header().Set("Content-Type", blob.SafeMediaType())
 	w.Header().Set("Docker-Content-Digest", blob.Digest.String())
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Cache-Control", a.cfg.CachePolicy.CacheControlHeader(true))
+	if requestedRange == nil {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.Header().Set("Content-Range", requestedRange.ContentRange(blob.SizeBytes))
+		w.WriteHeader(http.StatusPartialContent)
+	}
 	if r.Method != http.MethodHead {
 		// The use of io.LimitReader() here is a hint to io.Copy() to not allocate
 		// a buffer bigger than the expected size of the blob if the blob is small.
@@ -164,6 +305,110 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// byteRange is a single byte range that was requested by a Range header.
+type byteRange struct {
+	Offset uint64
+	Length uint64
+}
+
+// ContentRange renders the value for the Content-Range header of a 206 response.
+func (br byteRange) ContentRange(totalBytes uint64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.Offset, br.Offset+br.Length-1, totalBytes)
+}
+
+var byteRangeRx = regexp.MustCompile(`^bytes=([0-9]*)-([0-9]*)$`)
+
+// parseRangeHeader interprets the Range header of a blob GET request.
+//
+// Only single byte ranges are supported. If the header is missing or cannot be
+// interpreted (e.g. because it asks for multiple ranges), nil is returned and
+// the full blob shall be served, as allowed by RFC 9110, section 14.2. An error
+// is only returned if the range cannot be satisfied.
+func parseRangeHeader(header string, sizeBytes uint64) (*byteRange, error) {
+	match := byteRangeRx.FindStringSubmatch(strings.TrimSpace(header))
+	if match == nil || (match[1] == "" && match[2] == "") {
+		return nil, nil
+	}
+
+	// suffix range like "bytes=-500" (the last 500 bytes)
+	if match[1] == "" {
+		suffixLength, err := strconv.ParseUint(match[2], 10, 64)
+		if err != nil {
+			return nil, nil //nolint:nilerr // malformed ranges are ignored
+		}
+		if suffixLength == 0 || sizeBytes == 0 {
+			return nil, fmt.Errorf("cannot serve range %q for a blob of %d bytes", header, sizeBytes)
+		}
+		suffixLength = min(suffixLength, sizeBytes)
+		return &byteRange{Offset: sizeBytes - suffixLength, Length: suffixLength}, nil
+	}
+
+	// range like "bytes=100-199" or "bytes=100-" (everything starting from byte 100)
+	first, err := strconv.ParseUint(match[1], 10, 64)
+	if err != nil {
+		return nil, nil //nolint:nilerr // malformed ranges are ignored
+	}
+	last := sizeBytes - 1
+	if match[2] != "" {
+		last, err = strconv.ParseUint(match[2], 10, 64)
+		if err != nil || last < first {
+			return nil, nil //nolint:nilerr // malformed ranges are ignored
+		}
+	}
+	if first >= sizeBytes {
+		return nil, fmt.Errorf("cannot serve range %q for a blob of %d bytes", header, sizeBytes)
+	}
+	last = min(last, sizeBytes-1)
+	return &byteRange{Offset: first, Length: last - first + 1}, nil
+}
+
+// How long clients are asked to wait before retrying a GET on a blob that is
+// currently being replicated.
+const replicationRetryInterval = 10 * time.Second
+
+// Blobs smaller than this are always replicated while the client waits, even
+// if the account has ReplicationRetryHints, since a retry would take longer
+// than the replication itself.
+const backgroundReplicationMinBytes = 1 << 20 // 1 MiB
+
+// respondWithReplicationInProgress answers a GET request on a blob that is
+// currently being replicated. 429 Too Many Requests is not a perfect match for
+// this situation, but it's my best guess for getting clients to automatically
+// retry the request after a few seconds.
+func (a *API) respondWithReplicationInProgress(w http.ResponseWriter, r *http.Request, account models.ReducedAccount, blob models.Blob) {
+	pendingBlob, err := a.processor().GetBlobReplicationProgress(account.Name, blob.Digest)
+	if respondWithError(w, r, err) {
+		return
+	}
+
+	detail := keppel.RetryAfterDetail(keppel.ReasonReplicationInProgress, replicationRetryInterval)
+	detail.TotalBytes = &blob.SizeBytes
+	msg := "currently replicating from upstream, please retry in a few seconds"
+	if pendingBlob != nil {
+		detail.ReplicatedBytes = &pendingBlob.ReplicatedBytes
+		msg = fmt.Sprintf("currently replicating from upstream (%d of %d bytes done), please retry in a few seconds",
+			pendingBlob.ReplicatedBytes, blob.SizeBytes)
+	}
+	retryAfter := strconv.FormatInt(int64(replicationRetryInterval/time.Second), 10)
+	keppel.ErrTooManyRequests.With(msg).WithHeader("Retry-After", retryAfter).WithDetail(detail).WriteAsRegistryV2ResponseTo(w, r)
+}
+
+// respondWithVerificationPending answers a GET request on a manifest in an
+// account with ExternalPeerVerifyOnly whose digest chain has not been verified
+// yet. While the verification is still pending, clients are asked to retry
+// in the same way as for blobs that are currently being replicated.
+func respondWithVerificationPending(w http.ResponseWriter, r *http.Request, manifest models.Manifest) {
+	if manifest.VerificationErrorMessage != "" {
+		msg := "digest chain of manifest could not be verified: " + manifest.VerificationErrorMessage
+		keppel.ErrManifestUnverified.With(msg).WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+	detail := keppel.RetryAfterDetail(keppel.ReasonVerificationPending, replicationRetryInterval)
+	retryAfter := strconv.FormatInt(int64(replicationRetryInterval/time.Second), 10)
+	keppel.ErrTooManyRequests.With("digest chain of manifest has not been verified yet, please retry in a few seconds").
+		WithHeader("Retry-After", retryAfter).WithDetail(detail).WriteAsRegistryV2ResponseTo(w, r)
+}
+
 func (a *API) handleGetOrHeadBlobAnycast(w http.ResponseWriter, r *http.Request, info anycastRequestInfo) {
 	//NOTE: Rate limits are enforced by the peer that we reverse-proxy to, not by
 	// us. We couldn't enforce them anyway because we don't have this account.
@@ -177,7 +422,7 @@ func (a *API) handleGetOrHeadBlobAnycast(w http.ResponseWriter, r *http.Request,
 // This implements the DELETE /v2/<account>/<repository>/blobs/<digest> endpoint.
 func (a *API) handleDeleteBlob(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/v2/:account/:repo/blobs/:digest")
-	account, repo, _, _ := a.checkAccountAccess(w, r, failIfRepoMissing, nil)
+	account, repo, authz, _ := a.checkAccountAccess(w, r, failIfRepoMissing, nil)
 	if account == nil {
 		return
 	}
@@ -217,8 +462,22 @@ func (a *API) handleDeleteBlob(w http.ResponseWriter, r *http.Request) {
 	if respondWithError(w, r, err) {
 		return
 	}
+	a.dn.Notify(r, "delete", keppel.DistributionEventTarget{
+		Digest:     blob.Digest,
+		Repository: repo.FullName(),
+	}, authz.UserIdentity)
 
 	w.Header().Set("Content-Length", "0")
 	w.Header().Set("Docker-Content-Digest", blob.Digest.String())
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// blobEventTarget builds the target of a distribution notification event for a blob.
+func blobEventTarget(blob models.Blob, repo models.Repository) keppel.DistributionEventTarget {
+	return keppel.DistributionEventTarget{
+		MediaType:  "application/octet-stream",
+		Size:       blob.SizeBytes,
+		Digest:     blob.Digest,
+		Repository: repo.FullName(),
+	}
+}
@@ -11,8 +11,11 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/sapcc/go-bits/assert"
 
+	"github.com/sapcc/keppel/internal/api"
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 	"github.com/sapcc/keppel/internal/test"
@@ -144,6 +147,26 @@ func TestBlobMonolithicUpload(t *testing.T) {
 			expectBlobExists(t, h, token, "test1/foo", blob, nil)
 		}
 
+		// when the blob already exists in the repo, the request body is not even
+		// looked at (this is evident from the request succeeding even though the
+		// body does not match the digest)
+		assert.HTTPRequest{
+			Method: "POST",
+			Path:   "/v2/test1/foo/blobs/uploads/?digest=" + blob.Digest.String(),
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  "application/octet-stream",
+			},
+			Body:         assert.StringData("not the blob contents"),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Content-Length":      "0",
+				"Location":            "/v2/test1/foo/blobs/" + blob.Digest.String(),
+			},
+		}.Check(t, h)
+		expectBlobExists(t, h, token, "test1/foo", blob, nil)
+
 		// test GET via anycast
 		if currentlyWithAnycast {
 			testWithReplica(t, s, "on_first_use", func(firstPass bool, s2 test.Setup) {
@@ -237,7 +260,7 @@ func TestBlobStreamedAndChunkedUpload(t *testing.T) {
 				ExpectStatus: http.StatusUnauthorized,
 				ExpectHeader: map[string]string{
 					test.VersionHeaderKey: test.VersionHeaderValue,
-					"Www-Authenticate":    `Bearer realm="https://registry.example.org/keppel/v1/auth",service="registry.example.org",scope="repository:test1/foo:pull,push"`,
+					"Www-Authenticate":    `Bearer realm="https://registry.example.org/keppel/v1/auth",service="registry.example.org",scope="repository:test1/foo:push"`,
 				},
 			}.Check(t, h)
 
@@ -522,6 +545,87 @@ func TestBlobStreamedAndChunkedUpload(t *testing.T) {
 	}
 }
 
+func TestBlobUploadChunkSizeLimits(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+		blob := test.NewBytes([]byte("just some random data"))
+
+		_, err := keppel.FindOrCreateRepository(s.DB, "foo", models.AccountName("test1"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// without limits, no chunk size hints are advertised
+		resp, _ := assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/v2/test1/foo/blobs/uploads/",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusAccepted,
+		}.Check(t, h)
+		for _, key := range []string{"OCI-Chunk-Min-Length", "X-Keppel-Chunk-Max-Length"} {
+			if value := resp.Header.Get(key); value != "" {
+				t.Errorf("expected no %s header, but got %q", key, value)
+			}
+		}
+
+		// with limits from the storage driver, the hints are advertised
+		s.SD.ChunkLimits = keppel.ChunkSizeLimits{MinBytes: 5, MaxBytes: 10}
+		defer func() { s.SD.ChunkLimits = keppel.ChunkSizeLimits{} }()
+		resp, _ = assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/v2/test1/foo/blobs/uploads/",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusAccepted,
+			ExpectHeader: map[string]string{
+				"OCI-Chunk-Min-Length":      "5",
+				"X-Keppel-Chunk-Max-Length": "10",
+			},
+		}.Check(t, h)
+
+		// chunks above the maximum are rejected, and abort the upload
+		uploadURL := resp.Header.Get("Location")
+		assert.HTTPRequest{
+			Method: "PATCH",
+			Path:   uploadURL,
+			Header: map[string]string{
+				"Authorization":  "Bearer " + token,
+				"Content-Length": strconv.Itoa(len(blob.Contents)),
+				"Content-Range":  fmt.Sprintf("0-%d", len(blob.Contents)-1),
+				"Content-Type":   "application/octet-stream",
+			},
+			Body:         assert.ByteData(blob.Contents),
+			ExpectStatus: http.StatusRequestEntityTooLarge,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrSizeInvalid),
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         uploadURL,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusNotFound,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrBlobUploadUnknown),
+		}.Check(t, h)
+
+		// chunks within the limits work as usual
+		uploadURL = getBlobUploadURL(t, h, token, "test1/foo")
+		assert.HTTPRequest{
+			Method: "PATCH",
+			Path:   uploadURL,
+			Header: map[string]string{
+				"Authorization":  "Bearer " + token,
+				"Content-Length": "10",
+				"Content-Range":  "0-9",
+				"Content-Type":   "application/octet-stream",
+			},
+			Body:         assert.ByteData(blob.Contents[0:10]),
+			ExpectStatus: http.StatusAccepted,
+			ExpectHeader: map[string]string{"Range": "0-9"},
+		}.Check(t, h)
+	})
+}
+
 func TestGetBlobUpload(t *testing.T) {
 	testWithPrimary(t, nil, func(s test.Setup) {
 		h := s.Handler
@@ -739,12 +843,42 @@ func TestDeleteBlobUpload(t *testing.T) {
 	})
 }
 
+func TestPushOnlyToken(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		pushOnlyToken := s.GetToken(t, "repository:test1/foo:push")
+		blob := test.NewBytes([]byte("just some random data"))
+
+		// a token with only "push" is enough to upload blobs...
+		assert.HTTPRequest{
+			Method: "POST",
+			Path:   "/v2/test1/foo/blobs/uploads/?digest=" + blob.Digest.String(),
+			Header: map[string]string{
+				"Authorization":  "Bearer " + pushOnlyToken,
+				"Content-Length": strconv.Itoa(len(blob.Contents)),
+				"Content-Type":   "application/octet-stream",
+			},
+			Body:         assert.ByteData(blob.Contents),
+			ExpectStatus: http.StatusCreated,
+		}.Check(t, h)
+
+		// ...but not to pull them again
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/blobs/" + blob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + pushOnlyToken},
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectBody:   test.ErrorCode(keppel.ErrDenied),
+		}.Check(t, h)
+	})
+}
+
 func TestDeleteBlob(t *testing.T) {
 	testWithPrimary(t, nil, func(s test.Setup) {
 		h := s.Handler
 		token := s.GetToken(t, "repository:test1/foo:pull,push")
 		deleteToken := s.GetToken(t, "repository:test1/foo:delete")
-		otherRepoToken := s.GetToken(t, "repository:test1/bar:pull,push")
+		otherRepoToken := s.GetToken(t, "repository:test1/bar:pull,push", "repository:test1/foo:pull")
 
 		blob := test.NewBytes([]byte("just some random data"))
 
@@ -839,7 +973,8 @@ func TestCrossRepositoryBlobMount(t *testing.T) {
 	testWithPrimary(t, nil, func(s test.Setup) {
 		h := s.Handler
 		readOnlyToken := s.GetToken(t, "repository:test1/foo:pull")
-		token := s.GetToken(t, "repository:test1/foo:pull,push")
+		token := s.GetToken(t, "repository:test1/foo:pull,push", "repository:test1/bar:pull", "repository:test1/qux:pull")
+		targetOnlyToken := s.GetToken(t, "repository:test1/foo:pull,push")
 		otherRepoToken := s.GetToken(t, "repository:test1/bar:pull,push")
 
 		blob := test.NewBytes([]byte("just some random data"))
@@ -847,6 +982,27 @@ func TestCrossRepositoryBlobMount(t *testing.T) {
 		// upload a blob to test1/bar so that we can test mounting it to test1/foo
 		blob.MustUpload(t, s, barRepoRef)
 
+		// without pull access to the source repo, the mount is not performed and
+		// a regular upload is started instead
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/v2/test1/foo/blobs/uploads/?from=test1/bar&mount=" + blob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + targetOnlyToken},
+			ExpectStatus: http.StatusAccepted,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Content-Length":      "0",
+				"Range":               "0-0",
+			},
+		}.Check(t, h)
+		// this also does not reveal whether the source repo exists
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/v2/test1/foo/blobs/uploads/?from=test1/qux&mount=" + blob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + targetOnlyToken},
+			ExpectStatus: http.StatusAccepted,
+		}.Check(t, h)
+
 		// test failure cases: token does not have push access
 		assert.HTTPRequest{
 			Method:       "POST",
@@ -931,3 +1087,448 @@ func TestCrossRepositoryBlobMount(t *testing.T) {
 		expectBlobExists(t, h, otherRepoToken, "test1/bar", blob, nil)
 	})
 }
+
+func TestRepositoryStorageQuota(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		blob1 := test.NewBytes([]byte("just some random data"))
+		blob2 := test.NewBytes([]byte("some more data"))
+		blob1.MustUpload(t, s, fooRepoRef)
+		blob2.MustUpload(t, s, barRepoRef)
+		mountToken := s.GetToken(t, "repository:test1/foo:pull,push", "repository:test1/bar:pull")
+
+		// set a quota that has room for a few more bytes, but not for blob2
+		quota := uint64(len(blob1.Contents) + 5)
+		_, err := s.DB.Exec(`UPDATE repos SET storage_quota_bytes = $1 WHERE name = $2`, quota, "foo")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		quotaExceededMessage := test.ErrorCodeWithMessage{
+			Code: keppel.ErrDenied,
+			Message: fmt.Sprintf("storage quota of repository \"test1/foo\" exceeded (quota = %d bytes, usage = %d bytes, blob size = %d bytes)",
+				quota, len(blob1.Contents), len(blob2.Contents)),
+			Detail: keppel.QuotaExceededDetail(quota, uint64(len(blob1.Contents))),
+		}
+
+		// monolithic upload is rejected before accepting the data
+		assert.HTTPRequest{
+			Method: "POST",
+			Path:   "/v2/test1/foo/blobs/uploads/?digest=" + blob2.Digest.String(),
+			Header: map[string]string{
+				"Authorization":  "Bearer " + token,
+				"Content-Length": strconv.Itoa(len(blob2.Contents)),
+				"Content-Type":   "application/octet-stream",
+			},
+			Body:         assert.ByteData(blob2.Contents),
+			ExpectStatus: http.StatusConflict,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   quotaExceededMessage,
+		}.Check(t, h)
+
+		// cross-repo mount is rejected
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/v2/test1/foo/blobs/uploads/?from=test1/bar&mount=" + blob2.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + mountToken},
+			ExpectStatus: http.StatusConflict,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   quotaExceededMessage,
+		}.Check(t, h)
+
+		// chunked upload is rejected when finishing the upload
+		uploadURL := getBlobUploadURL(t, h, token, "test1/foo")
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   uploadURL + "?digest=" + blob2.Digest.String(),
+			Header: map[string]string{
+				"Authorization":  "Bearer " + token,
+				"Content-Length": strconv.Itoa(len(blob2.Contents)),
+				"Content-Type":   "application/octet-stream",
+			},
+			Body:         assert.ByteData(blob2.Contents),
+			ExpectStatus: http.StatusConflict,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   quotaExceededMessage,
+		}.Check(t, h)
+
+		// blobs that are already in the repo do not count again
+		blob1.MustUpload(t, s, fooRepoRef)
+
+		// an unfinished chunked upload reserves quota for the chunks that it has
+		// received so far, so concurrent uploads cannot use the same space
+		blob3 := test.NewBytes([]byte("12345"))
+		blob4 := test.NewBytes([]byte("abc"))
+		uploadURL = getBlobUploadURL(t, h, token, "test1/foo")
+		assert.HTTPRequest{
+			Method: "PATCH",
+			Path:   uploadURL,
+			Header: map[string]string{
+				"Authorization":  "Bearer " + token,
+				"Content-Length": "3",
+				"Content-Range":  "0-2",
+				"Content-Type":   "application/octet-stream",
+			},
+			Body:         assert.ByteData(blob3.Contents[0:3]),
+			ExpectStatus: http.StatusAccepted,
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method: "POST",
+			Path:   "/v2/test1/foo/blobs/uploads/?digest=" + blob4.Digest.String(),
+			Header: map[string]string{
+				"Authorization":  "Bearer " + token,
+				"Content-Length": strconv.Itoa(len(blob4.Contents)),
+				"Content-Type":   "application/octet-stream",
+			},
+			Body:         assert.ByteData(blob4.Contents),
+			ExpectStatus: http.StatusConflict,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code: keppel.ErrDenied,
+				Message: fmt.Sprintf("storage quota of repository \"test1/foo\" exceeded (quota = %d bytes, usage = %d bytes, blob size = %d bytes)",
+					quota, len(blob1.Contents)+3, len(blob4.Contents)),
+				Detail: keppel.QuotaExceededDetail(quota, uint64(len(blob1.Contents)+3)),
+			},
+		}.Check(t, h)
+
+		// aborting the upload releases the reservation
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         uploadURL,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusNoContent,
+		}.Check(t, h)
+		blob4.MustUpload(t, s, fooRepoRef)
+
+		// other repos are not affected
+		blob1.MustUpload(t, s, barRepoRef)
+	})
+}
+
+func TestBlobUploadIntoRepositoryWithRejectedName(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		s.NVD.RejectedNames["test1/tmp"] = `repository names must have the format "<team>/<image>"`
+		token := s.GetToken(t, "repository:test1/tmp:pull,push")
+
+		// the repository cannot be created implicitly by a push
+		blob := test.NewBytes([]byte("just some random data"))
+		assert.HTTPRequest{
+			Method: "POST",
+			Path:   "/v2/test1/tmp/blobs/uploads/?digest=" + blob.Digest.String(),
+			Header: map[string]string{
+				"Authorization":  "Bearer " + token,
+				"Content-Length": strconv.Itoa(len(blob.Contents)),
+				"Content-Type":   "application/octet-stream",
+			},
+			Body:         assert.ByteData(blob.Contents),
+			ExpectStatus: http.StatusUnprocessableEntity,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrNameInvalid,
+				Message: `repository name "tmp" is not acceptable: repository names must have the format "<team>/<image>"`,
+			},
+		}.Check(t, h)
+		repoCount, err := s.DB.SelectInt(`SELECT COUNT(*) FROM repos WHERE name = $1`, "tmp")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if repoCount != 0 {
+			t.Errorf("expected repository test1/tmp to not exist, but it does")
+		}
+
+		// other repositories are not affected
+		blob.MustUpload(t, s, fooRepoRef)
+	})
+}
+
+func TestBlobPullViaCDN(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		blob := test.NewBytes([]byte("just some random data"))
+		blob.MustUpload(t, s, fooRepoRef)
+
+		// without the account setting, the blob is served directly
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/blobs/" + blob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(blob.Contents),
+		}.Check(t, h)
+
+		// with the account setting, the client gets redirected to the CDN
+		_, err := s.DB.Exec(`UPDATE accounts SET serve_blobs_via_cdn = TRUE WHERE name = $1`, "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/blobs/" + blob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusTemporaryRedirect,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey:   test.VersionHeaderValue,
+				"Docker-Content-Digest": blob.Digest.String(),
+				"Location":              "https://cdn.example.org/test1/" + blob.Digest.String() + "?signature=dummy",
+			},
+		}.Check(t, h)
+	})
+}
+
+func TestBlobPullViaStorageRedirect(t *testing.T) {
+	smallBlob := test.NewBytes([]byte("just some random data"))
+	largeBlob := test.NewBytes(bytes.Repeat([]byte("0123456789abcdef"), 1024))
+	policy := keppel.BlobRedirectPolicy{MinSizeBytes: 1024}
+
+	testWithPrimary(t, []test.SetupOption{test.WithBlobRedirectPolicy(policy)}, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		smallBlob.MustUpload(t, s, fooRepoRef)
+		largeBlobModel := largeBlob.MustUpload(t, s, fooRepoRef)
+
+		// if the storage driver cannot generate URLs, all blobs are served directly
+		for _, blob := range []test.Bytes{smallBlob, largeBlob} {
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/blobs/" + blob.Digest.String(),
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusOK,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   assert.ByteData(blob.Contents),
+			}.Check(t, h)
+		}
+
+		// if it can, blobs above the size threshold are redirected to the storage
+		s.SD.BlobURLBase = "https://storage.example.org"
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/blobs/" + smallBlob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(smallBlob.Contents),
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/blobs/" + largeBlob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusTemporaryRedirect,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey:   test.VersionHeaderValue,
+				"Docker-Content-Digest": largeBlob.Digest.String(),
+				"Location":              "https://storage.example.org/test1/" + largeBlobModel.StorageID + "?signature=dummy",
+			},
+		}.Check(t, h)
+	})
+
+	// with redirects disabled, even large blobs are served directly
+	policy = keppel.BlobRedirectPolicy{Disabled: true}
+	testWithPrimary(t, []test.SetupOption{test.WithBlobRedirectPolicy(policy)}, func(s test.Setup) {
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		largeBlob.MustUpload(t, s, fooRepoRef)
+		s.SD.BlobURLBase = "https://storage.example.org"
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/blobs/" + largeBlob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(largeBlob.Contents),
+		}.Check(t, s.Handler)
+	})
+}
+
+func TestBlobPullWithRange(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		blob := test.NewBytes([]byte("0123456789abcdefghij"))
+		blob.MustUpload(t, s, fooRepoRef)
+		pullCountBefore := getBlobPullCount(t)
+
+		// satisfiable single ranges are served with 206
+		testCases := []struct {
+			Range        string
+			ContentRange string
+			Contents     string
+		}{
+			{"bytes=0-4", "bytes 0-4/20", "01234"},
+			{"bytes=5-9", "bytes 5-9/20", "56789"},
+			{"bytes=15-", "bytes 15-19/20", "fghij"},
+			{"bytes=-3", "bytes 17-19/20", "hij"},
+			{"bytes=10-100", "bytes 10-19/20", "abcdefghij"},
+			{"bytes=-100", "bytes 0-19/20", "0123456789abcdefghij"},
+		}
+		for _, tc := range testCases {
+			assert.HTTPRequest{
+				Method: "GET",
+				Path:   "/v2/test1/foo/blobs/" + blob.Digest.String(),
+				Header: map[string]string{
+					"Authorization": "Bearer " + token,
+					"Range":         tc.Range,
+				},
+				ExpectStatus: http.StatusPartialContent,
+				ExpectHeader: map[string]string{
+					test.VersionHeaderKey:   test.VersionHeaderValue,
+					"Accept-Ranges":         "bytes",
+					"Content-Length":        strconv.Itoa(len(tc.Contents)),
+					"Content-Range":         tc.ContentRange,
+					"Docker-Content-Digest": blob.Digest.String(),
+				},
+				ExpectBody: assert.ByteData([]byte(tc.Contents)),
+			}.Check(t, h)
+		}
+
+		// only ranges at the start of the blob are counted as pulls (here: "bytes=0-4" and "bytes=-100")
+		assert.DeepEqual(t, "pull count", getBlobPullCount(t)-pullCountBefore, float64(2))
+
+		// unsatisfiable ranges are rejected
+		for _, rangeHeader := range []string{"bytes=20-", "bytes=-0"} {
+			assert.HTTPRequest{
+				Method: "GET",
+				Path:   "/v2/test1/foo/blobs/" + blob.Digest.String(),
+				Header: map[string]string{
+					"Authorization": "Bearer " + token,
+					"Range":         rangeHeader,
+				},
+				ExpectStatus: http.StatusRequestedRangeNotSatisfiable,
+				ExpectHeader: map[string]string{
+					test.VersionHeaderKey: test.VersionHeaderValue,
+					"Content-Range":       "bytes */20",
+				},
+				ExpectBody: test.ErrorCode(keppel.ErrSizeInvalid),
+			}.Check(t, h)
+		}
+
+		// multiple ranges and malformed ranges are ignored, and so are ranges on HEAD
+		for _, rangeHeader := range []string{"bytes=0-1,5-6", "bytes=9-5", "items=0-5"} {
+			assert.HTTPRequest{
+				Method: "GET",
+				Path:   "/v2/test1/foo/blobs/" + blob.Digest.String(),
+				Header: map[string]string{
+					"Authorization": "Bearer " + token,
+					"Range":         rangeHeader,
+				},
+				ExpectStatus: http.StatusOK,
+				ExpectHeader: map[string]string{
+					test.VersionHeaderKey: test.VersionHeaderValue,
+					"Accept-Ranges":       "bytes",
+				},
+				ExpectBody: assert.ByteData(blob.Contents),
+			}.Check(t, h)
+		}
+		assert.HTTPRequest{
+			Method: "HEAD",
+			Path:   "/v2/test1/foo/blobs/" + blob.Digest.String(),
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Range":         "bytes=5-9",
+			},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Accept-Ranges":       "bytes",
+				"Content-Length":      "20",
+			},
+		}.Check(t, h)
+	})
+}
+
+func getBlobPullCount(t *testing.T) float64 {
+	t.Helper()
+	labels := prometheus.Labels{"account": "test1", "auth_tenant_id": authTenantID, "method": "registry-api"}
+	var metric dto.Metric
+	err := api.BlobsPulledCounter.With(labels).Write(&metric)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestBlobPullWithPullTermsAndResponseHeaders(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		s.AD.ExpectedUserName = "correctusername"
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		blob := test.NewBytes([]byte("just some random data"))
+		blob.MustUpload(t, s, fooRepoRef)
+
+		_, err := s.DB.Exec(`UPDATE accounts SET response_headers_json = $1, pull_terms_version = $2, pull_terms_url = $3 WHERE name = $4`,
+			`{"X-Support-Contact":"support@example.org"}`, "2025-01", "https://example.org/terms", "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// before the terms of use have been accepted, pulls are denied (but the
+		// custom response headers and the link to the terms of use are already present)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/blobs/" + blob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusForbidden,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"X-Support-Contact":   "support@example.org",
+				"Link":                `<https://example.org/terms>; rel="terms-of-service"`,
+			},
+			ExpectBody: test.ErrorCode(keppel.ErrDenied),
+		}.Check(t, h)
+
+		// accepting an older version does not help
+		_, err = s.DB.Exec(`INSERT INTO pull_terms_acceptances (account_name, user_name, terms_version, accepted_at) VALUES ($1, $2, $3, $4)`,
+			"test1", "correctusername", "2024-01", s.Clock.Now())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/blobs/" + blob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusForbidden,
+			ExpectBody:   test.ErrorCode(keppel.ErrDenied),
+		}.Check(t, h)
+
+		// after accepting the current version, pulls work
+		_, err = s.DB.Exec(`INSERT INTO pull_terms_acceptances (account_name, user_name, terms_version, accepted_at) VALUES ($1, $2, $3, $4)`,
+			"test1", "correctusername", "2025-01", s.Clock.Now())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/blobs/" + blob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"X-Support-Contact":   "support@example.org",
+			},
+			ExpectBody: assert.ByteData(blob.Contents),
+		}.Check(t, h)
+
+		// anonymous users cannot accept the terms of use, so they are only pointed to them
+		_, err = s.DB.Exec(`UPDATE accounts SET rbac_policies_json = $2 WHERE name = $1`, "test1",
+			test.ToJSON([]keppel.RBACPolicy{{
+				RepositoryPattern: "foo",
+				Permissions:       []keppel.RBACPermission{keppel.RBACAnonymousPullPermission},
+			}}),
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/blobs/" + blob.Digest.String(),
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Link":                `<https://example.org/terms>; rel="terms-of-service"`,
+			},
+			ExpectBody: assert.ByteData(blob.Contents),
+		}.Check(t, h)
+	})
+}
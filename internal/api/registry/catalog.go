@@ -132,7 +132,8 @@ func (a *API) handleGetCatalog(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-const catalogGetQuery = `SELECT name FROM repos WHERE account_name = $1 ORDER BY name`
+// archived repos are hidden from the catalog, but can still be pulled from
+const catalogGetQuery = `SELECT name FROM repos WHERE account_name = $1 AND NOT is_archived ORDER BY name`
 
 func (a *API) getCatalogForAccount(accountName models.AccountName, includeAccountName bool) ([]string, error) {
 	var result []string
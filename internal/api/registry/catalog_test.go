@@ -49,6 +49,7 @@ func TestCatalogEndpoint(t *testing.T) {
 	testDomainRemappedCatalog(t, s)
 	testAuthErrorsForCatalog(t, s)
 	testNoCatalogOnAnycast(t, s)
+	testArchivedReposHiddenFromCatalog(t, s)
 }
 
 func testEmptyCatalog(t *testing.T, s test.Setup) {
@@ -271,3 +272,20 @@ func testNoCatalogOnAnycast(t *testing.T, s test.Setup) {
 		ExpectBody:   test.ErrorCode(keppel.ErrUnsupported),
 	}.Check(t, s.Handler)
 }
+
+func testArchivedReposHiddenFromCatalog(t *testing.T, s test.Setup) {
+	_, err := s.DB.Exec(`UPDATE repos SET is_archived = TRUE WHERE account_name = $1 AND name = $2`, "test1", "qux")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	token := s.GetToken(t, "registry:catalog:*", "keppel_account:test1:view")
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/_catalog",
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+		ExpectHeader: test.VersionHeader,
+		ExpectBody:   assert.JSONObject{"repositories": []string{"test1/bar", "test1/foo"}},
+	}.Check(t, s.Handler)
+}
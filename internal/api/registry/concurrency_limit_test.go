@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package registryv2_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestConcurrencyLimits(t *testing.T) {
+	acl := keppel.NewConcurrencyLimiter(2)
+	setupOptions := []test.SetupOption{
+		test.WithConcurrencyLimiter(acl),
+	}
+
+	testWithPrimary(t, setupOptions, func(s test.Setup) {
+		_, err := keppel.FindOrCreateRepository(s.DB, "foo", models.AccountName("test1"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		bogusDigest := test.DeterministicDummyDigest(1).String()
+
+		// simulate two requests that are already in flight for this account
+		for range 2 {
+			if !acl.TryAcquire("test1") {
+				t.Fatal("could not occupy concurrency slot")
+			}
+		}
+
+		// further requests are rejected until one of them completes
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/" + bogusDigest,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusTooManyRequests,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Retry-After":         "1",
+			},
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrTooManyRequests,
+				Message: `too many concurrent requests for account "test1"`,
+				Detail:  keppel.RetryAfterDetail(keppel.ReasonConcurrencyLimited, time.Second),
+			},
+		}.Check(t, s.Handler)
+
+		acl.Release("test1")
+		for range 3 {
+			// since each request releases its slot when it is done, consecutive
+			// requests all fit into the remaining slot
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/manifests/" + bogusDigest,
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusNotFound,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   test.ErrorCode(keppel.ErrManifestUnknown),
+			}.Check(t, s.Handler)
+		}
+
+		// endpoints that do not refer to an account are not limited
+		acl.TryAcquire("test1")
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/",
+			Header:       map[string]string{"Authorization": "Bearer " + s.GetToken(t)},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, s.Handler)
+
+		acl.Release("test1")
+		acl.Release("test1")
+	})
+}
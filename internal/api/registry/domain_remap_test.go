@@ -142,3 +142,78 @@ func TestManifestAPIDomainRemap(t *testing.T) {
 		}.Check(t, h)
 	})
 }
+
+func TestRegistryAPICustomDomain(t *testing.T) {
+	// test Registry API endpoints with request URLs using the custom domain of an account
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		_, err := s.DB.Exec(`UPDATE accounts SET custom_domain = $1, custom_domain_verified_at = $2 WHERE name = $3`,
+			"registry.team.example.com", s.Clock.Now(), "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// without token, expect auth challenge pointing to the custom domain
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/",
+			Header: map[string]string{
+				"X-Forwarded-Host":  "registry.team.example.com",
+				"X-Forwarded-Proto": "https",
+			},
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Www-Authenticate":    `Bearer realm="https://registry.team.example.com/keppel/v1/auth",service="registry.team.example.com"`,
+			},
+			ExpectBody: test.ErrorCode(keppel.ErrUnauthorized),
+		}.Check(t, h)
+
+		// with token, the account's repositories are accessible without the account name in the path
+		image := test.GenerateImage( /* no layers */ )
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		token := s.GetCustomDomainToken(t, "test1", "registry.team.example.com", "repository:foo:pull")
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization":     "Bearer " + token,
+				"X-Forwarded-Host":  "registry.team.example.com",
+				"X-Forwarded-Proto": "https",
+			},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Content-Type":        image.Manifest.MediaType,
+			},
+		}.Check(t, h)
+
+		// tokens for the custom domain are not valid on the domain-remapped API and vice versa
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization":     "Bearer " + token,
+				"X-Forwarded-Host":  "test1.registry.example.org",
+				"X-Forwarded-Proto": "https",
+			},
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrUnauthorized),
+		}.Check(t, h)
+		remappedToken := s.GetDomainRemappedToken(t, "test1", "repository:foo:pull")
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization":     "Bearer " + remappedToken,
+				"X-Forwarded-Host":  "registry.team.example.com",
+				"X-Forwarded-Proto": "https",
+			},
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrUnauthorized),
+		}.Check(t, h)
+	})
+}
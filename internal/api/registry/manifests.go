@@ -4,7 +4,9 @@
 package registryv2
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -15,9 +17,11 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/opencontainers/go-digest"
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
 	accept "github.com/timewasted/go-accept-headers"
 
 	"github.com/sapcc/keppel/internal/api"
@@ -27,6 +31,23 @@ import (
 	"github.com/sapcc/keppel/internal/processor"
 )
 
+var updateTagLastPulledAtQuery = sqlext.SimplifyWhitespace(`
+	UPDATE tags SET last_pulled_at = $1, pull_count = pull_count + 1
+	 WHERE repo_id = $2 AND digest = $3 AND name = $4
+`)
+
+var updateRecompressedVariantPullCountQuery = sqlext.SimplifyWhitespace(`
+	UPDATE recompressed_variants SET pull_count = pull_count + 1
+	 WHERE repo_id = $1 AND variant_digest = $2
+`)
+
+var updateParentTagsLastPulledAtQuery = sqlext.SimplifyWhitespace(`
+	UPDATE tags SET last_pulled_at = $1, child_pull_count = child_pull_count + 1
+	 WHERE repo_id = $2 AND digest IN (
+	   SELECT parent_digest FROM manifest_manifest_refs WHERE repo_id = $2 AND child_digest = $3
+	 )
+`)
+
 // This implements the HEAD/GET /v2/<repo>/manifests/<reference> endpoint.
 func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/v2/:account/:repo/manifests/:reference")
@@ -34,6 +55,9 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 	if account == nil {
 		return
 	}
+	if !a.checkPullTermsAccepted(w, r, *account, authz) {
+		return
+	}
 
 	err := api.CheckRateLimit(r, a.rle, *account, authz, keppel.ManifestPullAction, 1)
 	if respondWithError(w, r, err) {
@@ -84,18 +108,58 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		// if manifest was found in our DB, fetch the contents from the DB (or fall
-		// back to the storage if the DB entry is not there for some reason)
-		manifestBytes, err = a.getManifestContentFromDB(repo.ID, dbManifest.Digest)
-		if err != nil {
-			if !errors.Is(err, sql.ErrNoRows) {
-				logg.Info("could not read manifest %s@%s from DB (falling back to read from storage): %s",
-					repo.FullName(), dbManifest.Digest, err.Error())
+		manifestBytes, err = a.readManifestContents(r.Context(), *account, *repo, *dbManifest)
+		if respondWithError(w, r, err) {
+			return
+		}
+	}
+	if respondWithError(w, r, checkManifestNotQuarantined(*dbManifest, authz)) {
+		return
+	}
+	if respondWithError(w, r, checkManifestPromotionState(*account, *dbManifest, authz)) {
+		return
+	}
+	if respondWithError(w, r, a.checkManifestKeppelLabels(*repo, *dbManifest, authz)) {
+		return
+	}
+
+	// in accounts replicating from untrusted upstreams, only manifests whose
+	// digest chain has been verified by the janitor may be served (see
+	// tasks.ManifestVerificationJob)
+	if account.ExternalPeerVerifyOnly && dbManifest.VerifiedAt == nil {
+		respondWithVerificationPending(w, r, *dbManifest)
+		return
+	}
+
+	// if a platform is selected (either explicitly by the client or through the
+	// account's default platform), GET on a tag referring to a list manifest
+	// directly returns the submanifest for that platform (this does not apply to
+	// peers since replication needs to see the actual list manifest)
+	pulledDigests := []digest.Digest{dbManifest.Digest}
+	if reference.IsTag() && authz.UserIdentity.UserType() != keppel.PeerUser {
+		platformSpec := account.DefaultPlatform
+		if headerValue := r.Header.Get("X-Keppel-Platform"); headerValue != "" {
+			platformSpec = headerValue
+		}
+		if platformSpec != "" && platformSpec != "none" {
+			platform, err := keppel.ParsePlatform(platformSpec)
+			if err != nil {
+				keppel.ErrUnsupported.With(err.Error()).WithStatus(http.StatusBadRequest).WriteAsRegistryV2ResponseTo(w, r)
+				return
 			}
-			manifestBytes, err = a.sd.ReadManifest(r.Context(), *account, repo.Name, dbManifest.Digest)
+			childManifest, childBytes, err := a.findSubmanifestForPlatform(r.Context(), *account, *repo, *dbManifest, manifestBytes, platform)
 			if respondWithError(w, r, err) {
 				return
 			}
+			// only serve the submanifest if the client can actually accept it
+			// (e.g. when the client explicitly asks for the list manifest, we should not override that)
+			if childManifest != nil && (r.Header.Get("Accept") == "" || accept.Parse(strings.Join(r.Header["Accept"], ", ")).Accepts(childManifest.MediaType)) {
+				if respondWithError(w, r, checkManifestNotQuarantined(*childManifest, authz)) {
+					return
+				}
+				dbManifest, manifestBytes = childManifest, childBytes
+				pulledDigests = append(pulledDigests, childManifest.Digest)
+			}
 		}
 	}
 
@@ -166,6 +230,11 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Length", strconv.FormatUint(uint64(len(manifestBytes)), 10))
 	w.Header().Set("Content-Type", dbManifest.MediaType)
 	w.Header().Set("Docker-Content-Digest", dbManifest.Digest.String())
+	w.Header().Set("Cache-Control", a.cfg.CachePolicy.CacheControlHeader(!reference.IsTag()))
+	if reference.IsTag() {
+		// for tags, the response can depend on these headers (see platform selection and content negotiation above)
+		w.Header().Set("Vary", "Accept, X-Keppel-Platform")
+	}
 	if securityInfo != nil {
 		w.Header().Set("X-Keppel-Vulnerability-Status", string(securityInfo.VulnerabilityStatus))
 	}
@@ -184,35 +253,64 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet && r.Header.Get("X-Keppel-No-Count-Towards-Last-Pulled") != "1" && authz.UserIdentity.UserType() != keppel.TrivyUser {
 		l := prometheus.Labels{"account": string(account.Name), "auth_tenant_id": account.AuthTenantID, "method": "registry-api"}
 		api.ManifestsPulledCounter.With(l).Inc()
-
-		// update manifests.last_pulled_at
-		_, err := a.db.Exec(
-			`UPDATE manifests SET last_pulled_at = $1 WHERE repo_id = $2 AND digest = $3`,
-			a.timeNow(), dbManifest.RepositoryID, dbManifest.Digest,
-		)
-
-		if err == nil {
-			if dbManifest.LastPulledAt != nil && dbManifest.LastPulledAt.Before(a.timeNow().Add(-7*24*time.Hour)) {
-				userNameDisplay := authz.UserIdentity.UserName()
-				if authz.UserIdentity.UserType() == keppel.AnonymousUser {
-					userNameDisplay = "<anonymous>"
-				}
-				logg.Info("last_pulled_at timestamp of manifest %s@%s got updated by more than 7 days by user %q, user agent %q",
-					repo.FullName(), dbManifest.Digest, userNameDisplay, r.Header.Get("User-Agent"))
+		a.dn.Notify(r, "pull", keppel.DistributionEventTarget{
+			MediaType:  dbManifest.MediaType,
+			Size:       uint64(len(manifestBytes)),
+			Digest:     dbManifest.Digest,
+			Repository: repo.FullName(),
+			Tag:        reference.Tag,
+		}, authz.UserIdentity)
+
+		// if the token identifies the client, remember that it pulled this manifest
+		a.par.Record(*repo, pulledDigests, authz.ClientID, a.timeNow())
+
+		// update manifests.last_pulled_at (if the tag was resolved into a submanifest
+		// for the selected platform, this affects both the list manifest and the submanifest)
+		for _, pulledDigest := range pulledDigests {
+			_, err := a.db.Exec(
+				`UPDATE manifests SET last_pulled_at = $1 WHERE repo_id = $2 AND digest = $3`,
+				a.timeNow(), dbManifest.RepositoryID, pulledDigest,
+			)
+			if err != nil {
+				logg.Error("could not update last_pulled_at timestamp on manifest %s@%s: %s", repo.FullName(), pulledDigest, err.Error())
 			}
-		} else {
-			logg.Error("could not update last_pulled_at timestamp on manifest %s@%s: %s", repo.FullName(), dbManifest.Digest, err.Error())
+		}
+		if dbManifest.LastPulledAt != nil && dbManifest.LastPulledAt.Before(a.timeNow().Add(-7*24*time.Hour)) {
+			userNameDisplay := authz.UserIdentity.UserName()
+			if authz.UserIdentity.UserType() == keppel.AnonymousUser {
+				userNameDisplay = "<anonymous>"
+			}
+			logg.Info("last_pulled_at timestamp of manifest %s@%s got updated by more than 7 days by user %q, user agent %q",
+				repo.FullName(), dbManifest.Digest, userNameDisplay, r.Header.Get("User-Agent"))
 		}
 
-		// also update tags.last_pulled_at if applicable
+		// also update tags.last_pulled_at and the tag's pull counters if applicable
 		if reference.IsTag() {
-			_, err := a.db.Exec(
-				`UPDATE tags SET last_pulled_at = $1 WHERE repo_id = $2 AND digest = $3 AND name = $4`,
-				a.timeNow(), dbManifest.RepositoryID, dbManifest.Digest, reference.Tag,
-			)
+			_, err := a.db.Exec(updateTagLastPulledAtQuery, a.timeNow(), dbManifest.RepositoryID, pulledDigests[0], reference.Tag)
 			if err != nil {
 				logg.Error("could not update last_pulled_at timestamp on tag %s/%s: %s", repo.FullName(), reference.Tag, err.Error())
 			}
+		} else {
+			// clients pulling a multi-arch image usually resolve the tag into the list
+			// manifest once, and then pull the submanifest for their platform by digest;
+			// attribute the latter pull to the tags of the list manifest (but count it
+			// separately from pulls of the tag itself), so that GC policies looking at
+			// tags[].last_pulled_at see the image as being in use
+			//
+			// The list manifest's own last_pulled_at is not touched: it shall only
+			// reflect actual pulls of the list manifest, otherwise untagged list
+			// manifests would be kept alive forever by pulls of their submanifests.
+			_, err := a.db.Exec(updateParentTagsLastPulledAtQuery, a.timeNow(), dbManifest.RepositoryID, pulledDigests[0])
+			if err != nil {
+				logg.Error("could not update last_pulled_at timestamps for tags of parents of manifest %s@%s: %s", repo.FullName(), pulledDigests[0], err.Error())
+			}
+
+			// recompressed variants are discovered through the Referrers API, so they
+			// are always pulled by digest; their pulls go into the savings report
+			_, err = a.db.Exec(updateRecompressedVariantPullCountQuery, dbManifest.RepositoryID, pulledDigests[0])
+			if err != nil {
+				logg.Error("could not update pull count of recompressed variant %s@%s: %s", repo.FullName(), pulledDigests[0], err.Error())
+			}
 		}
 	}
 }
@@ -245,13 +343,47 @@ func (a *API) findManifestInDB(repo models.Repository, reference models.Manifest
 	return &dbManifest, err
 }
 
-func (a *API) getManifestContentFromDB(repoID int64, digestStr digest.Digest) ([]byte, error) {
-	var result []byte
-	err := a.db.SelectOne(&result,
-		`SELECT content FROM manifest_contents WHERE repo_id = $1 AND digest = $2`,
-		repoID, digestStr,
-	)
-	return result, err
+// readManifestContents fetches the contents of a manifest from the DB (or
+// falls back to the storage if the DB entry is not there for some reason).
+func (a *API) readManifestContents(ctx context.Context, account models.ReducedAccount, repo models.Repository, dbManifest models.Manifest) ([]byte, error) {
+	manifestBytes, err := keppel.ReadManifestContent(ctx, a.db, a.secd, repo.ID, dbManifest.Digest)
+	if err == nil {
+		return manifestBytes, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		logg.Info("could not read manifest %s@%s from DB (falling back to read from storage): %s",
+			repo.FullName(), dbManifest.Digest, err.Error())
+	}
+	return keppel.ReadManifestFromStorage(ctx, a.sd, a.secd, account, repo.Name, dbManifest.Digest)
+}
+
+// findSubmanifestForPlatform checks if the given manifest is a list manifest
+// with a submanifest for the given platform. If so, the submanifest and its
+// contents are returned. If not, nil is returned without an error.
+func (a *API) findSubmanifestForPlatform(ctx context.Context, account models.ReducedAccount, repo models.Repository, dbManifest models.Manifest, manifestBytes []byte, platform imagespecs.Platform) (*models.Manifest, []byte, error) {
+	manifestParsed, err := keppel.ParseManifest(dbManifest.MediaType, manifestBytes)
+	if err != nil {
+		return nil, nil, keppel.ErrManifestInvalid.With(err.Error())
+	}
+	desc := keppel.FindSubmanifestForPlatform(manifestParsed, account.PlatformFilter, platform)
+	if desc == nil {
+		return nil, nil, nil
+	}
+
+	childManifest, err := a.findManifestInDB(repo, models.ManifestReference{Digest: desc.Digest})
+	if errors.Is(err, sql.ErrNoRows) {
+		// can happen e.g. in replica accounts if the submanifest was not replicated yet;
+		// in this case, we just serve the list manifest as usual
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	childBytes, err := a.readManifestContents(ctx, account, repo, *childManifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return childManifest, childBytes, nil
 }
 
 func (a *API) handleGetOrHeadManifestAnycast(w http.ResponseWriter, r *http.Request, info anycastRequestInfo) {
@@ -262,6 +394,116 @@ func (a *API) handleGetOrHeadManifestAnycast(w http.ResponseWriter, r *http.Requ
 	api.ManifestsPulledCounter.With(info.AsPrometheusLabels()).Inc()
 }
 
+// Quarantined manifests can only be pulled by admins, and by Trivy (so that
+// quarantined images can still be scanned).
+func checkManifestNotQuarantined(manifest models.Manifest, authz *auth.Authorization) error {
+	if manifest.State() != models.ManifestQuarantined {
+		return nil
+	}
+	uid := authz.UserIdentity
+	if uid.UserType() == keppel.TrivyUser || uid.HasPermission(keppel.CanAdministrateKeppel, "") {
+		return nil
+	}
+	return keppel.ErrDenied.With("manifest %s is quarantined: %s", manifest.Digest, manifest.QuarantineReason).
+		WithStatus(http.StatusForbidden).
+		WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonManifestQuarantined})
+}
+
+var blobReferencingManifestsQuery = sqlext.SimplifyWhitespace(`
+	SELECT m.* FROM manifests m
+	  JOIN manifest_blob_refs r ON m.repo_id = r.repo_id AND m.digest = r.digest
+	 WHERE r.repo_id = $1 AND r.blob_id = $2
+	 ORDER BY m.digest
+`)
+
+// Blobs are subject to the same restrictions as the manifests referencing
+// them. Otherwise, e.g. the layers of a quarantined image could still be
+// pulled by digest. Blobs that are not referenced by any manifest (e.g. while
+// an image is being pushed) are not restricted.
+//
+// This returns whether one of the manifests referencing the blob passes the
+// given check.
+func (a *API) isBlobAllowedByManifests(repo models.Repository, blob models.Blob, check func(models.Manifest) error) (bool, error) {
+	var manifests []models.Manifest
+	_, err := a.db.Select(&manifests, blobReferencingManifestsQuery, repo.ID, blob.ID)
+	if err != nil {
+		return false, err
+	}
+	if len(manifests) == 0 {
+		return true, nil
+	}
+	for _, manifest := range manifests {
+		if check(manifest) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (a *API) checkBlobNotQuarantined(repo models.Repository, blob models.Blob, authz *auth.Authorization) error {
+	isAllowed, err := a.isBlobAllowedByManifests(repo, blob, func(manifest models.Manifest) error {
+		return checkManifestNotQuarantined(manifest, authz)
+	})
+	if err != nil || isAllowed {
+		return err
+	}
+	return keppel.ErrDenied.With("blob %s only belongs to quarantined manifests", blob.Digest).
+		WithStatus(http.StatusForbidden).
+		WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonManifestQuarantined})
+}
+
+func (a *API) checkBlobPromotionState(account models.ReducedAccount, repo models.Repository, blob models.Blob, authz *auth.Authorization) error {
+	if account.MinPullPromotionState == models.PromotionStateNone {
+		return nil
+	}
+	isAllowed, err := a.isBlobAllowedByManifests(repo, blob, func(manifest models.Manifest) error {
+		return checkManifestPromotionState(account, manifest, authz)
+	})
+	if err != nil || isAllowed {
+		return err
+	}
+	return keppel.ErrDenied.With("blob %s only belongs to manifests that have not been promoted to %q yet", blob.Digest, account.MinPullPromotionState).
+		WithStatus(http.StatusForbidden).
+		WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonManifestNotPromoted})
+}
+
+func checkManifestPromotionState(account models.ReducedAccount, manifest models.Manifest, authz *auth.Authorization) error {
+	if manifest.PromotionState.IsAtLeast(account.MinPullPromotionState) {
+		return nil
+	}
+	// account admins need to be able to pull manifests to decide on promoting them
+	uid := authz.UserIdentity
+	switch uid.UserType() {
+	case keppel.PeerUser, keppel.TrivyUser:
+		return nil
+	}
+	if uid.HasPermission(keppel.CanChangeAccount, account.AuthTenantID) || uid.HasPermission(keppel.CanAdministrateKeppel, "") {
+		return nil
+	}
+	return keppel.ErrDenied.With("manifest %s has not been promoted to %q yet", manifest.Digest, account.MinPullPromotionState).
+		WithStatus(http.StatusForbidden).
+		WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonManifestNotPromoted})
+}
+
+// If pull access was only granted by RBAC policies with "match_keppel_labels",
+// only manifests with the respective Keppel labels (or manifests contained
+// within image lists with these labels) can be pulled.
+func (a *API) checkManifestKeppelLabels(repo models.Repository, manifest models.Manifest, authz *auth.Authorization) error {
+	restrictions, isRestricted := authz.KeppelLabelRestrictions[repo.FullName()]
+	if !isRestricted {
+		return nil
+	}
+	isAllowed, err := keppel.IsManifestAllowedByKeppelLabels(a.db, repo, manifest.Digest, restrictions)
+	if err != nil {
+		return err
+	}
+	if !isAllowed {
+		return keppel.ErrDenied.With("manifest %s does not have the Keppel labels required for pulling it", manifest.Digest).
+			WithStatus(http.StatusForbidden)
+	}
+	return nil
+}
+
 // This implements the DELETE /v2/<repo>/manifests/<reference> endpoint.
 func (a *API) handleDeleteManifest(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/v2/:account/:repo/manifests/:reference")
@@ -270,17 +512,43 @@ func (a *API) handleDeleteManifest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// archived repos are read-only, so nothing can be deleted from them either
+	if repo.IsArchived {
+		keppel.ErrUnsupported.With("repository is archived").WithStatus(http.StatusMethodNotAllowed).
+			WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonRepositoryArchived}).
+			WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+
 	// delete tag or manifest from the database
 	ref := models.ParseManifestReference(mux.Vars(r)["reference"])
 	actx := keppel.AuditContext{
 		UserIdentity: authz.UserIdentity,
 		Request:      r,
 	}
-	var err error
+	// protected tags can only be deleted by account admins
+	err := a.processor().CheckDeletionAllowedByTagProtection(*account, *repo, ref, authz.UserIdentity)
+	if respondWithError(w, r, err) {
+		return
+	}
+	// deletions that require approval can only be requested through the Keppel API
+	var pc *models.PendingChange
 	if ref.IsTag() {
-		err = a.processor().DeleteTag(*account, *repo, ref.Tag, actx)
+		pc, err = a.processor().PendingChangeForTagDeletion(*account, *repo, ref.Tag)
 	} else {
-		err = a.processor().DeleteManifest(r.Context(), *account, *repo, ref.Digest, actx)
+		pc, err = a.processor().PendingChangeForManifestDeletion(*account, *repo, ref.Digest)
+	}
+	if pc != nil {
+		keppel.ErrDenied.With("this deletion requires approval by a second user, please request it through the Keppel API").WithStatus(http.StatusForbidden).WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+
+	if err == nil {
+		if ref.IsTag() {
+			err = a.processor().DeleteTag(*account, *repo, ref.Tag, actx)
+		} else {
+			err = a.processor().DeleteManifest(r.Context(), *account, *repo, ref.Digest, actx)
+		}
 	}
 	if errors.Is(err, sql.ErrNoRows) {
 		keppel.ErrManifestUnknown.With("no such manifest").WriteAsRegistryV2ResponseTo(w, r)
@@ -289,6 +557,11 @@ func (a *API) handleDeleteManifest(w http.ResponseWriter, r *http.Request) {
 	if respondWithError(w, r, err) {
 		return
 	}
+	a.dn.Notify(r, "delete", keppel.DistributionEventTarget{
+		Digest:     ref.Digest,
+		Repository: repo.FullName(),
+		Tag:        ref.Tag,
+	}, authz.UserIdentity)
 
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -308,39 +581,57 @@ func (a *API) handlePutManifest(w http.ResponseWriter, r *http.Request) {
 
 	// forbid pushing into replica accounts
 	if account.UpstreamPeerHostName != "" {
-		msg := fmt.Sprintf("cannot push into replica account (push to %s/%s/%s instead!)",
-			account.UpstreamPeerHostName, account.Name, repo.Name,
-		)
-		keppel.ErrUnsupported.With(msg).WithStatus(http.StatusMethodNotAllowed).WriteAsRegistryV2ResponseTo(w, r)
+		pushTo := fmt.Sprintf("%s/%s/%s", account.UpstreamPeerHostName, account.Name, repo.Name)
+		msg := fmt.Sprintf("cannot push into replica account (push to %s instead!)", pushTo)
+		keppel.ErrUnsupported.With(msg).WithStatus(http.StatusMethodNotAllowed).
+			WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonPushToReplica, PushTo: pushTo}).
+			WriteAsRegistryV2ResponseTo(w, r)
 		return
 	}
 	if account.ExternalPeerURL != "" {
-		msg := fmt.Sprintf("cannot push into external replica account (push to %s/%s instead!)",
-			account.ExternalPeerURL, repo.Name,
-		)
-		keppel.ErrUnsupported.With(msg).WithStatus(http.StatusMethodNotAllowed).WriteAsRegistryV2ResponseTo(w, r)
+		pushTo := fmt.Sprintf("%s/%s", account.ExternalPeerURL, repo.Name)
+		msg := fmt.Sprintf("cannot push into external replica account (push to %s instead!)", pushTo)
+		keppel.ErrUnsupported.With(msg).WithStatus(http.StatusMethodNotAllowed).
+			WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonPushToReplica, PushTo: pushTo}).
+			WriteAsRegistryV2ResponseTo(w, r)
 		return
 	}
 
 	// forbid pushing during maintenance
 	if account.IsDeleting {
-		keppel.ErrUnsupported.With("account is being deleted").WithStatus(http.StatusMethodNotAllowed).WriteAsRegistryV2ResponseTo(w, r)
+		keppel.ErrUnsupported.With("account is being deleted").WithStatus(http.StatusMethodNotAllowed).
+			WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonAccountDeleting}).
+			WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+
+	// forbid pushing into archived repos
+	if repo.IsArchived {
+		keppel.ErrUnsupported.With("repository is archived").WithStatus(http.StatusMethodNotAllowed).
+			WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonRepositoryArchived}).
+			WriteAsRegistryV2ResponseTo(w, r)
 		return
 	}
 
 	// read manifest from request
 	manifestBytes, err := io.ReadAll(r.Body)
+	if rerr := keppel.AsRequestBodyError(err); rerr != nil {
+		rerr.WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
 	if respondWithError(w, r, err) {
 		return
 	}
 
 	// validate and store manifest
 	ref := models.ParseManifestReference(mux.Vars(r)["reference"])
-	manifest, err := a.processor().ValidateAndStoreManifest(r.Context(), *account, *repo, processor.IncomingManifest{
+	pushInfo := keppel.BuildManifestPushInfo(r)
+	manifest, tagDigest, err := a.processor().ValidateAndStoreManifest(r.Context(), *account, *repo, processor.IncomingManifest{
 		Reference: ref,
 		MediaType: r.Header.Get("Content-Type"),
 		Contents:  manifestBytes,
 		PushedAt:  a.timeNow(),
+		PushInfo:  &pushInfo,
 	}, keppel.AuditContext{
 		UserIdentity: authz.UserIdentity,
 		Request:      r,
@@ -352,6 +643,22 @@ func (a *API) handlePutManifest(w http.ResponseWriter, r *http.Request) {
 	// count the push
 	l := prometheus.Labels{"account": string(account.Name), "auth_tenant_id": account.AuthTenantID, "method": "registry-api"}
 	api.ManifestsPushedCounter.With(l).Inc()
+	a.dn.Notify(r, "push", keppel.DistributionEventTarget{
+		MediaType:  manifest.MediaType,
+		Size:       uint64(len(manifestBytes)),
+		Digest:     manifest.Digest,
+		Repository: repo.FullName(),
+		Tag:        ref.Tag,
+	}, authz.UserIdentity)
+	keppel.EnqueueWebhookNotificationOrLog(a.db, keppel.WebhookNotification{
+		Event:      keppel.WebhookEventPush,
+		Account:    account.Name,
+		Repository: repo.Name,
+		Digest:     manifest.Digest,
+		MediaType:  manifest.MediaType,
+		Tag:        ref.Tag,
+		Timestamp:  manifest.PushedAt.Unix(),
+	}, a.timeNow())
 
 	w.Header().Set("Content-Length", "0")
 	w.Header().Set("Docker-Content-Digest", manifest.Digest.String())
@@ -359,5 +666,19 @@ func (a *API) handlePutManifest(w http.ResponseWriter, r *http.Request) {
 	if manifest.SubjectDigest != "" {
 		w.Header().Set("Oci-Subject", manifest.SubjectDigest.String())
 	}
+	if tagDigest != "" {
+		// if a concurrent push of the same tag wins, the 409 response carries this header instead
+		w.Header().Set("X-Keppel-Tag-Digest", tagDigest.String())
+	}
+	if manifest.ValidationWarningsJSON != "" {
+		var warnings []string
+		err := json.Unmarshal([]byte(manifest.ValidationWarningsJSON), &warnings)
+		if err != nil {
+			logg.Error("cannot decode validation warnings for manifest %s: %s", manifest.Digest, err.Error())
+		}
+		for _, warning := range warnings {
+			w.Header().Add("X-Keppel-Validation-Warning", warning)
+		}
+	}
 	w.WriteHeader(http.StatusCreated)
 }
@@ -4,7 +4,10 @@
 package registryv2_test
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"testing"
 	"time"
@@ -493,6 +496,140 @@ func TestImageListManifestLifecycle(t *testing.T) {
 	})
 }
 
+func TestImageListDefaultPlatform(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		// GenerateImageList() assigns linux/amd64 to image1 and linux/arm to image2
+		image1 := test.GenerateImage(test.GenerateExampleLayer(1))
+		image2 := test.GenerateImage(test.GenerateExampleLayer(2))
+		list := test.GenerateImageList(image1, image2)
+		list.MustUpload(t, s, fooRepoRef, "list")
+
+		// without a default platform, the list manifest is served as usual
+		expectManifestExists(t, h, token, "test1/foo", list.Manifest, "list", nil)
+
+		// with the X-Keppel-Platform header, GET on the tag resolves into the matching submanifest
+		expectManifestExists(t, h, token, "test1/foo", image2.Manifest, "list", map[string]string{
+			"X-Keppel-Platform": "linux/arm",
+		})
+
+		// if no submanifest matches, the list manifest is served as usual
+		expectManifestExists(t, h, token, "test1/foo", list.Manifest, "list", map[string]string{
+			"X-Keppel-Platform": "windows/amd64",
+		})
+
+		// malformed platforms are rejected
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/test1/foo/manifests/list",
+			Header: map[string]string{
+				"Authorization":     "Bearer " + token,
+				"X-Keppel-Platform": "linux",
+			},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   test.ErrorCode(keppel.ErrUnsupported),
+		}.Check(t, h)
+
+		// set a default platform on the account
+		_, err := s.DB.Exec(`UPDATE accounts SET default_platform = $1 WHERE name = $2`, "linux/amd64", "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		expectManifestExists(t, h, token, "test1/foo", image1.Manifest, "list", nil)
+
+		// the X-Keppel-Platform header overrides the default platform, or disables it with the special value "none"
+		expectManifestExists(t, h, token, "test1/foo", image2.Manifest, "list", map[string]string{
+			"X-Keppel-Platform": "linux/arm",
+		})
+		expectManifestExists(t, h, token, "test1/foo", list.Manifest, "list", map[string]string{
+			"X-Keppel-Platform": "none",
+		})
+
+		// GET by digest is never affected by the default platform
+		expectManifestExists(t, h, token, "test1/foo", list.Manifest, "", nil)
+	})
+}
+
+func TestImageListSubmanifestPullAttribution(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		image1 := test.GenerateImage(test.GenerateExampleLayer(1))
+		image2 := test.GenerateImage(test.GenerateExampleLayer(2))
+		list := test.GenerateImageList(image1, image2)
+		list.MustUpload(t, s, fooRepoRef, "list")
+
+		// helpers that retrieve the relevant DB records
+		getTag := func() models.Tag {
+			t.Helper()
+			var tag models.Tag
+			err := s.DB.SelectOne(&tag, `SELECT * FROM tags WHERE name = $1`, "list")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			return tag
+		}
+		getManifestLastPulledAt := func(manifestDigest digest.Digest) *int64 {
+			t.Helper()
+			var lastPulledAt *time.Time
+			err := s.DB.QueryRow(`SELECT last_pulled_at FROM manifests WHERE digest = $1`, manifestDigest).Scan(&lastPulledAt)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			return keppel.MaybeTimeToUnix(lastPulledAt)
+		}
+		unixNow := func() *int64 {
+			now := s.Clock.Now().Unix()
+			return &now
+		}
+
+		// pulling a submanifest by digest counts as a pull of the list manifest's tags
+		// (but separately from pulls of the tag itself)
+		s.Clock.StepBy(time.Hour)
+		expectManifestExists(t, h, token, "test1/foo", image1.Manifest, "", nil)
+		tag := getTag()
+		assert.DeepEqual(t, "tag last_pulled_at", keppel.MaybeTimeToUnix(tag.LastPulledAt), unixNow())
+		assert.DeepEqual(t, "tag pull_count", tag.PullCount, uint64(0))
+		assert.DeepEqual(t, "tag child_pull_count", tag.ChildPullCount, uint64(2))
+		assert.DeepEqual(t, "submanifest last_pulled_at", getManifestLastPulledAt(image1.Manifest.Digest), unixNow())
+
+		// the list manifest itself and the sibling submanifest are not affected
+		assert.DeepEqual(t, "list manifest last_pulled_at", getManifestLastPulledAt(list.Manifest.Digest), (*int64)(nil))
+		assert.DeepEqual(t, "sibling submanifest last_pulled_at", getManifestLastPulledAt(image2.Manifest.Digest), (*int64)(nil))
+
+		// pulling the tag itself is counted separately
+		s.Clock.StepBy(time.Hour)
+		expectManifestExists(t, h, token, "test1/foo", list.Manifest, "list", nil)
+		tag = getTag()
+		assert.DeepEqual(t, "tag last_pulled_at", keppel.MaybeTimeToUnix(tag.LastPulledAt), unixNow())
+		assert.DeepEqual(t, "tag pull_count", tag.PullCount, uint64(2))
+		assert.DeepEqual(t, "tag child_pull_count", tag.ChildPullCount, uint64(2))
+
+		// HEAD requests do not count as pulls
+		s.Clock.StepBy(time.Hour)
+		assert.HTTPRequest{
+			Method:       "HEAD",
+			Path:         "/v2/test1/foo/manifests/" + image2.Manifest.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+		tag = getTag()
+		assert.DeepEqual(t, "tag last_pulled_at", tag.LastPulledAt.Unix(), s.Clock.Now().Add(-time.Hour).Unix())
+		assert.DeepEqual(t, "tag child_pull_count", tag.ChildPullCount, uint64(2))
+
+		// moving the tag resets its pull counters
+		s.Clock.StepBy(time.Second)
+		image2.MustUpload(t, s, fooRepoRef, "list")
+		tag = getTag()
+		assert.DeepEqual(t, "tag pull_count", tag.PullCount, uint64(0))
+		assert.DeepEqual(t, "tag child_pull_count", tag.ChildPullCount, uint64(0))
+	})
+}
+
 func TestManifestQuotaExceeded(t *testing.T) {
 	testWithPrimary(t, nil, func(s test.Setup) {
 		h := s.Handler
@@ -513,6 +650,7 @@ func TestManifestQuotaExceeded(t *testing.T) {
 		quotaExceededMessage := test.ErrorCodeWithMessage{
 			Code:    keppel.ErrDenied,
 			Message: "manifest quota exceeded (quota = 1, usage = 2)",
+			Detail:  keppel.QuotaExceededDetail(1, 2),
 		}
 
 		// further blob uploads are not possible now
@@ -538,6 +676,89 @@ func TestManifestQuotaExceeded(t *testing.T) {
 	})
 }
 
+func TestManifestStorageQuotaExceeded(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		// as a setup, upload the blobs of an image, and subscribe to quota notifications
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.Layers[0].MustUpload(t, s, fooRepoRef)
+		image.Config.MustUpload(t, s, fooRepoRef)
+		err := s.DB.Insert(&models.Webhook{
+			AccountName: "test1",
+			RepoPattern: "bar",
+			URL:         "https://hooks.example.com/quota",
+			EventTypes:  "storage_quota_exceeded",
+			CreatedAt:   s.Clock.Now(),
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expectQuotaExceededAt := func(expected *time.Time) {
+			t.Helper()
+			account, err := keppel.FindAccount(s.DB, "test1")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "storage_quota_exceeded_at",
+				keppel.MaybeTimeToUnix(account.StorageQuotaExceededAt), keppel.MaybeTimeToUnix(expected))
+		}
+		expectDeliveryCount := func(expected int64) {
+			t.Helper()
+			actual, err := s.DB.SelectInt(`SELECT COUNT(*) FROM webhook_deliveries`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "webhook delivery count", actual, expected)
+		}
+
+		// when the storage backend rejects writes because of its quota, pushes
+		// fail with a specific error instead of a generic 500
+		s.SD.SimulateQuotaExceeded = true
+		quotaExceededMessage := test.ErrorCodeWithMessage{
+			Code:    keppel.ErrDenied,
+			Message: "quota exceeded in storage backend: write failed as requested",
+			Detail:  keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonStorageQuotaExceeded},
+		}
+		assert.HTTPRequest{
+			Method:       "PUT",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token, "Content-Type": image.Manifest.MediaType},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusInsufficientStorage,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   quotaExceededMessage,
+		}.Check(t, h)
+
+		// the account is marked as degraded, and the account's webhooks are
+		// notified (the repository pattern does not apply to this event type)
+		firstFailureAt := s.Clock.Now()
+		expectQuotaExceededAt(&firstFailureAt)
+		expectDeliveryCount(1)
+
+		// repeated failures do not cause repeated notifications
+		s.Clock.StepBy(time.Minute)
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/v2/test1/foo/blobs/uploads/?digest=" + test.GenerateExampleLayer(2).Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			Body:         assert.ByteData(test.GenerateExampleLayer(2).Contents),
+			ExpectStatus: http.StatusInsufficientStorage,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   quotaExceededMessage,
+		}.Check(t, h)
+		expectQuotaExceededAt(&firstFailureAt)
+		expectDeliveryCount(1)
+
+		// the next successful push clears the mark
+		s.SD.SimulateQuotaExceeded = false
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		expectQuotaExceededAt(nil)
+	})
+}
+
 func TestManifestRequiredLabels(t *testing.T) {
 	testWithPrimary(t, nil, func(s test.Setup) {
 		h := s.Handler
@@ -572,6 +793,10 @@ func TestManifestRequiredLabels(t *testing.T) {
 			ExpectBody: test.ErrorCodeWithMessage{
 				Code:    keppel.ErrManifestInvalid,
 				Message: "missing required labels: somethingelse, andalsothis",
+				Detail: keppel.RegistryV2ErrorDetail{
+					Reason:        keppel.ReasonMissingRequiredLabels,
+					MissingLabels: []string{"somethingelse", "andalsothis"},
+				},
 			},
 		}.Check(t, h)
 
@@ -634,134 +859,1092 @@ func TestManifestRequiredLabels(t *testing.T) {
 	})
 }
 
-func expectLabelsJSONOnManifest(t *testing.T, db *keppel.DB, manifestDigest digest.Digest, expected map[string]string) {
-	t.Helper()
-	labelsJSONStr, err := db.SelectStr(`SELECT labels_json FROM manifests WHERE digest = $1`, manifestDigest.String())
-	if err != nil {
-		t.Fatal(err.Error())
-	}
-
-	var actual map[string]string
-	err = json.Unmarshal([]byte(labelsJSONStr), &actual)
-	if err != nil {
-		t.Fatal(err.Error())
-	}
-	assert.DeepEqual(t, "labels_json", actual, expected)
-}
-
-func TestImageManifestWrongBlobSize(t *testing.T) {
+func TestManifestValidationWarnings(t *testing.T) {
 	testWithPrimary(t, nil, func(s test.Setup) {
 		h := s.Handler
 		token := s.GetToken(t, "repository:test1/foo:pull,push")
 
-		// generate an image that references a layer, but the reference includes the wrong layer size
-		layer := test.GenerateExampleLayer(1)
-		layer.MustUpload(t, s, fooRepoRef)
-
-		layer.Contents = append(layer.Contents, []byte("something")...)
-		image := test.GenerateImage(layer)
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
 		image.Config.MustUpload(t, s, fooRepoRef)
+		image.Layers[0].MustUpload(t, s, fooRepoRef)
+
+		_, err := s.DB.Exec(
+			`UPDATE accounts SET recommended_annotations = $1 WHERE name = $2`,
+			"org.opencontainers.image.source", "test1",
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
 
+		// manifest push succeeds despite the warning, but the warning is reported
+		warning := "missing recommended annotations: org.opencontainers.image.source"
 		assert.HTTPRequest{
 			Method: "PUT",
 			Path:   "/v2/test1/foo/manifests/latest",
 			Header: map[string]string{
 				"Authorization": "Bearer " + token,
-				"Content-Type":  image.Manifest.MediaType,
+				"Content-Type":  manifest.DockerV2Schema2MediaType,
 			},
 			Body:         assert.ByteData(image.Manifest.Contents),
-			ExpectStatus: http.StatusBadRequest,
-			ExpectBody:   test.ErrorCode(keppel.ErrManifestInvalid),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey:         test.VersionHeaderValue,
+				"X-Keppel-Validation-Warning": warning,
+			},
 		}.Check(t, h)
-	})
-}
-
-func TestImageManifestCmdEntrypointAsString(t *testing.T) {
-	testWithPrimary(t, nil, func(s test.Setup) {
-		j := tasks.NewJanitor(s.Config, s.FD, s.SD, s.ICD, s.DB, s.AMD, s.Auditor).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next)
-		j.DisableJitter()
-		validateManifestJob := j.ManifestValidationJob(s.Registry)
-
-		// generate an image that has strings as Entrypoint and Cmd
-		image := test.GenerateImageWithCustomConfig(func(cfg map[string]any) {
-			cfg["config"].(map[string]any)["Cmd"] = "/usr/bin/env bash"
-		}, test.GenerateExampleLayer(1))
-		image.MustUpload(t, s, fooRepoRef, "first")
 
-		s.Clock.StepBy(36 * time.Hour)
-		err := validateManifestJob.ProcessOne(s.Ctx)
+		// the warning is also stored for display in the API
+		warningsJSON, err := s.DB.SelectStr(`SELECT validation_warnings_json FROM manifests WHERE digest = $1`, image.Manifest.Digest.String())
 		if err != nil {
-			t.Error("expected err = nil, but got: " + err.Error())
+			t.Fatal(err.Error())
 		}
+		assert.DeepEqual(t, "validation_warnings_json", warningsJSON, `["`+warning+`"]`)
 	})
 }
 
-func TestManifestAnnotations(t *testing.T) {
+func TestManifestAdmissionPolicies(t *testing.T) {
 	testWithPrimary(t, nil, func(s test.Setup) {
 		h := s.Handler
 		token := s.GetToken(t, "repository:test1/foo:pull,push")
 
-		image := test.GenerateOCIImage(test.OCIArgs{
-			ConfigMediaType: imgspecv1.MediaTypeImageManifest,
-			Annotations: map[string]string{
-				"abc": "def",
-			}},
-		)
-		image.MustUpload(t, s, fooRepoRef, "latest")
+		image := test.GenerateImageWithCustomConfig(func(cfg map[string]any) {
+			cfg["config"].(map[string]any)["Labels"] = map[string]string{"maintainer": "someone@example.org"}
+		}, test.GenerateExampleLayer(1))
+		image.Config.MustUpload(t, s, fooRepoRef)
+		image.Layers[0].MustUpload(t, s, fooRepoRef)
 
-		// manifest push should succeed
+		setPolicies := func(policiesJSON string) {
+			t.Helper()
+			_, err := s.DB.Exec(`UPDATE accounts SET admission_policies_json = $1 WHERE name = $2`, policiesJSON, "test1")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+
+		// manifest push should fail if a policy is not satisfied...
+		setPolicies(`[{"name":"has-team","language":"cel","expression":"'team' in labels","message":"all images must have a team label"}]`)
 		assert.HTTPRequest{
 			Method: "PUT",
 			Path:   "/v2/test1/foo/manifests/latest",
 			Header: map[string]string{
 				"Authorization": "Bearer " + token,
-				"Content-Type":  imgspecv1.MediaTypeImageManifest,
+				"Content-Type":  manifest.DockerV2Schema2MediaType,
 			},
 			Body:         assert.ByteData(image.Manifest.Contents),
-			ExpectStatus: http.StatusCreated,
+			ExpectStatus: http.StatusForbidden,
 			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrDenied,
+				Message: `manifest was rejected by admission policy "has-team": all images must have a team label`,
+				Detail: keppel.RegistryV2ErrorDetail{
+					Reason:          keppel.ReasonAdmissionPolicy,
+					AdmissionPolicy: "has-team",
+				},
+			},
 		}.Check(t, h)
 
-		// check that the annotations_json field is populated correctly in the DB
-		labelsJSONStr, err := s.DB.SelectStr(`SELECT annotations_json FROM manifests WHERE digest = $1`, image.Manifest.Digest.String())
-		if err != nil {
-			t.Fatal(err.Error())
-		}
+		// ...or if the policy cannot be evaluated
+		setPolicies(`[{"name":"team-label","language":"cel","expression":"labels.team == 'foo'"}]`)
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  manifest.DockerV2Schema2MediaType,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusForbidden,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrDenied,
+				Message: `manifest was rejected by admission policy "team-label": no such key: "team"`,
+				Detail: keppel.RegistryV2ErrorDetail{
+					Reason:          keppel.ReasonAdmissionPolicy,
+					AdmissionPolicy: "team-label",
+				},
+			},
+		}.Check(t, h)
 
-		var actual map[string]string
-		must.Succeed(json.Unmarshal([]byte(labelsJSONStr), &actual))
-		assert.DeepEqual(t, "annotations_json", actual, map[string]string{"abc": "def"})
+		// manifest push should succeed if all policies are satisfied
+		setPolicies(`[{"name":"has-maintainer","language":"cel","expression":"has(labels.maintainer) && labels.maintainer.endsWith('@example.org')"},{"name":"repo-name","language":"cel","expression":"repository.name.startsWith('foo') && account.name == 'test1'"}]`)
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  manifest.DockerV2Schema2MediaType,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
 	})
 }
 
-func TestManifestArtifactType(t *testing.T) {
+func TestManifestQuarantine(t *testing.T) {
 	testWithPrimary(t, nil, func(s test.Setup) {
 		h := s.Handler
 		token := s.GetToken(t, "repository:test1/foo:pull,push")
 
-		artifactType := "application/vnd.oci.artifact.config.v1+json"
-		image := test.GenerateOCIImage(test.OCIArgs{ArtifactType: artifactType})
-		image.MustUpload(t, s, fooRepoRef, "latest")
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.Config.MustUpload(t, s, fooRepoRef)
+		image.Layers[0].MustUpload(t, s, fooRepoRef)
 
-		// manifest push should succeed
+		// an admission policy with action "quarantine" does not reject the push...
+		_, err := s.DB.Exec(
+			`UPDATE accounts SET admission_policies_json = $1 WHERE name = $2`,
+			`[{"name":"has-maintainer","language":"cel","expression":"has(labels.maintainer)","message":"needs review","action":"quarantine"}]`, "test1",
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
 		assert.HTTPRequest{
 			Method: "PUT",
 			Path:   "/v2/test1/foo/manifests/latest",
 			Header: map[string]string{
 				"Authorization": "Bearer " + token,
-				"Content-Type":  imgspecv1.MediaTypeImageManifest,
+				"Content-Type":  manifest.DockerV2Schema2MediaType,
 			},
 			Body:         assert.ByteData(image.Manifest.Contents),
 			ExpectStatus: http.StatusCreated,
 			ExpectHeader: test.VersionHeader,
 		}.Check(t, h)
 
-		// check that the annotations_json field is populated correctly in the DB
-		artifactTypeStr, err := s.DB.SelectStr(`SELECT artifact_type FROM manifests WHERE digest = $1`, image.Manifest.Digest.String())
+		// ...but the manifest cannot be pulled while it is quarantined
+		reason := `violates admission policy "has-maintainer": needs review`
+		for _, ref := range []string{"latest", image.Manifest.Digest.String()} {
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/manifests/" + ref,
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusForbidden,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody: test.ErrorCodeWithMessage{
+					Code:    keppel.ErrDenied,
+					Message: fmt.Sprintf("manifest %s is quarantined: %s", image.Manifest.Digest, reason),
+					Detail:  keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonManifestQuarantined},
+				},
+			}.Check(t, h)
+		}
+		// ...and neither can its blobs
+		layerPath := "/v2/test1/foo/blobs/" + image.Layers[0].Digest.String()
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         layerPath,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusForbidden,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrDenied,
+				Message: fmt.Sprintf("blob %s only belongs to quarantined manifests", image.Layers[0].Digest),
+				Detail:  keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonManifestQuarantined},
+			},
+		}.Check(t, h)
+
+		// pushing the manifest again does not release it from quarantine
+		_, err = s.DB.Exec(`UPDATE accounts SET admission_policies_json = '' WHERE name = $1`, "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  manifest.DockerV2Schema2MediaType,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+		quarantineReason, err := s.DB.SelectStr(`SELECT quarantine_reason FROM manifests WHERE digest = $1`, image.Manifest.Digest.String())
 		if err != nil {
 			t.Fatal(err.Error())
 		}
+		assert.DeepEqual(t, "quarantine_reason", quarantineReason, reason)
 
-		assert.DeepEqual(t, "artifact_type", artifactType, artifactTypeStr)
+		// after release, the manifest can be pulled again
+		_, err = s.DB.Exec(`UPDATE manifests SET quarantined_at = NULL, quarantine_reason = '' WHERE digest = $1`, image.Manifest.Digest.String())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         layerPath,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(image.Layers[0].Contents),
+		}.Check(t, h)
+	})
+}
+
+func TestManifestPromotionState(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull")
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		layerPath := "/v2/test1/foo/blobs/" + image.Layers[0].Digest.String()
+
+		// without a minimum promotion state, all manifests can be pulled
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, h)
+
+		// with a minimum promotion state, manifests below that state cannot be pulled
+		_, err := s.DB.Exec(`UPDATE accounts SET min_pull_promotion_state = $1 WHERE name = $2`, models.PromotionStateStaging, "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, state := range []models.PromotionState{models.PromotionStateNone, models.PromotionStateDev} {
+			_, err := s.DB.Exec(`UPDATE manifests SET promotion_state = $1 WHERE digest = $2`, state, image.Manifest.Digest.String())
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/manifests/latest",
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusForbidden,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody: test.ErrorCodeWithMessage{
+					Code:    keppel.ErrDenied,
+					Message: fmt.Sprintf("manifest %s has not been promoted to \"staging\" yet", image.Manifest.Digest),
+					Detail:  keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonManifestNotPromoted},
+				},
+			}.Check(t, h)
+			// the same applies to the blobs of these manifests
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         layerPath,
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusForbidden,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody: test.ErrorCodeWithMessage{
+					Code:    keppel.ErrDenied,
+					Message: fmt.Sprintf("blob %s only belongs to manifests that have not been promoted to \"staging\" yet", image.Layers[0].Digest),
+					Detail:  keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonManifestNotPromoted},
+				},
+			}.Check(t, h)
+		}
+
+		// manifests in the minimum state or above can be pulled
+		for _, state := range []models.PromotionState{models.PromotionStateStaging, models.PromotionStateProd} {
+			_, err := s.DB.Exec(`UPDATE manifests SET promotion_state = $1 WHERE digest = $2`, state, image.Manifest.Digest.String())
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/manifests/latest",
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusOK,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   assert.ByteData(image.Manifest.Contents),
+			}.Check(t, h)
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         layerPath,
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusOK,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   assert.ByteData(image.Layers[0].Contents),
+			}.Check(t, h)
+		}
+	})
+}
+
+func TestManifestKeppelLabelRestriction(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		layerPath := "/v2/test1/foo/blobs/" + image.Layers[0].Digest.String()
+
+		// anonymous users can only pull manifests with the "approved-for-prod" label...
+		_, err := s.DB.Exec(`UPDATE accounts SET rbac_policies_json = $2 WHERE name = $1`, "test1",
+			test.ToJSON([]keppel.RBACPolicy{{
+				RepositoryPattern: "foo",
+				KeppelLabels:      map[string]string{"approved-for-prod": "true"},
+				Permissions:       []keppel.RBACPermission{keppel.RBACAnonymousPullPermission},
+			}}),
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			ExpectStatus: http.StatusForbidden,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrDenied,
+				Message: fmt.Sprintf("manifest %s does not have the Keppel labels required for pulling it", image.Manifest.Digest),
+			},
+		}.Check(t, h)
+		// ...and the blobs belonging to them
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         layerPath,
+			ExpectStatus: http.StatusForbidden,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrDenied,
+				Message: fmt.Sprintf("blob %s does not belong to a manifest with the Keppel labels required for pulling it", image.Layers[0].Digest),
+			},
+		}.Check(t, h)
+
+		// regular users with pull permission are not affected by this
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, h)
+
+		// once the label is set, anonymous users can pull
+		_, err = s.DB.Exec(`INSERT INTO manifest_keppel_labels (repo_id, digest, name, value) VALUES (1, $1, $2, $3)`,
+			image.Manifest.Digest.String(), "approved-for-prod", "true")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         layerPath,
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(image.Layers[0].Contents),
+		}.Check(t, h)
+	})
+}
+
+func TestManifestAdmissionWebhook(t *testing.T) {
+	var (
+		lastReview keppel.AdmissionReview
+		response   string
+	)
+	webhook := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer admission-webhook-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		err := json.NewDecoder(r.Body).Decode(&lastReview)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if response == "" {
+			http.Error(w, "policy service is down", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response)) //nolint:errcheck
+	})
+
+	for _, failOpen := range []bool{false, true} {
+		opts := []test.SetupOption{test.WithAdmissionWebhook(webhook, failOpen)}
+		testWithPrimary(t, opts, func(s test.Setup) {
+			h := s.Handler
+			token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+			image := test.GenerateImage(test.GenerateExampleLayer(1))
+			image.Config.MustUpload(t, s, fooRepoRef)
+			image.Layers[0].MustUpload(t, s, fooRepoRef)
+			pushRequest := assert.HTTPRequest{
+				Method: "PUT",
+				Path:   "/v2/test1/foo/manifests/latest",
+				Header: map[string]string{
+					"Authorization": "Bearer " + token,
+					"Content-Type":  manifest.DockerV2Schema2MediaType,
+				},
+				Body:         assert.ByteData(image.Manifest.Contents),
+				ExpectHeader: test.VersionHeader,
+			}
+
+			// when the webhook denies the push, it fails
+			response = `{"decision":"deny","message":"image is not signed"}`
+			req := pushRequest
+			req.ExpectStatus = http.StatusForbidden
+			req.ExpectBody = test.ErrorCodeWithMessage{
+				Code:    keppel.ErrDenied,
+				Message: "manifest was rejected by admission webhook: image is not signed",
+				Detail:  keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonAdmissionWebhook},
+			}
+			req.Check(t, h)
+
+			// the webhook is shown all relevant information about the push
+			assert.DeepEqual(t, "admission review", lastReview, keppel.AdmissionReview{
+				Account: keppel.AdmissionReviewAccount{
+					Name:         "test1",
+					AuthTenantID: authTenantID,
+				},
+				Repository: "test1/foo",
+				Tag:        "latest",
+				Manifest: keppel.AdmissionReviewManifest{
+					Digest:    image.Manifest.Digest.String(),
+					MediaType: manifest.DockerV2Schema2MediaType,
+					SizeBytes: image.SizeBytes(),
+				},
+				Actor: keppel.AdmissionReviewActor{Type: "regular", Name: "correctusername"},
+			})
+
+			// when the webhook fails, the push fails or succeeds depending on configuration
+			response = ""
+			if failOpen {
+				req = pushRequest
+				req.ExpectStatus = http.StatusCreated
+				req.Check(t, h)
+			} else {
+				req = pushRequest
+				req.ExpectStatus = http.StatusServiceUnavailable
+				req.ExpectBody = test.ErrorCode(keppel.ErrUnavailable)
+				req.Check(t, h)
+			}
+
+			// when the webhook decides to quarantine, the push succeeds, but the manifest is quarantined
+			response = `{"decision":"quarantine","message":"needs manual review"}`
+			req = pushRequest
+			req.ExpectStatus = http.StatusCreated
+			req.Check(t, h)
+			quarantineReason, err := s.DB.SelectStr(`SELECT quarantine_reason FROM manifests WHERE digest = $1`, image.Manifest.Digest.String())
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "quarantine_reason", quarantineReason, "quarantined by admission webhook: needs manual review")
+
+			// when the webhook allows the push, it succeeds
+			_, err = s.DB.Exec(`UPDATE manifests SET quarantined_at = NULL, quarantine_reason = ''`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			response = `{"decision":"allow"}`
+			req = pushRequest
+			req.ExpectStatus = http.StatusCreated
+			req.Check(t, h)
+			quarantineReason, err = s.DB.SelectStr(`SELECT quarantine_reason FROM manifests WHERE digest = $1`, image.Manifest.Digest.String())
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "quarantine_reason", quarantineReason, "")
+		})
+	}
+}
+
+func expectLabelsJSONOnManifest(t *testing.T, db *keppel.DB, manifestDigest digest.Digest, expected map[string]string) {
+	t.Helper()
+	labelsJSONStr, err := db.SelectStr(`SELECT labels_json FROM manifests WHERE digest = $1`, manifestDigest.String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var actual map[string]string
+	err = json.Unmarshal([]byte(labelsJSONStr), &actual)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "labels_json", actual, expected)
+}
+
+func TestImageManifestWrongBlobSize(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		// generate an image that references a layer, but the reference includes the wrong layer size
+		layer := test.GenerateExampleLayer(1)
+		layer.MustUpload(t, s, fooRepoRef)
+
+		layer.Contents = append(layer.Contents, []byte("something")...)
+		image := test.GenerateImage(layer)
+		image.Config.MustUpload(t, s, fooRepoRef)
+
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  image.Manifest.MediaType,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   test.ErrorCode(keppel.ErrManifestInvalid),
+		}.Check(t, h)
+	})
+}
+
+func TestImageManifestCmdEntrypointAsString(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		j := tasks.NewJanitor(s.Config, s.FD, s.SD, s.ICD, s.SecD, s.BD, s.CDN, s.NVD, s.DB, s.AMD, s.Auditor).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next)
+		j.DisableJitter()
+		validateManifestJob := j.ManifestValidationJob(s.Registry)
+
+		// generate an image that has strings as Entrypoint and Cmd
+		image := test.GenerateImageWithCustomConfig(func(cfg map[string]any) {
+			cfg["config"].(map[string]any)["Cmd"] = "/usr/bin/env bash"
+		}, test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "first")
+
+		s.Clock.StepBy(36 * time.Hour)
+		err := validateManifestJob.ProcessOne(s.Ctx)
+		if err != nil {
+			t.Error("expected err = nil, but got: " + err.Error())
+		}
+	})
+}
+
+func TestManifestAnnotations(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		image := test.GenerateOCIImage(test.OCIArgs{
+			ConfigMediaType: imgspecv1.MediaTypeImageManifest,
+			Annotations: map[string]string{
+				"abc": "def",
+			}},
+		)
+		image.MustUpload(t, s, fooRepoRef, "latest")
+
+		// manifest push should succeed
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  imgspecv1.MediaTypeImageManifest,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+
+		// check that the annotations_json field is populated correctly in the DB
+		labelsJSONStr, err := s.DB.SelectStr(`SELECT annotations_json FROM manifests WHERE digest = $1`, image.Manifest.Digest.String())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var actual map[string]string
+		must.Succeed(json.Unmarshal([]byte(labelsJSONStr), &actual))
+		assert.DeepEqual(t, "annotations_json", actual, map[string]string{"abc": "def"})
+	})
+}
+
+func TestManifestArtifactType(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		artifactType := "application/vnd.oci.artifact.config.v1+json"
+		image := test.GenerateOCIImage(test.OCIArgs{ArtifactType: artifactType})
+		image.MustUpload(t, s, fooRepoRef, "latest")
+
+		// manifest push should succeed
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  imgspecv1.MediaTypeImageManifest,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+
+		// check that the annotations_json field is populated correctly in the DB
+		artifactTypeStr, err := s.DB.SelectStr(`SELECT artifact_type FROM manifests WHERE digest = $1`, image.Manifest.Digest.String())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		assert.DeepEqual(t, "artifact_type", artifactType, artifactTypeStr)
+	})
+}
+
+func TestManifestPushNamespaceQuota(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		repo := models.Repository{AccountName: "test1", Name: "team-a/app"}
+		token := s.GetToken(t, "repository:test1/team-a/app:pull,push")
+
+		zero := uint64(0)
+		err := s.DB.Insert(&models.RepositoryNamespace{AccountName: "test1", Prefix: "team-a", ManifestQuota: &zero})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.Config.MustUpload(t, s, repo)
+		image.Layers[0].MustUpload(t, s, repo)
+
+		// pushing into the namespace fails because the namespace quota is exhausted...
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/team-a/app/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  manifest.DockerV2Schema2MediaType,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusConflict,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrDenied,
+				Message: `manifest quota of namespace "team-a" exceeded (quota = 0, usage = 0)`,
+				Detail:  keppel.QuotaExceededDetail(0, 0),
+			},
+		}.Check(t, h)
+
+		// ...but pushing outside of the namespace is not affected
+		image.Config.MustUpload(t, s, fooRepoRef)
+		image.Layers[0].MustUpload(t, s, fooRepoRef)
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + s.GetToken(t, "repository:test1/foo:pull,push"),
+				"Content-Type":  manifest.DockerV2Schema2MediaType,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+	})
+}
+
+func TestCacheControlHeaders(t *testing.T) {
+	policy := keppel.CachePolicy{DigestMaxAge: time.Hour, TagMaxAge: 30 * time.Second}
+	testWithPrimary(t, []test.SetupOption{test.WithCachePolicy(policy)}, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+
+		// digest-addressed content is immutable
+		for _, method := range []string{"GET", "HEAD"} {
+			assert.HTTPRequest{
+				Method:       method,
+				Path:         "/v2/test1/foo/blobs/" + image.Config.Digest.String(),
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusOK,
+				ExpectHeader: map[string]string{
+					"Cache-Control": "private, max-age=3600, immutable",
+				},
+			}.Check(t, h)
+			assert.HTTPRequest{
+				Method:       method,
+				Path:         "/v2/test1/foo/manifests/" + image.Manifest.Digest.String(),
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusOK,
+				ExpectHeader: map[string]string{
+					"Cache-Control": "private, max-age=3600, immutable",
+				},
+			}.Check(t, h)
+		}
+
+		// tag-addressed manifests can only be cached for a short time
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{
+				"Cache-Control": "private, max-age=30",
+				"Vary":          "Accept, X-Keppel-Platform",
+			},
+		}.Check(t, h)
+
+		// errors are not cached
+		resp, _ := assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/unknown",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusNotFound,
+		}.Check(t, h)
+		assert.DeepEqual(t, "Cache-Control header", resp.Header.Get("Cache-Control"), "")
+	})
+}
+
+func TestArchivedRepository(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		_, err := s.DB.Exec(`UPDATE repos SET is_archived = TRUE WHERE account_name = $1 AND name = $2`, "test1", "foo")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// pulls still work in archived repos...
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, h)
+
+		// ...but pushes are rejected
+		expectedError := test.ErrorCodeWithMessage{
+			Code:    keppel.ErrUnsupported,
+			Message: "repository is archived",
+			Detail:  keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonRepositoryArchived},
+		}
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/other",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  image.Manifest.MediaType,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusMethodNotAllowed,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   expectedError,
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/v2/test1/foo/blobs/uploads/",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusMethodNotAllowed,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   expectedError,
+		}.Check(t, h)
+
+		// deletions are rejected as well
+		deleteToken := s.GetToken(t, "repository:test1/foo:delete")
+		for _, ref := range []string{"latest", image.Manifest.Digest.String()} {
+			assert.HTTPRequest{
+				Method:       "DELETE",
+				Path:         "/v2/test1/foo/manifests/" + ref,
+				Header:       map[string]string{"Authorization": "Bearer " + deleteToken},
+				ExpectStatus: http.StatusMethodNotAllowed,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   expectedError,
+			}.Check(t, h)
+		}
+
+		// after unarchiving, pushes work again
+		_, err = s.DB.Exec(`UPDATE repos SET is_archived = FALSE WHERE account_name = $1 AND name = $2`, "test1", "foo")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/other",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  image.Manifest.MediaType,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+	})
+}
+
+func TestConcurrentTagPush(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		images := make([]test.Image, 3)
+		for idx := range images {
+			images[idx] = test.GenerateImage(test.GenerateExampleLayer(int64(idx + 1)))
+			images[idx].MustUpload(t, s, fooRepoRef, "")
+		}
+		pushTag := func(image test.Image, expectedTagDigest string) {
+			t.Helper()
+			assert.HTTPRequest{
+				Method: "PUT",
+				Path:   "/v2/test1/foo/manifests/latest",
+				Header: map[string]string{
+					"Authorization": "Bearer " + token,
+					"Content-Type":  manifest.DockerV2Schema2MediaType,
+				},
+				Body:         assert.ByteData(image.Manifest.Contents),
+				ExpectStatus: http.StatusCreated,
+				ExpectHeader: map[string]string{
+					test.VersionHeaderKey:   test.VersionHeaderValue,
+					"Docker-Content-Digest": image.Manifest.Digest.String(),
+					"X-Keppel-Tag-Digest":   expectedTagDigest,
+				},
+			}.Check(t, h)
+		}
+		expectTag := func(expectedDigest string, expectedPushedAt time.Time) {
+			t.Helper()
+			var tag models.Tag
+			err := s.DB.SelectOne(&tag, `SELECT * FROM tags WHERE name = $1`, "latest")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "tag digest", tag.Digest.String(), expectedDigest)
+			assert.DeepEqual(t, "tag pushed_at", tag.PushedAt.Unix(), expectedPushedAt.Unix())
+		}
+
+		// regular push of a tag
+		s.Clock.StepBy(time.Second)
+		firstPushedAt := s.Clock.Now()
+		pushTag(images[0], images[0].Manifest.Digest.String())
+		expectTag(images[0].Manifest.Digest.String(), firstPushedAt)
+
+		// pushing the same manifest again is idempotent
+		s.Clock.StepBy(time.Second)
+		pushTag(images[0], images[0].Manifest.Digest.String())
+		expectTag(images[0].Manifest.Digest.String(), firstPushedAt)
+
+		// simulate a concurrent push that moves the tag while the next push is
+		// being processed (i.e. after the next push looked at the tag, but before
+		// it locked the tag): the next push fails with a conflict
+		s.Clock.StepBy(time.Second)
+		winnerPushedAt := s.Clock.Now()
+		s.SD.BeforeReadBlob = func() {
+			_, err := s.DB.Exec(`UPDATE tags SET digest = $1, pushed_at = $2 WHERE name = $3`,
+				images[1].Manifest.Digest.String(), winnerPushedAt, "latest")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  manifest.DockerV2Schema2MediaType,
+			},
+			Body:         assert.ByteData(images[2].Manifest.Contents),
+			ExpectStatus: http.StatusConflict,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"X-Keppel-Tag-Digest": images[1].Manifest.Digest.String(),
+			},
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrDenied,
+				Message: fmt.Sprintf(`tag "latest" was moved to %s by a concurrent push while this push was being processed`, images[1].Manifest.Digest),
+			},
+		}.Check(t, h)
+		s.SD.BeforeReadBlob = nil
+		expectTag(images[1].Manifest.Digest.String(), winnerPushedAt)
+
+		// a push that starts afterwards moves the tag as usual
+		s.Clock.StepBy(time.Hour)
+		pushTag(images[2], images[2].Manifest.Digest.String())
+		expectTag(images[2].Manifest.Digest.String(), s.Clock.Now())
+	})
+}
+
+func TestDeleteProtectedTag(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		image := test.GenerateImage( /* no layers */ )
+		image.MustUpload(t, s, fooRepoRef, "release-1")
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		_, err := s.DB.Exec(`UPDATE accounts SET tag_protection_policies_json = $1 WHERE name = $2`, `[{"match_tag":"release-.*"}]`, "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		deleteToken := s.GetToken(t, "repository:test1/foo:delete")
+
+		// neither the protected tag nor its manifest can be deleted by users below account-admin level
+		for _, ref := range []string{"release-1", image.Manifest.Digest.String()} {
+			assert.HTTPRequest{
+				Method:       "DELETE",
+				Path:         "/v2/test1/foo/manifests/" + ref,
+				Header:       map[string]string{"Authorization": "Bearer " + deleteToken},
+				ExpectStatus: http.StatusForbidden,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody: test.ErrorCodeWithMessage{
+					Code:    keppel.ErrDenied,
+					Message: `tag "release-1" is protected from deletion by a tag protection policy (match_tag: "release-.*")`,
+					Detail: keppel.RegistryV2ErrorDetail{
+						Reason:              keppel.ReasonTagProtected,
+						TagProtectionPolicy: &keppel.TagProtectionPolicy{TagRx: "release-.*"},
+					},
+				},
+			}.Check(t, h)
+		}
+
+		// other tags can still be deleted
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + deleteToken},
+			ExpectStatus: http.StatusAccepted,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+	})
+}
+
+func TestImmutableTags(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		images := make([]test.Image, 2)
+		for idx := range images {
+			images[idx] = test.GenerateImage(test.GenerateExampleLayer(int64(idx + 1)))
+		}
+		images[0].MustUpload(t, s, fooRepoRef, "v1.0")
+		images[1].MustUpload(t, s, fooRepoRef, "")
+		policyJSON := `[{"match_tag":"v[0-9]+\\..*","immutable":true}]`
+		_, err := s.DB.Exec(`UPDATE accounts SET tag_protection_policies_json = $1 WHERE name = $2`, policyJSON, "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		token := s.GetToken(t, "repository:test1/foo:pull,push,delete")
+		expectedDetail := keppel.RegistryV2ErrorDetail{
+			Reason:              keppel.ReasonTagImmutable,
+			TagProtectionPolicy: &keppel.TagProtectionPolicy{TagRx: `v[0-9]+\..*`, Immutable: true},
+		}
+		pushRequest := func(image test.Image, tagName string) assert.HTTPRequest {
+			return assert.HTTPRequest{
+				Method: "PUT",
+				Path:   "/v2/test1/foo/manifests/" + tagName,
+				Header: map[string]string{
+					"Authorization": "Bearer " + token,
+					"Content-Type":  manifest.DockerV2Schema2MediaType,
+				},
+				Body:         assert.ByteData(image.Manifest.Contents),
+				ExpectHeader: test.VersionHeader,
+			}
+		}
+
+		// the immutable tag cannot be moved to a different manifest
+		req := pushRequest(images[1], "v1.0")
+		req.ExpectStatus = http.StatusForbidden
+		req.ExpectBody = test.ErrorCodeWithMessage{
+			Code:    keppel.ErrDenied,
+			Message: `tag "v1.0" is immutable and cannot be overwritten (match_tag: "v[0-9]+\\..*")`,
+			Detail:  expectedDetail,
+		}
+		req.Check(t, h)
+		expectTagDigest := func(tagName string, expected test.Image) {
+			t.Helper()
+			digestStr, err := s.DB.SelectStr(`SELECT digest FROM tags WHERE name = $1`, tagName)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "digest of tag "+tagName, digestStr, expected.Manifest.Digest.String())
+		}
+		expectTagDigest("v1.0", images[0])
+
+		// pushing the same manifest again is fine, as is pushing new tags
+		req = pushRequest(images[0], "v1.0")
+		req.ExpectStatus = http.StatusCreated
+		req.Check(t, h)
+		for _, tagName := range []string{"v1.1", "latest"} {
+			req = pushRequest(images[1], tagName)
+			req.ExpectStatus = http.StatusCreated
+			req.Check(t, h)
+			expectTagDigest(tagName, images[1])
+		}
+
+		// immutable tags cannot be deleted, neither by themselves nor along with their manifest
+		for _, ref := range []string{"v1.0", images[0].Manifest.Digest.String()} {
+			assert.HTTPRequest{
+				Method:       "DELETE",
+				Path:         "/v2/test1/foo/manifests/" + ref,
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusForbidden,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody: test.ErrorCodeWithMessage{
+					Code:    keppel.ErrDenied,
+					Message: `tag "v1.0" is immutable and cannot be deleted (match_tag: "v[0-9]+\\..*")`,
+					Detail:  expectedDetail,
+				},
+			}.Check(t, h)
+		}
+		expectTagDigest("v1.0", images[0])
+	})
+}
+
+func TestManifestContentEncryption(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		s.SecD.Secrets[authTenantID+"/secret/data-key"] = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x42}, 32))
+		_, err := s.DB.Exec(`UPDATE accounts SET content_encryption_key_ref = $1 WHERE name = $2`, "secret/data-key", "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// manifest contents are stored encrypted in the DB...
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		var content models.ManifestContent
+		err = s.DB.SelectOne(&content, `SELECT * FROM manifest_contents WHERE digest = $1`, image.Manifest.Digest.String())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "encryption key ref", content.EncryptionKeyRef, "secret/data-key")
+		if bytes.Contains(content.Content, image.Manifest.Contents) {
+			t.Error("expected manifest contents to be encrypted in the DB, but found plaintext")
+		}
+
+		// ...and in the storage backend
+		stored, err := s.SD.ReadManifest(s.Ctx, models.ReducedAccount{Name: "test1"}, "foo", image.Manifest.Digest)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if bytes.Contains(stored, image.Manifest.Contents) {
+			t.Error("expected manifest contents to be encrypted in the storage, but found plaintext")
+		}
+		s.ExpectManifestsExistInStorage(t, "foo", models.Manifest{RepositoryID: 1, Digest: image.Manifest.Digest})
+
+		// ...but are served in plaintext (remove the manifest from the storage to
+		// ensure that the contents come from the DB)
+		err = s.SD.DeleteManifest(s.Ctx, models.ReducedAccount{Name: "test1"}, "foo", image.Manifest.Digest)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, s.Handler)
+
+		// contents that were written before encryption was enabled remain readable
+		_, err = s.DB.Exec(`UPDATE accounts SET content_encryption_key_ref = '' WHERE name = $1`, "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, s.Handler)
 	})
 }
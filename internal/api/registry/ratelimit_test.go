@@ -118,6 +118,84 @@ func TestRateLimits(t *testing.T) {
 	})
 }
 
+func TestRateLimitExemptions(t *testing.T) {
+	// only one request per hour, so that the budget does not recover while we
+	// wait for exemptions to be reloaded
+	limit := redis_rate.Limit{Rate: 1, Period: time.Hour, Burst: 1}
+	rld := basic.RateLimitDriver{
+		Limits: map[keppel.RateLimitedAction]redis_rate.Limit{
+			keppel.ManifestPullAction: limit,
+		},
+	}
+	rle := &keppel.RateLimitEngine{Driver: rld, Client: nil}
+	setupOptions := []test.SetupOption{
+		test.WithRateLimitEngine(rle),
+	}
+
+	testWithPrimary(t, setupOptions, func(s test.Setup) {
+		_, err := keppel.FindOrCreateRepository(s.DB, "foo", models.AccountName("test1"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		req := assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/test1/foo/manifests/" + test.DeterministicDummyDigest(1).String(),
+			Header: map[string]string{
+				"Authorization":   "Bearer " + token,
+				"X-Forwarded-For": "198.51.100.10",
+			},
+			ExpectStatus: http.StatusNotFound,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrManifestUnknown),
+		}
+		failingReq := req
+		failingReq.ExpectStatus = http.StatusTooManyRequests
+		failingReq.ExpectHeader = nil
+		failingReq.ExpectBody = test.ErrorCode(keppel.ErrTooManyRequests)
+
+		// exhaust the rate limit
+		s.Clock.StepBy(time.Hour)
+		req.Check(t, h)
+		failingReq.Check(t, h)
+
+		// exemptions that do not match the request have no effect
+		mustInsertRateLimitExemption(t, s, models.RateLimitExemption{
+			AccountName: "test1",
+			CIDR:        "203.0.113.0/24",
+			CreatedAt:   s.Clock.Now(),
+		})
+		mustInsertRateLimitExemption(t, s, models.RateLimitExemption{
+			AccountName: "test2",
+			CIDR:        "198.51.100.0/24",
+			CreatedAt:   s.Clock.Now(),
+		})
+		s.Clock.StepBy(keppel.RateLimitExemptionRefreshInterval)
+		failingReq.Check(t, h)
+
+		// a matching exemption takes effect once the exemptions are reloaded
+		mustInsertRateLimitExemption(t, s, models.RateLimitExemption{
+			AccountName: "test1",
+			CIDR:        "198.51.100.0/24",
+			CreatedAt:   s.Clock.Now(),
+		})
+		failingReq.Check(t, h)
+		s.Clock.StepBy(keppel.RateLimitExemptionRefreshInterval)
+		req.Check(t, h)
+		req.Check(t, h)
+	})
+}
+
+func mustInsertRateLimitExemption(t *testing.T, s test.Setup, exemption models.RateLimitExemption) {
+	t.Helper()
+	err := s.DB.Insert(&exemption)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
 func TestAnycastRateLimits(t *testing.T) {
 	blob := test.NewBytes([]byte("the blob for our test case"))
 
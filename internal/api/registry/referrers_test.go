@@ -51,3 +51,53 @@ func TestReferrersApi(t *testing.T) {
 		}.Check(t, h)
 	})
 }
+
+func TestDeleteManifestCascadesToReferrers(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push,delete")
+
+		// setup an image with a signature, which is itself signed, and an unrelated image
+		image := test.GenerateOCIImage(test.OCIArgs{
+			ConfigMediaType: imgspecv1.MediaTypeImageManifest,
+		})
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		signature := test.GenerateOCIImage(test.OCIArgs{
+			ConfigMediaType: imgspecv1.MediaTypeImageManifest,
+			ArtifactType:    "application/vnd.example.signature",
+			SubjectDigest:   image.Manifest.Digest,
+		})
+		signature.MustUpload(t, s, fooRepoRef, "")
+		countersignature := test.GenerateOCIImage(test.OCIArgs{
+			ConfigMediaType: imgspecv1.MediaTypeImageManifest,
+			ArtifactType:    "application/vnd.example.countersignature",
+			SubjectDigest:   signature.Manifest.Digest,
+		})
+		countersignature.MustUpload(t, s, fooRepoRef, "")
+		otherImage := test.GenerateOCIImage(test.OCIArgs{
+			ConfigMediaType: imgspecv1.MediaTypeImageManifest,
+			Config:          map[string]any{"other": true},
+		})
+		otherImage.MustUpload(t, s, fooRepoRef, "other")
+
+		_, err := s.DB.Exec(`UPDATE accounts SET cascade_delete_referrers = TRUE WHERE name = $1`, "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// deleting the image also deletes all its direct and indirect referrers
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         "/v2/test1/foo/manifests/" + image.Manifest.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusAccepted,
+		}.Check(t, h)
+
+		var remainingDigests []string
+		_, err = s.DB.Select(&remainingDigests, `SELECT digest FROM manifests`)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "remaining manifests", remainingDigests, []string{otherImage.Manifest.Digest.String()})
+	})
+}
@@ -4,7 +4,11 @@
 package registryv2_test
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -14,8 +18,10 @@ import (
 	"github.com/sapcc/go-bits/assert"
 	"github.com/sapcc/go-bits/easypg"
 
+	"github.com/sapcc/keppel/internal/auth"
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/tasks"
 	"github.com/sapcc/keppel/internal/test"
 )
 
@@ -97,6 +103,12 @@ func TestReplicationImageList(t *testing.T) {
 				// do not step the clock in the second pass, otherwise the AssertDBContent
 				// will fail on the changed last_pulled_at timestamp
 				s1.Clock.StepBy(time.Second)
+			} else {
+				// for the same reason, reset the pull counter from the first pass
+				_, err := s2.DB.Exec(`UPDATE tags SET pull_count = 0`)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
 			}
 			expectManifestExists(t, h2, token, "test1/foo", list.Manifest, "list", nil)
 
@@ -437,6 +449,12 @@ func TestReplicationImageListWithPlatformFilter(t *testing.T) {
 				// do not step the clock in the second pass, otherwise the AssertDBContent
 				// will fail on the changed last_pulled_at timestamp
 				s1.Clock.StepBy(time.Second)
+			} else {
+				// for the same reason, reset the pull counter from the first pass
+				_, err := s2.DB.Exec(`UPDATE tags SET pull_count = 0`)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
 			}
 			expectManifestExists(t, h2, token, "test1/foo", list.Manifest, "list", nil)
 
@@ -552,3 +570,435 @@ func TestReplicationFailingOverIntoPullDelegation(t *testing.T) {
 		})
 	})
 }
+
+func TestReplicationVerifyOnly(t *testing.T) {
+	testWithPrimary(t, nil, func(s1 test.Setup) {
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s1, fooRepoRef, "first")
+
+		testWithReplica(t, s1, "from_external_on_first_use", func(firstPass bool, s2 test.Setup) {
+			h2 := s2.Handler
+			token := s2.GetToken(t, "repository:test1/foo:pull")
+			_, err := s2.DB.Exec(`UPDATE accounts SET external_peer_verify_only = TRUE`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if firstPass {
+				// blobs are not served before a manifest referencing them has been verified
+				assert.HTTPRequest{
+					Method:       "GET",
+					Path:         "/v2/test1/foo/blobs/" + image.Layers[0].Digest.String(),
+					Header:       map[string]string{"Authorization": "Bearer " + token},
+					ExpectStatus: http.StatusNotFound,
+					ExpectHeader: test.VersionHeader,
+					ExpectBody:   test.ErrorCode(keppel.ErrBlobUnknown),
+				}.Check(t, h2)
+
+				// pulling the manifest replicates it, but it is not served until the
+				// janitor has verified it
+				assert.HTTPRequest{
+					Method:       "GET",
+					Path:         "/v2/test1/foo/manifests/first",
+					Header:       map[string]string{"Authorization": "Bearer " + token},
+					ExpectStatus: http.StatusTooManyRequests,
+					ExpectHeader: map[string]string{test.VersionHeaderKey: test.VersionHeaderValue, "Retry-After": "10"},
+					ExpectBody:   test.ErrorCode(keppel.ErrTooManyRequests),
+				}.Check(t, h2)
+
+				// the verification replicates and verifies all referenced blobs
+				verifyJob := newReplicaJanitor(s2).ManifestVerificationJob(s2.Registry)
+				err := verifyJob.ProcessOne(s2.Ctx)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+				err = verifyJob.ProcessOne(s2.Ctx)
+				if !errors.Is(err, sql.ErrNoRows) {
+					t.Errorf("expected sql.ErrNoRows, but got: %v", err)
+				}
+			}
+
+			// afterwards, the manifest can be pulled
+			expectManifestExists(t, h2, token, "test1/foo", image.Manifest, "first", nil)
+			count, err := s2.DB.SelectInt(`SELECT COUNT(*) FROM manifests WHERE verified_at IS NOT NULL`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "verified manifests", count, int64(1))
+			count, err = s2.DB.SelectInt(`SELECT COUNT(*) FROM blobs WHERE storage_id = ''`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "unreplicated blobs", count, int64(0))
+
+			// afterwards, the blobs can be pulled (also when the upstream is unreachable)
+			expectBlobExists(t, h2, token, "test1/foo", image.Config, nil)
+			expectBlobExists(t, h2, token, "test1/foo", image.Layers[0], nil)
+		})
+	})
+}
+
+func TestReplicationVerifyOnlyRejectsTamperedBlob(t *testing.T) {
+	testWithPrimary(t, nil, func(s1 test.Setup) {
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s1, fooRepoRef, "first")
+
+		// tamper with the layer blob in the primary's storage, such that the
+		// primary delivers contents that do not match the digest
+		layer := image.Layers[0]
+		blob, err := keppel.FindBlobByAccountName(s1.DB, layer.Digest, "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		account := models.ReducedAccount{Name: "test1"}
+		tamperedContents := bytes.Repeat([]byte{'x'}, len(layer.Contents))
+		sizeBytes := uint64(len(tamperedContents))
+		err = s1.SD.DeleteBlob(s1.Ctx, account, blob.StorageID)
+		if err == nil {
+			err = s1.SD.AppendToBlob(s1.Ctx, account, blob.StorageID, 1, &sizeBytes, bytes.NewReader(tamperedContents))
+		}
+		if err == nil {
+			err = s1.SD.FinalizeBlob(s1.Ctx, account, blob.StorageID, 1)
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		testWithReplica(t, s1, "from_external_on_first_use", func(firstPass bool, s2 test.Setup) {
+			h2 := s2.Handler
+			token := s2.GetToken(t, "repository:test1/foo:pull")
+			_, err := s2.DB.Exec(`UPDATE accounts SET external_peer_verify_only = TRUE`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if !firstPass {
+				return
+			}
+
+			// pulling the manifest replicates it, but it is not served until the
+			// janitor has verified it
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/manifests/first",
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusTooManyRequests,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   test.ErrorCode(keppel.ErrTooManyRequests),
+			}.Check(t, h2)
+
+			// the verification fails because the digest chain does not validate
+			verifyJob := newReplicaJanitor(s2).ManifestVerificationJob(s2.Registry)
+			err = verifyJob.ProcessOne(s2.Ctx)
+			if err == nil {
+				t.Error("expected verification to fail, but it succeeded")
+			}
+			err = verifyJob.ProcessOne(s2.Ctx)
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("expected sql.ErrNoRows (since the retry is not due yet), but got: %v", err)
+			}
+
+			// so the manifest cannot be served (and this does not change when trying again)
+			for range []int{1, 2} {
+				assert.HTTPRequest{
+					Method:       "GET",
+					Path:         "/v2/test1/foo/manifests/first",
+					Header:       map[string]string{"Authorization": "Bearer " + token},
+					ExpectStatus: http.StatusBadRequest,
+					ExpectHeader: test.VersionHeader,
+					ExpectBody:   test.ErrorCode(keppel.ErrManifestUnverified),
+				}.Check(t, h2)
+			}
+
+			// neither can the tampered blob
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/blobs/" + layer.Digest.String(),
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusNotFound,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   test.ErrorCode(keppel.ErrBlobUnknown),
+			}.Check(t, h2)
+			count, err := s2.DB.SelectInt(`SELECT COUNT(*) FROM manifests WHERE verified_at IS NOT NULL`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "verified manifests", count, int64(0))
+		})
+	})
+}
+
+func newReplicaJanitor(s test.Setup) *tasks.Janitor {
+	j := tasks.NewJanitor(s.Config, s.FD, s.SD, s.ICD, s.SecD, s.BD, s.CDN, s.NVD, s.DB, s.AMD, s.Auditor).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next)
+	j.DisableJitter()
+	return j
+}
+
+func TestReplicationFromSharedBlobs(t *testing.T) {
+	testWithPrimary(t, nil, func(s1 test.Setup) {
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s1, fooRepoRef, "first")
+
+		testWithReplica(t, s1, "from_external_on_first_use", func(firstPass bool, s2 test.Setup) {
+			if !firstPass {
+				return
+			}
+			h2 := s2.Handler
+			token := s2.GetToken(t, "repository:test1/foo:pull")
+
+			// another account in the replica already has the same image
+			err := s2.DB.Insert(&models.Account{Name: "test2", AuthTenantID: authTenantID})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			image.MustUpload(t, s2, models.Repository{AccountName: "test2", Name: "foo"}, "first")
+
+			// replicate the manifest while upstream is fully reachable
+			expectManifestExists(t, h2, token, "test1/foo", image.Manifest, "first", nil)
+
+			// from now on, upstream does not deliver any blobs
+			tt := http.DefaultTransport.(*test.RoundTripper)
+			upstreamHandler := tt.Handlers["registry.example.org"]
+			tt.Handlers["registry.example.org"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.Contains(r.URL.Path, "/blobs/") {
+					keppel.ErrBlobUnknown.With("blob does not exist").WriteAsRegistryV2ResponseTo(w, r)
+					return
+				}
+				upstreamHandler.ServeHTTP(w, r)
+			})
+			defer func() {
+				tt.Handlers["registry.example.org"] = upstreamHandler
+			}()
+
+			// blobs are not shared with accounts in other auth tenants by default...
+			_, err = s2.DB.Exec(`UPDATE accounts SET auth_tenant_id = $1 WHERE name = $2`, "othertenant", "test2")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/blobs/" + image.Config.Digest.String(),
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusNotFound,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   test.ErrorCode(keppel.ErrBlobUnknown),
+			}.Check(t, h2)
+
+			// ...but when the other account opts into sharing its blobs, they are
+			// copied from there instead of from upstream
+			_, err = s2.DB.Exec(`UPDATE accounts SET share_blobs = TRUE WHERE name = $1`, "test2")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			expectBlobExists(t, h2, token, "test1/foo", image.Config, nil)
+
+			// within the same auth tenant, blobs are always shared
+			_, err = s2.DB.Exec(`UPDATE accounts SET auth_tenant_id = $1, share_blobs = FALSE WHERE name = $2`, authTenantID, "test2")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			expectBlobExists(t, h2, token, "test1/foo", image.Layers[0], nil)
+
+			count, err := s2.DB.SelectInt(`SELECT COUNT(*) FROM blobs WHERE account_name = $1 AND storage_id = ''`, "test1")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "unreplicated blobs", count, int64(0))
+		})
+	})
+}
+
+func TestReplicationRetryHints(t *testing.T) {
+	testWithPrimary(t, nil, func(s1 test.Setup) {
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s1, fooRepoRef, "first")
+		layer := image.Layers[0]
+
+		testWithReplica(t, s1, "from_external_on_first_use", func(firstPass bool, s2 test.Setup) {
+			if !firstPass {
+				return
+			}
+			h2 := s2.Handler
+			token := s2.GetToken(t, "repository:test1/foo:pull")
+			_, err := s2.DB.Exec(`UPDATE accounts SET replication_retry_hints = TRUE`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			expectManifestExists(t, h2, token, "test1/foo", image.Manifest, "first", nil)
+
+			// small blobs are replicated while the client waits, since a retry would
+			// take longer than the replication itself
+			expectBlobExists(t, h2, token, "test1/foo", image.Config, nil)
+
+			// while another worker replicates the blob, clients are told how far it has come
+			err = s2.DB.Insert(&models.PendingBlob{
+				AccountName:     "test1",
+				Digest:          layer.Digest,
+				Reason:          models.PendingBecauseOfReplication,
+				PendingSince:    s2.Clock.Now(),
+				ReplicatedBytes: 10,
+			})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			detail := keppel.RetryAfterDetail(keppel.ReasonReplicationInProgress, 10*time.Second)
+			replicatedBytes := uint64(10)
+			totalBytes := uint64(len(layer.Contents))
+			detail.ReplicatedBytes = &replicatedBytes
+			detail.TotalBytes = &totalBytes
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/blobs/" + layer.Digest.String(),
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusTooManyRequests,
+				ExpectHeader: map[string]string{
+					test.VersionHeaderKey:     test.VersionHeaderValue,
+					"Retry-After":             "10",
+					"X-Keppel-Replication-Id": layer.Digest.String(),
+				},
+				ExpectBody: test.ErrorCodeWithMessage{
+					Code:    keppel.ErrTooManyRequests,
+					Message: fmt.Sprintf("currently replicating from upstream (10 of %d bytes done), please retry in a few seconds", totalBytes),
+					Detail:  detail,
+				},
+			}.Check(t, h2)
+
+			// when no replication is ongoing, the first GET starts the replication
+			// in the background instead of waiting for it
+			_, err = s2.DB.Exec(`DELETE FROM pending_blobs`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/blobs/" + layer.Digest.String(),
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusTooManyRequests,
+				ExpectHeader: map[string]string{
+					test.VersionHeaderKey: test.VersionHeaderValue,
+					"Retry-After":         "10",
+				},
+			}.Check(t, h2)
+
+			// the client can retry until the replication is done
+			for range 100 {
+				count, err := s2.DB.SelectInt(`SELECT COUNT(*) FROM pending_blobs`)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+				if count == 0 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			expectBlobExists(t, h2, token, "test1/foo", layer, nil)
+		})
+	})
+}
+
+func TestReplicationFromNearerPeer(t *testing.T) {
+	testWithPrimary(t, nil, func(s1 test.Setup) {
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s1, fooRepoRef, "first")
+		otherImage := test.GenerateImage(test.GenerateExampleLayer(2))
+		otherImage.MustUpload(t, s1, fooRepoRef, "second")
+
+		testWithReplica(t, s1, "on_first_use", func(firstPass bool, s2 test.Setup) {
+			if !firstPass {
+				return
+			}
+			h2 := s2.Handler
+			token := s2.GetToken(t, "repository:test1/foo:pull")
+
+			// a third peer is nearer than the primary (for this test, it's just
+			// another name for the primary since it needs to have the same contents)
+			tt := http.DefaultTransport.(*test.RoundTripper)
+			var requestsOnNearerPeer []string
+			serveFromNearerPeer := true
+			tt.Handlers["registry-nearby.example.org"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestsOnNearerPeer = append(requestsOnNearerPeer, r.Method+" "+r.URL.Path)
+				if !serveFromNearerPeer {
+					keppel.ErrManifestUnknown.With("manifest does not exist").WriteAsRegistryV2ResponseTo(w, r)
+					return
+				}
+				r.Host = "registry.example.org"
+				tt.Handlers["registry.example.org"].ServeHTTP(w, r)
+			})
+			defer delete(tt.Handlers, "registry-nearby.example.org")
+
+			_, err := s2.DB.Exec(`UPDATE peers SET link_cost = 10, region = 'far-away' WHERE hostname = 'registry.example.org'`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			err = s2.DB.Insert(&models.Peer{
+				HostName:          "registry-nearby.example.org",
+				OurPassword:       test.GetReplicationPassword(),
+				Region:            "nearby",
+				LinkCost:          1,
+				UseForReplication: true,
+			})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			// manifests pulled by tag are always replicated from the primary...
+			expectManifestExists(t, h2, token, "test1/foo", image.Manifest, "first", nil)
+			for _, req := range requestsOnNearerPeer {
+				if strings.Contains(req, "/manifests/") {
+					t.Errorf("expected manifest to be replicated from primary, but nearer peer got request: %s", req)
+				}
+			}
+
+			// ...but blobs are replicated from the nearer peer (after asking it
+			// whether it has the blob)
+			layerPath := "/v2/test1/foo/blobs/" + image.Layers[0].Digest.String()
+			expectBlobExists(t, h2, token, "test1/foo", image.Layers[0], nil)
+			assert.DeepEqual(t, "HEAD requests for the layer blob on the nearer peer",
+				countMatchingRequests(requestsOnNearerPeer, "HEAD "+layerPath) > 0, true)
+			assert.DeepEqual(t, "GET requests for the layer blob on the nearer peer",
+				countMatchingRequests(requestsOnNearerPeer, "GET "+layerPath) > 0, true)
+
+			// if the nearer peer does not have an object, it is not downloaded from
+			// there, and the primary is used instead
+			serveFromNearerPeer = false
+			manifestPath := "/v2/test1/foo/manifests/" + otherImage.Manifest.Digest.String()
+			expectManifestExists(t, h2, token, "test1/foo", otherImage.Manifest, otherImage.Manifest.Digest.String(), nil)
+			assert.DeepEqual(t, "HEAD requests for the manifest on the nearer peer",
+				countMatchingRequests(requestsOnNearerPeer, "HEAD "+manifestPath), 1)
+			assert.DeepEqual(t, "GET requests for the manifest on the nearer peer",
+				countMatchingRequests(requestsOnNearerPeer, "GET "+manifestPath), 0)
+
+			// as a replication source, the replica does not replicate blobs on behalf
+			// of other peers, so that replications cannot bounce between peers
+			var ss auth.ScopeSet
+			ss.Add(auth.Scope{ResourceType: "repository", ResourceName: "test1/foo", Actions: []string{"pull"}})
+			peerToken, err := auth.Authorization{
+				UserIdentity: &auth.PeerUserIdentity{PeerHostName: "registry-nearby.example.org"},
+				ScopeSet:     ss,
+			}.IssueToken(s2.Config)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			otherLayerPath := "/v2/test1/foo/blobs/" + otherImage.Layers[0].Digest.String()
+			for _, method := range []string{"HEAD", "GET"} {
+				assert.HTTPRequest{
+					Method:       method,
+					Path:         otherLayerPath,
+					Header:       map[string]string{"Authorization": "Bearer " + peerToken.Token},
+					ExpectStatus: http.StatusNotFound,
+					ExpectHeader: test.VersionHeader,
+				}.Check(t, h2)
+			}
+			expectBlobExists(t, h2, token, "test1/foo", otherImage.Layers[0], nil)
+		})
+	})
+}
+
+func countMatchingRequests(requests []string, expected string) int {
+	count := 0
+	for _, req := range requests {
+		if req == expected {
+			count++
+		}
+	}
+	return count
+}
@@ -11,20 +11,23 @@ import (
 	"strconv"
 
 	distspecv1 "github.com/opencontainers/distribution-spec/specs-go/v1"
+	"github.com/opencontainers/go-digest"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/respondwith"
 	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
 )
 
 var tagsListQuery = sqlext.SimplifyWhitespace(`
-	SELECT name FROM tags
+	SELECT name, digest FROM tags
 	 WHERE repo_id = $1 AND (name > $2 or $2 = '')
 	 ORDER BY name ASC LIMIT $3
 `)
 
 func (a *API) handleListTags(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/v2/:account/:repo/tags/list")
-	account, repo, _, _ := a.checkAccountAccess(w, r, failIfRepoMissing, a.handleListTagsAnycast)
+	account, repo, authz, _ := a.checkAccountAccess(w, r, failIfRepoMissing, a.handleListTagsAnycast)
 	if account == nil {
 		return
 	}
@@ -55,18 +58,50 @@ func (a *API) handleListTags(w http.ResponseWriter, r *http.Request) {
 	// parse query: marker (parameter "last")
 	marker := query.Get("last")
 
-	// list tags (we request one more than `limit` to see if we need to paginate)
+	// if pull access was only granted by RBAC policies with "match_keppel_labels",
+	// only tags pointing to manifests with these labels are listed
+	restrictions, isRestricted := authz.KeppelLabelRestrictions[repo.FullName()]
+
+	// list tags (we request one more than `limit` to see if we need to paginate;
+	// if tags are filtered, we keep requesting more until we have enough)
 	tags := []string{}
-	err = sqlext.ForeachRow(a.db, tagsListQuery, []any{repo.ID, marker, limit + 1}, func(rows *sql.Rows) error {
-		var tagName string
-		err = rows.Scan(&tagName)
-		if err == nil {
+	for uint64(len(tags)) <= limit {
+		var (
+			pageNames   []string
+			pageDigests []digest.Digest
+		)
+		err = sqlext.ForeachRow(a.db, tagsListQuery, []any{repo.ID, marker, limit + 1}, func(rows *sql.Rows) error {
+			var (
+				tagName   string
+				tagDigest digest.Digest
+			)
+			err := rows.Scan(&tagName, &tagDigest)
+			if err == nil {
+				pageNames = append(pageNames, tagName)
+				pageDigests = append(pageDigests, tagDigest)
+			}
+			return err
+		})
+		if respondWithError(w, r, err) {
+			return
+		}
+
+		for idx, tagName := range pageNames {
+			if isRestricted {
+				isAllowed, err := keppel.IsManifestAllowedByKeppelLabels(a.db, *repo, pageDigests[idx], restrictions)
+				if respondWithError(w, r, err) {
+					return
+				}
+				if !isAllowed {
+					continue
+				}
+			}
 			tags = append(tags, tagName)
 		}
-		return err
-	})
-	if respondWithError(w, r, err) {
-		return
+		if !isRestricted || uint64(len(pageNames)) <= limit {
+			break
+		}
+		marker = pageNames[len(pageNames)-1]
 	}
 
 	// do we need to paginate?
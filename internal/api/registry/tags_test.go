@@ -156,3 +156,76 @@ func TestListTags(t *testing.T) {
 		}
 	})
 }
+
+func TestListTagsWithKeppelLabelRestriction(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+
+		// tags "a", "c" and "e" point to an image with the "approved-for-prod"
+		// label, tags "b" and "d" point to an image without it
+		approvedImage := test.GenerateImage(test.GenerateExampleLayer(1))
+		otherImage := test.GenerateImage(test.GenerateExampleLayer(2))
+		for _, tagName := range []string{"a", "c", "e"} {
+			approvedImage.MustUpload(t, s, fooRepoRef, tagName)
+		}
+		for _, tagName := range []string{"b", "d"} {
+			otherImage.MustUpload(t, s, fooRepoRef, tagName)
+		}
+		_, err := s.DB.Exec(`INSERT INTO manifest_keppel_labels (repo_id, digest, name, value) VALUES (1, $1, $2, $3)`,
+			approvedImage.Manifest.Digest.String(), "approved-for-prod", "true")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// anonymous users can only pull manifests with the "approved-for-prod" label
+		_, err = s.DB.Exec(`UPDATE accounts SET rbac_policies_json = $2 WHERE name = $1`, "test1",
+			test.ToJSON([]keppel.RBACPolicy{{
+				RepositoryPattern: "foo",
+				KeppelLabels:      map[string]string{"approved-for-prod": "true"},
+				Permissions:       []keppel.RBACPermission{keppel.RBACAnonymousPullPermission},
+			}}),
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// so they only see the tags pointing to these manifests
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/tags/list",
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.JSONObject{"name": "test1/foo", "tags": []string{"a", "c", "e"}},
+		}.Check(t, h)
+
+		// pagination skips over the tags that are filtered out
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/tags/list?n=1&last=a",
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Link":                `</v2/test1/foo/tags/list?last=c&n=1>; rel="next"`,
+			},
+			ExpectBody: assert.JSONObject{"name": "test1/foo", "tags": []string{"c"}},
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/tags/list?n=1&last=c",
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.JSONObject{"name": "test1/foo", "tags": []string{"e"}},
+		}.Check(t, h)
+
+		// regular users with pull permission see all tags
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/tags/list",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   assert.JSONObject{"name": "test1/foo", "tags": []string{"a", "b", "c", "d", "e"}},
+		}.Check(t, h)
+	})
+}
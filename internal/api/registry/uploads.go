@@ -50,23 +50,35 @@ func (a *API) handleStartBlobUpload(w http.ResponseWriter, r *http.Request) {
 
 	// forbid pushing into replica accounts
 	if account.UpstreamPeerHostName != "" {
-		msg := fmt.Sprintf("cannot push into replica account (push to %s/%s instead!)",
-			account.UpstreamPeerHostName, repo.FullName(),
-		)
-		keppel.ErrUnsupported.With(msg).WithStatus(http.StatusMethodNotAllowed).WriteAsRegistryV2ResponseTo(w, r)
+		pushTo := fmt.Sprintf("%s/%s", account.UpstreamPeerHostName, repo.FullName())
+		msg := fmt.Sprintf("cannot push into replica account (push to %s instead!)", pushTo)
+		keppel.ErrUnsupported.With(msg).WithStatus(http.StatusMethodNotAllowed).
+			WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonPushToReplica, PushTo: pushTo}).
+			WriteAsRegistryV2ResponseTo(w, r)
 		return
 	}
 	if account.ExternalPeerURL != "" {
-		msg := fmt.Sprintf("cannot push into external replica account (push to %s/%s instead!)",
-			account.ExternalPeerURL, repo.Name,
-		)
-		keppel.ErrUnsupported.With(msg).WithStatus(http.StatusMethodNotAllowed).WriteAsRegistryV2ResponseTo(w, r)
+		pushTo := fmt.Sprintf("%s/%s", account.ExternalPeerURL, repo.Name)
+		msg := fmt.Sprintf("cannot push into external replica account (push to %s instead!)", pushTo)
+		keppel.ErrUnsupported.With(msg).WithStatus(http.StatusMethodNotAllowed).
+			WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonPushToReplica, PushTo: pushTo}).
+			WriteAsRegistryV2ResponseTo(w, r)
 		return
 	}
 
 	// forbid pushing during maintenance
 	if account.IsDeleting {
-		keppel.ErrUnsupported.With("account is being deleted").WithStatus(http.StatusMethodNotAllowed).WriteAsRegistryV2ResponseTo(w, r)
+		keppel.ErrUnsupported.With("account is being deleted").WithStatus(http.StatusMethodNotAllowed).
+			WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonAccountDeleting}).
+			WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+
+	// forbid pushing into archived repos
+	if repo.IsArchived {
+		keppel.ErrUnsupported.With("repository is archived").WithStatus(http.StatusMethodNotAllowed).
+			WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonRepositoryArchived}).
+			WriteAsRegistryV2ResponseTo(w, r)
 		return
 	}
 
@@ -90,15 +102,19 @@ func (a *API) handleStartBlobUpload(w http.ResponseWriter, r *http.Request) {
 		msg := fmt.Sprintf("manifest quota exceeded (quota = %d, usage = %d)",
 			quotas.ManifestCount, manifestUsage,
 		)
-		keppel.ErrDenied.With(msg).WithStatus(http.StatusConflict).WriteAsRegistryV2ResponseTo(w, r)
+		keppel.ErrDenied.With(msg).WithStatus(http.StatusConflict).
+			WithDetail(keppel.QuotaExceededDetail(quotas.ManifestCount, manifestUsage)).
+			WriteAsRegistryV2ResponseTo(w, r)
 		return
 	}
 
 	// special case: request for cross-repo blob mount
 	query := r.URL.Query()
 	if sourceRepoFullName := query.Get("from"); sourceRepoFullName != "" {
-		a.performCrossRepositoryBlobMount(w, r, *account, *repo, authz, sourceRepoFullName, query.Get("mount"))
-		return
+		if a.performCrossRepositoryBlobMount(w, r, *account, *repo, authz, sourceRepoFullName, query.Get("mount")) {
+			return
+		}
+		// otherwise fall back to a regular upload, as allowed by the spec
 	}
 
 	// special case: monolithic upload
@@ -107,7 +123,8 @@ func (a *API) handleStartBlobUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// start a new upload
+	// start a new upload (the storage backend may still change when the first
+	// chunk arrives, see streamIntoUpload)
 	uuidV4, err := uuid.NewV4()
 	if respondWithError(w, r, err) {
 		return
@@ -115,7 +132,7 @@ func (a *API) handleStartBlobUpload(w http.ResponseWriter, r *http.Request) {
 	upload := models.Upload{
 		RepositoryID: repo.ID,
 		UUID:         uuidV4.String(),
-		StorageID:    a.generateStorageID(),
+		StorageID:    keppel.StorageIDForNewBlob(a.sd, *account, repo.Name, nil, a.generateStorageID()),
 		SizeBytes:    0,
 		Digest:       "",
 		NumChunks:    0,
@@ -131,59 +148,132 @@ func (a *API) handleStartBlobUpload(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Length", "0")
 	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", getRepoNameForURLPath(*repo, authz), upload.UUID))
 	w.Header().Set("Range", "0-0")
+	setChunkSizeLimitHeaders(w.Header(), keppel.GetChunkSizeLimits(a.sd))
 	w.WriteHeader(http.StatusAccepted)
 }
 
-func (a *API) performCrossRepositoryBlobMount(w http.ResponseWriter, r *http.Request, account models.ReducedAccount, targetRepo models.Repository, authz *auth.Authorization, sourceRepoFullName, blobDigestStr string) {
+// Advertises the chunk sizes supported by the storage driver, so that clients
+// do not send chunks that the storage driver cannot handle. The
+// OCI-Chunk-Min-Length header is defined by the OCI Distribution Spec, but
+// the spec does not have an equivalent for the maximum.
+func setChunkSizeLimitHeaders(hdr http.Header, limits keppel.ChunkSizeLimits) {
+	if limits.MinBytes > 0 {
+		hdr.Set("OCI-Chunk-Min-Length", strconv.FormatUint(limits.MinBytes, 10))
+	}
+	if limits.MaxBytes > 0 {
+		hdr.Set("X-Keppel-Chunk-Max-Length", strconv.FormatUint(limits.MaxBytes, 10))
+	}
+}
+
+// Returns false if the mount was not performed because the client may not pull
+// from the source repository. The caller shall then start a regular upload.
+func (a *API) performCrossRepositoryBlobMount(w http.ResponseWriter, r *http.Request, account models.ReducedAccount, targetRepo models.Repository, authz *auth.Authorization, sourceRepoFullName, blobDigestStr string) (handled bool) {
 	// validate source repository
 	sourceRepoName, ok := strings.CutPrefix(sourceRepoFullName, string(account.Name)+"/")
 	if !ok {
 		keppel.ErrUnsupported.With("cannot mount blobs across different accounts").WriteAsRegistryV2ResponseTo(w, r)
-		return
+		return true
 	}
 	if !models.RepoNameWithLeadingSlashRx.MatchString("/" + sourceRepoName) {
 		keppel.ErrNameInvalid.With("source repository is invalid").WriteAsRegistryV2ResponseTo(w, r)
-		return
+		return true
+	}
+
+	// mounting a blob is equivalent to pulling it from the source repository
+	// (this is checked before looking at the source repo to avoid leaking
+	// information about which repos exist)
+	canPull, err := a.canMountFromRepo(r, sourceRepoFullName)
+	if respondWithError(w, r, err) {
+		return true
+	}
+	if !canPull {
+		return false
 	}
 	sourceRepo, err := keppel.FindRepository(a.db, sourceRepoName, account.Name)
 	if errors.Is(err, sql.ErrNoRows) {
 		keppel.ErrNameUnknown.With("source repository does not exist").WriteAsRegistryV2ResponseTo(w, r)
-		return
+		return true
 	}
 	if respondWithError(w, r, err) {
-		return
+		return true
 	}
 
 	// validate blob
 	blobDigest, err := digest.Parse(blobDigestStr)
 	if err != nil {
 		keppel.ErrDigestInvalid.With(err.Error()).WriteAsRegistryV2ResponseTo(w, r)
-		return
+		return true
 	}
 	blob, err := keppel.FindBlobByRepository(a.db, blobDigest, *sourceRepo)
 	if errors.Is(err, sql.ErrNoRows) {
 		keppel.ErrBlobUnknown.With("blob does not exist in source repository").WriteAsRegistryV2ResponseTo(w, r)
-		return
+		return true
 	}
 	if respondWithError(w, r, err) {
-		return
+		return true
 	}
 
 	// create blob mount if missing
-	err = keppel.MountBlobIntoRepo(a.db, *blob, targetRepo)
+	tx, err := a.db.Begin()
 	if respondWithError(w, r, err) {
-		return
+		return true
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+	err = keppel.CheckStorageQuotaForBlobMount(tx, targetRepo, blob.Digest, blob.SizeBytes, "", a.timeNow())
+	if respondWithError(w, r, err) {
+		return true
+	}
+	err = keppel.MountBlobIntoRepo(tx, *blob, targetRepo)
+	if respondWithError(w, r, err) {
+		return true
+	}
+	err = tx.Commit()
+	if respondWithError(w, r, err) {
+		return true
 	}
+	target := blobEventTarget(*blob, targetRepo)
+	target.FromRepository = sourceRepo.FullName()
+	a.dn.Notify(r, "mount", target, authz.UserIdentity)
 
 	// the spec wants a Blob-Upload-Session-Id header even though the upload is done, so just make something up
 	uuidV4, err := uuid.NewV4()
 	if respondWithError(w, r, err) {
-		return
+		return true
 	}
 	w.Header().Set("Blob-Upload-Session-Id", uuidV4.String())
 	w.Header().Set("Content-Length", "0")
 	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", getRepoNameForURLPath(targetRepo, authz), blobDigest.String()))
 	w.WriteHeader(http.StatusCreated)
+	return true
+}
+
+// Checks whether the client may pull from the given repository for the purpose
+// of mounting blobs from it. The Authorization from checkAccountAccess() does
+// not help here, since it only covers the target repository when the client
+// authenticated without a token.
+func (a *API) canMountFromRepo(r *http.Request, repoFullName string) (bool, error) {
+	scope := auth.Scope{
+		ResourceType: "repository",
+		ResourceName: repoFullName,
+		Actions:      []string{"pull"},
+	}
+	authz, _, rerr := auth.IncomingRequest{
+		HTTPRequest:           r,
+		Scopes:                auth.NewScopeSet(scope),
+		AllowsDomainRemapping: true,
+		PartialAccessAllowed:  true,
+		RBACPolicyUsage:       a.rpur,
+		TimeNow:               a.timeNow,
+	}.Authorize(r.Context(), a.cfg, a.ad, a.db)
+	if rerr != nil {
+		return false, rerr
+	}
+	// pull access that is restricted to manifests with certain Keppel labels
+	// does not extend to arbitrary blobs in the repo
+	if _, isRestricted := authz.KeppelLabelRestrictions[repoFullName]; isRestricted {
+		return false, nil
+	}
+	return authz.ScopeSet.Contains(scope), nil
 }
 
 func (a *API) performMonolithicUpload(w http.ResponseWriter, r *http.Request, account models.ReducedAccount, repo models.Repository, authz *auth.Authorization, blobDigestStr string) (ok bool) {
@@ -193,6 +283,27 @@ func (a *API) performMonolithicUpload(w http.ResponseWriter, r *http.Request, ac
 		return false
 	}
 
+	// if the blob already exists in this repo, we do not need to receive the
+	// request body at all (this saves a lot of bandwidth for clients that push
+	// the same layers over and over again)
+	_, err = keppel.FindBlobByRepository(a.db, blobDigest, repo)
+	switch {
+	case err == nil:
+		// the spec wants a Blob-Upload-Session-Id header even though the upload is done, so just make something up
+		uuidV4, err := uuid.NewV4()
+		if respondWithError(w, r, err) {
+			return false
+		}
+		w.Header().Set("Blob-Upload-Session-Id", uuidV4.String())
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", getRepoNameForURLPath(repo, authz), blobDigest.String()))
+		w.WriteHeader(http.StatusCreated)
+		return true
+	case !errors.Is(err, sql.ErrNoRows):
+		respondWithError(w, r, err)
+		return false
+	}
+
 	// parse Content-Length
 	sizeBytesStr := r.Header.Get("Content-Length")
 	if sizeBytesStr == "" {
@@ -206,16 +317,33 @@ func (a *API) performMonolithicUpload(w http.ResponseWriter, r *http.Request, ac
 		return false
 	}
 
-	// stream request body into the storage backend while also computing the digest and length
+	// the size is known upfront, so we can reserve the required quota before
+	// accepting any data (this ensures that concurrent uploads cannot exceed the
+	// quota together)
 	upload := models.Upload{
-		StorageID: a.generateStorageID(),
+		StorageID: keppel.StorageIDForNewBlob(a.sd, account, repo.Name, &sizeBytes, a.generateStorageID()),
 		SizeBytes: 0,
 		NumChunks: 0,
 	}
+	err = keppel.ReserveStorageQuota(a.db, repo, upload.StorageID, sizeBytes, a.timeNow())
+	if respondWithError(w, r, err) {
+		return false
+	}
+	defer func() {
+		if !ok {
+			err := keppel.ReleaseStorageQuotaReservation(a.db, repo.ID, upload.StorageID)
+			if err != nil {
+				logg.Error("additional error encountered while releasing storage quota reservation for blob upload %s into %s: %s", upload.StorageID, repo.FullName(), err.Error())
+			}
+		}
+	}()
+
+	// stream request body into the storage backend while also computing the digest and length
 	dw := digestWriter{Hash: sha256.New()}
 	err = a.processor().AppendToBlob(r.Context(), account, &upload, io.TeeReader(r.Body, &dw), &sizeBytes)
 	if err == nil {
 		err = a.sd.FinalizeBlob(r.Context(), account, upload.StorageID, upload.NumChunks)
+		a.processor().RecordStorageQuotaError(account, err)
 	}
 	if respondWithError(w, r, err) {
 		countAbortedBlobUpload(account)
@@ -258,6 +386,10 @@ func (a *API) performMonolithicUpload(w http.ResponseWriter, r *http.Request, ac
 	defer sqlext.RollbackUnlessCommitted(tx)
 
 	blobPushedAt := a.timeNow()
+	err = keppel.CheckStorageQuotaForBlobMount(tx, repo, blobDigest, sizeBytes, upload.StorageID, blobPushedAt)
+	if respondWithError(w, r, err) {
+		return false
+	}
 	blob, err := a.createOrUpdateBlobObject(r.Context(), tx, sizeBytes, upload.StorageID, blobDigest, blobPushedAt, account)
 	if respondWithError(w, r, err) {
 		return false
@@ -266,10 +398,15 @@ func (a *API) performMonolithicUpload(w http.ResponseWriter, r *http.Request, ac
 	if respondWithError(w, r, err) {
 		return false
 	}
+	err = keppel.ReleaseStorageQuotaReservation(tx, repo.ID, upload.StorageID)
+	if respondWithError(w, r, err) {
+		return false
+	}
 	err = tx.Commit()
 	if respondWithError(w, r, err) {
 		return false
 	}
+	a.dn.Notify(r, "push", blobEventTarget(*blob, repo), authz.UserIdentity)
 
 	// the spec wants a Blob-Upload-Session-Id header even though the upload is done, so just make something up
 	uuidV4, err := uuid.NewV4()
@@ -305,6 +442,10 @@ func (a *API) handleDeleteBlobUpload(w http.ResponseWriter, r *http.Request) {
 	if respondWithError(w, r, err) {
 		return
 	}
+	err = keppel.ReleaseStorageQuotaReservation(tx, repo.ID, upload.StorageID)
+	if respondWithError(w, r, err) {
+		return
+	}
 
 	// perform the deletion in the storage backend, then make the DB change durable
 	if upload.NumChunks > 0 {
@@ -395,13 +536,17 @@ func (a *API) handleContinueBlobUpload(w http.ResponseWriter, r *http.Request) {
 			if err != nil {
 				logg.Error("additional error encountered while deleting Upload from DB: " + err.Error())
 			}
+			err = keppel.ReleaseStorageQuotaReservation(a.db, upload.RepositoryID, upload.StorageID)
+			if err != nil {
+				logg.Error("additional error encountered while releasing storage quota reservation: " + err.Error())
+			}
 			return
 		}
 		chunkSizeBytes = &lengthBytes
 	}
 
 	// append request body to upload
-	digestState, err := a.streamIntoUpload(r.Context(), *account, upload, dw, r.Body, chunkSizeBytes)
+	digestState, err := a.streamIntoUpload(r.Context(), *account, *repo, upload, dw, r.Body, chunkSizeBytes)
 	if respondWithError(w, r, err) {
 		return
 	}
@@ -442,7 +587,7 @@ func (a *API) handleFinishBlobUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if contentLength > 0 {
-			_, err = a.streamIntoUpload(r.Context(), *account, upload, dw, r.Body, &contentLength)
+			_, err = a.streamIntoUpload(r.Context(), *account, *repo, upload, dw, r.Body, &contentLength)
 			if respondWithError(w, r, err) {
 				return
 			}
@@ -459,6 +604,7 @@ func (a *API) handleFinishBlobUpload(w http.ResponseWriter, r *http.Request) {
 	// that up later.
 	var blob *models.Blob
 	err := a.sd.FinalizeBlob(r.Context(), *account, upload.StorageID, upload.NumChunks)
+	a.processor().RecordStorageQuotaError(*account, err)
 	if err == nil {
 		blob, err = a.createBlobFromUpload(r.Context(), *account, *repo, *upload, query.Get("digest"))
 	}
@@ -470,6 +616,10 @@ func (a *API) handleFinishBlobUpload(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			logg.Error("additional error encountered while deleting Upload from DB after late upload error: " + err.Error())
 		}
+		err = keppel.ReleaseStorageQuotaReservation(a.db, upload.RepositoryID, upload.StorageID)
+		if err != nil {
+			logg.Error("additional error encountered while releasing storage quota reservation after late upload error: " + err.Error())
+		}
 		err = a.sd.DeleteBlob(r.Context(), *account, upload.StorageID)
 		if err != nil {
 			logg.Error("additional error encountered during DeleteBlob() after late upload error: " + err.Error())
@@ -481,6 +631,7 @@ func (a *API) handleFinishBlobUpload(w http.ResponseWriter, r *http.Request) {
 	l := prometheus.Labels{"account": string(account.Name), "auth_tenant_id": account.AuthTenantID, "method": "registry-api"}
 	api.BlobsPushedCounter.With(l).Inc()
 	api.BlobBytesPushedCounter.With(l).Add(float64(blob.SizeBytes))
+	a.dn.Notify(r, "push", blobEventTarget(*blob, *repo), authz.UserIdentity)
 
 	w.Header().Set("Content-Length", "0")
 	w.Header().Set("Content-Range", makeRangeHeader(blob.SizeBytes))
@@ -518,6 +669,10 @@ func (a *API) resumeUpload(ctx context.Context, account models.ReducedAccount, u
 			if err != nil {
 				logg.Error("additional error encountered while deleting Upload from DB: " + err.Error())
 			}
+			err = keppel.ReleaseStorageQuotaReservation(a.db, upload.RepositoryID, upload.StorageID)
+			if err != nil {
+				logg.Error("additional error encountered while releasing storage quota reservation: " + err.Error())
+			}
 		}
 	}()
 
@@ -600,7 +755,7 @@ func (a *API) parseContentRange(upload *models.Upload, hdr http.Header) (uint64,
 	return length, nil
 }
 
-func (a *API) streamIntoUpload(ctx context.Context, account models.ReducedAccount, upload *models.Upload, dw *digestWriter, chunk io.Reader, chunkSizeBytes *uint64) (digestState string, returnErr error) {
+func (a *API) streamIntoUpload(ctx context.Context, account models.ReducedAccount, repo models.Repository, upload *models.Upload, dw *digestWriter, chunk io.Reader, chunkSizeBytes *uint64) (digestState string, returnErr error) {
 	// if anything happens during this operation, we likely have produced an
 	// inconsistent state between DB, storage backend and our internal book
 	// keeping (esp. the digestState in dw.Hash), so we will have to abort the
@@ -617,9 +772,38 @@ func (a *API) streamIntoUpload(ctx context.Context, account models.ReducedAccoun
 			if err != nil {
 				logg.Error("additional error encountered while deleting Upload from DB: " + err.Error())
 			}
+			err = keppel.ReleaseStorageQuotaReservation(a.db, upload.RepositoryID, upload.StorageID)
+			if err != nil {
+				logg.Error("additional error encountered while releasing storage quota reservation: " + err.Error())
+			}
 		}
 	}()
 
+	// if chunkSizeBytes is known, check that the storage driver can take a chunk this large
+	maxChunkSizeBytes := keppel.GetChunkSizeLimits(a.sd).MaxBytes
+	if chunkSizeBytes != nil && maxChunkSizeBytes > 0 && *chunkSizeBytes > maxChunkSizeBytes {
+		msg := fmt.Sprintf("chunk of %d bytes exceeds the maximum chunk size of %d bytes",
+			*chunkSizeBytes, maxChunkSizeBytes,
+		)
+		return "", keppel.ErrSizeInvalid.With(msg).WithStatus(http.StatusRequestEntityTooLarge)
+	}
+
+	// the storage backend is only chosen when the first chunk arrives, since
+	// the size of the first chunk is a lower bound for the size of the blob
+	// (when the upload was started, nothing was known about its size yet)
+	if upload.NumChunks == 0 && chunkSizeBytes != nil {
+		_, id := keppel.SplitStorageID(upload.StorageID)
+		upload.StorageID = keppel.StorageIDForNewBlob(a.sd, account, repo.Name, chunkSizeBytes, id)
+	}
+
+	// if chunkSizeBytes is known, reserve quota for the chunk before accepting any data
+	if chunkSizeBytes != nil {
+		err := keppel.ReserveStorageQuota(a.db, repo, upload.StorageID, upload.SizeBytes+*chunkSizeBytes, a.timeNow())
+		if err != nil {
+			return "", err
+		}
+	}
+
 	// stream data from request body into storage
 	sizeBytesBefore := upload.SizeBytes
 	err := a.processor().AppendToBlob(ctx, account, upload, io.TeeReader(chunk, dw), chunkSizeBytes)
@@ -636,6 +820,13 @@ func (a *API) streamIntoUpload(ctx context.Context, account models.ReducedAccoun
 		return "", keppel.ErrSizeInvalid.With(msg).WithStatus(http.StatusRequestedRangeNotSatisfiable)
 	}
 
+	// if chunkSizeBytes was not known, we can only check the quota now (this also
+	// renews the reservation for uploads that take a long time)
+	err = keppel.ReserveStorageQuota(a.db, repo, upload.StorageID, upload.SizeBytes, a.timeNow())
+	if err != nil {
+		return "", err
+	}
+
 	// serialize digest state for next resumeUpload() - note that we do this
 	// BEFORE digest.NewDigest() because digest.NewDigest() may alter the
 	// internal state of `dw.Hash`
@@ -675,12 +866,21 @@ func (a *API) createBlobFromUpload(ctx context.Context, account models.ReducedAc
 	}
 	defer sqlext.RollbackUnlessCommitted(tx)
 
+	// the reservation for this upload is replaced by the blob mount within the same transaction
+	blobPushedAt := a.timeNow()
+	err = keppel.CheckStorageQuotaForBlobMount(tx, repo, blobDigest, upload.SizeBytes, upload.StorageID, blobPushedAt)
+	if err != nil {
+		return nil, err
+	}
 	_, err = tx.Delete(&upload)
 	if err != nil {
 		return nil, err
 	}
+	err = keppel.ReleaseStorageQuotaReservation(tx, repo.ID, upload.StorageID)
+	if err != nil {
+		return nil, err
+	}
 
-	blobPushedAt := a.timeNow()
 	blob, err = a.createOrUpdateBlobObject(ctx, tx, upload.SizeBytes, upload.StorageID, blobDigest, blobPushedAt, account)
 	if err != nil {
 		return nil, err
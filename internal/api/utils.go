@@ -8,7 +8,7 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/sapcc/go-bits/httpext"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/sapcc/keppel/internal/auth"
 	"github.com/sapcc/keppel/internal/keppel"
@@ -29,14 +29,52 @@ func CheckRateLimit(r *http.Request, rle *keppel.RateLimitEngine, account models
 		return nil
 	}
 
-	allowed, result, err := rle.RateLimitAllows(r.Context(), httpext.GetRequesterIPFor(r), account, action, amount)
+	// operators can exempt specific accounts, networks or users from rate-limits
+	remoteAddr := keppel.ClientIPFor(r)
+	isExempt, err := rle.IsExempt(remoteAddr, account.Name, authz.UserIdentity.UserName())
+	if err != nil {
+		return err
+	}
+	if isExempt {
+		return nil
+	}
+
+	allowed, result, err := rle.RateLimitAllows(r.Context(), remoteAddr, account, action, amount)
 	if err != nil {
 		return err
 	}
 	if !allowed {
 		retryAfterStr := strconv.FormatUint(keppel.AtLeastZero(int64(result.RetryAfter/time.Second)), 10)
-		return keppel.ErrTooManyRequests.With("").WithHeader("Retry-After", retryAfterStr)
+		return keppel.ErrTooManyRequests.With("").WithHeader("Retry-After", retryAfterStr).
+			WithDetail(keppel.RetryAfterDetail(keppel.ReasonRateLimited, result.RetryAfter))
 	}
 
 	return nil
 }
+
+// AcquireConcurrencySlot reserves a slot for this request in the
+// ConcurrencyLimiter. On success, the returned function must be called to
+// release the slot once the request has been processed.
+func AcquireConcurrencySlot(acl *keppel.ConcurrencyLimiter, account models.ReducedAccount, authz *auth.Authorization) (release func(), err error) {
+	// concurrency limits are optional
+	if acl == nil {
+		return func() {}, nil
+	}
+
+	// cluster-internal traffic is exempt from concurrency limits for the same
+	// reason as in CheckRateLimit()
+	userType := authz.UserIdentity.UserType()
+	if userType == keppel.PeerUser || userType == keppel.TrivyUser {
+		return func() {}, nil
+	}
+
+	if !acl.TryAcquire(account.Name) {
+		ConcurrencyLimitRejectionsCounter.With(prometheus.Labels{
+			"account":        string(account.Name),
+			"auth_tenant_id": account.AuthTenantID,
+		}).Inc()
+		return nil, keppel.ErrTooManyRequests.With("too many concurrent requests for account %q", account.Name).WithHeader("Retry-After", "1").
+			WithDetail(keppel.RetryAfterDetail(keppel.ReasonConcurrencyLimited, time.Second))
+	}
+	return func() { acl.Release(account.Name) }, nil
+}
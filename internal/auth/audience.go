@@ -21,6 +21,9 @@ type Audience struct {
 	// When using a domain-remapped API, contains the account name specified in the domain name.
 	// Otherwise, contains the empty string.
 	AccountName models.AccountName
+	// When using the custom domain of an account, contains that domain name.
+	// AccountName is filled as well in this case, and IsAnycast is false.
+	CustomDomain string
 }
 
 // IdentifyAudience returns the Audience corresponding to the given domain name.
@@ -61,12 +64,34 @@ func IdentifyAudience(hostname string, cfg keppel.Configuration) Audience {
 	return Audience{IsAnycast: false, AccountName: ""}
 }
 
+// IdentifyAudienceOrCustomDomain is like IdentifyAudience, but if the
+// hostname is not one of our well-known hostnames, it also checks whether it
+// is the verified custom domain of one of our accounts.
+func IdentifyAudienceOrCustomDomain(hostname string, cfg keppel.Configuration, db *keppel.DB) (Audience, error) {
+	audience := IdentifyAudience(hostname, cfg)
+	if hostname == "" || audience.Hostname(cfg) == hostname {
+		return audience, nil
+	}
+
+	accountName, err := keppel.FindAccountForCustomDomain(db, hostname)
+	if err != nil {
+		return Audience{}, err
+	}
+	if accountName == "" {
+		return audience, nil
+	}
+	return Audience{IsAnycast: false, AccountName: accountName, CustomDomain: hostname}, nil
+}
+
 // Hostname returns the hostname that is used as the "audience" value in tokens
 // and as the "service" value in auth challenges. This is the inverse operation
 // of IdentifyAudience in the following sense:
 //
 //	audience == IdentifyAudience(audience.Hostname(cfg), cfg)
 func (a Audience) Hostname(cfg keppel.Configuration) string {
+	if a.CustomDomain != "" {
+		return a.CustomDomain
+	}
 	result := cfg.APIPublicHostname
 	if a.IsAnycast {
 		result = cfg.AnycastAPIPublicHostname
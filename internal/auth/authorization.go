@@ -3,7 +3,15 @@
 
 package auth
 
-import "github.com/sapcc/keppel/internal/keppel"
+import (
+	"slices"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
 
 // Authorization describes the access rights of a particular user session, i.e.
 // in the scope of an individual API request.
@@ -15,4 +23,31 @@ type Authorization struct {
 	ScopeSet ScopeSet
 	// Audience identifies the API endpoint where the user sent the request.
 	Audience Audience
+	// DigestRestriction is only set for narrowed tokens (see
+	// POST /keppel/v1/auth/narrow). If set, the ScopeSet may only be used to
+	// pull the blobs and manifests with these digests.
+	DigestRestriction []digest.Digest
+	// ExpiresAt is only set if the Authorization was obtained from a token.
+	ExpiresAt time.Time
+	// PolicyEpochs contains the RBAC policy epoch (see Account.RBACPolicyEpoch)
+	// of each account that repository scopes in the ScopeSet refer to. When the
+	// Authorization is obtained from a token, the token is only accepted if these
+	// epochs are still current.
+	PolicyEpochs map[models.AccountName]int64
+	// KeppelLabelRestrictions is set for repositories (identified by their full
+	// name) where pull access was only granted by RBAC policies with the
+	// "match_keppel_labels" attribute. Manifests and blobs in these repos may
+	// only be pulled if they belong to a manifest that has all the Keppel labels
+	// of at least one of the listed label sets.
+	KeppelLabelRestrictions map[string][]map[string]string
+	// ClientID is only set if the Authorization was obtained from a token that
+	// was requested with the "client_id" parameter. It is used to attribute
+	// pulls to the clients that performed them (see keppel.PullAttestationRecorder).
+	ClientID string
+}
+
+// AllowsDigest returns whether the DigestRestriction (if any) permits access
+// to the blob or manifest with the given digest.
+func (a Authorization) AllowsDigest(d digest.Digest) bool {
+	return a.DigestRestriction == nil || slices.Contains(a.DigestRestriction, d)
 }
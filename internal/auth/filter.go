@@ -7,19 +7,27 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	. "github.com/majewsky/gg/option"
-	"github.com/sapcc/go-bits/httpext"
+	"github.com/sapcc/go-bits/sqlext"
 
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 )
 
-// Produces a new ScopeSet containing only those scopes that the given
-// `uid` is permitted to access and only those actions therein which this `uid`
-// is permitted to perform.
-func filterAuthorized(ir IncomingRequest, uid keppel.UserIdentity, audience Audience, db *keppel.DB) (ScopeSet, error) {
+// Fills authz.ScopeSet with only those of the requested scopes that
+// authz.UserIdentity is permitted to access and only those actions therein
+// which this user is permitted to perform.
+//
+// Also fills authz.PolicyEpochs and authz.KeppelLabelRestrictions for all
+// repositories to which access was granted.
+func (ir IncomingRequest) filterAuthorized(authz *Authorization, db *keppel.DB) error {
+	uid := authz.UserIdentity
+	audience := authz.Audience
 	result := make(ScopeSet, 0, len(ir.Scopes))
+	authz.PolicyEpochs = make(map[models.AccountName]int64)
 	// make sure that additional scopes get appended at the end, on the offchance
 	// that a client might parse its token and look at access[0] to check for its
 	// authorization
@@ -32,14 +40,14 @@ func filterAuthorized(ir IncomingRequest, uid keppel.UserIdentity, audience Audi
 		case "registry":
 			filtered.Actions, err = filterRegistryActions(uid, audience, db, scope, &additional)
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 		case "repository":
-			ip := httpext.GetRequesterIPFor(ir.HTTPRequest)
-			filtered.Actions, err = filterRepoActions(ip, *scope, uid, audience, db)
+			ip := keppel.ClientIPFor(ir.HTTPRequest)
+			filtered.Actions, err = filterRepoActions(ip, *scope, authz, db, ir.RBACPolicyUsage, ir.TimeNow)
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 		case "keppel_api":
@@ -48,6 +56,8 @@ func filterAuthorized(ir IncomingRequest, uid keppel.UserIdentity, audience Audi
 				filtered.Actions = PeerAPIScope.Actions
 			case scope.Contains(InfoAPIScope) && uid.UserType() != keppel.AnonymousUser:
 				filtered.Actions = InfoAPIScope.Actions
+			case scope.Contains(AdminAPIScope) && uid.HasPermission(keppel.CanAdministrateKeppel, ""):
+				filtered.Actions = AdminAPIScope.Actions
 			default:
 				filtered.Actions = nil
 			}
@@ -55,7 +65,7 @@ func filterAuthorized(ir IncomingRequest, uid keppel.UserIdentity, audience Audi
 		case "keppel_account":
 			filtered.Actions, err = filterKeppelAccountActions(uid, audience, db, scope)
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 		case "keppel_auth_tenant":
@@ -77,7 +87,8 @@ func filterAuthorized(ir IncomingRequest, uid keppel.UserIdentity, audience Audi
 		result.Add(filtered)
 	}
 
-	return append(result, additional...), nil
+	authz.ScopeSet = append(result, additional...)
+	return nil
 }
 
 func addCatalogAccess(ss *ScopeSet, uid keppel.UserIdentity, audience Audience, db *keppel.DB) error {
@@ -144,27 +155,46 @@ func filterRegistryActions(uid keppel.UserIdentity, audience Audience, db *keppe
 	return filtered, nil
 }
 
-func filterRepoActions(ip string, scope Scope, uid keppel.UserIdentity, audience Audience, db *keppel.DB) ([]string, error) {
-	repoScope := scope.ParseRepositoryScope(audience)
+var repoActionsAccountQuery = sqlext.SimplifyWhitespace(`
+	SELECT a.auth_tenant_id, a.rbac_policies_json, a.rbac_policy_epoch, COALESCE(n.prefix, ''), COALESCE(n.rbac_policies_json, '')
+	  FROM accounts a
+	  LEFT OUTER JOIN repo_namespaces n ON n.account_name = a.name AND starts_with($2, n.prefix || '/')
+	 WHERE a.name = $1
+`)
+
+func filterRepoActions(ip string, scope Scope, authz *Authorization, db *keppel.DB, rbacPolicyUsage *keppel.RBACPolicyUsageRecorder, timeNow func() time.Time) ([]string, error) {
+	uid := authz.UserIdentity
+	repoScope := scope.ParseRepositoryScope(authz.Audience)
 	if repoScope.RepositoryName == "" {
 		// this happens when we are not on a domain-remapped API and thus expect a
 		// scope.ResourceName of the form "account/repo", but we only got "account"
 		// without any slashes
 		return nil, nil
 	}
+	if robot, ok := uid.(*RobotUserIdentity); ok {
+		if robot.AccountName != repoScope.AccountName || robot.RepoName != repoScope.RepositoryName {
+			// outside of the repository that they were generated for, robot users
+			// only get the same access as anonymous users
+			uid = AnonymousUserIdentity
+		}
+	}
 
 	// NOTE: As an optimization, this only loads the few required fields for the account
 	// instead of the entire `accounts` row. Before this optimization, the loads
 	// via keppel.FindAccount() at this callsite made up 8% of all allocations
 	// performed by keppel-api.
+	// The repo's namespace (if any) is loaded in the same query. Since namespaces
+	// cannot be nested, there is at most one matching namespace.
 	var (
 		authTenantID     string
 		rbacPoliciesJSON string
+		rbacPolicyEpoch  int64
+		namespace        models.RepositoryNamespace
 	)
 	err := db.QueryRow(
-		`SELECT auth_tenant_id, rbac_policies_json FROM accounts WHERE name = $1`,
-		repoScope.AccountName,
-	).Scan(&authTenantID, &rbacPoliciesJSON)
+		repoActionsAccountQuery,
+		repoScope.AccountName, repoScope.RepositoryName,
+	).Scan(&authTenantID, &rbacPoliciesJSON, &rbacPolicyEpoch, &namespace.Prefix, &namespace.RBACPoliciesJSON)
 	if errors.Is(err, sql.ErrNoRows) {
 		// if the account does not exist, we cannot give access to it
 		// (this is not an error, because an error would leak information on which accounts exist)
@@ -178,12 +208,44 @@ func filterRepoActions(ip string, scope Scope, uid keppel.UserIdentity, audience
 	if err != nil {
 		return nil, fmt.Errorf("while parsing account RBAC policies: %w", err)
 	}
+	accountPolicyCount := len(policies)
+	if namespace.Prefix != "" {
+		nsPolicies, err := keppel.NamespaceRBACPolicies(namespace)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing RBAC policies of namespace %q: %w", namespace.Prefix, err)
+		}
+		policies = append(policies, nsPolicies...)
+	}
 	permOverride := make(map[keppel.RBACPermission]Option[bool])
+	var keppelLabelRestrictions []map[string]string
 	userName := uid.UserName()
-	for _, policy := range policies {
+	for idx, policy := range policies {
 		if !policy.Matches(ip, repoScope.RepositoryName, userName) {
 			continue
 		}
+		// usage is only tracked for the account's own policies since these are
+		// what the credential report covers
+		if rbacPolicyUsage != nil && idx < accountPolicyCount {
+			now := time.Now()
+			if timeNow != nil {
+				now = timeNow()
+			}
+			rbacPolicyUsage.Record(repoScope.AccountName, policy, now)
+		}
+		// policies with "match_keppel_labels" can only grant pull access to some
+		// manifests, which we will consider below if no other policy grants pull
+		// access to the whole repo
+		if len(policy.KeppelLabels) > 0 {
+			for _, perm := range policy.Permissions {
+				isAnonymousPerm := perm == keppel.RBACAnonymousPullPermission
+				if isAnonymousPerm || uid.UserType() != keppel.AnonymousUser {
+					keppelLabelRestrictions = append(keppelLabelRestrictions, policy.KeppelLabels)
+					break
+				}
+			}
+			continue
+		}
+
 		// NOTE: forbidding overrides take precedence over granting overrides
 		for _, perm := range policy.Permissions {
 			if permOverride[perm] != Some(false) {
@@ -200,13 +262,22 @@ func filterRepoActions(ip string, scope Scope, uid keppel.UserIdentity, audience
 		delete(permOverride, keppel.RBACPullPermission)
 		delete(permOverride, keppel.RBACPushPermission)
 		delete(permOverride, keppel.RBACDeletePermission)
+		delete(permOverride, keppel.RBACPromotePermission)
+	}
+
+	// robot users can pull from the one repository that they were generated for,
+	// but RBAC policies cannot grant them any further permissions
+	canPullByDefault := uid.HasPermission(keppel.CanPullFromAccount, authTenantID)
+	if uid.UserType() == keppel.RobotUser {
+		canPullByDefault = true
+		delete(permOverride, keppel.RBACPushPermission)
+		delete(permOverride, keppel.RBACDeletePermission)
+		delete(permOverride, keppel.RBACPromotePermission)
 	}
 
 	// evaluate final permission set
 	isAllowedAction := map[string]bool{
-		"pull": permOverride[keppel.RBACPullPermission].UnwrapOr(
-			uid.HasPermission(keppel.CanPullFromAccount, authTenantID),
-		),
+		"pull": permOverride[keppel.RBACPullPermission].UnwrapOr(canPullByDefault),
 		"push": permOverride[keppel.RBACPushPermission].UnwrapOr(
 			uid.HasPermission(keppel.CanPushToAccount, authTenantID),
 		),
@@ -220,6 +291,21 @@ func filterRepoActions(ip string, scope Scope, uid keppel.UserIdentity, audience
 	if isAllowedAction["pull"] {
 		isAllowedAction["anonymous_first_pull"] = permOverride[keppel.RBACAnonymousFirstPullPermission].UnwrapOr(false)
 	}
+	// reading vulnerability reports is allowed for everyone who can pull
+	isAllowedAction["scan_read"] = isAllowedAction["pull"]
+	// if pull access was not granted for the whole repo, it may still be granted
+	// for manifests with specific Keppel labels (this is done after the
+	// previous steps since this kind of access should not imply "scan_read" or
+	// "anonymous_first_pull")
+	isRestrictedPull := false
+	if !isAllowedAction["pull"] && len(keppelLabelRestrictions) > 0 && permOverride[keppel.RBACPullPermission] != Some(false) {
+		isAllowedAction["pull"] = true
+		isRestrictedPull = true
+	}
+	// changing promotion states is reserved to account admins by default
+	isAllowedAction["promote"] = permOverride[keppel.RBACPromotePermission].UnwrapOr(
+		uid.HasPermission(keppel.CanChangeAccount, authTenantID),
+	)
 
 	// grant requested actions as possible
 	var result []string
@@ -231,6 +317,19 @@ func filterRepoActions(ip string, scope Scope, uid keppel.UserIdentity, audience
 			result = append(result, "anonymous_first_pull")
 		}
 	}
+
+	// remember the policy epoch that this decision was based on, so that tokens
+	// can be invalidated when the RBAC policies change
+	if len(result) > 0 && authz.PolicyEpochs != nil {
+		authz.PolicyEpochs[repoScope.AccountName] = rbacPolicyEpoch
+	}
+	if isRestrictedPull && slices.Contains(result, "pull") {
+		if authz.KeppelLabelRestrictions == nil {
+			authz.KeppelLabelRestrictions = make(map[string][]map[string]string)
+		}
+		fullRepoName := fmt.Sprintf("%s/%s", repoScope.AccountName, repoScope.RepositoryName)
+		authz.KeppelLabelRestrictions[fullRepoName] = keppelLabelRestrictions
+	}
 	return result, nil
 }
 
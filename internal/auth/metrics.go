@@ -0,0 +1,21 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// StaleTokenRejectionCounter is a prometheus.CounterVec.
+	StaleTokenRejectionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_stale_token_rejections",
+			Help: "Counter for tokens that were rejected because the RBAC policies of the respective account changed after the token was issued.",
+		},
+		[]string{"account"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(StaleTokenRejectionCounter)
+}
@@ -7,11 +7,15 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
 )
 
 // IncomingRequest describes everything we need to know about an incoming API
@@ -40,6 +44,12 @@ type IncomingRequest struct {
 	// If true, Authorize() will not assume an AnonymousUserIdentity when no auth
 	// headers are provided. Users MUST present some sort of auth header.
 	NoImplicitAnonymous bool
+	// If not nil, the use of RBAC policies for authorizing this request is
+	// recorded here.
+	RBACPolicyUsage *keppel.RBACPolicyUsageRecorder
+	// If not nil, this clock is used instead of time.Now() for recording RBAC
+	// policy usage and for checking the expiry of robot credentials.
+	TimeNow func() time.Time
 }
 
 // Authorize checks if the given incoming request has a proper Authorization.
@@ -56,7 +66,11 @@ func (ir IncomingRequest) Authorize(ctx context.Context, cfg keppel.Configuratio
 		audience = *ir.AudienceForTokenIssuance
 	} else {
 		u := keppel.OriginalRequestURL(r)
-		audience = IdentifyAudience(u.Hostname(), cfg)
+		var err error
+		audience, err = IdentifyAudienceOrCustomDomain(u.Hostname(), cfg, db)
+		if err != nil {
+			return nil, nil, keppel.AsRegistryV2Error(err)
+		}
 
 		// special case: an anycast request was explicitly reverse-proxied to our
 		// non-anycast API by the keppel-api that originally received it
@@ -104,7 +118,11 @@ func (ir IncomingRequest) Authorize(ctx context.Context, cfg keppel.Configuratio
 			// though that is completely nonsensical
 			return nil, nil, challenge.AddTo(keppel.ErrUnauthorized.With("basic auth is not supported on this endpoint, your library's auth workflow is probably broken"))
 		}
-		uid, err := checkBasicAuth(ctx, authHeader, ad, db)
+		now := time.Now()
+		if ir.TimeNow != nil {
+			now = ir.TimeNow()
+		}
+		uid, err := checkBasicAuth(ctx, authHeader, ad, db, now)
 		if err != nil {
 			return nil, nil, keppel.AsRegistryV2Error(err)
 		}
@@ -116,7 +134,11 @@ func (ir IncomingRequest) Authorize(ctx context.Context, cfg keppel.Configuratio
 	case strings.HasPrefix(authHeader, "Bearer "):
 		// clearly a request for token auth
 		var rerr *keppel.RegistryV2Error
-		authz, rerr = parseToken(cfg, ad, audience, strings.TrimPrefix(authHeader, "Bearer "))
+		authz, rerr = parseTokenWithTrivyCache(cfg, ad, audience, strings.TrimPrefix(authHeader, "Bearer "))
+		if rerr != nil {
+			return nil, nil, challenge.AddTo(rerr)
+		}
+		rerr = checkPolicyEpochs(authz, db)
 		if rerr != nil {
 			return nil, nil, challenge.AddTo(rerr)
 		}
@@ -205,7 +227,7 @@ func (ir IncomingRequest) buildAuthChallenge(cfg keppel.Configuration, audience
 
 var errMalformedAuthHeader = keppel.ErrUnauthorized.With("malformed Authorization header")
 
-func checkBasicAuth(ctx context.Context, authHeader string, ad keppel.AuthDriver, db *keppel.DB) (keppel.UserIdentity, error) {
+func checkBasicAuth(ctx context.Context, authHeader string, ad keppel.AuthDriver, db *keppel.DB, now time.Time) (keppel.UserIdentity, error) {
 	// decode auth header into username/password pair
 	bytes, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authHeader, "Basic "))
 	if err != nil {
@@ -230,6 +252,15 @@ func checkBasicAuth(ctx context.Context, authHeader string, ad keppel.AuthDriver
 		return &PeerUserIdentity{PeerHostName: peerHostName}, nil
 	}
 
+	// recognize robot credentials
+	robotUID, err := checkRobotCredentials(db, userName, password, now)
+	if err != nil {
+		return nil, err
+	}
+	if robotUID != nil {
+		return robotUID, nil
+	}
+
 	// recognize regular user credentials
 	uid, rerr := ad.AuthenticateUser(ctx, userName, password)
 	return uid, safelyReturnRegistryError(rerr)
@@ -247,14 +278,57 @@ func safelyReturnRegistryError(rerr *keppel.RegistryV2Error) error {
 }
 
 func (ir IncomingRequest) authorizeViaUserIdentity(uid keppel.UserIdentity, audience Audience, db *keppel.DB) (*Authorization, error) {
-	ss, err := filterAuthorized(ir, uid, audience, db)
+	authz := &Authorization{
+		UserIdentity: uid,
+		Audience:     audience,
+	}
+	err := ir.filterAuthorized(authz, db)
 	if err != nil {
 		return nil, err
 	}
+	return authz, nil
+}
+
+// Checks that the RBAC policy epochs recorded in a token are still current.
+// If the RBAC policies of any of the respective accounts have changed since the
+// token was issued, the token is rejected since it might grant access that has
+// since been revoked.
+func checkPolicyEpochs(authz *Authorization, db *keppel.DB) *keppel.RegistryV2Error {
+	if len(authz.PolicyEpochs) == 0 {
+		return nil
+	}
+	accountNames := slices.Sorted(maps.Keys(authz.PolicyEpochs))
 
-	return &Authorization{
-		UserIdentity: uid,
-		Audience:     audience,
-		ScopeSet:     ss,
-	}, nil
+	// the cache is only trusted to accept tokens; before rejecting a token, we
+	// double-check with the DB in case the cache is outdated
+	currentEpochs, err := keppel.GetRBACPolicyEpochs(db, accountNames, false)
+	if err != nil {
+		return keppel.AsRegistryV2Error(err)
+	}
+	if findStalePolicyEpoch(authz, accountNames, currentEpochs) == "" {
+		return nil
+	}
+	currentEpochs, err = keppel.GetRBACPolicyEpochs(db, accountNames, true)
+	if err != nil {
+		return keppel.AsRegistryV2Error(err)
+	}
+	accountName := findStalePolicyEpoch(authz, accountNames, currentEpochs)
+	if accountName == "" {
+		return nil
+	}
+	StaleTokenRejectionCounter.WithLabelValues(string(accountName)).Inc()
+	return keppel.ErrUnauthorized.With("token was issued before the access policies of account %q changed; please obtain a new token", accountName)
+}
+
+// Returns the first account whose current RBAC policy epoch does not match the
+// one in the token, or the empty string if all epochs match.
+func findStalePolicyEpoch(authz *Authorization, accountNames []models.AccountName, currentEpochs map[models.AccountName]int64) models.AccountName {
+	for _, accountName := range accountNames {
+		// if the account was deleted in the meantime, the epoch does not match either
+		currentEpoch, exists := currentEpochs[accountName]
+		if !exists || currentEpoch != authz.PolicyEpochs[accountName] {
+			return accountName
+		}
+	}
+	return ""
 }
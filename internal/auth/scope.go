@@ -83,6 +83,11 @@ func (s Scope) Contains(other Scope) bool {
 	for _, a := range s.Actions {
 		actions[a] = true
 	}
+	// "scan_read" is a narrower version of "pull" that only allows reading
+	// vulnerability reports, so tokens with "pull" still cover it
+	if s.ResourceType == "repository" && actions["pull"] {
+		actions["scan_read"] = true
+	}
 	for _, a := range other.Actions {
 		if !actions[a] {
 			return false
@@ -123,3 +128,11 @@ var InfoAPIScope = Scope{
 	ResourceName: "info",
 	Actions:      []string{"access"},
 }
+
+// AdminAPIScope is the Scope for all endpoints that perform cluster-wide
+// administrative operations.
+var AdminAPIScope = Scope{
+	ResourceType: "keppel_api",
+	ResourceName: "admin",
+	Actions:      []string{"access"},
+}
@@ -15,8 +15,10 @@ import (
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/opencontainers/go-digest"
 
 	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
 )
 
 func init() {
@@ -27,8 +29,12 @@ func init() {
 // Type representation for JWT claims issued by Keppel.
 type tokenClaims struct {
 	jwt.RegisteredClaims
-	Access   []Scope              `json:"access"`
-	Embedded embeddedUserIdentity `json:"kea"` // kea = keppel embedded authorization ("UserIdentity" used to be called "Authorization")
+	Access   []Scope                        `json:"access"`
+	Embedded embeddedUserIdentity           `json:"kea"`           // kea = keppel embedded authorization ("UserIdentity" used to be called "Authorization")
+	Digests  []digest.Digest                `json:"kdr,omitempty"` // kdr = keppel digest restriction (see Authorization.DigestRestriction)
+	Epochs   map[models.AccountName]int64   `json:"kpe,omitempty"` // kpe = keppel policy epochs (see Authorization.PolicyEpochs)
+	Labels   map[string][]map[string]string `json:"klr,omitempty"` // klr = keppel label restrictions (see Authorization.KeppelLabelRestrictions)
+	ClientID string                         `json:"kci,omitempty"` // kci = keppel client ID (see Authorization.ClientID)
 }
 
 func parseToken(cfg keppel.Configuration, ad keppel.AuthDriver, audience Audience, tokenStr string) (*Authorization, *keppel.RegistryV2Error) {
@@ -81,11 +87,37 @@ func parseToken(cfg keppel.Configuration, ad keppel.AuthDriver, audience Audienc
 	for _, scope := range claims.Access {
 		ss.Add(scope)
 	}
-	return &Authorization{
-		UserIdentity: claims.Embedded.UserIdentity,
-		ScopeSet:     ss,
-		Audience:     audience,
-	}, nil
+	authz := &Authorization{
+		UserIdentity:      claims.Embedded.UserIdentity,
+		ScopeSet:          ss,
+		Audience:          audience,
+		DigestRestriction: claims.Digests,
+		// unlike the policy epochs, label restrictions are also honored on
+		// anycast tokens since they can only ever reduce access
+		KeppelLabelRestrictions: claims.Labels,
+		ClientID:                claims.ClientID,
+	}
+	if !audience.IsAnycast {
+		// anycast tokens may have been issued by a peer, so the epochs therein
+		// refer to that peer's database and cannot be checked by us
+		authz.PolicyEpochs = claims.Epochs
+	}
+	if claims.ExpiresAt != nil {
+		authz.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return authz, nil
+}
+
+// ParseTokenFromPeer validates a token that was obtained from a peer's auth
+// API on behalf of an anycast request, and returns the Authorization described
+// by it. This is used to combine tokens for scopes that are served by
+// different peers into one token.
+func ParseTokenFromPeer(cfg keppel.Configuration, ad keppel.AuthDriver, audience Audience, tokenStr string) (*Authorization, error) {
+	if !audience.IsAnycast {
+		return nil, errors.New("tokens from peers can only be accepted for anycast requests")
+	}
+	authz, rerr := parseToken(cfg, ad, audience, tokenStr)
+	return authz, safelyReturnRegistryError(rerr)
 }
 
 // TokenResponse is the format expected by Docker in an auth response. The Token
@@ -117,12 +149,17 @@ func (a Authorization) IssueTokenWithExpires(cfg keppel.Configuration, expiresIn
 
 	// fill the "issuer" field with a dummy audience that has anycast forced to
 	// false to reveal the identity of the Keppel API that issued the token
-	issuer := Audience{IsAnycast: false, AccountName: a.Audience.AccountName}
+	issuer := Audience{IsAnycast: false, AccountName: a.Audience.AccountName, CustomDomain: a.Audience.CustomDomain}
 
 	uuidV4, err := uuid.NewV4()
 	if err != nil {
 		return nil, err
 	}
+	var policyEpochs map[models.AccountName]int64
+	if !a.Audience.IsAnycast {
+		policyEpochs = a.PolicyEpochs
+	}
+
 	publicHost := a.Audience.Hostname(cfg)
 	token := jwt.NewWithClaims(method, tokenClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -137,6 +174,10 @@ func (a Authorization) IssueTokenWithExpires(cfg keppel.Configuration, expiresIn
 		// access permissions granted to this token
 		Access:   a.ScopeSet.Flatten(),
 		Embedded: embeddedUserIdentity{UserIdentity: a.UserIdentity},
+		Digests:  a.DigestRestriction,
+		Epochs:   policyEpochs,
+		Labels:   a.KeppelLabelRestrictions,
+		ClientID: a.ClientID,
 	})
 	// we need to remember which key we used for this token, to choose the right
 	// key for validation during parseToken()
@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// How long tokens issued by IssueTokenForTrivy are valid, unless the scan
+// timeout requires a longer lifetime.
+const trivyTokenLifetime = 20 * time.Minute
+
+// Returns how long tokens issued by IssueTokenForTrivy are valid, and how long
+// they need to remain valid to be reused by IssueTokenForTrivy. The latter
+// needs to cover the given scan timeout (plus the headroom that the janitor
+// gives trivy-proxy), so that the token does not expire mid-scan.
+func trivyTokenLifetimes(scanTimeout time.Duration) (lifetime, minRemainingLifetime time.Duration) {
+	minRemainingLifetime = scanTimeout + 30*time.Second
+	return max(trivyTokenLifetime, minRemainingLifetime), minRemainingLifetime
+}
+
+// Trivy pulls each layer of an image with a separate request, and every
+// request needs to have its token validated. Since the same token is presented
+// over and over while an image is scanned, and Trivy tokens do not depend on
+// anything in the DB (in particular, they do not carry RBAC policy epochs), we
+// remember which Trivy tokens we have already validated until they expire.
+//
+// Likewise, we reuse issued Trivy tokens for subsequent scans of images in the
+// same repository, so that consecutive scans present the same token.
+var (
+	trivyTokenCacheMutex sync.Mutex
+	// key = audience + token string
+	validatedTrivyTokens = make(map[validatedTrivyTokenKey]Authorization)
+	// key = see issuedTrivyTokenKey()
+	issuedTrivyTokens = make(map[string]issuedTrivyToken)
+)
+
+type validatedTrivyTokenKey struct {
+	Audience Audience
+	Token    string
+}
+
+type issuedTrivyToken struct {
+	Response  TokenResponse
+	ExpiresAt time.Time
+}
+
+// Like parseToken, but validated Trivy tokens are remembered until they expire.
+func parseTokenWithTrivyCache(cfg keppel.Configuration, ad keppel.AuthDriver, audience Audience, tokenStr string) (*Authorization, *keppel.RegistryV2Error) {
+	key := validatedTrivyTokenKey{audience, tokenStr}
+	now := time.Now()
+
+	trivyTokenCacheMutex.Lock()
+	cached, ok := validatedTrivyTokens[key]
+	trivyTokenCacheMutex.Unlock()
+	if ok && now.Before(cached.ExpiresAt) {
+		cached.ScopeSet = slices.Clone(cached.ScopeSet)
+		return &cached, nil
+	}
+
+	authz, rerr := parseToken(cfg, ad, audience, tokenStr)
+	if rerr != nil || authz.UserIdentity.UserType() != keppel.TrivyUser || len(authz.PolicyEpochs) > 0 || authz.ExpiresAt.IsZero() {
+		return authz, rerr
+	}
+
+	trivyTokenCacheMutex.Lock()
+	defer trivyTokenCacheMutex.Unlock()
+	for k, v := range validatedTrivyTokens {
+		if !now.Before(v.ExpiresAt) {
+			delete(validatedTrivyTokens, k)
+		}
+	}
+	cached = *authz
+	cached.ScopeSet = slices.Clone(authz.ScopeSet)
+	validatedTrivyTokens[key] = cached
+	return authz, nil
+}
+
+// Tokens can only be reused when they are accepted by the same set of issuer
+// keys and grant the same scopes.
+func issuedTrivyTokenKey(cfg keppel.Configuration, repoFullName string) string {
+	issuerKeys := Audience{}.IssuerKeys(cfg)
+	if len(issuerKeys) == 0 {
+		return ""
+	}
+	return strings.Join(append([]string{
+		cfg.APIPublicHostname,
+		serializePublicKey(issuerKeys[0]),
+		repoFullName,
+	}, cfg.Trivy.AdditionalPullableRepos...), "\n")
+}
+
+func getIssuedTrivyToken(key string, now time.Time, minRemainingLifetime time.Duration) (*TokenResponse, bool) {
+	trivyTokenCacheMutex.Lock()
+	defer trivyTokenCacheMutex.Unlock()
+	cached, ok := issuedTrivyTokens[key]
+	if !ok || cached.ExpiresAt.Sub(now) < minRemainingLifetime {
+		return nil, false
+	}
+	resp := cached.Response
+	resp.ExpiresIn = uint64(cached.ExpiresAt.Sub(now).Seconds())
+	return &resp, true
+}
+
+func putIssuedTrivyToken(key string, resp TokenResponse, now time.Time, minRemainingLifetime time.Duration) {
+	trivyTokenCacheMutex.Lock()
+	defer trivyTokenCacheMutex.Unlock()
+	for k, v := range issuedTrivyTokens {
+		if v.ExpiresAt.Sub(now) < minRemainingLifetime {
+			delete(issuedTrivyTokens, k)
+		}
+	}
+	issuedTrivyTokens[key] = issuedTrivyToken{
+		Response:  resp,
+		ExpiresAt: now.Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/trivy"
+)
+
+type stubAuthDriver struct {
+	keppel.AuthDriver
+}
+
+func TestTrivyTokenCache(t *testing.T) {
+	_, issuerKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cfg := keppel.Configuration{
+		APIPublicHostname: "registry.example.org",
+		JWTIssuerKeys:     []crypto.PrivateKey{issuerKey},
+		Trivy:             &trivy.Config{},
+	}
+
+	// tokens are reused for the same repository...
+	resp1, err := IssueTokenForTrivy(cfg, "test1/foo", trivy.DefaultScanTimeout)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp2, err := IssueTokenForTrivy(cfg, "test1/foo", trivy.DefaultScanTimeout)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "reused token", resp2.Token, resp1.Token)
+
+	// ...but not for a different repository or a different issuer key
+	resp3, err := IssueTokenForTrivy(cfg, "test1/bar", trivy.DefaultScanTimeout)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if resp3.Token == resp1.Token {
+		t.Error("expected a different token for a different repository")
+	}
+	_, otherIssuerKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	otherCfg := cfg
+	otherCfg.JWTIssuerKeys = []crypto.PrivateKey{otherIssuerKey}
+	resp4, err := IssueTokenForTrivy(otherCfg, "test1/foo", trivy.DefaultScanTimeout)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if resp4.Token == resp1.Token {
+		t.Error("expected a different token for a different issuer key")
+	}
+
+	// tokens are valid for 20 minutes, unless the scan timeout requires a longer
+	// lifetime (in which case the previous token cannot be reused)
+	assert.DeepEqual(t, "token lifetime", resp1.ExpiresIn, uint64(20*60))
+	resp5, err := IssueTokenForTrivy(cfg, "test1/foo", time.Hour)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if resp5.Token == resp1.Token {
+		t.Error("expected a different token for a longer scan timeout")
+	}
+	assert.DeepEqual(t, "token lifetime", resp5.ExpiresIn, uint64(60*60+30))
+
+	// validated Trivy tokens are remembered (TrivyUserIdentity does not need
+	// anything from the AuthDriver, but it must not be nil during parsing)
+	ad := stubAuthDriver{}
+	authz, rerr := parseTokenWithTrivyCache(cfg, ad, Audience{}, resp1.Token)
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	assert.DeepEqual(t, "user type", authz.UserIdentity.UserType(), keppel.TrivyUser)
+	trivyTokenCacheMutex.Lock()
+	_, isCached := validatedTrivyTokens[validatedTrivyTokenKey{Audience{}, resp1.Token}]
+	trivyTokenCacheMutex.Unlock()
+	if !isCached {
+		t.Error("expected validated Trivy token to be cached")
+	}
+	cachedAuthz, rerr := parseTokenWithTrivyCache(cfg, ad, Audience{}, resp1.Token)
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	assert.DeepEqual(t, "cached scopes", cachedAuthz.ScopeSet, authz.ScopeSet)
+
+	// tokens that were not validated before still go through the full validation
+	_, rerr = parseTokenWithTrivyCache(otherCfg, ad, Audience{}, resp3.Token)
+	if rerr == nil {
+		t.Error("expected token signed by a different key to be rejected")
+	}
+}
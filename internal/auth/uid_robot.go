@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func init() {
+	keppel.UserIdentityRegistry.Add(func() keppel.UserIdentity { return &RobotUserIdentity{} })
+}
+
+// RobotUserIdentity is a keppel.UserIdentity for robot credentials. Robot
+// users do not have any permissions on the level of auth tenants. Instead,
+// they can only pull from the single repository that they were generated for.
+type RobotUserIdentity struct {
+	Name        string             `json:"name"`
+	AccountName models.AccountName `json:"account"`
+	RepoName    string             `json:"repo"`
+}
+
+// PluginTypeID implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) PluginTypeID() string {
+	return "robot"
+}
+
+// HasPermission implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) HasPermission(perm keppel.Permission, tenantID string) bool {
+	// pull access to the robot's repository is granted in filterRepoActions()
+	return false
+}
+
+// UserType implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) UserType() keppel.UserType {
+	return keppel.RobotUser
+}
+
+// UserName implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) UserName() string {
+	return uid.Name
+}
+
+// UserInfo implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) UserInfo() audittools.UserInfo {
+	return nil
+}
+
+// SerializeToJSON implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) SerializeToJSON() (payload []byte, err error) {
+	return json.Marshal(uid)
+}
+
+// DeserializeFromJSON implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) DeserializeFromJSON(in []byte, _ keppel.AuthDriver) error {
+	return json.Unmarshal(in, uid)
+}
+
+// RobotPasswordPrefix is the prefix of all robot passwords. Robot credentials
+// are recognized by this prefix rather than by their user name, since the
+// user name alone could also belong to a regular user of the auth driver.
+const RobotPasswordPrefix = "keppel-robot-"
+
+// NewRobotCredential generates a robot credential for pulling from the given
+// repository, stores it in the DB, and returns it along with its password.
+// The password is not stored anywhere, so it cannot be retrieved later on.
+func NewRobotCredential(db gorp.SqlExecutor, repo models.Repository, createdBy string, now, expiresAt time.Time) (models.RobotCredential, string, error) {
+	buf := make([]byte, 40)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return models.RobotCredential{}, "", err
+	}
+	password := RobotPasswordPrefix + hex.EncodeToString(buf[8:])
+
+	// NOTE: As with peer passwords (see tasks.IssueNewPasswordForPeer), hashing
+	// with SHA-256 is acceptable here because robot passwords have extremely high
+	// entropy (32 bytes = 256 bits) compared to passwords used by human users.
+	cred := models.RobotCredential{
+		RepositoryID: repo.ID,
+		UserName:     "robot@" + hex.EncodeToString(buf[:8]),
+		PasswordHash: digest.SHA256.FromString(password).String(),
+		CreatedAt:    now,
+		CreatedBy:    createdBy,
+		ExpiresAt:    expiresAt,
+	}
+	err = db.Insert(&cred)
+	return cred, password, err
+}
+
+var robotCredentialsQuery = sqlext.SimplifyWhitespace(`
+	SELECT c.password_hash, c.expires_at, r.account_name, r.name
+	  FROM robot_credentials c
+	  JOIN repos r ON r.id = c.repo_id
+	 WHERE c.user_name = $1
+`)
+
+// Checks the given robot credentials. If the password does not look like a
+// robot password, or if there is no robot with this user name, (nil, nil) is
+// returned and the caller shall try the credentials with the auth driver
+// instead. If the robot exists, but the password does not match or the robot
+// credential has expired, an error is returned.
+func checkRobotCredentials(db *keppel.DB, userName, password string, now time.Time) (*RobotUserIdentity, error) {
+	if !strings.HasPrefix(password, RobotPasswordPrefix) {
+		return nil, nil
+	}
+
+	var (
+		passwordHash string
+		expiresAt    time.Time
+	)
+	uid := RobotUserIdentity{Name: userName}
+	err := db.QueryRow(robotCredentialsQuery, userName).Scan(&passwordHash, &expiresAt, &uid.AccountName, &uid.RepoName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	actualHash := digest.SHA256.FromString(password).String()
+	if subtle.ConstantTimeCompare([]byte(passwordHash), []byte(actualHash)) != 1 {
+		return nil, keppel.ErrUnauthorized.With("invalid robot credentials")
+	}
+	if !expiresAt.After(now) {
+		return nil, keppel.ErrUnauthorized.With("robot credentials have expired")
+	}
+	return &uid, nil
+}
@@ -61,7 +61,18 @@ func (uid *TrivyUserIdentity) DeserializeFromJSON(in []byte, _ keppel.AuthDriver
 
 // IssueTokenForTrivy issues a token for Trivy to pull the image and it's databases with.
 // This needs to use the specialized TrivyUserIdentity to avoid updating the image's "last_pulled_at" timestamp.
-func IssueTokenForTrivy(cfg keppel.Configuration, repoFullName string) (*TokenResponse, error) {
+//
+// The token remains valid for at least the given scan timeout. Tokens are
+// reused for subsequent scans in the same repository while they remain valid
+// for long enough.
+func IssueTokenForTrivy(cfg keppel.Configuration, repoFullName string, scanTimeout time.Duration) (*TokenResponse, error) {
+	now := time.Now()
+	cacheKey := issuedTrivyTokenKey(cfg, repoFullName)
+	lifetime, minRemainingLifetime := trivyTokenLifetimes(scanTimeout)
+	if resp, ok := getIssuedTrivyToken(cacheKey, now, minRemainingLifetime); ok {
+		return resp, nil
+	}
+
 	scopes := []Scope{{
 		ResourceType: "repository",
 		ResourceName: repoFullName,
@@ -76,9 +87,13 @@ func IssueTokenForTrivy(cfg keppel.Configuration, repoFullName string) (*TokenRe
 		})
 	}
 
-	return Authorization{
+	resp, err := Authorization{
 		UserIdentity: &TrivyUserIdentity{},
 		Audience:     Audience{},
 		ScopeSet:     NewScopeSet(scopes...),
-	}.IssueTokenWithExpires(cfg, 20*time.Minute)
+	}.IssueTokenWithExpires(cfg, lifetime)
+	if err == nil && cacheKey != "" {
+		putIssuedTrivyToken(cacheKey, *resp, now, minRemainingLifetime)
+	}
+	return resp, err
 }
@@ -0,0 +1,25 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// DeleteManifest deletes a manifest (and all tags pointing to it) from this
+// repository. If an error is returned, it's usually a *keppel.RegistryV2Error.
+func (c *RepoClient) DeleteManifest(ctx context.Context, manifestDigest digest.Digest) error {
+	resp, err := c.doRequest(ctx, repoRequest{
+		Method:       "DELETE",
+		Path:         "manifests/" + manifestDigest.String(),
+		ExpectStatus: http.StatusAccepted,
+	})
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
@@ -5,8 +5,12 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -81,3 +85,149 @@ func (c *RepoClient) DownloadManifest(ctx context.Context, reference models.Mani
 
 	return respBytes, resp.Header.Get("Content-Type"), nil
 }
+
+// ListTags lists all tags in this repository. Paginated responses (as
+// indicated by a "Link" header with rel="next") are followed until the end.
+// If an error is returned, it's usually a *keppel.RegistryV2Error.
+func (c *RepoClient) ListTags(ctx context.Context) ([]string, error) {
+	var result []string
+	path := "tags/list"
+	for path != "" {
+		resp, err := c.doRequest(ctx, repoRequest{
+			Method:       "GET",
+			Path:         path,
+			ExpectStatus: http.StatusOK,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var respData struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&respData)
+		if err == nil {
+			err = resp.Body.Close()
+		} else {
+			resp.Body.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse response to GET %s: %w", path, err)
+		}
+		result = append(result, respData.Tags...)
+
+		path, err = nextPagePathFromLinkHeader(resp.Header.Get("Link"), "tags/list")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// ListRepositories lists all repositories on this registry using the catalog
+// endpoint. This must be called on a RepoClient with an empty RepoName.
+// Paginated responses are followed until the end. Note that not all
+// registries offer the catalog endpoint to all users (or at all).
+func (c *RepoClient) ListRepositories(ctx context.Context) ([]string, error) {
+	if c.RepoName != "" {
+		return nil, fmt.Errorf("cannot list repositories with a RepoClient for repository %q", c.RepoName)
+	}
+
+	var result []string
+	path := "_catalog"
+	for path != "" {
+		resp, err := c.doRequest(ctx, repoRequest{
+			Method:       "GET",
+			Path:         path,
+			ExpectStatus: http.StatusOK,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var respData struct {
+			Repositories []string `json:"repositories"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&respData)
+		if err == nil {
+			err = resp.Body.Close()
+		} else {
+			resp.Body.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse response to GET %s: %w", path, err)
+		}
+		result = append(result, respData.Repositories...)
+
+		path, err = nextPagePathFromLinkHeader(resp.Header.Get("Link"), "_catalog")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// HasBlob checks whether the given blob exists in this repository using a
+// HEAD request.
+func (c *RepoClient) HasBlob(ctx context.Context, blobDigest digest.Digest) (bool, error) {
+	resp, err := c.doRequest(ctx, repoRequest{
+		Method:       "HEAD",
+		Path:         "blobs/" + blobDigest.String(),
+		ExpectStatus: http.StatusOK,
+	})
+	if err == nil {
+		return true, resp.Body.Close()
+	}
+	var uerr unexpectedStatusCodeError
+	if errors.As(err, &uerr) && strings.HasPrefix(uerr.actualStatus, "404 ") {
+		return false, nil
+	}
+	return false, err
+}
+
+// Parses a header like `Link: </v2/foo/tags/list?last=bar&n=10>; rel="next"`
+// into a path that can be given to doRequest(), e.g. "tags/list?last=bar&n=10".
+// The `basePath` is the path of the paginated endpoint, e.g. "tags/list".
+func nextPagePathFromLinkHeader(link, basePath string) (string, error) {
+	if link == "" {
+		return "", nil
+	}
+	target, params, ok := strings.Cut(link, ";")
+	if !ok || strings.TrimSpace(params) != `rel="next"` {
+		return "", nil
+	}
+	target = strings.TrimSpace(target)
+	target = strings.TrimPrefix(target, "<")
+	target = strings.TrimSuffix(target, ">")
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("malformed Link header %q: %w", link, err)
+	}
+	return basePath + "?" + u.RawQuery, nil
+}
+
+// GetManifestDigest finds the digest of a manifest in this repository using a
+// HEAD request, without downloading the manifest contents. If an error is
+// returned, it's usually a *keppel.RegistryV2Error.
+func (c *RepoClient) GetManifestDigest(ctx context.Context, reference models.ManifestReference) (digest.Digest, error) {
+	hdr := make(http.Header)
+	hdr.Set("Accept", strings.Join(keppel.ManifestMediaTypes, ", "))
+	hdr.Set("X-Keppel-No-Count-Towards-Last-Pulled", "1")
+
+	resp, err := c.doRequest(ctx, repoRequest{
+		Method:       "HEAD",
+		Path:         "manifests/" + reference.String(),
+		Headers:      hdr,
+		ExpectStatus: http.StatusOK,
+	})
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	manifestDigest, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		return "", fmt.Errorf("cannot parse Docker-Content-Digest header in response to HEAD %s: %w", reference, err)
+	}
+	return manifestDigest, nil
+}
@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Registry hostnames in Amazon ECR look like "<account-id>.dkr.ecr.<region>.amazonaws.com".
+// Reference: <https://docs.aws.amazon.com/AmazonECR/latest/userguide/Registries.html>
+var ecrHostnameRx = regexp.MustCompile(`^([0-9]{12})\.dkr\.ecr\.([a-z0-9-]+)\.(amazonaws\.com(?:\.cn)?)$`)
+
+// ECRClient talks to the Amazon ECR API for the operations that are not
+// covered by the OCI Distribution API: ECR does not implement the catalog
+// endpoint, and registry credentials need to be obtained from the ECR API.
+type ECRClient struct {
+	Credentials AWSCredentials
+	RegistryID  string // the ID of the AWS account owning the registry
+	Endpoint    string // e.g. "https://api.ecr.us-east-1.amazonaws.com"
+}
+
+// NewECRClient returns an ECRClient for the registry with the given hostname,
+// or ok = false if the hostname does not belong to an ECR registry. The
+// credentials do not need to have the Region filled; it is taken from the hostname.
+func NewECRClient(registryHostname string, creds AWSCredentials) (c *ECRClient, ok bool) {
+	match := ecrHostnameRx.FindStringSubmatch(registryHostname)
+	if match == nil {
+		return nil, false
+	}
+	creds.Region = match[2]
+	return &ECRClient{
+		Credentials: creds,
+		RegistryID:  match[1],
+		Endpoint:    fmt.Sprintf("https://api.ecr.%s.%s", match[2], match[3]),
+	}, true
+}
+
+// ListRepositories returns the names of all repositories in this registry.
+func (c *ECRClient) ListRepositories(ctx context.Context) ([]string, error) {
+	var (
+		result    []string
+		nextToken string
+	)
+	for {
+		var respData struct {
+			Repositories []struct {
+				RepositoryName string `json:"repositoryName"`
+			} `json:"repositories"`
+			NextToken string `json:"nextToken"`
+		}
+		reqData := map[string]any{
+			"registryId": c.RegistryID,
+			"maxResults": 1000,
+		}
+		if nextToken != "" {
+			reqData["nextToken"] = nextToken
+		}
+		err := c.call(ctx, "DescribeRepositories", reqData, &respData)
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range respData.Repositories {
+			result = append(result, repo.RepositoryName)
+		}
+
+		if respData.NextToken == "" {
+			return result, nil
+		}
+		nextToken = respData.NextToken
+	}
+}
+
+// GetRegistryCredentials returns a username and password for the OCI
+// Distribution API of this registry. The password is valid for 12 hours.
+func (c *ECRClient) GetRegistryCredentials(ctx context.Context) (userName, password string, err error) {
+	var respData struct {
+		AuthorizationData []struct {
+			AuthorizationToken string `json:"authorizationToken"`
+		} `json:"authorizationData"`
+	}
+	err = c.call(ctx, "GetAuthorizationToken", map[string]any{}, &respData)
+	if err != nil {
+		return "", "", err
+	}
+	if len(respData.AuthorizationData) == 0 {
+		return "", "", errors.New("GetAuthorizationToken did not return any authorization data")
+	}
+
+	// the token is the base64 encoding of "username:password"
+	buf, err := base64.StdEncoding.DecodeString(respData.AuthorizationData[0].AuthorizationToken)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot decode authorization token: %w", err)
+	}
+	userName, password, ok := strings.Cut(string(buf), ":")
+	if !ok {
+		return "", "", errors.New("authorization token is not of the form \"username:password\"")
+	}
+	return userName, password, nil
+}
+
+// Calls an action of the ECR API using its JSON protocol.
+func (c *ECRClient) call(ctx context.Context, action string, reqData map[string]any, respData any) error {
+	reqBytes, err := json.Marshal(reqData)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint+"/", bytes.NewReader(reqBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonEC2ContainerRegistry_V20150921."+action)
+	c.Credentials.SignRequest(req, "ecr", AWSPayloadHash(reqBytes), time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("during ECR %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("during ECR %s: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("during ECR %s: expected status 200, but got %s: %s", action, resp.Status, strings.TrimSpace(string(respBytes)))
+	}
+	err = json.Unmarshal(respBytes, respData)
+	if err != nil {
+		return fmt.Errorf("during ECR %s: cannot parse response: %w", action, err)
+	}
+	return nil
+}
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/opencontainers/go-digest"
+
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 )
@@ -126,6 +128,34 @@ func (c Client) PerformReplicaSync(ctx context.Context, fullRepoName string, pay
 	return &respPayload, nil
 }
 
+// GetTrivyReport asks the peer for the vulnerability report that it has
+// obtained for the given manifest during its own security scanning.
+//
+// If the peer does not have a report for this manifest (i.e. 404 is
+// returned), this function will return (nil, nil) to signal to the caller
+// that it needs to scan the manifest by itself.
+func (c Client) GetTrivyReport(ctx context.Context, fullRepoName string, manifestDigest digest.Digest) (*keppel.TrivyReportForSync, error) {
+	reqURL := c.buildRequestURL(fmt.Sprintf("peer/v1/trivy-report/%s/manifests/%s", fullRepoName, manifestDigest))
+	respBodyBytes, respStatusCode, _, err := c.doRequest(ctx, http.MethodGet, reqURL, http.NoBody, nil)
+	if err != nil {
+		return nil, err
+	}
+	if respStatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if respStatusCode != http.StatusOK {
+		return nil, fmt.Errorf("during GET %s: expected 200, got %d with response: %s",
+			reqURL, respStatusCode, string(respBodyBytes))
+	}
+
+	var respPayload keppel.TrivyReportForSync
+	err = jsonUnmarshalStrict(respBodyBytes, &respPayload)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing response from GET %s: %w", reqURL, err)
+	}
+	return &respPayload, nil
+}
+
 // Like yaml.UnmarshalStrict(), but for JSON.
 func jsonUnmarshalStrict(buf []byte, target any) error {
 	dec := json.NewDecoder(bytes.NewReader(buf))
@@ -19,22 +19,28 @@ import (
 type RepoClient struct {
 	Scheme   string // either "http" or "https"
 	Host     string // either a plain hostname or a host:port like "example.org:443"
-	RepoName string
+	RepoName string // may be empty when only using registry-wide endpoints like ListRepositories
 
 	// credentials (only needed for non-public repos)
 	UserName string
 	Password string
 
+	// resilience features (both optional)
+	RetryPolicy    *RetryPolicy
+	CircuitBreaker CircuitBreaker
+
 	// auth state
-	token string
+	token        string
+	useBasicAuth bool // if the registry asked for HTTP Basic auth instead of tokens (e.g. ECR)
 }
 
 type repoRequest struct {
-	Method       string
-	Path         string
-	Headers      http.Header
-	Body         io.ReadSeeker
-	ExpectStatus int
+	Method        string
+	Path          string
+	Headers       http.Header
+	Body          io.ReadSeeker
+	ContentLength int64 // only required if Body is not a *bytes.Reader
+	ExpectStatus  int
 }
 
 // SetToken can be used in tests to inject a pre-computed token and bypass the
@@ -44,19 +50,65 @@ func (c *RepoClient) SetToken(token string) {
 }
 
 func (c *RepoClient) sendRequest(ctx context.Context, r repoRequest, uri string) (*http.Response, *http.Request, error) {
+	if c.CircuitBreaker != nil {
+		err := c.CircuitBreaker.BeforeRequest(ctx, c.Host)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	maxAttempts := 1
+	if c.RetryPolicy != nil {
+		maxAttempts = c.RetryPolicy.maxAttemptsFor(r.Method)
+	}
+	for attempt := 1; ; attempt++ {
+		resp, req, err := c.sendRequestOnce(ctx, r, uri)
+		if req == nil {
+			// request could not even be constructed, so retrying does not help
+			return nil, nil, err
+		}
+		failed := err != nil || isTransientStatus(resp.StatusCode)
+		if !failed || attempt >= maxAttempts || ctx.Err() != nil {
+			if c.CircuitBreaker != nil {
+				c.CircuitBreaker.AfterRequest(ctx, c.Host, failed)
+			}
+			return resp, req, err
+		}
+
+		// discard the failed response and try again after a while
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if c.RetryPolicy.OnRetry != nil {
+			c.RetryPolicy.OnRetry(c.Host)
+		}
+		err = c.RetryPolicy.wait(ctx, attempt)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+func (c *RepoClient) sendRequestOnce(ctx context.Context, r repoRequest, uri string) (*http.Response, *http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, r.Method, uri, r.Body)
 	if err != nil {
 		return nil, nil, err
 	}
+	if r.ContentLength > 0 {
+		req.ContentLength = r.ContentLength
+	}
 	for k, v := range r.Headers {
 		req.Header[k] = v
 	}
-	if c.token != "" {
+	switch {
+	case c.useBasicAuth:
+		req.SetBasicAuth(c.UserName, c.Password)
+	case c.token != "":
 		req.Header.Set("Authorization", "Bearer "+c.token)
 	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, nil, keppel.ErrUnavailable.With(err.Error())
+		return nil, req, keppel.ErrUnavailable.With(err.Error())
 	}
 
 	return resp, req, nil
@@ -68,6 +120,9 @@ func (c *RepoClient) doRequest(ctx context.Context, r repoRequest) (*http.Respon
 	}
 
 	uri := fmt.Sprintf("%s://%s/v2/%s/%s", c.Scheme, c.Host, c.RepoName, r.Path)
+	if c.RepoName == "" {
+		uri = fmt.Sprintf("%s://%s/v2/%s", c.Scheme, c.Host, r.Path)
+	}
 
 	// send GET request for manifest
 	resp, req, err := c.sendRequest(ctx, r, uri)
@@ -77,16 +132,21 @@ func (c *RepoClient) doRequest(ctx context.Context, r repoRequest) (*http.Respon
 
 	// if it's a 401, do the auth challenge...
 	if resp.StatusCode == http.StatusUnauthorized {
-		authChallenge, err := ParseAuthChallenge(resp.Header)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse auth challenge from 401 response to %s %s: %w", r.Method, uri, err)
-		}
-		c.token, err = authChallenge.GetToken(ctx, c.UserName, c.Password)
-		if err != nil {
-			return nil, fmt.Errorf("authentication failed: %w", err)
+		if strings.HasPrefix(resp.Header.Get("Www-Authenticate"), "Basic ") {
+			c.useBasicAuth = true
+		} else {
+			authChallenge, err := ParseAuthChallenge(resp.Header)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse auth challenge from 401 response to %s %s: %w", r.Method, uri, err)
+			}
+			c.token, err = authChallenge.GetToken(ctx, c.UserName, c.Password)
+			if err != nil {
+				return nil, fmt.Errorf("authentication failed: %w", err)
+			}
 		}
+		resp.Body.Close()
 
-		// ...then resend the GET request with the token
+		// ...then resend the request with the token (or the Basic auth credentials)
 		if r.Body != nil {
 			_, err = r.Body.Seek(0, io.SeekStart)
 			if err != nil {
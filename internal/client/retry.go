@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy can be set on a RepoClient to have it retry idempotent requests
+// (GET and HEAD) that fail with a transient error, i.e. a network error or a
+// 5xx response.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per request (including the first one).
+	MaxAttempts int
+	// The wait time before the n-th retry is chosen randomly between zero and
+	// InitialBackoff * 2^(n-1), but never more than MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// OnRetry, if not nil, is called before each retry (e.g. to count retries in a metric).
+	OnRetry func(host string)
+}
+
+// CircuitBreaker can be set on a RepoClient to avoid sending requests to
+// upstream registries that are known to be failing.
+type CircuitBreaker interface {
+	// BeforeRequest is called before a request is sent to the given host.
+	// If an error is returned, the request is not sent and the error is returned instead.
+	BeforeRequest(ctx context.Context, host string) error
+	// AfterRequest is called with the final outcome of a request (after all
+	// retries). A request counts as failed if it failed with a transient error.
+	AfterRequest(ctx context.Context, host string, failed bool)
+}
+
+func (p RetryPolicy) maxAttemptsFor(method string) int {
+	if method != http.MethodGet && method != http.MethodHead {
+		return 1
+	}
+	return max(p.MaxAttempts, 1)
+}
+
+func (p RetryPolicy) wait(ctx context.Context, retryCount int) error {
+	backoff := p.InitialBackoff
+	for i := 1; i < retryCount && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, p.MaxBackoff)
+
+	var jitteredBackoff time.Duration
+	if backoff > 0 {
+		jitteredBackoff = rand.N(backoff) //nolint:gosec // does not need crypto-grade randomness
+	}
+
+	timer := time.NewTimer(jitteredBackoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func isTransientStatus(statusCode int) bool {
+	// 501 Not Implemented will not go away by retrying
+	return statusCode >= 500 && statusCode != http.StatusNotImplemented
+}
@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer responds to the first `failures` requests with `failureStatus`,
+// and with 200 afterwards.
+type flakyServer struct {
+	failures      int64
+	failureStatus int
+	requestCount  atomic.Int64
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.requestCount.Add(1) <= s.failures {
+		w.WriteHeader(s.failureStatus)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type fakeCircuitBreaker struct {
+	rejectWith error
+	outcomes   []bool // one entry per AfterRequest() call, true = failed
+}
+
+func (b *fakeCircuitBreaker) BeforeRequest(ctx context.Context, host string) error {
+	return b.rejectWith
+}
+
+func (b *fakeCircuitBreaker) AfterRequest(ctx context.Context, host string, failed bool) {
+	b.outcomes = append(b.outcomes, failed)
+}
+
+func newTestRepoClient(t *testing.T, h http.Handler) (*RepoClient, *fakeCircuitBreaker) {
+	t.Helper()
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+
+	breaker := &fakeCircuitBreaker{}
+	c := &RepoClient{
+		Scheme: "http",
+		Host:   strings.TrimPrefix(server.URL, "http://"),
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     4 * time.Millisecond,
+		},
+		CircuitBreaker: breaker,
+	}
+	return c, breaker
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+	testCases := []struct {
+		Method           string
+		Failures         int64
+		FailureStatus    int
+		ExpectedStatus   int
+		ExpectedRequests int64
+		ExpectedOutcomes []bool
+	}{
+		// idempotent requests are retried until they succeed...
+		{http.MethodGet, 2, http.StatusServiceUnavailable, http.StatusOK, 3, []bool{false}},
+		{http.MethodHead, 1, http.StatusBadGateway, http.StatusOK, 2, []bool{false}},
+		// ...or until MaxAttempts is reached, which counts as one failure for the circuit breaker
+		{http.MethodGet, 5, http.StatusServiceUnavailable, http.StatusServiceUnavailable, 3, []bool{true}},
+		// non-idempotent requests are never retried
+		{http.MethodPut, 1, http.StatusServiceUnavailable, http.StatusServiceUnavailable, 1, []bool{true}},
+		{http.MethodDelete, 1, http.StatusInternalServerError, http.StatusInternalServerError, 1, []bool{true}},
+		// errors that will not go away by retrying are not retried and do not count as failures
+		{http.MethodGet, 1, http.StatusNotImplemented, http.StatusNotImplemented, 1, []bool{false}},
+		{http.MethodGet, 1, http.StatusNotFound, http.StatusNotFound, 1, []bool{false}},
+	}
+
+	for _, tc := range testCases {
+		server := &flakyServer{failures: tc.Failures, failureStatus: tc.FailureStatus}
+		c, breaker := newTestRepoClient(t, server)
+		var retriedHosts []string
+		c.RetryPolicy.OnRetry = func(host string) { retriedHosts = append(retriedHosts, host) }
+
+		resp, _, err := c.sendRequest(t.Context(), repoRequest{Method: tc.Method}, "http://"+c.Host+"/v2/")
+		if err != nil {
+			t.Errorf("%s with %d failures: unexpected error: %s", tc.Method, tc.Failures, err.Error())
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.ExpectedStatus {
+			t.Errorf("%s with %d failures: expected status %d, but got %d", tc.Method, tc.Failures, tc.ExpectedStatus, resp.StatusCode)
+		}
+		if count := server.requestCount.Load(); count != tc.ExpectedRequests {
+			t.Errorf("%s with %d failures: expected %d requests, but got %d", tc.Method, tc.Failures, tc.ExpectedRequests, count)
+		}
+		if len(retriedHosts) != int(tc.ExpectedRequests-1) {
+			t.Errorf("%s with %d failures: expected OnRetry to be called %d times, but got %d", tc.Method, tc.Failures, tc.ExpectedRequests-1, len(retriedHosts))
+		}
+		if !slices.Equal(breaker.outcomes, tc.ExpectedOutcomes) {
+			t.Errorf("%s with %d failures: expected circuit breaker outcomes %v, but got %v", tc.Method, tc.Failures, tc.ExpectedOutcomes, breaker.outcomes)
+		}
+	}
+}
+
+func TestRetryNetworkError(t *testing.T) {
+	server := &flakyServer{}
+	c, breaker := newTestRepoClient(t, server)
+
+	// point the client at a port where nobody is listening
+	listener := httptest.NewServer(server)
+	host := strings.TrimPrefix(listener.URL, "http://")
+	listener.Close()
+	c.Host = host
+
+	retryCount := 0
+	c.RetryPolicy.OnRetry = func(string) { retryCount++ }
+	_, _, err := c.sendRequest(t.Context(), repoRequest{Method: http.MethodGet}, "http://"+host+"/v2/")
+	if err == nil {
+		t.Fatal("expected network error, but got none")
+	}
+	if retryCount != 2 {
+		t.Errorf("expected 2 retries, but got %d", retryCount)
+	}
+	if !slices.Equal(breaker.outcomes, []bool{true}) {
+		t.Errorf("expected circuit breaker outcomes [true], but got %v", breaker.outcomes)
+	}
+}
+
+func TestCircuitBreakerRejection(t *testing.T) {
+	server := &flakyServer{}
+	c, breaker := newTestRepoClient(t, server)
+	breaker.rejectWith = errors.New("circuit breaker is open")
+
+	_, _, err := c.sendRequest(t.Context(), repoRequest{Method: http.MethodGet}, "http://"+c.Host+"/v2/")
+	if err == nil || err.Error() != "circuit breaker is open" {
+		t.Errorf("expected circuit breaker error, but got %v", err)
+	}
+	if count := server.requestCount.Load(); count != 0 {
+		t.Errorf("expected no requests to be sent, but got %d", count)
+	}
+	if len(breaker.outcomes) != 0 {
+		t.Errorf("expected AfterRequest not to be called, but got outcomes %v", breaker.outcomes)
+	}
+}
+
+func TestRetryPolicyWait(t *testing.T) {
+	// without backoff, there is no waiting
+	err := RetryPolicy{}.wait(t.Context(), 1)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	// the wait is capped at MaxBackoff even for high retry counts
+	p := RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	start := time.Now()
+	for range 10 {
+		err := p.wait(t.Context(), 100)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 10*10*time.Millisecond+time.Second {
+		t.Errorf("expected wait to be capped at MaxBackoff, but 10 waits took %s", elapsed)
+	}
+
+	// waiting is aborted when the context expires
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	p = RetryPolicy{InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	err = p.wait(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, but got %v", err)
+	}
+}
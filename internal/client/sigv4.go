@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// This file contains a minimal implementation of AWS Signature Version 4,
+// covering just what is needed for the S3 requests made by the S3 backup
+// driver and the ECR requests made by ECRClient.
+// Reference: <https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv-create-signed-request.html>
+
+const (
+	sigV4Algorithm     = "AWS4-HMAC-SHA256"
+	amzDateFormat      = "20060102T150405Z"
+	amzShortDateFormat = "20060102"
+
+	// AWSUnsignedPayload can be given to AWSCredentials.SignRequest() instead
+	// of a payload hash if the request body shall not be covered by the signature.
+	AWSUnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// AWSCredentials holds the credentials for signing requests to AWS APIs.
+type AWSCredentials struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // optional, only for temporary credentials
+}
+
+// SignRequest adds the Authorization header and the associated X-Amz-*
+// headers to the given request for the given service (e.g. "s3").
+// `payloadHash` is either the hex-encoded SHA-256 of the request body
+// (see AWSPayloadHash), or the constant AWSUnsignedPayload.
+func (c AWSCredentials) SignRequest(req *http.Request, service, payloadHash string, now time.Time) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+	c.sign(req, service, payloadHash, now, signedHeaders)
+}
+
+// sign adds the Authorization header to the given request, with a signature
+// that covers the given headers (names in lower case). Except for "host",
+// these headers must already be set on the request.
+func (c AWSCredentials) sign(req *http.Request, service, payloadHash string, now time.Time, signedHeaders []string) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	signedHeaders = slices.Sorted(slices.Values(signedHeaders))
+
+	// canonical request; the query string is rewritten into canonical form so
+	// that it matches the signature
+	req.URL.RawQuery = canonicalQueryString(req)
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		canonicalHeaders.WriteString(name + ":" + canonicalHeaderValue(req, name) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	// string to sign
+	scope := strings.Join([]string{now.Format(amzShortDateFormat), c.Region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		AWSPayloadHash([]byte(canonicalRequest)),
+	}, "\n")
+
+	// signature
+	key := deriveSigningKey(c.SecretAccessKey, now.Format(amzShortDateFormat), c.Region, service)
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", sigV4Algorithm+
+		" Credential="+c.AccessKeyID+"/"+scope+
+		", SignedHeaders="+strings.Join(signedHeaders, ";")+
+		", Signature="+signature)
+}
+
+// canonicalHeaderValue joins all values of the given header with commas,
+// after trimming them and collapsing sequences of spaces.
+func canonicalHeaderValue(req *http.Request, name string) string {
+	if name == "host" {
+		if req.Host != "" {
+			return req.Host
+		}
+		return req.URL.Host
+	}
+	values := req.Header.Values(name)
+	canonicalValues := make([]string, len(values))
+	for idx, value := range values {
+		canonicalValues[idx] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(canonicalValues, ",")
+}
+
+func deriveSigningKey(secretAccessKey, shortDate, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), shortDate)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func canonicalQueryString(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, sigV4Escape(key)+"="+sigV4Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// sigV4Escape percent-encodes everything except the unreserved characters
+// from RFC 3986, as required by SigV4.
+func sigV4Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+// AWSPayloadHash returns the payload hash of the given request body, as
+// expected by AWSCredentials.SignRequest().
+func AWSPayloadHash(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -6,6 +6,7 @@ package client
 import (
 	"bytes"
 	"context"
+	"io"
 	"net/http"
 	"strconv"
 
@@ -16,20 +17,28 @@ import (
 // blob's digest is returned.
 func (c *RepoClient) UploadMonolithicBlob(ctx context.Context, contents []byte) (digest.Digest, error) {
 	d := digest.Canonical.FromBytes(contents)
+	err := c.UploadMonolithicBlobFromReader(ctx, d, bytes.NewReader(contents), uint64(len(contents)))
+	return d, err
+}
 
+// UploadMonolithicBlobFromReader is like UploadMonolithicBlob, but streams the
+// blob contents from the given reader instead of holding them in memory. The
+// digest and size must be known in advance.
+func (c *RepoClient) UploadMonolithicBlobFromReader(ctx context.Context, blobDigest digest.Digest, contents io.ReadSeeker, sizeBytes uint64) error {
 	resp, err := c.doRequest(ctx, repoRequest{
 		Method: "POST",
-		Path:   "blobs/uploads/?digest=" + d.String(),
+		Path:   "blobs/uploads/?digest=" + blobDigest.String(),
 		Headers: http.Header{
 			"Content-Type": {"application/octet-stream"},
 		},
-		Body:         bytes.NewReader(contents),
-		ExpectStatus: http.StatusCreated,
+		Body:          contents,
+		ContentLength: int64(sizeBytes), //nolint:gosec // blob sizes are far below math.MaxInt64
+		ExpectStatus:  http.StatusCreated,
 	})
 	if err == nil {
 		resp.Body.Close()
 	}
-	return d, err
+	return err
 }
 
 // UploadManifest uploads a manifest. If `tagName` is not empty, this tag name
@@ -38,6 +38,7 @@ type Account struct {
 	SecurityScanPolicies []keppel.SecurityScanPolicy `json:"security_scan_policies"`
 	ValidationPolicy     *keppel.ValidationPolicy    `json:"validation"`
 	PlatformFilter       models.PlatformFilter       `json:"platform_filter"`
+	Metadata             map[string]string           `json:"metadata"`
 }
 
 func init() {
@@ -79,6 +80,9 @@ func (a *AccountManagementDriver) ConfigureAccount(accountName models.AccountNam
 			ValidationPolicy:  cfgAccount.ValidationPolicy,
 			PlatformFilter:    cfgAccount.PlatformFilter,
 		}
+		if len(cfgAccount.Metadata) > 0 {
+			account.Metadata = &cfgAccount.Metadata
+		}
 
 		return account, cfgAccount.SecurityScanPolicies, nil
 	}
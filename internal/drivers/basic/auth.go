@@ -0,0 +1,284 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package basic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/osext"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+func init() {
+	keppel.AuthDriverRegistry.Add(func() keppel.AuthDriver { return &AuthDriver{} })
+	keppel.UserIdentityRegistry.Add(func() keppel.UserIdentity { return &userIdentity{} })
+}
+
+// These are where AuthenticateUserFromRequest() looks for credentials. The
+// Authorization header cannot be used for this since it is reserved for
+// tokens issued by Keppel itself.
+const (
+	userNameHeader = "X-Keppel-Username"
+	passwordHeader = "X-Keppel-Password"
+)
+
+// AuthConfig is the contents of the file at $KEPPEL_AUTH_CONFIG_PATH.
+type AuthConfig struct {
+	Users []AuthUser `json:"users"`
+
+	// When authenticating an unknown user, the password is checked against
+	// this hash instead, so that unknown users cannot be told apart from
+	// known users by how long the check takes.
+	dummyHash []byte
+}
+
+// AuthUser appears in type AuthConfig.
+type AuthUser struct {
+	Name string `json:"name"`
+	// A bcrypt hash, e.g. from an htpasswd file generated with `htpasswd -B`.
+	PasswordHash string      `json:"password_hash" yaml:"password_hash"`
+	Grants       []AuthGrant `json:"grants"`
+}
+
+// AuthGrant appears in type AuthUser.
+type AuthGrant struct {
+	// Required unless the grant only contains CanAdministrateKeppel, which
+	// does not pertain to any tenant.
+	TenantID    string              `json:"tenant_id" yaml:"tenant_id"`
+	Permissions []keppel.Permission `json:"permissions"`
+}
+
+var knownPermissions = []keppel.Permission{
+	keppel.CanViewAccount,
+	keppel.CanPullFromAccount,
+	keppel.CanPushToAccount,
+	keppel.CanDeleteFromAccount,
+	keppel.CanChangeAccount,
+	keppel.CanViewQuotas,
+	keppel.CanChangeQuotas,
+	keppel.CanAdministrateKeppel,
+}
+
+func loadAuthConfig(path string) (AuthConfig, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return AuthConfig{}, err
+	}
+	var cfg AuthConfig
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	err = dec.Decode(&cfg)
+	if err != nil {
+		return AuthConfig{}, fmt.Errorf("while parsing %s: %w", path, err)
+	}
+
+	isUserName := make(map[string]bool, len(cfg.Users))
+	maxCost := 0
+	for idx, user := range cfg.Users {
+		if user.Name == "" {
+			return AuthConfig{}, fmt.Errorf("while parsing %s: users[%d] does not have a name", path, idx)
+		}
+		if isUserName[user.Name] {
+			return AuthConfig{}, fmt.Errorf("while parsing %s: duplicate user name %q", path, user.Name)
+		}
+		isUserName[user.Name] = true
+
+		cost, err := bcrypt.Cost([]byte(user.PasswordHash))
+		if err != nil {
+			return AuthConfig{}, fmt.Errorf("while parsing %s: cannot parse password_hash of user %q (expected a bcrypt hash): %w", path, user.Name, err)
+		}
+		maxCost = max(maxCost, cost)
+		for gidx, grant := range user.Grants {
+			for _, perm := range grant.Permissions {
+				if !slices.Contains(knownPermissions, perm) {
+					return AuthConfig{}, fmt.Errorf("while parsing %s: users[%d].grants[%d] contains unknown permission %q", path, idx, gidx, perm)
+				}
+				if perm != keppel.CanAdministrateKeppel && grant.TenantID == "" {
+					return AuthConfig{}, fmt.Errorf("while parsing %s: users[%d].grants[%d] grants permission %q, but does not have a tenant_id", path, idx, gidx, perm)
+				}
+			}
+		}
+	}
+
+	// the dummy hash needs the same cost as the real ones to take as long to check
+	if maxCost == 0 {
+		maxCost = bcrypt.DefaultCost
+	}
+	cfg.dummyHash, err = bcrypt.GenerateFromPassword([]byte("dummy"), maxCost)
+	if err != nil {
+		return AuthConfig{}, err
+	}
+	return cfg, nil
+}
+
+// Permissions computes the permissions of this user. The result maps tenant
+// IDs to permissions. CanAdministrateKeppel is stored under the empty tenant ID.
+func (u AuthUser) Permissions() map[string][]keppel.Permission {
+	result := make(map[string][]keppel.Permission)
+	for _, grant := range u.Grants {
+		for _, perm := range grant.Permissions {
+			tenantID := grant.TenantID
+			if perm == keppel.CanAdministrateKeppel {
+				tenantID = ""
+			}
+			if !slices.Contains(result[tenantID], perm) {
+				result[tenantID] = append(result[tenantID], perm)
+			}
+		}
+	}
+	return result
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// type AuthDriver
+
+// AuthDriver is the auth driver "basic".
+type AuthDriver struct {
+	ConfigPath string
+	config     AuthConfig
+	lock       sync.RWMutex
+	// for detecting changes to the config file
+	configModTime time.Time
+	configSize    int64
+}
+
+// PluginTypeID implements the keppel.AuthDriver interface.
+func (d *AuthDriver) PluginTypeID() string { return "basic" }
+
+// Init implements the keppel.AuthDriver interface.
+func (d *AuthDriver) Init(ctx context.Context, rc *redis.Client) error {
+	d.ConfigPath = osext.MustGetenv("KEPPEL_AUTH_CONFIG_PATH")
+	_, err := d.reloadConfigIfChanged()
+	return err
+}
+
+// reloadConfigIfChanged reloads the config file if it has been modified since
+// it was last loaded. If the new config is invalid, the old config stays in effect.
+func (d *AuthDriver) reloadConfigIfChanged() (changed bool, err error) {
+	fi, err := os.Stat(d.ConfigPath)
+	if err != nil {
+		return false, err
+	}
+
+	d.lock.RLock()
+	isUnchanged := fi.ModTime().Equal(d.configModTime) && fi.Size() == d.configSize
+	d.lock.RUnlock()
+	if isUnchanged {
+		return false, nil
+	}
+
+	config, err := loadAuthConfig(d.ConfigPath)
+	if err != nil {
+		return false, err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.config = config
+	d.configModTime = fi.ModTime()
+	d.configSize = fi.Size()
+	return true, nil
+}
+
+// AuthenticateUser implements the keppel.AuthDriver interface.
+func (d *AuthDriver) AuthenticateUser(ctx context.Context, userName, password string) (keppel.UserIdentity, *keppel.RegistryV2Error) {
+	changed, err := d.reloadConfigIfChanged()
+	if err != nil {
+		logg.Error("cannot reload %s (the previous version stays in effect): %s", d.ConfigPath, err.Error())
+	} else if changed {
+		logg.Info("reloaded %s", d.ConfigPath)
+	}
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	idx := slices.IndexFunc(d.config.Users, func(u AuthUser) bool { return u.Name == userName })
+	if idx == -1 {
+		// spend as much time as for a known user to not reveal which user names exist
+		_ = bcrypt.CompareHashAndPassword(d.config.dummyHash, []byte(password))
+		return nil, keppel.ErrUnauthorized.With(`invalid username or password`)
+	}
+	user := d.config.Users[idx]
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	if err != nil {
+		return nil, keppel.ErrUnauthorized.With(`invalid username or password`)
+	}
+	return &userIdentity{Name: user.Name, Permissions: user.Permissions()}, nil
+}
+
+// AuthenticateUserFromRequest implements the keppel.AuthDriver interface.
+func (d *AuthDriver) AuthenticateUserFromRequest(r *http.Request) (keppel.UserIdentity, *keppel.RegistryV2Error) {
+	userName := r.Header.Get(userNameHeader)
+	password := r.Header.Get(passwordHeader)
+	if userName == "" && password == "" {
+		// fallback to anonymous auth
+		return nil, nil
+	}
+	return d.AuthenticateUser(r.Context(), userName, password)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// type userIdentity
+
+type userIdentity struct {
+	Name string `json:"name"`
+	// key = tenant ID (or empty string for CanAdministrateKeppel)
+	Permissions map[string][]keppel.Permission `json:"perms,omitempty"`
+}
+
+// PluginTypeID implements the keppel.UserIdentity interface.
+func (uid *userIdentity) PluginTypeID() string { return "basic" }
+
+// HasPermission implements the keppel.UserIdentity interface.
+func (uid *userIdentity) HasPermission(perm keppel.Permission, tenantID string) bool {
+	if tenantID == "" && perm != keppel.CanAdministrateKeppel {
+		return false
+	}
+	return slices.Contains(uid.Permissions[tenantID], perm)
+}
+
+// UserType implements the keppel.UserIdentity interface.
+func (uid *userIdentity) UserType() keppel.UserType {
+	return keppel.RegularUser
+}
+
+// UserName implements the keppel.UserIdentity interface.
+func (uid *userIdentity) UserName() string {
+	return uid.Name
+}
+
+// UserInfo implements the keppel.UserIdentity interface.
+func (uid *userIdentity) UserInfo() audittools.UserInfo {
+	return nil
+}
+
+// SerializeToJSON implements the keppel.UserIdentity interface.
+func (uid *userIdentity) SerializeToJSON() (payload []byte, err error) {
+	return json.Marshal(uid)
+}
+
+// DeserializeFromJSON implements the keppel.UserIdentity interface.
+func (uid *userIdentity) DeserializeFromJSON(in []byte, ad keppel.AuthDriver) error {
+	if _, ok := ad.(*AuthDriver); !ok {
+		return keppel.ErrAuthDriverMismatch
+	}
+	err := json.Unmarshal(in, uid)
+	if err == nil && uid.Name == "" {
+		err = errors.New("user name is missing")
+	}
+	return err
+}
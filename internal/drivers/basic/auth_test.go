@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package basic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+func TestAuthDriver(t *testing.T) {
+	// work on a copy of the config file, since we want to modify it later
+	buf, err := os.ReadFile("./fixtures/auth.yaml")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	configPath := filepath.Join(t.TempDir(), "auth.yaml")
+	err = os.WriteFile(configPath, buf, 0o666)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	t.Setenv("KEPPEL_AUTH_CONFIG_PATH", configPath)
+	ad, err := keppel.NewAuthDriver(t.Context(), "basic", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// happy case: permissions are taken from the grants in the config file
+	uid, rerr := ad.AuthenticateUser(t.Context(), "alice", "alice-secret")
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	assert.DeepEqual(t, "UserName", uid.UserName(), "alice")
+	expectPermissions(t, uid, map[string]bool{
+		"view:tenant1": true,
+		"pull:tenant1": true,
+		"push:tenant1": true,
+		"view:tenant2": true,
+		"push:tenant2": false,
+		"view:tenant3": false,
+		"admin:":       false,
+	})
+
+	// permissions survive a serialization roundtrip
+	payload, err := uid.SerializeToJSON()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	uid2 := &userIdentity{}
+	err = uid2.DeserializeFromJSON(payload, ad)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "deserialized identity", uid2, uid.(*userIdentity))
+
+	// credentials can also be given in the request headers
+	req := httptest.NewRequest(http.MethodGet, "/keppel/v1/accounts", http.NoBody)
+	req.Header.Set(userNameHeader, "bob")
+	req.Header.Set(passwordHeader, "bob-secret")
+	uid, rerr = ad.AuthenticateUserFromRequest(req)
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	expectPermissions(t, uid, map[string]bool{
+		"admin:":       true,
+		"view:tenant1": false,
+	})
+
+	// no credentials -> anonymous
+	req = httptest.NewRequest(http.MethodGet, "/keppel/v1/accounts", http.NoBody)
+	uid, rerr = ad.AuthenticateUserFromRequest(req)
+	if uid != nil || rerr != nil {
+		t.Errorf("expected (nil, nil) for request without credentials, but got (%#v, %#v)", uid, rerr)
+	}
+
+	// failure cases
+	expectFailure := func(userName, password string) {
+		t.Helper()
+		_, rerr := ad.AuthenticateUser(t.Context(), userName, password)
+		if rerr == nil {
+			t.Errorf("expected authentication of %q to fail, but it succeeded", userName)
+		}
+	}
+	expectFailure("alice", "bob-secret")
+	expectFailure("alice", "")
+	expectFailure("carol", "alice-secret")
+
+	// unknown users are checked against a dummy hash with the same cost as the real ones
+	// (so that they cannot be distinguished from known users by timing)
+	cost, err := bcrypt.Cost(ad.(*AuthDriver).config.dummyHash)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "cost of dummy hash", cost, bcrypt.MinCost)
+
+	// changes to the config file are picked up without restarting
+	oldHash := "$2a$04$MErBAom3Hb53OlEnpdqvZ.uKCO5T1SRspDJTsKphhDGbaa4BqHTtu"
+	newHash := "$2a$04$3efKxO3.tHVK7pgnMjEzcOgS2yGStWGh7Q0w3RLP0KvHCjlLQHIa2"
+	buf = []byte(strings.Replace(string(buf), oldHash, newHash, 1))
+	writeConfig := func(buf []byte, modTime time.Time) {
+		t.Helper()
+		err := os.WriteFile(configPath, buf, 0o666)
+		if err == nil {
+			err = os.Chtimes(configPath, modTime, modTime)
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	writeConfig(buf, time.Now().Add(time.Minute))
+	expectFailure("alice", "alice-secret")
+	_, rerr = ad.AuthenticateUser(t.Context(), "alice", "alice-new-secret")
+	if rerr != nil {
+		t.Error(rerr.Error())
+	}
+
+	// if the new config file is invalid, the previous version stays in effect
+	writeConfig([]byte(`{"users":[{"name":"alice","password_hash":"$2y$10$foo"}]}`), time.Now().Add(2*time.Minute))
+	_, rerr = ad.AuthenticateUser(t.Context(), "alice", "alice-new-secret")
+	if rerr != nil {
+		t.Error(rerr.Error())
+	}
+}
+
+func TestLoadAuthConfigErrors(t *testing.T) {
+	bobHash := "$2y$04$dhoVraWOYlKmZLp8ZAsBT.YAbE2BXh3bD4AoR5wJiL63OHHdelfqm"
+	testCases := map[string]string{
+		`{"users":[{"name":"alice","password_hash":"plaintext"}]}`:                                                          "expected a bcrypt hash",
+		`{"users":[{"name":"alice","password_hash":"$6$salt$VsAiQ./Xl.kmmWMlMnuAfavurNLFkPQdK0aN1OiEH"}]}`:                  "expected a bcrypt hash",
+		`{"users":[{"name":"","password_hash":"` + bobHash + `"}]}`:                                                         "does not have a name",
+		`{"users":[{"name":"alice","password_hash":"` + bobHash + `"},{"name":"alice","password_hash":"` + bobHash + `"}]}`: "duplicate user name",
+		`{"users":[{"name":"alice","password_hash":"` + bobHash + `","grants":[{"permissions":["pull"]}]}]}`:                "does not have a tenant_id",
+		`{"users":[{"name":"alice","password_hash":"` + bobHash + `","grants":[{"permissions":["fly"]}]}]}`:                 "unknown permission",
+		`{"users":[{"name":"alice","password_hash":"` + bobHash + `"}],"unknown_field":1}`:                                  "not found in type",
+		"users:\n  - name: alice\n    password_hash: plaintext\n":                                                           "expected a bcrypt hash",
+	}
+	for input, expectedError := range testCases {
+		path := filepath.Join(t.TempDir(), "auth.yaml")
+		err := os.WriteFile(path, []byte(input), 0o666)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_, err = loadAuthConfig(path)
+		if err == nil || !strings.Contains(err.Error(), expectedError) {
+			t.Errorf("expected error containing %q for %s, but got: %v", expectedError, input, err)
+		}
+	}
+}
+
+func expectPermissions(t *testing.T, uid keppel.UserIdentity, expected map[string]bool) {
+	t.Helper()
+	for key, expectedValue := range expected {
+		perm, tenantID, _ := strings.Cut(key, ":")
+		actualValue := uid.HasPermission(keppel.Permission(perm), tenantID)
+		if actualValue != expectedValue {
+			t.Errorf("expected HasPermission(%q, %q) = %t, but got %t", perm, tenantID, expectedValue, actualValue)
+		}
+	}
+}
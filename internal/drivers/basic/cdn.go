@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package basic
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sapcc/go-bits/osext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// CDNDriver is the CDN driver "basic". It generates URLs that are signed with
+// a shared secret, and purges blobs by posting their URLs to a purge endpoint.
+type CDNDriver struct {
+	URLTemplate string
+	SigningKey  []byte
+	URLLifetime time.Duration
+	PurgeURL    string // optional
+	PurgeToken  string // optional
+	HTTPClient  *http.Client
+	// non-pure functions that can be replaced by deterministic doubles for unit tests
+	TimeNow func() time.Time
+}
+
+func init() {
+	keppel.CDNDriverRegistry.Add(func() keppel.CDNDriver { return &CDNDriver{} })
+}
+
+// PluginTypeID implements the keppel.CDNDriver interface.
+func (d *CDNDriver) PluginTypeID() string { return "basic" }
+
+// Init implements the keppel.CDNDriver interface.
+func (d *CDNDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	d.URLTemplate = osext.MustGetenv("KEPPEL_CDN_URL_TEMPLATE")
+	if !strings.Contains(d.URLTemplate, "%DIGEST%") {
+		return errors.New("malformed KEPPEL_CDN_URL_TEMPLATE: must contain the %DIGEST% placeholder")
+	}
+	d.SigningKey = []byte(osext.MustGetenv("KEPPEL_CDN_SIGNING_KEY"))
+
+	d.URLLifetime = 10 * time.Minute
+	if val := os.Getenv("KEPPEL_CDN_URL_LIFETIME"); val != "" {
+		var err error
+		d.URLLifetime, err = time.ParseDuration(val)
+		if err != nil || d.URLLifetime <= 0 {
+			return fmt.Errorf("malformed KEPPEL_CDN_URL_LIFETIME: expected a positive duration, but got %q", val)
+		}
+	}
+
+	d.PurgeURL = os.Getenv("KEPPEL_CDN_PURGE_URL")
+	d.PurgeToken = os.Getenv("KEPPEL_CDN_PURGE_TOKEN")
+	d.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	d.TimeNow = time.Now
+	return nil
+}
+
+func (d *CDNDriver) unsignedURLForBlob(account models.ReducedAccount, blob models.Blob) (*url.URL, error) {
+	s := d.URLTemplate
+	s = strings.ReplaceAll(s, "%ACCOUNT_NAME%", string(account.Name))
+	s = strings.ReplaceAll(s, "%AUTH_TENANT_ID%", account.AuthTenantID)
+	s = strings.ReplaceAll(s, "%DIGEST%", blob.Digest.String())
+	return url.Parse(s)
+}
+
+// URLForBlob implements the keppel.CDNDriver interface.
+//
+// The URL gets the query parameters "expires" (a UNIX timestamp) and
+// "signature" (the hex-encoded HMAC-SHA256 of the URL path and the "expires"
+// parameter, using the signing key). The CDN is expected to verify both.
+func (d *CDNDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob) (string, error) {
+	u, err := d.unsignedURLForBlob(account, blob)
+	if err != nil {
+		return "", fmt.Errorf("cannot build CDN URL for blob %s: %w", blob.Digest, err)
+	}
+
+	expires := strconv.FormatInt(d.TimeNow().Add(d.URLLifetime).Unix(), 10)
+	query := u.Query()
+	query.Set("expires", expires)
+	query.Set("signature", d.sign(u.Path, expires))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (d *CDNDriver) sign(path, expires string) string {
+	mac := hmac.New(sha256.New, d.SigningKey)
+	mac.Write([]byte(path + "?expires=" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PurgeBlob implements the keppel.CDNDriver interface.
+//
+// The unsigned URL of the blob is sent to the purge endpoint as
+// `{"urls":["..."]}`. If no purge endpoint is configured, this does nothing.
+func (d *CDNDriver) PurgeBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob) error {
+	if d.PurgeURL == "" {
+		return nil
+	}
+	u, err := d.unsignedURLForBlob(account, blob)
+	if err != nil {
+		return fmt.Errorf("cannot build CDN URL for blob %s: %w", blob.Digest, err)
+	}
+	reqBody, err := json.Marshal(map[string][]string{"urls": {u.String()}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.PurgeURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.PurgeToken != "" {
+		req.Header.Set("Authorization", "Bearer "+d.PurgeToken)
+	}
+	resp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("while purging blob %s from CDN: %w", blob.Digest, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("while purging blob %s from CDN: expected 2xx response, but got %s", blob.Digest, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package basic
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestCDNDriver(t *testing.T) {
+	var purgedURLs []string
+	purgeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secrettoken" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			URLs []string `json:"urls"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		purgedURLs = append(purgedURLs, body.URLs...)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer purgeServer.Close()
+
+	t.Setenv("KEPPEL_CDN_URL_TEMPLATE", "https://cdn.example.org/%ACCOUNT_NAME%/%DIGEST%")
+	t.Setenv("KEPPEL_CDN_SIGNING_KEY", "supersecret")
+	t.Setenv("KEPPEL_CDN_URL_LIFETIME", "5m")
+	t.Setenv("KEPPEL_CDN_PURGE_URL", purgeServer.URL)
+	t.Setenv("KEPPEL_CDN_PURGE_TOKEN", "secrettoken")
+
+	d := &CDNDriver{}
+	err := d.Init(t.Context(), keppel.Configuration{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	d.TimeNow = func() time.Time { return time.Unix(10000, 0) }
+
+	account := models.ReducedAccount{Name: "test1"}
+	blob := models.Blob{Digest: digest.FromString("hello")}
+	blobPath := "/test1/" + blob.Digest.String()
+
+	// generated URLs are signed with the signing key
+	url, err := d.URLForBlob(t.Context(), account, blob)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectedURL := "https://cdn.example.org" + blobPath + "?expires=10300&signature=" + d.sign(blobPath, "10300")
+	assert.DeepEqual(t, "URLForBlob", url, expectedURL)
+
+	// the signature depends on the signing key
+	otherDriver := *d
+	otherDriver.SigningKey = []byte("othersecret")
+	if otherDriver.sign(blobPath, "10300") == d.sign(blobPath, "10300") {
+		t.Error("expected signatures to differ for different signing keys")
+	}
+
+	// purging sends the unsigned URL to the purge endpoint
+	err = d.PurgeBlob(t.Context(), account, blob)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "purged URLs", purgedURLs, []string{"https://cdn.example.org" + blobPath})
+
+	// errors from the purge endpoint are reported
+	d.PurgeToken = "wrongtoken"
+	err = d.PurgeBlob(t.Context(), account, blob)
+	if err == nil {
+		t.Error("expected PurgeBlob to fail with wrong token, but it succeeded")
+	}
+}
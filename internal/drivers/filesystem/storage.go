@@ -5,12 +5,15 @@ package filesystem
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/opencontainers/go-digest"
 	"github.com/sapcc/go-bits/osext"
@@ -24,6 +27,23 @@ func init() {
 }
 
 // StorageDriver (driver ID "filesystem") is a keppel.StorageDriver that stores its contents in the local filesystem.
+//
+// Each account gets its own directory below the root path, with the following layout:
+//
+//	$ROOT/$AUTH_TENANT_ID/$ACCOUNT_NAME/blobs/$STORAGE_ID              -- finalized blobs
+//	$ROOT/$AUTH_TENANT_ID/$ACCOUNT_NAME/uploads/$STORAGE_ID            -- blob uploads in progress
+//	$ROOT/$AUTH_TENANT_ID/$ACCOUNT_NAME/uploads/$STORAGE_ID.chunks     -- chunk metadata for blob uploads in progress
+//	$ROOT/$AUTH_TENANT_ID/$ACCOUNT_NAME/manifests/$REPO_NAME/$DIGEST   -- manifests
+//
+// For uploads in progress, the sidecar file next to the upload records how many
+// chunks have been appended and how many bytes those chunks amount to. The
+// sidecar file is only replaced after a chunk has been written and synced
+// completely, so it always describes the last fully committed chunk, even
+// after a crash.
+//
+// All files are written into temporary files first, synced to disk, and then
+// renamed into place. The containing directory is synced after each rename or
+// removal, so that completed operations survive a power loss.
 type StorageDriver struct {
 	rootPath string
 }
@@ -34,60 +54,224 @@ func (d *StorageDriver) PluginTypeID() string { return "filesystem" }
 // Init implements the keppel.StorageDriver interface.
 func (d *StorageDriver) Init(ad keppel.AuthDriver, cfg keppel.Configuration) (err error) {
 	d.rootPath, err = filepath.Abs(osext.MustGetenv("KEPPEL_FILESYSTEM_PATH"))
-	return err
+	if err != nil {
+		return err
+	}
+	return d.checkRootPath()
+}
+
+func (d *StorageDriver) checkRootPath() error {
+	fi, err := os.Stat(d.rootPath)
+	if err != nil {
+		return fmt.Errorf("cannot use KEPPEL_FILESYSTEM_PATH: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("cannot use KEPPEL_FILESYSTEM_PATH: %s is not a directory", d.rootPath)
+	}
+	return nil
+}
+
+func (d *StorageDriver) getAccountPath(account models.ReducedAccount) string {
+	return filepath.Join(d.rootPath, account.AuthTenantID, string(account.Name))
 }
 
 func (d *StorageDriver) getBlobBasePath(account models.ReducedAccount) string {
-	return fmt.Sprintf("%s/%s/%s/blobs", d.rootPath, account.AuthTenantID, account.Name)
+	return filepath.Join(d.getAccountPath(account), "blobs")
 }
 
 func (d *StorageDriver) getBlobPath(account models.ReducedAccount, storageID string) string {
-	return fmt.Sprintf("%s/%s/%s/blobs/%s", d.rootPath, account.AuthTenantID, account.Name, storageID)
+	return filepath.Join(d.getBlobBasePath(account), storageID)
+}
+
+func (d *StorageDriver) getUploadBasePath(account models.ReducedAccount) string {
+	return filepath.Join(d.getAccountPath(account), "uploads")
+}
+
+func (d *StorageDriver) getUploadPath(account models.ReducedAccount, storageID string) string {
+	return filepath.Join(d.getUploadBasePath(account), storageID)
+}
+
+func (d *StorageDriver) getUploadMetadataPath(account models.ReducedAccount, storageID string) string {
+	return d.getUploadPath(account, storageID) + ".chunks"
 }
 
 func (d *StorageDriver) getManifestBasePath(account models.ReducedAccount) string {
-	return fmt.Sprintf("%s/%s/%s/manifests", d.rootPath, account.AuthTenantID, account.Name)
+	return filepath.Join(d.getAccountPath(account), "manifests")
 }
 
 func (d *StorageDriver) getManifestPath(account models.ReducedAccount, repoName string, manifestDigest digest.Digest) string {
-	return fmt.Sprintf("%s/%s/%s/manifests/%s/%s", d.rootPath, account.AuthTenantID, account.Name, repoName, manifestDigest)
+	return filepath.Join(d.getManifestBasePath(account), filepath.FromSlash(repoName), manifestDigest.String())
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// blob uploads
+
+// Describes an upload in progress, as recorded in its sidecar file.
+type uploadInfo struct {
+	ChunkCount uint32 `json:"chunk_count"`
+	SizeBytes  int64  `json:"size_bytes"`
+}
+
+// Returns nil if there is no upload in progress for this storage ID. An upload
+// without a sidecar file is left behind when the first chunk could not be
+// written completely, and is reported with a chunk count of 0.
+func (d *StorageDriver) findUpload(account models.ReducedAccount, storageID string) (*uploadInfo, error) {
+	_, err := os.Stat(d.getUploadPath(account, storageID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var upload uploadInfo
+	buf, err := os.ReadFile(d.getUploadMetadataPath(account, storageID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &upload, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &upload)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse chunk metadata for upload of blob %s: %w", storageID, err)
+	}
+	return &upload, nil
 }
 
 // AppendToBlob implements the keppel.StorageDriver interface.
 func (d *StorageDriver) AppendToBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkNumber uint32, chunkLength *uint64, chunk io.Reader) error {
-	path := d.getBlobPath(account, storageID)
-	tmpPath := path + ".tmp"
-	flags := os.O_APPEND | os.O_WRONLY
-	if chunkNumber == 1 {
-		err := os.MkdirAll(filepath.Dir(tmpPath), 0777)
-		if err != nil {
+	upload, err := d.findUpload(account, storageID)
+	if err != nil {
+		return err
+	}
+
+	// check that we're calling AppendToBlob() in the correct order
+	var expectedChunkNumber uint32 = 1
+	if upload != nil {
+		expectedChunkNumber = upload.ChunkCount + 1
+	}
+	if chunkNumber != expectedChunkNumber {
+		return fmt.Errorf("expected chunk #%d, but got chunk #%d", expectedChunkNumber, chunkNumber)
+	}
+	if upload == nil {
+		_, err := os.Stat(d.getBlobPath(account, storageID))
+		if err == nil {
+			return fmt.Errorf("cannot append to blob %s: blob is already finalized", storageID)
+		}
+		upload = &uploadInfo{}
+
+		// discard chunk metadata that may have been left behind by a crash in a
+		// previous FinalizeBlob() or AbortBlobUpload() for the same storage ID
+		err = os.Remove(d.getUploadMetadataPath(account, storageID))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
-		flags = flags | os.O_CREATE | os.O_TRUNC
 	}
-	f, err := os.OpenFile(tmpPath, flags, 0666)
+
+	uploadDir := d.getUploadBasePath(account)
+	err = os.MkdirAll(uploadDir, 0777)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(d.getUploadPath(account, storageID), os.O_CREATE|os.O_WRONLY, 0666)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	_, err = io.Copy(f, chunk)
-	return err
+
+	// discard any partial writes of a previous attempt to write this chunk
+	err = f.Truncate(upload.SizeBytes)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(upload.SizeBytes, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	bytesWritten, err := io.Copy(f, chunk)
+	if err != nil {
+		return wrapQuotaError(err)
+	}
+	if chunkLength != nil && uint64(bytesWritten) != *chunkLength {
+		return fmt.Errorf("expected chunk #%d to contain %d bytes, but got %d bytes", chunkNumber, *chunkLength, bytesWritten)
+	}
+	err = f.Sync()
+	if err != nil {
+		return wrapQuotaError(err)
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	// commit the chunk by recording it in the sidecar file
+	buf, err := json.Marshal(uploadInfo{ChunkCount: chunkNumber, SizeBytes: upload.SizeBytes + bytesWritten})
+	if err != nil {
+		return err
+	}
+	return wrapQuotaError(writeFileAtomically(d.getUploadMetadataPath(account, storageID), buf))
 }
 
 // FinalizeBlob implements the keppel.StorageDriver interface.
 func (d *StorageDriver) FinalizeBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error {
-	path := d.getBlobPath(account, storageID)
-	tmpPath := path + ".tmp"
-	return os.Rename(tmpPath, path)
+	upload, err := d.findUpload(account, storageID)
+	if err != nil {
+		return err
+	}
+	if upload == nil {
+		return fmt.Errorf("cannot finalize blob %s: no upload in progress", storageID)
+	}
+	if upload.ChunkCount != chunkCount {
+		return fmt.Errorf("cannot finalize blob %s: expected %d chunks, but found %d chunks", storageID, chunkCount, upload.ChunkCount)
+	}
+
+	// if a previous AppendToBlob() crashed midway, the file may contain trailing garbage
+	uploadPath := d.getUploadPath(account, storageID)
+	err = os.Truncate(uploadPath, upload.SizeBytes)
+	if err != nil {
+		return err
+	}
+
+	blobDir := d.getBlobBasePath(account)
+	err = os.MkdirAll(blobDir, 0777)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(uploadPath, d.getBlobPath(account, storageID))
+	if err != nil {
+		return err
+	}
+	err = syncDir(blobDir)
+	if err != nil {
+		return err
+	}
+	return removeAndSync(d.getUploadMetadataPath(account, storageID))
 }
 
 // AbortBlobUpload implements the keppel.StorageDriver interface.
 func (d *StorageDriver) AbortBlobUpload(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error {
-	path := d.getBlobPath(account, storageID)
-	tmpPath := path + ".tmp"
-	return os.Remove(tmpPath)
+	upload, err := d.findUpload(account, storageID)
+	if err != nil {
+		return err
+	}
+	if upload == nil {
+		return fmt.Errorf("cannot abort upload of blob %s: no upload in progress", storageID)
+	}
+	err = removeAndSync(d.getUploadPath(account, storageID))
+	if err != nil {
+		return err
+	}
+	err = removeAndSync(d.getUploadMetadataPath(account, storageID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // the first chunk was never committed
+	}
+	return err
 }
 
+////////////////////////////////////////////////////////////////////////////////
+// blobs
+
 // ReadBlob implements the keppel.StorageDriver interface.
 func (d *StorageDriver) ReadBlob(ctx context.Context, account models.ReducedAccount, storageID string) (io.ReadCloser, uint64, error) {
 	path := d.getBlobPath(account, storageID)
@@ -103,6 +287,24 @@ func (d *StorageDriver) ReadBlob(ctx context.Context, account models.ReducedAcco
 	return f, keppel.AtLeastZero(stat.Size()), nil
 }
 
+// ReadBlobRange implements the keppel.StorageDriver interface.
+func (d *StorageDriver) ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (io.ReadCloser, error) {
+	path := d.getBlobPath(account, storageID)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Seek(int64(offset), io.SeekStart) //nolint:gosec // offset is within the blob, so it will not be above 2^63
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, int64(length)), f}, nil //nolint:gosec // same as above
+}
+
 // URLForBlob implements the keppel.StorageDriver interface.
 func (d *StorageDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
 	return "", keppel.ErrCannotGenerateURL
@@ -110,10 +312,12 @@ func (d *StorageDriver) URLForBlob(ctx context.Context, account models.ReducedAc
 
 // DeleteBlob implements the keppel.StorageDriver interface.
 func (d *StorageDriver) DeleteBlob(ctx context.Context, account models.ReducedAccount, storageID string) error {
-	path := d.getBlobPath(account, storageID)
-	return os.Remove(path)
+	return removeAndSync(d.getBlobPath(account, storageID))
 }
 
+////////////////////////////////////////////////////////////////////////////////
+// manifests
+
 // ReadManifest implements the keppel.StorageDriver interface.
 func (d *StorageDriver) ReadManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) ([]byte, error) {
 	path := d.getManifestPath(account, repoName, manifestDigest)
@@ -122,122 +326,118 @@ func (d *StorageDriver) ReadManifest(ctx context.Context, account models.Reduced
 
 // WriteManifest implements the keppel.StorageDriver interface.
 func (d *StorageDriver) WriteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest, contents []byte) error {
-	path := d.getManifestPath(account, repoName, manifestDigest)
-	tmpPath := path + ".tmp"
-	err := os.MkdirAll(filepath.Dir(tmpPath), 0777)
-	if err != nil {
-		return err
-	}
-	err = os.WriteFile(tmpPath, contents, 0666)
-	if err != nil {
-		return err
-	}
-	return os.Rename(tmpPath, path)
+	return wrapQuotaError(writeFileAtomically(d.getManifestPath(account, repoName, manifestDigest), contents))
 }
 
 // DeleteManifest implements the keppel.StorageDriver interface.
 func (d *StorageDriver) DeleteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) error {
-	path := d.getManifestPath(account, repoName, manifestDigest)
-	return os.Remove(path)
+	return removeAndSync(d.getManifestPath(account, repoName, manifestDigest))
 }
 
+////////////////////////////////////////////////////////////////////////////////
+// storage sweep
+
 // ListStorageContents implements the keppel.StorageDriver interface.
 func (d *StorageDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount) ([]keppel.StoredBlobInfo, []keppel.StoredManifestInfo, error) {
 	blobs, err := d.getBlobs(account)
 	if err != nil {
 		return nil, nil, err
 	}
+	uploads, err := d.getUploads(account)
+	if err != nil {
+		return nil, nil, err
+	}
 	manifests, err := d.getManifests(account)
 	if err != nil {
 		return nil, nil, err
 	}
-	return blobs, manifests, nil
+	return append(blobs, uploads...), manifests, nil
 }
 
 func (d *StorageDriver) getBlobs(account models.ReducedAccount) ([]keppel.StoredBlobInfo, error) {
-	var blobs []keppel.StoredBlobInfo
-	directory, err := os.Open(d.getBlobBasePath(account))
+	entries, err := os.ReadDir(d.getBlobBasePath(account))
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return []keppel.StoredBlobInfo{}, nil
+			return nil, nil
 		}
 		return nil, err
 	}
-	defer directory.Close()
-	names, err := directory.Readdirnames(-1)
-	if err != nil {
-		return nil, err
-	}
-	for _, name := range names {
-		if strings.HasSuffix(name, ".tmp") {
+	var blobs []keppel.StoredBlobInfo
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
 			continue
 		}
-		blobs = append(blobs, keppel.StoredBlobInfo{
-			StorageID: name,
-		})
+		blobs = append(blobs, keppel.StoredBlobInfo{StorageID: entry.Name()})
 	}
 	return blobs, nil
 }
 
-func (d *StorageDriver) getManifests(account models.ReducedAccount) ([]keppel.StoredManifestInfo, error) {
-	var manifests []keppel.StoredManifestInfo
-	directory, err := os.Open(d.getManifestBasePath(account))
+func (d *StorageDriver) getUploads(account models.ReducedAccount) ([]keppel.StoredBlobInfo, error) {
+	entries, err := os.ReadDir(d.getUploadBasePath(account))
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return []keppel.StoredManifestInfo{}, nil
+			return nil, nil
 		}
 		return nil, err
 	}
-	defer directory.Close()
-	repos, err := directory.Readdirnames(-1)
-	if err != nil {
-		return nil, err
-	}
-	for _, repo := range repos {
-		repoManifests, err := d.getRepoManifests(account, repo)
+	var blobs []keppel.StoredBlobInfo
+	for _, entry := range entries {
+		// skip sidecar files and temporary files (storage IDs do not contain dots)
+		if entry.IsDir() || strings.Contains(entry.Name(), ".") {
+			continue
+		}
+		storageID := entry.Name()
+		upload, err := d.findUpload(account, storageID)
 		if err != nil {
 			return nil, err
 		}
-		manifests = append(manifests, repoManifests...)
+		if upload == nil {
+			continue // removed concurrently
+		}
+		// an upload whose first chunk was not written completely still needs to
+		// be reported as an upload in progress, so that AbortBlobUpload() can
+		// clean it up (ChunkCount = 0 would indicate a finalized blob)
+		blobs = append(blobs, keppel.StoredBlobInfo{StorageID: storageID, ChunkCount: max(upload.ChunkCount, 1)})
 	}
-	return manifests, nil
+	return blobs, nil
 }
 
-func (d *StorageDriver) getRepoManifests(account models.ReducedAccount, repo string) ([]keppel.StoredManifestInfo, error) {
+func (d *StorageDriver) getManifests(account models.ReducedAccount) ([]keppel.StoredManifestInfo, error) {
+	basePath := d.getManifestBasePath(account)
 	var manifests []keppel.StoredManifestInfo
-	directory, err := os.Open(filepath.Join(d.getManifestBasePath(account), repo))
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return []keppel.StoredManifestInfo{}, nil
+	err := filepath.WalkDir(basePath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == basePath && errors.Is(err, os.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return err
 		}
-		return nil, err
-	}
-	defer directory.Close()
-	digests, err := directory.Readdirnames(-1)
-	if err != nil {
-		return nil, err
-	}
-	for _, digestStr := range digests {
-		if strings.HasSuffix(digestStr, ".tmp") {
-			continue
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			return nil
 		}
 
-		manifestDigest, err := digest.Parse(digestStr)
+		// repo names may contain slashes, so everything between the base path
+		// and the file name is the repo name
+		manifestDigest, err := digest.Parse(entry.Name())
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("unexpected file in manifest storage: %s", path)
+		}
+		repoPath, err := filepath.Rel(basePath, filepath.Dir(path))
+		if err != nil {
+			return err
 		}
-
 		manifests = append(manifests, keppel.StoredManifestInfo{
-			RepoName: repo,
+			RepoName: filepath.ToSlash(repoPath),
 			Digest:   manifestDigest,
 		})
-	}
-	return manifests, nil
+		return nil
+	})
+	return manifests, err
 }
 
 // CanSetupAccount implements the keppel.StorageDriver interface.
 func (d *StorageDriver) CanSetupAccount(ctx context.Context, account models.ReducedAccount) error {
-	return nil // this driver does not perform any preflight checks here
+	return d.checkRootPath()
 }
 
 // CleanupAccount implements the keppel.StorageDriver interface.
@@ -245,6 +445,9 @@ func (d *StorageDriver) CleanupAccount(ctx context.Context, account models.Reduc
 	// double-check that cleanup order is right; when the account gets deleted,
 	// all blobs and manifests must have been deleted from it before
 	storedBlobs, storedManifests, err := d.ListStorageContents(ctx, account)
+	if err != nil {
+		return err
+	}
 	if len(storedBlobs) > 0 {
 		return fmt.Errorf(
 			"found undeleted blob during CleanupAccount: storageID = %q",
@@ -258,5 +461,76 @@ func (d *StorageDriver) CleanupAccount(ctx context.Context, account models.Reduc
 			storedManifests[0].Digest,
 		)
 	}
+
+	// only empty directories and leftover temporary files remain at this point
+	err = os.RemoveAll(d.getAccountPath(account))
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(d.getAccountPath(account)))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// helper functions
+
+func writeFileAtomically(path string, contents []byte) (returnedErr error) {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if returnedErr != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	_, err = f.Write(contents)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Full disks and exhausted disk quotas are reported as keppel.ErrStorageQuotaExceeded.
+func wrapQuotaError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		return fmt.Errorf("%w: %s", keppel.ErrStorageQuotaExceeded, err.Error())
+	}
 	return err
 }
+
+func removeAndSync(path string) error {
+	err := os.Remove(path)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Ensures that renames and removals of directory entries are persisted.
+func syncDir(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
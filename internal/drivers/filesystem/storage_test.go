@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package filesystem
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestStorageDriver(t *testing.T) {
+	ctx := context.Background()
+	rootPath := t.TempDir()
+	t.Setenv("KEPPEL_FILESYSTEM_PATH", rootPath)
+	sd := &StorageDriver{}
+	err := sd.Init(nil, keppel.Configuration{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	account := models.ReducedAccount{Name: "test1", AuthTenantID: "tenant1"}
+	err = sd.CanSetupAccount(ctx, account)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// upload a blob in several chunks
+	mustAppend := func(storageID string, chunkNumber uint32, contents string) {
+		t.Helper()
+		chunkLength := uint64(len(contents))
+		err := sd.AppendToBlob(ctx, account, storageID, chunkNumber, &chunkLength, strings.NewReader(contents))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	mustAppend("blob1", 1, "hello ")
+	mustAppend("blob1", 2, "world")
+
+	// chunks must be appended in order
+	err = sd.AppendToBlob(ctx, account, "blob1", 4, nil, strings.NewReader("!"))
+	expectError(t, err, "expected chunk #3, but got chunk #4")
+
+	// a chunk whose length does not match is not committed, but can be retried
+	chunkLength := uint64(5)
+	err = sd.AppendToBlob(ctx, account, "blob1", 3, &chunkLength, strings.NewReader("!"))
+	expectError(t, err, "expected chunk #3 to contain 5 bytes, but got 1 bytes")
+	mustAppend("blob1", 3, "!")
+
+	// start another upload, and write a manifest into a nested repo
+	mustAppend("blob2", 1, "incomplete")
+	manifestDigest := digest.FromString("manifest")
+	err = sd.WriteManifest(ctx, account, "library/alpine", manifestDigest, []byte("manifest"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the upload in progress is reported with its chunk count
+	expectContents(t, sd, account,
+		[]keppel.StoredBlobInfo{{StorageID: "blob1", ChunkCount: 3}, {StorageID: "blob2", ChunkCount: 1}},
+		[]keppel.StoredManifestInfo{{RepoName: "library/alpine", Digest: manifestDigest}},
+	)
+
+	// finalize the first upload and abort the second one
+	err = sd.FinalizeBlob(ctx, account, "blob1", 2)
+	expectError(t, err, "cannot finalize blob blob1: expected 2 chunks, but found 3 chunks")
+	err = sd.FinalizeBlob(ctx, account, "blob1", 3)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = sd.AppendToBlob(ctx, account, "blob1", 1, nil, strings.NewReader("more"))
+	expectError(t, err, "cannot append to blob blob1: blob is already finalized")
+	err = sd.AbortBlobUpload(ctx, account, "blob2", 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectContents(t, sd, account,
+		[]keppel.StoredBlobInfo{{StorageID: "blob1"}},
+		[]keppel.StoredManifestInfo{{RepoName: "library/alpine", Digest: manifestDigest}},
+	)
+
+	// read back what we wrote
+	reader, sizeBytes, err := sd.ReadBlob(ctx, account, "blob1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	reader.Close()
+	if string(contents) != "hello world!" || sizeBytes != 12 {
+		t.Errorf("expected blob contents %q with 12 bytes, but got %q with %d bytes", "hello world!", string(contents), sizeBytes)
+	}
+	reader, err = sd.ReadBlobRange(ctx, account, "blob1", 6, 5)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	contents, err = io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	reader.Close()
+	if string(contents) != "world" {
+		t.Errorf("expected blob range contents %q, but got %q", "world", string(contents))
+	}
+	manifestBytes, err := sd.ReadManifest(ctx, account, "library/alpine", manifestDigest)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(manifestBytes) != "manifest" {
+		t.Errorf("expected manifest contents %q, but got %q", "manifest", string(manifestBytes))
+	}
+
+	// account cannot be cleaned up while it still has contents
+	err = sd.CleanupAccount(ctx, account)
+	expectError(t, err, `found undeleted blob during CleanupAccount: storageID = "blob1"`)
+
+	err = sd.DeleteBlob(ctx, account, "blob1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = sd.DeleteManifest(ctx, account, "library/alpine", manifestDigest)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = sd.CleanupAccount(ctx, account)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = os.Stat(filepath.Join(rootPath, "tenant1", "test1"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected account directory to be removed, but got err = %v", err)
+	}
+}
+
+func TestStorageDriverCrashRecovery(t *testing.T) {
+	ctx := context.Background()
+	rootPath := t.TempDir()
+	t.Setenv("KEPPEL_FILESYSTEM_PATH", rootPath)
+	sd := &StorageDriver{}
+	err := sd.Init(nil, keppel.Configuration{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	account := models.ReducedAccount{Name: "test1", AuthTenantID: "tenant1"}
+	uploadPath := filepath.Join(rootPath, "tenant1", "test1", "uploads")
+
+	// simulate a crash while writing the first chunk of an upload: the data
+	// file exists, but no chunk has been committed to the sidecar file yet
+	err = os.MkdirAll(uploadPath, 0777)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = os.WriteFile(filepath.Join(uploadPath, "blob1"), []byte("garb"), 0666)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectContents(t, sd, account, []keppel.StoredBlobInfo{{StorageID: "blob1", ChunkCount: 1}}, nil)
+	err = sd.AbortBlobUpload(ctx, account, "blob1", 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectContents(t, sd, account, nil, nil)
+
+	// simulate a crash while writing the second chunk: the partial write is
+	// discarded when the chunk is retried
+	err = sd.AppendToBlob(ctx, account, "blob2", 1, nil, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	f, err := os.OpenFile(filepath.Join(uploadPath, "blob2"), os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = f.WriteString(" garbage")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	f.Close()
+	expectContents(t, sd, account, []keppel.StoredBlobInfo{{StorageID: "blob2", ChunkCount: 1}}, nil)
+	err = sd.AppendToBlob(ctx, account, "blob2", 2, nil, strings.NewReader(" world"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = sd.FinalizeBlob(ctx, account, "blob2", 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	buf, err := os.ReadFile(filepath.Join(rootPath, "tenant1", "test1", "blobs", "blob2"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(buf) != "hello world" {
+		t.Errorf("expected blob contents %q, but got %q", "hello world", string(buf))
+	}
+
+	// no sidecar files are left behind
+	entries, err := os.ReadDir(uploadPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected uploads directory to be empty, but found %s", entries[0].Name())
+	}
+}
+
+func expectError(t *testing.T, err error, expected string) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("expected error %q, but got no error", expected)
+	} else if err.Error() != expected {
+		t.Errorf("expected error %q, but got %q", expected, err.Error())
+	}
+}
+
+func expectContents(t *testing.T, sd *StorageDriver, account models.ReducedAccount, expectedBlobs []keppel.StoredBlobInfo, expectedManifests []keppel.StoredManifestInfo) {
+	t.Helper()
+	blobs, manifests, err := sd.ListStorageContents(context.Background(), account)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	slices.SortFunc(blobs, func(lhs, rhs keppel.StoredBlobInfo) int { return strings.Compare(lhs.StorageID, rhs.StorageID) })
+	if !reflect.DeepEqual(blobs, expectedBlobs) {
+		t.Errorf("expected blobs %#v, but got %#v", expectedBlobs, blobs)
+	}
+	if !reflect.DeepEqual(manifests, expectedManifests) {
+		t.Errorf("expected manifests %#v, but got %#v", expectedManifests, manifests)
+	}
+}
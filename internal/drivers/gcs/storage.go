@@ -0,0 +1,778 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/osext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// This file implements a storage driver for Google Cloud Storage, using the
+// JSON API directly. Reference: <https://cloud.google.com/storage/docs/json_api>
+//
+// Chunked blob uploads are mapped onto resumable uploads. GCS requires all
+// pieces of a resumable upload except for the last one to be a multiple of
+// 256 KiB in size, but Keppel does not know which chunk is the last one until
+// FinalizeBlob() is called. Therefore AppendToBlob() only sends the aligned
+// part of each chunk to GCS, and keeps the unaligned remainder in a small
+// state object (see type uploadState) until the next AppendToBlob() or the
+// final FinalizeBlob() call. Since the state lives in the bucket, each call
+// may be served by a different keppel-api instance.
+
+const (
+	// GCS requires all but the last piece of a resumable upload to be a multiple of this.
+	resumableUploadAlignment = 256 << 10 // 256 KiB
+	// Chunks are sent to GCS in pieces of this size, to avoid buffering entire chunks in memory.
+	resumableUploadPieceBytes = 32 * resumableUploadAlignment // 8 MiB
+
+	// GCS answers within this time even after receiving a full piece.
+	responseHeaderTimeout = 1 * time.Minute
+	// The metadata server runs on the same node, so it should answer quickly.
+	metadataRequestTimeout = 10 * time.Second
+
+	// How often sending a piece of a resumable upload is attempted when the
+	// connection is interrupted or GCS reports a temporary error.
+	resumableUploadMaxAttempts = 3
+	resumableUploadRetryDelay  = 1 * time.Second
+)
+
+var prefixRx = regexp.MustCompile(`^[A-Za-z0-9/_.-]*$`)
+
+type storageDriver struct {
+	endpoint string
+	bucket   string
+	prefix   string
+	tokens   *tokenSource
+	client   *http.Client
+	// delay before the second attempt at sending a piece (doubled for each further attempt)
+	retryDelay time.Duration
+}
+
+func init() {
+	keppel.StorageDriverRegistry.Add(func() keppel.StorageDriver { return &storageDriver{} })
+}
+
+// PluginTypeID implements the keppel.StorageDriver interface.
+func (d *storageDriver) PluginTypeID() string { return "gcs" }
+
+// Init implements the keppel.StorageDriver interface.
+func (d *storageDriver) Init(ad keppel.AuthDriver, cfg keppel.Configuration) error {
+	endpoint, err := url.Parse(osext.GetenvOrDefault("KEPPEL_GCS_ENDPOINT", "https://storage.googleapis.com"))
+	if err != nil {
+		return fmt.Errorf("malformed KEPPEL_GCS_ENDPOINT: %w", err)
+	}
+	d.endpoint = strings.TrimSuffix(endpoint.String(), "/")
+	d.bucket = osext.MustGetenv("KEPPEL_GCS_BUCKET")
+	d.prefix = strings.Trim(osext.GetenvOrDefault("KEPPEL_GCS_PREFIX", ""), "/")
+	if !prefixRx.MatchString(d.prefix) {
+		return fmt.Errorf("malformed KEPPEL_GCS_PREFIX: %q contains forbidden characters", d.prefix)
+	}
+	if d.prefix != "" {
+		d.prefix += "/"
+	}
+	d.tokens = &tokenSource{
+		MetadataHost:   osext.GetenvOrDefault("GCE_METADATA_HOST", defaultMetadataHost),
+		ServiceAccount: osext.GetenvOrDefault("KEPPEL_GCS_SERVICE_ACCOUNT", "default"),
+		Client:         &http.Client{Timeout: metadataRequestTimeout},
+		TimeNow:        time.Now,
+	}
+	d.client = newHTTPClient()
+	d.retryDelay = resumableUploadRetryDelay
+	return nil
+}
+
+// Since blob contents are streamed through requests to GCS, requests can take
+// arbitrarily long, so there is no timeout for entire requests. Instead,
+// establishing the connection and waiting for the response are limited.
+func newHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			ResponseHeaderTimeout: responseHeaderTimeout,
+		},
+		// resumable uploads use the status code 308 without a redirect target
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// object names
+
+func (d *storageDriver) accountPrefix(account models.ReducedAccount) string {
+	return fmt.Sprintf("%s%s/", d.prefix, account.Name)
+}
+
+func (d *storageDriver) blobObjectName(account models.ReducedAccount, storageID string) string {
+	return fmt.Sprintf("%s_blobs/%s", d.accountPrefix(account), storageID)
+}
+
+func (d *storageDriver) uploadObjectName(account models.ReducedAccount, storageID string) string {
+	return fmt.Sprintf("%s_uploads/%s", d.accountPrefix(account), storageID)
+}
+
+func (d *storageDriver) manifestObjectName(account models.ReducedAccount, repoName string, manifestDigest digest.Digest) string {
+	return fmt.Sprintf("%s%s/_manifests/%s", d.accountPrefix(account), repoName, manifestDigest)
+}
+
+var (
+	// These regexes are the reverse of the functions above, and operate on object names without the account prefix.
+	blobObjectNameRx     = regexp.MustCompile(`^_blobs/([^/]+)$`)
+	uploadObjectNameRx   = regexp.MustCompile(`^_uploads/([^/]+)$`)
+	manifestObjectNameRx = regexp.MustCompile(`^(.+)/_manifests/([^/]+)$`)
+)
+
+func (d *storageDriver) objectURL(objectName string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", d.endpoint, url.PathEscape(d.bucket), url.PathEscape(objectName))
+}
+
+func (d *storageDriver) uploadURL(query url.Values) string {
+	return fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", d.endpoint, url.PathEscape(d.bucket), query.Encode())
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// request helpers
+
+// do sends an authenticated request to the GCS API. If `body` is not nil,
+// `contentLength` must be given, and `body` must be a *bytes.Reader or a
+// *strings.Reader, so that the request can be repeated with a fresh token if
+// GCS rejects the cached one.
+func (d *storageDriver) do(ctx context.Context, method, reqURL string, header http.Header, body io.Reader, contentLength int64) (*http.Response, error) {
+	if body == nil {
+		body = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.ContentLength = contentLength
+	if body != http.NoBody && req.GetBody == nil {
+		return nil, fmt.Errorf("cannot send %s %s: request body cannot be repeated", method, req.URL.Path)
+	}
+
+	for attempt := 1; ; attempt++ {
+		token, err := d.tokens.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("during %s %s: %w", method, req.URL.Path, err)
+		}
+		// tokens can be revoked before their announced expiry (e.g. when the
+		// Workload Identity binding changes), so a rejected token is replaced once
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 1 {
+			return resp, nil
+		}
+		resp.Body.Close()
+		d.tokens.Invalidate(token)
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// expectStatus consumes the response and returns an error unless it has one of the given status codes.
+func expectStatus(resp *http.Response, action string, statusCodes ...int) error {
+	defer resp.Body.Close()
+	for _, code := range statusCodes {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("while %s in GCS: unexpected status %s", action, resp.Status)
+	}
+	return fmt.Errorf("while %s in GCS: unexpected status %s: %s", action, resp.Status, strings.TrimSpace(string(respBytes)))
+}
+
+func (d *storageDriver) writeObject(ctx context.Context, objectName string, contents []byte) error {
+	reqURL := d.uploadURL(url.Values{"uploadType": {"media"}, "name": {objectName}})
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err := d.do(ctx, http.MethodPost, reqURL, header, bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		return err
+	}
+	return expectStatus(resp, "writing "+objectName, http.StatusOK)
+}
+
+// Returns (nil, nil) if the object does not exist.
+func (d *storageDriver) readObject(ctx context.Context, objectName string) (io.ReadCloser, int64, error) {
+	resp, err := d.do(ctx, http.MethodGet, d.objectURL(objectName)+"?alt=media", nil, nil, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, 0, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, expectStatus(resp, "reading "+objectName, http.StatusOK)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func (d *storageDriver) deleteObject(ctx context.Context, objectName string) error {
+	resp, err := d.do(ctx, http.MethodDelete, d.objectURL(objectName), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	return expectStatus(resp, "deleting "+objectName, http.StatusNoContent)
+}
+
+// listObjects calls the callback for the names of all objects below the given prefix.
+func (d *storageDriver) listObjects(ctx context.Context, prefix string, callback func(objectName string) error) error {
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name),nextPageToken"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		reqURL := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", d.endpoint, url.PathEscape(d.bucket), query.Encode())
+		resp, err := d.do(ctx, http.MethodGet, reqURL, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return expectStatus(resp, "listing objects", http.StatusOK)
+		}
+		var data struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&data)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("while parsing object list from GCS: %w", err)
+		}
+
+		for _, item := range data.Items {
+			err := callback(item.Name)
+			if err != nil {
+				return err
+			}
+		}
+		if data.NextPageToken == "" {
+			return nil
+		}
+		pageToken = data.NextPageToken
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// blob uploads
+
+// uploadState is stored in the upload object while a chunked upload is in progress.
+type uploadState struct {
+	SessionURI string `json:"session_uri"`
+	ChunkCount uint32 `json:"chunk_count"`
+	// number of bytes that GCS has confirmed to have received
+	Offset int64 `json:"offset"`
+	// bytes that were appended by Keppel, but not sent to GCS yet
+	// because they do not make up a full piece of resumableUploadAlignment
+	Tail []byte `json:"tail,omitempty"`
+}
+
+// Returns (nil, nil) if there is no upload in progress for this storage ID.
+func (d *storageDriver) readUploadState(ctx context.Context, account models.ReducedAccount, storageID string) (*uploadState, error) {
+	reader, _, err := d.readObject(ctx, d.uploadObjectName(account, storageID))
+	if err != nil || reader == nil {
+		return nil, err
+	}
+	defer reader.Close()
+	var state uploadState
+	err = json.NewDecoder(reader).Decode(&state)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing state of upload %s: %w", storageID, err)
+	}
+	return &state, nil
+}
+
+func (d *storageDriver) writeUploadState(ctx context.Context, account models.ReducedAccount, storageID string, state uploadState) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return d.writeObject(ctx, d.uploadObjectName(account, storageID), buf)
+}
+
+func (d *storageDriver) startResumableUpload(ctx context.Context, objectName string) (string, error) {
+	reqURL := d.uploadURL(url.Values{"uploadType": {"resumable"}, "name": {objectName}})
+	header := http.Header{
+		"Content-Type":          {"application/json"},
+		"X-Upload-Content-Type": {"application/octet-stream"},
+	}
+	resp, err := d.do(ctx, http.MethodPost, reqURL, header, strings.NewReader("{}"), 2)
+	if err != nil {
+		return "", err
+	}
+	sessionURI := resp.Header.Get("Location")
+	err = expectStatus(resp, "starting upload of "+objectName, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	if sessionURI == "" {
+		return "", fmt.Errorf("while starting upload of %s in GCS: no session URI returned", objectName)
+	}
+	return sessionURI, nil
+}
+
+// uploadStatus is the state of a resumable upload, as reported by GCS in
+// response to sending a piece or to a status query.
+type uploadStatus struct {
+	// number of bytes that GCS has persisted
+	PersistedOffset int64
+	// whether the upload has been completed
+	IsComplete bool
+}
+
+// errUploadInterrupted wraps errors after which a piece of a resumable upload
+// can be sent again, after checking how much of it was persisted.
+type errUploadInterrupted struct {
+	Err error
+}
+
+func (e errUploadInterrupted) Error() string { return e.Err.Error() }
+func (e errUploadInterrupted) Unwrap() error { return e.Err }
+
+// Sends the given bytes (which must start at `offset`) to a resumable upload
+// session. If `final` is true, the total size of the object is announced as
+// `end`, which completes the upload. Without any bytes and without `final`,
+// this only queries the status of the upload.
+func (d *storageDriver) putToSession(ctx context.Context, sessionURI string, offset, end int64, data []byte, final bool) (uploadStatus, error) {
+	var contentRange string
+	switch {
+	case len(data) == 0 && !final:
+		contentRange = "bytes */*"
+	case len(data) == 0:
+		contentRange = fmt.Sprintf("bytes */%d", end)
+	case final:
+		contentRange = fmt.Sprintf("bytes %d-%d/%d", offset, end-1, end)
+	default:
+		contentRange = fmt.Sprintf("bytes %d-%d/*", offset, end-1)
+	}
+
+	header := http.Header{"Content-Range": {contentRange}}
+	resp, err := d.do(ctx, http.MethodPut, sessionURI, header, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return uploadStatus{}, errUploadInterrupted{err}
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		resp.Body.Close()
+		return uploadStatus{PersistedOffset: end, IsComplete: true}, nil
+	case resp.StatusCode == http.StatusPermanentRedirect:
+		// 308 Resume Incomplete reports how many bytes have been persisted in
+		// total (if nothing was persisted yet, there is no Range header)
+		persistedRange := resp.Header.Get("Range")
+		resp.Body.Close()
+		if persistedRange == "" {
+			return uploadStatus{}, nil
+		}
+		lastByteStr, ok := strings.CutPrefix(persistedRange, "bytes=0-")
+		lastByte, err := strconv.ParseInt(lastByteStr, 10, 64)
+		if !ok || err != nil {
+			return uploadStatus{}, fmt.Errorf("while sending to resumable upload in GCS: malformed persisted range %q", persistedRange)
+		}
+		return uploadStatus{PersistedOffset: lastByte + 1}, nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return uploadStatus{}, errUploadInterrupted{expectStatus(resp, "sending to resumable upload", http.StatusPermanentRedirect)}
+	default:
+		return uploadStatus{}, expectStatus(resp, "sending to resumable upload", http.StatusPermanentRedirect)
+	}
+}
+
+// Sends the next piece of a resumable upload. For the final piece, the total
+// size of the object is announced, which completes the upload.
+//
+// If the connection is interrupted or GCS reports a temporary error, the
+// status of the upload is queried and the part of the piece that was not
+// persisted is sent again. The same happens if GCS confirms only a part of
+// the piece, which it is allowed to do.
+// Reference: <https://cloud.google.com/storage/docs/performing-resumable-uploads#resume-upload>
+func (d *storageDriver) sendPiece(ctx context.Context, state *uploadState, piece []byte, final bool) error {
+	if len(piece) == 0 && !final {
+		return nil
+	}
+	start := state.Offset
+	end := start + int64(len(piece))
+	offset := start
+	for attempt := 1; ; attempt++ {
+		status, err := d.putToSession(ctx, state.SessionURI, offset, end, piece[offset-start:], final)
+		if errors.As(err, &errUploadInterrupted{}) && attempt < resumableUploadMaxAttempts {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(d.retryDelay << (attempt - 1)):
+			}
+			status, err = d.putToSession(ctx, state.SessionURI, 0, 0, nil, false)
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case status.IsComplete && final:
+			state.Offset = end
+			return nil
+		case status.IsComplete:
+			return errors.New("while sending piece of resumable upload to GCS: upload was completed unexpectedly")
+		case status.PersistedOffset < start || status.PersistedOffset > end:
+			return fmt.Errorf("while sending piece of resumable upload to GCS: expected persisted range to end between byte %d and %d, but GCS has persisted %d bytes", start, end, status.PersistedOffset)
+		case status.PersistedOffset == end && !final:
+			state.Offset = end
+			return nil
+		case attempt >= resumableUploadMaxAttempts:
+			return fmt.Errorf("while sending piece of resumable upload to GCS: only %d of %d bytes were persisted after %d attempts", status.PersistedOffset-start, end-start, attempt)
+		}
+		offset = status.PersistedOffset
+	}
+}
+
+// Asks GCS how many bytes of the resumable upload it has persisted, and
+// checks that this matches the upload state. This can only be different if a
+// previous AppendToBlob() failed after sending a part of its chunk (e.g.
+// because the chunk turned out to be too short, or because the process
+// crashed). Since GCS does not allow to take back data, the upload cannot be
+// continued in this case, and needs to be aborted.
+func (d *storageDriver) checkPersistedOffset(ctx context.Context, state uploadState) error {
+	status, err := d.putToSession(ctx, state.SessionURI, 0, 0, nil, false)
+	if err != nil {
+		return err
+	}
+	if status.IsComplete {
+		return errors.New("cannot continue resumable upload in GCS: upload was completed already")
+	}
+	if status.PersistedOffset != state.Offset {
+		return fmt.Errorf("cannot continue resumable upload in GCS: expected %d bytes to be persisted, but found %d bytes (a previous chunk was only partially written)", state.Offset, status.PersistedOffset)
+	}
+	return nil
+}
+
+// AppendToBlob implements the keppel.StorageDriver interface.
+func (d *storageDriver) AppendToBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkNumber uint32, chunkLength *uint64, chunk io.Reader) error {
+	state, err := d.readUploadState(ctx, account, storageID)
+	if err != nil {
+		return err
+	}
+
+	// check that we're calling AppendToBlob() in the correct order
+	var expectedChunkNumber uint32 = 1
+	if state != nil {
+		expectedChunkNumber = state.ChunkCount + 1
+	}
+	if chunkNumber != expectedChunkNumber {
+		return fmt.Errorf("expected chunk #%d, but got chunk #%d", expectedChunkNumber, chunkNumber)
+	}
+	if state == nil {
+		sessionURI, err := d.startResumableUpload(ctx, d.blobObjectName(account, storageID))
+		if err != nil {
+			return err
+		}
+		state = &uploadState{SessionURI: sessionURI}
+	} else {
+		err := d.checkPersistedOffset(ctx, *state)
+		if err != nil {
+			return err
+		}
+	}
+
+	// if the chunk length is known, it is checked before sending each piece,
+	// so that no data beyond the chunk length is ever sent (reading one more
+	// byte than expected is enough to detect an overlong chunk)
+	if chunkLength != nil {
+		chunk = io.LimitReader(chunk, int64(*chunkLength)+1) //nolint:gosec // chunk lengths are far below MaxInt64
+	}
+	var bytesRead uint64
+	checkLength := func(isComplete bool) error {
+		if chunkLength == nil || bytesRead == *chunkLength || (bytesRead < *chunkLength && !isComplete) {
+			return nil
+		}
+		return keppel.ErrSizeInvalid.With("expected %d bytes, but got %d bytes", *chunkLength, bytesRead)
+	}
+
+	// send the chunk (plus the tail left over from the previous chunk) in
+	// pieces, and keep the unaligned remainder as the new tail
+	buf := make([]byte, resumableUploadPieceBytes)
+	filled := copy(buf, state.Tail)
+	for {
+		n, err := io.ReadFull(chunk, buf[filled:])
+		filled += n
+		bytesRead += uint64(n) //nolint:gosec // n is never negative
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		err = checkLength(false)
+		if err != nil {
+			return err
+		}
+		err = d.sendPiece(ctx, state, buf[:filled], false)
+		if err != nil {
+			return err
+		}
+		filled = 0
+	}
+	err = checkLength(true)
+	if err != nil {
+		return err
+	}
+	aligned := filled - filled%resumableUploadAlignment
+	err = d.sendPiece(ctx, state, buf[:aligned], false)
+	if err != nil {
+		return err
+	}
+	state.Tail = buf[aligned:filled]
+
+	state.ChunkCount = chunkNumber
+	return d.writeUploadState(ctx, account, storageID, *state)
+}
+
+// FinalizeBlob implements the keppel.StorageDriver interface.
+func (d *storageDriver) FinalizeBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error {
+	state, err := d.readUploadState(ctx, account, storageID)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("cannot finalize blob %s: no upload in progress", storageID)
+	}
+	if state.ChunkCount != chunkCount {
+		return fmt.Errorf("cannot finalize blob %s: expected %d chunks, but found %d chunks", storageID, chunkCount, state.ChunkCount)
+	}
+
+	err = d.checkPersistedOffset(ctx, *state)
+	if err != nil {
+		return err
+	}
+	err = d.sendPiece(ctx, state, state.Tail, true)
+	if err != nil {
+		return err
+	}
+	return d.deleteObject(ctx, d.uploadObjectName(account, storageID))
+}
+
+// AbortBlobUpload implements the keppel.StorageDriver interface.
+func (d *storageDriver) AbortBlobUpload(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error {
+	state, err := d.readUploadState(ctx, account, storageID)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("cannot abort upload of blob %s: no upload in progress", storageID)
+	}
+
+	// cancel the resumable upload session (GCS answers this with the unusual
+	// status code 499; a 404 means that the session has expired already)
+	resp, err := d.do(ctx, http.MethodDelete, state.SessionURI, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	err = expectStatus(resp, "cancelling resumable upload", 499, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	return d.deleteObject(ctx, d.uploadObjectName(account, storageID))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// blobs
+
+// ReadBlob implements the keppel.StorageDriver interface.
+func (d *storageDriver) ReadBlob(ctx context.Context, account models.ReducedAccount, storageID string) (io.ReadCloser, uint64, error) {
+	objectName := d.blobObjectName(account, storageID)
+	reader, sizeBytes, err := d.readObject(ctx, objectName)
+	if err != nil {
+		return nil, 0, err
+	}
+	if reader == nil {
+		return nil, 0, fmt.Errorf("while reading %s in GCS: object not found", objectName)
+	}
+	return reader, keppel.AtLeastZero(sizeBytes), nil
+}
+
+// ReadBlobRange implements the keppel.StorageDriver interface.
+func (d *storageDriver) ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (io.ReadCloser, error) {
+	objectName := d.blobObjectName(account, storageID)
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)}}
+	resp, err := d.do(ctx, http.MethodGet, d.objectURL(objectName)+"?alt=media", header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, expectStatus(resp, "reading "+objectName, http.StatusPartialContent)
+	}
+	return resp.Body, nil
+}
+
+// URLForBlob implements the keppel.StorageDriver interface.
+func (d *storageDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
+	// signed URLs would require either a service account key or calls to the
+	// IAM Credentials API, neither of which is available with Workload Identity alone
+	return "", keppel.ErrCannotGenerateURL
+}
+
+// DeleteBlob implements the keppel.StorageDriver interface.
+func (d *storageDriver) DeleteBlob(ctx context.Context, account models.ReducedAccount, storageID string) error {
+	return d.deleteObject(ctx, d.blobObjectName(account, storageID))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// manifests
+
+// ReadManifest implements the keppel.StorageDriver interface.
+func (d *storageDriver) ReadManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) ([]byte, error) {
+	objectName := d.manifestObjectName(account, repoName, manifestDigest)
+	reader, _, err := d.readObject(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+	if reader == nil {
+		return nil, fmt.Errorf("while reading %s in GCS: object not found", objectName)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// WriteManifest implements the keppel.StorageDriver interface.
+func (d *storageDriver) WriteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest, contents []byte) error {
+	return d.writeObject(ctx, d.manifestObjectName(account, repoName, manifestDigest), contents)
+}
+
+// DeleteManifest implements the keppel.StorageDriver interface.
+func (d *storageDriver) DeleteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) error {
+	return d.deleteObject(ctx, d.manifestObjectName(account, repoName, manifestDigest))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// storage sweep
+
+// ListStorageContents implements the keppel.StorageDriver interface.
+func (d *storageDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount) ([]keppel.StoredBlobInfo, []keppel.StoredManifestInfo, error) {
+	var (
+		blobs            []keppel.StoredBlobInfo
+		manifests        []keppel.StoredManifestInfo
+		uploadStorageIDs []string
+	)
+	prefix := d.accountPrefix(account)
+	err := d.listObjects(ctx, prefix, func(objectName string) error {
+		name := strings.TrimPrefix(objectName, prefix)
+		if match := blobObjectNameRx.FindStringSubmatch(name); match != nil {
+			blobs = append(blobs, keppel.StoredBlobInfo{StorageID: match[1]})
+			return nil
+		}
+		if match := uploadObjectNameRx.FindStringSubmatch(name); match != nil {
+			uploadStorageIDs = append(uploadStorageIDs, match[1])
+			return nil
+		}
+		if match := manifestObjectNameRx.FindStringSubmatch(name); match != nil {
+			manifestDigest, err := digest.Parse(match[2])
+			if err != nil {
+				return err
+			}
+			manifests = append(manifests, keppel.StoredManifestInfo{
+				RepoName: match[1],
+				Digest:   manifestDigest,
+			})
+			return nil
+		}
+		return fmt.Errorf("encountered unexpected object while listing storage contents of account %s: %s", account.Name, objectName)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the chunk count of uploads in progress is only recorded in their state
+	// (uploads are rare enough that reading each state object is not a problem)
+	for _, storageID := range uploadStorageIDs {
+		state, err := d.readUploadState(ctx, account, storageID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if state == nil {
+			continue // upload was finalized or aborted in the meantime
+		}
+		blobs = append(blobs, keppel.StoredBlobInfo{
+			StorageID:  storageID,
+			ChunkCount: max(state.ChunkCount, 1),
+		})
+	}
+	return blobs, manifests, nil
+}
+
+// CanSetupAccount implements the keppel.StorageDriver interface.
+func (d *storageDriver) CanSetupAccount(ctx context.Context, account models.ReducedAccount) error {
+	// check that the bucket is accessible (this only requires permission to
+	// list objects, unlike a GET on the bucket itself)
+	query := url.Values{"prefix": {d.accountPrefix(account)}, "maxResults": {strconv.Itoa(1)}, "fields": {"nextPageToken"}}
+	reqURL := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", d.endpoint, url.PathEscape(d.bucket), query.Encode())
+	resp, err := d.do(ctx, http.MethodGet, reqURL, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	return expectStatus(resp, "checking access to bucket "+d.bucket, http.StatusOK)
+}
+
+// CleanupAccount implements the keppel.StorageDriver interface.
+func (d *storageDriver) CleanupAccount(ctx context.Context, account models.ReducedAccount) error {
+	// double-check that cleanup order is right; when the account gets deleted,
+	// all blobs and manifests must have been deleted from it before
+	// (there is nothing else to clean up since GCS has no directories)
+	storedBlobs, storedManifests, err := d.ListStorageContents(ctx, account)
+	if err != nil {
+		return err
+	}
+	if len(storedBlobs) > 0 {
+		return fmt.Errorf(
+			"found undeleted blob during CleanupAccount: storageID = %q",
+			storedBlobs[0].StorageID,
+		)
+	}
+	if len(storedManifests) > 0 {
+		return fmt.Errorf(
+			"found undeleted manifest during CleanupAccount: %s@%s",
+			storedManifests[0].RepoName,
+			storedManifests[0].Digest,
+		)
+	}
+	return nil
+}
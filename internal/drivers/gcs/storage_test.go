@@ -0,0 +1,625 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// fake GCS API and metadata server
+
+// fakeGCS implements the parts of the GCS JSON API and of the GCE metadata
+// server that the driver uses, for a single bucket called "bucket".
+type fakeGCS struct {
+	mutex         sync.Mutex
+	objects       map[string][]byte
+	sessions      map[string]*fakeSession
+	sessionCount  int
+	tokenRequests int
+	// only the most recently issued token is accepted
+	validToken string
+	// if set, issued tokens are never accepted
+	rejectNewTokens bool
+	// faults to inject into the next requests that send data to an upload session
+	faults []fakeFault
+}
+
+type fakeSession struct {
+	ObjectName  string
+	Contents    []byte
+	IsCompleted bool
+}
+
+type fakeFault int
+
+const (
+	// persist the first aligned part of the piece (if any), then drop the connection without responding
+	faultDropConnection fakeFault = iota
+	// handle the request normally, but drop the connection instead of responding
+	faultDropResponse
+	// do not persist anything, and respond with 503
+	faultServiceUnavailable
+	// persist only the first aligned part of the piece (if any), and report that in the 308 response
+	faultPartialPersist
+)
+
+func newFakeGCS(t *testing.T) (*fakeGCS, *httptest.Server) {
+	f := &fakeGCS{
+		objects:  make(map[string][]byte),
+		sessions: make(map[string]*fakeSession),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/token" {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor header", http.StatusForbidden)
+			return
+		}
+		f.tokenRequests++
+		token := fmt.Sprintf("token%d", f.tokenRequests)
+		if !f.rejectNewTokens {
+			f.validToken = token
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":%q,"expires_in":3600,"token_type":"Bearer"}`, token)
+		return
+	}
+	if f.validToken == "" || r.Header.Get("Authorization") != "Bearer "+f.validToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	switch path := r.URL.EscapedPath(); {
+	case path == "/upload/storage/v1/b/bucket/o" && query.Has("upload_id"):
+		f.serveResumableUpload(w, r, query.Get("upload_id"), body)
+	case path == "/upload/storage/v1/b/bucket/o" && r.Method == http.MethodPost:
+		switch query.Get("uploadType") {
+		case "media":
+			f.objects[query.Get("name")] = body
+			fmt.Fprint(w, `{}`)
+		case "resumable":
+			f.sessionCount++
+			sessionID := strconv.Itoa(f.sessionCount)
+			f.sessions[sessionID] = &fakeSession{ObjectName: query.Get("name")}
+			w.Header().Set("Location", fmt.Sprintf("http://%s/upload/storage/v1/b/bucket/o?uploadType=resumable&upload_id=%s", r.Host, sessionID))
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.Error(w, "unknown upload type", http.StatusBadRequest)
+		}
+	case path == "/storage/v1/b/bucket/o" && r.Method == http.MethodGet:
+		f.serveList(w, query)
+	case strings.HasPrefix(path, "/storage/v1/b/bucket/o/"):
+		objectName, err := url.PathUnescape(strings.TrimPrefix(path, "/storage/v1/b/bucket/o/"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.serveObject(w, r, objectName)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (f *fakeGCS) serveResumableUpload(w http.ResponseWriter, r *http.Request, sessionID string, body []byte) {
+	session := f.sessions[sessionID]
+	if session == nil {
+		http.Error(w, "no such upload session", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		delete(f.sessions, sessionID)
+		w.WriteHeader(499)
+		return
+	}
+	if session.IsCompleted {
+		// like GCS, report completed uploads when queried again
+		fmt.Fprint(w, `{}`)
+		return
+	}
+	fault, hasFault := fakeFault(0), false
+	if len(body) > 0 && len(f.faults) > 0 {
+		fault, hasFault = f.faults[0], true
+		f.faults = f.faults[1:]
+	}
+	if hasFault && fault == faultServiceUnavailable {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	// parse "bytes <first>-<last>/<total>" or "bytes */<total>" (total may be "*")
+	contentRange, ok := strings.CutPrefix(r.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		http.Error(w, "malformed Content-Range", http.StatusBadRequest)
+		return
+	}
+	byteRange, total, _ := strings.Cut(contentRange, "/")
+	if byteRange != "*" {
+		firstStr, lastStr, _ := strings.Cut(byteRange, "-")
+		first, err1 := strconv.Atoi(firstStr)
+		last, err2 := strconv.Atoi(lastStr)
+		switch {
+		case err1 != nil || err2 != nil || last-first+1 != len(body):
+			http.Error(w, "Content-Range does not match body", http.StatusBadRequest)
+			return
+		case first != len(session.Contents):
+			http.Error(w, "Content-Range does not start at the persisted offset", http.StatusBadRequest)
+			return
+		case total == "*" && len(body)%resumableUploadAlignment != 0:
+			http.Error(w, "non-final piece is not aligned", http.StatusBadRequest)
+			return
+		}
+		if hasFault && (fault == faultDropConnection || fault == faultPartialPersist) {
+			persisted := body[:min(len(body), resumableUploadAlignment)]
+			if len(body) <= resumableUploadAlignment {
+				persisted = nil
+			}
+			session.Contents = append(session.Contents, persisted...)
+			if fault == faultDropConnection {
+				dropConnection(w)
+				return
+			}
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(session.Contents)-1))
+			w.WriteHeader(http.StatusPermanentRedirect)
+			return
+		}
+		session.Contents = append(session.Contents, body...)
+	}
+	if hasFault && fault == faultDropResponse {
+		defer dropConnection(w)
+		w = httptest.NewRecorder()
+	}
+
+	if total == "*" {
+		// upload is not complete yet
+		if len(session.Contents) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(session.Contents)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+	if total != strconv.Itoa(len(session.Contents)) {
+		http.Error(w, "total size does not match", http.StatusBadRequest)
+		return
+	}
+	f.objects[session.ObjectName] = session.Contents
+	session.IsCompleted = true
+	fmt.Fprint(w, `{}`)
+}
+
+// Closes the client connection without sending a response.
+func dropConnection(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(err.Error())
+	}
+	conn.Close()
+}
+
+func (f *fakeGCS) serveList(w http.ResponseWriter, query url.Values) {
+	var names []string
+	for name := range f.objects {
+		if strings.HasPrefix(name, query.Get("prefix")) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	// paginate with a small page size to exercise the driver's pagination
+	const pageSize = 2
+	start := 0
+	if token := query.Get("pageToken"); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := min(start+pageSize, len(names))
+	var items []string
+	for _, name := range names[start:end] {
+		items = append(items, fmt.Sprintf(`{"name":%q}`, name))
+	}
+	nextPageToken := ""
+	if end < len(names) {
+		nextPageToken = strconv.Itoa(end)
+	}
+	fmt.Fprintf(w, `{"items":[%s],"nextPageToken":%q}`, strings.Join(items, ","), nextPageToken)
+}
+
+func (f *fakeGCS) serveObject(w http.ResponseWriter, r *http.Request, objectName string) {
+	contents, exists := f.objects[objectName]
+	if !exists {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodDelete:
+		delete(f.objects, objectName)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		if r.URL.Query().Get("alt") != "media" {
+			http.Error(w, "only media downloads are supported", http.StatusBadRequest)
+			return
+		}
+		http.ServeContent(w, r, objectName, time.Time{}, bytes.NewReader(contents))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeGCS) persistedBytes() []int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var result []int
+	for _, session := range f.sessions {
+		if !session.IsCompleted {
+			result = append(result, len(session.Contents))
+		}
+	}
+	return result
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// tests
+
+func setupStorageDriver(t *testing.T) (*fakeGCS, keppel.StorageDriver) {
+	t.Helper()
+	f, srv := newFakeGCS(t)
+	t.Setenv("KEPPEL_GCS_ENDPOINT", srv.URL)
+	t.Setenv("KEPPEL_GCS_BUCKET", "bucket")
+	t.Setenv("KEPPEL_GCS_PREFIX", "/keppel/")
+	t.Setenv("GCE_METADATA_HOST", srv.Listener.Addr().String())
+	sd, err := keppel.NewStorageDriver("gcs", nil, keppel.Configuration{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return f, sd
+}
+
+// Generates contents that are not aligned to any of the piece sizes.
+func makeContents(size int) []byte {
+	buf := make([]byte, size)
+	for idx := range buf {
+		buf[idx] = byte(idx % 251)
+	}
+	return buf
+}
+
+func TestStorageDriver(t *testing.T) {
+	ctx := context.Background()
+	f, sd := setupStorageDriver(t)
+	account := models.ReducedAccount{Name: "test1", AuthTenantID: "tenant1"}
+	err := sd.CanSetupAccount(ctx, account)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// upload a blob in chunks of various sizes: smaller than the alignment,
+	// larger than the alignment, and larger than one piece
+	chunks := [][]byte{makeContents(100 << 10), makeContents(300 << 10), makeContents(resumableUploadPieceBytes + 5)}
+	var chunkNumber uint32
+	for _, chunk := range chunks {
+		chunkNumber++
+		chunkLength := uint64(len(chunk))
+		err := sd.AppendToBlob(ctx, account, "blob1", chunkNumber, &chunkLength, bytes.NewReader(chunk))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// chunks must be appended in order
+	err = sd.AppendToBlob(ctx, account, "blob1", 5, nil, strings.NewReader("!"))
+	expectError(t, err, "expected chunk #4, but got chunk #5")
+
+	// uploads in progress show up in the storage listing with their chunk count
+	blobs, _, err := sd.ListStorageContents(ctx, account)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectedBlobs := []keppel.StoredBlobInfo{{StorageID: "blob1", ChunkCount: 3}}
+	if !reflect.DeepEqual(blobs, expectedBlobs) {
+		t.Errorf("expected blobs %#v, but got %#v", expectedBlobs, blobs)
+	}
+
+	// the last chunk has an unknown length
+	chunks = append(chunks, []byte("!"))
+	err = sd.AppendToBlob(ctx, account, "blob1", 4, nil, strings.NewReader("!"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = sd.FinalizeBlob(ctx, account, "blob1", 4)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectedContents := bytes.Join(chunks, nil)
+
+	// read the blob in full and in part
+	reader, sizeBytes, err := sd.ReadBlob(ctx, account, "blob1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	reader.Close()
+	if !bytes.Equal(contents, expectedContents) || sizeBytes != uint64(len(expectedContents)) {
+		t.Errorf("expected blob with %d bytes, but got %d bytes (reported as %d bytes) with different contents", len(expectedContents), len(contents), sizeBytes)
+	}
+	reader, err = sd.ReadBlobRange(ctx, account, "blob1", 1000, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	contents, err = io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	reader.Close()
+	if !bytes.Equal(contents, expectedContents[1000:1010]) {
+		t.Errorf("expected range %v, but got %v", expectedContents[1000:1010], contents)
+	}
+	_, err = sd.URLForBlob(ctx, account, "blob1")
+	if !errors.Is(err, keppel.ErrCannotGenerateURL) {
+		t.Errorf("expected ErrCannotGenerateURL, but got %v", err)
+	}
+
+	// start another upload and abort it
+	chunkLength := uint64(5)
+	err = sd.AppendToBlob(ctx, account, "blob2", 1, &chunkLength, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = sd.AbortBlobUpload(ctx, account, "blob2", 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(f.persistedBytes()) != 0 {
+		t.Error("expected all upload sessions to be finished or cancelled")
+	}
+
+	// write manifests into nested repos
+	manifestDigest := digest.FromString("manifest")
+	for _, repoName := range []string{"foo", "foo/bar"} {
+		err = sd.WriteManifest(ctx, account, repoName, manifestDigest, []byte("manifest"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	manifest, err := sd.ReadManifest(ctx, account, "foo/bar", manifestDigest)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(manifest) != "manifest" {
+		t.Errorf("expected manifest contents %q, but got %q", "manifest", string(manifest))
+	}
+	_, err = sd.ReadManifest(ctx, account, "qux", manifestDigest)
+	expectError(t, err, fmt.Sprintf("while reading keppel/test1/qux/_manifests/%s in GCS: object not found", manifestDigest))
+
+	// the storage listing is spread over several pages, and only covers this account
+	err = sd.WriteManifest(ctx, models.ReducedAccount{Name: "test2"}, "foo", manifestDigest, []byte("other"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	blobs, manifests, err := sd.ListStorageContents(ctx, account)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectedBlobs = []keppel.StoredBlobInfo{{StorageID: "blob1"}}
+	if !reflect.DeepEqual(blobs, expectedBlobs) {
+		t.Errorf("expected blobs %#v, but got %#v", expectedBlobs, blobs)
+	}
+	expectedManifests := []keppel.StoredManifestInfo{{RepoName: "foo", Digest: manifestDigest}, {RepoName: "foo/bar", Digest: manifestDigest}}
+	if !reflect.DeepEqual(manifests, expectedManifests) {
+		t.Errorf("expected manifests %#v, but got %#v", expectedManifests, manifests)
+	}
+
+	// cleanup is refused while objects are left over
+	err = sd.CleanupAccount(ctx, account)
+	expectError(t, err, `found undeleted blob during CleanupAccount: storageID = "blob1"`)
+	err = sd.DeleteBlob(ctx, account, "blob1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, repoName := range []string{"foo", "foo/bar"} {
+		err = sd.DeleteManifest(ctx, account, repoName, manifestDigest)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	err = sd.CleanupAccount(ctx, account)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the access token was only fetched once
+	if f.tokenRequests != 1 {
+		t.Errorf("expected 1 token request, but got %d", f.tokenRequests)
+	}
+}
+
+func TestAppendToBlobWithWrongChunkLength(t *testing.T) {
+	ctx := context.Background()
+	f, sd := setupStorageDriver(t)
+	account := models.ReducedAccount{Name: "test1", AuthTenantID: "tenant1"}
+
+	// an overlong chunk is detected before any data beyond the chunk length is sent
+	chunk := makeContents(resumableUploadPieceBytes + 1)
+	chunkLength := uint64(resumableUploadPieceBytes)
+	err := sd.AppendToBlob(ctx, account, "blob1", 1, &chunkLength, bytes.NewReader(chunk))
+	expectError(t, err, fmt.Sprintf("expected %d bytes, but got %d bytes", chunkLength, chunkLength+1))
+	for _, persisted := range f.persistedBytes() {
+		if persisted > resumableUploadPieceBytes {
+			t.Errorf("expected at most %d bytes to be sent to GCS, but %d bytes were sent", resumableUploadPieceBytes, persisted)
+		}
+	}
+
+	// a chunk that is too short can only be detected at its end, after some of
+	// it was sent already; the upload cannot be continued then, which is detected
+	chunkLength = 5
+	err = sd.AppendToBlob(ctx, account, "blob2", 1, &chunkLength, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	chunk = makeContents(resumableUploadPieceBytes + 1)
+	chunkLength = uint64(len(chunk) + 1)
+	err = sd.AppendToBlob(ctx, account, "blob2", 2, &chunkLength, bytes.NewReader(chunk))
+	expectError(t, err, fmt.Sprintf("expected %d bytes, but got %d bytes", chunkLength, len(chunk)))
+	expectedError := "cannot continue resumable upload in GCS: expected 0 bytes to be persisted, but found 8388608 bytes (a previous chunk was only partially written)"
+	err = sd.AppendToBlob(ctx, account, "blob2", 2, nil, strings.NewReader("world"))
+	expectError(t, err, expectedError)
+	err = sd.FinalizeBlob(ctx, account, "blob2", 1)
+	expectError(t, err, expectedError)
+
+	// such an upload can still be aborted
+	err = sd.AbortBlobUpload(ctx, account, "blob2", 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	blobs, _, err := sd.ListStorageContents(ctx, account)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(blobs) != 0 {
+		t.Errorf("expected no blobs after abort, but got %#v", blobs)
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	ctx := context.Background()
+	f, sd := setupStorageDriver(t)
+	now := time.Unix(10000, 0)
+	sd.(*storageDriver).tokens.TimeNow = func() time.Time { return now }
+	account := models.ReducedAccount{Name: "test1", AuthTenantID: "tenant1"}
+	manifestDigest := digest.FromString("manifest")
+	writeManifest := func() error {
+		return sd.WriteManifest(ctx, account, "foo", manifestDigest, []byte("manifest"))
+	}
+	expectTokenRequests := func(expected int) {
+		t.Helper()
+		if f.tokenRequests != expected {
+			t.Errorf("expected %d token requests, but got %d", expected, f.tokenRequests)
+		}
+	}
+
+	// the token is reused until shortly before it expires
+	if err := writeManifest(); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectTokenRequests(1)
+	now = now.Add(time.Hour - tokenExpiryMargin - time.Second)
+	if err := writeManifest(); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectTokenRequests(1)
+	now = now.Add(2 * time.Second)
+	if err := writeManifest(); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectTokenRequests(2)
+
+	// a token that is rejected before its expiry is replaced, and the request
+	// (including its body) is repeated with the new token
+	f.validToken = ""
+	if err := writeManifest(); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectTokenRequests(3)
+	if string(f.objects["keppel/test1/foo/_manifests/"+manifestDigest.String()]) != "manifest" {
+		t.Error("expected manifest to be written with the new token")
+	}
+
+	// this is only tried once per request
+	f.validToken = ""
+	f.rejectNewTokens = true
+	expectError(t, writeManifest(), fmt.Sprintf("while writing keppel/test1/foo/_manifests/%s in GCS: unexpected status 401 Unauthorized: unauthorized", manifestDigest))
+	expectTokenRequests(4)
+}
+
+func TestResumableUploadInterruptions(t *testing.T) {
+	ctx := context.Background()
+	account := models.ReducedAccount{Name: "test1", AuthTenantID: "tenant1"}
+	chunk := makeContents(resumableUploadPieceBytes + 5)
+
+	// each fault is injected into the first piece of the chunk as well as into
+	// the final piece, and the upload continues with the bytes that were not persisted
+	for _, fault := range []fakeFault{faultDropConnection, faultDropResponse, faultServiceUnavailable, faultPartialPersist} {
+		f, sd := setupStorageDriver(t)
+		sd.(*storageDriver).retryDelay = 0
+		f.faults = []fakeFault{fault, fault}
+
+		chunkLength := uint64(len(chunk))
+		err := sd.AppendToBlob(ctx, account, "blob1", 1, &chunkLength, bytes.NewReader(chunk))
+		if err != nil {
+			t.Fatalf("with fault %d: %s", fault, err.Error())
+		}
+		err = sd.FinalizeBlob(ctx, account, "blob1", 1)
+		if err != nil {
+			t.Fatalf("with fault %d: %s", fault, err.Error())
+		}
+		if len(f.faults) != 0 {
+			t.Errorf("with fault %d: expected all faults to be injected, but %d are left", fault, len(f.faults))
+		}
+		if !bytes.Equal(f.objects["keppel/test1/_blobs/blob1"], chunk) {
+			t.Errorf("with fault %d: expected blob with %d bytes, but got %d bytes with different contents", fault, len(chunk), len(f.objects["keppel/test1/_blobs/blob1"]))
+		}
+	}
+
+	// persistent errors are reported after a few attempts, and the chunk can be
+	// sent again once GCS is healthy again
+	f, sd := setupStorageDriver(t)
+	sd.(*storageDriver).retryDelay = 0
+	f.faults = slices.Repeat([]fakeFault{faultServiceUnavailable}, resumableUploadMaxAttempts)
+	chunkLength := uint64(len(chunk))
+	err := sd.AppendToBlob(ctx, account, "blob1", 1, &chunkLength, bytes.NewReader(chunk))
+	expectError(t, err, "while sending to resumable upload in GCS: unexpected status 503 Service Unavailable: service unavailable")
+	err = sd.AppendToBlob(ctx, account, "blob1", 1, &chunkLength, bytes.NewReader(chunk))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = sd.FinalizeBlob(ctx, account, "blob1", 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(f.objects["keppel/test1/_blobs/blob1"], chunk) {
+		t.Errorf("expected blob with %d bytes, but got %d bytes with different contents", len(chunk), len(f.objects["keppel/test1/_blobs/blob1"]))
+	}
+}
+
+func expectError(t *testing.T, err error, expected string) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("expected error %q, but got no error", expected)
+	} else if err.Error() != expected {
+		t.Errorf("expected error %q, but got %q", expected, err.Error())
+	}
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// This file contains a minimal client for the metadata server that is
+// available on GCE and GKE. With Workload Identity on GKE, the metadata server
+// hands out access tokens for the Google service account that is bound to the
+// Kubernetes service account of the pod, so no credentials need to be
+// configured explicitly.
+// Reference: <https://cloud.google.com/kubernetes-engine/docs/concepts/workload-identity>
+
+const defaultMetadataHost = "metadata.google.internal"
+
+// Tokens are refreshed this long before they expire, to account for clock
+// skew and for requests that take a while to arrive at the storage API.
+const tokenExpiryMargin = 2 * time.Minute
+
+type tokenSource struct {
+	// e.g. "metadata.google.internal" or "127.0.0.1:8080" (same format as $GCE_METADATA_HOST)
+	MetadataHost   string
+	ServiceAccount string
+	Client         *http.Client
+	TimeNow        func() time.Time
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// Token returns an OAuth2 access token for the configured service account,
+// fetching a new one from the metadata server if necessary.
+func (ts *tokenSource) Token(ctx context.Context) (string, error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if ts.token != "" && ts.TimeNow().Before(ts.expiresAt.Add(-tokenExpiryMargin)) {
+		return ts.token, nil
+	}
+
+	tokenURL := fmt.Sprintf("http://%s/computeMetadata/v1/instance/service-accounts/%s/token", ts.MetadataHost, ts.ServiceAccount)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := ts.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot obtain access token from GCE metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("cannot obtain access token from GCE metadata server: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cannot obtain access token from GCE metadata server: expected 200 OK, but got %s: %s", resp.Status, string(respBytes))
+	}
+
+	var data struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = json.Unmarshal(respBytes, &data)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse access token from GCE metadata server: %w", err)
+	}
+	if data.AccessToken == "" {
+		return "", errors.New("cannot obtain access token from GCE metadata server: response did not contain a token")
+	}
+
+	ts.token = data.AccessToken
+	ts.expiresAt = ts.TimeNow().Add(time.Duration(data.ExpiresIn) * time.Second)
+	return ts.token, nil
+}
+
+// Invalidate discards the given token if it is still cached, so that the next
+// call to Token() fetches a new one. This is used when GCS rejects a token
+// before its announced expiry.
+func (ts *tokenSource) Invalidate(token string) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if ts.token == token {
+		ts.token = ""
+	}
+}
@@ -0,0 +1,356 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ldap contains the AuthDriver "ldap": Users are authenticated with
+// their credentials in an LDAP directory (e.g. OpenLDAP or Active Directory),
+// and their group memberships are mapped to permissions on Keppel tenants
+// through a policy file.
+package ldap
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/redis/go-redis/v9"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/osext"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+func init() {
+	keppel.AuthDriverRegistry.Add(func() keppel.AuthDriver { return &AuthDriver{} })
+	keppel.UserIdentityRegistry.Add(func() keppel.UserIdentity { return &userIdentity{} })
+}
+
+const driverName = "ldap"
+
+// These are where AuthenticateUserFromRequest() looks for credentials. The
+// Authorization header cannot be used for this since it is reserved for
+// tokens issued by Keppel itself.
+const (
+	userNameHeader = "X-LDAP-Username"
+	passwordHeader = "X-LDAP-Password"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// type Policy
+
+// Policy is the contents of the file at $KEPPEL_LDAP_POLICY_PATH. It
+// describes how LDAP group memberships are mapped to Keppel permissions.
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyRule grants permissions to all members of a group.
+type PolicyRule struct {
+	// The DN of the group, as it appears in the user's group attribute.
+	// This is compared case-insensitively.
+	Group string `json:"group"`
+	// Required unless the rule only grants CanAdministrateKeppel, which does
+	// not pertain to any tenant.
+	TenantID    string              `json:"tenant_id" yaml:"tenant_id"`
+	Permissions []keppel.Permission `json:"permissions"`
+}
+
+var knownPermissions = []keppel.Permission{
+	keppel.CanViewAccount,
+	keppel.CanPullFromAccount,
+	keppel.CanPushToAccount,
+	keppel.CanDeleteFromAccount,
+	keppel.CanChangeAccount,
+	keppel.CanViewQuotas,
+	keppel.CanChangeQuotas,
+	keppel.CanAdministrateKeppel,
+}
+
+func loadPolicy(path string) (Policy, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	err = dec.Decode(&p)
+	if err != nil {
+		return Policy{}, fmt.Errorf("while parsing %s: %w", path, err)
+	}
+
+	for idx, rule := range p.Rules {
+		if rule.Group == "" {
+			return Policy{}, fmt.Errorf("while parsing %s: rules[%d] does not have a group", path, idx)
+		}
+		if len(rule.Permissions) == 0 {
+			return Policy{}, fmt.Errorf("while parsing %s: rules[%d] does not grant any permissions", path, idx)
+		}
+		for _, perm := range rule.Permissions {
+			if !slices.Contains(knownPermissions, perm) {
+				return Policy{}, fmt.Errorf("while parsing %s: rules[%d] contains unknown permission %q", path, idx, perm)
+			}
+			if perm != keppel.CanAdministrateKeppel && rule.TenantID == "" {
+				return Policy{}, fmt.Errorf("while parsing %s: rules[%d] grants permission %q, but does not have a tenant_id", path, idx, perm)
+			}
+		}
+	}
+	return p, nil
+}
+
+// PermissionsForGroups computes the permissions of a user with the given
+// group memberships. The result maps tenant IDs to permissions.
+// CanAdministrateKeppel is stored under the empty tenant ID.
+func (p Policy) PermissionsForGroups(groups []string) map[string][]keppel.Permission {
+	result := make(map[string][]keppel.Permission)
+	for _, rule := range p.Rules {
+		isMember := slices.ContainsFunc(groups, func(group string) bool {
+			return strings.EqualFold(group, rule.Group)
+		})
+		if !isMember {
+			continue
+		}
+		for _, perm := range rule.Permissions {
+			tenantID := rule.TenantID
+			if perm == keppel.CanAdministrateKeppel {
+				tenantID = ""
+			}
+			if !slices.Contains(result[tenantID], perm) {
+				result[tenantID] = append(result[tenantID], perm)
+			}
+		}
+	}
+	return result
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// type AuthDriver
+
+// AuthDriver is the auth driver "ldap".
+type AuthDriver struct {
+	Server serverConfig
+	// Credentials of the service user that searches for user entries.
+	// If empty, the search is performed anonymously.
+	BindDN       string
+	BindPassword string
+	// Where and how to search for user entries.
+	UserBaseDN     string
+	UserAttribute  string
+	GroupAttribute string
+	Policy         Policy
+	cache          bindCache
+}
+
+// PluginTypeID implements the keppel.AuthDriver interface.
+func (d *AuthDriver) PluginTypeID() string {
+	return driverName
+}
+
+// Init implements the keppel.AuthDriver interface.
+func (d *AuthDriver) Init(ctx context.Context, rc *redis.Client) (err error) {
+	d.Server.URL, err = url.Parse(osext.MustGetenv("KEPPEL_LDAP_URL"))
+	if err != nil {
+		return fmt.Errorf("malformed KEPPEL_LDAP_URL: %w", err)
+	}
+	switch d.Server.URL.Scheme {
+	case "ldap":
+		d.Server.StartTLS = osext.GetenvBool("KEPPEL_LDAP_START_TLS")
+		// without TLS, user passwords would cross the network in plain text
+		if !d.Server.StartTLS && !osext.GetenvBool("KEPPEL_LDAP_INSECURE") {
+			return errors.New("refusing to send passwords over an unencrypted connection: use an ldaps:// URL, set KEPPEL_LDAP_START_TLS=true, or set KEPPEL_LDAP_INSECURE=true to allow this anyway")
+		}
+	case "ldaps":
+		if osext.GetenvBool("KEPPEL_LDAP_START_TLS") {
+			return errors.New("KEPPEL_LDAP_START_TLS cannot be used with an ldaps:// URL")
+		}
+	default:
+		return fmt.Errorf("malformed KEPPEL_LDAP_URL: expected scheme \"ldap\" or \"ldaps\", but got %q", d.Server.URL.Scheme)
+	}
+	d.Server.TLSConfig = &tls.Config{
+		ServerName: d.Server.URL.Hostname(),
+		MinVersion: tls.VersionTLS12,
+	}
+	if path := os.Getenv("KEPPEL_LDAP_CA_CERT_PATH"); path != "" {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		d.Server.TLSConfig.RootCAs = x509.NewCertPool()
+		if !d.Server.TLSConfig.RootCAs.AppendCertsFromPEM(buf) {
+			return fmt.Errorf("malformed KEPPEL_LDAP_CA_CERT_PATH: no certificates found in %s", path)
+		}
+	}
+
+	d.BindDN = os.Getenv("KEPPEL_LDAP_BIND_DN")
+	if d.BindDN != "" {
+		d.BindPassword = osext.MustGetenv("KEPPEL_LDAP_BIND_PASSWORD")
+	}
+	d.UserBaseDN = osext.MustGetenv("KEPPEL_LDAP_USER_BASE_DN")
+	d.UserAttribute = osext.GetenvOrDefault("KEPPEL_LDAP_USER_ATTRIBUTE", "uid")
+	d.GroupAttribute = osext.GetenvOrDefault("KEPPEL_LDAP_GROUP_ATTRIBUTE", "memberOf")
+	d.Policy, err = loadPolicy(osext.MustGetenv("KEPPEL_LDAP_POLICY_PATH"))
+	if err != nil {
+		return err
+	}
+
+	cacheTTL := 5 * time.Minute
+	if val := os.Getenv("KEPPEL_LDAP_CACHE_TTL"); val != "" {
+		cacheTTL, err = time.ParseDuration(val)
+		if err != nil || cacheTTL < 0 {
+			return fmt.Errorf("malformed KEPPEL_LDAP_CACHE_TTL: expected a non-negative duration, but got %q", val)
+		}
+	}
+	d.cache, err = newBindCache(rc, cacheTTL, os.Getenv("KEPPEL_LDAP_CACHE_SECRET"))
+	return err
+}
+
+// AuthenticateUser implements the keppel.AuthDriver interface.
+func (d *AuthDriver) AuthenticateUser(ctx context.Context, userName, password string) (keppel.UserIdentity, *keppel.RegistryV2Error) {
+	// an empty password would result in an unauthenticated bind, which
+	// most servers accept without checking anything
+	if userName == "" || password == "" {
+		return nil, keppel.ErrUnauthorized.With("wrong credentials")
+	}
+
+	if payload := d.cache.Load(ctx, userName, password); payload != nil {
+		uid := &userIdentity{}
+		err := uid.DeserializeFromJSON(payload, d)
+		if err == nil {
+			return uid, nil
+		}
+		logg.Error("cannot deserialize cached LDAP bind result: %s", err.Error())
+	}
+
+	uid, err := d.authenticate(userName, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, keppel.ErrUnauthorized.With("wrong credentials")
+		}
+		logg.Error("LDAP authentication of user %q failed: %s", userName, err.Error())
+		return nil, keppel.ErrUnavailable.With("cannot authenticate with LDAP server")
+	}
+	if uid == nil {
+		return nil, keppel.ErrUnauthorized.With("wrong credentials")
+	}
+
+	payload, err := uid.SerializeToJSON()
+	if err == nil {
+		d.cache.Store(ctx, userName, password, payload)
+	}
+	return uid, nil
+}
+
+// authenticate returns (nil, nil) if the user does not exist.
+func (d *AuthDriver) authenticate(userName, password string) (*userIdentity, error) {
+	c, err := dial(d.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if d.BindDN != "" {
+		err = c.Bind(d.BindDN, d.BindPassword)
+		if err != nil {
+			return nil, fmt.Errorf("cannot bind as %q: %w", d.BindDN, err)
+		}
+	}
+	entries, err := searchUsers(c, d.UserBaseDN, d.UserAttribute, userName, []string{d.GroupAttribute})
+	if err != nil {
+		return nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return nil, nil
+	case 1:
+		// continue below
+	default:
+		return nil, fmt.Errorf("found multiple entries with %s=%s", d.UserAttribute, userName)
+	}
+
+	err = c.Bind(entries[0].DN, password)
+	if err != nil {
+		return nil, err
+	}
+	groups := entries[0].GetEqualFoldAttributeValues(d.GroupAttribute)
+	return &userIdentity{
+		Name:        userName,
+		Permissions: d.Policy.PermissionsForGroups(groups),
+	}, nil
+}
+
+// AuthenticateUserFromRequest implements the keppel.AuthDriver interface.
+func (d *AuthDriver) AuthenticateUserFromRequest(r *http.Request) (keppel.UserIdentity, *keppel.RegistryV2Error) {
+	userName := r.Header.Get(userNameHeader)
+	password := r.Header.Get(passwordHeader)
+	if userName == "" && password == "" {
+		// fallback to anonymous auth
+		return nil, nil
+	}
+	return d.AuthenticateUser(r.Context(), userName, password)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// type userIdentity
+
+type userIdentity struct {
+	Name string `json:"name"`
+	// key = tenant ID (or empty string for CanAdministrateKeppel)
+	Permissions map[string][]keppel.Permission `json:"perms,omitempty"`
+}
+
+// PluginTypeID implements the keppel.UserIdentity interface.
+func (uid *userIdentity) PluginTypeID() string {
+	return driverName
+}
+
+// HasPermission implements the keppel.UserIdentity interface.
+func (uid *userIdentity) HasPermission(perm keppel.Permission, tenantID string) bool {
+	if tenantID == "" && perm != keppel.CanAdministrateKeppel {
+		return false
+	}
+	return slices.Contains(uid.Permissions[tenantID], perm)
+}
+
+// UserType implements the keppel.UserIdentity interface.
+func (uid *userIdentity) UserType() keppel.UserType {
+	return keppel.RegularUser
+}
+
+// UserName implements the keppel.UserIdentity interface.
+func (uid *userIdentity) UserName() string {
+	return uid.Name
+}
+
+// UserInfo implements the keppel.UserIdentity interface.
+func (uid *userIdentity) UserInfo() audittools.UserInfo {
+	return nil
+}
+
+// SerializeToJSON implements the keppel.UserIdentity interface.
+func (uid *userIdentity) SerializeToJSON() (payload []byte, err error) {
+	return json.Marshal(uid)
+}
+
+// DeserializeFromJSON implements the keppel.UserIdentity interface.
+func (uid *userIdentity) DeserializeFromJSON(in []byte, ad keppel.AuthDriver) error {
+	if _, ok := ad.(*AuthDriver); !ok {
+		return keppel.ErrAuthDriverMismatch
+	}
+	err := json.Unmarshal(in, uid)
+	if err == nil && uid.Name == "" {
+		err = errors.New("user name is missing")
+	}
+	return err
+}
@@ -0,0 +1,292 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package ldap
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+type fakeDirectoryEntry struct {
+	DN       string
+	UID      string
+	Password string
+	MemberOf []string
+}
+
+var fakeDirectory = []fakeDirectoryEntry{
+	{
+		DN:       "cn=keppel,ou=services,dc=example,dc=com",
+		Password: "service-secret",
+	},
+	{
+		DN:       "uid=alice,ou=users,dc=example,dc=com",
+		UID:      "alice",
+		Password: "alice-secret",
+		MemberOf: []string{"CN=Team-A,OU=Groups,DC=example,DC=com", "cn=developers,ou=groups,dc=example,dc=com"},
+	},
+	{
+		DN:       "uid=bob,ou=users,dc=example,dc=com",
+		UID:      "bob",
+		Password: "bob-secret",
+		MemberOf: []string{"cn=keppel-admins,ou=groups,dc=example,dc=com"},
+	},
+}
+
+// fakeLDAPServer implements just enough of LDAP to serve the fakeDirectory.
+type fakeLDAPServer struct {
+	listener  net.Listener
+	bindCount atomic.Int64
+}
+
+func newFakeLDAPServer(t *testing.T) *fakeLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s := &fakeLDAPServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(t, conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *fakeLDAPServer) Close() {
+	s.listener.Close()
+}
+
+func (s *fakeLDAPServer) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	isBound := false
+
+	for {
+		msg, err := ber.ReadPacket(conn)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			t.Errorf("fake LDAP server could not read request: %s", err.Error())
+			return
+		}
+		messageID := msg.Children[0].Value
+		respond := func(ops ...*ber.Packet) {
+			for _, op := range ops {
+				envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+				envelope.AppendChild(op)
+				_, err := conn.Write(envelope.Bytes())
+				if err != nil {
+					t.Errorf("fake LDAP server could not write response: %s", err.Error())
+				}
+			}
+		}
+		result := func(tag ber.Tag, resultCode int64) *ber.Packet {
+			p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+			p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, resultCode, ""))
+			p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "")) // matchedDN
+			p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "")) // diagnosticMessage
+			return p
+		}
+
+		op := msg.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationUnbindRequest:
+			return
+
+		case ldap.ApplicationBindRequest:
+			s.bindCount.Add(1)
+			dn := op.Children[1].Data.String()
+			password := op.Children[2].Data.String()
+			resultCode := int64(ldap.LDAPResultInvalidCredentials)
+			for _, entry := range fakeDirectory {
+				if entry.DN == dn && entry.Password == password {
+					resultCode = ldap.LDAPResultSuccess
+				}
+			}
+			isBound = resultCode == ldap.LDAPResultSuccess
+			respond(result(ldap.ApplicationBindResponse, resultCode))
+
+		case ldap.ApplicationSearchRequest:
+			if !isBound {
+				respond(result(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				continue
+			}
+			filter := op.Children[6]
+			if filter.Tag != ldap.FilterEqualityMatch || filter.Children[0].Data.String() != "uid" {
+				t.Errorf("fake LDAP server received unexpected filter: %s", ber.DecodeString(filter.Bytes()))
+				respond(result(ldap.ApplicationSearchResultDone, ldap.LDAPResultUnwillingToPerform))
+				continue
+			}
+			var ops []*ber.Packet
+			for _, entry := range fakeDirectory {
+				if entry.UID == "" || entry.UID != filter.Children[1].Data.String() {
+					continue
+				}
+				values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+				for _, group := range entry.MemberOf {
+					values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, group, ""))
+				}
+				attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "memberOf", ""))
+				attr.AppendChild(values)
+				attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				attrs.AppendChild(attr)
+				op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, ""))
+				op.AppendChild(attrs)
+				ops = append(ops, op)
+			}
+			ops = append(ops, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+			respond(ops...)
+
+		default:
+			t.Errorf("fake LDAP server received unexpected operation with tag %d", op.Tag)
+			return
+		}
+	}
+}
+
+func TestAuthDriver(t *testing.T) {
+	srv := newFakeLDAPServer(t)
+	defer srv.Close()
+
+	t.Setenv("KEPPEL_LDAP_URL", srv.URL())
+	t.Setenv("KEPPEL_LDAP_INSECURE", "true") // the fake server does not support TLS
+	t.Setenv("KEPPEL_LDAP_BIND_DN", "cn=keppel,ou=services,dc=example,dc=com")
+	t.Setenv("KEPPEL_LDAP_BIND_PASSWORD", "service-secret")
+	t.Setenv("KEPPEL_LDAP_USER_BASE_DN", "ou=users,dc=example,dc=com")
+	t.Setenv("KEPPEL_LDAP_POLICY_PATH", "./fixtures/policy.yaml")
+	ad, err := keppel.NewAuthDriver(t.Context(), driverName, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// happy case: permissions are derived from group memberships (group DNs are matched case-insensitively)
+	uid, rerr := ad.AuthenticateUser(t.Context(), "alice", "alice-secret")
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	assert.DeepEqual(t, "UserName", uid.UserName(), "alice")
+	expectPermissions(t, uid, map[string]bool{
+		"view:tenant-a":   true,
+		"pull:tenant-a":   true,
+		"push:tenant-a":   true,
+		"delete:tenant-a": true,
+		"view:tenant-b":   true,
+		"pull:tenant-b":   true,
+		"push:tenant-b":   false,
+		"view:tenant-c":   false,
+		"admin:":          false,
+	})
+	assert.DeepEqual(t, "bind count", srv.bindCount.Load(), int64(2))
+
+	// repeated authentication is served from the cache
+	uid2, rerr := ad.AuthenticateUser(t.Context(), "alice", "alice-secret")
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	assert.DeepEqual(t, "cached identity", uid2, uid)
+	assert.DeepEqual(t, "bind count", srv.bindCount.Load(), int64(2))
+
+	// credentials can also be given in the request headers
+	req := httptest.NewRequest(http.MethodGet, "/keppel/v1/accounts", http.NoBody)
+	req.Header.Set(userNameHeader, "bob")
+	req.Header.Set(passwordHeader, "bob-secret")
+	uid, rerr = ad.AuthenticateUserFromRequest(req)
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	expectPermissions(t, uid, map[string]bool{
+		"admin:":        true,
+		"view:tenant-a": false,
+	})
+
+	// no credentials -> anonymous
+	req = httptest.NewRequest(http.MethodGet, "/keppel/v1/accounts", http.NoBody)
+	uid, rerr = ad.AuthenticateUserFromRequest(req)
+	if uid != nil || rerr != nil {
+		t.Errorf("expected (nil, nil) for request without credentials, but got (%#v, %#v)", uid, rerr)
+	}
+
+	// failure cases
+	expectFailure := func(userName, password string) {
+		t.Helper()
+		_, rerr := ad.AuthenticateUser(t.Context(), userName, password)
+		if rerr == nil {
+			t.Errorf("expected authentication of %q to fail, but it succeeded", userName)
+		} else if !strings.Contains(rerr.Error(), "wrong credentials") {
+			t.Errorf("expected authentication of %q to fail with \"wrong credentials\", but got: %s", userName, rerr.Error())
+		}
+	}
+	expectFailure("alice", "bob-secret")
+	expectFailure("alice", "") // must not turn into an unauthenticated bind
+	expectFailure("carol", "carol-secret")
+	expectFailure("", "alice-secret")
+}
+
+func TestInsecureConnectionRequiresOptIn(t *testing.T) {
+	t.Setenv("KEPPEL_LDAP_URL", "ldap://ldap.example.com")
+	t.Setenv("KEPPEL_LDAP_USER_BASE_DN", "ou=users,dc=example,dc=com")
+	t.Setenv("KEPPEL_LDAP_POLICY_PATH", "./fixtures/policy.yaml")
+	_, err := keppel.NewAuthDriver(t.Context(), driverName, nil)
+	if err == nil || !strings.Contains(err.Error(), "KEPPEL_LDAP_INSECURE") {
+		t.Errorf("expected error about KEPPEL_LDAP_INSECURE, but got: %v", err)
+	}
+
+	// StartTLS or an explicit opt-in are both fine
+	for _, key := range []string{"KEPPEL_LDAP_START_TLS", "KEPPEL_LDAP_INSECURE"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, "true")
+			_, err := keppel.NewAuthDriver(t.Context(), driverName, nil)
+			if err != nil {
+				t.Error(err.Error())
+			}
+		})
+	}
+}
+
+func TestCacheKeyDependsOnSecret(t *testing.T) {
+	h1 := cacheKeyHasher{[]byte("secret1")}
+	h2 := cacheKeyHasher{[]byte("secret2")}
+	key := h1.CacheKey("alice", "alice-secret")
+	assert.DeepEqual(t, "cache key", h1.CacheKey("alice", "alice-secret"), key)
+	for _, other := range []string{h1.CacheKey("alice", "bob-secret"), h1.CacheKey("bob", "alice-secret"), h2.CacheKey("alice", "alice-secret")} {
+		if other == key {
+			t.Errorf("expected cache keys to differ, but got %q twice", key)
+		}
+	}
+}
+
+func expectPermissions(t *testing.T, uid keppel.UserIdentity, expected map[string]bool) {
+	t.Helper()
+	for key, expectedValue := range expected {
+		perm, tenantID, _ := strings.Cut(key, ":")
+		actualValue := uid.HasPermission(keppel.Permission(perm), tenantID)
+		if actualValue != expectedValue {
+			t.Errorf("expected HasPermission(%q, %q) = %t, but got %t", perm, tenantID, expectedValue, actualValue)
+		}
+	}
+}
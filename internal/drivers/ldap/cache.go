@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package ldap
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sapcc/go-bits/logg"
+)
+
+// bindCache remembers the outcome of successful authentications for a short
+// time, so that `docker pull` and `docker push` (which authenticate many
+// times in quick succession) do not hammer the LDAP server.
+type bindCache interface {
+	Store(ctx context.Context, userName, password string, payload []byte)
+	Load(ctx context.Context, userName, password string) []byte
+}
+
+func newBindCache(rc *redis.Client, ttl time.Duration, secret string) (bindCache, error) {
+	if ttl <= 0 {
+		return noopCache{}, nil
+	}
+
+	// If no secret is configured, use a random one. Then entries stored in
+	// Redis can only be used by the same process, but that is still better
+	// than no caching at all.
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rc != nil {
+		return redisCache{rc, ttl, cacheKeyHasher{key}}, nil
+	}
+	return &memoryCache{ttl: ttl, hasher: cacheKeyHasher{key}, entries: make(map[string]memoryCacheEntry)}, nil
+}
+
+// cacheKeyHasher computes cache keys. The password is part of the cache key,
+// so a cache hit is only possible if the user presents the same password that
+// was accepted by the LDAP server. Only an HMAC of it is ever stored, so
+// that the password cannot be brute-forced from the cache contents without
+// also knowing the secret.
+type cacheKeyHasher struct {
+	secret []byte
+}
+
+func (h cacheKeyHasher) CacheKey(userName, password string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(userName + "\x00" + password))
+	return "ldap-" + hex.EncodeToString(mac.Sum(nil))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// type noopCache
+
+type noopCache struct{}
+
+func (noopCache) Store(ctx context.Context, userName, password string, payload []byte) {}
+
+func (noopCache) Load(ctx context.Context, userName, password string) []byte { return nil }
+
+////////////////////////////////////////////////////////////////////////////////
+// type redisCache
+
+type redisCache struct {
+	rc     *redis.Client
+	ttl    time.Duration
+	hasher cacheKeyHasher
+}
+
+func (c redisCache) Store(ctx context.Context, userName, password string, payload []byte) {
+	err := c.rc.Set(ctx, c.hasher.CacheKey(userName, password), payload, c.ttl).Err()
+	if err != nil {
+		logg.Error("cannot cache LDAP bind result in Redis: %s", err.Error())
+	}
+}
+
+func (c redisCache) Load(ctx context.Context, userName, password string) []byte {
+	payload, err := c.rc.Get(ctx, c.hasher.CacheKey(userName, password)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		logg.Error("cannot retrieve LDAP bind result from Redis: %s", err.Error())
+		return nil
+	}
+	return payload
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// type memoryCache
+
+// memoryCache is used when Redis is not configured. Since it is not shared
+// between replicas, it is less effective, but still better than nothing.
+type memoryCache struct {
+	ttl     time.Duration
+	hasher  cacheKeyHasher
+	mutex   sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	Payload   []byte
+	ExpiresAt time.Time
+}
+
+func (c *memoryCache) Store(ctx context.Context, userName, password string, payload []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// clean up expired entries to keep the cache from growing indefinitely
+	now := time.Now()
+	for key, entry := range c.entries {
+		if entry.ExpiresAt.Before(now) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[c.hasher.CacheKey(userName, password)] = memoryCacheEntry{payload, now.Add(c.ttl)}
+}
+
+func (c *memoryCache) Load(ctx context.Context, userName, password string) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, exists := c.entries[c.hasher.CacheKey(userName, password)]
+	if !exists || entry.ExpiresAt.Before(time.Now()) {
+		return nil
+	}
+	return entry.Payload
+}
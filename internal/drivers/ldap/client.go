@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package ldap
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Timeout for establishing a connection, and for each roundtrip on it.
+const connectionTimeout = 10 * time.Second
+
+// serverConfig describes how to connect to the LDAP server.
+type serverConfig struct {
+	URL       *url.URL // with scheme "ldap" or "ldaps"
+	StartTLS  bool     // only for scheme "ldap"
+	TLSConfig *tls.Config
+}
+
+func dial(cfg serverConfig) (*ldap.Conn, error) {
+	c, err := ldap.DialURL(cfg.URL.String(),
+		ldap.DialWithDialer(&net.Dialer{Timeout: connectionTimeout}),
+		ldap.DialWithTLSConfig(cfg.TLSConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to LDAP server: %w", err)
+	}
+	c.SetTimeout(connectionTimeout)
+
+	if cfg.URL.Scheme == "ldap" && cfg.StartTLS {
+		err = c.StartTLS(cfg.TLSConfig)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("StartTLS with LDAP server failed: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// searchUsers finds all entries below baseDN where the given attribute has
+// the given value, and returns the requested attributes for them.
+func searchUsers(c *ldap.Conn, baseDN, attribute, value string, requestedAttributes []string) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, // sizeLimit: we only ever expect one result, so two is enough to detect ambiguity
+		int(connectionTimeout/time.Second), false,
+		fmt.Sprintf("(%s=%s)", ldap.EscapeFilter(attribute), ldap.EscapeFilter(value)),
+		requestedAttributes, nil,
+	)
+	result, err := c.Search(req)
+	// "sizeLimitExceeded" is fine since the caller will report ambiguity anyway
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	return result.Entries, nil
+}
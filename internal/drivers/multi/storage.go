@@ -0,0 +1,315 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package multi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/osext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// storageDriver distributes blobs across several storage drivers according to
+// the storage placement rules of each account (see keppel.StoragePlacementRule).
+// Manifests are always stored in the first backend, which is also the default
+// backend for blobs.
+type storageDriver struct {
+	Names   []string
+	Drivers map[string]keppel.StorageDriver
+}
+
+// Backend names end up in storage IDs (see keppel.SplitStorageID) and in
+// environment variable names, so they are restricted to a safe character set.
+var backendNameRx = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func init() {
+	keppel.StorageDriverRegistry.Add(func() keppel.StorageDriver { return &storageDriver{} })
+}
+
+// PluginTypeID implements the keppel.StorageDriver interface.
+func (sd *storageDriver) PluginTypeID() string { return "multi" }
+
+// Init implements the keppel.StorageDriver interface.
+func (sd *storageDriver) Init(ad keppel.AuthDriver, cfg keppel.Configuration) error {
+	sd.Drivers = make(map[string]keppel.StorageDriver)
+	for _, field := range strings.Split(osext.MustGetenv("KEPPEL_STORAGE_MULTI_DRIVERS"), ",") {
+		// each entry is either "<name>=<driver>" or just "<driver>", in which case
+		// the backend is named after the driver
+		backendName, driverName, hasName := strings.Cut(strings.TrimSpace(field), "=")
+		if !hasName {
+			driverName = backendName
+		}
+		backendName = strings.TrimSpace(backendName)
+		driverName = strings.TrimSpace(driverName)
+		if driverName == "multi" {
+			// prevent infinite loops
+			return errors.New(`cannot nest "multi" storage driver within itself`)
+		}
+		if !backendNameRx.MatchString(backendName) {
+			return fmt.Errorf("invalid storage backend name %q in KEPPEL_STORAGE_MULTI_DRIVERS (must match /%s/)", backendName, backendNameRx.String())
+		}
+		if _, exists := sd.Drivers[backendName]; exists {
+			return fmt.Errorf("storage backend %q is listed multiple times in KEPPEL_STORAGE_MULTI_DRIVERS", backendName)
+		}
+
+		var subdriver keppel.StorageDriver
+		err := withEnvOverrides(backendEnvPrefix(backendName), func() (err error) {
+			subdriver, err = keppel.NewStorageDriver(driverName, ad, cfg)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("while initializing storage backend %q: %w", backendName, err)
+		}
+		sd.Names = append(sd.Names, backendName)
+		sd.Drivers[backendName] = subdriver
+	}
+	return nil
+}
+
+// Returns the prefix of environment variables that override the configuration
+// of the given backend, e.g. "KEPPEL_STORAGE_MULTI_ARCHIVE_" for backend
+// "archive".
+func backendEnvPrefix(backendName string) string {
+	return "KEPPEL_STORAGE_MULTI_" + strings.ToUpper(backendName) + "_"
+}
+
+// Storage drivers take their configuration from environment variables. To
+// allow the same driver to be used for several backends with different
+// configurations, each variable "<prefix><NAME>" is visible as "<NAME>" while
+// the backend's driver is initialized. This is safe because drivers are
+// initialized once during startup, before any other goroutines look at the
+// environment.
+func withEnvOverrides(prefix string, action func() error) error {
+	type savedVar struct {
+		Value  string
+		Exists bool
+	}
+	saved := make(map[string]savedVar)
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		name, ok := strings.CutPrefix(key, prefix)
+		if !ok || name == "" {
+			continue
+		}
+		oldValue, exists := os.LookupEnv(name)
+		saved[name] = savedVar{oldValue, exists}
+		err := os.Setenv(name, value)
+		if err != nil {
+			return err
+		}
+	}
+	defer func() {
+		for name, v := range saved {
+			var err error
+			if v.Exists {
+				err = os.Setenv(name, v.Value)
+			} else {
+				err = os.Unsetenv(name)
+			}
+			if err != nil {
+				logg.Error("cannot restore environment variable %s: %s", name, err.Error())
+			}
+		}
+	}()
+	return action()
+}
+
+// BackendNames implements the keppel.MultiBackendStorageDriver interface.
+func (sd *storageDriver) BackendNames() []string {
+	return sd.Names
+}
+
+// ChunkSizeLimits implements the keppel.StorageDriverWithChunkSizeLimits interface.
+// Since the backend for a chunked upload is only chosen when the first chunk
+// arrives, the limits of all backends need to be satisfied.
+func (sd *storageDriver) ChunkSizeLimits() keppel.ChunkSizeLimits {
+	var result keppel.ChunkSizeLimits
+	for _, name := range sd.Names {
+		limits := keppel.GetChunkSizeLimits(sd.Drivers[name])
+		result.MinBytes = max(result.MinBytes, limits.MinBytes)
+		if limits.MaxBytes > 0 && (result.MaxBytes == 0 || limits.MaxBytes < result.MaxBytes) {
+			result.MaxBytes = limits.MaxBytes
+		}
+	}
+	return result
+}
+
+// ListOrphanedSegments implements the keppel.StorageDriverWithSegments interface.
+func (sd *storageDriver) ListOrphanedSegments(ctx context.Context, account models.ReducedAccount) ([]keppel.StoredSegmentInfo, error) {
+	var result []keppel.StoredSegmentInfo
+	for idx, name := range sd.Names {
+		segments, err := keppel.ListOrphanedSegments(ctx, sd.Drivers[name], account)
+		if err != nil {
+			return nil, fmt.Errorf("while listing orphaned segments of storage backend %q: %w", name, err)
+		}
+		// same storage ID prefixing as in ListStorageContents()
+		for _, segment := range segments {
+			if idx > 0 {
+				segment.StorageID = name + ":" + segment.StorageID
+			}
+			result = append(result, segment)
+		}
+	}
+	return result, nil
+}
+
+// DeleteSegment implements the keppel.StorageDriverWithSegments interface.
+func (sd *storageDriver) DeleteSegment(ctx context.Context, account models.ReducedAccount, segment keppel.StoredSegmentInfo) error {
+	driver, id, err := sd.driverForBlob(segment.StorageID)
+	if err != nil {
+		return err
+	}
+	segment.StorageID = id
+	return keppel.DeleteSegment(ctx, driver, account, segment)
+}
+
+func (sd *storageDriver) defaultDriver() keppel.StorageDriver {
+	return sd.Drivers[sd.Names[0]]
+}
+
+// Returns the driver that holds the blob with the given storage ID, as well
+// as the storage ID within that driver.
+func (sd *storageDriver) driverForBlob(storageID string) (keppel.StorageDriver, string, error) {
+	backend, id := keppel.SplitStorageID(storageID)
+	if backend == "" {
+		return sd.defaultDriver(), id, nil
+	}
+	driver, exists := sd.Drivers[backend]
+	if !exists {
+		return nil, "", fmt.Errorf("blob with storage ID %q is stored in unknown backend %q", storageID, backend)
+	}
+	return driver, id, nil
+}
+
+// AppendToBlob implements the keppel.StorageDriver interface.
+func (sd *storageDriver) AppendToBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkNumber uint32, chunkLength *uint64, chunk io.Reader) error {
+	driver, id, err := sd.driverForBlob(storageID)
+	if err != nil {
+		return err
+	}
+	return driver.AppendToBlob(ctx, account, id, chunkNumber, chunkLength, chunk)
+}
+
+// FinalizeBlob implements the keppel.StorageDriver interface.
+func (sd *storageDriver) FinalizeBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error {
+	driver, id, err := sd.driverForBlob(storageID)
+	if err != nil {
+		return err
+	}
+	return driver.FinalizeBlob(ctx, account, id, chunkCount)
+}
+
+// AbortBlobUpload implements the keppel.StorageDriver interface.
+func (sd *storageDriver) AbortBlobUpload(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error {
+	driver, id, err := sd.driverForBlob(storageID)
+	if err != nil {
+		return err
+	}
+	return driver.AbortBlobUpload(ctx, account, id, chunkCount)
+}
+
+// ReadBlob implements the keppel.StorageDriver interface.
+func (sd *storageDriver) ReadBlob(ctx context.Context, account models.ReducedAccount, storageID string) (io.ReadCloser, uint64, error) {
+	driver, id, err := sd.driverForBlob(storageID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return driver.ReadBlob(ctx, account, id)
+}
+
+// ReadBlobRange implements the keppel.StorageDriver interface.
+func (sd *storageDriver) ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (io.ReadCloser, error) {
+	driver, id, err := sd.driverForBlob(storageID)
+	if err != nil {
+		return nil, err
+	}
+	return driver.ReadBlobRange(ctx, account, id, offset, length)
+}
+
+// URLForBlob implements the keppel.StorageDriver interface.
+func (sd *storageDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
+	driver, id, err := sd.driverForBlob(storageID)
+	if err != nil {
+		return "", err
+	}
+	return driver.URLForBlob(ctx, account, id)
+}
+
+// DeleteBlob implements the keppel.StorageDriver interface.
+func (sd *storageDriver) DeleteBlob(ctx context.Context, account models.ReducedAccount, storageID string) error {
+	driver, id, err := sd.driverForBlob(storageID)
+	if err != nil {
+		return err
+	}
+	return driver.DeleteBlob(ctx, account, id)
+}
+
+// ReadManifest implements the keppel.StorageDriver interface.
+func (sd *storageDriver) ReadManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) ([]byte, error) {
+	return sd.defaultDriver().ReadManifest(ctx, account, repoName, manifestDigest)
+}
+
+// WriteManifest implements the keppel.StorageDriver interface.
+func (sd *storageDriver) WriteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest, contents []byte) error {
+	return sd.defaultDriver().WriteManifest(ctx, account, repoName, manifestDigest, contents)
+}
+
+// DeleteManifest implements the keppel.StorageDriver interface.
+func (sd *storageDriver) DeleteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) error {
+	return sd.defaultDriver().DeleteManifest(ctx, account, repoName, manifestDigest)
+}
+
+// ListStorageContents implements the keppel.StorageDriver interface.
+func (sd *storageDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount) ([]keppel.StoredBlobInfo, []keppel.StoredManifestInfo, error) {
+	blobs, manifests, err := sd.defaultDriver().ListStorageContents(ctx, account)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the storage IDs from other backends need to be prefixed in the same way as in the DB,
+	// otherwise the storage sweep would consider all those blobs to be unknown
+	for _, name := range sd.Names[1:] {
+		otherBlobs, _, err := sd.Drivers[name].ListStorageContents(ctx, account)
+		if err != nil {
+			return nil, nil, fmt.Errorf("while listing contents of storage backend %q: %w", name, err)
+		}
+		for _, blob := range otherBlobs {
+			blob.StorageID = name + ":" + blob.StorageID
+			blobs = append(blobs, blob)
+		}
+	}
+	return blobs, manifests, nil
+}
+
+// CanSetupAccount implements the keppel.StorageDriver interface.
+func (sd *storageDriver) CanSetupAccount(ctx context.Context, account models.ReducedAccount) error {
+	for _, name := range sd.Names {
+		err := sd.Drivers[name].CanSetupAccount(ctx, account)
+		if err != nil {
+			return fmt.Errorf("in storage backend %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// CleanupAccount implements the keppel.StorageDriver interface.
+func (sd *storageDriver) CleanupAccount(ctx context.Context, account models.ReducedAccount) error {
+	for _, name := range sd.Names {
+		err := sd.Drivers[name].CleanupAccount(ctx, account)
+		if err != nil {
+			return fmt.Errorf("in storage backend %q: %w", name, err)
+		}
+	}
+	return nil
+}
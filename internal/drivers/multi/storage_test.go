@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package multi
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+
+	_ "github.com/sapcc/keppel/internal/drivers/filesystem"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestStorageDriverInit(t *testing.T) {
+	testCases := map[string]string{
+		"multi":                            `cannot nest "multi" storage driver within itself`,
+		"hot=filesystem,hot=filesystem":    `storage backend "hot" is listed multiple times in KEPPEL_STORAGE_MULTI_DRIVERS`,
+		"filesystem,filesystem":            `storage backend "filesystem" is listed multiple times in KEPPEL_STORAGE_MULTI_DRIVERS`,
+		"Hot=filesystem":                   `invalid storage backend name "Hot" in KEPPEL_STORAGE_MULTI_DRIVERS (must match /^[a-z][a-z0-9_]*$/)`,
+		"hot=filesystem,cold:1=filesystem": `invalid storage backend name "cold:1" in KEPPEL_STORAGE_MULTI_DRIVERS (must match /^[a-z][a-z0-9_]*$/)`,
+		"hot=unknown":                      `while initializing storage backend "hot": no such storage driver: unknown`,
+	}
+	t.Setenv("KEPPEL_FILESYSTEM_PATH", t.TempDir())
+	for input, expected := range testCases {
+		t.Setenv("KEPPEL_STORAGE_MULTI_DRIVERS", input)
+		err := (&storageDriver{}).Init(nil, keppel.Configuration{})
+		expectError(t, err, expected)
+	}
+}
+
+func TestStorageDriver(t *testing.T) {
+	ctx := context.Background()
+	hotPath := t.TempDir()
+	coldPath := t.TempDir()
+	defaultPath := t.TempDir()
+
+	// the same driver is used for two backends, with different configurations
+	t.Setenv("KEPPEL_STORAGE_MULTI_DRIVERS", "hot=filesystem, cold=filesystem")
+	t.Setenv("KEPPEL_FILESYSTEM_PATH", defaultPath)
+	t.Setenv("KEPPEL_STORAGE_MULTI_HOT_KEPPEL_FILESYSTEM_PATH", hotPath)
+	t.Setenv("KEPPEL_STORAGE_MULTI_COLD_KEPPEL_FILESYSTEM_PATH", coldPath)
+	sd := &storageDriver{}
+	err := sd.Init(nil, keppel.Configuration{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(sd.BackendNames(), []string{"hot", "cold"}) {
+		t.Errorf("expected backend names [hot cold], but got %v", sd.BackendNames())
+	}
+	if actual := os.Getenv("KEPPEL_FILESYSTEM_PATH"); actual != defaultPath {
+		t.Errorf("expected KEPPEL_FILESYSTEM_PATH to be restored to %q, but got %q", defaultPath, actual)
+	}
+
+	account := models.ReducedAccount{Name: "test1", AuthTenantID: "tenant1"}
+	err = sd.CanSetupAccount(ctx, account)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// blobs without backend prefix go into the default backend, all others into the named backend
+	mustUpload := func(storageID, contents string) {
+		t.Helper()
+		chunkLength := uint64(len(contents))
+		err := sd.AppendToBlob(ctx, account, storageID, 1, &chunkLength, strings.NewReader(contents))
+		if err == nil {
+			err = sd.FinalizeBlob(ctx, account, storageID, 1)
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	mustUpload("blob1", "hello")
+	mustUpload("cold:blob2", "world")
+	expectFile(t, filepath.Join(hotPath, "tenant1", "test1", "blobs", "blob1"), "hello")
+	expectFile(t, filepath.Join(coldPath, "tenant1", "test1", "blobs", "blob2"), "world")
+
+	// manifests always go into the default backend
+	manifestDigest := digest.FromString("manifest")
+	err = sd.WriteManifest(ctx, account, "foo", manifestDigest, []byte("manifest"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectFile(t, filepath.Join(hotPath, "tenant1", "test1", "manifests", "foo", manifestDigest.String()), "manifest")
+
+	// reads are routed by storage ID
+	reader, sizeBytes, err := sd.ReadBlob(ctx, account, "cold:blob2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	reader.Close()
+	if string(contents) != "world" || sizeBytes != 5 {
+		t.Errorf("expected blob contents %q with 5 bytes, but got %q with %d bytes", "world", string(contents), sizeBytes)
+	}
+	_, _, err = sd.ReadBlob(ctx, account, "warm:blob3")
+	expectError(t, err, `blob with storage ID "warm:blob3" is stored in unknown backend "warm"`)
+
+	// the storage listing uses the same storage IDs as the DB
+	blobs, manifests, err := sd.ListStorageContents(ctx, account)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	slices.SortFunc(blobs, func(lhs, rhs keppel.StoredBlobInfo) int { return strings.Compare(lhs.StorageID, rhs.StorageID) })
+	expectedBlobs := []keppel.StoredBlobInfo{{StorageID: "blob1"}, {StorageID: "cold:blob2"}}
+	if !reflect.DeepEqual(blobs, expectedBlobs) {
+		t.Errorf("expected blobs %#v, but got %#v", expectedBlobs, blobs)
+	}
+	expectedManifests := []keppel.StoredManifestInfo{{RepoName: "foo", Digest: manifestDigest}}
+	if !reflect.DeepEqual(manifests, expectedManifests) {
+		t.Errorf("expected manifests %#v, but got %#v", expectedManifests, manifests)
+	}
+
+	// storage placement rules choose between the backends
+	dbAccount := models.Account{Name: account.Name, AuthTenantID: account.AuthTenantID}
+	rules := []keppel.StoragePlacementRule{{MinSizeBytes: 1000, Backend: "cold"}}
+	rerr := keppel.ApplyStoragePlacementRulesToAccount(rules, sd, &dbAccount)
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	small, large := uint64(10), uint64(1000)
+	for _, tc := range []struct {
+		SizeBytes *uint64
+		Expected  string
+	}{
+		{nil, "blob3"},
+		{&small, "blob3"},
+		{&large, "cold:blob3"},
+	} {
+		actual := keppel.StorageIDForNewBlob(sd, dbAccount.Reduced(), "foo", tc.SizeBytes, "blob3")
+		if actual != tc.Expected {
+			t.Errorf("expected storage ID %q, but got %q", tc.Expected, actual)
+		}
+	}
+
+	// cleanup covers all backends
+	for _, storageID := range []string{"blob1", "cold:blob2"} {
+		err = sd.DeleteBlob(ctx, account, storageID)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	err = sd.DeleteManifest(ctx, account, "foo", manifestDigest)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = sd.CleanupAccount(ctx, account)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, rootPath := range []string{hotPath, coldPath} {
+		_, err = os.Stat(filepath.Join(rootPath, "tenant1", "test1"))
+		if !os.IsNotExist(err) {
+			t.Errorf("expected account directory in %s to be removed, but got err = %v", rootPath, err)
+		}
+	}
+}
+
+func expectError(t *testing.T, err error, expected string) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("expected error %q, but got no error", expected)
+	} else if err.Error() != expected {
+		t.Errorf("expected error %q, but got %q", expected, err.Error())
+	}
+}
+
+func expectFile(t *testing.T, path, expectedContents string) {
+	t.Helper()
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Error(err.Error())
+	} else if string(buf) != expectedContents {
+		t.Errorf("expected %s to contain %q, but got %q", path, expectedContents, string(buf))
+	}
+}
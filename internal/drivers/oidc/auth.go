@@ -0,0 +1,294 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+// Package oidc contains the AuthDriver "oidc": Users are authenticated with
+// ID tokens from an OpenID Connect issuer (e.g. Keycloak, Dex or Azure AD),
+// and the claims in these tokens are mapped to permissions on Keppel tenants
+// through a policy file.
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/osext"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+func init() {
+	keppel.AuthDriverRegistry.Add(func() keppel.AuthDriver { return &AuthDriver{} })
+	keppel.UserIdentityRegistry.Add(func() keppel.UserIdentity { return &userIdentity{} })
+}
+
+const driverName = "oidc"
+
+// tokenHeader is where AuthenticateUserFromRequest() looks for an ID token.
+// The Authorization header cannot be used for this since Bearer tokens in
+// there are always interpreted as tokens issued by Keppel itself.
+const tokenHeader = "X-OIDC-Token"
+
+// Tolerance for clock skew between us and the issuer when checking token expiry.
+const tokenLeeway = 30 * time.Second
+
+// These are the signature algorithms that ID tokens may be signed with.
+// Symmetric algorithms are deliberately not included since we only ever
+// see the issuer's public keys.
+var validSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+////////////////////////////////////////////////////////////////////////////////
+// type Policy
+
+// Policy is the contents of the file at $KEPPEL_OIDC_POLICY_PATH. It
+// describes how the claims in an ID token are mapped to Keppel permissions.
+type Policy struct {
+	// Claim containing the user name (default: "preferred_username").
+	UserNameClaim string `json:"username_claim" yaml:"username_claim"`
+	// Claim containing the list of groups (default: "groups").
+	GroupsClaim string `json:"groups_claim" yaml:"groups_claim"`
+	// Claim containing the tenant ID for rules without an explicit tenant ID (optional).
+	TenantClaim string       `json:"tenant_claim" yaml:"tenant_claim"`
+	Rules       []PolicyRule `json:"rules"`
+}
+
+// PolicyRule grants permissions to all members of a group.
+type PolicyRule struct {
+	Group string `json:"group"`
+	// If empty, permissions are granted on the tenant identified by the
+	// Policy.TenantClaim. This is ignored for CanAdministrateKeppel, which
+	// does not pertain to any tenant.
+	TenantID    string              `json:"tenant_id" yaml:"tenant_id"`
+	Permissions []keppel.Permission `json:"permissions"`
+}
+
+var knownPermissions = []keppel.Permission{
+	keppel.CanViewAccount,
+	keppel.CanPullFromAccount,
+	keppel.CanPushToAccount,
+	keppel.CanDeleteFromAccount,
+	keppel.CanChangeAccount,
+	keppel.CanViewQuotas,
+	keppel.CanChangeQuotas,
+	keppel.CanAdministrateKeppel,
+}
+
+func loadPolicy(path string) (Policy, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	err = dec.Decode(&p)
+	if err != nil {
+		return Policy{}, fmt.Errorf("while parsing %s: %w", path, err)
+	}
+
+	if p.UserNameClaim == "" {
+		p.UserNameClaim = "preferred_username"
+	}
+	if p.GroupsClaim == "" {
+		p.GroupsClaim = "groups"
+	}
+	for idx, rule := range p.Rules {
+		if rule.Group == "" {
+			return Policy{}, fmt.Errorf("while parsing %s: rules[%d] does not have a group", path, idx)
+		}
+		if len(rule.Permissions) == 0 {
+			return Policy{}, fmt.Errorf("while parsing %s: rules[%d] does not grant any permissions", path, idx)
+		}
+		for _, perm := range rule.Permissions {
+			if !slices.Contains(knownPermissions, perm) {
+				return Policy{}, fmt.Errorf("while parsing %s: rules[%d] contains unknown permission %q", path, idx, perm)
+			}
+		}
+	}
+	return p, nil
+}
+
+// PermissionsForClaims computes the permissions of a user with the given claims.
+// The result maps tenant IDs to permissions. CanAdministrateKeppel is stored
+// under the empty tenant ID.
+func (p Policy) PermissionsForClaims(claims jwt.MapClaims) map[string][]keppel.Permission {
+	var groups []string
+	switch value := claims[p.GroupsClaim].(type) {
+	case string:
+		groups = []string{value}
+	case []any:
+		for _, v := range value {
+			if group, ok := v.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+	tokenTenantID, _ := claims[p.TenantClaim].(string) //nolint:errcheck // a missing tenant claim is handled below
+
+	result := make(map[string][]keppel.Permission)
+	for _, rule := range p.Rules {
+		if !slices.Contains(groups, rule.Group) {
+			continue
+		}
+		for _, perm := range rule.Permissions {
+			tenantID := rule.TenantID
+			switch {
+			case perm == keppel.CanAdministrateKeppel:
+				tenantID = ""
+			case tenantID == "" && p.TenantClaim != "":
+				tenantID = tokenTenantID
+			}
+			if tenantID == "" && perm != keppel.CanAdministrateKeppel {
+				continue
+			}
+			if !slices.Contains(result[tenantID], perm) {
+				result[tenantID] = append(result[tenantID], perm)
+			}
+		}
+	}
+	return result
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// type AuthDriver
+
+// AuthDriver is the auth driver "oidc".
+type AuthDriver struct {
+	IssuerURL string
+	ClientID  string
+	Policy    Policy
+	keySet    *keySet
+}
+
+// PluginTypeID implements the keppel.AuthDriver interface.
+func (d *AuthDriver) PluginTypeID() string {
+	return driverName
+}
+
+// Init implements the keppel.AuthDriver interface.
+func (d *AuthDriver) Init(ctx context.Context, rc *redis.Client) (err error) {
+	d.IssuerURL = osext.MustGetenv("KEPPEL_OIDC_ISSUER_URL")
+	d.ClientID = osext.MustGetenv("KEPPEL_OIDC_CLIENT_ID")
+	d.Policy, err = loadPolicy(osext.MustGetenv("KEPPEL_OIDC_POLICY_PATH"))
+	if err != nil {
+		return err
+	}
+	d.keySet, err = discoverKeySet(ctx, d.IssuerURL)
+	return err
+}
+
+// AuthenticateUser implements the keppel.AuthDriver interface.
+//
+// The password must be an ID token for the given user.
+func (d *AuthDriver) AuthenticateUser(ctx context.Context, userName, password string) (keppel.UserIdentity, *keppel.RegistryV2Error) {
+	uid, err := d.validateToken(ctx, password)
+	if err != nil {
+		return nil, keppel.ErrUnauthorized.With("ID token validation failed: %s", err.Error())
+	}
+	if uid.Name != userName {
+		return nil, keppel.ErrUnauthorized.With("ID token validation failed: token was issued for user %q", uid.Name)
+	}
+	return uid, nil
+}
+
+// AuthenticateUserFromRequest implements the keppel.AuthDriver interface.
+func (d *AuthDriver) AuthenticateUserFromRequest(r *http.Request) (keppel.UserIdentity, *keppel.RegistryV2Error) {
+	tokenStr := r.Header.Get(tokenHeader)
+	if tokenStr == "" {
+		// fallback to anonymous auth
+		return nil, nil
+	}
+
+	uid, err := d.validateToken(r.Context(), tokenStr)
+	if err != nil {
+		return nil, keppel.ErrUnauthorized.With("%s validation failed: %s", tokenHeader, err.Error())
+	}
+	return uid, nil
+}
+
+func (d *AuthDriver) validateToken(ctx context.Context, tokenStr string) (*userIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, d.keySet.KeyFunc(ctx),
+		jwt.WithValidMethods(validSigningMethods),
+		jwt.WithIssuer(d.IssuerURL),
+		jwt.WithAudience(d.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(tokenLeeway),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	userName, _ := claims[d.Policy.UserNameClaim].(string) //nolint:errcheck // a missing user name is handled below
+	if userName == "" {
+		return nil, fmt.Errorf("token does not contain a %q claim", d.Policy.UserNameClaim)
+	}
+	return &userIdentity{
+		Name:        userName,
+		Permissions: d.Policy.PermissionsForClaims(claims),
+	}, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// type userIdentity
+
+type userIdentity struct {
+	Name string `json:"name"`
+	// key = tenant ID (or empty string for CanAdministrateKeppel)
+	Permissions map[string][]keppel.Permission `json:"perms,omitempty"`
+}
+
+// PluginTypeID implements the keppel.UserIdentity interface.
+func (uid *userIdentity) PluginTypeID() string {
+	return driverName
+}
+
+// HasPermission implements the keppel.UserIdentity interface.
+func (uid *userIdentity) HasPermission(perm keppel.Permission, tenantID string) bool {
+	if tenantID == "" && perm != keppel.CanAdministrateKeppel {
+		return false
+	}
+	return slices.Contains(uid.Permissions[tenantID], perm)
+}
+
+// UserType implements the keppel.UserIdentity interface.
+func (uid *userIdentity) UserType() keppel.UserType {
+	return keppel.RegularUser
+}
+
+// UserName implements the keppel.UserIdentity interface.
+func (uid *userIdentity) UserName() string {
+	return uid.Name
+}
+
+// UserInfo implements the keppel.UserIdentity interface.
+func (uid *userIdentity) UserInfo() audittools.UserInfo {
+	return nil
+}
+
+// SerializeToJSON implements the keppel.UserIdentity interface.
+func (uid *userIdentity) SerializeToJSON() (payload []byte, err error) {
+	return json.Marshal(uid)
+}
+
+// DeserializeFromJSON implements the keppel.UserIdentity interface.
+func (uid *userIdentity) DeserializeFromJSON(in []byte, ad keppel.AuthDriver) error {
+	if _, ok := ad.(*AuthDriver); !ok {
+		return keppel.ErrAuthDriverMismatch
+	}
+	err := json.Unmarshal(in, uid)
+	if err == nil && uid.Name == "" {
+		err = errors.New("user name is missing")
+	}
+	return err
+}
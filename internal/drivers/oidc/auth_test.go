@@ -0,0 +1,219 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+func TestAuthDriver(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// setup a minimal OIDC issuer
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		respondwith.JSON(w, http.StatusOK, map[string]any{"issuer": srv.URL, "jwks_uri": srv.URL + "/keys"})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		respondwith.JSON(w, http.StatusOK, map[string]any{"keys": []map[string]any{{
+			"kid": "key1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	t.Setenv("KEPPEL_OIDC_ISSUER_URL", srv.URL)
+	t.Setenv("KEPPEL_OIDC_CLIENT_ID", "keppel")
+	t.Setenv("KEPPEL_OIDC_POLICY_PATH", "./fixtures/policy.yaml")
+	ad, err := keppel.NewAuthDriver(t.Context(), driverName, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	makeToken := func(claims jwt.MapClaims) string {
+		baseClaims := jwt.MapClaims{
+			"iss":                srv.URL,
+			"aud":                "keppel",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"preferred_username": "alice",
+		}
+		for k, v := range claims {
+			baseClaims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, baseClaims)
+		token.Header["kid"] = "key1"
+		tokenStr, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return tokenStr
+	}
+
+	// happy case: permissions are derived from group memberships
+	tokenStr := makeToken(jwt.MapClaims{"groups": []string{"team-a", "developers"}, "tenant": "tenant-b"})
+	uid, rerr := ad.AuthenticateUser(t.Context(), "alice", tokenStr)
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	assert.DeepEqual(t, "UserName", uid.UserName(), "alice")
+	expectPermissions(t, uid, map[string]bool{
+		"view:tenant-a": true,
+		"pull:tenant-a": true,
+		"push:tenant-a": true,
+		"view:tenant-b": true,
+		"pull:tenant-b": true,
+		"push:tenant-b": false,
+		"view:tenant-c": false,
+		"admin:":        false,
+	})
+
+	// permissions survive a serialization roundtrip
+	payload, err := uid.SerializeToJSON()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	uid2 := &userIdentity{}
+	err = uid2.DeserializeFromJSON(payload, ad)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "deserialized identity", uid2, uid.(*userIdentity))
+
+	// tokens can also be given in the request headers (a single group can be given as a string)
+	req := httptest.NewRequest(http.MethodGet, "/keppel/v1/accounts", http.NoBody)
+	req.Header.Set(tokenHeader, makeToken(jwt.MapClaims{"groups": "keppel-admins"}))
+	uid, rerr = ad.AuthenticateUserFromRequest(req)
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	expectPermissions(t, uid, map[string]bool{
+		"admin:":        true,
+		"view:tenant-a": false,
+	})
+
+	// no token -> anonymous
+	req = httptest.NewRequest(http.MethodGet, "/keppel/v1/accounts", http.NoBody)
+	uid, rerr = ad.AuthenticateUserFromRequest(req)
+	if uid != nil || rerr != nil {
+		t.Errorf("expected (nil, nil) for request without token, but got (%#v, %#v)", uid, rerr)
+	}
+
+	// failure cases
+	expectFailure := func(userName, tokenStr string) {
+		t.Helper()
+		_, rerr := ad.AuthenticateUser(t.Context(), userName, tokenStr)
+		if rerr == nil {
+			t.Errorf("expected authentication of %q to fail, but it succeeded", userName)
+		}
+	}
+	expectFailure("bob", makeToken(nil))
+	expectFailure("alice", makeToken(jwt.MapClaims{"aud": "someone-else"}))
+	expectFailure("alice", makeToken(jwt.MapClaims{"iss": "https://evil.example.com"}))
+	expectFailure("alice", makeToken(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}))
+	expectFailure("alice", "not-a-token")
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                srv.URL,
+		"aud":                "keppel",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"preferred_username": "alice",
+	})
+	token.Header["kid"] = "key1"
+	forgedTokenStr, err := token.SignedString(otherKey)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectFailure("alice", forgedTokenStr)
+}
+
+func TestKeySetRefreshDoesNotBlockKnownKeys(t *testing.T) {
+	// the first request for the key set succeeds immediately, the second one blocks until released
+	refreshStarted := make(chan struct{})
+	releaseRefresh := make(chan struct{})
+	var requestCount atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := []map[string]any{{"kid": "key1", "kty": "EC", "crv": "P-256", "x": "AQ", "y": "Ag"}}
+		if requestCount.Add(1) > 1 {
+			close(refreshStarted)
+			<-releaseRefresh
+			keys = append(keys, map[string]any{"kid": "key2", "kty": "EC", "crv": "P-256", "x": "Aw", "y": "BA"})
+		}
+		respondwith.JSON(w, http.StatusOK, map[string]any{"keys": keys})
+	}))
+	defer srv.Close()
+
+	ks := &keySet{JWKSURL: srv.URL}
+	err := ks.refresh(t.Context())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ks.refreshedAt = time.Now().Add(-keySetMinRefreshInterval)
+
+	// a token with an unknown key ID triggers a refresh...
+	keyFunc := ks.KeyFunc(t.Context())
+	refreshResult := make(chan error, 1)
+	go func() {
+		_, err := keyFunc(&jwt.Token{Header: map[string]any{"kid": "key2"}})
+		refreshResult <- err
+	}()
+	<-refreshStarted
+
+	// ...but while the refresh is waiting for the issuer, tokens with known key IDs can still be validated
+	lookupResult := make(chan error, 1)
+	go func() {
+		_, err := keyFunc(&jwt.Token{Header: map[string]any{"kid": "key1"}})
+		lookupResult <- err
+	}()
+	select {
+	case err := <-lookupResult:
+		if err != nil {
+			t.Error(err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("lookup of known key was blocked by key set refresh")
+	}
+
+	close(releaseRefresh)
+	err = <-refreshResult
+	if err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func expectPermissions(t *testing.T, uid keppel.UserIdentity, expected map[string]bool) {
+	t.Helper()
+	for key, expectedValue := range expected {
+		perm, tenantID, _ := strings.Cut(key, ":")
+		actualValue := uid.HasPermission(keppel.Permission(perm), tenantID)
+		if actualValue != expectedValue {
+			t.Errorf("expected HasPermission(%q, %q) = %t, but got %t", perm, tenantID, expectedValue, actualValue)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// This file contains a minimal client for OpenID Connect discovery and for
+// the JSON Web Key Sets (JWKS) that are used by the issuer to sign ID tokens.
+// Reference: <https://openid.net/specs/openid-connect-discovery-1_0.html>
+
+// The key set is not refreshed more often than this, even if tokens with
+// unknown key IDs come in, to avoid hammering the issuer with requests.
+const keySetMinRefreshInterval = time.Minute
+
+// Requests to the issuer should never take this long, and since key set
+// refreshes block the authentication of users, we must not wait forever.
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+type keySet struct {
+	JWKSURL string
+
+	// refreshMutex serializes refreshes, but is not held while using the
+	// current keys, so that a slow issuer does not block the authentication of
+	// users with known key IDs.
+	refreshMutex sync.Mutex
+	mutex        sync.RWMutex
+	keys         map[string]any // key ID -> *rsa.PublicKey or *ecdsa.PublicKey
+	refreshedAt  time.Time
+}
+
+// discoverKeySet reads the discovery document of the given issuer to find the
+// URL of its key set.
+func discoverKeySet(ctx context.Context, issuerURL string) (*keySet, error) {
+	discoveryURL := strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
+	var data struct {
+		Issuer  string `json:"issuer"`
+		JWKSURL string `json:"jwks_uri"`
+	}
+	err := getJSON(ctx, discoveryURL, &data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read OIDC discovery document: %w", err)
+	}
+	if data.Issuer != issuerURL {
+		return nil, fmt.Errorf("cannot read OIDC discovery document: expected issuer %q, but got %q", issuerURL, data.Issuer)
+	}
+	if data.JWKSURL == "" {
+		return nil, errors.New("cannot read OIDC discovery document: jwks_uri is missing")
+	}
+
+	ks := &keySet{JWKSURL: data.JWKSURL}
+	err = ks.refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// KeyFunc returns a jwt.Keyfunc that finds the verification key for a token
+// in this key set. If the token refers to an unknown key ID, the key set is
+// reloaded from the issuer, since the issuer might have rotated its keys.
+func (ks *keySet) KeyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(t *jwt.Token) (any, error) {
+		keyID, _ := t.Header["kid"].(string) //nolint:errcheck // a missing "kid" is handled below
+
+		key, exists, canRefresh := ks.findKey(keyID)
+		if !exists && canRefresh {
+			ks.refreshMutex.Lock()
+			defer ks.refreshMutex.Unlock()
+
+			// another goroutine may have refreshed while we were waiting for the lock
+			key, exists, canRefresh = ks.findKey(keyID)
+			if !exists && canRefresh {
+				err := ks.refresh(ctx)
+				if err != nil {
+					return nil, err
+				}
+				key, exists, _ = ks.findKey(keyID)
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("no verification key found for key ID %q", keyID)
+		}
+		return key, nil
+	}
+}
+
+// findKey also reports whether the key set may be refreshed already.
+func (ks *keySet) findKey(keyID string) (key any, exists, canRefresh bool) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	canRefresh = time.Since(ks.refreshedAt) >= keySetMinRefreshInterval
+	if keyID == "" && len(ks.keys) == 1 {
+		// tokens do not need to specify a key ID if there is only one key
+		for _, key := range ks.keys {
+			return key, true, canRefresh
+		}
+	}
+	key, exists = ks.keys[keyID]
+	return key, exists, canRefresh
+}
+
+// refresh replaces the keys in this key set with the issuer's current keys.
+// The caller must hold ks.refreshMutex, except during construction.
+func (ks *keySet) refresh(ctx context.Context) error {
+	var data struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err := getJSON(ctx, ks.JWKSURL, &data)
+	if err != nil {
+		return fmt.Errorf("cannot read OIDC key set: %w", err)
+	}
+
+	keys := make(map[string]any, len(data.Keys))
+	for _, jwk := range data.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return fmt.Errorf("cannot parse key %q in OIDC key set: %w", jwk.KeyID, err)
+		}
+		if key != nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	ks.keys = keys
+	ks.refreshedAt = time.Now()
+	return nil
+}
+
+// jsonWebKey is a single key in a JWKS document. Only the fields for RSA and
+// EC keys are understood.
+// Reference: <https://datatracker.ietf.org/doc/html/rfc7518#section-6>
+type jsonWebKey struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+	CurveName string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// PublicKey returns (nil, nil) for key types that we do not support.
+func (jwk jsonWebKey) PublicKey() (any, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.Modulus)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(jwk.Exponent)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent: out of range")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.CurveName {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+func decodeBigInt(in string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(in)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return nil, errors.New("value is empty")
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
+
+func getJSON(ctx context.Context, url string, data any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: expected 200 OK, but got %s: %s", url, resp.Status, string(respBytes))
+	}
+	err = json.Unmarshal(respBytes, data)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", url, err)
+	}
+	return nil
+}
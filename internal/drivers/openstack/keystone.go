@@ -220,13 +220,14 @@ func newKeystoneUserIdentity(t *gopherpolicy.Token, isRelevantRole map[string]bo
 }
 
 var ruleForPerm = map[keppel.Permission]string{
-	keppel.CanViewAccount:       "account:show",
-	keppel.CanPullFromAccount:   "account:pull",
-	keppel.CanPushToAccount:     "account:push",
-	keppel.CanDeleteFromAccount: "account:delete",
-	keppel.CanChangeAccount:     "account:edit",
-	keppel.CanViewQuotas:        "quota:show",
-	keppel.CanChangeQuotas:      "quota:edit",
+	keppel.CanViewAccount:        "account:show",
+	keppel.CanPullFromAccount:    "account:pull",
+	keppel.CanPushToAccount:      "account:push",
+	keppel.CanDeleteFromAccount:  "account:delete",
+	keppel.CanChangeAccount:      "account:edit",
+	keppel.CanViewQuotas:         "quota:show",
+	keppel.CanChangeQuotas:       "quota:edit",
+	keppel.CanAdministrateKeppel: "cluster:admin",
 }
 
 // PluginTypeID implements the keppel.UserIdentity interface.
@@ -244,7 +245,7 @@ func (a *keystoneUserIdentity) UserName() string {
 
 // HasPermission implements the keppel.UserIdentity interface.
 func (a *keystoneUserIdentity) HasPermission(perm keppel.Permission, tenantID string) bool {
-	if tenantID == "" {
+	if tenantID == "" && perm != keppel.CanAdministrateKeppel {
 		return false
 	}
 
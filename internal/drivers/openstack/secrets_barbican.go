@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/sapcc/go-bits/gophercloudext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+type secretsDriverBarbican struct {
+	KeyManagerV1 *gophercloud.ServiceClient
+}
+
+func init() {
+	keppel.SecretsDriverRegistry.Add(func() keppel.SecretsDriver { return &secretsDriverBarbican{} })
+}
+
+// PluginTypeID implements the keppel.SecretsDriver interface.
+func (d *secretsDriverBarbican) PluginTypeID() string { return "barbican" }
+
+// Init implements the keppel.SecretsDriver interface.
+func (d *secretsDriverBarbican) Init(ctx context.Context, cfg keppel.Configuration) error {
+	provider, eo, err := gophercloudext.NewProviderClient(ctx, &gophercloudext.ClientOpts{EnvPrefix: "KEPPEL_SECRETS_OS_"})
+	if err != nil {
+		return err
+	}
+	d.KeyManagerV1, err = openstack.NewKeyManagerV1(provider, eo)
+	if err != nil {
+		return errors.New("cannot find Barbican v1 API for secrets driver: " + err.Error())
+	}
+	return nil
+}
+
+// The user-defined metadata key that marks a secret as belonging to an auth tenant.
+const barbicanTenantMetadataKey = "keppel_auth_tenant_id"
+
+var barbicanSecretIDRx = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// ReadSecret implements the keppel.SecretsDriver interface.
+//
+// References are either the UUID of a Barbican secret, or its full URL
+// (as shown in the `secret_ref` attribute in the Barbican API).
+//
+// Since Barbican does not report which project a secret belongs to, secrets
+// are only resolved if their user-defined metadata contains the key
+// "keppel_auth_tenant_id" with the auth tenant ID of the requesting user.
+// Only users with write access to a secret can set its metadata, so users
+// cannot make Keppel read secrets that do not belong to them.
+func (d *secretsDriverBarbican) ReadSecret(ctx context.Context, authTenantID, ref string) (string, error) {
+	secretID := ref
+	if strings.Contains(ref, "/") {
+		secretID = ref[strings.LastIndex(ref, "/")+1:]
+	}
+	if !barbicanSecretIDRx.MatchString(secretID) {
+		return "", fmt.Errorf("malformed secret reference %q: expected a Barbican secret UUID or URL", ref)
+	}
+
+	var metadata struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	_, err := d.KeyManagerV1.Get(ctx, d.KeyManagerV1.ServiceURL("secrets", secretID, "metadata"), &metadata, nil)
+	if err != nil {
+		return "", fmt.Errorf("while reading metadata of secret %q from Barbican: %w", ref, err)
+	}
+	if authTenantID == "" || metadata.Metadata[barbicanTenantMetadataKey] != authTenantID {
+		return "", fmt.Errorf("secret %q in Barbican is not marked as belonging to auth tenant %q", ref, authTenantID)
+	}
+
+	resp, err := d.KeyManagerV1.Get(ctx, d.KeyManagerV1.ServiceURL("secrets", secretID, "payload"), nil, &gophercloud.RequestOpts{
+		MoreHeaders:      map[string]string{"Accept": "text/plain"},
+		OkCodes:          []int{http.StatusOK},
+		KeepResponseBody: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("while reading secret %q from Barbican: %w", ref, err)
+	}
+	defer resp.Body.Close()
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("while reading secret %q from Barbican: %w", ref, err)
+	}
+	return string(payload), nil
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package openstack
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/sapcc/go-bits/assert"
+)
+
+func TestBarbicanSecretsDriver(t *testing.T) {
+	const (
+		tenant1SecretID  = "11111111-1111-1111-1111-111111111111"
+		operatorSecretID = "22222222-2222-2222-2222-222222222222"
+	)
+	// fake Barbican with one secret that belongs to tenant1, and one that does
+	// not carry any metadata (e.g. a secret of the operator)
+	metadata := map[string]string{
+		tenant1SecretID:  `{"metadata":{"keppel_auth_tenant_id":"tenant1"}}`,
+		operatorSecretID: `{"metadata":{}}`,
+	}
+	payloads := map[string]string{
+		tenant1SecretID:  "secret1",
+		operatorSecretID: "topsecret",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secretID, suffix, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/secrets/"), "/")
+		switch suffix {
+		case "metadata":
+			if payload, ok := metadata[secretID]; ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(payload))
+				return
+			}
+		case "payload":
+			if payload, ok := payloads[secretID]; ok {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(payload))
+				return
+			}
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	t.Cleanup(srv.Close)
+
+	d := &secretsDriverBarbican{KeyManagerV1: &gophercloud.ServiceClient{
+		ProviderClient: &gophercloud.ProviderClient{},
+		Endpoint:       srv.URL + "/v1/",
+	}}
+
+	// happy case: secrets can be referenced by UUID or URL
+	secret, err := d.ReadSecret(t.Context(), "tenant1", tenant1SecretID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "secret", secret, "secret1")
+	secret, err = d.ReadSecret(t.Context(), "tenant1", srv.URL+"/v1/secrets/"+tenant1SecretID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "secret", secret, "secret1")
+
+	// failure cases
+	expectError := func(authTenantID, ref, expectedMessage string) {
+		t.Helper()
+		_, err := d.ReadSecret(t.Context(), authTenantID, ref)
+		if err == nil || !strings.Contains(err.Error(), expectedMessage) {
+			t.Errorf("expected error containing %q for %q in tenant %q, but got: %v", expectedMessage, ref, authTenantID, err)
+		}
+	}
+	expectError("tenant1", "not-a-uuid", "malformed secret reference")
+	expectError("tenant1", "33333333-3333-3333-3333-333333333333", "while reading metadata")
+
+	// secrets that are not marked as belonging to the tenant are not resolved
+	expectError("tenant2", tenant1SecretID, `is not marked as belonging to auth tenant "tenant2"`)
+	expectError("tenant1", operatorSecretID, `is not marked as belonging to auth tenant "tenant1"`)
+	expectError("", operatorSecretID, `is not marked as belonging to auth tenant ""`)
+}
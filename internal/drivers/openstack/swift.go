@@ -164,7 +164,16 @@ func uploadToObject(ctx context.Context, o *schwift.Object, content io.Reader, o
 	if err != nil && !schwift.Is(err, http.StatusNotFound) {
 		return err
 	}
-	return o.Upload(ctx, content, opts, ropts)
+	return wrapQuotaError(o.Upload(ctx, content, opts, ropts))
+}
+
+// Swift reports exceeded account or container quotas with status 413, and
+// backends that have run out of space with status 507.
+func wrapQuotaError(err error) error {
+	if schwift.Is(err, http.StatusRequestEntityTooLarge) || schwift.Is(err, http.StatusInsufficientStorage) {
+		return fmt.Errorf("%w: %s", keppel.ErrStorageQuotaExceeded, err.Error())
+	}
+	return err
 }
 
 // AppendToBlob implements the keppel.StorageDriver interface.
@@ -181,6 +190,16 @@ func (d *swiftDriver) AppendToBlob(ctx context.Context, account models.ReducedAc
 	return uploadToObject(ctx, o, chunk, nil, hdr.ToOpts())
 }
 
+// With the default limit of 1000 segments per static large object, this
+// minimum chunk size ensures that chunked uploads of up to ~5 GiB can be
+// finalized.
+const swiftMinChunkSizeBytes = 5 << 20 // 5 MiB
+
+// ChunkSizeLimits implements the keppel.StorageDriverWithChunkSizeLimits interface.
+func (d *swiftDriver) ChunkSizeLimits() keppel.ChunkSizeLimits {
+	return keppel.ChunkSizeLimits{MinBytes: swiftMinChunkSizeBytes}
+}
+
 // FinalizeBlob implements the keppel.StorageDriver interface.
 func (d *swiftDriver) FinalizeBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error {
 	c, _, err := d.getBackendConnection(ctx, account)
@@ -215,7 +234,7 @@ func (d *swiftDriver) FinalizeBlob(ctx context.Context, account models.ReducedAc
 		}
 	}
 
-	return lo.WriteManifest(ctx, nil)
+	return wrapQuotaError(lo.WriteManifest(ctx, nil))
 }
 
 // AbortBlobUpload implements the keppel.StorageDriver interface.
@@ -260,6 +279,28 @@ func (d *swiftDriver) ReadBlob(ctx context.Context, account models.ReducedAccoun
 	return reader, hdr.SizeBytes().Get(), err
 }
 
+// ReadBlobRange implements the keppel.StorageDriver interface.
+func (d *swiftDriver) ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (io.ReadCloser, error) {
+	c, _, err := d.getBackendConnection(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	// o.Download() only accepts 200 OK, so we need to build the request ourselves
+	resp, err := schwift.Request{
+		Method:        http.MethodGet,
+		ContainerName: c.Name(),
+		ObjectName:    blobObject(c, storageID).Name(),
+		Options: &schwift.RequestOptions{
+			Headers: schwift.Headers{"Range": fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)},
+		},
+		ExpectStatusCodes: []int{http.StatusPartialContent},
+	}.Do(ctx, c.Account().Backend())
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
 // URLForBlob implements the keppel.StorageDriver interface.
 func (d *swiftDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
 	c, info, err := d.getBackendConnection(ctx, account)
@@ -408,6 +449,84 @@ func mergeChunkCount(chunkCounts map[string]uint32, key string, chunkNumber uint
 	}
 }
 
+// ListOrphanedSegments implements the keppel.StorageDriverWithSegments interface.
+func (d *swiftDriver) ListOrphanedSegments(ctx context.Context, account models.ReducedAccount) ([]keppel.StoredSegmentInfo, error) {
+	c, _, err := d.getBackendConnection(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	isFinalized := make(map[string]bool)                             // key = storage ID
+	chunksByStorageID := make(map[string][]keppel.StoredSegmentInfo) // key = storage ID
+	err = c.Objects().ForeachDetailed(ctx, func(info schwift.ObjectInfo) error {
+		if match := blobObjectNameRx.FindStringSubmatch(info.Object.Name()); match != nil {
+			isFinalized[match[1]+match[2]+match[3]] = true
+			return nil
+		}
+		if match := chunkObjectNameRx.FindStringSubmatch(info.Object.Name()); match != nil {
+			storageID := match[1] + match[2] + match[3]
+			chunkNumber, err := strconv.ParseUint(match[4], 10, 32)
+			if err != nil {
+				return fmt.Errorf("while parsing chunk object name %s: %s", info.Object.Name(), err.Error())
+			}
+			chunksByStorageID[storageID] = append(chunksByStorageID[storageID], keppel.StoredSegmentInfo{
+				StorageID:   storageID,
+				ChunkNumber: uint32(chunkNumber),
+				SizeBytes:   info.SizeBytes,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// chunks of unfinalized uploads are handled by the storage sweep (if the
+	// upload is unknown to the DB) or by the abandoned upload cleanup; for
+	// finalized blobs, the SLO manifest tells us which chunks are in use
+	var result []keppel.StoredSegmentInfo
+	for storageID, chunks := range chunksByStorageID {
+		if !isFinalized[storageID] {
+			continue
+		}
+		lo, err := blobObject(c, storageID).AsLargeObject(ctx)
+		if errors.Is(err, schwift.ErrNotLarge) {
+			// blob was deleted in the meantime; its leftover chunks will show up in the storage sweep
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("while inspecting segments of blob %s: %w", storageID, err)
+		}
+		segments, err := lo.Segments()
+		if err != nil {
+			return nil, err
+		}
+		isReferenced := make(map[string]bool, len(segments))
+		for _, segment := range segments {
+			isReferenced[segment.Object.FullName()] = true
+		}
+		for _, chunk := range chunks {
+			if !isReferenced[chunkObject(c, storageID, chunk.ChunkNumber).FullName()] {
+				result = append(result, chunk)
+			}
+		}
+	}
+	return result, nil
+}
+
+// DeleteSegment implements the keppel.StorageDriverWithSegments interface.
+func (d *swiftDriver) DeleteSegment(ctx context.Context, account models.ReducedAccount, segment keppel.StoredSegmentInfo) error {
+	c, _, err := d.getBackendConnection(ctx, account)
+	if err != nil {
+		return err
+	}
+	err = chunkObject(c, segment.StorageID, segment.ChunkNumber).Delete(ctx, nil, nil)
+	if schwift.Is(err, http.StatusNotFound) {
+		return nil // the segment is gone already, which is what we wanted
+	}
+	return err
+}
+
 // CanSetupAccount implements the keppel.StorageDriver interface.
 func (d *swiftDriver) CanSetupAccount(ctx context.Context, account models.ReducedAccount) error {
 	// check that the Swift account is accessible
@@ -0,0 +1,274 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/osext"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// S3 does not accept single-part uploads larger than this.
+const maxSinglePartUploadBytes = 5 << 30
+
+var prefixRx = regexp.MustCompile(`^[A-Za-z0-9/_.-]*$`)
+
+type backupDriver struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	creds    client.AWSCredentials
+}
+
+func init() {
+	keppel.BackupDriverRegistry.Add(func() keppel.BackupDriver { return &backupDriver{} })
+}
+
+// PluginTypeID implements the keppel.BackupDriver interface.
+func (d *backupDriver) PluginTypeID() string { return "s3" }
+
+// Init implements the keppel.BackupDriver interface.
+func (d *backupDriver) Init(ctx context.Context, cfg keppel.Configuration) (err error) {
+	d.creds = client.AWSCredentials{
+		Region:          osext.MustGetenv("KEPPEL_BACKUP_S3_REGION"),
+		AccessKeyID:     osext.MustGetenv("KEPPEL_BACKUP_S3_ACCESS_KEY_ID"),
+		SecretAccessKey: osext.MustGetenv("KEPPEL_BACKUP_S3_SECRET_ACCESS_KEY"),
+	}
+	defaultEndpoint := fmt.Sprintf("https://s3.%s.amazonaws.com", d.creds.Region)
+	d.endpoint, err = url.Parse(osext.GetenvOrDefault("KEPPEL_BACKUP_S3_ENDPOINT", defaultEndpoint))
+	if err != nil {
+		return fmt.Errorf("malformed KEPPEL_BACKUP_S3_ENDPOINT: %w", err)
+	}
+	d.bucket = osext.MustGetenv("KEPPEL_BACKUP_S3_BUCKET")
+	d.prefix = strings.Trim(osext.GetenvOrDefault("KEPPEL_BACKUP_S3_PREFIX", ""), "/")
+	if !prefixRx.MatchString(d.prefix) {
+		return fmt.Errorf("malformed KEPPEL_BACKUP_S3_PREFIX: %q contains forbidden characters", d.prefix)
+	}
+	if d.prefix != "" {
+		d.prefix += "/"
+	}
+	return nil
+}
+
+func (d *backupDriver) contentKey(dgst digest.Digest) string {
+	// the digest is split into algorithm and hex part to avoid colons in object names
+	return fmt.Sprintf("%scontents/%s/%s", d.prefix, dgst.Algorithm(), dgst.Encoded())
+}
+
+func (d *backupDriver) accountContentKey(accountName models.AccountName, repoName string, dgst digest.Digest) string {
+	return fmt.Sprintf("%saccount-contents/%s/%s/%s/%s", d.prefix, accountName, repoName, dgst.Algorithm(), dgst.Encoded())
+}
+
+func (d *backupDriver) snapshotKey(name string) string {
+	return d.prefix + "snapshots/" + name
+}
+
+// HasContent implements the keppel.BackupDriver interface.
+func (d *backupDriver) HasContent(ctx context.Context, dgst digest.Digest) (bool, error) {
+	resp, err := d.do(ctx, http.MethodHead, d.contentKey(dgst), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("while checking for %s in S3: expected 200 OK or 404 Not Found, but got %s", dgst, resp.Status)
+	}
+}
+
+// WriteContent implements the keppel.BackupDriver interface.
+func (d *backupDriver) WriteContent(ctx context.Context, dgst digest.Digest, sizeBytes uint64, contents io.Reader) error {
+	if sizeBytes > maxSinglePartUploadBytes {
+		return fmt.Errorf("cannot upload %s into S3: size of %d bytes exceeds the limit of %d bytes", dgst, sizeBytes, uint64(maxSinglePartUploadBytes))
+	}
+	resp, err := d.do(ctx, http.MethodPut, d.contentKey(dgst), nil, &requestBody{contents, sizeBytes, client.AWSUnsignedPayload})
+	if err != nil {
+		return err
+	}
+	return expectSuccess(resp, "uploading "+dgst.String())
+}
+
+// ReadContent implements the keppel.BackupDriver interface.
+func (d *backupDriver) ReadContent(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	resp, err := d.do(ctx, http.MethodGet, d.contentKey(dgst), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, keppel.ErrBackupContentNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, expectSuccess(resp, "downloading "+dgst.String())
+	}
+	return resp.Body, nil
+}
+
+// WriteAccountContent implements the keppel.BackupDriver interface.
+func (d *backupDriver) WriteAccountContent(ctx context.Context, accountName models.AccountName, repoName string, dgst digest.Digest, sizeBytes uint64, contents io.Reader) error {
+	if sizeBytes > maxSinglePartUploadBytes {
+		return fmt.Errorf("cannot upload %s/%s@%s into S3: size of %d bytes exceeds the limit of %d bytes", accountName, repoName, dgst, sizeBytes, uint64(maxSinglePartUploadBytes))
+	}
+	resp, err := d.do(ctx, http.MethodPut, d.accountContentKey(accountName, repoName, dgst), nil, &requestBody{contents, sizeBytes, client.AWSUnsignedPayload})
+	if err != nil {
+		return err
+	}
+	return expectSuccess(resp, fmt.Sprintf("uploading %s/%s@%s", accountName, repoName, dgst))
+}
+
+// ReadAccountContent implements the keppel.BackupDriver interface.
+func (d *backupDriver) ReadAccountContent(ctx context.Context, accountName models.AccountName, repoName string, dgst digest.Digest) (io.ReadCloser, error) {
+	resp, err := d.do(ctx, http.MethodGet, d.accountContentKey(accountName, repoName, dgst), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, keppel.ErrBackupContentNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, expectSuccess(resp, fmt.Sprintf("downloading %s/%s@%s", accountName, repoName, dgst))
+	}
+	return resp.Body, nil
+}
+
+// WriteSnapshot implements the keppel.BackupDriver interface.
+func (d *backupDriver) WriteSnapshot(ctx context.Context, name string, sizeBytes uint64, contents io.Reader) error {
+	if sizeBytes > maxSinglePartUploadBytes {
+		return fmt.Errorf("cannot upload snapshot %s into S3: size of %d bytes exceeds the limit of %d bytes", name, sizeBytes, uint64(maxSinglePartUploadBytes))
+	}
+	resp, err := d.do(ctx, http.MethodPut, d.snapshotKey(name), nil, &requestBody{contents, sizeBytes, client.AWSUnsignedPayload})
+	if err != nil {
+		return err
+	}
+	return expectSuccess(resp, "uploading snapshot "+name)
+}
+
+// ListSnapshots implements the keppel.BackupDriver interface.
+func (d *backupDriver) ListSnapshots(ctx context.Context) ([]string, error) {
+	prefix := d.snapshotKey("")
+	var (
+		names             []string
+		continuationToken string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		resp, err := d.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		respBytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("while listing snapshots in S3: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("while listing snapshots in S3: expected 200 OK, but got %s: %s", resp.Status, string(respBytes))
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.Unmarshal(respBytes, &result)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing snapshot list from S3: %w", err)
+		}
+		for _, obj := range result.Contents {
+			names = append(names, strings.TrimPrefix(obj.Key, prefix))
+		}
+		if !result.IsTruncated {
+			break
+		}
+		continuationToken = result.NextContinuationToken
+	}
+
+	// S3 already lists keys in lexicographical order
+	return names, nil
+}
+
+// ReadSnapshot implements the keppel.BackupDriver interface.
+func (d *backupDriver) ReadSnapshot(ctx context.Context, name string) ([]byte, error) {
+	resp, err := d.do(ctx, http.MethodGet, d.snapshotKey(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, expectSuccess(resp, "downloading snapshot "+name)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+type requestBody struct {
+	Reader      io.Reader
+	SizeBytes   uint64
+	PayloadHash string
+}
+
+// do sends a signed request for the given object key (or for the bucket
+// itself, if `key` is empty).
+func (d *backupDriver) do(ctx context.Context, method, key string, query url.Values, body *requestBody) (*http.Response, error) {
+	reqURL := d.endpoint.JoinPath(d.bucket, key)
+	reqURL.RawQuery = query.Encode()
+
+	var (
+		reader      io.Reader = http.NoBody
+		payloadHash           = client.AWSPayloadHash(nil)
+	)
+	if body != nil {
+		reader = body.Reader
+		payloadHash = body.PayloadHash
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = int64(body.SizeBytes) //nolint:gosec // size is limited by maxSinglePartUploadBytes
+	}
+	d.creds.SignRequest(req, "s3", payloadHash, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("during %s %s: %w", method, reqURL.Path, err)
+	}
+	return resp, nil
+}
+
+// expectSuccess consumes the response and returns an error unless it has a 2xx status.
+func expectSuccess(resp *http.Response, action string) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("while %s in S3: expected 2xx status, but got %s", action, resp.Status)
+	}
+	return fmt.Errorf("while %s in S3: expected 2xx status, but got %s: %s", action, resp.Status, string(respBytes))
+}
@@ -0,0 +1,29 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package trivial
+
+import (
+	"context"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+type secretsDriver struct{}
+
+func init() {
+	keppel.SecretsDriverRegistry.Add(func() keppel.SecretsDriver { return secretsDriver{} })
+}
+
+// PluginTypeID implements the keppel.SecretsDriver interface.
+func (secretsDriver) PluginTypeID() string { return driverName }
+
+// Init implements the keppel.SecretsDriver interface.
+func (secretsDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	return nil
+}
+
+// ReadSecret implements the keppel.SecretsDriver interface.
+func (secretsDriver) ReadSecret(ctx context.Context, authTenantID, ref string) (string, error) {
+	return "", keppel.ErrNoSecretsDriver
+}
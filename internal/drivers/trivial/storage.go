@@ -29,6 +29,13 @@ type StorageDriver struct {
 	blobChunkCounts   map[string]uint32 // previous chunkNumber for running upload, 0 when finished (same semantics as keppel.StoredBlobInfo.ChunkCount field)
 	manifests         map[string][]byte
 	ForbidNewAccounts bool
+	ChunkLimits       keppel.ChunkSizeLimits
+	// if set, AppendToBlob() and WriteManifest() fail with keppel.ErrStorageQuotaExceeded
+	SimulateQuotaExceeded bool
+	// if set, URLForBlob() returns URLs below this base URL
+	BlobURLBase string
+	// if set, ReadBlob() calls this first (for simulating concurrent operations in tests)
+	BeforeReadBlob func()
 }
 
 // PluginTypeID implements the keppel.StorageDriver interface.
@@ -42,11 +49,17 @@ func (d *StorageDriver) Init(ad keppel.AuthDriver, cfg keppel.Configuration) err
 	return nil
 }
 
+// ChunkSizeLimits implements the keppel.StorageDriverWithChunkSizeLimits interface.
+func (d *StorageDriver) ChunkSizeLimits() keppel.ChunkSizeLimits {
+	return d.ChunkLimits
+}
+
 var (
 	errNoSuchBlob                   = errors.New("no such blob")
 	errNoSuchManifest               = errors.New("no such manifest")
 	errAppendToBlobAfterFinalize    = errors.New("AppendToBlob() was called after FinalizeBlob()")
 	errAbortBlobUploadAfterFinalize = errors.New("AbortBlobUpload() was called after FinalizeBlob()")
+	errSimulatedQuotaExceeded       = fmt.Errorf("%w: write failed as requested", keppel.ErrStorageQuotaExceeded)
 )
 
 func blobKey(account models.ReducedAccount, storageID string) string {
@@ -59,6 +72,9 @@ func manifestKey(account models.ReducedAccount, repoName string, manifestDigest
 
 // AppendToBlob implements the keppel.StorageDriver interface.
 func (d *StorageDriver) AppendToBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkNumber uint32, chunkLength *uint64, chunk io.Reader) error {
+	if d.SimulateQuotaExceeded {
+		return errSimulatedQuotaExceeded
+	}
 	k := blobKey(account, storageID)
 
 	// check that we're calling AppendToBlob() in the correct order
@@ -106,6 +122,9 @@ func (d *StorageDriver) AbortBlobUpload(ctx context.Context, account models.Redu
 
 // ReadBlob implements the keppel.StorageDriver interface.
 func (d *StorageDriver) ReadBlob(ctx context.Context, account models.ReducedAccount, storageID string) (io.ReadCloser, uint64, error) {
+	if d.BeforeReadBlob != nil {
+		d.BeforeReadBlob()
+	}
 	contents, exists := d.blobs[blobKey(account, storageID)]
 	if !exists {
 		return nil, 0, errNoSuchBlob
@@ -113,9 +132,24 @@ func (d *StorageDriver) ReadBlob(ctx context.Context, account models.ReducedAcco
 	return io.NopCloser(bytes.NewReader(contents)), uint64(len(contents)), nil
 }
 
+// ReadBlobRange implements the keppel.StorageDriver interface.
+func (d *StorageDriver) ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (io.ReadCloser, error) {
+	contents, exists := d.blobs[blobKey(account, storageID)]
+	if !exists {
+		return nil, errNoSuchBlob
+	}
+	if offset+length > uint64(len(contents)) {
+		return nil, fmt.Errorf("range %d+%d exceeds size of blob (%d bytes)", offset, length, len(contents))
+	}
+	return io.NopCloser(bytes.NewReader(contents[offset : offset+length])), nil
+}
+
 // URLForBlob implements the keppel.StorageDriver interface.
 func (d *StorageDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
-	return "", keppel.ErrCannotGenerateURL
+	if d.BlobURLBase == "" {
+		return "", keppel.ErrCannotGenerateURL
+	}
+	return fmt.Sprintf("%s/%s?signature=dummy", d.BlobURLBase, blobKey(account, storageID)), nil
 }
 
 // DeleteBlob implements the keppel.StorageDriver interface.
@@ -142,6 +176,9 @@ func (d *StorageDriver) ReadManifest(ctx context.Context, account models.Reduced
 
 // WriteManifest implements the keppel.StorageDriver interface.
 func (d *StorageDriver) WriteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest, contents []byte) error {
+	if d.SimulateQuotaExceeded {
+		return errSimulatedQuotaExceeded
+	}
 	k := manifestKey(account, repoName, manifestDigest)
 	d.manifests[k] = contents
 	return nil
@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sapcc/go-bits/osext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+type secretsDriver struct {
+	address          *url.URL
+	token            string
+	mountPath        string
+	namespace        string
+	tenantPathPrefix string
+	client           *http.Client
+}
+
+func init() {
+	keppel.SecretsDriverRegistry.Add(func() keppel.SecretsDriver { return &secretsDriver{} })
+}
+
+// PluginTypeID implements the keppel.SecretsDriver interface.
+func (d *secretsDriver) PluginTypeID() string { return "vault" }
+
+// Init implements the keppel.SecretsDriver interface.
+func (d *secretsDriver) Init(ctx context.Context, cfg keppel.Configuration) (err error) {
+	d.address, err = url.Parse(osext.MustGetenv("KEPPEL_SECRETS_VAULT_ADDR"))
+	if err != nil {
+		return fmt.Errorf("malformed KEPPEL_SECRETS_VAULT_ADDR: %w", err)
+	}
+	d.token = osext.MustGetenv("KEPPEL_SECRETS_VAULT_TOKEN")
+	d.mountPath = strings.Trim(osext.GetenvOrDefault("KEPPEL_SECRETS_VAULT_KV_MOUNT", "secret"), "/")
+	d.namespace = osext.GetenvOrDefault("KEPPEL_SECRETS_VAULT_NAMESPACE", "")
+	d.tenantPathPrefix = strings.Trim(osext.GetenvOrDefault("KEPPEL_SECRETS_VAULT_TENANT_PATH_PREFIX", "keppel/tenants"), "/")
+	d.client = &http.Client{Timeout: 30 * time.Second}
+	return nil
+}
+
+// ReadSecret implements the keppel.SecretsDriver interface.
+//
+// References have the form "path/to/secret#key" and refer to the given key
+// of the latest version of a secret in a KV version 2 secrets engine. The path
+// is relative to the directory "$KEPPEL_SECRETS_VAULT_TENANT_PATH_PREFIX/$AUTH_TENANT_ID"
+// within the engine, so that users can only refer to secrets of their own tenant.
+func (d *secretsDriver) ReadSecret(ctx context.Context, authTenantID, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	path = strings.Trim(path, "/")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf(`malformed secret reference %q: expected "path/to/secret#key"`, ref)
+	}
+	if !isSafePath(path) {
+		return "", fmt.Errorf(`malformed secret reference %q: path may not contain empty, "." or ".." elements`, ref)
+	}
+	if strings.Contains(authTenantID, "/") || !isSafePath(authTenantID) {
+		return "", fmt.Errorf("cannot read secrets for auth tenant %q", authTenantID)
+	}
+
+	reqURL := d.address.JoinPath("v1", d.mountPath, "data", d.tenantPathPrefix, authTenantID, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", d.token)
+	if d.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", d.namespace)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("while reading secret %q from Vault: %w", ref, err)
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("while reading secret %q from Vault: %w", ref, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errors.New("no such secret in Vault: " + ref)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("while reading secret %q from Vault: expected 200 OK, but got %s", ref, resp.Status)
+	}
+
+	var data struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(respBytes, &data)
+	if err != nil {
+		return "", fmt.Errorf("while parsing secret %q from Vault: %w", ref, err)
+	}
+	value, ok := data.Data.Data[key].(string)
+	if !ok {
+		return "", errors.New("no such secret in Vault: " + ref)
+	}
+	return value, nil
+}
+
+// Returns whether the given slash-separated path does not contain any
+// elements that could be used to escape from the tenant's directory.
+func isSafePath(path string) bool {
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return false
+		}
+	}
+	return true
+}
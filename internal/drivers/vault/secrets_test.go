@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package vault
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+func TestSecretsDriver(t *testing.T) {
+	// fake Vault with a KV version 2 engine at "kv"
+	secrets := map[string]string{
+		"/v1/kv/data/keppel/tenants/tenant1/registry/robot": `{"data":{"data":{"password":"secret1"}}}`,
+		"/v1/kv/data/keppel/tenants/tenant2/registry/robot": `{"data":{"data":{"password":"secret2"}}}`,
+		"/v1/kv/data/operator/database":                     `{"data":{"data":{"password":"topsecret"}}}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "keppel-token" || r.Header.Get("X-Vault-Namespace") != "keppel" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		payload, ok := secrets[r.URL.Path]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(payload))
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("KEPPEL_SECRETS_VAULT_ADDR", srv.URL)
+	t.Setenv("KEPPEL_SECRETS_VAULT_TOKEN", "keppel-token")
+	t.Setenv("KEPPEL_SECRETS_VAULT_KV_MOUNT", "kv")
+	t.Setenv("KEPPEL_SECRETS_VAULT_NAMESPACE", "keppel")
+	secd, err := keppel.NewSecretsDriver(t.Context(), "vault", keppel.Configuration{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// happy case: references are resolved within the tenant's directory
+	secret, err := secd.ReadSecret(t.Context(), "tenant1", "registry/robot#password")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "secret", secret, "secret1")
+	secret, err = secd.ReadSecret(t.Context(), "tenant2", "/registry/robot/#password")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "secret", secret, "secret2")
+
+	// failure cases
+	expectError := func(authTenantID, ref, expectedMessage string) {
+		t.Helper()
+		_, err := secd.ReadSecret(t.Context(), authTenantID, ref)
+		if err == nil || !strings.Contains(err.Error(), expectedMessage) {
+			t.Errorf("expected error containing %q for %q in tenant %q, but got: %v", expectedMessage, ref, authTenantID, err)
+		}
+	}
+	expectError("tenant1", "registry/robot", `expected "path/to/secret#key"`)
+	expectError("tenant1", "registry/robot#", `expected "path/to/secret#key"`)
+	expectError("tenant1", "registry/robot#username", "no such secret")
+	expectError("tenant1", "registry/other#password", "no such secret")
+	expectError("tenant3", "registry/robot#password", "no such secret")
+
+	// references cannot escape from the tenant's directory
+	expectError("tenant1", "../tenant2/registry/robot#password", "path may not contain")
+	expectError("tenant1", "../../../operator/database#password", "path may not contain")
+	expectError("tenant1", "registry//robot#password", "path may not contain")
+	expectError("..", "operator/database#password", "cannot read secrets for auth tenant")
+	expectError("", "keppel/tenants/tenant1/registry/robot#password", "cannot read secrets for auth tenant")
+	expectError("tenant1/registry", "robot#password", "cannot read secrets for auth tenant")
+}
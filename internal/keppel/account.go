@@ -9,19 +9,53 @@ import (
 
 // Account represents an account in the API.
 type Account struct {
-	Name              models.AccountName    `json:"name"`
-	AuthTenantID      string                `json:"auth_tenant_id"`
-	GCPolicies        []GCPolicy            `json:"gc_policies,omitempty"`
-	RBACPolicies      []RBACPolicy          `json:"rbac_policies"`
-	ReplicationPolicy *ReplicationPolicy    `json:"replication,omitempty"`
-	State             string                `json:"state,omitempty"`
-	ValidationPolicy  *ValidationPolicy     `json:"validation,omitempty"`
-	PlatformFilter    models.PlatformFilter `json:"platform_filter,omitempty"`
-	Metadata          *map[string]string    `json:"metadata"`
+	Name                   models.AccountName         `json:"name"`
+	AuthTenantID           string                     `json:"auth_tenant_id"`
+	AdmissionPolicies      []AdmissionPolicy          `json:"admission_policies,omitempty"`
+	ApprovalPolicy         *ApprovalPolicy            `json:"approval_policy,omitempty"`
+	TagProtectionPolicies  []TagProtectionPolicy      `json:"tag_protection_policies,omitempty"`
+	TagRetentionPolicies   []TagRetentionPolicy       `json:"tag_retention_policies,omitempty"`
+	CascadeDeleteReferrers bool                       `json:"cascade_delete_referrers,omitempty"`
+	GCPolicies             []GCPolicy                 `json:"gc_policies,omitempty"`
+	RBACPolicies           []RBACPolicy               `json:"rbac_policies"`
+	ReplicationPolicy      *ReplicationPolicy         `json:"replication,omitempty"`
+	ReplicationRetryHints  bool                       `json:"replication_retry_hints,omitempty"`
+	State                  string                     `json:"state,omitempty"`
+	Issues                 []AccountIssue             `json:"issues,omitempty"`
+	ValidationPolicy       *ValidationPolicy          `json:"validation,omitempty"`
+	PlatformFilter         models.PlatformFilter      `json:"platform_filter,omitempty"`
+	DefaultPlatform        string                     `json:"default_platform,omitempty"`
+	CustomDomain           *CustomDomain              `json:"custom_domain,omitempty"`
+	EncryptionKeyRef       string                     `json:"content_encryption_key_ref,omitempty"`
+	ServeBlobsViaCDN       bool                       `json:"serve_blobs_via_cdn,omitempty"`
+	ShareBlobs             bool                       `json:"share_blobs,omitempty"`
+	LazyPullFormat         models.LazyPullFormat      `json:"lazy_pull_format,omitempty"`
+	RecompressionFormat    models.RecompressionFormat `json:"recompression_format,omitempty"`
+	StoragePlacement       []StoragePlacementRule     `json:"storage_placement,omitempty"`
+	ResponseHeaders        map[string]string          `json:"response_headers,omitempty"`
+	PullTerms              *PullTerms                 `json:"pull_terms,omitempty"`
+	MinPullPromotionState  models.PromotionState      `json:"min_pull_promotion_state,omitempty"`
+	Metadata               *map[string]string         `json:"metadata"`
 }
 
 // RenderAccount converts an account model from the DB into the API representation.
 func RenderAccount(dbAccount models.Account) (Account, error) {
+	admissionPolicies, err := ParseAdmissionPolicies(dbAccount.Reduced())
+	if err != nil {
+		return Account{}, err
+	}
+	approvalPolicy, err := ParseApprovalPolicy(dbAccount.Reduced())
+	if err != nil {
+		return Account{}, err
+	}
+	tagProtectionPolicies, err := ParseTagProtectionPolicies(dbAccount.Reduced())
+	if err != nil {
+		return Account{}, err
+	}
+	tagRetentionPolicies, err := ParseTagRetentionPolicies(dbAccount)
+	if err != nil {
+		return Account{}, err
+	}
 	gcPolicies, err := ParseGCPolicies(dbAccount)
 	if err != nil {
 		return Account{}, err
@@ -34,19 +68,56 @@ func RenderAccount(dbAccount models.Account) (Account, error) {
 		// do not render "null" in this field
 		rbacPolicies = []RBACPolicy{}
 	}
+	responseHeaders, err := ParseResponseHeaders(dbAccount.Reduced())
+	if err != nil {
+		return Account{}, err
+	}
+	storagePlacement, err := ParseStoragePlacementRules(dbAccount.Reduced())
+	if err != nil {
+		return Account{}, err
+	}
+	var metadata *map[string]string
+	metadataMap, err := ParseAccountMetadata(dbAccount)
+	if err != nil {
+		return Account{}, err
+	}
+	if len(metadataMap) > 0 {
+		metadata = &metadataMap
+	}
 	var state string
-	if dbAccount.IsDeleting {
+	switch {
+	case dbAccount.IsDeleting:
 		state = "deleting"
+	case dbAccount.ReplicationPausedAt != nil:
+		state = "replication_paused"
 	}
 
 	return Account{
-		Name:              dbAccount.Name,
-		AuthTenantID:      dbAccount.AuthTenantID,
-		GCPolicies:        gcPolicies,
-		State:             state,
-		RBACPolicies:      rbacPolicies,
-		ReplicationPolicy: RenderReplicationPolicy(dbAccount),
-		ValidationPolicy:  RenderValidationPolicy(dbAccount.Reduced()),
-		PlatformFilter:    dbAccount.PlatformFilter,
+		Name:                   dbAccount.Name,
+		AuthTenantID:           dbAccount.AuthTenantID,
+		AdmissionPolicies:      admissionPolicies,
+		ApprovalPolicy:         approvalPolicy,
+		TagProtectionPolicies:  tagProtectionPolicies,
+		TagRetentionPolicies:   tagRetentionPolicies,
+		CascadeDeleteReferrers: dbAccount.CascadeDeleteReferrers,
+		GCPolicies:             gcPolicies,
+		State:                  state,
+		RBACPolicies:           rbacPolicies,
+		ReplicationPolicy:      RenderReplicationPolicy(dbAccount),
+		ReplicationRetryHints:  dbAccount.ReplicationRetryHints,
+		ValidationPolicy:       RenderValidationPolicy(dbAccount.Reduced()),
+		PlatformFilter:         dbAccount.PlatformFilter,
+		DefaultPlatform:        dbAccount.DefaultPlatform,
+		CustomDomain:           RenderCustomDomain(dbAccount),
+		EncryptionKeyRef:       dbAccount.ContentEncryptionKeyRef,
+		ServeBlobsViaCDN:       dbAccount.ServeBlobsViaCDN,
+		ShareBlobs:             dbAccount.ShareBlobs,
+		LazyPullFormat:         dbAccount.LazyPullFormat,
+		RecompressionFormat:    dbAccount.RecompressionFormat,
+		StoragePlacement:       storagePlacement,
+		ResponseHeaders:        responseHeaders,
+		PullTerms:              RenderPullTerms(dbAccount.Reduced()),
+		MinPullPromotionState:  dbAccount.MinPullPromotionState,
+		Metadata:               metadata,
 	}, nil
 }
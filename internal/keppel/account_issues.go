@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// AccountIssueType is the closed set of values for AccountIssue.Type.
+type AccountIssueType string
+
+// Possible values for AccountIssueType.
+const (
+	AccountIssueReplicationPaused        AccountIssueType = "replication_paused"
+	AccountIssueManifestSyncFailing      AccountIssueType = "manifest_sync_failing"
+	AccountIssueGarbageCollectionFailing AccountIssueType = "garbage_collection_failing"
+	AccountIssueStorageQuotaExceeded     AccountIssueType = "storage_quota_exceeded"
+)
+
+// AccountIssue describes a problem with the background processing of an
+// account that requires attention by the account's owner. It appears in the
+// API representation of the account.
+type AccountIssue struct {
+	Type        AccountIssueType `json:"type"`
+	Reason      string           `json:"reason"`
+	Remediation string           `json:"remediation"`
+}
+
+var accountIssueRemediations = map[AccountIssueType]string{
+	AccountIssueReplicationPaused: "Check that the upstream registry is reachable and that the replication credentials of this account are valid," +
+		" then resume replication with POST /keppel/v1/accounts/:name/replication_health/resume.",
+	AccountIssueManifestSyncFailing: "Check that the upstream registry is reachable and that the replication credentials of this account are valid." +
+		" The manifest sync is retried automatically.",
+	AccountIssueGarbageCollectionFailing: "Check the GC policies of this account." +
+		" Garbage collection is retried automatically.",
+	AccountIssueStorageQuotaExceeded: "Delete unused images from this account or ask the operator of this registry to increase the quota of the storage backend." +
+		" This issue is cleared when the next push succeeds.",
+}
+
+var repoErrorMessagesQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM repos
+	 WHERE account_name = $1 AND (manifest_sync_error_message != '' OR gc_error_message != '')
+	 ORDER BY name
+`)
+
+// FindAccountIssues collects all AccountIssues that currently apply to the
+// given account. If the account has no issues, an empty list is returned.
+func FindAccountIssues(db gorp.SqlExecutor, account models.Account) ([]AccountIssue, error) {
+	var issues []AccountIssue
+	if account.ReplicationPausedAt != nil {
+		reason := fmt.Sprintf("replication from upstream has been paused since %s because of too many failed replications",
+			account.ReplicationPausedAt.UTC().Format(time.RFC3339))
+		issues = append(issues, newAccountIssue(AccountIssueReplicationPaused, reason))
+	}
+	if account.StorageQuotaExceededAt != nil {
+		reason := fmt.Sprintf("pushes have been failing since %s because the storage backend reported that its quota was exceeded",
+			account.StorageQuotaExceededAt.UTC().Format(time.RFC3339))
+		issues = append(issues, newAccountIssue(AccountIssueStorageQuotaExceeded, reason))
+	}
+
+	var repos []models.Repository
+	_, err := db.Select(&repos, repoErrorMessagesQuery, account.Name)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		syncFailures []string
+		gcFailures   []string
+	)
+	for _, repo := range repos {
+		if repo.ManifestSyncErrorMessage != "" {
+			syncFailures = append(syncFailures, fmt.Sprintf("manifest sync failed in repository %q: %s", repo.Name, repo.ManifestSyncErrorMessage))
+		}
+		if repo.GCErrorMessage != "" {
+			gcFailures = append(gcFailures, fmt.Sprintf("garbage collection failed in repository %q: %s", repo.Name, repo.GCErrorMessage))
+		}
+	}
+
+	if len(syncFailures) > 0 {
+		issues = append(issues, newAccountIssue(AccountIssueManifestSyncFailing, summarizeRepoFailures(syncFailures)))
+	}
+	if len(gcFailures) > 0 {
+		issues = append(issues, newAccountIssue(AccountIssueGarbageCollectionFailing, summarizeRepoFailures(gcFailures)))
+	}
+	return issues, nil
+}
+
+func newAccountIssue(issueType AccountIssueType, reason string) AccountIssue {
+	return AccountIssue{
+		Type:        issueType,
+		Reason:      reason,
+		Remediation: accountIssueRemediations[issueType],
+	}
+}
+
+// To keep the account representation compact, only the first failure is
+// reported verbatim when multiple repos are affected.
+func summarizeRepoFailures(failures []string) string {
+	if len(failures) == 1 {
+		return failures[0]
+	}
+	return fmt.Sprintf("%s (and in %d more repositories)", failures[0], len(failures)-1)
+}
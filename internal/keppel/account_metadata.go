@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// MaxAccountMetadataValueLength is the maximum length (in bytes) of a single
+// value in the metadata of an account.
+const MaxAccountMetadataValueLength = 256
+
+var accountMetadataFieldNameRx = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// AccountMetadataSchema describes the custom metadata fields that the
+// operator requires on accounts (e.g. cost center or owning team). It is read
+// from the file at $KEPPEL_ACCOUNT_METADATA_SCHEMA_PATH.
+type AccountMetadataSchema struct {
+	Fields []AccountMetadataField `json:"fields"`
+}
+
+// AccountMetadataField appears in type AccountMetadataSchema.
+type AccountMetadataField struct {
+	Name          string   `json:"name"`
+	Description   string   `json:"description,omitempty"`
+	Required      bool     `json:"required,omitempty"`
+	Pattern       string   `json:"pattern,omitempty"`
+	AllowedValues []string `json:"allowed_values,omitempty"`
+
+	patternRx *regexp.Regexp
+}
+
+// ParseAccountMetadataSchema parses and validates the contents of the file at
+// $KEPPEL_ACCOUNT_METADATA_SCHEMA_PATH.
+func ParseAccountMetadataSchema(buf []byte) (AccountMetadataSchema, error) {
+	var schema AccountMetadataSchema
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&schema)
+	if err != nil {
+		return AccountMetadataSchema{}, err
+	}
+
+	isFieldName := make(map[string]bool, len(schema.Fields))
+	for idx, field := range schema.Fields {
+		if !accountMetadataFieldNameRx.MatchString(field.Name) {
+			return AccountMetadataSchema{}, fmt.Errorf("invalid field name: %q (must consist of lowercase letters, digits and underscores)", field.Name)
+		}
+		if isFieldName[field.Name] {
+			return AccountMetadataSchema{}, fmt.Errorf("duplicate field name: %q", field.Name)
+		}
+		isFieldName[field.Name] = true
+
+		if field.Pattern != "" {
+			schema.Fields[idx].patternRx, err = regexp.Compile(`^(?:` + field.Pattern + `)$`)
+			if err != nil {
+				return AccountMetadataSchema{}, fmt.Errorf("invalid pattern for field %q: %w", field.Name, err)
+			}
+		}
+	}
+	return schema, nil
+}
+
+func mayGetenvAccountMetadataSchema(key string) (AccountMetadataSchema, error) {
+	path := os.Getenv(key)
+	if path == "" {
+		return AccountMetadataSchema{}, nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return AccountMetadataSchema{}, err
+	}
+	return ParseAccountMetadataSchema(buf)
+}
+
+// Validate checks the given account metadata against this schema.
+func (s AccountMetadataSchema) Validate(metadata map[string]string) error {
+	return s.validate(metadata, true)
+}
+
+// If checkRequired is false, missing values for required fields are accepted.
+func (s AccountMetadataSchema) validate(metadata map[string]string, checkRequired bool) error {
+	// check fields in a deterministic order to get reproducible error messages
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		if !slices.ContainsFunc(s.Fields, func(f AccountMetadataField) bool { return f.Name == key }) {
+			return fmt.Errorf("unknown account metadata field: %q", key)
+		}
+	}
+
+	for _, field := range s.Fields {
+		value, exists := metadata[field.Name]
+		if !exists || value == "" {
+			if field.Required && checkRequired {
+				return fmt.Errorf("missing value for required account metadata field %q", field.Name)
+			}
+			continue
+		}
+		if len(value) > MaxAccountMetadataValueLength {
+			return fmt.Errorf("value for account metadata field %q is too long (max. %d bytes)", field.Name, MaxAccountMetadataValueLength)
+		}
+		if field.patternRx != nil && !field.patternRx.MatchString(value) {
+			return fmt.Errorf("value for account metadata field %q does not match the pattern %q", field.Name, field.Pattern)
+		}
+		if len(field.AllowedValues) > 0 && !slices.Contains(field.AllowedValues, value) {
+			return fmt.Errorf("value for account metadata field %q must be one of: %q", field.Name, field.AllowedValues)
+		}
+	}
+	return nil
+}
+
+// ParseAccountMetadata parses the custom metadata of the given account.
+func ParseAccountMetadata(account models.Account) (map[string]string, error) {
+	if account.MetadataJSON == "" {
+		return nil, nil
+	}
+	var metadata map[string]string
+	err := json.Unmarshal([]byte(account.MetadataJSON), &metadata)
+	return metadata, err
+}
+
+// ApplyAccountMetadataToAccount validates the given custom metadata against
+// the given schema and stores it in the given account model.
+//
+// Required fields are only enforced for new accounts and when the metadata of
+// an existing account is changed. Otherwise, adding a required field to the
+// schema would block all updates to existing accounts (including managed
+// accounts and replica accounts) until their metadata is filled in.
+func ApplyAccountMetadataToAccount(metadata map[string]string, schema AccountMetadataSchema, account *models.Account, isNewAccount bool) *RegistryV2Error {
+	// empty values are equivalent to missing values
+	cleanMetadata := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		if value != "" {
+			cleanMetadata[key] = value
+		}
+	}
+
+	previousMetadata, err := ParseAccountMetadata(*account)
+	if err != nil {
+		return AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+	}
+	checkRequired := isNewAccount || !maps.Equal(cleanMetadata, previousMetadata)
+	err = schema.validate(metadata, checkRequired)
+	if err != nil {
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+
+	if len(cleanMetadata) == 0 {
+		account.MetadataJSON = ""
+		return nil
+	}
+	buf, err := json.Marshal(cleanMetadata)
+	if err != nil {
+		return AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+	}
+	account.MetadataJSON = string(buf)
+	return nil
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"testing"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestParseAccountMetadataSchemaErrors(t *testing.T) {
+	testCases := map[string]string{
+		`{"fields":[{"name":"CostCenter"}]}`:                    `invalid field name: "CostCenter" (must consist of lowercase letters, digits and underscores)`,
+		`{"fields":[{"name":"owner"},{"name":"owner"}]}`:        `duplicate field name: "owner"`,
+		`{"fields":[{"name":"owner","pattern":"team-("}]}`:      "invalid pattern for field \"owner\": error parsing regexp: missing closing ): `^(?:team-()$`",
+		`{"fields":[{"name":"owner","is_required":true}]}`:      `json: unknown field "is_required"`,
+		`{"fields":[{"name":"owner","pattern":"team-.*"}]}`:     ``,
+		`{"fields":[{"name":"cost_center_2","required":true}]}`: ``,
+	}
+	for input, expectedError := range testCases {
+		_, err := ParseAccountMetadataSchema([]byte(input))
+		actualError := ""
+		if err != nil {
+			actualError = err.Error()
+		}
+		if actualError != expectedError {
+			t.Errorf("while parsing %s: expected error %q, but got %q", input, expectedError, actualError)
+		}
+	}
+}
+
+func TestApplyAccountMetadataToAccount(t *testing.T) {
+	schema, err := ParseAccountMetadataSchema([]byte(`{"fields":[{"name":"cost_center","required":true},{"name":"owner"}]}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	testCases := []struct {
+		PreviousMetadataJSON string
+		IsNewAccount         bool
+		Metadata             map[string]string
+		ExpectedError        string
+	}{
+		// required fields are enforced for new accounts
+		{"", true, nil, `missing value for required account metadata field "cost_center"`},
+		{"", true, map[string]string{"cost_center": "123"}, ``},
+		// existing accounts that predate the required field can be updated without touching their metadata
+		{"", false, nil, ``},
+		{`{"owner":"team-a"}`, false, map[string]string{"owner": "team-a", "cost_center": ""}, ``},
+		// but not when their metadata changes
+		{"", false, map[string]string{"owner": "team-b"}, `missing value for required account metadata field "cost_center"`},
+		{`{"owner":"team-a"}`, false, map[string]string{"owner": "team-b"}, `missing value for required account metadata field "cost_center"`},
+		// all other checks always apply
+		{"", false, map[string]string{"color": "blue"}, `unknown account metadata field: "color"`},
+	}
+	for idx, tc := range testCases {
+		account := models.Account{MetadataJSON: tc.PreviousMetadataJSON}
+		rerr := ApplyAccountMetadataToAccount(tc.Metadata, schema, &account, tc.IsNewAccount)
+		actualError := ""
+		if rerr != nil {
+			actualError = rerr.Error()
+		}
+		if actualError != tc.ExpectedError {
+			t.Errorf("in test case %d: expected error %q, but got %q", idx, tc.ExpectedError, actualError)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/lib/pq"
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// AccountChangeChannel is the Postgres notification channel on which
+// NotifyAccountChanged() announces changes to the configuration of accounts.
+const AccountChangeChannel = "keppel_account_changed"
+
+// NotifyAccountChanged announces to all keppel-api replicas that the
+// configuration of the given account has changed, so that they can drop any
+// cached data derived from it. If `db` is a transaction, Postgres delivers the
+// notification only once the transaction is committed.
+//
+// Caches in the current process are invalidated immediately.
+func NotifyAccountChanged(db gorp.SqlExecutor, name models.AccountName) error {
+	InvalidateCustomDomainCache()
+	InvalidateRBACPolicyEpochCache()
+	_, err := db.Exec(`SELECT pg_notify($1, $2)`, AccountChangeChannel, string(name))
+	return err
+}
+
+// ListenForAccountChanges listens for notifications sent by
+// NotifyAccountChanged() until `ctx` expires. For each notification,
+// `onChange` is called with the name of the changed account.
+//
+// When the listener connection is lost, notifications sent in the meantime are
+// lost as well. Therefore, after each reconnect, `onChange` is called with an
+// empty account name to indicate that all cached data shall be discarded.
+func ListenForAccountChanges(ctx context.Context, dbURL url.URL, onChange func(models.AccountName)) error {
+	listener := pq.NewListener(dbURL.String(), 100*time.Millisecond, 10*time.Second, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			logg.Error("connection for LISTEN %s failed: %s", AccountChangeChannel, err.Error())
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(AccountChangeChannel)
+	if err != nil {
+		return err
+	}
+	processAccountChanges(ctx, listener, onChange)
+	return nil
+}
+
+// accountChangeListener is the subset of *pq.Listener that
+// processAccountChanges() needs. It is an interface to allow for unit tests
+// without a database.
+type accountChangeListener interface {
+	NotificationChannel() <-chan *pq.Notification
+	Ping() error
+}
+
+// How often processAccountChanges() checks the listener connection. Without
+// these checks, a dead connection might go unnoticed for a long time.
+var accountChangeListenerPingInterval = 90 * time.Second
+
+func processAccountChanges(ctx context.Context, listener accountChangeListener, onChange func(models.AccountName)) {
+	// NOTE: This uses a ticker instead of time.After() in the loop, since the
+	// latter would never fire while notifications arrive more frequently than
+	// the ping interval.
+	ticker := time.NewTicker(accountChangeListenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.NotificationChannel():
+			// a nil notification is delivered after the connection was re-established
+			if n == nil {
+				onChange("")
+			} else {
+				onChange(models.AccountName(n.Extra))
+			}
+		case <-ticker.C:
+			go func() {
+				err := listener.Ping()
+				if err != nil {
+					logg.Error("connection for LISTEN %s is broken: %s", AccountChangeChannel, err.Error())
+				}
+			}()
+		}
+	}
+}
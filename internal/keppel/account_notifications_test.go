@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/lib/pq"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+type fakeAccountChangeListener struct {
+	notifications chan *pq.Notification
+	pingCount     atomic.Int64
+	pingError     error
+}
+
+func (l *fakeAccountChangeListener) NotificationChannel() <-chan *pq.Notification {
+	return l.notifications
+}
+
+func (l *fakeAccountChangeListener) Ping() error {
+	l.pingCount.Add(1)
+	return l.pingError
+}
+
+func TestProcessAccountChanges(t *testing.T) {
+	listener := &fakeAccountChangeListener{notifications: make(chan *pq.Notification)}
+	changes := make(chan models.AccountName)
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		processAccountChanges(ctx, listener, func(name models.AccountName) { changes <- name })
+		close(done)
+	}()
+
+	// notifications are forwarded with the account name from their payload
+	listener.notifications <- &pq.Notification{Channel: AccountChangeChannel, Extra: "test1"}
+	expectAccountChange(t, changes, "test1")
+	listener.notifications <- &pq.Notification{Channel: AccountChangeChannel, Extra: "test2"}
+	expectAccountChange(t, changes, "test2")
+
+	// after a reconnect (signaled by a nil notification), notifications may
+	// have been missed, so all accounts are reported as changed
+	listener.notifications <- nil
+	expectAccountChange(t, changes, "")
+
+	// the loop ends when the context expires
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("processAccountChanges() did not return after the context was canceled")
+	}
+}
+
+func TestProcessAccountChangesPingsWhileBusy(t *testing.T) {
+	defer func(d time.Duration) { accountChangeListenerPingInterval = d }(accountChangeListenerPingInterval)
+	accountChangeListenerPingInterval = 20 * time.Millisecond
+
+	listener := &fakeAccountChangeListener{
+		notifications: make(chan *pq.Notification),
+		pingError:     errors.New("connection reset by peer"), // is only logged
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go processAccountChanges(ctx, listener, func(models.AccountName) {})
+
+	// the connection is checked periodically even if notifications arrive more
+	// often than the ping interval
+	deadline := time.Now().Add(5 * time.Second)
+	for listener.pingCount.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected at least 3 pings, but got %d", listener.pingCount.Load())
+		}
+		listener.notifications <- &pq.Notification{Channel: AccountChangeChannel, Extra: "test1"}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func expectAccountChange(t *testing.T, changes <-chan models.AccountName, expected models.AccountName) {
+	t.Helper()
+	select {
+	case actual := <-changes:
+		if actual != expected {
+			t.Errorf("expected change notification for account %q, but got %q", expected, actual)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected change notification for account %q, but got nothing", expected)
+	}
+}
+
+// recordingExecutor implements the Exec() method of gorp.SqlExecutor by
+// recording the query instead of executing it.
+type recordingExecutor struct {
+	gorp.SqlExecutor
+	queries []string
+	args    [][]any
+}
+
+func (e *recordingExecutor) Exec(query string, args ...any) (sql.Result, error) {
+	e.queries = append(e.queries, query)
+	e.args = append(e.args, args)
+	return nil, nil
+}
+
+func TestNotifyAccountChanged(t *testing.T) {
+	// fill the custom domain cache of this process
+	key := customDomainLookupCacheKey{nil, "registry.example.com"}
+	customDomainLookupCacheMutex.Lock()
+	customDomainLookupCache[key] = customDomainLookupCacheEntry{
+		AccountName: "test1",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+	customDomainLookupCacheMutex.Unlock()
+
+	db := &recordingExecutor{}
+	err := NotifyAccountChanged(db, "test1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the local cache is invalidated immediately...
+	customDomainLookupCacheMutex.Lock()
+	_, isCached := customDomainLookupCache[key]
+	customDomainLookupCacheMutex.Unlock()
+	if isCached {
+		t.Error("expected custom domain cache to be invalidated")
+	}
+
+	// ...and the other replicas are notified through the database
+	if !slices.Equal(db.queries, []string{`SELECT pg_notify($1, $2)`}) {
+		t.Errorf("unexpected queries: %#v", db.queries)
+	}
+	if len(db.args) != 1 || !slices.Equal(db.args[0], []any{AccountChangeChannel, "test1"}) {
+		t.Errorf("unexpected query arguments: %#v", db.args)
+	}
+}
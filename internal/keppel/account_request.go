@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// AccountRequest represents a request for the creation of an account in the API.
+type AccountRequest struct {
+	ID             int64                      `json:"id"`
+	Account        Account                    `json:"account"`
+	State          models.AccountRequestState `json:"state"`
+	RequestedAt    int64                      `json:"requested_at"`
+	RequestedBy    string                     `json:"requested_by"`
+	DecidedAt      *int64                     `json:"decided_at,omitempty"`
+	DecidedBy      string                     `json:"decided_by,omitempty"`
+	DecisionReason string                     `json:"decision_reason,omitempty"`
+}
+
+// RenderAccountRequest converts an account request model from the DB into the API representation.
+func RenderAccountRequest(r models.AccountRequest) (AccountRequest, error) {
+	var account Account
+	err := json.Unmarshal([]byte(r.AccountJSON), &account)
+	if err != nil {
+		return AccountRequest{}, err
+	}
+	if account.RBACPolicies == nil {
+		// do not render "null" in this field (same as in RenderAccount)
+		account.RBACPolicies = []RBACPolicy{}
+	}
+	return AccountRequest{
+		ID:             r.ID,
+		Account:        account,
+		State:          r.State,
+		RequestedAt:    r.RequestedAt.Unix(),
+		RequestedBy:    r.RequestedBy,
+		DecidedAt:      MaybeTimeToUnix(r.DecidedAt),
+		DecidedBy:      r.DecidedBy,
+		DecisionReason: r.DecisionReason,
+	}, nil
+}
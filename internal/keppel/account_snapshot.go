@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// AccountSnapshotContents is the format of models.AccountSnapshot.ContentsJSON.
+type AccountSnapshotContents struct {
+	Repositories []AccountSnapshotRepository `json:"repositories"`
+}
+
+// AccountSnapshotRepository appears in type AccountSnapshotContents.
+type AccountSnapshotRepository struct {
+	Name      string               `json:"name"`
+	Manifests []digest.Digest      `json:"manifests"`
+	Tags      []AccountSnapshotTag `json:"tags"`
+}
+
+// AccountSnapshotTag appears in type AccountSnapshotRepository.
+type AccountSnapshotTag struct {
+	Name   string        `json:"name"`
+	Digest digest.Digest `json:"digest"`
+}
+
+// AccountSnapshot represents an account snapshot in the API.
+type AccountSnapshot struct {
+	ID              int64  `json:"id"`
+	CreatedAt       int64  `json:"created_at"`
+	CreatedBy       string `json:"created_by,omitempty"`
+	RepositoryCount int    `json:"repository_count"`
+	ManifestCount   int    `json:"manifest_count"`
+	TagCount        int    `json:"tag_count"`
+	// only rendered when a single snapshot is requested
+	Repositories []AccountSnapshotRepository `json:"repositories,omitempty"`
+}
+
+// RenderAccountSnapshot converts an account snapshot model from the DB into
+// the API representation. The full contents are only included if
+// `withContents` is true.
+func RenderAccountSnapshot(s models.AccountSnapshot, withContents bool) (AccountSnapshot, error) {
+	var contents AccountSnapshotContents
+	err := json.Unmarshal([]byte(s.ContentsJSON), &contents)
+	if err != nil {
+		return AccountSnapshot{}, err
+	}
+
+	result := AccountSnapshot{
+		ID:              s.ID,
+		CreatedAt:       s.CreatedAt.Unix(),
+		CreatedBy:       s.CreatedBy,
+		RepositoryCount: len(contents.Repositories),
+	}
+	for _, repo := range contents.Repositories {
+		result.ManifestCount += len(repo.Manifests)
+		result.TagCount += len(repo.Tags)
+	}
+	if withContents {
+		result.Repositories = contents.Repositories
+	}
+	return result, nil
+}
+
+// AccountSnapshotRestoreResult is returned by the API when an account snapshot is restored.
+type AccountSnapshotRestoreResult struct {
+	RestoredTags []RestoredTag `json:"restored_tags"`
+	SkippedTags  []SkippedTag  `json:"skipped_tags"`
+}
+
+// RestoredTag appears in type AccountSnapshotRestoreResult.
+type RestoredTag struct {
+	RepositoryName string        `json:"repository"`
+	Name           string        `json:"name"`
+	Digest         digest.Digest `json:"digest"`
+	// empty if the tag did not exist before the restore
+	PreviousDigest digest.Digest `json:"previous_digest,omitempty"`
+}
+
+// SkippedTag appears in type AccountSnapshotRestoreResult.
+type SkippedTag struct {
+	RepositoryName string        `json:"repository"`
+	Name           string        `json:"name"`
+	Digest         digest.Digest `json:"digest"`
+	Reason         string        `json:"reason"`
+}
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sapcc/go-bits/pluggable"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// AdmissionPolicy is a policy that is evaluated whenever a manifest is pushed
+// into an account. Unless the policy's expression evaluates to true, the
+// policy's action is taken.
+type AdmissionPolicy struct {
+	Name       string                `json:"name"`
+	Language   string                `json:"language"`
+	Expression string                `json:"expression"`
+	Message    string                `json:"message,omitempty"`
+	Action     AdmissionPolicyAction `json:"action,omitempty"`
+}
+
+// AdmissionPolicyAction appears in type AdmissionPolicy.
+type AdmissionPolicyAction string
+
+const (
+	// AdmissionPolicyReject is the default action: The push is rejected.
+	AdmissionPolicyReject AdmissionPolicyAction = "reject"
+	// AdmissionPolicyQuarantine admits the manifest, but puts it in quarantine
+	// (see models.ManifestQuarantined).
+	AdmissionPolicyQuarantine AdmissionPolicyAction = "quarantine"
+)
+
+// AdmissionPolicyLanguage is the interface for a language in which the
+// expressions of admission policies can be written. Languages are identified
+// by their PluginTypeID, which appears in the "language" field of type
+// AdmissionPolicy.
+type AdmissionPolicyLanguage interface {
+	pluggable.Plugin
+	// Compile parses the given expression. Errors returned by this method are
+	// shown to the user when the policy is configured.
+	Compile(expression string) (AdmissionPolicyProgram, error)
+}
+
+// AdmissionPolicyProgram is a compiled expression, as returned by
+// AdmissionPolicyLanguage.Compile().
+type AdmissionPolicyProgram interface {
+	// Evaluate returns whether the manifest described by the given input shall
+	// be admitted.
+	Evaluate(input AdmissionPolicyInput) (bool, error)
+}
+
+// AdmissionPolicyLanguageRegistry is a pluggable.Registry for AdmissionPolicyLanguage implementations.
+var AdmissionPolicyLanguageRegistry pluggable.Registry[AdmissionPolicyLanguage]
+
+// AdmissionPolicyInput contains all the information about a manifest push that
+// admission policies can refer to.
+type AdmissionPolicyInput struct {
+	Account        models.ReducedAccount
+	RepositoryName string
+	Manifest       models.Manifest
+	// For list manifests, Labels contains all the labels that the submanifests agree on.
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// Variables renders this input into the set of variables that policy
+// expressions can refer to.
+func (i AdmissionPolicyInput) Variables() map[string]any {
+	return map[string]any{
+		"account": map[string]any{
+			"name":           string(i.Account.Name),
+			"auth_tenant_id": i.Account.AuthTenantID,
+			"is_replica":     i.Account.UpstreamPeerHostName != "" || i.Account.ExternalPeerURL != "",
+		},
+		"repository": map[string]any{
+			"name": i.RepositoryName,
+		},
+		"manifest": map[string]any{
+			"digest":        i.Manifest.Digest.String(),
+			"media_type":    i.Manifest.MediaType,
+			"artifact_type": i.Manifest.ArtifactType,
+			"size_bytes":    int64(i.Manifest.SizeBytes), //nolint:gosec // manifest sizes do not come anywhere close to overflowing int64
+		},
+		"labels":      stringMapToVariable(i.Labels),
+		"annotations": stringMapToVariable(i.Annotations),
+	}
+}
+
+func stringMapToVariable(in map[string]string) map[string]any {
+	result := make(map[string]any, len(in))
+	for k, v := range in {
+		result[k] = v
+	}
+	return result
+}
+
+// Compile validates this policy and compiles its expression.
+func (p AdmissionPolicy) Compile() (AdmissionPolicyProgram, error) {
+	if p.Name == "" {
+		return nil, errors.New(`admission policy must have the "name" attribute`)
+	}
+	if p.Expression == "" {
+		return nil, fmt.Errorf(`admission policy %q must have the "expression" attribute`, p.Name)
+	}
+	if len(p.Message) > 1024 {
+		return nil, fmt.Errorf(`message of admission policy %q cannot be larger than 1 KiB`, p.Name)
+	}
+	switch p.Action {
+	case "", AdmissionPolicyReject, AdmissionPolicyQuarantine:
+		// acceptable
+	default:
+		return nil, fmt.Errorf(`admission policy %q has invalid action %q`, p.Name, p.Action)
+	}
+
+	lang := AdmissionPolicyLanguageRegistry.Instantiate(p.Language)
+	if lang == nil {
+		return nil, fmt.Errorf(`admission policy %q has unsupported language %q`, p.Name, p.Language)
+	}
+	prog, err := lang.Compile(p.Expression)
+	if err != nil {
+		return nil, fmt.Errorf(`cannot compile expression of admission policy %q: %w`, p.Name, err)
+	}
+	return prog, nil
+}
+
+// ParseAdmissionPolicies parses the admission policies for the given account.
+func ParseAdmissionPolicies(account models.ReducedAccount) ([]AdmissionPolicy, error) {
+	if account.AdmissionPoliciesJSON == "" || account.AdmissionPoliciesJSON == "[]" {
+		return nil, nil
+	}
+	var policies []AdmissionPolicy
+	err := json.Unmarshal([]byte(account.AdmissionPoliciesJSON), &policies)
+	return policies, err
+}
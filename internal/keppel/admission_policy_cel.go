@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+)
+
+// This file implements the AdmissionPolicyLanguage "cel" using the reference
+// implementation of the Common Expression Language (CEL). Expressions are
+// type-checked against the variables from AdmissionPolicyInput.Variables()
+// when the policy is configured.
+
+func init() {
+	AdmissionPolicyLanguageRegistry.Add(func() AdmissionPolicyLanguage { return celLanguage{} })
+}
+
+type celLanguage struct{}
+
+// PluginTypeID implements the AdmissionPolicyLanguage interface.
+func (celLanguage) PluginTypeID() string { return "cel" }
+
+const (
+	// Limits on the size and complexity of expressions. These keep parsing,
+	// type-checking and evaluation (which recurse along the syntax tree) bounded.
+	// The nesting depth is enforced by the parser, which counts parentheses,
+	// brackets and function calls, but not chains of "&&" and "||".
+	celMaxExpressionLength = 4096
+	celMaxNestingDepth     = 32
+	// Limits the runtime cost of a single evaluation (see cel.CostLimit), since
+	// macros like all() and exists() iterate over lists and maps.
+	celMaxEvaluationCost = 100000
+
+	// Compiled programs are cached since the same expressions are compiled on
+	// every manifest push into an account with admission policies.
+	celProgramCacheMaxSize = 1000
+)
+
+var (
+	celProgramCacheMutex sync.Mutex
+	celProgramCache      = make(map[string]celProgram)
+)
+
+var celEnvironment = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("account", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("repository", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("manifest", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("labels", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("annotations", cel.MapType(cel.StringType, cel.StringType)),
+		cel.ParserRecursionLimit(celMaxNestingDepth),
+	)
+})
+
+// Compile implements the AdmissionPolicyLanguage interface.
+func (celLanguage) Compile(expression string) (AdmissionPolicyProgram, error) {
+	celProgramCacheMutex.Lock()
+	prog, ok := celProgramCache[expression]
+	celProgramCacheMutex.Unlock()
+	if ok {
+		return prog, nil
+	}
+
+	prog, err := celCompile(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	celProgramCacheMutex.Lock()
+	defer celProgramCacheMutex.Unlock()
+	if len(celProgramCache) >= celProgramCacheMaxSize {
+		clear(celProgramCache)
+	}
+	celProgramCache[expression] = prog
+	return prog, nil
+}
+
+func celCompile(expression string) (celProgram, error) {
+	if len(expression) > celMaxExpressionLength {
+		return celProgram{}, fmt.Errorf("expression may not be longer than %d bytes", celMaxExpressionLength)
+	}
+	env, err := celEnvironment()
+	if err != nil {
+		return celProgram{}, err
+	}
+
+	ast, issues := env.Parse(expression)
+	if issues.Err() != nil {
+		return celProgram{}, celFormatIssues(issues)
+	}
+	ast, issues = env.Check(ast)
+	if issues.Err() != nil {
+		return celProgram{}, celFormatIssues(issues)
+	}
+	if outputType := ast.OutputType(); !outputType.IsExactType(cel.BoolType) && !outputType.IsExactType(cel.DynType) {
+		return celProgram{}, fmt.Errorf("expected expression to evaluate to bool, but got %s", outputType)
+	}
+
+	program, err := env.Program(ast,
+		cel.EvalOptions(cel.OptOptimize),
+		cel.CostLimit(celMaxEvaluationCost),
+	)
+	if err != nil {
+		return celProgram{}, err
+	}
+	return celProgram{program}, nil
+}
+
+// Renders compile errors with their positions, but without the source excerpts
+// that issues.Err() includes, since those are hard to read in API responses.
+func celFormatIssues(issues *cel.Issues) error {
+	msgs := make([]string, len(issues.Errors()))
+	for idx, issue := range issues.Errors() {
+		msgs[idx] = fmt.Sprintf("%s at position %d", issue.Message, issue.Location.Column())
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+type celProgram struct {
+	program cel.Program
+}
+
+// Evaluate implements the AdmissionPolicyProgram interface.
+func (p celProgram) Evaluate(input AdmissionPolicyInput) (bool, error) {
+	result, _, err := p.program.Eval(input.Variables())
+	if err != nil {
+		return false, err
+	}
+	b, ok := result.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expected expression to evaluate to bool, but got %s", result.Type().TypeName())
+	}
+	return b, nil
+}
@@ -0,0 +1,224 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestAdmissionPolicyEvaluation(t *testing.T) {
+	input := AdmissionPolicyInput{
+		Account: models.ReducedAccount{
+			Name:         "test1",
+			AuthTenantID: "tenant1",
+		},
+		RepositoryName: "team/foo",
+		Manifest: models.Manifest{
+			Digest:    digest.FromString("manifest"),
+			MediaType: "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes: 1024,
+		},
+		Labels:      map[string]string{"maintainer": "someone@example.org"},
+		Annotations: map[string]string{"org.opencontainers.image.source": "https://example.org/foo.git"},
+	}
+
+	testCases := []struct {
+		Expression string
+		Result     bool
+		Error      string
+	}{
+		{`has(labels.maintainer)`, true, ""},
+		{`has(labels.team)`, false, ""},
+		{`"maintainer" in labels && !("team" in labels)`, true, ""},
+		{`labels["maintainer"].endsWith("@example.org")`, true, ""},
+		{`annotations["org.opencontainers.image.source"].matches("^https://example\\.org/")`, true, ""},
+		{`repository.name.startsWith("team/") || account.is_replica`, true, ""},
+		{`account.auth_tenant_id in ["tenant1", "tenant2"]`, true, ""},
+		{`manifest.size_bytes <= 512 || size(labels) > 1`, false, ""},
+		{`manifest.media_type.contains("oci") && -manifest.size_bytes < 0`, true, ""},
+		{`false && labels.team == "foo"`, false, ""},
+		{`labels.team == "foo"`, false, `no such key: team`},
+		// literals and operators
+		{`1 < 2 && 2 <= 2 && 3 > 2 && 3 >= 3 && 1 != 2`, true, ""},
+		{`"abc" < "abd" && "b" > "a"`, true, ""},
+		{`-(-1) == 1 && !!true`, true, ""},
+		{`'single' == "single" && "a\"b".contains("\"")`, true, ""},
+		{`[1, 2, 3][1] == 2 && size([]) == 0 && 2 in [1, 2]`, true, ""},
+		{`[1, "two", true] == [1, "two", true] && [1] != [2]`, true, ""},
+		{`{"maintainer": "someone@example.org"} == labels`, true, ""},
+		// logical operators ignore errors in one operand if the other operand
+		// determines the result
+		{`true || labels.team == "foo"`, true, ""},
+		{`labels.team == "foo" || true`, true, ""},
+		{`labels.team == "foo" && false`, false, ""},
+		// string methods and size()
+		{`manifest.digest.startsWith("sha256:") && manifest.digest.size() == 71`, true, ""},
+		{`size(repository.name) == 8 && repository.name.endsWith("/foo")`, true, ""},
+		{`labels.maintainer.matches(labels.maintainer)`, true, ""},
+		{`repository.name.matches(annotations["org.opencontainers.image.source"])`, false, ""},
+		// macros
+		{`labels.all(k, k.startsWith("m")) && ["a", "b"].exists(x, x == "b")`, true, ""},
+		{`annotations.exists_one(k, k.contains("opencontainers"))`, true, ""},
+		{`[1, 2, 3].map(x, x * 2).filter(x, x > 2) == [4, 6]`, true, ""},
+		// values of "account" and "manifest" are not statically typed, so type
+		// errors involving them are only detected during evaluation
+		{`account.is_replica`, false, ""},
+		{`manifest.size_bytes`, false, `expected expression to evaluate to bool, but got int`},
+		{`manifest.size_bytes.foo == 1`, false, `no such key: foo`},
+		{`manifest.size_bytes.contains("1")`, false, `no such overload`},
+		{`!manifest.size_bytes`, false, `no such overload`},
+		{`"foo" in manifest.size_bytes`, false, `no such overload`},
+		{`manifest.size_bytes && true`, false, `no such overload`},
+		// other runtime errors
+		{`[1, 2][5] == 1`, false, `index out of bounds: 5`},
+	}
+
+	for _, tc := range testCases {
+		prog, err := AdmissionPolicy{Name: "test", Language: "cel", Expression: tc.Expression}.Compile()
+		if err != nil {
+			t.Errorf("expected %s to compile, but got error: %s", tc.Expression, err.Error())
+			continue
+		}
+		result, err := prog.Evaluate(input)
+		switch {
+		case tc.Error == "" && err != nil:
+			t.Errorf("expected %s to evaluate successfully, but got error: %s", tc.Expression, err.Error())
+		case tc.Error != "" && err == nil:
+			t.Errorf("expected %s to fail with %q, but got result %t", tc.Expression, tc.Error, result)
+		case tc.Error != "" && err.Error() != tc.Error:
+			t.Errorf("expected %s to fail with %q, but got error: %s", tc.Expression, tc.Error, err.Error())
+		case result != tc.Result:
+			t.Errorf("expected %s to evaluate to %t, but got %t", tc.Expression, tc.Result, result)
+		}
+	}
+}
+
+func TestAdmissionPolicyCompileErrors(t *testing.T) {
+	testCases := []struct {
+		Policy AdmissionPolicy
+		Error  string
+	}{
+		{AdmissionPolicy{Language: "cel", Expression: "true"}, `admission policy must have the "name" attribute`},
+		{AdmissionPolicy{Name: "foo", Language: "cel"}, `admission policy "foo" must have the "expression" attribute`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: "true", Action: "delete"}, `admission policy "foo" has invalid action "delete"`},
+		{AdmissionPolicy{Name: "foo", Language: "rego", Expression: "true"}, `admission policy "foo" has unsupported language "rego"`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `has(labels)`}, `cannot compile expression of admission policy "foo": invalid argument to has() macro at position 4`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `labels.foo.lower()`}, `cannot compile expression of admission policy "foo": undeclared reference to 'lower' (in container '') at position 16`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `(true`}, `cannot compile expression of admission policy "foo": Syntax error: missing ')' at '<EOF>' at position 5`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `true false`}, `cannot compile expression of admission policy "foo": Syntax error: extraneous input 'false' expecting <EOF> at position 5`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `lower(labels.foo)`}, `cannot compile expression of admission policy "foo": undeclared reference to 'lower' (in container '') at position 5`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `unknown`}, `cannot compile expression of admission policy "foo": undeclared reference to 'unknown' (in container '') at position 0`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `labels.maintainer`}, `cannot compile expression of admission policy "foo": expected expression to evaluate to bool, but got string`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `size(labels.maintainer) > "foo"`}, `cannot compile expression of admission policy "foo": found no matching overload for '_>_' applied to '(int, string)' at position 24`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `labels[1] == "foo"`}, `cannot compile expression of admission policy "foo": found no matching overload for '_[_]' applied to '(map(string, string), int)' at position 6`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `[1, 2]["foo"] == 1`}, `cannot compile expression of admission policy "foo": found no matching overload for '_[_]' applied to '(list(int), string)' at position 6`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `true[0]`}, `cannot compile expression of admission policy "foo": found no matching overload for '_[_]' applied to '(bool, int)' at position 4`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `size(true) == 1`}, `cannot compile expression of admission policy "foo": found no matching overload for 'size' applied to '(bool)' at position 4`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `-repository.name == "foo"`}, `cannot compile expression of admission policy "foo": found no matching overload for '-_' applied to '(string)' at position 0`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `size(labels, annotations) > 0`}, `cannot compile expression of admission policy "foo": found no matching overload for 'size' applied to '(map(string, string), map(string, string))' at position 4`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `labels.size(1) > 0`}, `cannot compile expression of admission policy "foo": found no matching overload for 'size' applied to 'map(string, string).(int)' at position 11`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `labels.foo.contains()`}, `cannot compile expression of admission policy "foo": found no matching overload for 'contains' applied to 'string.()' at position 19`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `labels.foo.matches("[")`}, "cannot compile expression of admission policy \"foo\": error parsing regexp: missing closing ]: `[`"},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `labels.`}, `cannot compile expression of admission policy "foo": Syntax error: no viable alternative at input '.' at position 7`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `[1, 2`}, `cannot compile expression of admission policy "foo": Syntax error: mismatched input '<EOF>' expecting {']', ','} at position 5`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: `99999999999999999999 > 0`}, `cannot compile expression of admission policy "foo": invalid int literal at position 0`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: strings.Repeat("(", 32) + "true" + strings.Repeat(")", 32)}, `cannot compile expression of admission policy "foo": expression recursion limit exceeded: 32 at position -1`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: strings.Repeat("[", 40) + strings.Repeat("]", 40)}, `cannot compile expression of admission policy "foo": expression recursion limit exceeded: 32 at position -1`},
+		{AdmissionPolicy{Name: "foo", Language: "cel", Expression: "true" + strings.Repeat(" && true", 512)}, `cannot compile expression of admission policy "foo": expression may not be longer than 4096 bytes`},
+	}
+
+	for _, tc := range testCases {
+		_, err := tc.Policy.Compile()
+		if err == nil {
+			t.Errorf("expected %#v to fail with %q, but compiled successfully", tc.Policy, tc.Error)
+		} else if err.Error() != tc.Error {
+			t.Errorf("expected %#v to fail with %q, but got error: %s", tc.Policy, tc.Error, err.Error())
+		}
+	}
+}
+
+func TestAdmissionPolicyNestingWithinLimits(t *testing.T) {
+	// expressions up to the maximum nesting depth are accepted, and long chains
+	// of binary or unary operators do not count towards the nesting depth
+	expressions := []string{
+		strings.Repeat("(", 31) + "true" + strings.Repeat(")", 31),
+		"true" + strings.Repeat(" && true", 500),
+		strings.Repeat("!", 40) + "true",
+	}
+	for _, expr := range expressions {
+		prog, err := AdmissionPolicy{Name: "test", Language: "cel", Expression: expr}.Compile()
+		if err != nil {
+			t.Errorf("expected %q to compile, but got error: %s", expr, err.Error())
+			continue
+		}
+		result, err := prog.Evaluate(AdmissionPolicyInput{})
+		if err != nil || !result {
+			t.Errorf("expected %q to evaluate to true, but got %t and error %v", expr, result, err)
+		}
+	}
+}
+
+func TestAdmissionPolicyCompileCache(t *testing.T) {
+	expr := `labels.foo.matches("^ba[rz]$")`
+	prog1, err := celLanguage{}.Compile(expr)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	prog2, err := celLanguage{}.Compile(expr)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if prog1.(celProgram).program != prog2.(celProgram).program {
+		t.Error("expected second compilation of the same expression to be served from the cache")
+	}
+
+	// compile errors are not cached
+	_, err = celLanguage{}.Compile(`labels.foo.matches("[")`)
+	if err == nil {
+		t.Error("expected compile error for invalid regex")
+	}
+	celProgramCacheMutex.Lock()
+	_, isCached := celProgramCache[`labels.foo.matches("[")`]
+	celProgramCacheMutex.Unlock()
+	if isCached {
+		t.Error("expected failed compilation to not be cached")
+	}
+
+	// cached programs evaluate identically
+	for _, value := range []string{"bar", "baz", "qux"} {
+		input := AdmissionPolicyInput{Labels: map[string]string{"foo": value}}
+		result1, err1 := prog1.Evaluate(input)
+		result2, err2 := prog2.Evaluate(input)
+		if err1 != nil || err2 != nil || result1 != result2 || result1 != (value != "qux") {
+			t.Errorf("unexpected results for foo=%q: %t/%v and %t/%v", value, result1, err1, result2, err2)
+		}
+	}
+}
+
+func TestAdmissionPolicyEvaluationCostLimit(t *testing.T) {
+	// a million iterations are well beyond the evaluation cost limit
+	numbers := make([]string, 100)
+	for idx := range numbers {
+		numbers[idx] = strconv.Itoa(idx)
+	}
+	list := "[" + strings.Join(numbers, ", ") + "]"
+	expr := fmt.Sprintf(`%[1]s.all(x, %[1]s.all(y, %[1]s.all(z, x + y + z >= 0)))`, list)
+
+	prog, err := AdmissionPolicy{Name: "test", Language: "cel", Expression: expr}.Compile()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = prog.Evaluate(AdmissionPolicyInput{})
+	expected := "operation cancelled: actual cost limit exceeded"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected evaluation to fail with %q, but got %v", expected, err)
+	}
+}
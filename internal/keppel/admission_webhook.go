@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// AdmissionWebhook appears in type Configuration. If configured, each manifest
+// push is submitted to an external policy service for review.
+type AdmissionWebhook struct {
+	URL url.URL
+	// If not empty, this is sent to the webhook as a bearer token.
+	Token   string
+	Timeout time.Duration
+	// If true, pushes are admitted when the webhook cannot be reached or does not
+	// return a valid response. Otherwise they are rejected.
+	FailOpen bool
+}
+
+// AdmissionReview is the request body that Keppel sends to the admission webhook.
+type AdmissionReview struct {
+	Account    AdmissionReviewAccount  `json:"account"`
+	Repository string                  `json:"repository"`
+	Tag        string                  `json:"tag,omitempty"`
+	Manifest   AdmissionReviewManifest `json:"manifest"`
+	Actor      AdmissionReviewActor    `json:"actor"`
+}
+
+// AdmissionReviewAccount appears in type AdmissionReview.
+type AdmissionReviewAccount struct {
+	Name         models.AccountName `json:"name"`
+	AuthTenantID string             `json:"auth_tenant_id"`
+	IsReplica    bool               `json:"is_replica"`
+}
+
+// AdmissionReviewManifest appears in type AdmissionReview.
+type AdmissionReviewManifest struct {
+	Digest       string            `json:"digest"`
+	MediaType    string            `json:"media_type"`
+	ArtifactType string            `json:"artifact_type,omitempty"`
+	SizeBytes    uint64            `json:"size_bytes"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// AdmissionReviewActor appears in type AdmissionReview.
+type AdmissionReviewActor struct {
+	// One of "regular", "anonymous", "peer", "trivy" or "janitor".
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+var userTypeNames = map[UserType]string{
+	RegularUser:   "regular",
+	AnonymousUser: "anonymous",
+	PeerUser:      "peer",
+	TrivyUser:     "trivy",
+	JanitorUser:   "janitor",
+}
+
+// NewAdmissionReviewActor builds an AdmissionReviewActor describing the given user.
+func NewAdmissionReviewActor(uid UserIdentity) AdmissionReviewActor {
+	if uid == nil {
+		return AdmissionReviewActor{Type: userTypeNames[JanitorUser]}
+	}
+	return AdmissionReviewActor{
+		Type: userTypeNames[uid.UserType()],
+		Name: uid.UserName(),
+	}
+}
+
+// AdmissionDecision appears in type AdmissionReviewResponse.
+type AdmissionDecision string
+
+const (
+	// AdmissionAllow is the AdmissionDecision for admitting a manifest.
+	AdmissionAllow AdmissionDecision = "allow"
+	// AdmissionDeny is the AdmissionDecision for rejecting a manifest push.
+	AdmissionDeny AdmissionDecision = "deny"
+	// AdmissionQuarantine is the AdmissionDecision for admitting a manifest,
+	// but putting it in quarantine (see models.ManifestQuarantined).
+	AdmissionQuarantine AdmissionDecision = "quarantine"
+)
+
+// AdmissionReviewResponse is the response body that Keppel expects from the admission webhook.
+type AdmissionReviewResponse struct {
+	Decision AdmissionDecision `json:"decision"`
+	// A human-readable explanation for the decision (optional). This is shown
+	// to the user if the push is denied, or as the quarantine reason.
+	Message string `json:"message,omitempty"`
+}
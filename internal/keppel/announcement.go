@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// AnnouncementSeverity is an enum for the severity of an Announcement.
+type AnnouncementSeverity string
+
+const (
+	// InfoSeverity is an AnnouncementSeverity.
+	InfoSeverity AnnouncementSeverity = "info"
+	// WarningSeverity is an AnnouncementSeverity.
+	WarningSeverity AnnouncementSeverity = "warning"
+	// CriticalSeverity is an AnnouncementSeverity.
+	CriticalSeverity AnnouncementSeverity = "critical"
+)
+
+var isAnnouncementSeverity = map[AnnouncementSeverity]bool{
+	InfoSeverity:     true,
+	WarningSeverity:  true,
+	CriticalSeverity: true,
+}
+
+// Announcement represents a cluster-wide announcement (e.g. of upcoming
+// maintenance) in the API.
+type Announcement struct {
+	Message  string               `json:"message"`
+	Severity AnnouncementSeverity `json:"severity"`
+	// StartsAt and EndsAt describe the time window that the announcement
+	// refers to (e.g. a maintenance window). The announcement is shown to users
+	// until EndsAt has passed.
+	StartsAt *int64 `json:"starts_at,omitempty"`
+	EndsAt   *int64 `json:"ends_at,omitempty"`
+}
+
+// RenderAnnouncement converts an announcement model from the DB into the API representation.
+func RenderAnnouncement(a models.Announcement) Announcement {
+	return Announcement{
+		Message:  a.Message,
+		Severity: AnnouncementSeverity(a.Severity),
+		StartsAt: MaybeTimeToUnix(a.StartsAt),
+		EndsAt:   MaybeTimeToUnix(a.EndsAt),
+	}
+}
+
+// ApplyToModel validates this announcement and stores it in the given model.
+func (a Announcement) ApplyToModel(target *models.Announcement) error {
+	if strings.TrimSpace(a.Message) == "" {
+		return errors.New("announcement message may not be empty")
+	}
+	if strings.ContainsAny(a.Message, "\r\n") {
+		return errors.New("announcement message may not contain line breaks")
+	}
+	if !isAnnouncementSeverity[a.Severity] {
+		return fmt.Errorf("invalid announcement severity: %q", a.Severity)
+	}
+	if a.StartsAt != nil && a.EndsAt != nil && *a.StartsAt > *a.EndsAt {
+		return errors.New("announcement may not end before it starts")
+	}
+
+	target.Message = a.Message
+	target.Severity = string(a.Severity)
+	target.StartsAt = maybeUnixToTime(a.StartsAt)
+	target.EndsAt = maybeUnixToTime(a.EndsAt)
+	return nil
+}
+
+// HeaderValue renders this announcement into the format used by the
+// X-Keppel-Announcement response header.
+func (a Announcement) HeaderValue() string {
+	return fmt.Sprintf("%s: %s", a.Severity, a.Message)
+}
+
+func maybeUnixToTime(t *int64) *time.Time {
+	if t == nil {
+		return nil
+	}
+	val := time.Unix(*t, 0).UTC()
+	return &val
+}
+
+// FindCurrentAnnouncement returns the announcement that is currently shown to
+// users, or nil if there is none.
+func FindCurrentAnnouncement(db gorp.SqlExecutor, now time.Time) (*models.Announcement, error) {
+	var a models.Announcement
+	err := db.SelectOne(&a, `SELECT * FROM announcements WHERE ends_at IS NULL OR ends_at > $1 ORDER BY id DESC LIMIT 1`, now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return &a, err
+}
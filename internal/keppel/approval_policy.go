@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ApprovalPolicy represents the approval policy of an account in the API.
+//
+// If an account has an approval policy, its deletion always requires
+// approval by a second user. The thresholds extend this requirement to other
+// destructive operations. If a threshold is not set, the respective
+// operations never require approval.
+type ApprovalPolicy struct {
+	// Deletions of a manifest or tag that would delete more than this many tags require approval.
+	TagDeletionThreshold *uint64 `json:"tag_deletion_threshold,omitempty"`
+	// Changes to the GC policies that would delete more than this many additional manifests require approval.
+	GCManifestThreshold *uint64 `json:"gc_manifest_threshold,omitempty"`
+}
+
+// ParseApprovalPolicy parses the approval policy of the given account.
+// Returns nil if the account does not have an approval policy.
+func ParseApprovalPolicy(account models.ReducedAccount) (*ApprovalPolicy, error) {
+	if account.ApprovalPolicyJSON == "" {
+		return nil, nil
+	}
+	var policy ApprovalPolicy
+	err := json.Unmarshal([]byte(account.ApprovalPolicyJSON), &policy)
+	return &policy, err
+}
+
+// RequiresApprovalForTagDeletion returns whether an operation that deletes
+// the given number of tags requires approval under this policy.
+func (p *ApprovalPolicy) RequiresApprovalForTagDeletion(tagCount uint64) bool {
+	return p != nil && p.TagDeletionThreshold != nil && tagCount > *p.TagDeletionThreshold
+}
+
+// PendingChange represents a pending change in the API.
+type PendingChange struct {
+	ID          int64                    `json:"id"`
+	Kind        models.PendingChangeKind `json:"kind"`
+	Payload     *PendingChangePayload    `json:"payload,omitempty"`
+	RequestedAt int64                    `json:"requested_at"`
+	RequestedBy string                   `json:"requested_by"`
+}
+
+// PendingChangePayload is the format of models.PendingChange.PayloadJSON.
+// Which fields are filled depends on the kind of the pending change.
+type PendingChangePayload struct {
+	RepositoryName string          `json:"repository,omitempty"`
+	Digest         digest.Digest   `json:"digest,omitempty"`
+	TagName        string          `json:"tag,omitempty"`
+	TagCount       uint64          `json:"tag_count,omitempty"`
+	ManifestCount  uint64          `json:"manifest_count,omitempty"`
+	GCPolicies     *[]GCPolicy     `json:"gc_policies,omitempty"`
+	ApprovalPolicy *ApprovalPolicy `json:"approval_policy,omitempty"`
+	// only for PendingNamespaceGCPoliciesUpdate
+	NamespacePrefix string `json:"namespace,omitempty"`
+	// only for PendingRepoGCPoliciesUpdate
+	IsArchived                *bool `json:"archived,omitempty"`
+	IgnoreInheritedGCPolicies *bool `json:"ignore_inherited_gc_policies,omitempty"`
+}
+
+// RenderPendingChange converts a pending change model from the DB into the API representation.
+func RenderPendingChange(pc models.PendingChange) (PendingChange, error) {
+	result := PendingChange{
+		ID:          pc.ID,
+		Kind:        pc.Kind,
+		RequestedAt: pc.RequestedAt.Unix(),
+		RequestedBy: pc.RequestedBy,
+	}
+	if pc.PayloadJSON != "" {
+		result.Payload = &PendingChangePayload{}
+		err := json.Unmarshal([]byte(pc.PayloadJSON), result.Payload)
+		if err != nil {
+			return PendingChange{}, err
+		}
+	}
+	return result, nil
+}
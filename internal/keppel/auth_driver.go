@@ -32,6 +32,11 @@ const (
 	CanViewQuotas Permission = "viewquota"
 	// CanChangeQuotas is the permission for changing an auth tenant's quotas.
 	CanChangeQuotas Permission = "changequota"
+	// CanAdministrateKeppel is the permission for cluster-wide administrative
+	// operations that do not pertain to any specific auth tenant. When checking
+	// for this permission, UserIdentity.HasPermission() is called with an empty
+	// tenantID.
+	CanAdministrateKeppel Permission = "admin"
 )
 
 // AuthDriver represents an authentication backend that supports multiple
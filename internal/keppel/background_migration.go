@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"fmt"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// BackgroundMigration is a data migration that is too large to be done in a
+// regular schema migration without locking a large table for a long time.
+// Instead, the janitor processes it in small batches, each in its own
+// transaction. The schema migration that adds the respective columns must make
+// sure that the application works correctly while the backfill is not
+// complete yet.
+type BackgroundMigration struct {
+	// Name identifies the migration in the `background_migrations` table and
+	// in metrics. It must never change once the migration has been released.
+	Name string
+	// BatchQuery processes the next batch of rows. It receives the maximum
+	// number of rows to process as $1, and the affected row count must be the
+	// number of rows that were processed. Once it affects no rows anymore, the
+	// migration is considered finished.
+	BatchQuery string
+	// RemainingQuery counts the rows that still need to be processed.
+	RemainingQuery string
+	// BatchSize is the maximum number of rows processed per batch.
+	// If zero, DefaultBackgroundMigrationBatchSize is used.
+	BatchSize int
+}
+
+// DefaultBackgroundMigrationBatchSize is the default for BackgroundMigration.BatchSize.
+const DefaultBackgroundMigrationBatchSize = 1000
+
+// BackgroundMigrations lists all background migrations in the order in which
+// they are processed. Finished migrations may be removed from this list once
+// all supported installations are known to have processed them.
+var BackgroundMigrations []BackgroundMigration
+
+// CountRemainingRows returns how many rows still need to be processed by
+// this migration.
+func (m BackgroundMigration) CountRemainingRows(db *DB) (int64, error) {
+	remainingRows, err := db.SelectInt(m.RemainingQuery)
+	if err != nil {
+		return 0, fmt.Errorf("while counting remaining rows of background migration %q: %w", m.Name, err)
+	}
+	return remainingRows, nil
+}
+
+// GetBackgroundMigrationStatus returns the progress of each migration in
+// BackgroundMigrations. Migrations that have not started yet are
+// reported with a zero StartedAt.
+func GetBackgroundMigrationStatus(db *DB) ([]models.BackgroundMigration, error) {
+	var dbStatus []models.BackgroundMigration
+	_, err := db.Select(&dbStatus, `SELECT * FROM background_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	statusByName := make(map[string]models.BackgroundMigration, len(dbStatus))
+	for _, s := range dbStatus {
+		statusByName[s.Name] = s
+	}
+
+	result := make([]models.BackgroundMigration, len(BackgroundMigrations))
+	for idx, migration := range BackgroundMigrations {
+		status, exists := statusByName[migration.Name]
+		if !exists {
+			status = models.BackgroundMigration{Name: migration.Name}
+		}
+		result[idx] = status
+	}
+	return result, nil
+}
@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// BackupSnapshot is the format of the metadata snapshots that are written
+// into the BackupDriver. Blob and manifest contents are not part of the
+// snapshot; they are backed up separately by digest.
+type BackupSnapshot struct {
+	CreatedAt time.Time `json:"created_at"`
+	// The version of the DB schema (i.e. the number of the latest migration)
+	// at the time of the snapshot. Snapshots can only be restored into a DB
+	// with the same schema version.
+	SchemaVersion int64 `json:"schema_version"`
+	// Maps table names to their rows. Each row is a JSON object as rendered by
+	// the Postgres function row_to_json().
+	Tables map[string][]json.RawMessage `json:"tables"`
+}
+
+// BackupSnapshotTables lists the tables that are included in a
+// BackupSnapshot, in the order in which they need to be restored.
+//
+// Some tables are left out on purpose: manifest_contents is restored from the
+// backed-up manifest contents, and the remaining tables only contain
+// ephemeral state (e.g. uploads, peers, circuit breakers) that does not need
+// to survive a disaster recovery.
+var BackupSnapshotTables = []string{
+	"accounts",
+	"quotas",
+	"repo_namespaces",
+	"account_requests",
+	"account_snapshots",
+	"repos",
+	"blobs",
+	"blob_mounts",
+	"manifests",
+	"manifest_blob_refs",
+	"manifest_manifest_refs",
+	"tags",
+	"trivy_security_info",
+	"manifest_keppel_labels",
+	"lazy_pull_variants",
+	"recompressed_variants",
+}
+
+// BackupSnapshotName returns the name under which a snapshot created at the
+// given time is stored. Names sort in the same order as creation times.
+func BackupSnapshotName(createdAt time.Time) string {
+	return fmt.Sprintf("snapshot-%s.json.gz", createdAt.UTC().Format("20060102T150405Z"))
+}
+
+// BackupSnapshotWriter writes a BackupSnapshot into the format that is
+// written into the BackupDriver, one row at a time. This allows to write
+// snapshots of large databases without holding them in memory.
+type BackupSnapshotWriter struct {
+	gzw          *gzip.Writer
+	buf          bytes.Buffer
+	inTable      bool
+	isFirstTable bool
+	isFirstRow   bool
+}
+
+// NewBackupSnapshotWriter starts writing a snapshot into the given writer.
+// The caller must add all tables using BeginTable() and WriteRow(), and then
+// call Close().
+func NewBackupSnapshotWriter(w io.Writer, createdAt time.Time, schemaVersion int64) (*BackupSnapshotWriter, error) {
+	createdAtJSON, err := json.Marshal(createdAt)
+	if err != nil {
+		return nil, err
+	}
+	sw := &BackupSnapshotWriter{gzw: gzip.NewWriter(w), isFirstTable: true}
+	_, err = fmt.Fprintf(sw.gzw, `{"created_at":%s,"schema_version":%d,"tables":{`, createdAtJSON, schemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// BeginTable starts the list of rows for the given table.
+func (sw *BackupSnapshotWriter) BeginTable(name string) error {
+	nameJSON, err := json.Marshal(name)
+	if err != nil {
+		return err
+	}
+	sw.buf.Reset()
+	if sw.inTable {
+		sw.buf.WriteString("]")
+	}
+	if !sw.isFirstTable {
+		sw.buf.WriteString(",")
+	}
+	sw.buf.Write(nameJSON)
+	sw.buf.WriteString(":[")
+	sw.inTable = true
+	sw.isFirstTable = false
+	sw.isFirstRow = true
+	_, err = sw.gzw.Write(sw.buf.Bytes())
+	return err
+}
+
+// WriteRow appends a row to the table that was most recently started with
+// BeginTable(). The row must be a JSON object as rendered by the Postgres
+// function row_to_json().
+func (sw *BackupSnapshotWriter) WriteRow(row json.RawMessage) error {
+	if !sw.inTable {
+		return errors.New("BackupSnapshotWriter.WriteRow() called before BeginTable()")
+	}
+	sw.buf.Reset()
+	if !sw.isFirstRow {
+		sw.buf.WriteString(",")
+	}
+	err := json.Compact(&sw.buf, row)
+	if err != nil {
+		return fmt.Errorf("cannot serialize row: %w", err)
+	}
+	sw.isFirstRow = false
+	_, err = sw.gzw.Write(sw.buf.Bytes())
+	return err
+}
+
+// Close finishes the snapshot. It does not close the underlying writer.
+func (sw *BackupSnapshotWriter) Close() error {
+	suffix := "}}\n"
+	if sw.inTable {
+		suffix = "]" + suffix
+	}
+	_, err := sw.gzw.Write([]byte(suffix))
+	if err != nil {
+		return err
+	}
+	return sw.gzw.Close()
+}
+
+// DecodeBackupSnapshot parses a snapshot that was written by BackupSnapshotWriter.
+func DecodeBackupSnapshot(buf []byte) (BackupSnapshot, error) {
+	gzr, err := gzip.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return BackupSnapshot{}, fmt.Errorf("cannot decompress backup snapshot: %w", err)
+	}
+	contents, err := io.ReadAll(gzr)
+	if err != nil {
+		return BackupSnapshot{}, fmt.Errorf("cannot decompress backup snapshot: %w", err)
+	}
+
+	var s BackupSnapshot
+	err = json.Unmarshal(contents, &s)
+	if err != nil {
+		return BackupSnapshot{}, fmt.Errorf("cannot parse backup snapshot: %w", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/pluggable"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// BackupDriver is the abstract interface for an off-site backup target.
+//
+// Blob and manifest contents are stored by digest, so contents that appear in
+// multiple accounts or repositories are only stored once. The exception are
+// manifests in accounts with a content encryption key: Their contents are
+// encrypted with an account-specific key, so they are stored per account and
+// repository instead (see WriteAccountContent). Metadata snapshots (see type
+// BackupSnapshot) are stored under a name chosen by the caller.
+type BackupDriver interface {
+	pluggable.Plugin
+	// Init is called before any other interface methods, and allows the plugin to
+	// perform first-time initialization.
+	Init(context.Context, Configuration) error
+
+	// HasContent returns whether contents with the given digest have already
+	// been written by WriteContent().
+	HasContent(ctx context.Context, digest digest.Digest) (bool, error)
+	// WriteContent stores the given contents. The caller guarantees that
+	// `contents` yields exactly `sizeBytes` bytes with the given digest.
+	WriteContent(ctx context.Context, digest digest.Digest, sizeBytes uint64, contents io.Reader) error
+	// ReadContent returns ErrBackupContentNotFound if no contents with this
+	// digest have been written.
+	ReadContent(ctx context.Context, digest digest.Digest) (io.ReadCloser, error)
+
+	// WriteAccountContent stores the contents of the given manifest in a
+	// location specific to its account and repository. Unlike WriteContent,
+	// `contents` does not have the given digest (since it is encrypted), and
+	// existing contents in the same location are overwritten.
+	WriteAccountContent(ctx context.Context, accountName models.AccountName, repoName string, digest digest.Digest, sizeBytes uint64, contents io.Reader) error
+	// ReadAccountContent returns ErrBackupContentNotFound if no contents for
+	// this manifest have been written by WriteAccountContent().
+	ReadAccountContent(ctx context.Context, accountName models.AccountName, repoName string, digest digest.Digest) (io.ReadCloser, error)
+
+	// WriteSnapshot stores a snapshot (as written by BackupSnapshotWriter). The
+	// caller guarantees that `contents` yields exactly `sizeBytes` bytes.
+	WriteSnapshot(ctx context.Context, name string, sizeBytes uint64, contents io.Reader) error
+	// ListSnapshots returns the names of all snapshots in lexicographical order.
+	ListSnapshots(ctx context.Context) ([]string, error)
+	ReadSnapshot(ctx context.Context, name string) ([]byte, error)
+}
+
+// ErrBackupContentNotFound is returned by BackupDriver.ReadContent() for
+// contents that were never backed up.
+var ErrBackupContentNotFound = errors.New("content not found in backup")
+
+// BackupDriverRegistry is a pluggable.Registry for BackupDriver implementations.
+var BackupDriverRegistry pluggable.Registry[BackupDriver]
+
+// NewBackupDriver creates a new BackupDriver using one of the plugins
+// registered with BackupDriverRegistry.
+func NewBackupDriver(ctx context.Context, pluginTypeID string, cfg Configuration) (BackupDriver, error) {
+	logg.Debug("initializing backup driver %q...", pluginTypeID)
+
+	bd := BackupDriverRegistry.Instantiate(pluginTypeID)
+	if bd == nil {
+		return nil, errors.New("no such backup driver: " + pluginTypeID)
+	}
+	return bd, bd.Init(ctx, cfg)
+}
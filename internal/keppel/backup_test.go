@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+)
+
+func TestBackupSnapshotWriter(t *testing.T) {
+	createdAt := time.Unix(86400, 0).UTC()
+	tables := []struct {
+		Name string
+		Rows []string
+	}{
+		{"accounts", []string{`{"name": "first"}`, `{"name":"second"}`}},
+		{"quotas", nil},
+		{"repos", []string{`{"id":1,"name":"foo"}`}},
+	}
+
+	var buf bytes.Buffer
+	sw, err := NewBackupSnapshotWriter(&buf, createdAt, 42)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, table := range tables {
+		err := sw.BeginTable(table.Name)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, row := range table.Rows {
+			err := sw.WriteRow(json.RawMessage(row))
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+	}
+	err = sw.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	snapshot, err := DecodeBackupSnapshot(buf.Bytes())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "snapshot", snapshot, BackupSnapshot{
+		CreatedAt:     createdAt,
+		SchemaVersion: 42,
+		Tables: map[string][]json.RawMessage{
+			"accounts": {json.RawMessage(`{"name":"first"}`), json.RawMessage(`{"name":"second"}`)},
+			"quotas":   {},
+			"repos":    {json.RawMessage(`{"id":1,"name":"foo"}`)},
+		},
+	})
+
+	// malformed rows are rejected instead of corrupting the snapshot
+	sw, err = NewBackupSnapshotWriter(&bytes.Buffer{}, createdAt, 42)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = sw.WriteRow(json.RawMessage(`{}`))
+	if err == nil {
+		t.Error("expected WriteRow() before BeginTable() to fail")
+	}
+	err = sw.BeginTable("accounts")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = sw.WriteRow(json.RawMessage(`{"name":`))
+	if err == nil {
+		t.Error("expected WriteRow() with malformed JSON to fail")
+	}
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"github.com/go-gorp/gorp/v3"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// BlobSweepStatus is the API representation of how much storage in an account
+// is occupied by blobs that are not referenced by any manifest anymore, and
+// will therefore be deleted by upcoming blob mount sweeps and blob sweeps.
+type BlobSweepStatus struct {
+	UnreferencedBlobCount uint64 `json:"unreferenced_blob_count"`
+	UnreferencedBlobBytes uint64 `json:"unreferenced_blob_bytes"`
+	// NextSweepAt is omitted when the next sweep will happen as soon as possible.
+	NextSweepAt *int64 `json:"next_sweep_at,omitempty"`
+}
+
+// Unbacked blobs (i.e. blobs in replica accounts that have not been replicated
+// yet) do not occupy any storage, so they are not counted.
+var unreferencedBlobUsageQuery = sqlext.SimplifyWhitespace(`
+	SELECT COUNT(*), COALESCE(SUM(size_bytes), 0)
+	  FROM blobs
+	 WHERE account_name = $1 AND storage_id != '' AND id NOT IN (
+		SELECT mbr.blob_id FROM manifest_blob_refs mbr JOIN repos r ON mbr.repo_id = r.id
+		 WHERE r.account_name = $1
+	 )
+`)
+
+// FindBlobSweepStatus computes the BlobSweepStatus for the given account.
+func FindBlobSweepStatus(db gorp.SqlExecutor, account models.Account) (BlobSweepStatus, error) {
+	var status BlobSweepStatus
+	err := db.QueryRow(unreferencedBlobUsageQuery, account.Name).Scan(&status.UnreferencedBlobCount, &status.UnreferencedBlobBytes)
+	if err != nil {
+		return BlobSweepStatus{}, err
+	}
+	if account.NextBlobSweepedAt != nil {
+		nextSweepAt := account.NextBlobSweepedAt.Unix()
+		status.NextSweepAt = &nextSweepAt
+	}
+	return status, nil
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/pluggable"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// CDNDriver is the abstract interface for a content delivery network (CDN)
+// that blob pulls can be redirected to. This is only used for accounts that
+// have ServeBlobsViaCDN enabled.
+//
+// How the CDN obtains the blob contents from its origin is up to the CDN
+// configuration. Keppel only generates URLs that the CDN accepts, and asks the
+// CDN to drop blobs from its caches when they are deleted in Keppel.
+type CDNDriver interface {
+	pluggable.Plugin
+	// Init is called before any other interface methods, and allows the plugin to
+	// perform first-time initialization.
+	Init(context.Context, Configuration) error
+
+	// URLForBlob returns a (usually signed and time-limited) URL under which
+	// the CDN serves the contents of the given blob. The client will be
+	// redirected to this URL.
+	URLForBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob) (string, error)
+	// PurgeBlob asks the CDN to remove the given blob from its caches. This is
+	// called after the blob has been deleted from the backing storage.
+	PurgeBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob) error
+}
+
+// CDNDriverRegistry is a pluggable.Registry for CDNDriver implementations.
+var CDNDriverRegistry pluggable.Registry[CDNDriver]
+
+// NewCDNDriver creates a new CDNDriver using one of the plugins registered
+// with CDNDriverRegistry.
+func NewCDNDriver(ctx context.Context, pluginTypeID string, cfg Configuration) (CDNDriver, error) {
+	logg.Debug("initializing CDN driver %q...", pluginTypeID)
+
+	cdnd := CDNDriverRegistry.Instantiate(pluginTypeID)
+	if cdnd == nil {
+		return nil, errors.New("no such CDN driver: " + pluginTypeID)
+	}
+	return cdnd, cdnd.Init(ctx, cfg)
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"sync"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ConcurrencyLimiter limits how many requests for the same account can be
+// processed at the same time by one keppel-api instance. Unlike rate limits,
+// which limit how many requests can be made over time, this ensures that a
+// burst of slow requests for one account (e.g. a pull storm) cannot occupy all
+// workers of the API and starve requests for other accounts.
+type ConcurrencyLimiter struct {
+	limit    int
+	mutex    sync.Mutex
+	inFlight map[models.AccountName]int
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter that allows up to
+// `limit` concurrent requests per account. If `limit` is zero, nil is
+// returned since concurrency limits are disabled.
+func NewConcurrencyLimiter(limit int) *ConcurrencyLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &ConcurrencyLimiter{
+		limit:    limit,
+		inFlight: make(map[models.AccountName]int),
+	}
+}
+
+// TryAcquire reserves a slot for a request on the given account. If the
+// account already has the maximum number of requests in flight, false is
+// returned. Otherwise, the slot must be returned with Release() once the
+// request has been processed.
+func (l *ConcurrencyLimiter) TryAcquire(accountName models.AccountName) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.inFlight[accountName] >= l.limit {
+		return false
+	}
+	l.inFlight[accountName]++
+	return true
+}
+
+// Release returns a slot that was reserved by TryAcquire().
+func (l *ConcurrencyLimiter) Release(accountName models.AccountName) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.inFlight[accountName] <= 1 {
+		delete(l.inFlight, accountName)
+	} else {
+		l.inFlight[accountName]--
+	}
+}
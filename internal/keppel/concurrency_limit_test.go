@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"testing"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	if NewConcurrencyLimiter(0) != nil {
+		t.Error("expected concurrency limits to be disabled for limit = 0")
+	}
+
+	l := NewConcurrencyLimiter(2)
+	expect := func(accountName models.AccountName, expected bool) {
+		t.Helper()
+		actual := l.TryAcquire(accountName)
+		if actual != expected {
+			t.Errorf("expected TryAcquire(%q) = %t, but got %t", accountName, expected, actual)
+		}
+	}
+
+	expect("first", true)
+	expect("first", true)
+	expect("first", false)
+	// other accounts are not affected
+	expect("second", true)
+
+	l.Release("first")
+	expect("first", true)
+	expect("first", false)
+
+	// slots that are not in use are cleaned up
+	l.Release("first")
+	l.Release("first")
+	l.Release("second")
+	if len(l.inFlight) != 0 {
+		t.Errorf("expected no requests in flight, but got %#v", l.inFlight)
+	}
+}
@@ -7,20 +7,24 @@ import (
 	"crypto"
 	"fmt"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
 	"github.com/sapcc/go-api-declarations/bininfo"
 	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/errext"
 	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/go-bits/must"
 	"github.com/sapcc/go-bits/osext"
 
+	"github.com/sapcc/keppel/internal/models"
 	"github.com/sapcc/keppel/internal/trivy"
 )
 
@@ -32,6 +36,168 @@ type Configuration struct {
 	JWTIssuerKeys            []crypto.PrivateKey
 	AnycastJWTIssuerKeys     []crypto.PrivateKey
 	Trivy                    *trivy.Config
+	RequestLimits            RequestLimits
+	UpstreamPolicy           UpstreamPolicy
+	ReplicationErrorBudget   ReplicationErrorBudget
+	AdmissionWebhook         *AdmissionWebhook
+	CachePolicy              CachePolicy
+	BlobRedirectPolicy       BlobRedirectPolicy
+	// If true, users without permission to create accounts can request them,
+	// and admins approve or deny these requests.
+	AccountRequestsEnabled bool
+	// When a pushed image has more layers than this, a validation warning is
+	// generated. Zero disables this warning.
+	ManifestLayerCountWarningThreshold int
+	// How often the janitor writes a metadata snapshot into the BackupDriver
+	// (only relevant if a BackupDriver is configured).
+	BackupSnapshotInterval time.Duration
+	CredentialReport       CredentialReportConfig
+	// If non-zero, the janitor deletes orphaned segments in the backing storage
+	// once they have been orphaned for this long (see
+	// StorageDriverWithSegments). If zero, they are only reported.
+	OrphanedSegmentDeletionDelay time.Duration
+	// The janitor deletes pull attestations (see PullAttestationRecorder) once
+	// the respective client has not pulled the respective manifest for this long.
+	PullAttestationRetention time.Duration
+	// Endpoints that receive registry events in the notification format of
+	// docker/distribution (see type DistributionNotifier).
+	DistributionNotificationURLs []url.URL
+	Telemetry                    *TelemetryConfig
+	DatabaseMaintenance          DatabaseMaintenanceConfig
+	// New accounts and repositories cannot be created if their name fully
+	// matches any of these patterns (see func ValidateNewAccountName and
+	// ValidateNewRepositoryName). Existing accounts and repositories are not
+	// affected.
+	ReservedAccountNames    []*regexp.Regexp
+	ReservedRepositoryNames []*regexp.Regexp
+	// Custom metadata fields on accounts that are validated whenever an account
+	// is created or updated. Required fields are only enforced on creation and
+	// when the metadata is changed (see func ApplyAccountMetadataToAccount).
+	AccountMetadataSchema AccountMetadataSchema
+	// Restricts which registries external replica accounts may replicate from.
+	// This is checked when an account is created and whenever replication occurs.
+	ExternalUpstreamHosts ExternalUpstreamHostPolicy
+	// Restricts the destinations of webhooks that are configured by users.
+	WebhookTargets OutboundRequestPolicy
+}
+
+// RequestLimits contains limits that keppel-api enforces on incoming requests,
+// to ensure that huge or deliberately slow requests cannot tie up workers.
+// Zero values mean that the respective limit is not enforced.
+type RequestLimits struct {
+	// Limits for JSON request bodies (Keppel API, LIQUID API, peer API etc.).
+	MaxJSONBodySizeBytes int64
+	JSONBodyReadTimeout  time.Duration
+	// Limits for request bodies of manifest PUTs on the Registry API.
+	// (Blob uploads are not limited in this way since they can be arbitrarily large.)
+	MaxManifestBodySizeBytes int64
+	ManifestBodyReadTimeout  time.Duration
+	// Limit for requests on the Registry API that are in flight at the same
+	// time for the same account (see type ConcurrencyLimiter).
+	MaxConcurrentRequestsPerAccount int
+	// Limits for the HTTP server itself.
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+}
+
+// UpstreamPolicy controls how Keppel reacts to failing requests to upstream
+// registries during replication. Zero values disable the respective feature.
+type UpstreamPolicy struct {
+	// Idempotent requests that fail with a transient error (i.e. a network error
+	// or a 5xx response) are attempted up to this many times in total. The wait
+	// time between attempts grows exponentially from RetryInitialBackoff up to
+	// RetryMaxBackoff, with random jitter.
+	RetryMaxAttempts    int
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
+	// After this many consecutive failed requests to the same upstream registry,
+	// all further requests to it fail immediately until CircuitBreakerCooldown has passed.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+}
+
+// ReplicationErrorBudget controls how failed replications from upstream
+// registries are tracked for each replica account.
+type ReplicationErrorBudget struct {
+	// Successful and failed replications are counted over this rolling window.
+	Window time.Duration
+	// If at least PauseMinAttempts replications were attempted within the
+	// window, and at least PauseErrorPercent of them failed, replication is
+	// paused for the account until it is resumed through the API. A zero value
+	// for PauseErrorPercent disables this behavior.
+	PauseErrorPercent int
+	PauseMinAttempts  int
+}
+
+// CredentialReportConfig controls the credential usage reports that are
+// generated for each account (see type CredentialReport).
+type CredentialReportConfig struct {
+	// RBAC policies that have not been used for this many days are reported as
+	// unused, unless a different threshold is requested through the API.
+	UnusedDays int
+	// If not nil, the janitor periodically submits the credential reports of all
+	// accounts with unused RBAC policies to this webhook.
+	WebhookURL *url.URL
+}
+
+// CachePolicy controls the Cache-Control headers on blob and manifest
+// responses of the Registry API, to allow CDNs and caching proxies in front of
+// Keppel to serve repeated pulls. Zero values mean that the respective
+// responses are marked as "no-cache".
+type CachePolicy struct {
+	// Applies to blobs and to manifests that are addressed by digest. Since
+	// their contents can never change, they are additionally marked as immutable.
+	// This should still not be too long since quarantined content can only be
+	// revoked once cached copies have expired.
+	DigestMaxAge time.Duration
+	// Applies to manifests that are addressed by tag. This should be short since
+	// the tag can be moved to a different manifest at any time.
+	TagMaxAge time.Duration
+	// If true, responses are marked as "public" instead of "private", i.e. shared
+	// caches may serve them to other clients. Only enable this if the shared
+	// caches perform their own authorization of incoming requests.
+	Public bool
+}
+
+// BlobRedirectPolicy controls when blob pulls on the Registry API are answered
+// with a redirect to a URL generated by the storage driver (see
+// StorageDriver.URLForBlob) instead of streaming the blob contents through
+// keppel-api. Redirects to a CDN (see CDNDriver) are not affected by this.
+type BlobRedirectPolicy struct {
+	// If true, blob contents are always streamed through keppel-api.
+	Disabled bool
+	// Blobs smaller than this are always streamed through keppel-api, since
+	// the additional roundtrip for the redirect is not worth it for them.
+	MinSizeBytes uint64
+}
+
+// AppliesTo returns whether a redirect to the storage shall be attempted
+// when the given blob is pulled.
+func (p BlobRedirectPolicy) AppliesTo(blob models.Blob) bool {
+	return !p.Disabled && blob.SizeBytes >= p.MinSizeBytes
+}
+
+// CacheControlHeader returns the value for the Cache-Control header of a blob
+// or manifest response.
+func (p CachePolicy) CacheControlHeader(isDigestAddressed bool) string {
+	maxAge := p.TagMaxAge
+	if isDigestAddressed {
+		maxAge = p.DigestMaxAge
+	}
+	maxAgeSecs := int64(maxAge / time.Second)
+	if maxAgeSecs <= 0 {
+		return "no-cache"
+	}
+
+	visibility := "private"
+	if p.Public {
+		visibility = "public"
+	}
+	result := fmt.Sprintf("%s, max-age=%d", visibility, maxAgeSecs)
+	if isDigestAddressed {
+		result += ", immutable"
+	}
+	return result
 }
 
 var (
@@ -86,17 +252,30 @@ func GetDatabaseURLFromEnvironment() (dbURL url.URL, dbName string) {
 // ParseConfiguration obtains a keppel.Configuration instance from the
 // corresponding environment variables. Aborts on error.
 func ParseConfiguration() Configuration {
+	cfg, errs := ParseConfigurationFromEnvironment()
+	errs.LogFatalIfError()
+	return cfg
+}
+
+// ParseConfigurationFromEnvironment is like ParseConfiguration, but returns
+// all errors instead of aborting.
+func ParseConfigurationFromEnvironment() (cfg Configuration, errs errext.ErrorSet) {
 	logg.Debug("parsing configuration...")
 
-	cfg := Configuration{
-		APIPublicHostname:        osext.MustGetenv("KEPPEL_API_PUBLIC_FQDN"),
+	cfg = Configuration{
+		APIPublicHostname:        needGetenv(&errs, "KEPPEL_API_PUBLIC_FQDN"),
 		AnycastAPIPublicHostname: os.Getenv("KEPPEL_API_ANYCAST_FQDN"),
 	}
 
 	parseIssuerKeys := func(prefix string) []crypto.PrivateKey {
-		key, err := ParseIssuerKey(osext.MustGetenv(prefix + "_ISSUER_KEY"))
+		keyStr := needGetenv(&errs, prefix+"_ISSUER_KEY")
+		if keyStr == "" {
+			return nil
+		}
+		key, err := ParseIssuerKey(keyStr)
 		if err != nil {
-			logg.Fatal("failed to read %s_ISSUER_KEY: %s", prefix, err.Error())
+			errs.Addf("failed to read %s_ISSUER_KEY: %s", prefix, err.Error())
+			return nil
 		}
 		prevKeyStr := os.Getenv(prefix + "_PREVIOUS_ISSUER_KEY")
 		if prevKeyStr == "" {
@@ -104,7 +283,8 @@ func ParseConfiguration() Configuration {
 		}
 		prevKey, err := ParseIssuerKey(prevKeyStr)
 		if err != nil {
-			logg.Fatal("failed to read %s_PREVIOUS_ISSUER_KEY: %s", prefix, err.Error())
+			errs.Addf("failed to read %s_PREVIOUS_ISSUER_KEY: %s", prefix, err.Error())
+			return nil
 		}
 		return []crypto.PrivateKey{key, prevKey}
 	}
@@ -114,31 +294,258 @@ func ParseConfiguration() Configuration {
 		cfg.AnycastJWTIssuerKeys = parseIssuerKeys("KEPPEL_ANYCAST")
 	}
 
-	trivyURL := mayGetenvURL("KEPPEL_TRIVY_URL")
+	trivyURL := mayGetenvURL(&errs, "KEPPEL_TRIVY_URL")
 	if trivyURL != nil {
 		additionalPullableRepos := strings.Split(os.Getenv("KEPPEL_TRIVY_ADDITIONAL_PULLABLE_REPOS"), ",")
 		cfg.Trivy = &trivy.Config{
 			AdditionalPullableRepos: additionalPullableRepos,
-			Token:                   osext.MustGetenv("KEPPEL_TRIVY_TOKEN"),
+			Token:                   needGetenv(&errs, "KEPPEL_TRIVY_TOKEN"),
 			URL:                     *trivyURL,
+			ScanTimeout:             getenvDurationOrDefault(&errs, "KEPPEL_TRIVY_SCAN_TIMEOUT", trivy.DefaultScanTimeout),
+			MaxScanTimeout:          getenvDurationOrDefault(&errs, "KEPPEL_TRIVY_MAX_SCAN_TIMEOUT", 1*time.Hour),
+		}
+		if cfg.Trivy.ScanTimeout < time.Minute {
+			errs.Addf("malformed KEPPEL_TRIVY_SCAN_TIMEOUT: must be at least 1 minute")
+		}
+		if cfg.Trivy.MaxScanTimeout < cfg.Trivy.ScanTimeout {
+			errs.Addf("malformed KEPPEL_TRIVY_MAX_SCAN_TIMEOUT: must not be shorter than KEPPEL_TRIVY_SCAN_TIMEOUT")
 		}
 	}
 
-	return cfg
+	cfg.RequestLimits = RequestLimits{
+		MaxJSONBodySizeBytes:            getenvInt64OrDefault(&errs, "KEPPEL_API_MAX_JSON_BODY_SIZE_BYTES", 1<<20),
+		JSONBodyReadTimeout:             getenvDurationOrDefault(&errs, "KEPPEL_API_JSON_BODY_READ_TIMEOUT", 30*time.Second),
+		MaxManifestBodySizeBytes:        getenvInt64OrDefault(&errs, "KEPPEL_API_MAX_MANIFEST_BODY_SIZE_BYTES", 4<<20),
+		ManifestBodyReadTimeout:         getenvDurationOrDefault(&errs, "KEPPEL_API_MANIFEST_BODY_READ_TIMEOUT", 30*time.Second),
+		MaxConcurrentRequestsPerAccount: int(getenvInt64OrDefault(&errs, "KEPPEL_API_MAX_CONCURRENT_REQUESTS_PER_ACCOUNT", 0)),
+		ReadHeaderTimeout:               getenvDurationOrDefault(&errs, "KEPPEL_API_READ_HEADER_TIMEOUT", 10*time.Second),
+		IdleTimeout:                     getenvDurationOrDefault(&errs, "KEPPEL_API_IDLE_TIMEOUT", 2*time.Minute),
+	}
+
+	cfg.UpstreamPolicy = UpstreamPolicy{
+		RetryMaxAttempts:        int(getenvInt64OrDefault(&errs, "KEPPEL_UPSTREAM_RETRY_MAX_ATTEMPTS", 3)),
+		RetryInitialBackoff:     getenvDurationOrDefault(&errs, "KEPPEL_UPSTREAM_RETRY_INITIAL_BACKOFF", 200*time.Millisecond),
+		RetryMaxBackoff:         getenvDurationOrDefault(&errs, "KEPPEL_UPSTREAM_RETRY_MAX_BACKOFF", 5*time.Second),
+		CircuitBreakerThreshold: int(getenvInt64OrDefault(&errs, "KEPPEL_UPSTREAM_CIRCUIT_BREAKER_THRESHOLD", 10)),
+		CircuitBreakerCooldown:  getenvDurationOrDefault(&errs, "KEPPEL_UPSTREAM_CIRCUIT_BREAKER_COOLDOWN", time.Minute),
+	}
+
+	cfg.ReplicationErrorBudget = ReplicationErrorBudget{
+		Window:            getenvDurationOrDefault(&errs, "KEPPEL_REPLICATION_ERROR_BUDGET_WINDOW", time.Hour),
+		PauseErrorPercent: int(getenvInt64OrDefault(&errs, "KEPPEL_REPLICATION_PAUSE_ERROR_PERCENT", 0)),
+		PauseMinAttempts:  int(getenvInt64OrDefault(&errs, "KEPPEL_REPLICATION_PAUSE_MIN_ATTEMPTS", 20)),
+	}
+	if cfg.ReplicationErrorBudget.Window < time.Minute {
+		errs.Addf("malformed KEPPEL_REPLICATION_ERROR_BUDGET_WINDOW: must be at least 1 minute")
+	}
+	if cfg.ReplicationErrorBudget.PauseErrorPercent > 100 {
+		errs.Addf("malformed KEPPEL_REPLICATION_PAUSE_ERROR_PERCENT: must be between 0 and 100")
+	}
+
+	cfg.CachePolicy = CachePolicy{
+		DigestMaxAge: getenvDurationOrDefault(&errs, "KEPPEL_API_CACHE_DIGEST_MAX_AGE", 10*time.Minute),
+		TagMaxAge:    getenvDurationOrDefault(&errs, "KEPPEL_API_CACHE_TAG_MAX_AGE", 0),
+		Public:       osext.GetenvBool("KEPPEL_API_CACHE_PUBLIC"),
+	}
+
+	cfg.BlobRedirectPolicy = BlobRedirectPolicy{
+		Disabled:     osext.GetenvBool("KEPPEL_API_BLOB_REDIRECT_DISABLE"),
+		MinSizeBytes: AtLeastZero(getenvInt64OrDefault(&errs, "KEPPEL_API_BLOB_REDIRECT_MIN_SIZE_BYTES", 0)),
+	}
+
+	cfg.ManifestLayerCountWarningThreshold = int(getenvInt64OrDefault(&errs, "KEPPEL_MANIFEST_LAYER_COUNT_WARNING_THRESHOLD", 100))
+
+	cfg.AccountRequestsEnabled = osext.GetenvBool("KEPPEL_ACCOUNT_REQUESTS_ENABLE")
+
+	cfg.BackupSnapshotInterval = getenvDurationOrDefault(&errs, "KEPPEL_BACKUP_SNAPSHOT_INTERVAL", time.Hour)
+	if cfg.BackupSnapshotInterval < time.Minute {
+		errs.Addf("malformed KEPPEL_BACKUP_SNAPSHOT_INTERVAL: must be at least 1 minute")
+	}
+
+	cfg.OrphanedSegmentDeletionDelay = getenvDurationOrDefault(&errs, "KEPPEL_ORPHANED_SEGMENT_DELETION_DELAY", 0)
+	if cfg.OrphanedSegmentDeletionDelay != 0 && cfg.OrphanedSegmentDeletionDelay < time.Hour {
+		errs.Addf("malformed KEPPEL_ORPHANED_SEGMENT_DELETION_DELAY: must be at least 1 hour")
+	}
+
+	cfg.PullAttestationRetention = getenvDurationOrDefault(&errs, "KEPPEL_PULL_ATTESTATION_RETENTION", 365*24*time.Hour)
+	if cfg.PullAttestationRetention < 24*time.Hour {
+		errs.Addf("malformed KEPPEL_PULL_ATTESTATION_RETENTION: must be at least 24 hours")
+	}
+
+	cfg.CredentialReport = CredentialReportConfig{
+		UnusedDays: int(getenvInt64OrDefault(&errs, "KEPPEL_CREDENTIAL_REPORT_UNUSED_DAYS", 90)),
+		WebhookURL: mayGetenvURL(&errs, "KEPPEL_CREDENTIAL_REPORT_WEBHOOK_URL"),
+	}
+	if cfg.CredentialReport.UnusedDays < 1 {
+		errs.Addf("malformed KEPPEL_CREDENTIAL_REPORT_UNUSED_DAYS: must be at least 1")
+	}
+
+	for _, val := range strings.Split(os.Getenv("KEPPEL_DISTRIBUTION_NOTIFICATION_URLS"), ",") {
+		val = strings.TrimSpace(val)
+		if val == "" {
+			continue
+		}
+		parsed, err := url.Parse(val)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			errs.Addf("malformed KEPPEL_DISTRIBUTION_NOTIFICATION_URLS: %q is not an http:// or https:// URL", val)
+			continue
+		}
+		cfg.DistributionNotificationURLs = append(cfg.DistributionNotificationURLs, *parsed)
+	}
+
+	telemetryURL := mayGetenvURL(&errs, "KEPPEL_TELEMETRY_URL")
+	if telemetryURL != nil {
+		if telemetryURL.Scheme != "https" {
+			errs.Addf("malformed KEPPEL_TELEMETRY_URL: must be an https:// URL")
+		}
+		cfg.Telemetry = &TelemetryConfig{
+			URL:      *telemetryURL,
+			Interval: getenvDurationOrDefault(&errs, "KEPPEL_TELEMETRY_INTERVAL", 24*time.Hour),
+		}
+		if cfg.Telemetry.Interval < time.Hour {
+			errs.Addf("malformed KEPPEL_TELEMETRY_INTERVAL: must be at least 1 hour")
+		}
+	}
+
+	cfg.DatabaseMaintenance = DatabaseMaintenanceConfig{
+		Interval:              getenvDurationOrDefault(&errs, "KEPPEL_DB_MAINTENANCE_INTERVAL", time.Hour),
+		BloatThresholdPercent: int(getenvInt64OrDefault(&errs, "KEPPEL_DB_MAINTENANCE_BLOAT_THRESHOLD_PERCENT", 30)),
+	}
+	if cfg.DatabaseMaintenance.Interval < time.Minute {
+		errs.Addf("malformed KEPPEL_DB_MAINTENANCE_INTERVAL: must be at least 1 minute")
+	}
+	if cfg.DatabaseMaintenance.BloatThresholdPercent < 1 || cfg.DatabaseMaintenance.BloatThresholdPercent > 100 {
+		errs.Addf("malformed KEPPEL_DB_MAINTENANCE_BLOAT_THRESHOLD_PERCENT: must be between 1 and 100")
+	}
+	maintenanceWindows, err := ParseMaintenanceWindows(os.Getenv("KEPPEL_DB_MAINTENANCE_WINDOWS"))
+	if err != nil {
+		errs.Addf("malformed KEPPEL_DB_MAINTENANCE_WINDOWS: %s", err.Error())
+	}
+	cfg.DatabaseMaintenance.Windows = maintenanceWindows
+
+	admissionWebhookURL := mayGetenvURL(&errs, "KEPPEL_ADMISSION_WEBHOOK_URL")
+	if admissionWebhookURL != nil {
+		if admissionWebhookURL.Scheme != "https" {
+			errs.Addf("malformed KEPPEL_ADMISSION_WEBHOOK_URL: must be an https:// URL")
+		}
+		cfg.AdmissionWebhook = &AdmissionWebhook{
+			URL:      *admissionWebhookURL,
+			Token:    os.Getenv("KEPPEL_ADMISSION_WEBHOOK_TOKEN"),
+			Timeout:  getenvDurationOrDefault(&errs, "KEPPEL_ADMISSION_WEBHOOK_TIMEOUT", 5*time.Second),
+			FailOpen: osext.GetenvBool("KEPPEL_ADMISSION_WEBHOOK_FAIL_OPEN"),
+		}
+		if cfg.AdmissionWebhook.Timeout == 0 {
+			errs.Addf("malformed KEPPEL_ADMISSION_WEBHOOK_TIMEOUT: must not be zero")
+		}
+	}
+
+	cfg.ReservedAccountNames = mayGetenvPatterns(&errs, "KEPPEL_RESERVED_ACCOUNT_NAMES")
+	cfg.ReservedRepositoryNames = mayGetenvPatterns(&errs, "KEPPEL_RESERVED_REPOSITORY_NAMES")
+
+	cfg.ExternalUpstreamHosts = ExternalUpstreamHostPolicy{
+		AllowedHosts: mayGetenvPatterns(&errs, "KEPPEL_EXTERNAL_REPLICA_ALLOWED_HOSTS"),
+		BlockedHosts: mayGetenvPatterns(&errs, "KEPPEL_EXTERNAL_REPLICA_BLOCKED_HOSTS"),
+	}
+
+	for val := range strings.SplitSeq(os.Getenv("KEPPEL_WEBHOOK_ALLOWED_NETWORKS"), ",") {
+		val = strings.TrimSpace(val)
+		if val == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(val)
+		if err != nil {
+			errs.Addf("malformed KEPPEL_WEBHOOK_ALLOWED_NETWORKS: %s", err.Error())
+			continue
+		}
+		cfg.WebhookTargets.AllowedNetworks = append(cfg.WebhookTargets.AllowedNetworks, prefix)
+	}
+
+	cfg.AccountMetadataSchema, err = mayGetenvAccountMetadataSchema("KEPPEL_ACCOUNT_METADATA_SCHEMA_PATH")
+	if err != nil {
+		errs.Addf("malformed KEPPEL_ACCOUNT_METADATA_SCHEMA_PATH: %s", err.Error())
+	}
+
+	return cfg, errs
 }
 
-func mayGetenvURL(key string) *url.URL {
+// Like osext.MustGetenv, but reports a missing variable into the given ErrorSet.
+func needGetenv(errs *errext.ErrorSet, key string) string {
+	val, err := osext.NeedGetenv(key)
+	if err != nil {
+		errs.Add(err)
+	}
+	return val
+}
+
+func getenvInt64OrDefault(errs *errext.ErrorSet, key string, defaultValue int64) int64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || parsed < 0 {
+		errs.Addf("malformed %s: expected a non-negative integer, but got %q", key, val)
+		return defaultValue
+	}
+	return parsed
+}
+
+func getenvDurationOrDefault(errs *errext.ErrorSet, key string, defaultValue time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(val)
+	if err != nil || parsed < 0 {
+		errs.Addf("malformed %s: expected a non-negative duration like \"30s\", but got %q", key, val)
+		return defaultValue
+	}
+	return parsed
+}
+
+func mayGetenvURL(errs *errext.ErrorSet, key string) *url.URL {
 	val := os.Getenv(key)
 	if val == "" {
 		return nil
 	}
 	parsed, err := url.Parse(val)
 	if err != nil {
-		logg.Fatal("malformed %s: %s", key, err.Error())
+		errs.Addf("malformed %s: %s", key, err.Error())
+		return nil
 	}
 	return parsed
 }
 
+// Parses a comma-separated list of regexes. Each regex is anchored on both
+// sides, so that it needs to match the entire name.
+func mayGetenvPatterns(errs *errext.ErrorSet, key string) []*regexp.Regexp {
+	var result []*regexp.Regexp
+	for _, val := range strings.Split(os.Getenv(key), ",") {
+		val = strings.TrimSpace(val)
+		if val == "" {
+			continue
+		}
+		rx, err := regexp.Compile(`^(?:` + val + `)$`)
+		if err != nil {
+			errs.Addf("malformed %s: %s", key, err.Error())
+			continue
+		}
+		result = append(result, rx)
+	}
+	return result
+}
+
+// GetRateLimitIPv6PrefixLength reads the KEPPEL_RATELIMIT_IPV6_PREFIX_LENGTH
+// variable, which fills RateLimitEngine.IPv6PrefixLength.
+func GetRateLimitIPv6PrefixLength() (int, error) {
+	valStr := osext.GetenvOrDefault("KEPPEL_RATELIMIT_IPV6_PREFIX_LENGTH", strconv.Itoa(DefaultIPv6PrefixLength))
+	val, err := strconv.Atoi(valStr)
+	if err != nil || val < 1 || val > 128 {
+		return 0, fmt.Errorf("invalid value for KEPPEL_RATELIMIT_IPV6_PREFIX_LENGTH: %q (expected an integer between 1 and 128)", valStr)
+	}
+	return val, nil
+}
+
 // GetRedisOptions returns a redis.Options by getting the required parameters
 // from environment variables:
 //
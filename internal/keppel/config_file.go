@@ -0,0 +1,385 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sapcc/go-bits/errext"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/osext"
+	"gopkg.in/yaml.v3"
+)
+
+// The configuration file is an alternative to setting the KEPPEL_* environment
+// variables one by one. Its structure mirrors the names of those variables,
+// e.g. the setting {"api":{"public_fqdn":"registry.example.org"}} is
+// equivalent to KEPPEL_API_PUBLIC_FQDN=registry.example.org. The special
+// section "environment" holds variables without the KEPPEL_ prefix (e.g. the
+// OS_* variables used by the OpenStack drivers) verbatim. The file is YAML,
+// so JSON is accepted as well.
+//
+// Variables that are set in the actual environment take precedence over the
+// configuration file, so that individual settings can be overridden e.g. for
+// debugging.
+//
+// Only documented variables can be set in the configuration file. The list of
+// those variables is generated from the docs into config_file_variables.go.
+
+type configFileReloadHook struct {
+	Names []string
+	Apply func() error
+}
+
+// Changes to these variables are applied when the configuration file is
+// reloaded (see WatchConfigFile). All other settings are only read on startup.
+var configFileReloadHooks = []configFileReloadHook{{
+	Names: []string{"KEPPEL_DEBUG"},
+	Apply: func() error {
+		logg.ShowDebug = osext.GetenvBool("KEPPEL_DEBUG")
+		return nil
+	},
+}}
+
+// OnConfigFileReload registers a function that is called when any of the
+// given variables has been changed by a reload of the configuration file. It
+// shall re-read those variables from the environment and start using the new
+// values. If it returns an error, the previous values of the variables are
+// restored and the error is logged.
+func OnConfigFileReload(apply func() error, names ...string) {
+	configFileMutex.Lock()
+	defer configFileMutex.Unlock()
+	configFileReloadHooks = append(configFileReloadHooks, configFileReloadHook{names, apply})
+}
+
+var (
+	configFileKeyRx         = regexp.MustCompile(`^[a-z0-9]+(?:_[a-z0-9]+)*$`)
+	configFileEnvironmentRx = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+)
+
+// ParseConfigFile reads the configuration file at the given path and returns
+// the environment variables that it sets. All problems in the file are
+// reported at once.
+func ParseConfigFile(path string) (map[string]string, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	var data map[string]any
+	err = dec.Decode(&data)
+	if err != nil && !errors.Is(err, io.EOF) { // io.EOF means that the file is empty
+		return nil, fmt.Errorf("while parsing %s: %w", path, err)
+	}
+	if err := dec.Decode(new(any)); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("while parsing %s: expected only a single YAML document", path)
+	}
+
+	result := make(map[string]string)
+	var errs errext.ErrorSet
+	for _, key := range slices.Sorted(maps.Keys(data)) {
+		if key != "environment" {
+			collectConfigFileVariables(result, &errs, key, "KEPPEL_"+strings.ToUpper(key), data[key])
+			continue
+		}
+		env, ok := data[key].(map[string]any)
+		if !ok {
+			errs.Addf(`"environment" must be an object`)
+			continue
+		}
+		for _, name := range slices.Sorted(maps.Keys(env)) {
+			switch {
+			case !configFileEnvironmentRx.MatchString(name):
+				errs.Addf("environment.%s is not a valid environment variable name", name)
+			case strings.HasPrefix(name, "KEPPEL_"):
+				errs.Addf("environment.%s is not allowed (KEPPEL_* variables must be given as structured settings)", name)
+			default:
+				value, ok := env[name].(string)
+				if !ok {
+					errs.Addf("environment.%s must be a string", name)
+					continue
+				}
+				result[name] = value
+			}
+		}
+	}
+	if !errs.IsEmpty() {
+		return nil, fmt.Errorf("invalid configuration in %s: %s", path, errs.Join("; "))
+	}
+	return result, nil
+}
+
+func collectConfigFileVariables(result map[string]string, errs *errext.ErrorSet, path, envName string, value any) {
+	lastKey := path[strings.LastIndex(path, ".")+1:]
+	if !configFileKeyRx.MatchString(lastKey) {
+		errs.Addf("%s is not a valid key (expected lowercase letters, digits and single underscores)", path)
+		return
+	}
+
+	// objects are sections that group settings
+	if _, ok := value.(map[any]any); ok {
+		errs.Addf("%s contains keys that are not strings", path)
+		return
+	}
+	if section, ok := value.(map[string]any); ok {
+		for _, key := range slices.Sorted(maps.Keys(section)) {
+			collectConfigFileVariables(result, errs, path+"."+key, envName+"_"+strings.ToUpper(key), section[key])
+		}
+		return
+	}
+
+	// everything else is an actual setting
+	if !isKnownConfigFileVariable(envName) {
+		errs.Addf("%s is not a known setting", path)
+		return
+	}
+	if _, exists := result[envName]; exists {
+		errs.Addf("%s is set more than once (as %s)", path, envName)
+		return
+	}
+	switch value := value.(type) {
+	case string:
+		result[envName] = value
+	case bool:
+		result[envName] = fmt.Sprint(value)
+	case int, int64, uint64:
+		result[envName] = fmt.Sprint(value)
+	case float64:
+		errs.Addf("%s must be an integer, but is %v", path, value)
+	case []any:
+		items := make([]string, len(value))
+		for idx, item := range value {
+			str, ok := item.(string)
+			if !ok {
+				errs.Addf("%s[%d] must be a string", path, idx)
+				return
+			}
+			items[idx] = str
+		}
+		result[envName] = strings.Join(items, ",")
+	default:
+		errs.Addf("%s must be a string, boolean, integer or list of strings", path)
+	}
+}
+
+func isKnownConfigFileVariable(name string) bool {
+	if slices.Contains(configFileVariables, name) {
+		return true
+	}
+	for _, prefix := range configFileVariablePrefixes {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	configFileMutex sync.Mutex
+	// variables that were set from the configuration file (as opposed to the environment)
+	configFileAppliedValues = make(map[string]string)
+)
+
+// LoadConfigFile reads the configuration file given in KEPPEL_CONFIG_FILE (if
+// any) and sets the environment variables from it, except for those that are
+// already set in the environment. If a configuration file was loaded before,
+// its values are replaced. This needs to be called before any other
+// configuration is read.
+func LoadConfigFile() error {
+	path := os.Getenv("KEPPEL_CONFIG_FILE")
+	if path == "" {
+		return nil
+	}
+	values, err := ParseConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	configFileMutex.Lock()
+	defer configFileMutex.Unlock()
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		_, wasFromFile := configFileAppliedValues[name]
+		if _, isSet := os.LookupEnv(name); isSet && !wasFromFile {
+			logg.Info("%s is set in the environment, so the value from %s is ignored", name, path)
+			continue
+		}
+		err := os.Setenv(name, values[name])
+		if err != nil {
+			return err
+		}
+		configFileAppliedValues[name] = values[name]
+	}
+	return nil
+}
+
+// WatchConfigFile reloads the configuration file given in KEPPEL_CONFIG_FILE
+// whenever the process receives SIGHUP, until the context expires. Changes to
+// settings with a hook registered through OnConfigFileReload are applied
+// immediately. Changes to other settings are only reported, since they
+// require a restart to take effect.
+func WatchConfigFile(ctx context.Context) {
+	path := os.Getenv("KEPPEL_CONFIG_FILE")
+	if path == "" {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				err := reloadConfigFile(path)
+				if err != nil {
+					logg.Error("could not reload configuration from %s: %s", path, err.Error())
+				}
+			}
+		}
+	}()
+}
+
+func reloadConfigFile(path string) error {
+	values, err := ParseConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	configFileMutex.Lock()
+	defer configFileMutex.Unlock()
+
+	var changedNames []string
+	for name := range values {
+		if value, exists := configFileAppliedValues[name]; !exists || value != values[name] {
+			changedNames = append(changedNames, name)
+		}
+	}
+	for name := range configFileAppliedValues {
+		if _, exists := values[name]; !exists {
+			changedNames = append(changedNames, name)
+		}
+	}
+	slices.Sort(changedNames)
+
+	// only variables from the file can be reloaded (the environment takes precedence)
+	changed := make(map[string]bool, len(changedNames))
+	for _, name := range changedNames {
+		_, wasFromFile := configFileAppliedValues[name]
+		if _, isSet := os.LookupEnv(name); isSet && !wasFromFile {
+			continue
+		}
+		changed[name] = true
+	}
+
+	var errs errext.ErrorSet
+	handled := make(map[string]bool, len(changed))
+	for _, hook := range configFileReloadHooks {
+		names := slices.DeleteFunc(slices.Clone(hook.Names), func(name string) bool { return !changed[name] })
+		if len(names) == 0 {
+			continue
+		}
+		err := applyConfigFileReloadHook(hook, names, values)
+		if err != nil {
+			errs.Addf("could not apply changes to %s: %w", strings.Join(names, ", "), err)
+		} else {
+			logg.Info("reloaded %s from %s", strings.Join(names, ", "), path)
+		}
+		for _, name := range names {
+			handled[name] = true
+		}
+	}
+
+	var ignoredNames []string
+	for _, name := range changedNames {
+		if changed[name] && !handled[name] {
+			ignoredNames = append(ignoredNames, name)
+		}
+	}
+	if len(ignoredNames) > 0 {
+		logg.Info("changes to %s in %s will only take effect after a restart", strings.Join(ignoredNames, ", "), path)
+	}
+
+	if !errs.IsEmpty() {
+		return errors.New(errs.Join("; "))
+	}
+	return nil
+}
+
+// Sets the given variables to their new values and calls the hook. If the
+// hook fails, the previous values are restored.
+func applyConfigFileReloadHook(hook configFileReloadHook, names []string, values map[string]string) error {
+	type previousValue struct {
+		Value string
+		IsSet bool
+	}
+	previous := make(map[string]previousValue, len(names))
+	for _, name := range names {
+		value, isSet := os.LookupEnv(name)
+		previous[name] = previousValue{value, isSet}
+		value, exists := values[name]
+		err := setConfigFileVariable(name, value, exists)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := hook.Apply()
+	if err == nil {
+		return nil
+	}
+	for name, prev := range previous {
+		rerr := setConfigFileVariable(name, prev.Value, prev.IsSet)
+		if rerr != nil {
+			return fmt.Errorf("%w (and then could not restore %s: %w)", err, name, rerr)
+		}
+	}
+	return err
+}
+
+func setConfigFileVariable(name, value string, exists bool) error {
+	if !exists {
+		delete(configFileAppliedValues, name)
+		return os.Unsetenv(name)
+	}
+	configFileAppliedValues[name] = value
+	return os.Setenv(name, value)
+}
+
+// ValidateConfigFile checks the configuration file at the given path. Besides
+// the structural checks from ParseConfigFile, the settings are applied to the
+// environment of this process and then checked like on startup, so that
+// errors in the settings themselves are reported as well. All errors are
+// reported at once.
+func ValidateConfigFile(path string) error {
+	err := os.Setenv("KEPPEL_CONFIG_FILE", path)
+	if err != nil {
+		return err
+	}
+	err = LoadConfigFile()
+	if err != nil {
+		return err
+	}
+	_, errs := ParseConfigurationFromEnvironment()
+	_, err = GetRateLimitIPv6PrefixLength()
+	if err != nil {
+		errs.Add(err)
+	}
+	if !errs.IsEmpty() {
+		return fmt.Errorf("invalid configuration in %s: %s", path, errs.Join("; "))
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/logg"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keppel.yaml")
+	err := os.WriteFile(path, []byte(contents), 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return path
+}
+
+func TestParseConfigFile(t *testing.T) {
+	expected := map[string]string{
+		"KEPPEL_API_PUBLIC_FQDN":              "registry.example.org",
+		"KEPPEL_API_MAX_JSON_BODY_SIZE_BYTES": "1048576",
+		"KEPPEL_API_CACHE_PUBLIC":             "true",
+		"KEPPEL_API_CACHE_TAG_MAX_AGE":        "5m",
+		"KEPPEL_DRIVER_AUTH":                  "keystone",
+		"KEPPEL_RESERVED_ACCOUNT_NAMES":       "admin,keppel-.*",
+		"KEPPEL_SECRETS_OS_AUTH_URL":          "https://keystone.example.org/v3",
+		"OS_AUTH_URL":                         "https://keystone.example.org/v3",
+	}
+
+	path := writeConfigFile(t, `
+api:
+  public_fqdn: registry.example.org
+  max_json_body_size_bytes: 1048576
+  cache:
+    public: true
+    tag_max_age: 5m
+driver:
+  auth: keystone
+reserved_account_names:
+  - admin
+  - keppel-.*
+secrets:
+  os_auth_url: https://keystone.example.org/v3
+environment:
+  OS_AUTH_URL: https://keystone.example.org/v3
+`)
+	values, err := ParseConfigFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "parsed values from YAML", values, expected)
+
+	// since YAML is a superset of JSON, JSON files work as well
+	path = writeConfigFile(t, `{
+  "api": {
+    "public_fqdn": "registry.example.org",
+    "max_json_body_size_bytes": 1048576,
+    "cache": { "public": true, "tag_max_age": "5m" }
+  },
+  "driver": { "auth": "keystone" },
+  "reserved_account_names": ["admin", "keppel-.*"],
+  "secrets": { "os_auth_url": "https://keystone.example.org/v3" },
+  "environment": { "OS_AUTH_URL": "https://keystone.example.org/v3" }
+}`)
+	values, err = ParseConfigFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "parsed values from JSON", values, expected)
+
+	// an empty file does not set anything
+	values, err = ParseConfigFile(writeConfigFile(t, ""))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "parsed values from empty file", values, map[string]string{})
+}
+
+func TestParseConfigFileErrors(t *testing.T) {
+	path := writeConfigFile(t, `
+api:
+  public_fqdn: registry.example.org
+  public: { fqdn: registry.example.com }
+  max_json_body_size_bytes: 1.5
+  listen_adress: ":8080"
+  Idle_Timeout: 2m
+  cors:
+    allowed_origins: ["*", 42]
+db:
+  port: null
+  maintenance: { 1: foo }
+environment:
+  KEPPEL_DEBUG: "true"
+  os-auth-url: foo
+`)
+	_, err := ParseConfigFile(path)
+	if err == nil {
+		t.Fatal("expected error, but got none")
+	}
+	for _, expected := range []string{
+		"api.Idle_Timeout is not a valid key",
+		"api.cors.allowed_origins[1] must be a string",
+		"api.listen_adress is not a known setting",
+		"api.max_json_body_size_bytes must be an integer, but is 1.5",
+		"api.public_fqdn is set more than once (as KEPPEL_API_PUBLIC_FQDN)",
+		"db.maintenance contains keys that are not strings",
+		"db.port must be a string, boolean, integer or list of strings",
+		"environment.KEPPEL_DEBUG is not allowed",
+		"environment.os-auth-url is not a valid environment variable name",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, but got: %s", expected, err.Error())
+		}
+	}
+
+	// only a single document is allowed
+	_, err = ParseConfigFile(writeConfigFile(t, "debug: true\n---\ndebug: false\n"))
+	if err == nil || !strings.Contains(err.Error(), "expected only a single YAML document") {
+		t.Errorf("expected error about multiple documents, but got: %v", err)
+	}
+}
+
+func TestReloadConfigFile(t *testing.T) {
+	showDebug := logg.ShowDebug
+	hooks := slices.Clone(configFileReloadHooks)
+	t.Cleanup(func() { logg.ShowDebug = showDebug })
+	t.Cleanup(func() { configFileReloadHooks = hooks })
+	t.Cleanup(func() { clear(configFileAppliedValues) })
+
+	path := writeConfigFile(t, "debug: false\napi:\n  public_fqdn: registry.example.org\n  cors:\n    max_age: 1m\n")
+	t.Setenv("KEPPEL_CONFIG_FILE", path)
+	for _, name := range []string{"KEPPEL_DEBUG", "KEPPEL_API_PUBLIC_FQDN", "KEPPEL_API_CORS_MAX_AGE", "KEPPEL_API_CORS_ALLOWED_ORIGINS"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	t.Setenv("KEPPEL_API_ANYCAST_FQDN", "anycast.example.org")
+
+	err := LoadConfigFile()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "KEPPEL_API_PUBLIC_FQDN", os.Getenv("KEPPEL_API_PUBLIC_FQDN"), "registry.example.org")
+	logg.ShowDebug = false
+
+	// this hook stands in for a component that reads the CORS settings
+	var (
+		maxAge         = os.Getenv("KEPPEL_API_CORS_MAX_AGE")
+		allowedOrigins = os.Getenv("KEPPEL_API_CORS_ALLOWED_ORIGINS")
+	)
+	OnConfigFileReload(func() error {
+		if os.Getenv("KEPPEL_API_CORS_MAX_AGE") == "invalid" {
+			return errors.New("malformed KEPPEL_API_CORS_MAX_AGE")
+		}
+		maxAge = os.Getenv("KEPPEL_API_CORS_MAX_AGE")
+		allowedOrigins = os.Getenv("KEPPEL_API_CORS_ALLOWED_ORIGINS")
+		return nil
+	}, "KEPPEL_API_CORS_MAX_AGE", "KEPPEL_API_CORS_ALLOWED_ORIGINS")
+
+	// reloadable settings are applied on reload, others are not
+	err = os.WriteFile(path, []byte("debug: true\napi:\n  public_fqdn: registry.example.com\n  anycast_fqdn: other.example.org\n  cors:\n    allowed_origins: [https://example.org]\n"), 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = reloadConfigFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "logg.ShowDebug", logg.ShowDebug, true)
+	assert.DeepEqual(t, "KEPPEL_API_PUBLIC_FQDN", os.Getenv("KEPPEL_API_PUBLIC_FQDN"), "registry.example.org")
+	assert.DeepEqual(t, "KEPPEL_API_ANYCAST_FQDN", os.Getenv("KEPPEL_API_ANYCAST_FQDN"), "anycast.example.org")
+	assert.DeepEqual(t, "max age in hook", maxAge, "")
+	assert.DeepEqual(t, "allowed origins in hook", allowedOrigins, "https://example.org")
+
+	// if a hook fails, its variables are restored, but other changes still apply
+	err = os.WriteFile(path, []byte("debug: false\napi:\n  public_fqdn: registry.example.org\n  cors:\n    allowed_origins: [https://example.com]\n    max_age: invalid\n"), 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = reloadConfigFile(path)
+	if err == nil || !strings.Contains(err.Error(), "malformed KEPPEL_API_CORS_MAX_AGE") {
+		t.Errorf("expected reload to fail because of the hook, but got: %v", err)
+	}
+	assert.DeepEqual(t, "logg.ShowDebug", logg.ShowDebug, false)
+	assert.DeepEqual(t, "KEPPEL_API_CORS_ALLOWED_ORIGINS", os.Getenv("KEPPEL_API_CORS_ALLOWED_ORIGINS"), "https://example.org")
+	_, isSet := os.LookupEnv("KEPPEL_API_CORS_MAX_AGE")
+	assert.DeepEqual(t, "KEPPEL_API_CORS_MAX_AGE is set", isSet, false)
+	assert.DeepEqual(t, "allowed origins in hook", allowedOrigins, "https://example.org")
+
+	// an invalid file is rejected without changing anything
+	err = os.WriteFile(path, []byte("debug: true\nno_such: setting\n"), 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = reloadConfigFile(path)
+	if err == nil {
+		t.Error("expected reload of invalid configuration file to fail")
+	}
+	assert.DeepEqual(t, "logg.ShowDebug", logg.ShowDebug, false)
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	t.Cleanup(func() { clear(configFileAppliedValues) })
+	t.Setenv("KEPPEL_CONFIG_FILE", "")
+	for _, name := range []string{"KEPPEL_API_PUBLIC_FQDN", "KEPPEL_ISSUER_KEY", "KEPPEL_TRIVY_URL"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	// all problems are reported at once
+	path := writeConfigFile(t, "api:\n  public_fqdn: registry.example.org\ntrivy:\n  url: https://trivy.example.org\n  scan_timeout: forever\n")
+	err := ValidateConfigFile(path)
+	if err == nil {
+		t.Fatal("expected error, but got none")
+	}
+	for _, expected := range []string{
+		`environment variable "KEPPEL_ISSUER_KEY" is not set`,
+		`malformed KEPPEL_TRIVY_SCAN_TIMEOUT: expected a non-negative duration like "30s", but got "forever"`,
+		`environment variable "KEPPEL_TRIVY_TOKEN" is not set`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, but got: %s", expected, err.Error())
+		}
+	}
+}
+
+var (
+	docsTableVariableRx = regexp.MustCompile("`(KEPPEL_[^`]*)`")
+	goVariableLiteralRx = regexp.MustCompile(`"(KEPPEL_[A-Z0-9_]+)"`)
+	placeholderRx       = regexp.MustCompile(`<|\.\.\.|\*`)
+)
+
+// These variables are not settings, so they cannot be set in the configuration file.
+var variablesExcludedFromConfigFile = []string{
+	"KEPPEL_CONFIG_FILE",
+	"KEPPEL_RUN_DB_SETUP_FOR_CONFORMANCE_TEST",
+}
+
+// TestConfigFileVariablesAreUpToDate generates config_file_variables.go from
+// the variables documented in the first column of the tables in docs/. If the
+// file is outdated, the new version is written next to it (use `make
+// copy-fixtures` to apply it).
+func TestConfigFileVariablesAreUpToDate(t *testing.T) {
+	var variables, prefixes []string
+	err := filepath.WalkDir("../../docs", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".md") {
+			return err
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for line := range strings.Lines(string(buf)) {
+			if !strings.HasPrefix(line, "|") {
+				continue
+			}
+			firstColumn, _, _ := strings.Cut(strings.TrimPrefix(line, "|"), "|")
+			for _, match := range docsTableVariableRx.FindAllStringSubmatch(firstColumn, -1) {
+				name := match[1]
+				if slices.Contains(variablesExcludedFromConfigFile, name) {
+					continue
+				}
+				if loc := placeholderRx.FindStringIndex(name); loc != nil {
+					prefixes = append(prefixes, name[:loc[0]])
+				} else {
+					variables = append(variables, name)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	slices.Sort(variables)
+	variables = slices.Compact(variables)
+	slices.Sort(prefixes)
+	prefixes = slices.Compact(prefixes)
+
+	var buf bytes.Buffer
+	buf.WriteString("// SPDX-FileCopyrightText: 2026 SAP SE\n// SPDX-License-Identifier: Apache-2.0\n\n")
+	buf.WriteString("// Code generated by TestConfigFileVariablesAreUpToDate from the tables in docs/. DO NOT EDIT.\n\n")
+	buf.WriteString("package keppel\n\n")
+	buf.WriteString("// Only these variables can be set in the configuration file (in addition to\n// those matching configFileVariablePrefixes).\n")
+	buf.WriteString("var configFileVariables = []string{\n")
+	for _, name := range variables {
+		fmt.Fprintf(&buf, "\t%q,\n", name)
+	}
+	buf.WriteString("}\n\n")
+	buf.WriteString("// Variables with these prefixes are documented with a placeholder (e.g. for\n// driver-specific OS_* variables), so their exact names are not known upfront.\n")
+	buf.WriteString("var configFileVariablePrefixes = []string{\n")
+	for _, prefix := range prefixes {
+		fmt.Fprintf(&buf, "\t%q,\n", prefix)
+	}
+	buf.WriteString("}\n")
+
+	expected, err := os.ReadFile("config_file_variables.go")
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(expected, buf.Bytes()) {
+		t.Error("config_file_variables.go does not match the docs, the new version was written to config_file_variables.go.actual (use `make copy-fixtures` to apply it)")
+		err := os.WriteFile("config_file_variables.go.actual", buf.Bytes(), 0o666)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// every variable that is read somewhere in the code must be documented
+	err = filepath.WalkDir("../..", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "vendor" || strings.HasPrefix(d.Name(), ".") && path != "../.." {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, match := range goVariableLiteralRx.FindAllStringSubmatch(string(buf), -1) {
+			name := match[1]
+			isKnown := slices.Contains(variablesExcludedFromConfigFile, name) ||
+				slices.ContainsFunc(variables, func(v string) bool { return strings.HasPrefix(v, name) }) ||
+				slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(name, p) || strings.HasPrefix(p, name) })
+			if !isKnown {
+				t.Errorf("%s is used in %s, but not documented in a table in docs/", name, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
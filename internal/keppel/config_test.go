@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestCachePolicy(t *testing.T) {
+	testCases := []struct {
+		Policy            CachePolicy
+		IsDigestAddressed bool
+		Expected          string
+	}{
+		{CachePolicy{}, true, "no-cache"},
+		{CachePolicy{}, false, "no-cache"},
+		{CachePolicy{DigestMaxAge: 365 * 24 * time.Hour}, true, "private, max-age=31536000, immutable"},
+		{CachePolicy{DigestMaxAge: 365 * 24 * time.Hour}, false, "no-cache"},
+		{CachePolicy{DigestMaxAge: time.Hour, TagMaxAge: 30 * time.Second, Public: true}, true, "public, max-age=3600, immutable"},
+		{CachePolicy{DigestMaxAge: time.Hour, TagMaxAge: 30 * time.Second, Public: true}, false, "public, max-age=30"},
+		{CachePolicy{TagMaxAge: 500 * time.Millisecond}, false, "no-cache"},
+	}
+	for _, tc := range testCases {
+		actual := tc.Policy.CacheControlHeader(tc.IsDigestAddressed)
+		if actual != tc.Expected {
+			t.Errorf("expected %#v.CacheControlHeader(%t) = %q, but got %q", tc.Policy, tc.IsDigestAddressed, tc.Expected, actual)
+		}
+	}
+}
+
+func TestBlobRedirectPolicy(t *testing.T) {
+	testCases := []struct {
+		Policy    BlobRedirectPolicy
+		SizeBytes uint64
+		Expected  bool
+	}{
+		{BlobRedirectPolicy{}, 0, true},
+		{BlobRedirectPolicy{}, 1 << 30, true},
+		{BlobRedirectPolicy{Disabled: true}, 1 << 30, false},
+		{BlobRedirectPolicy{MinSizeBytes: 1 << 20}, 1<<20 - 1, false},
+		{BlobRedirectPolicy{MinSizeBytes: 1 << 20}, 1 << 20, true},
+		{BlobRedirectPolicy{Disabled: true, MinSizeBytes: 1 << 20}, 1 << 20, false},
+	}
+	for _, tc := range testCases {
+		actual := tc.Policy.AppliesTo(models.Blob{SizeBytes: tc.SizeBytes})
+		if actual != tc.Expected {
+			t.Errorf("expected %#v.AppliesTo(blob with %d bytes) = %t, but got %t", tc.Policy, tc.SizeBytes, tc.Expected, actual)
+		}
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	windows, err := ParseMaintenanceWindows("02:00-04:00, 22:30-01:00")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cfg := DatabaseMaintenanceConfig{Windows: windows}
+
+	testCases := []struct {
+		Time     time.Time
+		Expected bool
+	}{
+		{time.Date(2026, 1, 1, 1, 59, 59, 0, time.UTC), false},
+		{time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC), true},
+		{time.Date(2026, 1, 1, 3, 59, 59, 0, time.UTC), true},
+		{time.Date(2026, 1, 1, 4, 0, 0, 0, time.UTC), false},
+		{time.Date(2026, 1, 1, 22, 29, 0, 0, time.UTC), false},
+		{time.Date(2026, 1, 1, 23, 15, 0, 0, time.UTC), true},
+		{time.Date(2026, 1, 1, 0, 30, 0, 0, time.UTC), true},
+		{time.Date(2026, 1, 1, 1, 0, 0, 0, time.UTC), false},
+		// windows are in UTC regardless of the time zone of the input
+		{time.Date(2026, 1, 1, 3, 0, 0, 0, time.FixedZone("UTC+1", 3600)), true},
+	}
+	for _, tc := range testCases {
+		actual := cfg.IsInMaintenanceWindow(tc.Time)
+		if actual != tc.Expected {
+			t.Errorf("expected IsInMaintenanceWindow(%s) = %t, but got %t", tc.Time, tc.Expected, actual)
+		}
+	}
+
+	for _, input := range []string{"02:00", "02:00-25:00", "2am-4am", "03:00-03:00"} {
+		_, err := ParseMaintenanceWindows(input)
+		if err == nil {
+			t.Errorf("expected ParseMaintenanceWindows(%q) to fail, but it succeeded", input)
+		}
+	}
+}
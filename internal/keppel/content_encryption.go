@@ -0,0 +1,276 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// Manifest contents can be encrypted at rest in the `manifest_contents` table
+// with a per-account data key. The data key is not stored in the database.
+// Instead, models.Account.ContentEncryptionKeyRef refers to the secret store
+// behind SecretsDriver, and each encrypted row records the reference of the
+// key that it was encrypted with. Rows with an empty key reference contain
+// plaintext, so encryption can be enabled on existing accounts without
+// rewriting all existing contents at once.
+//
+// Encrypted contents consist of the random nonce, followed by the AES-GCM
+// ciphertext. The manifest digest is used as additional authenticated data,
+// so that encrypted contents cannot be swapped between rows unnoticed.
+
+const contentEncryptionKeySize = 32 // AES-256
+
+// ErrMalformedContentEncryptionKey is returned by ParseContentEncryptionKey.
+// It deliberately does not say what exactly is wrong with the secret, to avoid
+// revealing anything about the secret's contents.
+var ErrMalformedContentEncryptionKey = fmt.Errorf("content encryption key must be a base64-encoded key of %d bytes", contentEncryptionKeySize)
+
+// ParseContentEncryptionKey parses the contents of the secret referenced by
+// models.Account.ContentEncryptionKeyRef. The secret must contain a
+// base64-encoded key of 32 bytes.
+func ParseContentEncryptionKey(secret string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(secret))
+	if err != nil || len(key) != contentEncryptionKeySize {
+		return nil, ErrMalformedContentEncryptionKey
+	}
+	return key, nil
+}
+
+// EncryptManifestContent encrypts the given manifest contents for storage in
+// the `manifest_contents` table, using the data key referenced by keyRef
+// (which is resolved within the namespace of the account's auth tenant). If
+// keyRef is empty, the contents are returned unchanged.
+func EncryptManifestContent(ctx context.Context, secd SecretsDriver, authTenantID, keyRef string, manifestDigest digest.Digest, plaintext []byte) ([]byte, error) {
+	if keyRef == "" {
+		return plaintext, nil
+	}
+	aead, err := getContentEncryptionAEAD(ctx, secd, authTenantID, keyRef)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(manifestDigest)), nil
+}
+
+// DecryptManifestContent reverses EncryptManifestContent.
+func DecryptManifestContent(ctx context.Context, secd SecretsDriver, authTenantID, keyRef string, manifestDigest digest.Digest, content []byte) ([]byte, error) {
+	if keyRef == "" {
+		return content, nil
+	}
+	aead, err := getContentEncryptionAEAD(ctx, secd, authTenantID, keyRef)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < aead.NonceSize() {
+		return nil, fmt.Errorf("cannot decrypt contents of manifest %s: ciphertext too short", manifestDigest)
+	}
+	nonce, ciphertext := content[:aead.NonceSize()], content[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(manifestDigest))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt contents of manifest %s: %w", manifestDigest, err)
+	}
+	return plaintext, nil
+}
+
+var readManifestContentQuery = sqlext.SimplifyWhitespace(`
+	SELECT mc.content, mc.encryption_key_ref, a.auth_tenant_id
+	  FROM manifest_contents mc
+	  JOIN repos r ON r.id = mc.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	 WHERE mc.repo_id = $1 AND mc.digest = $2
+`)
+
+// ReadManifestContent reads the contents of the given manifest from the
+// `manifest_contents` table, and decrypts them if necessary. If there is no
+// such row, sql.ErrNoRows is returned.
+func ReadManifestContent(ctx context.Context, db gorp.SqlExecutor, secd SecretsDriver, repoID int64, manifestDigest digest.Digest) ([]byte, error) {
+	var (
+		content      []byte
+		keyRef       string
+		authTenantID string
+	)
+	err := db.QueryRow(readManifestContentQuery, repoID, manifestDigest).Scan(&content, &keyRef, &authTenantID)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptManifestContent(ctx, secd, authTenantID, keyRef, manifestDigest, content)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// manifest copies in the storage backend
+
+// The copies of manifests in the storage backend are encrypted with the same
+// data key as the contents in the database. Since there is no separate place
+// to record the key reference for these copies, it is stored in a header in
+// front of the ciphertext:
+//
+//	"keppel-encrypted-v1:" + base64url(keyRef) + "\n" + nonce + ciphertext
+//
+// Plaintext manifests cannot start with this header since they are JSON documents.
+const storedManifestEncryptionHeader = "keppel-encrypted-v1:"
+
+// EncodeManifestForStorage encrypts the given manifest contents for storage
+// in the storage backend, using the data key referenced by keyRef. If keyRef
+// is empty, the contents are returned unchanged.
+func EncodeManifestForStorage(ctx context.Context, secd SecretsDriver, authTenantID, keyRef string, manifestDigest digest.Digest, plaintext []byte) ([]byte, error) {
+	if keyRef == "" {
+		return plaintext, nil
+	}
+	ciphertext, err := EncryptManifestContent(ctx, secd, authTenantID, keyRef, manifestDigest, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return WrapEncryptedManifestContent(keyRef, ciphertext), nil
+}
+
+// WrapEncryptedManifestContent converts encrypted contents from the
+// `manifest_contents` table (as returned by EncryptManifestContent) into the
+// format used for manifest copies in the storage backend and in backups. This
+// does not require access to the data key.
+func WrapEncryptedManifestContent(keyRef string, ciphertext []byte) []byte {
+	header := storedManifestEncryptionHeader + base64.RawURLEncoding.EncodeToString([]byte(keyRef)) + "\n"
+	return append([]byte(header), ciphertext...)
+}
+
+// UnwrapEncryptedManifestContent reverses WrapEncryptedManifestContent. If the
+// given contents are not encrypted, they are returned unchanged with an empty
+// keyRef.
+func UnwrapEncryptedManifestContent(manifestDigest digest.Digest, content []byte) (keyRef string, ciphertext []byte, err error) {
+	rest, isEncrypted := bytes.CutPrefix(content, []byte(storedManifestEncryptionHeader))
+	if !isEncrypted {
+		return "", content, nil
+	}
+	encodedKeyRef, ciphertext, ok := bytes.Cut(rest, []byte("\n"))
+	if !ok {
+		return "", nil, fmt.Errorf("cannot decrypt contents of manifest %s: malformed encryption header", manifestDigest)
+	}
+	keyRefBytes, err := base64.RawURLEncoding.DecodeString(string(encodedKeyRef))
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot decrypt contents of manifest %s: malformed encryption header: %w", manifestDigest, err)
+	}
+	if len(keyRefBytes) == 0 {
+		return "", nil, fmt.Errorf("cannot decrypt contents of manifest %s: malformed encryption header: empty key reference", manifestDigest)
+	}
+	return string(keyRefBytes), ciphertext, nil
+}
+
+// DecodeManifestFromStorage reverses EncodeManifestForStorage. Besides the
+// plaintext, the reference of the key that the contents were encrypted with
+// is returned (or the empty string if the contents were stored in plaintext).
+func DecodeManifestFromStorage(ctx context.Context, secd SecretsDriver, authTenantID string, manifestDigest digest.Digest, content []byte) (plaintext []byte, keyRef string, err error) {
+	keyRef, ciphertext, err := UnwrapEncryptedManifestContent(manifestDigest, content)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext, err = DecryptManifestContent(ctx, secd, authTenantID, keyRef, manifestDigest, ciphertext)
+	return plaintext, keyRef, err
+}
+
+// ReadManifestFromStorage reads a manifest from the storage backend, and
+// decrypts it if necessary.
+func ReadManifestFromStorage(ctx context.Context, sd StorageDriver, secd SecretsDriver, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) ([]byte, error) {
+	content, err := sd.ReadManifest(ctx, account, repoName, manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, _, err := DecodeManifestFromStorage(ctx, secd, account.AuthTenantID, manifestDigest, content)
+	return plaintext, err
+}
+
+// WriteManifestToStorage writes a manifest into the storage backend. If the
+// account has a content encryption key, the manifest is encrypted with it.
+func WriteManifestToStorage(ctx context.Context, sd StorageDriver, secd SecretsDriver, account models.ReducedAccount, repoName string, manifestDigest digest.Digest, plaintext []byte) error {
+	content, err := EncodeManifestForStorage(ctx, secd, account.AuthTenantID, account.ContentEncryptionKeyRef, manifestDigest, plaintext)
+	if err != nil {
+		return err
+	}
+	return sd.WriteManifest(ctx, account, repoName, manifestDigest, content)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// key cache
+
+// Data keys are cached for a short time to avoid hitting the secret store on
+// every manifest push or pull.
+const contentEncryptionKeyCacheTTL = 5 * time.Minute
+
+type contentEncryptionKeyCacheKey struct {
+	SecretsDriver SecretsDriver
+	AuthTenantID  string
+	KeyRef        string
+}
+
+type contentEncryptionKeyCacheEntry struct {
+	AEAD      cipher.AEAD
+	ExpiresAt time.Time
+}
+
+var (
+	contentEncryptionKeyCache      = make(map[contentEncryptionKeyCacheKey]contentEncryptionKeyCacheEntry)
+	contentEncryptionKeyCacheMutex sync.Mutex
+)
+
+func getContentEncryptionAEAD(ctx context.Context, secd SecretsDriver, authTenantID, keyRef string) (cipher.AEAD, error) {
+	if secd == nil {
+		return nil, errors.New("cannot use content encryption key without a secrets driver")
+	}
+	cacheKey := contentEncryptionKeyCacheKey{secd, authTenantID, keyRef}
+	now := time.Now()
+
+	contentEncryptionKeyCacheMutex.Lock()
+	entry, exists := contentEncryptionKeyCache[cacheKey]
+	contentEncryptionKeyCacheMutex.Unlock()
+	if exists && entry.ExpiresAt.After(now) {
+		return entry.AEAD, nil
+	}
+
+	secret, err := secd.ReadSecret(ctx, authTenantID, keyRef)
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain content encryption key %q: %w", keyRef, err)
+	}
+	key, err := ParseContentEncryptionKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	contentEncryptionKeyCacheMutex.Lock()
+	defer contentEncryptionKeyCacheMutex.Unlock()
+	// drop expired entries, so that keys which are not used anymore (e.g. after
+	// a key rotation) do not stay in memory forever
+	for k, v := range contentEncryptionKeyCache {
+		if !v.ExpiresAt.After(now) {
+			delete(contentEncryptionKeyCache, k)
+		}
+	}
+	contentEncryptionKeyCache[cacheKey] = contentEncryptionKeyCacheEntry{aead, now.Add(contentEncryptionKeyCacheTTL)}
+	return aead, nil
+}
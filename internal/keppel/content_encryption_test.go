@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// key = auth tenant ID + "/" + ref
+type staticSecretsDriver map[string]string
+
+func (d staticSecretsDriver) PluginTypeID() string                              { return "static" }
+func (d staticSecretsDriver) Init(ctx context.Context, cfg Configuration) error { return nil }
+
+func (d staticSecretsDriver) ReadSecret(ctx context.Context, authTenantID, ref string) (string, error) {
+	secret, ok := d[authTenantID+"/"+ref]
+	if !ok {
+		return "", fmt.Errorf("no such secret: %q", ref)
+	}
+	return secret, nil
+}
+
+func TestManifestContentEncryption(t *testing.T) {
+	ctx := context.Background()
+	secd := &staticSecretsDriver{
+		"tenant1/key1":    base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+		"tenant1/key2":    base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)),
+		"tenant1/invalid": base64.StdEncoding.EncodeToString([]byte("too short")),
+	}
+	plaintext := []byte(`{"schemaVersion":2}`)
+	digest1 := digest.FromBytes(plaintext)
+	digest2 := digest.FromString("something else")
+
+	// without a key reference, contents are stored in plaintext
+	content, err := EncryptManifestContent(ctx, secd, "tenant1", "", digest1, plaintext)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(content, plaintext) {
+		t.Errorf("expected plaintext to be stored unchanged, but got %q", string(content))
+	}
+
+	// with a key reference, contents are encrypted and can be decrypted with the same key
+	content, err = EncryptManifestContent(ctx, secd, "tenant1", "key1", digest1, plaintext)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if bytes.Contains(content, plaintext) {
+		t.Errorf("expected contents to be encrypted, but got %q", string(content))
+	}
+	decrypted, err := DecryptManifestContent(ctx, secd, "tenant1", "key1", digest1, content)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("expected decryption to yield %q, but got %q", string(plaintext), string(decrypted))
+	}
+
+	// decryption fails with a different key or for a different manifest
+	_, err = DecryptManifestContent(ctx, secd, "tenant1", "key2", digest1, content)
+	if err == nil {
+		t.Error("expected decryption with the wrong key to fail, but it succeeded")
+	}
+	_, err = DecryptManifestContent(ctx, secd, "tenant1", "key1", digest2, content)
+	if err == nil {
+		t.Error("expected decryption for the wrong digest to fail, but it succeeded")
+	}
+
+	// keys are resolved within the namespace of the respective auth tenant
+	_, err = DecryptManifestContent(ctx, secd, "tenant2", "key1", digest1, content)
+	if err == nil {
+		t.Error("expected decryption with a key reference from a different tenant to fail, but it succeeded")
+	}
+
+	// invalid or missing keys are reported
+	_, err = EncryptManifestContent(ctx, secd, "tenant1", "invalid", digest1, plaintext)
+	expected := "content encryption key must be a base64-encoded key of 32 bytes"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, but got %v", expected, err)
+	}
+	_, err = EncryptManifestContent(ctx, secd, "tenant1", "missing", digest1, plaintext)
+	expected = `cannot obtain content encryption key "missing": no such secret: "missing"`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, but got %v", expected, err)
+	}
+}
+
+func TestStoredManifestEncryption(t *testing.T) {
+	ctx := context.Background()
+	secd := &staticSecretsDriver{
+		"tenant1/key1": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+		"tenant1/key2": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)),
+	}
+	plaintext := []byte(`{"schemaVersion":2}`)
+	manifestDigest := digest.FromBytes(plaintext)
+
+	// plaintext copies are read back unchanged
+	content, err := EncodeManifestForStorage(ctx, secd, "tenant1", "", manifestDigest, plaintext)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	decoded, keyRef, err := DecodeManifestFromStorage(ctx, secd, "tenant1", manifestDigest, content)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(decoded, plaintext) || keyRef != "" {
+		t.Errorf("expected plaintext copy to be decoded unchanged, but got %q with key %q", string(decoded), keyRef)
+	}
+
+	// encrypted copies record the key that was used, so that they can still be
+	// read after the account has switched to a different key
+	for _, expectedKeyRef := range []string{"key1", "key2"} {
+		content, err := EncodeManifestForStorage(ctx, secd, "tenant1", expectedKeyRef, manifestDigest, plaintext)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if bytes.Contains(content, plaintext) {
+			t.Errorf("expected stored copy to be encrypted, but got %q", string(content))
+		}
+		decoded, keyRef, err := DecodeManifestFromStorage(ctx, secd, "tenant1", manifestDigest, content)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !bytes.Equal(decoded, plaintext) || keyRef != expectedKeyRef {
+			t.Errorf("expected %q with key %q, but got %q with key %q", string(plaintext), expectedKeyRef, string(decoded), keyRef)
+		}
+
+		// the key reference is resolved within the tenant that reads the copy
+		_, _, err = DecodeManifestFromStorage(ctx, secd, "tenant2", manifestDigest, content)
+		if err == nil {
+			t.Error("expected decoding in a different tenant to fail, but it succeeded")
+		}
+	}
+
+	// malformed headers are reported
+	_, _, err = DecodeManifestFromStorage(ctx, secd, "tenant1", manifestDigest, []byte(storedManifestEncryptionHeader+"a2V5MQ"))
+	expected := "cannot decrypt contents of manifest " + manifestDigest.String() + ": malformed encryption header"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, but got %v", expected, err)
+	}
+}
+
+func TestContentEncryptionKeyCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	secd := &staticSecretsDriver{
+		"tenant1/key1": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+	}
+	expiredKey := contentEncryptionKeyCacheKey{secd, "tenant1", "rotated-away"}
+	contentEncryptionKeyCacheMutex.Lock()
+	contentEncryptionKeyCache[expiredKey] = contentEncryptionKeyCacheEntry{nil, time.Now().Add(-time.Second)}
+	contentEncryptionKeyCacheMutex.Unlock()
+
+	// loading a key evicts expired entries, even for keys that are not used anymore
+	_, err := getContentEncryptionAEAD(ctx, secd, "tenant1", "key1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	contentEncryptionKeyCacheMutex.Lock()
+	_, isExpiredKeyCached := contentEncryptionKeyCache[expiredKey]
+	_, isKeyCached := contentEncryptionKeyCache[contentEncryptionKeyCacheKey{secd, "tenant1", "key1"}]
+	contentEncryptionKeyCacheMutex.Unlock()
+	if isExpiredKeyCached {
+		t.Error("expected expired cache entry to be evicted")
+	}
+	if !isKeyCached {
+		t.Error("expected loaded key to be cached")
+	}
+}
@@ -0,0 +1,276 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/lib/pq"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// Above this number of pending records, further RBAC policy usages are not
+// recorded until the next flush, to bound memory usage if the DB is unreachable.
+const rbacPolicyUsageMaxPending = 10000
+
+// The WHERE EXISTS skips records for accounts that were deleted in the meantime.
+var flushRBACPolicyUsageQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO rbac_policy_usage (account_name, policy_fingerprint, first_seen_at, last_used_at)
+	SELECT $1::TEXT, $2::TEXT, $3::TIMESTAMPTZ, $4::TIMESTAMPTZ WHERE EXISTS (SELECT 1 FROM accounts WHERE name = $1)
+	ON CONFLICT (account_name, policy_fingerprint) DO UPDATE
+	SET last_used_at = GREATEST(rbac_policy_usage.last_used_at, EXCLUDED.last_used_at)
+`)
+
+type rbacPolicyUsageKey struct {
+	AccountName       models.AccountName
+	PolicyFingerprint string
+}
+
+type rbacPolicyUsageUpdate struct {
+	FirstUsedAt time.Time
+	LastUsedAt  time.Time
+}
+
+// RBACPolicyUsageRecorder collects usages of RBAC policies (see
+// models.RBACPolicyUsage) in memory, and writes them into the DB in batches.
+// This way, authorizing a request does not cause additional DB writes.
+//
+// All methods can be called on a nil RBACPolicyUsageRecorder and do nothing
+// in that case.
+type RBACPolicyUsageRecorder struct {
+	db      *DB
+	mutex   sync.Mutex
+	pending map[rbacPolicyUsageKey]rbacPolicyUsageUpdate
+}
+
+// NewRBACPolicyUsageRecorder builds a RBACPolicyUsageRecorder. Recorded usages
+// are only written into the DB on Flush(), or periodically when Run() is
+// called.
+func NewRBACPolicyUsageRecorder(db *DB) *RBACPolicyUsageRecorder {
+	return &RBACPolicyUsageRecorder{
+		db:      db,
+		pending: make(map[rbacPolicyUsageKey]rbacPolicyUsageUpdate),
+	}
+}
+
+// Record remembers that the given RBAC policy of the given account has been
+// used for authorizing a request.
+func (r *RBACPolicyUsageRecorder) Record(accountName models.AccountName, policy RBACPolicy, now time.Time) {
+	if r == nil {
+		return
+	}
+
+	key := rbacPolicyUsageKey{accountName, policy.Fingerprint()}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	update, exists := r.pending[key]
+	if !exists {
+		if len(r.pending) >= rbacPolicyUsageMaxPending {
+			logg.Error("dropping usage of RBAC policy in account %s: too many pending records", accountName)
+			return
+		}
+		update.FirstUsedAt = now
+	}
+	update.LastUsedAt = now
+	r.pending[key] = update
+}
+
+// Flush writes all pending records into the DB.
+func (r *RBACPolicyUsageRecorder) Flush() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+	pending := r.pending
+	r.pending = make(map[rbacPolicyUsageKey]rbacPolicyUsageUpdate, len(pending))
+	r.mutex.Unlock()
+
+	// the number of records is bounded by the number of RBAC policies that are
+	// in use, so there is no need for batching
+	keys := slices.SortedFunc(maps.Keys(pending), func(lhs, rhs rbacPolicyUsageKey) int {
+		return cmp.Or(
+			cmp.Compare(lhs.AccountName, rhs.AccountName),
+			cmp.Compare(lhs.PolicyFingerprint, rhs.PolicyFingerprint),
+		)
+	})
+	for idx, key := range keys {
+		update := pending[key]
+		_, err := r.db.Exec(flushRBACPolicyUsageQuery, key.AccountName, key.PolicyFingerprint, update.FirstUsedAt, update.LastUsedAt)
+		if err != nil {
+			// keep the unwritten records around for the next attempt
+			r.restore(pending, keys[idx:])
+			return fmt.Errorf("could not write %d RBAC policy usage records: %w", len(keys)-idx, err)
+		}
+	}
+	return nil
+}
+
+// restore merges records that could not be written back into r.pending.
+func (r *RBACPolicyUsageRecorder) restore(updates map[rbacPolicyUsageKey]rbacPolicyUsageUpdate, keys []rbacPolicyUsageKey) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, key := range keys {
+		update := updates[key]
+		if newer, exists := r.pending[key]; exists {
+			update.LastUsedAt = newer.LastUsedAt
+		} else if len(r.pending) >= rbacPolicyUsageMaxPending {
+			continue
+		}
+		r.pending[key] = update
+	}
+}
+
+// Run flushes pending records at the given interval until the given context
+// expires. Remaining records are flushed before returning.
+func (r *RBACPolicyUsageRecorder) Run(ctx context.Context, interval time.Duration) {
+	if r == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			err := r.Flush()
+			if err != nil {
+				logg.Error(err.Error())
+			}
+			return
+		case <-ticker.C:
+			err := r.Flush()
+			if err != nil {
+				logg.Error(err.Error())
+			}
+		}
+	}
+}
+
+var (
+	observeRBACPolicyQuery = sqlext.SimplifyWhitespace(`
+		INSERT INTO rbac_policy_usage (account_name, policy_fingerprint, first_seen_at) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`)
+	pruneRBACPolicyUsageQuery = sqlext.SimplifyWhitespace(`
+		DELETE FROM rbac_policy_usage WHERE account_name = $1 AND NOT (policy_fingerprint = ANY($2))
+	`)
+)
+
+// ObserveRBACPolicies ensures that usage records exist for all current RBAC
+// policies of the given account, so that unused policies can be detected
+// even if they have never been used at all. Usage records for policies that
+// no longer exist are removed.
+func ObserveRBACPolicies(db gorp.SqlExecutor, account models.Account, now time.Time) error {
+	policies, err := ParseRBACPolicies(account)
+	if err != nil {
+		return fmt.Errorf("cannot parse RBAC policies: %w", err)
+	}
+	fingerprints := make([]string, len(policies))
+	for idx, policy := range policies {
+		fingerprints[idx] = policy.Fingerprint()
+		_, err := db.Exec(observeRBACPolicyQuery, account.Name, fingerprints[idx], now)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(pruneRBACPolicyUsageQuery, account.Name, pq.Array(fingerprints))
+	return err
+}
+
+// CredentialReport is the API representation of the credential usage report of
+// an account. It lists all credentials of the account together with when they
+// were last used, to support the cleanup of stale credentials.
+//
+// Since Keppel does not have robot accounts or long-lived credentials of its
+// own (user credentials are managed by the auth driver, and peer credentials
+// are rotated automatically), the RBAC policies of the account are the only
+// credentials covered by this report.
+type CredentialReport struct {
+	UnusedDays   int                     `json:"unused_days"`
+	RBACPolicies []RBACPolicyUsageReport `json:"rbac_policies"`
+}
+
+// RBACPolicyUsageReport appears in type CredentialReport.
+type RBACPolicyUsageReport struct {
+	Policy RBACPolicy `json:"policy"`
+	// FirstSeenAt and LastUsedAt are nil if Keppel has not observed this policy yet.
+	FirstSeenAt    *int64 `json:"first_seen_at"`
+	LastUsedAt     *int64 `json:"last_used_at"`
+	IsUnused       bool   `json:"unused"`
+	Recommendation string `json:"recommendation,omitempty"`
+}
+
+// HasUnusedCredentials returns whether the report lists any unused credentials.
+func (r CredentialReport) HasUnusedCredentials() bool {
+	for _, p := range r.RBACPolicies {
+		if p.IsUnused {
+			return true
+		}
+	}
+	return false
+}
+
+// CredentialReportNotification is the request body that Keppel sends to the
+// credential report webhook (see CredentialReportConfig.WebhookURL).
+type CredentialReportNotification struct {
+	Account models.AccountName `json:"account"`
+	Report  CredentialReport   `json:"report"`
+}
+
+// BuildCredentialReport builds the CredentialReport for the given account.
+// Credentials are considered unused if they have not been used within the
+// given number of days.
+func BuildCredentialReport(db gorp.SqlExecutor, account models.Account, unusedDays int, now time.Time) (CredentialReport, error) {
+	policies, err := ParseRBACPolicies(account)
+	if err != nil {
+		return CredentialReport{}, fmt.Errorf("cannot parse RBAC policies: %w", err)
+	}
+
+	var usages []models.RBACPolicyUsage
+	_, err = db.Select(&usages, `SELECT * FROM rbac_policy_usage WHERE account_name = $1`, account.Name)
+	if err != nil {
+		return CredentialReport{}, err
+	}
+	usageByFingerprint := make(map[string]models.RBACPolicyUsage, len(usages))
+	for _, u := range usages {
+		usageByFingerprint[u.PolicyFingerprint] = u
+	}
+
+	threshold := now.AddDate(0, 0, -unusedDays)
+	report := CredentialReport{
+		UnusedDays:   unusedDays,
+		RBACPolicies: make([]RBACPolicyUsageReport, len(policies)),
+	}
+	for idx, policy := range policies {
+		entry := RBACPolicyUsageReport{Policy: policy}
+		usage, exists := usageByFingerprint[policy.Fingerprint()]
+		if exists {
+			entry.FirstSeenAt = MaybeTimeToUnix(&usage.FirstSeenAt)
+			entry.LastUsedAt = MaybeTimeToUnix(usage.LastUsedAt)
+			switch {
+			case usage.LastUsedAt == nil && usage.FirstSeenAt.Before(threshold):
+				entry.IsUnused = true
+				entry.Recommendation = fmt.Sprintf("This policy has not been used since it was first seen on %s. Consider removing it.",
+					usage.FirstSeenAt.UTC().Format(time.DateOnly))
+			case usage.LastUsedAt != nil && usage.LastUsedAt.Before(threshold):
+				entry.IsUnused = true
+				entry.Recommendation = fmt.Sprintf("This policy has not been used since %s. Consider removing it.",
+					usage.LastUsedAt.UTC().Format(time.DateOnly))
+			}
+		}
+		report.RBACPolicies[idx] = entry
+	}
+	return report, nil
+}
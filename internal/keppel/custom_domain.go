@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// CustomDomain represents the custom domain of an account in the API.
+type CustomDomain struct {
+	Hostname string `json:"hostname"`
+	// If not empty, this is a reference into the secret store behind
+	// SecretsDriver, where the PEM-encoded TLS certificate chain and private key
+	// for this hostname are stored.
+	CertificateRef string `json:"certificate_ref,omitempty"`
+	// Read-only. Ignored when the account is updated.
+	Verification *CustomDomainVerification `json:"verification,omitempty"`
+}
+
+// CustomDomainVerification appears in type CustomDomain.
+type CustomDomainVerification struct {
+	// Either "pending" or "verified".
+	Status string `json:"status"`
+	// The owner of the custom domain must publish a TXT record with this name
+	// and value to prove that they control the domain.
+	RecordName  string `json:"txt_record_name"`
+	RecordValue string `json:"txt_record_value"`
+}
+
+// CustomDomainVerificationRecordName returns the name of the DNS TXT record
+// that proves ownership of the given custom domain.
+func CustomDomainVerificationRecordName(hostname string) string {
+	return "_keppel-challenge." + hostname
+}
+
+// RenderCustomDomain builds a CustomDomain object out of the information in
+// the given account model.
+func RenderCustomDomain(account models.Account) *CustomDomain {
+	if account.CustomDomain == "" {
+		return nil
+	}
+	status := "pending"
+	if account.CustomDomainVerifiedAt != nil {
+		status = "verified"
+	}
+	return &CustomDomain{
+		Hostname:       account.CustomDomain,
+		CertificateRef: account.CustomDomainCertificateRef,
+		Verification: &CustomDomainVerification{
+			Status:      status,
+			RecordName:  CustomDomainVerificationRecordName(account.CustomDomain),
+			RecordValue: account.CustomDomainVerificationToken,
+		},
+	}
+}
+
+var hostnameRx = regexp.MustCompile(`^(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ApplyToAccount validates this custom domain and stores it in the given
+// account model. The certificate is not checked here since that requires
+// access to the SecretsDriver; use ParseCustomDomainCertificate for that.
+func (d CustomDomain) ApplyToAccount(account *models.Account, cfg Configuration) *RegistryV2Error {
+	if len(d.Hostname) > 253 || !hostnameRx.MatchString(d.Hostname) {
+		err := fmt.Errorf("invalid hostname for custom domain: %q", d.Hostname)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+
+	// hostnames below our own public hostnames are reserved for domain-remapped APIs
+	for _, ownHostname := range []string{cfg.APIPublicHostname, cfg.AnycastAPIPublicHostname} {
+		if ownHostname != "" && (d.Hostname == ownHostname || strings.HasSuffix(d.Hostname, "."+ownHostname)) {
+			err := fmt.Errorf("custom domain %q overlaps with the domain of this registry", d.Hostname)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+	}
+
+	account.CustomDomain = d.Hostname
+	account.CustomDomainCertificateRef = d.CertificateRef
+	return nil
+}
+
+// ParseCustomDomainCertificate parses the contents of the secret referenced
+// by models.Account.CustomDomainCertificateRef, and checks that the
+// certificate is valid for the given hostname.
+func ParseCustomDomainCertificate(secret, hostname string) (*tls.Certificate, error) {
+	// the secret contains both the certificate chain and the private key;
+	// tls.X509KeyPair() picks the respective PEM blocks out of it
+	cert, err := tls.X509KeyPair([]byte(secret), []byte(secret))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse TLS certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		return nil, errors.New("cannot parse TLS certificate: missing leaf certificate")
+	}
+	err = cert.Leaf.VerifyHostname(hostname)
+	if err != nil {
+		return nil, fmt.Errorf("TLS certificate is not valid for custom domain: %w", err)
+	}
+	return &cert, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// lookup of custom domains
+
+// Since every request with a Host header that is not one of our well-known
+// hostnames causes a lookup of custom domains, the results (including
+// negative results) are cached for a short time. Changes to accounts
+// invalidate the cache immediately in the process that makes the change, and
+// in all keppel-api replicas through ListenForAccountChanges().
+const (
+	customDomainLookupCacheTTL     = 1 * time.Minute
+	customDomainLookupCacheMaxSize = 10000
+)
+
+type customDomainLookupCacheKey struct {
+	DB       *DB
+	Hostname string
+}
+
+type customDomainLookupCacheEntry struct {
+	AccountName models.AccountName // empty if the hostname is not a custom domain
+	ExpiresAt   time.Time
+}
+
+var (
+	customDomainLookupCacheMutex sync.Mutex
+	customDomainLookupCache      = make(map[customDomainLookupCacheKey]customDomainLookupCacheEntry)
+)
+
+var findAccountForCustomDomainQuery = `SELECT name FROM accounts WHERE custom_domain = $1 AND custom_domain_verified_at IS NOT NULL`
+
+// FindAccountForCustomDomain returns the name of the account that has the
+// given hostname as its verified custom domain, or the empty string if there
+// is no such account.
+func FindAccountForCustomDomain(db *DB, hostname string) (models.AccountName, error) {
+	key := customDomainLookupCacheKey{db, hostname}
+	now := time.Now()
+	customDomainLookupCacheMutex.Lock()
+	entry, ok := customDomainLookupCache[key]
+	customDomainLookupCacheMutex.Unlock()
+	if ok && entry.ExpiresAt.After(now) {
+		return entry.AccountName, nil
+	}
+
+	accountName, err := db.SelectStr(findAccountForCustomDomainQuery, hostname)
+	if err != nil {
+		return "", err
+	}
+
+	customDomainLookupCacheMutex.Lock()
+	defer customDomainLookupCacheMutex.Unlock()
+	if len(customDomainLookupCache) >= customDomainLookupCacheMaxSize {
+		// do not let clients with made-up Host headers grow the cache without bounds
+		clear(customDomainLookupCache)
+	}
+	customDomainLookupCache[key] = customDomainLookupCacheEntry{
+		AccountName: models.AccountName(accountName),
+		ExpiresAt:   now.Add(customDomainLookupCacheTTL),
+	}
+	return models.AccountName(accountName), nil
+}
+
+// InvalidateCustomDomainCache discards all results cached by
+// FindAccountForCustomDomain().
+func InvalidateCustomDomainCache() {
+	customDomainLookupCacheMutex.Lock()
+	defer customDomainLookupCacheMutex.Unlock()
+	clear(customDomainLookupCache)
+}
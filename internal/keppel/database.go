@@ -343,6 +343,715 @@ var sqlMigrations = map[string]string{
 	"047_add_manifest_subject_digest_index.down.sql": `
 		DROP INDEX manifests_repo_id_subject_digest_idx;
 	`,
+	"048_add_announcements.up.sql": `
+		CREATE TABLE announcements (
+			id         BIGSERIAL   NOT NULL PRIMARY KEY,
+			message    TEXT        NOT NULL,
+			severity   TEXT        NOT NULL,
+			starts_at  TIMESTAMPTZ DEFAULT NULL,
+			ends_at    TIMESTAMPTZ DEFAULT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			created_by TEXT        NOT NULL DEFAULT ''
+		);
+	`,
+	"048_add_announcements.down.sql": `
+		DROP TABLE announcements;
+	`,
+	"049_add_accounts_default_platform.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN default_platform TEXT NOT NULL DEFAULT '';
+	`,
+	"049_add_accounts_default_platform.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN default_platform;
+	`,
+	"050_add_accounts_external_peer_credentials.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN external_peer_credentials_json TEXT NOT NULL DEFAULT '';
+	`,
+	"050_add_accounts_external_peer_credentials.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN external_peer_credentials_json;
+	`,
+	"051_add_accounts_external_peer_password_ref.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN external_peer_password_ref TEXT NOT NULL DEFAULT '';
+	`,
+	"051_add_accounts_external_peer_password_ref.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN external_peer_password_ref;
+	`,
+	"052_add_upstream_circuit_breakers.up.sql": `
+		CREATE TABLE upstream_circuit_breakers (
+			hostname             TEXT        NOT NULL PRIMARY KEY,
+			consecutive_failures INTEGER     NOT NULL DEFAULT 0,
+			open_until           TIMESTAMPTZ DEFAULT NULL
+		);
+	`,
+	"052_add_upstream_circuit_breakers.down.sql": `
+		DROP TABLE upstream_circuit_breakers;
+	`,
+	"053_add_replication_error_budget.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN replication_paused_at TIMESTAMPTZ DEFAULT NULL;
+		CREATE TABLE replication_outcomes (
+			account_name TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			bucket_start TIMESTAMPTZ NOT NULL,
+			successes    INTEGER     NOT NULL DEFAULT 0,
+			failures     INTEGER     NOT NULL DEFAULT 0,
+			PRIMARY KEY (account_name, bucket_start)
+		);
+	`,
+	"053_add_replication_error_budget.down.sql": `
+		DROP TABLE replication_outcomes;
+		ALTER TABLE accounts
+			DROP COLUMN replication_paused_at;
+	`,
+	"054_add_manifest_validation_warnings.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN recommended_annotations TEXT NOT NULL DEFAULT '';
+		ALTER TABLE manifests
+			ADD COLUMN validation_warnings_json TEXT NOT NULL DEFAULT '';
+	`,
+	"054_add_manifest_validation_warnings.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN recommended_annotations;
+		ALTER TABLE manifests
+			DROP COLUMN validation_warnings_json;
+	`,
+	"055_add_accounts_admission_policies_json.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN admission_policies_json TEXT NOT NULL DEFAULT '';
+	`,
+	"055_add_accounts_admission_policies_json.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN admission_policies_json;
+	`,
+	"056_add_manifests_quarantine.up.sql": `
+		ALTER TABLE manifests
+			ADD COLUMN quarantined_at TIMESTAMPTZ DEFAULT NULL,
+			ADD COLUMN quarantine_reason TEXT NOT NULL DEFAULT '';
+	`,
+	"056_add_manifests_quarantine.down.sql": `
+		ALTER TABLE manifests
+			DROP COLUMN quarantined_at,
+			DROP COLUMN quarantine_reason;
+	`,
+	"057_add_account_requests.up.sql": `
+		CREATE TABLE account_requests (
+			id              BIGSERIAL   NOT NULL PRIMARY KEY,
+			account_name    TEXT        NOT NULL,
+			auth_tenant_id  TEXT        NOT NULL,
+			account_json    TEXT        NOT NULL,
+			state           TEXT        NOT NULL,
+			requested_at    TIMESTAMPTZ NOT NULL,
+			requested_by    TEXT        NOT NULL,
+			decided_at      TIMESTAMPTZ DEFAULT NULL,
+			decided_by      TEXT        NOT NULL DEFAULT '',
+			decision_reason TEXT        NOT NULL DEFAULT ''
+		);
+		CREATE UNIQUE INDEX account_requests_pending_account_name_idx ON account_requests (account_name) WHERE state = 'pending';
+	`,
+	"057_add_account_requests.down.sql": `
+		DROP TABLE account_requests;
+	`,
+	"058_add_repo_namespaces.up.sql": `
+		CREATE TABLE repo_namespaces (
+			account_name           TEXT   NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			prefix                 TEXT   NOT NULL,
+			admin_username_pattern TEXT   NOT NULL DEFAULT '',
+			manifest_quota         BIGINT DEFAULT NULL,
+			rbac_policies_json     TEXT   NOT NULL DEFAULT '',
+			gc_policies_json       TEXT   NOT NULL DEFAULT '',
+			PRIMARY KEY (account_name, prefix)
+		);
+	`,
+	"058_add_repo_namespaces.down.sql": `
+		DROP TABLE repo_namespaces;
+	`,
+	"059_add_accounts_custom_domain.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN custom_domain TEXT NOT NULL DEFAULT '',
+			ADD COLUMN custom_domain_certificate_ref TEXT NOT NULL DEFAULT '';
+		CREATE UNIQUE INDEX accounts_custom_domain_idx ON accounts (custom_domain) WHERE custom_domain != '';
+	`,
+	"059_add_accounts_custom_domain.down.sql": `
+		DROP INDEX accounts_custom_domain_idx;
+		ALTER TABLE accounts
+			DROP COLUMN custom_domain,
+			DROP COLUMN custom_domain_certificate_ref;
+	`,
+	"060_add_backed_up_at.up.sql": `
+		ALTER TABLE blobs ADD COLUMN backed_up_at TIMESTAMPTZ DEFAULT NULL;
+		ALTER TABLE manifests ADD COLUMN backed_up_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"060_add_backed_up_at.down.sql": `
+		ALTER TABLE blobs DROP COLUMN backed_up_at;
+		ALTER TABLE manifests DROP COLUMN backed_up_at;
+	`,
+	"061_add_account_snapshots.up.sql": `
+		CREATE TABLE account_snapshots (
+			id            BIGSERIAL   NOT NULL PRIMARY KEY,
+			account_name  TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			created_at    TIMESTAMPTZ NOT NULL,
+			created_by    TEXT        NOT NULL DEFAULT '',
+			contents_json TEXT        NOT NULL
+		);
+	`,
+	"061_add_account_snapshots.down.sql": `
+		DROP TABLE account_snapshots;
+	`,
+	"062_add_pending_changes.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN approval_policy_json TEXT NOT NULL DEFAULT '';
+		CREATE TABLE pending_changes (
+			id           BIGSERIAL   NOT NULL PRIMARY KEY,
+			account_name TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			kind         TEXT        NOT NULL,
+			payload_json TEXT        NOT NULL DEFAULT '',
+			requested_at TIMESTAMPTZ NOT NULL,
+			requested_by TEXT        NOT NULL
+		);
+	`,
+	"062_add_pending_changes.down.sql": `
+		DROP TABLE pending_changes;
+		ALTER TABLE accounts
+			DROP COLUMN approval_policy_json;
+	`,
+	"063_add_background_migrations.up.sql": `
+		CREATE TABLE background_migrations (
+			name           TEXT        NOT NULL PRIMARY KEY,
+			processed_rows BIGINT      NOT NULL DEFAULT 0,
+			started_at     TIMESTAMPTZ NOT NULL,
+			finished_at    TIMESTAMPTZ DEFAULT NULL
+		);
+	`,
+	"063_add_background_migrations.down.sql": `
+		DROP TABLE background_migrations;
+	`,
+	"064_add_repos_storage_quota_bytes.up.sql": `
+		ALTER TABLE repos ADD COLUMN storage_quota_bytes BIGINT DEFAULT NULL;
+	`,
+	"064_add_repos_storage_quota_bytes.down.sql": `
+		ALTER TABLE repos DROP COLUMN storage_quota_bytes;
+	`,
+	"065_add_accounts_sync_policies_from_primary.up.sql": `
+		ALTER TABLE accounts ADD COLUMN sync_policies_from_primary BOOLEAN NOT NULL DEFAULT FALSE;
+	`,
+	"065_add_accounts_sync_policies_from_primary.down.sql": `
+		ALTER TABLE accounts DROP COLUMN sync_policies_from_primary;
+	`,
+	"066_add_rate_limit_exemptions.up.sql": `
+		CREATE TABLE rate_limit_exemptions (
+			id           BIGSERIAL   NOT NULL PRIMARY KEY,
+			account_name TEXT        NOT NULL DEFAULT '',
+			cidr         TEXT        NOT NULL DEFAULT '',
+			user_name    TEXT        NOT NULL DEFAULT '',
+			comment      TEXT        NOT NULL DEFAULT '',
+			created_at   TIMESTAMPTZ NOT NULL,
+			created_by   TEXT        NOT NULL DEFAULT ''
+		);
+	`,
+	"066_add_rate_limit_exemptions.down.sql": `
+		DROP TABLE rate_limit_exemptions;
+	`,
+	"067_add_accounts_serve_blobs_via_cdn.up.sql": `
+		ALTER TABLE accounts ADD COLUMN serve_blobs_via_cdn BOOLEAN NOT NULL DEFAULT FALSE;
+	`,
+	"067_add_accounts_serve_blobs_via_cdn.down.sql": `
+		ALTER TABLE accounts DROP COLUMN serve_blobs_via_cdn;
+	`,
+	"068_add_accounts_response_headers_and_pull_terms.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN response_headers_json TEXT NOT NULL DEFAULT '',
+			ADD COLUMN pull_terms_version TEXT NOT NULL DEFAULT '',
+			ADD COLUMN pull_terms_url TEXT NOT NULL DEFAULT '';
+		CREATE TABLE pull_terms_acceptances (
+			account_name  TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			user_name     TEXT        NOT NULL,
+			terms_version TEXT        NOT NULL,
+			accepted_at   TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (account_name, user_name, terms_version)
+		);
+	`,
+	"068_add_accounts_response_headers_and_pull_terms.down.sql": `
+		DROP TABLE pull_terms_acceptances;
+		ALTER TABLE accounts
+			DROP COLUMN response_headers_json,
+			DROP COLUMN pull_terms_version,
+			DROP COLUMN pull_terms_url;
+	`,
+	"069_add_external_peer_verify_only.up.sql": `
+		ALTER TABLE accounts ADD COLUMN external_peer_verify_only BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE manifests ADD COLUMN verified_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"069_add_external_peer_verify_only.down.sql": `
+		ALTER TABLE accounts DROP COLUMN external_peer_verify_only;
+		ALTER TABLE manifests DROP COLUMN verified_at;
+	`,
+	"070_add_manifests_promotion_state.up.sql": `
+		ALTER TABLE manifests ADD COLUMN promotion_state TEXT NOT NULL DEFAULT '';
+		ALTER TABLE accounts ADD COLUMN min_pull_promotion_state TEXT NOT NULL DEFAULT '';
+	`,
+	"070_add_manifests_promotion_state.down.sql": `
+		ALTER TABLE manifests DROP COLUMN promotion_state;
+		ALTER TABLE accounts DROP COLUMN min_pull_promotion_state;
+	`,
+	"071_add_tag_watches.up.sql": `
+		CREATE TABLE tag_watches (
+			id              BIGSERIAL   NOT NULL PRIMARY KEY,
+			account_name    TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			repo_name       TEXT        NOT NULL,
+			tag_pattern     TEXT        NOT NULL,
+			webhook_url     TEXT        NOT NULL DEFAULT '',
+			known_tags_json TEXT        NOT NULL DEFAULT '',
+			created_at      TIMESTAMPTZ NOT NULL,
+			created_by      TEXT        NOT NULL DEFAULT '',
+			checked_at      TIMESTAMPTZ DEFAULT NULL,
+			next_check_at   TIMESTAMPTZ NOT NULL,
+			error_message   TEXT        NOT NULL DEFAULT '',
+			UNIQUE (account_name, repo_name, tag_pattern)
+		);
+	`,
+	"071_add_tag_watches.down.sql": `
+		DROP TABLE tag_watches;
+	`,
+	"072_add_repos_is_archived.up.sql": `
+		ALTER TABLE repos ADD COLUMN is_archived BOOLEAN NOT NULL DEFAULT FALSE;
+	`,
+	"072_add_repos_is_archived.down.sql": `
+		ALTER TABLE repos DROP COLUMN is_archived;
+	`,
+	// Re 073: index is used by FindSharedBlob
+	"073_add_blobs_digest_index.up.sql": `
+		CREATE INDEX blobs_digest_idx ON blobs (digest) WHERE storage_id != '';
+		ALTER TABLE accounts ADD COLUMN share_blobs BOOLEAN NOT NULL DEFAULT FALSE;
+	`,
+	"073_add_blobs_digest_index.down.sql": `
+		DROP INDEX blobs_digest_idx;
+		ALTER TABLE accounts DROP COLUMN share_blobs;
+	`,
+	"074_add_repos_error_messages.up.sql": `
+		ALTER TABLE repos
+			ADD COLUMN manifest_sync_error_message TEXT NOT NULL DEFAULT '',
+			ADD COLUMN gc_error_message TEXT NOT NULL DEFAULT '';
+	`,
+	"074_add_repos_error_messages.down.sql": `
+		ALTER TABLE repos
+			DROP COLUMN manifest_sync_error_message,
+			DROP COLUMN gc_error_message;
+	`,
+	"075_add_rbac_policy_usage.up.sql": `
+		CREATE TABLE rbac_policy_usage (
+			account_name       TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			policy_fingerprint TEXT        NOT NULL,
+			first_seen_at      TIMESTAMPTZ NOT NULL,
+			last_used_at       TIMESTAMPTZ DEFAULT NULL,
+			PRIMARY KEY (account_name, policy_fingerprint)
+		);
+		ALTER TABLE accounts ADD COLUMN next_credential_report_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"075_add_rbac_policy_usage.down.sql": `
+		DROP TABLE rbac_policy_usage;
+		ALTER TABLE accounts DROP COLUMN next_credential_report_at;
+	`,
+	"076_add_accounts_rbac_policy_epoch.up.sql": `
+		ALTER TABLE accounts ADD COLUMN rbac_policy_epoch BIGINT NOT NULL DEFAULT 0;
+	`,
+	"076_add_accounts_rbac_policy_epoch.down.sql": `
+		ALTER TABLE accounts DROP COLUMN rbac_policy_epoch;
+	`,
+	"077_add_manifest_keppel_labels.up.sql": `
+		CREATE TABLE manifest_keppel_labels (
+			repo_id BIGINT NOT NULL,
+			digest  TEXT   NOT NULL,
+			name    TEXT   NOT NULL,
+			value   TEXT   NOT NULL,
+			PRIMARY KEY (repo_id, digest, name),
+			FOREIGN KEY (repo_id, digest) REFERENCES manifests ON DELETE CASCADE
+		);
+		CREATE INDEX manifest_keppel_labels_name_value_idx ON manifest_keppel_labels (name, value);
+	`,
+	"077_add_manifest_keppel_labels.down.sql": `
+		DROP TABLE manifest_keppel_labels;
+	`,
+	"078_add_lazy_pull_variants.up.sql": `
+		ALTER TABLE accounts ADD COLUMN lazy_pull_format TEXT NOT NULL DEFAULT '';
+		CREATE TABLE lazy_pull_variants (
+			repo_id         BIGINT      NOT NULL,
+			digest          TEXT        NOT NULL,
+			format          TEXT        NOT NULL,
+			variant_digest  TEXT        NOT NULL DEFAULT '',
+			generated_at    TIMESTAMPTZ NOT NULL,
+			error_message   TEXT        NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMPTZ DEFAULT NULL,
+			PRIMARY KEY (repo_id, digest, format),
+			FOREIGN KEY (repo_id, digest) REFERENCES manifests ON DELETE CASCADE
+		);
+	`,
+	"078_add_lazy_pull_variants.down.sql": `
+		DROP TABLE lazy_pull_variants;
+		ALTER TABLE accounts DROP COLUMN lazy_pull_format;
+	`,
+	"079_add_accounts_storage_placement_json.up.sql": `
+		ALTER TABLE accounts ADD COLUMN storage_placement_json TEXT NOT NULL DEFAULT '';
+	`,
+	"079_add_accounts_storage_placement_json.down.sql": `
+		ALTER TABLE accounts DROP COLUMN storage_placement_json;
+	`,
+	"080_add_webhooks.up.sql": `
+		CREATE TABLE webhooks (
+			id           BIGSERIAL   NOT NULL PRIMARY KEY,
+			account_name TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			repo_pattern TEXT        NOT NULL DEFAULT '',
+			url          TEXT        NOT NULL,
+			event_types  TEXT        NOT NULL,
+			created_at   TIMESTAMPTZ NOT NULL,
+			created_by   TEXT        NOT NULL DEFAULT ''
+		);
+		CREATE TABLE webhook_deliveries (
+			id              BIGSERIAL   NOT NULL PRIMARY KEY,
+			webhook_id      BIGINT      NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+			payload_json    TEXT        NOT NULL,
+			created_at      TIMESTAMPTZ NOT NULL,
+			failed_count    BIGINT      NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ NOT NULL,
+			error_message   TEXT        NOT NULL DEFAULT ''
+		);
+		CREATE INDEX webhook_deliveries_next_attempt_at_idx ON webhook_deliveries (next_attempt_at);
+	`,
+	"080_add_webhooks.down.sql": `
+		DROP TABLE webhook_deliveries;
+		DROP TABLE webhooks;
+	`,
+	"081_add_deprecated_api_usage.up.sql": `
+		CREATE TABLE deprecated_api_usage (
+			account_name   TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			deprecation_id TEXT        NOT NULL,
+			first_used_at  TIMESTAMPTZ NOT NULL,
+			last_used_at   TIMESTAMPTZ NOT NULL,
+			usage_count    BIGINT      NOT NULL DEFAULT 1,
+			PRIMARY KEY (account_name, deprecation_id)
+		);
+	`,
+	"081_add_deprecated_api_usage.down.sql": `
+		DROP TABLE deprecated_api_usage;
+	`,
+	"082_add_accounts_foreign_layer_policy.up.sql": `
+		ALTER TABLE accounts ADD COLUMN foreign_layer_policy TEXT NOT NULL DEFAULT '';
+	`,
+	"082_add_accounts_foreign_layer_policy.down.sql": `
+		ALTER TABLE accounts DROP COLUMN foreign_layer_policy;
+	`,
+	"083_add_robot_credentials.up.sql": `
+		CREATE TABLE robot_credentials (
+			id            BIGSERIAL   NOT NULL PRIMARY KEY,
+			repo_id       BIGINT      NOT NULL REFERENCES repos ON DELETE CASCADE,
+			user_name     TEXT        NOT NULL UNIQUE,
+			password_hash TEXT        NOT NULL,
+			created_at    TIMESTAMPTZ NOT NULL,
+			created_by    TEXT        NOT NULL DEFAULT ''
+		);
+	`,
+	"083_add_robot_credentials.down.sql": `
+		DROP TABLE robot_credentials;
+	`,
+	"084_add_accounts_tag_protection_policies_json.up.sql": `
+		ALTER TABLE accounts ADD COLUMN tag_protection_policies_json TEXT NOT NULL DEFAULT '';
+	`,
+	"084_add_accounts_tag_protection_policies_json.down.sql": `
+		ALTER TABLE accounts DROP COLUMN tag_protection_policies_json;
+	`,
+	"085_add_replication_retry_hints.up.sql": `
+		ALTER TABLE accounts ADD COLUMN replication_retry_hints BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE pending_blobs ADD COLUMN replicated_bytes BIGINT NOT NULL DEFAULT 0;
+	`,
+	"085_add_replication_retry_hints.down.sql": `
+		ALTER TABLE accounts DROP COLUMN replication_retry_hints;
+		ALTER TABLE pending_blobs DROP COLUMN replicated_bytes;
+	`,
+	"086_add_peers_topology.up.sql": `
+		ALTER TABLE peers
+			ADD COLUMN region TEXT NOT NULL DEFAULT '',
+			ADD COLUMN link_cost INTEGER NOT NULL DEFAULT 0,
+			ADD COLUMN use_for_replication BOOLEAN NOT NULL DEFAULT FALSE;
+	`,
+	"086_add_peers_topology.down.sql": `
+		ALTER TABLE peers
+			DROP COLUMN region,
+			DROP COLUMN link_cost,
+			DROP COLUMN use_for_replication;
+	`,
+	"087_add_pull_attestations.up.sql": `
+		CREATE TABLE pull_attestations (
+			account_name    TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			repo_name       TEXT        NOT NULL,
+			digest          TEXT        NOT NULL,
+			client_id       TEXT        NOT NULL,
+			first_pulled_at TIMESTAMPTZ NOT NULL,
+			last_pulled_at  TIMESTAMPTZ NOT NULL,
+			pull_count      BIGINT      NOT NULL DEFAULT 1,
+			PRIMARY KEY (account_name, repo_name, digest, client_id)
+		);
+		CREATE INDEX pull_attestations_digest_idx ON pull_attestations (digest);
+	`,
+	"087_add_pull_attestations.down.sql": `
+		DROP TABLE pull_attestations;
+	`,
+	"088_add_replicated_manifests.up.sql": `
+		CREATE TABLE replicated_manifests (
+			repo_id       BIGINT NOT NULL REFERENCES repos ON DELETE CASCADE,
+			digest        TEXT   NOT NULL,
+			peer_hostname TEXT   NOT NULL,
+			PRIMARY KEY (repo_id, digest, peer_hostname)
+		);
+	`,
+	"088_add_replicated_manifests.down.sql": `
+		DROP TABLE replicated_manifests;
+	`,
+	"089_add_accounts_cascade_delete_referrers.up.sql": `
+		ALTER TABLE accounts ADD COLUMN cascade_delete_referrers BOOLEAN NOT NULL DEFAULT FALSE;
+	`,
+	"089_add_accounts_cascade_delete_referrers.down.sql": `
+		ALTER TABLE accounts DROP COLUMN cascade_delete_referrers;
+	`,
+	"090_add_storage_quota_reservations.up.sql": `
+		CREATE TABLE storage_quota_reservations (
+			repo_id    BIGINT      NOT NULL REFERENCES repos ON DELETE CASCADE,
+			storage_id TEXT        NOT NULL,
+			size_bytes BIGINT      NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (repo_id, storage_id)
+		);
+	`,
+	"090_add_storage_quota_reservations.down.sql": `
+		DROP TABLE storage_quota_reservations;
+	`,
+	"091_add_tag_retention_policies.up.sql": `
+		ALTER TABLE accounts ADD COLUMN tag_retention_policies_json TEXT NOT NULL DEFAULT '';
+		ALTER TABLE repos ADD COLUMN next_tag_retention_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"091_add_tag_retention_policies.down.sql": `
+		ALTER TABLE accounts DROP COLUMN tag_retention_policies_json;
+		ALTER TABLE repos DROP COLUMN next_tag_retention_at;
+	`,
+	"092_add_manifests_push_info_json.up.sql": `
+		ALTER TABLE manifests ADD COLUMN push_info_json TEXT NOT NULL DEFAULT '';
+	`,
+	"092_add_manifests_push_info_json.down.sql": `
+		ALTER TABLE manifests DROP COLUMN push_info_json;
+	`,
+	"093_add_content_encryption.up.sql": `
+		ALTER TABLE accounts ADD COLUMN content_encryption_key_ref TEXT NOT NULL DEFAULT '';
+		ALTER TABLE manifest_contents ADD COLUMN encryption_key_ref TEXT NOT NULL DEFAULT '';
+	`,
+	"093_add_content_encryption.down.sql": `
+		ALTER TABLE accounts DROP COLUMN content_encryption_key_ref;
+		ALTER TABLE manifest_contents DROP COLUMN encryption_key_ref;
+	`,
+	"094_add_orphaned_segments.up.sql": `
+		ALTER TABLE accounts ADD COLUMN next_segment_sweep_at TIMESTAMPTZ DEFAULT NULL;
+		CREATE TABLE orphaned_segments (
+			account_name TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			storage_id   TEXT        NOT NULL,
+			chunk_number BIGINT      NOT NULL,
+			size_bytes   BIGINT      NOT NULL,
+			detected_at  TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (account_name, storage_id, chunk_number)
+		);
+	`,
+	"094_add_orphaned_segments.down.sql": `
+		DROP TABLE orphaned_segments;
+		ALTER TABLE accounts DROP COLUMN next_segment_sweep_at;
+	`,
+	"095_add_accounts_storage_quota_exceeded_at.up.sql": `
+		ALTER TABLE accounts ADD COLUMN storage_quota_exceeded_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"095_add_accounts_storage_quota_exceeded_at.down.sql": `
+		ALTER TABLE accounts DROP COLUMN storage_quota_exceeded_at;
+	`,
+	"096_add_repos_gc_policies.up.sql": `
+		ALTER TABLE repos ADD COLUMN gc_policies_json TEXT NOT NULL DEFAULT '';
+		ALTER TABLE repos ADD COLUMN ignore_inherited_gc_policies BOOLEAN NOT NULL DEFAULT FALSE;
+	`,
+	"096_add_repos_gc_policies.down.sql": `
+		ALTER TABLE repos DROP COLUMN gc_policies_json;
+		ALTER TABLE repos DROP COLUMN ignore_inherited_gc_policies;
+	`,
+	"097_add_accounts_metadata_json.up.sql": `
+		ALTER TABLE accounts ADD COLUMN metadata_json TEXT NOT NULL DEFAULT '';
+	`,
+	"097_add_accounts_metadata_json.down.sql": `
+		ALTER TABLE accounts DROP COLUMN metadata_json;
+	`,
+	"098_add_accounts_custom_domain_verification.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN custom_domain_verification_token TEXT NOT NULL DEFAULT '',
+			ADD COLUMN custom_domain_verified_at TIMESTAMPTZ DEFAULT NULL,
+			ADD COLUMN next_custom_domain_verification_at TIMESTAMPTZ DEFAULT NULL;
+		-- custom domains that were configured before verification was introduced stay in use
+		UPDATE accounts SET custom_domain_verified_at = NOW() WHERE custom_domain != '';
+		-- unverified domains may be claimed by multiple accounts, the first one to verify wins
+		DROP INDEX accounts_custom_domain_idx;
+		CREATE UNIQUE INDEX accounts_custom_domain_idx ON accounts (custom_domain) WHERE custom_domain_verified_at IS NOT NULL;
+	`,
+	"098_add_accounts_custom_domain_verification.down.sql": `
+		DROP INDEX accounts_custom_domain_idx;
+		UPDATE accounts SET custom_domain = '', custom_domain_certificate_ref = '' WHERE custom_domain_verified_at IS NULL;
+		CREATE UNIQUE INDEX accounts_custom_domain_idx ON accounts (custom_domain) WHERE custom_domain != '';
+		ALTER TABLE accounts
+			DROP COLUMN custom_domain_verification_token,
+			DROP COLUMN custom_domain_verified_at,
+			DROP COLUMN next_custom_domain_verification_at;
+	`,
+	"099_add_webhooks_secret.up.sql": `
+		ALTER TABLE webhooks ADD COLUMN secret TEXT NOT NULL DEFAULT '';
+	`,
+	"099_add_webhooks_secret.down.sql": `
+		ALTER TABLE webhooks DROP COLUMN secret;
+	`,
+	"100_add_repos_revalidation.up.sql": `
+		ALTER TABLE repos
+			ADD COLUMN next_revalidation_at TIMESTAMPTZ DEFAULT NULL,
+			ADD COLUMN revalidation_repair BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN revalidated_at TIMESTAMPTZ DEFAULT NULL,
+			ADD COLUMN revalidation_result_json TEXT NOT NULL DEFAULT '';
+	`,
+	"100_add_repos_revalidation.down.sql": `
+		ALTER TABLE repos
+			DROP COLUMN next_revalidation_at,
+			DROP COLUMN revalidation_repair,
+			DROP COLUMN revalidated_at,
+			DROP COLUMN revalidation_result_json;
+	`,
+	"101_add_next_backup_at.up.sql": `
+		ALTER TABLE blobs
+			ADD COLUMN next_backup_at TIMESTAMPTZ DEFAULT NULL,
+			ADD COLUMN backup_error_message TEXT NOT NULL DEFAULT '';
+		ALTER TABLE manifests
+			ADD COLUMN next_backup_at TIMESTAMPTZ DEFAULT NULL,
+			ADD COLUMN backup_error_message TEXT NOT NULL DEFAULT '';
+	`,
+	"101_add_next_backup_at.down.sql": `
+		ALTER TABLE blobs
+			DROP COLUMN next_backup_at,
+			DROP COLUMN backup_error_message;
+		ALTER TABLE manifests
+			DROP COLUMN next_backup_at,
+			DROP COLUMN backup_error_message;
+	`,
+	"102_add_trivy_reports.up.sql": `
+		CREATE TABLE trivy_reports (
+			repo_id  BIGINT NOT NULL,
+			digest   TEXT   NOT NULL,
+			contents BYTEA  NOT NULL, -- gzip-compressed JSON report
+			FOREIGN KEY (repo_id, digest) REFERENCES manifests ON DELETE CASCADE,
+			PRIMARY KEY (repo_id, digest)
+		);
+	`,
+	"102_add_trivy_reports.down.sql": `
+		DROP TABLE trivy_reports;
+	`,
+	"103_add_next_verification_at.up.sql": `
+		ALTER TABLE manifests
+			ADD COLUMN next_verification_at TIMESTAMPTZ DEFAULT NULL,
+			ADD COLUMN verification_error_message TEXT NOT NULL DEFAULT '';
+	`,
+	"103_add_next_verification_at.down.sql": `
+		ALTER TABLE manifests
+			DROP COLUMN next_verification_at,
+			DROP COLUMN verification_error_message;
+	`,
+	"104_add_robot_credentials_expires_at.up.sql": `
+		ALTER TABLE robot_credentials ADD COLUMN expires_at TIMESTAMPTZ DEFAULT NULL;
+		UPDATE robot_credentials SET expires_at = created_at + INTERVAL '90 days';
+		ALTER TABLE robot_credentials ALTER COLUMN expires_at SET NOT NULL;
+	`,
+	"104_add_robot_credentials_expires_at.down.sql": `
+		ALTER TABLE robot_credentials DROP COLUMN expires_at;
+	`,
+	"105_add_pending_changes_requested_by_id.up.sql": `
+		ALTER TABLE pending_changes ADD COLUMN requested_by_id TEXT NOT NULL DEFAULT '';
+	`,
+	"105_add_pending_changes_requested_by_id.down.sql": `
+		ALTER TABLE pending_changes DROP COLUMN requested_by_id;
+	`,
+	"106_add_trivy_partial_results.up.sql": `
+		ALTER TABLE blobs ADD COLUMN trivy_fetched_at TIMESTAMPTZ DEFAULT NULL;
+		ALTER TABLE trivy_security_info ADD COLUMN scanned_layer_count INTEGER DEFAULT NULL;
+	`,
+	"106_add_trivy_partial_results.down.sql": `
+		ALTER TABLE blobs DROP COLUMN trivy_fetched_at;
+		ALTER TABLE trivy_security_info DROP COLUMN scanned_layer_count;
+	`,
+	"107_add_tags_pull_counts.up.sql": `
+		ALTER TABLE tags ADD COLUMN pull_count BIGINT NOT NULL DEFAULT 0;
+		ALTER TABLE tags ADD COLUMN child_pull_count BIGINT NOT NULL DEFAULT 0;
+	`,
+	"107_add_tags_pull_counts.down.sql": `
+		ALTER TABLE tags DROP COLUMN pull_count;
+		ALTER TABLE tags DROP COLUMN child_pull_count;
+	`,
+	"108_add_accounts_policy_sync_fields.up.sql": `
+		ALTER TABLE accounts ADD COLUMN policies_overridden_locally BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE accounts ADD COLUMN next_policy_sync_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"108_add_accounts_policy_sync_fields.down.sql": `
+		ALTER TABLE accounts DROP COLUMN policies_overridden_locally;
+		ALTER TABLE accounts DROP COLUMN next_policy_sync_at;
+	`,
+	"109_add_pending_cdn_purges.up.sql": `
+		CREATE TABLE pending_cdn_purges (
+			id              BIGSERIAL   NOT NULL PRIMARY KEY,
+			account_name    TEXT        NOT NULL,
+			auth_tenant_id  TEXT        NOT NULL,
+			digest          TEXT        NOT NULL,
+			failed_count    BIGINT      NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ NOT NULL,
+			error_message   TEXT        NOT NULL DEFAULT ''
+		);
+		CREATE INDEX pending_cdn_purges_next_attempt_at_idx ON pending_cdn_purges (next_attempt_at);
+	`,
+	"109_add_pending_cdn_purges.down.sql": `
+		DROP TABLE pending_cdn_purges;
+	`,
+	"110_add_telemetry_instance.up.sql": `
+		CREATE TABLE telemetry_instance (
+			singleton   BOOLEAN NOT NULL PRIMARY KEY DEFAULT TRUE CHECK (singleton),
+			instance_id TEXT    NOT NULL
+		);
+	`,
+	"110_add_telemetry_instance.down.sql": `
+		DROP TABLE telemetry_instance;
+	`,
+	"111_add_webhooks_namespace_prefix.up.sql": `
+		ALTER TABLE webhooks ADD COLUMN namespace_prefix TEXT NOT NULL DEFAULT '';
+	`,
+	"111_add_webhooks_namespace_prefix.down.sql": `
+		ALTER TABLE webhooks DROP COLUMN namespace_prefix;
+	`,
+	"112_add_recompressed_variants.up.sql": `
+		ALTER TABLE accounts ADD COLUMN recompression_format TEXT NOT NULL DEFAULT '';
+		CREATE TABLE recompressed_variants (
+			repo_id                    BIGINT      NOT NULL,
+			digest                     TEXT        NOT NULL,
+			format                     TEXT        NOT NULL,
+			variant_digest             TEXT        NOT NULL DEFAULT '',
+			original_layers_size_bytes BIGINT      NOT NULL DEFAULT 0,
+			variant_layers_size_bytes  BIGINT      NOT NULL DEFAULT 0,
+			generated_at               TIMESTAMPTZ NOT NULL,
+			error_message              TEXT        NOT NULL DEFAULT '',
+			next_attempt_at            TIMESTAMPTZ DEFAULT NULL,
+			pull_count                 BIGINT      NOT NULL DEFAULT 0,
+			PRIMARY KEY (repo_id, digest, format),
+			FOREIGN KEY (repo_id, digest) REFERENCES manifests ON DELETE CASCADE
+		);
+		CREATE INDEX recompressed_variants_variant_digest_idx ON recompressed_variants (repo_id, variant_digest);
+	`,
+	"112_add_recompressed_variants.down.sql": `
+		DROP TABLE recompressed_variants;
+		ALTER TABLE accounts DROP COLUMN recompression_format;
+	`,
 }
 
 // DB adds convenience functions on top of gorp.DbMap.
@@ -383,7 +1092,28 @@ func InitORM(dbConn *sql.DB) *DB {
 	result.DbMap.AddTableWithName(models.PendingBlob{}, "pending_blobs").SetKeys(false, "account_name", "digest")
 	result.DbMap.AddTableWithName(models.UnknownBlob{}, "unknown_blobs").SetKeys(false, "account_name", "storage_id")
 	result.DbMap.AddTableWithName(models.UnknownManifest{}, "unknown_manifests").SetKeys(false, "account_name", "repo_name", "digest")
+	result.DbMap.AddTableWithName(models.OrphanedSegment{}, "orphaned_segments").SetKeys(false, "account_name", "storage_id", "chunk_number")
 	result.DbMap.AddTableWithName(models.TrivySecurityInfo{}, "trivy_security_info").SetKeys(false, "repo_id", "digest")
+	result.DbMap.AddTableWithName(models.Announcement{}, "announcements").SetKeys(true, "id")
+	result.DbMap.AddTableWithName(models.UpstreamCircuitBreaker{}, "upstream_circuit_breakers").SetKeys(false, "hostname")
+	result.DbMap.AddTableWithName(models.AccountRequest{}, "account_requests").SetKeys(true, "id")
+	result.DbMap.AddTableWithName(models.RepositoryNamespace{}, "repo_namespaces").SetKeys(false, "account_name", "prefix")
+	result.DbMap.AddTableWithName(models.AccountSnapshot{}, "account_snapshots").SetKeys(true, "id")
+	result.DbMap.AddTableWithName(models.PendingChange{}, "pending_changes").SetKeys(true, "id")
+	result.DbMap.AddTableWithName(models.BackgroundMigration{}, "background_migrations").SetKeys(false, "name")
+	result.DbMap.AddTableWithName(models.RateLimitExemption{}, "rate_limit_exemptions").SetKeys(true, "id")
+	result.DbMap.AddTableWithName(models.PullTermsAcceptance{}, "pull_terms_acceptances").SetKeys(false, "account_name", "user_name", "terms_version")
+	result.DbMap.AddTableWithName(models.TagWatch{}, "tag_watches").SetKeys(true, "id")
+	result.DbMap.AddTableWithName(models.RBACPolicyUsage{}, "rbac_policy_usage").SetKeys(false, "account_name", "policy_fingerprint")
+	result.DbMap.AddTableWithName(models.ManifestKeppelLabel{}, "manifest_keppel_labels").SetKeys(false, "repo_id", "digest", "name")
+	result.DbMap.AddTableWithName(models.LazyPullVariant{}, "lazy_pull_variants").SetKeys(false, "repo_id", "digest", "format")
+	result.DbMap.AddTableWithName(models.RecompressedVariant{}, "recompressed_variants").SetKeys(false, "repo_id", "digest", "format")
+	result.DbMap.AddTableWithName(models.Webhook{}, "webhooks").SetKeys(true, "id")
+	result.DbMap.AddTableWithName(models.WebhookDelivery{}, "webhook_deliveries").SetKeys(true, "id")
+	result.DbMap.AddTableWithName(models.PendingCDNPurge{}, "pending_cdn_purges").SetKeys(true, "id")
+	result.DbMap.AddTableWithName(models.DeprecatedAPIUsage{}, "deprecated_api_usage").SetKeys(false, "account_name", "deprecation_id")
+	result.DbMap.AddTableWithName(models.PullAttestation{}, "pull_attestations").SetKeys(false, "account_name", "repo_name", "digest", "client_id")
+	result.DbMap.AddTableWithName(models.RobotCredential{}, "robot_credentials").SetKeys(true, "id")
 
 	return result
 }
@@ -4,8 +4,12 @@
 package keppel
 
 import (
+	"bytes"
+	"compress/gzip"
 	"database/sql"
 	"errors"
+	"fmt"
+	"io"
 
 	"github.com/go-gorp/gorp/v3"
 	"github.com/opencontainers/go-digest"
@@ -27,8 +31,11 @@ func FindAccount(db gorp.SqlExecutor, name models.AccountName) (*models.Account,
 
 var reducedAccountGetByNameQuery = sqlext.SimplifyWhitespace(`
 	SELECT auth_tenant_id, upstream_peer_hostname,
-	       external_peer_url, external_peer_username, external_peer_password,
-	       platform_filter, required_labels, is_deleting
+	       external_peer_url, external_peer_username, external_peer_password, external_peer_password_ref, external_peer_credentials_json,
+	       external_peer_verify_only, platform_filter, replication_paused_at, default_platform, required_labels, recommended_annotations, admission_policies_json, is_deleting,
+	       approval_policy_json, serve_blobs_via_cdn, response_headers_json, pull_terms_version, pull_terms_url,
+	       min_pull_promotion_state, storage_placement_json, foreign_layer_policy, tag_protection_policies_json, replication_retry_hints,
+	       cascade_delete_referrers, content_encryption_key_ref, storage_quota_exceeded_at
 	  FROM accounts
 	 WHERE name = $1
 `)
@@ -39,8 +46,11 @@ func FindReducedAccount(db gorp.SqlExecutor, name models.AccountName) (*models.R
 	a := models.ReducedAccount{Name: name}
 	err := db.QueryRow(reducedAccountGetByNameQuery, name).Scan(
 		&a.AuthTenantID, &a.UpstreamPeerHostName,
-		&a.ExternalPeerURL, &a.ExternalPeerUserName, &a.ExternalPeerPassword,
-		&a.PlatformFilter, &a.RequiredLabels, &a.IsDeleting,
+		&a.ExternalPeerURL, &a.ExternalPeerUserName, &a.ExternalPeerPassword, &a.ExternalPeerPasswordRef, &a.ExternalPeerCredentials,
+		&a.ExternalPeerVerifyOnly, &a.PlatformFilter, &a.ReplicationPausedAt, &a.DefaultPlatform, &a.RequiredLabels, &a.RecommendedAnnotations, &a.AdmissionPoliciesJSON, &a.IsDeleting,
+		&a.ApprovalPolicyJSON, &a.ServeBlobsViaCDN, &a.ResponseHeadersJSON, &a.PullTermsVersion, &a.PullTermsURL,
+		&a.MinPullPromotionState, &a.StoragePlacementJSON, &a.ForeignLayerPolicy, &a.TagProtectionPoliciesJSON, &a.ReplicationRetryHints,
+		&a.CascadeDeleteReferrers, &a.ContentEncryptionKeyRef, &a.StorageQuotaExceededAt,
 	)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
@@ -99,6 +109,27 @@ func FindBlobByAccountName(db gorp.SqlExecutor, blobDigest digest.Digest, accoun
 	return &blob, err
 }
 
+var sharedBlobGetQuery = sqlext.SimplifyWhitespace(`
+	SELECT b.*
+	  FROM blobs b
+	  JOIN accounts a ON a.name = b.account_name
+	 WHERE b.digest = $1 AND b.storage_id != '' AND b.account_name != $2
+	   AND NOT a.is_deleting AND (a.auth_tenant_id = $3 OR a.share_blobs)
+	 ORDER BY a.auth_tenant_id = $3 DESC, b.account_name
+	 LIMIT 1
+`)
+
+// FindSharedBlob looks for a blob with the given digest that is stored in any
+// account other than the given one, and that may be copied into the given
+// account. Blobs are always shared between accounts of the same auth tenant,
+// and across auth tenants only if the account holding the blob has ShareBlobs
+// enabled. If no such blob exists, sql.ErrNoRows is returned.
+func FindSharedBlob(db gorp.SqlExecutor, blobDigest digest.Digest, account models.ReducedAccount) (*models.Blob, error) {
+	var blob models.Blob
+	err := db.SelectOne(&blob, sharedBlobGetQuery, blobDigest.String(), account.Name, account.AuthTenantID)
+	return &blob, err
+}
+
 // MountBlobIntoRepo creates an entry in the blob_mounts database table.
 func MountBlobIntoRepo(db gorp.SqlExecutor, blob models.Blob, repo models.Repository) error {
 	_, err := db.Exec(
@@ -221,3 +252,47 @@ func GetSecurityInfo(db gorp.SqlExecutor, repoID int64, manifestDigest digest.Di
 
 	return securityInfo, err
 }
+
+var storeTrivyReportQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO trivy_reports (repo_id, digest, contents) VALUES ($1, $2, $3)
+	ON CONFLICT (repo_id, digest) DO UPDATE SET contents = EXCLUDED.contents
+`)
+
+// StoreTrivyReport stores the given Trivy report (in JSON format) for the
+// given manifest, replacing any previously stored report.
+func StoreTrivyReport(db gorp.SqlExecutor, repoID int64, manifestDigest digest.Digest, report []byte) error {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	_, err := gzw.Write(report)
+	if err != nil {
+		return err
+	}
+	err = gzw.Close()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(storeTrivyReportQuery, repoID, manifestDigest, buf.Bytes())
+	return err
+}
+
+// GetTrivyReport returns the Trivy report (in JSON format) that was stored
+// for the given manifest by StoreTrivyReport, or nil if there is none.
+func GetTrivyReport(db gorp.SqlExecutor, repoID int64, manifestDigest digest.Digest) ([]byte, error) {
+	var contents []byte
+	err := db.QueryRow(`SELECT contents FROM trivy_reports WHERE repo_id = $1 AND digest = $2`, repoID, manifestDigest).Scan(&contents)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	gzr, err := gzip.NewReader(bytes.NewReader(contents))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress stored Trivy report: %w", err)
+	}
+	report, err := io.ReadAll(gzr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress stored Trivy report: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DatabaseMaintenanceConfig controls the janitor job that measures table and
+// index bloat in the busiest database tables and, during maintenance windows,
+// reduces it.
+type DatabaseMaintenanceConfig struct {
+	// How often bloat is measured.
+	Interval time.Duration
+	// Maintenance is only performed while the current time is within one of
+	// these windows. If empty, bloat is only measured and reported as metrics.
+	Windows []MaintenanceWindow
+	// Tables and indexes are only maintained if at least this percentage of
+	// their size is estimated to be bloat.
+	BloatThresholdPercent int
+}
+
+// MaintenanceWindow is a time window that recurs every day. Start and End are
+// offsets from midnight UTC. If End is before Start, the window spans midnight.
+type MaintenanceWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Contains returns whether the given point in time is within this window.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// IsInMaintenanceWindow returns whether the given point in time is within one
+// of the configured maintenance windows.
+func (c DatabaseMaintenanceConfig) IsInMaintenanceWindow(t time.Time) bool {
+	for _, w := range c.Windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMaintenanceWindows parses the contents of the
+// KEPPEL_DB_MAINTENANCE_WINDOWS variable, a comma-separated list of time
+// ranges in UTC like "02:00-04:00,22:30-23:00".
+func ParseMaintenanceWindows(in string) ([]MaintenanceWindow, error) {
+	var result []MaintenanceWindow
+	for _, field := range strings.Split(in, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		startStr, endStr, ok := strings.Cut(field, "-")
+		if !ok {
+			return nil, fmt.Errorf("expected a time range like \"02:00-04:00\", but got %q", field)
+		}
+		start, err := parseTimeOfDay(startStr)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(endStr)
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("time range %q is empty", field)
+		}
+		result = append(result, MaintenanceWindow{Start: start, End: end})
+	}
+	return result, nil
+}
+
+func parseTimeOfDay(in string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(in))
+	if err != nil {
+		return 0, fmt.Errorf("expected a time of day like \"02:00\", but got %q", in)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// Deprecation describes an API endpoint or an API behavior that is slated
+// for removal. Responses that involve a deprecated endpoint or behavior carry
+// the Deprecation header from RFC 9745 and, if a removal date has been
+// decided on, the Sunset header from RFC 8594.
+type Deprecation struct {
+	// ID identifies this deprecation in the deprecated_api_usage table and in the API.
+	ID          string
+	Description string
+	// Replacement describes what clients should do instead.
+	Replacement  string
+	DeprecatedAt time.Time
+	// SunsetAt is zero if no removal date has been decided on yet.
+	SunsetAt time.Time
+}
+
+// AllDeprecations lists all known deprecations. To deprecate an endpoint or
+// behavior, add it here and call ReportDeprecatedUsage() wherever it is used.
+//
+// There are currently no deprecated endpoints or behaviors.
+var AllDeprecations []Deprecation
+
+// DeprecatedAPIUsageCounter is a prometheus.CounterVec.
+var DeprecatedAPIUsageCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "keppel_deprecated_api_usages",
+		Help: "Counts requests that involve a deprecated API endpoint or behavior.",
+	},
+	[]string{"deprecation"},
+)
+
+func init() {
+	prometheus.MustRegister(DeprecatedAPIUsageCounter)
+}
+
+const (
+	// Above this number of pending records, further usages are not recorded in
+	// the DB until the next flush, to bound memory usage if the DB is unreachable.
+	deprecatedAPIUsageMaxPending = 10000
+)
+
+// The WHERE EXISTS skips records for accounts that were deleted in the meantime.
+var flushDeprecatedAPIUsageQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO deprecated_api_usage (account_name, deprecation_id, first_used_at, last_used_at, usage_count)
+	SELECT $1::TEXT, $2::TEXT, $3::TIMESTAMPTZ, $4::TIMESTAMPTZ, $5::BIGINT WHERE EXISTS (SELECT 1 FROM accounts WHERE name = $1)
+	ON CONFLICT (account_name, deprecation_id) DO UPDATE
+	SET first_used_at = LEAST(deprecated_api_usage.first_used_at, EXCLUDED.first_used_at),
+	    last_used_at = GREATEST(deprecated_api_usage.last_used_at, EXCLUDED.last_used_at),
+	    usage_count = deprecated_api_usage.usage_count + EXCLUDED.usage_count
+`)
+
+type deprecatedAPIUsageKey struct {
+	AccountName   models.AccountName
+	DeprecationID string
+}
+
+type deprecatedAPIUsageUpdate struct {
+	FirstUsedAt time.Time
+	LastUsedAt  time.Time
+	UsageCount  int64
+}
+
+// Usages of deprecated APIs within accounts are collected in memory, and are
+// only written into the DB by FlushDeprecatedAPIUsage(), so that requests
+// using a deprecated API do not cause additional DB writes.
+var (
+	deprecatedAPIUsageMutex   sync.Mutex
+	deprecatedAPIUsagePending = make(map[deprecatedAPIUsageKey]deprecatedAPIUsageUpdate)
+)
+
+// ReportDeprecatedUsage adds the Deprecation and Sunset headers for the given
+// deprecation to the response, and counts the usage in
+// DeprecatedAPIUsageCounter. If the request refers to an account, the usage is
+// also counted in the deprecated_api_usage table (see
+// FlushDeprecatedAPIUsage), so that operators can find out who still relies
+// on the deprecated endpoint or behavior.
+//
+// This must be called before the response status is written.
+func ReportDeprecatedUsage(w http.ResponseWriter, d Deprecation, accountName models.AccountName, now time.Time) {
+	hdr := w.Header()
+	hdr.Set("Deprecation", "@"+strconv.FormatInt(d.DeprecatedAt.Unix(), 10))
+	if !d.SunsetAt.IsZero() {
+		hdr.Set("Sunset", d.SunsetAt.UTC().Format(http.TimeFormat))
+	}
+	DeprecatedAPIUsageCounter.With(prometheus.Labels{"deprecation": d.ID}).Inc()
+
+	if accountName == "" {
+		return
+	}
+	key := deprecatedAPIUsageKey{accountName, d.ID}
+	deprecatedAPIUsageMutex.Lock()
+	defer deprecatedAPIUsageMutex.Unlock()
+	update, exists := deprecatedAPIUsagePending[key]
+	if !exists {
+		if len(deprecatedAPIUsagePending) >= deprecatedAPIUsageMaxPending {
+			logg.Error("dropping usage of deprecated API %q in account %q: too many pending records", d.ID, accountName)
+			return
+		}
+		update.FirstUsedAt = now
+	}
+	update.LastUsedAt = now
+	update.UsageCount++
+	deprecatedAPIUsagePending[key] = update
+}
+
+// FlushDeprecatedAPIUsage writes all usages recorded by ReportDeprecatedUsage
+// into the DB.
+func FlushDeprecatedAPIUsage(db *DB) error {
+	deprecatedAPIUsageMutex.Lock()
+	pending := deprecatedAPIUsagePending
+	deprecatedAPIUsagePending = make(map[deprecatedAPIUsageKey]deprecatedAPIUsageUpdate, len(pending))
+	deprecatedAPIUsageMutex.Unlock()
+
+	keys := slices.SortedFunc(maps.Keys(pending), func(lhs, rhs deprecatedAPIUsageKey) int {
+		return cmp.Or(
+			cmp.Compare(lhs.AccountName, rhs.AccountName),
+			cmp.Compare(lhs.DeprecationID, rhs.DeprecationID),
+		)
+	})
+	for idx, key := range keys {
+		update := pending[key]
+		_, err := db.Exec(flushDeprecatedAPIUsageQuery, key.AccountName, key.DeprecationID, update.FirstUsedAt, update.LastUsedAt, update.UsageCount)
+		if err != nil {
+			// keep the unwritten records around for the next attempt
+			restoreDeprecatedAPIUsage(pending, keys[idx:])
+			return fmt.Errorf("could not write %d deprecated API usage records: %w", len(keys)-idx, err)
+		}
+	}
+	return nil
+}
+
+// restoreDeprecatedAPIUsage merges records that could not be written back
+// into deprecatedAPIUsagePending.
+func restoreDeprecatedAPIUsage(updates map[deprecatedAPIUsageKey]deprecatedAPIUsageUpdate, keys []deprecatedAPIUsageKey) {
+	deprecatedAPIUsageMutex.Lock()
+	defer deprecatedAPIUsageMutex.Unlock()
+	for _, key := range keys {
+		update := updates[key]
+		if newer, exists := deprecatedAPIUsagePending[key]; exists {
+			update.LastUsedAt = newer.LastUsedAt
+			update.UsageCount += newer.UsageCount
+		} else if len(deprecatedAPIUsagePending) >= deprecatedAPIUsageMaxPending {
+			continue
+		}
+		deprecatedAPIUsagePending[key] = update
+	}
+}
+
+// RunDeprecatedAPIUsageFlush calls FlushDeprecatedAPIUsage() at the given
+// interval until the given context expires. Remaining records are flushed
+// before returning.
+func RunDeprecatedAPIUsageFlush(ctx context.Context, db *DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			err := FlushDeprecatedAPIUsage(db)
+			if err != nil {
+				logg.Error(err.Error())
+			}
+			return
+		case <-ticker.C:
+			err := FlushDeprecatedAPIUsage(db)
+			if err != nil {
+				logg.Error(err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/logg"
+)
+
+// DistributionEventsMediaType is the media type of the notification envelope
+// that docker/distribution sends to its notification endpoints.
+const DistributionEventsMediaType = "application/vnd.docker.distribution.events.v1+json"
+
+const (
+	distributionNotificationQueueSize  = 1024
+	distributionNotificationBatchSize  = 100
+	distributionNotificationMaxRetries = 3
+	distributionNotificationTimeout    = 10 * time.Second
+)
+
+// DistributionEnvelope is the request body that DistributionNotifier sends
+// to each endpoint. The format matches that of docker/distribution, so that
+// existing notification consumers can be pointed at Keppel without changes.
+type DistributionEnvelope struct {
+	Events []DistributionEvent `json:"events"`
+}
+
+// DistributionEvent appears in type DistributionEnvelope.
+type DistributionEvent struct {
+	ID        string                   `json:"id"`
+	Timestamp time.Time                `json:"timestamp"`
+	Action    string                   `json:"action"` // one of "push", "pull", "mount" or "delete"
+	Target    DistributionEventTarget  `json:"target"`
+	Request   DistributionEventRequest `json:"request"`
+	Actor     DistributionEventActor   `json:"actor"`
+	Source    DistributionEventSource  `json:"source"`
+}
+
+// DistributionEventTarget appears in type DistributionEvent.
+type DistributionEventTarget struct {
+	MediaType      string        `json:"mediaType,omitempty"`
+	Size           uint64        `json:"size,omitempty"`
+	Digest         digest.Digest `json:"digest,omitempty"`
+	Length         uint64        `json:"length,omitempty"`
+	Repository     string        `json:"repository"`
+	FromRepository string        `json:"fromRepository,omitempty"`
+	URL            string        `json:"url,omitempty"`
+	Tag            string        `json:"tag,omitempty"`
+}
+
+// DistributionEventRequest appears in type DistributionEvent.
+type DistributionEventRequest struct {
+	ID        string `json:"id,omitempty"`
+	Addr      string `json:"addr"`
+	Host      string `json:"host"`
+	Method    string `json:"method"`
+	UserAgent string `json:"useragent"`
+}
+
+// DistributionEventActor appears in type DistributionEvent.
+type DistributionEventActor struct {
+	Name string `json:"name,omitempty"`
+}
+
+// DistributionEventSource appears in type DistributionEvent.
+type DistributionEventSource struct {
+	Addr       string `json:"addr"`
+	InstanceID string `json:"instanceID"`
+}
+
+// DistributionNotifier delivers registry events to the endpoints configured in
+// Configuration.DistributionNotificationURLs. Delivery happens asynchronously
+// and on a best-effort basis: When the endpoints cannot keep up, events are
+// dropped instead of slowing down the registry API.
+type DistributionNotifier struct {
+	urls   []url.URL
+	source DistributionEventSource
+	queue  chan DistributionEvent
+}
+
+// NewDistributionNotifier builds a DistributionNotifier and starts its
+// delivery loop, which runs until the given context expires. If no
+// notification URLs are configured, nil is returned. All methods can be
+// called on a nil DistributionNotifier and do nothing in that case.
+func NewDistributionNotifier(ctx context.Context, cfg Configuration) *DistributionNotifier {
+	if len(cfg.DistributionNotificationURLs) == 0 {
+		return nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = cfg.APIPublicHostname
+	}
+	n := &DistributionNotifier{
+		urls: cfg.DistributionNotificationURLs,
+		source: DistributionEventSource{
+			Addr:       hostname,
+			InstanceID: audittools.GenerateUUID(),
+		},
+		queue: make(chan DistributionEvent, distributionNotificationQueueSize),
+	}
+	go n.deliveryLoop(ctx)
+	return n
+}
+
+// Notify enqueues an event for the given request. The target must have at
+// least Repository filled. If target.URL is empty, it is filled from the
+// request's URL.
+func (n *DistributionNotifier) Notify(r *http.Request, action string, target DistributionEventTarget, userIdentity UserIdentity) {
+	if n == nil {
+		return
+	}
+
+	if target.URL == "" {
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = r.Host
+		u.RawQuery = ""
+		target.URL = u.String()
+	}
+	if target.Length == 0 {
+		target.Length = target.Size
+	}
+	event := DistributionEvent{
+		ID:        audittools.GenerateUUID(),
+		Timestamp: time.Now().UTC(),
+		Action:    action,
+		Target:    target,
+		Request: DistributionEventRequest{
+			ID:        r.Header.Get("X-Request-Id"),
+			Addr:      ClientIPFor(r),
+			Host:      r.Host,
+			Method:    r.Method,
+			UserAgent: r.Header.Get("User-Agent"),
+		},
+		Source: n.source,
+	}
+	if userIdentity != nil {
+		event.Actor.Name = userIdentity.UserName()
+	}
+
+	select {
+	case n.queue <- event:
+	default:
+		logg.Error("dropping %s event for %s because the distribution notification queue is full", action, target.Repository)
+	}
+}
+
+func (n *DistributionNotifier) deliveryLoop(ctx context.Context) {
+	for {
+		var events []DistributionEvent
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-n.queue:
+			events = append(events, event)
+		}
+
+		// collect whatever else is already waiting, to reduce the number of requests
+	collect:
+		for len(events) < distributionNotificationBatchSize {
+			select {
+			case event := <-n.queue:
+				events = append(events, event)
+			default:
+				break collect
+			}
+		}
+
+		body, err := json.Marshal(DistributionEnvelope{Events: events})
+		if err != nil {
+			logg.Error("cannot serialize distribution notification envelope: %s", err.Error())
+			continue
+		}
+		for _, endpointURL := range n.urls {
+			err := deliverDistributionEnvelope(ctx, endpointURL.String(), body)
+			if err != nil {
+				logg.Error("could not deliver %d distribution notification events to %s: %s", len(events), endpointURL.Redacted(), err.Error())
+			}
+		}
+	}
+}
+
+func deliverDistributionEnvelope(ctx context.Context, endpointURL string, body []byte) (err error) {
+	for attempt := range distributionNotificationMaxRetries {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+		err = submitDistributionEnvelope(ctx, endpointURL, body)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func submitDistributionEnvelope(ctx context.Context, endpointURL string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, distributionNotificationTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpointURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", DistributionEventsMediaType)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10)) //nolint:errcheck // only used for the error message
+		return fmt.Errorf("expected 2xx status, but got %s: %q", resp.Status, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDistributionNotifier(t *testing.T) {
+	// nil notifiers must be usable
+	if n := NewDistributionNotifier(t.Context(), Configuration{}); n != nil {
+		t.Fatalf("expected nil notifier without configured URLs, but got %#v", n)
+	}
+	(*DistributionNotifier)(nil).Notify(httptest.NewRequest(http.MethodGet, "/", http.NoBody), "pull", DistributionEventTarget{}, nil)
+
+	received := make(chan *http.Request, 1)
+	envelopes := make(chan DistributionEnvelope, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope DistributionEnvelope
+		err := json.NewDecoder(r.Body).Decode(&envelope)
+		if err != nil {
+			t.Errorf("cannot decode notification envelope: %s", err.Error())
+		}
+		received <- r
+		envelopes <- envelope
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	srvURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	n := NewDistributionNotifier(t.Context(), Configuration{
+		APIPublicHostname:            "registry.example.org",
+		DistributionNotificationURLs: []url.URL{*srvURL},
+	})
+
+	r := httptest.NewRequest(http.MethodPut, "https://registry.example.org/v2/test1/foo/manifests/latest", http.NoBody)
+	r.Header.Set("User-Agent", "docker/28.0")
+	n.Notify(r, "push", DistributionEventTarget{
+		MediaType:  "application/vnd.oci.image.manifest.v1+json",
+		Size:       42,
+		Digest:     "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		Repository: "test1/foo",
+		Tag:        "latest",
+	}, nil)
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out while waiting for notification delivery")
+	case req := <-received:
+		envelope := <-envelopes
+		if ct := req.Header.Get("Content-Type"); ct != DistributionEventsMediaType {
+			t.Errorf("expected Content-Type %q, but got %q", DistributionEventsMediaType, ct)
+		}
+		if len(envelope.Events) != 1 {
+			t.Fatalf("expected exactly one event, but got %#v", envelope.Events)
+		}
+		event := envelope.Events[0]
+		if event.Action != "push" || event.Target.Repository != "test1/foo" || event.Target.Tag != "latest" {
+			t.Errorf("unexpected event: %#v", event)
+		}
+		if event.Target.Length != 42 {
+			t.Errorf("expected target.length to be filled from target.size, but got %d", event.Target.Length)
+		}
+		if event.Target.URL != "https://registry.example.org/v2/test1/foo/manifests/latest" {
+			t.Errorf("unexpected target.url: %q", event.Target.URL)
+		}
+		if event.Request.Method != http.MethodPut || event.Request.UserAgent != "docker/28.0" {
+			t.Errorf("unexpected request info: %#v", event.Request)
+		}
+		if event.ID == "" || event.Source.InstanceID == "" {
+			t.Errorf("expected event ID and instance ID to be filled, but got %#v", event)
+		}
+	}
+}
@@ -5,8 +5,10 @@ package keppel
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/sapcc/go-bits/errext"
 	"github.com/sapcc/go-bits/respondwith"
@@ -100,20 +102,91 @@ var apiErrorStatusCodes = map[RegistryV2ErrorCode]int{
 type RegistryV2Error struct {
 	Code    RegistryV2ErrorCode `json:"code"`
 	Message string              `json:"message"`
-	// Detail is always a string for errors generated by Keppel, but may be a JSON
-	// object (i.e. map[string]any or similar) for errors coming from
-	// keppel-registry.
+	// Detail is either a string or a RegistryV2ErrorDetail for errors generated
+	// by Keppel, but may be a JSON object (i.e. map[string]any or similar) for
+	// errors coming from upstream registries.
 	Detail  any         `json:"detail"`
 	Status  int         `json:"-"`
 	Headers http.Header `json:"-"`
 }
 
+// RegistryV2ErrorReason is the closed set of values for RegistryV2ErrorDetail.Reason.
+type RegistryV2ErrorReason string
+
+// Possible values for RegistryV2ErrorReason.
+const (
+	ReasonQuotaExceeded         RegistryV2ErrorReason = "quota_exceeded"
+	ReasonMissingRequiredLabels RegistryV2ErrorReason = "missing_required_labels"
+	ReasonPushToReplica         RegistryV2ErrorReason = "push_to_replica"
+	ReasonAccountDeleting       RegistryV2ErrorReason = "account_being_deleted"
+	ReasonRepositoryArchived    RegistryV2ErrorReason = "repository_archived"
+	ReasonRateLimited           RegistryV2ErrorReason = "rate_limited"
+	ReasonConcurrencyLimited    RegistryV2ErrorReason = "too_many_concurrent_requests"
+	ReasonUpstreamUnavailable   RegistryV2ErrorReason = "upstream_unavailable"
+	ReasonReplicationPaused     RegistryV2ErrorReason = "replication_paused"
+	ReasonUpstreamBlocked       RegistryV2ErrorReason = "upstream_blocked"
+	ReasonReplicationInProgress RegistryV2ErrorReason = "replication_in_progress"
+	ReasonVerificationPending   RegistryV2ErrorReason = "verification_pending"
+	ReasonAdmissionPolicy       RegistryV2ErrorReason = "blocked_by_admission_policy"
+	ReasonManifestQuarantined   RegistryV2ErrorReason = "manifest_quarantined"
+	ReasonManifestNotPromoted   RegistryV2ErrorReason = "manifest_not_promoted"
+	ReasonAdmissionWebhook      RegistryV2ErrorReason = "blocked_by_admission_webhook"
+	ReasonTagProtected          RegistryV2ErrorReason = "tag_protected"
+	ReasonTagImmutable          RegistryV2ErrorReason = "tag_immutable"
+	ReasonStorageQuotaExceeded  RegistryV2ErrorReason = "storage_quota_exceeded"
+)
+
+// RegistryV2ErrorDetail is a machine-readable remediation hint that appears
+// in RegistryV2Error.Detail for some errors generated by Keppel. Since the
+// Message field of these errors already contains the same information in
+// human-readable form, it is not repeated by RegistryV2Error.Error().
+//
+// Only the fields relevant to the respective Reason are filled.
+type RegistryV2ErrorDetail struct {
+	Reason RegistryV2ErrorReason `json:"reason"`
+	// for ReasonQuotaExceeded
+	Limit *uint64 `json:"limit,omitempty"`
+	Usage *uint64 `json:"usage,omitempty"`
+	// for ReasonMissingRequiredLabels
+	MissingLabels []string `json:"missing_labels,omitempty"`
+	// for ReasonAdmissionPolicy
+	AdmissionPolicy string `json:"admission_policy,omitempty"`
+	// for ReasonTagProtected and ReasonTagImmutable
+	TagProtectionPolicy *TagProtectionPolicy `json:"tag_protection_policy,omitempty"`
+	// for ReasonPushToReplica (where to push instead)
+	PushTo string `json:"push_to,omitempty"`
+	// for ReasonUpstreamUnavailable and ReasonUpstreamBlocked
+	UpstreamHostName string `json:"upstream_hostname,omitempty"`
+	// for ReasonReplicationInProgress
+	ReplicatedBytes *uint64 `json:"replicated_bytes,omitempty"`
+	TotalBytes      *uint64 `json:"total_bytes,omitempty"`
+	// for ReasonRateLimited, ReasonConcurrencyLimited, ReasonUpstreamUnavailable, ReasonReplicationInProgress and ReasonVerificationPending
+	RetryAfterSeconds *uint64 `json:"retry_after_seconds,omitempty"`
+}
+
+// QuotaExceededDetail builds a RegistryV2ErrorDetail with ReasonQuotaExceeded.
+func QuotaExceededDetail(limit, usage uint64) RegistryV2ErrorDetail {
+	return RegistryV2ErrorDetail{Reason: ReasonQuotaExceeded, Limit: &limit, Usage: &usage}
+}
+
+// RetryAfterDetail builds a RegistryV2ErrorDetail for a reason where the
+// client can retry the request after the given time.
+func RetryAfterDetail(reason RegistryV2ErrorReason, retryAfter time.Duration) RegistryV2ErrorDetail {
+	seconds := AtLeastZero(int64(retryAfter / time.Second))
+	return RegistryV2ErrorDetail{Reason: reason, RetryAfterSeconds: &seconds}
+}
+
 // AsRegistryV2Error tries to cast `err` into RegistryV2Error. If `err` is not a
-// RegistryV2Error, it gets wrapped in ErrUnknown instead.
+// RegistryV2Error, it gets wrapped in ErrUnknown instead (except for
+// ErrStorageQuotaExceeded, which gets its own error code and detail).
 func AsRegistryV2Error(err error) *RegistryV2Error {
 	if rerr, ok := errext.As[*RegistryV2Error](err); ok {
 		return rerr
 	}
+	if errors.Is(err, ErrStorageQuotaExceeded) {
+		return ErrDenied.With(err.Error()).WithStatus(http.StatusInsufficientStorage).
+			WithDetail(RegistryV2ErrorDetail{Reason: ReasonStorageQuotaExceeded})
+	}
 	return ErrUnknown.With(err.Error())
 }
 
@@ -187,6 +260,9 @@ func (e *RegistryV2Error) WriteAsTextTo(w http.ResponseWriter) {
 // Error implements the builtin/error interface.
 func (e *RegistryV2Error) Error() string {
 	text := e.Message
+	if _, ok := e.Detail.(RegistryV2ErrorDetail); ok {
+		return text
+	}
 	if e.Detail != nil {
 		detailStr, ok := e.Detail.(string)
 		if !ok {
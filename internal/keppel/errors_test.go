@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistryV2ErrorDetail(t *testing.T) {
+	rerr := ErrDenied.With("manifest quota exceeded (quota = 1, usage = 2)").
+		WithStatus(http.StatusConflict).
+		WithDetail(QuotaExceededDetail(1, 2))
+
+	// the string form is unaffected by structured details
+	expectedText := "manifest quota exceeded (quota = 1, usage = 2)"
+	if rerr.Error() != expectedText {
+		t.Errorf("expected Error() = %q, but got %q", expectedText, rerr.Error())
+	}
+
+	// the structured detail appears in the Registry V2 API response
+	rec := httptest.NewRecorder()
+	rerr.WriteAsRegistryV2ResponseTo(rec, httptest.NewRequest(http.MethodGet, "/v2/", http.NoBody))
+	expectedBody := `{"errors":[{"code":"DENIED","message":"manifest quota exceeded (quota = 1, usage = 2)","detail":{"reason":"quota_exceeded","limit":1,"usage":2}}]}` + "\n"
+	if rec.Body.String() != expectedBody {
+		t.Errorf("expected response body %q, but got %q", expectedBody, rec.Body.String())
+	}
+
+	// string details still work as before
+	rerr = ErrManifestUnknown.With("").WithDetail("latest")
+	expectedText = "manifest unknown: latest"
+	if rerr.Error() != expectedText {
+		t.Errorf("expected Error() = %q, but got %q", expectedText, rerr.Error())
+	}
+}
@@ -0,0 +1,331 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Constants for the eStargz layer format, as defined by
+// <https://github.com/containerd/stargz-snapshotter/blob/main/docs/estargz.md>.
+const (
+	// EstargzTOCDigestAnnotation is the layer annotation that contains the digest
+	// of the TOC JSON of an eStargz layer.
+	EstargzTOCDigestAnnotation = "containerd.io/snapshot/stargz/toc.digest"
+	// EstargzUncompressedSizeAnnotation is the layer annotation that contains the
+	// size of the uncompressed tar stream of an eStargz layer.
+	EstargzUncompressedSizeAnnotation = "io.containers.estargz.uncompressed-size"
+
+	estargzTOCName            = "stargz.index.json"
+	estargzNoPrefetchLandmark = ".no.prefetch.landmark"
+	estargzPrefetchLandmark   = ".prefetch.landmark"
+	estargzLandmarkContents   = 0xf
+	estargzChunkSize          = 4 << 20 // 4 MiB
+	estargzFooterSize         = 51
+)
+
+// EstargzLayer describes a layer that was generated by ConvertLayerToEstargz.
+type EstargzLayer struct {
+	// Digest of the TOC JSON (for EstargzTOCDigestAnnotation).
+	TOCDigest digest.Digest
+	// Digest of the uncompressed tar stream (for the image config's rootfs.diff_ids).
+	DiffID digest.Digest
+	// Size of the uncompressed tar stream (for EstargzUncompressedSizeAnnotation).
+	UncompressedSizeBytes uint64
+}
+
+type estargzTOC struct {
+	Version int                `json:"version"`
+	Entries []*estargzTOCEntry `json:"entries"`
+}
+
+type estargzTOCEntry struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Size        int64             `json:"size,omitempty"`
+	ModTime3339 string            `json:"modtime,omitempty"`
+	LinkName    string            `json:"linkName,omitempty"`
+	Mode        int64             `json:"mode,omitempty"`
+	UID         int               `json:"uid,omitempty"`
+	GID         int               `json:"gid,omitempty"`
+	Uname       string            `json:"userName,omitempty"`
+	Gname       string            `json:"groupName,omitempty"`
+	Offset      int64             `json:"offset,omitempty"`
+	DevMajor    int64             `json:"devMajor,omitempty"`
+	DevMinor    int64             `json:"devMinor,omitempty"`
+	Xattrs      map[string][]byte `json:"xattrs,omitempty"`
+	Digest      string            `json:"digest,omitempty"`
+	ChunkOffset int64             `json:"chunkOffset,omitempty"`
+	ChunkSize   int64             `json:"chunkSize,omitempty"`
+	ChunkDigest string            `json:"chunkDigest,omitempty"`
+}
+
+// ConvertLayerToEstargz reads an uncompressed tar archive from `in` and writes
+// the same files as a seekable eStargz layer into `out`. Lazy-pulling container
+// runtimes can use the TOC within the eStargz layer to fetch individual files
+// on demand, while all other clients can still consume the layer like any
+// other gzip-compressed tar archive.
+//
+// Since the eStargz layer contains additional tar entries, its uncompressed
+// tar stream is different from the input, so the image config needs to be
+// updated with the returned DiffID.
+func ConvertLayerToEstargz(in io.Reader, out io.Writer) (EstargzLayer, error) {
+	w := &estargzWriter{
+		out:    &estargzCountingWriter{Writer: out},
+		diffID: digest.Canonical.Digester(),
+		toc:    estargzTOC{Version: 1},
+	}
+
+	// we do not know which files are needed at container startup, so mark the
+	// layer as not requiring any prefetch
+	err := w.appendEntry(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     estargzNoPrefetchLandmark,
+		Mode:     0o644,
+		Size:     1,
+	}, bytes.NewReader([]byte{estargzLandmarkContents}))
+	if err != nil {
+		return EstargzLayer{}, err
+	}
+
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return EstargzLayer{}, fmt.Errorf("while reading input tar: %w", err)
+		}
+		switch cleanEstargzEntryName(hdr.Name) {
+		case estargzTOCName, estargzNoPrefetchLandmark, estargzPrefetchLandmark:
+			return EstargzLayer{}, fmt.Errorf("input tar contains reserved entry %q", hdr.Name)
+		}
+		err = w.appendEntry(hdr, tr)
+		if err != nil {
+			return EstargzLayer{}, err
+		}
+	}
+
+	return w.finish()
+}
+
+func cleanEstargzEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+type estargzCountingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *estargzCountingWriter) Write(buf []byte) (int, error) {
+	n, err := w.Writer.Write(buf)
+	w.n += int64(n)
+	return n, err
+}
+
+// estargzWriter is an io.Writer for the uncompressed tar stream. It writes into
+// the current gzip member, and opens a new one if none is open.
+type estargzWriter struct {
+	out                   *estargzCountingWriter
+	gz                    *gzip.Writer
+	diffID                digest.Digester
+	uncompressedSizeBytes uint64
+	toc                   estargzTOC
+}
+
+func (w *estargzWriter) Write(buf []byte) (int, error) {
+	if w.gz == nil {
+		w.gz = gzip.NewWriter(w.out)
+	}
+	n, err := w.gz.Write(buf)
+	w.diffID.Hash().Write(buf[:n])
+	w.uncompressedSizeBytes += uint64(n) //nolint:gosec // n is never negative
+	return n, err
+}
+
+func (w *estargzWriter) closeGzipMember() error {
+	if w.gz == nil {
+		return nil
+	}
+	err := w.gz.Close()
+	w.gz = nil
+	return err
+}
+
+func (w *estargzWriter) appendEntry(hdr *tar.Header, contents io.Reader) error {
+	name := cleanEstargzEntryName(hdr.Name)
+	entry := &estargzTOCEntry{
+		Name:  name,
+		Mode:  hdr.Mode,
+		UID:   hdr.Uid,
+		GID:   hdr.Gid,
+		Uname: hdr.Uname,
+		Gname: hdr.Gname,
+	}
+	if !hdr.ModTime.IsZero() {
+		entry.ModTime3339 = hdr.ModTime.UTC().Round(time.Second).Format(time.RFC3339)
+	}
+	for key, value := range hdr.PAXRecords {
+		if xattrName, ok := strings.CutPrefix(key, "SCHILY.xattr."); ok {
+			if entry.Xattrs == nil {
+				entry.Xattrs = make(map[string][]byte)
+			}
+			entry.Xattrs[xattrName] = []byte(value)
+		}
+	}
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		entry.Type = "reg"
+		entry.Size = hdr.Size
+	case tar.TypeDir:
+		entry.Type = "dir"
+	case tar.TypeSymlink:
+		entry.Type = "symlink"
+		entry.LinkName = hdr.Linkname
+	case tar.TypeLink:
+		entry.Type = "hardlink"
+		entry.LinkName = hdr.Linkname
+	case tar.TypeChar:
+		entry.Type = "char"
+		entry.DevMajor = hdr.Devmajor
+		entry.DevMinor = hdr.Devminor
+	case tar.TypeBlock:
+		entry.Type = "block"
+		entry.DevMajor = hdr.Devmajor
+		entry.DevMinor = hdr.Devminor
+	case tar.TypeFifo:
+		entry.Type = "fifo"
+	default:
+		return fmt.Errorf("input tar contains entry %q of unsupported type %q", hdr.Name, hdr.Typeflag)
+	}
+
+	// the tar header goes into the current gzip member...
+	tw := tar.NewWriter(w)
+	err := tw.WriteHeader(hdr)
+	if err != nil {
+		return fmt.Errorf("while writing tar header for %q: %w", hdr.Name, err)
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		w.toc.Entries = append(w.toc.Entries, entry)
+		return tw.Flush()
+	}
+
+	// ...but each chunk of file contents goes into its own gzip member, so that
+	// it can be fetched and decompressed independently
+	fileEntry := entry
+	fileDigester := digest.Canonical.Digester()
+	fileContents := io.TeeReader(contents, fileDigester.Hash())
+	var written int64
+	for written < hdr.Size {
+		err := w.closeGzipMember()
+		if err != nil {
+			return err
+		}
+
+		chunkSize := int64(estargzChunkSize)
+		if remaining := hdr.Size - written; remaining < chunkSize {
+			chunkSize = remaining
+		} else {
+			entry.ChunkSize = chunkSize
+		}
+		entry.Offset = w.out.n
+		entry.ChunkOffset = written
+
+		chunkDigester := digest.Canonical.Digester()
+		_, err = io.CopyN(tw, io.TeeReader(fileContents, chunkDigester.Hash()), chunkSize)
+		if err != nil {
+			return fmt.Errorf("while copying contents of %q: %w", hdr.Name, err)
+		}
+		entry.ChunkDigest = chunkDigester.Digest().String()
+		w.toc.Entries = append(w.toc.Entries, entry)
+
+		written += chunkSize
+		entry = &estargzTOCEntry{Name: name, Type: "chunk"}
+	}
+	if hdr.Size == 0 {
+		w.toc.Entries = append(w.toc.Entries, entry)
+	}
+	fileEntry.Digest = fileDigester.Digest().String()
+	return tw.Flush()
+}
+
+func (w *estargzWriter) finish() (EstargzLayer, error) {
+	err := w.closeGzipMember()
+	if err != nil {
+		return EstargzLayer{}, err
+	}
+
+	// the TOC goes into a separate gzip member, along with the end of the tar archive
+	tocJSON, err := json.MarshalIndent(w.toc, "", "\t")
+	if err != nil {
+		return EstargzLayer{}, err
+	}
+	tocOffset := w.out.n
+	tw := tar.NewWriter(w)
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     estargzTOCName,
+		Size:     int64(len(tocJSON)),
+	})
+	if err != nil {
+		return EstargzLayer{}, err
+	}
+	_, err = tw.Write(tocJSON)
+	if err != nil {
+		return EstargzLayer{}, err
+	}
+	err = tw.Close()
+	if err != nil {
+		return EstargzLayer{}, err
+	}
+	err = w.closeGzipMember()
+	if err != nil {
+		return EstargzLayer{}, err
+	}
+
+	// the footer is an empty gzip member that points to the TOC
+	_, err = w.out.Write(estargzFooter(tocOffset))
+	if err != nil {
+		return EstargzLayer{}, err
+	}
+
+	return EstargzLayer{
+		TOCDigest:             digest.Canonical.FromBytes(tocJSON),
+		DiffID:                w.diffID.Digest(),
+		UncompressedSizeBytes: w.uncompressedSizeBytes,
+	}, nil
+}
+
+// estargzFooter builds the footer by hand instead of through gzip.Writer,
+// because readers rely on its exact size, and compress/flate does not
+// guarantee to encode an empty stream as an empty stored block.
+func estargzFooter(tocOffset int64) []byte {
+	subfield := fmt.Sprintf("%016xSTARGZ", tocOffset)
+	extra := binary.LittleEndian.AppendUint16([]byte{'S', 'G'}, uint16(len(subfield)))
+	extra = append(extra, subfield...)
+
+	// gzip header with FEXTRA flag, zero mtime, and unknown OS
+	buf := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff}
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(extra)))
+	buf = append(buf, extra...)
+	// final empty stored block
+	buf = append(buf, 0x01, 0x00, 0x00, 0xff, 0xff)
+	// CRC-32 and size of the empty payload
+	buf = append(buf, 0, 0, 0, 0, 0, 0, 0, 0)
+	return buf
+}
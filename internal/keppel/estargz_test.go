@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+)
+
+func TestConvertLayerToEstargz(t *testing.T) {
+	// build an input layer with a file that spans multiple chunks
+	largeFile := make([]byte, 2*estargzChunkSize+1000)
+	_, _ = rand.New(rand.NewSource(42)).Read(largeFile) //nolint:gosec // not used for security
+	modTime := time.Unix(1700000000, 0)
+	var in bytes.Buffer
+	tw := tar.NewWriter(&in)
+	mustDo(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0o755, ModTime: modTime}))
+	mustDo(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "etc/hostname", Mode: 0o644, Size: 8, ModTime: modTime}))
+	_, err := tw.Write([]byte("example\n"))
+	mustDo(t, err)
+	mustDo(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "etc/empty", Mode: 0o644, ModTime: modTime}))
+	mustDo(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/localtime", Linkname: "/usr/share/zoneinfo/UTC", ModTime: modTime}))
+	mustDo(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "./usr/lib/large.so", Mode: 0o755, Size: int64(len(largeFile)), ModTime: modTime}))
+	_, err = tw.Write(largeFile)
+	mustDo(t, err)
+	mustDo(t, tw.Close())
+
+	var out bytes.Buffer
+	layer, err := ConvertLayerToEstargz(bytes.NewReader(in.Bytes()), &out)
+	mustDo(t, err)
+	blob := out.Bytes()
+
+	// the layer can be consumed like any other gzip-compressed tar archive
+	gz, err := gzip.NewReader(bytes.NewReader(blob))
+	mustDo(t, err)
+	uncompressed, err := io.ReadAll(gz)
+	mustDo(t, err)
+	assert.DeepEqual(t, "DiffID", layer.DiffID, digest.Canonical.FromBytes(uncompressed))
+	assert.DeepEqual(t, "UncompressedSizeBytes", layer.UncompressedSizeBytes, uint64(len(uncompressed)))
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(uncompressed))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		mustDo(t, err)
+		names = append(names, hdr.Name)
+		if hdr.Name == "./usr/lib/large.so" {
+			contents, err := io.ReadAll(tr)
+			mustDo(t, err)
+			assert.DeepEqual(t, "large file contents", bytes.Equal(contents, largeFile), true)
+		}
+	}
+	assert.DeepEqual(t, "tar entries", names, []string{
+		".no.prefetch.landmark", "etc/", "etc/hostname", "etc/empty", "etc/localtime", "./usr/lib/large.so", "stargz.index.json",
+	})
+
+	// the footer points to the TOC
+	footer := blob[len(blob)-estargzFooterSize:]
+	gz, err = gzip.NewReader(bytes.NewReader(footer))
+	mustDo(t, err)
+	extra := string(gz.Header.Extra)
+	if !strings.HasPrefix(extra, "SG\x16\x00") || !strings.HasSuffix(extra, "STARGZ") {
+		t.Fatalf("unexpected footer extra field: %q", extra)
+	}
+	tocOffset, err := strconv.ParseInt(extra[4:20], 16, 64)
+	mustDo(t, err)
+
+	gz, err = gzip.NewReader(bytes.NewReader(blob[tocOffset:]))
+	mustDo(t, err)
+	gz.Multistream(false)
+	tr = tar.NewReader(gz)
+	hdr, err := tr.Next()
+	mustDo(t, err)
+	assert.DeepEqual(t, "TOC entry name", hdr.Name, "stargz.index.json")
+	tocJSON, err := io.ReadAll(tr)
+	mustDo(t, err)
+	assert.DeepEqual(t, "TOCDigest", layer.TOCDigest, digest.Canonical.FromBytes(tocJSON))
+
+	var toc estargzTOC
+	mustDo(t, json.Unmarshal(tocJSON, &toc))
+	var summary []string
+	for _, entry := range toc.Entries {
+		summary = append(summary, entry.Type+":"+entry.Name)
+	}
+	assert.DeepEqual(t, "TOC entries", summary, []string{
+		"reg:.no.prefetch.landmark", "dir:etc", "reg:etc/hostname", "reg:etc/empty", "symlink:etc/localtime",
+		"reg:usr/lib/large.so", "chunk:usr/lib/large.so", "chunk:usr/lib/large.so",
+	})
+	assert.DeepEqual(t, "symlink target", toc.Entries[4].LinkName, "/usr/share/zoneinfo/UTC")
+	assert.DeepEqual(t, "modtime", toc.Entries[2].ModTime3339, "2023-11-14T22:13:20Z")
+	assert.DeepEqual(t, "file digest", toc.Entries[5].Digest, digest.Canonical.FromBytes(largeFile).String())
+
+	// each chunk can be decompressed on its own, starting at its offset
+	fileSizes := make(map[string]int64)
+	for _, entry := range toc.Entries {
+		if entry.Type == "reg" {
+			fileSizes[entry.Name] = entry.Size
+		}
+		if entry.ChunkDigest == "" {
+			continue
+		}
+		chunkSize := entry.ChunkSize
+		if chunkSize == 0 {
+			// the last chunk of each file extends until the end of the file
+			chunkSize = fileSizes[entry.Name] - entry.ChunkOffset
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(blob[entry.Offset:]))
+		mustDo(t, err)
+		chunk := make([]byte, chunkSize)
+		_, err = io.ReadFull(gz, chunk)
+		mustDo(t, err)
+		assert.DeepEqual(t, "chunk digest of "+entry.Name, digest.Canonical.FromBytes(chunk).String(), entry.ChunkDigest)
+	}
+
+	// reserved names cannot appear in the input
+	in.Reset()
+	tw = tar.NewWriter(&in)
+	mustDo(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "stargz.index.json"}))
+	mustDo(t, tw.Close())
+	_, err = ConvertLayerToEstargz(&in, io.Discard)
+	if err == nil || err.Error() != `input tar contains reserved entry "stargz.index.json"` {
+		t.Errorf("expected error for reserved entry name, but got %v", err)
+	}
+}
+
+func mustDo(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// countingWriter counts the bytes written into it, and can be inspected while
+// writes are still ongoing.
+type countingWriter struct {
+	n atomic.Int64
+}
+
+func (w *countingWriter) Write(buf []byte) (int, error) {
+	w.n.Add(int64(len(buf)))
+	return len(buf), nil
+}
+
+func TestConvertLayerToEstargzStreams(t *testing.T) {
+	// the input is a file with several chunks that is written through a pipe, so
+	// we can observe the output while the input is still being produced
+	const fileSize = 4 * estargzChunkSize
+	pipeReader, pipeWriter := io.Pipe()
+	out := &countingWriter{}
+	done := make(chan error, 1)
+	go func() {
+		_, err := ConvertLayerToEstargz(pipeReader, out)
+		done <- err
+	}()
+
+	tw := tar.NewWriter(pipeWriter)
+	mustDo(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "large.bin", Mode: 0o644, Size: fileSize}))
+	chunk := bytes.Repeat([]byte{'x'}, estargzChunkSize)
+	for range 2 {
+		_, err := tw.Write(chunk)
+		mustDo(t, err)
+	}
+
+	// after two chunks of input were consumed, at least the first chunk must
+	// have been written out already, i.e. the layer is not buffered in full
+	if out.n.Load() == 0 {
+		t.Error("expected output to be written while input is still being read")
+	}
+
+	for range 2 {
+		_, err := tw.Write(chunk)
+		mustDo(t, err)
+	}
+	mustDo(t, tw.Close())
+	mustDo(t, pipeWriter.Close())
+	mustDo(t, <-done)
+}
@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"time"
+	_ "time/tzdata" // time zones in GC policies must work even if the host has no tzdata installed
 
 	"github.com/sapcc/go-bits/regexpext"
 
@@ -21,9 +23,14 @@ import (
 type GCPolicy struct {
 	RepositoryRx         regexpext.BoundedRegexp `json:"match_repository"`
 	NegativeRepositoryRx regexpext.BoundedRegexp `json:"except_repository,omitempty"`
+	OnlyArchived         bool                    `json:"only_archived,omitempty"`
+	ExceptArchived       bool                    `json:"except_archived,omitempty"`
 	TagRx                regexpext.BoundedRegexp `json:"match_tag,omitempty"`
 	NegativeTagRx        regexpext.BoundedRegexp `json:"except_tag,omitempty"`
 	OnlyUntagged         bool                    `json:"only_untagged,omitempty"`
+	ExceptWithReferrers  bool                    `json:"except_with_referrers,omitempty"`
+	KeppelLabels         map[string]string       `json:"match_keppel_labels,omitempty"`
+	NegativeKeppelLabels map[string]string       `json:"except_keppel_labels,omitempty"`
 	TimeConstraint       *GCTimeConstraint       `json:"time_constraint,omitempty"`
 	Action               string                  `json:"action"`
 }
@@ -35,6 +42,74 @@ type GCTimeConstraint struct {
 	NewestCount uint64   `json:"newest,omitempty"`
 	MinAge      Duration `json:"older_than,omitempty"`
 	MaxAge      Duration `json:"newer_than,omitempty"`
+	// Business days are Monday through Friday in the configured time zone.
+	MinBusinessDays uint64 `json:"older_than_business_days,omitempty"`
+	MaxBusinessDays uint64 `json:"newer_than_business_days,omitempty"`
+	// IANA time zone name like "Europe/Berlin". If set, durations and business
+	// days are evaluated on the wall clock of this time zone instead of UTC.
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// Caches the results of time.LoadLocation(), which needs to parse the tzdata
+// on each call, for GCTimeConstraint.location().
+var gcTimeZoneCache sync.Map
+
+func (tc GCTimeConstraint) location() (*time.Location, error) {
+	if tc.TimeZone == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := gcTimeZoneCache.Load(tc.TimeZone); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(tc.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	gcTimeZoneCache.Store(tc.TimeZone, loc)
+	return loc, nil
+}
+
+// threshold returns the point in time that lies the given duration or amount
+// of business days before `now`, as measured on the wall clock of the
+// configured time zone. For example, "1 day before 10:00" is always 10:00 on
+// the previous day, even if a DST transition happened in between.
+func (tc GCTimeConstraint) threshold(now time.Time, d Duration, businessDays uint64) time.Time {
+	loc, err := tc.location()
+	if err != nil {
+		panic(fmt.Sprintf("unexpected GC policy time zone %q (why was this not caught by Validate!?): %s", tc.TimeZone, err.Error()))
+	}
+
+	// do all computations on a timeline where each day has exactly 24 hours
+	local := now.In(loc)
+	wallClock := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+
+	if businessDays == 0 {
+		wallClock = wallClock.Add(-time.Duration(d))
+	} else {
+		// time on weekends does not count, so when we are on a weekend, start
+		// counting at the end of the preceding Friday (i.e. on Saturday 00:00)
+		if isWeekend(wallClock) {
+			wallClock = wallClock.Truncate(24 * time.Hour)
+			if wallClock.Weekday() == time.Sunday {
+				wallClock = wallClock.AddDate(0, 0, -1)
+			}
+		}
+		for range businessDays {
+			wallClock = wallClock.AddDate(0, 0, -1)
+			for isWeekend(wallClock) {
+				wallClock = wallClock.AddDate(0, 0, -1)
+			}
+		}
+	}
+
+	// convert back into the actual time zone (if this wall clock time does not
+	// exist or is ambiguous because of DST, time.Date() picks one of the candidates)
+	return time.Date(wallClock.Year(), wallClock.Month(), wallClock.Day(), wallClock.Hour(), wallClock.Minute(), wallClock.Second(), wallClock.Nanosecond(), loc)
+}
+
+func isWeekend(t time.Time) bool {
+	wd := t.Weekday()
+	return wd == time.Saturday || wd == time.Sunday
 }
 
 // MatchesRepository evaluates the repository regexes in this policy.
@@ -46,6 +121,18 @@ func (g GCPolicy) MatchesRepository(repoName string) bool {
 	return g.RepositoryRx.MatchString(repoName)
 }
 
+// MatchesArchivalState evaluates the "only_archived" and "except_archived"
+// attributes in this policy for a repository.
+func (g GCPolicy) MatchesArchivalState(isArchived bool) bool {
+	if g.OnlyArchived && !isArchived {
+		return false
+	}
+	if g.ExceptArchived && isArchived {
+		return false
+	}
+	return true
+}
+
 // MatchesTags evaluates the tag regexes in this policy for a complete set of
 // tag names belonging to a single manifest.
 func (g GCPolicy) MatchesTags(tagNames []string) bool {
@@ -74,6 +161,25 @@ func (g GCPolicy) MatchesTags(tagNames []string) bool {
 	return g.TagRx == ""
 }
 
+// MatchesReferrers evaluates the "except_with_referrers" attribute in this
+// policy for a single manifest. The argument tells whether any other manifest
+// in the same repo refers to this manifest as its subject.
+func (g GCPolicy) MatchesReferrers(hasReferrers bool) bool {
+	return !g.ExceptWithReferrers || !hasReferrers
+}
+
+// MatchesKeppelLabels evaluates the "match_keppel_labels" and
+// "except_keppel_labels" attributes in this policy for the Keppel labels of a
+// single manifest. All labels in "match_keppel_labels" must be present with
+// the given values, and none of the labels in "except_keppel_labels" may be.
+func (g GCPolicy) MatchesKeppelLabels(labels map[string]string) bool {
+	//NOTE: NegativeKeppelLabels takes precedence and is thus evaluated first.
+	if KeppelLabelsOverlap(g.NegativeKeppelLabels, labels) {
+		return false
+	}
+	return KeppelLabelsMatch(g.KeppelLabels, labels)
+}
+
 // MatchesTimeConstraint evaluates the time constraint in this policy for the
 // given manifest. A full list of all manifests in this repo must be supplied in
 // order to evaluate "newest" and "oldest" time constraints. The final argument
@@ -109,11 +215,11 @@ func (g GCPolicy) MatchesTimeConstraint(manifest models.Manifest, allManifestsIn
 	}
 
 	// option 1: simple threshold-based time constraint
-	if tc.MinAge != 0 {
-		return getAge(manifest) >= tc.MinAge
+	if tc.MinAge != 0 || tc.MinBusinessDays != 0 {
+		return !getTime(manifest).After(tc.threshold(now, tc.MinAge, tc.MinBusinessDays))
 	}
-	if tc.MaxAge != 0 {
-		return getAge(manifest) <= tc.MaxAge
+	if tc.MaxAge != 0 || tc.MaxBusinessDays != 0 {
+		return !getTime(manifest).Before(tc.threshold(now, tc.MaxAge, tc.MaxBusinessDays))
 	}
 
 	// option 2: order-based time constraint (we can skip all the sorting logic if we have less manifests than we want to match)
@@ -156,6 +262,9 @@ func (g GCPolicy) Validate() error {
 	if g.RepositoryRx == "" {
 		return errors.New(`GC policy must have the "match_repository" attribute`)
 	}
+	if g.OnlyArchived && g.ExceptArchived {
+		return errors.New(`GC policy cannot have the "only_archived" and "except_archived" attributes at the same time`)
+	}
 
 	if g.OnlyUntagged {
 		if g.TagRx != "" {
@@ -166,6 +275,17 @@ func (g GCPolicy) Validate() error {
 		}
 	}
 
+	for name := range g.KeppelLabels {
+		if !KeppelLabelNameRx.MatchString(name) {
+			return fmt.Errorf(`GC policy has invalid Keppel label name %q in "match_keppel_labels"`, name)
+		}
+	}
+	for name := range g.NegativeKeppelLabels {
+		if !KeppelLabelNameRx.MatchString(name) {
+			return fmt.Errorf(`GC policy has invalid Keppel label name %q in "except_keppel_labels"`, name)
+		}
+	}
+
 	if g.TimeConstraint != nil {
 		tc := *g.TimeConstraint
 		var tcFilledFields []string
@@ -187,6 +307,21 @@ func (g GCPolicy) Validate() error {
 		if tc.MaxAge != 0 {
 			tcFilledFields = append(tcFilledFields, `"newer_than"`)
 		}
+		if tc.MinBusinessDays != 0 {
+			tcFilledFields = append(tcFilledFields, `"older_than_business_days"`)
+		}
+		if tc.MaxBusinessDays != 0 {
+			tcFilledFields = append(tcFilledFields, `"newer_than_business_days"`)
+		}
+		if tc.TimeZone != "" {
+			if tc.OldestCount != 0 || tc.NewestCount != 0 {
+				return errors.New(`GC policy time constraint cannot set "time_zone" together with "oldest" or "newest"`)
+			}
+			_, err := tc.location()
+			if err != nil || tc.TimeZone == "Local" {
+				return fmt.Errorf(`%q is not a valid time zone for a GC policy time constraint`, tc.TimeZone)
+			}
+		}
 
 		switch tc.FieldName {
 		case "":
@@ -216,11 +351,22 @@ func (g GCPolicy) Validate() error {
 
 // ParseGCPolicies parses the GC policies for the given account.
 func ParseGCPolicies(account models.Account) ([]GCPolicy, error) {
-	if account.GCPoliciesJSON == "" || account.GCPoliciesJSON == "[]" {
+	return parseGCPoliciesField(account.GCPoliciesJSON)
+}
+
+// ParseRepositoryGCPolicies parses the GC policies that are set on the given
+// repository itself (see SelectGCPoliciesForRepo in package processor for how
+// they are combined with inherited policies).
+func ParseRepositoryGCPolicies(repo models.Repository) ([]GCPolicy, error) {
+	return parseGCPoliciesField(repo.GCPoliciesJSON)
+}
+
+func parseGCPoliciesField(buf string) ([]GCPolicy, error) {
+	if buf == "" || buf == "[]" {
 		return nil, nil
 	}
 	var policies []GCPolicy
-	err := json.Unmarshal([]byte(account.GCPoliciesJSON), &policies)
+	err := json.Unmarshal([]byte(buf), &policies)
 	return policies, err
 }
 
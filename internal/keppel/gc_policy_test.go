@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestGCTimeConstraintThresholds(t *testing.T) {
+	mustParse := func(value string) time.Time {
+		t.Helper()
+		result, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return result
+	}
+	day := Duration(24 * time.Hour)
+
+	testCases := []struct {
+		Description string
+		Constraint  GCTimeConstraint
+		Now         string
+		PushedAt    string
+		Expected    bool
+	}{
+		// in UTC, a day is always 24 hours
+		{"UTC across DST start", GCTimeConstraint{MinAge: day}, "2026-03-29T08:00:00Z", "2026-03-28T09:00:00Z", false},
+		{"UTC across DST end", GCTimeConstraint{MinAge: day}, "2026-10-25T09:00:00Z", "2026-10-24T08:30:00Z", true},
+		// in a time zone with DST, a day may have 23 or 25 hours (here: 10:00 local time on both days)
+		{"Berlin across DST start", GCTimeConstraint{MinAge: day, TimeZone: "Europe/Berlin"}, "2026-03-29T08:00:00Z", "2026-03-28T09:00:00Z", true},
+		{"Berlin across DST start, 1s too late", GCTimeConstraint{MinAge: day, TimeZone: "Europe/Berlin"}, "2026-03-29T08:00:00Z", "2026-03-28T09:00:01Z", false},
+		{"Berlin across DST end", GCTimeConstraint{MinAge: day, TimeZone: "Europe/Berlin"}, "2026-10-25T09:00:00Z", "2026-10-24T08:00:00Z", true},
+		{"Berlin across DST end, 30m too late", GCTimeConstraint{MinAge: day, TimeZone: "Europe/Berlin"}, "2026-10-25T09:00:00Z", "2026-10-24T08:30:00Z", false},
+		{"Berlin newer_than across DST end", GCTimeConstraint{MaxAge: day, TimeZone: "Europe/Berlin"}, "2026-10-25T09:00:00Z", "2026-10-24T08:30:00Z", true},
+		// business days (2026-10-16 is a Friday)
+		{"Monday, 1 business day, pushed on Friday", GCTimeConstraint{MinBusinessDays: 1}, "2026-10-19T10:00:00Z", "2026-10-16T10:00:00Z", true},
+		{"Monday, 1 business day, pushed on Saturday", GCTimeConstraint{MinBusinessDays: 1}, "2026-10-19T10:00:00Z", "2026-10-17T10:00:00Z", false},
+		{"Tuesday midnight, 1 business day, pushed on Saturday", GCTimeConstraint{MinBusinessDays: 1}, "2026-10-20T00:00:00Z", "2026-10-17T10:00:00Z", true},
+		{"Saturday, 1 business day, pushed on Thursday", GCTimeConstraint{MinBusinessDays: 1}, "2026-10-17T10:00:00Z", "2026-10-15T23:00:00Z", true},
+		{"Saturday, 1 business day, pushed on Friday", GCTimeConstraint{MinBusinessDays: 1}, "2026-10-17T10:00:00Z", "2026-10-16T01:00:00Z", false},
+		{"Sunday, 1 business day, pushed on Thursday", GCTimeConstraint{MinBusinessDays: 1}, "2026-10-18T15:00:00Z", "2026-10-15T23:59:59Z", true},
+		{"10 business days", GCTimeConstraint{MinBusinessDays: 10}, "2026-10-14T12:00:00Z", "2026-09-30T12:00:00Z", true},
+		{"10 business days, 1s too late", GCTimeConstraint{MinBusinessDays: 10}, "2026-10-14T12:00:00Z", "2026-09-30T12:00:01Z", false},
+		{"newer than 5 business days", GCTimeConstraint{MaxBusinessDays: 5}, "2026-10-19T10:00:00Z", "2026-10-12T11:00:00Z", true},
+		{"newer than 5 business days, too old", GCTimeConstraint{MaxBusinessDays: 5}, "2026-10-19T10:00:00Z", "2026-10-12T09:00:00Z", false},
+		// business days are determined in the configured time zone (Monday 01:00 in Tokyo is still Sunday in UTC)
+		{"Tokyo, 1 business day", GCTimeConstraint{MinBusinessDays: 1, TimeZone: "Asia/Tokyo"}, "2026-10-18T16:00:00Z", "2026-10-15T15:00:00Z", true},
+		{"Tokyo, 1 business day, 1s too late", GCTimeConstraint{MinBusinessDays: 1, TimeZone: "Asia/Tokyo"}, "2026-10-18T16:00:00Z", "2026-10-15T16:00:01Z", false},
+	}
+
+	for _, tc := range testCases {
+		tc.Constraint.FieldName = "pushed_at"
+		policy := GCPolicy{
+			RepositoryRx:   ".*",
+			TimeConstraint: &tc.Constraint,
+			Action:         "protect",
+		}
+		err := policy.Validate()
+		if err != nil {
+			t.Errorf("%s: unexpected validation error: %s", tc.Description, err.Error())
+			continue
+		}
+
+		manifest := models.Manifest{Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000", PushedAt: mustParse(tc.PushedAt)}
+		actual := policy.MatchesTimeConstraint(manifest, []models.Manifest{manifest}, mustParse(tc.Now))
+		if actual != tc.Expected {
+			t.Errorf("%s: expected match = %t, but got %t", tc.Description, tc.Expected, actual)
+		}
+	}
+}
+
+func TestGCTimeConstraintValidation(t *testing.T) {
+	testCases := []struct {
+		Constraint   GCTimeConstraint
+		ErrorMessage string
+	}{
+		{
+			GCTimeConstraint{FieldName: "pushed_at", MinBusinessDays: 5, TimeZone: "Mars/Olympus_Mons"},
+			`"Mars/Olympus_Mons" is not a valid time zone for a GC policy time constraint`,
+		},
+		{
+			GCTimeConstraint{FieldName: "pushed_at", MinBusinessDays: 5, TimeZone: "Local"},
+			`"Local" is not a valid time zone for a GC policy time constraint`,
+		},
+		{
+			GCTimeConstraint{FieldName: "pushed_at", OldestCount: 5, TimeZone: "Europe/Berlin"},
+			`GC policy time constraint cannot set "time_zone" together with "oldest" or "newest"`,
+		},
+		{
+			GCTimeConstraint{FieldName: "pushed_at", MinAge: Duration(time.Hour), MinBusinessDays: 5},
+			`GC policy time constraint cannot set all these attributes at once: "older_than", "older_than_business_days"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		policy := GCPolicy{
+			RepositoryRx:   ".*",
+			TimeConstraint: &tc.Constraint,
+			Action:         "protect",
+		}
+		err := policy.Validate()
+		if err == nil {
+			t.Errorf("expected validation of %#v to fail, but it succeeded", tc.Constraint)
+		} else if err.Error() != tc.ErrorMessage {
+			t.Errorf("expected validation error %q, but got %q", tc.ErrorMessage, err.Error())
+		}
+	}
+}
@@ -4,7 +4,12 @@
 package keppel
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/sapcc/go-api-declarations/bininfo"
 	"github.com/sapcc/go-bits/httpext"
@@ -25,3 +30,60 @@ func SetTaskName(taskName string) {
 	wrap.SetOverrideUserAgent(bininfo.Component(), bininfo.VersionOr("rolling"))
 	logg.Info("starting %s %s", bininfo.Component(), bininfo.VersionOr("rolling"))
 }
+
+// LimitRequestBody enforces a maximum size and a read deadline on the body of
+// an incoming request. Zero values mean that the respective limit is not
+// enforced. When the limits are exceeded, reading from the request body will
+// fail with errors that can be turned into API errors by AsRequestBodyError.
+func LimitRequestBody(w http.ResponseWriter, r *http.Request, maxSizeBytes int64, readTimeout time.Duration) {
+	if maxSizeBytes > 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSizeBytes)
+	}
+	if readTimeout > 0 && r.Body != nil && r.Body != http.NoBody {
+		// this can only fail if `w` does not support deadlines (e.g. in unit tests),
+		// in which case there is nothing we can do anyway
+		rc := http.NewResponseController(w)
+		if rc.SetReadDeadline(time.Now().Add(readTimeout)) == nil {
+			r.Body = &deadlineClearingBody{r.Body, rc}
+		}
+	}
+}
+
+// Once the request body has been read, net/http keeps reading from the
+// connection in the background to detect when the client goes away. The read
+// deadline from LimitRequestBody must not apply to that background read:
+// otherwise the request context would get cancelled when the deadline expires,
+// even if the request body was received in time.
+type deadlineClearingBody struct {
+	io.ReadCloser
+	rc *http.ResponseController
+}
+
+func (b *deadlineClearingBody) Read(buf []byte) (int, error) {
+	n, err := b.ReadCloser.Read(buf)
+	if errors.Is(err, io.EOF) {
+		_ = b.rc.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+func (b *deadlineClearingBody) Close() error {
+	_ = b.rc.SetReadDeadline(time.Time{})
+	return b.ReadCloser.Close()
+}
+
+// AsRequestBodyError checks if `err` was caused by a request body exceeding
+// the limits imposed by LimitRequestBody. If so, an appropriate API error is
+// returned. Otherwise, nil is returned.
+func AsRequestBodyError(err error) *RegistryV2Error {
+	var mbe *http.MaxBytesError
+	switch {
+	case errors.As(err, &mbe):
+		msg := fmt.Sprintf("request body exceeds the maximum size of %d bytes", mbe.Limit)
+		return ErrSizeInvalid.With(msg).WithStatus(http.StatusRequestEntityTooLarge)
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrUnknown.With("request body was not received in time").WithStatus(http.StatusRequestTimeout)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+const (
+	// MaxKeppelLabelsPerManifest is how many Keppel labels can be attached to a single manifest.
+	MaxKeppelLabelsPerManifest = 64
+	// MaxKeppelLabelValueLength is the maximum length of the value of a Keppel label.
+	MaxKeppelLabelValueLength = 256
+)
+
+// KeppelLabelNameRx is the format of Keppel label names (see type models.ManifestKeppelLabel).
+var KeppelLabelNameRx = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._/-]{0,127}$`)
+
+// ValidateKeppelLabels returns an error if the given set of Keppel labels
+// cannot be attached to a manifest.
+func ValidateKeppelLabels(labels map[string]string) error {
+	if len(labels) > MaxKeppelLabelsPerManifest {
+		return fmt.Errorf("too many Keppel labels (got %d, but the limit is %d)", len(labels), MaxKeppelLabelsPerManifest)
+	}
+	for name, value := range labels {
+		if !KeppelLabelNameRx.MatchString(name) {
+			return fmt.Errorf("%q is not a valid Keppel label name", name)
+		}
+		if len(value) > MaxKeppelLabelValueLength {
+			return fmt.Errorf("value of Keppel label %q is too long (got %d bytes, but the limit is %d)", name, len(value), MaxKeppelLabelValueLength)
+		}
+	}
+	return nil
+}
+
+// KeppelLabelsMatch returns whether all labels in `required` are present with
+// the same value in `actual`. An empty `required` set matches everything.
+func KeppelLabelsMatch(required, actual map[string]string) bool {
+	for name, value := range required {
+		actualValue, exists := actual[name]
+		if !exists || actualValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// KeppelLabelsOverlap returns whether any label in `candidates` is present
+// with the same value in `actual`.
+func KeppelLabelsOverlap(candidates, actual map[string]string) bool {
+	for name, value := range candidates {
+		actualValue, exists := actual[name]
+		if exists && actualValue == value {
+			return true
+		}
+	}
+	return false
+}
+
+// FindManifestKeppelLabels returns the Keppel labels of the given manifest.
+// The result is never nil.
+func FindManifestKeppelLabels(db sqlext.Executor, repo models.Repository, manifestDigest digest.Digest) (map[string]string, error) {
+	labels := make(map[string]string)
+	query := `SELECT name, value FROM manifest_keppel_labels WHERE repo_id = $1 AND digest = $2`
+	err := sqlext.ForeachRow(db, query, []any{repo.ID, manifestDigest}, func(rows *sql.Rows) error {
+		var name, value string
+		err := rows.Scan(&name, &value)
+		labels[name] = value
+		return err
+	})
+	return labels, err
+}
+
+// FindKeppelLabelsInRepo returns the Keppel labels of all manifests in the
+// given repo that have any.
+func FindKeppelLabelsInRepo(db sqlext.Executor, repo models.Repository) (map[digest.Digest]map[string]string, error) {
+	result := make(map[digest.Digest]map[string]string)
+	query := `SELECT digest, name, value FROM manifest_keppel_labels WHERE repo_id = $1`
+	err := sqlext.ForeachRow(db, query, []any{repo.ID}, func(rows *sql.Rows) error {
+		var (
+			manifestDigest digest.Digest
+			name, value    string
+		)
+		err := rows.Scan(&manifestDigest, &name, &value)
+		if err != nil {
+			return err
+		}
+		if result[manifestDigest] == nil {
+			result[manifestDigest] = make(map[string]string)
+		}
+		result[manifestDigest][name] = value
+		return nil
+	})
+	return result, err
+}
+
+var manifestKeppelLabelRestrictionQuery = sqlext.SimplifyWhitespace(`
+	SELECT digest, name, value FROM manifest_keppel_labels
+	 WHERE repo_id = $1 AND (
+	   digest = $2 OR digest IN (
+	     SELECT parent_digest FROM manifest_manifest_refs WHERE repo_id = $1 AND child_digest = $2
+	   )
+	 )
+`)
+
+var blobKeppelLabelRestrictionQuery = sqlext.SimplifyWhitespace(`
+	SELECT digest, name, value FROM manifest_keppel_labels
+	 WHERE repo_id = $1 AND (
+	   digest IN (
+	     SELECT digest FROM manifest_blob_refs WHERE repo_id = $1 AND blob_id = $2
+	   ) OR digest IN (
+	     SELECT mmr.parent_digest FROM manifest_manifest_refs mmr
+	       JOIN manifest_blob_refs mbr ON mbr.repo_id = mmr.repo_id AND mbr.digest = mmr.child_digest
+	      WHERE mbr.repo_id = $1 AND mbr.blob_id = $2
+	   )
+	 )
+`)
+
+// IsManifestAllowedByKeppelLabels checks whether the given manifest may be
+// pulled by a user whose pull access to the repo is restricted to manifests
+// with certain Keppel labels (see Authorization.KeppelLabelRestrictions in
+// package auth). Access is allowed if the manifest itself, or one of the image
+// lists containing it, has all the labels of at least one of the restrictions.
+func IsManifestAllowedByKeppelLabels(db sqlext.Executor, repo models.Repository, manifestDigest digest.Digest, restrictions []map[string]string) (bool, error) {
+	return isAllowedByKeppelLabels(db, manifestKeppelLabelRestrictionQuery, []any{repo.ID, manifestDigest}, restrictions)
+}
+
+// IsBlobAllowedByKeppelLabels is like IsManifestAllowedByKeppelLabels, but
+// for blobs. Access is allowed if the blob belongs to a manifest that could
+// be pulled.
+func IsBlobAllowedByKeppelLabels(db sqlext.Executor, repo models.Repository, blob models.Blob, restrictions []map[string]string) (bool, error) {
+	return isAllowedByKeppelLabels(db, blobKeppelLabelRestrictionQuery, []any{repo.ID, blob.ID}, restrictions)
+}
+
+func isAllowedByKeppelLabels(db sqlext.Executor, query string, args []any, restrictions []map[string]string) (bool, error) {
+	labelsByDigest := make(map[digest.Digest]map[string]string)
+	err := sqlext.ForeachRow(db, query, args, func(rows *sql.Rows) error {
+		var (
+			manifestDigest digest.Digest
+			name, value    string
+		)
+		err := rows.Scan(&manifestDigest, &name, &value)
+		if err != nil {
+			return err
+		}
+		if labelsByDigest[manifestDigest] == nil {
+			labelsByDigest[manifestDigest] = make(map[string]string)
+		}
+		labelsByDigest[manifestDigest][name] = value
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, labels := range labelsByDigest {
+		for _, required := range restrictions {
+			if KeppelLabelsMatch(required, labels) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateKeppelLabels(t *testing.T) {
+	tooManyLabels := make(map[string]string)
+	for idx := range MaxKeppelLabelsPerManifest + 1 {
+		tooManyLabels[strings.Repeat("a", idx+1)] = "true"
+	}
+
+	testCases := []struct {
+		Labels       map[string]string
+		ErrorMessage string
+	}{
+		{map[string]string{}, ""},
+		{map[string]string{"approved-for-prod": "true", "example.com/team": "alpha", "A_B": ""}, ""},
+		{map[string]string{"": "true"}, `"" is not a valid Keppel label name`},
+		{map[string]string{".hidden": "true"}, `".hidden" is not a valid Keppel label name`},
+		{map[string]string{"with space": "true"}, `"with space" is not a valid Keppel label name`},
+		{map[string]string{strings.Repeat("a", 129): "true"}, `"` + strings.Repeat("a", 129) + `" is not a valid Keppel label name`},
+		{map[string]string{"foo": strings.Repeat("x", 257)}, `value of Keppel label "foo" is too long (got 257 bytes, but the limit is 256)`},
+		{tooManyLabels, `too many Keppel labels (got 65, but the limit is 64)`},
+	}
+
+	for _, tc := range testCases {
+		err := ValidateKeppelLabels(tc.Labels)
+		switch {
+		case tc.ErrorMessage == "" && err != nil:
+			t.Errorf("expected %#v to be valid, but got error: %s", tc.Labels, err.Error())
+		case tc.ErrorMessage != "" && err == nil:
+			t.Errorf("expected %#v to be invalid, but it was accepted", tc.Labels)
+		case tc.ErrorMessage != "" && err.Error() != tc.ErrorMessage:
+			t.Errorf("expected validation error %q, but got %q", tc.ErrorMessage, err.Error())
+		}
+	}
+}
+
+func TestGCPolicyMatchesKeppelLabels(t *testing.T) {
+	policy := GCPolicy{
+		RepositoryRx:         ".*",
+		KeppelLabels:         map[string]string{"approved-for-prod": "false"},
+		NegativeKeppelLabels: map[string]string{"keep": "true"},
+		Action:               "delete",
+	}
+	err := policy.Validate()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	testCases := []struct {
+		Labels   map[string]string
+		Expected bool
+	}{
+		{nil, false},
+		{map[string]string{"approved-for-prod": "true"}, false},
+		{map[string]string{"approved-for-prod": "false"}, true},
+		{map[string]string{"approved-for-prod": "false", "keep": "false"}, true},
+		{map[string]string{"approved-for-prod": "false", "keep": "true"}, false},
+	}
+	for _, tc := range testCases {
+		actual := policy.MatchesKeppelLabels(tc.Labels)
+		if actual != tc.Expected {
+			t.Errorf("expected match = %t for %#v, but got %t", tc.Expected, tc.Labels, actual)
+		}
+	}
+
+	// policies without Keppel label constraints match everything
+	policy = GCPolicy{RepositoryRx: ".*", Action: "delete"}
+	if !policy.MatchesKeppelLabels(nil) {
+		t.Error("expected policy without Keppel label constraints to match unlabeled manifest")
+	}
+
+	// invalid label names are rejected
+	policy = GCPolicy{RepositoryRx: ".*", NegativeKeppelLabels: map[string]string{"with space": ""}, Action: "delete"}
+	err = policy.Validate()
+	expectedMessage := `GC policy has invalid Keppel label name "with space" in "except_keppel_labels"`
+	if err == nil || err.Error() != expectedMessage {
+		t.Errorf("expected validation error %q, but got %v", expectedMessage, err)
+	}
+}
+
+func TestRBACPolicyWithKeppelLabels(t *testing.T) {
+	testCases := []struct {
+		Policy       RBACPolicy
+		ErrorMessage string
+	}{
+		{
+			RBACPolicy{KeppelLabels: map[string]string{"approved-for-prod": "true"}, Permissions: []RBACPermission{RBACAnonymousPullPermission}},
+			"",
+		},
+		{
+			RBACPolicy{UserNamePattern: "foo", KeppelLabels: map[string]string{"approved-for-prod": "true"}, Permissions: []RBACPermission{RBACPullPermission}},
+			"",
+		},
+		{
+			RBACPolicy{KeppelLabels: map[string]string{"with space": "true"}, Permissions: []RBACPermission{RBACAnonymousPullPermission}},
+			`"with space" is not a valid Keppel label name`,
+		},
+		{
+			RBACPolicy{UserNamePattern: "foo", KeppelLabels: map[string]string{"approved-for-prod": "true"}, Permissions: []RBACPermission{RBACPullPermission, RBACPushPermission}},
+			`RBAC policy with "match_keppel_labels" may only grant "pull" or "anonymous_pull"`,
+		},
+		{
+			RBACPolicy{UserNamePattern: "foo", KeppelLabels: map[string]string{"approved-for-prod": "false"}, ForbiddenPermissions: []RBACPermission{RBACPullPermission}},
+			`RBAC policy with "match_keppel_labels" may not have the "forbidden_permissions" attribute`,
+		},
+	}
+
+	for _, tc := range testCases {
+		err := tc.Policy.ValidateAndNormalize(NoReplicationStrategy)
+		switch {
+		case tc.ErrorMessage == "" && err != nil:
+			t.Errorf("expected %#v to be valid, but got error: %s", tc.Policy, err.Error())
+		case tc.ErrorMessage != "" && err == nil:
+			t.Errorf("expected %#v to be invalid, but it was accepted", tc.Policy)
+		case tc.ErrorMessage != "" && err.Error() != tc.ErrorMessage:
+			t.Errorf("expected validation error %q, but got %q", tc.ErrorMessage, err.Error())
+		}
+	}
+}
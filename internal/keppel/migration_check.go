@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MigrationLockImpact describes how much a single statement in a schema
+// migration interferes with concurrent usage of the affected table.
+type MigrationLockImpact string
+
+const (
+	// LockImpactNone means that no existing table is locked (e.g. CREATE TABLE).
+	LockImpactNone MigrationLockImpact = "none"
+	// LockImpactBrief means that the table is locked exclusively, but only for
+	// a short metadata change that does not depend on the table size.
+	LockImpactBrief MigrationLockImpact = "brief"
+	// LockImpactLocksRows means that the affected rows are locked while they
+	// are updated or deleted, which takes time proportional to the table size.
+	LockImpactLocksRows MigrationLockImpact = "locks_rows"
+	// LockImpactBlocksWrites means that writes to the table are blocked while
+	// the table is scanned (e.g. CREATE INDEX, or validating a new constraint).
+	LockImpactBlocksWrites MigrationLockImpact = "blocks_writes"
+	// LockImpactRewritesTable means that the entire table is rewritten while
+	// all access to it is blocked (e.g. ALTER COLUMN ... TYPE).
+	LockImpactRewritesTable MigrationLockImpact = "rewrites_table"
+	// LockImpactUnknown is reported for statements that are not recognized.
+	LockImpactUnknown MigrationLockImpact = "unknown"
+)
+
+// PendingMigration appears in the result of CheckPendingMigrations.
+type PendingMigration struct {
+	Version    uint                 `json:"version"`
+	Name       string               `json:"name"`
+	Statements []MigrationStatement `json:"statements"`
+}
+
+// MigrationStatement appears in type PendingMigration.
+type MigrationStatement struct {
+	SQL        string              `json:"sql"`
+	Table      string              `json:"table,omitempty"`
+	LockImpact MigrationLockImpact `json:"lock_impact"`
+	// estimated from the Postgres statistics, so this may be somewhat off
+	EstimatedRows int64 `json:"estimated_rows"`
+}
+
+var (
+	migrationFileNameRx = regexp.MustCompile(`^(\d+)_(\w+)\.up\.sql$`)
+	sqlCommentRx        = regexp.MustCompile(`--[^\n]*`)
+
+	createTableRx         = regexp.MustCompile(`^CREATE TABLE (?:IF NOT EXISTS )?(\w+)`)
+	createIndexConcRx     = regexp.MustCompile(`^CREATE (?:UNIQUE )?INDEX CONCURRENTLY .*?\bON (\w+)`)
+	createIndexRx         = regexp.MustCompile(`^CREATE (?:UNIQUE )?INDEX .*?\bON (\w+)`)
+	dropRx                = regexp.MustCompile(`^DROP (?:TABLE|INDEX) (?:IF EXISTS )?(\w+)`)
+	alterTableRx          = regexp.MustCompile(`^ALTER TABLE (?:IF EXISTS )?(?:ONLY )?(\w+)`)
+	alterTypeRx           = regexp.MustCompile(`\bALTER COLUMN \w+ (?:SET DATA )?TYPE\b`)
+	volatileDefaultRx     = regexp.MustCompile(`\bDEFAULT (?:RANDOM|GEN_RANDOM_UUID|CLOCK_TIMESTAMP|NEXTVAL)\(|\bADD COLUMN \w+ (?:BIG|SMALL)?SERIAL\b`)
+	validatingClauseRx    = regexp.MustCompile(`\bSET NOT NULL\b|\bADD CONSTRAINT\b|\bADD (?:PRIMARY KEY|UNIQUE|FOREIGN KEY|CHECK)\b|\bREFERENCES\b`)
+	updateOrDeleteRx      = regexp.MustCompile(`^(?:UPDATE|DELETE FROM) (\w+)`)
+	insertRx              = regexp.MustCompile(`^INSERT INTO (\w+)`)
+	estimatedRowsQuery    = `SELECT GREATEST(reltuples, 0)::BIGINT FROM pg_class WHERE relname = $1 AND relkind = 'r'`
+	schemaVersionQuery    = `SELECT version, dirty FROM schema_migrations`
+	schemaTableThereQuery = `SELECT to_regclass('schema_migrations') IS NOT NULL`
+)
+
+// ClassifyMigrationStatement determines which table is affected by the given
+// SQL statement, and how much the statement will interfere with concurrent
+// usage of that table.
+func ClassifyMigrationStatement(stmt string) (table string, impact MigrationLockImpact) {
+	stmt = strings.ToUpper(strings.Join(strings.Fields(sqlCommentRx.ReplaceAllString(stmt, "")), " "))
+	if match := createTableRx.FindStringSubmatch(stmt); match != nil {
+		return strings.ToLower(match[1]), LockImpactNone
+	}
+	if match := createIndexConcRx.FindStringSubmatch(stmt); match != nil {
+		return strings.ToLower(match[1]), LockImpactNone
+	}
+	if match := createIndexRx.FindStringSubmatch(stmt); match != nil {
+		return strings.ToLower(match[1]), LockImpactBlocksWrites
+	}
+	if match := dropRx.FindStringSubmatch(stmt); match != nil {
+		return strings.ToLower(match[1]), LockImpactBrief
+	}
+	if match := alterTableRx.FindStringSubmatch(stmt); match != nil {
+		table := strings.ToLower(match[1])
+		switch {
+		case alterTypeRx.MatchString(stmt), volatileDefaultRx.MatchString(stmt):
+			return table, LockImpactRewritesTable
+		case validatingClauseRx.MatchString(stmt):
+			return table, LockImpactBlocksWrites
+		default:
+			return table, LockImpactBrief
+		}
+	}
+	if match := updateOrDeleteRx.FindStringSubmatch(stmt); match != nil {
+		return strings.ToLower(match[1]), LockImpactLocksRows
+	}
+	if match := insertRx.FindStringSubmatch(stmt); match != nil {
+		return strings.ToLower(match[1]), LockImpactNone
+	}
+	return "", LockImpactUnknown
+}
+
+// LatestSchemaVersion returns the version of the newest schema migration.
+func LatestSchemaVersion() uint {
+	var result uint
+	for fileName := range sqlMigrations {
+		match := migrationFileNameRx.FindStringSubmatch(fileName)
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err == nil && uint(version) > result {
+			result = uint(version)
+		}
+	}
+	return result
+}
+
+// GetSchemaVersion returns the version of the latest schema migration that
+// was applied to the given database, and whether that migration failed
+// halfway through. If no migrations were applied yet, 0 is returned.
+func GetSchemaVersion(db *sql.DB) (version uint, dirty bool, err error) {
+	var exists bool
+	err = db.QueryRow(schemaTableThereQuery).Scan(&exists)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+	err = db.QueryRow(schemaVersionQuery).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// CheckPendingMigrations returns all schema migrations that have not been
+// applied to the given database yet, along with an estimate of their impact.
+// The database is not modified.
+func CheckPendingMigrations(db *sql.DB) ([]PendingMigration, error) {
+	currentVersion, dirty, err := GetSchemaVersion(db)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read schema version: %w", err)
+	}
+	if dirty {
+		// the failed migration will be retried, so it counts as pending
+		currentVersion--
+	}
+
+	var result []PendingMigration
+	for fileName, contents := range sqlMigrations {
+		match := migrationFileNameRx.FindStringSubmatch(fileName)
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil || uint(version) <= currentVersion {
+			continue
+		}
+
+		pm := PendingMigration{Version: uint(version), Name: match[2]}
+		for _, stmt := range strings.Split(sqlCommentRx.ReplaceAllString(contents, ""), ";") {
+			stmt = strings.Join(strings.Fields(stmt), " ")
+			if stmt == "" {
+				continue
+			}
+			ms := MigrationStatement{SQL: stmt}
+			ms.Table, ms.LockImpact = ClassifyMigrationStatement(stmt)
+			if ms.Table != "" {
+				err := db.QueryRow(estimatedRowsQuery, ms.Table).Scan(&ms.EstimatedRows)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					return nil, fmt.Errorf("cannot estimate size of table %q: %w", ms.Table, err)
+				}
+			}
+			pm.Statements = append(pm.Statements, ms)
+		}
+		result = append(result, pm)
+	}
+
+	slices.SortFunc(result, func(lhs, rhs PendingMigration) int {
+		return cmp.Compare(lhs.Version, rhs.Version)
+	})
+	return result, nil
+}
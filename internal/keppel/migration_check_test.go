@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+)
+
+func TestClassifyMigrationStatement(t *testing.T) {
+	testCases := []struct {
+		Statement string
+		Table     string
+		Impact    MigrationLockImpact
+	}{
+		{"CREATE TABLE foo (id BIGSERIAL NOT NULL PRIMARY KEY)", "foo", LockImpactNone},
+		{"create index concurrently foo_bar_idx on foo (bar)", "foo", LockImpactNone},
+		{"CREATE UNIQUE INDEX foo_bar_idx ON foo (bar)", "foo", LockImpactBlocksWrites},
+		{"CREATE INDEX ON manifests (next_validation_at)", "manifests", LockImpactBlocksWrites},
+		{"DROP TABLE foo", "foo", LockImpactBrief},
+		{"ALTER TABLE accounts ADD COLUMN foo TEXT NOT NULL DEFAULT ''", "accounts", LockImpactBrief},
+		{"ALTER TABLE accounts DROP COLUMN foo, DROP COLUMN bar", "accounts", LockImpactBrief},
+		{"ALTER TABLE accounts ADD COLUMN foo TIMESTAMPTZ NOT NULL DEFAULT NOW()", "accounts", LockImpactBrief},
+		{"ALTER TABLE accounts ADD COLUMN foo UUID NOT NULL DEFAULT gen_random_uuid()", "accounts", LockImpactRewritesTable},
+		{"ALTER TABLE blobs ADD COLUMN seq BIGSERIAL", "blobs", LockImpactRewritesTable},
+		{"ALTER TABLE blobs ALTER COLUMN size_bytes TYPE NUMERIC", "blobs", LockImpactRewritesTable},
+		{"ALTER TABLE blobs ALTER COLUMN media_type SET NOT NULL", "blobs", LockImpactBlocksWrites},
+		{"ALTER TABLE tags ADD CONSTRAINT foo CHECK (name != '')", "tags", LockImpactBlocksWrites},
+		{"UPDATE accounts SET is_deleting = TRUE WHERE in_maintenance", "accounts", LockImpactLocksRows},
+		{"DELETE FROM tags WHERE name = ''", "tags", LockImpactLocksRows},
+		{"INSERT INTO quotas (auth_tenant_id) SELECT DISTINCT auth_tenant_id FROM accounts", "quotas", LockImpactNone},
+		{"VACUUM FULL", "", LockImpactUnknown},
+	}
+	for _, tc := range testCases {
+		table, impact := ClassifyMigrationStatement(tc.Statement)
+		assert.DeepEqual(t, "table for "+tc.Statement, table, tc.Table)
+		assert.DeepEqual(t, "lock impact for "+tc.Statement, impact, tc.Impact)
+	}
+}
+
+func TestAllMigrationStatementsAreRecognized(t *testing.T) {
+	for fileName, contents := range sqlMigrations {
+		if !strings.HasSuffix(fileName, ".up.sql") {
+			continue
+		}
+		for _, stmt := range strings.Split(contents, ";") {
+			if strings.TrimSpace(stmt) == "" {
+				continue
+			}
+			_, impact := ClassifyMigrationStatement(stmt)
+			if impact == LockImpactUnknown {
+				t.Errorf("statement in %s was not recognized: %s", fileName, stmt)
+			}
+		}
+	}
+
+	latestPrefix := fmt.Sprintf("%03d_", LatestSchemaVersion()+1)
+	for fileName := range sqlMigrations {
+		if strings.HasPrefix(fileName, latestPrefix) {
+			t.Errorf("LatestSchemaVersion() does not consider %s", fileName)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/pluggable"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// NameValidationDriver is a pluggable interface for enforcing organization-specific
+// naming conventions on new accounts and repositories. It is only consulted
+// when an account or repository is created, so existing accounts and
+// repositories are not affected by changes in the naming conventions.
+type NameValidationDriver interface {
+	pluggable.Plugin
+	// Init is called before any other interface methods, and allows the plugin to
+	// perform first-time initialization.
+	Init(context.Context, Configuration) error
+
+	// ValidateAccountName checks whether a new account with this name may be
+	// created in the given auth tenant. If not, a non-empty rejection message is
+	// returned that will be shown to the user verbatim, so it should explain the
+	// naming convention in question. A non-nil error is only returned if the
+	// name could not be checked at all.
+	ValidateAccountName(ctx context.Context, name models.AccountName, authTenantID string) (rejection string, err error)
+	// ValidateRepositoryName is like ValidateAccountName, but for a new
+	// repository within an existing account.
+	ValidateRepositoryName(ctx context.Context, account models.ReducedAccount, repoName string) (rejection string, err error)
+}
+
+// NameValidationDriverRegistry is a pluggable.Registry for NameValidationDriver implementations.
+var NameValidationDriverRegistry pluggable.Registry[NameValidationDriver]
+
+// NewNameValidationDriver creates a new NameValidationDriver using one of the
+// plugins registered with NameValidationDriverRegistry.
+func NewNameValidationDriver(ctx context.Context, pluginTypeID string, cfg Configuration) (NameValidationDriver, error) {
+	logg.Debug("initializing name validation driver %q...", pluginTypeID)
+
+	nvd := NameValidationDriverRegistry.Instantiate(pluginTypeID)
+	if nvd == nil {
+		return nil, errors.New("no such name validation driver: " + pluginTypeID)
+	}
+	return nvd, nvd.Init(ctx, cfg)
+}
+
+// ValidateNewAccountName checks the name of an account that is about to be
+// created against the reserved account names from the configuration and, if
+// given, against the NameValidationDriver.
+func ValidateNewAccountName(ctx context.Context, cfg Configuration, nvd NameValidationDriver, name models.AccountName, authTenantID string) *RegistryV2Error {
+	if rx := findReservedNamePattern(cfg.ReservedAccountNames, string(name)); rx != nil {
+		return ErrNameInvalid.With("account name %q is reserved by the operator of this registry (matches reserved pattern %q)", name, rx.String()).
+			WithStatus(http.StatusUnprocessableEntity)
+	}
+	if nvd == nil {
+		return nil
+	}
+	rejection, err := nvd.ValidateAccountName(ctx, name, authTenantID)
+	if err != nil {
+		return AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+	}
+	if rejection != "" {
+		return ErrNameInvalid.With("account name %q is not acceptable: %s", name, rejection).WithStatus(http.StatusUnprocessableEntity)
+	}
+	return nil
+}
+
+// ValidateNewRepositoryName checks the name of a repository that is about to
+// be created against the reserved repository names from the configuration
+// and, if given, against the NameValidationDriver.
+func ValidateNewRepositoryName(ctx context.Context, cfg Configuration, nvd NameValidationDriver, account models.ReducedAccount, repoName string) *RegistryV2Error {
+	if rx := findReservedNamePattern(cfg.ReservedRepositoryNames, repoName); rx != nil {
+		return ErrNameInvalid.With("repository name %q is reserved by the operator of this registry (matches reserved pattern %q)", repoName, rx.String()).
+			WithStatus(http.StatusUnprocessableEntity)
+	}
+	if nvd == nil {
+		return nil
+	}
+	rejection, err := nvd.ValidateRepositoryName(ctx, account, repoName)
+	if err != nil {
+		return AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+	}
+	if rejection != "" {
+		return ErrNameInvalid.With("repository name %q is not acceptable: %s", repoName, rejection).WithStatus(http.StatusUnprocessableEntity)
+	}
+	return nil
+}
+
+func findReservedNamePattern(patterns []*regexp.Regexp, name string) *regexp.Regexp {
+	for _, rx := range patterns {
+		if rx.MatchString(name) {
+			return rx
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// OutboundRequestPolicy restricts the destinations of HTTP requests to URLs
+// that were supplied by users (e.g. webhooks). Without this, users could make
+// Keppel send requests into networks that are only reachable from within the
+// deployment, e.g. to cloud metadata services or to internal APIs (SSRF).
+//
+// By default, only destinations with public IP addresses are allowed.
+type OutboundRequestPolicy struct {
+	// Destinations in these networks are allowed even if they are not public.
+	AllowedNetworks []netip.Prefix
+}
+
+// These networks are not covered by the netip.Addr.IsXXX() methods, but are
+// still not reachable on the public internet.
+var nonPublicNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+}
+
+// IsAllowedAddress checks whether requests to the given IP address are allowed.
+func (p OutboundRequestPolicy) IsAllowedAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if slices.ContainsFunc(p.AllowedNetworks, func(n netip.Prefix) bool { return n.Contains(addr) }) {
+		return true
+	}
+	// link-local covers the metadata services of most clouds (169.254.169.254),
+	// private covers the IPv6 variant of the AWS metadata service (fd00:ec2::254)
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return false
+	}
+	return !slices.ContainsFunc(nonPublicNetworks, func(n netip.Prefix) bool { return n.Contains(addr) })
+}
+
+// CheckURL performs a cheap validation of a user-supplied URL, to give early
+// feedback when the URL obviously points to a forbidden destination. Since
+// hostnames can resolve to different addresses later on, the actual
+// enforcement happens when connecting (see NewHTTPClient).
+func (p OutboundRequestPolicy) CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not a valid http(s) URL", rawURL)
+	}
+	hostname := strings.ToLower(u.Hostname())
+	if addr, err := netip.ParseAddr(hostname); err == nil {
+		if !p.IsAllowedAddress(addr) {
+			return fmt.Errorf("%q points to a non-public IP address", rawURL)
+		}
+	} else if hostname == "localhost" || strings.HasSuffix(hostname, ".localhost") {
+		if !p.IsAllowedAddress(netip.MustParseAddr("127.0.0.1")) && !p.IsAllowedAddress(netip.IPv6Loopback()) {
+			return fmt.Errorf("%q points to a non-public IP address", rawURL)
+		}
+	}
+	return nil
+}
+
+// ErrForbiddenDestination is returned by HTTP clients created by
+// OutboundRequestPolicy.NewHTTPClient when a connection to a forbidden
+// destination is attempted.
+var ErrForbiddenDestination = errors.New("destination address is not allowed")
+
+// NewHTTPClient returns an HTTP client that refuses to connect to forbidden
+// destinations. The check happens after DNS resolution, so it also covers
+// hostnames that resolve to forbidden addresses and redirects. Proxies from
+// the environment are not used, since the check would then only apply to the
+// proxy.
+func (p OutboundRequestPolicy) NewHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("cannot parse destination address %q: %w", address, err)
+			}
+			if !p.IsAllowedAddress(addrPort.Addr()) {
+				return fmt.Errorf("cannot connect to %s: %w", addrPort.Addr(), ErrForbiddenDestination)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
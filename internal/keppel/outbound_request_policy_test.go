@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestOutboundRequestPolicyCheckURL(t *testing.T) {
+	defaultPolicy := OutboundRequestPolicy{}
+	testCases := map[string]string{
+		"https://hooks.example.com/notify":      ``,
+		"http://203.0.113.10:8080/notify":       ``,
+		"https://[2001:db8::1]/notify":          ``,
+		"ftp://hooks.example.com/notify":        `"ftp://hooks.example.com/notify" is not a valid http(s) URL`,
+		"https:///notify":                       `"https:///notify" is not a valid http(s) URL`,
+		"http://169.254.169.254/latest":         `"http://169.254.169.254/latest" points to a non-public IP address`,
+		"http://10.0.0.1/":                      `"http://10.0.0.1/" points to a non-public IP address`,
+		"http://127.0.0.1:8080/":                `"http://127.0.0.1:8080/" points to a non-public IP address`,
+		"http://100.64.0.1/":                    `"http://100.64.0.1/" points to a non-public IP address`,
+		"http://0.0.0.0/":                       `"http://0.0.0.0/" points to a non-public IP address`,
+		"http://[::1]/":                         `"http://[::1]/" points to a non-public IP address`,
+		"http://[fd00:ec2::254]/":               `"http://[fd00:ec2::254]/" points to a non-public IP address`,
+		"http://[::ffff:192.168.0.1]/":          `"http://[::ffff:192.168.0.1]/" points to a non-public IP address`,
+		"http://LOCALHOST:8080/":                `"http://LOCALHOST:8080/" points to a non-public IP address`,
+		"http://metadata.localhost/computeMeta": `"http://metadata.localhost/computeMeta" points to a non-public IP address`,
+	}
+	for input, expectedError := range testCases {
+		err := defaultPolicy.CheckURL(input)
+		actualError := ""
+		if err != nil {
+			actualError = err.Error()
+		}
+		if actualError != expectedError {
+			t.Errorf("while checking %s: expected error %q, but got %q", input, expectedError, actualError)
+		}
+	}
+
+	// operators can allow specific internal networks
+	policy := OutboundRequestPolicy{AllowedNetworks: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("127.0.0.0/8"),
+	}}
+	for _, input := range []string{"http://10.0.0.1/", "http://[::ffff:10.0.0.1]/", "http://localhost:8080/"} {
+		err := policy.CheckURL(input)
+		if err != nil {
+			t.Errorf("expected %s to be allowed, but got: %s", input, err.Error())
+		}
+	}
+	err := policy.CheckURL("http://169.254.169.254/")
+	if err == nil {
+		t.Error("expected metadata service address to still be forbidden, but it was allowed")
+	}
+}
+
+func TestOutboundRequestPolicyHTTPClient(t *testing.T) {
+	// the check also applies to addresses that are only known after DNS
+	// resolution, so connection attempts to forbidden addresses fail before
+	// anything is sent
+	client := OutboundRequestPolicy{}.NewHTTPClient(5 * time.Second)
+	for _, target := range []string{"http://127.0.0.1:1/", "http://localhost:1/", "http://10.0.0.1/"} {
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, target, http.NoBody)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if !errors.Is(err, ErrForbiddenDestination) {
+			t.Errorf("expected request to %s to fail with %q, but got: %v", target, ErrForbiddenDestination.Error(), err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sapcc/keppel/internal/models"
+
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ParsePlatform parses a platform specification of the form "os/arch" or
+// "os/arch/variant" (e.g. "linux/amd64" or "linux/arm64/v8"). This format is
+// used for the default platform of accounts and in the X-Keppel-Platform header.
+func ParsePlatform(spec string) (imagespecs.Platform, error) {
+	fields := strings.Split(spec, "/")
+	if len(fields) < 2 || len(fields) > 3 {
+		return imagespecs.Platform{}, fmt.Errorf(`invalid platform %q: expected "os/arch" or "os/arch/variant"`, spec)
+	}
+	for _, field := range fields {
+		if field == "" {
+			return imagespecs.Platform{}, fmt.Errorf(`invalid platform %q: expected "os/arch" or "os/arch/variant"`, spec)
+		}
+	}
+
+	platform := imagespecs.Platform{
+		OS:           fields[0],
+		Architecture: fields[1],
+	}
+	if len(fields) == 3 {
+		platform.Variant = fields[2]
+	}
+	return platform, nil
+}
+
+// FindSubmanifestForPlatform looks through the submanifests of a list
+// manifest and returns the descriptor of the first one that matches the given
+// platform. The variant is only compared if the given platform specifies one.
+// If there is no match (or if the manifest is not a list manifest), nil is
+// returned.
+func FindSubmanifestForPlatform(m ParsedManifest, pf models.PlatformFilter, platform imagespecs.Platform) *imagespecs.Descriptor {
+	for _, desc := range m.ManifestReferences(pf) {
+		p := desc.Platform
+		if p == nil || p.OS != platform.OS || p.Architecture != platform.Architecture {
+			continue
+		}
+		if platform.Variant != "" && p.Variant != platform.Variant {
+			continue
+		}
+		return &desc
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"testing"
+
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestParsePlatform(t *testing.T) {
+	validCases := map[string]imagespecs.Platform{
+		"linux/amd64":    {OS: "linux", Architecture: "amd64"},
+		"linux/arm64/v8": {OS: "linux", Architecture: "arm64", Variant: "v8"},
+	}
+	for input, expected := range validCases {
+		actual, err := ParsePlatform(input)
+		if err != nil {
+			t.Errorf("unexpected error for ParsePlatform(%q): %s", input, err.Error())
+		} else if actual.OS != expected.OS || actual.Architecture != expected.Architecture || actual.Variant != expected.Variant {
+			t.Errorf("expected ParsePlatform(%q) = %#v, but got %#v", input, expected, actual)
+		}
+	}
+
+	for _, input := range []string{"", "linux", "linux/", "/amd64", "linux/arm64/v8/extra"} {
+		_, err := ParsePlatform(input)
+		if err == nil {
+			t.Errorf("expected error for ParsePlatform(%q), but got none", input)
+		}
+	}
+}
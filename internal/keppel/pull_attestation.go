@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// NormalizeClientID validates a client ID given by a client. Surrounding
+// whitespace is removed. If the client ID is too long or contains non-printable
+// characters, the empty string is returned, i.e. the client ID is ignored.
+func NormalizeClientID(clientID string) string {
+	clientID = strings.TrimSpace(clientID)
+	if len(clientID) > 255 {
+		return ""
+	}
+	for _, r := range clientID {
+		if !unicode.IsPrint(r) {
+			return ""
+		}
+	}
+	return clientID
+}
+
+const (
+	// Above this number of pending records, further pulls are not recorded
+	// until the next flush, to bound memory usage if the DB is unreachable.
+	pullAttestationMaxPending = 100000
+	// The maximum number of records written by a single statement.
+	pullAttestationFlushBatchSize = 1000
+)
+
+var flushPullAttestationsQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO pull_attestations (account_name, repo_name, digest, client_id, first_pulled_at, last_pulled_at, pull_count)
+	SELECT * FROM UNNEST($1::TEXT[], $2::TEXT[], $3::TEXT[], $4::TEXT[], $5::TIMESTAMPTZ[], $6::TIMESTAMPTZ[], $7::BIGINT[])
+	ON CONFLICT (account_name, repo_name, digest, client_id) DO UPDATE
+	SET first_pulled_at = LEAST(pull_attestations.first_pulled_at, EXCLUDED.first_pulled_at),
+	    last_pulled_at = GREATEST(pull_attestations.last_pulled_at, EXCLUDED.last_pulled_at),
+	    pull_count = pull_attestations.pull_count + EXCLUDED.pull_count
+`)
+
+type pullAttestationKey struct {
+	AccountName models.AccountName
+	RepoName    string
+	Digest      digest.Digest
+	ClientID    string
+}
+
+type pullAttestationUpdate struct {
+	FirstPulledAt time.Time
+	LastPulledAt  time.Time
+	PullCount     int64
+}
+
+// PullAttestationRecorder collects pull attestations (see
+// models.PullAttestation) in memory, and writes them into the DB in batches.
+// This way, manifest pulls do not cause additional DB writes, and frequent
+// pulls of the same manifest by the same client are aggregated into a single
+// write.
+//
+// All methods can be called on a nil PullAttestationRecorder and do nothing
+// in that case.
+type PullAttestationRecorder struct {
+	db      *DB
+	mutex   sync.Mutex
+	pending map[pullAttestationKey]pullAttestationUpdate
+}
+
+// NewPullAttestationRecorder builds a PullAttestationRecorder. Recorded pulls
+// are only written into the DB on Flush(), or periodically when Run() is
+// called.
+func NewPullAttestationRecorder(db *DB) *PullAttestationRecorder {
+	return &PullAttestationRecorder{
+		db:      db,
+		pending: make(map[pullAttestationKey]pullAttestationUpdate),
+	}
+}
+
+// Record remembers that the client with the given ID has pulled the manifests
+// with the given digests. The client ID must be taken from the token that
+// authorized the pull (see Authorization.ClientID), since client-supplied
+// identifications could be forged. If the token does not carry a client ID,
+// nothing is recorded.
+func (r *PullAttestationRecorder) Record(repo models.Repository, digests []digest.Digest, clientID string, now time.Time) {
+	if r == nil || clientID == "" {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, d := range digests {
+		key := pullAttestationKey{repo.AccountName, repo.Name, d, clientID}
+		update, exists := r.pending[key]
+		if !exists {
+			if len(r.pending) >= pullAttestationMaxPending {
+				logg.Error("dropping pull attestation for %s@%s by client %q: too many pending records", repo.FullName(), d, clientID)
+				continue
+			}
+			update.FirstPulledAt = now
+		}
+		update.LastPulledAt = now
+		update.PullCount++
+		r.pending[key] = update
+	}
+}
+
+// Flush writes all pending records into the DB.
+func (r *PullAttestationRecorder) Flush() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+	pending := r.pending
+	r.pending = make(map[pullAttestationKey]pullAttestationUpdate, len(pending))
+	r.mutex.Unlock()
+
+	keys := slices.SortedFunc(maps.Keys(pending), func(lhs, rhs pullAttestationKey) int {
+		return cmp.Or(
+			cmp.Compare(lhs.AccountName, rhs.AccountName),
+			cmp.Compare(lhs.RepoName, rhs.RepoName),
+			cmp.Compare(lhs.Digest, rhs.Digest),
+			cmp.Compare(lhs.ClientID, rhs.ClientID),
+		)
+	})
+	for offset := 0; offset < len(keys); offset += pullAttestationFlushBatchSize {
+		chunk := keys[offset:min(offset+pullAttestationFlushBatchSize, len(keys))]
+		var (
+			accountNames   = make([]string, len(chunk))
+			repoNames      = make([]string, len(chunk))
+			digests        = make([]string, len(chunk))
+			clientIDs      = make([]string, len(chunk))
+			firstPulledAts = make([]string, len(chunk))
+			lastPulledAts  = make([]string, len(chunk))
+			pullCounts     = make([]int64, len(chunk))
+		)
+		for idx, key := range chunk {
+			update := pending[key]
+			accountNames[idx] = string(key.AccountName)
+			repoNames[idx] = key.RepoName
+			digests[idx] = key.Digest.String()
+			clientIDs[idx] = key.ClientID
+			firstPulledAts[idx] = update.FirstPulledAt.Format(time.RFC3339Nano)
+			lastPulledAts[idx] = update.LastPulledAt.Format(time.RFC3339Nano)
+			pullCounts[idx] = update.PullCount
+		}
+		_, err := r.db.Exec(flushPullAttestationsQuery,
+			pq.Array(accountNames), pq.Array(repoNames), pq.Array(digests), pq.Array(clientIDs),
+			pq.Array(firstPulledAts), pq.Array(lastPulledAts), pq.Array(pullCounts),
+		)
+		if err != nil {
+			// keep the unwritten records around for the next attempt
+			r.restore(pending, keys[offset:])
+			return fmt.Errorf("could not write %d pull attestations: %w", len(keys)-offset, err)
+		}
+	}
+	return nil
+}
+
+// restore merges records that could not be written back into r.pending.
+func (r *PullAttestationRecorder) restore(updates map[pullAttestationKey]pullAttestationUpdate, keys []pullAttestationKey) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, key := range keys {
+		update := updates[key]
+		if newer, exists := r.pending[key]; exists {
+			update.LastPulledAt = newer.LastPulledAt
+			update.PullCount += newer.PullCount
+		} else if len(r.pending) >= pullAttestationMaxPending {
+			continue
+		}
+		r.pending[key] = update
+	}
+}
+
+// Run flushes pending records at the given interval until the given context
+// expires. Remaining records are flushed before returning.
+func (r *PullAttestationRecorder) Run(ctx context.Context, interval time.Duration) {
+	if r == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			err := r.Flush()
+			if err != nil {
+				logg.Error(err.Error())
+			}
+			return
+		case <-ticker.C:
+			err := r.Flush()
+			if err != nil {
+				logg.Error(err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// PullTerms represents the terms of use of an account in the API. If an
+// account has terms of use, users need to accept them before they can pull
+// from this account.
+type PullTerms struct {
+	// Identifies the current revision of the terms of use. When this changes,
+	// all users need to accept the terms of use again.
+	Version string `json:"version"`
+	// Where users can read the terms of use.
+	URL string `json:"url"`
+}
+
+// RenderPullTerms builds a PullTerms object out of the information in the
+// given account model.
+func RenderPullTerms(account models.ReducedAccount) *PullTerms {
+	if account.PullTermsVersion == "" {
+		return nil
+	}
+	return &PullTerms{
+		Version: account.PullTermsVersion,
+		URL:     account.PullTermsURL,
+	}
+}
+
+// ApplyToAccount validates these terms of use and stores them in the given
+// account model.
+func (t PullTerms) ApplyToAccount(account *models.Account) *RegistryV2Error {
+	if t.Version == "" || len(t.Version) > 64 || strings.ContainsAny(t.Version, " \t\r\n") {
+		err := fmt.Errorf("invalid version for pull terms: %q", t.Version)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		err := fmt.Errorf("invalid URL for pull terms: %q", t.URL)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+
+	account.PullTermsVersion = t.Version
+	account.PullTermsURL = t.URL
+	return nil
+}
+
+// PullTermsRequiredFor returns whether the given user needs to have accepted
+// the terms of use of an account before pulling from it. Technical users
+// (peers during replication, Trivy during security scanning) are exempt.
+// Anonymous users are also exempt since they cannot accept terms of use; they
+// are only pointed to the terms of use through a Link header instead.
+func PullTermsRequiredFor(uid UserIdentity) bool {
+	return uid.UserType() == RegularUser
+}
+
+// PullTermsLinkHeader returns the value of the Link header (RFC 8288) that
+// points to the terms of use of the given account, or "" if the account does
+// not have terms of use.
+func PullTermsLinkHeader(account models.ReducedAccount) string {
+	if account.PullTermsVersion == "" {
+		return ""
+	}
+	return fmt.Sprintf(`<%s>; rel="terms-of-service"`, account.PullTermsURL)
+}
+
+var pullTermsAcceptedQuery = `SELECT COUNT(*) > 0 FROM pull_terms_acceptances WHERE account_name = $1 AND user_name = $2 AND terms_version = $3`
+
+// Since HasAcceptedPullTerms() is called for every pull from an account with
+// terms of use, positive results are cached for a short time. Negative results
+// are not cached, so that pulls work immediately after the terms of use have
+// been accepted. Acceptances are never revoked (only the terms version can
+// change, which is part of the cache key), so the TTL only bounds how long
+// acceptances survive the deletion of their account.
+const (
+	pullTermsAcceptanceCacheTTL     = 5 * time.Minute
+	pullTermsAcceptanceCacheMaxSize = 10000
+)
+
+type pullTermsAcceptanceCacheKey struct {
+	DB           gorp.SqlExecutor
+	AccountName  models.AccountName
+	UserName     string
+	TermsVersion string
+}
+
+var (
+	pullTermsAcceptanceCacheMutex sync.Mutex
+	pullTermsAcceptanceCache      = make(map[pullTermsAcceptanceCacheKey]time.Time) // value = expiry time
+)
+
+// HasAcceptedPullTerms returns whether the given user has accepted the current
+// terms of use of the given account. If the account does not have terms of
+// use, true is returned.
+func HasAcceptedPullTerms(db gorp.SqlExecutor, account models.ReducedAccount, userName string) (bool, error) {
+	if account.PullTermsVersion == "" {
+		return true, nil
+	}
+	if userName == "" {
+		// anonymous users cannot accept terms of use
+		return false, nil
+	}
+
+	key := pullTermsAcceptanceCacheKey{db, account.Name, userName, account.PullTermsVersion}
+	now := time.Now()
+	pullTermsAcceptanceCacheMutex.Lock()
+	expiresAt, ok := pullTermsAcceptanceCache[key]
+	pullTermsAcceptanceCacheMutex.Unlock()
+	if ok && expiresAt.After(now) {
+		return true, nil
+	}
+
+	var accepted bool
+	err := db.QueryRow(pullTermsAcceptedQuery, account.Name, userName, account.PullTermsVersion).Scan(&accepted)
+	if err != nil || !accepted {
+		return false, err
+	}
+
+	pullTermsAcceptanceCacheMutex.Lock()
+	defer pullTermsAcceptanceCacheMutex.Unlock()
+	if len(pullTermsAcceptanceCache) >= pullTermsAcceptanceCacheMaxSize {
+		clear(pullTermsAcceptanceCache)
+	}
+	pullTermsAcceptanceCache[key] = now.Add(pullTermsAcceptanceCacheTTL)
+	return true, nil
+}
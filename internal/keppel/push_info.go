@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// PushMetadataHeaderPrefix is the prefix of request headers that clients can
+// use to attach additional metadata (e.g. the URL of the CI build that
+// produced the image) to a manifest push. For example, the header
+// "X-Keppel-Push-Metadata-Build-Url: https://ci.example.org/builds/42" is
+// recorded as metadata entry "build-url".
+const PushMetadataHeaderPrefix = "X-Keppel-Push-Metadata-"
+
+const (
+	// upper bounds for the size of ManifestPushInfo; excess metadata is ignored
+	maxPushMetadataEntries = 16
+	maxPushInfoValueLength = 1024
+)
+
+// ManifestPushInfo describes the client that originally pushed a manifest. It
+// is stored in serialized form in the PushInfoJSON field of type
+// models.Manifest.
+type ManifestPushInfo struct {
+	UserAgent string            `json:"user_agent,omitempty"`
+	ClientIP  string            `json:"client_ip,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// BuildManifestPushInfo collects the ManifestPushInfo for a manifest that is
+// being pushed with the given request.
+//
+// The identity of the pushing user is not included here since it is already
+// recorded in the audit event for the push.
+func BuildManifestPushInfo(r *http.Request) ManifestPushInfo {
+	info := ManifestPushInfo{
+		UserAgent: normalizePushInfoValue(r.Header.Get("User-Agent")),
+		ClientIP:  ClientIPFor(r),
+	}
+
+	// iterate in sorted order to decide deterministically which entries are
+	// kept if the client sends too many
+	for _, headerName := range slices.Sorted(maps.Keys(r.Header)) {
+		key, ok := strings.CutPrefix(headerName, PushMetadataHeaderPrefix)
+		if !ok || key == "" {
+			continue
+		}
+		value := normalizePushInfoValue(r.Header.Get(headerName))
+		if value == "" {
+			continue
+		}
+		if info.Metadata == nil {
+			info.Metadata = make(map[string]string)
+		}
+		if len(info.Metadata) >= maxPushMetadataEntries {
+			break
+		}
+		info.Metadata[strings.ToLower(key)] = value
+	}
+	return info
+}
+
+// Removes surrounding whitespace. Values that are overlong or contain
+// non-printable characters are ignored.
+func normalizePushInfoValue(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > maxPushInfoValueLength {
+		return ""
+	}
+	for _, r := range value {
+		if !unicode.IsPrint(r) {
+			return ""
+		}
+	}
+	return value
+}
+
+// RedactPushInfoClientIP removes the client IP from a serialized
+// ManifestPushInfo. The client IP is personal data, so it is only shown to
+// users who can change the account.
+func RedactPushInfoClientIP(pushInfoJSON string) string {
+	if pushInfoJSON == "" {
+		return ""
+	}
+	var info ManifestPushInfo
+	err := json.Unmarshal([]byte(pushInfoJSON), &info)
+	if err != nil {
+		// defense in depth: do not leak anything that we cannot parse
+		return ""
+	}
+	info.ClientIP = ""
+	if info.UserAgent == "" && len(info.Metadata) == 0 {
+		return ""
+	}
+	return info.Serialize()
+}
+
+// Serialize returns the JSON representation of this ManifestPushInfo.
+func (i ManifestPushInfo) Serialize() string {
+	buf, err := json.Marshal(i)
+	if err != nil {
+		// defense in depth: this cannot fail for a struct of strings
+		return ""
+	}
+	return string(buf)
+}
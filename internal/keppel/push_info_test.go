@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuildManifestPushInfo(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/v2/test1/foo/manifests/latest", http.NoBody)
+	r.RemoteAddr = "198.51.100.10:12345"
+	r.Header.Set("User-Agent", "docker/27.0.3 go/go1.22.5")
+	r.Header.Set("X-Keppel-Push-Metadata-Build-Url", " https://ci.example.org/builds/42 ")
+	r.Header.Set("X-Keppel-Push-Metadata-Commit", "0123456789abcdef")
+	r.Header.Set("X-Keppel-Push-Metadata-Overlong", strings.Repeat("x", maxPushInfoValueLength+1))
+	r.Header.Set("X-Keppel-Push-Metadata-Control", "foo\x7fbar")
+	r.Header.Set("X-Unrelated", "ignored")
+
+	expected := `{"user_agent":"docker/27.0.3 go/go1.22.5","client_ip":"198.51.100.10","metadata":{"build-url":"https://ci.example.org/builds/42","commit":"0123456789abcdef"}}`
+	actual := BuildManifestPushInfo(r).Serialize()
+	if actual != expected {
+		t.Errorf("expected %s, but got %s", expected, actual)
+	}
+
+	// X-Forwarded-For takes precedence over the remote address
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	info := BuildManifestPushInfo(r)
+	if info.ClientIP != "203.0.113.7" {
+		t.Errorf("expected client IP from X-Forwarded-For, but got %q", info.ClientIP)
+	}
+
+	// excess metadata entries are ignored
+	r = httptest.NewRequest("PUT", "/v2/test1/foo/manifests/latest", http.NoBody)
+	for idx := range 2 * maxPushMetadataEntries {
+		r.Header.Set(fmt.Sprintf("%sKey%02d", PushMetadataHeaderPrefix, idx), "value")
+	}
+	info = BuildManifestPushInfo(r)
+	if len(info.Metadata) != maxPushMetadataEntries {
+		t.Errorf("expected %d metadata entries, but got %d", maxPushMetadataEntries, len(info.Metadata))
+	}
+	if _, exists := info.Metadata["key00"]; !exists {
+		t.Errorf("expected metadata entries to be selected in sorted order, but got %v", info.Metadata)
+	}
+}
+
+func TestRedactPushInfoClientIP(t *testing.T) {
+	testCases := map[string]string{
+		``:                          ``,
+		`{"client_ip":"192.0.2.1"}`: ``,
+		`{"user_agent":"docker/27.0.3","client_ip":"192.0.2.1"}`: `{"user_agent":"docker/27.0.3"}`,
+		`{"client_ip":"192.0.2.1","metadata":{"commit":"abc"}}`:  `{"metadata":{"commit":"abc"}}`,
+		`not json`: ``,
+	}
+	for input, expected := range testCases {
+		actual := RedactPushInfoClientIP(input)
+		if actual != expected {
+			t.Errorf("while redacting %s: expected %q, but got %q", input, expected, actual)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"net/netip"
 	"time"
 
 	"github.com/go-redis/redis_rate/v10"
@@ -77,6 +78,24 @@ func NewRateLimitDriver(pluginTypeID string, ad AuthDriver, cfg Configuration) (
 type RateLimitEngine struct {
 	Driver RateLimitDriver
 	Client *redis.Client
+	// IPv6PrefixLength controls how IPv6 requesters are grouped together for
+	// the purpose of rate-limiting: All addresses within the same prefix of
+	// this length share one rate limit budget, since a single client usually
+	// has an entire /64 (or larger) at its disposal. If zero,
+	// DefaultIPv6PrefixLength is used.
+	IPv6PrefixLength int
+	// Exemptions contains the requesters that are exempt from rate limits.
+	// If nil, no exemptions apply.
+	Exemptions *RateLimitExemptionCache
+}
+
+// IsExempt checks whether a request with the given properties is exempt from
+// rate limits because of an operator-configured exemption.
+func (e RateLimitEngine) IsExempt(remoteAddr string, accountName models.AccountName, userName string) (bool, error) {
+	if e.Exemptions == nil {
+		return false, nil
+	}
+	return e.Exemptions.IsExempt(remoteAddr, accountName, userName)
 }
 
 // RateLimitAllows checks whether the given action on the given account is allowed by
@@ -108,10 +127,37 @@ func (e RateLimitEngine) RateLimitAllows(ctx context.Context, remoteAddr string,
 	}
 
 	limiter := redis_rate.NewLimiter(e.Client)
-	key := fmt.Sprintf("keppel-ratelimit-%s-%s-%s", remoteAddr, account.Name, string(action))
+	requester := RateLimitRequesterKey(remoteAddr, e.IPv6PrefixLength)
+	key := fmt.Sprintf("keppel-ratelimit-%s-%s-%s", requester, account.Name, string(action))
 	result, err := limiter.AllowN(ctx, key, *rateQuota, int(amount))
 	if err != nil {
 		return false, &redis_rate.Result{}, err
 	}
 	return result.Allowed > 0, result, err
 }
+
+// DefaultIPv6PrefixLength is the default value for RateLimitEngine.IPv6PrefixLength.
+const DefaultIPv6PrefixLength = 64
+
+// RateLimitRequesterKey returns the part of a rate limit key that identifies
+// the requester with the given IP address. IPv4 addresses are used as-is,
+// whereas IPv6 addresses are reduced to the network prefix of the given length
+// (or DefaultIPv6PrefixLength if zero is given). Values that are not valid IP
+// addresses are returned unchanged.
+func RateLimitRequesterKey(remoteAddr string, ipv6PrefixLength int) string {
+	addr, err := netip.ParseAddr(NormalizeIP(remoteAddr))
+	if err != nil {
+		return remoteAddr
+	}
+	if addr.Is4() {
+		return addr.String()
+	}
+	if ipv6PrefixLength <= 0 || ipv6PrefixLength > 128 {
+		ipv6PrefixLength = DefaultIPv6PrefixLength
+	}
+	prefix, err := addr.WithZone("").Prefix(ipv6PrefixLength)
+	if err != nil {
+		return addr.String()
+	}
+	return prefix.String()
+}
@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// RateLimitExemption represents an exemption from rate limits in the API.
+type RateLimitExemption struct {
+	ID          int64              `json:"id"`
+	AccountName models.AccountName `json:"account,omitempty"`
+	CIDR        string             `json:"cidr,omitempty"`
+	UserName    string             `json:"user_name,omitempty"`
+	Comment     string             `json:"comment,omitempty"`
+	CreatedAt   int64              `json:"created_at"`
+	CreatedBy   string             `json:"created_by,omitempty"`
+}
+
+// RenderRateLimitExemption converts a rate limit exemption model from the DB into the API representation.
+func RenderRateLimitExemption(e models.RateLimitExemption) RateLimitExemption {
+	return RateLimitExemption{
+		ID:          e.ID,
+		AccountName: e.AccountName,
+		CIDR:        e.CIDR,
+		UserName:    e.UserName,
+		Comment:     e.Comment,
+		CreatedAt:   e.CreatedAt.Unix(),
+		CreatedBy:   e.CreatedBy,
+	}
+}
+
+// ApplyToModel validates this exemption and stores it in the given model.
+// The fields ID, CreatedAt and CreatedBy are ignored since they are not
+// user-controlled.
+func (e RateLimitExemption) ApplyToModel(target *models.RateLimitExemption) error {
+	if e.AccountName == "" && e.CIDR == "" && e.UserName == "" {
+		return errors.New("rate limit exemption must match on at least one of account, cidr or user_name")
+	}
+	if strings.ContainsAny(e.Comment, "\r\n") {
+		return errors.New("rate limit exemption comment may not contain line breaks")
+	}
+
+	target.CIDR = ""
+	if e.CIDR != "" {
+		prefix, err := netip.ParsePrefix(e.CIDR)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR for rate limit exemption: %w", err)
+		}
+		target.CIDR = prefix.Masked().String()
+	}
+	target.AccountName = e.AccountName
+	target.UserName = e.UserName
+	target.Comment = e.Comment
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// RateLimitExemptionRefreshInterval is how long RateLimitExemptionCache
+// holds on to the set of exemptions before reloading it from the DB.
+const RateLimitExemptionRefreshInterval = 1 * time.Minute
+
+// RateLimitExemptionCache holds the contents of the `rate_limit_exemptions`
+// table in memory. It is used by RateLimitEngine to decide which requests are
+// exempt from rate limits without having to query the DB on each request.
+//
+// Changes to the table become visible once the cache is refreshed, which
+// happens at least once every RateLimitExemptionRefreshInterval, or
+// immediately after Invalidate() is called.
+type RateLimitExemptionCache struct {
+	db      gorp.SqlExecutor
+	timeNow func() time.Time
+
+	// The DB is queried without holding the mutex, so that requests do not
+	// need to wait for each other while the exemptions are being reloaded.
+	// While one request is reloading, other requests keep using the previous
+	// set of exemptions, if any.
+	mutex        sync.Mutex
+	exemptions   []rateLimitExemptionMatcher // never modified in place, only replaced
+	loadedAt     time.Time
+	isRefreshing bool
+	generation   uint64 // incremented by Invalidate()
+}
+
+type rateLimitExemptionMatcher struct {
+	AccountName models.AccountName
+	Prefix      netip.Prefix // not valid if no CIDR was given
+	UserName    string
+}
+
+// NewRateLimitExemptionCache builds a new RateLimitExemptionCache.
+func NewRateLimitExemptionCache(db gorp.SqlExecutor, timeNow func() time.Time) *RateLimitExemptionCache {
+	return &RateLimitExemptionCache{db: db, timeNow: timeNow}
+}
+
+// Invalidate forces the set of exemptions to be reloaded on next use.
+func (c *RateLimitExemptionCache) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.loadedAt = time.Time{}
+	c.generation++
+}
+
+// Returns the current set of exemptions, reloading it from the DB if necessary.
+func (c *RateLimitExemptionCache) getExemptions() ([]rateLimitExemptionMatcher, error) {
+	c.mutex.Lock()
+	now := c.timeNow()
+	isStale := c.loadedAt.IsZero() || now.Sub(c.loadedAt) >= RateLimitExemptionRefreshInterval
+	if !isStale || (c.isRefreshing && !c.loadedAt.IsZero()) {
+		exemptions := c.exemptions
+		c.mutex.Unlock()
+		return exemptions, nil
+	}
+	c.isRefreshing = true
+	generation := c.generation
+	c.mutex.Unlock()
+
+	exemptions, err := c.loadExemptions()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.isRefreshing = false
+	if err != nil {
+		return nil, err
+	}
+	// if Invalidate() was called while we were loading, our result may already
+	// be outdated, so it can be used for this request, but not cached
+	if c.generation == generation {
+		c.exemptions = exemptions
+		c.loadedAt = now
+	}
+	return exemptions, nil
+}
+
+func (c *RateLimitExemptionCache) loadExemptions() ([]rateLimitExemptionMatcher, error) {
+	var dbExemptions []models.RateLimitExemption
+	_, err := c.db.Select(&dbExemptions, `SELECT * FROM rate_limit_exemptions`)
+	if err != nil {
+		return nil, fmt.Errorf("while loading rate limit exemptions: %w", err)
+	}
+	exemptions := make([]rateLimitExemptionMatcher, 0, len(dbExemptions))
+	for _, e := range dbExemptions {
+		m := rateLimitExemptionMatcher{AccountName: e.AccountName, UserName: e.UserName}
+		if e.CIDR != "" {
+			m.Prefix, err = netip.ParsePrefix(e.CIDR)
+			if err != nil {
+				// should not happen since CIDRs are validated before being stored
+				return nil, fmt.Errorf("while parsing CIDR of rate limit exemption %d: %w", e.ID, err)
+			}
+		}
+		exemptions = append(exemptions, m)
+	}
+	return exemptions, nil
+}
+
+// IsExempt returns whether a request with the given properties matches any of
+// the exemptions in the DB.
+func (c *RateLimitExemptionCache) IsExempt(remoteAddr string, accountName models.AccountName, userName string) (bool, error) {
+	exemptions, err := c.getExemptions()
+	if err != nil {
+		return false, err
+	}
+
+	addr, err := netip.ParseAddr(NormalizeIP(remoteAddr))
+	isValidAddr := err == nil
+	for _, m := range exemptions {
+		if m.AccountName != "" && m.AccountName != accountName {
+			continue
+		}
+		if m.UserName != "" && m.UserName != userName {
+			continue
+		}
+		if m.Prefix.IsValid() && !(isValidAddr && m.Prefix.Contains(addr)) {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
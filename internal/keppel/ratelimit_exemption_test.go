@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/sapcc/go-bits/mock"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// A gorp.SqlExecutor that serves the `rate_limit_exemptions` table from memory.
+// If `Block` is not nil, each query signals on `Entered` and then waits until
+// `Block` can be received from.
+type fakeExemptionDB struct {
+	gorp.SqlExecutor
+	Exemptions []models.RateLimitExemption
+	QueryCount int
+	Entered    chan struct{}
+	Block      chan struct{}
+}
+
+func (db *fakeExemptionDB) Select(i any, query string, args ...any) ([]any, error) {
+	db.QueryCount++
+	result := slices.Clone(db.Exemptions)
+	if db.Block != nil {
+		db.Entered <- struct{}{}
+		<-db.Block
+	}
+	*(i.(*[]models.RateLimitExemption)) = result
+	return nil, nil
+}
+
+func TestRateLimitExemptionCache(t *testing.T) {
+	clock := mock.NewClock()
+	db := &fakeExemptionDB{
+		Exemptions: []models.RateLimitExemption{{ID: 1, CIDR: "192.0.2.0/24"}},
+	}
+	c := NewRateLimitExemptionCache(db, clock.Now)
+
+	expectExempt := func(remoteAddr string, expected bool) {
+		t.Helper()
+		actual, err := c.IsExempt(remoteAddr, "test1", "someone")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if actual != expected {
+			t.Errorf("expected IsExempt(%q) = %t, but got %t", remoteAddr, expected, actual)
+		}
+	}
+	expectQueryCount := func(expected int) {
+		t.Helper()
+		if db.QueryCount != expected {
+			t.Errorf("expected %d queries, but got %d", expected, db.QueryCount)
+		}
+	}
+
+	// the exemptions are loaded on first use and then cached
+	expectExempt("192.0.2.1", true)
+	expectExempt("198.51.100.1", false)
+	expectQueryCount(1)
+
+	// while the exemptions are being reloaded, other requests do not wait for
+	// the DB and keep using the previous set of exemptions
+	db.Exemptions = []models.RateLimitExemption{{ID: 2, CIDR: "198.51.100.0/24"}}
+	db.Entered = make(chan struct{})
+	db.Block = make(chan struct{})
+	clock.StepBy(RateLimitExemptionRefreshInterval)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		expectExempt("198.51.100.1", true)
+	}()
+	<-db.Entered
+	expectExempt("192.0.2.1", true)
+	expectExempt("198.51.100.1", false)
+	close(db.Block)
+	wg.Wait()
+	db.Block = nil
+	expectQueryCount(2)
+
+	// afterwards, the new set of exemptions is used
+	expectExempt("192.0.2.1", false)
+	expectExempt("198.51.100.1", true)
+	expectQueryCount(2)
+
+	// when the cache is invalidated during a reload, the result of that reload
+	// is not cached since it may not reflect the latest changes
+	db.Block = make(chan struct{})
+	c.Invalidate()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		expectExempt("198.51.100.1", true)
+	}()
+	<-db.Entered
+	db.Exemptions = nil
+	c.Invalidate()
+	close(db.Block)
+	wg.Wait()
+	db.Block = nil
+	expectExempt("198.51.100.1", false)
+	expectQueryCount(4)
+
+	// the cache also expires on its own
+	db.Exemptions = []models.RateLimitExemption{{ID: 3, UserName: "someone"}}
+	clock.StepBy(RateLimitExemptionRefreshInterval - time.Second)
+	expectExempt("203.0.113.1", false)
+	clock.StepBy(time.Second)
+	expectExempt("203.0.113.1", true)
+	expectQueryCount(5)
+}
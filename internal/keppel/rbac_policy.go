@@ -4,11 +4,14 @@
 package keppel
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 
+	"github.com/sapcc/go-bits/must"
 	"github.com/sapcc/go-bits/regexpext"
 
 	"github.com/sapcc/keppel/internal/models"
@@ -16,10 +19,15 @@ import (
 
 // RBACPolicy is a policy granting user-defined access to repos in an account.
 // It is stored in serialized form in the RBACPoliciesJSON field of type Account.
+//
+// If KeppelLabels is set, the policy only grants pull access to those
+// manifests that have all of these Keppel labels (see type
+// models.ManifestKeppelLabel).
 type RBACPolicy struct {
 	CidrPattern          string                  `json:"match_cidr,omitempty"`
 	RepositoryPattern    regexpext.BoundedRegexp `json:"match_repository,omitempty"`
 	UserNamePattern      regexpext.BoundedRegexp `json:"match_username,omitempty"`
+	KeppelLabels         map[string]string       `json:"match_keppel_labels,omitempty"`
 	Permissions          []RBACPermission        `json:"permissions"`
 	ForbiddenPermissions []RBACPermission        `json:"forbidden_permissions,omitempty"`
 }
@@ -31,6 +39,7 @@ const (
 	RBACPullPermission               RBACPermission = "pull"
 	RBACPushPermission               RBACPermission = "push"
 	RBACDeletePermission             RBACPermission = "delete"
+	RBACPromotePermission            RBACPermission = "promote"
 	RBACAnonymousPullPermission      RBACPermission = "anonymous_pull"
 	RBACAnonymousFirstPullPermission RBACPermission = "anonymous_first_pull"
 )
@@ -39,6 +48,7 @@ var isRBACPermission = map[RBACPermission]bool{
 	RBACPullPermission:               true,
 	RBACPushPermission:               true,
 	RBACDeletePermission:             true,
+	RBACPromotePermission:            true,
 	RBACAnonymousPullPermission:      true,
 	RBACAnonymousFirstPullPermission: true,
 }
@@ -63,6 +73,14 @@ func (r RBACPolicy) Matches(ip, repoName, userName string) bool {
 	return true
 }
 
+// Fingerprint returns a string that identifies this policy within its account.
+// Since policies do not have IDs, the fingerprint is derived from the
+// policy's contents, so any change to a policy also changes its fingerprint.
+func (r RBACPolicy) Fingerprint() string {
+	sum := sha256.Sum256(must.Return(json.Marshal(r)))
+	return hex.EncodeToString(sum[:])
+}
+
 // ValidateAndNormalize performs some normalizations and returns an error if
 // this policy is invalid.
 func (r *RBACPolicy) ValidateAndNormalize(strategy ReplicationStrategy) error {
@@ -104,12 +122,27 @@ func (r *RBACPolicy) ValidateAndNormalize(strategy ReplicationStrategy) error {
 	if len(r.Permissions) == 0 && len(r.ForbiddenPermissions) == 0 {
 		return errors.New(`RBAC policy must grant at least one permission`)
 	}
-	if r.CidrPattern == "" && r.UserNamePattern == "" && r.RepositoryPattern == "" {
+	if r.CidrPattern == "" && r.UserNamePattern == "" && r.RepositoryPattern == "" && len(r.KeppelLabels) == 0 {
 		return errors.New(`RBAC policy must have at least one "match_..." attribute`)
 	}
 	if (refersToPerm[RBACAnonymousPullPermission] || refersToPerm[RBACAnonymousFirstPullPermission]) && r.UserNamePattern != "" {
 		return errors.New(`RBAC policy with "anonymous_pull" or "anonymous_first_pull" may not have the "match_username" attribute`)
 	}
+	if len(r.KeppelLabels) > 0 {
+		for name := range r.KeppelLabels {
+			if !KeppelLabelNameRx.MatchString(name) {
+				return fmt.Errorf("%q is not a valid Keppel label name", name)
+			}
+		}
+		if len(r.ForbiddenPermissions) > 0 {
+			return errors.New(`RBAC policy with "match_keppel_labels" may not have the "forbidden_permissions" attribute`)
+		}
+		for _, perm := range r.Permissions {
+			if perm != RBACPullPermission && perm != RBACAnonymousPullPermission {
+				return errors.New(`RBAC policy with "match_keppel_labels" may only grant "pull" or "anonymous_pull"`)
+			}
+		}
+	}
 	if refersToPerm[RBACPullPermission] && r.CidrPattern == "" && r.UserNamePattern == "" {
 		return errors.New(`RBAC policy with "pull" must have the "match_cidr" or "match_username" attribute`)
 	}
@@ -119,6 +152,9 @@ func (r *RBACPolicy) ValidateAndNormalize(strategy ReplicationStrategy) error {
 	if refersToPerm[RBACDeletePermission] && r.UserNamePattern == "" {
 		return errors.New(`RBAC policy with "delete" must have the "match_username" attribute`)
 	}
+	if refersToPerm[RBACPromotePermission] && r.UserNamePattern == "" {
+		return errors.New(`RBAC policy with "promote" must have the "match_username" attribute`)
+	}
 	if refersToPerm[RBACAnonymousFirstPullPermission] && strategy != FromExternalOnFirstUseStrategy {
 		return errors.New(`RBAC policy with "anonymous_first_pull" may only be for external replica accounts`)
 	}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// Since the RBAC policy epochs of all accounts in a token are checked on
+// every request with that token, they are cached for a short time. Changes to
+// accounts invalidate the cache immediately in the process that makes the
+// change, and in all keppel-api replicas through ListenForAccountChanges().
+const rbacPolicyEpochCacheTTL = 1 * time.Minute
+
+type rbacPolicyEpochCacheKey struct {
+	DB          *DB
+	AccountName models.AccountName
+}
+
+type rbacPolicyEpochCacheEntry struct {
+	Epoch     int64
+	ExpiresAt time.Time
+}
+
+var (
+	rbacPolicyEpochCacheMutex sync.Mutex
+	rbacPolicyEpochCache      = make(map[rbacPolicyEpochCacheKey]rbacPolicyEpochCacheEntry)
+)
+
+var rbacPolicyEpochsQuery = `SELECT name, rbac_policy_epoch FROM accounts WHERE name = ANY($1)`
+
+// GetRBACPolicyEpochs returns the current RBAC policy epochs (see
+// Account.RBACPolicyEpoch) of the given accounts. Accounts that do not exist
+// are missing from the result.
+//
+// If `skipCache` is true, all epochs are read from the DB. This should be
+// used before rejecting a request because of an epoch mismatch, since the
+// cached epoch may be outdated when a notification about the change has not
+// arrived yet.
+func GetRBACPolicyEpochs(db *DB, accountNames []models.AccountName, skipCache bool) (map[models.AccountName]int64, error) {
+	result := make(map[models.AccountName]int64, len(accountNames))
+	now := time.Now()
+
+	var missingNames []string
+	rbacPolicyEpochCacheMutex.Lock()
+	for _, name := range accountNames {
+		entry, ok := rbacPolicyEpochCache[rbacPolicyEpochCacheKey{db, name}]
+		if ok && !skipCache && entry.ExpiresAt.After(now) {
+			result[name] = entry.Epoch
+		} else {
+			missingNames = append(missingNames, string(name))
+		}
+	}
+	rbacPolicyEpochCacheMutex.Unlock()
+	if len(missingNames) == 0 {
+		return result, nil
+	}
+
+	loaded := make(map[models.AccountName]int64, len(missingNames))
+	err := sqlext.ForeachRow(db, rbacPolicyEpochsQuery, []any{pq.Array(missingNames)}, func(rows *sql.Rows) error {
+		var (
+			accountName models.AccountName
+			epoch       int64
+		)
+		err := rows.Scan(&accountName, &epoch)
+		loaded[accountName] = epoch
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rbacPolicyEpochCacheMutex.Lock()
+	defer rbacPolicyEpochCacheMutex.Unlock()
+	for name, epoch := range loaded {
+		result[name] = epoch
+		rbacPolicyEpochCache[rbacPolicyEpochCacheKey{db, name}] = rbacPolicyEpochCacheEntry{
+			Epoch:     epoch,
+			ExpiresAt: now.Add(rbacPolicyEpochCacheTTL),
+		}
+	}
+	return result, nil
+}
+
+// InvalidateRBACPolicyEpochCache discards all results cached by
+// GetRBACPolicyEpochs().
+func InvalidateRBACPolicyEpochCache() {
+	rbacPolicyEpochCacheMutex.Lock()
+	defer rbacPolicyEpochCacheMutex.Unlock()
+	clear(rbacPolicyEpochCache)
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import "github.com/opencontainers/go-digest"
+
+// ReplicaDivergenceKind enumerates the kinds of ReplicaDivergence.
+type ReplicaDivergenceKind string
+
+const (
+	// TagMovedDivergence means that the tag points to a different manifest upstream.
+	TagMovedDivergence ReplicaDivergenceKind = "tag_moved"
+	// TagDeletedDivergence means that the tag does not exist upstream anymore.
+	TagDeletedDivergence ReplicaDivergenceKind = "tag_deleted"
+	// ManifestDeletedDivergence means that the manifest does not exist upstream anymore.
+	ManifestDeletedDivergence ReplicaDivergenceKind = "manifest_deleted"
+	// ManifestMismatchDivergence means that upstream serves different contents
+	// for the manifest's digest. This points to a misbehaving upstream registry,
+	// and cannot be repaired by Keppel.
+	ManifestMismatchDivergence ReplicaDivergenceKind = "manifest_mismatch"
+)
+
+// IsRepairable returns whether the manifest sync in the janitor resolves
+// divergences of this kind.
+func (k ReplicaDivergenceKind) IsRepairable() bool {
+	return k != ManifestMismatchDivergence
+}
+
+// ReplicaDivergence describes a difference between a repository in a replica
+// account and the corresponding upstream repository.
+type ReplicaDivergence struct {
+	Kind ReplicaDivergenceKind `json:"kind"`
+	// Tag is only filled for the tag_* kinds.
+	Tag    string        `json:"tag,omitempty"`
+	Digest digest.Digest `json:"digest"`
+	// UpstreamDigest is only filled for TagMovedDivergence.
+	UpstreamDigest digest.Digest `json:"upstream_digest,omitempty"`
+}
+
+// HasRepairableDivergences returns whether the manifest sync in the janitor
+// resolves at least one of the given divergences.
+func HasRepairableDivergences(divergences []ReplicaDivergence) bool {
+	for _, d := range divergences {
+		if d.Kind.IsRepairable() {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplicaRevalidationResult describes the outcome of a revalidation of a
+// replica repo that was performed by the janitor. It is stored in the
+// repos.revalidation_result_json column.
+type ReplicaRevalidationResult struct {
+	Divergences     []ReplicaDivergence `json:"divergences,omitempty"`
+	Error           string              `json:"error,omitempty"`
+	RepairScheduled bool                `json:"repair_scheduled,omitempty"`
+}
@@ -3,7 +3,11 @@
 
 package keppel
 
-import "github.com/opencontainers/go-digest"
+import (
+	"encoding/json"
+
+	"github.com/opencontainers/go-digest"
+)
 
 // ReplicaSyncPayload is the format for request bodies and response bodies of
 // the sync-replica API endpoint.
@@ -56,3 +60,16 @@ func (p ReplicaSyncPayload) DigestForTag(name string) digest.Digest {
 	}
 	return ""
 }
+
+// TrivyReportForSync is the format for response bodies of the peer API
+// endpoint that replicas use to obtain vulnerability reports from the primary
+// account instead of scanning the respective manifests themselves.
+//
+// (This type is declared in this package because it gets used in both
+// internal/api/peer and internal/tasks.)
+type TrivyReportForSync struct {
+	// CheckedAt is when the primary last scanned this manifest, as a UNIX timestamp.
+	CheckedAt int64 `json:"checked_at"`
+	// Report is the vulnerability report in Trivy's JSON format.
+	Report json.RawMessage `json:"report"`
+}
@@ -16,6 +16,10 @@ type ReplicationPolicy struct {
 	Strategy ReplicationStrategy `json:"strategy"`
 	// only for `on_first_use`
 	UpstreamPeerHostName string `json:"upstream_peer_hostname"`
+	// only for `on_first_use`
+	SyncPolicies bool `json:"sync_policies"`
+	// only for `on_first_use`
+	LocalPolicyOverride bool `json:"local_policy_override"`
 	// only for `from_external_on_first_use`
 	ExternalPeer ReplicationExternalPeerSpec `json:"external_peer"`
 }
@@ -34,6 +38,15 @@ type ReplicationExternalPeerSpec struct {
 	URL      string `json:"url"`
 	UserName string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
+	// PasswordRef can be given instead of Password to refer to a secret in the
+	// secret store behind the SecretsDriver.
+	PasswordRef string `json:"password_ref,omitempty"`
+	// Credentials contains additional credentials that are used instead of
+	// UserName and Password for upstream repos with a matching name prefix.
+	Credentials models.ExternalPeerCredentials `json:"credentials,omitempty"`
+	// VerifyOnly enables additional verification of replicated contents for
+	// upstreams that are not trusted to deliver the contents that they claim.
+	VerifyOnly bool `json:"verify_only,omitempty"`
 }
 
 // MarshalJSON implements the json.Marshaler interface.
@@ -43,7 +56,9 @@ func (r ReplicationPolicy) MarshalJSON() ([]byte, error) {
 		data := struct {
 			Strategy             ReplicationStrategy `json:"strategy"`
 			UpstreamPeerHostName string              `json:"upstream"`
-		}{r.Strategy, r.UpstreamPeerHostName}
+			SyncPolicies         bool                `json:"sync_policies,omitempty"`
+			LocalPolicyOverride  bool                `json:"local_policy_override,omitempty"`
+		}{r.Strategy, r.UpstreamPeerHostName, r.SyncPolicies, r.LocalPolicyOverride}
 		return json.Marshal(data)
 	case FromExternalOnFirstUseStrategy:
 		data := struct {
@@ -59,14 +74,18 @@ func (r ReplicationPolicy) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON implements the json.Unmarshaler interface.
 func (r *ReplicationPolicy) UnmarshalJSON(buf []byte) error {
 	var s struct {
-		Strategy ReplicationStrategy `json:"strategy"`
-		Upstream json.RawMessage     `json:"upstream"`
+		Strategy            ReplicationStrategy `json:"strategy"`
+		Upstream            json.RawMessage     `json:"upstream"`
+		SyncPolicies        bool                `json:"sync_policies"`
+		LocalPolicyOverride bool                `json:"local_policy_override"`
 	}
 	err := json.Unmarshal(buf, &s)
 	if err != nil {
 		return err
 	}
 	r.Strategy = s.Strategy
+	r.SyncPolicies = s.SyncPolicies
+	r.LocalPolicyOverride = s.LocalPolicyOverride
 
 	if len(s.Upstream) == 0 {
 		// need a more explicit error for this, otherwise the next json.Unmarshal()
@@ -78,6 +97,12 @@ func (r *ReplicationPolicy) UnmarshalJSON(buf []byte) error {
 	case OnFirstUseStrategy:
 		return json.Unmarshal(s.Upstream, &r.UpstreamPeerHostName)
 	case FromExternalOnFirstUseStrategy:
+		if s.SyncPolicies {
+			return fmt.Errorf(`field "sync_policies" is not supported for ReplicationPolicy with strategy %q`, r.Strategy)
+		}
+		if s.LocalPolicyOverride {
+			return fmt.Errorf(`field "local_policy_override" is not supported for ReplicationPolicy with strategy %q`, r.Strategy)
+		}
 		return json.Unmarshal(s.Upstream, &r.ExternalPeer)
 	default:
 		return fmt.Errorf("do not know how to deserialize ReplicationPolicy with strategy %q", r.Strategy)
@@ -91,6 +116,8 @@ func RenderReplicationPolicy(account models.Account) *ReplicationPolicy {
 		return &ReplicationPolicy{
 			Strategy:             OnFirstUseStrategy,
 			UpstreamPeerHostName: account.UpstreamPeerHostName,
+			SyncPolicies:         account.SyncPoliciesFromPrimary,
+			LocalPolicyOverride:  account.PoliciesOverriddenLocally,
 		}
 	}
 
@@ -100,7 +127,10 @@ func RenderReplicationPolicy(account models.Account) *ReplicationPolicy {
 			ExternalPeer: ReplicationExternalPeerSpec{
 				URL:      account.ExternalPeerURL,
 				UserName: account.ExternalPeerUserName,
-				//NOTE: Password is omitted here for security reasons
+				//NOTE: Passwords are omitted here for security reasons (but references to passwords are fine)
+				PasswordRef: account.ExternalPeerPasswordRef,
+				Credentials: account.ExternalPeerCredentials.Redacted(),
+				VerifyOnly:  account.ExternalPeerVerifyOnly,
 			},
 		}
 	}
@@ -130,6 +160,11 @@ func (r ReplicationPolicy) ApplyToAccount(account *models.Account) error {
 			// on existing accounts, changing the upstream peer is not allowed
 			return ErrIncompatibleReplicationPolicy
 		}
+		if r.LocalPolicyOverride && !r.SyncPolicies {
+			return errors.New(`field "local_policy_override" requires "sync_policies" to be set`)
+		}
+		account.SyncPoliciesFromPrimary = r.SyncPolicies
+		account.PoliciesOverriddenLocally = r.LocalPolicyOverride
 
 	case FromExternalOnFirstUseStrategy:
 		rerr := r.ExternalPeer.applyToAccount(account)
@@ -156,22 +191,64 @@ func (r ReplicationExternalPeerSpec) applyToAccount(account *models.Account) err
 		return ErrIncompatibleReplicationPolicy
 	}
 
+	if r.Password != "" && r.PasswordRef != "" {
+		return errors.New(`cannot give both password and password_ref for "from_external_on_first_use" replication`)
+	}
+
 	// on existing accounts, having only a username is acceptable if it's unchanged
 	// (this case occurs when a client GETs the account, changes something not related
 	// to replication, and PUTs the result; the password is redacted in GET)
-	if !isNewAccount && r.UserName != "" && r.Password == "" {
+	if !isNewAccount && r.UserName != "" && r.Password == "" && r.PasswordRef == "" {
 		if r.UserName == account.ExternalPeerUserName {
-			r.Password = account.ExternalPeerPassword // to save it from being overwritten below
+			// to save them from being overwritten below
+			r.Password = account.ExternalPeerPassword
+			r.PasswordRef = account.ExternalPeerPasswordRef
 		} else {
 			return errors.New(`cannot change username for "from_external_on_first_use" replication without also changing password`)
 		}
 	}
 
 	// pull credentials can be updated mostly at will
-	if (r.UserName == "") != (r.Password == "") {
+	if (r.UserName == "") != (r.Password == "" && r.PasswordRef == "") {
 		return errors.New(`need either both username and password or neither for "from_external_on_first_use" replication`)
 	}
 	account.ExternalPeerUserName = r.UserName
 	account.ExternalPeerPassword = r.Password
+	account.ExternalPeerPasswordRef = r.PasswordRef
+
+	// per-repo-prefix credentials follow the same rules as above
+	credentials := make(models.ExternalPeerCredentials, len(r.Credentials))
+	isPrefixSeen := make(map[string]bool, len(r.Credentials))
+	for idx, set := range r.Credentials {
+		if set.RepoPrefix == "" {
+			return errors.New(`missing repo_prefix in credentials for "from_external_on_first_use" replication`)
+		}
+		if isPrefixSeen[set.RepoPrefix] {
+			return fmt.Errorf(`duplicate repo_prefix %q in credentials for "from_external_on_first_use" replication`, set.RepoPrefix)
+		}
+		isPrefixSeen[set.RepoPrefix] = true
+
+		if set.Password != "" && set.PasswordRef != "" {
+			return fmt.Errorf(`cannot give both password and password_ref for repo_prefix %q in "from_external_on_first_use" replication`, set.RepoPrefix)
+		}
+		if !isNewAccount && set.UserName != "" && set.Password == "" && set.PasswordRef == "" {
+			existingSet := account.ExternalPeerCredentials.ForRepo(set.RepoPrefix)
+			if existingSet != nil && existingSet.RepoPrefix == set.RepoPrefix && existingSet.UserName == set.UserName {
+				set.Password = existingSet.Password
+				set.PasswordRef = existingSet.PasswordRef
+			} else {
+				return fmt.Errorf(`cannot change username for repo_prefix %q in "from_external_on_first_use" replication without also changing password`, set.RepoPrefix)
+			}
+		}
+		if set.UserName == "" || (set.Password == "" && set.PasswordRef == "") {
+			return fmt.Errorf(`need both username and password for repo_prefix %q in "from_external_on_first_use" replication`, set.RepoPrefix)
+		}
+		credentials[idx] = set
+	}
+	if len(credentials) == 0 {
+		credentials = nil
+	}
+	account.ExternalPeerCredentials = credentials
+	account.ExternalPeerVerifyOnly = r.VerifyOnly
 	return nil
 }
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ReplicationHealth represents the error budget for replication from upstream
+// of a single replica account in the API.
+type ReplicationHealth struct {
+	WindowSeconds uint64 `json:"window_seconds"`
+	Successes     uint64 `json:"successes"`
+	Failures      uint64 `json:"failures"`
+	// ErrorPercent is omitted when no replications were attempted within the window.
+	ErrorPercent *float64 `json:"error_percent,omitempty"`
+	PausedAt     *int64   `json:"paused_at,omitempty"`
+}
+
+var replicationOutcomesSumQuery = sqlext.SimplifyWhitespace(`
+	SELECT COALESCE(SUM(successes), 0), COALESCE(SUM(failures), 0)
+	  FROM replication_outcomes
+	 WHERE account_name = $1 AND bucket_start > $2
+`)
+
+// FindReplicationHealth computes the current ReplicationHealth of the given
+// account by counting replications within the configured window.
+func FindReplicationHealth(db gorp.SqlExecutor, account models.ReducedAccount, budget ReplicationErrorBudget, now time.Time) (ReplicationHealth, error) {
+	result := ReplicationHealth{
+		WindowSeconds: AtLeastZero(int64(budget.Window / time.Second)),
+		PausedAt:      MaybeTimeToUnix(account.ReplicationPausedAt),
+	}
+	err := db.QueryRow(replicationOutcomesSumQuery, account.Name, now.Add(-budget.Window)).Scan(&result.Successes, &result.Failures)
+	if err != nil {
+		return ReplicationHealth{}, err
+	}
+
+	attempts := result.Successes + result.Failures
+	if attempts > 0 {
+		errorPercent := 100 * float64(result.Failures) / float64(attempts)
+		result.ErrorPercent = &errorPercent
+	}
+	return result, nil
+}
+
+// ExceedsBudget returns whether this ReplicationHealth is bad enough that
+// replication should be paused according to the given budget.
+func (h ReplicationHealth) ExceedsBudget(budget ReplicationErrorBudget) bool {
+	if budget.PauseErrorPercent == 0 || h.ErrorPercent == nil {
+		return false
+	}
+	if h.Successes+h.Failures < AtLeastZero(int64(budget.PauseMinAttempts)) {
+		return false
+	}
+	return *h.ErrorPercent >= float64(budget.PauseErrorPercent)
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"testing"
+)
+
+func TestReplicationHealthExceedsBudget(t *testing.T) {
+	budget := ReplicationErrorBudget{PauseErrorPercent: 50, PauseMinAttempts: 10}
+	errorPercent := func(successes, failures uint64) *float64 {
+		result := 100 * float64(failures) / float64(successes+failures)
+		return &result
+	}
+
+	testCases := []struct {
+		Health   ReplicationHealth
+		Expected bool
+	}{
+		// no attempts at all
+		{ReplicationHealth{}, false},
+		// high error rate, but not enough attempts to be sure
+		{ReplicationHealth{Successes: 1, Failures: 8, ErrorPercent: errorPercent(1, 8)}, false},
+		// enough attempts, but error rate is below threshold
+		{ReplicationHealth{Successes: 6, Failures: 4, ErrorPercent: errorPercent(6, 4)}, false},
+		// enough attempts and error rate exactly on threshold
+		{ReplicationHealth{Successes: 5, Failures: 5, ErrorPercent: errorPercent(5, 5)}, true},
+		{ReplicationHealth{Successes: 0, Failures: 20, ErrorPercent: errorPercent(0, 20)}, true},
+	}
+	for _, tc := range testCases {
+		actual := tc.Health.ExceedsBudget(budget)
+		if actual != tc.Expected {
+			t.Errorf("expected ExceedsBudget() = %t for %d successes and %d failures, but got %t",
+				tc.Expected, tc.Health.Successes, tc.Health.Failures, actual)
+		}
+	}
+
+	// auto-pausing can be disabled
+	budget.PauseErrorPercent = 0
+	if (ReplicationHealth{Failures: 20, ErrorPercent: errorPercent(0, 20)}).ExceedsBudget(budget) {
+		t.Error("expected ExceedsBudget() = false when auto-pausing is disabled")
+	}
+}
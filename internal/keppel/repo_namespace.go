@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/sapcc/go-bits/regexpext"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// RepositoryNamespace represents a repository namespace in the API.
+type RepositoryNamespace struct {
+	Prefix               string                  `json:"prefix"`
+	AdminUserNamePattern regexpext.BoundedRegexp `json:"match_admin_username,omitempty"`
+	ManifestQuota        *uint64                 `json:"manifest_quota,omitempty"`
+	// ManifestUsage is only filled when rendering, and ignored when parsing.
+	ManifestUsage uint64       `json:"manifest_usage"`
+	RBACPolicies  []RBACPolicy `json:"rbac_policies"`
+	GCPolicies    []GCPolicy   `json:"gc_policies"`
+}
+
+// RenderRepositoryNamespace converts a namespace model from the DB into the API representation.
+func RenderRepositoryNamespace(ns models.RepositoryNamespace, manifestUsage uint64) (RepositoryNamespace, error) {
+	rbacPolicies, err := ParseRBACPoliciesField(ns.RBACPoliciesJSON)
+	if err != nil {
+		return RepositoryNamespace{}, err
+	}
+	if rbacPolicies == nil {
+		rbacPolicies = []RBACPolicy{}
+	}
+	gcPolicies, err := parseGCPoliciesField(ns.GCPoliciesJSON)
+	if err != nil {
+		return RepositoryNamespace{}, err
+	}
+	if gcPolicies == nil {
+		gcPolicies = []GCPolicy{}
+	}
+	return RepositoryNamespace{
+		Prefix:               ns.Prefix,
+		AdminUserNamePattern: regexpext.BoundedRegexp(ns.AdminUserNamePattern),
+		ManifestQuota:        ns.ManifestQuota,
+		ManifestUsage:        manifestUsage,
+		RBACPolicies:         rbacPolicies,
+		GCPolicies:           gcPolicies,
+	}, nil
+}
+
+// IsNamespaceAdmin returns whether the given user has been delegated
+// administration of the given namespace.
+func IsNamespaceAdmin(ns models.RepositoryNamespace, uid UserIdentity) bool {
+	if ns.AdminUserNamePattern == "" || uid == nil || uid.UserType() != RegularUser {
+		return false
+	}
+	return regexpext.BoundedRegexp(ns.AdminUserNamePattern).MatchString(uid.UserName())
+}
+
+// NamespaceRBACPolicies parses the RBAC policies of the given namespace, and
+// rewrites their repository patterns such that they can be evaluated like
+// account-level policies.
+func NamespaceRBACPolicies(ns models.RepositoryNamespace) ([]RBACPolicy, error) {
+	policies, err := ParseRBACPoliciesField(ns.RBACPoliciesJSON)
+	if err != nil {
+		return nil, err
+	}
+	for idx, policy := range policies {
+		policies[idx].RepositoryPattern = absoluteNamespacePattern(ns.Prefix, policy.RepositoryPattern)
+	}
+	return policies, nil
+}
+
+// NamespaceGCPolicies parses the GC policies of the given namespace, and
+// rewrites their repository patterns such that they can be evaluated like
+// account-level policies.
+func NamespaceGCPolicies(ns models.RepositoryNamespace) ([]GCPolicy, error) {
+	policies, err := parseGCPoliciesField(ns.GCPoliciesJSON)
+	if err != nil {
+		return nil, err
+	}
+	for idx, policy := range policies {
+		policies[idx].RepositoryRx = absoluteNamespacePattern(ns.Prefix, policy.RepositoryRx)
+		if policy.NegativeRepositoryRx != "" {
+			policies[idx].NegativeRepositoryRx = absoluteNamespacePattern(ns.Prefix, policy.NegativeRepositoryRx)
+		}
+	}
+	return policies, nil
+}
+
+func absoluteNamespacePattern(prefix string, pattern regexpext.BoundedRegexp) regexpext.BoundedRegexp {
+	if pattern == "" {
+		pattern = ".*"
+	}
+	return regexpext.BoundedRegexp(regexp.QuoteMeta(prefix) + "/(?:" + string(pattern) + ")")
+}
+
+var findRepositoryNamespaceForRepoQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM repo_namespaces WHERE account_name = $1 AND starts_with($2, prefix || '/')
+`)
+
+// FindRepositoryNamespaceForRepo returns the namespace containing the given
+// repository, or nil if the repository is not located within a namespace.
+// Since namespaces may not be nested, there is at most one such namespace.
+func FindRepositoryNamespaceForRepo(db gorp.SqlExecutor, accountName models.AccountName, repoName string) (*models.RepositoryNamespace, error) {
+	var ns models.RepositoryNamespace
+	err := db.SelectOne(&ns, findRepositoryNamespaceForRepoQuery, accountName, repoName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return &ns, err
+}
+
+// IsValidNamespacePrefix returns whether the given string can be used as the prefix of a namespace.
+func IsValidNamespacePrefix(prefix string) bool {
+	return models.RepoPathRx.MatchString(prefix) && !strings.HasSuffix(prefix, "/")
+}
+
+var namespaceManifestUsageQuery = sqlext.SimplifyWhitespace(`
+	SELECT COUNT(*) FROM manifests m JOIN repos r ON m.repo_id = r.id
+	 WHERE r.account_name = $1 AND starts_with(r.name, $2 || '/')
+`)
+
+// GetNamespaceManifestUsage returns how many manifests exist within the given namespace.
+func GetNamespaceManifestUsage(db gorp.SqlExecutor, ns models.RepositoryNamespace) (uint64, error) {
+	usage, err := db.SelectInt(namespaceManifestUsageQuery, ns.AccountName, ns.Prefix)
+	return uint64(usage), err //nolint:gosec // COUNT(*) is never negative
+}
+
+// BumpRBACPolicyEpoch increments the RBAC policy epoch of the given account.
+// This must be called whenever RBAC policies of one of the account's
+// namespaces change, to invalidate tokens issued under the previous policies.
+func BumpRBACPolicyEpoch(db gorp.SqlExecutor, accountName models.AccountName) error {
+	_, err := db.Exec(`UPDATE accounts SET rbac_policy_epoch = rbac_policy_epoch + 1 WHERE name = $1`, accountName)
+	if err != nil {
+		return err
+	}
+	return NotifyAccountChanged(db, accountName)
+}
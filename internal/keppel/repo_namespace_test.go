@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestNamespacePolicies(t *testing.T) {
+	ns := models.RepositoryNamespace{
+		Prefix:           "team.a",
+		RBACPoliciesJSON: `[{"match_repository":"public/.*","permissions":["anonymous_pull"]},{"match_username":"alice","permissions":["pull","push"]}]`,
+		GCPoliciesJSON:   `[{"match_repository":".*","except_repository":"keep","only_untagged":true,"action":"delete"}]`,
+	}
+
+	rbacPolicies, err := NamespaceRBACPolicies(ns)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	gcPolicies, err := NamespaceGCPolicies(ns)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	webhook := models.Webhook{NamespacePrefix: ns.Prefix, RepoPattern: "public/.*"}
+
+	testCases := []struct {
+		RepoName        string
+		InNamespace     bool
+		MatchesPublic   bool
+		MatchesAlice    bool
+		MatchesGCPolicy bool
+	}{
+		{"team.a/public/app", true, true, true, true},
+		{"team.a/app", true, false, true, true},
+		{"team.a/keep", true, false, true, false},
+		{"team.a", false, false, false, false},
+		{"teamxa/app", false, false, false, false},
+		{"public/app", false, false, false, false},
+		{"other/team.a/app", false, false, false, false},
+	}
+	for _, tc := range testCases {
+		assert.DeepEqual(t, "Contains("+tc.RepoName+")", ns.Contains(tc.RepoName), tc.InNamespace)
+		assert.DeepEqual(t, "public policy matches "+tc.RepoName, rbacPolicies[0].Matches("", tc.RepoName, ""), tc.MatchesPublic)
+		assert.DeepEqual(t, "alice policy matches "+tc.RepoName, rbacPolicies[1].Matches("", tc.RepoName, "alice"), tc.MatchesAlice)
+		assert.DeepEqual(t, "GC policy matches "+tc.RepoName, gcPolicies[0].MatchesRepository(tc.RepoName), tc.MatchesGCPolicy)
+		assert.DeepEqual(t, "webhook matches "+tc.RepoName, webhookMatchesRepository(webhook, tc.RepoName), tc.MatchesPublic)
+	}
+}
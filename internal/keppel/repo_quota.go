@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var repoStorageUsageQuery = sqlext.SimplifyWhitespace(`
+	SELECT COALESCE(SUM(b.size_bytes), 0), COUNT(*) FILTER (WHERE b.digest = $2) > 0
+	  FROM blob_mounts bm
+	  JOIN blobs b ON b.id = bm.blob_id
+	 WHERE bm.repo_id = $1
+`)
+
+// GetRepoStorageUsage returns the total size of all blobs mounted in the
+// given repo. This is the usage value that is compared against
+// Repository.StorageQuotaBytes.
+func GetRepoStorageUsage(db gorp.SqlExecutor, repo models.Repository) (uint64, error) {
+	usage, _, err := getRepoStorageUsage(db, repo, "")
+	return usage, err
+}
+
+func getRepoStorageUsage(db gorp.SqlExecutor, repo models.Repository, blobDigest digest.Digest) (usage uint64, isBlobMounted bool, err error) {
+	err = db.QueryRow(repoStorageUsageQuery, repo.ID, blobDigest.String()).Scan(&usage, &isBlobMounted)
+	return usage, isBlobMounted, err
+}
+
+// StorageQuotaReservationLifetime is how long a reservation made by
+// ReserveStorageQuota is valid unless it is renewed. Reservations are usually
+// released long before that. The expiry only matters for uploads that are
+// abandoned without being aborted, so this matches the time after which the
+// janitor cleans up abandoned uploads.
+const StorageQuotaReservationLifetime = 24 * time.Hour
+
+var lockRepoForStorageQuotaQuery = sqlext.SimplifyWhitespace(`
+	SELECT id FROM repos WHERE id = $1 FOR UPDATE
+`)
+
+var storageQuotaReservationsQuery = sqlext.SimplifyWhitespace(`
+	SELECT COALESCE(SUM(size_bytes), 0) FROM storage_quota_reservations
+	 WHERE repo_id = $1 AND storage_id != $2 AND expires_at > $3
+`)
+
+var upsertStorageQuotaReservationQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO storage_quota_reservations (repo_id, storage_id, size_bytes, expires_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (repo_id, storage_id) DO UPDATE SET size_bytes = EXCLUDED.size_bytes, expires_at = EXCLUDED.expires_at
+`)
+
+var cleanupStorageQuotaReservationsQuery = sqlext.SimplifyWhitespace(`
+	DELETE FROM storage_quota_reservations WHERE repo_id = $1 AND expires_at <= $2
+`)
+
+// CheckStorageQuotaForBlobMount returns an error if mounting a blob with the
+// given digest and size into the given repo would exceed the repo's storage
+// quota. Blobs that are already mounted in the repo do not count against the
+// quota again. Space that is reserved for other uploads (see
+// ReserveStorageQuota) counts against the quota. The reservation for the blob
+// being mounted (if any) is identified by its storage ID and does not count.
+//
+// Concurrent checks for the same repo are serialized until the end of the
+// given transaction. The check is therefore only accurate if the blob is
+// mounted (and its reservation released) within the same transaction.
+func CheckStorageQuotaForBlobMount(tx *gorp.Transaction, repo models.Repository, blobDigest digest.Digest, blobSizeBytes uint64, storageID string, now time.Time) error {
+	if repo.StorageQuotaBytes == nil {
+		return nil
+	}
+	_, err := tx.Exec(lockRepoForStorageQuotaQuery, repo.ID)
+	if err != nil {
+		return err
+	}
+	usage, isBlobMounted, err := getRepoStorageUsage(tx, repo, blobDigest)
+	if err != nil || isBlobMounted {
+		return err
+	}
+	reservedBytes, err := tx.SelectInt(storageQuotaReservationsQuery, repo.ID, storageID, now)
+	if err != nil {
+		return err
+	}
+	usage += AtLeastZero(reservedBytes)
+
+	quota := *repo.StorageQuotaBytes
+	if usage+blobSizeBytes > quota {
+		return ErrDenied.With("storage quota of repository %q exceeded (quota = %d bytes, usage = %d bytes, blob size = %d bytes)",
+			repo.FullName(), quota, usage, blobSizeBytes,
+		).WithStatus(http.StatusConflict).WithDetail(QuotaExceededDetail(quota, usage))
+	}
+	return nil
+}
+
+// ReserveStorageQuota reserves space in the given repo's storage quota for a
+// blob upload that has not been finalized yet. This ensures that concurrent
+// uploads cannot exceed the storage quota together even though each of them
+// fits into the quota on its own. If the repo has no storage quota, nothing
+// is reserved.
+//
+// The reservation is identified by the storage ID of the upload. Calling this
+// again with the same storage ID replaces the previous reservation, e.g. to
+// grow it when another chunk is uploaded. Once the blob is mounted into the
+// repo, or when the upload is aborted, the reservation must be released with
+// ReleaseStorageQuotaReservation.
+func ReserveStorageQuota(db *DB, repo models.Repository, storageID string, sizeBytes uint64, now time.Time) error {
+	if repo.StorageQuotaBytes == nil {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	// the blob digest is not known yet, so the upload is always counted as a new blob
+	err = CheckStorageQuotaForBlobMount(tx, repo, "", sizeBytes, storageID, now)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(cleanupStorageQuotaReservationsQuery, repo.ID, now)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(upsertStorageQuotaReservationQuery, repo.ID, storageID, sizeBytes, now.Add(StorageQuotaReservationLifetime))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReleaseStorageQuotaReservation removes a reservation that was created by
+// ReserveStorageQuota. When the blob is mounted into the repo, this should be
+// done within the same transaction, so that the reserved space is accounted
+// for by the blob mount instead without a gap in between.
+func ReleaseStorageQuotaReservation(db gorp.SqlExecutor, repoID int64, storageID string) error {
+	_, err := db.Exec(`DELETE FROM storage_quota_reservations WHERE repo_id = $1 AND storage_id = $2`, repoID, storageID)
+	return err
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// MaxResponseHeaders is the largest number of custom response headers that
+// can be configured for an account.
+const MaxResponseHeaders = 10
+
+// Custom response headers may not override any headers that are relevant to
+// the Registry API protocol, so only "X-" headers are allowed.
+var responseHeaderNameRx = regexp.MustCompile(`^X-[A-Za-z0-9-]+$`)
+
+// ParseResponseHeaders parses the custom response headers of the given account.
+func ParseResponseHeaders(account models.ReducedAccount) (map[string]string, error) {
+	if account.ResponseHeadersJSON == "" {
+		return nil, nil
+	}
+	var headers map[string]string
+	err := json.Unmarshal([]byte(account.ResponseHeadersJSON), &headers)
+	return headers, err
+}
+
+// ApplyResponseHeadersToAccount validates the given custom response headers
+// and stores them in the given account model.
+func ApplyResponseHeadersToAccount(headers map[string]string, account *models.Account) *RegistryV2Error {
+	if len(headers) == 0 {
+		account.ResponseHeadersJSON = ""
+		return nil
+	}
+	if len(headers) > MaxResponseHeaders {
+		err := fmt.Errorf("cannot configure more than %d response headers", MaxResponseHeaders)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+
+	canonicalHeaders := make(map[string]string, len(headers))
+	for name, value := range headers {
+		canonicalName := http.CanonicalHeaderKey(name)
+		if !responseHeaderNameRx.MatchString(canonicalName) || strings.HasPrefix(canonicalName, "X-Keppel-") {
+			err := fmt.Errorf("invalid response header name: %q (must start with \"X-\", but not with \"X-Keppel-\")", name)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+		if _, exists := canonicalHeaders[canonicalName]; exists {
+			err := fmt.Errorf("duplicate response header name: %q", canonicalName)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+		if value == "" || strings.ContainsAny(value, "\r\n\x00") {
+			err := fmt.Errorf("invalid value for response header %q", canonicalName)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+		canonicalHeaders[canonicalName] = value
+	}
+
+	buf, err := json.Marshal(canonicalHeaders)
+	if err != nil {
+		return AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+	}
+	account.ResponseHeadersJSON = string(buf)
+	return nil
+}
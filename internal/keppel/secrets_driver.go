@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/pluggable"
+)
+
+// SecretsDriver is the abstract interface for an external secret store.
+// Credentials that are configured by users (e.g. upstream passwords for
+// external replica accounts) can be given as references into this secret
+// store, in which case Keppel only stores the reference in its database and
+// reads the actual secret when it is needed.
+type SecretsDriver interface {
+	pluggable.Plugin
+	// Init is called before any other interface methods, and allows the plugin to
+	// perform first-time initialization.
+	Init(context.Context, Configuration) error
+
+	// ReadSecret returns the value of the secret identified by the given
+	// reference. The format of the reference is specific to each driver.
+	//
+	// References are supplied by users of the given auth tenant. Drivers must
+	// only resolve references within the namespace that the operator has
+	// reserved for this auth tenant, and must never resolve references to
+	// secrets of other tenants or of the operator itself.
+	ReadSecret(ctx context.Context, authTenantID, ref string) (string, error)
+}
+
+// ErrNoSecretsDriver is returned by SecretsDriver.ReadSecret() when secret
+// references are not supported because no actual secret store is configured.
+var ErrNoSecretsDriver = errors.New("secret references are not supported by this Keppel instance")
+
+// ResolveSecretRef calls SecretsDriver.ReadSecret() for a reference that was
+// supplied by a user in the given field of an API request. If the reference
+// cannot be resolved, the detailed error is logged, but the returned error
+// does not contain it, since it might reveal information about which secrets
+// exist in the secret store.
+func ResolveSecretRef(ctx context.Context, secd SecretsDriver, authTenantID, fieldName, ref string) (string, *RegistryV2Error) {
+	secret, err := secd.ReadSecret(ctx, authTenantID, ref)
+	if err == nil {
+		return secret, nil
+	}
+	if errors.Is(err, ErrNoSecretsDriver) {
+		return "", AsRegistryV2Error(fmt.Errorf("cannot resolve %s %q: %w", fieldName, ref, err))
+	}
+	logg.Error("cannot resolve %s %q for auth tenant %q: %s", fieldName, ref, authTenantID, err.Error())
+	return "", ErrUnknown.With("cannot resolve %s %q: no such secret, or access denied", fieldName, ref)
+}
+
+// SecretsDriverRegistry is a pluggable.Registry for SecretsDriver implementations.
+var SecretsDriverRegistry pluggable.Registry[SecretsDriver]
+
+// NewSecretsDriver creates a new SecretsDriver using one of the plugins
+// registered with SecretsDriverRegistry.
+func NewSecretsDriver(ctx context.Context, pluginTypeID string, cfg Configuration) (SecretsDriver, error) {
+	logg.Debug("initializing secrets driver %q...", pluginTypeID)
+
+	secd := SecretsDriverRegistry.Instantiate(pluginTypeID)
+	if secd == nil {
+		return nil, errors.New("no such secrets driver: " + pluginTypeID)
+	}
+	return secd, secd.Init(ctx, cfg)
+}
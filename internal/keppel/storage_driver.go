@@ -8,6 +8,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/opencontainers/go-digest"
@@ -50,6 +51,11 @@ type StorageDriver interface {
 	AbortBlobUpload(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error
 
 	ReadBlob(ctx context.Context, account models.ReducedAccount, storageID string) (contents io.ReadCloser, sizeBytes uint64, err error)
+	// ReadBlobRange is like ReadBlob(), but only yields `length` bytes of the
+	// blob, starting at `offset`. This is used to serve range requests. The
+	// caller guarantees that `length` is not zero and that the range lies
+	// entirely within the blob.
+	ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (contents io.ReadCloser, err error)
 	// If the blob can be retrieved by a publicly accessible URL, URLForBlob shall
 	// return it. Otherwise ErrCannotGenerateURL shall be returned to instruct the
 	// caller fall back to ReadBlob().
@@ -96,6 +102,81 @@ type StoredManifestInfo struct {
 	Digest   digest.Digest
 }
 
+// ChunkSizeLimits describes the chunk sizes that a StorageDriver can handle
+// for chunked blob uploads. Zero values mean "no limit". These limits are
+// advertised to clients when an upload is started (see
+// StorageDriverWithChunkSizeLimits).
+type ChunkSizeLimits struct {
+	// MinBytes applies to all chunks except for the last one.
+	MinBytes uint64
+	MaxBytes uint64
+}
+
+// StorageDriverWithChunkSizeLimits is an optional interface for StorageDriver
+// implementations that cannot handle chunked uploads with arbitrary chunk
+// sizes, e.g. because the backend only supports a limited number of segments
+// per object.
+type StorageDriverWithChunkSizeLimits interface {
+	StorageDriver
+	ChunkSizeLimits() ChunkSizeLimits
+}
+
+// GetChunkSizeLimits returns the ChunkSizeLimits of the given StorageDriver,
+// or an empty set of limits if it does not implement
+// StorageDriverWithChunkSizeLimits.
+func GetChunkSizeLimits(sd StorageDriver) ChunkSizeLimits {
+	if sdl, ok := sd.(StorageDriverWithChunkSizeLimits); ok {
+		return sdl.ChunkSizeLimits()
+	}
+	return ChunkSizeLimits{}
+}
+
+// StoredSegmentInfo is returned by StorageDriverWithSegments.ListOrphanedSegments().
+type StoredSegmentInfo struct {
+	StorageID   string
+	ChunkNumber uint32
+	SizeBytes   uint64
+}
+
+// StorageDriverWithSegments is an optional interface for StorageDriver
+// implementations that store each chunk of a blob upload as a separate object
+// (a "segment") in the backing storage, and assemble finalized blobs from
+// these segments.
+//
+// When a chunked upload crashes after a segment has been written, but before
+// the upload has been updated in the database, the finalized blob may not
+// reference all segments that exist for its storage ID. Since such orphaned
+// segments share the storage ID of a valid blob, they are invisible to the
+// storage sweep.
+type StorageDriverWithSegments interface {
+	StorageDriver
+	// ListOrphanedSegments returns all segments that belong to finalized blobs,
+	// but are not referenced by them. Segments of uploads that have not been
+	// finalized yet are never reported.
+	ListOrphanedSegments(ctx context.Context, account models.ReducedAccount) ([]StoredSegmentInfo, error)
+	// DeleteSegment deletes a segment that was reported by ListOrphanedSegments().
+	DeleteSegment(ctx context.Context, account models.ReducedAccount, segment StoredSegmentInfo) error
+}
+
+// ListOrphanedSegments calls StorageDriverWithSegments.ListOrphanedSegments()
+// if the given StorageDriver implements that interface, or returns an empty
+// list otherwise.
+func ListOrphanedSegments(ctx context.Context, sd StorageDriver, account models.ReducedAccount) ([]StoredSegmentInfo, error) {
+	if sds, ok := sd.(StorageDriverWithSegments); ok {
+		return sds.ListOrphanedSegments(ctx, account)
+	}
+	return nil, nil
+}
+
+// DeleteSegment calls StorageDriverWithSegments.DeleteSegment() if the given
+// StorageDriver implements that interface, or fails otherwise.
+func DeleteSegment(ctx context.Context, sd StorageDriver, account models.ReducedAccount, segment StoredSegmentInfo) error {
+	if sds, ok := sd.(StorageDriverWithSegments); ok {
+		return sds.DeleteSegment(ctx, account, segment)
+	}
+	return fmt.Errorf("storage driver %q does not store segments", sd.PluginTypeID())
+}
+
 // ErrAuthDriverMismatch is returned by Init() methods on most driver
 // interfaces, to indicate that the driver in question does not work with the
 // selected AuthDriver.
@@ -105,6 +186,13 @@ var ErrAuthDriverMismatch = errors.New("given AuthDriver is not supported by thi
 // StorageDriver does not support blob URLs.
 var ErrCannotGenerateURL = errors.New("URLForBlob() is not supported")
 
+// ErrStorageQuotaExceeded is returned by StorageDriver methods that write
+// contents when the backing storage rejected the write because of a quota or
+// because it ran out of space. Drivers shall wrap this error, e.g.
+// fmt.Errorf("%w: %s", keppel.ErrStorageQuotaExceeded, err.Error()), to
+// retain the original error message for the user.
+var ErrStorageQuotaExceeded = errors.New("quota exceeded in storage backend")
+
 // StorageDriverRegistry is a pluggable.Registry for StorageDriver implementations.
 var StorageDriverRegistry pluggable.Registry[StorageDriver]
 
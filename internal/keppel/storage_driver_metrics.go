@@ -0,0 +1,306 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var (
+	// StorageDriverOperationDurationHistogram is a prometheus.HistogramVec.
+	StorageDriverOperationDurationHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "keppel_storage_driver_operation_duration_seconds",
+			Help: "Duration of calls into the storage driver. For ReadBlob, this only covers the time until the blob contents start streaming.",
+			// object storage backends usually answer within tens of milliseconds,
+			// but large chunk uploads or degraded backends can take much longer
+			Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		},
+		// no "account" label here: multiplied by the buckets, that would result in too many timeseries
+		[]string{"driver", "operation", "result"},
+	)
+	// StorageDriverBytesCounter is a prometheus.CounterVec.
+	StorageDriverBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_storage_driver_bytes",
+			Help: "Counts bytes transferred to or from the storage driver by calls that move blob or manifest contents.",
+		},
+		[]string{"driver", "operation", "account"},
+	)
+	// StorageDriverQuotaErrorCounter is a prometheus.CounterVec.
+	StorageDriverQuotaErrorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_storage_driver_quota_errors",
+			Help: "Counts calls into the storage driver that failed because the storage backend reported that a quota was exceeded or that it ran out of space.",
+		},
+		[]string{"driver", "operation", "account"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(StorageDriverOperationDurationHistogram)
+	prometheus.MustRegister(StorageDriverBytesCounter)
+	prometheus.MustRegister(StorageDriverQuotaErrorCounter)
+}
+
+// InstrumentStorageDriver wraps the given StorageDriver such that each call
+// into it is recorded in StorageDriverOperationDurationHistogram and, if
+// contents are transferred, StorageDriverBytesCounter. Errors wrapping
+// ErrStorageQuotaExceeded are also counted in StorageDriverQuotaErrorCounter.
+//
+// The optional interfaces StorageDriverWithChunkSizeLimits,
+// StorageDriverWithSegments and MultiBackendStorageDriver are passed through.
+func InstrumentStorageDriver(sd StorageDriver) StorageDriver {
+	isd := instrumentedStorageDriver{sd}
+	if msd, ok := sd.(MultiBackendStorageDriver); ok {
+		return instrumentedMultiBackendStorageDriver{isd, msd}
+	}
+	return isd
+}
+
+type instrumentedStorageDriver struct {
+	inner StorageDriver
+}
+
+type instrumentedMultiBackendStorageDriver struct {
+	instrumentedStorageDriver
+	inner MultiBackendStorageDriver
+}
+
+// BackendNames implements the MultiBackendStorageDriver interface.
+func (d instrumentedMultiBackendStorageDriver) BackendNames() []string {
+	return d.inner.BackendNames()
+}
+
+// Records a completed operation. For operations that do not move contents, bytes is 0.
+func (d instrumentedStorageDriver) observe(operation string, account models.ReducedAccount, startedAt time.Time, bytes uint64, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	StorageDriverOperationDurationHistogram.With(prometheus.Labels{
+		"driver":    d.inner.PluginTypeID(),
+		"operation": operation,
+		"result":    result,
+	}).Observe(time.Since(startedAt).Seconds())
+	d.observeBytes(operation, account, bytes)
+
+	if errors.Is(err, ErrStorageQuotaExceeded) {
+		StorageDriverQuotaErrorCounter.With(prometheus.Labels{
+			"driver":    d.inner.PluginTypeID(),
+			"operation": operation,
+			"account":   string(account.Name),
+		}).Inc()
+	}
+}
+
+func (d instrumentedStorageDriver) observeBytes(operation string, account models.ReducedAccount, bytes uint64) {
+	if bytes == 0 {
+		return
+	}
+	StorageDriverBytesCounter.With(prometheus.Labels{
+		"driver":    d.inner.PluginTypeID(),
+		"operation": operation,
+		"account":   string(account.Name),
+	}).Add(float64(bytes))
+}
+
+// PluginTypeID implements the StorageDriver interface.
+func (d instrumentedStorageDriver) PluginTypeID() string {
+	return d.inner.PluginTypeID()
+}
+
+// Init implements the StorageDriver interface.
+func (d instrumentedStorageDriver) Init(ad AuthDriver, cfg Configuration) error {
+	return d.inner.Init(ad, cfg)
+}
+
+// ChunkSizeLimits implements the StorageDriverWithChunkSizeLimits interface.
+func (d instrumentedStorageDriver) ChunkSizeLimits() ChunkSizeLimits {
+	return GetChunkSizeLimits(d.inner)
+}
+
+// ListOrphanedSegments implements the StorageDriverWithSegments interface.
+func (d instrumentedStorageDriver) ListOrphanedSegments(ctx context.Context, account models.ReducedAccount) ([]StoredSegmentInfo, error) {
+	startedAt := time.Now()
+	segments, err := ListOrphanedSegments(ctx, d.inner, account)
+	d.observe("ListOrphanedSegments", account, startedAt, 0, err)
+	return segments, err
+}
+
+// DeleteSegment implements the StorageDriverWithSegments interface.
+func (d instrumentedStorageDriver) DeleteSegment(ctx context.Context, account models.ReducedAccount, segment StoredSegmentInfo) error {
+	startedAt := time.Now()
+	err := DeleteSegment(ctx, d.inner, account, segment)
+	d.observe("DeleteSegment", account, startedAt, 0, err)
+	return err
+}
+
+// AppendToBlob implements the StorageDriver interface.
+func (d instrumentedStorageDriver) AppendToBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkNumber uint32, chunkLength *uint64, chunk io.Reader) error {
+	startedAt := time.Now()
+	cr := &countingReader{Reader: chunk}
+	err := d.inner.AppendToBlob(ctx, account, storageID, chunkNumber, chunkLength, cr)
+	d.observe("AppendToBlob", account, startedAt, cr.BytesRead, err)
+	return err
+}
+
+// FinalizeBlob implements the StorageDriver interface.
+func (d instrumentedStorageDriver) FinalizeBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error {
+	startedAt := time.Now()
+	err := d.inner.FinalizeBlob(ctx, account, storageID, chunkCount)
+	d.observe("FinalizeBlob", account, startedAt, 0, err)
+	return err
+}
+
+// AbortBlobUpload implements the StorageDriver interface.
+func (d instrumentedStorageDriver) AbortBlobUpload(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error {
+	startedAt := time.Now()
+	err := d.inner.AbortBlobUpload(ctx, account, storageID, chunkCount)
+	d.observe("AbortBlobUpload", account, startedAt, 0, err)
+	return err
+}
+
+// ReadBlob implements the StorageDriver interface.
+func (d instrumentedStorageDriver) ReadBlob(ctx context.Context, account models.ReducedAccount, storageID string) (io.ReadCloser, uint64, error) {
+	startedAt := time.Now()
+	contents, sizeBytes, err := d.inner.ReadBlob(ctx, account, storageID)
+	d.observe("ReadBlob", account, startedAt, 0, err)
+	if err != nil {
+		return contents, sizeBytes, err
+	}
+	// bytes are counted as they are streamed to the caller
+	return &countingReadCloser{
+		countingReader: countingReader{Reader: contents},
+		closer:         contents,
+		onClose: func(bytesRead uint64) {
+			d.observeBytes("ReadBlob", account, bytesRead)
+		},
+	}, sizeBytes, nil
+}
+
+// ReadBlobRange implements the StorageDriver interface.
+func (d instrumentedStorageDriver) ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (io.ReadCloser, error) {
+	startedAt := time.Now()
+	contents, err := d.inner.ReadBlobRange(ctx, account, storageID, offset, length)
+	d.observe("ReadBlobRange", account, startedAt, 0, err)
+	if err != nil {
+		return contents, err
+	}
+	// bytes are counted as they are streamed to the caller
+	return &countingReadCloser{
+		countingReader: countingReader{Reader: contents},
+		closer:         contents,
+		onClose: func(bytesRead uint64) {
+			d.observeBytes("ReadBlobRange", account, bytesRead)
+		},
+	}, nil
+}
+
+// URLForBlob implements the StorageDriver interface.
+func (d instrumentedStorageDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
+	startedAt := time.Now()
+	url, err := d.inner.URLForBlob(ctx, account, storageID)
+	if errors.Is(err, ErrCannotGenerateURL) {
+		// not a failure, just an unsupported feature; no need to record this
+		return url, err
+	}
+	d.observe("URLForBlob", account, startedAt, 0, err)
+	return url, err
+}
+
+// DeleteBlob implements the StorageDriver interface.
+func (d instrumentedStorageDriver) DeleteBlob(ctx context.Context, account models.ReducedAccount, storageID string) error {
+	startedAt := time.Now()
+	err := d.inner.DeleteBlob(ctx, account, storageID)
+	d.observe("DeleteBlob", account, startedAt, 0, err)
+	return err
+}
+
+// ReadManifest implements the StorageDriver interface.
+func (d instrumentedStorageDriver) ReadManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) ([]byte, error) {
+	startedAt := time.Now()
+	contents, err := d.inner.ReadManifest(ctx, account, repoName, manifestDigest)
+	d.observe("ReadManifest", account, startedAt, uint64(len(contents)), err)
+	return contents, err
+}
+
+// WriteManifest implements the StorageDriver interface.
+func (d instrumentedStorageDriver) WriteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest, contents []byte) error {
+	startedAt := time.Now()
+	err := d.inner.WriteManifest(ctx, account, repoName, manifestDigest, contents)
+	var bytes uint64
+	if err == nil {
+		bytes = uint64(len(contents))
+	}
+	d.observe("WriteManifest", account, startedAt, bytes, err)
+	return err
+}
+
+// DeleteManifest implements the StorageDriver interface.
+func (d instrumentedStorageDriver) DeleteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) error {
+	startedAt := time.Now()
+	err := d.inner.DeleteManifest(ctx, account, repoName, manifestDigest)
+	d.observe("DeleteManifest", account, startedAt, 0, err)
+	return err
+}
+
+// ListStorageContents implements the StorageDriver interface.
+func (d instrumentedStorageDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount) ([]StoredBlobInfo, []StoredManifestInfo, error) {
+	startedAt := time.Now()
+	blobs, manifests, err := d.inner.ListStorageContents(ctx, account)
+	d.observe("ListStorageContents", account, startedAt, 0, err)
+	return blobs, manifests, err
+}
+
+// CanSetupAccount implements the StorageDriver interface.
+func (d instrumentedStorageDriver) CanSetupAccount(ctx context.Context, account models.ReducedAccount) error {
+	startedAt := time.Now()
+	err := d.inner.CanSetupAccount(ctx, account)
+	d.observe("CanSetupAccount", account, startedAt, 0, err)
+	return err
+}
+
+// CleanupAccount implements the StorageDriver interface.
+func (d instrumentedStorageDriver) CleanupAccount(ctx context.Context, account models.ReducedAccount) error {
+	startedAt := time.Now()
+	err := d.inner.CleanupAccount(ctx, account)
+	d.observe("CleanupAccount", account, startedAt, 0, err)
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// helper types
+
+type countingReader struct {
+	io.Reader
+	BytesRead uint64
+}
+
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.Reader.Read(buf)
+	r.BytesRead += uint64(max(n, 0))
+	return n, err
+}
+
+type countingReadCloser struct {
+	countingReader
+	closer    io.Closer
+	onClose   func(bytesRead uint64)
+	closeOnce sync.Once
+}
+
+func (r *countingReadCloser) Close() error {
+	r.closeOnce.Do(func() { r.onClose(r.BytesRead) })
+	return r.closer.Close()
+}
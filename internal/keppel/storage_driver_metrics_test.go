@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// Implements only those StorageDriver methods that are called by the test below.
+type metricsTestStorageDriver struct {
+	StorageDriver
+	Blobs map[string][]byte
+}
+
+func (d metricsTestStorageDriver) PluginTypeID() string { return "metrics-test" }
+
+func (d metricsTestStorageDriver) AppendToBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkNumber uint32, chunkLength *uint64, chunk io.Reader) error {
+	buf, err := io.ReadAll(chunk)
+	d.Blobs[storageID] = buf
+	return err
+}
+
+func (d metricsTestStorageDriver) ReadBlob(ctx context.Context, account models.ReducedAccount, storageID string) (io.ReadCloser, uint64, error) {
+	buf, exists := d.Blobs[storageID]
+	if !exists {
+		return nil, 0, errors.New("no such blob")
+	}
+	return io.NopCloser(bytes.NewReader(buf)), uint64(len(buf)), nil
+}
+
+func (d metricsTestStorageDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
+	return "", ErrCannotGenerateURL
+}
+
+func (d metricsTestStorageDriver) WriteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest, contents []byte) error {
+	return nil
+}
+
+func TestInstrumentStorageDriver(t *testing.T) {
+	ctx := context.Background()
+	sd := InstrumentStorageDriver(metricsTestStorageDriver{Blobs: make(map[string][]byte)})
+	account := models.ReducedAccount{Name: "metrics-test-account"}
+
+	// optional interfaces are only passed through if the inner driver implements them
+	if _, ok := sd.(MultiBackendStorageDriver); ok {
+		t.Error("expected instrumented driver to not implement MultiBackendStorageDriver")
+	}
+
+	err := sd.AppendToBlob(ctx, account, "blob1", 1, nil, strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	reader, _, err := sd.ReadBlob(ctx, account, "blob1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = reader.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, _, err = sd.ReadBlob(ctx, account, "blob2")
+	if err == nil {
+		t.Error("expected ReadBlob on missing blob to fail")
+	}
+	_, err = sd.URLForBlob(ctx, account, "blob1")
+	if !errors.Is(err, ErrCannotGenerateURL) {
+		t.Errorf("expected ErrCannotGenerateURL, but got %v", err)
+	}
+	err = sd.WriteManifest(ctx, account, "foo", digest.FromString("manifest"), []byte("manifest"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// check the resulting metrics
+	rec := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	var metrics []string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.Contains(line, `driver="metrics-test"`) && (strings.Contains(line, "_count{") || strings.HasPrefix(line, "keppel_storage_driver_bytes{")) {
+			metrics = append(metrics, line)
+		}
+	}
+	expected := []string{
+		`keppel_storage_driver_bytes{account="metrics-test-account",driver="metrics-test",operation="AppendToBlob"} 11`,
+		`keppel_storage_driver_bytes{account="metrics-test-account",driver="metrics-test",operation="ReadBlob"} 11`,
+		`keppel_storage_driver_bytes{account="metrics-test-account",driver="metrics-test",operation="WriteManifest"} 8`,
+		`keppel_storage_driver_operation_duration_seconds_count{driver="metrics-test",operation="AppendToBlob",result="success"} 1`,
+		`keppel_storage_driver_operation_duration_seconds_count{driver="metrics-test",operation="ReadBlob",result="failure"} 1`,
+		`keppel_storage_driver_operation_duration_seconds_count{driver="metrics-test",operation="ReadBlob",result="success"} 1`,
+		`keppel_storage_driver_operation_duration_seconds_count{driver="metrics-test",operation="WriteManifest",result="success"} 1`,
+	}
+	if strings.Join(metrics, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected metrics:\n%s\nbut got:\n%s", strings.Join(expected, "\n"), strings.Join(metrics, "\n"))
+	}
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/regexpext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// MultiBackendStorageDriver is an optional interface for StorageDriver
+// implementations that distribute blobs across several storage backends.
+// Accounts can only have storage placement rules if the StorageDriver
+// implements this interface.
+//
+// Blobs in backends other than the default backend have storage IDs of the
+// form "<backend>:<id>" (see StorageIDForNewBlob). Since storage IDs are
+// recorded in the blobs table, the blob record tracks which backend it is
+// stored in.
+type MultiBackendStorageDriver interface {
+	StorageDriver
+	// BackendNames returns the names of all storage backends. The first one is
+	// the default backend.
+	BackendNames() []string
+}
+
+// StoragePlacementRule decides which storage backend new blobs go into. It is
+// stored in serialized form in the StoragePlacementJSON field of type Account.
+//
+// The first rule that matches a new blob applies. If no rule matches, the
+// blob goes into the default backend.
+type StoragePlacementRule struct {
+	RepositoryRx regexpext.BoundedRegexp `json:"match_repository,omitempty"`
+	MinSizeBytes uint64                  `json:"min_size_bytes,omitempty"`
+	Backend      string                  `json:"backend"`
+}
+
+// Matches checks whether this rule applies to a blob of the given size that
+// is uploaded into the given repo. For chunked uploads, `sizeBytes` is only a
+// lower bound (the size of the first chunk). If nothing is known about the
+// size, rules with a MinSizeBytes never match.
+func (r StoragePlacementRule) Matches(repoName string, sizeBytes *uint64) bool {
+	if r.RepositoryRx != "" && !r.RepositoryRx.MatchString(repoName) {
+		return false
+	}
+	if r.MinSizeBytes > 0 && (sizeBytes == nil || *sizeBytes < r.MinSizeBytes) {
+		return false
+	}
+	return true
+}
+
+// ParseStoragePlacementRules parses the storage placement rules of the given account.
+func ParseStoragePlacementRules(account models.ReducedAccount) ([]StoragePlacementRule, error) {
+	if account.StoragePlacementJSON == "" {
+		return nil, nil
+	}
+	var rules []StoragePlacementRule
+	err := json.Unmarshal([]byte(account.StoragePlacementJSON), &rules)
+	return rules, err
+}
+
+// ApplyStoragePlacementRulesToAccount validates the given storage placement
+// rules against the backends offered by the given StorageDriver, and stores
+// them in the given account model.
+func ApplyStoragePlacementRulesToAccount(rules []StoragePlacementRule, sd StorageDriver, account *models.Account) *RegistryV2Error {
+	if len(rules) == 0 {
+		account.StoragePlacementJSON = ""
+		return nil
+	}
+	msd, ok := sd.(MultiBackendStorageDriver)
+	if !ok {
+		err := fmt.Errorf("storage placement rules are not supported by the %q storage driver", sd.PluginTypeID())
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+	backendNames := msd.BackendNames()
+	for idx, rule := range rules {
+		if !slices.Contains(backendNames, rule.Backend) {
+			err := fmt.Errorf("storage placement rule %d refers to unknown backend %q (valid backends are: %s)",
+				idx+1, rule.Backend, strings.Join(backendNames, ", "))
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+	}
+
+	buf, err := json.Marshal(rules)
+	if err != nil {
+		return AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+	}
+	account.StoragePlacementJSON = string(buf)
+	return nil
+}
+
+// StorageIDForNewBlob takes a freshly generated storage ID (see
+// GenerateStorageID) and prefixes it with the storage backend that the
+// account's storage placement rules choose for a new blob in the given repo.
+// `sizeBytes` shall be given if the size of the blob, or a lower bound for it,
+// is known upfront.
+//
+// The storage ID is returned unchanged if the blob goes into the default
+// backend, or if the StorageDriver only has one backend.
+func StorageIDForNewBlob(sd StorageDriver, account models.ReducedAccount, repoName string, sizeBytes *uint64, storageID string) string {
+	msd, ok := sd.(MultiBackendStorageDriver)
+	if !ok || account.StoragePlacementJSON == "" {
+		return storageID
+	}
+	rules, err := ParseStoragePlacementRules(account)
+	if err != nil {
+		// this should have been caught during validation, so do not fail the upload over it
+		logg.Error("cannot parse storage placement rules of account %q: %s", account.Name, err.Error())
+		return storageID
+	}
+
+	backendNames := msd.BackendNames()
+	for _, rule := range rules {
+		if !rule.Matches(repoName, sizeBytes) {
+			continue
+		}
+		// if the backend was removed from the configuration, fall back to the default backend
+		if rule.Backend == backendNames[0] || !slices.Contains(backendNames, rule.Backend) {
+			return storageID
+		}
+		return rule.Backend + ":" + storageID
+	}
+	return storageID
+}
+
+// SplitStorageID is the reverse of StorageIDForNewBlob. It returns an empty
+// backend name for storage IDs of blobs in the default backend.
+func SplitStorageID(storageID string) (backend, id string) {
+	backend, id, ok := strings.Cut(storageID, ":")
+	if !ok {
+		return "", storageID
+	}
+	return backend, id
+}
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+type fakeMultiBackendStorageDriver struct {
+	StorageDriver // only PluginTypeID() and BackendNames() are called in this test
+}
+
+func (fakeMultiBackendStorageDriver) PluginTypeID() string { return "multi" }
+
+func (fakeMultiBackendStorageDriver) BackendNames() []string {
+	return []string{"primary", "large", "archive"}
+}
+
+type fakeSingleBackendStorageDriver struct {
+	StorageDriver
+}
+
+func (fakeSingleBackendStorageDriver) PluginTypeID() string { return "single" }
+
+func TestStoragePlacement(t *testing.T) {
+	rules := []StoragePlacementRule{
+		{RepositoryRx: "archive/.*", Backend: "archive"},
+		{RepositoryRx: "important/.*", Backend: "primary"},
+		{MinSizeBytes: 1 << 30, Backend: "large"},
+	}
+	var dbAccount models.Account
+	rerr := ApplyStoragePlacementRulesToAccount(rules, fakeMultiBackendStorageDriver{}, &dbAccount)
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	parsedRules, err := ParseStoragePlacementRules(dbAccount.Reduced())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "parsed rules", parsedRules, rules)
+
+	sd := fakeMultiBackendStorageDriver{}
+	account := dbAccount.Reduced()
+	small := uint64(1 << 20)
+	large := uint64(2 << 30)
+	testCases := []struct {
+		RepoName          string
+		SizeBytes         *uint64
+		ExpectedStorageID string
+	}{
+		{"archive/old", &large, "archive:abc"},
+		{"archive/old", nil, "archive:abc"},
+		{"important/app", &large, "abc"}, // default backend does not get a prefix
+		{"other", &large, "large:abc"},
+		{"other", &small, "abc"},
+		{"other", nil, "abc"}, // unknown size never matches size rules
+	}
+	for _, tc := range testCases {
+		storageID := StorageIDForNewBlob(sd, account, tc.RepoName, tc.SizeBytes, "abc")
+		assert.DeepEqual(t, "storage ID for "+tc.RepoName, storageID, tc.ExpectedStorageID)
+
+		backend, id := SplitStorageID(storageID)
+		assert.DeepEqual(t, "ID part of "+storageID, id, "abc")
+		if storageID == "abc" {
+			assert.DeepEqual(t, "backend part of "+storageID, backend, "")
+		}
+	}
+
+	// rules are ignored if the storage driver does not support them
+	assert.DeepEqual(t, "storage ID on single backend",
+		StorageIDForNewBlob(fakeSingleBackendStorageDriver{}, account, "archive/old", nil, "abc"), "abc")
+
+	// validation errors
+	rerr = ApplyStoragePlacementRulesToAccount(rules, fakeSingleBackendStorageDriver{}, &dbAccount)
+	assert.DeepEqual(t, "error on single backend", rerr.Error(),
+		`storage placement rules are not supported by the "single" storage driver`)
+	rerr = ApplyStoragePlacementRulesToAccount([]StoragePlacementRule{{Backend: "tape"}}, sd, &dbAccount)
+	assert.DeepEqual(t, "error on unknown backend", rerr.Error(),
+		`storage placement rule 1 refers to unknown backend "tape" (valid backends are: primary, large, archive)`)
+
+	// empty rules are always accepted
+	rerr = ApplyStoragePlacementRulesToAccount(nil, fakeSingleBackendStorageDriver{}, &dbAccount)
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	assert.DeepEqual(t, "StoragePlacementJSON", dbAccount.StoragePlacementJSON, "")
+}
@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/sapcc/go-bits/regexpext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// TagProtectionPolicy prevents the deletion of matching tags by users that do
+// not have permission to change the account. It is stored in serialized form
+// in the TagProtectionPoliciesJSON field of type Account.
+//
+// If Immutable is set, matching tags can neither be deleted (not even by
+// users that may change the account) nor moved to a different manifest by
+// pushing.
+//
+// Unlike GC policies, tag protection policies are only enforced on deletions
+// requested through the API. Manifests with protected tags can still be
+// deleted by GC policies, since those are configured by account admins.
+type TagProtectionPolicy struct {
+	RepositoryRx         regexpext.BoundedRegexp `json:"match_repository,omitempty"`
+	NegativeRepositoryRx regexpext.BoundedRegexp `json:"except_repository,omitempty"`
+	TagRx                regexpext.BoundedRegexp `json:"match_tag"`
+	NegativeTagRx        regexpext.BoundedRegexp `json:"except_tag,omitempty"`
+	Immutable            bool                    `json:"immutable,omitempty"`
+}
+
+// Matches checks whether this policy protects the given tag in the given repo.
+func (p TagProtectionPolicy) Matches(repoName, tagName string) bool {
+	//NOTE: The negative regexes take precedence and are thus evaluated first.
+	if p.NegativeRepositoryRx != "" && p.NegativeRepositoryRx.MatchString(repoName) {
+		return false
+	}
+	if p.RepositoryRx != "" && !p.RepositoryRx.MatchString(repoName) {
+		return false
+	}
+	if p.NegativeTagRx != "" && p.NegativeTagRx.MatchString(tagName) {
+		return false
+	}
+	return p.TagRx.MatchString(tagName)
+}
+
+// ParseTagProtectionPolicies parses the tag protection policies of the given account.
+func ParseTagProtectionPolicies(account models.ReducedAccount) ([]TagProtectionPolicy, error) {
+	if account.TagProtectionPoliciesJSON == "" {
+		return nil, nil
+	}
+	var policies []TagProtectionPolicy
+	err := json.Unmarshal([]byte(account.TagProtectionPoliciesJSON), &policies)
+	return policies, err
+}
+
+// ApplyTagProtectionPoliciesToAccount validates the given tag protection
+// policies and stores them in the given account model.
+func ApplyTagProtectionPoliciesToAccount(policies []TagProtectionPolicy, account *models.Account) *RegistryV2Error {
+	if len(policies) == 0 {
+		account.TagProtectionPoliciesJSON = ""
+		return nil
+	}
+	for _, policy := range policies {
+		if policy.TagRx == "" {
+			err := errors.New(`tag protection policy must have the "match_tag" attribute`)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+	}
+
+	buf, err := json.Marshal(policies)
+	if err != nil {
+		return AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+	}
+	account.TagProtectionPoliciesJSON = string(buf)
+	return nil
+}
+
+// CheckTagDeletionAllowed returns an error if any of the given tags in the
+// given repo is protected by one of the account's tag protection policies.
+// Tags protected by non-immutable policies may still be deleted by users that
+// are allowed to change the account.
+func CheckTagDeletionAllowed(account models.ReducedAccount, repoName string, tagNames []string, uid UserIdentity) error {
+	if account.TagProtectionPoliciesJSON == "" || len(tagNames) == 0 {
+		return nil
+	}
+	policies, err := ParseTagProtectionPolicies(account)
+	if err != nil {
+		return err
+	}
+	isAccountAdmin := uid.HasPermission(CanChangeAccount, account.AuthTenantID) || uid.HasPermission(CanAdministrateKeppel, "")
+
+	for _, tagName := range tagNames {
+		for _, policy := range policies {
+			if !policy.Matches(repoName, tagName) {
+				continue
+			}
+			if policy.Immutable {
+				return errTagImmutable(tagName, "deleted", policy)
+			}
+			if !isAccountAdmin {
+				return ErrDenied.With("tag %q is protected from deletion by a tag protection policy (match_tag: %q)", tagName, string(policy.TagRx)).
+					WithStatus(http.StatusForbidden).
+					WithDetail(RegistryV2ErrorDetail{Reason: ReasonTagProtected, TagProtectionPolicy: &policy})
+			}
+		}
+	}
+	return nil
+}
+
+// CheckTagOverwriteAllowed returns an error if the given tag in the given
+// repo is made immutable by one of the account's tag protection policies.
+// This is checked when a push would move an existing tag to a different
+// manifest.
+func CheckTagOverwriteAllowed(account models.ReducedAccount, repoName, tagName string) error {
+	if account.TagProtectionPoliciesJSON == "" {
+		return nil
+	}
+	policies, err := ParseTagProtectionPolicies(account)
+	if err != nil {
+		return err
+	}
+	for _, policy := range policies {
+		if policy.Immutable && policy.Matches(repoName, tagName) {
+			return errTagImmutable(tagName, "overwritten", policy)
+		}
+	}
+	return nil
+}
+
+func errTagImmutable(tagName, verb string, policy TagProtectionPolicy) *RegistryV2Error {
+	return ErrDenied.With("tag %q is immutable and cannot be %s (match_tag: %q)", tagName, verb, string(policy.TagRx)).
+		WithStatus(http.StatusForbidden).
+		WithDetail(RegistryV2ErrorDetail{Reason: ReasonTagImmutable, TagProtectionPolicy: &policy})
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"testing"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestTagProtectionPolicyMatches(t *testing.T) {
+	policy := TagProtectionPolicy{
+		NegativeRepositoryRx: "sandbox/.*",
+		TagRx:                "release-.*",
+		NegativeTagRx:        "release-rc.*",
+	}
+
+	testCases := []struct {
+		RepoName string
+		TagName  string
+		Expected bool
+	}{
+		{"foo", "release-1", true},
+		{"foo/bar", "release-2.0", true},
+		{"foo", "release-rc1", false},
+		{"foo", "latest", false},
+		{"foo", "pre-release-1", false},
+		{"sandbox/foo", "release-1", false},
+	}
+	for _, tc := range testCases {
+		actual := policy.Matches(tc.RepoName, tc.TagName)
+		if actual != tc.Expected {
+			t.Errorf("expected Matches(%q, %q) = %t, but got %t", tc.RepoName, tc.TagName, tc.Expected, actual)
+		}
+	}
+
+	policy.RepositoryRx = "foo"
+	if policy.Matches("foo/bar", "release-1") {
+		t.Error("expected policy with match_repository to not match a different repository")
+	}
+}
+
+func TestCheckTagOverwriteAllowed(t *testing.T) {
+	account := models.ReducedAccount{
+		TagProtectionPoliciesJSON: `[{"match_tag":"release-.*"},{"match_tag":"v[0-9].*","except_tag":"v0.*","immutable":true}]`,
+	}
+	testCases := map[string]bool{
+		"release-1": true, // protected from deletion, but not immutable
+		"v1.0":      false,
+		"v0.1":      true,
+		"latest":    true,
+	}
+	for tagName, expected := range testCases {
+		err := CheckTagOverwriteAllowed(account, "foo", tagName)
+		if (err == nil) != expected {
+			t.Errorf("expected CheckTagOverwriteAllowed(%q) to succeed = %t, but got error: %v", tagName, expected, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/sapcc/go-bits/regexpext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// TagRetentionPolicy limits how many tags matching a certain pattern are kept
+// in each repository. It is stored in serialized form in the
+// TagRetentionPoliciesJSON field of type Account.
+//
+// Of all tags in a repo that match the policy, only the KeepCount newest ones
+// (by push time) are retained. Manifests whose tags have all fallen out of
+// retention are deleted by tasks.TagRetentionJob.
+type TagRetentionPolicy struct {
+	RepositoryRx         regexpext.BoundedRegexp `json:"match_repository,omitempty"`
+	NegativeRepositoryRx regexpext.BoundedRegexp `json:"except_repository,omitempty"`
+	TagRx                regexpext.BoundedRegexp `json:"match_tag"`
+	NegativeTagRx        regexpext.BoundedRegexp `json:"except_tag,omitempty"`
+	KeepCount            uint64                  `json:"keep_newest"`
+}
+
+// MatchesRepository evaluates the repository regexes in this policy.
+func (p TagRetentionPolicy) MatchesRepository(repoName string) bool {
+	//NOTE: NegativeRepositoryRx takes precedence and is thus evaluated first.
+	if p.NegativeRepositoryRx != "" && p.NegativeRepositoryRx.MatchString(repoName) {
+		return false
+	}
+	return p.RepositoryRx == "" || p.RepositoryRx.MatchString(repoName)
+}
+
+// MatchesTag evaluates the tag regexes in this policy.
+func (p TagRetentionPolicy) MatchesTag(tagName string) bool {
+	//NOTE: NegativeTagRx takes precedence and is thus evaluated first.
+	if p.NegativeTagRx != "" && p.NegativeTagRx.MatchString(tagName) {
+		return false
+	}
+	return p.TagRx.MatchString(tagName)
+}
+
+// Validate returns an error if this policy is invalid.
+func (p TagRetentionPolicy) Validate() error {
+	if p.TagRx == "" {
+		return errors.New(`tag retention policy must have the "match_tag" attribute`)
+	}
+	if p.KeepCount == 0 {
+		return errors.New(`tag retention policy must have the "keep_newest" attribute set to a positive number`)
+	}
+	return nil
+}
+
+// ParseTagRetentionPolicies parses the tag retention policies of the given account.
+func ParseTagRetentionPolicies(account models.Account) ([]TagRetentionPolicy, error) {
+	if account.TagRetentionPoliciesJSON == "" {
+		return nil, nil
+	}
+	var policies []TagRetentionPolicy
+	err := json.Unmarshal([]byte(account.TagRetentionPoliciesJSON), &policies)
+	return policies, err
+}
+
+// ApplyTagRetentionPoliciesToAccount validates the given tag retention
+// policies and stores them in the given account model.
+func ApplyTagRetentionPoliciesToAccount(policies []TagRetentionPolicy, account *models.Account) *RegistryV2Error {
+	if len(policies) == 0 {
+		account.TagRetentionPoliciesJSON = ""
+		return nil
+	}
+	for _, policy := range policies {
+		err := policy.Validate()
+		if err != nil {
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+	}
+
+	buf, err := json.Marshal(policies)
+	if err != nil {
+		return AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+	}
+	account.TagRetentionPoliciesJSON = string(buf)
+	return nil
+}
+
+// FindExpiredTags evaluates the given tag retention policies (which must
+// already have been filtered down to those matching the repo) on the given
+// tags of a single repo. It returns those tags that have fallen out of
+// retention, along with the first policy that expired them. A tag that is
+// retained by any policy is never expired, even if it falls out of retention
+// under another policy.
+func FindExpiredTags(policies []TagRetentionPolicy, tags []models.Tag) map[string]TagRetentionPolicy {
+	// sort tags from newest to oldest (with the name as tiebreaker for deterministic behavior)
+	tags = slices.Clone(tags)
+	sort.Slice(tags, func(i, j int) bool {
+		lhs, rhs := tags[i], tags[j]
+		if !lhs.PushedAt.Equal(rhs.PushedAt) {
+			return lhs.PushedAt.After(rhs.PushedAt)
+		}
+		return lhs.Name < rhs.Name
+	})
+
+	retained := make(map[string]bool)
+	expired := make(map[string]TagRetentionPolicy)
+	for _, policy := range policies {
+		var matchCount uint64
+		for _, tag := range tags {
+			if !policy.MatchesTag(tag.Name) {
+				continue
+			}
+			matchCount++
+			if matchCount <= policy.KeepCount {
+				retained[tag.Name] = true
+			} else if _, exists := expired[tag.Name]; !exists {
+				expired[tag.Name] = policy
+			}
+		}
+	}
+
+	for tagName := range retained {
+		delete(expired, tagName)
+	}
+	return expired
+}
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestFindExpiredTags(t *testing.T) {
+	now := time.Unix(10000, 0)
+	tags := []models.Tag{
+		{Name: "release-1", PushedAt: now.Add(-4 * time.Hour)},
+		{Name: "release-2", PushedAt: now.Add(-3 * time.Hour)},
+		{Name: "release-3", PushedAt: now.Add(-2 * time.Hour)},
+		{Name: "release-rc4", PushedAt: now.Add(-1 * time.Hour)},
+		{Name: "latest", PushedAt: now.Add(-1 * time.Hour)},
+	}
+	policies := []TagRetentionPolicy{{
+		TagRx:         "release-.*",
+		NegativeTagRx: "release-rc.*",
+		KeepCount:     2,
+	}}
+
+	expired := FindExpiredTags(policies, tags)
+	assert.DeepEqual(t, "expired tags", slices.Sorted(maps.Keys(expired)), []string{"release-1"})
+
+	// a tag retained by any policy is never expired
+	policies = append(policies, TagRetentionPolicy{TagRx: ".*", KeepCount: 1})
+	expired = FindExpiredTags(policies, tags)
+	assert.DeepEqual(t, "expired tags", slices.Sorted(maps.Keys(expired)), []string{"release-1", "release-rc4"})
+	assert.DeepEqual(t, "policy for release-1", expired["release-1"].KeepCount, uint64(2))
+	assert.DeepEqual(t, "policy for release-rc4", expired["release-rc4"].KeepCount, uint64(1))
+}
+
+func TestTagRetentionPolicyValidate(t *testing.T) {
+	var account models.Account
+	rerr := ApplyTagRetentionPoliciesToAccount([]TagRetentionPolicy{{TagRx: "release-.*"}}, &account)
+	if rerr == nil || rerr.Message != `tag retention policy must have the "keep_newest" attribute set to a positive number` {
+		t.Errorf("unexpected error: %v", rerr)
+	}
+	rerr = ApplyTagRetentionPoliciesToAccount([]TagRetentionPolicy{{KeepCount: 10}}, &account)
+	if rerr == nil || rerr.Message != `tag retention policy must have the "match_tag" attribute` {
+		t.Errorf("unexpected error: %v", rerr)
+	}
+	rerr = ApplyTagRetentionPoliciesToAccount([]TagRetentionPolicy{{TagRx: "release-.*", KeepCount: 10}}, &account)
+	if rerr != nil {
+		t.Error(rerr.Error())
+	}
+	assert.DeepEqual(t, "TagRetentionPoliciesJSON", account.TagRetentionPoliciesJSON, `[{"match_tag":"release-.*","keep_newest":10}]`)
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/regexpext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// TagWatch represents a tag watch in the API.
+type TagWatch struct {
+	ID             int64                   `json:"id"`
+	RepositoryName string                  `json:"repository"`
+	TagPattern     regexpext.BoundedRegexp `json:"match_tag"`
+	WebhookURL     string                  `json:"webhook_url,omitempty"`
+	CreatedAt      int64                   `json:"created_at"`
+	CreatedBy      string                  `json:"created_by,omitempty"`
+	CheckedAt      *int64                  `json:"checked_at,omitempty"`
+	ErrorMessage   string                  `json:"error,omitempty"`
+}
+
+// RenderTagWatch converts a tag watch model from the DB into the API representation.
+func RenderTagWatch(w models.TagWatch) TagWatch {
+	return TagWatch{
+		ID:             w.ID,
+		RepositoryName: w.RepoName,
+		TagPattern:     regexpext.BoundedRegexp(w.TagPattern),
+		WebhookURL:     w.WebhookURL,
+		CreatedAt:      w.CreatedAt.Unix(),
+		CreatedBy:      w.CreatedBy,
+		CheckedAt:      MaybeTimeToUnix(w.CheckedAt),
+		ErrorMessage:   w.ErrorMessage,
+	}
+}
+
+// ApplyToModel validates this tag watch and stores it in the given model.
+// Only the user-controlled fields (repository, match_tag, webhook_url) are considered.
+func (w TagWatch) ApplyToModel(target *models.TagWatch, policy OutboundRequestPolicy) error {
+	if w.RepositoryName == "" {
+		return errors.New(`missing attribute "repository" in tag watch`)
+	}
+	if w.TagPattern == "" {
+		return errors.New(`missing attribute "match_tag" in tag watch`)
+	}
+	if w.WebhookURL != "" {
+		err := policy.CheckURL(w.WebhookURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	target.RepoName = w.RepositoryName
+	target.TagPattern = string(w.TagPattern)
+	target.WebhookURL = w.WebhookURL
+	return nil
+}
+
+// ParseKnownTags parses the KnownTagsJSON field of the given tag watch.
+// The second return value is false if the upstream has not been checked yet.
+func ParseKnownTags(w models.TagWatch) (map[string]digest.Digest, bool, error) {
+	if w.KnownTagsJSON == "" {
+		return nil, false, nil
+	}
+	var result map[string]digest.Digest
+	err := json.Unmarshal([]byte(w.KnownTagsJSON), &result)
+	return result, true, err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// notifications
+
+// TagWatchNotification is the payload that is POSTed to the webhook of a tag
+// watch when changes to watched tags are detected upstream.
+type TagWatchNotification struct {
+	Account    models.AccountName `json:"account"`
+	Repository string             `json:"repository"`
+	Upstream   string             `json:"upstream"`
+	Changes    []TagWatchChange   `json:"changes"`
+}
+
+// TagWatchChange appears in type TagWatchNotification.
+type TagWatchChange struct {
+	Tag string `json:"tag"`
+	// PreviousDigest is empty if the tag was newly created upstream.
+	PreviousDigest digest.Digest `json:"previous_digest,omitempty"`
+	// CurrentDigest is empty if the tag was deleted upstream.
+	CurrentDigest digest.Digest `json:"current_digest,omitempty"`
+}
+
+// DiffTagWatchState computes the changes between two sets of tags observed upstream.
+// The result is sorted by tag name.
+func DiffTagWatchState(previous, current map[string]digest.Digest) []TagWatchChange {
+	var result []TagWatchChange
+	for tagName, currentDigest := range current {
+		if previous[tagName] != currentDigest {
+			result = append(result, TagWatchChange{tagName, previous[tagName], currentDigest})
+		}
+	}
+	for tagName, previousDigest := range previous {
+		if _, exists := current[tagName]; !exists {
+			result = append(result, TagWatchChange{tagName, previousDigest, ""})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Tag < result[j].Tag
+	})
+	return result
+}
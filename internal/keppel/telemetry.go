@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"time"
+)
+
+// TelemetryConfig appears in type Configuration. If configured, the janitor
+// periodically submits a TelemetryReport to the given URL.
+type TelemetryConfig struct {
+	URL      url.URL
+	Interval time.Duration
+}
+
+// TelemetrySchemaVersion is the value of TelemetryReport.SchemaVersion. It is
+// increased whenever fields are removed or their meaning changes.
+const TelemetrySchemaVersion = 1
+
+// TelemetryReport contains aggregate usage statistics for a Keppel
+// installation. It deliberately does not contain any names, IDs or other
+// information that would allow to identify individual accounts, repositories
+// or users.
+type TelemetryReport struct {
+	SchemaVersion int    `json:"schema_version"`
+	InstanceID    string `json:"instance_id"`
+	KeppelVersion string `json:"keppel_version"`
+	CreatedAt     int64  `json:"created_at"`
+
+	Accounts     TelemetryAccountStats `json:"accounts"`
+	Repositories uint64                `json:"repositories"`
+	Manifests    TelemetryObjectStats  `json:"manifests"`
+	Blobs        TelemetryObjectStats  `json:"blobs"`
+	Tags         uint64                `json:"tags"`
+}
+
+// TelemetryAccountStats appears in type TelemetryReport.
+type TelemetryAccountStats struct {
+	Total            uint64 `json:"total"`
+	Replicas         uint64 `json:"replicas"`
+	ExternalReplicas uint64 `json:"external_replicas"`
+	Managed          uint64 `json:"managed"`
+	// For each optional feature, the number of accounts that use it.
+	Features map[string]uint64 `json:"features"`
+}
+
+// TelemetryObjectStats appears in type TelemetryReport.
+type TelemetryObjectStats struct {
+	Count     uint64 `json:"count"`
+	SizeBytes uint64 `json:"size_bytes"`
+}
+
+var (
+	telemetryInstanceInsertQuery = `INSERT INTO telemetry_instance (instance_id) VALUES ($1) ON CONFLICT DO NOTHING`
+	telemetryInstanceSelectQuery = `SELECT instance_id FROM telemetry_instance`
+)
+
+// GetTelemetryInstanceID returns the value for TelemetryReport.InstanceID. It
+// is a random ID that is generated on first use and stored in the DB, so it
+// is stable for the same installation, but does not reveal anything about it.
+func GetTelemetryInstanceID(db *DB) (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	// if an ID already exists, this does nothing
+	_, err = db.Exec(telemetryInstanceInsertQuery, hex.EncodeToString(buf))
+	if err != nil {
+		return "", err
+	}
+	return db.SelectStr(telemetryInstanceSelectQuery)
+}
@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ExternalUpstreamHostPolicy restricts which registries external replica
+// accounts may replicate from. Each pattern must match the entire hostname
+// (without port) of the upstream registry.
+type ExternalUpstreamHostPolicy struct {
+	// If not empty, only upstream hosts matching at least one of these patterns are allowed.
+	AllowedHosts []*regexp.Regexp
+	// Upstream hosts matching any of these patterns are never allowed.
+	BlockedHosts []*regexp.Regexp
+}
+
+// ExternalUpstreamHostNameOf extracts the hostname from the ExternalPeerURL
+// of an account (e.g. "registry-1.docker.io/library" -> "registry-1.docker.io").
+// The port is removed, and the hostname is normalized to lowercase.
+func ExternalUpstreamHostNameOf(externalPeerURL string) string {
+	host, _, _ := strings.Cut(externalPeerURL, "/")
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.ToLower(host)
+}
+
+// Check returns an error if replication from the given ExternalPeerURL is not allowed.
+func (p ExternalUpstreamHostPolicy) Check(externalPeerURL string) *RegistryV2Error {
+	hostname := ExternalUpstreamHostNameOf(externalPeerURL)
+	matches := func(rx *regexp.Regexp) bool { return rx.MatchString(hostname) }
+	isAllowed := len(p.AllowedHosts) == 0 || slices.ContainsFunc(p.AllowedHosts, matches)
+	if isAllowed && !slices.ContainsFunc(p.BlockedHosts, matches) {
+		return nil
+	}
+	msg := fmt.Sprintf("replication from upstream registry %q is not allowed by the operator of this Keppel", hostname)
+	return ErrDenied.With(msg).WithStatus(http.StatusForbidden).
+		WithDetail(RegistryV2ErrorDetail{Reason: ReasonUpstreamBlocked, UpstreamHostName: hostname})
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+)
+
+func TestExternalUpstreamHostPolicy(t *testing.T) {
+	p := ExternalUpstreamHostPolicy{
+		AllowedHosts: []*regexp.Regexp{regexp.MustCompile(`^(?:.*\.example\.org|localhost)$`)},
+		BlockedHosts: []*regexp.Regexp{regexp.MustCompile(`^(?:untrusted\.example\.org)$`)},
+	}
+	expectedResults := map[string]bool{
+		"registry.example.org":              true,
+		"registry.example.org/library":      true,
+		"Registry.Example.Org:5000/library": true,
+		"localhost:5000":                    true,
+		"registry.example.com":              false,
+		"example.org":                       false,
+		"untrusted.example.org":             false,
+		"UNTRUSTED.example.org:443/foo":     false,
+	}
+	for externalPeerURL, expected := range expectedResults {
+		rerr := p.Check(externalPeerURL)
+		if expected && rerr != nil {
+			t.Errorf("expected %q to be allowed, but got: %s", externalPeerURL, rerr.Error())
+		}
+		if !expected {
+			if rerr == nil {
+				t.Errorf("expected %q to be blocked, but it was allowed", externalPeerURL)
+				continue
+			}
+			assert.DeepEqual(t, "status", rerr.Status, http.StatusForbidden)
+			assert.DeepEqual(t, "reason", rerr.Detail, any(RegistryV2ErrorDetail{
+				Reason:           ReasonUpstreamBlocked,
+				UpstreamHostName: ExternalUpstreamHostNameOf(externalPeerURL),
+			}))
+		}
+	}
+
+	// an empty policy allows everything
+	rerr := ExternalUpstreamHostPolicy{}.Check("registry.example.com")
+	if rerr != nil {
+		t.Errorf("expected empty policy to allow everything, but got: %s", rerr.Error())
+	}
+}
@@ -6,6 +6,7 @@ package keppel
 import (
 	"fmt"
 
+	"github.com/sapcc/go-api-declarations/cadf"
 	"github.com/sapcc/go-bits/audittools"
 	"github.com/sapcc/go-bits/pluggable"
 )
@@ -27,6 +28,8 @@ const (
 	TrivyUser
 	// JanitorUser is a dummy UserType for when the janitor needs an Authorization for audit logging purposes.
 	JanitorUser
+	// RobotUser is the UserType for robot credentials, which can only pull from the single repository that they were generated for.
+	RobotUser
 )
 
 // UserIdentity describes the identity and access rights of a user. For regular
@@ -74,3 +77,17 @@ func DeserializeUserIdentity(typeID string, payload []byte, ad AuthDriver) (User
 	err := uid.DeserializeFromJSON(payload, ad)
 	return uid, err
 }
+
+// IdentifyUser returns a string that identifies the user behind the given
+// UserIdentity. Unlike UserName(), it does not depend on the scope of the
+// user's credentials (e.g. the project scope of a Keystone token), so it can
+// be used to check whether two requests were made by the same user.
+func IdentifyUser(uid UserIdentity) string {
+	if userInfo := uid.UserInfo(); userInfo != nil {
+		initiator := userInfo.AsInitiator(cadf.Host{})
+		if initiator.ID != "" {
+			return fmt.Sprintf("%s:%s", uid.PluginTypeID(), initiator.ID)
+		}
+	}
+	return fmt.Sprintf("%s:%s", uid.PluginTypeID(), uid.UserName())
+}
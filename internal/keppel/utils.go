@@ -4,10 +4,14 @@
 package keppel
 
 import (
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/sapcc/go-bits/httpext"
 )
 
 // OriginalRequestURL returns the URL that the original requester used when
@@ -33,6 +37,10 @@ func OriginalRequestURL(r *http.Request) url.URL {
 	if r.Host != "" {
 		u.Host = r.Host
 		u.Scheme = "http"
+		if r.TLS != nil {
+			// we are terminating TLS ourselves (e.g. for the custom domain of an account)
+			u.Scheme = "https"
+		}
 		return u
 	}
 
@@ -40,6 +48,43 @@ func OriginalRequestURL(r *http.Request) url.URL {
 	return u
 }
 
+// NormalizeIP converts an IP address into its canonical textual form, e.g.
+// "2001:DB8:0:0::1" becomes "2001:db8::1", and IPv4-mapped IPv6 addresses like
+// "::ffff:192.0.2.1" become plain IPv4 addresses like "192.0.2.1". Values that
+// are not valid IP addresses are returned unchanged.
+func NormalizeIP(ip string) string {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return ip
+	}
+	return addr.Unmap().String()
+}
+
+// NormalizeForwardedFor extracts the client IP from the value of an
+// X-Forwarded-For header, in the same form as NormalizeIP. When the request
+// went through several proxies, the header contains a comma-separated list of
+// hops, and the first one is the original client. Each hop may also include a
+// port, e.g. "192.0.2.1:12345" or "[2001:db8::1]:12345".
+func NormalizeForwardedFor(value string) string {
+	firstHop, _, _ := strings.Cut(value, ",")
+	firstHop = strings.TrimSpace(firstHop)
+	if host, _, err := net.SplitHostPort(firstHop); err == nil {
+		firstHop = host
+	}
+	return NormalizeIP(firstHop)
+}
+
+// ClientIPFor returns the IP address of the client that sent the given
+// request, in the same form as NormalizeIP. This is the only place where the
+// requester IP should be taken from, so that RBAC policies, rate limits, audit
+// events and push info all agree on it.
+func ClientIPFor(r *http.Request) string {
+	if xForwardedFor := r.Header.Get("X-Forwarded-For"); xForwardedFor != "" {
+		return NormalizeForwardedFor(xForwardedFor)
+	}
+	return NormalizeIP(httpext.GetRequesterIPFor(r))
+}
+
 // AppendQuery adds additional query parameters to an existing unparsed URL.
 func AppendQuery(urlStr string, query url.Values) string {
 	if strings.Contains(urlStr, "?") {
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"testing"
+)
+
+func TestNormalizeIP(t *testing.T) {
+	testCases := map[string]string{
+		"192.0.2.1":                 "192.0.2.1",
+		"::ffff:192.0.2.1":          "192.0.2.1",
+		"2001:DB8:0:0:0:0:0:1":      "2001:db8::1",
+		" 2001:db8::1 ":             "2001:db8::1",
+		"not-an-ip":                 "not-an-ip",
+		"2001:db8:0:0:1:0:0:1%eth0": "2001:db8::1:0:0:1%eth0",
+	}
+	for input, expected := range testCases {
+		actual := NormalizeIP(input)
+		if actual != expected {
+			t.Errorf("expected NormalizeIP(%q) = %q, but got %q", input, expected, actual)
+		}
+	}
+}
+
+func TestNormalizeForwardedFor(t *testing.T) {
+	testCases := map[string]string{
+		"192.0.2.1":                             "192.0.2.1",
+		"192.0.2.1:12345":                       "192.0.2.1",
+		"192.0.2.1, 198.51.100.1":               "192.0.2.1",
+		" 192.0.2.1:12345 ,198.51.100.1:443":    "192.0.2.1",
+		"2001:DB8::1, 198.51.100.1":             "2001:db8::1",
+		"[2001:db8::1]:12345":                   "2001:db8::1",
+		"[::ffff:192.0.2.1]:12345, 2001:db8::2": "192.0.2.1",
+		"not-an-ip, 192.0.2.1":                  "not-an-ip",
+	}
+	for input, expected := range testCases {
+		actual := NormalizeForwardedFor(input)
+		if actual != expected {
+			t.Errorf("expected NormalizeForwardedFor(%q) = %q, but got %q", input, expected, actual)
+		}
+	}
+}
+
+func TestRateLimitRequesterKey(t *testing.T) {
+	testCases := []struct {
+		Input        string
+		PrefixLength int
+		Expected     string
+	}{
+		{"192.0.2.1", 64, "192.0.2.1"},
+		{"::ffff:192.0.2.1", 64, "192.0.2.1"},
+		{"2001:db8:1:2:3:4:5:6", 64, "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:ffff::1", 64, "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:3:4:5:6", 48, "2001:db8:1::/48"},
+		{"2001:db8:1:2:3:4:5:6", 128, "2001:db8:1:2:3:4:5:6/128"},
+		{"2001:db8:1:2:3:4:5:6", 0, "2001:db8:1:2::/64"},
+		{"not-an-ip", 64, "not-an-ip"},
+	}
+	for _, tc := range testCases {
+		actual := RateLimitRequesterKey(tc.Input, tc.PrefixLength)
+		if actual != tc.Expected {
+			t.Errorf("expected RateLimitRequesterKey(%q, %d) = %q, but got %q", tc.Input, tc.PrefixLength, tc.Expected, actual)
+		}
+	}
+}
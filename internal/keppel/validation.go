@@ -8,24 +8,33 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/containers/image/v5/manifest"
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
+
 	"github.com/sapcc/keppel/internal/models"
 )
 
 // ValidationPolicy represents a validation policy in the API.
 type ValidationPolicy struct {
-	RequiredLabels []string `json:"required_labels,omitempty"`
+	RequiredLabels         []string                  `json:"required_labels,omitempty"`
+	RecommendedAnnotations []string                  `json:"recommended_annotations,omitempty"`
+	ForeignLayers          models.ForeignLayerPolicy `json:"foreign_layers,omitempty"`
 }
 
 // RenderValidationPolicy builds a ValidationPolicy object out of the
 // information in the given account model.
 func RenderValidationPolicy(account models.ReducedAccount) *ValidationPolicy {
-	if account.RequiredLabels == "" {
+	if account.RequiredLabels == "" && account.RecommendedAnnotations == "" && account.ForeignLayerPolicy == models.ForeignLayersRequireBlobs {
 		return nil
 	}
 
-	return &ValidationPolicy{
-		RequiredLabels: account.SplitRequiredLabels(),
+	var result ValidationPolicy
+	if account.RequiredLabels != "" {
+		result.RequiredLabels = account.SplitRequiredLabels()
 	}
+	result.RecommendedAnnotations = account.SplitRecommendedAnnotations()
+	result.ForeignLayers = account.ForeignLayerPolicy
+	return &result
 }
 
 // ApplyToAccount validates this policy and stores it in the given account model.
@@ -36,7 +45,80 @@ func (v ValidationPolicy) ApplyToAccount(account *models.Account) *RegistryV2Err
 			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
 		}
 	}
+	for _, annotation := range v.RecommendedAnnotations {
+		if annotation == "" || strings.Contains(annotation, ",") {
+			err := fmt.Errorf(`invalid annotation name: %q`, annotation)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+	}
+
+	if !v.ForeignLayers.IsValid() {
+		err := fmt.Errorf(`invalid value for "foreign_layers": %q`, v.ForeignLayers)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
 
 	account.RequiredLabels = strings.Join(v.RequiredLabels, ",")
+	account.RecommendedAnnotations = strings.Join(v.RecommendedAnnotations, ",")
+	account.ForeignLayerPolicy = v.ForeignLayers
 	return nil
 }
+
+// Foreign layers (Docker) and non-distributable layers (OCI) are deprecated
+// because they cannot be mirrored reliably.
+var deprecatedLayerMediaTypes = map[string]bool{
+	manifest.DockerV2Schema2ForeignLayerMediaType:      true,
+	manifest.DockerV2Schema2ForeignLayerMediaTypeGzip:  true,
+	imagespecs.MediaTypeImageLayerNonDistributable:     true, //nolint:staticcheck // we need to refer to deprecated media types to detect them
+	imagespecs.MediaTypeImageLayerNonDistributableGzip: true, //nolint:staticcheck // same as above
+	imagespecs.MediaTypeImageLayerNonDistributableZstd: true, //nolint:staticcheck // same as above
+}
+
+// IsForeignLayerWithURLs returns whether the given layer is a foreign or
+// non-distributable layer whose contents can be downloaded from elsewhere.
+func IsForeignLayerWithURLs(layerInfo manifest.LayerInfo) bool {
+	return deprecatedLayerMediaTypes[layerInfo.MediaType] && len(layerInfo.URLs) > 0
+}
+
+// IsBlobRequiredForLayer returns whether the contents of the given layer must
+// have been uploaded into the account (or, for replica accounts, must be
+// replicated from upstream) according to the account's ForeignLayerPolicy.
+func IsBlobRequiredForLayer(account models.ReducedAccount, layerInfo manifest.LayerInfo) bool {
+	return account.ForeignLayerPolicy != models.ForeignLayersAllowURLs || !IsForeignLayerWithURLs(layerInfo)
+}
+
+// ManifestValidationWarnings checks the given manifest for problems that are
+// not severe enough to reject it, and returns a human-readable description for
+// each problem found. layerCountThreshold is the configured
+// Configuration.ManifestLayerCountWarningThreshold.
+func ManifestValidationWarnings(account models.ReducedAccount, m ParsedManifest, layerCountThreshold int) []string {
+	var warnings []string
+
+	// for image manifests, BlobReferences() lists the config blob first, followed by the layers
+	// (for list manifests, it is empty)
+	var layerInfos []manifest.LayerInfo
+	if blobRefs := m.BlobReferences(); len(blobRefs) > 0 {
+		layerInfos = blobRefs[1:]
+	}
+	layerCount := len(layerInfos)
+	for _, layerInfo := range layerInfos {
+		if deprecatedLayerMediaTypes[layerInfo.MediaType] {
+			warnings = append(warnings, fmt.Sprintf("layer %s uses the deprecated media type %s", layerInfo.Digest, layerInfo.MediaType))
+		}
+	}
+	if layerCountThreshold > 0 && layerCount > layerCountThreshold {
+		warnings = append(warnings, fmt.Sprintf("image has %d layers, which is more than the recommended maximum of %d", layerCount, layerCountThreshold))
+	}
+
+	var missingAnnotations []string
+	annotations := m.GetAnnotations()
+	for _, key := range account.SplitRecommendedAnnotations() {
+		if _, exists := annotations[key]; !exists {
+			missingAnnotations = append(missingAnnotations, key)
+		}
+	}
+	if len(missingAnnotations) > 0 {
+		warnings = append(warnings, "missing recommended annotations: "+strings.Join(missingAnnotations, ", "))
+	}
+
+	return warnings
+}
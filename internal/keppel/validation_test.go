@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func buildOCIManifestForValidationTest(t *testing.T, layerMediaTypes []string, annotations map[string]string) ParsedManifest {
+	t.Helper()
+	m := imagespecs.Manifest{
+		MediaType: imagespecs.MediaTypeImageManifest,
+		Config: imagespecs.Descriptor{
+			MediaType: imagespecs.MediaTypeImageConfig,
+			Digest:    digest.FromString("config"),
+			Size:      6,
+		},
+		Annotations: annotations,
+	}
+	m.SchemaVersion = 2
+	for idx, mediaType := range layerMediaTypes {
+		m.Layers = append(m.Layers, imagespecs.Descriptor{
+			MediaType: mediaType,
+			Digest:    digest.FromString(fmt.Sprintf("layer%d", idx)),
+			Size:      100,
+		})
+	}
+
+	buf, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	parsed, err := ParseManifest(imagespecs.MediaTypeImageManifest, buf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return parsed
+}
+
+func TestManifestValidationWarnings(t *testing.T) {
+	account := models.ReducedAccount{Name: "test1"}
+
+	// a well-behaved manifest does not generate warnings
+	m := buildOCIManifestForValidationTest(t, []string{imagespecs.MediaTypeImageLayerGzip, imagespecs.MediaTypeImageLayerGzip}, nil)
+	warnings := ManifestValidationWarnings(account, m, 2)
+	if len(warnings) > 0 {
+		t.Errorf("expected no warnings, but got %#v", warnings)
+	}
+
+	// too many layers
+	warnings = ManifestValidationWarnings(account, m, 1)
+	expected := []string{"image has 2 layers, which is more than the recommended maximum of 1"}
+	if !slices.Equal(warnings, expected) {
+		t.Errorf("expected warnings %#v, but got %#v", expected, warnings)
+	}
+
+	// deprecated layer media types
+	m = buildOCIManifestForValidationTest(t, []string{imagespecs.MediaTypeImageLayerGzip, imagespecs.MediaTypeImageLayerNonDistributableGzip}, nil) //nolint:staticcheck // testing detection of deprecated media type
+	warnings = ManifestValidationWarnings(account, m, 0)
+	expected = []string{fmt.Sprintf("layer %s uses the deprecated media type %s", digest.FromString("layer1"), imagespecs.MediaTypeImageLayerNonDistributableGzip)} //nolint:staticcheck // same as above
+	if !slices.Equal(warnings, expected) {
+		t.Errorf("expected warnings %#v, but got %#v", expected, warnings)
+	}
+
+	// missing recommended annotations
+	account.RecommendedAnnotations = "org.opencontainers.image.source,org.opencontainers.image.authors,org.opencontainers.image.revision"
+	m = buildOCIManifestForValidationTest(t, []string{imagespecs.MediaTypeImageLayerGzip}, map[string]string{
+		"org.opencontainers.image.authors": "jane@example.com",
+	})
+	warnings = ManifestValidationWarnings(account, m, 0)
+	expected = []string{"missing recommended annotations: org.opencontainers.image.source, org.opencontainers.image.revision"}
+	if !slices.Equal(warnings, expected) {
+		t.Errorf("expected warnings %#v, but got %#v", expected, warnings)
+	}
+}
+
+func TestForeignLayerHandling(t *testing.T) {
+	foreignLayer := manifest.LayerInfo{BlobInfo: types.BlobInfo{
+		Digest:    digest.FromString("layer0"),
+		MediaType: manifest.DockerV2Schema2ForeignLayerMediaTypeGzip,
+		URLs:      []string{"https://mcr.microsoft.com/v2/windows/servercore/blobs/" + digest.FromString("layer0").String()},
+	}}
+	regularLayer := manifest.LayerInfo{BlobInfo: types.BlobInfo{
+		Digest:    digest.FromString("layer1"),
+		MediaType: manifest.DockerV2Schema2LayerMediaType,
+	}}
+
+	// only the "allow" policy allows to skip the blobs of foreign layers with URLs
+	for _, policy := range []models.ForeignLayerPolicy{models.ForeignLayersRequireBlobs, models.ForeignLayersAllowURLs} {
+		account := models.ReducedAccount{Name: "test1", ForeignLayerPolicy: policy}
+		expected := policy != models.ForeignLayersAllowURLs
+		if actual := IsBlobRequiredForLayer(account, foreignLayer); actual != expected {
+			t.Errorf("expected IsBlobRequiredForLayer(%q, foreignLayer) = %t, but got %t", policy, expected, actual)
+		}
+		if !IsBlobRequiredForLayer(account, regularLayer) {
+			t.Errorf("expected IsBlobRequiredForLayer(%q, regularLayer) = true, but got false", policy)
+		}
+	}
+
+}
@@ -0,0 +1,213 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package keppel
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/regexpext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// WebhookEventType enumerates the types of events that webhooks can subscribe to.
+type WebhookEventType string
+
+const (
+	// WebhookEventPush is sent when a manifest is pushed into a repository.
+	WebhookEventPush WebhookEventType = "push"
+	// WebhookEventVulnerabilityScan is sent when the vulnerability status of a
+	// manifest changes as a result of a vulnerability scan.
+	WebhookEventVulnerabilityScan WebhookEventType = "vulnerability_scan"
+	// WebhookEventStorageQuotaExceeded is sent when a push into the account
+	// fails because the storage backend reported that its quota was exceeded.
+	// It is only sent once until a push succeeds again.
+	WebhookEventStorageQuotaExceeded WebhookEventType = "storage_quota_exceeded"
+)
+
+var allWebhookEventTypes = []WebhookEventType{WebhookEventPush, WebhookEventVulnerabilityScan, WebhookEventStorageQuotaExceeded}
+
+// Webhook represents a webhook in the API.
+type Webhook struct {
+	ID                int64                   `json:"id"`
+	Namespace         string                  `json:"namespace,omitempty"`
+	RepositoryPattern regexpext.BoundedRegexp `json:"match_repository,omitempty"`
+	URL               string                  `json:"url"`
+	EventTypes        []WebhookEventType      `json:"events"`
+	CreatedAt         int64                   `json:"created_at"`
+	CreatedBy         string                  `json:"created_by,omitempty"`
+	// Secret is only filled in the response to the creation of the webhook.
+	Secret string `json:"secret,omitempty"`
+}
+
+// RenderWebhook converts a webhook model from the DB into the API representation.
+func RenderWebhook(w models.Webhook) Webhook {
+	return Webhook{
+		ID:                w.ID,
+		Namespace:         w.NamespacePrefix,
+		RepositoryPattern: regexpext.BoundedRegexp(w.RepoPattern),
+		URL:               w.URL,
+		EventTypes:        parseWebhookEventTypes(w.EventTypes),
+		CreatedAt:         w.CreatedAt.Unix(),
+		CreatedBy:         w.CreatedBy,
+	}
+}
+
+func parseWebhookEventTypes(input string) []WebhookEventType {
+	var result []WebhookEventType
+	for field := range strings.SplitSeq(input, ",") {
+		if field != "" {
+			result = append(result, WebhookEventType(field))
+		}
+	}
+	return result
+}
+
+// ApplyToModel validates this webhook and stores it in the given model.
+// Only the user-controlled fields (namespace, match_repository, url, events)
+// are considered. The caller must check that the namespace exists.
+func (w Webhook) ApplyToModel(target *models.Webhook, policy OutboundRequestPolicy) error {
+	err := policy.CheckURL(w.URL)
+	if err != nil {
+		return err
+	}
+	if len(w.EventTypes) == 0 {
+		return errors.New(`missing attribute "events" in webhook`)
+	}
+	eventTypes := make([]string, 0, len(w.EventTypes))
+	for _, eventType := range w.EventTypes {
+		if !slices.Contains(allWebhookEventTypes, eventType) {
+			return fmt.Errorf("%q is not a valid webhook event type", eventType)
+		}
+		// events concerning the account as a whole are not shown to namespace admins
+		if w.Namespace != "" && eventType == WebhookEventStorageQuotaExceeded {
+			return fmt.Errorf("webhooks in namespaces cannot subscribe to %q events", eventType)
+		}
+		if !slices.Contains(eventTypes, string(eventType)) {
+			eventTypes = append(eventTypes, string(eventType))
+		}
+	}
+
+	target.NamespacePrefix = w.Namespace
+	target.RepoPattern = string(w.RepositoryPattern)
+	target.URL = w.URL
+	target.EventTypes = strings.Join(eventTypes, ",")
+	return nil
+}
+
+// GenerateWebhookSecret generates a random secret for models.Webhook.Secret.
+func GenerateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// WebhookSignatureHeader is the header in which notifications to webhooks
+// carry their signature (see SignWebhookPayload).
+const WebhookSignatureHeader = "X-Keppel-Signature-256"
+
+// SignWebhookPayload computes the value of the WebhookSignatureHeader for a
+// notification with the given request body. The receiver can verify the
+// signature by computing the same HMAC with the secret that was shown to them
+// when the webhook was created. Since the payload contains a timestamp, this
+// also allows the receiver to reject replayed notifications.
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// notifications
+
+// WebhookNotification is the payload that is POSTed to a webhook.
+type WebhookNotification struct {
+	Event   WebhookEventType   `json:"event"`
+	Account models.AccountName `json:"account"`
+	// Repository and Digest are empty for "storage_quota_exceeded" events,
+	// which concern the account as a whole.
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest,omitempty"`
+	MediaType  string        `json:"media_type,omitempty"`
+	// Tag is only filled for "push" events when a tag was pushed.
+	Tag string `json:"tag,omitempty"`
+	// VulnerabilityStatus is only filled for "vulnerability_scan" events.
+	VulnerabilityStatus models.VulnerabilityStatus `json:"vulnerability_status,omitempty"`
+	// Message is only filled for "storage_quota_exceeded" events, and contains
+	// the error message from the storage backend.
+	Message   string `json:"message,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// EnqueueWebhookNotification schedules the delivery of the given notification
+// to all webhooks in the account that are subscribed to its event type and
+// whose repository pattern matches the notification's repository (the pattern
+// is ignored for notifications that do not concern a specific repository).
+// The actual delivery is done by tasks.WebhookDeliveryJob.
+func EnqueueWebhookNotification(db gorp.SqlExecutor, n WebhookNotification, now time.Time) error {
+	var webhooks []models.Webhook
+	_, err := db.Select(&webhooks, `SELECT * FROM webhooks WHERE account_name = $1 ORDER BY id`, n.Account)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	for _, w := range webhooks {
+		if !slices.Contains(parseWebhookEventTypes(w.EventTypes), n.Event) {
+			continue
+		}
+		if n.Repository != "" && !webhookMatchesRepository(w, n.Repository) {
+			continue
+		}
+		err := db.Insert(&models.WebhookDelivery{
+			WebhookID:     w.ID,
+			PayloadJSON:   string(payload),
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func webhookMatchesRepository(w models.Webhook, repoName string) bool {
+	if w.NamespacePrefix != "" {
+		return absoluteNamespacePattern(w.NamespacePrefix, regexpext.BoundedRegexp(w.RepoPattern)).MatchString(repoName)
+	}
+	return w.RepoPattern == "" || regexpext.BoundedRegexp(w.RepoPattern).MatchString(repoName)
+}
+
+// EnqueueWebhookNotificationOrLog is like EnqueueWebhookNotification, but
+// errors are only logged. This is used in places where the main operation has
+// already succeeded and should not fail because of a webhook.
+func EnqueueWebhookNotificationOrLog(db gorp.SqlExecutor, n WebhookNotification, now time.Time) {
+	err := EnqueueWebhookNotification(db, n, now)
+	if err != nil {
+		logg.Error("cannot enqueue %s webhook notification for %s/%s@%s: %s",
+			n.Event, n.Account, n.Repository, n.Digest, err.Error())
+	}
+}
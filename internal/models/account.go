@@ -19,48 +19,174 @@ type Account struct {
 
 	// UpstreamPeerHostName is set if and only if the "on_first_use" replication strategy is used.
 	UpstreamPeerHostName string `db:"upstream_peer_hostname"`
+	// SyncPoliciesFromPrimary can only be set if UpstreamPeerHostName is set.
+	// If true, RBAC policies and GC policies are copied from the primary account
+	// during manifest sync (at most once per hour).
+	SyncPoliciesFromPrimary bool `db:"sync_policies_from_primary"`
+	// PoliciesOverriddenLocally suspends the sync enabled by
+	// SyncPoliciesFromPrimary, so that policies can be changed on this account.
+	PoliciesOverriddenLocally bool `db:"policies_overridden_locally"`
 	// ExternalPeerURL, ExternalPeerUserName and ExternalPeerPassword are set if
 	// and only if the "from_external_on_first_use" replication strategy is used.
 	ExternalPeerURL      string `db:"external_peer_url"`
 	ExternalPeerUserName string `db:"external_peer_username"`
 	ExternalPeerPassword string `db:"external_peer_password"`
+	// ExternalPeerPasswordRef is set instead of ExternalPeerPassword if the
+	// password is stored in the secret store behind keppel.SecretsDriver.
+	ExternalPeerPasswordRef string `db:"external_peer_password_ref"`
+	// ExternalPeerCredentials contains additional per-repo-prefix credentials for
+	// the "from_external_on_first_use" replication strategy.
+	ExternalPeerCredentials ExternalPeerCredentials `db:"external_peer_credentials_json"`
+	// ExternalPeerVerifyOnly can only be set if ExternalPeerURL is set. If true,
+	// replicated blobs are verified against their digests, and manifests are
+	// only served once their entire digest chain has been verified.
+	ExternalPeerVerifyOnly bool `db:"external_peer_verify_only"`
+	// ReplicationRetryHints can only be set on replica accounts. If true, blob
+	// pulls that need to wait for replication from upstream are answered with a
+	// retry hint instead of holding the connection open until replication is done.
+	ReplicationRetryHints bool `db:"replication_retry_hints"`
 	// PlatformFilter restricts which submanifests get replicated when a list manifest is replicated.
 	PlatformFilter PlatformFilter `db:"platform_filter"`
+	// DefaultPlatform is either empty or a platform specification like "linux/amd64".
+	// If set, GET on a tag referring to a list manifest returns the submanifest for this platform instead.
+	DefaultPlatform string `db:"default_platform"`
+	// CustomDomain is either empty or a hostname under which the
+	// domain-remapped API for this account is offered in addition to the usual one.
+	CustomDomain string `db:"custom_domain"`
+	// CustomDomainCertificateRef is either empty or a reference into the secret
+	// store behind keppel.SecretsDriver. The secret contains the PEM-encoded TLS
+	// certificate chain and private key for CustomDomain.
+	CustomDomainCertificateRef string `db:"custom_domain_certificate_ref"`
+	// CustomDomainVerificationToken is generated when CustomDomain is set. The
+	// owner of the domain proves ownership by publishing it in a DNS TXT record
+	// (see keppel.CustomDomainVerificationRecordName).
+	CustomDomainVerificationToken string `db:"custom_domain_verification_token"`
+	// CustomDomainVerifiedAt is set once the ownership of CustomDomain has been
+	// verified. Until then, the custom domain is not served.
+	CustomDomainVerifiedAt *time.Time `db:"custom_domain_verified_at"`
+	// ContentEncryptionKeyRef is either empty or a reference into the secret
+	// store behind keppel.SecretsDriver. The secret contains the data key that
+	// manifest contents are encrypted with in the database (see
+	// keppel.EncryptManifestContent).
+	ContentEncryptionKeyRef string `db:"content_encryption_key_ref"`
+	// ServeBlobsViaCDN indicates whether blob pulls are redirected to the CDN
+	// behind keppel.CDNDriver (if one is configured).
+	ServeBlobsViaCDN bool `db:"serve_blobs_via_cdn"`
+	// ShareBlobs indicates whether blobs stored in this account may be copied
+	// into accounts of other auth tenants when those replicate the same blob
+	// (see keppel.FindSharedBlob). Within the same auth tenant, blobs are
+	// always shared.
+	ShareBlobs bool `db:"share_blobs"`
+	// LazyPullFormat is empty by default. If set, the janitor generates
+	// variants of pushed images in this format (see tasks.LazyPullVariantJob).
+	LazyPullFormat LazyPullFormat `db:"lazy_pull_format"`
+	// RecompressionFormat is empty by default. If set, the janitor generates
+	// variants of pushed images with layers recompressed in this format (see
+	// tasks.RecompressedVariantJob).
+	RecompressionFormat RecompressionFormat `db:"recompression_format"`
+	// StoragePlacementJSON contains a JSON string of []keppel.StoragePlacementRule, or the empty string.
+	StoragePlacementJSON string `db:"storage_placement_json"`
+	// ResponseHeadersJSON contains a JSON string of map[string]string, or the empty string.
+	// These headers are added to all Registry API responses for this account.
+	ResponseHeadersJSON string `db:"response_headers_json"`
+	// PullTermsVersion and PullTermsURL are either both empty, or describe
+	// terms of use that users must accept before pulling (see keppel.PullTerms).
+	PullTermsVersion string `db:"pull_terms_version"`
+	PullTermsURL     string `db:"pull_terms_url"`
+	// MetadataJSON contains a JSON string of map[string]string, or the empty string.
+	// The permissible keys and values are defined by keppel.AccountMetadataSchema.
+	MetadataJSON string `db:"metadata_json"`
+	// MinPullPromotionState is the minimum PromotionState that manifests must
+	// have to be pullable by regular users. If empty, no minimum is enforced.
+	MinPullPromotionState PromotionState `db:"min_pull_promotion_state"`
 
 	// RequiredLabels is a comma-separated list of labels that must be present on
 	// all image manifests in this account.
 	RequiredLabels string `db:"required_labels"`
+	// RecommendedAnnotations is a comma-separated list of annotations that
+	// should be present on all manifests in this account. Missing annotations
+	// only cause validation warnings, not errors.
+	RecommendedAnnotations string `db:"recommended_annotations"`
+	// ForeignLayerPolicy controls how manifests with foreign layers (i.e. layers
+	// that carry URLs to download their contents from elsewhere) are handled.
+	ForeignLayerPolicy ForeignLayerPolicy `db:"foreign_layer_policy"`
+	// AdmissionPoliciesJSON contains a JSON string of []keppel.AdmissionPolicy, or the empty string.
+	AdmissionPoliciesJSON string `db:"admission_policies_json"`
+	// ApprovalPolicyJSON contains a JSON string of keppel.ApprovalPolicy, or the empty string.
+	ApprovalPolicyJSON string `db:"approval_policy_json"`
+	// TagProtectionPoliciesJSON contains a JSON string of []keppel.TagProtectionPolicy, or the empty string.
+	TagProtectionPoliciesJSON string `db:"tag_protection_policies_json"`
+	// TagRetentionPoliciesJSON contains a JSON string of []keppel.TagRetentionPolicy, or the empty string.
+	TagRetentionPoliciesJSON string `db:"tag_retention_policies_json"`
+	// CascadeDeleteReferrers indicates whether deleting a manifest also deletes
+	// all manifests referring to it as their subject (e.g. signatures and SBOMs).
+	CascadeDeleteReferrers bool `db:"cascade_delete_referrers"`
 	// IsDeleting indicates whether the account is currently being deleted.
 	IsDeleting bool `db:"is_deleting"`
 	// IsManaged indicates if the account was created by AccountManagementDriver
 	IsManaged bool `db:"is_managed"`
+	// ReplicationPausedAt is set when replication from upstream was paused
+	// because too many replications failed (see keppel.ReplicationErrorBudget).
+	ReplicationPausedAt *time.Time `db:"replication_paused_at"`
+	// StorageQuotaExceededAt is set when a write into the storage backend failed
+	// because of a quota in the backend (see keppel.ErrStorageQuotaExceeded),
+	// and cleared when the next write succeeds.
+	StorageQuotaExceededAt *time.Time `db:"storage_quota_exceeded_at"`
 
 	// RBACPoliciesJSON contains a JSON string of []keppel.RBACPolicy, or the empty string.
 	RBACPoliciesJSON string `db:"rbac_policies_json"`
+	// RBACPolicyEpoch is incremented whenever the RBAC policies of this account
+	// or of its namespaces change. Tokens record the epoch at the time of
+	// issuance, and are rejected once the epoch has moved on.
+	RBACPolicyEpoch int64 `db:"rbac_policy_epoch"`
 	// GCPoliciesJSON contains a JSON string of []keppel.GCPolicy, or the empty string.
 	GCPoliciesJSON string `db:"gc_policies_json"`
 	// SecurityScanPoliciesJSON contains a JSON string of []keppel.SecurityScanPolicy, or the empty string.
 	SecurityScanPoliciesJSON string `db:"security_scan_policies_json"`
 
-	NextBlobSweepedAt            *time.Time `db:"next_blob_sweep_at"`              // see tasks.BlobSweepJob
-	NextDeletionAttempt          *time.Time `db:"next_deletion_attempt_at"`        // see tasks.AccountDeletionJob
-	NextEnforcementAt            *time.Time `db:"next_enforcement_at"`             // see tasks.CreateManagedAccountsJob
-	NextStorageSweepedAt         *time.Time `db:"next_storage_sweep_at"`           // see tasks.StorageSweepJob
-	NextFederationAnnouncementAt *time.Time `db:"next_federation_announcement_at"` // see tasks.AnnounceAccountToFederationJob
+	NextBlobSweepedAt              *time.Time `db:"next_blob_sweep_at"`                 // see tasks.BlobSweepJob
+	NextDeletionAttempt            *time.Time `db:"next_deletion_attempt_at"`           // see tasks.AccountDeletionJob
+	NextEnforcementAt              *time.Time `db:"next_enforcement_at"`                // see tasks.CreateManagedAccountsJob
+	NextStorageSweepedAt           *time.Time `db:"next_storage_sweep_at"`              // see tasks.StorageSweepJob
+	NextFederationAnnouncementAt   *time.Time `db:"next_federation_announcement_at"`    // see tasks.AnnounceAccountToFederationJob
+	NextCredentialReportAt         *time.Time `db:"next_credential_report_at"`          // see tasks.CredentialReportJob
+	NextSegmentSweepAt             *time.Time `db:"next_segment_sweep_at"`              // see tasks.OrphanedSegmentSweepJob
+	NextCustomDomainVerificationAt *time.Time `db:"next_custom_domain_verification_at"` // see tasks.CustomDomainVerificationJob
+	NextPolicySyncAt               *time.Time `db:"next_policy_sync_at"`                // see tasks.ManifestSyncJob
 }
 
 // Reduced converts an Account into a ReducedAccount.
 func (a Account) Reduced() ReducedAccount {
 	return ReducedAccount{
-		Name:                 a.Name,
-		AuthTenantID:         a.AuthTenantID,
-		UpstreamPeerHostName: a.UpstreamPeerHostName,
-		ExternalPeerURL:      a.ExternalPeerURL,
-		ExternalPeerUserName: a.ExternalPeerUserName,
-		ExternalPeerPassword: a.ExternalPeerPassword,
-		PlatformFilter:       a.PlatformFilter,
-		RequiredLabels:       a.RequiredLabels,
-		IsDeleting:           a.IsDeleting,
+		Name:                      a.Name,
+		AuthTenantID:              a.AuthTenantID,
+		UpstreamPeerHostName:      a.UpstreamPeerHostName,
+		ExternalPeerURL:           a.ExternalPeerURL,
+		ExternalPeerUserName:      a.ExternalPeerUserName,
+		ExternalPeerPassword:      a.ExternalPeerPassword,
+		ExternalPeerPasswordRef:   a.ExternalPeerPasswordRef,
+		ExternalPeerCredentials:   a.ExternalPeerCredentials,
+		ExternalPeerVerifyOnly:    a.ExternalPeerVerifyOnly,
+		ReplicationRetryHints:     a.ReplicationRetryHints,
+		PlatformFilter:            a.PlatformFilter,
+		DefaultPlatform:           a.DefaultPlatform,
+		ServeBlobsViaCDN:          a.ServeBlobsViaCDN,
+		ContentEncryptionKeyRef:   a.ContentEncryptionKeyRef,
+		StoragePlacementJSON:      a.StoragePlacementJSON,
+		ResponseHeadersJSON:       a.ResponseHeadersJSON,
+		PullTermsVersion:          a.PullTermsVersion,
+		PullTermsURL:              a.PullTermsURL,
+		MinPullPromotionState:     a.MinPullPromotionState,
+		RequiredLabels:            a.RequiredLabels,
+		RecommendedAnnotations:    a.RecommendedAnnotations,
+		ForeignLayerPolicy:        a.ForeignLayerPolicy,
+		AdmissionPoliciesJSON:     a.AdmissionPoliciesJSON,
+		ApprovalPolicyJSON:        a.ApprovalPolicyJSON,
+		TagProtectionPoliciesJSON: a.TagProtectionPoliciesJSON,
+		CascadeDeleteReferrers:    a.CascadeDeleteReferrers,
+		IsDeleting:                a.IsDeleting,
+		ReplicationPausedAt:       a.ReplicationPausedAt,
+		StorageQuotaExceededAt:    a.StorageQuotaExceededAt,
 	}
 }
 
@@ -72,15 +198,45 @@ type ReducedAccount struct {
 	AuthTenantID string
 
 	// replication policy
-	UpstreamPeerHostName string
-	ExternalPeerURL      string
-	ExternalPeerUserName string
-	ExternalPeerPassword string
-	PlatformFilter       PlatformFilter
+	UpstreamPeerHostName    string
+	ExternalPeerURL         string
+	ExternalPeerUserName    string
+	ExternalPeerPassword    string
+	ExternalPeerPasswordRef string
+	ExternalPeerCredentials ExternalPeerCredentials
+	ExternalPeerVerifyOnly  bool
+	ReplicationRetryHints   bool
+	PlatformFilter          PlatformFilter
+	ReplicationPausedAt     *time.Time
+
+	// tag resolution
+	DefaultPlatform string
+
+	// blob delivery and storage
+	ServeBlobsViaCDN        bool
+	StoragePlacementJSON    string
+	ContentEncryptionKeyRef string
+	StorageQuotaExceededAt  *time.Time
+
+	// response customization, terms of use
+	ResponseHeadersJSON string
+	PullTermsVersion    string
+	PullTermsURL        string
+
+	// promotion workflow
+	MinPullPromotionState PromotionState
 
 	// validation policy, status
-	RequiredLabels string
-	IsDeleting     bool
+	RequiredLabels         string
+	RecommendedAnnotations string
+	ForeignLayerPolicy     ForeignLayerPolicy
+	AdmissionPoliciesJSON  string
+	IsDeleting             bool
+
+	// deletion approval, protection and cascading
+	ApprovalPolicyJSON        string
+	TagProtectionPoliciesJSON string
+	CascadeDeleteReferrers    bool
 
 	// NOTE: When adding or removing fields, always adjust Account.Reduced() and keppel.FindReducedAccount() too!
 }
@@ -89,3 +245,35 @@ type ReducedAccount struct {
 func (a ReducedAccount) SplitRequiredLabels() []string {
 	return strings.Split(a.RequiredLabels, ",")
 }
+
+// SplitRecommendedAnnotations parses the RecommendedAnnotations field.
+func (a ReducedAccount) SplitRecommendedAnnotations() []string {
+	if a.RecommendedAnnotations == "" {
+		return nil
+	}
+	return strings.Split(a.RecommendedAnnotations, ",")
+}
+
+// ForeignLayerPolicy appears in type Account.
+//
+// Foreign layers (in Docker manifests) and non-distributable layers (in OCI
+// manifests) carry URLs where clients can download their contents from,
+// usually because the image vendor does not allow redistributing them. This
+// is mostly seen in the base layers of older Windows images.
+type ForeignLayerPolicy string
+
+const (
+	// ForeignLayersRequireBlobs is the default value of Account.ForeignLayerPolicy.
+	// Foreign layers are treated like all other layers, i.e. their contents
+	// must have been uploaded into the account.
+	ForeignLayersRequireBlobs ForeignLayerPolicy = ""
+	// ForeignLayersAllowURLs means that the contents of foreign layers with URLs
+	// do not need to be uploaded into the account, since clients can download
+	// them from these URLs. Such layers are also skipped during replication.
+	ForeignLayersAllowURLs ForeignLayerPolicy = "allow"
+)
+
+// IsValid returns whether this is one of the predefined policies.
+func (p ForeignLayerPolicy) IsValid() bool {
+	return p == ForeignLayersRequireBlobs || p == ForeignLayersAllowURLs
+}
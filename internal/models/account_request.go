@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// AccountRequestState is an enum for the state of an AccountRequest.
+type AccountRequestState string
+
+const (
+	// AccountRequestPending is the AccountRequestState of requests that have not been decided yet.
+	AccountRequestPending AccountRequestState = "pending"
+	// AccountRequestApproved is the AccountRequestState of requests that were approved by an admin.
+	// The requested account is created at the time of approval.
+	AccountRequestApproved AccountRequestState = "approved"
+	// AccountRequestDenied is the AccountRequestState of requests that were denied by an admin.
+	AccountRequestDenied AccountRequestState = "denied"
+)
+
+// AccountRequest contains a record from the `account_requests` table.
+//
+// Account requests are submitted by users who want an account to be created,
+// and are then approved or denied by an admin.
+type AccountRequest struct {
+	ID           int64       `db:"id"`
+	AccountName  AccountName `db:"account_name"`
+	AuthTenantID string      `db:"auth_tenant_id"`
+	// AccountJSON contains the requested account configuration, in the same
+	// format as the request body of PUT /keppel/v1/accounts/:name.
+	AccountJSON    string              `db:"account_json"`
+	State          AccountRequestState `db:"state"`
+	RequestedAt    time.Time           `db:"requested_at"`
+	RequestedBy    string              `db:"requested_by"`
+	DecidedAt      *time.Time          `db:"decided_at"`
+	DecidedBy      string              `db:"decided_by"`
+	DecisionReason string              `db:"decision_reason"`
+}
@@ -0,0 +1,20 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// AccountSnapshot contains a record from the `account_snapshots` table.
+//
+// A snapshot records which repositories, manifests and tags existed in an
+// account at a certain point in time. It can be used to restore tags after an
+// accidental mass deletion, as long as the tagged manifests still exist.
+type AccountSnapshot struct {
+	ID          int64       `db:"id"`
+	AccountName AccountName `db:"account_name"`
+	CreatedAt   time.Time   `db:"created_at"`
+	CreatedBy   string      `db:"created_by"`
+	// ContentsJSON contains a serialized keppel.AccountSnapshotContents.
+	ContentsJSON string `db:"contents_json"`
+}
@@ -0,0 +1,20 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// Announcement contains a record from the `announcements` table.
+//
+// There is at most one announcement at any given time. It is shown to users
+// until its EndsAt timestamp passes (if any).
+type Announcement struct {
+	ID        int64      `db:"id"`
+	Message   string     `db:"message"`
+	Severity  string     `db:"severity"`
+	StartsAt  *time.Time `db:"starts_at"`
+	EndsAt    *time.Time `db:"ends_at"`
+	CreatedAt time.Time  `db:"created_at"`
+	CreatedBy string     `db:"created_by"`
+}
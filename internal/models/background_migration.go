@@ -0,0 +1,15 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// BackgroundMigration contains a record from the `background_migrations` table.
+// It tracks the progress of one of the migrations in keppel.BackgroundMigrations.
+type BackgroundMigration struct {
+	Name          string     `db:"name"`
+	ProcessedRows int64      `db:"processed_rows"`
+	StartedAt     time.Time  `db:"started_at"`
+	FinishedAt    *time.Time `db:"finished_at"`
+}
@@ -31,6 +31,10 @@ type Blob struct {
 	ValidationErrorMessage string        `db:"validation_error_message"`
 	CanBeDeletedAt         *time.Time    `db:"can_be_deleted_at"` // see tasks.BlobSweepJob
 	BlocksVulnScanning     *bool         `db:"blocks_vuln_scanning"`
+	BackedUpAt             *time.Time    `db:"backed_up_at"`   // see tasks.BlobBackupJob
+	NextBackupAt           *time.Time    `db:"next_backup_at"` // only set after a failed backup
+	BackupErrorMessage     string        `db:"backup_error_message"`
+	TrivyFetchedAt         *time.Time    `db:"trivy_fetched_at"` // when Trivy last pulled this blob for a security scan
 }
 
 // SafeMediaType returns the MediaType field, but falls back to "application/octet-stream" if it is empty.
@@ -48,6 +52,9 @@ const (
 	// BlobValidationAfterErrorInterval is how quickly BlobValidationJob will
 	// retry a failed blob validation.
 	BlobValidationAfterErrorInterval = 10 * time.Minute
+	// BlobBackupAfterErrorInterval is how quickly BlobBackupJob will retry a
+	// failed blob backup.
+	BlobBackupAfterErrorInterval = 1 * time.Hour
 )
 
 // Upload contains a record from the `uploads` table.
@@ -0,0 +1,18 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// DeprecatedAPIUsage contains a record from the `deprecated_api_usage` table.
+//
+// Each record counts how often a deprecated API endpoint or behavior (see
+// keppel.Deprecation) has been used within a certain account.
+type DeprecatedAPIUsage struct {
+	AccountName   AccountName `db:"account_name"`
+	DeprecationID string      `db:"deprecation_id"`
+	FirstUsedAt   time.Time   `db:"first_used_at"`
+	LastUsedAt    time.Time   `db:"last_used_at"`
+	UsageCount    uint64      `db:"usage_count"`
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ExternalPeerCredentials appears in type Account. For external replica
+// accounts, it contains additional credentials that are used instead of
+// ExternalPeerUserName and ExternalPeerPassword when replicating from upstream
+// repos with a matching name prefix.
+type ExternalPeerCredentials []ExternalPeerCredentialSet
+
+// ExternalPeerCredentialSet appears in type ExternalPeerCredentials.
+type ExternalPeerCredentialSet struct {
+	// RepoPrefix is matched against the full repository name on the upstream side
+	// (including the path from the external peer URL, if any).
+	RepoPrefix string `json:"repo_prefix"`
+	UserName   string `json:"username"`
+	Password   string `json:"password,omitempty"`
+	// PasswordRef is set instead of Password if the password is stored in the
+	// secret store behind keppel.SecretsDriver.
+	PasswordRef string `json:"password_ref,omitempty"`
+}
+
+// Scan implements the sql.Scanner interface.
+func (c *ExternalPeerCredentials) Scan(src any) error {
+	in, ok := src.(string)
+	if !ok {
+		return fmt.Errorf("cannot deserialize %T into %T", src, c)
+	}
+
+	// default value: empty string = no additional credentials
+	if in == "" {
+		*c = nil
+		return nil
+	}
+
+	// otherwise deserialize from JSON
+	var list []ExternalPeerCredentialSet
+	err := json.Unmarshal([]byte(in), &list)
+	if err != nil {
+		return fmt.Errorf("cannot deserialize into ExternalPeerCredentials: %w", err)
+	}
+
+	*c = list
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (c ExternalPeerCredentials) Value() (driver.Value, error) {
+	// default value: no additional credentials == empty string
+	if len(c) == 0 {
+		return "", nil
+	}
+
+	// otherwise serialize to JSON
+	return json.Marshal(c)
+}
+
+// ForRepo returns the credential set that applies to the given upstream
+// repository. If several credential sets match, the one with the longest
+// prefix wins. If none match, nil is returned.
+func (c ExternalPeerCredentials) ForRepo(upstreamRepoName string) *ExternalPeerCredentialSet {
+	var result *ExternalPeerCredentialSet
+	for idx, set := range c {
+		if !strings.HasPrefix(upstreamRepoName, set.RepoPrefix) {
+			continue
+		}
+		if result == nil || len(set.RepoPrefix) > len(result.RepoPrefix) {
+			result = &c[idx]
+		}
+	}
+	return result
+}
+
+// Redacted returns a copy of this list with all passwords removed.
+func (c ExternalPeerCredentials) Redacted() ExternalPeerCredentials {
+	if len(c) == 0 {
+		return nil
+	}
+	result := make(ExternalPeerCredentials, len(c))
+	for idx, set := range c {
+		set.Password = ""
+		result[idx] = set
+	}
+	return result
+}
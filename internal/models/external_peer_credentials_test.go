@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import (
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+)
+
+func TestExternalPeerCredentialsForRepo(t *testing.T) {
+	credentials := ExternalPeerCredentials{
+		{RepoPrefix: "org1/", UserName: "robot1", Password: "secret1"},
+		{RepoPrefix: "org1/team/", UserName: "robot2", Password: "secret2"},
+		{RepoPrefix: "org2/", UserName: "robot3", Password: "secret3"},
+	}
+
+	testCases := map[string]string{
+		"org1/foo":         "robot1",
+		"org1/team/foo":    "robot2",
+		"org2/bar":         "robot3",
+		"org3/baz":         "",
+		"org1":             "",
+		"library/org1/foo": "",
+	}
+	for repoName, expectedUserName := range testCases {
+		actualUserName := ""
+		if set := credentials.ForRepo(repoName); set != nil {
+			actualUserName = set.UserName
+		}
+		assert.DeepEqual(t, "username for "+repoName, actualUserName, expectedUserName)
+	}
+
+	redacted := credentials.Redacted()
+	for idx, set := range redacted {
+		assert.DeepEqual(t, "redacted password", set.Password, "")
+		assert.DeepEqual(t, "original password", credentials[idx].Password != "", true)
+	}
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// LazyPullFormat is a layer format that lazy-pulling container runtimes can
+// use to start containers before all layers have been downloaded.
+type LazyPullFormat string
+
+const (
+	// LazyPullFormatNone is the default value of Account.LazyPullFormat,
+	// meaning that no lazy-pulling variants are generated.
+	LazyPullFormatNone LazyPullFormat = ""
+	// LazyPullFormatEstargz is the eStargz format understood by the stargz snapshotter.
+	LazyPullFormatEstargz LazyPullFormat = "estargz"
+)
+
+// IsValid returns whether this is one of the predefined formats.
+func (f LazyPullFormat) IsValid() bool {
+	return f == LazyPullFormatNone || f == LazyPullFormatEstargz
+}
+
+// LazyPullVariant contains a record from the `lazy_pull_variants` table.
+//
+// Each record describes the attempt to generate a variant of the image
+// manifest (RepositoryID, Digest) with layers in the given Format. On success,
+// VariantDigest refers to a manifest in the same repository that has the
+// original manifest as its subject.
+type LazyPullVariant struct {
+	RepositoryID  int64          `db:"repo_id"`
+	Digest        digest.Digest  `db:"digest"`
+	Format        LazyPullFormat `db:"format"`
+	VariantDigest digest.Digest  `db:"variant_digest"` // empty if ErrorMessage is set
+	GeneratedAt   time.Time      `db:"generated_at"`
+	ErrorMessage  string         `db:"error_message"`
+	// NextAttemptAt is only set if generation failed, and shall be retried.
+	NextAttemptAt *time.Time `db:"next_attempt_at"`
+}
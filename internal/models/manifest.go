@@ -18,7 +18,10 @@ type Manifest struct {
 	PushedAt               time.Time     `db:"pushed_at"`
 	NextValidationAt       time.Time     `db:"next_validation_at"` // see tasks.ManifestValidationJob
 	ValidationErrorMessage string        `db:"validation_error_message"`
-	LastPulledAt           *time.Time    `db:"last_pulled_at"`
+	// ValidationWarningsJSON contains a JSON string of a []string with non-fatal
+	// validation warnings, or an empty string if there are none.
+	ValidationWarningsJSON string     `db:"validation_warnings_json"`
+	LastPulledAt           *time.Time `db:"last_pulled_at"`
 	// LabelsJSON contains a JSON string of a map[string]string, or an empty string.
 	LabelsJSON string `db:"labels_json"`
 	// GCStatusJSON contains a keppel.GCStatus serialized into JSON, or an empty
@@ -31,6 +34,92 @@ type Manifest struct {
 	AnnotationsJSON string        `db:"annotations_json"`
 	ArtifactType    string        `db:"artifact_type"`
 	SubjectDigest   digest.Digest `db:"subject_digest"`
+	// QuarantinedAt is set while the manifest is quarantined (see ManifestState).
+	// QuarantineReason explains why, and is empty for non-quarantined manifests.
+	QuarantinedAt      *time.Time `db:"quarantined_at"`
+	QuarantineReason   string     `db:"quarantine_reason"`
+	BackedUpAt         *time.Time `db:"backed_up_at"`   // see tasks.ManifestBackupJob
+	NextBackupAt       *time.Time `db:"next_backup_at"` // only set after a failed backup
+	BackupErrorMessage string     `db:"backup_error_message"`
+	// VerifiedAt is only used in replica accounts with ExternalPeerVerifyOnly.
+	// It is set once the digests of this manifest and of all blobs and
+	// submanifests referenced by it have been verified (see tasks.ManifestVerificationJob).
+	VerifiedAt               *time.Time `db:"verified_at"`
+	NextVerificationAt       *time.Time `db:"next_verification_at"` // only set after a failed verification
+	VerificationErrorMessage string     `db:"verification_error_message"`
+	// PromotionState is empty for manifests that have not entered the optional
+	// promotion workflow (see PromotionState).
+	PromotionState PromotionState `db:"promotion_state"`
+	// PushInfoJSON contains a JSON string of keppel.ManifestPushInfo describing
+	// the client that originally pushed this manifest, or an empty string if
+	// the manifest was not pushed through the Registry API (e.g. replicated).
+	PushInfoJSON string `db:"push_info_json"`
+}
+
+// ManifestState describes whether a manifest can be pulled. It is derived from
+// the QuarantinedAt field of type Manifest.
+//
+// Manifests start out as ManifestActive (or as ManifestQuarantined if an
+// admission policy requests that), can be moved between the two states by
+// admins, and can be deleted in either state.
+type ManifestState string
+
+const (
+	// ManifestActive is the normal state of manifests.
+	ManifestActive ManifestState = "active"
+	// ManifestQuarantined is the state of manifests that are pending review.
+	// They can only be pulled by admins and by Trivy.
+	ManifestQuarantined ManifestState = "quarantined"
+)
+
+// State returns the state of this manifest.
+func (m Manifest) State() ManifestState {
+	if m.QuarantinedAt != nil {
+		return ManifestQuarantined
+	}
+	return ManifestActive
+}
+
+// PromotionState is the lifecycle state of a manifest within the optional
+// promotion workflow. Manifests start out without a promotion state and can be
+// moved into any state (in any direction) by users with the "promote"
+// permission. Accounts can require a minimum promotion state for pulls.
+type PromotionState string
+
+const (
+	// PromotionStateNone is the state of manifests that have not been promoted.
+	PromotionStateNone    PromotionState = ""
+	PromotionStateDev     PromotionState = "dev"
+	PromotionStateStaging PromotionState = "staging"
+	PromotionStateProd    PromotionState = "prod"
+)
+
+// Rank returns the position of this state in the promotion pipeline, or -1
+// if the state is not valid. Later states have higher ranks.
+func (s PromotionState) Rank() int {
+	switch s {
+	case PromotionStateNone:
+		return 0
+	case PromotionStateDev:
+		return 1
+	case PromotionStateStaging:
+		return 2
+	case PromotionStateProd:
+		return 3
+	default:
+		return -1
+	}
+}
+
+// IsValid returns whether this is one of the predefined states.
+func (s PromotionState) IsValid() bool {
+	return s.Rank() >= 0
+}
+
+// IsAtLeast returns whether this state is equal to or later than the other
+// state in the promotion pipeline.
+func (s PromotionState) IsAtLeast(other PromotionState) bool {
+	return s.Rank() >= other.Rank()
 }
 
 const (
@@ -39,6 +128,10 @@ const (
 	ManifestValidationInterval = 24 * time.Hour
 	// ManifestValidationAfterErrorInterval is how quickly ManifestValidationJob will retry a failed manifest validation.
 	ManifestValidationAfterErrorInterval = 10 * time.Minute
+	// ManifestBackupAfterErrorInterval is how quickly ManifestBackupJob will retry a failed manifest backup.
+	ManifestBackupAfterErrorInterval = 1 * time.Hour
+	// ManifestVerificationAfterErrorInterval is how quickly ManifestVerificationJob will retry a failed manifest verification.
+	ManifestVerificationAfterErrorInterval = 10 * time.Minute
 )
 
 // Tag contains a record from the `tags` table.
@@ -48,6 +141,10 @@ type Tag struct {
 	Digest       digest.Digest `db:"digest"`
 	PushedAt     time.Time     `db:"pushed_at"`
 	LastPulledAt *time.Time    `db:"last_pulled_at"`
+	// PullCount counts pulls using this tag name. ChildPullCount counts pulls of
+	// submanifests of the tagged list manifest by digest.
+	PullCount      uint64 `db:"pull_count"`
+	ChildPullCount uint64 `db:"child_pull_count"`
 }
 
 // ManifestContent contains a record from the `manifest_contents` table.
@@ -55,4 +152,8 @@ type ManifestContent struct {
 	RepositoryID int64  `db:"repo_id"`
 	Digest       string `db:"digest"`
 	Content      []byte `db:"content"`
+	// EncryptionKeyRef is empty if Content is stored in plaintext. Otherwise,
+	// Content is encrypted with the data key behind this secret reference (see
+	// keppel.EncryptManifestContent).
+	EncryptionKeyRef string `db:"encryption_key_ref"`
 }
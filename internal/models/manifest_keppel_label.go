@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "github.com/opencontainers/go-digest"
+
+// ManifestKeppelLabel contains a record from the `manifest_keppel_labels` table.
+//
+// Keppel labels are key-value pairs that users attach to a manifest through
+// the Keppel API after it has been pushed. Unlike the labels in Manifest.LabelsJSON
+// (which come from the image configuration), they are not part of the manifest
+// contents, so changing them does not change the manifest digest.
+type ManifestKeppelLabel struct {
+	RepositoryID int64         `db:"repo_id"`
+	Digest       digest.Digest `db:"digest"`
+	Name         string        `db:"name"`
+	Value        string        `db:"value"`
+}
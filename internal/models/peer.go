@@ -11,6 +11,14 @@ type Peer struct {
 
 	UseForPullDelegation bool `db:"use_for_pull_delegation"`
 
+	// Region and LinkCost describe where this peer is located in the peer mesh.
+	// If UseForReplication is true, replica accounts may replicate blobs and
+	// manifests from this peer instead of from the peer holding their primary
+	// account, if this peer has a lower LinkCost.
+	Region            string `db:"region"`
+	LinkCost          int    `db:"link_cost"`
+	UseForReplication bool   `db:"use_for_replication"`
+
 	// OurPassword is what we use to log in at the peer.
 	OurPassword string `db:"our_password"`
 
@@ -15,6 +15,9 @@ type PendingBlob struct {
 	Digest       digest.Digest `db:"digest"`
 	Reason       PendingReason `db:"reason"`
 	PendingSince time.Time     `db:"since"`
+	// ReplicatedBytes is updated periodically while the blob is being
+	// replicated, to give clients waiting for the blob a progress hint.
+	ReplicatedBytes uint64 `db:"replicated_bytes"`
 }
 
 // PendingReason is an enum that explains why a blob is pending.
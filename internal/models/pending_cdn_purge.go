@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// PendingCDNPurge contains a record from the `pending_cdn_purges` table.
+//
+// Each record is a deleted blob that is waiting to be purged from the CDN by
+// tasks.CDNPurgeJob. Since the blob and possibly also its account are already
+// gone from the DB at this point, the record holds everything that is needed
+// to identify the blob on the CDN.
+type PendingCDNPurge struct {
+	ID            int64         `db:"id"`
+	AccountName   AccountName   `db:"account_name"`
+	AuthTenantID  string        `db:"auth_tenant_id"`
+	Digest        digest.Digest `db:"digest"`
+	FailedCount   uint64        `db:"failed_count"`
+	NextAttemptAt time.Time     `db:"next_attempt_at"`
+	// ErrorMessage is empty unless a previous purge attempt failed.
+	ErrorMessage string `db:"error_message"`
+}
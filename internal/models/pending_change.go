@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// PendingChangeKind enumerates the kinds of destructive operations that can
+// be held back in a PendingChange.
+type PendingChangeKind string
+
+const (
+	// PendingAccountDeletion is the deletion of the whole account.
+	PendingAccountDeletion PendingChangeKind = "delete_account"
+	// PendingManifestDeletion is the deletion of a manifest along with its tags.
+	PendingManifestDeletion PendingChangeKind = "delete_manifest"
+	// PendingTagDeletion is the deletion of a single tag.
+	PendingTagDeletion PendingChangeKind = "delete_tag"
+	// PendingGCPoliciesUpdate is a change to the account's GC policies.
+	PendingGCPoliciesUpdate PendingChangeKind = "update_gc_policies"
+	// PendingRepoGCPoliciesUpdate is a change to the GC-related settings of a
+	// repository (its GC policies, its archival state, or whether it ignores
+	// inherited GC policies).
+	PendingRepoGCPoliciesUpdate PendingChangeKind = "update_repo_gc_policies"
+	// PendingNamespaceGCPoliciesUpdate is a change to the GC policies of a
+	// repository namespace.
+	PendingNamespaceGCPoliciesUpdate PendingChangeKind = "update_namespace_gc_policies"
+	// PendingApprovalPolicyUpdate is a change to the account's approval policy.
+	PendingApprovalPolicyUpdate PendingChangeKind = "update_approval_policy"
+)
+
+// PendingChange contains a record from the `pending_changes` table.
+//
+// For accounts with an approval policy, destructive operations are not
+// executed immediately. Instead, they are recorded as a PendingChange that
+// needs to be approved by a different user before it is executed.
+type PendingChange struct {
+	ID          int64             `db:"id"`
+	AccountName AccountName       `db:"account_name"`
+	Kind        PendingChangeKind `db:"kind"`
+	// PayloadJSON contains a serialized keppel.PendingChangePayload.
+	PayloadJSON string    `db:"payload_json"`
+	RequestedAt time.Time `db:"requested_at"`
+	RequestedBy string    `db:"requested_by"`
+	// RequestedByID identifies the requesting user (see keppel.IdentifyUser).
+	// This is only used for checking that approvals come from a different user.
+	RequestedByID string `db:"requested_by_id"`
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
 
 	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
 )
@@ -59,6 +60,11 @@ func (f PlatformFilter) Includes(platform imagespecs.Platform) bool {
 	}
 
 	for _, p := range f {
+		// the OS version in the filter may leave out the patch level (see OSVersionMatches)
+		if OSVersionMatches(p.OSVersion, platform.OSVersion) {
+			p.OSVersion = platform.OSVersion
+		}
+
 		//NOTE: This check could be much more elaborate, e.g. consider only fields
 		// that are not empty in `p`.
 		if reflect.DeepEqual(p, platform) {
@@ -68,6 +74,19 @@ func (f PlatformFilter) Includes(platform imagespecs.Platform) bool {
 	return false
 }
 
+// OSVersionMatches checks whether the OS version of a platform (`actual`)
+// matches an OS version given in a PlatformFilter (`pattern`). Windows images
+// have OS versions like "10.0.17763.5458", where the last component is the
+// patch level that changes with every monthly update. To allow for filtering
+// by Windows release regardless of patch level, a pattern like "10.0.17763"
+// matches all OS versions that it is a prefix of.
+func OSVersionMatches(pattern, actual string) bool {
+	if pattern == "" {
+		return actual == ""
+	}
+	return actual == pattern || strings.HasPrefix(actual, pattern+".")
+}
+
 // IsEqualTo checks whether both filters are equal.
 func (f PlatformFilter) IsEqualTo(other PlatformFilter) bool {
 	if len(f) != len(other) {
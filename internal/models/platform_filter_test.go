@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import (
+	"testing"
+
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPlatformFilterIncludes(t *testing.T) {
+	filter := PlatformFilter{
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763"},
+		{OS: "windows", Architecture: "arm64", OSVersion: "10.0.20348.2113", OSFeatures: []string{"win32k"}},
+	}
+
+	testCases := []struct {
+		Platform imagespecs.Platform
+		Expected bool
+	}{
+		{imagespecs.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, true},
+		{imagespecs.Platform{OS: "linux", Architecture: "arm64"}, false},
+		{imagespecs.Platform{OS: "linux", Architecture: "amd64"}, false},
+		// OS version in the filter matches all patch levels of that version
+		{imagespecs.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763"}, true},
+		{imagespecs.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.5458"}, true},
+		{imagespecs.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.177630"}, false},
+		{imagespecs.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.2113"}, false},
+		{imagespecs.Platform{OS: "windows", Architecture: "amd64"}, false},
+		// all other fields must match exactly
+		{imagespecs.Platform{OS: "linux", Architecture: "arm64", Variant: "v8", OSVersion: "6.1"}, false},
+		{imagespecs.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.5458", OSFeatures: []string{"win32k"}}, false},
+		{imagespecs.Platform{OS: "windows", Architecture: "arm64", OSVersion: "10.0.20348.2113", OSFeatures: []string{"win32k"}}, true},
+		{imagespecs.Platform{OS: "windows", Architecture: "arm64", OSVersion: "10.0.20348.2113"}, false},
+		{imagespecs.Platform{OS: "windows", Architecture: "arm64", OSVersion: "10.0.20348.2340", OSFeatures: []string{"win32k"}}, false},
+	}
+
+	for _, tc := range testCases {
+		actual := filter.Includes(tc.Platform)
+		if actual != tc.Expected {
+			t.Errorf("expected Includes(%#v) = %t, but got %t", tc.Platform, tc.Expected, actual)
+		}
+	}
+}
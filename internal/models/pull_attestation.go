@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// PullAttestation contains a record from the `pull_attestations` table.
+//
+// Each record counts how often a client that identified itself with a client
+// ID has pulled a certain manifest. Unlike the manifest itself, this record is
+// retained when the manifest or its repository is deleted, so that it can
+// still be found when the manifest later turns out to have been compromised.
+type PullAttestation struct {
+	AccountName    AccountName   `db:"account_name"`
+	RepositoryName string        `db:"repo_name"`
+	Digest         digest.Digest `db:"digest"`
+	ClientID       string        `db:"client_id"`
+	FirstPulledAt  time.Time     `db:"first_pulled_at"`
+	LastPulledAt   time.Time     `db:"last_pulled_at"`
+	PullCount      uint64        `db:"pull_count"`
+}
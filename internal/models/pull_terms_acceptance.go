@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// PullTermsAcceptance contains a record from the `pull_terms_acceptances` table.
+// It records that a user has accepted a specific version of the terms of use
+// of an account (see Account.PullTermsVersion).
+type PullTermsAcceptance struct {
+	AccountName  AccountName `db:"account_name"`
+	UserName     string      `db:"user_name"`
+	TermsVersion string      `db:"terms_version"`
+	AcceptedAt   time.Time   `db:"accepted_at"`
+}
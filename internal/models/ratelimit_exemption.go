@@ -0,0 +1,20 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// RateLimitExemption contains a record from the `rate_limit_exemptions` table.
+//
+// Each of AccountName, CIDR and UserName may be empty. Requests are exempt
+// from rate limits if they match all of the non-empty fields.
+type RateLimitExemption struct {
+	ID          int64       `db:"id"`
+	AccountName AccountName `db:"account_name"`
+	CIDR        string      `db:"cidr"`
+	UserName    string      `db:"user_name"`
+	Comment     string      `db:"comment"`
+	CreatedAt   time.Time   `db:"created_at"`
+	CreatedBy   string      `db:"created_by"`
+}
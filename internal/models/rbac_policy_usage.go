@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// RBACPolicyUsage contains a record from the `rbac_policy_usage` table.
+// It records when an RBAC policy of an account was last used for granting or
+// forbidding access. Policies are identified by their fingerprint (see
+// keppel.RBACPolicy.Fingerprint), since they do not have IDs of their own.
+type RBACPolicyUsage struct {
+	AccountName       AccountName `db:"account_name"`
+	PolicyFingerprint string      `db:"policy_fingerprint"`
+	// FirstSeenAt is when the policy was first observed by Keppel, either when
+	// it was first used or when it was first included in a credential report.
+	FirstSeenAt time.Time  `db:"first_seen_at"`
+	LastUsedAt  *time.Time `db:"last_used_at"`
+}
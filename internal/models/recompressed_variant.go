@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// RecompressionFormat is a compression format that image layers can be
+// recompressed into to save storage and network transfer.
+type RecompressionFormat string
+
+const (
+	// RecompressionFormatNone is the default value of Account.RecompressionFormat,
+	// meaning that no recompressed variants are generated.
+	RecompressionFormatNone RecompressionFormat = ""
+	// RecompressionFormatZstd recompresses gzip layers with zstd.
+	RecompressionFormatZstd RecompressionFormat = "zstd"
+)
+
+// IsValid returns whether this is one of the predefined formats.
+func (f RecompressionFormat) IsValid() bool {
+	return f == RecompressionFormatNone || f == RecompressionFormatZstd
+}
+
+// RecompressedVariant contains a record from the `recompressed_variants` table.
+//
+// Each record describes the attempt to generate a variant of the image
+// manifest (RepositoryID, Digest) with layers recompressed in the given
+// Format. On success, VariantDigest refers to a manifest in the same
+// repository that has the original manifest as its subject, and the layer
+// sizes of both manifests are recorded for the savings report.
+type RecompressedVariant struct {
+	RepositoryID  int64               `db:"repo_id"`
+	Digest        digest.Digest       `db:"digest"`
+	Format        RecompressionFormat `db:"format"`
+	VariantDigest digest.Digest       `db:"variant_digest"` // empty if ErrorMessage is set
+	// OriginalLayersSizeBytes and VariantLayersSizeBytes are the sums of the
+	// layer sizes in the original manifest and the variant, respectively.
+	OriginalLayersSizeBytes uint64    `db:"original_layers_size_bytes"`
+	VariantLayersSizeBytes  uint64    `db:"variant_layers_size_bytes"`
+	GeneratedAt             time.Time `db:"generated_at"`
+	ErrorMessage            string    `db:"error_message"`
+	// NextAttemptAt is only set if generation failed, and shall be retried.
+	NextAttemptAt *time.Time `db:"next_attempt_at"`
+	// PullCount counts pulls of the variant manifest.
+	PullCount uint64 `db:"pull_count"`
+}
@@ -0,0 +1,33 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "strings"
+
+// RepositoryNamespace contains a record from the `repo_namespaces` table.
+//
+// A namespace comprises all repositories below a certain path prefix within an
+// account (e.g. the namespace with prefix "team-a" contains the repositories
+// "team-a/app" and "team-a/project/app", but not "team-a" itself). The
+// administration of a namespace can be delegated to users that do not have
+// permission to change the account itself.
+type RepositoryNamespace struct {
+	AccountName AccountName `db:"account_name"`
+	Prefix      string      `db:"prefix"`
+	// If not empty, users with matching names may change the policies of this namespace.
+	AdminUserNamePattern string `db:"admin_username_pattern"`
+	// If not nil, the number of manifests in this namespace is limited to this
+	// value (in addition to the quota of the account's auth tenant).
+	ManifestQuota *uint64 `db:"manifest_quota"`
+	// RBACPoliciesJSON and GCPoliciesJSON contain policies like in type Account,
+	// but their repository patterns are relative to the namespace prefix.
+	RBACPoliciesJSON string `db:"rbac_policies_json"`
+	GCPoliciesJSON   string `db:"gc_policies_json"`
+}
+
+// Contains returns whether the repository with the given name (without the
+// account name prefix) is located within this namespace.
+func (ns RepositoryNamespace) Contains(repoName string) bool {
+	return strings.HasPrefix(repoName, ns.Prefix+"/")
+}
@@ -15,6 +15,30 @@ type Repository struct {
 	NextBlobMountSweepAt    *time.Time  `db:"next_blob_mount_sweep_at"` // see tasks.BlobMountSweepJob
 	NextManifestSyncAt      *time.Time  `db:"next_manifest_sync_at"`    // see tasks.ManifestSyncJob (only set for replica accounts)
 	NextGarbageCollectionAt *time.Time  `db:"next_gc_at"`               // see tasks.GarbageCollectManifestsJob
+	NextTagRetentionAt      *time.Time  `db:"next_tag_retention_at"`    // see tasks.TagRetentionJob
+	NextRevalidationAt      *time.Time  `db:"next_revalidation_at"`     // see tasks.ReplicaRevalidationJob (only set while a revalidation is pending)
+	StorageQuotaBytes       *uint64     `db:"storage_quota_bytes"`      // nil = no limit beyond the account quota
+	// IsArchived marks the repo as read-only: pushes are rejected, but pulls still work.
+	// Archived repos are also hidden from repository listings by default.
+	IsArchived bool `db:"is_archived"`
+	// GCPoliciesJSON contains GC policies like in type Account that only apply
+	// to this repo. They take precedence over the policies of the account and
+	// the namespace, which are not applied at all if IgnoreInheritedGCPolicies is set.
+	GCPoliciesJSON            string `db:"gc_policies_json"`
+	IgnoreInheritedGCPolicies bool   `db:"ignore_inherited_gc_policies"`
+	// ManifestSyncErrorMessage and GCErrorMessage contain the error from the
+	// last failed run of the respective janitor job on this repo, or are empty
+	// if the last run succeeded. They are reported as issues on the account.
+	ManifestSyncErrorMessage string `db:"manifest_sync_error_message"`
+	GCErrorMessage           string `db:"gc_error_message"`
+	// RevalidationRepair is set together with NextRevalidationAt if the
+	// revalidation shall schedule a repair for repairable divergences.
+	RevalidationRepair bool `db:"revalidation_repair"`
+	// RevalidatedAt and RevalidationResultJSON describe the result of the last
+	// revalidation of a replica repo against its upstream (see
+	// keppel.ReplicaRevalidationResult).
+	RevalidatedAt          *time.Time `db:"revalidated_at"`
+	RevalidationResultJSON string     `db:"revalidation_result_json"`
 }
 
 // FullName prepends the account name to the repository name.
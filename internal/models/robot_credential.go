@@ -0,0 +1,21 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// RobotCredential contains a record from the `robot_credentials` table.
+//
+// Robot credentials are generated as part of a Kubernetes pull secret. They
+// grant pull access to exactly one repository.
+type RobotCredential struct {
+	ID           int64  `db:"id"`
+	RepositoryID int64  `db:"repo_id"`
+	UserName     string `db:"user_name"`
+	// PasswordHash is the SHA-256 digest of the password (see auth.NewRobotCredential for why this is acceptable).
+	PasswordHash string    `db:"password_hash"`
+	CreatedAt    time.Time `db:"created_at"`
+	CreatedBy    string    `db:"created_by"`
+	ExpiresAt    time.Time `db:"expires_at"`
+}
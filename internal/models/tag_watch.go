@@ -0,0 +1,33 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// TagWatch contains a record from the `tag_watches` table.
+//
+// Tag watches only exist in external replica accounts. They make the janitor
+// check the upstream repository for changes to tags matching TagPattern
+// periodically, regardless of whether anyone pulls those tags.
+type TagWatch struct {
+	ID          int64       `db:"id"`
+	AccountName AccountName `db:"account_name"`
+	RepoName    string      `db:"repo_name"`
+	// TagPattern is a regex that tag names must match in full to be watched
+	// (see regexpext.BoundedRegexp).
+	TagPattern string `db:"tag_pattern"`
+	// WebhookURL is either empty, or an URL that receives a POST request
+	// with a keppel.TagWatchNotification whenever changes are detected.
+	WebhookURL string `db:"webhook_url"`
+	// KnownTagsJSON contains a JSON string of a map[string]digest.Digest with
+	// the matching tags that were seen upstream during the last check, or an
+	// empty string if the upstream has not been checked yet.
+	KnownTagsJSON string     `db:"known_tags_json"`
+	CreatedAt     time.Time  `db:"created_at"`
+	CreatedBy     string     `db:"created_by"`
+	CheckedAt     *time.Time `db:"checked_at"`
+	NextCheckAt   time.Time  `db:"next_check_at"` // see tasks.TagWatchJob
+	// ErrorMessage is empty if the last check succeeded.
+	ErrorMessage string `db:"error_message"`
+}
@@ -17,4 +17,5 @@ type TrivySecurityInfo struct {
 	NextCheckAt         time.Time           `db:"next_check_at"` // see tasks.CheckTrivySecurityStatusJob
 	CheckedAt           *time.Time          `db:"checked_at"`
 	CheckDurationSecs   *float64            `db:"check_duration_secs"`
+	ScannedLayerCount   *int64              `db:"scanned_layer_count"` // only set after a timed-out scan, see tasks.CheckTrivySecurityStatusJob
 }
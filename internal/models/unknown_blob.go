@@ -28,3 +28,13 @@ type UnknownManifest struct {
 	Digest         digest.Digest `db:"digest"`
 	CanBeDeletedAt time.Time     `db:"can_be_deleted_at"`
 }
+
+// OrphanedSegment contains a record from the `orphaned_segments` table.
+// This is only used by tasks.OrphanedSegmentSweepJob().
+type OrphanedSegment struct {
+	AccountName AccountName `db:"account_name"`
+	StorageID   string      `db:"storage_id"`
+	ChunkNumber uint32      `db:"chunk_number"`
+	SizeBytes   uint64      `db:"size_bytes"`
+	DetectedAt  time.Time   `db:"detected_at"`
+}
@@ -0,0 +1,17 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// UpstreamCircuitBreaker contains a record from the `upstream_circuit_breakers` table.
+//
+// A record exists for each upstream registry where the most recent request
+// failed. While OpenUntil is in the future, no requests are sent to that
+// upstream registry.
+type UpstreamCircuitBreaker struct {
+	HostName            string     `db:"hostname"`
+	ConsecutiveFailures int        `db:"consecutive_failures"`
+	OpenUntil           *time.Time `db:"open_until"`
+}
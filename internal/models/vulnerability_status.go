@@ -9,6 +9,8 @@ type VulnerabilityStatus string
 const (
 	// ErrorVulnerabilityStatus is a VulnerabilityStatus that indicates that vulnerability scanning failed.
 	ErrorVulnerabilityStatus VulnerabilityStatus = "Error"
+	// TimedOutVulnerabilityStatus is a VulnerabilityStatus that indicates that vulnerability scanning did not complete within the scan timeout.
+	TimedOutVulnerabilityStatus VulnerabilityStatus = "TimedOut"
 	// PendingVulnerabilityStatus is a VulnerabilityStatus which means that we're not done scanning vulnerabilities yet.
 	PendingVulnerabilityStatus VulnerabilityStatus = "Pending"
 	// UnsupportedVulnerabilityStatus is a VulnerabilityStatus which means that we don't support scanning this manifest.
@@ -32,6 +34,7 @@ const (
 
 var sevMap = map[VulnerabilityStatus]uint{
 	ErrorVulnerabilityStatus:       0,
+	TimedOutVulnerabilityStatus:    0,
 	PendingVulnerabilityStatus:     0,
 	UnsupportedVulnerabilityStatus: 0,
 	CleanSeverity:                  1,
@@ -52,6 +55,7 @@ func (s VulnerabilityStatus) HasReport() bool {
 // MergeVulnerabilityStatuses combines multiple VulnerabilityStatus values into one.
 //
 // * Any ErrorVulnerabilityStatus input results in an ErrorVulnerabilityStatus result.
+// * Otherwise, any TimedOutVulnerabilityStatus input results in a TimedOutVulnerabilityStatus result.
 // * Otherwise, any UnsupportedVulnerabilityStatus input results in an UnsupportedVulnerabilityStatus result.
 // * Otherwise, any PendingVulnerabilityStatus input results in a PendingVulnerabilityStatus result.
 // * Otherwise, the result is the same as the highest individual severity.
@@ -69,6 +73,7 @@ func MergeVulnerabilityStatuses(sevs ...VulnerabilityStatus) VulnerabilityStatus
 	// these special severities can override everything else, in the priority order stated here
 	overrides := []VulnerabilityStatus{
 		ErrorVulnerabilityStatus,
+		TimedOutVulnerabilityStatus,
 		UnsupportedVulnerabilityStatus,
 		PendingVulnerabilityStatus,
 	}
@@ -21,6 +21,13 @@ func TestMergeVulnerabilityStatuses(t *testing.T) {
 	expect(ErrorVulnerabilityStatus, MergeVulnerabilityStatuses(PendingVulnerabilityStatus, ErrorVulnerabilityStatus))
 	expect(ErrorVulnerabilityStatus, MergeVulnerabilityStatuses(ErrorVulnerabilityStatus, HighSeverity))
 
+	expect(ErrorVulnerabilityStatus, MergeVulnerabilityStatuses(TimedOutVulnerabilityStatus, ErrorVulnerabilityStatus))
+
+	expect(TimedOutVulnerabilityStatus, MergeVulnerabilityStatuses(TimedOutVulnerabilityStatus))
+	expect(TimedOutVulnerabilityStatus, MergeVulnerabilityStatuses(TimedOutVulnerabilityStatus, UnsupportedVulnerabilityStatus))
+	expect(TimedOutVulnerabilityStatus, MergeVulnerabilityStatuses(PendingVulnerabilityStatus, TimedOutVulnerabilityStatus))
+	expect(TimedOutVulnerabilityStatus, MergeVulnerabilityStatuses(CriticalSeverity, TimedOutVulnerabilityStatus))
+
 	expect(UnsupportedVulnerabilityStatus, MergeVulnerabilityStatuses(UnsupportedVulnerabilityStatus))
 	expect(UnsupportedVulnerabilityStatus, MergeVulnerabilityStatuses(UnsupportedVulnerabilityStatus, HighSeverity))
 	expect(UnsupportedVulnerabilityStatus, MergeVulnerabilityStatuses(HighSeverity, UnsupportedVulnerabilityStatus))
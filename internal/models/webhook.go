@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package models
+
+import "time"
+
+// Webhook contains a record from the `webhooks` table.
+//
+// Webhooks receive a POST request with a keppel.WebhookNotification for each
+// event of the subscribed types in the account. If RepoPattern is not empty,
+// only events in repositories matching it are delivered. This allows teams
+// that only own some repositories in a shared account to subscribe to just
+// the events that concern them.
+type Webhook struct {
+	ID          int64       `db:"id"`
+	AccountName AccountName `db:"account_name"`
+	// NamespacePrefix is set for webhooks that belong to a repository namespace
+	// (see RepositoryNamespace). These webhooks can be managed by the namespace
+	// admins, and only receive events in repositories within the namespace.
+	NamespacePrefix string `db:"namespace_prefix"`
+	// RepoPattern is a regex that repository names must match in full (see
+	// regexpext.BoundedRegexp), or empty to match all repositories. If
+	// NamespacePrefix is set, it is matched against the part of the repository
+	// name after the namespace prefix.
+	RepoPattern string `db:"repo_pattern"`
+	URL         string `db:"url"`
+	// EventTypes is a comma-separated list of keppel.WebhookEventType values.
+	EventTypes string    `db:"event_types"`
+	CreatedAt  time.Time `db:"created_at"`
+	CreatedBy  string    `db:"created_by"`
+	// Secret is used to sign the notifications sent to this webhook (see
+	// keppel.SignWebhookPayload). It is only shown to the user once, when the
+	// webhook is created. Webhooks created before signatures were introduced
+	// have an empty secret, and their notifications are not signed.
+	Secret string `db:"secret"`
+}
+
+// WebhookDelivery contains a record from the `webhook_deliveries` table.
+//
+// Each record is a notification that is waiting to be delivered to its
+// webhook by tasks.WebhookDeliveryJob.
+type WebhookDelivery struct {
+	ID        int64 `db:"id"`
+	WebhookID int64 `db:"webhook_id"`
+	// PayloadJSON contains the serialized keppel.WebhookNotification.
+	PayloadJSON   string    `db:"payload_json"`
+	CreatedAt     time.Time `db:"created_at"`
+	FailedCount   uint64    `db:"failed_count"`
+	NextAttemptAt time.Time `db:"next_attempt_at"`
+	// ErrorMessage is empty unless a previous delivery attempt failed.
+	ErrorMessage string `db:"error_message"`
+}
@@ -0,0 +1,228 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var (
+	snapshotReposQuery = sqlext.SimplifyWhitespace(`
+		SELECT name FROM repos WHERE account_name = $1 ORDER BY name
+	`)
+	snapshotManifestsQuery = sqlext.SimplifyWhitespace(`
+		SELECT r.name, m.digest FROM manifests m JOIN repos r ON m.repo_id = r.id
+		 WHERE r.account_name = $1 ORDER BY r.name, m.digest
+	`)
+	snapshotTagsQuery = sqlext.SimplifyWhitespace(`
+		SELECT r.name, t.name, t.digest FROM tags t JOIN repos r ON t.repo_id = r.id
+		 WHERE r.account_name = $1 ORDER BY r.name, t.name
+	`)
+)
+
+// CreateAccountSnapshot records the current repositories, manifests and tags
+// of the given account in a new AccountSnapshot.
+func (p *Processor) CreateAccountSnapshot(account models.ReducedAccount, actx keppel.AuditContext) (models.AccountSnapshot, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return models.AccountSnapshot{}, err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	var (
+		contents keppel.AccountSnapshotContents
+		repoIdx  = make(map[string]int)
+	)
+	err = sqlext.ForeachRow(tx, snapshotReposQuery, []any{account.Name}, func(rows *sql.Rows) error {
+		repo := keppel.AccountSnapshotRepository{
+			Manifests: []digest.Digest{},
+			Tags:      []keppel.AccountSnapshotTag{},
+		}
+		err := rows.Scan(&repo.Name)
+		repoIdx[repo.Name] = len(contents.Repositories)
+		contents.Repositories = append(contents.Repositories, repo)
+		return err
+	})
+	if err != nil {
+		return models.AccountSnapshot{}, err
+	}
+	err = sqlext.ForeachRow(tx, snapshotManifestsQuery, []any{account.Name}, func(rows *sql.Rows) error {
+		var (
+			repoName       string
+			manifestDigest digest.Digest
+		)
+		err := rows.Scan(&repoName, &manifestDigest)
+		repo := &contents.Repositories[repoIdx[repoName]]
+		repo.Manifests = append(repo.Manifests, manifestDigest)
+		return err
+	})
+	if err != nil {
+		return models.AccountSnapshot{}, err
+	}
+	err = sqlext.ForeachRow(tx, snapshotTagsQuery, []any{account.Name}, func(rows *sql.Rows) error {
+		var (
+			repoName string
+			tag      keppel.AccountSnapshotTag
+		)
+		err := rows.Scan(&repoName, &tag.Name, &tag.Digest)
+		repo := &contents.Repositories[repoIdx[repoName]]
+		repo.Tags = append(repo.Tags, tag)
+		return err
+	})
+	if err != nil {
+		return models.AccountSnapshot{}, err
+	}
+	if contents.Repositories == nil {
+		contents.Repositories = []keppel.AccountSnapshotRepository{}
+	}
+
+	buf, err := json.Marshal(contents)
+	if err != nil {
+		return models.AccountSnapshot{}, err
+	}
+	snapshot := models.AccountSnapshot{
+		AccountName:  account.Name,
+		CreatedAt:    p.timeNow(),
+		CreatedBy:    actx.UserIdentity.UserName(),
+		ContentsJSON: string(buf),
+	}
+	err = tx.Insert(&snapshot)
+	if err != nil {
+		return models.AccountSnapshot{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.AccountSnapshot{}, err
+	}
+
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		p.auditor.Record(audittools.Event{
+			Time:       p.timeNow(),
+			Request:    actx.Request,
+			User:       userInfo,
+			ReasonCode: http.StatusCreated,
+			Action:     cadf.CreateAction,
+			Target:     AuditAccountSnapshot{Account: account, Snapshot: snapshot},
+		})
+	}
+	return snapshot, nil
+}
+
+// RestoreAccountSnapshot moves all tags in the given account back to where
+// they pointed at the time of the snapshot. Tags whose repository or manifest
+// does not exist anymore are skipped. Tags that were created after the
+// snapshot are left untouched.
+func (p *Processor) RestoreAccountSnapshot(account models.ReducedAccount, snapshot models.AccountSnapshot, actx keppel.AuditContext) (keppel.AccountSnapshotRestoreResult, error) {
+	var contents keppel.AccountSnapshotContents
+	err := json.Unmarshal([]byte(snapshot.ContentsJSON), &contents)
+	if err != nil {
+		return keppel.AccountSnapshotRestoreResult{}, err
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return keppel.AccountSnapshotRestoreResult{}, err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	result := keppel.AccountSnapshotRestoreResult{
+		RestoredTags: []keppel.RestoredTag{},
+		SkippedTags:  []keppel.SkippedTag{},
+	}
+	var auditTargets []auditTag
+	for _, snapshotRepo := range contents.Repositories {
+		if len(snapshotRepo.Tags) == 0 {
+			continue
+		}
+		repo, err := keppel.FindRepository(tx, snapshotRepo.Name, account.Name)
+		if errors.Is(err, sql.ErrNoRows) {
+			for _, tag := range snapshotRepo.Tags {
+				result.SkippedTags = append(result.SkippedTags, keppel.SkippedTag{
+					RepositoryName: snapshotRepo.Name,
+					Name:           tag.Name,
+					Digest:         tag.Digest,
+					Reason:         "repository does not exist anymore",
+				})
+			}
+			continue
+		}
+		if err != nil {
+			return keppel.AccountSnapshotRestoreResult{}, err
+		}
+
+		for _, tag := range snapshotRepo.Tags {
+			currentDigestStr, err := tx.SelectStr(`SELECT digest FROM tags WHERE repo_id = $1 AND name = $2`, repo.ID, tag.Name)
+			if err != nil {
+				return keppel.AccountSnapshotRestoreResult{}, err
+			}
+			if currentDigestStr == tag.Digest.String() {
+				continue
+			}
+			_, err = keppel.FindManifest(tx, *repo, tag.Digest)
+			if errors.Is(err, sql.ErrNoRows) {
+				result.SkippedTags = append(result.SkippedTags, keppel.SkippedTag{
+					RepositoryName: repo.Name,
+					Name:           tag.Name,
+					Digest:         tag.Digest,
+					Reason:         "manifest does not exist anymore",
+				})
+				continue
+			}
+			if err != nil {
+				return keppel.AccountSnapshotRestoreResult{}, err
+			}
+
+			err = upsertTag(tx, models.Tag{
+				RepositoryID: repo.ID,
+				Name:         tag.Name,
+				Digest:       tag.Digest,
+				PushedAt:     p.timeNow(),
+			})
+			if err != nil {
+				return keppel.AccountSnapshotRestoreResult{}, err
+			}
+			result.RestoredTags = append(result.RestoredTags, keppel.RestoredTag{
+				RepositoryName: repo.Name,
+				Name:           tag.Name,
+				Digest:         tag.Digest,
+				PreviousDigest: digest.Digest(currentDigestStr),
+			})
+			auditTargets = append(auditTargets, auditTag{
+				Account:    account,
+				Repository: *repo,
+				Digest:     tag.Digest,
+				TagName:    tag.Name,
+			})
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return keppel.AccountSnapshotRestoreResult{}, err
+	}
+
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		for _, target := range auditTargets {
+			p.auditor.Record(audittools.Event{
+				Time:       p.timeNow(),
+				Request:    actx.Request,
+				User:       userInfo,
+				ReasonCode: http.StatusOK,
+				Action:     cadf.UpdateAction,
+				Target:     target,
+			})
+		}
+	}
+	return result, nil
+}
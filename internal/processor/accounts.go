@@ -26,6 +26,14 @@ import (
 
 // GetPlatformFilterFromPrimaryAccount takes a replica account and queries the peer holding the primary account for that account.
 func (p *Processor) GetPlatformFilterFromPrimaryAccount(ctx context.Context, peer models.Peer, replicaAccount models.Account) (models.PlatformFilter, error) {
+	upstreamAccount, err := p.getPrimaryAccount(ctx, peer, replicaAccount)
+	if err != nil {
+		return nil, err
+	}
+	return upstreamAccount.PlatformFilter, nil
+}
+
+func (p *Processor) getPrimaryAccount(ctx context.Context, peer models.Peer, replicaAccount models.Account) (keppel.Account, error) {
 	viewScope := auth.Scope{
 		ResourceType: "keppel_account",
 		ResourceName: string(replicaAccount.Name),
@@ -33,17 +41,116 @@ func (p *Processor) GetPlatformFilterFromPrimaryAccount(ctx context.Context, pee
 	}
 	client, err := peerclient.New(ctx, p.cfg, peer, viewScope)
 	if err != nil {
-		return nil, err
+		return keppel.Account{}, err
 	}
 
 	var upstreamAccount keppel.Account
 	err = client.GetForeignAccountConfigurationInto(ctx, &upstreamAccount, replicaAccount.Name)
+	return upstreamAccount, err
+}
+
+// SyncPoliciesFromPrimaryAccount takes a replica account with
+// SyncPoliciesFromPrimary = true, and copies the RBAC policies and GC
+// policies of the primary account into it. Nothing is done for accounts where
+// policy sync is not enabled, or where it is suspended by a local override.
+func (p *Processor) SyncPoliciesFromPrimaryAccount(ctx context.Context, account models.Account, actx keppel.AuditContext) error {
+	if !account.SyncPoliciesFromPrimary || account.PoliciesOverriddenLocally || account.UpstreamPeerHostName == "" {
+		return nil
+	}
+	peer, err := keppel.GetPeerFromAccount(p.db, account)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return upstreamAccount.PlatformFilter, nil
+	upstreamAccount, err := p.getPrimaryAccount(ctx, peer, account)
+	if err != nil {
+		return fmt.Errorf("cannot get configuration of primary account: %w", err)
+	}
+
+	// the policies are validated in the same way as in CreateOrUpdateAccount(),
+	// but from the perspective of this replica account
+	targetAccount := account
+	if len(upstreamAccount.GCPolicies) == 0 {
+		targetAccount.GCPoliciesJSON = "[]"
+	} else {
+		for _, policy := range upstreamAccount.GCPolicies {
+			err := policy.Validate()
+			if err != nil {
+				return fmt.Errorf("invalid GC policy on primary account: %w", err)
+			}
+		}
+		buf, _ := json.Marshal(upstreamAccount.GCPolicies)
+		targetAccount.GCPoliciesJSON = string(buf)
+	}
+	if len(upstreamAccount.RBACPolicies) == 0 {
+		targetAccount.RBACPoliciesJSON = ""
+	} else {
+		for idx, policy := range upstreamAccount.RBACPolicies {
+			err := policy.ValidateAndNormalize(keppel.OnFirstUseStrategy)
+			if err != nil {
+				return fmt.Errorf("invalid RBAC policy on primary account: %w", err)
+			}
+			upstreamAccount.RBACPolicies[idx] = policy
+		}
+		buf, _ := json.Marshal(upstreamAccount.RBACPolicies)
+		targetAccount.RBACPoliciesJSON = string(buf)
+	}
+
+	// GC policies from the primary account are subject to the approval policy
+	// of this account, in the same way as changes through the API
+	if targetAccount.GCPoliciesJSON != account.GCPoliciesJSON {
+		approvalPolicy, err := keppel.ParseApprovalPolicy(account.Reduced())
+		if err != nil {
+			return err
+		}
+		pc, err := p.pendingChangeForGCPoliciesUpdate(account, approvalPolicy, upstreamAccount.GCPolicies)
+		if err != nil {
+			return err
+		}
+		if pc != nil {
+			targetAccount.GCPoliciesJSON = account.GCPoliciesJSON
+			err = p.submitRecurringPendingChange(account.Reduced(), pc, actx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if targetAccount.GCPoliciesJSON == account.GCPoliciesJSON && targetAccount.RBACPoliciesJSON == account.RBACPoliciesJSON {
+		return nil
+	}
+
+	var epochIncrement int64
+	if targetAccount.RBACPoliciesJSON != account.RBACPoliciesJSON {
+		// invalidate tokens that were issued under the previous policies
+		epochIncrement = 1
+	}
+	_, err = p.db.Exec(`UPDATE accounts SET gc_policies_json = $1, rbac_policies_json = $2, rbac_policy_epoch = rbac_policy_epoch + $3 WHERE name = $4`,
+		targetAccount.GCPoliciesJSON, targetAccount.RBACPoliciesJSON, epochIncrement, account.Name)
+	if err != nil {
+		return err
+	}
+	err = keppel.NotifyAccountChanged(p.db, account.Name)
+	if err != nil {
+		return err
+	}
+
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		p.auditor.Record(audittools.Event{
+			Time:       p.timeNow(),
+			Request:    actx.Request,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     cadf.UpdateAction,
+			Target:     AuditAccount{Account: targetAccount},
+		})
+	}
+	return nil
 }
 
+var customDomainInUseQuery = sqlext.SimplifyWhitespace(`
+	SELECT COUNT(*) > 0 FROM accounts WHERE custom_domain = $1 AND name != $2 AND custom_domain_verified_at IS NOT NULL
+`)
+
 var looksLikeAPIVersionRx = regexp.MustCompile(`^v[0-9][1-9]*$`)
 var ErrAccountNameEmpty = errors.New("account name cannot be empty string")
 
@@ -77,6 +184,11 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 	// this distinction is important because several fields can only be set at creation
 	var targetAccount models.Account
 	if originalAccount == nil {
+		// org-specific naming conventions only apply to new accounts
+		rerr := keppel.ValidateNewAccountName(ctx, p.cfg, p.nvd, account.Name, account.AuthTenantID)
+		if rerr != nil {
+			return models.Account{}, rerr
+		}
 		targetAccount = models.Account{
 			Name:                     account.Name,
 			AuthTenantID:             account.AuthTenantID,
@@ -104,6 +216,46 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 		targetAccount.GCPoliciesJSON = string(buf)
 	}
 
+	// validate admission policies
+	if len(account.AdmissionPolicies) == 0 {
+		targetAccount.AdmissionPoliciesJSON = ""
+	} else {
+		isPolicyName := make(map[string]bool, len(account.AdmissionPolicies))
+		for _, policy := range account.AdmissionPolicies {
+			_, err := policy.Compile()
+			if err != nil {
+				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+			}
+			if isPolicyName[policy.Name] {
+				err := fmt.Errorf("duplicate admission policy name: %q", policy.Name)
+				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+			}
+			isPolicyName[policy.Name] = true
+		}
+		buf, _ := json.Marshal(account.AdmissionPolicies)
+		targetAccount.AdmissionPoliciesJSON = string(buf)
+	}
+
+	// update approval policy (there is nothing to validate here)
+	if account.ApprovalPolicy == nil {
+		targetAccount.ApprovalPolicyJSON = ""
+	} else {
+		buf, _ := json.Marshal(*account.ApprovalPolicy)
+		targetAccount.ApprovalPolicyJSON = string(buf)
+	}
+
+	// validate tag protection policies
+	rerr := keppel.ApplyTagProtectionPoliciesToAccount(account.TagProtectionPolicies, &targetAccount)
+	if rerr != nil {
+		return models.Account{}, rerr
+	}
+
+	// validate tag retention policies
+	rerr = keppel.ApplyTagRetentionPoliciesToAccount(account.TagRetentionPolicies, &targetAccount)
+	if rerr != nil {
+		return models.Account{}, rerr
+	}
+
 	// validate replication policy (for OnFirstUseStrategy, the peer hostname is
 	// checked for correctness down below when validating the platform filter)
 	var originalStrategy keppel.ReplicationStrategy
@@ -138,6 +290,31 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 			return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
 		}
 		replicationStrategy = rp.Strategy
+
+		// the operator may restrict which external registries can be replicated from
+		// (this is only checked for new accounts since the upstream cannot be
+		// changed later, and is checked again for each replication anyway)
+		if originalAccount == nil && replicationStrategy == keppel.FromExternalOnFirstUseStrategy {
+			rerr := p.cfg.ExternalUpstreamHosts.Check(targetAccount.ExternalPeerURL)
+			if rerr != nil {
+				return models.Account{}, rerr.WithStatus(http.StatusUnprocessableEntity)
+			}
+		}
+
+		// if passwords are given as references into the secret store, check that they can be resolved
+		passwordRefs := []string{targetAccount.ExternalPeerPasswordRef}
+		for _, set := range targetAccount.ExternalPeerCredentials {
+			passwordRefs = append(passwordRefs, set.PasswordRef)
+		}
+		for _, ref := range passwordRefs {
+			if ref == "" {
+				continue
+			}
+			_, rerr := keppel.ResolveSecretRef(ctx, p.secd, targetAccount.AuthTenantID, "password_ref", ref)
+			if rerr != nil {
+				return models.Account{}, rerr.WithStatus(http.StatusUnprocessableEntity)
+			}
+		}
 	}
 
 	// validate RBAC policies
@@ -155,6 +332,15 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 		targetAccount.RBACPoliciesJSON = string(buf)
 	}
 
+	// while policies are synced from the primary account, local changes would be
+	// overwritten by the next sync, so they require an explicit local override
+	if originalAccount != nil && targetAccount.SyncPoliciesFromPrimary && !targetAccount.PoliciesOverriddenLocally {
+		if targetAccount.RBACPoliciesJSON != originalAccount.RBACPoliciesJSON || targetAccount.GCPoliciesJSON != originalAccount.GCPoliciesJSON {
+			msg := "policies of this account are synced from the primary account; set replication.local_policy_override to change them locally"
+			return models.Account{}, keppel.AsRegistryV2Error(errors.New(msg)).WithStatus(http.StatusConflict)
+		}
+	}
+
 	// validate validation policy
 	if account.ValidationPolicy != nil {
 		rerr := account.ValidationPolicy.ApplyToAccount(&targetAccount)
@@ -205,7 +391,139 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 		return models.Account{}, keppel.AsRegistryV2Error(errors.New(`cannot change platform filter on existing account`)).WithStatus(http.StatusConflict)
 	}
 
-	rerr := setCustomFields(&targetAccount)
+	// validate default platform
+	if account.DefaultPlatform == "" {
+		targetAccount.DefaultPlatform = ""
+	} else {
+		_, err := keppel.ParsePlatform(account.DefaultPlatform)
+		if err != nil {
+			return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+		targetAccount.DefaultPlatform = account.DefaultPlatform
+	}
+	targetAccount.ServeBlobsViaCDN = account.ServeBlobsViaCDN
+	targetAccount.ShareBlobs = account.ShareBlobs
+	targetAccount.CascadeDeleteReferrers = account.CascadeDeleteReferrers
+
+	// validate replication retry hints
+	if account.ReplicationRetryHints && replicationStrategy == keppel.NoReplicationStrategy {
+		return models.Account{}, keppel.AsRegistryV2Error(errors.New(`replication retry hints are only allowed on replica accounts`)).WithStatus(http.StatusUnprocessableEntity)
+	}
+	targetAccount.ReplicationRetryHints = account.ReplicationRetryHints
+
+	// validate lazy pull format (variants can only be generated in primary
+	// accounts, since replica accounts only contain what their upstream has)
+	if !account.LazyPullFormat.IsValid() {
+		msg := fmt.Sprintf("%q is not a valid lazy pull format", account.LazyPullFormat)
+		return models.Account{}, keppel.AsRegistryV2Error(errors.New(msg)).WithStatus(http.StatusUnprocessableEntity)
+	}
+	if account.LazyPullFormat != models.LazyPullFormatNone && replicationStrategy != keppel.NoReplicationStrategy {
+		return models.Account{}, keppel.AsRegistryV2Error(errors.New(`lazy pull format is only allowed on primary accounts`)).WithStatus(http.StatusUnprocessableEntity)
+	}
+	targetAccount.LazyPullFormat = account.LazyPullFormat
+
+	// validate recompression format (same restriction as for the lazy pull format)
+	if !account.RecompressionFormat.IsValid() {
+		msg := fmt.Sprintf("%q is not a valid recompression format", account.RecompressionFormat)
+		return models.Account{}, keppel.AsRegistryV2Error(errors.New(msg)).WithStatus(http.StatusUnprocessableEntity)
+	}
+	if account.RecompressionFormat != models.RecompressionFormatNone && replicationStrategy != keppel.NoReplicationStrategy {
+		return models.Account{}, keppel.AsRegistryV2Error(errors.New(`recompression format is only allowed on primary accounts`)).WithStatus(http.StatusUnprocessableEntity)
+	}
+	targetAccount.RecompressionFormat = account.RecompressionFormat
+
+	// validate storage placement rules
+	rerr = keppel.ApplyStoragePlacementRulesToAccount(account.StoragePlacement, p.sd, &targetAccount)
+	if rerr != nil {
+		return models.Account{}, rerr
+	}
+
+	// validate minimum promotion state
+	if !account.MinPullPromotionState.IsValid() {
+		msg := fmt.Sprintf("%q is not a valid promotion state", account.MinPullPromotionState)
+		return models.Account{}, keppel.AsRegistryV2Error(errors.New(msg)).WithStatus(http.StatusUnprocessableEntity)
+	}
+	targetAccount.MinPullPromotionState = account.MinPullPromotionState
+
+	// validate response headers and pull terms
+	rerr = keppel.ApplyResponseHeadersToAccount(account.ResponseHeaders, &targetAccount)
+	if rerr != nil {
+		return models.Account{}, rerr
+	}
+	if account.PullTerms == nil {
+		targetAccount.PullTermsVersion = ""
+		targetAccount.PullTermsURL = ""
+	} else {
+		rerr := account.PullTerms.ApplyToAccount(&targetAccount)
+		if rerr != nil {
+			return models.Account{}, rerr
+		}
+	}
+
+	// validate custom metadata
+	var metadata map[string]string
+	if account.Metadata != nil {
+		metadata = *account.Metadata
+	}
+	rerr = keppel.ApplyAccountMetadataToAccount(metadata, p.cfg.AccountMetadataSchema, &targetAccount, originalAccount == nil)
+	if rerr != nil {
+		return models.Account{}, rerr
+	}
+
+	// validate custom domain
+	previousCustomDomain := targetAccount.CustomDomain
+	if account.CustomDomain == nil {
+		targetAccount.CustomDomain = ""
+		targetAccount.CustomDomainCertificateRef = ""
+	} else {
+		rerr := account.CustomDomain.ApplyToAccount(&targetAccount, p.cfg)
+		if rerr != nil {
+			return models.Account{}, rerr
+		}
+		if ref := targetAccount.CustomDomainCertificateRef; ref != "" {
+			secret, rerr := keppel.ResolveSecretRef(ctx, p.secd, targetAccount.AuthTenantID, "certificate_ref", ref)
+			if rerr != nil {
+				return models.Account{}, rerr.WithStatus(http.StatusUnprocessableEntity)
+			}
+			_, err := keppel.ParseCustomDomainCertificate(secret, targetAccount.CustomDomain)
+			if err != nil {
+				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+			}
+		}
+		isInUse, err := p.db.SelectBool(customDomainInUseQuery, targetAccount.CustomDomain, targetAccount.Name)
+		if err != nil {
+			return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+		}
+		if isInUse {
+			msg := fmt.Errorf("custom domain %q is already in use by another account", targetAccount.CustomDomain)
+			return models.Account{}, keppel.AsRegistryV2Error(msg).WithStatus(http.StatusConflict)
+		}
+	}
+	if targetAccount.CustomDomain != previousCustomDomain {
+		// a new custom domain is only served once the janitor has verified its ownership
+		targetAccount.CustomDomainVerificationToken = ""
+		if targetAccount.CustomDomain != "" {
+			targetAccount.CustomDomainVerificationToken = "keppel-verification=" + p.generateStorageID()
+		}
+		targetAccount.CustomDomainVerifiedAt = nil
+		targetAccount.NextCustomDomainVerificationAt = nil
+	}
+
+	// validate content encryption key (only newly written manifest contents are
+	// encrypted with it; existing contents keep the key that they were written with)
+	if ref := account.EncryptionKeyRef; ref != "" {
+		secret, rerr := keppel.ResolveSecretRef(ctx, p.secd, targetAccount.AuthTenantID, "content_encryption_key_ref", ref)
+		if rerr != nil {
+			return models.Account{}, rerr.WithStatus(http.StatusUnprocessableEntity)
+		}
+		_, err := keppel.ParseContentEncryptionKey(secret)
+		if err != nil {
+			return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+	}
+	targetAccount.ContentEncryptionKeyRef = account.EncryptionKeyRef
+
+	rerr = setCustomFields(&targetAccount)
 	if rerr != nil {
 		return models.Account{}, rerr
 	}
@@ -252,6 +570,10 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 		if err != nil {
 			return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
 		}
+		err = keppel.NotifyAccountChanged(tx, targetAccount.Name)
+		if err != nil {
+			return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+		}
 
 		// commit the changes
 		err = tx.Commit()
@@ -271,11 +593,27 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 		}
 	} else {
 		// originalAccount != nil: update if necessary
+		if originalAccount.RBACPoliciesJSON != targetAccount.RBACPoliciesJSON {
+			// invalidate tokens that were issued under the previous policies
+			targetAccount.RBACPolicyEpoch++
+		}
 		if !reflect.DeepEqual(*originalAccount, targetAccount) {
 			_, err := p.db.Update(&targetAccount)
 			if err != nil {
 				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
 			}
+			err = keppel.NotifyAccountChanged(p.db, targetAccount.Name)
+			if err != nil {
+				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+			}
+		}
+		if originalAccount.ContentEncryptionKeyRef != targetAccount.ContentEncryptionKeyRef {
+			// have all manifests validated soon, which re-encrypts their contents with the new key,
+			// and back them up again, since backups of encrypted manifests contain the ciphertext
+			_, err := p.db.Exec(scheduleReencryptionQuery, p.timeNow(), targetAccount.Name)
+			if err != nil {
+				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+			}
 		}
 
 		// audit log is necessary for all changes except to InMaintenance
@@ -299,6 +637,11 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 
 var (
 	markAccountForDeletion = `UPDATE accounts SET is_deleting = TRUE, next_deletion_attempt_at = $1 WHERE name = $2`
+
+	scheduleReencryptionQuery = sqlext.SimplifyWhitespace(`
+		UPDATE manifests SET next_validation_at = LEAST(next_validation_at, $1), backed_up_at = NULL
+		 WHERE repo_id IN (SELECT id FROM repos WHERE account_name = $2)
+	`)
 )
 
 func (p *Processor) MarkAccountForDeletion(account models.Account, actx keppel.AuditContext) error {
@@ -306,6 +649,10 @@ func (p *Processor) MarkAccountForDeletion(account models.Account, actx keppel.A
 	if err != nil {
 		return err
 	}
+	err = keppel.NotifyAccountChanged(p.db, account.Name)
+	if err != nil {
+		return err
+	}
 
 	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
 		p.auditor.Record(audittools.Event{
@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// checkAdmissionWebhook submits the given manifest push to the external
+// admission webhook, and returns an error if the push is denied. If the
+// webhook decides to quarantine the manifest, the manifest is put into
+// quarantine instead.
+func (p *Processor) checkAdmissionWebhook(ctx context.Context, cfg keppel.AdmissionWebhook, account models.ReducedAccount, repo models.Repository, manifest *models.Manifest, opts validateAndStoreManifestOpts, labels, annotations map[string]string) error {
+	review := keppel.AdmissionReview{
+		Account: keppel.AdmissionReviewAccount{
+			Name:         account.Name,
+			AuthTenantID: account.AuthTenantID,
+			IsReplica:    account.UpstreamPeerHostName != "" || account.ExternalPeerURL != "",
+		},
+		Repository: repo.FullName(),
+		Tag:        opts.Tag,
+		Manifest: keppel.AdmissionReviewManifest{
+			Digest:       manifest.Digest.String(),
+			MediaType:    manifest.MediaType,
+			ArtifactType: manifest.ArtifactType,
+			SizeBytes:    manifest.SizeBytes,
+			Labels:       labels,
+			Annotations:  annotations,
+		},
+		Actor: keppel.NewAdmissionReviewActor(opts.Actor),
+	}
+
+	resp, err := submitAdmissionReview(ctx, cfg, review)
+	if err != nil {
+		logg.Error("admission webhook failed for manifest %s in %s: %s", manifest.Digest, repo.FullName(), err.Error())
+		if cfg.FailOpen {
+			AdmissionWebhookReviewCounter.WithLabelValues(string(account.Name), "error-fail-open").Inc()
+			return nil
+		}
+		AdmissionWebhookReviewCounter.WithLabelValues(string(account.Name), "error-fail-closed").Inc()
+		return keppel.ErrUnavailable.With("admission webhook is not available, please retry later")
+	}
+	AdmissionWebhookReviewCounter.WithLabelValues(string(account.Name), string(resp.Decision)).Inc()
+
+	switch resp.Decision {
+	case keppel.AdmissionDeny:
+		msg := "manifest was rejected by admission webhook"
+		if resp.Message != "" {
+			msg += ": " + resp.Message
+		}
+		return keppel.ErrDenied.With(msg).WithStatus(http.StatusForbidden).WithDetail(keppel.RegistryV2ErrorDetail{
+			Reason: keppel.ReasonAdmissionWebhook,
+		})
+	case keppel.AdmissionQuarantine:
+		// if an admission policy has already quarantined the manifest, its reason takes precedence
+		if manifest.QuarantinedAt == nil {
+			now := p.timeNow()
+			manifest.QuarantinedAt = &now
+			manifest.QuarantineReason = "quarantined by admission webhook"
+			if resp.Message != "" {
+				manifest.QuarantineReason += ": " + resp.Message
+			}
+		}
+	}
+	return nil
+}
+
+func submitAdmissionReview(ctx context.Context, cfg keppel.AdmissionWebhook, review keppel.AdmissionReview) (*keppel.AdmissionReviewResponse, error) {
+	reqBody, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+
+	// the timeout covers the entire request including reading the response body
+	client := &http.Client{Timeout: cfg.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected 200 OK, but got %s: %q", resp.Status, string(respBody))
+	}
+
+	var result keppel.AdmissionReviewResponse
+	err = json.Unmarshal(respBody, &result)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode response: %w", err)
+	}
+	switch result.Decision {
+	case keppel.AdmissionAllow, keppel.AdmissionDeny, keppel.AdmissionQuarantine:
+		return &result, nil
+	case "":
+		return nil, errors.New(`response does not contain a "decision"`)
+	default:
+		return nil, fmt.Errorf("response contains unknown decision %q", result.Decision)
+	}
+}
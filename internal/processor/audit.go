@@ -5,10 +5,12 @@ package processor
 
 import (
 	"encoding/json"
+	"strconv"
 
 	"github.com/sapcc/go-api-declarations/cadf"
 	"github.com/sapcc/go-bits/must"
 
+	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 )
 
@@ -58,3 +60,62 @@ func (a AuditQuotas) Render() cadf.Resource {
 		},
 	}
 }
+
+// AuditReplicationHealth is an audittools.Target.
+type AuditReplicationHealth struct {
+	Account models.ReducedAccount
+	Health  keppel.ReplicationHealth
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditReplicationHealth) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI:   "docker-registry/account",
+		ID:        string(a.Account.Name),
+		ProjectID: a.Account.AuthTenantID,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("replication-health", a.Health)),
+		},
+	}
+}
+
+// AuditAccountSnapshot is an audittools.Target.
+type AuditAccountSnapshot struct {
+	Account  models.ReducedAccount
+	Snapshot models.AccountSnapshot
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditAccountSnapshot) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI:   "docker-registry/account/snapshot",
+		Name:      string(a.Account.Name),
+		ID:        strconv.FormatInt(a.Snapshot.ID, 10),
+		ProjectID: a.Account.AuthTenantID,
+	}
+}
+
+// AuditPendingChange is an audittools.Target.
+type AuditPendingChange struct {
+	Account models.ReducedAccount
+	Change  models.PendingChange
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditPendingChange) Render() cadf.Resource {
+	res := cadf.Resource{
+		TypeURI:   "docker-registry/account/pending-change",
+		Name:      string(a.Account.Name),
+		ID:        strconv.FormatInt(a.Change.ID, 10),
+		ProjectID: a.Account.AuthTenantID,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("kind", a.Change.Kind)),
+			must.Return(cadf.NewJSONAttachment("requested-by", a.Change.RequestedBy)),
+		},
+	}
+	if a.Change.PayloadJSON != "" {
+		attachment := must.Return(cadf.NewJSONAttachment("payload", json.RawMessage(a.Change.PayloadJSON)))
+		res.Attachments = append(res.Attachments, attachment)
+	}
+	return res
+}
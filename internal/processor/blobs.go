@@ -15,10 +15,12 @@ import (
 
 	"github.com/containers/image/v5/manifest"
 	"github.com/go-gorp/gorp/v3"
+	"github.com/opencontainers/go-digest"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/logg"
 
 	"github.com/sapcc/keppel/internal/api"
+	"github.com/sapcc/keppel/internal/client"
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 )
@@ -110,6 +112,8 @@ var (
 )
 
 // ReplicateBlob replicates the given blob from its account's upstream registry.
+// If another account that shares its blobs with this account already has the
+// blob, it is copied from there instead (see keppel.FindSharedBlob).
 //
 // If a ResponseWriter is given, the response to the GET request to the upstream
 // registry is also copied into it as the blob contents are being streamed into
@@ -117,14 +121,73 @@ var (
 // this happened. It may be false if an error occurred before writing into the
 // ResponseWriter took place.
 func (p *Processor) ReplicateBlob(ctx context.Context, blob models.Blob, account models.ReducedAccount, repo models.Repository, w http.ResponseWriter) (responseWasWritten bool, returnErr error) {
-	// mark this blob as currently being replicated
+	pendingBlob, err := p.beginBlobReplication(blob, account)
+	if err != nil {
+		return false, err
+	}
+	return p.finishBlobReplication(ctx, pendingBlob, blob, account, repo, w)
+}
+
+// ReplicateBlobInBackground is like ReplicateBlob, but it returns as soon as
+// the replication has been started. The blob contents are then replicated by
+// a separate goroutine. GetBlobReplicationProgress() can be used to check on
+// the replication while it is running.
+//
+// The given `release` function is called once the replication has ended (or
+// right away if it could not be started). The caller uses this to hold a slot
+// in its concurrency limit for the duration of the replication.
+func (p *Processor) ReplicateBlobInBackground(ctx context.Context, blob models.Blob, account models.ReducedAccount, repo models.Repository, release func()) error {
+	pendingBlob, err := p.beginBlobReplication(blob, account)
+	if err != nil {
+		release()
+		return err
+	}
+
+	// the replication shall continue when the request that triggered it ends
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer release()
+		_, err := p.finishBlobReplication(ctx, pendingBlob, blob, account, repo, nil)
+		if err != nil {
+			logg.Error("while trying to replicate blob %s in %s/%s in the background: %s",
+				blob.Digest, account.Name, repo.Name, err.Error())
+		}
+	}()
+	return nil
+}
+
+// GetBlobReplicationProgress returns the PendingBlob entry for the given blob
+// if it is currently being replicated, or nil otherwise.
+func (p *Processor) GetBlobReplicationProgress(accountName models.AccountName, blobDigest digest.Digest) (*models.PendingBlob, error) {
+	var pendingBlob models.PendingBlob
+	err := p.db.SelectOne(&pendingBlob,
+		`SELECT * FROM pending_blobs WHERE account_name = $1 AND digest = $2`,
+		accountName, blobDigest,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pendingBlob, nil
+}
+
+// beginBlobReplication marks the given blob as currently being replicated. If
+// another worker is already replicating it, ErrConcurrentReplication is returned.
+func (p *Processor) beginBlobReplication(blob models.Blob, account models.ReducedAccount) (models.PendingBlob, error) {
+	err := checkReplicationNotPaused(account)
+	if err != nil {
+		return models.PendingBlob{}, err
+	}
+
 	pendingBlob := models.PendingBlob{
 		AccountName:  account.Name,
 		Digest:       blob.Digest,
 		Reason:       models.PendingBecauseOfReplication,
 		PendingSince: p.timeNow(),
 	}
-	err := p.db.Insert(&pendingBlob)
+	err = p.db.Insert(&pendingBlob)
 	if err != nil {
 		// did we get a duplicate-key error because this blob is already being replicated?
 		count, err := p.db.SelectInt(
@@ -132,11 +195,16 @@ func (p *Processor) ReplicateBlob(ctx context.Context, blob models.Blob, account
 			account.Name, blob.Digest,
 		)
 		if err == nil && count > 0 {
-			return false, ErrConcurrentReplication
+			return models.PendingBlob{}, ErrConcurrentReplication
 		}
-		return false, err
+		return models.PendingBlob{}, err
 	}
+	return pendingBlob, nil
+}
 
+// finishBlobReplication performs the actual replication of a blob that was
+// previously marked as pending by beginBlobReplication().
+func (p *Processor) finishBlobReplication(ctx context.Context, pendingBlob models.PendingBlob, blob models.Blob, account models.ReducedAccount, repo models.Repository, w http.ResponseWriter) (responseWasWritten bool, returnErr error) {
 	// whatever happens, don't forget to cleanup the PendingBlob DB entry afterwards
 	// to unblock others who are waiting for this replication to come to an end
 	// (one way or the other)
@@ -150,20 +218,38 @@ func (p *Processor) ReplicateBlob(ctx context.Context, blob models.Blob, account
 		}
 	}()
 
-	// query upstream for the blob
-	client, err := p.getRepoClientForUpstream(account, repo)
-	if err != nil {
-		return false, err
-	}
-	blobReadCloser, blobLengthBytes, err := client.DownloadBlob(ctx, blob.Digest)
-	if err != nil {
-		return false, err
+	// if another account already has this blob, copy it from there instead of
+	// querying upstream; otherwise query upstream for the blob
+	blobReadCloser, blobLengthBytes := p.readSharedBlob(ctx, blob, account)
+	if blobReadCloser == nil {
+		upstream, err := p.getRepoClientForUpstream(ctx, account, repo)
+		if err != nil {
+			return false, err
+		}
+		probe := func(ctx context.Context, c *client.RepoClient) (bool, error) {
+			return c.HasBlob(ctx, blob.Digest)
+		}
+		err = p.replicateFromNearestSource(ctx, account, repo, "blob", probe, upstream, func(c *client.RepoClient) (err error) {
+			blobReadCloser, blobLengthBytes, err = c.DownloadBlob(ctx, blob.Digest)
+			return err
+		})
+		if err != nil {
+			p.recordReplicationOutcome(account, true, nil)
+			return false, err
+		}
+		p.recordReplicationOutcome(account, false, nil)
 	}
 	defer blobReadCloser.Close()
 
-	// stream into `w` if requested
-	blobReader := io.Reader(blobReadCloser)
-	if w != nil {
+	// stream into `w` if requested (but not if we need to verify the blob
+	// contents first: once we have started streaming, we cannot take it back)
+	blobReader := io.Reader(&replicationProgressReader{
+		wrapped:      blobReadCloser,
+		p:            p,
+		pendingBlob:  pendingBlob,
+		lastReportAt: pendingBlob.PendingSince,
+	})
+	if w != nil && !account.ExternalPeerVerifyOnly {
 		w.Header().Set("Content-Type", blob.SafeMediaType()) // we know the media type because we have already replicated a referencing manifest
 		w.Header().Set("Docker-Content-Digest", blob.Digest.String())
 		w.Header().Set("Content-Length", strconv.FormatUint(blobLengthBytes, 10))
@@ -171,18 +257,85 @@ func (p *Processor) ReplicateBlob(ctx context.Context, blob models.Blob, account
 		blobReader = io.TeeReader(blobReader, w)
 	}
 
-	err = p.uploadBlobToLocal(ctx, blob, account, blobReader, blobLengthBytes)
+	responseWasWritten = w != nil && !account.ExternalPeerVerifyOnly
+	err := p.uploadBlobToLocal(ctx, blob, account, repo, blobReader, blobLengthBytes)
 	if err != nil {
-		return true, err
+		return responseWasWritten, err
 	}
 
 	// count the successful push
 	l := prometheus.Labels{"account": string(account.Name), "auth_tenant_id": account.AuthTenantID, "method": "replication"}
 	api.BlobsPushedCounter.With(l).Inc()
-	return true, nil
+	return responseWasWritten, nil
 }
 
-func (p *Processor) uploadBlobToLocal(ctx context.Context, blob models.Blob, account models.ReducedAccount, blobReader io.Reader, blobLengthBytes uint64) (returnErr error) {
+// How often replicationProgressReader records its progress in the DB. Both
+// limits apply, so that the number of DB writes does not grow with the number
+// of slow replications, but only with the amount of replicated data.
+const (
+	replicationProgressInterval = 5 * time.Second
+	replicationProgressMinBytes = 32 << 20 // 32 MiB
+)
+
+// replicationProgressReader is used by ReplicateBlob to wrap the blob contents
+// while they are being replicated. It periodically records how many bytes
+// were replicated so far in the respective PendingBlob entry.
+type replicationProgressReader struct {
+	wrapped           io.Reader
+	p                 *Processor
+	pendingBlob       models.PendingBlob
+	lastReportAt      time.Time
+	lastReportedBytes uint64
+}
+
+// Read implements the io.Reader interface.
+func (r *replicationProgressReader) Read(buf []byte) (int, error) {
+	n, err := r.wrapped.Read(buf)
+	r.pendingBlob.ReplicatedBytes += keppel.AtLeastZero(n)
+
+	now := r.p.timeNow()
+	if now.Sub(r.lastReportAt) >= replicationProgressInterval && r.pendingBlob.ReplicatedBytes-r.lastReportedBytes >= replicationProgressMinBytes {
+		r.lastReportAt = now
+		r.lastReportedBytes = r.pendingBlob.ReplicatedBytes
+		_, dbErr := r.p.db.Exec(
+			`UPDATE pending_blobs SET replicated_bytes = $1 WHERE account_name = $2 AND digest = $3`,
+			r.pendingBlob.ReplicatedBytes, r.pendingBlob.AccountName, r.pendingBlob.Digest,
+		)
+		if dbErr != nil {
+			// not fatal: the progress is only used for informational purposes
+			logg.Error("cannot record replication progress for blob %s in account %s: %s",
+				r.pendingBlob.Digest, r.pendingBlob.AccountName, dbErr.Error())
+		}
+	}
+	return n, err
+}
+
+// readSharedBlob is used by ReplicateBlob to read the blob contents from
+// another account that already has this blob (see keppel.FindSharedBlob). If
+// no such account exists, or if the blob cannot be read from there, nil is
+// returned and the caller falls back to replicating from upstream.
+func (p *Processor) readSharedBlob(ctx context.Context, blob models.Blob, account models.ReducedAccount) (io.ReadCloser, uint64) {
+	sharedBlob, err := keppel.FindSharedBlob(p.db, blob.Digest, account)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logg.Error("cannot look for shared copies of blob %s for account %s: %s", blob.Digest, account.Name, err.Error())
+		}
+		return nil, 0
+	}
+	sourceAccount, err := keppel.FindReducedAccount(p.db, sharedBlob.AccountName)
+	if err != nil || sourceAccount == nil {
+		return nil, 0
+	}
+
+	readCloser, sizeBytes, err := p.sd.ReadBlob(ctx, *sourceAccount, sharedBlob.StorageID)
+	if err != nil {
+		logg.Error("cannot read shared copy of blob %s from account %s: %s", blob.Digest, sourceAccount.Name, err.Error())
+		return nil, 0
+	}
+	return readCloser, sizeBytes
+}
+
+func (p *Processor) uploadBlobToLocal(ctx context.Context, blob models.Blob, account models.ReducedAccount, repo models.Repository, blobReader io.Reader, blobLengthBytes uint64) (returnErr error) {
 	defer func() {
 		// if blob upload fails, count an aborted upload
 		if returnErr != nil {
@@ -191,12 +344,27 @@ func (p *Processor) uploadBlobToLocal(ctx context.Context, blob models.Blob, acc
 		}
 	}()
 
+	// for untrusted upstreams, check that the contents actually match the digest
+	var digester digest.Digester
+	if account.ExternalPeerVerifyOnly {
+		digester = blob.Digest.Algorithm().Digester()
+		blobReader = io.TeeReader(blobReader, digester.Hash())
+	}
+
 	upload := models.Upload{
-		StorageID: p.generateStorageID(),
+		StorageID: keppel.StorageIDForNewBlob(p.sd, account, repo.Name, &blobLengthBytes, p.generateStorageID()),
 		SizeBytes: 0,
 		NumChunks: 0,
 	}
 	err := p.AppendToBlob(ctx, account, &upload, blobReader, &blobLengthBytes)
+	if err == nil && digester != nil {
+		switch {
+		case digester.Digest() != blob.Digest:
+			err = keppel.ErrDigestInvalid.With("expected digest %s, but upstream delivered %s", blob.Digest, digester.Digest())
+		case upload.SizeBytes != blob.SizeBytes:
+			err = keppel.ErrSizeInvalid.With("expected %d bytes, but upstream delivered %d bytes", blob.SizeBytes, upload.SizeBytes)
+		}
+	}
 	if err != nil {
 		abortErr := p.sd.AbortBlobUpload(ctx, account, upload.StorageID, upload.NumChunks)
 		if abortErr != nil {
@@ -249,7 +417,9 @@ func (p *Processor) AppendToBlob(ctx context.Context, account models.ReducedAcco
 		return foreachChunkWithKnownSize(contents, *lengthBytes, func(chunk io.Reader, chunkLengthBytes uint64) error {
 			upload.NumChunks++
 			upload.SizeBytes += chunkLengthBytes
-			return p.sd.AppendToBlob(ctx, account, upload.StorageID, upload.NumChunks, &chunkLengthBytes, chunk)
+			err := p.sd.AppendToBlob(ctx, account, upload.StorageID, upload.NumChunks, &chunkLengthBytes, chunk)
+			p.RecordStorageQuotaError(account, err)
+			return err
 		})
 	}
 
@@ -257,7 +427,9 @@ func (p *Processor) AppendToBlob(ctx context.Context, account models.ReducedAcco
 	ctr := chunkingTrackingReader{wrapped: contents}
 	err := foreachChunkWithUnknownSize(&ctr, func(chunk io.Reader) error {
 		upload.NumChunks++
-		return p.sd.AppendToBlob(ctx, account, upload.StorageID, upload.NumChunks, nil, chunk)
+		err := p.sd.AppendToBlob(ctx, account, upload.StorageID, upload.NumChunks, nil, chunk)
+		p.RecordStorageQuotaError(account, err)
+		return err
 	})
 	upload.SizeBytes += ctr.bytesRead
 	return err
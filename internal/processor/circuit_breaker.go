@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// upstreamCircuitBreaker implements client.CircuitBreaker. Its state is kept in
+// the DB, so that it is shared between all keppel-api and keppel-janitor
+// processes, and so that it can be reset through the Keppel API.
+type upstreamCircuitBreaker struct {
+	db      *keppel.DB
+	policy  keppel.UpstreamPolicy
+	timeNow func() time.Time
+}
+
+func (p *Processor) upstreamResilienceOptions() (*client.RetryPolicy, client.CircuitBreaker) {
+	policy := p.cfg.UpstreamPolicy
+	retryPolicy := &client.RetryPolicy{
+		MaxAttempts:    policy.RetryMaxAttempts,
+		InitialBackoff: policy.RetryInitialBackoff,
+		MaxBackoff:     policy.RetryMaxBackoff,
+		OnRetry: func(host string) {
+			UpstreamRequestRetryCounter.With(prometheus.Labels{"external_hostname": host}).Inc()
+		},
+	}
+	if policy.CircuitBreakerThreshold <= 0 {
+		return retryPolicy, nil
+	}
+	return retryPolicy, upstreamCircuitBreaker{p.db, policy, p.timeNow}
+}
+
+// BeforeRequest implements the client.CircuitBreaker interface.
+func (b upstreamCircuitBreaker) BeforeRequest(ctx context.Context, host string) error {
+	var openUntil *time.Time
+	err := b.db.QueryRow(`SELECT open_until FROM upstream_circuit_breakers WHERE hostname = $1`, host).Scan(&openUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot check circuit breaker for %s: %w", host, err)
+	}
+
+	if openUntil != nil && openUntil.After(b.timeNow()) {
+		UpstreamCircuitBreakerRejectionCounter.With(prometheus.Labels{"external_hostname": host}).Inc()
+		msg := fmt.Sprintf("requests to %s are suspended until %s because of repeated failures",
+			host, openUntil.UTC().Format(time.RFC3339))
+		detail := keppel.RetryAfterDetail(keppel.ReasonUpstreamUnavailable, openUntil.Sub(b.timeNow()))
+		detail.UpstreamHostName = host
+		return keppel.ErrUnavailable.With(msg).WithStatus(http.StatusServiceUnavailable).WithDetail(detail)
+	}
+	return nil
+}
+
+var recordUpstreamFailureQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO upstream_circuit_breakers (hostname, consecutive_failures) VALUES ($1, 1)
+	ON CONFLICT (hostname) DO UPDATE SET consecutive_failures = upstream_circuit_breakers.consecutive_failures + 1
+	RETURNING consecutive_failures
+`)
+
+// AfterRequest implements the client.CircuitBreaker interface.
+func (b upstreamCircuitBreaker) AfterRequest(ctx context.Context, host string, failed bool) {
+	if !failed {
+		// a single successful request closes the circuit breaker again
+		_, err := b.db.Exec(`DELETE FROM upstream_circuit_breakers WHERE hostname = $1`, host)
+		if err != nil {
+			logg.Error("could not reset circuit breaker for %s: %s", host, err.Error())
+		}
+		return
+	}
+
+	var failureCount int
+	err := b.db.QueryRow(recordUpstreamFailureQuery, host).Scan(&failureCount)
+	if err != nil {
+		logg.Error("could not record failed request in circuit breaker for %s: %s", host, err.Error())
+		return
+	}
+	if failureCount < b.policy.CircuitBreakerThreshold {
+		return
+	}
+
+	openUntil := b.timeNow().Add(b.policy.CircuitBreakerCooldown)
+	_, err = b.db.Exec(`UPDATE upstream_circuit_breakers SET open_until = $2 WHERE hostname = $1`, host, openUntil)
+	if err != nil {
+		logg.Error("could not open circuit breaker for %s: %s", host, err.Error())
+		return
+	}
+	UpstreamCircuitBreakerTripCounter.With(prometheus.Labels{"external_hostname": host}).Inc()
+	logg.Info("circuit breaker for %s is open until %s after %d consecutive failed requests",
+		host, openUntil.UTC().Format(time.RFC3339), failureCount)
+}
@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/mock"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+func TestMain(m *testing.M) {
+	easypg.WithTestDB(m, func() int { return m.Run() })
+}
+
+func TestUpstreamCircuitBreaker(t *testing.T) {
+	db := keppel.InitORM(easypg.ConnectForTest(t, keppel.DBConfiguration(),
+		easypg.ClearTables("upstream_circuit_breakers"),
+	))
+	clock := mock.NewClock()
+	b := upstreamCircuitBreaker{
+		db: db,
+		policy: keppel.UpstreamPolicy{
+			CircuitBreakerThreshold: 3,
+			CircuitBreakerCooldown:  time.Minute,
+		},
+		timeNow: clock.Now,
+	}
+	ctx := t.Context()
+	const host = "registry.example.org"
+
+	expectClosed := func() {
+		t.Helper()
+		err := b.BeforeRequest(ctx, host)
+		if err != nil {
+			t.Errorf("expected circuit breaker to be closed, but got: %s", err.Error())
+		}
+	}
+	expectOpen := func(retryAfter time.Duration) {
+		t.Helper()
+		err := b.BeforeRequest(ctx, host)
+		var rerr *keppel.RegistryV2Error
+		if !errors.As(err, &rerr) {
+			t.Errorf("expected circuit breaker to be open, but got: %v", err)
+			return
+		}
+		if rerr.Status != http.StatusServiceUnavailable {
+			t.Errorf("expected status %d, but got %d", http.StatusServiceUnavailable, rerr.Status)
+		}
+		detail, ok := rerr.Detail.(keppel.RegistryV2ErrorDetail)
+		if !ok || detail.RetryAfterSeconds == nil || detail.UpstreamHostName != host {
+			t.Errorf("unexpected error detail: %#v", rerr.Detail)
+			return
+		}
+		if expected := uint64(retryAfter / time.Second); *detail.RetryAfterSeconds != expected {
+			t.Errorf("expected retry after %d seconds, but got %d", expected, *detail.RetryAfterSeconds)
+		}
+	}
+
+	tr, tr0 := easypg.NewTracker(t, db.Db)
+	tr0.AssertEmpty()
+
+	// failures below the threshold are counted, but do not open the circuit breaker
+	b.AfterRequest(ctx, host, true)
+	b.AfterRequest(ctx, host, true)
+	expectClosed()
+	tr.DBChanges().AssertEqualf(`
+		INSERT INTO upstream_circuit_breakers (hostname, consecutive_failures) VALUES ('registry.example.org', 2);
+	`)
+
+	// a success in between resets the failure count
+	b.AfterRequest(ctx, host, false)
+	tr.DBChanges().AssertEqualf(`
+		DELETE FROM upstream_circuit_breakers WHERE hostname = 'registry.example.org';
+	`)
+
+	// reaching the threshold opens the circuit breaker for the cooldown period
+	for range 3 {
+		b.AfterRequest(ctx, host, true)
+	}
+	tr.DBChanges().AssertEqualf(`
+		INSERT INTO upstream_circuit_breakers (hostname, consecutive_failures, open_until) VALUES ('registry.example.org', 3, %[1]d);
+	`, clock.Now().Add(time.Minute).Unix())
+	expectOpen(time.Minute)
+
+	// other hosts are not affected
+	err := b.BeforeRequest(ctx, "registry.example.com")
+	if err != nil {
+		t.Errorf("expected circuit breaker for other host to be closed, but got: %s", err.Error())
+	}
+
+	// the circuit breaker stays open until the cooldown has passed
+	clock.StepBy(30 * time.Second)
+	expectOpen(30 * time.Second)
+	clock.StepBy(30 * time.Second)
+	expectClosed()
+
+	// after the cooldown, a single failed probe opens the circuit breaker again
+	// immediately since the failure count is still above the threshold
+	b.AfterRequest(ctx, host, true)
+	tr.DBChanges().AssertEqualf(`
+		UPDATE upstream_circuit_breakers SET consecutive_failures = 4, open_until = %[1]d WHERE hostname = 'registry.example.org';
+	`, clock.Now().Add(time.Minute).Unix())
+	expectOpen(time.Minute)
+
+	// a successful probe after the cooldown closes it for good
+	clock.StepBy(time.Minute)
+	b.AfterRequest(ctx, host, false)
+	tr.DBChanges().AssertEqualf(`
+		DELETE FROM upstream_circuit_breakers WHERE hostname = 'registry.example.org';
+	`)
+	expectClosed()
+}
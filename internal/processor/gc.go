@@ -0,0 +1,360 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// GCManifest tracks the state of a single manifest during the evaluation of GC policies.
+type GCManifest struct {
+	Manifest      models.Manifest
+	TagNames      []string
+	KeppelLabels  map[string]string
+	ParentDigests []string
+	GCStatus      keppel.GCStatus
+	IsDeleted     bool
+}
+
+// SelectGCPoliciesForRepo returns those GC policies that apply to the given
+// repo: first the repo's own GC policies, then the given account-level GC
+// policies, followed by the GC policies of the repo's namespace (if any).
+// Account-level and namespace policies are skipped entirely if the repo has
+// IgnoreInheritedGCPolicies set.
+func (p *Processor) SelectGCPoliciesForRepo(accountPolicies []keppel.GCPolicy, repo models.Repository) ([]keppel.GCPolicy, error) {
+	var namespace *models.RepositoryNamespace
+	if !repo.IgnoreInheritedGCPolicies {
+		var err error
+		namespace, err = keppel.FindRepositoryNamespaceForRepo(p.db, repo.AccountName, repo.Name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find namespace for repo %s: %w", repo.FullName(), err)
+		}
+	}
+	return selectGCPoliciesForRepo(accountPolicies, repo, namespace)
+}
+
+// Like SelectGCPoliciesForRepo, but with an explicitly given namespace (or
+// nil if the repo is not located within a namespace).
+func selectGCPoliciesForRepo(accountPolicies []keppel.GCPolicy, repo models.Repository, namespace *models.RepositoryNamespace) ([]keppel.GCPolicy, error) {
+	policies, err := keppel.ParseRepositoryGCPolicies(repo)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load GC policies for repo %s: %w", repo.FullName(), err)
+	}
+	if !repo.IgnoreInheritedGCPolicies {
+		policies = append(policies, accountPolicies...)
+		if namespace != nil {
+			nsPolicies, err := keppel.NamespaceGCPolicies(*namespace)
+			if err != nil {
+				return nil, fmt.Errorf("cannot load GC policies for namespace %q in account %s: %w", namespace.Prefix, repo.AccountName, err)
+			}
+			policies = append(policies, nsPolicies...)
+		}
+	}
+
+	var policiesForRepo []keppel.GCPolicy
+	for idx, policy := range policies {
+		err := policy.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("GC policy #%d for account %s is invalid: %w", idx+1, repo.AccountName, err)
+		}
+		if policy.MatchesRepository(repo.Name) && policy.MatchesArchivalState(repo.IsArchived) {
+			policiesForRepo = append(policiesForRepo, policy)
+		}
+	}
+	return policiesForRepo, nil
+}
+
+// LoadManifestsForGC loads all manifests in the given repo, along with the
+// information required to evaluate GC policies on them.
+func (p *Processor) LoadManifestsForGC(repo models.Repository) ([]*GCManifest, error) {
+	// load manifests in repo
+	var dbManifests []models.Manifest
+	_, err := p.db.Select(&dbManifests, `SELECT * FROM manifests WHERE repo_id = $1`, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// setup a bit of structure to track state in during the policy evaluation
+	var manifests []*GCManifest
+	for _, m := range dbManifests {
+		manifests = append(manifests, &GCManifest{
+			Manifest: m,
+			GCStatus: keppel.GCStatus{
+				ProtectedByRecentUpload: m.PushedAt.After(p.timeNow().Add(-10 * time.Minute)),
+			},
+			IsDeleted: false,
+		})
+	}
+
+	// load tags (for matching policies on match_tag, except_tag and only_untagged)
+	query := `SELECT digest, name FROM tags WHERE repo_id = $1`
+	err = sqlext.ForeachRow(p.db, query, []any{repo.ID}, func(rows *sql.Rows) error {
+		var (
+			digest  digest.Digest
+			tagName string
+		)
+		err := rows.Scan(&digest, &tagName)
+		if err != nil {
+			return err
+		}
+		for _, m := range manifests {
+			if m.Manifest.Digest == digest {
+				m.TagNames = append(m.TagNames, tagName)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// load Keppel labels (for matching policies on match_keppel_labels and except_keppel_labels)
+	keppelLabels, err := keppel.FindKeppelLabelsInRepo(p.db, repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range manifests {
+		m.KeppelLabels = keppelLabels[m.Manifest.Digest]
+	}
+
+	// check manifest-manifest relations to fill GCStatus.ProtectedByManifest
+	query = `SELECT parent_digest, child_digest FROM manifest_manifest_refs WHERE repo_id = $1`
+	err = sqlext.ForeachRow(p.db, query, []any{repo.ID}, func(rows *sql.Rows) error {
+		var (
+			parentDigest string
+			childDigest  digest.Digest
+		)
+		err := rows.Scan(&parentDigest, &childDigest)
+		if err != nil {
+			return err
+		}
+		for _, m := range manifests {
+			if m.Manifest.Digest == childDigest {
+				m.ParentDigests = append(m.ParentDigests, parentDigest)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range manifests {
+		if len(m.ParentDigests) > 0 {
+			sort.Strings(m.ParentDigests) // for deterministic test behavior
+			m.GCStatus.ProtectedByParentManifest = m.ParentDigests[0]
+		}
+	}
+
+	// check if the subject target digest manifest exists
+outer:
+	for _, manifest := range manifests {
+		if manifest.Manifest.SubjectDigest == "" {
+			continue
+		}
+
+		for _, m := range manifests {
+			if m.Manifest.Digest == manifest.Manifest.SubjectDigest {
+				manifest.GCStatus.ProtectedBySubjectManifest = manifest.Manifest.SubjectDigest.String()
+				continue outer
+			}
+		}
+	}
+
+	return manifests, nil
+}
+
+// MarkReferrersAsDeleted marks all manifests that refer to the given manifest
+// as their subject (directly or transitively) as deleted. This is used after
+// deleting a manifest in an account with CascadeDeleteReferrers, since
+// DeleteManifest has deleted those referrers along with their subject.
+func MarkReferrersAsDeleted(manifests []*GCManifest, subjectDigest digest.Digest) {
+	for _, m := range manifests {
+		if m.IsDeleted || m.Manifest.SubjectDigest != subjectDigest {
+			continue
+		}
+		m.IsDeleted = true
+		MarkReferrersAsDeleted(manifests, m.Manifest.Digest)
+	}
+}
+
+// EvaluateGCPolicy evaluates the given policy on the given manifests. For
+// each manifest that the policy wants to delete, `deleteManifest` is called,
+// and the manifest is marked as deleted if it returns no error.
+func (p *Processor) EvaluateGCPolicy(manifests []*GCManifest, policy keppel.GCPolicy, deleteManifest func(*GCManifest) error) error {
+	// for some time constraint matches, we need to know which manifests are
+	// still alive
+	var aliveManifests []models.Manifest
+	hasAliveReferrers := make(map[digest.Digest]bool)
+	for _, m := range manifests {
+		if !m.IsDeleted {
+			aliveManifests = append(aliveManifests, m.Manifest)
+			if m.Manifest.SubjectDigest != "" {
+				hasAliveReferrers[m.Manifest.SubjectDigest] = true
+			}
+		}
+	}
+
+	// evaluate policy for each manifest
+	for _, m := range manifests {
+		// skip those manifests that are already deleted, and those which are
+		// protected by an earlier policy or one of the baseline checks above
+		if m.IsDeleted || m.GCStatus.IsProtected() {
+			continue
+		}
+
+		// track matching "delete" policies in GCStatus to allow users insight
+		// into how policies match
+		if policy.Action == "delete" {
+			m.GCStatus.RelevantPolicies = append(m.GCStatus.RelevantPolicies, policy)
+		}
+
+		// evaluate constraints
+		if !policy.MatchesTags(m.TagNames) {
+			continue
+		}
+		if !policy.MatchesKeppelLabels(m.KeppelLabels) {
+			continue
+		}
+		if !policy.MatchesReferrers(hasAliveReferrers[m.Manifest.Digest]) {
+			continue
+		}
+		if !policy.MatchesTimeConstraint(m.Manifest, aliveManifests, p.timeNow()) {
+			continue
+		}
+
+		pCopied := policy
+		// execute policy action
+		switch policy.Action {
+		case "protect":
+			m.GCStatus.ProtectedByPolicy = &pCopied
+		case "delete":
+			err := deleteManifest(m)
+			if err != nil {
+				return err
+			}
+			m.IsDeleted = true
+		default:
+			// defense in depth: we already did p.Validate() earlier
+			return fmt.Errorf("unexpected GC policy action: %q (why was this not caught by Validate!?)", policy.Action)
+		}
+	}
+
+	return nil
+}
+
+// CountManifestsDeletedByGCPolicyChange returns how many manifests in the
+// given account would be deleted by the new GC policies, but not by the old
+// ones. No manifests are actually deleted.
+func (p *Processor) CountManifestsDeletedByGCPolicyChange(accountName models.AccountName, oldPolicies, newPolicies []keppel.GCPolicy) (uint64, error) {
+	var repos []models.Repository
+	_, err := p.db.Select(&repos, `SELECT * FROM repos WHERE account_name = $1`, accountName)
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint64
+	for _, repo := range repos {
+		deletedBefore, err := p.simulateGCPolicies(repo, oldPolicies)
+		if err != nil {
+			return 0, err
+		}
+		deletedAfter, err := p.simulateGCPolicies(repo, newPolicies)
+		if err != nil {
+			return 0, err
+		}
+		for manifestDigest := range deletedAfter {
+			if !deletedBefore[manifestDigest] {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+var reposInNamespaceQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM repos WHERE account_name = $1 AND starts_with(name, $2 || '/')
+`)
+
+// CountManifestsDeletedByNamespaceChange returns how many manifests in the
+// given namespace would be deleted if the namespace's GC policies were
+// changed from those in `original` to those in `namespace`, but are not
+// deleted right now. No manifests are actually deleted.
+func (p *Processor) CountManifestsDeletedByNamespaceChange(account models.Account, original, namespace models.RepositoryNamespace) (uint64, error) {
+	accountPolicies, err := keppel.ParseGCPolicies(account)
+	if err != nil {
+		return 0, err
+	}
+	var repos []models.Repository
+	_, err = p.db.Select(&repos, reposInNamespaceQuery, account.Name, namespace.Prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint64
+	for _, repo := range repos {
+		policiesBefore, err := selectGCPoliciesForRepo(accountPolicies, repo, &original)
+		if err != nil {
+			return 0, err
+		}
+		deletedBefore, err := p.simulateSelectedGCPolicies(repo, policiesBefore)
+		if err != nil {
+			return 0, err
+		}
+		policiesAfter, err := selectGCPoliciesForRepo(accountPolicies, repo, &namespace)
+		if err != nil {
+			return 0, err
+		}
+		deletedAfter, err := p.simulateSelectedGCPolicies(repo, policiesAfter)
+		if err != nil {
+			return 0, err
+		}
+		for manifestDigest := range deletedAfter {
+			if !deletedBefore[manifestDigest] {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// simulateGCPolicies returns the digests of all manifests in the given repo
+// that the given account-level GC policies would delete.
+func (p *Processor) simulateGCPolicies(repo models.Repository, accountPolicies []keppel.GCPolicy) (map[digest.Digest]bool, error) {
+	policies, err := p.SelectGCPoliciesForRepo(accountPolicies, repo)
+	if err != nil {
+		return nil, err
+	}
+	return p.simulateSelectedGCPolicies(repo, policies)
+}
+
+// Like simulateGCPolicies, but with the policies already selected for the repo.
+func (p *Processor) simulateSelectedGCPolicies(repo models.Repository, policies []keppel.GCPolicy) (map[digest.Digest]bool, error) {
+	manifests, err := p.LoadManifestsForGC(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	isDeleted := make(map[digest.Digest]bool)
+	for _, policy := range policies {
+		err := p.EvaluateGCPolicy(manifests, policy, func(m *GCManifest) error {
+			isDeleted[m.Manifest.Digest] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return isDeleted, nil
+}
@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"maps"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/must"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// SetManifestKeppelLabels replaces the Keppel labels of the given manifest
+// (see type models.ManifestKeppelLabel). Since the labels are stored outside
+// of the manifest contents, the manifest digest does not change. If the
+// manifest does not exist, sql.ErrNoRows is returned.
+//
+// The caller is responsible for checking that the user is allowed to change
+// the labels, and for validating them with keppel.ValidateKeppelLabels().
+func (p *Processor) SetManifestKeppelLabels(account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest, labels map[string]string, actx keppel.AuditContext) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	// lock the manifest to serialize concurrent updates of its labels
+	var manifest models.Manifest
+	err = tx.SelectOne(&manifest, `SELECT * FROM manifests WHERE repo_id = $1 AND digest = $2 FOR UPDATE`, repo.ID, manifestDigest)
+	if err != nil {
+		return err
+	}
+	previousLabels, err := keppel.FindManifestKeppelLabels(tx, repo, manifestDigest)
+	if err != nil {
+		return err
+	}
+	if maps.Equal(previousLabels, labels) {
+		return nil
+	}
+
+	_, err = tx.Exec(`DELETE FROM manifest_keppel_labels WHERE repo_id = $1 AND digest = $2`, repo.ID, manifestDigest)
+	if err != nil {
+		return err
+	}
+	for name, value := range labels {
+		err = tx.Insert(&models.ManifestKeppelLabel{
+			RepositoryID: repo.ID,
+			Digest:       manifestDigest,
+			Name:         name,
+			Value:        value,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		p.auditor.Record(audittools.Event{
+			Time:       p.timeNow(),
+			Request:    actx.Request,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     cadf.UpdateAction,
+			Target: auditManifestKeppelLabels{
+				Account:        account,
+				Repository:     repo,
+				Digest:         manifestDigest,
+				PreviousLabels: previousLabels,
+				NewLabels:      labels,
+			},
+		})
+	}
+	return nil
+}
+
+// auditManifestKeppelLabels is an audittools.Target.
+type auditManifestKeppelLabels struct {
+	Account        models.ReducedAccount
+	Repository     models.Repository
+	Digest         digest.Digest
+	PreviousLabels map[string]string
+	NewLabels      map[string]string
+}
+
+// Render implements the audittools.Target interface.
+func (a auditManifestKeppelLabels) Render() cadf.Resource {
+	res := auditManifest{
+		Account:    a.Account,
+		Repository: a.Repository,
+		Digest:     a.Digest,
+	}.Render()
+	res.Attachments = []cadf.Attachment{must.Return(cadf.NewJSONAttachment("keppel_labels", map[string]map[string]string{
+		"previous_labels": a.PreviousLabels,
+		"new_labels":      a.NewLabels,
+	}))}
+	return res
+}
@@ -0,0 +1,326 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+
+	imageManifest "github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// GenerateEstargzVariant converts all layers of the given image manifest into
+// eStargz layers, and stores an image manifest referencing those layers in the
+// same repository. The new manifest has the original manifest as its subject,
+// so clients can discover it through the Referrers API, and garbage collection
+// keeps it around for as long as the original manifest exists.
+//
+// Returns the digest of the new manifest.
+func (p *Processor) GenerateEstargzVariant(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifest models.Manifest, actx keppel.AuditContext) (digest.Digest, error) {
+	variant, err := p.generateVariant(ctx, account, repo, manifest, "eStargz", p.convertLayerToEstargz, actx)
+	return variant.Digest, err
+}
+
+// A function that converts a single layer while generating a variant. Returns
+// the descriptor of the new layer, as well as its DiffID. The DiffID is empty
+// if the uncompressed tar stream of the layer did not change.
+type variantLayerConverter func(ctx context.Context, account models.ReducedAccount, repo models.Repository, layer imagespecs.Descriptor) (imagespecs.Descriptor, digest.Digest, error)
+
+// GeneratedVariant is returned by the functions that generate variants of
+// image manifests.
+type GeneratedVariant struct {
+	Digest digest.Digest
+	// Sums of the layer sizes of the original manifest and the variant.
+	OriginalLayersSizeBytes uint64
+	VariantLayersSizeBytes  uint64
+}
+
+// Implements the parts of GenerateEstargzVariant and GenerateZstdVariant that
+// do not depend on the layer format.
+func (p *Processor) generateVariant(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifest models.Manifest, formatName string, convertLayer variantLayerConverter, actx keppel.AuditContext) (GeneratedVariant, error) {
+	if manifest.MediaType != imageManifest.DockerV2Schema2MediaType && manifest.MediaType != imagespecs.MediaTypeImageManifest {
+		return GeneratedVariant{}, fmt.Errorf("cannot generate %s variant for manifest with media type %q", formatName, manifest.MediaType)
+	}
+	manifestBytes, err := keppel.ReadManifestFromStorage(ctx, p.sd, p.secd, account, repo.Name, manifest.Digest)
+	if err != nil {
+		return GeneratedVariant{}, err
+	}
+	var original imagespecs.Manifest
+	err = json.Unmarshal(manifestBytes, &original)
+	if err != nil {
+		return GeneratedVariant{}, fmt.Errorf("cannot parse manifest: %w", err)
+	}
+
+	// convert layers
+	var result GeneratedVariant
+	layers := make([]imagespecs.Descriptor, len(original.Layers))
+	diffIDs := make([]digest.Digest, len(original.Layers))
+	hasChangedLayers := false
+	hasChangedDiffIDs := false
+	for idx, layer := range original.Layers {
+		layers[idx], diffIDs[idx], err = convertLayer(ctx, account, repo, layer)
+		if err != nil {
+			return GeneratedVariant{}, fmt.Errorf("while converting layer %s: %w", layer.Digest, err)
+		}
+		hasChangedLayers = hasChangedLayers || layers[idx].Digest != layer.Digest
+		hasChangedDiffIDs = hasChangedDiffIDs || diffIDs[idx] != ""
+		result.OriginalLayersSizeBytes += uint64(layer.Size)      //nolint:gosec // blob sizes are never negative
+		result.VariantLayersSizeBytes += uint64(layers[idx].Size) //nolint:gosec // blob sizes are never negative
+	}
+	if !hasChangedLayers {
+		return GeneratedVariant{}, fmt.Errorf("none of the layers can be converted to %s", formatName)
+	}
+
+	// if the uncompressed tar streams changed, the image config needs to refer to the new ones
+	configDesc := imagespecs.Descriptor{
+		MediaType: imagespecs.MediaTypeImageConfig,
+		Digest:    original.Config.Digest,
+		Size:      original.Config.Size,
+	}
+	if hasChangedDiffIDs {
+		configDesc, err = p.rewriteDiffIDsInConfig(ctx, account, repo, original.Config, diffIDs)
+		if err != nil {
+			return GeneratedVariant{}, err
+		}
+	}
+
+	// store the new manifest
+	variant := imagespecs.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imagespecs.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    layers,
+		Subject: &imagespecs.Descriptor{
+			MediaType: manifest.MediaType,
+			Digest:    manifest.Digest,
+			Size:      int64(len(manifestBytes)),
+		},
+		Annotations: original.Annotations,
+	}
+	variantBytes, err := json.Marshal(variant)
+	if err != nil {
+		return GeneratedVariant{}, err
+	}
+	variantManifest, _, err := p.ValidateAndStoreManifest(ctx, account, repo, IncomingManifest{
+		Reference: models.ManifestReference{Digest: digest.Canonical.FromBytes(variantBytes)},
+		MediaType: imagespecs.MediaTypeImageManifest,
+		Contents:  variantBytes,
+		PushedAt:  p.timeNow(),
+	}, actx)
+	if err != nil {
+		return GeneratedVariant{}, fmt.Errorf("while storing manifest: %w", err)
+	}
+	result.Digest = variantManifest.Digest
+	return result, nil
+}
+
+// Stores a copy of the given image config with rootfs.diff_ids replaced by
+// the given DiffIDs. Empty DiffIDs are taken from the original config.
+func (p *Processor) rewriteDiffIDsInConfig(ctx context.Context, account models.ReducedAccount, repo models.Repository, originalConfig imagespecs.Descriptor, diffIDs []digest.Digest) (imagespecs.Descriptor, error) {
+	configBlob, err := keppel.FindBlobByRepository(p.db, originalConfig.Digest, repo)
+	if err != nil {
+		return imagespecs.Descriptor{}, fmt.Errorf("while looking up config blob %s: %w", originalConfig.Digest, err)
+	}
+	var config map[string]json.RawMessage
+	err = p.decodeBlobContents(ctx, account, *configBlob, &config)
+	if err != nil {
+		return imagespecs.Descriptor{}, fmt.Errorf("cannot parse config blob %s: %w", originalConfig.Digest, err)
+	}
+	var rootfs map[string]json.RawMessage
+	err = json.Unmarshal(config["rootfs"], &rootfs)
+	if err != nil {
+		return imagespecs.Descriptor{}, fmt.Errorf("cannot parse rootfs in config blob %s: %w", originalConfig.Digest, err)
+	}
+	if slices.Contains(diffIDs, "") {
+		var originalDiffIDs []digest.Digest
+		err = json.Unmarshal(rootfs["diff_ids"], &originalDiffIDs)
+		if err != nil {
+			return imagespecs.Descriptor{}, fmt.Errorf("cannot parse rootfs.diff_ids in config blob %s: %w", originalConfig.Digest, err)
+		}
+		if len(originalDiffIDs) != len(diffIDs) {
+			return imagespecs.Descriptor{}, fmt.Errorf("config blob %s has %d diff_ids for %d layers", originalConfig.Digest, len(originalDiffIDs), len(diffIDs))
+		}
+		for idx, diffID := range diffIDs {
+			if diffID == "" {
+				diffIDs[idx] = originalDiffIDs[idx]
+			}
+		}
+	}
+	rootfs["diff_ids"], err = json.Marshal(diffIDs)
+	if err != nil {
+		return imagespecs.Descriptor{}, err
+	}
+	config["rootfs"], err = json.Marshal(rootfs)
+	if err != nil {
+		return imagespecs.Descriptor{}, err
+	}
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return imagespecs.Descriptor{}, err
+	}
+	newConfigBlob, err := p.storeGeneratedBlob(ctx, account, repo, bytes.NewReader(configBytes))
+	if err != nil {
+		return imagespecs.Descriptor{}, fmt.Errorf("while storing config blob: %w", err)
+	}
+	return imagespecs.Descriptor{
+		MediaType: imagespecs.MediaTypeImageConfig,
+		Digest:    newConfigBlob.Digest,
+		Size:      int64(newConfigBlob.SizeBytes), //nolint:gosec // blob sizes are far below MaxInt64
+	}, nil
+}
+
+// Converts a single layer for GenerateEstargzVariant.
+// Implements variantLayerConverter.
+func (p *Processor) convertLayerToEstargz(ctx context.Context, account models.ReducedAccount, repo models.Repository, layer imagespecs.Descriptor) (imagespecs.Descriptor, digest.Digest, error) {
+	var isCompressed bool
+	switch layer.MediaType {
+	case imagespecs.MediaTypeImageLayerGzip, imageManifest.DockerV2Schema2LayerMediaType:
+		isCompressed = true
+	case imagespecs.MediaTypeImageLayer:
+		isCompressed = false
+	default:
+		return imagespecs.Descriptor{}, "", fmt.Errorf("unsupported layer media type %q", layer.MediaType)
+	}
+
+	blob, err := keppel.FindBlobByRepository(p.db, layer.Digest, repo)
+	if err != nil {
+		return imagespecs.Descriptor{}, "", err
+	}
+	blobReader, _, err := p.sd.ReadBlob(ctx, account, blob.StorageID)
+	if err != nil {
+		return imagespecs.Descriptor{}, "", err
+	}
+	defer blobReader.Close()
+	var tarReader io.Reader = blobReader
+	if isCompressed {
+		gzipReader, err := gzip.NewReader(blobReader)
+		if err != nil {
+			return imagespecs.Descriptor{}, "", err
+		}
+		defer gzipReader.Close()
+		tarReader = gzipReader
+	}
+
+	// the conversion runs in the background while its output is being uploaded
+	pipeReader, pipeWriter := io.Pipe()
+	var result keppel.EstargzLayer
+	done := make(chan struct{})
+	go func() {
+		var err error
+		result, err = keppel.ConvertLayerToEstargz(tarReader, pipeWriter)
+		pipeWriter.CloseWithError(err)
+		close(done)
+	}()
+	newBlob, err := p.storeGeneratedBlob(ctx, account, repo, pipeReader)
+	pipeReader.CloseWithError(err) // if the upload failed early, this unblocks the conversion
+	<-done
+	if err != nil {
+		return imagespecs.Descriptor{}, "", err
+	}
+
+	annotations := maps.Clone(layer.Annotations)
+	if annotations == nil {
+		annotations = make(map[string]string, 2)
+	}
+	annotations[keppel.EstargzTOCDigestAnnotation] = result.TOCDigest.String()
+	annotations[keppel.EstargzUncompressedSizeAnnotation] = strconv.FormatUint(result.UncompressedSizeBytes, 10)
+	return imagespecs.Descriptor{
+		MediaType:   imagespecs.MediaTypeImageLayerGzip,
+		Digest:      newBlob.Digest,
+		Size:        int64(newBlob.SizeBytes), //nolint:gosec // blob sizes are far below MaxInt64
+		Annotations: annotations,
+	}, result.DiffID, nil
+}
+
+// Decodes a JSON blob directly from the storage, without buffering it first.
+func (p *Processor) decodeBlobContents(ctx context.Context, account models.ReducedAccount, blob models.Blob, target any) error {
+	reader, _, err := p.sd.ReadBlob(ctx, account, blob.StorageID)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return json.NewDecoder(reader).Decode(target)
+}
+
+var insertGeneratedBlobQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO blobs (account_name, digest, size_bytes, storage_id, pushed_at, next_validation_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT DO NOTHING
+`)
+
+// Uploads a blob that Keppel generated by itself, and mounts it into the
+// given repository. If a blob with the same digest already exists in the
+// account, that blob is reused instead.
+func (p *Processor) storeGeneratedBlob(ctx context.Context, account models.ReducedAccount, repo models.Repository, contents io.Reader) (blob *models.Blob, returnErr error) {
+	digester := digest.Canonical.Digester()
+	upload := models.Upload{StorageID: keppel.StorageIDForNewBlob(p.sd, account, repo.Name, nil, p.generateStorageID())}
+	err := p.AppendToBlob(ctx, account, &upload, io.TeeReader(contents, digester.Hash()), nil)
+	if err == nil {
+		err = p.sd.FinalizeBlob(ctx, account, upload.StorageID, upload.NumChunks)
+	}
+	if err != nil {
+		abortErr := p.sd.AbortBlobUpload(ctx, account, upload.StorageID, upload.NumChunks)
+		if abortErr != nil {
+			logg.Error("additional error encountered when aborting upload %s into account %s: %s",
+				upload.StorageID, account.Name, abortErr.Error())
+		}
+		return nil, err
+	}
+
+	// if the blob contents turn out to be unused, we need to clean them up in the storage
+	defer func() {
+		if returnErr != nil || blob.StorageID != upload.StorageID {
+			deleteErr := p.sd.DeleteBlob(ctx, account, upload.StorageID)
+			if deleteErr != nil {
+				logg.Error("additional error encountered when deleting unused blob %s from account %s: %s",
+					upload.StorageID, account.Name, deleteErr.Error())
+			}
+		}
+	}()
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	blobDigest := digester.Digest()
+	now := p.timeNow()
+	err = keppel.CheckStorageQuotaForBlobMount(tx, repo, blobDigest, upload.SizeBytes, "", now)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(insertGeneratedBlobQuery,
+		account.Name, blobDigest.String(), upload.SizeBytes, upload.StorageID,
+		now, now.Add(models.BlobValidationInterval),
+	)
+	if err != nil {
+		return nil, err
+	}
+	blob, err = keppel.FindBlobByAccountName(tx, blobDigest, account.Name)
+	if err != nil {
+		return nil, err
+	}
+	err = keppel.MountBlobIntoRepo(tx, *blob, repo)
+	if err != nil {
+		return nil, err
+	}
+	return blob, tx.Commit()
+}
@@ -40,19 +40,26 @@ type IncomingManifest struct {
 	MediaType string
 	Contents  []byte
 	PushedAt  time.Time // usually time.Now(), but can be different in unit tests
+	// PushInfo is only set for manifests pushed by users through the Registry API.
+	PushInfo *keppel.ManifestPushInfo
 }
 
 var checkManifestExistsQuery = sqlext.SimplifyWhitespace(`
 	SELECT COUNT(*) > 0 FROM manifests WHERE repo_id = $1 AND digest = $2
 `)
-var checkTagExistsAtSameDigestQuery = sqlext.SimplifyWhitespace(`
-	SELECT COUNT(*) > 0 FROM tags WHERE repo_id = $1 AND name = $2 AND digest = $3
+var findTagDigestQuery = sqlext.SimplifyWhitespace(`
+	SELECT digest FROM tags WHERE repo_id = $1 AND name = $2
 `)
 
 // ValidateAndStoreManifest validates the given manifest and stores it under the
 // given reference. If the reference is a digest, it is validated. Otherwise, a
 // tag with that name is created that points to the new manifest.
-func (p *Processor) ValidateAndStoreManifest(ctx context.Context, account models.ReducedAccount, repo models.Repository, m IncomingManifest, actx keppel.AuditContext) (*models.Manifest, error) {
+//
+// When pushing to a tag, the digest that the tag points to afterwards is
+// returned as well. If a concurrent push of the same tag has moved the tag
+// elsewhere while this push was being processed, this push fails with a
+// conflict instead of silently losing against the other push.
+func (p *Processor) ValidateAndStoreManifest(ctx context.Context, account models.ReducedAccount, repo models.Repository, m IncomingManifest, actx keppel.AuditContext) (*models.Manifest, digest.Digest, error) {
 	// check if the objects we want to create already exist in the database; this
 	// check is not 100% reliable since it does not run in the same transaction as
 	// the actual upsert, so results should be taken with a grain of salt; but the
@@ -60,27 +67,35 @@ func (p *Processor) ValidateAndStoreManifest(ctx context.Context, account models
 	contentsDigest := digest.Canonical.FromBytes(m.Contents)
 	manifestExistsAlready, err := p.db.SelectBool(checkManifestExistsQuery, repo.ID, contentsDigest.String())
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	logg.Debug("ValidateAndStoreManifest: in repo %d, manifest %s already exists = %t", repo.ID, contentsDigest, manifestExistsAlready)
-	var tagExistsAlready bool
+	// remember where the tag points before the push, to detect concurrent pushes of the same tag
+	var previousTagDigest digest.Digest
 	if m.Reference.IsTag() {
-		tagExistsAlready, err = p.db.SelectBool(checkTagExistsAtSameDigestQuery, repo.ID, m.Reference.Tag, contentsDigest.String())
+		digestStr, err := p.db.SelectStr(findTagDigestQuery, repo.ID, m.Reference.Tag)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
-		logg.Debug("ValidateAndStoreManifest: in repo %d, tag %s @%s already exists = %t", repo.ID, m.Reference.Tag, contentsDigest, tagExistsAlready)
+		previousTagDigest = digest.Digest(digestStr)
+		logg.Debug("ValidateAndStoreManifest: in repo %d, tag %s currently points to %q", repo.ID, m.Reference.Tag, previousTagDigest)
 	}
+	tagExistsAlready := previousTagDigest == contentsDigest
 
 	// the quota check can be skipped if we are sure that we won't need to insert
 	// a new row into the manifests table
 	if !manifestExistsAlready {
 		err = p.checkQuotaForManifestPush(account)
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+		err = p.checkNamespaceQuotaForManifestPush(account, repo)
+		if err != nil {
+			return nil, "", err
 		}
 	}
 
+	var tagDigest digest.Digest
 	manifest := &models.Manifest{
 		//NOTE: .Digest and .SizeBytes are computed by validateAndStoreManifestCommon()
 		RepositoryID:     repo.ID,
@@ -88,6 +103,9 @@ func (p *Processor) ValidateAndStoreManifest(ctx context.Context, account models
 		PushedAt:         m.PushedAt,
 		NextValidationAt: m.PushedAt.Add(models.ManifestValidationInterval),
 	}
+	if m.PushInfo != nil {
+		manifest.PushInfoJSON = m.PushInfo.Serialize()
+	}
 	if m.Reference.IsDigest() {
 		// allow validateAndStoreManifestCommon() to validate the user-supplied
 		// digest against the actual manifest data
@@ -95,14 +113,16 @@ func (p *Processor) ValidateAndStoreManifest(ctx context.Context, account models
 	}
 	err = p.validateAndStoreManifestCommon(ctx, account, repo, manifest, NewBytesWithDigest(m.Contents), validateAndStoreManifestOpts{
 		IsBeingPushed: true,
+		Tag:           m.Reference.Tag,
+		Actor:         actx.UserIdentity,
 		ActionBeforeCommit: func(tx *gorp.Transaction) error {
 			if m.Reference.IsTag() {
-				err = upsertTag(tx, models.Tag{
+				tagDigest, err = storeTagForPush(tx, account, repo, models.Tag{
 					RepositoryID: repo.ID,
 					Name:         m.Reference.Tag,
 					Digest:       manifest.Digest,
 					PushedAt:     m.PushedAt,
-				})
+				}, previousTagDigest)
 				if err != nil {
 					return err
 				}
@@ -110,12 +130,15 @@ func (p *Processor) ValidateAndStoreManifest(ctx context.Context, account models
 
 			// after making all DB changes, but before committing the DB transaction,
 			// write the manifest into the backend
-			return p.sd.WriteManifest(ctx, account, repo.Name, manifest.Digest, m.Contents)
+			err := keppel.WriteManifestToStorage(ctx, p.sd, p.secd, account, repo.Name, manifest.Digest, m.Contents)
+			p.RecordStorageQuotaError(account, err)
+			return err
 		},
 	})
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	p.clearStorageQuotaError(account)
 
 	// submit audit events, but only if we are reasonably sure that we actually
 	// inserted a new manifest and/or changed a tag (without this restriction, we
@@ -149,23 +172,41 @@ func (p *Processor) ValidateAndStoreManifest(ctx context.Context, account models
 			})
 		}
 	}
-	return manifest, nil
+	return manifest, tagDigest, nil
 }
 
 // ValidateExistingManifest validates the given manifest that already exists in the DB.
+//
+// Since the contents of the manifest are written into the DB again, this also
+// re-encrypts them if the account's content encryption key has changed since
+// the manifest was last written. The copy in the storage backend is rewritten
+// in this case, too.
 func (p *Processor) ValidateExistingManifest(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifest *models.Manifest) error {
-	manifestBytes, err := p.sd.ReadManifest(ctx, account, repo.Name, manifest.Digest)
+	storedBytes, err := p.sd.ReadManifest(ctx, account, repo.Name, manifest.Digest)
+	if err != nil {
+		return err
+	}
+	manifestBytes, keyRef, err := keppel.DecodeManifestFromStorage(ctx, p.secd, account.AuthTenantID, manifest.Digest, storedBytes)
 	if err != nil {
 		return err
 	}
 
 	return p.validateAndStoreManifestCommon(ctx, account, repo, manifest, NewBytesWithDigest(manifestBytes),
-		validateAndStoreManifestOpts{},
+		validateAndStoreManifestOpts{
+			ActionBeforeCommit: func(*gorp.Transaction) error {
+				if keyRef == account.ContentEncryptionKeyRef {
+					return nil
+				}
+				return keppel.WriteManifestToStorage(ctx, p.sd, p.secd, account, repo.Name, manifest.Digest, manifestBytes)
+			},
+		},
 	)
 }
 
 type validateAndStoreManifestOpts struct {
-	IsBeingPushed      bool // only set when the manifest is pushed, not when it is later validated
+	IsBeingPushed      bool                // only set when the manifest is pushed, not when it is later validated
+	Tag                string              // only set when IsBeingPushed and the manifest is pushed by tag
+	Actor              keppel.UserIdentity // only set when IsBeingPushed
 	ActionBeforeCommit func(*gorp.Transaction) error
 }
 
@@ -188,75 +229,111 @@ func (p *Processor) validateAndStoreManifestCommon(ctx context.Context, account
 		manifest.SizeBytes += keppel.AtLeastZero(desc.Size)
 	}
 
-	return p.insideTransaction(ctx, func(ctx context.Context, tx *gorp.Transaction) error {
-		refsInfo, err := findManifestReferencedObjects(tx, account, repo, manifestParsed)
-		if err != nil {
-			return err
+	// NOTE: All checks (including the admission webhook) run before the
+	// transaction is opened, so that no DB locks are held while waiting for
+	// external services.
+	refsInfo, err := findManifestReferencedObjects(p.db, account, repo, manifestParsed)
+	if err != nil {
+		return err
+	}
+	manifest.SizeBytes += refsInfo.SumChildSizes
+
+	configInfo, err := parseManifestConfig(ctx, p.db, p.sd, account, manifestParsed)
+	if err != nil {
+		return err
+	}
+
+	// enforce account-specific validation rules on manifest, but not list manifest
+	// and only when pushing (not when validating at a later point in time,
+	// the set of RequiredLabels could have been changed by then)
+	labelsRequired := opts.IsBeingPushed && account.RequiredLabels != "" &&
+		manifest.MediaType != imageManifest.DockerV2ListMediaType && manifest.MediaType != imagespecs.MediaTypeImageIndex
+	if labelsRequired {
+		var missingLabels []string
+		for _, l := range account.SplitRequiredLabels() {
+			if _, exists := configInfo.Labels[l]; !exists {
+				missingLabels = append(missingLabels, l)
+			}
 		}
-		manifest.SizeBytes += refsInfo.SumChildSizes
+		if len(missingLabels) > 0 {
+			msg := "missing required labels: " + strings.Join(missingLabels, ", ")
+			return keppel.ErrManifestInvalid.With(msg).WithDetail(keppel.RegistryV2ErrorDetail{
+				Reason:        keppel.ReasonMissingRequiredLabels,
+				MissingLabels: missingLabels,
+			})
+		}
+	}
 
-		configInfo, err := parseManifestConfig(ctx, tx, p.sd, account, manifestParsed)
+	// for plain manifests, we report the labels from the manifest config; for
+	// list manifests (which do not have a config), we instead report all the
+	// labels that the constituent manifests agree on
+	reportedLabels := configInfo.Labels
+	if manifest.MediaType == imageManifest.DockerV2ListMediaType || manifest.MediaType == imagespecs.MediaTypeImageIndex {
+		reportedLabels = refsInfo.CommonLabels
+	}
+	if len(reportedLabels) > 0 {
+		labelsJSON, err := json.Marshal(reportedLabels)
 		if err != nil {
 			return err
 		}
+		manifest.LabelsJSON = string(labelsJSON)
+	} else {
+		manifest.LabelsJSON = ""
+	}
 
-		// enforce account-specific validation rules on manifest, but not list manifest
-		// and only when pushing (not when validating at a later point in time,
-		// the set of RequiredLabels could have been changed by then)
-		labelsRequired := opts.IsBeingPushed && account.RequiredLabels != "" &&
-			manifest.MediaType != imageManifest.DockerV2ListMediaType && manifest.MediaType != imagespecs.MediaTypeImageIndex
-		if labelsRequired {
-			var missingLabels []string
-			for _, l := range account.SplitRequiredLabels() {
-				if _, exists := configInfo.Labels[l]; !exists {
-					missingLabels = append(missingLabels, l)
-				}
-			}
-			if len(missingLabels) > 0 {
-				msg := "missing required labels: " + strings.Join(missingLabels, ", ")
-				return keppel.ErrManifestInvalid.With(msg)
-			}
+	annotations := manifestParsed.GetAnnotations()
+	if len(annotations) > 0 {
+		annotationsJSON, err := json.Marshal(annotations)
+		if err != nil {
+			return err
 		}
+		manifest.AnnotationsJSON = string(annotationsJSON)
+	} else {
+		manifest.AnnotationsJSON = ""
+	}
 
-		// for plain manifests, we report the labels from the manifest config; for
-		// list manifests (which do not have a config), we instead report all the
-		// labels that the constituent manifests agree on
-		reportedLabels := configInfo.Labels
-		if manifest.MediaType == imageManifest.DockerV2ListMediaType || manifest.MediaType == imagespecs.MediaTypeImageIndex {
-			reportedLabels = refsInfo.CommonLabels
-		}
-		if len(reportedLabels) > 0 {
-			labelsJSON, err := json.Marshal(reportedLabels)
-			if err != nil {
-				return err
-			}
-			manifest.LabelsJSON = string(labelsJSON)
-		} else {
-			manifest.LabelsJSON = ""
+	// non-fatal problems are only reported, but do not cause the manifest to be rejected
+	warnings := keppel.ManifestValidationWarnings(account, manifestParsed, p.cfg.ManifestLayerCountWarningThreshold)
+	if len(warnings) > 0 {
+		warningsJSON, err := json.Marshal(warnings)
+		if err != nil {
+			return err
 		}
+		manifest.ValidationWarningsJSON = string(warningsJSON)
+	} else {
+		manifest.ValidationWarningsJSON = ""
+	}
 
-		annotations := manifestParsed.GetAnnotations()
-		if len(annotations) > 0 {
-			annotationsJSON, err := json.Marshal(annotations)
-			if err != nil {
-				return err
-			}
-			manifest.AnnotationsJSON = string(annotationsJSON)
-		} else {
-			manifest.AnnotationsJSON = ""
-		}
+	manifest.MinLayerCreatedAt = keppel.MinMaybeTime(refsInfo.MinCreationTime, configInfo.MinCreationTime)
+	manifest.MaxLayerCreatedAt = keppel.MaxMaybeTime(refsInfo.MaxCreationTime, configInfo.MaxCreationTime)
 
-		manifest.MinLayerCreatedAt = keppel.MinMaybeTime(refsInfo.MinCreationTime, configInfo.MinCreationTime)
-		manifest.MaxLayerCreatedAt = keppel.MaxMaybeTime(refsInfo.MaxCreationTime, configInfo.MaxCreationTime)
+	// backfill information incase the manifest was uploaded before we supported them
+	manifest.ArtifactType = manifestParsed.GetArtifactType()
+	if subject := manifestParsed.GetSubject(); subject != nil {
+		manifest.SubjectDigest = subject.Digest
+	}
 
-		// backfill information incase the manifest was uploaded before we supported them
-		manifest.ArtifactType = manifestParsed.GetArtifactType()
-		if subject := manifestParsed.GetSubject(); subject != nil {
-			manifest.SubjectDigest = subject.Digest
+	// enforce admission policies only when pushing (for the same reason as with RequiredLabels above)
+	if opts.IsBeingPushed && account.AdmissionPoliciesJSON != "" {
+		err := p.checkAdmissionPolicies(account, repo, manifest, reportedLabels, annotations)
+		if err != nil {
+			return err
 		}
+	}
+	if opts.IsBeingPushed && p.cfg.AdmissionWebhook != nil {
+		err := p.checkAdmissionWebhook(ctx, *p.cfg.AdmissionWebhook, account, repo, manifest, opts, reportedLabels, annotations)
+		if err != nil {
+			return err
+		}
+	}
 
+	return p.insideTransaction(ctx, func(ctx context.Context, tx *gorp.Transaction) error {
 		// create or update database entries
-		err = upsertManifest(tx, *manifest, manifestBytes.Bytes(), p.timeNow())
+		content, err := keppel.EncryptManifestContent(ctx, p.secd, account.AuthTenantID, account.ContentEncryptionKeyRef, manifest.Digest, manifestBytes.Bytes())
+		if err != nil {
+			return err
+		}
+		err = upsertManifest(tx, *manifest, models.ManifestContent{Content: content, EncryptionKeyRef: account.ContentEncryptionKeyRef}, p.timeNow())
 		if err != nil {
 			return err
 		}
@@ -294,7 +371,7 @@ type manifestRefsInfo struct {
 	SumChildSizes   uint64
 }
 
-func findManifestReferencedObjects(tx *gorp.Transaction, account models.ReducedAccount, repo models.Repository, manifest keppel.ParsedManifest) (result manifestRefsInfo, err error) {
+func findManifestReferencedObjects(db gorp.SqlExecutor, account models.ReducedAccount, repo models.Repository, manifest keppel.ParsedManifest) (result manifestRefsInfo, err error) {
 	// ensure that we don't insert duplicate entries into `blobRefs` and `manifestDigests`
 	wasHandled := make(map[digest.Digest]bool)
 
@@ -305,8 +382,13 @@ func findManifestReferencedObjects(tx *gorp.Transaction, account models.ReducedA
 		}
 		wasHandled[layerInfo.Digest] = true
 
+		// foreign layers may not need to exist in our storage (depending on the account's configuration)
+		if !keppel.IsBlobRequiredForLayer(account, layerInfo) {
+			continue
+		}
+
 		// check that the blob exists
-		blob, err := keppel.FindBlobByRepository(tx, layerInfo.Digest, repo)
+		blob, err := keppel.FindBlobByRepository(db, layerInfo.Digest, repo)
 		if errors.Is(err, sql.ErrNoRows) {
 			return manifestRefsInfo{}, keppel.ErrManifestBlobUnknown.With("").WithDetail(layerInfo.Digest.String())
 		}
@@ -332,7 +414,7 @@ func findManifestReferencedObjects(tx *gorp.Transaction, account models.ReducedA
 		wasHandled[desc.Digest] = true
 
 		// check that the child manifest exists
-		manifest, err := keppel.FindManifest(tx, repo, desc.Digest)
+		manifest, err := keppel.FindManifest(db, repo, desc.Digest)
 		if errors.Is(err, sql.ErrNoRows) {
 			return manifestRefsInfo{}, keppel.ErrManifestUnknown.With("").WithDetail(desc.Digest.String())
 		}
@@ -379,7 +461,7 @@ type manifestConfigInfo struct {
 }
 
 // Returns the list of missing labels, or nil if everything is ok.
-func parseManifestConfig(ctx context.Context, tx *gorp.Transaction, sd keppel.StorageDriver, account models.ReducedAccount, manifest keppel.ParsedManifest) (result manifestConfigInfo, err error) {
+func parseManifestConfig(ctx context.Context, db gorp.SqlExecutor, sd keppel.StorageDriver, account models.ReducedAccount, manifest keppel.ParsedManifest) (result manifestConfigInfo, err error) {
 	// is this manifest an image that has labels?
 	configBlob := manifest.FindImageConfigBlob()
 	if configBlob == nil {
@@ -387,7 +469,7 @@ func parseManifestConfig(ctx context.Context, tx *gorp.Transaction, sd keppel.St
 	}
 
 	// load the config blob
-	storageID, err := tx.SelectStr(
+	storageID, err := db.SelectStr(
 		`SELECT storage_id FROM blobs WHERE account_name = $1 AND digest = $2`,
 		account.Name, configBlob.Digest.String(),
 	)
@@ -445,20 +527,26 @@ func parseManifestConfig(ctx context.Context, tx *gorp.Transaction, sd keppel.St
 	return result, nil
 }
 
+// NOTE: Pushing a quarantined manifest again must not release it from quarantine.
+// NOTE: Pushing a manifest again must not overwrite the info about the original push.
 var upsertManifestQuery = sqlext.SimplifyWhitespace(`
-	INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at, labels_json, min_layer_created_at, max_layer_created_at, annotations_json, artifact_type, subject_digest)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at, labels_json, min_layer_created_at, max_layer_created_at, annotations_json, artifact_type, subject_digest, validation_warnings_json, quarantined_at, quarantine_reason, push_info_json)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 	ON CONFLICT (repo_id, digest) DO UPDATE
 		SET size_bytes = EXCLUDED.size_bytes, next_validation_at = EXCLUDED.next_validation_at, labels_json = EXCLUDED.labels_json,
 		min_layer_created_at = EXCLUDED.min_layer_created_at, max_layer_created_at = EXCLUDED.max_layer_created_at,
-    annotations_json = EXCLUDED.annotations_json, artifact_type = EXCLUDED.artifact_type, subject_digest = EXCLUDED.subject_digest
+    annotations_json = EXCLUDED.annotations_json, artifact_type = EXCLUDED.artifact_type, subject_digest = EXCLUDED.subject_digest,
+		validation_warnings_json = EXCLUDED.validation_warnings_json,
+		quarantine_reason = CASE WHEN manifests.quarantined_at IS NULL THEN EXCLUDED.quarantine_reason ELSE manifests.quarantine_reason END,
+		quarantined_at = COALESCE(manifests.quarantined_at, EXCLUDED.quarantined_at),
+		push_info_json = CASE WHEN manifests.push_info_json = '' THEN EXCLUDED.push_info_json ELSE manifests.push_info_json END
 `)
 
 var upsertManifestContentQuery = sqlext.SimplifyWhitespace(`
-	INSERT INTO manifest_contents (repo_id, digest, content)
-	VALUES ($1, $2, $3)
+	INSERT INTO manifest_contents (repo_id, digest, content, encryption_key_ref)
+	VALUES ($1, $2, $3, $4)
 	ON CONFLICT (repo_id, digest) DO UPDATE
-		SET content = EXCLUDED.content
+		SET content = EXCLUDED.content, encryption_key_ref = EXCLUDED.encryption_key_ref
 `)
 
 var upsertManifestSecurityInfo = sqlext.SimplifyWhitespace(`
@@ -467,12 +555,14 @@ var upsertManifestSecurityInfo = sqlext.SimplifyWhitespace(`
 	ON CONFLICT DO NOTHING
 `)
 
-func upsertManifest(db gorp.SqlExecutor, m models.Manifest, manifestBytes []byte, timeNow time.Time) error {
-	_, err := db.Exec(upsertManifestQuery, m.RepositoryID, m.Digest, m.MediaType, m.SizeBytes, m.PushedAt, m.NextValidationAt, m.LabelsJSON, m.MinLayerCreatedAt, m.MaxLayerCreatedAt, m.AnnotationsJSON, m.ArtifactType, m.SubjectDigest)
+// The RepositoryID and Digest fields of `content` are ignored since they are
+// taken from `m` instead.
+func upsertManifest(db gorp.SqlExecutor, m models.Manifest, content models.ManifestContent, timeNow time.Time) error {
+	_, err := db.Exec(upsertManifestQuery, m.RepositoryID, m.Digest, m.MediaType, m.SizeBytes, m.PushedAt, m.NextValidationAt, m.LabelsJSON, m.MinLayerCreatedAt, m.MaxLayerCreatedAt, m.AnnotationsJSON, m.ArtifactType, m.SubjectDigest, m.ValidationWarningsJSON, m.QuarantinedAt, m.QuarantineReason, m.PushInfoJSON)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(upsertManifestContentQuery, m.RepositoryID, m.Digest, manifestBytes)
+	_, err = db.Exec(upsertManifestContentQuery, m.RepositoryID, m.Digest, content.Content, content.EncryptionKeyRef)
 	if err != nil {
 		return err
 	}
@@ -489,7 +579,10 @@ var upsertTagQuery = sqlext.SimplifyWhitespace(`
 			-- only set "pushed_at" when the tag is actually moving to a different manifest
 			pushed_at = (CASE WHEN tags.digest = EXCLUDED.digest THEN tags.pushed_at ELSE EXCLUDED.pushed_at END),
 			-- merge "last_pulled_at" when staying on the same manifest, otherwise use only new value
-			last_pulled_at = (CASE WHEN tags.digest = EXCLUDED.digest THEN GREATEST(tags.last_pulled_at, EXCLUDED.last_pulled_at) ELSE EXCLUDED.last_pulled_at END)
+			last_pulled_at = (CASE WHEN tags.digest = EXCLUDED.digest THEN GREATEST(tags.last_pulled_at, EXCLUDED.last_pulled_at) ELSE EXCLUDED.last_pulled_at END),
+			-- like "last_pulled_at", the pull counters refer to the manifest that the tag points to
+			pull_count = (CASE WHEN tags.digest = EXCLUDED.digest THEN tags.pull_count ELSE 0 END),
+			child_pull_count = (CASE WHEN tags.digest = EXCLUDED.digest THEN tags.child_pull_count ELSE 0 END)
 `)
 
 func upsertTag(db gorp.SqlExecutor, t models.Tag) error {
@@ -497,6 +590,73 @@ func upsertTag(db gorp.SqlExecutor, t models.Tag) error {
 	return err
 }
 
+var lockTagForPushQuery = sqlext.SimplifyWhitespace(`
+	SELECT digest FROM tags WHERE repo_id = $1 AND name = $2 FOR UPDATE
+`)
+
+var insertTagForPushQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO tags (repo_id, name, digest, pushed_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (repo_id, name) DO NOTHING
+`)
+
+// Like upsertTag, but for tags that are being pushed by a user. Concurrent
+// pushes of the same tag are serialized by locking the tag row. Returns the
+// digest that the tag points to afterwards (see ValidateAndStoreManifest).
+//
+// `previousDigest` is where the tag pointed (or "" if it did not exist) when
+// the push started. If the tag has been moved to a different manifest since
+// then, a concurrent push has won the race, and this push fails with a
+// conflict. (This only uses the state in the DB, so it does not depend on
+// the clocks of the API instances involved.)
+//
+// Moving an existing tag is rejected if the tag is immutable according to the
+// account's tag protection policies. (This is not enforced in replica
+// accounts, where tags follow the upstream.)
+func storeTagForPush(tx *gorp.Transaction, account models.ReducedAccount, repo models.Repository, t models.Tag, previousDigest digest.Digest) (digest.Digest, error) {
+	var currentDigest digest.Digest
+	err := tx.QueryRow(lockTagForPushQuery, t.RepositoryID, t.Name).Scan(&currentDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		// tag does not exist yet; if a concurrent push creates it first, this
+		// insert waits for that transaction and then does nothing
+		result, err := tx.Exec(insertTagForPushQuery, t.RepositoryID, t.Name, t.Digest, t.PushedAt)
+		if err != nil {
+			return "", err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return "", err
+		}
+		if rowsAffected > 0 {
+			return t.Digest, nil
+		}
+		// the concurrent push won the race -> treat its result like any other
+		// existing tag, so that the checks below apply to it
+		err = tx.QueryRow(lockTagForPushQuery, t.RepositoryID, t.Name).Scan(&currentDigest)
+		if err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	switch {
+	case currentDigest == t.Digest:
+		// pushing the same manifest to the same tag again is a no-op
+		return currentDigest, nil
+	case currentDigest != previousDigest:
+		msg := fmt.Sprintf("tag %q was moved to %s by a concurrent push while this push was being processed", t.Name, currentDigest)
+		return "", keppel.ErrDenied.With(msg).WithStatus(http.StatusConflict).WithHeader("X-Keppel-Tag-Digest", currentDigest.String())
+	case account.UpstreamPeerHostName == "" && account.ExternalPeerURL == "":
+		err := keppel.CheckTagOverwriteAllowed(account, repo.Name, t.Name)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return t.Digest, upsertTag(tx, t)
+}
+
 func maintainManifestBlobRefs(tx *gorp.Transaction, m models.Manifest, referencedBlobs []blobRef) error {
 	// maintain media type on blobs (we have no way of knowing the media type of a
 	// blob when it gets uploaded by itself, but manifests always include the
@@ -655,13 +815,20 @@ func (e UpstreamManifestMissingError) Error() string {
 // ReplicateManifest replicates the manifest from its account's upstream registry.
 // On success, the manifest's metadata and contents are returned.
 func (p *Processor) ReplicateManifest(ctx context.Context, account models.ReducedAccount, repo models.Repository, reference models.ManifestReference, actx keppel.AuditContext) (*models.Manifest, []byte, error) {
+	err := checkReplicationNotPaused(account)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	manifestBytes, manifestMediaType, err := p.downloadManifestViaInboundCache(ctx, account, repo, reference)
 	if err != nil {
 		if errorIsManifestNotFound(err) {
 			return nil, nil, UpstreamManifestMissingError{reference, err}
 		}
+		p.recordReplicationOutcome(account, true, &actx)
 		return nil, nil, err
 	}
+	p.recordReplicationOutcome(account, false, &actx)
 
 	// parse the manifest to discover references to other manifests and blobs
 	manifestParsed, err := keppel.ParseManifest(manifestMediaType, manifestBytes)
@@ -680,8 +847,22 @@ func (p *Processor) ReplicateManifest(ctx context.Context, account models.Reduce
 		}
 	}
 
+	// if only the metadata of a tagged image changed upstream, the layers are
+	// already present in this repo and do not need to be looked at again
+	knownLayers, err := p.findPredecessorWithSameLayers(ctx, repo, reference, manifestParsed)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// mark all missing blobs as pending replication
 	for _, layerInfo := range manifestParsed.BlobReferences() {
+		if knownLayers[layerInfo.Digest] {
+			continue
+		}
+		// foreign layers may not need to be replicated (depending on the account's configuration)
+		if !keppel.IsBlobRequiredForLayer(account, layerInfo) {
+			continue
+		}
 		// mark referenced blobs as pending replication if not replicated yet
 		blob, err := p.FindBlobOrInsertUnbackedBlob(ctx, layerInfo, account.Name)
 		if err != nil {
@@ -713,13 +894,16 @@ func (p *Processor) ReplicateManifest(ctx context.Context, account models.Reduce
 		}
 	}
 
-	manifest, err := p.ValidateAndStoreManifest(ctx, account, repo, IncomingManifest{
+	manifest, _, err := p.ValidateAndStoreManifest(ctx, account, repo, IncomingManifest{
 		Reference: reference,
 		MediaType: manifestMediaType,
 		Contents:  manifestBytes,
 		PushedAt:  p.timeNow(),
 	}, actx)
-	return manifest, manifestBytes, err
+	if err != nil {
+		return nil, nil, err
+	}
+	return manifest, manifestBytes, nil
 }
 
 // CheckManifestOnPrimary checks if the given manifest exists on its account's
@@ -756,7 +940,7 @@ func errorIsUpstreamRateLimit(err error) bool {
 // Downloads a manifest from an account's upstream using
 // RepoClient.DownloadManifest(), but also takes into account the inbound cache.
 func (p *Processor) downloadManifestViaInboundCache(ctx context.Context, account models.ReducedAccount, repo models.Repository, ref models.ManifestReference) (manifestBytes []byte, manifestMediaType string, err error) {
-	c, err := p.getRepoClientForUpstream(account, repo)
+	c, err := p.getRepoClientForUpstream(ctx, account, repo)
 	if err != nil {
 		return nil, "", err
 	}
@@ -777,16 +961,27 @@ func (p *Processor) downloadManifestViaInboundCache(ctx context.Context, account
 		return nil, "", err
 	}
 
-	// cache miss -> download from actual upstream registry
-	manifestBytes, manifestMediaType, err = c.DownloadManifest(ctx, ref, &client.DownloadManifestOpts{
-		DoNotCountTowardsLastPulled: true,
+	// cache miss -> download from actual upstream registry (or from a nearer
+	// peer, if the manifest is referenced by digest)
+	var probe func(context.Context, *client.RepoClient) (bool, error)
+	if !ref.IsTag() {
+		probe = func(ctx context.Context, c *client.RepoClient) (bool, error) {
+			_, err := c.GetManifestDigest(ctx, ref)
+			return err == nil, err
+		}
+	}
+	err = p.replicateFromNearestSource(ctx, account, repo, "manifest", probe, c, func(c *client.RepoClient) (err error) {
+		manifestBytes, manifestMediaType, err = c.DownloadManifest(ctx, ref, &client.DownloadManifestOpts{
+			DoNotCountTowardsLastPulled: true,
+		})
+		return err
 	})
 	if err != nil && account.ExternalPeerURL != "" && errorIsUpstreamRateLimit(err) {
 		// when a pull from an external registry runs into a rate limit, ask a
 		// random peer to retry the pull for us; they might be successful since
 		// rate limits are usually per source IP
 		var ok bool
-		manifestBytes, manifestMediaType, ok = p.downloadManifestViaPullDelegation(ctx, imageRef, account.ExternalPeerUserName, account.ExternalPeerPassword)
+		manifestBytes, manifestMediaType, ok = p.downloadManifestViaPullDelegation(ctx, imageRef, c.UserName, c.Password)
 		if ok {
 			err = nil
 		}
@@ -834,8 +1029,29 @@ func (p *Processor) downloadManifestViaPullDelegation(ctx context.Context, image
 	return respBytes, contentType, true
 }
 
+// CheckDeletionAllowedByTagProtection checks whether the given user may
+// delete the given tag, or the given manifest including all its tags,
+// according to the account's tag protection policies (see
+// keppel.CheckTagDeletionAllowed). This is only checked for deletions
+// requested through the API, not for deletions caused by GC policies.
+func (p *Processor) CheckDeletionAllowedByTagProtection(account models.ReducedAccount, repo models.Repository, ref models.ManifestReference, uid keppel.UserIdentity) error {
+	if account.TagProtectionPoliciesJSON == "" {
+		return nil
+	}
+	tagNames := []string{ref.Tag}
+	if !ref.IsTag() {
+		tagNames = nil
+		_, err := p.db.Select(&tagNames, `SELECT name FROM tags WHERE repo_id = $1 AND digest = $2 ORDER BY name`, repo.ID, ref.Digest)
+		if err != nil {
+			return err
+		}
+	}
+	return keppel.CheckTagDeletionAllowed(account, repo.Name, tagNames, uid)
+}
+
 // DeleteManifest deletes the given manifest from both the database and the
-// backing storage.
+// backing storage. If the account has CascadeDeleteReferrers enabled, all
+// manifests referring to this manifest as their subject are deleted as well.
 //
 // If the manifest does not exist, sql.ErrNoRows is returned.
 func (p *Processor) DeleteManifest(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest, actx keppel.AuditContext) error {
@@ -911,6 +1127,31 @@ func (p *Processor) DeleteManifest(ctx context.Context, account models.ReducedAc
 		})
 	}
 
+	if account.CascadeDeleteReferrers {
+		return p.deleteReferrers(ctx, account, repo, manifestDigest, actx)
+	}
+	return nil
+}
+
+// deleteReferrers deletes all manifests that refer to the given (already
+// deleted) manifest as their subject. Since DeleteManifest recurses into this
+// function, referrers of referrers are deleted as well.
+func (p *Processor) deleteReferrers(ctx context.Context, account models.ReducedAccount, repo models.Repository, subjectDigest digest.Digest, actx keppel.AuditContext) error {
+	var referrerDigests []digest.Digest
+	_, err := p.db.Select(&referrerDigests,
+		`SELECT digest FROM manifests WHERE repo_id = $1 AND subject_digest = $2 ORDER BY digest`,
+		repo.ID, subjectDigest)
+	if err != nil {
+		return fmt.Errorf("cannot find referrers of deleted manifest %s: %w", subjectDigest, err)
+	}
+
+	for _, referrerDigest := range referrerDigests {
+		err := p.DeleteManifest(ctx, account, repo, referrerDigest, actx)
+		// if the referrer was deleted concurrently, that's fine
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("cannot delete referrer %s of deleted manifest %s: %w", referrerDigest, subjectDigest, err)
+		}
+	}
 	return nil
 }
 
@@ -998,3 +1239,56 @@ func (a auditTag) Render() cadf.Resource {
 		ProjectID: a.Account.AuthTenantID,
 	}
 }
+
+// checkAdmissionPolicies returns an error if the given manifest is rejected by
+// an admission policy. If an admission policy with action "quarantine" is not
+// satisfied, the manifest is put into quarantine instead.
+func (p *Processor) checkAdmissionPolicies(account models.ReducedAccount, repo models.Repository, manifest *models.Manifest, labels, annotations map[string]string) error {
+	policies, err := keppel.ParseAdmissionPolicies(account)
+	if err != nil {
+		return err
+	}
+	input := keppel.AdmissionPolicyInput{
+		Account:        account,
+		RepositoryName: repo.Name,
+		Manifest:       *manifest,
+		Labels:         labels,
+		Annotations:    annotations,
+	}
+
+	for _, policy := range policies {
+		prog, err := policy.Compile()
+		if err == nil {
+			var admitted bool
+			admitted, err = prog.Evaluate(input)
+			if err == nil && admitted {
+				continue
+			}
+		}
+
+		// if the policy cannot be evaluated, we err on the side of caution and treat it as violated
+		msg := fmt.Sprintf("admission policy %q", policy.Name)
+		switch {
+		case err != nil:
+			msg += ": " + err.Error()
+		case policy.Message != "":
+			msg += ": " + policy.Message
+		}
+
+		if policy.Action == keppel.AdmissionPolicyQuarantine {
+			// the first violated policy determines the quarantine reason, but we
+			// still need to check the remaining policies for rejections
+			if manifest.QuarantinedAt == nil {
+				now := p.timeNow()
+				manifest.QuarantinedAt = &now
+				manifest.QuarantineReason = "violates " + msg
+			}
+			continue
+		}
+		return keppel.ErrDenied.With("manifest was rejected by " + msg).WithStatus(http.StatusForbidden).WithDetail(keppel.RegistryV2ErrorDetail{
+			Reason:          keppel.ReasonAdmissionPolicy,
+			AdmissionPolicy: policy.Name,
+		})
+	}
+	return nil
+}
@@ -22,9 +22,63 @@ var (
 		},
 		[]string{"external_hostname"},
 	)
+	// InboundReplicationCounter is a prometheus.CounterVec.
+	InboundReplicationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_inbound_replications",
+			Help: "Counter for manifests and blobs that replica accounts tried to replicate from their upstream.",
+		},
+		[]string{"account", "auth_tenant_id", "upstream", "outcome"},
+	)
+	// InboundReplicationSourceCounter is a prometheus.CounterVec.
+	InboundReplicationSourceCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_inbound_replication_sources",
+			Help: "Counter for manifests and blobs that replica accounts replicated successfully, by the registry that they were replicated from.",
+		},
+		[]string{"account", "auth_tenant_id", "source", "source_region", "type"},
+	)
+	// UpstreamRequestRetryCounter is a prometheus.CounterVec.
+	UpstreamRequestRetryCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_upstream_request_retries",
+			Help: "Counter for requests to upstream registries that were retried after a transient error.",
+		},
+		[]string{"external_hostname"},
+	)
+	// UpstreamCircuitBreakerTripCounter is a prometheus.CounterVec.
+	UpstreamCircuitBreakerTripCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_upstream_circuit_breaker_trips",
+			Help: "Counter for how often the circuit breaker for an upstream registry was opened because of repeated failures.",
+		},
+		[]string{"external_hostname"},
+	)
+	// UpstreamCircuitBreakerRejectionCounter is a prometheus.CounterVec.
+	UpstreamCircuitBreakerRejectionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_upstream_circuit_breaker_rejections",
+			Help: "Counter for requests to upstream registries that were not sent because the respective circuit breaker was open.",
+		},
+		[]string{"external_hostname"},
+	)
+	// AdmissionWebhookReviewCounter is a prometheus.CounterVec.
+	AdmissionWebhookReviewCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_admission_webhook_reviews",
+			Help: "Counter for manifest pushes that were submitted to the external admission webhook.",
+		},
+		[]string{"account", "outcome"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(InboundManifestCacheHitCounter)
 	prometheus.MustRegister(InboundManifestCacheMissCounter)
+	prometheus.MustRegister(InboundReplicationCounter)
+	prometheus.MustRegister(InboundReplicationSourceCounter)
+	prometheus.MustRegister(UpstreamRequestRetryCounter)
+	prometheus.MustRegister(UpstreamCircuitBreakerTripCounter)
+	prometheus.MustRegister(UpstreamCircuitBreakerRejectionCounter)
+	prometheus.MustRegister(AdmissionWebhookReviewCounter)
 }
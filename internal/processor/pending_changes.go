@@ -0,0 +1,520 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ErrSelfApproval is returned by ApprovePendingChange when the approver is
+// the same user who requested the change (as determined by keppel.IdentifyUser).
+var ErrSelfApproval = errors.New("pending changes must be approved by a different user than the one who requested them")
+
+// PendingChangeForAccountDeletion returns the PendingChange that needs to be
+// submitted instead of deleting the given account, or nil if the deletion
+// does not require approval.
+func (p *Processor) PendingChangeForAccountDeletion(account models.Account) (*models.PendingChange, error) {
+	policy, err := keppel.ParseApprovalPolicy(account.Reduced())
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	return newPendingChange(account.Name, models.PendingAccountDeletion, keppel.PendingChangePayload{})
+}
+
+// PendingChangeForManifestDeletion returns the PendingChange that needs to be
+// submitted instead of deleting the given manifest, or nil if the deletion
+// does not require approval.
+func (p *Processor) PendingChangeForManifestDeletion(account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest) (*models.PendingChange, error) {
+	policy, err := keppel.ParseApprovalPolicy(account)
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	tagCount, err := p.db.SelectInt(`SELECT COUNT(*) FROM tags WHERE repo_id = $1 AND digest = $2`, repo.ID, manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+	if !policy.RequiresApprovalForTagDeletion(uint64(tagCount)) { //nolint:gosec // COUNT(*) is never negative
+		return nil, nil
+	}
+	_, err = keppel.FindManifest(p.db, repo, manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+	return newPendingChange(account.Name, models.PendingManifestDeletion, keppel.PendingChangePayload{
+		RepositoryName: repo.Name,
+		Digest:         manifestDigest,
+		TagCount:       uint64(tagCount), //nolint:gosec // COUNT(*) is never negative
+	})
+}
+
+// PendingChangeForTagDeletion returns the PendingChange that needs to be
+// submitted instead of deleting the given tag, or nil if the deletion does not
+// require approval.
+func (p *Processor) PendingChangeForTagDeletion(account models.ReducedAccount, repo models.Repository, tagName string) (*models.PendingChange, error) {
+	policy, err := keppel.ParseApprovalPolicy(account)
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	if !policy.RequiresApprovalForTagDeletion(1) {
+		return nil, nil
+	}
+	digestStr, err := p.db.SelectStr(`SELECT digest FROM tags WHERE repo_id = $1 AND name = $2`, repo.ID, tagName)
+	if err != nil {
+		return nil, err
+	}
+	if digestStr == "" {
+		return nil, sql.ErrNoRows
+	}
+	return newPendingChange(account.Name, models.PendingTagDeletion, keppel.PendingChangePayload{
+		RepositoryName: repo.Name,
+		Digest:         digest.Digest(digestStr),
+		TagName:        tagName,
+		TagCount:       1,
+	})
+}
+
+// PendingChangesForAccountUpdate checks which parts of the requested update
+// to an existing account require approval. Those parts are reverted in
+// `account` to their original values, so that the rest of the update can be
+// applied immediately, and are returned as PendingChanges instead.
+func (p *Processor) PendingChangesForAccountUpdate(original models.Account, account *keppel.Account) ([]models.PendingChange, *keppel.RegistryV2Error) {
+	policy, err := keppel.ParseApprovalPolicy(original.Reduced())
+	if err != nil {
+		return nil, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+	}
+	if policy == nil {
+		return nil, nil
+	}
+	var result []models.PendingChange
+
+	// any change to the approval policy requires approval, otherwise it could
+	// trivially be circumvented by removing the policy first
+	if !jsonEqual(account.ApprovalPolicy, policy) {
+		pc, err := newPendingChange(original.Name, models.PendingApprovalPolicyUpdate, keppel.PendingChangePayload{
+			ApprovalPolicy: account.ApprovalPolicy,
+		})
+		if err != nil {
+			return nil, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+		}
+		result = append(result, *pc)
+		account.ApprovalPolicy = policy
+	}
+
+	// GC policy changes require approval if they would delete too many manifests
+	for _, gcPolicy := range account.GCPolicies {
+		err := gcPolicy.Validate()
+		if err != nil {
+			return nil, keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+	}
+	pc, err := p.pendingChangeForGCPoliciesUpdate(original, policy, account.GCPolicies)
+	if err != nil {
+		return nil, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+	}
+	if pc != nil {
+		result = append(result, *pc)
+		account.GCPolicies, err = keppel.ParseGCPolicies(original)
+		if err != nil {
+			return nil, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+		}
+	}
+
+	return result, nil
+}
+
+// Returns the PendingChange that needs to be submitted instead of replacing
+// the account's GC policies with `gcPolicies`, or nil if this does not
+// require approval. The GC policies must already have been validated.
+func (p *Processor) pendingChangeForGCPoliciesUpdate(original models.Account, policy *keppel.ApprovalPolicy, gcPolicies []keppel.GCPolicy) (*models.PendingChange, error) {
+	if policy == nil || policy.GCManifestThreshold == nil {
+		return nil, nil
+	}
+	oldGCPolicies, err := keppel.ParseGCPolicies(original)
+	if err != nil {
+		return nil, err
+	}
+	gcPolicies = normalizeGCPolicies(gcPolicies)
+	if jsonEqual(gcPolicies, normalizeGCPolicies(oldGCPolicies)) {
+		return nil, nil
+	}
+	count, err := p.CountManifestsDeletedByGCPolicyChange(original.Name, oldGCPolicies, gcPolicies)
+	if err != nil {
+		return nil, err
+	}
+	if count <= *policy.GCManifestThreshold {
+		return nil, nil
+	}
+	return newPendingChange(original.Name, models.PendingGCPoliciesUpdate, keppel.PendingChangePayload{
+		ManifestCount: count,
+		GCPolicies:    &gcPolicies,
+	})
+}
+
+// PendingChangeForRepositoryUpdate checks whether the requested update to the
+// GC-related settings of a repository requires approval, because it would
+// make GC delete more manifests than the account's approval policy allows. In
+// that case, those settings are reverted in `repo` to their values in
+// `original`, so that the rest of the update can be applied immediately, and
+// the GC-related settings are returned as a PendingChange instead.
+func (p *Processor) PendingChangeForRepositoryUpdate(account models.Account, original models.Repository, repo *models.Repository) (*models.PendingChange, error) {
+	policy, err := keppel.ParseApprovalPolicy(account.Reduced())
+	if err != nil || policy == nil || policy.GCManifestThreshold == nil {
+		return nil, err
+	}
+	if repo.GCPoliciesJSON == original.GCPoliciesJSON && repo.IsArchived == original.IsArchived &&
+		repo.IgnoreInheritedGCPolicies == original.IgnoreInheritedGCPolicies {
+		return nil, nil
+	}
+
+	accountPolicies, err := keppel.ParseGCPolicies(account)
+	if err != nil {
+		return nil, err
+	}
+	deletedBefore, err := p.simulateGCPolicies(original, accountPolicies)
+	if err != nil {
+		return nil, err
+	}
+	deletedAfter, err := p.simulateGCPolicies(*repo, accountPolicies)
+	if err != nil {
+		return nil, err
+	}
+	var count uint64
+	for manifestDigest := range deletedAfter {
+		if !deletedBefore[manifestDigest] {
+			count++
+		}
+	}
+	if count <= *policy.GCManifestThreshold {
+		return nil, nil
+	}
+
+	gcPolicies, err := keppel.ParseRepositoryGCPolicies(*repo)
+	if err != nil {
+		return nil, err
+	}
+	gcPolicies = normalizeGCPolicies(gcPolicies)
+	pc, err := newPendingChange(account.Name, models.PendingRepoGCPoliciesUpdate, keppel.PendingChangePayload{
+		RepositoryName:            repo.Name,
+		ManifestCount:             count,
+		GCPolicies:                &gcPolicies,
+		IsArchived:                &repo.IsArchived,
+		IgnoreInheritedGCPolicies: &repo.IgnoreInheritedGCPolicies,
+	})
+	if err != nil {
+		return nil, err
+	}
+	repo.GCPoliciesJSON = original.GCPoliciesJSON
+	repo.IsArchived = original.IsArchived
+	repo.IgnoreInheritedGCPolicies = original.IgnoreInheritedGCPolicies
+	return pc, nil
+}
+
+// PendingChangeForNamespaceUpdate checks whether the requested update to the
+// GC policies of a repository namespace requires approval, because it would
+// make GC delete more manifests than the account's approval policy allows.
+// For new namespaces, `original` shall be the zero value. If approval is
+// required, the GC policies are reverted in `namespace` to those in
+// `original`, so that the rest of the update can be applied immediately, and
+// the GC policies are returned as a PendingChange instead.
+func (p *Processor) PendingChangeForNamespaceUpdate(account models.Account, original models.RepositoryNamespace, namespace *models.RepositoryNamespace) (*models.PendingChange, error) {
+	policy, err := keppel.ParseApprovalPolicy(account.Reduced())
+	if err != nil || policy == nil || policy.GCManifestThreshold == nil {
+		return nil, err
+	}
+	if namespace.GCPoliciesJSON == original.GCPoliciesJSON {
+		return nil, nil
+	}
+	count, err := p.CountManifestsDeletedByNamespaceChange(account, original, *namespace)
+	if err != nil {
+		return nil, err
+	}
+	if count <= *policy.GCManifestThreshold {
+		return nil, nil
+	}
+
+	var gcPolicies []keppel.GCPolicy
+	if namespace.GCPoliciesJSON != "" {
+		err := json.Unmarshal([]byte(namespace.GCPoliciesJSON), &gcPolicies)
+		if err != nil {
+			return nil, err
+		}
+	}
+	gcPolicies = normalizeGCPolicies(gcPolicies)
+	pc, err := newPendingChange(account.Name, models.PendingNamespaceGCPoliciesUpdate, keppel.PendingChangePayload{
+		NamespacePrefix: namespace.Prefix,
+		ManifestCount:   count,
+		GCPolicies:      &gcPolicies,
+	})
+	if err != nil {
+		return nil, err
+	}
+	namespace.GCPoliciesJSON = original.GCPoliciesJSON
+	return pc, nil
+}
+
+// SubmitPendingChange stores a PendingChange that was returned by one of the
+// PendingChangeFor...() methods. The change is recorded in the audit log
+// before it is executed, i.e. right away.
+func (p *Processor) SubmitPendingChange(account models.ReducedAccount, pc *models.PendingChange, actx keppel.AuditContext) error {
+	pc.RequestedAt = p.timeNow()
+	pc.RequestedBy = actx.UserIdentity.UserName()
+	if pc.RequestedBy == "" && actx.UserIdentity.UserType() == keppel.JanitorUser {
+		pc.RequestedBy = "keppel-janitor"
+	}
+	pc.RequestedByID = keppel.IdentifyUser(actx.UserIdentity)
+	err := p.db.Insert(pc)
+	if err != nil {
+		return err
+	}
+
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		p.auditor.Record(audittools.Event{
+			Time:       p.timeNow(),
+			Request:    actx.Request,
+			User:       userInfo,
+			ReasonCode: http.StatusAccepted,
+			Action:     cadf.CreateAction,
+			Target:     AuditPendingChange{Account: account, Change: *pc},
+		})
+	}
+	return nil
+}
+
+// Like SubmitPendingChange, but for changes that are computed again and again
+// by the janitor until they are approved. If an equivalent change is already
+// pending, nothing is done. Outdated changes from the same source are
+// replaced.
+func (p *Processor) submitRecurringPendingChange(account models.ReducedAccount, pc *models.PendingChange, actx keppel.AuditContext) error {
+	var payload keppel.PendingChangePayload
+	err := json.Unmarshal([]byte(pc.PayloadJSON), &payload)
+	if err != nil {
+		return err
+	}
+
+	var existingChanges []models.PendingChange
+	_, err = p.db.Select(&existingChanges, `SELECT * FROM pending_changes WHERE account_name = $1 AND kind = $2 AND requested_by_id = $3`,
+		account.Name, pc.Kind, keppel.IdentifyUser(actx.UserIdentity))
+	if err != nil {
+		return err
+	}
+	for _, existing := range existingChanges {
+		var existingPayload keppel.PendingChangePayload
+		err := json.Unmarshal([]byte(existing.PayloadJSON), &existingPayload)
+		if err != nil {
+			return err
+		}
+		// the number of affected manifests changes over time, so it is not compared
+		existingPayload.ManifestCount = payload.ManifestCount
+		if jsonEqual(existingPayload, payload) {
+			return nil
+		}
+		err = p.deletePendingChange(account, existing, cadf.DenyAction, actx)
+		if err != nil {
+			return err
+		}
+	}
+	return p.SubmitPendingChange(account, pc, actx)
+}
+
+// ApprovePendingChange executes the given PendingChange and removes it from
+// the DB. The caller must check that the approver has the permissions
+// required for executing the change.
+func (p *Processor) ApprovePendingChange(ctx context.Context, account models.Account, pc models.PendingChange, actx keppel.AuditContext) error {
+	isSameUser := keppel.IdentifyUser(actx.UserIdentity) == pc.RequestedByID
+	if pc.RequestedByID == "" {
+		// pending changes from before requested_by_id was introduced
+		isSameUser = actx.UserIdentity.UserName() == pc.RequestedBy
+	}
+	if isSameUser {
+		return ErrSelfApproval
+	}
+	var payload keppel.PendingChangePayload
+	if pc.PayloadJSON != "" {
+		err := json.Unmarshal([]byte(pc.PayloadJSON), &payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch pc.Kind {
+	case models.PendingAccountDeletion:
+		if !account.IsDeleting {
+			err := p.MarkAccountForDeletion(account, actx)
+			if err != nil {
+				return err
+			}
+		}
+	case models.PendingManifestDeletion:
+		repo, err := keppel.FindRepository(p.db, payload.RepositoryName, account.Name)
+		if err != nil {
+			return err
+		}
+		err = p.DeleteManifest(ctx, account.Reduced(), *repo, payload.Digest, actx)
+		if err != nil {
+			return err
+		}
+	case models.PendingTagDeletion:
+		repo, err := keppel.FindRepository(p.db, payload.RepositoryName, account.Name)
+		if err != nil {
+			return err
+		}
+		err = p.DeleteTag(account.Reduced(), *repo, payload.TagName, actx)
+		if err != nil {
+			return err
+		}
+	case models.PendingGCPoliciesUpdate:
+		if payload.GCPolicies == nil {
+			return fmt.Errorf("pending change %d does not contain GC policies", pc.ID)
+		}
+		buf, _ := json.Marshal(*payload.GCPolicies)
+		account.GCPoliciesJSON = string(buf)
+		err := p.updateAccountForPendingChange(account, actx)
+		if err != nil {
+			return err
+		}
+	case models.PendingRepoGCPoliciesUpdate:
+		if payload.GCPolicies == nil || payload.IsArchived == nil || payload.IgnoreInheritedGCPolicies == nil {
+			return fmt.Errorf("pending change %d does not contain GC settings", pc.ID)
+		}
+		repo, err := keppel.FindRepository(p.db, payload.RepositoryName, account.Name)
+		if err != nil {
+			return err
+		}
+		repo.GCPoliciesJSON = ""
+		if len(*payload.GCPolicies) > 0 {
+			buf, err := json.Marshal(*payload.GCPolicies)
+			if err != nil {
+				return err
+			}
+			repo.GCPoliciesJSON = string(buf)
+		}
+		repo.IsArchived = *payload.IsArchived
+		repo.IgnoreInheritedGCPolicies = *payload.IgnoreInheritedGCPolicies
+		_, err = p.db.Update(repo)
+		if err != nil {
+			return err
+		}
+	case models.PendingNamespaceGCPoliciesUpdate:
+		if payload.GCPolicies == nil {
+			return fmt.Errorf("pending change %d does not contain GC policies", pc.ID)
+		}
+		var namespace models.RepositoryNamespace
+		err := p.db.SelectOne(&namespace, `SELECT * FROM repo_namespaces WHERE account_name = $1 AND prefix = $2`, account.Name, payload.NamespacePrefix)
+		if err != nil {
+			return err
+		}
+		namespace.GCPoliciesJSON = ""
+		if len(*payload.GCPolicies) > 0 {
+			buf, err := json.Marshal(*payload.GCPolicies)
+			if err != nil {
+				return err
+			}
+			namespace.GCPoliciesJSON = string(buf)
+		}
+		_, err = p.db.Update(&namespace)
+		if err != nil {
+			return err
+		}
+	case models.PendingApprovalPolicyUpdate:
+		account.ApprovalPolicyJSON = ""
+		if payload.ApprovalPolicy != nil {
+			buf, _ := json.Marshal(*payload.ApprovalPolicy)
+			account.ApprovalPolicyJSON = string(buf)
+		}
+		err := p.updateAccountForPendingChange(account, actx)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("pending change %d has unknown kind %q", pc.ID, pc.Kind)
+	}
+
+	return p.deletePendingChange(account.Reduced(), pc, cadf.AllowAction, actx)
+}
+
+// RejectPendingChange removes the given PendingChange without executing it.
+func (p *Processor) RejectPendingChange(account models.ReducedAccount, pc models.PendingChange, actx keppel.AuditContext) error {
+	return p.deletePendingChange(account, pc, cadf.DenyAction, actx)
+}
+
+func (p *Processor) deletePendingChange(account models.ReducedAccount, pc models.PendingChange, action cadf.Action, actx keppel.AuditContext) error {
+	_, err := p.db.Delete(&pc)
+	if err != nil {
+		return err
+	}
+
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		p.auditor.Record(audittools.Event{
+			Time:       p.timeNow(),
+			Request:    actx.Request,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     action,
+			Target:     AuditPendingChange{Account: account, Change: pc},
+		})
+	}
+	return nil
+}
+
+func (p *Processor) updateAccountForPendingChange(account models.Account, actx keppel.AuditContext) error {
+	_, err := p.db.Update(&account)
+	if err != nil {
+		return err
+	}
+	err = keppel.NotifyAccountChanged(p.db, account.Name)
+	if err != nil {
+		return err
+	}
+
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		p.auditor.Record(audittools.Event{
+			Time:       p.timeNow(),
+			Request:    actx.Request,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     cadf.UpdateAction,
+			Target:     AuditAccount{Account: account},
+		})
+	}
+	return nil
+}
+
+func newPendingChange(accountName models.AccountName, kind models.PendingChangeKind, payload keppel.PendingChangePayload) (*models.PendingChange, error) {
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &models.PendingChange{
+		AccountName: accountName,
+		Kind:        kind,
+		PayloadJSON: string(buf),
+	}, nil
+}
+
+// normalizeGCPolicies ensures that an empty list of GC policies is always
+// represented in the same way.
+func normalizeGCPolicies(policies []keppel.GCPolicy) []keppel.GCPolicy {
+	if len(policies) == 0 {
+		return []keppel.GCPolicy{}
+	}
+	return policies
+}
+
+func jsonEqual(lhs, rhs any) bool {
+	lhsJSON, _ := json.Marshal(lhs)
+	rhsJSON, _ := json.Marshal(rhs)
+	return string(lhsJSON) == string(rhsJSON)
+}
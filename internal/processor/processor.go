@@ -28,6 +28,8 @@ type Processor struct {
 	fd          keppel.FederationDriver
 	sd          keppel.StorageDriver
 	icd         keppel.InboundCacheDriver
+	secd        keppel.SecretsDriver
+	nvd         keppel.NameValidationDriver // may be nil
 	auditor     audittools.Auditor
 	repoClients map[string]*client.RepoClient // key = account name
 
@@ -37,8 +39,8 @@ type Processor struct {
 }
 
 // New creates a new Processor.
-func New(cfg keppel.Configuration, db *keppel.DB, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, auditor audittools.Auditor, fd keppel.FederationDriver, timenow func() time.Time) *Processor {
-	return &Processor{cfg, db, fd, sd, icd, auditor, make(map[string]*client.RepoClient), timenow, keppel.GenerateStorageID}
+func New(cfg keppel.Configuration, db *keppel.DB, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, secd keppel.SecretsDriver, auditor audittools.Auditor, fd keppel.FederationDriver, nvd keppel.NameValidationDriver, timenow func() time.Time) *Processor {
+	return &Processor{cfg, db, fd, sd, icd, secd, nvd, auditor, make(map[string]*client.RepoClient), timenow, keppel.GenerateStorageID}
 }
 
 // OverrideTimeNow replaces time.Now with a test double.
@@ -118,14 +120,43 @@ func (p *Processor) checkQuotaForManifestPush(account models.ReducedAccount) err
 		msg := fmt.Sprintf("manifest quota exceeded (quota = %d, usage = %d)",
 			quotas.ManifestCount, manifestUsage,
 		)
-		return keppel.ErrDenied.With(msg).WithStatus(http.StatusConflict)
+		return keppel.ErrDenied.With(msg).WithStatus(http.StatusConflict).
+			WithDetail(keppel.QuotaExceededDetail(quotas.ManifestCount, manifestUsage))
+	}
+	return nil
+}
+
+func (p *Processor) checkNamespaceQuotaForManifestPush(account models.ReducedAccount, repo models.Repository) error {
+	namespace, err := keppel.FindRepositoryNamespaceForRepo(p.db, account.Name, repo.Name)
+	if err != nil || namespace == nil || namespace.ManifestQuota == nil {
+		return err
+	}
+	manifestUsage, err := keppel.GetNamespaceManifestUsage(p.db, *namespace)
+	if err != nil {
+		return err
+	}
+	quota := *namespace.ManifestQuota
+	if manifestUsage >= quota {
+		msg := fmt.Sprintf("manifest quota of namespace %q exceeded (quota = %d, usage = %d)",
+			namespace.Prefix, quota, manifestUsage,
+		)
+		return keppel.ErrDenied.With(msg).WithStatus(http.StatusConflict).
+			WithDetail(keppel.QuotaExceededDetail(quota, manifestUsage))
 	}
 	return nil
 }
 
 // Takes a repo in a replica account and returns a RepoClient for accessing its
 // the upstream repo in the corresponding primary account.
-func (p *Processor) getRepoClientForUpstream(account models.ReducedAccount, repo models.Repository) (*client.RepoClient, error) {
+func (p *Processor) getRepoClientForUpstream(ctx context.Context, account models.ReducedAccount, repo models.Repository) (*client.RepoClient, error) {
+	// the operator may have blocked this upstream after the account was created
+	if account.ExternalPeerURL != "" {
+		rerr := p.cfg.ExternalUpstreamHosts.Check(account.ExternalPeerURL)
+		if rerr != nil {
+			return nil, rerr
+		}
+	}
+
 	// use cached client if possible (this one probably already contains a valid
 	// pull token)
 	if c, ok := p.repoClients[repo.FullName()]; ok {
@@ -139,13 +170,7 @@ func (p *Processor) getRepoClientForUpstream(account models.ReducedAccount, repo
 			return nil, err
 		}
 
-		c := &client.RepoClient{
-			Scheme:   "https",
-			Host:     peer.HostName,
-			RepoName: repo.FullName(),
-			UserName: "replication@" + p.cfg.APIPublicHostname,
-			Password: peer.OurPassword,
-		}
+		c := p.repoClientForPeer(peer, repo)
 		p.repoClients[repo.FullName()] = c
 		return c, nil
 	}
@@ -156,6 +181,8 @@ func (p *Processor) getRepoClientForUpstream(account models.ReducedAccount, repo
 			UserName: account.ExternalPeerUserName,
 			Password: account.ExternalPeerPassword,
 		}
+		c.RetryPolicy, c.CircuitBreaker = p.upstreamResilienceOptions()
+		passwordRef := account.ExternalPeerPasswordRef
 		if strings.Contains(account.ExternalPeerURL, "/") {
 			fields := strings.SplitN(account.ExternalPeerURL, "/", 2)
 			c.Host = fields[0]
@@ -164,9 +191,36 @@ func (p *Processor) getRepoClientForUpstream(account models.ReducedAccount, repo
 			c.Host = account.ExternalPeerURL
 			c.RepoName = repo.Name
 		}
+		// if there are dedicated credentials for this upstream repo, they take precedence
+		if set := account.ExternalPeerCredentials.ForRepo(c.RepoName); set != nil {
+			c.UserName = set.UserName
+			c.Password = set.Password
+			passwordRef = set.PasswordRef
+		}
+		if passwordRef != "" {
+			var err error
+			c.Password, err = p.secd.ReadSecret(ctx, account.AuthTenantID, passwordRef)
+			if err != nil {
+				return nil, fmt.Errorf("cannot obtain password for upstream registry: %w", err)
+			}
+		}
 		p.repoClients[repo.FullName()] = c
 		return c, nil
 	}
 
 	return nil, fmt.Errorf("account %q does not have an upstream", account.Name)
 }
+
+// Returns a RepoClient for accessing the given repo on the given peer, using
+// the replication credentials that we obtained from that peer.
+func (p *Processor) repoClientForPeer(peer models.Peer, repo models.Repository) *client.RepoClient {
+	c := &client.RepoClient{
+		Scheme:   "https",
+		Host:     peer.HostName,
+		RepoName: repo.FullName(),
+		UserName: "replication@" + p.cfg.APIPublicHostname,
+		Password: peer.OurPassword,
+	}
+	c.RetryPolicy, c.CircuitBreaker = p.upstreamResilienceOptions()
+	return c
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/must"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ErrManifestAlreadyInPromotionState is returned by PromoteManifest() if the
+// manifest is already in the requested promotion state.
+type ErrManifestAlreadyInPromotionState struct {
+	State models.PromotionState
+}
+
+// Error implements the builtin/error interface.
+func (e ErrManifestAlreadyInPromotionState) Error() string {
+	return fmt.Sprintf("manifest is already in promotion state %q", e.State)
+}
+
+var promoteManifestQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET promotion_state = $3 WHERE repo_id = $1 AND digest = $2
+`)
+
+var promoteChildManifestsQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET promotion_state = $3
+	 WHERE repo_id = $1 AND digest IN (
+	   SELECT child_digest FROM manifest_manifest_refs WHERE repo_id = $1 AND parent_digest = $2
+	 )
+`)
+
+// PromoteManifest moves the given manifest into the given promotion state (see
+// models.PromotionState). Submanifests of list manifests are moved along with
+// their parent, so that multi-arch images can be pulled as a whole. If the
+// manifest does not exist, sql.ErrNoRows is returned.
+//
+// The caller is responsible for checking that the user is allowed to promote
+// manifests, and that the target state is valid.
+func (p *Processor) PromoteManifest(account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest, state models.PromotionState, actx keppel.AuditContext) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	manifest, err := keppel.FindManifest(tx, repo, manifestDigest)
+	if err != nil {
+		return err
+	}
+	previousState := manifest.PromotionState
+	if previousState == state {
+		return ErrManifestAlreadyInPromotionState{state}
+	}
+
+	_, err = tx.Exec(promoteManifestQuery, repo.ID, manifestDigest, state)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(promoteChildManifestsQuery, repo.ID, manifestDigest, state)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		p.auditor.Record(audittools.Event{
+			Time:       p.timeNow(),
+			Request:    actx.Request,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     cadf.UpdateAction,
+			Target: auditManifestPromotion{
+				Account:       account,
+				Repository:    repo,
+				Digest:        manifestDigest,
+				PreviousState: previousState,
+				NewState:      state,
+			},
+		})
+	}
+	return nil
+}
+
+// auditManifestPromotion is an audittools.Target.
+type auditManifestPromotion struct {
+	Account       models.ReducedAccount
+	Repository    models.Repository
+	Digest        digest.Digest
+	PreviousState models.PromotionState
+	NewState      models.PromotionState
+}
+
+// Render implements the audittools.Target interface.
+func (a auditManifestPromotion) Render() cadf.Resource {
+	res := auditManifest{
+		Account:    a.Account,
+		Repository: a.Repository,
+		Digest:     a.Digest,
+	}.Render()
+	res.Attachments = []cadf.Attachment{must.Return(cadf.NewJSONAttachment("promotion", map[string]models.PromotionState{
+		"previous_state": a.PreviousState,
+		"new_state":      a.NewState,
+	}))}
+	return res
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var (
+	// ErrManifestAlreadyQuarantined is returned by QuarantineManifest() if the manifest is already quarantined.
+	ErrManifestAlreadyQuarantined = errors.New("manifest is already quarantined")
+	// ErrManifestNotQuarantined is returned by ReleaseManifest() if the manifest is not quarantined.
+	ErrManifestNotQuarantined = errors.New("manifest is not quarantined")
+)
+
+var quarantineManifestQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET quarantined_at = $3, quarantine_reason = $4
+	 WHERE repo_id = $1 AND digest = $2 AND quarantined_at IS NULL
+`)
+
+var releaseManifestQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET quarantined_at = NULL, quarantine_reason = ''
+	 WHERE repo_id = $1 AND digest = $2 AND quarantined_at IS NOT NULL
+`)
+
+// QuarantineManifest moves the given manifest into the quarantined state (see
+// models.ManifestState). If the manifest does not exist, sql.ErrNoRows is
+// returned.
+func (p *Processor) QuarantineManifest(account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest, reason string, actx keppel.AuditContext) error {
+	return p.changeManifestState(account, repo, manifestDigest, actx, cadf.DisableAction, ErrManifestAlreadyQuarantined,
+		quarantineManifestQuery, repo.ID, manifestDigest.String(), p.timeNow(), reason)
+}
+
+// ReleaseManifest moves the given quarantined manifest back into the active
+// state (see models.ManifestState). If the manifest does not exist,
+// sql.ErrNoRows is returned.
+func (p *Processor) ReleaseManifest(account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest, actx keppel.AuditContext) error {
+	return p.changeManifestState(account, repo, manifestDigest, actx, cadf.EnableAction, ErrManifestNotQuarantined,
+		releaseManifestQuery, repo.ID, manifestDigest.String())
+}
+
+func (p *Processor) changeManifestState(account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest, actx keppel.AuditContext, action cadf.Action, errWrongState error, query string, args ...any) error {
+	result, err := p.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		// distinguish between "manifest does not exist" and "manifest is in the wrong state"
+		_, err := keppel.FindManifest(p.db, repo, manifestDigest)
+		if err != nil {
+			return err
+		}
+		return errWrongState
+	}
+
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		p.auditor.Record(audittools.Event{
+			Time:       p.timeNow(),
+			Request:    actx.Request,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     action,
+			Target: auditManifest{
+				Account:    account,
+				Repository: repo,
+				Digest:     manifestDigest,
+			},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+
+	imageManifest "github.com/containers/image/v5/manifest"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// GenerateZstdVariant recompresses all gzip layers of the given image
+// manifest with zstd, and stores an image manifest referencing the
+// recompressed layers in the same repository. Like with
+// GenerateEstargzVariant, the new manifest has the original manifest as its
+// subject. Since the uncompressed tar streams do not change, the image config
+// is shared with the original manifest.
+func (p *Processor) GenerateZstdVariant(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifest models.Manifest, actx keppel.AuditContext) (GeneratedVariant, error) {
+	return p.generateVariant(ctx, account, repo, manifest, "zstd", p.convertLayerToZstd, actx)
+}
+
+// Converts a single layer for GenerateZstdVariant.
+// Implements variantLayerConverter.
+func (p *Processor) convertLayerToZstd(ctx context.Context, account models.ReducedAccount, repo models.Repository, layer imagespecs.Descriptor) (imagespecs.Descriptor, digest.Digest, error) {
+	switch layer.MediaType {
+	case imagespecs.MediaTypeImageLayerGzip, imageManifest.DockerV2Schema2LayerMediaType:
+		// recompress below
+	case imagespecs.MediaTypeImageLayer, imagespecs.MediaTypeImageLayerZstd:
+		// layers that are already zstd-compressed are kept as they are, and so are
+		// uncompressed layers since their author chose not to compress them
+		// (e.g. because they contain mostly compressed files)
+		return layer, "", nil
+	default:
+		return imagespecs.Descriptor{}, "", fmt.Errorf("unsupported layer media type %q", layer.MediaType)
+	}
+
+	blob, err := keppel.FindBlobByRepository(p.db, layer.Digest, repo)
+	if err != nil {
+		return imagespecs.Descriptor{}, "", err
+	}
+	blobReader, _, err := p.sd.ReadBlob(ctx, account, blob.StorageID)
+	if err != nil {
+		return imagespecs.Descriptor{}, "", err
+	}
+	defer blobReader.Close()
+	gzipReader, err := gzip.NewReader(blobReader)
+	if err != nil {
+		return imagespecs.Descriptor{}, "", err
+	}
+	defer gzipReader.Close()
+
+	// the recompression runs in the background while its output is being uploaded
+	pipeReader, pipeWriter := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		pipeWriter.CloseWithError(recompressWithZstd(gzipReader, pipeWriter))
+		close(done)
+	}()
+	newBlob, err := p.storeGeneratedBlob(ctx, account, repo, pipeReader)
+	pipeReader.CloseWithError(err) // if the upload failed early, this unblocks the recompression
+	<-done
+	if err != nil {
+		return imagespecs.Descriptor{}, "", err
+	}
+
+	return imagespecs.Descriptor{
+		MediaType:   imagespecs.MediaTypeImageLayerZstd,
+		Digest:      newBlob.Digest,
+		Size:        int64(newBlob.SizeBytes), //nolint:gosec // blob sizes are far below MaxInt64
+		Annotations: layer.Annotations,
+	}, "", nil
+}
+
+func recompressWithZstd(in io.Reader, out io.Writer) error {
+	// since this runs in the background, we can afford a better compression
+	// ratio than the default, but stay on a single core to not starve other jobs
+	zw, err := zstd.NewWriter(out,
+		zstd.WithEncoderLevel(zstd.SpeedBetterCompression),
+		zstd.WithEncoderConcurrency(1),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(zw, in)
+	closeErr := zw.Close()
+	if err != nil {
+		return fmt.Errorf("while recompressing: %w", err)
+	}
+	return closeErr
+}
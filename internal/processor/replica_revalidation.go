@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// RevalidateReplicaRepo compares the tags and manifests in the given repo in
+// a replica account with the upstream repository. All tags are checked for
+// whether they still point to the same manifest upstream, and all manifests
+// are downloaded from upstream again to check that they still exist there
+// with the same contents. Nothing is changed on our side.
+//
+// An error is returned if the account is not a replica, or if the upstream
+// registry cannot be queried.
+func (p *Processor) RevalidateReplicaRepo(ctx context.Context, account models.ReducedAccount, repo models.Repository) ([]keppel.ReplicaDivergence, error) {
+	c, err := p.getRepoClientForUpstream(ctx, account, repo)
+	if err != nil {
+		return nil, err
+	}
+	result := []keppel.ReplicaDivergence{}
+
+	var tags []models.Tag
+	_, err = p.db.Select(&tags, `SELECT * FROM tags WHERE repo_id = $1 ORDER BY name`, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		upstreamDigest, err := c.GetManifestDigest(ctx, models.ManifestReference{Tag: tag.Name})
+		switch {
+		case errorIsManifestNotFound(err):
+			result = append(result, keppel.ReplicaDivergence{
+				Kind:   keppel.TagDeletedDivergence,
+				Tag:    tag.Name,
+				Digest: tag.Digest,
+			})
+		case err != nil:
+			return nil, fmt.Errorf("while checking tag %s: %w", tag.Name, err)
+		case upstreamDigest != tag.Digest:
+			result = append(result, keppel.ReplicaDivergence{
+				Kind:           keppel.TagMovedDivergence,
+				Tag:            tag.Name,
+				Digest:         tag.Digest,
+				UpstreamDigest: upstreamDigest,
+			})
+		}
+	}
+
+	var manifests []models.Manifest
+	_, err = p.db.Select(&manifests, `SELECT * FROM manifests WHERE repo_id = $1 ORDER BY digest`, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, manifest := range manifests {
+		// this deliberately bypasses the inbound cache, since we want to know what upstream has right now
+		ref := models.ManifestReference{Digest: manifest.Digest}
+		contents, _, err := c.DownloadManifest(ctx, ref, &client.DownloadManifestOpts{DoNotCountTowardsLastPulled: true})
+		switch {
+		case errorIsManifestNotFound(err):
+			result = append(result, keppel.ReplicaDivergence{
+				Kind:   keppel.ManifestDeletedDivergence,
+				Digest: manifest.Digest,
+			})
+		case err != nil:
+			return nil, fmt.Errorf("while checking manifest %s: %w", manifest.Digest, err)
+		case manifest.Digest.Algorithm().FromBytes(contents) != manifest.Digest:
+			result = append(result, keppel.ReplicaDivergence{
+				Kind:   keppel.ManifestMismatchDivergence,
+				Digest: manifest.Digest,
+			})
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// checkReplicationNotPaused returns an error if replication from upstream has
+// been paused for this account because of too many failed replications.
+func checkReplicationNotPaused(account models.ReducedAccount) error {
+	if account.ReplicationPausedAt == nil {
+		return nil
+	}
+	msg := fmt.Sprintf("replication from upstream has been paused since %s because of too many failed replications",
+		account.ReplicationPausedAt.UTC().Format(time.RFC3339))
+	return keppel.ErrUnavailable.With(msg).WithStatus(http.StatusServiceUnavailable).
+		WithDetail(keppel.RegistryV2ErrorDetail{Reason: keppel.ReasonReplicationPaused})
+}
+
+func upstreamNameOf(account models.ReducedAccount) string {
+	if account.UpstreamPeerHostName != "" {
+		return account.UpstreamPeerHostName
+	}
+	return account.ExternalPeerURL
+}
+
+var recordReplicationOutcomeQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO replication_outcomes (account_name, bucket_start, successes, failures) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (account_name, bucket_start) DO UPDATE SET
+		successes = replication_outcomes.successes + EXCLUDED.successes,
+		failures = replication_outcomes.failures + EXCLUDED.failures
+`)
+
+// recordReplicationOutcome counts a replication from upstream towards the
+// account's error budget, and pauses replication for this account if the error
+// budget is exceeded.
+//
+// Errors are only logged because they should not affect the replication itself.
+func (p *Processor) recordReplicationOutcome(account models.ReducedAccount, failed bool, actx *keppel.AuditContext) {
+	outcome := "success"
+	successes, failures := 1, 0
+	if failed {
+		outcome = "failure"
+		successes, failures = 0, 1
+	}
+	InboundReplicationCounter.With(prometheus.Labels{
+		"account":        string(account.Name),
+		"auth_tenant_id": account.AuthTenantID,
+		"upstream":       upstreamNameOf(account),
+		"outcome":        outcome,
+	}).Inc()
+
+	now := p.timeNow()
+	budget := p.cfg.ReplicationErrorBudget
+	_, err := p.db.Exec(recordReplicationOutcomeQuery, account.Name, now.Truncate(time.Minute), successes, failures)
+	if err == nil {
+		_, err = p.db.Exec(`DELETE FROM replication_outcomes WHERE account_name = $1 AND bucket_start <= $2`,
+			account.Name, now.Add(-budget.Window))
+	}
+	if err != nil {
+		logg.Error("could not record replication outcome for account %s: %s", account.Name, err.Error())
+		return
+	}
+	if !failed || account.ReplicationPausedAt != nil {
+		return
+	}
+
+	// check whether the error budget is exhausted
+	health, err := keppel.FindReplicationHealth(p.db, account, budget, now)
+	if err != nil {
+		logg.Error("could not compute replication health for account %s: %s", account.Name, err.Error())
+		return
+	}
+	if !health.ExceedsBudget(budget) {
+		return
+	}
+	result, err := p.db.Exec(`UPDATE accounts SET replication_paused_at = $1 WHERE name = $2 AND replication_paused_at IS NULL`, now, account.Name)
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+	if err != nil {
+		logg.Error("could not pause replication for account %s: %s", account.Name, err.Error())
+		return
+	}
+	if rowsAffected == 0 {
+		return // someone else paused replication concurrently
+	}
+
+	health.PausedAt = keppel.MaybeTimeToUnix(&now)
+	logg.Info("pausing replication for account %s: %d out of %d replications from %s failed within the last %s",
+		account.Name, health.Failures, health.Successes+health.Failures, upstreamNameOf(account), budget.Window)
+	if actx != nil {
+		if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+			p.auditor.Record(audittools.Event{
+				Time:       now,
+				Request:    actx.Request,
+				User:       userInfo,
+				ReasonCode: http.StatusServiceUnavailable,
+				Action:     cadf.DisableAction,
+				Target:     AuditReplicationHealth{Account: account, Health: health},
+			})
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"context"
+	"maps"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// findPredecessorWithSameLayers is used by ReplicateManifest when a tag is
+// replicated. If the tag currently points to a manifest that references
+// exactly the same set of layers as the given new manifest, the digests of
+// those layers are returned.
+//
+// This is the common case when upstream only changed annotations or labels:
+// The manifest gets a new digest (and for labels, also a new config blob),
+// but all layers stay the same. Since the layers are already referenced by a
+// manifest in this repo, they do not need to be replicated again.
+func (p *Processor) findPredecessorWithSameLayers(ctx context.Context, repo models.Repository, reference models.ManifestReference, manifestParsed keppel.ParsedManifest) (map[digest.Digest]bool, error) {
+	if !reference.IsTag() {
+		return nil, nil
+	}
+	newLayers := layerDigestsOf(manifestParsed)
+	if len(newLayers) == 0 {
+		// image lists do not have layers, and their submanifests are replicated
+		// separately anyway
+		return nil, nil
+	}
+
+	digestStr, err := p.db.SelectStr(`SELECT digest FROM tags WHERE repo_id = $1 AND name = $2`, repo.ID, reference.Tag)
+	if err != nil || digestStr == "" {
+		return nil, err
+	}
+	predecessor, err := keppel.FindManifest(p.db, repo, digest.Digest(digestStr))
+	if err != nil {
+		return nil, err
+	}
+	predecessorBytes, err := keppel.ReadManifestContent(ctx, p.db, p.secd, repo.ID, predecessor.Digest)
+	if err != nil {
+		return nil, err
+	}
+	predecessorParsed, err := keppel.ParseManifest(predecessor.MediaType, predecessorBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if !maps.Equal(newLayers, layerDigestsOf(predecessorParsed)) {
+		return nil, nil
+	}
+	return newLayers, nil
+}
+
+// Returns the digests of all blobs referenced by this manifest, except for the image config.
+func layerDigestsOf(manifestParsed keppel.ParsedManifest) map[digest.Digest]bool {
+	var configDigest digest.Digest
+	if configBlobDesc := manifestParsed.FindImageConfigBlob(); configBlobDesc != nil {
+		configDigest = configBlobDesc.Digest
+	}
+	result := make(map[digest.Digest]bool)
+	for _, layerInfo := range manifestParsed.BlobReferences() {
+		if layerInfo.Digest != configDigest {
+			result[layerInfo.Digest] = true
+		}
+	}
+	return result
+}
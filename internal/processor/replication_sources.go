@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// Finds the peer holding the primary account ($1), as well as all peers that
+// are nearer than it (i.e. have a lower link cost) and that are healthy (i.e.
+// their circuit breaker is not open). The peer holding the primary account is
+// always sorted last.
+var replicationSourcesQuery = sqlext.SimplifyWhitespace(`
+	SELECT p.* FROM peers p
+	 WHERE p.hostname = $1 OR (
+	         p.use_for_replication AND p.our_password != ''
+	         AND p.link_cost < (SELECT link_cost FROM peers WHERE hostname = $1)
+	         AND NOT EXISTS (SELECT 1 FROM upstream_circuit_breakers cb WHERE cb.hostname = p.hostname AND cb.open_until > $2)
+	       )
+	 ORDER BY p.hostname = $1, p.link_cost, p.hostname
+`)
+
+// Since the replication sources are needed for every blob and manifest that
+// is replicated, they are cached for a short time instead of being queried
+// every time. The peer mesh topology only changes when keppel-api restarts
+// with a different KEPPEL_PEERS, and peers whose circuit breaker opens in the
+// meantime are still rejected by the circuit breaker of their RepoClient.
+const replicationSourcesCacheTTL = 1 * time.Minute
+
+// How long we wait for nearer replication sources to tell us whether they
+// have the object in question before falling back to the upstream.
+const replicationSourceProbeTimeout = 5 * time.Second
+
+type replicationSourcesCacheKey struct {
+	DB               *keppel.DB
+	UpstreamHostName string
+}
+
+type replicationSourcesCacheEntry struct {
+	Sources   []models.Peer
+	ExpiresAt time.Time
+}
+
+var (
+	replicationSourcesCacheMutex sync.Mutex
+	replicationSourcesCache      = make(map[replicationSourcesCacheKey]replicationSourcesCacheEntry)
+)
+
+// Returns the result of replicationSourcesQuery, from the cache if possible.
+func (p *Processor) getReplicationSources(upstreamHostName string) ([]models.Peer, error) {
+	key := replicationSourcesCacheKey{p.db, upstreamHostName}
+	now := p.timeNow()
+
+	replicationSourcesCacheMutex.Lock()
+	entry, ok := replicationSourcesCache[key]
+	replicationSourcesCacheMutex.Unlock()
+	if ok && entry.ExpiresAt.After(now) {
+		return entry.Sources, nil
+	}
+
+	var sources []models.Peer
+	_, err := p.db.Select(&sources, replicationSourcesQuery, upstreamHostName, now)
+	if err != nil {
+		return nil, err
+	}
+
+	replicationSourcesCacheMutex.Lock()
+	defer replicationSourcesCacheMutex.Unlock()
+	replicationSourcesCache[key] = replicationSourcesCacheEntry{
+		Sources:   sources,
+		ExpiresAt: now.Add(replicationSourcesCacheTTL),
+	}
+	return sources, nil
+}
+
+// replicateFromNearestSource is used when replicating objects into a replica
+// account. Content-addressed objects (i.e. blobs and manifests referenced by
+// digest) are the same everywhere, so internal replica accounts can replicate
+// them from any peer that has them, not just from the peer holding the primary
+// account. Manifests referenced by tag can only be replicated from the primary
+// account since tags in other replicas might be outdated.
+//
+// For content-addressed objects, `probe` must be given. It is used to ask all
+// nearer replication sources at once whether they have the object. The given
+// action is then executed with a RepoClient for the nearest source that has
+// the object. If no nearer source has the object (or none exists), or if the
+// action fails on it, the action is executed with the given client for the
+// upstream.
+//
+// Peers only serve objects to us that they have stored themselves instead of
+// replicating them on our behalf (see handleGetOrHeadBlob in package
+// registryv2), so a replication never bounces between peers.
+func (p *Processor) replicateFromNearestSource(ctx context.Context, account models.ReducedAccount, repo models.Repository, objectType string, probe func(context.Context, *client.RepoClient) (bool, error), upstream *client.RepoClient, action func(*client.RepoClient) error) error {
+	var sources []models.Peer
+	if account.UpstreamPeerHostName != "" {
+		var err error
+		sources, err = p.getReplicationSources(account.UpstreamPeerHostName)
+		if err != nil {
+			// not fatal: we can always fall back to the upstream
+			logg.Error("cannot find replication sources for account %s: %s", account.Name, err.Error())
+			sources = nil
+		}
+	}
+
+	upstreamRegion := ""
+	var candidates []models.Peer
+	for _, peer := range sources {
+		if peer.HostName == account.UpstreamPeerHostName {
+			upstreamRegion = peer.Region
+		} else if probe != nil {
+			candidates = append(candidates, peer)
+		}
+	}
+
+	for _, c := range p.probeReplicationSources(ctx, repo, objectType, candidates, probe) {
+		err := action(c)
+		if err == nil {
+			countReplicationSource(account, c.Host, regionOfPeer(candidates, c.Host), objectType)
+			return nil
+		}
+		logg.Info("cannot replicate %s in %s from peer %s, will try the next replication source: %s",
+			objectType, repo.FullName(), c.Host, err.Error())
+	}
+
+	err := action(upstream)
+	if err == nil {
+		countReplicationSource(account, upstream.Host, upstreamRegion, objectType)
+	}
+	return err
+}
+
+// Probes all candidates concurrently, and returns RepoClients for those
+// candidates that have the object, in the same order as the candidates.
+func (p *Processor) probeReplicationSources(ctx context.Context, repo models.Repository, objectType string, candidates []models.Peer, probe func(context.Context, *client.RepoClient) (bool, error)) []*client.RepoClient {
+	if len(candidates) == 0 {
+		return nil
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, replicationSourceProbeTimeout)
+	defer cancel()
+
+	clients := make([]*client.RepoClient, len(candidates))
+	var wg sync.WaitGroup
+	for idx, peer := range candidates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// no retries since we can fall back to the upstream right away
+			c := p.repoClientForPeer(peer, repo)
+			c.RetryPolicy = nil
+			hasObject, err := probe(probeCtx, c)
+			if err != nil {
+				logg.Info("cannot check whether peer %s has %s in %s: %s", peer.HostName, objectType, repo.FullName(), err.Error())
+				return
+			}
+			if hasObject {
+				clients[idx] = c
+			}
+		}()
+	}
+	wg.Wait()
+
+	var result []*client.RepoClient
+	for _, c := range clients {
+		if c != nil {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func regionOfPeer(peers []models.Peer, hostName string) string {
+	for _, peer := range peers {
+		if peer.HostName == hostName {
+			return peer.Region
+		}
+	}
+	return ""
+}
+
+func countReplicationSource(account models.ReducedAccount, source, sourceRegion, objectType string) {
+	InboundReplicationSourceCounter.With(prometheus.Labels{
+		"account":        string(account.Name),
+		"auth_tenant_id": account.AuthTenantID,
+		"source":         source,
+		"source_region":  sourceRegion,
+		"type":           objectType,
+	}).Inc()
+}
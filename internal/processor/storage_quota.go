@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"errors"
+
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// RecordStorageQuotaError shall be called with errors returned by writes into
+// the storage backend. If the error indicates that the storage backend ran
+// out of quota (see keppel.ErrStorageQuotaExceeded), the account is marked as
+// degraded and the account's webhooks are notified, unless this already
+// happened since the last successful push.
+//
+// Errors are only logged because they should not obscure the original error.
+func (p *Processor) RecordStorageQuotaError(account models.ReducedAccount, storageErr error) {
+	if !errors.Is(storageErr, keppel.ErrStorageQuotaExceeded) || account.StorageQuotaExceededAt != nil {
+		return
+	}
+
+	now := p.timeNow()
+	result, err := p.db.Exec(`UPDATE accounts SET storage_quota_exceeded_at = $1 WHERE name = $2 AND storage_quota_exceeded_at IS NULL`, now, account.Name)
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+	if err != nil {
+		logg.Error("could not mark account %s as having exceeded the storage quota: %s", account.Name, err.Error())
+		return
+	}
+	if rowsAffected == 0 {
+		return // someone else noticed this concurrently
+	}
+
+	logg.Info("storage backend rejected write into account %s because of quota: %s", account.Name, storageErr.Error())
+	err = keppel.EnqueueWebhookNotification(p.db, keppel.WebhookNotification{
+		Event:     keppel.WebhookEventStorageQuotaExceeded,
+		Account:   account.Name,
+		Message:   storageErr.Error(),
+		Timestamp: now.Unix(),
+	}, now)
+	if err != nil {
+		logg.Error("cannot enqueue %s webhook notification for account %s: %s",
+			keppel.WebhookEventStorageQuotaExceeded, account.Name, err.Error())
+	}
+}
+
+// Clears the mark left by RecordStorageQuotaError() after a successful push.
+func (p *Processor) clearStorageQuotaError(account models.ReducedAccount) {
+	if account.StorageQuotaExceededAt == nil {
+		return
+	}
+	_, err := p.db.Exec(`UPDATE accounts SET storage_quota_exceeded_at = NULL WHERE name = $1`, account.Name)
+	if err != nil {
+		logg.Error("could not clear storage quota issue on account %s: %s", account.Name, err.Error())
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/must"
+	"github.com/sapcc/go-bits/regexpext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ListTagsOnPrimary lists the tags matching the given pattern on the
+// account's upstream registry, and finds the digests that they point to. The
+// first return value identifies the upstream repository for display purposes.
+// An error is returned if the account is not a replica, or if the upstream
+// registry cannot be queried.
+func (p *Processor) ListTagsOnPrimary(ctx context.Context, account models.ReducedAccount, repo models.Repository, tagPattern regexpext.BoundedRegexp) (upstream string, tags map[string]digest.Digest, err error) {
+	c, err := p.getRepoClientForUpstream(ctx, account, repo)
+	if err != nil {
+		return "", nil, err
+	}
+	upstream = fmt.Sprintf("%s/%s", c.Host, c.RepoName)
+
+	tagNames, err := c.ListTags(ctx)
+	if err != nil {
+		if errorIsManifestNotFound(err) {
+			// upstream repo does not exist (yet), so there are no tags
+			return upstream, map[string]digest.Digest{}, nil
+		}
+		return upstream, nil, err
+	}
+
+	tags = make(map[string]digest.Digest)
+	for _, tagName := range tagNames {
+		if !tagPattern.MatchString(tagName) {
+			continue
+		}
+		tagDigest, err := c.GetManifestDigest(ctx, models.ManifestReference{Tag: tagName})
+		if err != nil {
+			if errorIsManifestNotFound(err) {
+				// tag was deleted between listing and inspecting it
+				continue
+			}
+			return upstream, nil, fmt.Errorf("while inspecting tag %s: %w", tagName, err)
+		}
+		tags[tagName] = tagDigest
+	}
+	return upstream, tags, nil
+}
+
+// RecordTagWatchChanges records an audit event for changes that a tag watch
+// observed upstream.
+func (p *Processor) RecordTagWatchChanges(account models.ReducedAccount, watch models.TagWatch, notification keppel.TagWatchNotification, actx keppel.AuditContext) {
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		p.auditor.Record(audittools.Event{
+			Time:       p.timeNow(),
+			Request:    actx.Request,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     cadf.UpdateAction,
+			Target: auditTagWatchChanges{
+				Account:      account,
+				Watch:        watch,
+				Notification: notification,
+			},
+		})
+	}
+}
+
+// auditTagWatchChanges is an audittools.Target.
+type auditTagWatchChanges struct {
+	Account      models.ReducedAccount
+	Watch        models.TagWatch
+	Notification keppel.TagWatchNotification
+}
+
+// Render implements the audittools.Target interface.
+func (a auditTagWatchChanges) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI:   "docker-registry/account/repository/tag-watch",
+		Name:      fmt.Sprintf("%s/%s", a.Account.Name, a.Watch.RepoName),
+		ID:        strconv.FormatInt(a.Watch.ID, 10),
+		ProjectID: a.Account.AuthTenantID,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("changes", a.Notification.Changes)),
+		},
+	}
+}
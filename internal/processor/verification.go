@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package processor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var verifyFindChildManifestsQuery = sqlext.SimplifyWhitespace(`
+	SELECT child_digest FROM manifest_manifest_refs WHERE repo_id = $1 AND parent_digest = $2
+`)
+
+var verifyFindBlobsQuery = sqlext.SimplifyWhitespace(`
+	SELECT b.* FROM blobs b
+	  JOIN manifest_blob_refs r ON b.id = r.blob_id
+	 WHERE r.repo_id = $1 AND r.digest = $2
+`)
+
+var verifyIsBlobVerifiedQuery = sqlext.SimplifyWhitespace(`
+	SELECT EXISTS(
+		SELECT 1 FROM manifest_blob_refs r
+		  JOIN manifests m ON m.repo_id = r.repo_id AND m.digest = r.digest
+		 WHERE r.repo_id = $1 AND r.blob_id = $2 AND m.verified_at IS NOT NULL
+	)
+`)
+
+var verifyMarkManifestQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET verified_at = $3, next_verification_at = NULL, verification_error_message = ''
+	 WHERE repo_id = $1 AND digest = $2
+`)
+
+// VerifyManifestChain is used in replica accounts with ExternalPeerVerifyOnly
+// (see tasks.ManifestVerificationJob). It checks that the contents of the
+// given manifest, of all its submanifests and of all blobs referenced by any
+// of them match their respective digests. Blobs that have not been replicated
+// yet are replicated in the process. Blobs that are referenced by a different
+// verified manifest in the same repo (e.g. because upstream only changed the
+// labels on a tagged image) have been verified already and are skipped.
+//
+// On success, the manifest's VerifiedAt attribute is set (both in the given
+// object and in the DB) to attest that the manifest can be served. If any
+// verification fails, an error is returned and no attestation is recorded.
+func (p *Processor) VerifyManifestChain(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifest *models.Manifest) error {
+	if manifest.VerifiedAt != nil {
+		return nil
+	}
+
+	// the manifest digest is computed from the manifest contents when the
+	// manifest is stored, but it does not hurt to double-check what we serve
+	manifestBytes, err := keppel.ReadManifestFromStorage(ctx, p.sd, p.secd, account, repo.Name, manifest.Digest)
+	if err != nil {
+		return err
+	}
+	actualDigest := manifest.Digest.Algorithm().FromBytes(manifestBytes)
+	if actualDigest != manifest.Digest {
+		msg := fmt.Sprintf("expected manifest digest %s, but got %s", manifest.Digest, actualDigest)
+		return keppel.ErrManifestInvalid.With(msg)
+	}
+
+	// verify submanifests recursively
+	var childDigests []digest.Digest
+	err = sqlext.ForeachRow(p.db, verifyFindChildManifestsQuery, []any{repo.ID, manifest.Digest}, func(rows *sql.Rows) error {
+		var childDigest digest.Digest
+		err := rows.Scan(&childDigest)
+		childDigests = append(childDigests, childDigest)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, childDigest := range childDigests {
+		childManifest, err := keppel.FindManifest(p.db, repo, childDigest)
+		if err != nil {
+			return err
+		}
+		err = p.VerifyManifestChain(ctx, account, repo, childManifest)
+		if err != nil {
+			return err
+		}
+	}
+
+	// verify blobs (blobs that still need to be replicated are verified by ReplicateBlob)
+	var blobs []models.Blob
+	_, err = p.db.Select(&blobs, verifyFindBlobsQuery, repo.ID, manifest.Digest)
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		if blob.StorageID == "" {
+			_, err = p.ReplicateBlob(ctx, blob, account, repo, nil)
+		} else {
+			var isVerified bool
+			isVerified, err = p.db.SelectBool(verifyIsBlobVerifiedQuery, repo.ID, blob.ID)
+			if err == nil && !isVerified {
+				err = p.ValidateExistingBlob(ctx, account, blob)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("while verifying blob %s: %w", blob.Digest, err)
+		}
+	}
+
+	now := p.timeNow()
+	_, err = p.db.Exec(verifyMarkManifestQuery, repo.ID, manifest.Digest, now)
+	if err != nil {
+		return err
+	}
+	manifest.VerifiedAt = &now
+	return nil
+}
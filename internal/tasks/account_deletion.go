@@ -179,6 +179,10 @@ func (j *Janitor) deleteMarkedAccount(ctx context.Context, accountName models.Ac
 	if err != nil {
 		return err
 	}
+	err = keppel.NotifyAccountChanged(tx, accountModel.Name)
+	if err != nil {
+		return err
+	}
 
 	// before committing the transaction, confirm account deletion with the
 	// storage driver and the federation driver
@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+var backgroundMigrationRemainingRowsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "keppel_background_migration_remaining_rows",
+		Help: "Number of rows that still need to be processed by a background migration.",
+	},
+	[]string{"migration"},
+)
+
+func init() {
+	prometheus.MustRegister(backgroundMigrationRemainingRowsGauge)
+}
+
+var backgroundMigrationProgressQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO background_migrations (name, processed_rows, started_at, finished_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (name) DO UPDATE SET
+		processed_rows = background_migrations.processed_rows + EXCLUDED.processed_rows,
+		finished_at = EXCLUDED.finished_at
+`)
+
+// BackgroundMigrationJob is a job. Each task processes one batch of the first
+// unfinished migration in keppel.BackgroundMigrations.
+func (j *Janitor) BackgroundMigrationJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[keppel.BackgroundMigration]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "batch of background migration",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_background_migration_batches",
+				Help: "Counter for batches processed by background migrations.",
+			},
+		},
+		DiscoverTask: j.discoverBackgroundMigration,
+		ProcessTask:  j.processBackgroundMigrationBatch,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) discoverBackgroundMigration(_ context.Context, _ prometheus.Labels) (keppel.BackgroundMigration, error) {
+	var finished []string
+	_, err := j.db.Select(&finished, `SELECT name FROM background_migrations WHERE finished_at IS NOT NULL`)
+	if err != nil {
+		return keppel.BackgroundMigration{}, err
+	}
+	isFinished := make(map[string]bool, len(finished))
+	for _, name := range finished {
+		isFinished[name] = true
+	}
+
+	for _, migration := range keppel.BackgroundMigrations {
+		if !isFinished[migration.Name] {
+			return migration, nil
+		}
+	}
+	return keppel.BackgroundMigration{}, sql.ErrNoRows
+}
+
+func (j *Janitor) processBackgroundMigrationBatch(_ context.Context, migration keppel.BackgroundMigration, _ prometheus.Labels) error {
+	batchSize := migration.BatchSize
+	if batchSize == 0 {
+		batchSize = keppel.DefaultBackgroundMigrationBatchSize
+	}
+
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	// do not queue up behind long-running transactions, since that would block
+	// all other writers on the affected table as well
+	_, err = tx.Exec(`SET LOCAL lock_timeout = '5s'`)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(migration.BatchQuery, batchSize)
+	if err != nil {
+		return fmt.Errorf("while processing batch of background migration %q: %w", migration.Name, err)
+	}
+	processedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	var finishedAt any
+	if processedRows == 0 {
+		finishedAt = j.timeNow()
+	}
+	_, err = tx.Exec(backgroundMigrationProgressQuery, migration.Name, processedRows, j.timeNow(), finishedAt)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	if processedRows == 0 {
+		logg.Info("background migration %q is finished", migration.Name)
+	}
+
+	remainingRows, err := migration.CountRemainingRows(j.db)
+	if err != nil {
+		return err
+	}
+	backgroundMigrationRemainingRowsGauge.WithLabelValues(migration.Name).Set(float64(remainingRows))
+	return nil
+}
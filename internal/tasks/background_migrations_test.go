@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestBackgroundMigrationJob(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	job := j.BackgroundMigrationJob(s.Registry)
+
+	// without any background migrations, there is nothing to do
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+
+	// setup a dummy migration that backfills a column in the repos table
+	// (there are three repos in total, including the default repo "foo")
+	for _, name := range []string{"bar", "baz"} {
+		mustDo(t, s.DB.Insert(&models.Repository{AccountName: "test1", Name: name}))
+	}
+	keppel.BackgroundMigrations = []keppel.BackgroundMigration{{
+		Name:           "backfill_repos_next_gc_at",
+		BatchQuery:     `UPDATE repos SET next_gc_at = '2000-01-01' WHERE id IN (SELECT id FROM repos WHERE next_gc_at IS NULL ORDER BY id LIMIT $1)`,
+		RemainingQuery: `SELECT COUNT(*) FROM repos WHERE next_gc_at IS NULL`,
+		BatchSize:      2,
+	}}
+	defer func() { keppel.BackgroundMigrations = nil }()
+
+	status, err := keppel.GetBackgroundMigrationStatus(s.DB)
+	mustDo(t, err)
+	assert.DeepEqual(t, "status before start", status, []models.BackgroundMigration{{Name: "backfill_repos_next_gc_at"}})
+
+	// first batch processes two rows
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	remainingRows, err := keppel.BackgroundMigrations[0].CountRemainingRows(s.DB)
+	mustDo(t, err)
+	assert.DeepEqual(t, "remaining rows", remainingRows, int64(1))
+
+	// second batch processes the last row, third batch finds nothing to do
+	// and marks the migration as finished
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	status, err = keppel.GetBackgroundMigrationStatus(s.DB)
+	mustDo(t, err)
+	finishedAt := s.Clock.Now()
+	assert.DeepEqual(t, "status after finish", status, []models.BackgroundMigration{{
+		Name:          "backfill_repos_next_gc_at",
+		ProcessedRows: 3,
+		StartedAt:     s.Clock.Now(),
+		FinishedAt:    &finishedAt,
+	}})
+
+	// finished migrations are not picked up again
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+}
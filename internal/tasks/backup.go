@@ -0,0 +1,540 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var blobBackupSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM blobs WHERE backed_up_at IS NULL AND storage_id != ''
+	AND (next_backup_at IS NULL OR next_backup_at < $1)
+	-- blobs whose backup failed before are retried after all others
+	ORDER BY next_backup_at ASC NULLS FIRST, id ASC
+	LIMIT 1 -- one at a time
+`)
+
+var blobBackupFinishQuery = sqlext.SimplifyWhitespace(`
+	UPDATE blobs SET backed_up_at = $1, next_backup_at = NULL, backup_error_message = '' WHERE id = $2
+`)
+
+var blobBackupFailedQuery = sqlext.SimplifyWhitespace(`
+	UPDATE blobs SET next_backup_at = $1, backup_error_message = $2 WHERE id = $3
+`)
+
+// BlobBackupJob is a job. Each task copies the contents of a blob that has not
+// been backed up yet into the BackupDriver. Contents that are already present
+// in the backup (e.g. because the same blob exists in a different account)
+// are not written again. Failed backups are retried after
+// models.BlobBackupAfterErrorInterval, so that they do not block the backup
+// of other blobs.
+//
+//nolint:dupl
+func (j *Janitor) BlobBackupJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.Blob]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "backup of blob contents",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_blob_backups",
+				Help: "Counter for blob backups.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (blob models.Blob, err error) {
+			err = j.db.SelectOne(&blob, blobBackupSearchQuery, j.timeNow())
+			return blob, err
+		},
+		ProcessTask: j.backupBlob,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) backupBlob(ctx context.Context, blob models.Blob, _ prometheus.Labels) error {
+	err := j.doBackupBlob(ctx, blob)
+	if err != nil {
+		_, updateErr := j.db.Exec(blobBackupFailedQuery,
+			j.timeNow().Add(j.addJitter(models.BlobBackupAfterErrorInterval)),
+			err.Error(), blob.ID,
+		)
+		if updateErr != nil {
+			err = fmt.Errorf("%w (additional error encountered while recording backup error: %s)", err, updateErr.Error())
+		}
+		return err
+	}
+
+	_, err = j.db.Exec(blobBackupFinishQuery, j.timeNow(), blob.ID)
+	return err
+}
+
+func (j *Janitor) doBackupBlob(ctx context.Context, blob models.Blob) error {
+	exists, err := j.bd.HasContent(ctx, blob.Digest)
+	if err != nil {
+		return fmt.Errorf("cannot check backup for blob %s: %w", blob.Digest, err)
+	}
+	if !exists {
+		account, err := keppel.FindReducedAccount(j.db, blob.AccountName)
+		if err != nil {
+			return fmt.Errorf("cannot find account for blob %s/%s: %w", blob.AccountName, blob.Digest, err)
+		}
+		reader, sizeBytes, err := j.sd.ReadBlob(ctx, *account, blob.StorageID)
+		if err != nil {
+			return fmt.Errorf("cannot read blob %s/%s: %w", blob.AccountName, blob.Digest, err)
+		}
+		defer reader.Close()
+		err = j.bd.WriteContent(ctx, blob.Digest, sizeBytes, reader)
+		if err != nil {
+			return fmt.Errorf("cannot back up blob %s/%s: %w", blob.AccountName, blob.Digest, err)
+		}
+	}
+	return nil
+}
+
+var manifestBackupSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM manifests WHERE backed_up_at IS NULL
+	AND (next_backup_at IS NULL OR next_backup_at < $1)
+	-- manifests whose backup failed before are retried after all others
+	ORDER BY next_backup_at ASC NULLS FIRST, pushed_at ASC
+	LIMIT 1 -- one at a time
+`)
+
+var manifestBackupFinishQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET backed_up_at = $1, next_backup_at = NULL, backup_error_message = '' WHERE repo_id = $2 AND digest = $3
+`)
+
+var manifestBackupFailedQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET next_backup_at = $1, backup_error_message = $2 WHERE repo_id = $3 AND digest = $4
+`)
+
+// ManifestBackupJob is a job. Each task copies the contents of a manifest
+// that has not been backed up yet into the BackupDriver. Like for
+// BlobBackupJob, contents are deduplicated by digest, and failed backups are
+// retried after models.ManifestBackupAfterErrorInterval.
+//
+//nolint:dupl
+func (j *Janitor) ManifestBackupJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.Manifest]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "backup of manifest contents",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_manifest_backups",
+				Help: "Counter for manifest backups.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (manifest models.Manifest, err error) {
+			err = j.db.SelectOne(&manifest, manifestBackupSearchQuery, j.timeNow())
+			return manifest, err
+		},
+		ProcessTask: j.backupManifest,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) backupManifest(ctx context.Context, manifest models.Manifest, _ prometheus.Labels) error {
+	err := j.doBackupManifest(ctx, manifest)
+	if err != nil {
+		_, updateErr := j.db.Exec(manifestBackupFailedQuery,
+			j.timeNow().Add(j.addJitter(models.ManifestBackupAfterErrorInterval)),
+			err.Error(), manifest.RepositoryID, manifest.Digest,
+		)
+		if updateErr != nil {
+			err = fmt.Errorf("%w (additional error encountered while recording backup error: %s)", err, updateErr.Error())
+		}
+		return err
+	}
+
+	_, err = j.db.Exec(manifestBackupFinishQuery, j.timeNow(), manifest.RepositoryID, manifest.Digest)
+	return err
+}
+
+var manifestBackupContentQuery = sqlext.SimplifyWhitespace(`
+	SELECT mc.content, mc.encryption_key_ref, r.account_name, r.name, a.auth_tenant_id, a.content_encryption_key_ref
+	  FROM manifest_contents mc
+	  JOIN repos r ON r.id = mc.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	 WHERE mc.repo_id = $1 AND mc.digest = $2
+`)
+
+func (j *Janitor) doBackupManifest(ctx context.Context, manifest models.Manifest) error {
+	var (
+		content       []byte
+		contentKeyRef string
+		accountName   models.AccountName
+		repoName      string
+		authTenantID  string
+		accountKeyRef string
+	)
+	err := j.db.QueryRow(manifestBackupContentQuery, manifest.RepositoryID, manifest.Digest).
+		Scan(&content, &contentKeyRef, &accountName, &repoName, &authTenantID, &accountKeyRef)
+	if err != nil {
+		return fmt.Errorf("cannot read manifest %s in repo %d: %w", manifest.Digest, manifest.RepositoryID, err)
+	}
+
+	if accountKeyRef == "" {
+		// plaintext contents are deduplicated across accounts
+		exists, err := j.bd.HasContent(ctx, manifest.Digest)
+		if err != nil {
+			return fmt.Errorf("cannot check backup for manifest %s: %w", manifest.Digest, err)
+		}
+		if exists {
+			return nil
+		}
+		// contents may still be encrypted if the account's key was removed recently
+		plaintext, err := keppel.DecryptManifestContent(ctx, j.secd, authTenantID, contentKeyRef, manifest.Digest, content)
+		if err != nil {
+			return fmt.Errorf("cannot read manifest %s in repo %d: %w", manifest.Digest, manifest.RepositoryID, err)
+		}
+		err = j.bd.WriteContent(ctx, manifest.Digest, uint64(len(plaintext)), bytes.NewReader(plaintext))
+		if err != nil {
+			return fmt.Errorf("cannot back up manifest %s in repo %d: %w", manifest.Digest, manifest.RepositoryID, err)
+		}
+		return nil
+	}
+
+	// in accounts with a content encryption key, the backup contains the
+	// ciphertext (in the same format as the copy in the storage backend), and is
+	// stored per account since the same digest has a different ciphertext in each account
+	var stored []byte
+	if contentKeyRef == accountKeyRef {
+		stored = keppel.WrapEncryptedManifestContent(contentKeyRef, content)
+	} else {
+		// contents have not been re-encrypted since the account's key was changed
+		plaintext, err := keppel.DecryptManifestContent(ctx, j.secd, authTenantID, contentKeyRef, manifest.Digest, content)
+		if err == nil {
+			stored, err = keppel.EncodeManifestForStorage(ctx, j.secd, authTenantID, accountKeyRef, manifest.Digest, plaintext)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot encrypt manifest %s in repo %d: %w", manifest.Digest, manifest.RepositoryID, err)
+		}
+	}
+	err = j.bd.WriteAccountContent(ctx, accountName, repoName, manifest.Digest, uint64(len(stored)), bytes.NewReader(stored))
+	if err != nil {
+		return fmt.Errorf("cannot back up manifest %s in repo %d: %w", manifest.Digest, manifest.RepositoryID, err)
+	}
+	return nil
+}
+
+// BackupSnapshotJob is a job. Each task writes a snapshot of the DB metadata
+// listed in keppel.BackupSnapshotTables into the BackupDriver.
+func (j *Janitor) BackupSnapshotJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "backup of DB metadata snapshots",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_backup_snapshots",
+				Help: "Counter for metadata snapshots written into the backup.",
+			},
+		},
+		Interval:     j.cfg.BackupSnapshotInterval,
+		InitialDelay: j.cfg.BackupSnapshotInterval / 10,
+		Task:         j.writeBackupSnapshot,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) writeBackupSnapshot(ctx context.Context, _ prometheus.Labels) (returnErr error) {
+	now := j.timeNow()
+
+	// the snapshot is spooled into a temporary file instead of being held in
+	// memory, since the BackupDriver needs to know its size before uploading
+	file, err := os.CreateTemp("", "keppel-backup-snapshot-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+		err := os.Remove(file.Name())
+		if err != nil && returnErr == nil {
+			returnErr = err
+		}
+	}()
+
+	// read all tables within one transaction to get a consistent view
+	tx, err := j.db.Db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	var schemaVersion int64
+	err = tx.QueryRowContext(ctx, `SELECT version FROM schema_migrations`).Scan(&schemaVersion)
+	if err != nil {
+		return fmt.Errorf("cannot read schema version: %w", err)
+	}
+	sw, err := keppel.NewBackupSnapshotWriter(file, now, schemaVersion)
+	if err != nil {
+		return err
+	}
+	for _, table := range keppel.BackupSnapshotTables {
+		err := sw.BeginTable(table)
+		if err != nil {
+			return err
+		}
+		query := fmt.Sprintf(`SELECT row_to_json(t) FROM %s t`, table) //nolint:gosec // table names are not user-supplied
+		err = sqlext.ForeachRow(tx, query, nil, func(r *sql.Rows) error {
+			var row []byte
+			err := r.Scan(&row)
+			if err != nil {
+				return err
+			}
+			return sw.WriteRow(json.RawMessage(row))
+		})
+		if err != nil {
+			return fmt.Errorf("cannot read table %s: %w", table, err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	err = sw.Close()
+	if err != nil {
+		return err
+	}
+
+	sizeBytes, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return j.bd.WriteSnapshot(ctx, keppel.BackupSnapshotName(now), uint64(sizeBytes), file) //nolint:gosec // file size is never negative
+}
+
+// RestoreBackup restores the given snapshot (or the latest snapshot, if
+// `snapshotName` is empty) from the BackupDriver into an empty database, and
+// then restores all blob and manifest contents referenced by it into the
+// StorageDriver. Encrypted manifest backups are restored as they are, so the
+// respective content encryption keys do not need to be available. Plaintext
+// manifest backups are encrypted again if the respective account has a content
+// encryption key.
+func RestoreBackup(ctx context.Context, db *keppel.DB, sd keppel.StorageDriver, bd keppel.BackupDriver, secd keppel.SecretsDriver, snapshotName string) error {
+	if snapshotName == "" {
+		names, err := bd.ListSnapshots(ctx)
+		if err != nil {
+			return fmt.Errorf("cannot list snapshots: %w", err)
+		}
+		if len(names) == 0 {
+			return errors.New("no snapshots found in backup")
+		}
+		snapshotName = names[len(names)-1]
+	}
+	logg.Info("restoring backup snapshot %s...", snapshotName)
+
+	buf, err := bd.ReadSnapshot(ctx, snapshotName)
+	if err != nil {
+		return fmt.Errorf("cannot read snapshot %s: %w", snapshotName, err)
+	}
+	snapshot, err := keppel.DecodeBackupSnapshot(buf)
+	if err != nil {
+		return err
+	}
+
+	err = restoreBackupSnapshot(db, snapshot)
+	if err != nil {
+		return err
+	}
+
+	// restore contents
+	missingCount := 0
+	err = restoreBlobContents(ctx, db, sd, bd, &missingCount)
+	if err != nil {
+		return err
+	}
+	err = restoreManifestContents(ctx, db, sd, bd, secd, &missingCount)
+	if err != nil {
+		return err
+	}
+	if missingCount > 0 {
+		return fmt.Errorf("restored metadata from snapshot %s, but %d blobs or manifests were missing from the backup", snapshotName, missingCount)
+	}
+	logg.Info("restored backup snapshot %s successfully", snapshotName)
+	return nil
+}
+
+// Tables with a BIGSERIAL column whose sequence needs to be reset after restoring.
+var backupTablesWithSerialID = []string{"repos", "blobs", "account_requests", "account_snapshots"}
+
+func restoreBackupSnapshot(db *keppel.DB, snapshot keppel.BackupSnapshot) error {
+	var schemaVersion int64
+	err := db.QueryRow(`SELECT version FROM schema_migrations`).Scan(&schemaVersion)
+	if err != nil {
+		return fmt.Errorf("cannot read schema version: %w", err)
+	}
+	if schemaVersion != snapshot.SchemaVersion {
+		return fmt.Errorf("snapshot has schema version %d, but database has schema version %d", snapshot.SchemaVersion, schemaVersion)
+	}
+	accountCount, err := db.SelectInt(`SELECT COUNT(*) FROM accounts`)
+	if err != nil {
+		return err
+	}
+	if accountCount > 0 {
+		return errors.New("refusing to restore into a database that already contains accounts")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	for _, table := range keppel.BackupSnapshotTables {
+		query := fmt.Sprintf(`INSERT INTO %[1]s SELECT * FROM json_populate_record(NULL::%[1]s, $1)`, table) //nolint:gosec // table names are not user-supplied
+		for _, row := range snapshot.Tables[table] {
+			_, err := tx.Exec(query, string(row))
+			if err != nil {
+				return fmt.Errorf("cannot restore row into table %s: %w", table, err)
+			}
+		}
+		logg.Info("restored %d rows into table %s", len(snapshot.Tables[table]), table)
+	}
+	for _, table := range backupTablesWithSerialID {
+		query := fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %[1]s`, table) //nolint:gosec // table names are not user-supplied
+		_, err := tx.Exec(query)
+		if err != nil {
+			return fmt.Errorf("cannot reset ID sequence for table %s: %w", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func restoreBlobContents(ctx context.Context, db *keppel.DB, sd keppel.StorageDriver, bd keppel.BackupDriver, missingCount *int) error {
+	var blobs []models.Blob
+	_, err := db.Select(&blobs, `SELECT * FROM blobs WHERE storage_id != '' ORDER BY id`)
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range blobs {
+		account, err := keppel.FindReducedAccount(db, blob.AccountName)
+		if err != nil {
+			return fmt.Errorf("cannot find account for blob %s/%s: %w", blob.AccountName, blob.Digest, err)
+		}
+		reader, err := bd.ReadContent(ctx, blob.Digest)
+		if errors.Is(err, keppel.ErrBackupContentNotFound) {
+			logg.Error("cannot restore blob %s/%s: %s", blob.AccountName, blob.Digest, err.Error())
+			*missingCount++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read backup of blob %s/%s: %w", blob.AccountName, blob.Digest, err)
+		}
+
+		sizeBytes := blob.SizeBytes
+		err = sd.AppendToBlob(ctx, *account, blob.StorageID, 1, &sizeBytes, reader)
+		reader.Close()
+		if err == nil {
+			err = sd.FinalizeBlob(ctx, *account, blob.StorageID, 1)
+		}
+		if err != nil {
+			abortErr := sd.AbortBlobUpload(ctx, *account, blob.StorageID, 1)
+			if abortErr != nil {
+				logg.Error("additional error encountered when aborting restore of blob %s/%s: %s", blob.AccountName, blob.Digest, abortErr.Error())
+			}
+			return fmt.Errorf("cannot restore blob %s/%s: %w", blob.AccountName, blob.Digest, err)
+		}
+	}
+	return nil
+}
+
+var restoreManifestsQuery = sqlext.SimplifyWhitespace(`
+	SELECT r.account_name, r.name, m.repo_id, m.digest
+	  FROM manifests m JOIN repos r ON r.id = m.repo_id
+	 ORDER BY m.repo_id, m.digest
+`)
+
+func restoreManifestContents(ctx context.Context, db *keppel.DB, sd keppel.StorageDriver, bd keppel.BackupDriver, secd keppel.SecretsDriver, missingCount *int) error {
+	type manifestRef struct {
+		AccountName  models.AccountName
+		RepoName     string
+		RepositoryID int64
+		Digest       digest.Digest
+	}
+	var refs []manifestRef
+	err := sqlext.ForeachRow(db, restoreManifestsQuery, nil, func(rows *sql.Rows) error {
+		var ref manifestRef
+		err := rows.Scan(&ref.AccountName, &ref.RepoName, &ref.RepositoryID, &ref.Digest)
+		refs = append(refs, ref)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		account, err := keppel.FindReducedAccount(db, ref.AccountName)
+		if err != nil {
+			return fmt.Errorf("cannot find account for manifest %s/%s: %w", ref.AccountName, ref.Digest, err)
+		}
+		content, err := readManifestBackup(ctx, bd, *account, ref.RepoName, ref.Digest)
+		if errors.Is(err, keppel.ErrBackupContentNotFound) {
+			logg.Error("cannot restore manifest %s/%s@%s: %s", ref.AccountName, ref.RepoName, ref.Digest, err.Error())
+			*missingCount++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read backup of manifest %s/%s@%s: %w", ref.AccountName, ref.RepoName, ref.Digest, err)
+		}
+
+		keyRef, dbContent, err := keppel.UnwrapEncryptedManifestContent(ref.Digest, content)
+		if err == nil && keyRef == "" {
+			dbContent, err = keppel.EncryptManifestContent(ctx, secd, account.AuthTenantID, account.ContentEncryptionKeyRef, ref.Digest, content)
+			keyRef = account.ContentEncryptionKeyRef
+		}
+		if err == nil {
+			err = db.Insert(&models.ManifestContent{
+				RepositoryID:     ref.RepositoryID,
+				Digest:           ref.Digest.String(),
+				Content:          dbContent,
+				EncryptionKeyRef: keyRef,
+			})
+		}
+		if err == nil {
+			storedContent := content
+			if keyRef != "" {
+				storedContent = keppel.WrapEncryptedManifestContent(keyRef, dbContent)
+			}
+			err = sd.WriteManifest(ctx, *account, ref.RepoName, ref.Digest, storedContent)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot restore manifest %s/%s@%s: %w", ref.AccountName, ref.RepoName, ref.Digest, err)
+		}
+	}
+	return nil
+}
+
+// Reads the backup of a manifest, preferring the location that matches the
+// account's current encryption setting, but falling back to the other one
+// for manifests whose backup predates a change of that setting.
+func readManifestBackup(ctx context.Context, bd keppel.BackupDriver, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) ([]byte, error) {
+	read := func(fromAccountContents bool) (io.ReadCloser, error) {
+		if fromAccountContents {
+			return bd.ReadAccountContent(ctx, account.Name, repoName, manifestDigest)
+		}
+		return bd.ReadContent(ctx, manifestDigest)
+	}
+	isEncrypted := account.ContentEncryptionKeyRef != ""
+	reader, err := read(isEncrypted)
+	if errors.Is(err, keppel.ErrBackupContentNotFound) {
+		reader, err = read(!isEncrypted)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
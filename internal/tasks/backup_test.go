@@ -0,0 +1,180 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	j, s := setup(t,
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "test1authtenant"}),
+		test.WithRepo(models.Repository{AccountName: "test2", Name: "bar"}),
+	)
+	s.Clock.StepBy(1 * time.Hour)
+	blobBackupJob := j.BlobBackupJob(s.Registry)
+	manifestBackupJob := j.ManifestBackupJob(s.Registry)
+	snapshotJob := j.BackupSnapshotJob(s.Registry)
+
+	// push the same image into two different accounts
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	barRepoRef := models.Repository{AccountName: "test2", Name: "bar"}
+	manifest1 := image.MustUpload(t, s, fooRepoRef, "latest")
+	manifest2 := image.MustUpload(t, s, barRepoRef, "latest")
+
+	// BlobBackupJob backs up each blob of each account, but writes the contents
+	// of each digest only once (2 accounts x 2 blobs = 4 tasks, but only 2 writes)
+	for range 4 {
+		expectSuccess(t, blobBackupJob.ProcessOne(s.Ctx))
+	}
+	expectError(t, sql.ErrNoRows.Error(), blobBackupJob.ProcessOne(s.Ctx))
+	assert.DeepEqual(t, "ContentWriteCount", s.BD.ContentWriteCount, 2)
+
+	// same for manifests
+	for range 2 {
+		expectSuccess(t, manifestBackupJob.ProcessOne(s.Ctx))
+	}
+	expectError(t, sql.ErrNoRows.Error(), manifestBackupJob.ProcessOne(s.Ctx))
+	assert.DeepEqual(t, "ContentWriteCount", s.BD.ContentWriteCount, 3)
+
+	// write a snapshot
+	expectSuccess(t, snapshotJob.ProcessOne(s.Ctx))
+	snapshotName := keppel.BackupSnapshotName(s.Clock.Now())
+	names, err := s.BD.ListSnapshots(s.Ctx)
+	mustDo(t, err)
+	assert.DeepEqual(t, "snapshot names", names, []string{snapshotName})
+
+	// restoring into a DB that still has accounts is refused
+	expectError(t, "refusing to restore into a database that already contains accounts",
+		RestoreBackup(s.Ctx, s.DB, s.SD, s.BD, s.SecD, ""))
+
+	// restore into a fresh setup with empty DB and storage
+	bd := s.BD
+	s2 := test.NewSetup(t)
+	expectSuccess(t, RestoreBackup(s2.Ctx, s2.DB, s2.SD, bd, s2.SecD, ""))
+
+	accountCount, err := s2.DB.SelectInt(`SELECT COUNT(*) FROM accounts`)
+	mustDo(t, err)
+	assert.DeepEqual(t, "account count", accountCount, int64(2))
+	var blobs []models.Blob
+	_, err = s2.DB.Select(&blobs, `SELECT * FROM blobs ORDER BY id`)
+	mustDo(t, err)
+	assert.DeepEqual(t, "blob count", len(blobs), 4)
+	s2.ExpectBlobsExistInStorage(t, blobs...)
+	s2.ExpectManifestsExistInStorage(t, "foo", manifest1)
+	s2.ExpectManifestsExistInStorage(t, "bar", manifest2)
+	manifestContentCount, err := s2.DB.SelectInt(`SELECT COUNT(*) FROM manifest_contents`)
+	mustDo(t, err)
+	assert.DeepEqual(t, "manifest content count", manifestContentCount, int64(2))
+
+	// newly created repos must not collide with restored ones
+	repo, err := keppel.FindOrCreateRepository(s2.DB, "baz", "test1")
+	mustDo(t, err)
+	if repo.ID <= manifest2.RepositoryID {
+		t.Errorf("expected new repo to get an ID greater than %d, but got %d", manifest2.RepositoryID, repo.ID)
+	}
+}
+
+func TestBackupAndRestoreOfEncryptedManifests(t *testing.T) {
+	j, s := setup(t,
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "test1authtenant"}),
+		test.WithRepo(models.Repository{AccountName: "test2", Name: "bar"}),
+	)
+	s.Clock.StepBy(1 * time.Hour)
+	manifestBackupJob := j.ManifestBackupJob(s.Registry)
+	snapshotJob := j.BackupSnapshotJob(s.Registry)
+
+	// only the first account has a content encryption key
+	s.SecD.Secrets["test1authtenant/secret/data-key"] = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x42}, 32))
+	mustExec(t, s.DB, `UPDATE accounts SET content_encryption_key_ref = $1 WHERE name = $2`, "secret/data-key", "test1")
+
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	barRepoRef := models.Repository{AccountName: "test2", Name: "bar"}
+	manifest1 := image.MustUpload(t, s, fooRepoRef, "latest")
+	manifest2 := image.MustUpload(t, s, barRepoRef, "latest")
+
+	// the encrypted manifest is backed up as ciphertext in a location specific
+	// to its account, and is therefore not deduplicated with the plaintext copy
+	// of the same manifest in the other account
+	for range 2 {
+		expectSuccess(t, manifestBackupJob.ProcessOne(s.Ctx))
+	}
+	expectError(t, sql.ErrNoRows.Error(), manifestBackupJob.ProcessOne(s.Ctx))
+	assert.DeepEqual(t, "ContentWriteCount", s.BD.ContentWriteCount, 1)
+	assert.DeepEqual(t, "plaintext backup", s.BD.Contents[image.Manifest.Digest], image.Manifest.Contents)
+	encryptedBackup := s.BD.AccountContents["test1/foo@"+image.Manifest.Digest.String()]
+	if !bytes.HasPrefix(encryptedBackup, []byte("keppel-encrypted-v1:")) || bytes.Contains(encryptedBackup, image.Manifest.Contents) {
+		t.Errorf("expected encrypted backup of test1/foo, but got %q", string(encryptedBackup))
+	}
+	var dbContent models.ManifestContent
+	mustDo(t, s.DB.SelectOne(&dbContent, `SELECT * FROM manifest_contents WHERE repo_id = $1`, manifest1.RepositoryID))
+	assert.DeepEqual(t, "encrypted backup", encryptedBackup, keppel.WrapEncryptedManifestContent(dbContent.EncryptionKeyRef, dbContent.Content))
+
+	// restoring does not require the key: the ciphertext is restored as it is
+	expectSuccess(t, snapshotJob.ProcessOne(s.Ctx))
+	bd := s.BD
+	s2 := test.NewSetup(t)
+	expectSuccess(t, RestoreBackup(s2.Ctx, s2.DB, s2.SD, bd, s2.SecD, ""))
+
+	var restoredContent models.ManifestContent
+	mustDo(t, s2.DB.SelectOne(&restoredContent, `SELECT * FROM manifest_contents WHERE repo_id = $1`, manifest1.RepositoryID))
+	assert.DeepEqual(t, "restored encryption key ref", restoredContent.EncryptionKeyRef, "secret/data-key")
+	assert.DeepEqual(t, "restored content", restoredContent.Content, dbContent.Content)
+	account, err := keppel.FindReducedAccount(s2.DB, "test1")
+	mustDo(t, err)
+	stored, err := s2.SD.ReadManifest(s2.Ctx, *account, "foo", image.Manifest.Digest)
+	mustDo(t, err)
+	assert.DeepEqual(t, "restored manifest in storage", stored, encryptedBackup)
+	s2.ExpectManifestsExistInStorage(t, "bar", manifest2)
+
+	// once the key is available, the restored manifest can be decrypted
+	s2.SecD.Secrets["test1authtenant/secret/data-key"] = s.SecD.Secrets["test1authtenant/secret/data-key"]
+	plaintext, err := keppel.ReadManifestContent(s2.Ctx, s2.DB, s2.SecD, manifest1.RepositoryID, image.Manifest.Digest)
+	mustDo(t, err)
+	assert.DeepEqual(t, "decrypted manifest", plaintext, image.Manifest.Contents)
+}
+
+func TestBackupRetriesFailedItemsLater(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	blobBackupJob := j.BlobBackupJob(s.Registry)
+
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, fooRepoRef, "latest")
+
+	// make the backup of the first blob fail by removing its contents from the storage
+	var blobs []models.Blob
+	_, err := s.DB.Select(&blobs, `SELECT * FROM blobs ORDER BY id`)
+	mustDo(t, err)
+	account, err := keppel.FindReducedAccount(s.DB, "test1")
+	mustDo(t, err)
+	mustDo(t, s.SD.DeleteBlob(s.Ctx, *account, blobs[0].StorageID))
+
+	// the failing blob does not block the backup of the other blob
+	expectedError := fmt.Sprintf("cannot read blob test1/%s: no such blob", blobs[0].Digest)
+	expectError(t, expectedError, blobBackupJob.ProcessOne(s.Ctx))
+	expectSuccess(t, blobBackupJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), blobBackupJob.ProcessOne(s.Ctx))
+
+	var blob models.Blob
+	mustDo(t, s.DB.SelectOne(&blob, `SELECT * FROM blobs WHERE id = $1`, blobs[0].ID))
+	assert.DeepEqual(t, "backup_error_message", blob.BackupErrorMessage, expectedError)
+	expectedNextBackupAt := s.Clock.Now().Add(models.BlobBackupAfterErrorInterval)
+	assert.DeepEqual(t, "next_backup_at", blob.NextBackupAt, &expectedNextBackupAt)
+
+	// the failed blob is retried once the backoff has expired
+	s.Clock.StepBy(models.BlobBackupAfterErrorInterval + time.Second)
+	expectError(t, expectedError, blobBackupJob.ProcessOne(s.Ctx))
+}
@@ -118,8 +118,7 @@ func (j *Janitor) sweepBlobsInRepo(ctx context.Context, account models.Account,
 		logg.Info("sweeping %d blobs in account %s", len(blobs), account.Name)
 	}
 	for _, blob := range blobs {
-		// without transaction: we need this committed right now
-		_, err := j.db.Delete(&blob)
+		err := j.deleteBlobFromDB(account, blob)
 		if err != nil {
 			return err
 		}
@@ -135,6 +134,37 @@ func (j *Janitor) sweepBlobsInRepo(ctx context.Context, account models.Account,
 	return err
 }
 
+// Deletes the blob from the DB. If the blob may have been served via CDN, a
+// purge from the CDN is enqueued in the same transaction, to be executed by
+// CDNPurgeJob. (The purge cannot be done right here since it could not be
+// retried once the blob is gone from the DB.)
+func (j *Janitor) deleteBlobFromDB(account models.Account, blob models.Blob) error {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	_, err = tx.Delete(&blob)
+	if err != nil {
+		return err
+	}
+	if j.cdnd != nil && account.ServeBlobsViaCDN {
+		err = tx.Insert(&models.PendingCDNPurge{
+			AccountName:   account.Name,
+			AuthTenantID:  account.AuthTenantID,
+			Digest:        blob.Digest,
+			NextAttemptAt: j.timeNow(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// commit right away: the blob must be gone from the DB before it is deleted from the storage
+	return tx.Commit()
+}
+
 var validateBlobSearchQuery = sqlext.SimplifyWhitespace(`
 	SELECT * FROM blobs WHERE storage_id != '' AND next_validation_at < $1
 	ORDER BY next_validation_at ASC
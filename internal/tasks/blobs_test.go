@@ -5,11 +5,13 @@ package tasks
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
 	"github.com/sapcc/go-bits/easypg"
 
 	"github.com/sapcc/keppel/internal/models"
@@ -61,6 +63,60 @@ func TestSweepBlobs(t *testing.T) {
 	s.ExpectBlobsExistInStorage(t, dbBlobs[2:]...)
 }
 
+func TestSweepBlobsPurgesCDN(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	sweepBlobsJob := j.BlobSweepJob(s.Registry)
+
+	var dbBlobs []models.Blob
+	for idx := range 2 {
+		blob := test.GenerateExampleLayer(int64(idx))
+		dbBlobs = append(dbBlobs, blob.MustUpload(t, s, fooRepoRef))
+	}
+	mustExec(t, s.DB, `UPDATE accounts SET serve_blobs_via_cdn = TRUE`)
+
+	cdnPurgeJob := j.CDNPurgeJob(s.Registry)
+
+	// after the blob is swept, a purge from the CDN is enqueued
+	mustExec(t, s.DB, `DELETE FROM blob_mounts WHERE blob_id = $1`, dbBlobs[0].ID)
+	expectSuccess(t, sweepBlobsJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), cdnPurgeJob.ProcessOne(s.Ctx))
+	s.Clock.StepBy(2 * time.Hour)
+	tr, tr0 := easypg.NewTracker(t, s.DB.Db)
+	tr0.Ignore()
+	expectSuccess(t, sweepBlobsJob.ProcessOne(s.Ctx))
+	s.ExpectBlobsMissingInStorage(t, dbBlobs[0])
+	assert.DeepEqual(t, "purged blobs", s.CDN.PurgedBlobs, []string(nil))
+	tr.DBChanges().AssertEqualf(`
+			DELETE FROM blobs WHERE id = 1 AND account_name = 'test1' AND digest = '%[1]s';
+			UPDATE accounts SET next_blob_sweep_at = %[2]d WHERE name = 'test1';
+			INSERT INTO pending_cdn_purges (id, account_name, auth_tenant_id, digest, next_attempt_at) VALUES (1, 'test1', 'test1authtenant', '%[1]s', %[3]d);
+		`,
+		dbBlobs[0].Digest, s.Clock.Now().Add(1*time.Hour).Unix(), s.Clock.Now().Unix(),
+	)
+
+	// failed purges are retried with increasing delays
+	s.CDN.PurgeError = errors.New("CDN unavailable")
+	s.Clock.StepBy(time.Second)
+	expectError(t, fmt.Sprintf("while purging blob %s in account test1 from CDN: CDN unavailable", dbBlobs[0].Digest), cdnPurgeJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), cdnPurgeJob.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE pending_cdn_purges SET failed_count = 1, next_attempt_at = %[1]d, error_message = 'CDN unavailable' WHERE id = 1;
+		`,
+		s.Clock.Now().Add(5*time.Minute).Unix(),
+	)
+
+	// once the CDN works again, the purge goes through
+	s.CDN.PurgeError = nil
+	s.Clock.StepBy(6 * time.Minute)
+	expectSuccess(t, cdnPurgeJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), cdnPurgeJob.ProcessOne(s.Ctx))
+	assert.DeepEqual(t, "purged blobs", s.CDN.PurgedBlobs, []string{"test1/" + dbBlobs[0].Digest.String()})
+	tr.DBChanges().AssertEqualf(`
+			DELETE FROM pending_cdn_purges WHERE id = 1;
+		`)
+}
+
 func TestValidateBlobs(t *testing.T) {
 	j, s := setup(t)
 	s.Clock.StepBy(1 * time.Hour)
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+const (
+	cdnPurgeMaxAttempts   = 10
+	cdnPurgeRetryInterval = 5 * time.Minute
+)
+
+var cdnPurgeSelectQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM pending_cdn_purges
+	 WHERE next_attempt_at < $1
+	 ORDER BY next_attempt_at ASC
+	 LIMIT 1 -- one at a time
+`)
+
+var cdnPurgeFailedQuery = sqlext.SimplifyWhitespace(`
+	UPDATE pending_cdn_purges SET failed_count = $2, next_attempt_at = $3, error_message = $4
+	 WHERE id = $1
+`)
+
+// CDNPurgeJob is a job. Each task takes a blob that was deleted by
+// BlobSweepJob and asks the CDN to purge it from its caches. Failed purges are
+// retried with increasing delays. After cdnPurgeMaxAttempts failed attempts,
+// the purge is dropped, and the CDN serves stale content until its cache
+// expires.
+func (j *Janitor) CDNPurgeJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.PendingCDNPurge]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "purge deleted blobs from CDN",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_cdn_purges",
+				Help: "Counter for attempts to purge deleted blobs from the CDN.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (purge models.PendingCDNPurge, err error) {
+			err = j.db.SelectOne(&purge, cdnPurgeSelectQuery, j.timeNow())
+			return purge, err
+		},
+		ProcessTask: j.purgeBlobFromCDN,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) purgeBlobFromCDN(ctx context.Context, purge models.PendingCDNPurge, _ prometheus.Labels) error {
+	// if the CDN driver was removed from the configuration, there is nothing left to purge
+	if j.cdnd == nil {
+		_, err := j.db.Delete(&purge)
+		return err
+	}
+
+	account := models.ReducedAccount{Name: purge.AccountName, AuthTenantID: purge.AuthTenantID}
+	blob := models.Blob{AccountName: purge.AccountName, Digest: purge.Digest}
+	err := j.cdnd.PurgeBlob(ctx, account, blob)
+	if err == nil {
+		_, err = j.db.Delete(&purge)
+		return err
+	}
+
+	// give up after too many attempts, to avoid piling up purges if the CDN is gone for good
+	purge.FailedCount++
+	if purge.FailedCount >= cdnPurgeMaxAttempts {
+		_, err2 := j.db.Delete(&purge)
+		if err2 != nil {
+			return fmt.Errorf("%w (additional error when deleting failed purge: %s)", err, err2.Error())
+		}
+		return fmt.Errorf("giving up on purging blob %s in account %s from CDN after %d failed attempts: %w",
+			purge.Digest, purge.AccountName, purge.FailedCount, err)
+	}
+
+	//nolint:gosec // FailedCount is below cdnPurgeMaxAttempts here
+	nextAttemptAt := j.timeNow().Add(j.addJitter(time.Duration(purge.FailedCount) * cdnPurgeRetryInterval))
+	_, err2 := j.db.Exec(cdnPurgeFailedQuery, purge.ID, purge.FailedCount, nextAttemptAt, err.Error())
+	if err2 != nil {
+		return fmt.Errorf("%w (additional error when writing error message into DB: %s)", err, err2.Error())
+	}
+	return fmt.Errorf("while purging blob %s in account %s from CDN: %w", purge.Digest, purge.AccountName, err)
+}
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+const (
+	credentialReportInterval      = 24 * time.Hour
+	credentialReportRetryInterval = 1 * time.Hour
+	webhookTimeout                = 10 * time.Second
+)
+
+var credentialReportSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM accounts
+		WHERE (next_credential_report_at IS NULL OR next_credential_report_at < $1) AND NOT is_deleting
+	-- accounts without any reports first, then sorted by last report
+	ORDER BY next_credential_report_at IS NULL DESC, next_credential_report_at ASC
+	-- only one account at a time
+	LIMIT 1
+`)
+
+var credentialReportDoneQuery = sqlext.SimplifyWhitespace(`
+	UPDATE accounts SET next_credential_report_at = $2 WHERE name = $1
+`)
+
+// CredentialReportJob is a job. Each task finds an account whose credential
+// report has not been generated in more than a day, and generates it. If the
+// report lists unused credentials and a webhook is configured, the report is
+// submitted to that webhook.
+func (j *Janitor) CredentialReportJob(registerer prometheus.Registerer) jobloop.Job { //nolint: dupl // interface implementation of different things
+	return (&jobloop.ProducerConsumerJob[models.Account]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "generate credential reports",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_credential_reports",
+				Help: "Counter for generated credential usage reports.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (account models.Account, err error) {
+			err = j.db.SelectOne(&account, credentialReportSearchQuery, j.timeNow())
+			return account, err
+		},
+		ProcessTask: j.generateCredentialReport,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) generateCredentialReport(ctx context.Context, account models.Account, _ prometheus.Labels) error {
+	err := j.doGenerateCredentialReport(ctx, account)
+	if err != nil {
+		_, err2 := j.db.Exec(credentialReportDoneQuery, account.Name, j.timeNow().Add(j.addJitter(credentialReportRetryInterval)))
+		if err2 != nil {
+			return fmt.Errorf("%w (additional error when scheduling retry: %s)", err, err2.Error())
+		}
+		return fmt.Errorf("while generating credential report for account %s: %w", account.Name, err)
+	}
+
+	_, err = j.db.Exec(credentialReportDoneQuery, account.Name, j.timeNow().Add(j.addJitter(credentialReportInterval)))
+	return err
+}
+
+func (j *Janitor) doGenerateCredentialReport(ctx context.Context, account models.Account) error {
+	now := j.timeNow()
+	err := keppel.ObserveRBACPolicies(j.db, account, now)
+	if err != nil {
+		return err
+	}
+	report, err := keppel.BuildCredentialReport(j.db, account, j.cfg.CredentialReport.UnusedDays, now)
+	if err != nil {
+		return err
+	}
+
+	webhookURL := j.cfg.CredentialReport.WebhookURL
+	if webhookURL == nil || !report.HasUnusedCredentials() {
+		return nil
+	}
+	err = submitToWebhook(ctx, webhookURL.String(), keppel.CredentialReportNotification{
+		Account: account.Name,
+		Report:  report,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot deliver credential report to webhook: %w", err)
+	}
+	return nil
+}
+
+// Used for webhooks that are configured by the operator. Webhooks configured
+// by users go through Janitor.webhookClient instead.
+var operatorWebhookClient = &http.Client{Timeout: webhookTimeout}
+
+// submitToWebhook POSTs the given payload as JSON to the given URL, which must
+// be configured by the operator.
+func submitToWebhook(ctx context.Context, webhookURL string, payload any) error {
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postToWebhook(ctx, operatorWebhookClient, webhookURL, reqBody, nil)
+}
+
+// postToWebhook POSTs the given JSON request body to the given URL.
+func postToWebhook(ctx context.Context, client *http.Client, webhookURL string, reqBody []byte, header http.Header) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10)) //nolint:errcheck // only used for the error message
+		return fmt.Errorf("expected 2xx status, but got %s: %q", resp.Status, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/must"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestCredentialReportJob(t *testing.T) {
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		_, s := setup(t)
+		s.Clock.StepBy(1 * time.Hour)
+
+		cfg := s.Config
+		cfg.CredentialReport.WebhookURL = must.Return(url.Parse("https://hooks.example.com/credentials"))
+		j := NewJanitor(cfg, s.FD, s.SD, s.ICD, s.SecD, s.BD, s.CDN, s.NVD, s.DB, s.AMD, s.Auditor).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next)
+		j.DisableJitter()
+		job := j.CredentialReportJob(s.Registry)
+
+		// mock a webhook receiver
+		var notifications []keppel.CredentialReportNotification
+		webhookStatus := http.StatusNoContent
+		tt.Handlers["hooks.example.com"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var n keppel.CredentialReportNotification
+			mustDo(t, json.NewDecoder(r.Body).Decode(&n))
+			notifications = append(notifications, n)
+			w.WriteHeader(webhookStatus)
+		})
+
+		policiesJSON := `[{"match_username":"ci-bot","permissions":["pull","push"]}]`
+		mustExec(t, s.DB, `UPDATE accounts SET rbac_policies_json = $1`, policiesJSON)
+		policies := must.Return(keppel.ParseRBACPoliciesField(policiesJSON))
+		tr, _ := easypg.NewTracker(t, s.DB.Db)
+
+		// the first report starts tracking the policy, but does not report it yet
+		expectSuccess(t, job.ProcessOne(s.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE accounts SET next_credential_report_at = %[3]d WHERE name = 'test1';
+				INSERT INTO rbac_policy_usage (account_name, policy_fingerprint, first_seen_at) VALUES ('test1', '%[1]s', %[2]d);
+			`,
+			policies[0].Fingerprint(), s.Clock.Now().Unix(), s.Clock.Now().Add(24*time.Hour).Unix(),
+		)
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+		assert.DeepEqual(t, "notifications", len(notifications), 0)
+
+		// when the policy has not been used for long enough, it is reported to the webhook
+		firstSeenAt := s.Clock.Now()
+		s.Clock.StepBy(91 * 24 * time.Hour)
+		expectSuccess(t, job.ProcessOne(s.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE accounts SET next_credential_report_at = %[1]d WHERE name = 'test1';
+			`,
+			s.Clock.Now().Add(24*time.Hour).Unix(),
+		)
+		assert.DeepEqual(t, "notifications", len(notifications), 1)
+		assert.DeepEqual(t, "account", notifications[0].Account, "test1")
+		assert.DeepEqual(t, "report", notifications[0].Report.RBACPolicies, []keppel.RBACPolicyUsageReport{{
+			Policy:         policies[0],
+			FirstSeenAt:    keppel.MaybeTimeToUnix(&firstSeenAt),
+			IsUnused:       true,
+			Recommendation: "This policy has not been used since it was first seen on 1970-01-01. Consider removing it.",
+		}})
+
+		// when the webhook fails, the report is retried after an hour
+		webhookStatus = http.StatusInternalServerError
+		s.Clock.StepBy(25 * time.Hour)
+		expectError(t, "while generating credential report for account test1: cannot deliver credential report to webhook: expected 2xx status, but got 500 Internal Server Error: \"\"",
+			job.ProcessOne(s.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE accounts SET next_credential_report_at = %[1]d WHERE name = 'test1';
+			`,
+			s.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+
+		// when the policy is removed, its usage record is cleaned up
+		webhookStatus = http.StatusNoContent
+		mustExec(t, s.DB, `UPDATE accounts SET rbac_policies_json = ''`)
+		tr.DBChanges().Ignore()
+		s.Clock.StepBy(2 * time.Hour)
+		expectSuccess(t, job.ProcessOne(s.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE accounts SET next_credential_report_at = %[2]d WHERE name = 'test1';
+				DELETE FROM rbac_policy_usage WHERE account_name = 'test1' AND policy_fingerprint = '%[1]s';
+			`,
+			policies[0].Fingerprint(), s.Clock.Now().Add(24*time.Hour).Unix(),
+		)
+		assert.DeepEqual(t, "notifications", len(notifications), 2)
+	})
+}
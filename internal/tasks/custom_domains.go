@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var customDomainVerificationSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM accounts
+		WHERE custom_domain != '' AND custom_domain_verified_at IS NULL
+		  AND (next_custom_domain_verification_at IS NULL OR next_custom_domain_verification_at < $1)
+	-- accounts without any checks first, then sorted by last check
+	ORDER BY next_custom_domain_verification_at IS NULL DESC, next_custom_domain_verification_at ASC, name ASC
+	-- only one account at a time
+	LIMIT 1
+`)
+
+var customDomainVerificationFailedQuery = sqlext.SimplifyWhitespace(`
+	UPDATE accounts SET next_custom_domain_verification_at = $2 WHERE name = $1
+`)
+
+// The WHERE clause guards against the custom domain having been changed while we were looking at the DNS.
+var customDomainVerificationDoneQuery = sqlext.SimplifyWhitespace(`
+	UPDATE accounts SET custom_domain_verified_at = $2, next_custom_domain_verification_at = NULL
+	 WHERE name = $1 AND custom_domain = $3 AND custom_domain_verification_token = $4
+`)
+
+var customDomainVerifiedElsewhereQuery = sqlext.SimplifyWhitespace(`
+	SELECT COUNT(*) FROM accounts WHERE custom_domain = $1 AND name != $2 AND custom_domain_verified_at IS NOT NULL
+`)
+
+// CustomDomainVerificationJob is a job. Each task finds an account with a
+// custom domain whose ownership has not been verified yet, and checks whether
+// the verification token has been published in the respective DNS TXT record.
+// If no accounts need to be checked, sql.ErrNoRows is returned to instruct the
+// caller to slow down.
+func (j *Janitor) CustomDomainVerificationJob(registerer prometheus.Registerer) jobloop.Job { //nolint: dupl // interface implementation of different things
+	return (&jobloop.ProducerConsumerJob[models.Account]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "custom domain verification",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_custom_domain_verifications",
+				Help: "Counter for ownership checks of custom domains of accounts.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (account models.Account, err error) {
+			err = j.db.SelectOne(&account, customDomainVerificationSearchQuery, j.timeNow())
+			return account, err
+		},
+		ProcessTask: j.verifyCustomDomain,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) verifyCustomDomain(ctx context.Context, account models.Account, labels prometheus.Labels) error {
+	recordName := keppel.CustomDomainVerificationRecordName(account.CustomDomain)
+	records, err := j.lookupTXT(ctx, recordName)
+	if err != nil {
+		// a missing record (NXDOMAIN) is also reported as an error, so this is
+		// expected until the user has set up their DNS
+		logg.Debug("cannot lookup TXT record %s for account %q: %s", recordName, account.Name, err.Error())
+	}
+
+	if !slices.Contains(records, account.CustomDomainVerificationToken) {
+		_, err := j.db.Exec(customDomainVerificationFailedQuery, account.Name, j.timeNow().Add(j.addJitter(5*time.Minute)))
+		return err
+	}
+
+	// the first account to verify a custom domain gets to keep it
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+	isInUse, err := tx.SelectInt(customDomainVerifiedElsewhereQuery, account.CustomDomain, account.Name)
+	if err != nil {
+		return err
+	}
+	if isInUse != 0 {
+		logg.Info("not verifying custom domain %q for account %q: domain is already in use by another account", account.CustomDomain, account.Name)
+		_, err := tx.Exec(customDomainVerificationFailedQuery, account.Name, j.timeNow().Add(j.addJitter(1*time.Hour)))
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	_, err = tx.Exec(customDomainVerificationDoneQuery, account.Name, j.timeNow(), account.CustomDomain, account.CustomDomainVerificationToken)
+	if err != nil {
+		return err
+	}
+	err = keppel.NotifyAccountChanged(tx, account.Name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/easypg"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestCustomDomainVerificationJob(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+
+	// fake DNS
+	txtRecords := make(map[string][]string)
+	j.OverrideLookupTXT(func(_ context.Context, name string) ([]string, error) {
+		records, ok := txtRecords[name]
+		if !ok {
+			return nil, errors.New("no such host")
+		}
+		return records, nil
+	})
+	job := j.CustomDomainVerificationJob(s.Registry)
+
+	// two accounts claim the same custom domain
+	mustExec(t, s.DB, `UPDATE accounts SET custom_domain = $1, custom_domain_verification_token = $2 WHERE name = 'test1'`,
+		"registry.example.com", "keppel-verification=token1")
+	account2 := models.Account{
+		Name:                          "test2",
+		AuthTenantID:                  "test2authtenant",
+		CustomDomain:                  "registry.example.com",
+		CustomDomainVerificationToken: "keppel-verification=token2",
+	}
+	mustDo(t, s.DB.Insert(&account2))
+	tr, _ := easypg.NewTracker(t, s.DB.Db)
+
+	// as long as the TXT record is missing or does not contain the token, nothing is verified
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE accounts SET next_custom_domain_verification_at = %[1]d WHERE name = 'test1';
+			UPDATE accounts SET next_custom_domain_verification_at = %[1]d WHERE name = 'test2';
+		`,
+		s.Clock.Now().Add(5*time.Minute).Unix(),
+	)
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+
+	txtRecords["_keppel-challenge.registry.example.com"] = []string{"v=spf1 -all", "keppel-verification=other"}
+	s.Clock.StepBy(10 * time.Minute)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE accounts SET next_custom_domain_verification_at = %[1]d WHERE name = 'test1';
+			UPDATE accounts SET next_custom_domain_verification_at = %[1]d WHERE name = 'test2';
+		`,
+		s.Clock.Now().Add(5*time.Minute).Unix(),
+	)
+
+	// the account whose token is published gets the domain
+	txtRecords["_keppel-challenge.registry.example.com"] = []string{"v=spf1 -all", "keppel-verification=token2"}
+	s.Clock.StepBy(10 * time.Minute)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE accounts SET next_custom_domain_verification_at = %[1]d WHERE name = 'test1';
+			UPDATE accounts SET custom_domain_verified_at = %[2]d, next_custom_domain_verification_at = NULL WHERE name = 'test2';
+		`,
+		s.Clock.Now().Add(5*time.Minute).Unix(), s.Clock.Now().Unix(),
+	)
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+
+	// the other account cannot take over the domain afterwards, even if its token gets published
+	txtRecords["_keppel-challenge.registry.example.com"] = []string{"keppel-verification=token1"}
+	s.Clock.StepBy(10 * time.Minute)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE accounts SET next_custom_domain_verification_at = %[1]d WHERE name = 'test1';
+		`,
+		s.Clock.Now().Add(1*time.Hour).Unix(),
+	)
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+}
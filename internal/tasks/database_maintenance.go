@@ -0,0 +1,345 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+)
+
+// databaseMaintenanceLockID identifies the Postgres advisory lock that is
+// held while a janitor performs maintenance on bloated tables and indexes.
+// The value is arbitrary, but must not be used for any other advisory lock.
+const databaseMaintenanceLockID int64 = 0x6b657070656c // "keppel"
+
+// hotDatabaseTables are the tables that see the most churn (through pushes,
+// deletions, GC and validation), and are therefore most prone to bloat.
+var hotDatabaseTables = []string{
+	"blob_mounts",
+	"blobs",
+	"manifest_blob_refs",
+	"manifest_manifest_refs",
+	"manifests",
+	"repos",
+	"tags",
+	"trivy_reports",
+	"trivy_security_info",
+	"uploads",
+}
+
+var (
+	databaseTableSizeGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_db_table_size_bytes",
+			Help: "Size of a database table (excluding indexes), as measured by the database bloat check.",
+		},
+		[]string{"table"},
+	)
+	databaseTableBloatGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_db_table_bloat_ratio",
+			Help: "Estimated fraction of a database table that is taken up by dead rows.",
+		},
+		[]string{"table"},
+	)
+	databaseIndexSizeGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_db_index_size_bytes",
+			Help: "Size of a database index, as measured by the database bloat check.",
+		},
+		[]string{"table", "index"},
+	)
+	databaseIndexBloatGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_db_index_bloat_ratio",
+			Help: "Estimated fraction of a database index that is taken up by bloat.",
+		},
+		[]string{"table", "index"},
+	)
+	databaseMaintenanceCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_db_maintenance_operations",
+			Help: "Counter for maintenance operations that were performed on bloated database tables and indexes.",
+		},
+		[]string{"relation", "operation"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(databaseTableSizeGauge)
+	prometheus.MustRegister(databaseTableBloatGauge)
+	prometheus.MustRegister(databaseIndexSizeGauge)
+	prometheus.MustRegister(databaseIndexBloatGauge)
+	prometheus.MustRegister(databaseMaintenanceCounter)
+}
+
+var databaseTableStatsQuery = sqlext.SimplifyWhitespace(`
+	SELECT relname, pg_table_size(relid), n_live_tup, n_dead_tup
+	  FROM pg_stat_user_tables
+	 WHERE schemaname = current_schema() AND relname = ANY($1)
+	 ORDER BY relname
+`)
+
+// Only btree indexes on plain columns are considered since the size
+// estimate in estimateBtreeIndexBloat() relies on column statistics.
+var databaseIndexStatsQuery = sqlext.SimplifyWhitespace(`
+	SELECT t.relname, ci.relname, pg_relation_size(ci.oid), ci.reltuples, COALESCE((
+	         SELECT SUM(s.avg_width) FROM pg_attribute a
+	           JOIN pg_stats s ON s.schemaname = n.nspname AND s.tablename = t.relname AND s.attname = a.attname
+	          WHERE a.attrelid = ci.oid
+	       ), 0)
+	  FROM pg_index i
+	  JOIN pg_class ci ON ci.oid = i.indexrelid
+	  JOIN pg_class t ON t.oid = i.indrelid
+	  JOIN pg_namespace n ON n.oid = t.relnamespace
+	  JOIN pg_am am ON am.oid = ci.relam
+	 WHERE n.nspname = current_schema() AND t.relname = ANY($1) AND am.amname = 'btree' AND i.indexprs IS NULL
+	 ORDER BY t.relname, ci.relname
+`)
+
+// databaseTableBloat is the result of measuring bloat in a database table.
+type databaseTableBloat struct {
+	TableName  string
+	SizeBytes  int64
+	BloatRatio float64
+}
+
+// databaseIndexBloat is the result of measuring bloat in a database index.
+type databaseIndexBloat struct {
+	TableName  string
+	IndexName  string
+	SizeBytes  int64
+	BloatRatio float64
+}
+
+// DatabaseMaintenanceJob is a job. Each task measures table and index bloat
+// in the busiest database tables and reports it as metrics. If the current
+// time is within one of the configured maintenance windows, tables and
+// indexes with too much bloat are vacuumed or rebuilt, respectively.
+func (j *Janitor) DatabaseMaintenanceJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "database bloat check",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_db_bloat_checks",
+				Help: "Counter for measurements of database table and index bloat.",
+			},
+		},
+		Interval: j.cfg.DatabaseMaintenance.Interval,
+		Task:     j.checkDatabaseBloat,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) checkDatabaseBloat(ctx context.Context, _ prometheus.Labels) error {
+	tables, indexes, err := j.measureDatabaseBloat()
+	if err != nil {
+		return err
+	}
+	for _, tb := range tables {
+		databaseTableSizeGauge.WithLabelValues(tb.TableName).Set(float64(tb.SizeBytes))
+		databaseTableBloatGauge.WithLabelValues(tb.TableName).Set(tb.BloatRatio)
+	}
+	for _, ib := range indexes {
+		databaseIndexSizeGauge.WithLabelValues(ib.TableName, ib.IndexName).Set(float64(ib.SizeBytes))
+		databaseIndexBloatGauge.WithLabelValues(ib.TableName, ib.IndexName).Set(ib.BloatRatio)
+	}
+
+	if !j.cfg.DatabaseMaintenance.IsInMaintenanceWindow(j.timeNow()) {
+		return nil
+	}
+	threshold := float64(j.cfg.DatabaseMaintenance.BloatThresholdPercent) / 100
+
+	// VACUUM and REINDEX CONCURRENTLY cannot run inside a transaction, so these
+	// statements are executed directly on a dedicated connection, which also
+	// holds the advisory lock that keeps multiple janitors from doing
+	// maintenance at the same time
+	conn, err := j.db.Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var isLocked bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, databaseMaintenanceLockID).Scan(&isLocked)
+	if err != nil {
+		return fmt.Errorf("cannot acquire advisory lock for database maintenance: %w", err)
+	}
+	if !isLocked {
+		logg.Info("skipping database maintenance since another janitor is already doing it")
+		return nil
+	}
+	defer func() {
+		_, err := conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, databaseMaintenanceLockID)
+		if err != nil {
+			logg.Error("cannot release advisory lock for database maintenance: %s", err.Error())
+		}
+	}()
+
+	for _, tb := range tables {
+		if tb.BloatRatio < threshold {
+			continue
+		}
+		logg.Info("vacuuming database table %s (estimated bloat: %.0f%%)", tb.TableName, tb.BloatRatio*100)
+		_, err := conn.ExecContext(ctx, "VACUUM (ANALYZE) "+pq.QuoteIdentifier(tb.TableName))
+		if err != nil {
+			return fmt.Errorf("cannot vacuum table %s: %w", tb.TableName, err)
+		}
+		databaseMaintenanceCounter.WithLabelValues(tb.TableName, "vacuum").Inc()
+	}
+	for _, ib := range indexes {
+		if ib.BloatRatio < threshold {
+			continue
+		}
+		// unlike a plain REINDEX, this does not block writes to the table while
+		// the index is rebuilt, similar to what pg_repack does
+		logg.Info("rebuilding database index %s on table %s (estimated bloat: %.0f%%)", ib.IndexName, ib.TableName, ib.BloatRatio*100)
+		_, err := conn.ExecContext(ctx, "REINDEX INDEX CONCURRENTLY "+pq.QuoteIdentifier(ib.IndexName))
+		if err != nil {
+			// a failed REINDEX CONCURRENTLY leaves an invalid copy of the index
+			// behind, which would otherwise slow down all writes to the table
+			err2 := dropInvalidIndexCopies(ctx, conn, ib.IndexName)
+			if err2 != nil {
+				return fmt.Errorf("cannot rebuild index %s: %w (additional error during cleanup: %s)", ib.IndexName, err, err2.Error())
+			}
+			return fmt.Errorf("cannot rebuild index %s: %w", ib.IndexName, err)
+		}
+		var isValid bool
+		err = conn.QueryRowContext(ctx, databaseIndexValidQuery, ib.IndexName).Scan(&isValid)
+		if err != nil {
+			return fmt.Errorf("cannot check validity of index %s: %w", ib.IndexName, err)
+		}
+		if !isValid {
+			return fmt.Errorf("index %s is not valid after rebuilding it", ib.IndexName)
+		}
+		databaseMaintenanceCounter.WithLabelValues(ib.IndexName, "reindex").Inc()
+	}
+	return nil
+}
+
+var databaseIndexValidQuery = sqlext.SimplifyWhitespace(`
+	SELECT i.indisvalid FROM pg_index i
+	  JOIN pg_class ci ON ci.oid = i.indexrelid
+	  JOIN pg_namespace n ON n.oid = ci.relnamespace
+	 WHERE n.nspname = current_schema() AND ci.relname = $1
+`)
+
+// REINDEX CONCURRENTLY builds the new index under the name "<index>_ccnew"
+// (with a number appended if that name is taken).
+var databaseInvalidIndexCopiesQuery = sqlext.SimplifyWhitespace(`
+	SELECT ci.relname FROM pg_index i
+	  JOIN pg_class ci ON ci.oid = i.indexrelid
+	  JOIN pg_namespace n ON n.oid = ci.relnamespace
+	 WHERE n.nspname = current_schema() AND NOT i.indisvalid AND ci.relname ~ ('^' || $1 || '_ccnew[0-9]*$')
+`)
+
+func dropInvalidIndexCopies(ctx context.Context, conn *sql.Conn, indexName string) error {
+	rows, err := conn.QueryContext(ctx, databaseInvalidIndexCopiesQuery, indexName)
+	if err != nil {
+		return err
+	}
+	var copyNames []string
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		copyNames = append(copyNames, name)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range copyNames {
+		logg.Info("dropping invalid index %s that was left behind by rebuilding index %s", name, indexName)
+		_, err := conn.ExecContext(ctx, "DROP INDEX CONCURRENTLY IF EXISTS "+pq.QuoteIdentifier(name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *Janitor) measureDatabaseBloat() (tables []databaseTableBloat, indexes []databaseIndexBloat, err error) {
+	err = sqlext.ForeachRow(j.db, databaseTableStatsQuery, []any{pq.Array(hotDatabaseTables)}, func(rows *sql.Rows) error {
+		var (
+			tb                 databaseTableBloat
+			liveRows, deadRows int64
+		)
+		err := rows.Scan(&tb.TableName, &tb.SizeBytes, &liveRows, &deadRows)
+		if err != nil {
+			return err
+		}
+		if liveRows+deadRows > 0 {
+			tb.BloatRatio = float64(deadRows) / float64(liveRows+deadRows)
+		}
+		tables = append(tables, tb)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot measure table bloat: %w", err)
+	}
+
+	err = sqlext.ForeachRow(j.db, databaseIndexStatsQuery, []any{pq.Array(hotDatabaseTables)}, func(rows *sql.Rows) error {
+		var (
+			ib       databaseIndexBloat
+			rowCount float64
+			rowWidth int64
+		)
+		err := rows.Scan(&ib.TableName, &ib.IndexName, &ib.SizeBytes, &rowCount, &rowWidth)
+		if err != nil {
+			return err
+		}
+		ib.BloatRatio = estimateBtreeIndexBloat(ib.SizeBytes, rowCount, rowWidth)
+		indexes = append(indexes, ib)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot measure index bloat: %w", err)
+	}
+	return tables, indexes, nil
+}
+
+const (
+	// these are the Postgres defaults for page layout and btree leaf pages
+	dbPageSizeBytes       = 8192
+	dbPageHeaderBytes     = 24
+	btreeSpecialBytes     = 16
+	btreeTupleHeaderBytes = 8
+	btreeItemPointerBytes = 4
+	btreeFillFactor       = 0.9
+)
+
+// estimateBtreeIndexBloat returns the estimated fraction of a btree index
+// that is taken up by bloat, by comparing its actual size with the size that
+// it would have right after being rebuilt. If the row count is unknown
+// (because the table has not been analyzed yet), zero is returned.
+func estimateBtreeIndexBloat(sizeBytes int64, rowCount float64, rowWidthBytes int64) float64 {
+	if sizeBytes <= 0 || rowCount < 0 {
+		return 0
+	}
+
+	// each index tuple is padded to a multiple of 8 bytes
+	tupleBytes := btreeTupleHeaderBytes + 8*math.Ceil(float64(rowWidthBytes)/8) + btreeItemPointerBytes
+	usableBytesPerPage := (dbPageSizeBytes - dbPageHeaderBytes - btreeSpecialBytes) * btreeFillFactor
+	// +1 for the metapage
+	expectedPages := math.Ceil(rowCount*tupleBytes/usableBytesPerPage) + 1
+	expectedBytes := expectedPages * dbPageSizeBytes
+
+	bloatBytes := float64(sizeBytes) - expectedBytes
+	if bloatBytes <= 0 {
+		return 0
+	}
+	return bloatBytes / float64(sizeBytes)
+}
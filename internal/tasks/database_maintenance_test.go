@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestDatabaseMaintenanceJob(t *testing.T) {
+	_, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, fooRepoRef, "latest")
+	mustExec(t, s.DB, `DELETE FROM tags`)
+	mustExec(t, s.DB, `ANALYZE`)
+
+	cfg := s.Config
+	cfg.DatabaseMaintenance = keppel.DatabaseMaintenanceConfig{
+		Interval:              time.Hour,
+		BloatThresholdPercent: 1,
+	}
+	j := NewJanitor(cfg, s.FD, s.SD, s.ICD, s.SecD, s.BD, s.CDN, s.NVD, s.DB, s.AMD, s.Auditor).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next)
+	j.DisableJitter()
+
+	// bloat is measured for all hot tables and their indexes
+	tables, indexes, err := j.measureDatabaseBloat()
+	mustDo(t, err)
+	tableNames := make([]string, len(tables))
+	for idx, tb := range tables {
+		tableNames[idx] = tb.TableName
+	}
+	assert.DeepEqual(t, "measured tables", tableNames, hotDatabaseTables)
+	hasTagsIndex := false
+	for _, ib := range indexes {
+		if ib.TableName == "tags" && ib.SizeBytes > 0 {
+			hasTagsIndex = true
+		}
+	}
+	if !hasTagsIndex {
+		t.Error("expected indexes of the tags table to be measured")
+	}
+
+	// outside of maintenance windows, only measurements are taken
+	job := j.DatabaseMaintenanceJob(s.Registry)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+
+	// within a maintenance window, bloated tables and indexes are maintained
+	// (with such a low threshold, VACUUM and REINDEX are exercised for real)
+	j.cfg.DatabaseMaintenance.Windows = []keppel.MaintenanceWindow{{Start: 0, End: 24 * time.Hour}}
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+}
+
+func TestEstimateBtreeIndexBloat(t *testing.T) {
+	testCases := []struct {
+		SizeBytes int64
+		RowCount  float64
+		RowWidth  int64
+		Expected  float64
+	}{
+		// unknown row count
+		{8 * 8192, -1, 8, 0},
+		// 1000 rows with 20 bytes per tuple fit into 3 leaf pages (plus metapage)
+		{4 * 8192, 1000, 4, 0},
+		{8 * 8192, 1000, 4, 0.5},
+		// an index that is smaller than expected is not bloated
+		{8192, 100000, 32, 0},
+	}
+	for _, tc := range testCases {
+		actual := estimateBtreeIndexBloat(tc.SizeBytes, tc.RowCount, tc.RowWidth)
+		if actual != tc.Expected {
+			t.Errorf("expected estimateBtreeIndexBloat(%d, %g, %d) = %g, but got %g",
+				tc.SizeBytes, tc.RowCount, tc.RowWidth, tc.Expected, actual)
+		}
+	}
+}
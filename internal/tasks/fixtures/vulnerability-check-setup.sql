@@ -36,11 +36,11 @@ INSERT INTO manifest_contents (repo_id, digest, content) VALUES (1, 'sha256:e3c1
 INSERT INTO manifest_manifest_refs (repo_id, parent_digest, child_digest) VALUES (1, 'sha256:44e1f93f1d7e65ecd552c9b3544af5966095177dbc3b3757fb2e7629dc219f1a', 'sha256:0962993cac41a5429d58b5142279ea849c3291cdffcc881d0188f1be73928ffd');
 INSERT INTO manifest_manifest_refs (repo_id, parent_digest, child_digest) VALUES (1, 'sha256:44e1f93f1d7e65ecd552c9b3544af5966095177dbc3b3757fb2e7629dc219f1a', 'sha256:e3c1e46560a7ce30e3d107791e1f60a588eda9554564a5d17aa365e53dd6ae58');
 
-INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at) VALUES (1, 'sha256:0962993cac41a5429d58b5142279ea849c3291cdffcc881d0188f1be73928ffd', 'application/vnd.docker.distribution.manifest.v2+json', 1050604, 3600, 90000);
-INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at) VALUES (1, 'sha256:44e1f93f1d7e65ecd552c9b3544af5966095177dbc3b3757fb2e7629dc219f1a', 'application/vnd.docker.distribution.manifest.list.v2+json', 2101735, 3600, 90000);
-INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at) VALUES (1, 'sha256:4c4f2bca300e74786a04590aa15cfcbfa1f3ec64c15fad0a0df8a6674dcbf34b', 'application/vnd.docker.distribution.manifest.v2+json', 1050604, 3600, 90000);
-INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at) VALUES (1, 'sha256:9483f33be9f2a735089786cda3bfc71c21965e3f089cb60cd56feaf10179a102', 'application/vnd.docker.distribution.manifest.v2+json', 2099500, 3600, 90000);
-INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at) VALUES (1, 'sha256:e3c1e46560a7ce30e3d107791e1f60a588eda9554564a5d17aa365e53dd6ae58', 'application/vnd.docker.distribution.manifest.v2+json', 1050604, 3600, 90000);
+INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at, push_info_json) VALUES (1, 'sha256:0962993cac41a5429d58b5142279ea849c3291cdffcc881d0188f1be73928ffd', 'application/vnd.docker.distribution.manifest.v2+json', 1050604, 3600, 90000, '{"client_ip":"192.0.2.1"}');
+INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at, push_info_json) VALUES (1, 'sha256:44e1f93f1d7e65ecd552c9b3544af5966095177dbc3b3757fb2e7629dc219f1a', 'application/vnd.docker.distribution.manifest.list.v2+json', 2101735, 3600, 90000, '{"client_ip":"192.0.2.1"}');
+INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at, push_info_json) VALUES (1, 'sha256:4c4f2bca300e74786a04590aa15cfcbfa1f3ec64c15fad0a0df8a6674dcbf34b', 'application/vnd.docker.distribution.manifest.v2+json', 1050604, 3600, 90000, '{"client_ip":"192.0.2.1"}');
+INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at, push_info_json) VALUES (1, 'sha256:9483f33be9f2a735089786cda3bfc71c21965e3f089cb60cd56feaf10179a102', 'application/vnd.docker.distribution.manifest.v2+json', 2099500, 3600, 90000, '{"client_ip":"192.0.2.1"}');
+INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at, push_info_json) VALUES (1, 'sha256:e3c1e46560a7ce30e3d107791e1f60a588eda9554564a5d17aa365e53dd6ae58', 'application/vnd.docker.distribution.manifest.v2+json', 1050604, 3600, 90000, '{"client_ip":"192.0.2.1"}');
 
 INSERT INTO quotas (auth_tenant_id, manifests) VALUES ('test1authtenant', 100);
 
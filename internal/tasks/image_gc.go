@@ -8,10 +8,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"sort"
 	"time"
 
-	"github.com/opencontainers/go-digest"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/jobloop"
 	"github.com/sapcc/go-bits/logg"
@@ -36,30 +34,71 @@ var imageGCResetStatusQuery = sqlext.SimplifyWhitespace(`
 `)
 
 var imageGCRepoDoneQuery = sqlext.SimplifyWhitespace(`
-	UPDATE repos SET next_gc_at = $2 WHERE id = $1
+	UPDATE repos SET next_gc_at = $2, gc_error_message = '' WHERE id = $1
+`)
+
+var imageGCRepoFailedQuery = sqlext.SimplifyWhitespace(`
+	UPDATE repos SET gc_error_message = $2 WHERE id = $1
 `)
 
 // ManifestGarbageCollectionJob is a job. Each task finds the a where GC has
 // not been performed for more than an hour, and performs GC based on the GC
 // policies configured on the repo's account.
-func (j *Janitor) ManifestGarbageCollectionJob(registerer prometheus.Registerer) jobloop.Job { //nolint: dupl // interface implementation of different things
-	return (&jobloop.ProducerConsumerJob[models.Repository]{
-		Metadata: jobloop.JobMetadata{
-			ReadableName: "manifest garbage collection",
-			CounterOpts: prometheus.CounterOpts{
-				Name: "keppel_image_garbage_collections",
-				Help: "Counter for image garbage collection runs in repos.",
-			},
+func (j *Janitor) ManifestGarbageCollectionJob(registerer prometheus.Registerer) jobloop.Job {
+	return j.policyDrivenDeletionJob(registerer, jobloop.JobMetadata{
+		ReadableName: "manifest garbage collection",
+		CounterOpts: prometheus.CounterOpts{
+			Name: "keppel_image_garbage_collections",
+			Help: "Counter for image garbage collection runs in repos.",
 		},
+	}, imageGCRepoSelectQuery, j.garbageCollectManifestsInRepo)
+}
+
+// policyDrivenDeletionJob contains the parts that are shared between
+// ManifestGarbageCollectionJob and TagRetentionJob: Each task takes one repo
+// that is selected by `selectQuery` (which gets the current time as $1).
+func (j *Janitor) policyDrivenDeletionJob(registerer prometheus.Registerer, metadata jobloop.JobMetadata, selectQuery string, processRepo func(context.Context, models.Repository, prometheus.Labels) error) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.Repository]{
+		Metadata: metadata,
 		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (repo models.Repository, err error) {
-			err = j.db.SelectOne(&repo, imageGCRepoSelectQuery, j.timeNow())
+			err = j.db.SelectOne(&repo, selectQuery, j.timeNow())
 			return repo, err
 		},
-		ProcessTask: j.garbageCollectManifestsInRepo,
+		ProcessTask: processRepo,
 	}).Setup(registerer)
 }
 
-func (j *Janitor) garbageCollectManifestsInRepo(ctx context.Context, repo models.Repository, labels prometheus.Labels) (returnErr error) {
+// deleteManifestByPolicy deletes a manifest that was selected for deletion by
+// a GC policy or tag retention policy, and marks its referrers in `manifests`
+// as deleted if the deletion cascades to them. The caller is responsible for
+// marking `m` itself as deleted.
+func (j *Janitor) deleteManifestByPolicy(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifests []*processor.GCManifest, m *processor.GCManifest, uid janitorUserIdentity) error {
+	err := j.processor().DeleteManifest(ctx, account, repo, m.Manifest.Digest, keppel.AuditContext{
+		UserIdentity: uid,
+		Request:      janitorDummyRequest,
+	})
+	if err != nil {
+		return err
+	}
+	if account.CascadeDeleteReferrers {
+		processor.MarkReferrersAsDeleted(manifests, m.Manifest.Digest)
+	}
+	return nil
+}
+
+func (j *Janitor) garbageCollectManifestsInRepo(ctx context.Context, repo models.Repository, _ prometheus.Labels) error {
+	err := j.doGarbageCollectManifestsInRepo(ctx, repo)
+	if err != nil {
+		// remember the error, so that it can be reported as an issue on the account
+		_, err2 := j.db.Exec(imageGCRepoFailedQuery, repo.ID, err.Error())
+		if err2 != nil {
+			return fmt.Errorf("%w (additional error when writing error message into DB: %s)", err, err2.Error())
+		}
+	}
+	return err
+}
+
+func (j *Janitor) doGarbageCollectManifestsInRepo(ctx context.Context, repo models.Repository) error {
 	// load GC policies for this repository
 	account, err := keppel.FindAccount(j.db, repo.AccountName)
 	if err != nil {
@@ -69,15 +108,9 @@ func (j *Janitor) garbageCollectManifestsInRepo(ctx context.Context, repo models
 	if err != nil {
 		return fmt.Errorf("cannot load GC policies for account %s: %w", account.Name, err)
 	}
-	var policiesForRepo []keppel.GCPolicy
-	for idx, policy := range policies {
-		err := policy.Validate()
-		if err != nil {
-			return fmt.Errorf("GC policy #%d for account %s is invalid: %w", idx+1, account.Name, err)
-		}
-		if policy.MatchesRepository(repo.Name) {
-			policiesForRepo = append(policiesForRepo, policy)
-		}
+	policiesForRepo, err := j.processor().SelectGCPoliciesForRepo(policies, repo)
+	if err != nil {
+		return err
 	}
 
 	// execute GC policies
@@ -100,174 +133,37 @@ func (j *Janitor) garbageCollectManifestsInRepo(ctx context.Context, repo models
 	return err
 }
 
-type manifestData struct {
-	Manifest      models.Manifest
-	TagNames      []string
-	ParentDigests []string
-	GCStatus      keppel.GCStatus
-	IsDeleted     bool
-}
-
 func (j *Janitor) executeGCPolicies(ctx context.Context, account models.ReducedAccount, repo models.Repository, policies []keppel.GCPolicy) error {
-	// load manifests in repo
-	var dbManifests []models.Manifest
-	_, err := j.db.Select(&dbManifests, `SELECT * FROM manifests WHERE repo_id = $1`, repo.ID)
-	if err != nil {
-		return err
-	}
-
-	// setup a bit of structure to track state in during the policy evaluation
-	var manifests []*manifestData
-	for _, m := range dbManifests {
-		manifests = append(manifests, &manifestData{
-			Manifest: m,
-			GCStatus: keppel.GCStatus{
-				ProtectedByRecentUpload: m.PushedAt.After(j.timeNow().Add(-10 * time.Minute)),
-			},
-			IsDeleted: false,
-		})
-	}
-
-	// load tags (for matching policies on match_tag, except_tag and only_untagged)
-	query := `SELECT digest, name FROM tags WHERE repo_id = $1`
-	err = sqlext.ForeachRow(j.db, query, []any{repo.ID}, func(rows *sql.Rows) error {
-		var (
-			digest  digest.Digest
-			tagName string
-		)
-		err := rows.Scan(&digest, &tagName)
-		if err != nil {
-			return err
-		}
-		for _, m := range manifests {
-			if m.Manifest.Digest == digest {
-				m.TagNames = append(m.TagNames, tagName)
-				break
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-
-	// check manifest-manifest relations to fill GCStatus.ProtectedByManifest
-	query = `SELECT parent_digest, child_digest FROM manifest_manifest_refs WHERE repo_id = $1`
-	err = sqlext.ForeachRow(j.db, query, []any{repo.ID}, func(rows *sql.Rows) error {
-		var (
-			parentDigest string
-			childDigest  digest.Digest
-		)
-		err := rows.Scan(&parentDigest, &childDigest)
-		if err != nil {
-			return err
-		}
-		for _, m := range manifests {
-			if m.Manifest.Digest == childDigest {
-				m.ParentDigests = append(m.ParentDigests, parentDigest)
-				break
-			}
-		}
-		return nil
-	})
+	proc := j.processor()
+	manifests, err := proc.LoadManifestsForGC(repo)
 	if err != nil {
 		return err
 	}
-	for _, m := range manifests {
-		if len(m.ParentDigests) > 0 {
-			sort.Strings(m.ParentDigests) // for deterministic test behavior
-			m.GCStatus.ProtectedByParentManifest = m.ParentDigests[0]
-		}
-	}
-
-	// check if the subject target digest manifest exists
-outer:
-	for _, manifest := range manifests {
-		if manifest.Manifest.SubjectDigest == "" {
-			continue
-		}
-
-		for _, m := range manifests {
-			if m.Manifest.Digest == manifest.Manifest.SubjectDigest {
-				manifest.GCStatus.ProtectedBySubjectManifest = manifest.Manifest.SubjectDigest.String()
-				continue outer
-			}
-		}
-	}
 
 	// evaluate policies in order
-	proc := j.processor()
 	for _, policy := range policies {
-		err := j.evaluatePolicy(ctx, proc, manifests, account, repo, policy)
-		if err != nil {
-			return err
-		}
-	}
-
-	return j.persistGCStatus(manifests, repo.ID)
-}
-
-func (j *Janitor) evaluatePolicy(ctx context.Context, proc *processor.Processor, manifests []*manifestData, account models.ReducedAccount, repo models.Repository, policy keppel.GCPolicy) error {
-	// for some time constraint matches, we need to know which manifests are
-	// still alive
-	var aliveManifests []models.Manifest
-	for _, m := range manifests {
-		if !m.IsDeleted {
-			aliveManifests = append(aliveManifests, m.Manifest)
-		}
-	}
-
-	// evaluate policy for each manifest
-	for _, m := range manifests {
-		// skip those manifests that are already deleted, and those which are
-		// protected by an earlier policy or one of the baseline checks above
-		if m.IsDeleted || m.GCStatus.IsProtected() {
-			continue
-		}
-
-		// track matching "delete" policies in GCStatus to allow users insight
-		// into how policies match
-		if policy.Action == "delete" {
-			m.GCStatus.RelevantPolicies = append(m.GCStatus.RelevantPolicies, policy)
-		}
-
-		// evaluate constraints
-		if !policy.MatchesTags(m.TagNames) {
-			continue
-		}
-		if !policy.MatchesTimeConstraint(m.Manifest, aliveManifests, j.timeNow()) {
-			continue
-		}
-
 		pCopied := policy
-		// execute policy action
-		switch policy.Action {
-		case "protect":
-			m.GCStatus.ProtectedByPolicy = &pCopied
-		case "delete":
-			err := proc.DeleteManifest(ctx, account, repo, m.Manifest.Digest, keppel.AuditContext{
-				UserIdentity: janitorUserIdentity{
-					TaskName: "policy-driven-gc",
-					GCPolicy: &pCopied,
-				},
-				Request: janitorDummyRequest,
+		err := proc.EvaluateGCPolicy(manifests, policy, func(m *processor.GCManifest) error {
+			err := j.deleteManifestByPolicy(ctx, account, repo, manifests, m, janitorUserIdentity{
+				TaskName: "policy-driven-gc",
+				GCPolicy: &pCopied,
 			})
 			if err != nil {
 				return err
 			}
-			m.IsDeleted = true
 			policyJSON, _ := json.Marshal(policy)
 			logg.Info("GC on repo %s: deleted manifest %s because of policy %s", repo.FullName(), m.Manifest.Digest, string(policyJSON))
-		default:
-			// defense in depth: we already did p.Validate() earlier
-			return fmt.Errorf("unexpected GC policy action: %q (why was this not caught by Validate!?)", policy.Action)
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	}
 
-	return nil
+	return j.persistGCStatus(manifests, repo.ID)
 }
 
-func (j *Janitor) persistGCStatus(manifests []*manifestData, repoID int64) error {
+func (j *Janitor) persistGCStatus(manifests []*processor.GCManifest, repoID int64) error {
 	// finalize and persist GCStatus for all affected manifests
 	query := `UPDATE manifests SET gc_status_json = $1 WHERE repo_id = $2 AND digest = $3`
 	err := sqlext.WithPreparedStatement(j.db, query, func(stmt *sql.Stmt) error {
@@ -6,12 +6,14 @@ package tasks
 import (
 	"database/sql"
 	"fmt"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/assert"
 	"github.com/sapcc/go-bits/easypg"
 
 	"github.com/sapcc/keppel/internal/test"
@@ -405,3 +407,171 @@ func TestGCProtectSubject(t *testing.T) {
 		image.Manifest.Digest, subjectManifest.Manifest.Digest, deletingGCPolicyJSON, s.Clock.Now().Add(1*time.Hour).Unix(),
 	)
 }
+
+func TestGCCascadeDeleteReferrers(t *testing.T) {
+	j, s := setup(t)
+
+	image := test.GenerateOCIImage(test.OCIArgs{
+		ConfigMediaType: imgspecv1.MediaTypeImageManifest,
+	})
+	image.MustUpload(t, s, fooRepoRef, "latest")
+
+	subjectManifest := test.GenerateOCIImage(test.OCIArgs{
+		ConfigMediaType: imgspecv1.MediaTypeImageManifest,
+		SubjectDigest:   image.Manifest.Digest,
+	})
+	subjectManifest.MustUpload(t, s, fooRepoRef, "")
+
+	// this policy only matches the tagged image, but the untagged referrer
+	// goes along with it since referrers are deleted together with their subject
+	deletingGCPolicyJSON := `[{"match_repository":".*","match_tag":"latest","action":"delete"}]`
+	mustExec(t, s.DB, `UPDATE accounts SET gc_policies_json = $1, cascade_delete_referrers = TRUE`, deletingGCPolicyJSON)
+
+	// skip an hour to avoid protected_by_recent_upload
+	s.Clock.StepBy(1 * time.Hour)
+
+	garbageJob := j.ManifestGarbageCollectionJob(s.Registry)
+	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
+
+	count, err := s.DB.SelectInt(`SELECT COUNT(*) FROM manifests`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "remaining manifest count", count, int64(0))
+}
+
+func TestGCExceptWithReferrers(t *testing.T) {
+	j, s := setup(t)
+
+	image1 := test.GenerateOCIImage(test.OCIArgs{
+		ConfigMediaType: imgspecv1.MediaTypeImageManifest,
+	})
+	image1.MustUpload(t, s, fooRepoRef, "")
+	image2 := test.GenerateImage(test.GenerateExampleLayer(1))
+	image2.MustUpload(t, s, fooRepoRef, "")
+
+	referrer := test.GenerateOCIImage(test.OCIArgs{
+		ConfigMediaType: imgspecv1.MediaTypeImageManifest,
+		SubjectDigest:   image1.Manifest.Digest,
+	})
+	referrer.MustUpload(t, s, fooRepoRef, "")
+
+	// this policy matches both untagged images, but only the one without referrers is deleted
+	deletingGCPolicyJSON := `[{"match_repository":".*","only_untagged":true,"except_with_referrers":true,"action":"delete"}]`
+	mustExec(t, s.DB, `UPDATE accounts SET gc_policies_json = $1`, deletingGCPolicyJSON)
+
+	// skip an hour to avoid protected_by_recent_upload
+	s.Clock.StepBy(1 * time.Hour)
+
+	garbageJob := j.ManifestGarbageCollectionJob(s.Registry)
+	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
+
+	var remainingDigests []string
+	_, err := s.DB.Select(&remainingDigests, `SELECT digest FROM manifests ORDER BY digest`)
+	mustDo(t, err)
+	expectedDigests := []string{image1.Manifest.Digest.String(), referrer.Manifest.Digest.String()}
+	slices.Sort(expectedDigests)
+	assert.DeepEqual(t, "remaining manifests", remainingDigests, expectedDigests)
+}
+
+func TestGCOnlyArchived(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	garbageJob := j.ManifestGarbageCollectionJob(s.Registry)
+
+	// setup GC policy that only applies to archived repos
+	mustExec(t, s.DB,
+		`UPDATE accounts SET gc_policies_json = $1`,
+		`[{"match_repository":".*","only_archived":true,"only_untagged":true,"action":"delete"}]`,
+	)
+	image := test.GenerateImage(test.GenerateExampleLayer(0))
+	image.MustUpload(t, s, fooRepoRef, "")
+
+	// as long as the repo is not archived, the policy does not match
+	s.Clock.StepBy(2 * time.Hour)
+	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
+	manifestCount, err := s.DB.SelectInt(`SELECT COUNT(*) FROM manifests`)
+	mustDo(t, err)
+	assert.DeepEqual(t, "manifest count", manifestCount, int64(1))
+
+	// once the repo is archived, the untagged image gets deleted
+	mustExec(t, s.DB, `UPDATE repos SET is_archived = TRUE`)
+	s.Clock.StepBy(2 * time.Hour)
+	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
+	manifestCount, err = s.DB.SelectInt(`SELECT COUNT(*) FROM manifests`)
+	mustDo(t, err)
+	assert.DeepEqual(t, "manifest count", manifestCount, int64(0))
+}
+
+func TestGCRepositoryPolicies(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	garbageJob := j.ManifestGarbageCollectionJob(s.Registry)
+
+	// setup GC policy on the account that deletes all untagged images
+	mustExec(t, s.DB,
+		`UPDATE accounts SET gc_policies_json = $1`,
+		`[{"match_repository":".*","only_untagged":true,"action":"delete"}]`,
+	)
+	image := test.GenerateImage(test.GenerateExampleLayer(0))
+	image.MustUpload(t, s, fooRepoRef, "")
+	expectManifestCount := func(expected int64) {
+		t.Helper()
+		s.Clock.StepBy(2 * time.Hour)
+		expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
+		expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
+		manifestCount, err := s.DB.SelectInt(`SELECT COUNT(*) FROM manifests`)
+		mustDo(t, err)
+		assert.DeepEqual(t, "manifest count", manifestCount, expected)
+	}
+
+	// a protecting policy on the repo takes precedence over the account's policies
+	mustExec(t, s.DB,
+		`UPDATE repos SET gc_policies_json = $1`,
+		`[{"match_repository":".*","action":"protect"}]`,
+	)
+	expectManifestCount(1)
+
+	// a repo that ignores inherited policies is not affected by the account's policies at all
+	mustExec(t, s.DB, `UPDATE repos SET gc_policies_json = '', ignore_inherited_gc_policies = TRUE`)
+	expectManifestCount(1)
+
+	// but its own policies still apply
+	mustExec(t, s.DB,
+		`UPDATE repos SET gc_policies_json = $1`,
+		`[{"match_repository":".*","only_untagged":true,"action":"delete"}]`,
+	)
+	expectManifestCount(0)
+}
+
+func TestGCErrorsAreRecorded(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	image := test.GenerateImage(test.GenerateExampleLayer(0))
+	image.MustUpload(t, s, fooRepoRef, "first")
+
+	// GC fails because of broken GC policies
+	mustExec(t, s.DB, `UPDATE accounts SET gc_policies_json = $1`, `[{"match_repository":`)
+	garbageJob := j.ManifestGarbageCollectionJob(s.Registry)
+	tr, _ := easypg.NewTracker(t, s.DB.Db)
+
+	// the error is recorded on the repo, so that it can be shown on the account
+	expectedError := "cannot load GC policies for account test1: unexpected end of JSON input"
+	expectError(t, expectedError, garbageJob.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE repos SET gc_error_message = '%s' WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+		`,
+		expectedError,
+	)
+
+	// once GC succeeds again, the error is cleared
+	mustExec(t, s.DB, `UPDATE accounts SET gc_policies_json = $1`, "")
+	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
+	errorMessage, err := s.DB.SelectStr(`SELECT gc_error_message FROM repos WHERE id = 1`)
+	mustDo(t, err)
+	assert.DeepEqual(t, "gc_error_message", errorMessage, "")
+}
@@ -4,9 +4,11 @@
 package tasks
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
@@ -37,19 +39,28 @@ type Janitor struct {
 	fd      keppel.FederationDriver
 	sd      keppel.StorageDriver
 	icd     keppel.InboundCacheDriver
+	secd    keppel.SecretsDriver
+	bd      keppel.BackupDriver         // may be nil if backups are not configured
+	cdnd    keppel.CDNDriver            // may be nil if no CDN is configured
+	nvd     keppel.NameValidationDriver // may be nil if no naming conventions are enforced
 	db      *keppel.DB
 	amd     keppel.AccountManagementDriver
 	auditor audittools.Auditor
 
+	// for webhooks that are configured by users (see keppel.OutboundRequestPolicy)
+	webhookClient *http.Client
+
 	// non-pure functions that can be replaced by deterministic doubles for unit tests
 	timeNow           func() time.Time
 	generateStorageID func() string
 	addJitter         func(time.Duration) time.Duration
+	lookupTXT         func(ctx context.Context, name string) ([]string, error)
 }
 
 // NewJanitor creates a new Janitor.
-func NewJanitor(cfg keppel.Configuration, fd keppel.FederationDriver, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, db *keppel.DB, amd keppel.AccountManagementDriver, auditor audittools.Auditor) *Janitor {
-	j := &Janitor{cfg, fd, sd, icd, db, amd, auditor, time.Now, keppel.GenerateStorageID, addJitter}
+func NewJanitor(cfg keppel.Configuration, fd keppel.FederationDriver, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, secd keppel.SecretsDriver, bd keppel.BackupDriver, cdnd keppel.CDNDriver, nvd keppel.NameValidationDriver, db *keppel.DB, amd keppel.AccountManagementDriver, auditor audittools.Auditor) *Janitor {
+	webhookClient := cfg.WebhookTargets.NewHTTPClient(webhookTimeout)
+	j := &Janitor{cfg, fd, sd, icd, secd, bd, cdnd, nvd, db, amd, auditor, webhookClient, time.Now, keppel.GenerateStorageID, addJitter, net.DefaultResolver.LookupTXT}
 	return j
 }
 
@@ -65,6 +76,12 @@ func (j *Janitor) OverrideGenerateStorageID(generateStorageID func() string) *Ja
 	return j
 }
 
+// OverrideLookupTXT replaces DNS lookups of TXT records with a test double.
+func (j *Janitor) OverrideLookupTXT(lookupTXT func(ctx context.Context, name string) ([]string, error)) *Janitor {
+	j.lookupTXT = lookupTXT
+	return j
+}
+
 // DisableJitter replaces addJitter with a no-op for this Janitor.
 func (j *Janitor) DisableJitter() {
 	j.addJitter = func(d time.Duration) time.Duration { return d }
@@ -81,7 +98,7 @@ func addJitter(duration time.Duration) time.Duration {
 }
 
 func (j *Janitor) processor() *processor.Processor {
-	return processor.New(j.cfg, j.db, j.sd, j.icd, j.auditor, j.fd, j.timeNow).OverrideTimeNow(j.timeNow).OverrideGenerateStorageID(j.generateStorageID)
+	return processor.New(j.cfg, j.db, j.sd, j.icd, j.secd, j.auditor, j.fd, j.nvd, j.timeNow).OverrideTimeNow(j.timeNow).OverrideGenerateStorageID(j.generateStorageID)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -90,8 +107,9 @@ func (j *Janitor) processor() *processor.Processor {
 // janitorUserIdentity is a keppel.UserIdentity for the janitor user. It is only
 // used for generating audit events and issuing tokens for internal services like Trivy.
 type janitorUserIdentity struct {
-	TaskName string
-	GCPolicy *keppel.GCPolicy
+	TaskName           string
+	GCPolicy           *keppel.GCPolicy
+	TagRetentionPolicy *keppel.TagRetentionPolicy
 }
 
 // PluginTypeID implements the keppel.UserIdentity interface.
@@ -121,7 +139,7 @@ func (uid janitorUserIdentity) UserInfo() audittools.UserInfo {
 
 // SerializeToJSON implements the keppel.UserIdentity interface.
 func (uid janitorUserIdentity) SerializeToJSON() (payload []byte, err error) {
-	if uid.GCPolicy != nil {
+	if uid.GCPolicy != nil || uid.TagRetentionPolicy != nil {
 		return nil, errors.New("janitorUserIdentity.SerializeToJSON is not allowed")
 	}
 	return json.Marshal(uid.TaskName)
@@ -139,8 +157,9 @@ func (uid janitorUserIdentity) DeserializeFromJSON(in []byte, ad keppel.AuthDriv
 // keppel-janitor (who does not have a corresponding OpenStack user). It can be
 // used via `type JanitorUserIdentity`.
 type janitorUserInfo struct {
-	TaskName string
-	GCPolicy *keppel.GCPolicy
+	TaskName           string
+	GCPolicy           *keppel.GCPolicy
+	TagRetentionPolicy *keppel.TagRetentionPolicy
 }
 
 // AsInitiator implements the audittools.NonStandardUserInfo interface.
@@ -159,5 +178,13 @@ func (u janitorUserInfo) AsInitiator(_ cadf.Host) cadf.Resource {
 			Content: string(gcPolicyJSON),
 		})
 	}
+	if u.TagRetentionPolicy != nil {
+		policyJSON, _ := json.Marshal(*u.TagRetentionPolicy)
+		res.Attachments = append(res.Attachments, cadf.Attachment{
+			Name:    "tag-retention-policy",
+			TypeURI: "mime:application/json",
+			Content: string(policyJSON),
+		})
+	}
 	return res
 }
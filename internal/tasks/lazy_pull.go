@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	imageManifest "github.com/containers/image/v5/manifest"
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+const lazyPullVariantRetryInterval = 6 * time.Hour
+
+// Image manifests that have a subject are skipped. This covers the variants
+// generated by this job, as well as signatures, SBOMs etc.
+var lazyPullVariantSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT m.* FROM manifests m
+	  JOIN repos r ON r.id = m.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	  LEFT OUTER JOIN lazy_pull_variants v ON v.repo_id = m.repo_id AND v.digest = m.digest AND v.format = a.lazy_pull_format
+	 WHERE a.lazy_pull_format != '' AND NOT a.is_deleting
+	   AND m.media_type IN ($2, $3) AND m.subject_digest = '' AND m.quarantined_at IS NULL
+	   AND (v.digest IS NULL OR v.next_attempt_at < $1)
+	-- new manifests first, then retries sorted by their schedule
+	ORDER BY v.next_attempt_at IS NULL DESC, v.next_attempt_at ASC, m.pushed_at ASC
+	LIMIT 1 -- one at a time
+`)
+
+var lazyPullVariantUpsertQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO lazy_pull_variants (repo_id, digest, format, variant_digest, generated_at, error_message, next_attempt_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (repo_id, digest, format) DO UPDATE SET
+		variant_digest = EXCLUDED.variant_digest, generated_at = EXCLUDED.generated_at,
+		error_message = EXCLUDED.error_message, next_attempt_at = EXCLUDED.next_attempt_at
+`)
+
+// LazyPullVariantJob is a job. Each task finds an image manifest in an account
+// with a LazyPullFormat that does not have a variant in that format yet, and
+// generates it. The variant is stored in the same repository as a referrer of
+// the original manifest. If generation fails, it is retried after some time.
+func (j *Janitor) LazyPullVariantJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.Manifest]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "generate lazy-pulling variants of images",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_lazy_pull_variant_generations",
+				Help: "Counter for generations of lazy-pulling variants of images.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (manifest models.Manifest, err error) {
+			err = j.db.SelectOne(&manifest, lazyPullVariantSearchQuery, j.timeNow(),
+				imageManifest.DockerV2Schema2MediaType, imagespecs.MediaTypeImageManifest)
+			return manifest, err
+		},
+		ProcessTask: j.generateLazyPullVariant,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) generateLazyPullVariant(ctx context.Context, manifest models.Manifest, _ prometheus.Labels) error {
+	var repo models.Repository
+	err := j.db.SelectOne(&repo, `SELECT * FROM repos WHERE id = $1`, manifest.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("cannot find repo %d for manifest %s: %w", manifest.RepositoryID, manifest.Digest, err)
+	}
+	account, err := keppel.FindAccount(j.db, repo.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), manifest.Digest, err)
+	}
+	if account == nil {
+		return fmt.Errorf("account %q not found", repo.AccountName)
+	}
+
+	variant := models.LazyPullVariant{
+		RepositoryID: repo.ID,
+		Digest:       manifest.Digest,
+		Format:       account.LazyPullFormat,
+	}
+	var genErr error
+	switch account.LazyPullFormat {
+	case models.LazyPullFormatEstargz:
+		variant.VariantDigest, genErr = j.processor().GenerateEstargzVariant(ctx, account.Reduced(), repo, manifest, keppel.AuditContext{
+			UserIdentity: janitorUserIdentity{TaskName: "lazy-pull-variant"},
+			Request:      janitorDummyRequest,
+		})
+	default:
+		genErr = fmt.Errorf("unsupported lazy pull format %q", account.LazyPullFormat)
+	}
+
+	variant.GeneratedAt = j.timeNow()
+	if genErr != nil {
+		variant.ErrorMessage = genErr.Error()
+		nextAttemptAt := j.timeNow().Add(j.addJitter(lazyPullVariantRetryInterval))
+		variant.NextAttemptAt = &nextAttemptAt
+	}
+	_, err = j.db.Exec(lazyPullVariantUpsertQuery,
+		variant.RepositoryID, variant.Digest, variant.Format, variant.VariantDigest,
+		variant.GeneratedAt, variant.ErrorMessage, variant.NextAttemptAt,
+	)
+	if genErr != nil {
+		if err != nil {
+			genErr = fmt.Errorf("%w (additional error encountered while recording generation error: %w)", genErr, err)
+		}
+		return fmt.Errorf("while generating %s variant of manifest %s/%s: %w", variant.Format, repo.FullName(), manifest.Digest, genErr)
+	}
+	return err
+}
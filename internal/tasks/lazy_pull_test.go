@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	imageManifest "github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func generateTarLayer(t *testing.T, fileContents string) test.Bytes {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	mustDo(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "etc/motd", Mode: 0o644, Size: int64(len(fileContents))}))
+	_, err := tw.Write([]byte(fileContents))
+	mustDo(t, err)
+	mustDo(t, tw.Close())
+	mustDo(t, gz.Close())
+	return test.Bytes{
+		Contents:  buf.Bytes(),
+		Digest:    digest.Canonical.FromBytes(buf.Bytes()),
+		MediaType: imageManifest.DockerV2Schema2LayerMediaType,
+	}
+}
+
+func TestLazyPullVariantJob(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	job := j.LazyPullVariantJob(s.Registry)
+
+	image := test.GenerateImage(generateTarLayer(t, "hello world\n"))
+	image.MustUpload(t, s, fooRepoRef, "latest")
+
+	// nothing happens while the account has not opted in
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+
+	// once opted in, the image gets converted exactly once
+	mustExec(t, s.DB, `UPDATE accounts SET lazy_pull_format = $1`, models.LazyPullFormatEstargz)
+	s.Clock.StepBy(1 * time.Minute)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+
+	var variant models.LazyPullVariant
+	mustDo(t, s.DB.SelectOne(&variant, `SELECT * FROM lazy_pull_variants WHERE digest = $1`, image.Manifest.Digest))
+	assert.DeepEqual(t, "error message", variant.ErrorMessage, "")
+	assert.DeepEqual(t, "next attempt", variant.NextAttemptAt, (*time.Time)(nil))
+
+	// the variant is a referrer of the original image, and has eStargz layers
+	var variantManifest models.Manifest
+	mustDo(t, s.DB.SelectOne(&variantManifest, `SELECT * FROM manifests WHERE digest = $1`, variant.VariantDigest))
+	assert.DeepEqual(t, "variant subject", variantManifest.SubjectDigest, image.Manifest.Digest)
+	account, err := keppel.FindReducedAccount(s.DB, "test1")
+	mustDo(t, err)
+	variantBytes, err := s.SD.ReadManifest(s.Ctx, *account, "foo", variant.VariantDigest)
+	mustDo(t, err)
+	var parsed imagespecs.Manifest
+	mustDo(t, json.Unmarshal(variantBytes, &parsed))
+	assert.DeepEqual(t, "variant layer count", len(parsed.Layers), 1)
+	if parsed.Layers[0].Annotations[keppel.EstargzTOCDigestAnnotation] == "" {
+		t.Errorf("expected TOC digest annotation on variant layer, but got %#v", parsed.Layers[0].Annotations)
+	}
+
+	// layers that are not tar archives cannot be converted, and are retried later
+	brokenImage := test.GenerateImage(test.GenerateExampleLayer(1))
+	brokenImage.MustUpload(t, s, fooRepoRef, "broken")
+	err = job.ProcessOne(s.Ctx)
+	if err == nil || !strings.Contains(err.Error(), "while reading input tar") {
+		t.Errorf("expected conversion error, but got %v", err)
+	}
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+	mustDo(t, s.DB.SelectOne(&variant, `SELECT * FROM lazy_pull_variants WHERE digest = $1`, brokenImage.Manifest.Digest))
+	assert.DeepEqual(t, "next attempt", variant.NextAttemptAt.Unix(), s.Clock.Now().Add(lazyPullVariantRetryInterval).Unix())
+
+	s.Clock.StepBy(lazyPullVariantRetryInterval + time.Minute)
+	err = job.ProcessOne(s.Ctx)
+	if err == nil || !strings.Contains(err.Error(), "while reading input tar") {
+		t.Errorf("expected conversion error, but got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var manifestVerificationSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT m.* FROM manifests m
+		JOIN repos r ON m.repo_id = r.id
+		JOIN accounts a ON r.account_name = a.name
+		WHERE a.external_peer_verify_only AND m.verified_at IS NULL
+		  AND (m.next_verification_at IS NULL OR m.next_verification_at < $1)
+	-- manifests without failed verifications first, then sorted by next attempt
+	ORDER BY m.next_verification_at ASC NULLS FIRST, m.pushed_at ASC, m.digest ASC
+	-- only one manifest at a time
+	LIMIT 1
+`)
+
+var manifestVerificationFailedQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET next_verification_at = $1, verification_error_message = $2 WHERE repo_id = $3 AND digest = $4
+`)
+
+// ManifestVerificationJob is a job. Each task takes a manifest in a replica
+// account with ExternalPeerVerifyOnly that has not been verified yet, and
+// verifies its digest chain (see processor.VerifyManifestChain). Until that
+// has succeeded, the manifest and its blobs are not served to clients.
+func (j *Janitor) ManifestVerificationJob(registerer prometheus.Registerer) jobloop.Job { //nolint:dupl // interface implementation of different things
+	return (&jobloop.ProducerConsumerJob[models.Manifest]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "manifest verification",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_manifest_verifications",
+				Help: "Counter for digest chain verifications of manifests replicated from untrusted upstreams.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (manifest models.Manifest, err error) {
+			err = j.db.SelectOne(&manifest, manifestVerificationSearchQuery, j.timeNow())
+			return manifest, err
+		},
+		ProcessTask: j.verifyManifest,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) verifyManifest(ctx context.Context, manifest models.Manifest, _ prometheus.Labels) error {
+	// find corresponding account and repo
+	var repo models.Repository
+	err := j.db.SelectOne(&repo, `SELECT * FROM repos WHERE id = $1`, manifest.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("cannot find repo %d for manifest %s: %w", manifest.RepositoryID, manifest.Digest, err)
+	}
+	account, err := keppel.FindReducedAccount(j.db, repo.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), manifest.Digest, err)
+	}
+
+	// on success, the attestation is recorded by VerifyManifestChain
+	err = j.processor().VerifyManifestChain(ctx, *account, repo, &manifest)
+	if err != nil {
+		// on failure, record the error message for the registry API and try again later
+		_, updateErr := j.db.Exec(manifestVerificationFailedQuery,
+			j.timeNow().Add(j.addJitter(models.ManifestVerificationAfterErrorInterval)),
+			err.Error(), repo.ID, manifest.Digest,
+		)
+		if updateErr != nil {
+			err = fmt.Errorf("%w (additional error encountered while recording verification error: %w)", err, updateErr)
+		}
+		return fmt.Errorf("while verifying manifest %s/%s: %w", repo.FullName(), manifest.Digest, err)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"compress/gzip"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -19,6 +20,7 @@ import (
 
 	imageManifest "github.com/containers/image/v5/manifest"
 	"github.com/go-gorp/gorp/v3"
+	"github.com/lib/pq"
 	"github.com/opencontainers/go-digest"
 	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/prometheus/client_golang/prometheus"
@@ -130,7 +132,15 @@ var syncManifestEnumerateRefsQuery = sqlext.SimplifyWhitespace(`
 `)
 
 var syncManifestDoneQuery = sqlext.SimplifyWhitespace(`
-	UPDATE repos SET next_manifest_sync_at = $2 WHERE id = $1
+	UPDATE repos SET next_manifest_sync_at = $2, manifest_sync_error_message = '' WHERE id = $1
+`)
+
+var syncManifestFailedQuery = sqlext.SimplifyWhitespace(`
+	UPDATE repos SET manifest_sync_error_message = $2 WHERE id = $1
+`)
+
+var syncPoliciesDoneQuery = sqlext.SimplifyWhitespace(`
+	UPDATE accounts SET next_policy_sync_at = $2 WHERE name = $1
 `)
 
 var syncManifestCleanupEmptyQuery = sqlext.SimplifyWhitespace(`
@@ -140,7 +150,9 @@ var syncManifestCleanupEmptyQuery = sqlext.SimplifyWhitespace(`
 // ManifestSyncJob is a job. Each task finds a repository in a replica account where
 // manifests have not been synced for more than an hour, and syncs its manifests.
 // Syncing involves checking with the primary account which manifests have been
-// deleted there, and replicating the deletions on our side.
+// deleted there, and replicating the deletions on our side. For accounts that
+// have opted into it, RBAC policies and GC policies are also synced from the
+// primary account.
 func (j *Janitor) ManifestSyncJob(registerer prometheus.Registerer) jobloop.Job { //nolint:dupl // false positive
 	return (&jobloop.ProducerConsumerJob[models.Repository]{
 		Metadata: jobloop.JobMetadata{
@@ -159,6 +171,40 @@ func (j *Janitor) ManifestSyncJob(registerer prometheus.Registerer) jobloop.Job
 }
 
 func (j *Janitor) syncManifestsInReplicaRepo(ctx context.Context, repo models.Repository, _ prometheus.Labels) error {
+	err := j.doSyncManifestsInReplicaRepo(ctx, repo)
+	if err != nil {
+		// remember the error, so that it can be reported as an issue on the account
+		_, err2 := j.db.Exec(syncManifestFailedQuery, repo.ID, err.Error())
+		if err2 != nil {
+			return fmt.Errorf("%w (additional error when writing error message into DB: %s)", err, err2.Error())
+		}
+	}
+	return err
+}
+
+// Policy sync runs as part of manifest sync, but only once per account per
+// hour instead of once per repo to avoid hammering the primary account's peer.
+func (j *Janitor) syncPoliciesIfDue(ctx context.Context, account models.Account, actx keppel.AuditContext) error {
+	if !account.SyncPoliciesFromPrimary || account.PoliciesOverriddenLocally {
+		return nil
+	}
+	if account.NextPolicySyncAt != nil && account.NextPolicySyncAt.After(j.timeNow()) {
+		return nil
+	}
+
+	err := j.processor().SyncPoliciesFromPrimaryAccount(ctx, account, actx)
+	nextSyncAt := j.timeNow().Add(j.addJitter(1 * time.Hour))
+	if err != nil {
+		nextSyncAt = j.timeNow().Add(j.addJitter(5 * time.Minute))
+	}
+	_, err2 := j.db.Exec(syncPoliciesDoneQuery, account.Name, nextSyncAt)
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (j *Janitor) doSyncManifestsInReplicaRepo(ctx context.Context, repo models.Repository) error {
 	// find corresponding account
 	account, err := keppel.FindAccount(j.db, repo.AccountName)
 	if err != nil {
@@ -167,6 +213,15 @@ func (j *Janitor) syncManifestsInReplicaRepo(ctx context.Context, repo models.Re
 
 	// do not perform manifest sync while account is in deletion (deletion mode blocks all kinds of replication)
 	if !account.IsDeleting {
+		actx := keppel.AuditContext{
+			UserIdentity: janitorUserIdentity{TaskName: "manifest-sync"},
+			Request:      janitorDummyRequest,
+		}
+		err = j.syncPoliciesIfDue(ctx, *account, actx)
+		if err != nil {
+			return fmt.Errorf("while syncing policies into account %s: %w", account.Name, err)
+		}
+
 		syncPayload, err := j.getReplicaSyncPayload(ctx, *account, repo)
 		if err != nil {
 			return err
@@ -438,7 +493,7 @@ func (j *Janitor) collectManifestLayerBlobs(ctx context.Context, account models.
 	}
 
 	// we only care about blobs that are image layers; the manifest tells us which blobs are layers
-	manifestBytes, err := j.sd.ReadManifest(ctx, account, repo.Name, manifest.Digest)
+	manifestBytes, err := keppel.ReadManifestFromStorage(ctx, j.sd, j.secd, account, repo.Name, manifest.Digest)
 	if err != nil {
 		return nil, err
 	}
@@ -528,8 +583,9 @@ func (j *Janitor) processTrivySecurityInfo(ctx context.Context, tx *gorp.Transac
 	}
 
 	type chanReturnStruct struct {
-		securityInfo models.TrivySecurityInfo
-		err          error
+		securityInfo   models.TrivySecurityInfo
+		previousStatus models.VulnerabilityStatus
+		err            error
 	}
 
 	// create a channel the size of the threads we are going to spawn to not deadlock when ranging over it
@@ -545,10 +601,12 @@ func (j *Janitor) processTrivySecurityInfo(ctx context.Context, tx *gorp.Transac
 
 			// inputChan acts as a queue here and each go routine picks the next SecurityInfo task when it is done with the previous
 			for securityInfo := range inputChan {
+				previousStatus := securityInfo.VulnerabilityStatus
 				err := j.doSecurityCheck(ctx, &securityInfo)
 				returnChan <- chanReturnStruct{
-					securityInfo: securityInfo,
-					err:          err,
+					securityInfo:   securityInfo,
+					previousStatus: previousStatus,
+					err:            err,
 				}
 			}
 		}()
@@ -568,6 +626,10 @@ func (j *Janitor) processTrivySecurityInfo(ctx context.Context, tx *gorp.Transac
 
 		_, err := tx.Update(&returned.securityInfo)
 		errs.Add(err)
+
+		if returned.securityInfo.VulnerabilityStatus != returned.previousStatus {
+			errs.Add(j.notifyVulnerabilityStatusChange(tx, returned.securityInfo))
+		}
 	}
 
 	errs.Add(tx.Commit())
@@ -579,6 +641,29 @@ func (j *Janitor) processTrivySecurityInfo(ctx context.Context, tx *gorp.Transac
 	return nil
 }
 
+// Enqueues a webhook notification for a manifest whose vulnerability status
+// was changed by CheckTrivySecurityStatusJob.
+func (j *Janitor) notifyVulnerabilityStatusChange(tx *gorp.Transaction, securityInfo models.TrivySecurityInfo) error {
+	repo, err := keppel.FindRepositoryByID(tx, securityInfo.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("cannot find repo for manifest %s: %w", securityInfo.Digest, err)
+	}
+	manifest, err := keppel.FindManifest(tx, *repo, securityInfo.Digest)
+	if err != nil {
+		return fmt.Errorf("cannot find manifest %s@%s: %w", repo.FullName(), securityInfo.Digest, err)
+	}
+	now := j.timeNow()
+	return keppel.EnqueueWebhookNotification(tx, keppel.WebhookNotification{
+		Event:               keppel.WebhookEventVulnerabilityScan,
+		Account:             repo.AccountName,
+		Repository:          repo.Name,
+		Digest:              manifest.Digest,
+		MediaType:           manifest.MediaType,
+		VulnerabilityStatus: securityInfo.VulnerabilityStatus,
+		Timestamp:           now.Unix(),
+	}, now)
+}
+
 // NOTE: The `repo_id` match in the various JOIN and WHERE clauses is technically a bit redundant,
 // but having this allows us to use foreign-key indices for all joins to get a nice performance boost.
 var securityInfoCheckSubmanifestInfoQuery = sqlext.SimplifyWhitespace(`
@@ -588,6 +673,19 @@ var securityInfoCheckSubmanifestInfoQuery = sqlext.SimplifyWhitespace(`
 		WHERE r.repo_id = $1 AND r.parent_digest = $2
 `)
 
+// Counts the given layers that Trivy has fetched at some point. Since Trivy
+// only fetches layers that are not in its cache yet, this is how far the
+// Trivy server got with analyzing an image whose scans keep timing out.
+var trivyFetchedLayerCountQuery = `SELECT COUNT(*) FROM blobs WHERE id = ANY($1) AND trivy_fetched_at IS NOT NULL`
+
+func blobIDsOf(blobs []models.Blob) []int64 {
+	ids := make([]int64, len(blobs))
+	for idx, blob := range blobs {
+		ids[idx] = blob.ID
+	}
+	return ids
+}
+
 var trivyTransientErrorsRxs = []*regexp.Regexp{
 	regexp.MustCompile(`connect: connection refused$`),
 	regexp.MustCompile(`i/o timeout$`),
@@ -619,10 +717,14 @@ func (j *Janitor) doSecurityCheck(ctx context.Context, securityInfo *models.Triv
 		return fmt.Errorf("cannot find manifest for repo %s and digest %s: %w", repo.FullName(), securityInfo.Digest, err)
 	}
 
+	// this needs to be computed before the timing information from the previous check gets cleared
+	scanTimeout, maxScanTimeout := j.trivyScanTimeoutFor(*securityInfo)
+
 	// clear timing information (this will be filled down below once we actually talk to Trivy;
 	// if any preflight check fails, the fields stay at nil)
 	securityInfo.CheckedAt = nil
 	securityInfo.CheckDurationSecs = nil
+	securityInfo.ScannedLayerCount = nil
 
 	// skip validation while account is in maintenance (maintenance mode blocks
 	// all kinds of activity on an account's contents)
@@ -656,6 +758,31 @@ func (j *Janitor) doSecurityCheck(ctx context.Context, securityInfo *models.Triv
 			return
 		}
 
+		// for timeouts, we record the timeout that was exceeded as the check
+		// duration, so that the next attempt can allow for a longer scan; once we
+		// have reached the maximum timeout, there is no point in retrying quickly
+		if errors.Is(returnedError, trivy.ErrScanTimedOut) {
+			checkFinishedAt := j.timeNow()
+			securityInfo.CheckedAt = &checkFinishedAt
+			duration := scanTimeout.Seconds()
+			securityInfo.CheckDurationSecs = &duration
+			securityInfo.Message = returnedError.Error()
+			securityInfo.VulnerabilityStatus = models.TimedOutVulnerabilityStatus
+			scannedLayerCount, err := j.db.SelectInt(trivyFetchedLayerCountQuery, pq.Array(blobIDsOf(layerBlobs)))
+			if err == nil {
+				securityInfo.ScannedLayerCount = &scannedLayerCount
+			} else {
+				logg.Error("cannot count layers of %s@%s that were fetched by Trivy: %s", repo.FullName(), securityInfo.Digest, err.Error())
+			}
+			if scanTimeout < maxScanTimeout {
+				securityInfo.NextCheckAt = j.timeNow().Add(j.addJitter(5 * time.Minute))
+			} else {
+				securityInfo.NextCheckAt = j.timeNow().Add(j.addJitter(6 * time.Hour))
+			}
+			returnedError = fmt.Errorf("cannot check manifest %s@%s: %w", repo.FullName(), securityInfo.Digest, returnedError)
+			return
+		}
+
 		// retry in a bit again but only write down the error if it is not transient
 		securityInfo.NextCheckAt = j.timeNow().Add(j.addJitter(5 * time.Minute))
 
@@ -673,27 +800,31 @@ func (j *Janitor) doSecurityCheck(ctx context.Context, securityInfo *models.Triv
 		Reference: models.ManifestReference{Digest: manifest.Digest},
 	}
 
-	tokenResp, err := auth.IssueTokenForTrivy(j.cfg, repo.FullName())
-	if err != nil {
-		return err
-	}
-
 	// ask Trivy for the security status of the manifest
 	securityInfo.Message = "" // unless it gets set to something else below
 
-	// Trivy has an internal timeout we set to 10m per image (which is already an
-	// insanely generous timeout) and we give it a bit of headroom to start
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute+30*time.Second)
+	// Trivy enforces the scan timeout internally, and we give it a bit of headroom to start
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout+30*time.Second)
 	defer cancel()
 
 	var securityStatuses []models.VulnerabilityStatus
 
 	if len(layerBlobs) > 0 {
-		parsedTrivyReport, err := j.cfg.Trivy.ScanManifestAndParse(ctx, tokenResp.Token, imageRef)
+		parsedTrivyReport, err := j.obtainTrivyReport(ctx, *account, *repo, imageRef, scanTimeout)
 		if err != nil {
 			return fmt.Errorf("scan error: %w", err)
 		}
 
+		// keep the report around for replicas of this account (see peer API)
+		reportJSON, err := json.Marshal(parsedTrivyReport)
+		if err != nil {
+			return err
+		}
+		err = keppel.StoreTrivyReport(j.db, repo.ID, manifest.Digest, reportJSON)
+		if err != nil {
+			return fmt.Errorf("cannot store Trivy report: %w", err)
+		}
+
 		if parsedTrivyReport.Metadata.IsRotten() {
 			securityStatuses = append(securityStatuses, models.RottenVulnerabilityStatus)
 		}
@@ -735,6 +866,74 @@ func (j *Janitor) doSecurityCheck(ctx context.Context, securityInfo *models.Triv
 	return nil
 }
 
+// Replicas do not need to scan manifests that the primary account has
+// scanned recently. When a primary-side report is older than this, we scan
+// locally instead.
+const trivyPeerReportMaxAge = 2 * time.Hour
+
+// Returns the timeout for the next Trivy scan of the given image, as well as
+// the maximum timeout. When the previous scan timed out, the timeout is
+// doubled (up to the maximum). This is worth it because the Trivy server
+// caches the analysis results for each layer that it has scanned, so a timed
+// out scan is not completely wasted: The next attempt only needs to analyze
+// the layers that the previous attempt did not get to.
+func (j *Janitor) trivyScanTimeoutFor(securityInfo models.TrivySecurityInfo) (timeout, maxTimeout time.Duration) {
+	initial, maxTimeout := j.cfg.Trivy.EffectiveScanTimeouts()
+	if securityInfo.VulnerabilityStatus != models.TimedOutVulnerabilityStatus || securityInfo.CheckDurationSecs == nil {
+		return initial, maxTimeout
+	}
+	previous := time.Duration(*securityInfo.CheckDurationSecs * float64(time.Second))
+	return min(max(initial, 2*previous), maxTimeout), maxTimeout
+}
+
+// Obtains the Trivy report for the given manifest. In replica accounts, we
+// first try to reuse the report from the primary account to reduce the load on
+// Trivy. If that is not possible, the manifest is scanned locally.
+func (j *Janitor) obtainTrivyReport(ctx context.Context, account models.Account, repo models.Repository, imageRef models.ImageReference, scanTimeout time.Duration) (trivy.Report, error) {
+	if account.UpstreamPeerHostName != "" {
+		report, err := j.getTrivyReportFromPeer(ctx, account, repo, imageRef.Reference.Digest)
+		if err != nil {
+			logg.Error("cannot obtain Trivy report for %s@%s from peer %s (falling back to local scan): %s",
+				repo.FullName(), imageRef.Reference.Digest, account.UpstreamPeerHostName, err.Error())
+		}
+		if report != nil {
+			return *report, nil
+		}
+	}
+
+	tokenResp, err := auth.IssueTokenForTrivy(j.cfg, repo.FullName(), scanTimeout)
+	if err != nil {
+		return trivy.Report{}, err
+	}
+	return j.cfg.Trivy.ScanManifestAndParse(ctx, tokenResp.Token, imageRef, scanTimeout)
+}
+
+// Returns (nil, nil) if the peer does not have a sufficiently fresh report.
+func (j *Janitor) getTrivyReportFromPeer(ctx context.Context, account models.Account, repo models.Repository, manifestDigest digest.Digest) (*trivy.Report, error) {
+	var peer models.Peer
+	err := j.db.SelectOne(&peer, `SELECT * FROM peers WHERE hostname = $1`, account.UpstreamPeerHostName)
+	if err != nil {
+		return nil, err
+	}
+	client, err := peerclient.New(ctx, j.cfg, peer, auth.PeerAPIScope)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := client.GetTrivyReport(ctx, repo.FullName(), manifestDigest)
+	if err != nil || payload == nil {
+		return nil, err
+	}
+	if j.timeNow().Sub(time.Unix(payload.CheckedAt, 0)) > trivyPeerReportMaxAge {
+		return nil, nil
+	}
+
+	report, err := trivy.UnmarshalReportFromJSON(payload.Report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
 var blobUncompressedSizeTooBigGiB float64 = 10
 
 func (j *Janitor) checkPreConditionsForTrivy(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifest models.Manifest, securityInfo *models.TrivySecurityInfo) (continueCheck bool, layerBlobs []models.Blob, err error) {
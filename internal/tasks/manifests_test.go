@@ -286,16 +286,23 @@ func TestManifestSyncJob(t *testing.T) {
 			// timestamps into the primary, i.e. primary.last_pulled_at =
 			// max(primary.last_pulled_at, replica.last_pulled_at); this only touches
 			// the DB when the replica's last_pulled_at is after the primary's
+			// (also, the primary remembers which manifests the replica has)
 			if strategy == "on_first_use" {
 				trForPrimary.DBChanges().AssertEqualf(`
 						UPDATE manifests SET last_pulled_at = %[1]d WHERE repo_id = 1 AND digest = '%[2]s';
 						UPDATE manifests SET last_pulled_at = %[3]d WHERE repo_id = 1 AND digest = '%[4]s';
+						INSERT INTO replicated_manifests (repo_id, digest, peer_hostname) VALUES (1, '%[2]s', 'registry-secondary.example.org');
+						INSERT INTO replicated_manifests (repo_id, digest, peer_hostname) VALUES (1, '%[4]s', 'registry-secondary.example.org');
+						INSERT INTO replicated_manifests (repo_id, digest, peer_hostname) VALUES (1, '%[5]s', 'registry-secondary.example.org');
+						INSERT INTO replicated_manifests (repo_id, digest, peer_hostname) VALUES (1, '%[6]s', 'registry-secondary.example.org');
 						UPDATE tags SET last_pulled_at = %[3]d WHERE repo_id = 1 AND name = 'other';
 					`,
 					initialLastPulledAt.Unix(),
 					images[3].Manifest.Digest,
 					laterLastPulledAt.Unix(),
 					images[2].Manifest.Digest,
+					imageList.Manifest.Digest,
+					images[1].Manifest.Digest,
 				)
 				// reset all timestamps to prevent divergences in the rest of the test
 				mustExec(t, s1.DB, `UPDATE manifests SET last_pulled_at = $1`, initialLastPulledAt)
@@ -428,8 +435,9 @@ func TestManifestSyncJob(t *testing.T) {
 			if strategy == "on_first_use" {
 				manifestValidationBecauseOfExistingTag = ""
 			}
-			tr.DBChanges().AssertEqualf(`%sDELETE FROM tags WHERE repo_id = 1 AND name = 'latest';`,
-				manifestValidationBecauseOfExistingTag,
+			tr.DBChanges().AssertEqualf(`%sUPDATE repos SET manifest_sync_error_message = '%s' WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+DELETE FROM tags WHERE repo_id = 1 AND name = 'latest';`,
+				manifestValidationBecauseOfExistingTag, expectedError,
 			)
 
 			// also remove the image list manifest on the primary side
@@ -454,7 +462,7 @@ func TestManifestSyncJob(t *testing.T) {
 					DELETE FROM manifest_manifest_refs WHERE repo_id = 1 AND parent_digest = '%[2]s' AND child_digest = '%[1]s';
 					DELETE FROM manifests WHERE repo_id = 1 AND digest = '%[1]s';
 					DELETE FROM manifests WHERE repo_id = 1 AND digest = '%[2]s';
-					UPDATE repos SET next_manifest_sync_at = %[4]d WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+					UPDATE repos SET next_manifest_sync_at = %[4]d, manifest_sync_error_message = '' WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
 					DELETE FROM tags WHERE repo_id = 1 AND name = 'other';
 					DELETE FROM trivy_security_info WHERE repo_id = 1 AND digest = '%[1]s';
 					DELETE FROM trivy_security_info WHERE repo_id = 1 AND digest = '%[2]s';
@@ -482,7 +490,9 @@ func TestManifestSyncJob(t *testing.T) {
 				images[1].Manifest.Digest, // the only manifest that is left
 			)
 			expectError(t, expectedError, syncManifestsJob2.ProcessOne(s2.Ctx))
-			tr.DBChanges().AssertEmpty()
+			tr.DBChanges().AssertEqualf(`UPDATE repos SET manifest_sync_error_message = '%s' WHERE id = 1 AND account_name = 'test1' AND name = 'foo';`,
+				expectedError,
+			)
 
 			// check that the manifest sync did not update the last_pulled_at timestamps
 			// in the primary DB (even though there were GET requests for the manifests
@@ -529,6 +539,132 @@ func TestManifestSyncJob(t *testing.T) {
 	})
 }
 
+func TestManifestSyncJobWithPolicySync(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		_, s1 := setup(t)
+		j2, s2 := setupReplica(t, s1, "on_first_use")
+		s1.Clock.StepBy(1 * time.Hour)
+		syncManifestsJob2 := j2.ManifestSyncJob(s2.Registry)
+
+		gcPoliciesJSON := `[{"match_repository":".*","only_untagged":true,"action":"delete"}]`
+		rbacPoliciesJSON := `[{"match_repository":"library/.*","permissions":["anonymous_pull"]}]`
+		mustExec(t, s1.DB, `UPDATE accounts SET gc_policies_json = $1, rbac_policies_json = $2`, gcPoliciesJSON, rbacPoliciesJSON)
+		tr, _ := easypg.NewTracker(t, s2.DB.Db)
+
+		// without the opt-in, policies are not synced
+		expectSuccess(t, syncManifestsJob2.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				DELETE FROM repos WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`)
+
+		// with the opt-in, policies are copied from the primary
+		mustExec(t, s2.DB, `UPDATE accounts SET sync_policies_from_primary = TRUE`)
+		mustExec(t, s2.DB, `INSERT INTO repos (id, account_name, name) VALUES (1, 'test1', 'foo')`)
+		tr.DBChanges().Ignore()
+		expectSuccess(t, syncManifestsJob2.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE accounts SET gc_policies_json = '%[1]s', rbac_policies_json = '%[2]s', rbac_policy_epoch = 1, next_policy_sync_at = %[3]d WHERE name = 'test1';
+				DELETE FROM repos WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`,
+			gcPoliciesJSON, rbacPoliciesJSON, s2.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+
+		// policies are not synced again within the same hour, even when the
+		// primary account changes in the meantime
+		rbacPoliciesJSON = `[{"match_repository":"library/.*|other/.*","permissions":["anonymous_pull"]}]`
+		mustExec(t, s1.DB, `UPDATE accounts SET rbac_policies_json = $1`, rbacPoliciesJSON)
+		mustExec(t, s2.DB, `INSERT INTO repos (id, account_name, name) VALUES (1, 'test1', 'foo')`)
+		tr.DBChanges().Ignore()
+		expectSuccess(t, syncManifestsJob2.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				DELETE FROM repos WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`)
+
+		// after the hour has passed, the change is picked up
+		s2.Clock.StepBy(1 * time.Hour)
+		mustExec(t, s2.DB, `INSERT INTO repos (id, account_name, name) VALUES (1, 'test1', 'foo')`)
+		tr.DBChanges().Ignore()
+		expectSuccess(t, syncManifestsJob2.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE accounts SET rbac_policies_json = '%[1]s', rbac_policy_epoch = 2, next_policy_sync_at = %[2]d WHERE name = 'test1';
+				DELETE FROM repos WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`,
+			rbacPoliciesJSON, s2.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+
+		// with a local override, policies are not synced at all
+		mustExec(t, s2.DB, `UPDATE accounts SET policies_overridden_locally = TRUE`)
+		mustExec(t, s1.DB, `UPDATE accounts SET rbac_policies_json = ''`)
+		s2.Clock.StepBy(2 * time.Hour)
+		mustExec(t, s2.DB, `INSERT INTO repos (id, account_name, name) VALUES (1, 'test1', 'foo')`)
+		tr.DBChanges().Ignore()
+		expectSuccess(t, syncManifestsJob2.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				DELETE FROM repos WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`)
+	})
+}
+
+func TestManifestSyncJobWithMetadataOnlyUpdate(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		_, s1 := setup(t)
+		j2, s2 := setupReplica(t, s1, "from_external_on_first_use")
+		mustExec(t, s2.DB, `UPDATE accounts SET external_peer_verify_only = TRUE`)
+		replicaToken := s2.GetToken(t, "repository:test1/foo:pull")
+		syncManifestsJob2 := j2.ManifestSyncJob(s2.Registry)
+		verifyManifestsJob2 := j2.ManifestVerificationJob(s2.Registry)
+
+		// replicate an image (since the replica is verify-only, the janitor
+		// verifies all blobs before the image can be pulled)
+		layer := test.GenerateExampleLayer(1)
+		image := test.GenerateImage(layer)
+		image.MustUpload(t, s1, fooRepoRef, "latest")
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+			ExpectStatus: http.StatusTooManyRequests,
+		}.Check(t, s2.Handler)
+		expectSuccess(t, verifyManifestsJob2.ProcessOne(s2.Ctx))
+		expectError(t, sql.ErrNoRows.Error(), verifyManifestsJob2.ProcessOne(s2.Ctx))
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, s2.Handler)
+
+		// upstream only changes the labels on the image (this yields a new config
+		// blob and manifest, but the layers stay the same)
+		relabeledImage := test.GenerateImageWithCustomConfig(func(cfg map[string]any) {
+			cfg["config"].(map[string]any)["Labels"] = map[string]string{"foo": "bar"}
+		}, layer)
+		relabeledImage.MustUpload(t, s1, fooRepoRef, "latest")
+
+		// remove the layer from the replica's storage: if the tag sync or the
+		// verification tried to look at the layer again, it would fail now
+		blob, err := keppel.FindBlobByAccountName(s2.DB, layer.Digest, "test1")
+		mustDo(t, err)
+		mustDo(t, s2.SD.DeleteBlob(s2.Ctx, models.ReducedAccount{Name: "test1"}, blob.StorageID))
+
+		// the tag sync replicates the new manifest and its config blob, but does
+		// not need to look at the layer again
+		s1.Clock.StepBy(1 * time.Hour)
+		expectSuccess(t, syncManifestsJob2.ProcessOne(s2.Ctx))
+		tagDigest, err := s2.DB.SelectStr(`SELECT digest FROM tags WHERE repo_id = 1 AND name = 'latest'`)
+		mustDo(t, err)
+		assert.DeepEqual(t, "tag digest", tagDigest, relabeledImage.Manifest.Digest.String())
+
+		// the new manifest is verified without looking at the layer again, since
+		// the layer is already referenced by the verified old manifest
+		expectSuccess(t, verifyManifestsJob2.ProcessOne(s2.Ctx))
+		verifiedCount, err := s2.DB.SelectInt(`SELECT COUNT(*) FROM manifests WHERE digest = $1 AND verified_at IS NOT NULL`, relabeledImage.Manifest.Digest)
+		mustDo(t, err)
+		assert.DeepEqual(t, "verified manifests", verifiedCount, int64(1))
+	})
+}
+
 func answerMostWith404(h http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/keppel/v1/auth" {
@@ -542,6 +678,21 @@ func answerMostWith404(h http.Handler) http.HandlerFunc {
 ////////////////////////////////////////////////////////////////////////////////
 // tests for CheckVulnerabilitiesForNextManifest
 
+// Checks that a Trivy report has been stored for each of the given manifests,
+// then removes all stored reports, so that their (compressed) contents do not
+// show up in the DB diffs asserted by the tests below.
+func expectTrivyReportsStored(t *testing.T, s test.Setup, manifestDigests ...digest.Digest) {
+	t.Helper()
+	for _, manifestDigest := range manifestDigests {
+		report, err := keppel.GetTrivyReport(s.DB, 1, manifestDigest)
+		mustDo(t, err)
+		if report == nil {
+			t.Errorf("expected a Trivy report to be stored for %s, but found none", manifestDigest)
+		}
+	}
+	mustExec(t, s.DB, `DELETE FROM trivy_reports`)
+}
+
 func TestCheckVulnerabilitiesForNextManifest(t *testing.T) {
 	test.WithRoundTripper(func(_ *test.RoundTripper) {
 		j, s := setup(t, test.WithTrivyDouble)
@@ -579,6 +730,8 @@ func TestCheckVulnerabilitiesForNextManifest(t *testing.T) {
 		s.Clock.StepBy(5 * time.Minute)
 		expectSuccess(t, trivyJob.ProcessOne(s.Ctx))
 		expectError(t, sql.ErrNoRows.Error(), trivyJob.ProcessOne(s.Ctx))
+		// the image list has no layers of its own, and the last image was not scanned because it is too big
+		expectTrivyReportsStored(t, s, images[0].Manifest.Digest, images[1].Manifest.Digest, images[2].Manifest.Digest)
 		tr.DBChanges().AssertEqualf(`
 			UPDATE blobs SET blocks_vuln_scanning = FALSE WHERE id = 1 AND account_name = 'test1' AND digest = '%[10]s';
 			UPDATE blobs SET blocks_vuln_scanning = FALSE WHERE id = 3 AND account_name = 'test1' AND digest = '%[11]s';
@@ -598,6 +751,7 @@ func TestCheckVulnerabilitiesForNextManifest(t *testing.T) {
 		s.Clock.StepBy(1 * time.Hour)
 		expectSuccess(t, trivyJob.ProcessOne(s.Ctx))
 		expectError(t, sql.ErrNoRows.Error(), trivyJob.ProcessOne(s.Ctx))
+		expectTrivyReportsStored(t, s, images[0].Manifest.Digest, images[1].Manifest.Digest, images[2].Manifest.Digest)
 		tr.DBChanges().AssertEqualf(`
 			UPDATE trivy_security_info SET next_check_at = %[6]d, checked_at = %[5]d WHERE repo_id = 1 AND digest = '%[1]s';
 			UPDATE trivy_security_info SET vuln_status = 'Critical', next_check_at = %[6]d, checked_at = %[5]d WHERE repo_id = 1 AND digest = '%[2]s';
@@ -636,12 +790,77 @@ func TestCheckVulnerabilitiesForNextManifestWithError(t *testing.T) {
 		s.TrivyDouble.ReportFixtures[image.ImageRef(s, fooRepoRef)] = "fixtures/trivy/report-vulnerable.json"
 		expectSuccess(t, trivyJob.ProcessOne(s.Ctx))
 		expectError(t, sql.ErrNoRows.Error(), trivyJob.ProcessOne(s.Ctx))
+		expectTrivyReportsStored(t, s, image.Manifest.Digest)
 		tr.DBChanges().AssertEqualf(`
 			UPDATE trivy_security_info SET vuln_status = 'Critical', message = '', next_check_at = %[2]d, checked_at = %[3]d, check_duration_secs = 0 WHERE repo_id = 1 AND digest = '%[1]s';
 		`, image.Manifest.Digest, s.Clock.Now().Add(60*time.Minute).Unix(), s.Clock.Now().Unix(), models.LowSeverity)
 	})
 }
 
+func TestCheckVulnerabilitiesForNextManifestWithTimeout(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		j, s := setup(t, test.WithTrivyDouble)
+		s.Config.Trivy.MaxScanTimeout = 30 * time.Minute
+		s.Clock.StepBy(1 * time.Hour)
+		tr, _ := easypg.NewTracker(t, s.DB.Db)
+		trivyJob := j.CheckTrivySecurityStatusJob(s.Registry)
+
+		image := test.GenerateImage(test.GenerateExampleLayer(4))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		imageRef := image.ImageRef(s, fooRepoRef)
+		tr.DBChanges().Ignore()
+
+		// a timed out scan is recorded with a distinct status, and the timeout is
+		// recorded as the check duration
+		s.Clock.StepBy(30 * time.Minute)
+		s.TrivyDouble.ReportTimeout[imageRef] = true
+		expectedMessage := "scan error: scan timed out after 10m0s: simulated error: context deadline exceeded"
+		expectError(t, fmt.Sprintf("cannot check manifest test1/foo@%s: %s", image.Manifest.Digest, expectedMessage), trivyJob.ProcessOne(s.Ctx))
+		assert.DeepEqual(t, "requested timeout", s.TrivyDouble.RequestedTimeouts[imageRef], "10m0s")
+		// the layers that Trivy fetched before timing out are recorded as a partial result
+		tr.DBChanges().AssertEqualf(`
+			UPDATE blobs SET blocks_vuln_scanning = FALSE, trivy_fetched_at = %[5]d WHERE id = 1 AND account_name = 'test1' AND digest = '%[1]s';
+			UPDATE trivy_security_info SET vuln_status = 'TimedOut', message = '%[3]s', next_check_at = %[4]d, checked_at = %[5]d, check_duration_secs = 600, scanned_layer_count = 1 WHERE repo_id = 1 AND digest = '%[2]s';
+		`, image.Layers[0].Digest, image.Manifest.Digest, expectedMessage, s.Clock.Now().Add(5*time.Minute).Unix(), s.Clock.Now().Unix())
+
+		// the next attempt gets a longer timeout
+		s.Clock.StepBy(5 * time.Minute)
+		expectedMessage = "scan error: scan timed out after 20m0s: simulated error: context deadline exceeded"
+		expectError(t, fmt.Sprintf("cannot check manifest test1/foo@%s: %s", image.Manifest.Digest, expectedMessage), trivyJob.ProcessOne(s.Ctx))
+		assert.DeepEqual(t, "requested timeout", s.TrivyDouble.RequestedTimeouts[imageRef], "20m0s")
+		tr.DBChanges().AssertEqualf(`
+			UPDATE blobs SET trivy_fetched_at = %[4]d WHERE id = 1 AND account_name = 'test1' AND digest = '%[5]s';
+			UPDATE trivy_security_info SET message = '%[2]s', next_check_at = %[3]d, checked_at = %[4]d, check_duration_secs = 1200 WHERE repo_id = 1 AND digest = '%[1]s';
+		`, image.Manifest.Digest, expectedMessage, s.Clock.Now().Add(5*time.Minute).Unix(), s.Clock.Now().Unix(), image.Layers[0].Digest)
+
+		// once the maximum timeout is reached, retries happen much less often
+		s.Clock.StepBy(5 * time.Minute)
+		expectedMessage = "scan error: scan timed out after 30m0s: simulated error: context deadline exceeded"
+		expectError(t, fmt.Sprintf("cannot check manifest test1/foo@%s: %s", image.Manifest.Digest, expectedMessage), trivyJob.ProcessOne(s.Ctx))
+		assert.DeepEqual(t, "requested timeout", s.TrivyDouble.RequestedTimeouts[imageRef], "30m0s")
+		tr.DBChanges().AssertEqualf(`
+			UPDATE blobs SET trivy_fetched_at = %[4]d WHERE id = 1 AND account_name = 'test1' AND digest = '%[5]s';
+			UPDATE trivy_security_info SET message = '%[2]s', next_check_at = %[3]d, checked_at = %[4]d, check_duration_secs = 1800 WHERE repo_id = 1 AND digest = '%[1]s';
+		`, image.Manifest.Digest, expectedMessage, s.Clock.Now().Add(6*time.Hour).Unix(), s.Clock.Now().Unix(), image.Layers[0].Digest)
+
+		// when the scan succeeds eventually, the regular schedule resumes
+		s.Clock.StepBy(6 * time.Hour)
+		s.TrivyDouble.ReportTimeout[imageRef] = false
+		s.TrivyDouble.ReportFixtures[imageRef] = "fixtures/trivy/report-vulnerable.json"
+		expectSuccess(t, trivyJob.ProcessOne(s.Ctx))
+		assert.DeepEqual(t, "requested timeout", s.TrivyDouble.RequestedTimeouts[imageRef], "30m0s")
+		expectTrivyReportsStored(t, s, image.Manifest.Digest)
+		tr.DBChanges().AssertEqualf(`
+			UPDATE trivy_security_info SET vuln_status = 'Critical', message = '', next_check_at = %[2]d, checked_at = %[3]d, check_duration_secs = 0, scanned_layer_count = NULL WHERE repo_id = 1 AND digest = '%[1]s';
+		`, image.Manifest.Digest, s.Clock.Now().Add(60*time.Minute).Unix(), s.Clock.Now().Unix())
+
+		// after a successful scan, the next scan starts with the initial timeout again
+		s.Clock.StepBy(1 * time.Hour)
+		expectSuccess(t, trivyJob.ProcessOne(s.Ctx))
+		assert.DeepEqual(t, "requested timeout", s.TrivyDouble.RequestedTimeouts[imageRef], "10m0s")
+	})
+}
+
 func TestCheckTrivySecurityStatusWithPolicies(t *testing.T) {
 	test.WithRoundTripper(func(_ *test.RoundTripper) {
 		j, s := setup(t, test.WithTrivyDouble)
@@ -658,6 +877,7 @@ func TestCheckTrivySecurityStatusWithPolicies(t *testing.T) {
 		s.Clock.StepBy(1 * time.Hour)
 		expectSuccess(t, trivyJob.ProcessOne(s.Ctx))
 		expectError(t, sql.ErrNoRows.Error(), trivyJob.ProcessOne(s.Ctx))
+		expectTrivyReportsStored(t, s, image.Manifest.Digest)
 		tr.DBChanges().AssertEqualf(`
 			UPDATE blobs SET blocks_vuln_scanning = FALSE WHERE id = 1 AND account_name = 'test1' AND digest = '%[1]s';
 			UPDATE trivy_security_info SET vuln_status = '%[2]s', next_check_at = %[3]d, checked_at = %[4]d, check_duration_secs = 0 WHERE repo_id = 1 AND digest = '%[5]s';
@@ -676,6 +896,7 @@ func TestCheckTrivySecurityStatusWithPolicies(t *testing.T) {
 			s.Clock.StepBy(1 * time.Hour)
 			expectSuccess(t, trivyJob.ProcessOne(s.Ctx))
 			expectError(t, sql.ErrNoRows.Error(), trivyJob.ProcessOne(s.Ctx))
+			expectTrivyReportsStored(t, s, image.Manifest.Digest)
 
 			tr.DBChanges().AssertEqualf(`
 				UPDATE trivy_security_info SET vuln_status = '%[1]s', next_check_at = %[2]d, checked_at = %[3]d WHERE repo_id = 1 AND digest = '%[4]s';
@@ -790,6 +1011,7 @@ func TestCheckTrivySecurityStatusWithEOSL(t *testing.T) {
 		s.Clock.StepBy(1 * time.Hour)
 		expectSuccess(t, trivyJob.ProcessOne(s.Ctx))
 		expectError(t, sql.ErrNoRows.Error(), trivyJob.ProcessOne(s.Ctx))
+		expectTrivyReportsStored(t, s, image.Manifest.Digest)
 		tr.DBChanges().AssertEqualf(`
 			UPDATE blobs SET blocks_vuln_scanning = FALSE WHERE id = 1 AND account_name = 'test1' AND digest = '%[1]s';
 			UPDATE trivy_security_info SET vuln_status = '%[2]s', next_check_at = %[3]d, checked_at = %[4]d, check_duration_secs = 0 WHERE repo_id = 1 AND digest = '%[5]s';
@@ -797,6 +1019,71 @@ func TestCheckTrivySecurityStatusWithEOSL(t *testing.T) {
 	})
 }
 
+func TestCheckTrivySecurityStatusInReplicaUsesReportFromPrimary(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		j1, s1 := setup(t, test.WithTrivyDouble)
+		_, s2 := setupReplica(t, s1, "on_first_use")
+		s1.Clock.StepBy(1 * time.Hour)
+
+		// the replica talks to the same Trivy double as the primary (the reports
+		// for both sides are distinguished by the image reference)
+		cfg2 := s2.Config
+		cfg2.Trivy = s1.Config.Trivy
+		j2 := NewJanitor(cfg2, s2.FD, s2.SD, s2.ICD, s2.SecD, s2.BD, s2.CDN, s2.NVD, s2.DB, s2.AMD, s2.Auditor).OverrideTimeNow(s2.Clock.Now).OverrideGenerateStorageID(s2.SIDGenerator.Next)
+		j2.DisableJitter()
+		trivyJob1 := j1.CheckTrivySecurityStatusJob(s1.Registry)
+		trivyJob2 := j2.CheckTrivySecurityStatusJob(s2.Registry)
+
+		// upload an image to the primary and have it scanned there
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s1, fooRepoRef, "latest")
+		s1.TrivyDouble.ReportFixtures[image.ImageRef(s1, fooRepoRef)] = "fixtures/trivy/report-vulnerable.json"
+		s1.Clock.StepBy(5 * time.Minute)
+		expectSuccess(t, trivyJob1.ProcessOne(s1.Ctx))
+
+		// replicate the image including all its blobs
+		replicaToken := s2.GetToken(t, "repository:test1/foo:pull")
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         fmt.Sprintf("/v2/test1/foo/manifests/%s", image.Manifest.Digest),
+			Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, s2.Handler)
+		for _, blob := range append(image.Layers, image.Config) {
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         fmt.Sprintf("/v2/test1/foo/blobs/%s", blob.Digest),
+				Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+				ExpectStatus: http.StatusOK,
+				ExpectBody:   assert.ByteData(blob.Contents),
+			}.Check(t, s2.Handler)
+		}
+
+		s1.Clock.StepBy(5 * time.Minute)
+
+		// the primary serves the report that it stored during its own scan, so it
+		// does not need to ask Trivy again
+		delete(s1.TrivyDouble.ReportFixtures, image.ImageRef(s1, fooRepoRef))
+
+		// since the primary has a fresh report, the replica does not need to scan
+		// by itself (there is no report fixture for the replica's image reference,
+		// so a local scan would fail)
+		expectSuccess(t, trivyJob2.ProcessOne(s2.Ctx))
+		securityInfo, err := keppel.GetSecurityInfo(s2.DB, 1, image.Manifest.Digest)
+		mustDo(t, err)
+		assert.DeepEqual(t, "vuln_status", securityInfo.VulnerabilityStatus, models.CriticalSeverity)
+
+		// when the primary's report is stale, the replica falls back to scanning locally
+		s1.Clock.StepBy(3 * time.Hour)
+		s1.TrivyDouble.ReportFixtures[image.ImageRef(s2, fooRepoRef)] = "fixtures/trivy/report-clean.json"
+		expectSuccess(t, trivyJob2.ProcessOne(s2.Ctx))
+		securityInfo, err = keppel.GetSecurityInfo(s2.DB, 1, image.Manifest.Digest)
+		mustDo(t, err)
+		assert.DeepEqual(t, "vuln_status", securityInfo.VulnerabilityStatus, models.CleanSeverity)
+	})
+}
+
 func TestManifestValidationJobWithoutPlatform(t *testing.T) {
 	j, s := setup(t)
 	tr, _ := easypg.NewTracker(t, s.DB.Db)
@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+)
+
+var pullAttestationPruneQuery = sqlext.SimplifyWhitespace(`
+	DELETE FROM pull_attestations WHERE last_pulled_at < $1
+`)
+
+// PullAttestationPruningJob is a job. Each task deletes pull attestations
+// that have not been refreshed within the configured retention period.
+func (j *Janitor) PullAttestationPruningJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "pruning of old pull attestations",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_pull_attestation_prunings",
+				Help: "Counter for prunings of old pull attestations.",
+			},
+		},
+		Interval: 1 * time.Hour,
+		Task:     j.prunePullAttestations,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) prunePullAttestations(_ context.Context, _ prometheus.Labels) error {
+	result, err := j.db.Exec(pullAttestationPruneQuery, j.timeNow().Add(-j.cfg.PullAttestationRetention))
+	if err != nil {
+		return fmt.Errorf("cannot prune pull attestations: %w", err)
+	}
+	rowsDeleted, err := result.RowsAffected()
+	if err == nil && rowsDeleted > 0 {
+		logg.Info("pruned %d pull attestations older than %s", rowsDeleted, j.cfg.PullAttestationRetention)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/easypg"
+)
+
+func TestPullAttestationPruningJob(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	job := j.PullAttestationPruningJob(s.Registry)
+
+	// one client pulled long ago, the other one pulls regularly
+	const insertQuery = `INSERT INTO pull_attestations (account_name, repo_name, digest, client_id, first_pulled_at, last_pulled_at, pull_count) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	mustExec(t, s.DB, insertQuery, "test1", "foo", "sha256:1234", "cluster-a", s.Clock.Now(), s.Clock.Now(), 1)
+	mustExec(t, s.DB, insertQuery, "test1", "foo", "sha256:1234", "cluster-b", s.Clock.Now(), s.Clock.Now(), 1)
+	tr, tr0 := easypg.NewTracker(t, s.DB.Db)
+	tr0.Ignore()
+
+	// nothing is pruned within the retention period
+	s.Clock.StepBy(300 * 24 * time.Hour)
+	mustExec(t, s.DB, `UPDATE pull_attestations SET last_pulled_at = $1, pull_count = 2 WHERE client_id = $2`, s.Clock.Now(), "cluster-b")
+	tr.DBChanges().Ignore()
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEmpty()
+
+	// after the retention period, stale records are pruned
+	s.Clock.StepBy(100 * 24 * time.Hour)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			DELETE FROM pull_attestations WHERE account_name = 'test1' AND repo_name = 'foo' AND digest = 'sha256:1234' AND client_id = 'cluster-a';
+		`)
+}
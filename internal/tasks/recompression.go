@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	imageManifest "github.com/containers/image/v5/manifest"
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/processor"
+)
+
+const recompressedVariantRetryInterval = 6 * time.Hour
+
+// Image manifests that have a subject are skipped. This covers the variants
+// generated by this job and by LazyPullVariantJob, as well as signatures,
+// SBOMs etc.
+var recompressedVariantSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT m.* FROM manifests m
+	  JOIN repos r ON r.id = m.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	  LEFT OUTER JOIN recompressed_variants v ON v.repo_id = m.repo_id AND v.digest = m.digest AND v.format = a.recompression_format
+	 WHERE a.recompression_format != '' AND NOT a.is_deleting
+	   AND m.media_type IN ($2, $3) AND m.subject_digest = '' AND m.quarantined_at IS NULL
+	   AND (v.digest IS NULL OR v.next_attempt_at < $1)
+	-- new manifests first, then retries sorted by their schedule
+	ORDER BY v.next_attempt_at IS NULL DESC, v.next_attempt_at ASC, m.pushed_at ASC
+	LIMIT 1 -- one at a time
+`)
+
+var recompressedVariantUpsertQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO recompressed_variants (repo_id, digest, format, variant_digest, original_layers_size_bytes, variant_layers_size_bytes, generated_at, error_message, next_attempt_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	ON CONFLICT (repo_id, digest, format) DO UPDATE SET
+		variant_digest = EXCLUDED.variant_digest,
+		original_layers_size_bytes = EXCLUDED.original_layers_size_bytes, variant_layers_size_bytes = EXCLUDED.variant_layers_size_bytes,
+		generated_at = EXCLUDED.generated_at, error_message = EXCLUDED.error_message, next_attempt_at = EXCLUDED.next_attempt_at
+`)
+
+// RecompressedVariantJob is a job. Each task finds an image manifest in an
+// account with a RecompressionFormat that does not have a variant in that
+// format yet, and generates it. Like with LazyPullVariantJob, the variant is
+// stored in the same repository as a referrer of the original manifest, and
+// generation is retried after some time if it fails.
+func (j *Janitor) RecompressedVariantJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.Manifest]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "generate recompressed variants of images",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_recompressed_variant_generations",
+				Help: "Counter for generations of recompressed variants of images.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (manifest models.Manifest, err error) {
+			err = j.db.SelectOne(&manifest, recompressedVariantSearchQuery, j.timeNow(),
+				imageManifest.DockerV2Schema2MediaType, imagespecs.MediaTypeImageManifest)
+			return manifest, err
+		},
+		ProcessTask: j.generateRecompressedVariant,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) generateRecompressedVariant(ctx context.Context, manifest models.Manifest, _ prometheus.Labels) error {
+	var repo models.Repository
+	err := j.db.SelectOne(&repo, `SELECT * FROM repos WHERE id = $1`, manifest.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("cannot find repo %d for manifest %s: %w", manifest.RepositoryID, manifest.Digest, err)
+	}
+	account, err := keppel.FindAccount(j.db, repo.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), manifest.Digest, err)
+	}
+	if account == nil {
+		return fmt.Errorf("account %q not found", repo.AccountName)
+	}
+
+	var generated processor.GeneratedVariant
+	var genErr error
+	switch account.RecompressionFormat {
+	case models.RecompressionFormatZstd:
+		generated, genErr = j.processor().GenerateZstdVariant(ctx, account.Reduced(), repo, manifest, keppel.AuditContext{
+			UserIdentity: janitorUserIdentity{TaskName: "recompressed-variant"},
+			Request:      janitorDummyRequest,
+		})
+	default:
+		genErr = fmt.Errorf("unsupported recompression format %q", account.RecompressionFormat)
+	}
+
+	variant := models.RecompressedVariant{
+		RepositoryID:            repo.ID,
+		Digest:                  manifest.Digest,
+		Format:                  account.RecompressionFormat,
+		VariantDigest:           generated.Digest,
+		OriginalLayersSizeBytes: generated.OriginalLayersSizeBytes,
+		VariantLayersSizeBytes:  generated.VariantLayersSizeBytes,
+		GeneratedAt:             j.timeNow(),
+	}
+	if genErr != nil {
+		variant.ErrorMessage = genErr.Error()
+		nextAttemptAt := j.timeNow().Add(j.addJitter(recompressedVariantRetryInterval))
+		variant.NextAttemptAt = &nextAttemptAt
+	}
+	_, err = j.db.Exec(recompressedVariantUpsertQuery,
+		variant.RepositoryID, variant.Digest, variant.Format, variant.VariantDigest,
+		variant.OriginalLayersSizeBytes, variant.VariantLayersSizeBytes,
+		variant.GeneratedAt, variant.ErrorMessage, variant.NextAttemptAt,
+	)
+	if genErr != nil {
+		if err != nil {
+			genErr = fmt.Errorf("%w (additional error encountered while recording generation error: %w)", genErr, err)
+		}
+		return fmt.Errorf("while generating %s variant of manifest %s/%s: %w", variant.Format, repo.FullName(), manifest.Digest, genErr)
+	}
+	return err
+}
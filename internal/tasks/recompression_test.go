@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"archive/tar"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	imageManifest "github.com/containers/image/v5/manifest"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	imagespecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestRecompressedVariantJob(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	job := j.RecompressedVariantJob(s.Registry)
+
+	fileContents := strings.Repeat("hello world\n", 1000)
+	image := test.GenerateImage(generateTarLayer(t, fileContents))
+	image.MustUpload(t, s, fooRepoRef, "latest")
+
+	// nothing happens while the account has not opted in
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+
+	// once opted in, the image gets recompressed exactly once
+	mustExec(t, s.DB, `UPDATE accounts SET recompression_format = $1`, models.RecompressionFormatZstd)
+	s.Clock.StepBy(1 * time.Minute)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+
+	var variant models.RecompressedVariant
+	mustDo(t, s.DB.SelectOne(&variant, `SELECT * FROM recompressed_variants WHERE digest = $1`, image.Manifest.Digest))
+	assert.DeepEqual(t, "error message", variant.ErrorMessage, "")
+	assert.DeepEqual(t, "next attempt", variant.NextAttemptAt, (*time.Time)(nil))
+	assert.DeepEqual(t, "original layers size", variant.OriginalLayersSizeBytes, uint64(len(image.Layers[0].Contents)))
+
+	// the variant is a referrer of the original image, and shares its config
+	var variantManifest models.Manifest
+	mustDo(t, s.DB.SelectOne(&variantManifest, `SELECT * FROM manifests WHERE digest = $1`, variant.VariantDigest))
+	assert.DeepEqual(t, "variant subject", variantManifest.SubjectDigest, image.Manifest.Digest)
+	account, err := keppel.FindReducedAccount(s.DB, "test1")
+	mustDo(t, err)
+	variantBytes, err := s.SD.ReadManifest(s.Ctx, *account, "foo", variant.VariantDigest)
+	mustDo(t, err)
+	var parsed imagespecs.Manifest
+	mustDo(t, json.Unmarshal(variantBytes, &parsed))
+	assert.DeepEqual(t, "variant config", parsed.Config.Digest, image.Config.Digest)
+	assert.DeepEqual(t, "variant layer count", len(parsed.Layers), 1)
+	assert.DeepEqual(t, "variant layer media type", parsed.Layers[0].MediaType, imagespecs.MediaTypeImageLayerZstd)
+	assert.DeepEqual(t, "variant layers size", variant.VariantLayersSizeBytes, uint64(parsed.Layers[0].Size)) //nolint:gosec // test data
+
+	// the recompressed layer contains the same tar stream
+	blob, err := keppel.FindBlobByAccountName(s.DB, parsed.Layers[0].Digest, "test1")
+	mustDo(t, err)
+	blobReader, _, err := s.SD.ReadBlob(s.Ctx, *account, blob.StorageID)
+	mustDo(t, err)
+	defer blobReader.Close()
+	zr, err := zstd.NewReader(blobReader)
+	mustDo(t, err)
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	mustDo(t, err)
+	assert.DeepEqual(t, "file name", hdr.Name, "etc/motd")
+	buf, err := io.ReadAll(tr)
+	mustDo(t, err)
+	assert.DeepEqual(t, "file contents", string(buf), fileContents)
+
+	// layers that are not valid gzip streams cannot be recompressed, and are retried later
+	brokenLayerContents := []byte("this is not a gzip stream")
+	brokenImage := test.GenerateImage(test.Bytes{
+		Contents:  brokenLayerContents,
+		Digest:    digest.Canonical.FromBytes(brokenLayerContents),
+		MediaType: imageManifest.DockerV2Schema2LayerMediaType,
+	})
+	brokenImage.MustUpload(t, s, fooRepoRef, "broken")
+	err = job.ProcessOne(s.Ctx)
+	if err == nil || !strings.Contains(err.Error(), "gzip: invalid header") {
+		t.Errorf("expected recompression error, but got %v", err)
+	}
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+	mustDo(t, s.DB.SelectOne(&variant, `SELECT * FROM recompressed_variants WHERE digest = $1`, brokenImage.Manifest.Digest))
+	assert.DeepEqual(t, "next attempt", variant.NextAttemptAt.Unix(), s.Clock.Now().Add(recompressedVariantRetryInterval).Unix())
+	assert.DeepEqual(t, "variant digest", variant.VariantDigest.String(), "")
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var (
+	replicaRevalidationSelectQuery = sqlext.SimplifyWhitespace(`
+		SELECT * FROM repos
+		 WHERE next_revalidation_at <= $1
+		-- oldest requests first, then sorted by ID for deterministic behavior in unit tests
+		 ORDER BY next_revalidation_at ASC, id ASC
+		-- only one repo at a time
+		 LIMIT 1
+	`)
+	replicaRevalidationDoneQuery = sqlext.SimplifyWhitespace(`
+		UPDATE repos
+		   SET next_revalidation_at = NULL, revalidation_repair = FALSE, revalidated_at = $2, revalidation_result_json = $3,
+		       next_manifest_sync_at = CASE WHEN $4 THEN $2 ELSE next_manifest_sync_at END
+		 WHERE id = $1
+	`)
+)
+
+// ReplicaRevalidationJob is a job. Each task takes a repo in a replica
+// account for which a revalidation was requested through the API, and
+// compares its contents with the upstream registry (see
+// processor.RevalidateReplicaRepo). The result is stored in the repo for
+// retrieval through the API. If requested, the next manifest sync is
+// scheduled right away to repair the divergences.
+func (j *Janitor) ReplicaRevalidationJob(registerer prometheus.Registerer) jobloop.Job { //nolint:dupl // false positive
+	return (&jobloop.ProducerConsumerJob[models.Repository]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "revalidation of replica repos",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_replica_revalidations",
+				Help: "Counter for revalidations of replica repos against their upstream.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (repo models.Repository, err error) {
+			err = j.db.SelectOne(&repo, replicaRevalidationSelectQuery, j.timeNow())
+			return repo, err
+		},
+		ProcessTask: j.revalidateReplicaRepo,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) revalidateReplicaRepo(ctx context.Context, repo models.Repository, _ prometheus.Labels) error {
+	var result keppel.ReplicaRevalidationResult
+	divergences, revalidationErr := j.doRevalidateReplicaRepo(ctx, repo)
+	if revalidationErr == nil {
+		result.Divergences = divergences
+		result.RepairScheduled = repo.RevalidationRepair && keppel.HasRepairableDivergences(divergences)
+	} else {
+		result.Error = revalidationErr.Error()
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = j.db.Exec(replicaRevalidationDoneQuery, repo.ID, j.timeNow(), string(resultJSON), result.RepairScheduled)
+	if err != nil {
+		return fmt.Errorf("cannot store revalidation result for repo %s: %w", repo.FullName(), err)
+	}
+	if revalidationErr != nil {
+		return fmt.Errorf("while revalidating repo %s: %w", repo.FullName(), revalidationErr)
+	}
+	return nil
+}
+
+func (j *Janitor) doRevalidateReplicaRepo(ctx context.Context, repo models.Repository) ([]keppel.ReplicaDivergence, error) {
+	account, err := keppel.FindAccount(j.db, repo.AccountName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find account: %w", err)
+	}
+	if account == nil {
+		return nil, errors.New("account not found")
+	}
+	if account.UpstreamPeerHostName == "" && account.ExternalPeerURL == "" {
+		return nil, errors.New("account is not a replica account")
+	}
+	if account.IsDeleting {
+		return nil, errors.New("account is being deleted")
+	}
+	return j.processor().RevalidateReplicaRepo(ctx, account.Reduced(), repo)
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/easypg"
+
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestReplicaRevalidationJob(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		_, s1 := setup(t)
+		j2, s2 := setupReplica(t, s1, "on_first_use")
+		s1.Clock.StepBy(1 * time.Hour)
+		s2.Clock.StepBy(1 * time.Hour)
+		job := j2.ReplicaRevalidationJob(s2.Registry)
+
+		// upload an image to the primary and replicate it
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s1, fooRepoRef, "latest")
+		replicaToken := s2.GetToken(t, "repository:test1/foo:pull")
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+			ExpectStatus: http.StatusOK,
+		}.Check(t, s2.Handler)
+		mustExec(t, s2.DB, `UPDATE repos SET next_manifest_sync_at = $1`, s2.Clock.Now().Add(24*time.Hour))
+
+		// nothing happens until a revalidation is requested
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s2.Ctx))
+		tr, tr0 := easypg.NewTracker(t, s2.DB.Db)
+		tr0.Ignore()
+
+		// while the replica is in sync, there are no divergences
+		mustExec(t, s2.DB, `UPDATE repos SET next_revalidation_at = $1`, s2.Clock.Now())
+		tr.DBChanges().Ignore()
+		s2.Clock.StepBy(1 * time.Minute)
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE repos SET next_revalidation_at = NULL, revalidated_at = %[1]d, revalidation_result_json = '{}' WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`,
+			s2.Clock.Now().Unix(),
+		)
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s2.Ctx))
+
+		// when the tag is deleted upstream, this is reported, and a repair is
+		// scheduled if requested
+		mustExec(t, s1.DB, `DELETE FROM tags WHERE name = $1`, "latest")
+		mustExec(t, s2.DB, `UPDATE repos SET next_revalidation_at = $1, revalidation_repair = TRUE`, s2.Clock.Now())
+		tr.DBChanges().Ignore()
+		s2.Clock.StepBy(1 * time.Minute)
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE repos SET next_manifest_sync_at = %[1]d, next_revalidation_at = NULL, revalidation_repair = FALSE, revalidated_at = %[1]d, revalidation_result_json = '{"divergences":[{"kind":"tag_deleted","tag":"latest","digest":"%[2]s"}],"repair_scheduled":true}' WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`,
+			s2.Clock.Now().Unix(), image.Manifest.Digest,
+		)
+
+		// errors are reported in the result as well
+		mustExec(t, s2.DB, `UPDATE accounts SET upstream_peer_hostname = ''`)
+		mustExec(t, s2.DB, `UPDATE repos SET next_revalidation_at = $1`, s2.Clock.Now())
+		tr.DBChanges().Ignore()
+		s2.Clock.StepBy(1 * time.Minute)
+		expectError(t, "while revalidating repo test1/foo: account is not a replica account", job.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE repos SET next_revalidation_at = NULL, revalidated_at = %[1]d, revalidation_result_json = '%[2]s' WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`,
+			s2.Clock.Now().Unix(), fmt.Sprintf(`{"error":%q}`, "account is not a replica account"),
+		)
+	})
+}
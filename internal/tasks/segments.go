@@ -0,0 +1,202 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var (
+	orphanedSegmentCountGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_orphaned_segments",
+			Help: "Number of orphaned segments in the backing storage of an account, as found by the last orphaned segment sweep.",
+		},
+		[]string{"account"},
+	)
+	orphanedSegmentBytesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_orphaned_segment_bytes",
+			Help: "Total size of orphaned segments in the backing storage of an account, as found by the last orphaned segment sweep.",
+		},
+		[]string{"account"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(orphanedSegmentCountGauge)
+	prometheus.MustRegister(orphanedSegmentBytesGauge)
+}
+
+const (
+	orphanedSegmentSweepInterval      = 24 * time.Hour
+	orphanedSegmentSweepRetryInterval = 1 * time.Hour
+)
+
+var orphanedSegmentSweepSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM accounts
+		WHERE (next_segment_sweep_at IS NULL OR next_segment_sweep_at < $1) AND NOT is_deleting
+	-- accounts without any sweeps first, then sorted by last sweep
+	ORDER BY next_segment_sweep_at IS NULL DESC, next_segment_sweep_at ASC
+	-- only one account at a time
+	LIMIT 1
+`)
+
+var orphanedSegmentSweepDoneQuery = sqlext.SimplifyWhitespace(`
+	UPDATE accounts SET next_segment_sweep_at = $2 WHERE name = $1
+`)
+
+// OrphanedSegmentSweepJob is a job. Each task finds an account whose backing
+// storage needs to be checked for orphaned segments, i.e. segments of chunked
+// uploads that are not referenced by the finalized blob that they belong to
+// (see keppel.StorageDriverWithSegments). Such segments are left behind when
+// an upload crashes between writing a segment and updating the database.
+//
+// Orphaned segments are recorded in the `orphaned_segments` table and
+// reported in the log and as Prometheus metrics. If
+// KEPPEL_ORPHANED_SEGMENT_DELETION_DELAY is configured, segments that have
+// been orphaned for at least that long are deleted from the backing storage.
+//
+// For storage drivers that do not implement keppel.StorageDriverWithSegments,
+// this job does not do anything besides advancing the account's timestamp.
+//
+// The storage of each account is checked at most once every 24 hours. If the
+// check fails, it is retried after one hour, so that a single broken account
+// does not block the sweeps for all other accounts.
+func (j *Janitor) OrphanedSegmentSweepJob(registerer prometheus.Registerer) jobloop.Job { //nolint:dupl // false positive
+	return (&jobloop.ProducerConsumerJob[models.Account]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "orphaned segment sweep",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_orphaned_segment_sweeps",
+				Help: "Counter for checks of an account's backing storage for orphaned segments.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (account models.Account, err error) {
+			err = j.db.SelectOne(&account, orphanedSegmentSweepSearchQuery, j.timeNow())
+			return account, err
+		},
+		ProcessTask: j.sweepOrphanedSegments,
+	}).Setup(registerer)
+}
+
+type orphanedSegmentKey struct {
+	StorageID   string
+	ChunkNumber uint32
+}
+
+func (j *Janitor) sweepOrphanedSegments(ctx context.Context, account models.Account, _ prometheus.Labels) error {
+	err := j.doSweepOrphanedSegments(ctx, account)
+	if err != nil {
+		_, err2 := j.db.Exec(orphanedSegmentSweepDoneQuery, account.Name, j.timeNow().Add(j.addJitter(orphanedSegmentSweepRetryInterval)))
+		if err2 != nil {
+			return fmt.Errorf("%w (additional error when scheduling retry: %s)", err, err2.Error())
+		}
+		return fmt.Errorf("while sweeping orphaned segments in account %s: %w", account.Name, err)
+	}
+
+	_, err = j.db.Exec(orphanedSegmentSweepDoneQuery, account.Name, j.timeNow().Add(j.addJitter(orphanedSegmentSweepInterval)))
+	return err
+}
+
+func (j *Janitor) doSweepOrphanedSegments(ctx context.Context, account models.Account) error {
+	reducedAccount := account.Reduced()
+
+	actualSegments, err := keppel.ListOrphanedSegments(ctx, j.sd, reducedAccount)
+	if err != nil {
+		return err
+	}
+	actualSegmentsByKey := make(map[orphanedSegmentKey]keppel.StoredSegmentInfo, len(actualSegments))
+	for _, segment := range actualSegments {
+		actualSegmentsByKey[orphanedSegmentKey{segment.StorageID, segment.ChunkNumber}] = segment
+	}
+
+	var (
+		remainingCount int
+		remainingBytes uint64
+	)
+
+	// check segments that were found in previous passes
+	var knownSegments []models.OrphanedSegment
+	_, err = j.db.Select(&knownSegments, `SELECT * FROM orphaned_segments WHERE account_name = $1`, account.Name)
+	if err != nil {
+		return err
+	}
+	isKnownSegment := make(map[orphanedSegmentKey]bool, len(knownSegments))
+	for _, knownSegment := range knownSegments {
+		key := orphanedSegmentKey{knownSegment.StorageID, knownSegment.ChunkNumber}
+		isKnownSegment[key] = true
+
+		// forget about segments that have disappeared in the meantime (e.g. because
+		// an operator cleaned them up manually, or because the blob was deleted)
+		segment, exists := actualSegmentsByKey[key]
+		if !exists {
+			_, err = j.db.Delete(&knownSegment)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// delete segments that have been orphaned long enough, if enabled
+		deletionDelay := j.cfg.OrphanedSegmentDeletionDelay
+		if deletionDelay > 0 && knownSegment.DetectedAt.Add(deletionDelay).Before(j.timeNow()) {
+			logg.Info("orphaned segment sweep in account %s: removing segment %d of blob stored at %s (%d bytes)",
+				account.Name, segment.ChunkNumber, segment.StorageID, segment.SizeBytes)
+			err = keppel.DeleteSegment(ctx, j.sd, reducedAccount, segment)
+			if err != nil {
+				return err
+			}
+			_, err = j.db.Delete(&knownSegment)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		remainingCount++
+		remainingBytes += segment.SizeBytes
+	}
+
+	// record newly discovered segments
+	for key, segment := range actualSegmentsByKey {
+		if isKnownSegment[key] {
+			continue
+		}
+		logg.Info("orphaned segment sweep in account %s: found orphaned segment %d of blob stored at %s (%d bytes)",
+			account.Name, segment.ChunkNumber, segment.StorageID, segment.SizeBytes)
+		err := j.db.Insert(&models.OrphanedSegment{
+			AccountName: account.Name,
+			StorageID:   segment.StorageID,
+			ChunkNumber: segment.ChunkNumber,
+			SizeBytes:   segment.SizeBytes,
+			DetectedAt:  j.timeNow(),
+		})
+		if err != nil {
+			return err
+		}
+		remainingCount++
+		remainingBytes += segment.SizeBytes
+	}
+
+	// report the remaining segments (but do not keep metrics for clean accounts around)
+	if remainingCount > 0 {
+		orphanedSegmentCountGauge.WithLabelValues(string(account.Name)).Set(float64(remainingCount))
+		orphanedSegmentBytesGauge.WithLabelValues(string(account.Name)).Set(float64(remainingBytes))
+	} else {
+		orphanedSegmentCountGauge.DeleteLabelValues(string(account.Name))
+		orphanedSegmentBytesGauge.DeleteLabelValues(string(account.Name))
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// A keppel.StorageDriverWithSegments that reports a fixed set of orphaned segments.
+type segmentedStorageDriver struct {
+	keppel.StorageDriver
+	Segments []keppel.StoredSegmentInfo
+	ListErr  error
+}
+
+func (d *segmentedStorageDriver) ListOrphanedSegments(ctx context.Context, account models.ReducedAccount) ([]keppel.StoredSegmentInfo, error) {
+	if d.ListErr != nil {
+		return nil, d.ListErr
+	}
+	return slices.Clone(d.Segments), nil
+}
+
+func (d *segmentedStorageDriver) DeleteSegment(ctx context.Context, account models.ReducedAccount, segment keppel.StoredSegmentInfo) error {
+	d.Segments = slices.DeleteFunc(d.Segments, func(s keppel.StoredSegmentInfo) bool { return s == segment })
+	return nil
+}
+
+func TestOrphanedSegmentSweep(t *testing.T) {
+	_, s := setup(t)
+	sd := &segmentedStorageDriver{
+		StorageDriver: s.SD,
+		Segments: []keppel.StoredSegmentInfo{
+			{StorageID: "abcdef", ChunkNumber: 3, SizeBytes: 1024},
+			{StorageID: "abcdef", ChunkNumber: 4, SizeBytes: 512},
+			{StorageID: "123456", ChunkNumber: 2, SizeBytes: 2048},
+		},
+	}
+	j := NewJanitor(s.Config, s.FD, sd, s.ICD, s.SecD, s.BD, s.CDN, s.NVD, s.DB, s.AMD, s.Auditor).OverrideTimeNow(s.Clock.Now)
+	j.DisableJitter()
+	job := j.OrphanedSegmentSweepJob(s.Registry)
+
+	expectOrphanedSegmentCount := func(expected int64) {
+		t.Helper()
+		actual, err := s.DB.SelectInt(`SELECT COUNT(*) FROM orphaned_segments WHERE account_name = $1`, "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if actual != expected {
+			t.Errorf("expected %d orphaned segments in the DB, but got %d", expected, actual)
+		}
+	}
+
+	// first pass records all orphaned segments, but does not delete anything
+	// since deletion is not enabled
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+	expectOrphanedSegmentCount(3)
+	if len(sd.Segments) != 3 {
+		t.Errorf("expected no segments to be deleted, but %d segments are left", len(sd.Segments))
+	}
+
+	// segments that disappear by themselves are forgotten in the next pass
+	sd.Segments = sd.Segments[:2]
+	s.Clock.StepBy(25 * time.Hour)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectOrphanedSegmentCount(2)
+	if len(sd.Segments) != 2 {
+		t.Errorf("expected no segments to be deleted, but %d segments are left", len(sd.Segments))
+	}
+
+	// with deletion enabled, segments are deleted once they have been orphaned for long enough
+	j.cfg.OrphanedSegmentDeletionDelay = 48 * time.Hour
+	sd.Segments = append(sd.Segments, keppel.StoredSegmentInfo{StorageID: "fedcba", ChunkNumber: 5, SizeBytes: 100})
+	s.Clock.StepBy(25 * time.Hour)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectOrphanedSegmentCount(1)
+	expected := []keppel.StoredSegmentInfo{{StorageID: "fedcba", ChunkNumber: 5, SizeBytes: 100}}
+	if !slices.Equal(sd.Segments, expected) {
+		t.Errorf("expected remaining segments %#v, but got %#v", expected, sd.Segments)
+	}
+
+	// when the storage cannot be listed, the account is retried after one hour
+	// instead of blocking the sweep for all other accounts
+	sd.ListErr = errors.New("storage unavailable")
+	s.Clock.StepBy(25 * time.Hour)
+	expectError(t, "while sweeping orphaned segments in account test1: storage unavailable", job.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+	expectOrphanedSegmentCount(1)
+
+	sd.ListErr = nil
+	s.Clock.StepBy(30 * time.Minute)
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+	s.Clock.StepBy(31 * time.Minute)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var tagRetentionRepoSelectQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM repos
+		WHERE (next_tag_retention_at IS NULL OR next_tag_retention_at < $1)
+		-- archived repos are read-only, so their tags are not subject to retention
+		AND NOT is_archived
+	-- repos without any runs first, then sorted by last run
+	ORDER BY next_tag_retention_at IS NULL DESC, next_tag_retention_at ASC
+	-- only one repo at a time
+	LIMIT 1
+`)
+
+var tagRetentionRepoDoneQuery = sqlext.SimplifyWhitespace(`
+	UPDATE repos SET next_tag_retention_at = $2 WHERE id = $1
+`)
+
+// TagRetentionJob is a job. Each task finds a non-archived repo where tag
+// retention has not been enforced for more than an hour, and deletes those
+// manifests whose tags have all fallen out of retention according to the tag
+// retention policies configured on the repo's account. Manifests with tags
+// that are covered by a tag protection policy are never deleted.
+func (j *Janitor) TagRetentionJob(registerer prometheus.Registerer) jobloop.Job {
+	return j.policyDrivenDeletionJob(registerer, jobloop.JobMetadata{
+		ReadableName: "tag retention",
+		CounterOpts: prometheus.CounterOpts{
+			Name: "keppel_tag_retention_runs",
+			Help: "Counter for tag retention runs in repos.",
+		},
+	}, tagRetentionRepoSelectQuery, j.enforceTagRetentionInRepo)
+}
+
+func (j *Janitor) enforceTagRetentionInRepo(ctx context.Context, repo models.Repository, _ prometheus.Labels) error {
+	account, err := keppel.FindAccount(j.db, repo.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for repo %s: %w", repo.FullName(), err)
+	}
+	policies, err := keppel.ParseTagRetentionPolicies(*account)
+	if err != nil {
+		return fmt.Errorf("cannot load tag retention policies for account %s: %w", account.Name, err)
+	}
+	var policiesForRepo []keppel.TagRetentionPolicy
+	for _, policy := range policies {
+		if policy.MatchesRepository(repo.Name) {
+			policiesForRepo = append(policiesForRepo, policy)
+		}
+	}
+
+	if len(policiesForRepo) > 0 {
+		err = j.executeTagRetentionPolicies(ctx, account.Reduced(), repo, policiesForRepo)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = j.db.Exec(tagRetentionRepoDoneQuery, repo.ID, j.timeNow().Add(j.addJitter(1*time.Hour)))
+	return err
+}
+
+func (j *Janitor) executeTagRetentionPolicies(ctx context.Context, account models.ReducedAccount, repo models.Repository, policies []keppel.TagRetentionPolicy) error {
+	var tags []models.Tag
+	_, err := j.db.Select(&tags, `SELECT * FROM tags WHERE repo_id = $1`, repo.ID)
+	if err != nil {
+		return err
+	}
+	expiredTags := keppel.FindExpiredTags(policies, tags)
+	if len(expiredTags) == 0 {
+		return nil
+	}
+	protectionPolicies, err := keppel.ParseTagProtectionPolicies(account)
+	if err != nil {
+		return fmt.Errorf("cannot load tag protection policies for account %s: %w", account.Name, err)
+	}
+
+	// reuse the bookkeeping from the GC policy evaluation to find out which
+	// manifests must not be deleted (e.g. because an image list refers to them)
+	proc := j.processor()
+	manifests, err := proc.LoadManifestsForGC(repo)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range manifests {
+		if m.IsDeleted || m.GCStatus.IsProtected() || len(m.TagNames) == 0 {
+			continue
+		}
+
+		// a manifest is only deleted once all of its tags have fallen out of retention
+		var policy *keppel.TagRetentionPolicy
+		for _, tagName := range m.TagNames {
+			p, isExpired := expiredTags[tagName]
+			if !isExpired {
+				policy = nil
+				break
+			}
+			if policy == nil {
+				policy = &p
+			}
+		}
+		if policy == nil || isAnyTagProtected(protectionPolicies, repo.Name, m.TagNames) {
+			continue
+		}
+
+		err := j.deleteManifestByPolicy(ctx, account, repo, manifests, m, janitorUserIdentity{
+			TaskName:           "tag-retention",
+			TagRetentionPolicy: policy,
+		})
+		if err != nil {
+			return err
+		}
+		m.IsDeleted = true
+		policyJSON, _ := json.Marshal(*policy)
+		logg.Info("tag retention on repo %s: deleted manifest %s with tags %v because of policy %s", repo.FullName(), m.Manifest.Digest, m.TagNames, string(policyJSON))
+	}
+
+	return nil
+}
+
+// isAnyTagProtected returns whether any of the given tags is covered by a tag
+// protection policy (immutable or not).
+func isAnyTagProtected(policies []keppel.TagProtectionPolicy, repoName string, tagNames []string) bool {
+	for _, policy := range policies {
+		for _, tagName := range tagNames {
+			if policy.Matches(repoName, tagName) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestTagRetention(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	retentionJob := j.TagRetentionJob(s.Registry)
+
+	// push three releases; the second one is also tagged with a name that is
+	// not covered by the retention policy
+	var images []test.Image
+	for idx, tagName := range []string{"release-1", "release-2", "release-3"} {
+		image := test.GenerateImage(test.GenerateExampleLayer(int64(idx)))
+		image.MustUpload(t, s, fooRepoRef, tagName)
+		images = append(images, image)
+		s.Clock.StepBy(1 * time.Hour)
+	}
+	images[1].MustUpload(t, s, fooRepoRef, "stable")
+
+	expectManifests := func(expected ...test.Image) {
+		t.Helper()
+		var actualDigests []string
+		_, err := s.DB.Select(&actualDigests, `SELECT digest FROM manifests ORDER BY digest`)
+		mustDo(t, err)
+		var expectedDigests []string
+		for _, image := range expected {
+			expectedDigests = append(expectedDigests, image.Manifest.Digest.String())
+		}
+		slices.Sort(expectedDigests)
+		assert.DeepEqual(t, "remaining manifests", actualDigests, expectedDigests)
+	}
+
+	// without retention policies, nothing happens
+	expectSuccess(t, retentionJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), retentionJob.ProcessOne(s.Ctx))
+	expectManifests(images...)
+
+	// with a retention policy keeping only the newest release, the first
+	// release gets deleted, but the second release is kept because of its other tag
+	mustExec(t, s.DB, `UPDATE accounts SET tag_retention_policies_json = $1`,
+		`[{"match_tag":"release-.*","keep_newest":1}]`)
+	s.Clock.StepBy(2 * time.Hour)
+	expectSuccess(t, retentionJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), retentionJob.ProcessOne(s.Ctx))
+	expectManifests(images[1], images[2])
+
+	// once the other tag is gone, the second release would be deleted as well,
+	// but not while its tag is covered by a tag protection policy...
+	mustExec(t, s.DB, `DELETE FROM tags WHERE name = $1`, "stable")
+	mustExec(t, s.DB, `UPDATE accounts SET tag_protection_policies_json = $1`,
+		`[{"match_tag":"release-2","immutable":true}]`)
+	s.Clock.StepBy(2 * time.Hour)
+	expectSuccess(t, retentionJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), retentionJob.ProcessOne(s.Ctx))
+	expectManifests(images[1], images[2])
+
+	// ...and not while the repo is archived
+	mustExec(t, s.DB, `UPDATE accounts SET tag_protection_policies_json = ''`)
+	mustExec(t, s.DB, `UPDATE repos SET is_archived = TRUE`)
+	s.Clock.StepBy(2 * time.Hour)
+	expectError(t, sql.ErrNoRows.Error(), retentionJob.ProcessOne(s.Ctx))
+	expectManifests(images[1], images[2])
+
+	mustExec(t, s.DB, `UPDATE repos SET is_archived = FALSE`)
+	s.Clock.StepBy(2 * time.Hour)
+	expectSuccess(t, retentionJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), retentionJob.ProcessOne(s.Ctx))
+	expectManifests(images[2])
+
+	// policies on other repos do not apply
+	mustExec(t, s.DB, `UPDATE accounts SET tag_retention_policies_json = $1`,
+		`[{"match_repository":"bar","match_tag":".*","keep_newest":1}]`)
+	image := test.GenerateImage(test.GenerateExampleLayer(3))
+	image.MustUpload(t, s, fooRepoRef, "release-4")
+	s.Clock.StepBy(2 * time.Hour)
+	expectSuccess(t, retentionJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), retentionJob.ProcessOne(s.Ctx))
+	expectManifests(images[2], image)
+}
@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/regexpext"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+const (
+	tagWatchCheckInterval      = 1 * time.Hour
+	tagWatchCheckRetryInterval = 10 * time.Minute
+)
+
+var tagWatchSelectQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM tag_watches
+	 WHERE next_check_at < $1
+	 ORDER BY next_check_at ASC
+	 LIMIT 1 -- one at a time
+`)
+
+var tagWatchDoneQuery = sqlext.SimplifyWhitespace(`
+	UPDATE tag_watches SET known_tags_json = $2, checked_at = $3, next_check_at = $4, error_message = ''
+	 WHERE id = $1
+`)
+
+var tagWatchFailedQuery = sqlext.SimplifyWhitespace(`
+	UPDATE tag_watches SET next_check_at = $2, error_message = $3
+	 WHERE id = $1
+`)
+
+// TagWatchJob is a job. Each task checks a tag watch that has not been checked
+// for more than an hour. The upstream registry is asked for the current
+// digests of all tags matching the watch's pattern, and any differences to the
+// previous check are reported through an audit event and the watch's webhook.
+// The first check of a watch only records the initial state.
+func (j *Janitor) TagWatchJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.TagWatch]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "check tag watches in external replica accounts",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_tag_watch_checks",
+				Help: "Counter for checks of tag watches in external replica accounts.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (watch models.TagWatch, err error) {
+			err = j.db.SelectOne(&watch, tagWatchSelectQuery, j.timeNow())
+			return watch, err
+		},
+		ProcessTask: j.checkTagWatch,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) checkTagWatch(ctx context.Context, watch models.TagWatch, _ prometheus.Labels) error {
+	err := j.checkTagWatchUpstream(ctx, watch)
+	if err != nil {
+		// check again soon-ish; the known tags remain unchanged, so that no
+		// changes get lost when notifications cannot be delivered
+		_, err2 := j.db.Exec(tagWatchFailedQuery, watch.ID, j.timeNow().Add(j.addJitter(tagWatchCheckRetryInterval)), err.Error())
+		if err2 != nil {
+			return fmt.Errorf("%w (additional error when writing error message into DB: %s)", err, err2.Error())
+		}
+		return fmt.Errorf("while checking tag watch %d in %s/%s: %w", watch.ID, watch.AccountName, watch.RepoName, err)
+	}
+	return nil
+}
+
+func (j *Janitor) checkTagWatchUpstream(ctx context.Context, watch models.TagWatch) error {
+	account, err := keppel.FindAccount(j.db, watch.AccountName)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return fmt.Errorf("account %q not found", watch.AccountName)
+	}
+	if account.ExternalPeerURL == "" {
+		return fmt.Errorf("account %q is not an external replica", account.Name)
+	}
+	// do not contact the upstream while the account is in deletion (deletion mode blocks all kinds of replication)
+	if account.IsDeleting {
+		return nil
+	}
+
+	previousTags, wasCheckedBefore, err := keppel.ParseKnownTags(watch)
+	if err != nil {
+		return fmt.Errorf("cannot parse known tags: %w", err)
+	}
+
+	repo := models.Repository{AccountName: account.Name, Name: watch.RepoName}
+	upstream, currentTags, err := j.processor().ListTagsOnPrimary(ctx, account.Reduced(), repo, regexpext.BoundedRegexp(watch.TagPattern))
+	if err != nil {
+		return err
+	}
+
+	// on the first check, we only record the initial state
+	changes := keppel.DiffTagWatchState(previousTags, currentTags)
+	if wasCheckedBefore && len(changes) > 0 {
+		notification := keppel.TagWatchNotification{
+			Account:    account.Name,
+			Repository: watch.RepoName,
+			Upstream:   upstream,
+			Changes:    changes,
+		}
+		if watch.WebhookURL != "" {
+			reqBody, err := json.Marshal(notification)
+			if err != nil {
+				return err
+			}
+			err = postToWebhook(ctx, j.webhookClient, watch.WebhookURL, reqBody, nil)
+			if err != nil {
+				return fmt.Errorf("cannot deliver notification to webhook: %w", err)
+			}
+		}
+		// the audit event is only recorded once the changes are known to not be
+		// reported again (i.e. after successful delivery), to avoid duplicate
+		// events when the delivery is retried
+		j.processor().RecordTagWatchChanges(account.Reduced(), watch, notification, keppel.AuditContext{
+			UserIdentity: janitorUserIdentity{TaskName: "tag-watch"},
+			Request:      janitorDummyRequest,
+		})
+	}
+
+	knownTagsJSON, err := json.Marshal(currentTags)
+	if err != nil {
+		return err
+	}
+	now := j.timeNow()
+	_, err = j.db.Exec(tagWatchDoneQuery, watch.ID, string(knownTagsJSON), now, now.Add(j.addJitter(tagWatchCheckInterval)))
+	return err
+}
@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/easypg"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestTagWatchJob(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		_, s1 := setup(t)
+		j2, s2 := setupReplica(t, s1, "from_external_on_first_use")
+		s1.Clock.StepBy(1 * time.Hour)
+		job := j2.TagWatchJob(s2.Registry)
+
+		// mock a webhook receiver (this needs to be an actual server on the
+		// loopback interface, since notifications are not delivered through
+		// http.DefaultTransport, but a client that enforces KEPPEL_WEBHOOK_ALLOWED_NETWORKS)
+		var notifications []keppel.TagWatchNotification
+		webhookStatus := http.StatusNoContent
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var n keppel.TagWatchNotification
+			mustDo(t, json.NewDecoder(r.Body).Decode(&n))
+			notifications = append(notifications, n)
+			w.WriteHeader(webhookStatus)
+		}))
+		t.Cleanup(srv.Close)
+
+		// upload some images to the upstream, only some of them with tags matching the watch
+		images := make([]test.Image, 3)
+		for idx := range images {
+			images[idx] = test.GenerateImage(test.GenerateExampleLayer(int64(idx)))
+		}
+		images[0].MustUpload(t, s1, fooRepoRef, "1.0")
+		images[1].MustUpload(t, s1, fooRepoRef, "latest")
+
+		mustDo(t, s2.DB.Insert(&models.TagWatch{
+			AccountName: "test1",
+			RepoName:    "foo",
+			TagPattern:  `1\..*`,
+			WebhookURL:  srv.URL + "/keppel",
+			CreatedAt:   s1.Clock.Now(),
+			NextCheckAt: s1.Clock.Now(),
+		}))
+		tr, _ := easypg.NewTracker(t, s2.DB.Db)
+
+		// the first check only records the initial state, without notifying anyone
+		s1.Clock.StepBy(1 * time.Minute)
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE tag_watches SET known_tags_json = '{"1.0":"%[1]s"}', checked_at = %[2]d, next_check_at = %[3]d WHERE id = 1;
+			`,
+			images[0].Manifest.Digest,
+			s1.Clock.Now().Unix(),
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s2.Ctx))
+		assert.DeepEqual(t, "notifications", len(notifications), 0)
+		s2.Auditor.ExpectEvents(t /*, nothing */)
+
+		// changes to tags that are not watched do not generate notifications
+		images[2].MustUpload(t, s1, fooRepoRef, "latest")
+		s1.Clock.StepBy(2 * time.Hour)
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE tag_watches SET checked_at = %[1]d, next_check_at = %[2]d WHERE id = 1;
+			`,
+			s1.Clock.Now().Unix(),
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+		assert.DeepEqual(t, "notifications", len(notifications), 0)
+
+		// new and moved tags are reported
+		images[2].MustUpload(t, s1, fooRepoRef, "1.1")
+		images[1].MustUpload(t, s1, fooRepoRef, "1.0")
+		s1.Clock.StepBy(2 * time.Hour)
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE tag_watches SET known_tags_json = '{"1.0":"%[1]s","1.1":"%[2]s"}', checked_at = %[3]d, next_check_at = %[4]d WHERE id = 1;
+			`,
+			images[1].Manifest.Digest,
+			images[2].Manifest.Digest,
+			s1.Clock.Now().Unix(),
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+		expectedChanges := []keppel.TagWatchChange{
+			{Tag: "1.0", PreviousDigest: images[0].Manifest.Digest, CurrentDigest: images[1].Manifest.Digest},
+			{Tag: "1.1", CurrentDigest: images[2].Manifest.Digest},
+		}
+		assert.DeepEqual(t, "notifications", notifications, []keppel.TagWatchNotification{{
+			Account:    "test1",
+			Repository: "foo",
+			Upstream:   "registry.example.org/test1/foo",
+			Changes:    expectedChanges,
+		}})
+		s2.Auditor.ExpectEvents(t, cadf.Event{
+			RequestPath: janitorDummyRequest.URL.String(),
+			Action:      cadf.UpdateAction,
+			Outcome:     "success",
+			Reason:      test.CADFReasonOK,
+			Target: cadf.Resource{
+				TypeURI:   "docker-registry/account/repository/tag-watch",
+				Name:      "test1/foo",
+				ID:        "1",
+				ProjectID: "test1authtenant",
+				Attachments: []cadf.Attachment{{
+					Name:    "changes",
+					TypeURI: "mime:application/json",
+					Content: test.ToJSON(expectedChanges),
+				}},
+			},
+			Initiator: cadf.Resource{
+				TypeURI: "service/docker-registry/janitor-task",
+				ID:      "tag-watch",
+				Name:    "tag-watch",
+				Domain:  "keppel",
+			},
+		})
+
+		// when the webhook fails, the known tags are not updated, so that the
+		// change is reported again on the next attempt; the audit event is only
+		// recorded once the delivery succeeds
+		notifications = nil
+		webhookStatus = http.StatusInternalServerError
+		mustExec(t, s1.DB, `DELETE FROM tags WHERE name = $1`, "1.1")
+		s1.Clock.StepBy(2 * time.Hour)
+		expectedError := `while checking tag watch 1 in test1/foo: cannot deliver notification to webhook: expected 2xx status, but got 500 Internal Server Error: ""`
+		expectError(t, expectedError, job.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE tag_watches SET next_check_at = %[1]d, error_message = '%[2]s' WHERE id = 1;
+			`,
+			s1.Clock.Now().Add(10*time.Minute).Unix(),
+			`cannot deliver notification to webhook: expected 2xx status, but got 500 Internal Server Error: ""`,
+		)
+		assert.DeepEqual(t, "notifications", len(notifications), 1)
+		s2.Auditor.ExpectEvents(t /*, nothing */)
+
+		notifications = nil
+		webhookStatus = http.StatusOK
+		s1.Clock.StepBy(15 * time.Minute)
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE tag_watches SET known_tags_json = '{"1.0":"%[1]s"}', checked_at = %[2]d, next_check_at = %[3]d, error_message = '' WHERE id = 1;
+			`,
+			images[1].Manifest.Digest,
+			s1.Clock.Now().Unix(),
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+		assert.DeepEqual(t, "notifications", notifications, []keppel.TagWatchNotification{{
+			Account:    "test1",
+			Repository: "foo",
+			Upstream:   "registry.example.org/test1/foo",
+			Changes: []keppel.TagWatchChange{
+				{Tag: "1.1", PreviousDigest: images[2].Manifest.Digest},
+			},
+		}})
+		// the audit event is recorded only once, after the successful delivery
+		expectedChanges = []keppel.TagWatchChange{
+			{Tag: "1.1", PreviousDigest: images[2].Manifest.Digest},
+		}
+		s2.Auditor.ExpectEvents(t, cadf.Event{
+			RequestPath: janitorDummyRequest.URL.String(),
+			Action:      cadf.UpdateAction,
+			Outcome:     "success",
+			Reason:      test.CADFReasonOK,
+			Target: cadf.Resource{
+				TypeURI:   "docker-registry/account/repository/tag-watch",
+				Name:      "test1/foo",
+				ID:        "1",
+				ProjectID: "test1authtenant",
+				Attachments: []cadf.Attachment{{
+					Name:    "changes",
+					TypeURI: "mime:application/json",
+					Content: test.ToJSON(expectedChanges),
+				}},
+			},
+			Initiator: cadf.Resource{
+				TypeURI: "service/docker-registry/janitor-task",
+				ID:      "tag-watch",
+				Name:    "tag-watch",
+				Domain:  "keppel",
+			},
+		})
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s2.Ctx))
+	})
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-api-declarations/bininfo"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+var telemetryAccountsQuery = sqlext.SimplifyWhitespace(`
+	SELECT COUNT(*),
+		COUNT(*) FILTER (WHERE upstream_peer_hostname != ''),
+		COUNT(*) FILTER (WHERE external_peer_url != ''),
+		COUNT(*) FILTER (WHERE is_managed),
+		COUNT(*) FILTER (WHERE rbac_policies_json NOT IN ('', '[]')),
+		COUNT(*) FILTER (WHERE gc_policies_json NOT IN ('', '[]')),
+		COUNT(*) FILTER (WHERE security_scan_policies_json NOT IN ('', '[]')),
+		COUNT(*) FILTER (WHERE admission_policies_json NOT IN ('', '[]')),
+		COUNT(*) FILTER (WHERE approval_policy_json NOT IN ('', '{}')),
+		COUNT(*) FILTER (WHERE custom_domain != ''),
+		COUNT(*) FILTER (WHERE serve_blobs_via_cdn),
+		COUNT(*) FILTER (WHERE pull_terms_version != ''),
+		COUNT(*) FILTER (WHERE platform_filter != '')
+	FROM accounts WHERE NOT is_deleting
+`)
+
+// TelemetryExportJob is a job. Each task collects aggregate usage statistics
+// for this Keppel installation and submits them to the configured telemetry
+// endpoint. This job is only run if telemetry is configured.
+func (j *Janitor) TelemetryExportJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "export of usage telemetry",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_telemetry_exports",
+				Help: "Counter for telemetry reports submitted to the telemetry endpoint.",
+			},
+		},
+		Interval:     j.cfg.Telemetry.Interval,
+		InitialDelay: j.cfg.Telemetry.Interval / 10,
+		Task:         j.exportTelemetry,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) exportTelemetry(ctx context.Context, _ prometheus.Labels) error {
+	report, err := j.collectTelemetry()
+	if err != nil {
+		return fmt.Errorf("cannot collect telemetry: %w", err)
+	}
+	err = submitToWebhook(ctx, j.cfg.Telemetry.URL.String(), report)
+	if err != nil {
+		return fmt.Errorf("cannot deliver telemetry report: %w", err)
+	}
+	return nil
+}
+
+func (j *Janitor) collectTelemetry() (keppel.TelemetryReport, error) {
+	report := keppel.TelemetryReport{
+		SchemaVersion: keppel.TelemetrySchemaVersion,
+		KeppelVersion: bininfo.VersionOr("rolling"),
+		CreatedAt:     j.timeNow().Unix(),
+	}
+	instanceID, err := keppel.GetTelemetryInstanceID(j.db)
+	if err != nil {
+		return report, err
+	}
+	report.InstanceID = instanceID
+
+	var (
+		accounts = &report.Accounts
+		features [9]uint64
+	)
+	err = j.db.QueryRow(telemetryAccountsQuery).Scan(
+		&accounts.Total, &accounts.Replicas, &accounts.ExternalReplicas, &accounts.Managed,
+		&features[0], &features[1], &features[2], &features[3], &features[4],
+		&features[5], &features[6], &features[7], &features[8],
+	)
+	if err != nil {
+		return report, err
+	}
+	accounts.Features = map[string]uint64{
+		"rbac_policies":          features[0],
+		"gc_policies":            features[1],
+		"security_scan_policies": features[2],
+		"admission_policies":     features[3],
+		"approval_policy":        features[4],
+		"custom_domain":          features[5],
+		"cdn":                    features[6],
+		"pull_terms":             features[7],
+		"platform_filter":        features[8],
+	}
+
+	err = j.db.QueryRow(`SELECT COUNT(*) FROM repos`).Scan(&report.Repositories)
+	if err != nil {
+		return report, err
+	}
+	err = j.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(size_bytes), 0) FROM manifests`).Scan(&report.Manifests.Count, &report.Manifests.SizeBytes)
+	if err != nil {
+		return report, err
+	}
+	err = j.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(size_bytes), 0) FROM blobs`).Scan(&report.Blobs.Count, &report.Blobs.SizeBytes)
+	if err != nil {
+		return report, err
+	}
+	err = j.db.QueryRow(`SELECT COUNT(*) FROM tags`).Scan(&report.Tags)
+	return report, err
+}
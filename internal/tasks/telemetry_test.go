@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/must"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestTelemetryExportJob(t *testing.T) {
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		_, s := setup(t,
+			test.WithAccount(models.Account{Name: "test2", AuthTenantID: "test1authtenant", UpstreamPeerHostName: "registry.example.com"}),
+		)
+		s.Clock.StepBy(1 * time.Hour)
+
+		cfg := s.Config
+		cfg.Telemetry = &keppel.TelemetryConfig{
+			URL:      *must.Return(url.Parse("https://telemetry.example.com/keppel")),
+			Interval: 24 * time.Hour,
+		}
+		j := NewJanitor(cfg, s.FD, s.SD, s.ICD, s.SecD, s.BD, s.CDN, s.NVD, s.DB, s.AMD, s.Auditor).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next)
+		j.DisableJitter()
+		job := j.TelemetryExportJob(s.Registry)
+
+		// mock a telemetry receiver
+		var reports []keppel.TelemetryReport
+		receiverStatus := http.StatusNoContent
+		tt.Handlers["telemetry.example.com"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var report keppel.TelemetryReport
+			mustDo(t, json.NewDecoder(r.Body).Decode(&report))
+			reports = append(reports, report)
+			w.WriteHeader(receiverStatus)
+		})
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		mustExec(t, s.DB, `UPDATE accounts SET gc_policies_json = $1 WHERE name = 'test1'`,
+			`[{"match_repository":".*","except_tag":"latest","only_untagged":true,"action":"delete"}]`)
+
+		expectSuccess(t, job.ProcessOne(s.Ctx))
+		assert.DeepEqual(t, "reports", len(reports), 1)
+		instanceID, err := s.DB.SelectStr(`SELECT instance_id FROM telemetry_instance`)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "report", reports[0], keppel.TelemetryReport{
+			SchemaVersion: keppel.TelemetrySchemaVersion,
+			InstanceID:    instanceID,
+			KeppelVersion: "rolling",
+			CreatedAt:     s.Clock.Now().Unix(),
+			Accounts: keppel.TelemetryAccountStats{
+				Total:    2,
+				Replicas: 1,
+				Features: map[string]uint64{
+					"rbac_policies":          0,
+					"gc_policies":            1,
+					"security_scan_policies": 0,
+					"admission_policies":     0,
+					"approval_policy":        0,
+					"custom_domain":          0,
+					"cdn":                    0,
+					"pull_terms":             0,
+					"platform_filter":        0,
+				},
+			},
+			Repositories: 1,
+			Manifests:    keppel.TelemetryObjectStats{Count: 1, SizeBytes: image.SizeBytes()},
+			Blobs: keppel.TelemetryObjectStats{
+				Count:     2,
+				SizeBytes: uint64(len(image.Layers[0].Contents) + len(image.Config.Contents)),
+			},
+			Tags: 1,
+		})
+
+		// the instance ID is random, but stays the same for subsequent reports
+		assert.DeepEqual(t, "instance ID length", len(instanceID), 32)
+		s.Clock.StepBy(24 * time.Hour)
+		expectSuccess(t, job.ProcessOne(s.Ctx))
+		assert.DeepEqual(t, "reports", len(reports), 2)
+		assert.DeepEqual(t, "instance ID", reports[1].InstanceID, instanceID)
+
+		// failures to deliver the report are reported as errors
+		receiverStatus = http.StatusInternalServerError
+		expectError(t, "cannot deliver telemetry report: expected 2xx status, but got 500 Internal Server Error: \"\"",
+			job.ProcessOne(s.Ctx))
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/sapcc/go-bits/jobloop"
 	"github.com/sapcc/go-bits/sqlext"
 
+	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 )
 
@@ -65,6 +66,10 @@ func (j *Janitor) deleteAbandonedUpload(ctx context.Context, tx *gorp.Transactio
 	if err != nil {
 		return err
 	}
+	err = keppel.ReleaseStorageQuotaReservation(tx, upload.RepositoryID, upload.StorageID)
+	if err != nil {
+		return err
+	}
 
 	// remove from backing storage if necessary
 	if upload.NumChunks > 0 {
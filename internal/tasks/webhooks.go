@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+const (
+	webhookDeliveryMaxAttempts   = 5
+	webhookDeliveryRetryInterval = 5 * time.Minute
+)
+
+var webhookDeliverySelectQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM webhook_deliveries
+	 WHERE next_attempt_at < $1
+	 ORDER BY next_attempt_at ASC
+	 LIMIT 1 -- one at a time
+`)
+
+var webhookDeliveryFailedQuery = sqlext.SimplifyWhitespace(`
+	UPDATE webhook_deliveries SET failed_count = $2, next_attempt_at = $3, error_message = $4
+	 WHERE id = $1
+`)
+
+// WebhookDeliveryJob is a job. Each task takes a pending webhook notification
+// and POSTs it to its webhook. Failed deliveries are retried with increasing
+// delays. After webhookDeliveryMaxAttempts failed attempts, the notification
+// is dropped.
+func (j *Janitor) WebhookDeliveryJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.WebhookDelivery]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "deliver webhook notifications",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_webhook_deliveries",
+				Help: "Counter for attempts to deliver webhook notifications.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (delivery models.WebhookDelivery, err error) {
+			err = j.db.SelectOne(&delivery, webhookDeliverySelectQuery, j.timeNow())
+			return delivery, err
+		},
+		ProcessTask: j.deliverWebhookNotification,
+	}).Setup(registerer)
+}
+
+func (j *Janitor) deliverWebhookNotification(ctx context.Context, delivery models.WebhookDelivery, _ prometheus.Labels) error {
+	var webhook models.Webhook
+	err := j.db.SelectOne(&webhook, `SELECT * FROM webhooks WHERE id = $1`, delivery.WebhookID)
+	if err != nil {
+		return fmt.Errorf("cannot find webhook %d: %w", delivery.WebhookID, err)
+	}
+
+	reqBody := []byte(delivery.PayloadJSON)
+	header := make(http.Header)
+	if webhook.Secret != "" {
+		header.Set(keppel.WebhookSignatureHeader, keppel.SignWebhookPayload(webhook.Secret, reqBody))
+	}
+	err = postToWebhook(ctx, j.webhookClient, webhook.URL, reqBody, header)
+	if err == nil {
+		_, err = j.db.Delete(&delivery)
+		return err
+	}
+
+	// give up after too many attempts, to avoid piling up notifications for webhooks that are gone for good
+	delivery.FailedCount++
+	if delivery.FailedCount >= webhookDeliveryMaxAttempts {
+		_, err2 := j.db.Delete(&delivery)
+		if err2 != nil {
+			return fmt.Errorf("%w (additional error when deleting undeliverable notification: %s)", err, err2.Error())
+		}
+		return fmt.Errorf("dropping notification for webhook %d in account %s after %d failed attempts: %w",
+			webhook.ID, webhook.AccountName, delivery.FailedCount, err)
+	}
+
+	//nolint:gosec // FailedCount is below webhookDeliveryMaxAttempts here
+	nextAttemptAt := j.timeNow().Add(j.addJitter(time.Duration(delivery.FailedCount) * webhookDeliveryRetryInterval))
+	_, err2 := j.db.Exec(webhookDeliveryFailedQuery, delivery.ID, delivery.FailedCount, nextAttemptAt, err.Error())
+	if err2 != nil {
+		return fmt.Errorf("%w (additional error when writing error message into DB: %s)", err, err2.Error())
+	}
+	return fmt.Errorf("while delivering notification to webhook %d in account %s: %w", webhook.ID, webhook.AccountName, err)
+}
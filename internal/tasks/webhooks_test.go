@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package tasks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/easypg"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestWebhookDeliveryJob(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	job := j.WebhookDeliveryJob(s.Registry)
+
+	// mock a webhook receiver (this needs to be an actual server on the
+	// loopback interface, since webhook deliveries do not use
+	// http.DefaultTransport, but a client that enforces KEPPEL_WEBHOOK_ALLOWED_NETWORKS)
+	var notifications []keppel.WebhookNotification
+	var signatures []string
+	webhookStatus := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		mustDo(t, err)
+		var n keppel.WebhookNotification
+		mustDo(t, json.Unmarshal(body, &n))
+		notifications = append(notifications, n)
+		if sig := r.Header.Get(keppel.WebhookSignatureHeader); sig != "" {
+			if sig != keppel.SignWebhookPayload("webhooksecret", body) {
+				t.Errorf("notification for %s has invalid signature: %q", r.URL.Path, sig)
+			}
+			signatures = append(signatures, r.URL.Path)
+		}
+		w.WriteHeader(webhookStatus)
+	}))
+	t.Cleanup(srv.Close)
+
+	// one webhook for the entire account, and one for just some repos
+	mustDo(t, s.DB.Insert(&models.Webhook{
+		AccountName: "test1",
+		URL:         srv.URL + "/account",
+		EventTypes:  "vulnerability_scan",
+		CreatedAt:   s.Clock.Now(),
+	}))
+	mustDo(t, s.DB.Insert(&models.Webhook{
+		AccountName: "test1",
+		RepoPattern: "foo|bar/.*",
+		URL:         srv.URL + "/team",
+		Secret:      "webhooksecret",
+		EventTypes:  "push,vulnerability_scan",
+		CreatedAt:   s.Clock.Now(),
+	}))
+	tr, _ := easypg.NewTracker(t, s.DB.Db)
+
+	// notifications are only enqueued for webhooks that match both event type and repo
+	pushToFoo := keppel.WebhookNotification{
+		Event:      keppel.WebhookEventPush,
+		Account:    "test1",
+		Repository: "foo",
+		Digest:     "sha256:1234",
+		Tag:        "latest",
+		Timestamp:  s.Clock.Now().Unix(),
+	}
+	pushToOther := pushToFoo
+	pushToOther.Repository = "other"
+	mustDo(t, keppel.EnqueueWebhookNotification(s.DB, pushToFoo, s.Clock.Now()))
+	mustDo(t, keppel.EnqueueWebhookNotification(s.DB, pushToOther, s.Clock.Now()))
+	tr.DBChanges().AssertEqualf(`
+			INSERT INTO webhook_deliveries (id, webhook_id, payload_json, created_at, failed_count, next_attempt_at, error_message) VALUES (1, 2, '{"event":"push","account":"test1","repository":"foo","digest":"sha256:1234","tag":"latest","timestamp":%[1]d}', %[1]d, 0, %[1]d, '');
+		`,
+		s.Clock.Now().Unix(),
+	)
+
+	// delivery is attempted right away
+	s.Clock.StepBy(1 * time.Minute)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			DELETE FROM webhook_deliveries WHERE id = 1;
+		`)
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+	assert.DeepEqual(t, "notifications", notifications, []keppel.WebhookNotification{pushToFoo})
+	assert.DeepEqual(t, "signed notifications", signatures, []string{"/team"})
+
+	// failed deliveries are retried with increasing delays, until we give up
+	webhookStatus = http.StatusInternalServerError
+	notifications = nil
+	mustDo(t, keppel.EnqueueWebhookNotification(s.DB, pushToFoo, s.Clock.Now()))
+	tr.DBChanges().Ignore()
+	for attempt := 1; attempt < webhookDeliveryMaxAttempts; attempt++ {
+		s.Clock.StepBy(1 * time.Hour)
+		expectError(t, `while delivering notification to webhook 2 in account test1: expected 2xx status, but got 500 Internal Server Error: ""`, job.ProcessOne(s.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE webhook_deliveries SET failed_count = %[1]d, next_attempt_at = %[2]d, error_message = 'expected 2xx status, but got 500 Internal Server Error: ""' WHERE id = 2;
+			`,
+			attempt,
+			s.Clock.Now().Add(time.Duration(attempt)*webhookDeliveryRetryInterval).Unix(),
+		)
+	}
+	s.Clock.StepBy(1 * time.Hour)
+	expectError(t, `dropping notification for webhook 2 in account test1 after 5 failed attempts: expected 2xx status, but got 500 Internal Server Error: ""`, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			DELETE FROM webhook_deliveries WHERE id = 2;
+		`)
+	assert.DeepEqual(t, "notification count", len(notifications), webhookDeliveryMaxAttempts)
+
+	// deleting a webhook also deletes its pending notifications
+	mustDo(t, keppel.EnqueueWebhookNotification(s.DB, pushToFoo, s.Clock.Now()))
+	mustExec(t, s.DB, `DELETE FROM webhooks WHERE id = 2`)
+	tr.DBChanges().AssertEqualf(`
+			DELETE FROM webhooks WHERE id = 2;
+		`)
+
+	// webhooks pointing to non-public addresses are refused when connecting,
+	// even if the URL slipped past the validation in the API (e.g. because the
+	// hostname resolved to a different address at that time)
+	mustDo(t, s.DB.Insert(&models.Webhook{
+		AccountName: "test1",
+		URL:         "http://10.0.0.1/internal",
+		EventTypes:  "push",
+		CreatedAt:   s.Clock.Now(),
+	}))
+	mustDo(t, keppel.EnqueueWebhookNotification(s.DB, pushToFoo, s.Clock.Now()))
+	tr.DBChanges().Ignore()
+	err := job.ProcessOne(s.Ctx)
+	if !errors.Is(err, keppel.ErrForbiddenDestination) {
+		t.Errorf("expected delivery to fail with %q, but got: %v", keppel.ErrForbiddenDestination.Error(), err)
+	}
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// BackupDriver (driver ID "unittest") is a keppel.BackupDriver for unit tests
+// that keeps all backed-up data in memory.
+type BackupDriver struct {
+	Contents map[digest.Digest][]byte
+	// Keys are of the form "account/repo@digest".
+	AccountContents map[string][]byte
+	Snapshots       map[string][]byte
+	// Counts how often WriteContent() was called, to check deduplication.
+	ContentWriteCount int
+}
+
+func init() {
+	keppel.BackupDriverRegistry.Add(func() keppel.BackupDriver { return &BackupDriver{} })
+}
+
+// PluginTypeID implements the keppel.BackupDriver interface.
+func (d *BackupDriver) PluginTypeID() string { return "unittest" }
+
+// Init implements the keppel.BackupDriver interface.
+func (d *BackupDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	d.Contents = make(map[digest.Digest][]byte)
+	d.AccountContents = make(map[string][]byte)
+	d.Snapshots = make(map[string][]byte)
+	return nil
+}
+
+// HasContent implements the keppel.BackupDriver interface.
+func (d *BackupDriver) HasContent(ctx context.Context, dgst digest.Digest) (bool, error) {
+	_, exists := d.Contents[dgst]
+	return exists, nil
+}
+
+// WriteContent implements the keppel.BackupDriver interface.
+func (d *BackupDriver) WriteContent(ctx context.Context, dgst digest.Digest, sizeBytes uint64, contents io.Reader) error {
+	buf, err := io.ReadAll(contents)
+	if err != nil {
+		return err
+	}
+	if uint64(len(buf)) != sizeBytes {
+		return fmt.Errorf("expected %d bytes for %s, but got %d bytes", sizeBytes, dgst, len(buf))
+	}
+	d.Contents[dgst] = buf
+	d.ContentWriteCount++
+	return nil
+}
+
+// ReadContent implements the keppel.BackupDriver interface.
+func (d *BackupDriver) ReadContent(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	buf, exists := d.Contents[dgst]
+	if !exists {
+		return nil, keppel.ErrBackupContentNotFound
+	}
+	return io.NopCloser(bytes.NewReader(buf)), nil
+}
+
+// WriteAccountContent implements the keppel.BackupDriver interface.
+func (d *BackupDriver) WriteAccountContent(ctx context.Context, accountName models.AccountName, repoName string, dgst digest.Digest, sizeBytes uint64, contents io.Reader) error {
+	buf, err := io.ReadAll(contents)
+	if err != nil {
+		return err
+	}
+	if uint64(len(buf)) != sizeBytes {
+		return fmt.Errorf("expected %d bytes for %s/%s@%s, but got %d bytes", sizeBytes, accountName, repoName, dgst, len(buf))
+	}
+	d.AccountContents[fmt.Sprintf("%s/%s@%s", accountName, repoName, dgst)] = buf
+	return nil
+}
+
+// ReadAccountContent implements the keppel.BackupDriver interface.
+func (d *BackupDriver) ReadAccountContent(ctx context.Context, accountName models.AccountName, repoName string, dgst digest.Digest) (io.ReadCloser, error) {
+	buf, exists := d.AccountContents[fmt.Sprintf("%s/%s@%s", accountName, repoName, dgst)]
+	if !exists {
+		return nil, keppel.ErrBackupContentNotFound
+	}
+	return io.NopCloser(bytes.NewReader(buf)), nil
+}
+
+// WriteSnapshot implements the keppel.BackupDriver interface.
+func (d *BackupDriver) WriteSnapshot(ctx context.Context, name string, sizeBytes uint64, contents io.Reader) error {
+	buf, err := io.ReadAll(contents)
+	if err != nil {
+		return err
+	}
+	if uint64(len(buf)) != sizeBytes {
+		return fmt.Errorf("expected %d bytes for snapshot %s, but got %d bytes", sizeBytes, name, len(buf))
+	}
+	d.Snapshots[name] = buf
+	return nil
+}
+
+// ListSnapshots implements the keppel.BackupDriver interface.
+func (d *BackupDriver) ListSnapshots(ctx context.Context) ([]string, error) {
+	names := make([]string, 0, len(d.Snapshots))
+	for name := range d.Snapshots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ReadSnapshot implements the keppel.BackupDriver interface.
+func (d *BackupDriver) ReadSnapshot(ctx context.Context, name string) ([]byte, error) {
+	buf, exists := d.Snapshots[name]
+	if !exists {
+		return nil, fmt.Errorf("no such snapshot: %q", name)
+	}
+	return buf, nil
+}
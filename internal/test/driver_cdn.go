@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// CDNDriver (driver ID "unittest") is a keppel.CDNDriver for unit tests.
+type CDNDriver struct {
+	// Contains "<account>/<digest>" for each successful call to PurgeBlob(), in order.
+	PurgedBlobs []string
+	// If not nil, PurgeBlob() fails with this error.
+	PurgeError error
+}
+
+func init() {
+	keppel.CDNDriverRegistry.Add(func() keppel.CDNDriver { return &CDNDriver{} })
+}
+
+// PluginTypeID implements the keppel.CDNDriver interface.
+func (d *CDNDriver) PluginTypeID() string { return "unittest" }
+
+// Init implements the keppel.CDNDriver interface.
+func (d *CDNDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	return nil
+}
+
+// URLForBlob implements the keppel.CDNDriver interface.
+func (d *CDNDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob) (string, error) {
+	return fmt.Sprintf("https://cdn.example.org/%s/%s?signature=dummy", account.Name, blob.Digest), nil
+}
+
+// PurgeBlob implements the keppel.CDNDriver interface.
+func (d *CDNDriver) PurgeBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob) error {
+	if d.PurgeError != nil {
+		return d.PurgeError
+	}
+	d.PurgedBlobs = append(d.PurgedBlobs, fmt.Sprintf("%s/%s", account.Name, blob.Digest))
+	return nil
+}
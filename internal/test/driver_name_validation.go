@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2026 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package test
+
+import (
+	"context"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// NameValidationDriver (driver ID "unittest") is a keppel.NameValidationDriver for unit tests.
+type NameValidationDriver struct {
+	// Maps account names (for new accounts) or "<account>/<repo>" (for new
+	// repositories) to the rejection message that shall be returned for them.
+	RejectedNames map[string]string
+}
+
+func init() {
+	keppel.NameValidationDriverRegistry.Add(func() keppel.NameValidationDriver { return &NameValidationDriver{} })
+}
+
+// PluginTypeID implements the keppel.NameValidationDriver interface.
+func (d *NameValidationDriver) PluginTypeID() string { return "unittest" }
+
+// Init implements the keppel.NameValidationDriver interface.
+func (d *NameValidationDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	d.RejectedNames = make(map[string]string)
+	return nil
+}
+
+// ValidateAccountName implements the keppel.NameValidationDriver interface.
+func (d *NameValidationDriver) ValidateAccountName(ctx context.Context, name models.AccountName, authTenantID string) (string, error) {
+	return d.RejectedNames[string(name)], nil
+}
+
+// ValidateRepositoryName implements the keppel.NameValidationDriver interface.
+func (d *NameValidationDriver) ValidateRepositoryName(ctx context.Context, account models.ReducedAccount, repoName string) (string, error) {
+	return d.RejectedNames[string(account.Name)+"/"+repoName], nil
+}
@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2025 SAP SE
+// SPDX-License-Identifier: Apache-2.0
+
+package test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// SecretsDriver (driver ID "unittest") is a keppel.SecretsDriver for unit
+// tests. Secrets can be placed in the Secrets map by the test. Each auth
+// tenant can only see the secrets whose key starts with "$AUTH_TENANT_ID/",
+// e.g. a user in tenant "tenant1" can refer to the secret "tenant1/foo" as "foo".
+type SecretsDriver struct {
+	Secrets map[string]string
+}
+
+func init() {
+	keppel.SecretsDriverRegistry.Add(func() keppel.SecretsDriver { return &SecretsDriver{} })
+}
+
+// PluginTypeID implements the keppel.SecretsDriver interface.
+func (d *SecretsDriver) PluginTypeID() string { return "unittest" }
+
+// Init implements the keppel.SecretsDriver interface.
+func (d *SecretsDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	d.Secrets = make(map[string]string)
+	return nil
+}
+
+// ReadSecret implements the keppel.SecretsDriver interface.
+func (d *SecretsDriver) ReadSecret(ctx context.Context, authTenantID, ref string) (string, error) {
+	secret, ok := d.Secrets[authTenantID+"/"+ref]
+	if !ok {
+		return "", fmt.Errorf("no such secret: %q", ref)
+	}
+	return secret, nil
+}
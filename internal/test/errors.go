@@ -6,6 +6,7 @@ package test
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/sapcc/keppel/internal/keppel"
@@ -18,14 +19,16 @@ type ErrorCode keppel.RegistryV2ErrorCode
 // AssertResponseBody implements the assert.HTTPResponseBody interface.
 func (e ErrorCode) AssertResponseBody(t *testing.T, requestInfo string, responseBody []byte) bool {
 	t.Helper()
-	wrapped := ErrorCodeWithMessage{keppel.RegistryV2ErrorCode(e), ""}
+	wrapped := ErrorCodeWithMessage{Code: keppel.RegistryV2ErrorCode(e)}
 	return wrapped.AssertResponseBody(t, requestInfo, responseBody)
 }
 
 // ErrorCodeWithMessage extends ErrorCode with an expected detail message.
+// If Detail is not nil, the error detail is also checked.
 type ErrorCodeWithMessage struct {
 	Code    keppel.RegistryV2ErrorCode
 	Message string
+	Detail  any
 }
 
 // AssertResponseBody implements the assert.HTTPResponseBody interface.
@@ -35,6 +38,7 @@ func (e ErrorCodeWithMessage) AssertResponseBody(t *testing.T, requestInfo strin
 		Errors []struct {
 			Code    keppel.RegistryV2ErrorCode `json:"code"`
 			Message string                     `json:"message"`
+			Detail  any                        `json:"detail"`
 		} `json:"errors"`
 	}
 	err := json.Unmarshal(responseBody, &data)
@@ -53,6 +57,24 @@ func (e ErrorCodeWithMessage) AssertResponseBody(t *testing.T, requestInfo strin
 	if matches {
 		matches = e.Message == "" || data.Errors[0].Message == e.Message
 	}
+	if matches && e.Detail != nil {
+		// decode the expected detail in the same way as the actual detail, to
+		// avoid type mismatches between e.g. structs and maps (comparing the
+		// serialized forms does not work since the order of keys may differ)
+		expectedDetailJSON, err := json.Marshal(e.Detail)
+		if err != nil {
+			t.Errorf("%s: cannot encode expected error detail: %s", requestInfo, err.Error())
+			return false
+		}
+		var expectedDetail any
+		err = json.Unmarshal(expectedDetailJSON, &expectedDetail)
+		if err != nil {
+			t.Errorf("%s: cannot decode expected error detail: %s", requestInfo, err.Error())
+			return false
+		}
+		matches = reflect.DeepEqual(expectedDetail, data.Errors[0].Detail)
+		expectedStr += fmt.Sprintf(" and detail: %s", expectedDetailJSON)
+	}
 	if !matches {
 		t.Error(requestInfo + ": got unexpected error")
 		t.Logf("\texpected = %q\n", expectedStr)
@@ -38,6 +38,13 @@ func (s Setup) GetDomainRemappedToken(t *testing.T, accountName models.AccountNa
 	return s.getToken(t, auth.Audience{IsAnycast: false, AccountName: accountName}, scopes...)
 }
 
+// GetCustomDomainToken is like GetDomainRemappedToken, but instead returns a
+// token for the custom domain of an account.
+func (s Setup) GetCustomDomainToken(t *testing.T, accountName models.AccountName, hostname string, scopes ...string) string {
+	t.Helper()
+	return s.getToken(t, auth.Audience{IsAnycast: false, AccountName: accountName, CustomDomain: hostname}, scopes...)
+}
+
 func (s Setup) getToken(t *testing.T, audience auth.Audience, scopes ...string) string {
 	t.Helper()
 
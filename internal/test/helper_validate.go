@@ -70,8 +70,9 @@ func (s Setup) ExpectManifestsExistInStorage(t *testing.T, repoName string, mani
 	for _, manifest := range manifests {
 		repo, err := keppel.FindRepositoryByID(s.DB, manifest.RepositoryID)
 		mustDo(t, err)
-		account := models.ReducedAccount{Name: repo.AccountName}
-		manifestBytes, err := s.SD.ReadManifest(s.Ctx, account, repoName, manifest.Digest)
+		account, err := keppel.FindReducedAccount(s.DB, repo.AccountName)
+		mustDo(t, err)
+		manifestBytes, err := keppel.ReadManifestFromStorage(s.Ctx, s.SD, s.SecD, *account, repoName, manifest.Digest)
 		if err != nil {
 			t.Errorf("expected manifest %s to exist in the storage, but got: %s", manifest.Digest, err.Error())
 			continue
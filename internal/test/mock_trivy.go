@@ -6,11 +6,14 @@ package test
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/respondwith"
 
@@ -20,17 +23,29 @@ import (
 )
 
 // TrivyDouble acts as a test double for a Trivy API.
+//
+// ReportFixtures contains paths to reports in the "json" format. Reports in
+// other formats are read from a file next to it, e.g. "report.sarif.json"
+// instead of "report.json" for the "sarif" format.
+//
+// ReportTimeout simulates scans exceeding their timeout after the first layer
+// of the image was fetched. The timeout that was requested for the last scan
+// of each image is recorded in RequestedTimeouts.
 type TrivyDouble struct {
-	T              *testing.T
-	ReportError    map[models.ImageReference]bool
-	ReportFixtures map[models.ImageReference]string
+	T                 *testing.T
+	ReportError       map[models.ImageReference]bool
+	ReportTimeout     map[models.ImageReference]bool
+	ReportFixtures    map[models.ImageReference]string
+	RequestedTimeouts map[models.ImageReference]string
 }
 
 // NewTrivyDouble creates a TrivyDouble.
 func NewTrivyDouble() *TrivyDouble {
 	return &TrivyDouble{
-		ReportError:    make(map[models.ImageReference]bool),
-		ReportFixtures: make(map[models.ImageReference]string),
+		ReportError:       make(map[models.ImageReference]bool),
+		ReportTimeout:     make(map[models.ImageReference]bool),
+		ReportFixtures:    make(map[models.ImageReference]string),
+		RequestedTimeouts: make(map[models.ImageReference]string),
 	}
 }
 
@@ -57,15 +72,42 @@ func (t *TrivyDouble) mockRunTrivy(w http.ResponseWriter, r *http.Request) {
 		RepoName: imageRef.RepoName,
 	}
 	c.SetToken(r.Header[http.CanonicalHeaderKey(trivy.KeppelTokenHeader)][0])
-	_, _, err = c.DownloadManifest(r.Context(), imageRef.Reference, &client.DownloadManifestOpts{})
+	manifestBytes, _, err := c.DownloadManifest(r.Context(), imageRef.Reference, &client.DownloadManifestOpts{})
 	if respondwith.ErrorText(w, err) {
 		return
 	}
 
+	t.RequestedTimeouts[imageRef] = r.URL.Query().Get("timeout")
 	if t.ReportError[imageRef] {
 		http.Error(w, "simulated error", http.StatusInternalServerError)
 		return
 	}
+	if t.ReportTimeout[imageRef] {
+		// simulate that Trivy got through the first layer before timing out
+		var manifest struct {
+			Layers []struct {
+				Digest digest.Digest `json:"digest"`
+			} `json:"layers"`
+		}
+		err = json.Unmarshal(manifestBytes, &manifest)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+		if len(manifest.Layers) > 0 {
+			reader, _, err := c.DownloadBlob(r.Context(), manifest.Layers[0].Digest)
+			if respondwith.ErrorText(w, err) {
+				return
+			}
+			_, err = io.Copy(io.Discard, reader)
+			reader.Close()
+			if respondwith.ErrorText(w, err) {
+				return
+			}
+		}
+		w.Header().Set(trivy.ScanTimedOutHeader, "true")
+		http.Error(w, "simulated error: context deadline exceeded", http.StatusGatewayTimeout)
+		return
+	}
 
 	fixturePath := t.ReportFixtures[imageRef]
 	if fixturePath == "" {
@@ -73,6 +115,10 @@ func (t *TrivyDouble) mockRunTrivy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if format := r.URL.Query().Get("format"); format != "json" {
+		fixturePath = strings.TrimSuffix(fixturePath, ".json") + "." + format + ".json"
+	}
+
 	reportBytes, err := os.ReadFile(fixturePath)
 	if respondwith.ErrorText(w, err) {
 		return
@@ -7,8 +7,11 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/opencontainers/go-digest"
@@ -34,18 +37,28 @@ import (
 
 type setupParams struct {
 	// all false/empty by default
-	IsSecondary             bool
-	WithAnycast             bool
-	WithKeppelAPI           bool
-	WithPeerAPI             bool
-	WithTrivyDouble         bool
-	WithQuotas              bool
-	WithPreviousIssuerKey   bool
-	WithoutCurrentIssuerKey bool
-	RateLimitEngine         *keppel.RateLimitEngine
-	SetupOfPrimary          *Setup
-	Accounts                []*models.Account
-	Repos                   []*models.Repository
+	IsSecondary              bool
+	WithAnycast              bool
+	WithKeppelAPI            bool
+	WithPeerAPI              bool
+	WithTrivyDouble          bool
+	WithQuotas               bool
+	WithAccountRequests      bool
+	WithPreviousIssuerKey    bool
+	WithoutCurrentIssuerKey  bool
+	RateLimitEngine          *keppel.RateLimitEngine
+	ConcurrencyLimiter       *keppel.ConcurrencyLimiter
+	AdmissionWebhook         http.Handler
+	AdmissionWebhookFailOpen bool
+	CachePolicy              keppel.CachePolicy
+	BlobRedirectPolicy       keppel.BlobRedirectPolicy
+	ReservedAccountNames     []*regexp.Regexp
+	ReservedRepositoryNames  []*regexp.Regexp
+	AccountMetadataSchema    keppel.AccountMetadataSchema
+	ExternalUpstreamHosts    keppel.ExternalUpstreamHostPolicy
+	SetupOfPrimary           *Setup
+	Accounts                 []*models.Account
+	Repos                    []*models.Repository
 }
 
 // SetupOption is an option that can be given to NewSetup().
@@ -89,6 +102,11 @@ func WithQuotas(params *setupParams) {
 	params.WithQuotas = true
 }
 
+// WithAccountRequests is a SetupOption that enables the self-service workflow for account requests.
+func WithAccountRequests(params *setupParams) {
+	params.WithAccountRequests = true
+}
+
 // WithRateLimitEngine is a SetupOption to use a RateLimitEngine in enabled APIs.
 func WithRateLimitEngine(rle *keppel.RateLimitEngine) SetupOption {
 	return func(params *setupParams) {
@@ -96,6 +114,63 @@ func WithRateLimitEngine(rle *keppel.RateLimitEngine) SetupOption {
 	}
 }
 
+// WithConcurrencyLimiter is a SetupOption to use a ConcurrencyLimiter in the Registry API.
+func WithConcurrencyLimiter(acl *keppel.ConcurrencyLimiter) SetupOption {
+	return func(params *setupParams) {
+		params.ConcurrencyLimiter = acl
+	}
+}
+
+// WithAdmissionWebhook is a SetupOption that configures an admission webhook
+// at admission.example.org that is served by the given handler.
+func WithAdmissionWebhook(handler http.Handler, failOpen bool) SetupOption {
+	return func(params *setupParams) {
+		params.AdmissionWebhook = handler
+		params.AdmissionWebhookFailOpen = failOpen
+	}
+}
+
+// WithCachePolicy is a SetupOption that configures the Cache-Control headers
+// on blob and manifest responses.
+func WithCachePolicy(policy keppel.CachePolicy) SetupOption {
+	return func(params *setupParams) {
+		params.CachePolicy = policy
+	}
+}
+
+// WithBlobRedirectPolicy is a SetupOption that configures when blob pulls are
+// redirected to the storage.
+func WithBlobRedirectPolicy(policy keppel.BlobRedirectPolicy) SetupOption {
+	return func(params *setupParams) {
+		params.BlobRedirectPolicy = policy
+	}
+}
+
+// WithReservedNames is a SetupOption that configures patterns for names that
+// cannot be used for new accounts and repositories, respectively.
+func WithReservedNames(accountNames, repositoryNames []*regexp.Regexp) SetupOption {
+	return func(params *setupParams) {
+		params.ReservedAccountNames = accountNames
+		params.ReservedRepositoryNames = repositoryNames
+	}
+}
+
+// WithAccountMetadataSchema is a SetupOption that configures custom metadata
+// fields on accounts.
+func WithAccountMetadataSchema(schema keppel.AccountMetadataSchema) SetupOption {
+	return func(params *setupParams) {
+		params.AccountMetadataSchema = schema
+	}
+}
+
+// WithExternalUpstreamHosts is a SetupOption that restricts which registries
+// external replica accounts may replicate from.
+func WithExternalUpstreamHosts(policy keppel.ExternalUpstreamHostPolicy) SetupOption {
+	return func(params *setupParams) {
+		params.ExternalUpstreamHosts = policy
+	}
+}
+
 // WithAccount is a SetupOption that adds the given keppel.Account to the DB during NewSetup().
 func WithAccount(account models.Account) SetupOption {
 	return func(params *setupParams) {
@@ -142,9 +217,17 @@ type Setup struct {
 	FD           *FederationDriver
 	SD           *trivial.StorageDriver
 	ICD          *InboundCacheDriver
+	SecD         *SecretsDriver
+	BD           *BackupDriver
+	CDN          *CDNDriver
+	NVD          *NameValidationDriver
 	Handler      http.Handler
 	Ctx          context.Context //nolint: containedctx  // only used in tests
 	Registry     *prometheus.Registry
+	// Pulls are only recorded in the DB when PullAttestations.Flush() is called.
+	PullAttestations *keppel.PullAttestationRecorder
+	// RBAC policy usage is only recorded in the DB when RBACPolicyUsage.Flush() is called.
+	RBACPolicyUsage *keppel.RBACPolicyUsageRecorder
 	// fields that are only set if the respective With... setup option is included
 	TrivyDouble *TrivyDouble
 	// fields that are filled by WithAccount and WithRepo (in order)
@@ -190,6 +273,22 @@ func NewSetup(t *testing.T, opts ...SetupOption) Setup {
 	s := Setup{
 		Config: keppel.Configuration{
 			APIPublicHostname: apiPublicHostname,
+			// auto-pausing of replication stays disabled unless a test enables it
+			ReplicationErrorBudget:   keppel.ReplicationErrorBudget{Window: time.Hour},
+			AccountRequestsEnabled:   params.WithAccountRequests,
+			CachePolicy:              params.CachePolicy,
+			BlobRedirectPolicy:       params.BlobRedirectPolicy,
+			CredentialReport:         keppel.CredentialReportConfig{UnusedDays: 90},
+			PullAttestationRetention: 365 * 24 * time.Hour,
+			ReservedAccountNames:     params.ReservedAccountNames,
+			ReservedRepositoryNames:  params.ReservedRepositoryNames,
+			AccountMetadataSchema:    params.AccountMetadataSchema,
+			ExternalUpstreamHosts:    params.ExternalUpstreamHosts,
+			// webhooks in tests are delivered to httptest servers on localhost
+			WebhookTargets: keppel.OutboundRequestPolicy{AllowedNetworks: []netip.Prefix{
+				netip.MustParsePrefix("127.0.0.0/8"),
+				netip.MustParsePrefix("::1/128"),
+			}},
 		},
 		Ctx:        t.Context(),
 		Registry:   prometheus.NewPedanticRegistry(),
@@ -226,12 +325,29 @@ func NewSetup(t *testing.T, opts ...SetupOption) Setup {
 		}
 	}
 
+	if params.AdmissionWebhook != nil {
+		webhookURL, err := url.Parse("https://admission.example.org/review")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s.Config.AdmissionWebhook = &keppel.AdmissionWebhook{
+			URL:      *webhookURL,
+			Token:    "admission-webhook-token",
+			Timeout:  5 * time.Second,
+			FailOpen: params.AdmissionWebhookFailOpen,
+		}
+		if tt, ok := http.DefaultTransport.(*RoundTripper); ok {
+			tt.Handlers[webhookURL.Host] = params.AdmissionWebhook
+		}
+	}
+
 	// connect to DB
 	dbOpts := []easypg.TestSetupOption{
 		// manifest_manifest_refs needs a specialized cleanup strategy because of an "ON DELETE RESTRICT" constraint
 		easypg.ClearContentsWith(`DELETE FROM manifest_manifest_refs WHERE parent_digest NOT IN (SELECT child_digest FROM manifest_manifest_refs)`),
-		easypg.ClearTables("manifest_blob_refs", "accounts", "peers", "quotas"),
-		easypg.ResetPrimaryKeys("blobs", "repos"),
+		easypg.ClearTables("manifest_blob_refs", "accounts", "peers", "quotas", "announcements", "upstream_circuit_breakers", "account_requests", "background_migrations", "rate_limit_exemptions", "pending_cdn_purges", "telemetry_instance"),
+		easypg.ResetPrimaryKeys("blobs", "repos", "account_requests", "pending_cdn_purges"),
 	}
 	if params.IsSecondary {
 		dbOpts = append(dbOpts, easypg.OverrideDatabaseName(t.Name()+"_secondary"))
@@ -278,6 +394,18 @@ func NewSetup(t *testing.T, opts ...SetupOption) Setup {
 	icd, err := keppel.NewInboundCacheDriver(s.Ctx, "unittest", s.Config)
 	mustDo(t, err)
 	s.ICD = icd.(*InboundCacheDriver)
+	secd, err := keppel.NewSecretsDriver(s.Ctx, "unittest", s.Config)
+	mustDo(t, err)
+	s.SecD = secd.(*SecretsDriver)
+	bd, err := keppel.NewBackupDriver(s.Ctx, "unittest", s.Config)
+	mustDo(t, err)
+	s.BD = bd.(*BackupDriver)
+	cdnd, err := keppel.NewCDNDriver(s.Ctx, "unittest", s.Config)
+	mustDo(t, err)
+	s.CDN = cdnd.(*CDNDriver)
+	nvd, err := keppel.NewNameValidationDriver(s.Ctx, "unittest", s.Config)
+	mustDo(t, err)
+	s.NVD = nvd.(*NameValidationDriver)
 
 	if params.RateLimitEngine != nil {
 		sr := miniredis.RunT(t)
@@ -287,18 +415,21 @@ func NewSetup(t *testing.T, opts ...SetupOption) Setup {
 			// SETINFO not supported by miniredis
 			DisableIdentity: true,
 		})
+		params.RateLimitEngine.Exemptions = keppel.NewRateLimitExemptionCache(s.DB, s.Clock.Now)
 	}
 
 	// setup APIs
+	s.PullAttestations = keppel.NewPullAttestationRecorder(s.DB)
+	s.RBACPolicyUsage = keppel.NewRBACPolicyUsageRecorder(s.DB)
 	apis := []httpapi.API{
 		httpapi.WithoutLogging(),
 		// Registry API (and thus Auth API) are nearly always needed for
 		// Bytes.Upload, Image.Upload and ImageList.Upload
-		registryv2.NewAPI(s.Config, ad, fd, sd, icd, s.DB, s.Auditor, params.RateLimitEngine).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next),
-		authapi.NewAPI(s.Config, ad, fd, s.DB),
+		registryv2.NewAPI(s.Config, ad, fd, sd, icd, secd, cdnd, nvd, s.DB, s.Auditor, params.RateLimitEngine, params.ConcurrencyLimiter, nil, s.PullAttestations, s.RBACPolicyUsage).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next),
+		authapi.NewAPI(s.Config, ad, fd, s.DB, s.RBACPolicyUsage).OverrideTimeNow(s.Clock.Now),
 	}
 	if params.WithKeppelAPI {
-		apis = append(apis, keppelv1.NewAPI(s.Config, ad, fd, sd, icd, s.DB, s.Auditor, params.RateLimitEngine).OverrideTimeNow(s.Clock.Now))
+		apis = append(apis, keppelv1.NewAPI(s.Config, ad, fd, sd, icd, secd, nvd, s.DB, s.Auditor, params.RateLimitEngine, s.RBACPolicyUsage).OverrideTimeNow(s.Clock.Now))
 	}
 	if params.WithPeerAPI {
 		apis = append(apis, peerv1.NewAPI(s.Config, ad, s.DB))
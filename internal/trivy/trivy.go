@@ -5,12 +5,15 @@ package trivy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sapcc/keppel/internal/models"
 )
@@ -37,8 +40,51 @@ type Config struct {
 	AdditionalPullableRepos []string
 	Token                   string
 	URL                     url.URL
+	// ScanTimeout is how long Trivy may take to scan a single image. If a scan
+	// times out, the janitor retries it with successively longer timeouts, up to
+	// MaxScanTimeout. If zero, DefaultScanTimeout applies to both.
+	ScanTimeout    time.Duration
+	MaxScanTimeout time.Duration
 }
 
+// DefaultScanTimeout is the ScanTimeout used when none is configured. This is
+// also what trivy-proxy uses when it does not get a timeout in its request.
+const DefaultScanTimeout = 10 * time.Minute
+
+// EffectiveScanTimeouts returns ScanTimeout and MaxScanTimeout with defaults applied.
+func (tc *Config) EffectiveScanTimeouts() (initial, maximum time.Duration) {
+	initial = tc.ScanTimeout
+	if initial <= 0 {
+		initial = DefaultScanTimeout
+	}
+	return initial, max(initial, tc.MaxScanTimeout)
+}
+
+// ScanTimedOutHeader is set by trivy-proxy on its 504 responses when Trivy
+// could not complete a scan within the requested timeout. This distinguishes
+// scan timeouts from gateway timeouts reported by proxies in between.
+const ScanTimedOutHeader = "X-Keppel-Trivy-Scan-Timed-Out"
+
+// ErrScanTimedOut is returned by ScanManifest (possibly wrapped) when Trivy
+// could not complete the scan within the given timeout.
+var ErrScanTimedOut = errors.New("scan timed out")
+
+// Scans of images with many layers and scans of many images in a row (e.g. in
+// the janitor) send many requests to trivy-proxy, so we want to keep enough
+// idle connections around to avoid repeated TLS handshakes.
+const maxIdleConnsPerProxyHost = 16
+
+var proxyHTTPClient = sync.OnceValue(func() *http.Client {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		// DefaultTransport was replaced (e.g. by a test double), so we cannot tune it
+		return http.DefaultClient
+	}
+	transport = transport.Clone()
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerProxyHost
+	return &http.Client{Transport: transport}
+})
+
 // ReportPayload contains a report that was returned by Trivy (and potentially
 // enhanced by Keppel).
 type ReportPayload struct {
@@ -47,12 +93,14 @@ type ReportPayload struct {
 }
 
 // ScanManifest queries the Trivy server for a report on the given manifest.
-func (tc *Config) ScanManifest(ctx context.Context, keppelToken string, manifestRef models.ImageReference, format string) (ReportPayload, error) {
+// Trivy will give up on the scan after the given timeout.
+func (tc *Config) ScanManifest(ctx context.Context, keppelToken string, manifestRef models.ImageReference, format string, timeout time.Duration) (ReportPayload, error) {
 	requestURL := tc.URL
 	requestURL.Path = "/trivy"
 	requestURL.RawQuery = url.Values{
-		"image":  {manifestRef.String()},
-		"format": {format},
+		"image":   {manifestRef.String()},
+		"format":  {format},
+		"timeout": {timeout.String()},
 	}.Encode()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), http.NoBody)
@@ -63,8 +111,11 @@ func (tc *Config) ScanManifest(ctx context.Context, keppelToken string, manifest
 	req.Header.Set(TokenHeader, tc.Token)
 	req.Header.Set(KeppelTokenHeader, keppelToken)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := proxyHTTPClient().Do(req)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ReportPayload{}, fmt.Errorf("%w after %s: %w", ErrScanTimedOut, timeout, err)
+		}
 		return ReportPayload{}, err
 	}
 	defer resp.Body.Close()
@@ -75,6 +126,9 @@ func (tc *Config) ScanManifest(ctx context.Context, keppelToken string, manifest
 	if resp.StatusCode != http.StatusOK {
 		// from inner to outer: cast to string, remove extra new lines, remove color escape codes, replace multiple consecutive spaces with one
 		respCleaned := strings.Join(strings.Fields(stripColor(strings.TrimSpace(string(respBody)))), " ")
+		if resp.StatusCode == http.StatusGatewayTimeout && resp.Header.Get(ScanTimedOutHeader) == "true" {
+			return ReportPayload{}, fmt.Errorf("%w after %s: %s", ErrScanTimedOut, timeout, respCleaned)
+		}
 		return ReportPayload{}, fmt.Errorf("trivy proxy did not return 200: %d %s", resp.StatusCode, respCleaned)
 	}
 
@@ -91,8 +145,8 @@ func stripColor(in string) string {
 // ScanManifest is like ScanManifestAndParse, except that the result is parsed
 // instead of being returned as a bytestring. The report format "json" is
 // implied in order to match the return type.
-func (tc *Config) ScanManifestAndParse(ctx context.Context, keppelToken string, manifestRef models.ImageReference) (Report, error) {
-	report, err := tc.ScanManifest(ctx, keppelToken, manifestRef, "json")
+func (tc *Config) ScanManifestAndParse(ctx context.Context, keppelToken string, manifestRef models.ImageReference, timeout time.Duration) (Report, error) {
+	report, err := tc.ScanManifest(ctx, keppelToken, manifestRef, "json", timeout)
 	if err != nil {
 		return Report{}, err
 	}
@@ -15,6 +15,11 @@ import (
 	apicmd "github.com/sapcc/keppel/cmd/api"
 	healthmonitorcmd "github.com/sapcc/keppel/cmd/healthmonitor"
 	janitorcmd "github.com/sapcc/keppel/cmd/janitor"
+	loadtestcmd "github.com/sapcc/keppel/cmd/loadtest"
+	migratecmd "github.com/sapcc/keppel/cmd/migrate"
+	migratefromregistrycmd "github.com/sapcc/keppel/cmd/migratefromregistry"
+	restorebackupcmd "github.com/sapcc/keppel/cmd/restorebackup"
+	selftestcmd "github.com/sapcc/keppel/cmd/selftest"
 	trivyproxycmd "github.com/sapcc/keppel/cmd/trivyproxy"
 	validatecmd "github.com/sapcc/keppel/cmd/validate"
 	validateconfigcmd "github.com/sapcc/keppel/cmd/validateconfig"
@@ -23,13 +28,20 @@ import (
 	// include all known driver implementations
 	_ "github.com/sapcc/keppel/internal/drivers/basic"
 	_ "github.com/sapcc/keppel/internal/drivers/filesystem"
+	_ "github.com/sapcc/keppel/internal/drivers/gcs"
+	_ "github.com/sapcc/keppel/internal/drivers/ldap"
 	_ "github.com/sapcc/keppel/internal/drivers/multi"
+	_ "github.com/sapcc/keppel/internal/drivers/oidc"
 	_ "github.com/sapcc/keppel/internal/drivers/openstack"
 	_ "github.com/sapcc/keppel/internal/drivers/redis"
+	_ "github.com/sapcc/keppel/internal/drivers/s3"
 	_ "github.com/sapcc/keppel/internal/drivers/trivial"
+	_ "github.com/sapcc/keppel/internal/drivers/vault"
 )
 
 func main() {
+	// the configuration file needs to be loaded before anything else looks at the environment
+	must.Succeed(keppel.LoadConfigFile())
 	logg.ShowDebug = osext.GetenvBool("KEPPEL_DEBUG")
 	undoMaxprocs := must.Return(maxprocs.Set(maxprocs.Logger(logg.Debug)))
 	defer undoMaxprocs()
@@ -45,6 +57,9 @@ func main() {
 			cmd.Help()
 		},
 	}
+	loadtestcmd.AddCommandTo(rootCmd)
+	migratefromregistrycmd.AddCommandTo(rootCmd)
+	selftestcmd.AddCommandTo(rootCmd)
 	validatecmd.AddCommandTo(rootCmd)
 
 	serverCmd := &cobra.Command{
@@ -59,6 +74,8 @@ func main() {
 	apicmd.AddCommandTo(serverCmd)
 	healthmonitorcmd.AddCommandTo(serverCmd)
 	janitorcmd.AddCommandTo(serverCmd)
+	migratecmd.AddCommandTo(serverCmd)
+	restorebackupcmd.AddCommandTo(serverCmd)
 	trivyproxycmd.AddCommandTo(serverCmd)
 	validateconfigcmd.AddCommandTo(serverCmd)
 	rootCmd.AddCommand(serverCmd)